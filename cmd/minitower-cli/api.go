@@ -11,25 +11,37 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"minitower/internal/httputil"
 )
 
 type apiClient struct {
 	baseURL string
 	token   string
 	http    *http.Client
+	// authHint is attached to 401 errors as their hint.
+	authHint string
 }
 
 type apiError struct {
-	Status  int
-	Code    string
-	Message string
+	Status    int
+	Code      string
+	Message   string
+	RequestID string
+	Items     []apiErrorItem
+	// Hint suggests a fix; see apiClient.authHint.
+	Hint string
 }
 
 func (e *apiError) Error() string {
-	if e.Code == "" {
-		return fmt.Sprintf("request failed: status=%d message=%s", e.Status, e.Message)
+	msg := fmt.Sprintf("request failed: status=%d message=%s", e.Status, e.Message)
+	if e.Code != "" {
+		msg = fmt.Sprintf("request failed: status=%d code=%s message=%s", e.Status, e.Code, e.Message)
+	}
+	if e.RequestID != "" {
+		msg += " request_id=" + e.RequestID
 	}
-	return fmt.Sprintf("request failed: status=%d code=%s message=%s", e.Status, e.Code, e.Message)
+	return msg
 }
 
 func newAPIClient(serverURL, token string) *apiClient {
@@ -42,6 +54,18 @@ func newAPIClient(serverURL, token string) *apiClient {
 	}
 }
 
+// newConnectionClient returns a client for conn that trusts its profile's
+// CA certificate, or skips verification when the profile says so.
+func newConnectionClient(conn *resolvedConnection) (*apiClient, error) {
+	transport, err := httputil.NewTransport(conn.CACert, conn.Insecure)
+	if err != nil {
+		return nil, err
+	}
+	client := newAPIClient(conn.Server, conn.Token)
+	client.http.Transport = transport
+	return client, nil
+}
+
 func (c *apiClient) endpoint(apiPath string) string {
 	if strings.HasPrefix(apiPath, "http://") || strings.HasPrefix(apiPath, "https://") {
 		return apiPath
@@ -77,12 +101,21 @@ func (c *apiClient) decodeResponse(resp *http.Response, out any) error {
 		msg = http.StatusText(resp.StatusCode)
 	}
 
+	// Proxies in front of the server may drop the body, so fall back to the
+	// echoed header for the request ID.
+	requestID := resp.Header.Get("X-Request-ID")
+	ae := &apiError{Status: resp.StatusCode, Message: msg, RequestID: requestID}
 	var env errorEnvelope
 	if err := json.Unmarshal(body, &env); err == nil && env.Error.Message != "" {
-		return &apiError{Status: resp.StatusCode, Code: env.Error.Code, Message: env.Error.Message}
+		if env.Error.RequestID != "" {
+			requestID = env.Error.RequestID
+		}
+		ae = &apiError{Status: resp.StatusCode, Code: env.Error.Code, Message: env.Error.Message, RequestID: requestID, Items: env.Error.Items}
 	}
-
-	return &apiError{Status: resp.StatusCode, Message: msg}
+	if resp.StatusCode == http.StatusUnauthorized {
+		ae.Hint = c.authHint
+	}
+	return ae
 }
 
 func (c *apiClient) doJSON(ctx context.Context, method, apiPath string, reqBody, out any) error {
@@ -111,9 +144,46 @@ func (c *apiClient) doJSON(ctx context.Context, method, apiPath string, reqBody,
 	return c.decodeResponse(resp, out)
 }
 
-func (c *apiClient) doMultipartFile(ctx context.Context, apiPath, fieldName, fileName string, data []byte, out any) error {
+// getRaw fetches a non-JSON response body, such as a text file.
+func (c *apiClient) getRaw(ctx context.Context, apiPath string) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, apiPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, c.decodeResponse(resp, nil)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// latestVersion returns the app's newest version, or nil when it has none.
+func (c *apiClient) latestVersion(ctx context.Context, app string) (*versionResponse, error) {
+	var resp listVersionsResponse
+	if err := c.doJSON(ctx, http.MethodGet, "/api/v1/apps/"+url.PathEscape(app)+"/versions", nil, &resp); err != nil {
+		return nil, err
+	}
+	// Versions are listed newest first.
+	if len(resp.Versions) == 0 {
+		return nil, nil
+	}
+	return &resp.Versions[0], nil
+}
+
+// doMultipartFile posts data as the file part fieldName, after a plain
+// part for each of fields.
+func (c *apiClient) doMultipartFile(ctx context.Context, apiPath string, fields map[string]string, fieldName, fileName string, data []byte, out any) error {
 	var buf bytes.Buffer
 	w := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		if err := w.WriteField(name, value); err != nil {
+			return err
+		}
+	}
 	fw, err := w.CreateFormFile(fieldName, fileName)
 	if err != nil {
 		return err
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIErrorCarriesRequestID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-ID", "hdr-id")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":{"code":"internal","message":"internal error","request_id":"body-id"}}`))
+	}))
+	defer srv.Close()
+
+	err := newAPIClient(srv.URL, "tok").doJSON(context.Background(), http.MethodGet, "/api/v1/me", nil, nil)
+	var ae *apiError
+	if !errors.As(err, &ae) {
+		t.Fatalf("expected apiError, got %v", err)
+	}
+	if ae.RequestID != "body-id" {
+		t.Fatalf("request id = %q, want body-id", ae.RequestID)
+	}
+
+	var ee *exitError
+	if !errors.As(mapError(err), &ee) {
+		t.Fatalf("expected exitError")
+	}
+	if ee.Message != "internal error (request id: body-id)" {
+		t.Fatalf("message = %q", ee.Message)
+	}
+}
+
+func TestAPIErrorFallsBackToRequestIDHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Request-ID", "hdr-id")
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("bad gateway"))
+	}))
+	defer srv.Close()
+
+	err := newAPIClient(srv.URL, "tok").doJSON(context.Background(), http.MethodGet, "/api/v1/me", nil, nil)
+	var ee *exitError
+	if !errors.As(mapError(err), &ee) || !strings.HasSuffix(ee.Message, "(request id: hdr-id)") {
+		t.Fatalf("message = %v", mapError(err))
+	}
+}
+
+func TestMapErrorUsesAPICodes(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+		want   int
+	}{
+		{http.StatusConflict, "runner_exists", 14},
+		{http.StatusConflict, "slug_taken", 14},
+		{http.StatusConflict, "app_disabled", 15},
+		{http.StatusConflict, "run_not_queued", 16},
+		{http.StatusServiceUnavailable, "unavailable", 17},
+		{http.StatusConflict, "lease_conflict", 12},
+		{http.StatusNotFound, "not_found", 11},
+		{http.StatusGone, "lease_invalid", 13},
+		{http.StatusBadRequest, "invalid_request", 1},
+		{http.StatusConflict, "", 12},
+	}
+	for _, tt := range tests {
+		err := mapError(&apiError{Status: tt.status, Code: tt.code, Message: "boom"})
+		var ee *exitError
+		if !errors.As(err, &ee) {
+			t.Fatalf("%s: expected exitError, got %v", tt.code, err)
+		}
+		if ee.Code != tt.want || ee.APICode != tt.code {
+			t.Errorf("%d %q: exit code %d (api code %q), want %d", tt.status, tt.code, ee.Code, ee.APICode, tt.want)
+		}
+	}
+}
+
+func TestReportErrorJSONIncludesCode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":{"code":"app_disabled","message":"app is disabled","request_id":"req-1"}}`))
+	}))
+	defer srv.Close()
+
+	stdout, stderr := captureOutput(t)
+	err := run([]string{"runs", "create", "--app", "hello", "--server", srv.URL, "--token", "tok", "--json"})
+	if code := ui.reportError(err); code != 15 {
+		t.Fatalf("exit code = %d, want 15 (err %v)", code, err)
+	}
+	var env struct {
+		Error struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+			ExitCode  int    `json:"exit_code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &env); err != nil {
+		t.Fatalf("stderr is not JSON: %q", stderr.String())
+	}
+	if env.Error.Code != "app_disabled" || env.Error.RequestID != "req-1" || env.Error.ExitCode != 15 {
+		t.Fatalf("error envelope = %+v", env.Error)
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("stdout = %q, want empty", stdout.String())
+	}
+
+	resetOutput(stdout, stderr)
+	err = run([]string{"runs", "create", "--app", "hello", "--server", srv.URL, "--token", "tok"})
+	ui.reportError(err)
+	if !strings.HasPrefix(stderr.String(), "error: app is disabled") {
+		t.Fatalf("stderr = %q, want text error", stderr.String())
+	}
+}
+
+func TestProfileTLSSettings(t *testing.T) {
+	_, stderr := captureOutput(t)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"apps":[]}`))
+	}))
+	defer srv.Close()
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caCert, pemData, 0o600); err != nil {
+		t.Fatalf("write CA cert: %v", err)
+	}
+	listApps := func(profile string) error {
+		return run([]string{"apps", "list", "--profile", profile, "--json"})
+	}
+
+	if err := run([]string{"config", "set", "--profile", "plain", "--server", srv.URL, "--token", "tok"}); err != nil {
+		t.Fatalf("config set: %v", err)
+	}
+	if err := listApps("plain"); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected a certificate error without a CA, got %v", err)
+	}
+
+	if err := run([]string{"config", "set", "--profile", "ca", "--server", srv.URL, "--token", "tok", "--ca-cert", caCert}); err != nil {
+		t.Fatalf("config set --ca-cert: %v", err)
+	}
+	stderr.Reset()
+	if err := listApps("ca"); err != nil {
+		t.Fatalf("expected the CA to be trusted, got %v", err)
+	}
+	if strings.Contains(stderr.String(), "warning") {
+		t.Fatalf("expected no warning with a CA, got %q", stderr.String())
+	}
+
+	if err := run([]string{"config", "set", "--profile", "lab", "--server", srv.URL, "--token", "tok", "--insecure"}); err != nil {
+		t.Fatalf("config set --insecure: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		stderr.Reset()
+		if err := listApps("lab"); err != nil {
+			t.Fatalf("expected verification skipped, got %v", err)
+		}
+		if want := "warning: TLS certificate verification is disabled for " + srv.URL + "\n"; stderr.String() != want {
+			t.Fatalf("expected the insecure warning on every use, got %q", stderr.String())
+		}
+	}
+	if err := run([]string{"config", "set", "--profile", "lab", "--insecure=false"}); err != nil {
+		t.Fatalf("config set --insecure=false: %v", err)
+	}
+	if err := listApps("lab"); err == nil {
+		t.Fatal("expected verification back on")
+	}
+
+	err := run([]string{"config", "set", "--profile", "ca", "--ca-cert", filepath.Join(t.TempDir(), "missing.pem")})
+	var ee *exitError
+	if !errors.As(err, &ee) || !strings.HasPrefix(ee.Message, "--ca-cert: read CA certificate:") {
+		t.Fatalf("expected an unreadable CA rejected, got %v", err)
+	}
+}
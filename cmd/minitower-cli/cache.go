@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxCacheEntries caps the cached responses kept per profile; the oldest are
+// pruned on write.
+const maxCacheEntries = 32
+
+// refreshCache is set by the global --refresh-cache flag. It makes cached
+// commands call the API and update the cache even when --cached is given.
+var refreshCache bool
+
+// cacheEntry is one cached GET response.
+type cacheEntry struct {
+	Server   string          `json:"server"`
+	Path     string          `json:"path"`
+	CachedAt time.Time       `json:"cached_at"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// cacheDir returns the response cache directory of a profile, next to the
+// config file.
+func cacheDir(profileName string) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "cache", normalizeProfileName(profileName)), nil
+}
+
+// cacheFile names the entry for a server and API path.
+func cacheFile(dir, server, apiPath string) string {
+	sum := sha256.Sum256([]byte(server + " " + apiPath))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// getJSONCached GETs apiPath into out. With cached set (and no
+// --refresh-cache) it reads the last successful response from the profile's
+// cache instead, printing when it was cached; otherwise a successful response
+// is written to the cache.
+func getJSONCached(client *apiClient, conn *resolvedConnection, apiPath string, cached bool, out any) error {
+	dir, err := cacheDir(conn.ProfileName)
+	if err != nil {
+		return err
+	}
+	file := cacheFile(dir, conn.Server, apiPath)
+
+	if cached && !refreshCache {
+		entry, err := readCacheEntry(file)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return &exitError{Code: 1, Message: fmt.Sprintf("no cached response for %s in profile %q; run the command without --cached first", apiPath, normalizeProfileName(conn.ProfileName))}
+		}
+		ui.warnf("CACHED at %s (%s old)\n", entry.CachedAt.Local().Format(time.RFC3339), formatElapsed(time.Since(entry.CachedAt)))
+		if err := json.Unmarshal(entry.Body, out); err != nil {
+			return &exitError{Code: 1, Message: fmt.Sprintf("decode cached response: %v", err)}
+		}
+		return nil
+	}
+
+	var body json.RawMessage
+	if err := client.doJSON(context.Background(), http.MethodGet, apiPath, nil, &body); err != nil {
+		return mapError(err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	entry := cacheEntry{Server: conn.Server, Path: apiPath, CachedAt: time.Now().UTC(), Body: body}
+	if err := writeCacheEntry(dir, file, entry); err != nil {
+		ui.warnf("warning: could not update response cache: %v\n", err)
+	}
+	return nil
+}
+
+// getJSONFresh GETs apiPath into out, but serves the profile's cached
+// response instead while it is younger than maxAge. --refresh-cache always
+// calls the API.
+func getJSONFresh(ctx context.Context, client *apiClient, conn *resolvedConnection, apiPath string, maxAge time.Duration, out any) error {
+	dir, err := cacheDir(conn.ProfileName)
+	if err != nil {
+		return err
+	}
+	file := cacheFile(dir, conn.Server, apiPath)
+
+	if !refreshCache {
+		if entry, err := readCacheEntry(file); err == nil && entry != nil && time.Since(entry.CachedAt) < maxAge {
+			if err := json.Unmarshal(entry.Body, out); err == nil {
+				return nil
+			}
+		}
+	}
+
+	var body json.RawMessage
+	if err := client.doJSON(ctx, http.MethodGet, apiPath, nil, &body); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	entry := cacheEntry{Server: conn.Server, Path: apiPath, CachedAt: time.Now().UTC(), Body: body}
+	if err := writeCacheEntry(dir, file, entry); err != nil {
+		ui.warnf("warning: could not update response cache: %v\n", err)
+	}
+	return nil
+}
+
+func readCacheEntry(file string) (*cacheEntry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read response cache: %w", err)
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("parse response cache: %w", err)
+	}
+	return &entry, nil
+}
+
+// writeCacheEntry replaces the entry atomically, then prunes the profile's
+// cache to maxCacheEntries.
+func writeCacheEntry(dir, file string, entry cacheEntry) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return pruneCache(dir)
+}
+
+// pruneCache removes the least recently written entries beyond
+// maxCacheEntries.
+func pruneCache(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	type cached struct {
+		path    string
+		modTime time.Time
+	}
+	var files []cached
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cached{path: filepath.Join(dir, e.Name()), modTime: info.ModTime()})
+	}
+	if len(files) <= maxCacheEntries {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	for _, f := range files[maxCacheEntries:] {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedRunsListServedWhileServerDown(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	srv := newRunsServer(t)
+
+	if err := run([]string{"config", "set", "--server", srv.URL, "--token", "tok"}); err != nil {
+		t.Fatalf("config set: %v", err)
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "list", "--json"}); err != nil {
+		t.Fatalf("runs list: %v", err)
+	}
+	live := stdout.String()
+
+	dir, err := cacheDir("default")
+	if err != nil {
+		t.Fatalf("cache dir: %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one cache entry, got %v (%v)", files, err)
+	}
+
+	srv.Close()
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "list", "--json", "--cached"}); err != nil {
+		t.Fatalf("cached runs list: %v", err)
+	}
+	if stdout.String() != live {
+		t.Fatalf("cached output differs:\nlive:   %q\ncached: %q", live, stdout.String())
+	}
+	if !strings.HasPrefix(stderr.String(), "CACHED at ") {
+		t.Fatalf("expected cached banner, got %q", stderr.String())
+	}
+
+	// The banner shows how stale the entry is, even under --quiet.
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read entry: %v", err)
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("parse entry: %v", err)
+	}
+	entry.CachedAt = time.Now().Add(-2 * time.Hour)
+	data, _ = json.Marshal(entry)
+	if err := os.WriteFile(files[0], data, 0o600); err != nil {
+		t.Fatalf("write entry: %v", err)
+	}
+	resetOutput(stdout, stderr)
+	if err := run([]string{"--quiet", "runs", "list", "--cached"}); err != nil {
+		t.Fatalf("cached runs list: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "(2h00m00s old)") {
+		t.Fatalf("expected staleness in banner, got %q", stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "RUN_ID") {
+		t.Fatalf("expected cached table, got %q", stdout.String())
+	}
+
+	// --refresh-cache goes to the API even with --cached.
+	resetOutput(stdout, stderr)
+	if err := run([]string{"--refresh-cache", "runs", "list", "--cached"}); err == nil {
+		t.Fatalf("expected --refresh-cache to call the stopped server")
+	}
+}
+
+func TestCachedWithoutEntryErrors(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	if err := run([]string{"config", "set", "--server", "http://example.invalid", "--token", "tok"}); err != nil {
+		t.Fatalf("config set: %v", err)
+	}
+
+	resetOutput(stdout, stderr)
+	err := run([]string{"me", "--cached"})
+	if err == nil {
+		t.Fatalf("expected an error without a cache entry")
+	}
+	if !strings.Contains(err.Error(), `no cached response for /api/v1/me in profile "default"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("expected nothing on stdout, got %q", stdout.String())
+	}
+}
+
+func TestPruneCacheKeepsNewestEntries(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < maxCacheEntries+3; i++ {
+		path := filepath.Join(dir, strings.Repeat("a", i+1)+".json")
+		if err := os.WriteFile(path, []byte("{}"), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		at := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+
+	if err := pruneCache(dir); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != maxCacheEntries {
+		t.Fatalf("expected %d entries, got %d", maxCacheEntries, len(files))
+	}
+	for _, oldest := range []string{"a", "aa", "aaa"} {
+		if _, err := os.Stat(filepath.Join(dir, oldest+".json")); !os.IsNotExist(err) {
+			t.Fatalf("expected %s pruned, got %v", oldest, err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// column is one field a list command can print. value returns "" for an
+// unset field; tables show that as "-" and porcelain as an empty field.
+type column[T any] struct {
+	name   string
+	header string
+	value  func(T) string
+}
+
+// columnSet is every column a resource offers, in the order --columns lists
+// them as valid, and the names its table shows by default.
+type columnSet[T any] struct {
+	resource string
+	columns  []column[T]
+	defaults []string
+}
+
+// parse resolves a --columns value to columns in the order given. An empty
+// value returns nil so the caller can fall back to its defaults.
+func (s columnSet[T]) parse(spec string) ([]column[T], error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var cols []column[T]
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		c, ok := s.lookup(name)
+		if !ok {
+			return nil, &exitError{Code: 1, Message: fmt.Sprintf("unknown %s column %q (valid: %s)", s.resource, name, strings.Join(s.names(), ", "))}
+		}
+		cols = append(cols, c)
+	}
+	return cols, nil
+}
+
+// pick returns the named columns; names must all exist.
+func (s columnSet[T]) pick(names []string) []column[T] {
+	cols := make([]column[T], 0, len(names))
+	for _, name := range names {
+		c, ok := s.lookup(name)
+		if !ok {
+			panic("unknown " + s.resource + " column " + name)
+		}
+		cols = append(cols, c)
+	}
+	return cols
+}
+
+func (s columnSet[T]) lookup(name string) (column[T], bool) {
+	for _, c := range s.columns {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return column[T]{}, false
+}
+
+func (s columnSet[T]) names() []string {
+	names := make([]string, len(s.columns))
+	for i, c := range s.columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// printColumns writes rows as a table with one column per entry in cols.
+func printColumns[T any](cols []column[T], rows []T) {
+	tw := ui.table()
+	cells := make([]string, len(cols))
+	for i, c := range cols {
+		cells[i] = c.header
+	}
+	fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	for _, row := range rows {
+		for i, c := range cols {
+			cells[i] = c.value(row)
+			if cells[i] == "" {
+				cells[i] = "-"
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	_ = tw.Flush()
+}
+
+// printColumnsPorcelain writes one porcelain record per row holding exactly
+// the fields in cols, in order.
+func printColumnsPorcelain[T any](cols []column[T], rows []T) {
+	for _, row := range rows {
+		fields := make([]string, len(cols))
+		for i, c := range cols {
+			fields[i] = c.value(row)
+		}
+		ui.porcelainLine(fields...)
+	}
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// formatDuration is finished minus started, or "" unless both parse.
+func formatDuration(startedAt, finishedAt *string) string {
+	if startedAt == nil || finishedAt == nil {
+		return ""
+	}
+	start, err := time.Parse(time.RFC3339, *startedAt)
+	if err != nil {
+		return ""
+	}
+	end, err := time.Parse(time.RFC3339, *finishedAt)
+	if err != nil {
+		return ""
+	}
+	return end.Sub(start).String()
+}
+
+var runColumns = columnSet[runResponse]{
+	resource: "runs",
+	columns: []column[runResponse]{
+		{"run_id", "RUN_ID", func(r runResponse) string { return strconv.FormatInt(r.RunID, 10) }},
+		{"run_no", "RUN_NO", func(r runResponse) string { return strconv.FormatInt(r.RunNo, 10) }},
+		{"app", "APP", func(r runResponse) string { return r.AppSlug }},
+		{"status", "STATUS", func(r runResponse) string { return r.Status }},
+		{"reason", "REASON", func(r runResponse) string {
+			switch r.Status {
+			case "dead":
+				return r.DeadReason
+			case "failed":
+				return r.FailureKind
+			}
+			return ""
+		}},
+		{"version", "VERSION", func(r runResponse) string { return strconv.FormatInt(r.VersionNo, 10) }},
+		{"queued_at", "QUEUED_AT", func(r runResponse) string { return r.QueuedAt }},
+		{"started_at", "STARTED_AT", func(r runResponse) string { return derefString(r.StartedAt) }},
+		{"finished_at", "FINISHED_AT", func(r runResponse) string { return derefString(r.FinishedAt) }},
+		{"duration", "DURATION", func(r runResponse) string { return formatDuration(r.StartedAt, r.FinishedAt) }},
+		{"entrypoint", "ENTRYPOINT", func(r runResponse) string { return r.Entrypoint }},
+		{"command", "COMMAND", func(r runResponse) string { return r.Command }},
+		{"input", "INPUT", func(r runResponse) string {
+			if r.InputOmitted {
+				return "(omitted)"
+			}
+			if len(r.Input) == 0 {
+				return ""
+			}
+			data, err := json.Marshal(r.Input)
+			if err != nil {
+				return ""
+			}
+			return string(data)
+		}},
+		{"priority", "PRIORITY", func(r runResponse) string { return strconv.Itoa(r.Priority) }},
+		{"retry_count", "RETRY_COUNT", func(r runResponse) string { return strconv.Itoa(r.RetryCount) }},
+		{"max_retries", "MAX_RETRIES", func(r runResponse) string { return strconv.Itoa(r.MaxRetries) }},
+		{"retries", "RETRIES", func(r runResponse) string { return fmt.Sprintf("%d/%d", r.RetryCount, r.MaxRetries) }},
+		{"environment", "ENVIRONMENT", func(r runResponse) string { return r.Environment }},
+		{"batch_id", "BATCH_ID", func(r runResponse) string { return r.BatchID }},
+		{"rerun_of", "RERUN_OF", func(r runResponse) string {
+			if r.RerunOfRunID == nil {
+				return ""
+			}
+			return strconv.FormatInt(*r.RerunOfRunID, 10)
+		}},
+		{"exit_code", "EXIT_CODE", func(r runResponse) string {
+			if r.ExitCode == nil {
+				return ""
+			}
+			return strconv.Itoa(*r.ExitCode)
+		}},
+		{"runner", "RUNNER", func(r runResponse) string { return r.RunnerName }},
+	},
+	defaults: []string{"run_id", "run_no", "app", "status", "reason", "version", "retries", "queued_at"},
+}
+
+// runRunnerDefaults are the runs defaults with --include runner.
+var runRunnerDefaults = []string{"run_id", "run_no", "app", "status", "reason", "version", "runner", "retries", "queued_at"}
+
+var appColumns = columnSet[appResponse]{
+	resource: "apps",
+	columns: []column[appResponse]{
+		{"app_id", "APP_ID", func(a appResponse) string { return strconv.FormatInt(a.AppID, 10) }},
+		{"slug", "SLUG", func(a appResponse) string { return a.Slug }},
+		{"disabled", "DISABLED", func(a appResponse) string { return strconv.FormatBool(a.Disabled) }},
+		{"last_run", "LAST_RUN", func(a appResponse) string {
+			if a.Stats == nil {
+				return ""
+			}
+			return derefString(a.Stats.LastRunStatus)
+		}},
+		{"success", "SUCCESS", func(a appResponse) string {
+			if a.Stats == nil || a.Stats.SuccessRate == nil {
+				return ""
+			}
+			return fmt.Sprintf("%.0f%%", *a.Stats.SuccessRate*100)
+		}},
+		{"description", "DESCRIPTION", func(a appResponse) string { return derefString(a.Description) }},
+		{"created_at", "CREATED_AT", func(a appResponse) string { return a.CreatedAt }},
+		{"updated_at", "UPDATED_AT", func(a appResponse) string { return a.UpdatedAt }},
+	},
+	defaults: []string{"app_id", "slug", "disabled", "description", "updated_at"},
+}
+
+// appStatsDefaults are the apps defaults when the response carries stats.
+var appStatsDefaults = []string{"app_id", "slug", "disabled", "last_run", "success", "description", "updated_at"}
+
+var versionColumns = columnSet[versionResponse]{
+	resource: "versions",
+	columns: []column[versionResponse]{
+		{"version_no", "VERSION_NO", func(v versionResponse) string { return strconv.FormatInt(v.VersionNo, 10) }},
+		{"version_id", "VERSION_ID", func(v versionResponse) string { return strconv.FormatInt(v.VersionID, 10) }},
+		{"entrypoint", "ENTRYPOINT", func(v versionResponse) string { return v.Entrypoint }},
+		{"sha256", "SHA256", func(v versionResponse) string {
+			if len(v.ArtifactSHA256) > 12 {
+				return v.ArtifactSHA256[:12]
+			}
+			return v.ArtifactSHA256
+		}},
+		{"labels", "LABELS", func(v versionResponse) string { return strings.Join(v.Labels, ",") }},
+		{"timeout", "TIMEOUT", func(v versionResponse) string {
+			if v.TimeoutSeconds == nil {
+				return ""
+			}
+			return fmt.Sprintf("%ds", *v.TimeoutSeconds)
+		}},
+		{"import_paths", "IMPORT_PATHS", func(v versionResponse) string { return strings.Join(v.ImportPaths, ",") }},
+		{"schema_version", "SCHEMA_VERSION", func(v versionResponse) string {
+			if v.TowerfileSchemaVersion == 0 {
+				return ""
+			}
+			return strconv.Itoa(v.TowerfileSchemaVersion)
+		}},
+		{"promoted_from", "PROMOTED_FROM", func(v versionResponse) string {
+			if v.PromotedFrom == nil {
+				return ""
+			}
+			return fmt.Sprintf("%s@%d", v.PromotedFrom.App, v.PromotedFrom.VersionNo)
+		}},
+		{"created_at", "CREATED_AT", func(v versionResponse) string { return v.CreatedAt }},
+	},
+	defaults: []string{"version_no", "version_id", "entrypoint", "sha256", "labels", "promoted_from", "created_at"},
+}
+
+var runnerColumns = columnSet[adminRunnerResponse]{
+	resource: "runners",
+	columns: []column[adminRunnerResponse]{
+		{"runner_id", "RUNNER_ID", func(r adminRunnerResponse) string { return strconv.FormatInt(r.RunnerID, 10) }},
+		{"name", "NAME", func(r adminRunnerResponse) string { return r.Name }},
+		{"environment", "ENVIRONMENT", func(r adminRunnerResponse) string { return r.Environment }},
+		{"status", "STATUS", func(r adminRunnerResponse) string { return r.Status }},
+		{"python", "PYTHON", func(r adminRunnerResponse) string { return strings.Join(r.PythonVersions, ",") }},
+		{"cpu", "CPU%", func(r adminRunnerResponse) string {
+			if r.Stats == nil || r.Stats.CPUPercent == nil {
+				return ""
+			}
+			return fmt.Sprintf("%.1f", *r.Stats.CPUPercent)
+		}},
+		{"mem", "MEM", func(r adminRunnerResponse) string {
+			if r.Stats == nil || r.Stats.MemUsedBytes == nil || r.Stats.MemTotalBytes == nil {
+				return ""
+			}
+			return formatBytes(*r.Stats.MemUsedBytes) + "/" + formatBytes(*r.Stats.MemTotalBytes)
+		}},
+		{"disk", "DISK", func(r adminRunnerResponse) string {
+			if r.Stats == nil || r.Stats.DiskFreeBytes == nil {
+				return ""
+			}
+			return formatBytes(*r.Stats.DiskFreeBytes) + " free"
+		}},
+		{"load1", "LOAD1", func(r adminRunnerResponse) string {
+			if r.Stats == nil || r.Stats.Load1 == nil {
+				return ""
+			}
+			return fmt.Sprintf("%.2f", *r.Stats.Load1)
+		}},
+		{"last_seen_at", "LAST_SEEN_AT", func(r adminRunnerResponse) string { return derefString(r.LastSeenAt) }},
+		{"stats_at", "STATS_AT", func(r adminRunnerResponse) string { return derefString(r.StatsAt) }},
+	},
+	defaults: []string{"runner_id", "name", "environment", "status", "cpu", "mem", "disk", "last_seen_at"},
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -15,64 +19,19 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"text/tabwriter"
 	"time"
 
+	"minitower/internal/apierror"
+	"minitower/internal/httputil"
 	"minitower/internal/towerfile"
 )
 
-func run(args []string) error {
-	if len(args) == 0 {
-		printRootUsage(os.Stderr)
-		return &exitError{Code: 1}
-	}
-
-	switch args[0] {
-	case "-h", "--help", "help":
-		printRootUsage(os.Stdout)
-		return nil
-	case "deploy":
-		return cmdDeploy(args[1:])
-	case "login":
-		return cmdLogin(args[1:])
-	case "config":
-		return cmdConfig(args[1:])
-	case "me":
-		return cmdMe(args[1:])
-	case "apps":
-		return cmdApps(args[1:])
-	case "versions":
-		return cmdVersions(args[1:])
-	case "runs":
-		return cmdRuns(args[1:])
-	case "tokens":
-		return cmdTokens(args[1:])
-	case "runners":
-		return cmdRunners(args[1:])
-	default:
-		printRootUsage(os.Stderr)
-		return &exitError{Code: 1, Message: fmt.Sprintf("unknown command: %s", args[0])}
-	}
-}
-
-func printRootUsage(w io.Writer) {
-	fmt.Fprintln(w, "usage: minitower-cli <command> [args]")
-	fmt.Fprintln(w)
-	fmt.Fprintln(w, "commands:")
-	fmt.Fprintln(w, "  login                             login with team credentials")
-	fmt.Fprintln(w, "  config <set|get|list|use>         manage local profiles")
-	fmt.Fprintln(w, "  me                                show current identity")
-	fmt.Fprintln(w, "  apps <list|get|create>            manage apps")
-	fmt.Fprintln(w, "  versions <list|get|upload>        manage versions")
-	fmt.Fprintln(w, "  runs <create|list|get|cancel|retry|watch|logs>")
-	fmt.Fprintln(w, "  tokens <create|list|revoke>       manage tokens (list/revoke pending API)")
-	fmt.Fprintln(w, "  runners list                      list runners (admin)")
-	fmt.Fprintln(w, "  deploy                            deploy from Towerfile")
-}
+// flagOutput receives flag parse errors and -h output.
+var flagOutput io.Writer = os.Stderr
 
 func newFlagSet(name string) *flag.FlagSet {
 	fs := flag.NewFlagSet(name, flag.ContinueOnError)
-	fs.SetOutput(os.Stderr)
+	fs.SetOutput(flagOutput)
 	return fs
 }
 
@@ -88,69 +47,241 @@ func resolveCommandConnection(profileName, server, token string, requireToken bo
 	if err != nil {
 		return nil, nil, &exitError{Code: 1, Message: err.Error()}
 	}
-	return newAPIClient(conn.Server, conn.Token), conn, nil
+	client, err := connectionClient(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A rejected token is the usual cause of a 401; config test tells it
+	// apart from a wrong server URL or profile.
+	client.authHint = "run " + cliCommand("config test", profileName)
+	return client, conn, nil
 }
 
-func printJSON(v any) error {
-	enc := json.NewEncoder(os.Stdout)
-	enc.SetIndent("", "  ")
-	return enc.Encode(v)
+// connectionClient returns a client for conn, warning on every use of a
+// profile that skips certificate verification.
+func connectionClient(conn *resolvedConnection) (*apiClient, error) {
+	client, err := newConnectionClient(conn)
+	if err != nil {
+		return nil, &exitError{Code: 1, Message: err.Error()}
+	}
+	if conn.Insecure {
+		ui.warnf("warning: TLS certificate verification is disabled for %s\n", conn.Server)
+	}
+	return client, nil
 }
 
-func printAppTable(apps []appResponse) {
-	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "APP_ID\tSLUG\tDISABLED\tDESCRIPTION\tUPDATED_AT")
-	for _, app := range apps {
-		desc := ""
-		if app.Description != nil {
-			desc = *app.Description
+// printAppTable prints apps with cols, or the default columns when cols is
+// nil; the defaults include LAST_RUN and SUCCESS when any app has stats.
+func printAppTable(apps []appResponse, cols []column[appResponse]) {
+	if cols == nil {
+		cols = appColumns.pick(appColumns.defaults)
+		for _, app := range apps {
+			if app.Stats != nil {
+				cols = appColumns.pick(appStatsDefaults)
+				break
+			}
 		}
-		fmt.Fprintf(tw, "%d\t%s\t%t\t%s\t%s\n", app.AppID, app.Slug, app.Disabled, desc, app.UpdatedAt)
 	}
-	_ = tw.Flush()
+	printColumns(cols, apps)
+}
+
+func printVersionTable(versions []versionResponse, cols []column[versionResponse]) {
+	if cols == nil {
+		cols = versionColumns.pick(versionColumns.defaults)
+	}
+	printColumns(cols, versions)
 }
 
-func printVersionTable(versions []versionResponse) {
-	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "VERSION_NO\tVERSION_ID\tENTRYPOINT\tSHA256\tCREATED_AT")
-	for _, v := range versions {
-		sha := v.ArtifactSHA256
-		if len(sha) > 12 {
-			sha = sha[:12]
+// printVersionCommands lists a version's named commands under its table.
+func printVersionCommands(commands []versionCommand) {
+	if len(commands) == 0 {
+		return
+	}
+	fmt.Fprintln(ui.out, "\nCommands:")
+	tw := ui.table()
+	fmt.Fprintln(tw, "NAME\tSCRIPT\tTIMEOUT\tPARAMETERS")
+	for _, c := range commands {
+		timeout := "-"
+		if c.TimeoutSeconds != nil {
+			timeout = fmt.Sprintf("%ds", *c.TimeoutSeconds)
+		}
+		params := "-"
+		if props, ok := c.ParamsSchema["properties"].(map[string]any); ok && len(props) > 0 {
+			names := make([]string, 0, len(props))
+			for name := range props {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			params = strings.Join(names, ",")
 		}
-		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n", v.VersionNo, v.VersionID, v.Entrypoint, sha, v.CreatedAt)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, c.Script, timeout, params)
 	}
 	_ = tw.Flush()
 }
 
-func printRunTable(runs []runResponse) {
-	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "RUN_ID\tRUN_NO\tAPP\tSTATUS\tVERSION\tQUEUED_AT")
+// printRunsPorcelain prints one record per run: run_id, run_no, app_slug,
+// status, version_no, priority, retry_count, queued_at, started_at,
+// finished_at. Unset times are empty. The field order is a stable contract
+// for scripts; new fields are only ever appended. With cols, each record
+// holds exactly those fields instead.
+func printRunsPorcelain(runs []runResponse, cols []column[runResponse]) {
+	if cols != nil {
+		printColumnsPorcelain(cols, runs)
+		return
+	}
 	for _, r := range runs {
-		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%d\t%s\n", r.RunID, r.RunNo, r.AppSlug, r.Status, r.VersionNo, r.QueuedAt)
+		startedAt, finishedAt := "", ""
+		if r.StartedAt != nil {
+			startedAt = *r.StartedAt
+		}
+		if r.FinishedAt != nil {
+			finishedAt = *r.FinishedAt
+		}
+		ui.porcelainLine(
+			strconv.FormatInt(r.RunID, 10),
+			strconv.FormatInt(r.RunNo, 10),
+			r.AppSlug,
+			r.Status,
+			strconv.FormatInt(r.VersionNo, 10),
+			strconv.Itoa(r.Priority),
+			strconv.Itoa(r.RetryCount),
+			r.QueuedAt,
+			startedAt,
+			finishedAt,
+		)
 	}
-	_ = tw.Flush()
 }
 
-func printRunnerTable(runners []adminRunnerResponse) {
-	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "RUNNER_ID\tNAME\tENVIRONMENT\tSTATUS\tLAST_SEEN_AT")
-	for _, r := range runners {
-		lastSeen := ""
-		if r.LastSeenAt != nil {
-			lastSeen = *r.LastSeenAt
+func printRunTable(runs []runResponse, cols []column[runResponse]) {
+	if cols == nil {
+		cols = runColumns.pick(runColumns.defaults)
+	}
+	printColumns(cols, runs)
+}
+
+func printRunnerTable(runners []adminRunnerResponse, cols []column[adminRunnerResponse]) {
+	if cols == nil {
+		cols = runnerColumns.pick(runnerColumns.defaults)
+	}
+	printColumns(cols, runners)
+}
+
+func printAdminOverview(o adminOverviewResponse) {
+	ui.printf("Teams:      %d\n", o.TeamCount)
+	ui.printf("Artifacts:  %s\n", formatBytes(o.ArtifactBytes))
+	ui.printf("Runners:    %d online, %d offline\n", o.Runners.Online, o.Runners.Offline)
+	for _, env := range o.StarvedEnvironments {
+		ui.printf("Starved:    %s has %d queued runs and no online runner (oldest queued %s)\n", env.Environment, env.QueuedRuns, env.OldestQueuedAt)
+	}
+
+	statuses := make([]string, 0, len(o.RunsLast24h))
+	for status := range o.RunsLast24h {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	parts := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		parts = append(parts, fmt.Sprintf("%s=%d", status, o.RunsLast24h[status]))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "none")
+	}
+	ui.printf("Runs (24h): %s\n\n", strings.Join(parts, " "))
+
+	tw := ui.table()
+	fmt.Fprintln(tw, "TEAM_ID\tTEAM\tAPPS\tRUNS\tACTIVE/QUOTA")
+	for _, t := range o.Teams {
+		quota := "-"
+		if t.MaxActiveRuns != nil {
+			quota = strconv.FormatInt(*t.MaxActiveRuns, 10)
 		}
-		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", r.RunnerID, r.Name, r.Environment, r.Status, lastSeen)
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d/%s\n", t.TeamID, t.Slug, t.Apps, t.Runs, t.ActiveRuns, quota)
+	}
+	_ = tw.Flush()
+}
+
+// usageReportHeader names the usage report columns in table and CSV output.
+var usageReportHeader = []string{"GROUP", "RUNS", "COMPLETED", "FAILED", "EXECUTION_SECONDS", "QUEUE_SECONDS"}
+
+func usageReportFields(row usageRow) []string {
+	return []string{
+		row.Group,
+		strconv.FormatInt(row.Runs, 10),
+		strconv.FormatInt(row.Completed, 10),
+		strconv.FormatInt(row.Failed, 10),
+		strconv.FormatFloat(row.TotalExecutionSeconds, 'f', 3, 64),
+		strconv.FormatFloat(row.TotalQueueSeconds, 'f', 3, 64),
+	}
+}
+
+func printUsageReport(report usageReportResponse) {
+	tw := ui.table()
+	fmt.Fprintln(tw, strings.Join(usageReportHeader, "\t"))
+	for _, row := range report.Rows {
+		fmt.Fprintln(tw, strings.Join(usageReportFields(row), "\t"))
+	}
+	_ = tw.Flush()
+}
+
+func printUsageReportCSV(report usageReportResponse) error {
+	w := csv.NewWriter(ui.out)
+	header := make([]string, len(usageReportHeader))
+	for i, h := range usageReportHeader {
+		header[i] = strings.ToLower(h)
+	}
+	_ = w.Write(header)
+	for _, row := range report.Rows {
+		_ = w.Write(usageReportFields(row))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func printEnvironmentTable(envs []environmentResponse) {
+	tw := ui.table()
+	fmt.Fprintln(tw, "NAME\tDEFAULT\tQUEUED\tRUNNING\tRUNNERS\tCREATED_AT")
+	for _, e := range envs {
+		fmt.Fprintf(tw, "%s\t%t\t%d\t%d\t%d\t%s\n", e.Name, e.IsDefault, e.QueuedRuns, e.RunningRuns, e.OnlineRunners, e.CreatedAt)
 	}
 	_ = tw.Flush()
 }
 
-func printLogs(logs []runLogEntry) {
+// formatBytes renders a byte count with a binary unit suffix, e.g. 1.5G.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// printLogsPorcelain prints one record per log line: seq, stream, logged_at,
+// line. The field order is a stable contract for scripts.
+func printLogsPorcelain(logs []runLogEntry) {
 	for _, l := range logs {
-		fmt.Printf("[%d] %s %s\n", l.Seq, strings.ToUpper(l.Stream), l.Line)
+		ui.porcelainLine(strconv.FormatInt(l.Seq, 10), l.Stream, l.LoggedAt, l.Line)
 	}
 }
 
+// apiCodeExitCodes gives selected API error codes an exit code of their
+// own, so scripts can tell them apart from other errors with the same HTTP
+// status. Codes not listed fall back to apiStatusExitCode.
+var apiCodeExitCodes = map[apierror.Code]int{
+	apierror.SlugTaken:     14,
+	apierror.NameTaken:     14,
+	apierror.TeamExists:    14,
+	apierror.RunnerExists:  14,
+	apierror.AppDisabled:   15,
+	apierror.RunNotQueued:  16,
+	apierror.Unavailable:   17,
+	apierror.QuotaExceeded: 18,
+}
+
 func apiStatusExitCode(status int) int {
 	switch status {
 	case http.StatusUnauthorized, http.StatusForbidden:
@@ -172,7 +303,17 @@ func mapError(err error) error {
 	}
 	var ae *apiError
 	if errors.As(err, &ae) {
-		return &exitError{Code: apiStatusExitCode(ae.Status), Message: ae.Message}
+		msg := ae.Message
+		if ae.RequestID != "" {
+			// Quoting the request ID lets operators find the matching
+			// server log lines.
+			msg = fmt.Sprintf("%s (request id: %s)", msg, ae.RequestID)
+		}
+		code, ok := apiCodeExitCodes[apierror.Code(ae.Code)]
+		if !ok {
+			code = apiStatusExitCode(ae.Status)
+		}
+		return &exitError{Code: code, Message: msg, APICode: ae.Code, RequestID: ae.RequestID, Hint: ae.Hint}
 	}
 	return err
 }
@@ -183,7 +324,7 @@ func cmdLogin(args []string) error {
 	team := fs.String("team", "", "team slug")
 	password := fs.String("password", "", "team password")
 	profileName := fs.String("profile", "", "profile name")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
@@ -205,6 +346,10 @@ func cmdLogin(args []string) error {
 	if existing == nil {
 		existing = &profile{}
 	}
+	jsonOut, err := formats.resolve(existing.Output)
+	if err != nil {
+		return err
+	}
 
 	resolvedServer := strings.TrimSpace(*server)
 	if resolvedServer == "" {
@@ -227,9 +372,9 @@ func cmdLogin(args []string) error {
 
 	resolvedPassword := strings.TrimSpace(*password)
 	if resolvedPassword == "" {
-		fmt.Fprint(os.Stderr, "Password: ")
+		fmt.Fprint(ui.errOut, "Password: ")
 		line, readErr := bufio.NewReader(os.Stdin).ReadString('\n')
-		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(ui.errOut)
 		if readErr != nil && !errors.Is(readErr, io.EOF) {
 			return &exitError{Code: 1, Message: fmt.Sprintf("read password: %v", readErr)}
 		}
@@ -239,7 +384,14 @@ func cmdLogin(args []string) error {
 		return &exitError{Code: 1, Message: "password is required"}
 	}
 
-	client := newAPIClient(resolvedServer, "")
+	client, err := connectionClient(&resolvedConnection{
+		Server:   resolvedServer,
+		CACert:   strings.TrimSpace(existing.CACert),
+		Insecure: existing.InsecureSkipVerify,
+	})
+	if err != nil {
+		return err
+	}
 	var resp loginResponse
 	err = client.doJSON(context.Background(), http.MethodPost, "/api/v1/teams/login", map[string]string{
 		"slug":     resolvedTeam,
@@ -258,38 +410,18 @@ func cmdLogin(args []string) error {
 		return err
 	}
 
-	if *jsonOut {
-		return printJSON(map[string]any{
+	if jsonOut {
+		return ui.json(map[string]any{
 			"profile": name,
 			"login":   resp,
 		})
 	}
 
-	fmt.Printf("Logged in as team %q (role: %s)\n", resolvedTeam, resp.Role)
-	fmt.Printf("Profile %q updated\n", name)
+	ui.infof("Logged in as team %q (role: %s)\n", resolvedTeam, resp.Role)
+	ui.infof("Profile %q updated\n", name)
 	return nil
 }
 
-func cmdConfig(args []string) error {
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "usage: minitower-cli config <set|get|list|use> ...")
-		return &exitError{Code: 1}
-	}
-
-	switch args[0] {
-	case "set":
-		return cmdConfigSet(args[1:])
-	case "get":
-		return cmdConfigGet(args[1:])
-	case "list":
-		return cmdConfigList(args[1:])
-	case "use":
-		return cmdConfigUse(args[1:])
-	default:
-		return &exitError{Code: 1, Message: fmt.Sprintf("unknown config subcommand: %s", args[0])}
-	}
-}
-
 func cmdConfigSet(args []string) error {
 	fs := newFlagSet("config set")
 	profileName := fs.String("profile", "", "profile name")
@@ -297,13 +429,39 @@ func cmdConfigSet(args []string) error {
 	token := fs.String("token", "", "API token")
 	team := fs.String("team", "", "default team slug")
 	app := fs.String("app", "", "default app slug")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	outputFormat := fs.String("output", "", "default output format (table|json)")
+	caCert := fs.String("ca-cert", "", "PEM CA bundle to trust for the server's certificate")
+	insecure := fs.Bool("insecure", false, "skip server certificate verification (--insecure=false turns it back on)")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
 	if err := ensureNoExtraArgs(fs); err != nil {
 		return err
 	}
+	if v := strings.TrimSpace(*outputFormat); v != "" {
+		if err := validateOutputFormat(v); err != nil {
+			return err
+		}
+	}
+	caCertPath := strings.TrimSpace(*caCert)
+	if caCertPath != "" {
+		// Stored absolute so the profile works from any directory.
+		abs, err := filepath.Abs(caCertPath)
+		if err != nil {
+			return &exitError{Code: 1, Message: fmt.Sprintf("--ca-cert: %v", err)}
+		}
+		if _, err := httputil.LoadCertPool(abs); err != nil {
+			return &exitError{Code: 1, Message: fmt.Sprintf("--ca-cert: %v", err)}
+		}
+		caCertPath = abs
+	}
+	insecureSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "insecure" {
+			insecureSet = true
+		}
+	})
 
 	cfg, err := loadProfileConfig()
 	if err != nil {
@@ -337,9 +495,25 @@ func cmdConfigSet(args []string) error {
 		p.App = strings.TrimSpace(*app)
 		updated = true
 	}
+	if strings.TrimSpace(*outputFormat) != "" {
+		p.Output = strings.TrimSpace(*outputFormat)
+		updated = true
+	}
+	if caCertPath != "" {
+		p.CACert = caCertPath
+		updated = true
+	}
+	if insecureSet {
+		p.InsecureSkipVerify = *insecure
+		updated = true
+	}
 
 	if !updated {
-		return &exitError{Code: 1, Message: "no changes provided (set one of: --server --token --team --app)"}
+		return &exitError{Code: 1, Message: "no changes provided (set one of: --server --token --team --app --output --ca-cert --insecure)"}
+	}
+	jsonOut, err := formats.resolve(p.Output)
+	if err != nil {
+		return err
 	}
 
 	cfg.Profiles[name] = p
@@ -350,17 +524,17 @@ func cmdConfigSet(args []string) error {
 		return err
 	}
 
-	if *jsonOut {
-		return printJSON(map[string]any{"profile": name, "config": p})
+	if jsonOut {
+		return ui.json(map[string]any{"profile": name, "config": p})
 	}
-	fmt.Printf("Profile %q updated\n", name)
+	ui.infof("Profile %q updated\n", name)
 	return nil
 }
 
 func cmdConfigGet(args []string) error {
 	fs := newFlagSet("config get")
 	profileName := fs.String("profile", "", "profile name")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
@@ -379,9 +553,13 @@ func cmdConfigGet(args []string) error {
 	if p == nil {
 		return &exitError{Code: 1, Message: "no profile configured"}
 	}
+	jsonOut, err := formats.resolve(p.Output)
+	if err != nil {
+		return err
+	}
 
-	if *jsonOut {
-		return printJSON(map[string]any{
+	if jsonOut {
+		return ui.json(map[string]any{
 			"profile":         name,
 			"is_current":      name == normalizeProfileName(cfg.CurrentProfile),
 			"current_profile": normalizeProfileName(cfg.CurrentProfile),
@@ -389,24 +567,29 @@ func cmdConfigGet(args []string) error {
 		})
 	}
 
-	fmt.Printf("Profile: %s\n", name)
+	ui.printf("Profile: %s\n", name)
 	if name == normalizeProfileName(cfg.CurrentProfile) {
-		fmt.Fprintln(os.Stdout, "Current: true")
+		ui.printf("Current: true\n")
+	}
+	ui.printf("Server: %s\n", p.Server)
+	ui.printf("Team: %s\n", p.Team)
+	ui.printf("Default App: %s\n", p.App)
+	ui.printf("Output: %s\n", p.Output)
+	ui.printf("CA Cert: %s\n", p.CACert)
+	if p.InsecureSkipVerify {
+		ui.printf("Insecure: true\n")
 	}
-	fmt.Printf("Server: %s\n", p.Server)
-	fmt.Printf("Team: %s\n", p.Team)
-	fmt.Printf("Default App: %s\n", p.App)
 	if p.Token != "" {
-		fmt.Fprintln(os.Stdout, "Token: set")
+		ui.printf("Token: set\n")
 	} else {
-		fmt.Fprintln(os.Stdout, "Token: not set")
+		ui.printf("Token: not set\n")
 	}
 	return nil
 }
 
 func cmdConfigList(args []string) error {
 	fs := newFlagSet("config list")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
@@ -425,7 +608,16 @@ func cmdConfigList(args []string) error {
 	}
 	sort.Strings(names)
 
-	if *jsonOut {
+	var currentOutput string
+	if current := cfg.Profiles[normalizeProfileName(cfg.CurrentProfile)]; current != nil {
+		currentOutput = current.Output
+	}
+	jsonOut, err := formats.resolve(currentOutput)
+	if err != nil {
+		return err
+	}
+
+	if jsonOut {
 		profiles := make([]map[string]any, 0, len(names))
 		for _, name := range names {
 			profiles = append(profiles, map[string]any{
@@ -434,19 +626,19 @@ func cmdConfigList(args []string) error {
 				"config":     cfg.Profiles[name],
 			})
 		}
-		return printJSON(map[string]any{
+		return ui.json(map[string]any{
 			"current_profile": normalizeProfileName(cfg.CurrentProfile),
 			"profiles":        profiles,
 		})
 	}
 
 	if len(names) == 0 {
-		fmt.Fprintln(os.Stdout, "No profiles configured.")
+		ui.infof("No profiles configured.\n")
 		return nil
 	}
 
-	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "CURRENT\tPROFILE\tSERVER\tTEAM\tAPP\tTOKEN")
+	tw := ui.table()
+	fmt.Fprintln(tw, "CURRENT\tPROFILE\tSERVER\tTEAM\tAPP\tOUTPUT\tTOKEN")
 	for _, name := range names {
 		p := cfg.Profiles[name]
 		current := ""
@@ -457,7 +649,7 @@ func cmdConfigList(args []string) error {
 		if p.Token != "" {
 			token = "set"
 		}
-		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", current, name, p.Server, p.Team, p.App, token)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", current, name, p.Server, p.Team, p.App, p.Output, token)
 	}
 	_ = tw.Flush()
 	return nil
@@ -485,7 +677,7 @@ func cmdConfigUse(args []string) error {
 		return err
 	}
 
-	fmt.Printf("Current profile set to %q\n", name)
+	ui.infof("Current profile set to %q\n", name)
 	return nil
 }
 
@@ -494,7 +686,8 @@ func cmdMe(args []string) error {
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	cached := fs.Bool("cached", false, "print the last cached response instead of calling the API")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
@@ -502,41 +695,33 @@ func cmdMe(args []string) error {
 		return err
 	}
 
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
 	if err != nil {
 		return err
 	}
 
 	var resp meResponse
-	if err := client.doJSON(context.Background(), http.MethodGet, "/api/v1/me", nil, &resp); err != nil {
-		return mapError(err)
+	if err := getJSONCached(client, conn, "/api/v1/me", *cached, &resp); err != nil {
+		return err
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	fmt.Printf("Team: %s (id=%d)\n", resp.TeamSlug, resp.TeamID)
-	fmt.Printf("Token ID: %d\n", resp.TokenID)
-	fmt.Printf("Role: %s\n", resp.Role)
-	return nil
-}
-
-func cmdApps(args []string) error {
-	if len(args) == 0 {
-		return &exitError{Code: 1, Message: "usage: minitower-cli apps <list|get|create> ..."}
+	ui.printf("Team: %s (id=%d)\n", resp.TeamSlug, resp.TeamID)
+	ui.printf("Token ID: %d\n", resp.TokenID)
+	ui.printf("Role: %s\n", resp.Role)
+	if len(resp.Scopes) > 0 {
+		ui.printf("Scopes: %s\n", strings.Join(resp.Scopes, ", "))
 	}
-	var err error
-	switch args[0] {
-	case "list":
-		err = cmdAppsList(args[1:])
-	case "get":
-		err = cmdAppsGet(args[1:])
-	case "create":
-		err = cmdAppsCreate(args[1:])
-	default:
-		err = &exitError{Code: 1, Message: fmt.Sprintf("unknown apps subcommand: %s", args[0])}
+	if resp.AppSlug != "" {
+		ui.printf("App: %s\n", resp.AppSlug)
 	}
-	return err
+	return nil
 }
 
 func cmdAppsList(args []string) error {
@@ -544,28 +729,43 @@ func cmdAppsList(args []string) error {
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	withStats := fs.Bool("stats", false, "include last run status and success rate")
+	cached := fs.Bool("cached", false, "print the last cached response instead of calling the API")
+	columns := fs.String("columns", "", "comma-separated columns to print")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
 	if err := ensureNoExtraArgs(fs); err != nil {
 		return err
 	}
+	cols, err := appColumns.parse(*columns)
+	if err != nil {
+		return err
+	}
 
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
 	if err != nil {
 		return err
 	}
 
+	path := "/api/v1/apps"
+	if *withStats {
+		path += "?include=stats"
+	}
 	var resp listAppsResponse
-	if err := client.doJSON(context.Background(), http.MethodGet, "/api/v1/apps", nil, &resp); err != nil {
-		return mapError(err)
+	if err := getJSONCached(client, conn, path, *cached, &resp); err != nil {
+		return err
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	printAppTable(resp.Apps)
+	printAppTable(resp.Apps, cols)
 	return nil
 }
 
@@ -574,7 +774,7 @@ func cmdAppsGet(args []string) error {
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
@@ -582,7 +782,11 @@ func cmdAppsGet(args []string) error {
 		return &exitError{Code: 1, Message: "usage: minitower-cli apps get <app>"}
 	}
 
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
 	if err != nil {
 		return err
 	}
@@ -594,10 +798,88 @@ func cmdAppsGet(args []string) error {
 		return mapError(err)
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	printAppTable([]appResponse{resp})
+	printAppTable([]appResponse{resp}, nil)
+	if resp.DefaultInput != nil {
+		data, err := json.Marshal(resp.DefaultInput)
+		if err != nil {
+			return &exitError{Code: 1, Message: fmt.Sprintf("encode default input: %v", err)}
+		}
+		ui.printf("default input: %s\n", data)
+	}
+	return nil
+}
+
+func cmdAppsErrors(args []string) error {
+	fs := newFlagSet("apps errors")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	window := fs.String("window", "", "how far back to look, e.g. 24h (server default 24h)")
+	limit := fs.Int("limit", 0, "most error groups to show (server default 10)")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	// The app may come before the flags, as in apps errors my-etl --window 24h.
+	if fs.NArg() == 0 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli apps errors <app> [--window 24h] [--limit N]"}
+	}
+	app := strings.TrimSpace(fs.Arg(0))
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+	if *window != "" {
+		if d, err := time.ParseDuration(*window); err != nil || d <= 0 {
+			return &exitError{Code: 1, Message: fmt.Sprintf("--window: must be a positive duration such as 24h, got %q", *window)}
+		}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	limitValue := ""
+	if *limit > 0 {
+		limitValue = strconv.Itoa(*limit)
+	}
+	var resp appErrorsResponse
+	path, err := withQuery("/api/v1/apps/"+url.PathEscape(app)+"/errors", map[string]string{"window": *window, "limit": limitValue})
+	if err != nil {
+		return err
+	}
+	if err := client.doJSON(context.Background(), http.MethodGet, path, nil, &resp); err != nil {
+		return mapError(err)
+	}
+
+	if jsonOut {
+		return ui.json(resp)
+	}
+	if len(resp.Errors) == 0 {
+		ui.printf("no failures since %s\n", resp.Since)
+		return nil
+	}
+	tw := ui.table()
+	fmt.Fprintln(tw, "COUNT\tFIRST_SEEN\tLAST_SEEN\tRUNS\tERROR")
+	for _, g := range resp.Errors {
+		runIDs := make([]string, len(g.ExampleRunIDs))
+		for i, id := range g.ExampleRunIDs {
+			runIDs[i] = strconv.FormatInt(id, 10)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", g.Count, g.FirstSeenAt, g.LastSeenAt, strings.Join(runIDs, ","), g.Pattern)
+	}
+	_ = tw.Flush()
+	ui.printf("%d failures since %s\n", resp.TotalFailures, resp.Since)
 	return nil
 }
 
@@ -608,7 +890,7 @@ func cmdAppsCreate(args []string) error {
 	profileName := fs.String("profile", "", "profile name")
 	slug := fs.String("slug", "", "app slug")
 	description := fs.String("description", "", "description")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
@@ -622,7 +904,11 @@ func cmdAppsCreate(args []string) error {
 		return &exitError{Code: 1, Message: fmt.Sprintf("unexpected arguments: %s", strings.Join(fs.Args(), " "))}
 	}
 
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
 	if err != nil {
 		return err
 	}
@@ -642,29 +928,13 @@ func cmdAppsCreate(args []string) error {
 		return mapError(err)
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	fmt.Printf("App %q created (id=%d)\n", resp.Slug, resp.AppID)
+	ui.infof("App %q created (id=%d)\n", resp.Slug, resp.AppID)
 	return nil
 }
 
-func cmdVersions(args []string) error {
-	if len(args) == 0 {
-		return &exitError{Code: 1, Message: "usage: minitower-cli versions <list|get|upload> ..."}
-	}
-	switch args[0] {
-	case "list":
-		return cmdVersionsList(args[1:])
-	case "get":
-		return cmdVersionsGet(args[1:])
-	case "upload":
-		return cmdVersionsUpload(args[1:])
-	default:
-		return &exitError{Code: 1, Message: fmt.Sprintf("unknown versions subcommand: %s", args[0])}
-	}
-}
-
 func defaultAppOrFlag(flagValue, fallback string) (string, error) {
 	app := strings.TrimSpace(flagValue)
 	if app == "" {
@@ -676,24 +946,52 @@ func defaultAppOrFlag(flagValue, fallback string) (string, error) {
 	return app, nil
 }
 
+// setRunVersion adds the --version value of a run create command to its
+// payload: a number selects version_no and anything else a version label,
+// which the server resolves when the run is created.
+func setRunVersion(payload map[string]any, version string) error {
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return nil
+	}
+	if val, err := strconv.ParseInt(version, 10, 64); err == nil {
+		if val <= 0 {
+			return &exitError{Code: 1, Message: "--version must be a positive integer or a label"}
+		}
+		payload["version_no"] = val
+		return nil
+	}
+	payload["version_label"] = version
+	return nil
+}
+
 func cmdVersionsList(args []string) error {
 	fs := newFlagSet("versions list")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
 	appFlag := fs.String("app", "", "app slug")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	columns := fs.String("columns", "", "comma-separated columns to print")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
 	if err := ensureNoExtraArgs(fs); err != nil {
 		return err
 	}
+	cols, err := versionColumns.parse(*columns)
+	if err != nil {
+		return err
+	}
 
 	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
 	if err != nil {
 		return err
 	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
 	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
 	if err != nil {
 		return err
@@ -705,10 +1003,10 @@ func cmdVersionsList(args []string) error {
 		return mapError(err)
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	printVersionTable(resp.Versions)
+	printVersionTable(resp.Versions, cols)
 	return nil
 }
 
@@ -718,7 +1016,7 @@ func cmdVersionsGet(args []string) error {
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
 	appFlag := fs.String("app", "", "app slug")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
@@ -735,46 +1033,53 @@ func cmdVersionsGet(args []string) error {
 	if err != nil {
 		return err
 	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
 	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
 	if err != nil {
 		return err
 	}
 
-	var resp listVersionsResponse
-	path := "/api/v1/apps/" + url.PathEscape(app) + "/versions"
-	if err := client.doJSON(context.Background(), http.MethodGet, path, nil, &resp); err != nil {
+	var v versionResponse
+	path := fmt.Sprintf("/api/v1/apps/%s/versions/%d", url.PathEscape(app), versionNo)
+	if err := client.doJSON(context.Background(), http.MethodGet, path, nil, &v); err != nil {
 		return mapError(err)
 	}
 
-	for _, v := range resp.Versions {
-		if v.VersionNo == versionNo {
-			if *jsonOut {
-				return printJSON(v)
-			}
-			printVersionTable([]versionResponse{v})
-			return nil
-		}
+	if jsonOut {
+		return ui.json(v)
 	}
-
-	return &exitError{Code: 11, Message: fmt.Sprintf("version %d not found", versionNo)}
+	printVersionTable([]versionResponse{v}, nil)
+	printVersionCommands(v.Commands)
+	return nil
 }
 
-func cmdVersionsUpload(args []string) error {
-	fs := newFlagSet("versions upload")
+// cmdVersionsSchema prints the params schema of the app's latest version,
+// or of the version given by number or label, as the server stored it.
+func cmdVersionsSchema(args []string) error {
+	fs := newFlagSet("versions schema")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
 	appFlag := fs.String("app", "", "app slug")
-	filePath := fs.String("file", "", "artifact path (.tar.gz)")
-	jsonOut := fs.Bool("json", false, "print JSON")
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if err := ensureNoExtraArgs(fs); err != nil {
-		return err
+	if fs.NArg() > 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli versions schema [version-no|label] --app <app>"}
 	}
-	if strings.TrimSpace(*filePath) == "" {
-		return &exitError{Code: 1, Message: "--file is required"}
+
+	query := map[string]string{}
+	if fs.NArg() == 1 {
+		version := map[string]any{}
+		if err := setRunVersion(version, fs.Arg(0)); err != nil {
+			return &exitError{Code: 1, Message: "version must be a positive integer or a label"}
+		}
+		for k, v := range version {
+			query[k] = fmt.Sprint(v)
+		}
 	}
 
 	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
@@ -786,701 +1091,2890 @@ func cmdVersionsUpload(args []string) error {
 		return err
 	}
 
-	artifactData, err := os.ReadFile(*filePath)
+	path, err := withQuery("/api/v1/apps/"+url.PathEscape(app)+"/schema", query)
 	if err != nil {
-		return &exitError{Code: 1, Message: fmt.Sprintf("read artifact: %v", err)}
+		return err
 	}
-
-	var resp versionResponse
-	uploadPath := "/api/v1/apps/" + url.PathEscape(app) + "/versions"
-	err = client.doMultipartFile(context.Background(), uploadPath, "artifact", filepath.Base(*filePath), artifactData, &resp)
+	schema, err := client.getRaw(context.Background(), path)
 	if err != nil {
 		return mapError(err)
 	}
-
-	if *jsonOut {
-		return printJSON(resp)
+	// Indenting keeps the stored key order, unlike decoding into a map.
+	var out bytes.Buffer
+	if err := json.Indent(&out, schema, "", "  "); err != nil {
+		return fmt.Errorf("decode schema: %w", err)
 	}
-	fmt.Printf("Uploaded version %d for app %q (sha256:%s)\n", resp.VersionNo, app, shortenSHA(resp.ArtifactSHA256))
-	return nil
+	out.WriteByte('\n')
+	return ui.write(out.Bytes())
 }
 
-func cmdDeploy(args []string) error {
-	fs := newFlagSet("deploy")
+func cmdVersionsFiles(args []string) error {
+	fs := newFlagSet("versions files")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	dir := fs.String("dir", ".", "project directory")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	appFlag := fs.String("app", "", "app slug")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if err := ensureNoExtraArgs(fs); err != nil {
-		return err
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli versions files <version-no> --app <app>"}
 	}
 
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	versionNo, err := strconv.ParseInt(strings.TrimSpace(fs.Arg(0)), 10, 64)
+	if err != nil || versionNo <= 0 {
+		return &exitError{Code: 1, Message: "version number must be a positive integer"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
 	if err != nil {
 		return err
 	}
-
-	result, err := deployFromDir(context.Background(), client, *dir)
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
 	if err != nil {
+		return err
+	}
+
+	var resp versionFilesResponse
+	path := fmt.Sprintf("/api/v1/apps/%s/versions/%d/files", url.PathEscape(app), versionNo)
+	if err := client.doJSON(context.Background(), http.MethodGet, path, nil, &resp); err != nil {
 		return mapError(err)
 	}
 
-	if *jsonOut {
-		return printJSON(result)
+	if jsonOut {
+		return ui.json(resp)
+	}
+	tw := ui.table()
+	fmt.Fprintln(tw, "MODE\tSIZE\tPATH")
+	for _, f := range resp.Files {
+		name := f.Path
+		if f.IsDir {
+			name += "/"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", f.Mode, f.Size, name)
+	}
+	_ = tw.Flush()
+	if resp.Truncated {
+		ui.warnf("listing truncated after %d entries\n", len(resp.Files))
 	}
-	fmt.Printf("Deploying app %q from %s\n", result.AppSlug, *dir)
-	fmt.Printf("Artifact packaged (%d bytes, sha256:%s)\n", result.ArtifactBytes, shortenSHA(result.PackagedSHA))
-	fmt.Printf("Version %d created (sha256:%s)\n", result.Version.VersionNo, shortenSHA(result.Version.ArtifactSHA256))
 	return nil
 }
 
-type deployResult struct {
-	AppSlug       string          `json:"app_slug"`
-	ArtifactBytes int             `json:"artifact_bytes"`
-	PackagedSHA   string          `json:"packaged_sha256"`
-	Version       versionResponse `json:"version"`
-}
-
-func deployFromDir(ctx context.Context, client *apiClient, dir string) (*deployResult, error) {
-	tfPath := filepath.Join(dir, "Towerfile")
-	f, err := os.Open(tfPath)
-	if err != nil {
-		return nil, &exitError{Code: 1, Message: fmt.Sprintf("cannot open Towerfile: %v", err)}
-	}
-	defer f.Close()
-
-	tf, err := towerfile.Parse(f)
-	if err != nil {
-		return nil, &exitError{Code: 1, Message: fmt.Sprintf("parsing Towerfile: %v", err)}
+func cmdVersionsCat(args []string) error {
+	fs := newFlagSet("versions cat")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	appFlag := fs.String("app", "", "app slug")
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if err := towerfile.Validate(tf); err != nil {
-		return nil, &exitError{Code: 1, Message: fmt.Sprintf("validating Towerfile: %v", err)}
+	if fs.NArg() != 2 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli versions cat <version-no> <path> --app <app>"}
 	}
 
-	artifact, sha256, err := towerfile.Package(dir, tf)
-	if err != nil {
-		return nil, &exitError{Code: 1, Message: fmt.Sprintf("packaging artifact: %v", err)}
+	versionNo, err := strconv.ParseInt(strings.TrimSpace(fs.Arg(0)), 10, 64)
+	if err != nil || versionNo <= 0 {
+		return &exitError{Code: 1, Message: "version number must be a positive integer"}
 	}
 
-	artifactData, err := io.ReadAll(artifact)
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
 	if err != nil {
-		return nil, &exitError{Code: 1, Message: fmt.Sprintf("reading artifact: %v", err)}
-	}
-
-	if err := ensureApp(ctx, client, tf.App.Name); err != nil {
-		return nil, err
+		return err
 	}
-
-	var version versionResponse
-	uploadPath := "/api/v1/apps/" + url.PathEscape(tf.App.Name) + "/versions"
-	err = client.doMultipartFile(ctx, uploadPath, "artifact", "artifact.tar.gz", artifactData, &version)
+	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
 	if err != nil {
-		return nil, err
-	}
-
-	return &deployResult{
-		AppSlug:       tf.App.Name,
-		ArtifactBytes: len(artifactData),
-		PackagedSHA:   sha256,
-		Version:       version,
-	}, nil
-}
-
-func ensureApp(ctx context.Context, client *apiClient, slug string) error {
-	var existing appResponse
-	getPath := "/api/v1/apps/" + url.PathEscape(slug)
-	err := client.doJSON(ctx, http.MethodGet, getPath, nil, &existing)
-	if err == nil {
-		return nil
-	}
-
-	var ae *apiError
-	if !errors.As(err, &ae) || ae.Status != http.StatusNotFound {
 		return err
 	}
 
-	var created appResponse
-	if err := client.doJSON(ctx, http.MethodPost, "/api/v1/apps", map[string]string{"slug": slug}, &created); err != nil {
+	path, err := withQuery(fmt.Sprintf("/api/v1/apps/%s/versions/%d/files/content", url.PathEscape(app), versionNo), map[string]string{"path": fs.Arg(1)})
+	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func cmdRuns(args []string) error {
-	if len(args) == 0 {
-		return &exitError{Code: 1, Message: "usage: minitower-cli runs <create|list|get|cancel|retry|watch|logs> ..."}
-	}
-	var err error
-	switch args[0] {
-	case "create":
-		err = cmdRunsCreate(args[1:])
-	case "list":
-		err = cmdRunsList(args[1:])
-	case "get":
-		err = cmdRunsGet(args[1:])
-	case "cancel":
-		err = cmdRunsCancel(args[1:])
-	case "retry":
-		err = cmdRunsRetry(args[1:])
-	case "watch":
-		err = cmdRunsWatch(args[1:])
-	case "logs":
-		err = cmdRunsLogs(args[1:])
-	default:
-		err = &exitError{Code: 1, Message: fmt.Sprintf("unknown runs subcommand: %s", args[0])}
+	content, err := client.getRaw(context.Background(), path)
+	if err != nil {
+		return mapError(err)
 	}
-	return err
+	return ui.write(content)
 }
 
-func cmdRunsCreate(args []string) error {
-	fs := newFlagSet("runs create")
+func cmdVersionsLabel(args []string) error {
+	fs := newFlagSet("versions label")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
 	appFlag := fs.String("app", "", "app slug")
-	inputJSON := fs.String("input", "", "input JSON object")
-	version := fs.String("version", "", "version number")
-	priority := fs.String("priority", "", "priority")
-	maxRetries := fs.String("max-retries", "", "max retries")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if err := ensureNoExtraArgs(fs); err != nil {
-		return err
+	if fs.NArg() != 2 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli versions label <version-no> <label> --app <app>"}
+	}
+
+	versionNo, err := strconv.ParseInt(strings.TrimSpace(fs.Arg(0)), 10, 64)
+	if err != nil || versionNo <= 0 {
+		return &exitError{Code: 1, Message: "version number must be a positive integer"}
 	}
+	label := strings.TrimSpace(fs.Arg(1))
 
 	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
 	if err != nil {
 		return err
 	}
-	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
+	jsonOut, err := formats.resolve(conn.Output)
 	if err != nil {
 		return err
 	}
-
-	payload := map[string]any{}
-	if strings.TrimSpace(*inputJSON) != "" {
-		var input map[string]any
-		if err := json.Unmarshal([]byte(*inputJSON), &input); err != nil {
-			return &exitError{Code: 1, Message: fmt.Sprintf("invalid --input JSON: %v", err)}
-		}
-		payload["input"] = input
-	}
-	if strings.TrimSpace(*version) != "" {
-		val, err := strconv.ParseInt(strings.TrimSpace(*version), 10, 64)
-		if err != nil || val <= 0 {
-			return &exitError{Code: 1, Message: "--version must be a positive integer"}
-		}
-		payload["version_no"] = val
-	}
-	if strings.TrimSpace(*priority) != "" {
-		val, err := strconv.Atoi(strings.TrimSpace(*priority))
-		if err != nil {
-			return &exitError{Code: 1, Message: "--priority must be an integer"}
-		}
-		payload["priority"] = val
-	}
-	if strings.TrimSpace(*maxRetries) != "" {
-		val, err := strconv.Atoi(strings.TrimSpace(*maxRetries))
-		if err != nil || val < 0 {
-			return &exitError{Code: 1, Message: "--max-retries must be a non-negative integer"}
-		}
-		payload["max_retries"] = val
+	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
+	if err != nil {
+		return err
 	}
 
-	createPath := "/api/v1/apps/" + url.PathEscape(app) + "/runs"
-	var resp runResponse
-	err = client.doJSON(context.Background(), http.MethodPost, createPath, payload, &resp)
-	if err != nil {
+	var resp versionResponse
+	path := fmt.Sprintf("/api/v1/apps/%s/versions/%d/labels", url.PathEscape(app), versionNo)
+	if err := client.doJSON(context.Background(), http.MethodPost, path, map[string]string{"label": label}, &resp); err != nil {
 		return mapError(err)
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	fmt.Printf("Run #%d created (id=%d, status=%s)\n", resp.RunNo, resp.RunID, resp.Status)
+	ui.infof("Label %q now points at version %d of %s\n", label, resp.VersionNo, app)
 	return nil
 }
 
-func cmdRunsList(args []string) error {
-	fs := newFlagSet("runs list")
+func cmdVersionsPromote(args []string) error {
+	fs := newFlagSet("versions promote")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	app := fs.String("app", "", "app slug")
-	status := fs.String("status", "", "status filter")
-	limit := fs.Int("limit", 50, "max rows")
-	offset := fs.Int("offset", 0, "offset")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	appFlag := fs.String("app", "", "source app slug")
+	to := fs.String("to", "", "target app slug")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if err := ensureNoExtraArgs(fs); err != nil {
-		return err
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli versions promote <version-no> --app <app> --to <app>"}
 	}
-	if *limit <= 0 || *limit > 100 {
-		return &exitError{Code: 1, Message: "--limit must be between 1 and 100"}
+
+	versionNo, err := strconv.ParseInt(strings.TrimSpace(fs.Arg(0)), 10, 64)
+	if err != nil || versionNo <= 0 {
+		return &exitError{Code: 1, Message: "version number must be a positive integer"}
 	}
-	if *offset < 0 {
-		return &exitError{Code: 1, Message: "--offset must be >= 0"}
+	target := strings.TrimSpace(*to)
+	if target == "" {
+		return &exitError{Code: 1, Message: "--to is required"}
 	}
 
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
 	if err != nil {
 		return err
 	}
-
-	qPath, err := withQuery("/api/v1/runs", map[string]string{
-		"app":    strings.TrimSpace(*app),
-		"status": strings.TrimSpace(*status),
-		"limit":  strconv.Itoa(*limit),
-		"offset": strconv.Itoa(*offset),
-	})
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
 	if err != nil {
 		return err
 	}
 
-	var resp listRunsResponse
-	if err := client.doJSON(context.Background(), http.MethodGet, qPath, nil, &resp); err != nil {
+	var resp versionResponse
+	path := fmt.Sprintf("/api/v1/apps/%s/versions/%d/promote", url.PathEscape(app), versionNo)
+	if err := client.doJSON(context.Background(), http.MethodPost, path, map[string]string{"target_app": target}, &resp); err != nil {
 		return mapError(err)
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	printRunTable(resp.Runs)
+	ui.infof("Promoted version %d of %s to version %d of %s\n", versionNo, app, resp.VersionNo, target)
 	return nil
 }
 
-func parseRunIDArg(arg string) (int64, error) {
-	runID, err := strconv.ParseInt(strings.TrimSpace(arg), 10, 64)
-	if err != nil || runID <= 0 {
-		return 0, &exitError{Code: 1, Message: "run ID must be a positive integer"}
-	}
-	return runID, nil
-}
-
-func cmdRunsGet(args []string) error {
-	fs := newFlagSet("runs get")
+func cmdVersionsStats(args []string) error {
+	fs := newFlagSet("versions stats")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	appFlag := fs.String("app", "", "app slug")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
 	if fs.NArg() != 1 {
-		return &exitError{Code: 1, Message: "usage: minitower-cli runs get <run-id>"}
+		return &exitError{Code: 1, Message: "usage: minitower-cli versions stats <version-no> --app <app>"}
 	}
-	runID, err := parseRunIDArg(fs.Arg(0))
+
+	versionNo, err := strconv.ParseInt(strings.TrimSpace(fs.Arg(0)), 10, 64)
+	if err != nil || versionNo <= 0 {
+		return &exitError{Code: 1, Message: "version number must be a positive integer"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
 	if err != nil {
 		return err
 	}
-
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	jsonOut, err := formats.resolve(conn.Output)
 	if err != nil {
 		return err
 	}
-	var resp runResponse
-	if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", runID), nil, &resp); err != nil {
+	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
+	if err != nil {
+		return err
+	}
+
+	var resp versionStatsResponse
+	path := fmt.Sprintf("/api/v1/apps/%s/versions/%d/stats", url.PathEscape(app), versionNo)
+	if err := client.doJSON(context.Background(), http.MethodGet, path, nil, &resp); err != nil {
 		return mapError(err)
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	printRunTable([]runResponse{resp})
+	printVersionStats(resp)
 	return nil
 }
 
-func cmdRunsCancel(args []string) error {
-	fs := newFlagSet("runs cancel")
+func printVersionStats(st versionStatsResponse) {
+	statuses := make([]string, 0, len(st.RunsByStatus))
+	for status := range st.RunsByStatus {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	parts := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		parts = append(parts, fmt.Sprintf("%s=%d", status, st.RunsByStatus[status]))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "none")
+	}
+	seconds := func(s *float64) string {
+		if s == nil {
+			return "-"
+		}
+		return strconv.FormatFloat(*s, 'f', 3, 64) + "s"
+	}
+	firstRun, lastRun := "-", "-"
+	if st.FirstRunAt != nil {
+		firstRun = *st.FirstRunAt
+	}
+	if st.LastRunAt != nil {
+		lastRun = *st.LastRunAt
+	}
+
+	ui.printf("Version:   %d\n", st.VersionNo)
+	ui.printf("Runs:      %d (%s)\n", st.TotalRuns, strings.Join(parts, " "))
+	ui.printf("Duration:  avg %s, p50 %s, p95 %s\n", seconds(st.DurationAvgSeconds), seconds(st.DurationP50Seconds), seconds(st.DurationP95Seconds))
+	ui.printf("First run: %s\n", firstRun)
+	ui.printf("Last run:  %s\n", lastRun)
+}
+
+func cmdVersionsUpload(args []string) error {
+	fs := newFlagSet("versions upload")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	appFlag := fs.String("app", "", "app slug")
+	filePath := fs.String("file", "", "artifact path (.tar.gz)")
+	expectedSHA := fs.String("expected-sha256", "", "reject the upload unless the server receives an artifact with this sha256")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if fs.NArg() != 1 {
-		return &exitError{Code: 1, Message: "usage: minitower-cli runs cancel <run-id>"}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
 	}
-	runID, err := parseRunIDArg(fs.Arg(0))
+	if strings.TrimSpace(*filePath) == "" {
+		return &exitError{Code: 1, Message: "--file is required"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
 	if err != nil {
 		return err
 	}
 
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	artifactData, err := os.ReadFile(*filePath)
 	if err != nil {
+		return &exitError{Code: 1, Message: fmt.Sprintf("read artifact: %v", err)}
+	}
+	if err := checkArtifactSize(len(artifactData), artifactLimit(client, conn), "rebuild it without the large files"); err != nil {
 		return err
 	}
 
-	var resp runResponse
-	if err := client.doJSON(context.Background(), http.MethodPost, fmt.Sprintf("/api/v1/runs/%d/cancel", runID), nil, &resp); err != nil {
+	var resp versionResponse
+	uploadPath := "/api/v1/apps/" + url.PathEscape(app) + "/versions"
+	var fields map[string]string
+	if sum := strings.TrimSpace(*expectedSHA); sum != "" {
+		fields = map[string]string{"expected_sha256": sum}
+	}
+	err = client.doMultipartFile(context.Background(), uploadPath, fields, "artifact", filepath.Base(*filePath), artifactData, &resp)
+	if err != nil {
 		return mapError(err)
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	printCompatibilityWarnings(resp.CompatibilityWarnings)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	fmt.Printf("Run %d status: %s\n", resp.RunID, resp.Status)
+	ui.infof("Uploaded version %d for app %q (sha256:%s)\n", resp.VersionNo, app, shortenSHA(resp.ArtifactSHA256))
 	return nil
 }
 
-func cmdRunsRetry(args []string) error {
-	fs := newFlagSet("runs retry")
+// limitsCacheAge is how long a profile's cached server limits are reused.
+const limitsCacheAge = time.Hour
+
+// artifactLimit returns the server's maximum artifact size, or 0 when it
+// cannot be fetched, as from servers without GET /api/v1/meta/limits. The
+// upload then goes ahead and the server enforces its limit.
+func artifactLimit(client *apiClient, conn *resolvedConnection) int64 {
+	var limits limitsResponse
+	if err := getJSONFresh(context.Background(), client, conn, "/api/v1/meta/limits", limitsCacheAge, &limits); err != nil {
+		return 0
+	}
+	return limits.MaxArtifactBytes
+}
+
+// checkArtifactSize refuses an upload of size bytes over limit before any of
+// it is sent. hint says how to make the artifact smaller. A zero limit is
+// unknown and passes.
+func checkArtifactSize(size int, limit int64, hint string) error {
+	if limit <= 0 || int64(size) <= limit {
+		return nil
+	}
+	return &exitError{Code: 1, Message: fmt.Sprintf("artifact is %s (%d bytes), over the server's limit of %s (%d bytes); %s",
+		formatBytes(int64(size)), size, formatBytes(limit), limit, hint)}
+}
+
+// printCompatibilityWarnings prints an upload's compatibility warnings to
+// stderr, even under --quiet, since callers of the app may break.
+func printCompatibilityWarnings(warnings []compatibilityWarning) {
+	if len(warnings) == 0 {
+		return
+	}
+	ui.warnf("WARNING: this version may break input that worked with the previous version:\n")
+	for _, w := range warnings {
+		ui.warnf("  - %s\n", w.Message)
+	}
+}
+
+func cmdDeploy(args []string) error {
+	fs := newFlagSet("deploy")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	dir := fs.String("dir", ".", "project directory")
+	skipUnchanged := fs.Bool("skip-unchanged", true, "skip the upload when the artifact matches the latest version")
+	force := fs.Bool("force", false, "upload a new version even if nothing changed")
+	plan := fs.Bool("plan", false, "print what the deploy would do without changing anything")
+	failOnIncompatible := fs.Bool("fail-on-incompatible", false, "exit non-zero when the new version has compatibility warnings")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if fs.NArg() != 1 {
-		return &exitError{Code: 1, Message: "usage: minitower-cli runs retry <run-id>"}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
 	}
-	runID, err := parseRunIDArg(fs.Arg(0))
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
 	if err != nil {
 		return err
 	}
-
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	jsonOut, err := formats.resolve(conn.Output)
 	if err != nil {
 		return err
 	}
 
-	var current runResponse
-	if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", runID), nil, &current); err != nil {
+	opts := deployOptions{skipUnchanged: *skipUnchanged && !*force, plan: *plan, maxArtifactBytes: artifactLimit(client, conn)}
+	result, err := deployFromDir(context.Background(), client, *dir, opts)
+	if err != nil {
 		return mapError(err)
 	}
-	if strings.TrimSpace(current.AppSlug) == "" {
-		return &exitError{Code: 1, Message: "run response missing app_slug; cannot retry"}
+	var incompatible error
+	if !result.Planned && !result.Unchanged {
+		warnings := result.Version.CompatibilityWarnings
+		printCompatibilityWarnings(warnings)
+		if *failOnIncompatible && len(warnings) > 0 {
+			incompatible = &exitError{Code: 1, Message: fmt.Sprintf("version %d was uploaded with %d compatibility warnings", result.Version.VersionNo, len(warnings))}
+		}
 	}
 
-	payload := map[string]any{
-		"input":       current.Input,
-		"version_no":  current.VersionNo,
-		"priority":    current.Priority,
-		"max_retries": current.MaxRetries,
+	if jsonOut {
+		if err := ui.json(result); err != nil {
+			return err
+		}
+		return incompatible
 	}
-	createPath := "/api/v1/apps/" + url.PathEscape(current.AppSlug) + "/runs"
-	var resp runResponse
-	if err := client.doJSON(context.Background(), http.MethodPost, createPath, payload, &resp); err != nil {
-		return mapError(err)
+	if result.Planned {
+		printDeployPlan(result, *dir)
+		return nil
 	}
-
-	if *jsonOut {
-		return printJSON(resp)
+	ui.infof("Deploying app %q from %s\n", result.AppSlug, *dir)
+	ui.infof("Artifact packaged (%d files, %d bytes, sha256:%s)\n", result.FileCount, result.ArtifactBytes, shortenSHA(result.PackagedSHA))
+	if result.DescriptionUpdated {
+		ui.infof("Updated app description\n")
 	}
-	fmt.Printf("Retry created: run #%d (id=%d)\n", resp.RunNo, resp.RunID)
-	return nil
+	if result.Unchanged {
+		ui.infof("No changes since version %d (sha256:%s)\n", result.Version.VersionNo, shortenSHA(result.Version.ArtifactSHA256))
+		return nil
+	}
+	ui.infof("Version %d created (sha256:%s)\n", result.Version.VersionNo, shortenSHA(result.Version.ArtifactSHA256))
+	if params := result.Version.Parameters; len(params) > 0 {
+		names := make([]string, len(params))
+		for i, p := range params {
+			names[i] = fmt.Sprintf("%s (%s)", p.Name, p.Type)
+		}
+		ui.infof("Parameters: %s\n", strings.Join(names, ", "))
+	}
+	return incompatible
 }
 
-func resolveWatchRunID(client *apiClient, runIDArg, appFlag string, defaultApp string) (int64, error) {
-	if strings.TrimSpace(runIDArg) != "" {
-		return parseRunIDArg(runIDArg)
+// deployOptions controls what deployFromDir does after packaging.
+type deployOptions struct {
+	// skipUnchanged skips the upload when the artifact's sha256 matches the
+	// app's latest version.
+	skipUnchanged bool
+	// plan stops before any write and reports what would happen.
+	plan bool
+	// maxArtifactBytes is the server's artifact limit, or 0 when unknown.
+	maxArtifactBytes int64
+}
+
+type deployResult struct {
+	AppSlug string `json:"app_slug"`
+	// AppCreated is set when the deploy created the app, or with --plan
+	// when it would.
+	AppCreated bool `json:"app_created"`
+	// DescriptionUpdated is set when the deploy changed the app's
+	// description to the Towerfile's, or with --plan when it would.
+	DescriptionUpdated bool   `json:"description_updated"`
+	FileCount          int    `json:"file_count"`
+	ArtifactBytes      int    `json:"artifact_bytes"`
+	PackagedSHA        string `json:"packaged_sha256"`
+	// Unchanged is set when the artifact matches the latest version, which
+	// is then reported as Version and no upload happens.
+	Unchanged bool `json:"unchanged"`
+	// Planned is set for --plan: nothing was written, and Version is the
+	// latest version if there is one.
+	Planned  bool             `json:"planned,omitempty"`
+	Version  *versionResponse `json:"version,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+func deployFromDir(ctx context.Context, client *apiClient, dir string, opts deployOptions) (*deployResult, error) {
+	tfPath := filepath.Join(dir, "Towerfile")
+	f, err := os.Open(tfPath)
+	if err != nil {
+		return nil, &exitError{Code: 1, Message: fmt.Sprintf("cannot open Towerfile: %v", err)}
 	}
+	defer f.Close()
 
-	app, err := defaultAppOrFlag(appFlag, defaultApp)
+	tf, err := towerfile.Parse(f)
+	if err != nil {
+		return nil, &exitError{Code: 1, Message: fmt.Sprintf("parsing Towerfile: %v", err)}
+	}
+	warnings, err := towerfile.Validate(tf)
+	for _, w := range warnings {
+		ui.warnf("warning: %s\n", w)
+	}
+	if err != nil {
+		return nil, &exitError{Code: 1, Message: fmt.Sprintf("validating Towerfile: %v", err)}
+	}
+
+	artifact, sha256, err := towerfile.Package(dir, tf)
+	if err != nil {
+		return nil, &exitError{Code: 1, Message: fmt.Sprintf("packaging artifact: %v", err)}
+	}
+
+	artifactData, err := io.ReadAll(artifact)
+	if err != nil {
+		return nil, &exitError{Code: 1, Message: fmt.Sprintf("reading artifact: %v", err)}
+	}
+	fileCount, err := countArtifactFiles(artifactData)
+	if err != nil {
+		return nil, &exitError{Code: 1, Message: fmt.Sprintf("reading artifact: %v", err)}
+	}
+	if err := checkArtifactSize(len(artifactData), opts.maxArtifactBytes, "narrow the Towerfile's source patterns to leave out large files"); err != nil {
+		return nil, err
+	}
+
+	result := &deployResult{
+		AppSlug:       tf.App.Name,
+		FileCount:     fileCount,
+		ArtifactBytes: len(artifactData),
+		PackagedSHA:   sha256,
+		Planned:       opts.plan,
+		Warnings:      warnings,
+	}
+
+	app, err := getApp(ctx, client, tf.App.Name)
+	if err != nil {
+		return nil, err
+	}
+	exists := app != nil
+	result.AppCreated = !exists
+	// A Towerfile without a description leaves the app's alone, so one set
+	// through the API survives deploys. A new app is created with it.
+	description := tf.App.Description
+	result.DescriptionUpdated = exists && description != "" && (app.Description == nil || *app.Description != description)
+
+	// A new app has no versions, so there is nothing to compare against.
+	if exists && (opts.plan || opts.skipUnchanged) {
+		latest, err := client.latestVersion(ctx, tf.App.Name)
+		if err != nil {
+			return nil, err
+		}
+		if latest != nil {
+			result.Version = latest
+			result.Unchanged = opts.skipUnchanged && latest.ArtifactSHA256 == sha256
+		}
+	}
+	if opts.plan {
+		return result, nil
+	}
+
+	if !exists {
+		if err := createApp(ctx, client, tf.App.Name, description); err != nil {
+			return nil, err
+		}
+	}
+	if result.DescriptionUpdated {
+		var updated appResponse
+		patchPath := "/api/v1/apps/" + url.PathEscape(tf.App.Name)
+		if err := client.doJSON(ctx, http.MethodPatch, patchPath, map[string]string{"description": description}, &updated); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Unchanged {
+		return result, nil
+	}
+
+	var version versionResponse
+	uploadPath := "/api/v1/apps/" + url.PathEscape(tf.App.Name) + "/versions"
+	// The server rejects the upload if the bytes it receives hash to
+	// anything else.
+	err = client.doMultipartFile(ctx, uploadPath, map[string]string{"expected_sha256": sha256}, "artifact", "artifact.tar.gz", artifactData, &version)
+	if err != nil {
+		return nil, err
+	}
+	result.Version = &version
+	return result, nil
+}
+
+// printDeployPlan prints what a deploy would do, from a --plan result.
+func printDeployPlan(result *deployResult, dir string) {
+	ui.printf("Plan for app %q from %s:\n", result.AppSlug, dir)
+	if result.AppCreated {
+		ui.printf("  app:      create\n")
+	} else {
+		ui.printf("  app:      exists\n")
+	}
+	if result.DescriptionUpdated {
+		ui.printf("  app:      update description\n")
+	}
+	switch {
+	case result.Unchanged:
+		ui.printf("  version:  unchanged since version %d (sha256:%s)\n", result.Version.VersionNo, shortenSHA(result.Version.ArtifactSHA256))
+	case result.Version != nil:
+		ui.printf("  version:  new version after %d\n", result.Version.VersionNo)
+	default:
+		ui.printf("  version:  first version\n")
+	}
+	ui.printf("  artifact: %d files, %d bytes (sha256:%s)\n", result.FileCount, result.ArtifactBytes, shortenSHA(result.PackagedSHA))
+}
+
+// countArtifactFiles counts the entries of a packaged tar.gz artifact.
+func countArtifactFiles(data []byte) (int, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return 0, err
 	}
-	listPath, err := withQuery("/api/v1/apps/"+url.PathEscape(app)+"/runs", map[string]string{
-		"limit": "1",
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	n := 0
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return 0, err
+		}
+		n++
+	}
+}
+
+// getApp returns the team's app with the slug, or nil if there is none.
+func getApp(ctx context.Context, client *apiClient, slug string) (*appResponse, error) {
+	var app appResponse
+	getPath := "/api/v1/apps/" + url.PathEscape(slug)
+	err := client.doJSON(ctx, http.MethodGet, getPath, nil, &app)
+	if err == nil {
+		return &app, nil
+	}
+
+	var ae *apiError
+	if !errors.As(err, &ae) || ae.Status != http.StatusNotFound {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func createApp(ctx context.Context, client *apiClient, slug, description string) error {
+	payload := map[string]string{"slug": slug}
+	if description != "" {
+		payload["description"] = description
+	}
+	var created appResponse
+	return client.doJSON(ctx, http.MethodPost, "/api/v1/apps", payload, &created)
+}
+
+func cmdRunsCreate(args []string) error {
+	fs := newFlagSet("runs create")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	appFlag := fs.String("app", "", "app slug")
+	inputJSON := fs.String("input", "", "input JSON object")
+	var sets stringsFlag
+	fs.Var(&sets, "set", "set an input key with key=value; dots reach nested keys and values that read as JSON, such as [1,2] or {\"a\":1}, keep that type (repeatable)")
+	version := fs.String("version", "", "version number or label")
+	priority := fs.String("priority", "", "priority")
+	maxRetries := fs.String("max-retries", "", "max retries")
+	atMostOnce := fs.Bool("at-most-once", false, "mark the run dead instead of retrying it once its process has started")
+	dryRun := fs.Bool("dry-run", false, "validate the input without enqueueing a run")
+	noCoerce := fs.Bool("no-coerce", false, "send string inputs as strings instead of letting the server convert them to the schema's types")
+	verbose := fs.Bool("verbose", false, "report input fields the server converted")
+	command := fs.String("command", "", "named Towerfile command to run instead of the app script")
+	withStatusToken := fs.Bool("with-status-token", false, "print a URL that reads only this run's status without an API token")
+	wait := fs.Bool("wait", false, "wait for the run to finish and exit like runs watch --status-only")
+	interval := fs.Duration("interval", 2*time.Second, "poll interval for --wait")
+	notify := addNotifyFlags(fs)
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+	if *wait && *dryRun {
+		return &exitError{Code: 1, Message: "--wait cannot be used with --dry-run"}
+	}
+	if notify.set() && !*wait {
+		return &exitError{Code: 1, Message: "--notify-command and --bell need --wait"}
+	}
+	if *interval <= 0 {
+		return &exitError{Code: 1, Message: "--interval must be > 0"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]any{}
+	var input map[string]any
+	if strings.TrimSpace(*inputJSON) != "" {
+		if err := json.Unmarshal([]byte(*inputJSON), &input); err != nil {
+			return &exitError{Code: 1, Message: fmt.Sprintf("invalid --input JSON: %v", err)}
+		}
+	}
+	if len(sets) > 0 {
+		values, err := parseInputOverrides(sets)
+		if err != nil {
+			return err
+		}
+		input = setInputValues(input, values)
+	}
+	if input != nil {
+		payload["input"] = input
+	}
+	if err := setRunVersion(payload, *version); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*priority) != "" {
+		val, err := strconv.Atoi(strings.TrimSpace(*priority))
+		if err != nil {
+			return &exitError{Code: 1, Message: "--priority must be an integer"}
+		}
+		payload["priority"] = val
+	}
+	if strings.TrimSpace(*maxRetries) != "" {
+		val, err := strconv.Atoi(strings.TrimSpace(*maxRetries))
+		if err != nil || val < 0 {
+			return &exitError{Code: 1, Message: "--max-retries must be a non-negative integer"}
+		}
+		payload["max_retries"] = val
+	}
+	if *atMostOnce {
+		payload["at_most_once"] = true
+	}
+	if strings.TrimSpace(*command) != "" {
+		payload["command"] = strings.TrimSpace(*command)
+	}
+	if *withStatusToken && !*dryRun {
+		payload["with_status_token"] = true
+	}
+
+	// Inputs typed on a command line are strings, so the server converts
+	// them to the schema's types unless --no-coerce is given.
+	query := map[string]string{}
+	if !*noCoerce {
+		query["coerce"] = "true"
+	}
+	if *dryRun {
+		query["dry_run"] = "true"
+	}
+	createPath, err := withQuery("/api/v1/apps/"+url.PathEscape(app)+"/runs", query)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		var resp dryRunResponse
+		if err := client.doJSON(context.Background(), http.MethodPost, createPath, payload, &resp); err != nil {
+			return mapError(err)
+		}
+		if jsonOut {
+			return ui.json(resp)
+		}
+		printCoercedFields(*verbose, resp.CoercedFields)
+		ui.infof("Input valid for %s version %d (priority=%d, max_retries=%d); no run created\n", resp.AppSlug, resp.VersionNo, resp.Priority, resp.MaxRetries)
+		return nil
+	}
+
+	var resp runResponse
+	err = client.doJSON(context.Background(), http.MethodPost, createPath, payload, &resp)
+	if err != nil {
+		return mapError(err)
+	}
+
+	if jsonOut && !*wait {
+		return ui.json(resp)
+	}
+	printCoercedFields(*verbose, resp.CoercedFields)
+	ui.infof("Run #%d created (id=%d, status=%s)\n", resp.RunNo, resp.RunID, resp.Status)
+	if resp.StatusToken != "" {
+		// The URL goes to stdout so scripts can capture it.
+		ui.printf("%s/api/v1/run-status/%s\n", client.baseURL, resp.StatusToken)
+	}
+	if *wait {
+		// Under --json the finished run is printed instead of the new one.
+		return watchRun(client, resp.RunID, watchOptions{
+			statusOnly: true,
+			interval:   *interval,
+			json:       jsonOut,
+			notifier:   notify.notifier(),
+		})
+	}
+	return nil
+}
+
+// printCoercedFields reports, under --verbose, the input values the server
+// converted from strings.
+func printCoercedFields(verbose bool, fields []string) {
+	if !verbose || len(fields) == 0 {
+		return
+	}
+	ui.infof("Coerced input fields: %s\n", strings.Join(fields, ", "))
+}
+
+func cmdRunsList(args []string) error {
+	fs := newFlagSet("runs list")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	app := fs.String("app", "", "app slug")
+	status := fs.String("status", "", "status filter")
+	var inputs stringsFlag
+	fs.Var(&inputs, "input", "only runs whose input has key=value (repeatable)")
+	version := fs.String("version", "", "only runs of this version number")
+	runner := fs.String("runner", "", "only runs whose latest attempt ran on this runner")
+	since := fs.String("since", "", "runs queued at or after this time (duration such as 1h, YYYY-MM-DD or RFC 3339)")
+	include := fs.String("include", "", "extra fields: runner, input")
+	limit := fs.Int("limit", 50, "max rows")
+	offset := fs.Int("offset", 0, "offset")
+	porcelain := fs.Bool("porcelain", false, "print stable tab-separated fields")
+	cached := fs.Bool("cached", false, "print the last cached response instead of calling the API")
+	columns := fs.String("columns", "", "comma-separated columns to print")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if *porcelain && *formats.json {
+		return &exitError{Code: 1, Message: "--porcelain cannot be combined with --json"}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+	if *limit <= 0 || *limit > 100 {
+		return &exitError{Code: 1, Message: "--limit must be between 1 and 100"}
+	}
+	if *offset < 0 {
+		return &exitError{Code: 1, Message: "--offset must be >= 0"}
+	}
+	versionNo := strings.TrimSpace(*version)
+	if versionNo != "" {
+		if n, err := strconv.ParseInt(versionNo, 10, 64); err != nil || n <= 0 {
+			return &exitError{Code: 1, Message: "--version must be a positive integer"}
+		}
+	}
+	inputContains, err := parseInputMatches(inputs)
+	if err != nil {
+		return err
+	}
+	sinceValue, err := auditTimeFlag("since", *since, time.Now())
+	if err != nil {
+		return err
+	}
+	// The extras cost the server a join (runner) or decompressing large
+	// inputs (input), so they are only asked for on request.
+	var includes []string
+	includeRunner, includeInput := false, false
+	for _, part := range strings.Split(*include, ",") {
+		switch strings.TrimSpace(part) {
+		case "":
+		case "runner":
+			includeRunner = true
+		case "input":
+			includeInput = true
+		default:
+			return &exitError{Code: 1, Message: fmt.Sprintf("--include: unknown value %q (valid: runner, input)", strings.TrimSpace(part))}
+		}
+	}
+	cols, err := runColumns.parse(*columns)
+	if err != nil {
+		return err
+	}
+	if cols == nil && includeRunner {
+		cols = runColumns.pick(runRunnerDefaults)
+	}
+	for _, c := range cols {
+		// Printing inputs needs the compressed ones too.
+		if c.name == "input" {
+			includeInput = true
+		}
+	}
+	if includeRunner {
+		includes = append(includes, "runner")
+	}
+	if includeInput {
+		includes = append(includes, "input")
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	qPath, err := withQuery("/api/v1/runs", map[string]string{
+		"app":            strings.TrimSpace(*app),
+		"status":         strings.TrimSpace(*status),
+		"version_no":     versionNo,
+		"input_contains": inputContains,
+		"runner":         strings.TrimSpace(*runner),
+		"since":          sinceValue,
+		"include":        strings.Join(includes, ","),
+		"limit":          strconv.Itoa(*limit),
+		"offset":         strconv.Itoa(*offset),
 	})
 	if err != nil {
-		return 0, err
+		return err
+	}
+
+	var resp listRunsResponse
+	if err := getJSONCached(client, conn, qPath, *cached, &resp); err != nil {
+		return err
+	}
+
+	if *porcelain {
+		printRunsPorcelain(resp.Runs, cols)
+		return nil
+	}
+	if jsonOut {
+		return ui.json(resp)
+	}
+	printRunTable(resp.Runs, cols)
+	return nil
+}
+
+// stringsFlag collects every value of a repeatable flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// parseInputMatches turns --input key=value flags into the input_contains
+// JSON object, or "" when there are none. A value that reads as JSON true,
+// false, null, a number or a quoted string keeps that type; anything else is
+// a string, so customer=acme and customer='"acme"' are the same match.
+func parseInputMatches(pairs []string) (string, error) {
+	if len(pairs) == 0 {
+		return "", nil
+	}
+	filter := make(map[string]any, len(pairs))
+	for _, pair := range pairs {
+		key, raw, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return "", &exitError{Code: 1, Message: fmt.Sprintf("--input %q must be key=value", pair)}
+		}
+		if _, dup := filter[key]; dup {
+			return "", &exitError{Code: 1, Message: fmt.Sprintf("--input key %q given twice", key)}
+		}
+		var value any = raw
+		var decoded any
+		if err := json.Unmarshal([]byte(raw), &decoded); err == nil {
+			switch decoded.(type) {
+			case nil, bool, float64, string:
+				value = json.RawMessage(raw)
+			}
+		}
+		filter[key] = value
+	}
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func parseRunIDArg(arg string) (int64, error) {
+	runID, err := strconv.ParseInt(strings.TrimSpace(arg), 10, 64)
+	if err != nil || runID <= 0 {
+		return 0, &exitError{Code: 1, Message: "run ID must be a positive integer"}
+	}
+	return runID, nil
+}
+
+func cmdRunsGet(args []string) error {
+	fs := newFlagSet("runs get")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	porcelain := fs.Bool("porcelain", false, "print stable tab-separated fields")
+	columns := fs.String("columns", "", "comma-separated columns to print")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if *porcelain && *formats.json {
+		return &exitError{Code: 1, Message: "--porcelain cannot be combined with --json"}
+	}
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs get <run-id>"}
+	}
+	runID, err := parseRunIDArg(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cols, err := runColumns.parse(*columns)
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+	var resp runResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", runID), nil, &resp); err != nil {
+		return mapError(err)
+	}
+
+	if *porcelain {
+		printRunsPorcelain([]runResponse{resp}, cols)
+		return nil
+	}
+	if jsonOut {
+		return ui.json(resp)
+	}
+	printRunTable([]runResponse{resp}, cols)
+	return nil
+}
+
+// confirmInput is where confirmation prompts read the answer; tests replace it.
+var confirmInput io.Reader = os.Stdin
+
+// confirm asks a yes/no question on stderr and reports whether the answer
+// was yes.
+func confirm(question string) (bool, error) {
+	fmt.Fprintf(ui.errOut, "%s [y/N] ", question)
+	line, err := bufio.NewReader(confirmInput).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+func cmdRunsCancel(args []string) error {
+	fs := newFlagSet("runs cancel")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	all := fs.Bool("all", false, "cancel every run matching --app, --status and --created-before")
+	app := fs.String("app", "", "with --all, only runs of this app")
+	status := fs.String("status", "", "with --all, queued, running or all-nonterminal (default)")
+	createdBefore := fs.String("created-before", "", "with --all, only runs created before this RFC 3339 time")
+	yes := fs.Bool("yes", false, "with --all, cancel without prompting")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if *all {
+		if fs.NArg() != 0 {
+			return &exitError{Code: 1, Message: "run IDs cannot be combined with --all"}
+		}
+		return runsCancelAll(*profileName, *server, *token, *app, *status, *createdBefore, *yes, formats)
+	}
+	if *app != "" || *status != "" || *createdBefore != "" || *yes {
+		return &exitError{Code: 1, Message: "--app, --status, --created-before and --yes require --all"}
+	}
+	if fs.NArg() == 0 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs cancel <run-id> [run-id...] | runs cancel --all [--app <slug>] [--status <status>] [--yes]"}
+	}
+	runIDs := make([]int64, 0, fs.NArg())
+	for _, arg := range fs.Args() {
+		runID, err := parseRunIDArg(arg)
+		if err != nil {
+			return err
+		}
+		runIDs = append(runIDs, runID)
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	if len(runIDs) == 1 {
+		var resp runResponse
+		if err := client.doJSON(context.Background(), http.MethodPost, fmt.Sprintf("/api/v1/runs/%d/cancel", runIDs[0]), nil, &resp); err != nil {
+			return mapError(err)
+		}
+		if jsonOut {
+			return ui.json(resp)
+		}
+		ui.infof("Run %d status: %s\n", resp.RunID, resp.Status)
+		return nil
+	}
+
+	// Cancel every run even if some fail; exit with the first failure's code.
+	var results []runResponse
+	var firstErr *exitError
+	failed := 0
+	for _, runID := range runIDs {
+		var resp runResponse
+		if err := client.doJSON(context.Background(), http.MethodPost, fmt.Sprintf("/api/v1/runs/%d/cancel", runID), nil, &resp); err != nil {
+			mapped := mapError(err)
+			ui.warnf("error: run %d: %v\n", runID, exitMessage(mapped))
+			if firstErr == nil {
+				firstErr = &exitError{Code: 1}
+				if ee, ok := mapped.(*exitError); ok {
+					firstErr.Code = ee.Code
+				}
+			}
+			failed++
+			continue
+		}
+		results = append(results, resp)
+		if !jsonOut {
+			ui.infof("Run %d status: %s\n", resp.RunID, resp.Status)
+		}
+	}
+
+	if jsonOut {
+		if results == nil {
+			results = []runResponse{}
+		}
+		if err := ui.json(results); err != nil {
+			return err
+		}
+	}
+	if firstErr != nil {
+		firstErr.Message = fmt.Sprintf("failed to cancel %d of %d runs", failed, len(runIDs))
+		return firstErr
+	}
+	return nil
+}
+
+// runsCancelAll cancels every run matching a filter. It asks the server how
+// many runs match, confirms that number with the user unless yes is set, and
+// sends it back as confirm_count so the server refuses if the match changed.
+func runsCancelAll(profileName, server, token, app, status, createdBefore string, yes bool, formats *formatFlags) error {
+	if createdBefore != "" {
+		if _, err := time.Parse(time.RFC3339, createdBefore); err != nil {
+			return &exitError{Code: 1, Message: "--created-before must be an RFC 3339 time"}
+		}
+	}
+
+	client, conn, err := resolveCommandConnection(profileName, server, token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	req := bulkCancelRequest{App: app, Status: status, CreatedBefore: createdBefore, DryRun: true}
+	var dry bulkCancelResponse
+	if err := client.doJSON(context.Background(), http.MethodPost, "/api/v1/runs/cancel", req, &dry); err != nil {
+		return mapError(err)
+	}
+	if dry.Count == 0 {
+		if jsonOut {
+			return ui.json(dry)
+		}
+		ui.infof("No runs match\n")
+		return nil
+	}
+	if !yes {
+		ok, err := confirm(fmt.Sprintf("Cancel %d runs?", dry.Count))
+		if err != nil {
+			return &exitError{Code: 1, Message: fmt.Sprintf("read confirmation: %v", err)}
+		}
+		if !ok {
+			return &exitError{Code: 1, Message: "aborted; no runs were cancelled"}
+		}
+	}
+
+	req.DryRun = false
+	req.ConfirmCount = &dry.Count
+	var resp bulkCancelResponse
+	if err := client.doJSON(context.Background(), http.MethodPost, "/api/v1/runs/cancel", req, &resp); err != nil {
+		return mapError(err)
+	}
+	if jsonOut {
+		return ui.json(resp)
+	}
+	for _, res := range resp.Results {
+		ui.infof("Run %d status: %s\n", res.RunID, res.Status)
+	}
+	ui.infof("Cancelled %d queued runs, asked %d running runs to stop\n", resp.Cancelled, resp.Cancelling)
+	return nil
+}
+
+// exitMessage returns the user-facing text of an error returned by mapError.
+func exitMessage(err error) string {
+	if ee, ok := err.(*exitError); ok {
+		return ee.Message
+	}
+	return err.Error()
+}
+
+func cmdRunsRetry(args []string) error {
+	fs := newFlagSet("runs retry")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs retry <run-id>"}
+	}
+	runID, err := parseRunIDArg(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var current runResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", runID), nil, &current); err != nil {
+		return mapError(err)
+	}
+	if strings.TrimSpace(current.AppSlug) == "" {
+		return &exitError{Code: 1, Message: "run response missing app_slug; cannot retry"}
+	}
+
+	payload := map[string]any{
+		"input":        current.Input,
+		"version_no":   current.VersionNo,
+		"priority":     current.Priority,
+		"max_retries":  current.MaxRetries,
+		"at_most_once": current.AtMostOnce,
+	}
+	if current.Command != "" {
+		payload["command"] = current.Command
+	}
+	createPath := "/api/v1/apps/" + url.PathEscape(current.AppSlug) + "/runs"
+	var resp runResponse
+	if err := client.doJSON(context.Background(), http.MethodPost, createPath, payload, &resp); err != nil {
+		return mapError(err)
+	}
+
+	if jsonOut {
+		return ui.json(resp)
+	}
+	ui.infof("Retry created: run #%d (id=%d)\n", resp.RunNo, resp.RunID)
+	return nil
+}
+
+func cmdRunsRerun(args []string) error {
+	fs := newFlagSet("runs rerun")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	var sets stringsFlag
+	fs.Var(&sets, "set", "override an input key with key=value; dots reach nested keys and null deletes the key (repeatable)")
+	version := fs.String("version", "", "version number or label to rerun on instead of the run's version")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	// The run ID may come before the flags, as in runs rerun 42 --set k=v.
+	if fs.NArg() == 0 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs rerun <run-id> [--set key=value]... [--version N]"}
+	}
+	runIDArg := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+	runID, err := parseRunIDArg(runIDArg)
+	if err != nil {
+		return err
+	}
+	overrides, err := parseInputOverrides(sets)
+	if err != nil {
+		return err
+	}
+	payload := map[string]any{}
+	if len(overrides) > 0 {
+		payload["input_overrides"] = overrides
+	}
+	if err := setRunVersion(payload, *version); err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var resp rerunResponse
+	if err := client.doJSON(context.Background(), http.MethodPost, fmt.Sprintf("/api/v1/runs/%d/rerun", runID), payload, &resp); err != nil {
+		return mapError(err)
+	}
+
+	if jsonOut {
+		return ui.json(resp)
+	}
+	ui.infof("Rerun created: run #%d (id=%d, version %d, rerun of %d)\n", resp.Run.RunNo, resp.Run.RunID, resp.Run.VersionNo, runID)
+	for _, c := range resp.InputChanges {
+		switch c.Kind {
+		case "added":
+			ui.infof("  + %s: %s\n", c.Path, compactJSON(c.After))
+		case "removed":
+			ui.infof("  - %s: %s\n", c.Path, compactJSON(c.Before))
+		default:
+			ui.infof("  ~ %s: %s -> %s\n", c.Path, compactJSON(c.Before), compactJSON(c.After))
+		}
+	}
+	return nil
+}
+
+// parseInputOverrides turns --set key=value flags into the input_overrides
+// object. Dotted keys build nested objects, and values are typed as in
+// parseInputMatches, so fix_mode=true is a boolean and key=null deletes key.
+func parseInputOverrides(pairs []string) (map[string]any, error) {
+	overrides := map[string]any{}
+	for _, pair := range pairs {
+		key, raw, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, &exitError{Code: 1, Message: fmt.Sprintf("--set %q must be key=value", pair)}
+		}
+		var value any = raw
+		var decoded any
+		if err := json.Unmarshal([]byte(raw), &decoded); err == nil {
+			value = decoded
+		}
+		parts := strings.Split(key, ".")
+		obj := overrides
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := obj[part].(map[string]any)
+			if !ok {
+				if _, set := obj[part]; set || part == "" {
+					return nil, &exitError{Code: 1, Message: fmt.Sprintf("--set key %q repeats or conflicts with another --set", key)}
+				}
+				next = map[string]any{}
+				obj[part] = next
+			}
+			obj = next
+		}
+		last := parts[len(parts)-1]
+		if _, dup := obj[last]; dup || last == "" {
+			return nil, &exitError{Code: 1, Message: fmt.Sprintf("--set key %q repeats or conflicts with another --set", key)}
+		}
+		obj[last] = value
+	}
+	return overrides, nil
+}
+
+// setInputValues applies --set values over input: objects on both sides
+// merge key by key and any other value replaces the input's. A null is kept
+// so the server removes the key from the app's default input.
+func setInputValues(input, values map[string]any) map[string]any {
+	if input == nil {
+		input = map[string]any{}
+	}
+	for k, v := range values {
+		base, baseIsMap := input[k].(map[string]any)
+		over, overIsMap := v.(map[string]any)
+		if baseIsMap && overIsMap {
+			input[k] = setInputValues(base, over)
+		} else {
+			input[k] = v
+		}
+	}
+	return input
+}
+
+// compactJSON renders an input value for display.
+func compactJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func cmdRunsPriority(args []string) error {
+	fs := newFlagSet("runs priority")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if fs.NArg() != 2 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs priority <run-id> <value>"}
+	}
+	runID, err := parseRunIDArg(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	priority, err := strconv.Atoi(strings.TrimSpace(fs.Arg(1)))
+	if err != nil {
+		return &exitError{Code: 1, Message: "priority must be an integer"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var resp runResponse
+	path := fmt.Sprintf("/api/v1/runs/%d/priority", runID)
+	if err := client.doJSON(context.Background(), http.MethodPost, path, map[string]any{"priority": priority}, &resp); err != nil {
+		return mapError(err)
+	}
+
+	if jsonOut {
+		return ui.json(resp)
+	}
+	ui.infof("Run %d priority: %d\n", resp.RunID, resp.Priority)
+	return nil
+}
+
+func resolveWatchRunID(client *apiClient, runIDArg, appFlag string, defaultApp string) (int64, error) {
+	if strings.TrimSpace(runIDArg) != "" {
+		return parseRunIDArg(runIDArg)
+	}
+
+	app, err := defaultAppOrFlag(appFlag, defaultApp)
+	if err != nil {
+		return 0, err
+	}
+	listPath, err := withQuery("/api/v1/apps/"+url.PathEscape(app)+"/runs", map[string]string{
+		"limit": "1",
+	})
+	if err != nil {
+		return 0, err
+	}
+	var resp listRunsResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, listPath, nil, &resp); err != nil {
+		return 0, mapError(err)
+	}
+	if len(resp.Runs) == 0 {
+		return 0, &exitError{Code: 11, Message: fmt.Sprintf("no runs found for app %q", app)}
+	}
+	return resp.Runs[0].RunID, nil
+}
+
+func fetchRunLogs(ctx context.Context, client *apiClient, runID int64, afterSeq int64) ([]runLogEntry, error) {
+	logPath, err := withQuery(fmt.Sprintf("/api/v1/runs/%d/logs", runID), map[string]string{
+		"after_seq": strconv.FormatInt(afterSeq, 10),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var logsResp runLogsResponse
+	if err := client.doJSON(ctx, http.MethodGet, logPath, nil, &logsResp); err != nil {
+		return nil, err
+	}
+	return logsResp.Logs, nil
+}
+
+func cmdRunsWatch(args []string) error {
+	fs := newFlagSet("runs watch")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	appFlag := fs.String("app", "", "app slug (required if run-id omitted)")
+	statusOnly := fs.Bool("status-only", false, "watch status without logs")
+	interval := fs.Duration("interval", 2*time.Second, "poll interval")
+	jsonOut := fs.Bool("json", false, "print final run JSON")
+	logFlags := addLogFormatFlags(fs)
+	notify := addNotifyFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if *interval <= 0 {
+		return &exitError{Code: 1, Message: "--interval must be > 0"}
+	}
+	logFmt, err := logFlags.resolve()
+	if err != nil {
+		return err
+	}
+	if *jsonOut && !*statusOnly {
+		return &exitError{Code: 1, Message: "--json is only supported with --status-only for runs watch"}
+	}
+	if fs.NArg() > 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs watch [run-id] [--app APP]"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+
+	runIDArg := ""
+	if fs.NArg() == 1 {
+		runIDArg = fs.Arg(0)
+	}
+	runID, err := resolveWatchRunID(client, runIDArg, *appFlag, conn.DefaultApp)
+	if err != nil {
+		return err
+	}
+	return watchRun(client, runID, watchOptions{
+		statusOnly: *statusOnly,
+		interval:   *interval,
+		logFmt:     logFmt,
+		json:       *jsonOut,
+		notifier:   notify.notifier(),
+	})
+}
+
+// watchOptions configures watchRun.
+type watchOptions struct {
+	// statusOnly polls the run's status without fetching its logs.
+	statusOnly bool
+	interval   time.Duration
+	logFmt     logFormat
+	// json prints the finished run as JSON.
+	json     bool
+	notifier runNotifier
+}
+
+// watchRun polls a run until it finishes, printing its status changes and,
+// unless opts.statusOnly, its logs. It returns runs watch's exit error for
+// the run's final status: nil for completed, 2 for cancelled, 4 for dead
+// and 1 for any other failure.
+func watchRun(client *apiClient, runID int64, opts watchOptions) error {
+	// On a terminal a live status line replaces the status-change lines;
+	// redirected output stays line-oriented for CI logs.
+	var live *statusLine
+	if !opts.statusOnly && !ui.quiet && stdoutIsTerminal() {
+		live = &statusLine{w: ui.out}
+	}
+
+	var afterSeq, received int64
+	var frame int
+	lastStatus := ""
+	printNew := func(logs []runLogEntry) {
+		if len(logs) == 0 {
+			return
+		}
+		if live != nil {
+			live.clear()
+		}
+		printLogs(logs, opts.logFmt)
+		afterSeq = logs[len(logs)-1].Seq
+		received += int64(len(logs))
+	}
+	for {
+		var run runResponse
+		if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", runID), nil, &run); err != nil {
+			return mapError(err)
+		}
+
+		if live == nil && run.Status != lastStatus {
+			if run.Status == "dead" && run.DeadReason != "" {
+				ui.printf("run %d status: dead (%s)\n", runID, run.DeadReason)
+			} else {
+				ui.printf("run %d status: %s\n", runID, run.Status)
+			}
+			lastStatus = run.Status
+		}
+
+		if !opts.statusOnly {
+			logs, err := fetchRunLogs(context.Background(), client, runID, afterSeq)
+			if err != nil {
+				return mapError(err)
+			}
+			printNew(logs)
+		}
+
+		if isTerminalRunStatus(run.Status) {
+			if !opts.statusOnly {
+				logs, err := fetchRunLogs(context.Background(), client, runID, afterSeq)
+				if err != nil {
+					return mapError(err)
+				}
+				printNew(logs)
+			}
+			if live != nil {
+				live.clear()
+				ui.printf("%s\n", formatWatchSummary(run))
+			}
+			if opts.json {
+				if err := ui.json(run); err != nil {
+					return err
+				}
+			}
+			opts.notifier.notify(run)
+
+			switch run.Status {
+			case "completed":
+				return nil
+			case "cancelled":
+				return &exitError{Code: 2}
+			case "dead":
+				// Dead runs ended without a result from the runner, so
+				// scripts may want to retry them rather than treat them
+				// as the app failing.
+				return &exitError{Code: 4}
+			default:
+				return &exitError{Code: 1}
+			}
+		}
+
+		if live != nil {
+			live.draw(formatWatchStatus(run, time.Now(), received, frame))
+			frame++
+		}
+		time.Sleep(opts.interval)
+	}
+}
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// formatWatchStatus renders the live status line of runs watch.
+func formatWatchStatus(run runResponse, now time.Time, lines int64, frame int) string {
+	attempt := "-"
+	if run.AttemptNo > 0 {
+		attempt = strconv.FormatInt(run.AttemptNo, 10)
+	}
+	since := "queued"
+	from := run.QueuedAt
+	if run.StartedAt != nil {
+		since, from = "started", *run.StartedAt
+	}
+	elapsed := "?"
+	if t, err := time.Parse(time.RFC3339, from); err == nil {
+		elapsed = formatElapsed(now.Sub(t))
+	}
+	return fmt.Sprintf("%s run %d %s  %s since %s  attempt %s  %d lines",
+		spinnerFrames[frame%len(spinnerFrames)], run.RunID, run.Status, elapsed, since, attempt, lines)
+}
+
+// formatWatchSummary renders the line runs watch prints once the run has
+// finished: status, time from start (or from queueing, for runs that never
+// started) to finish, and the exit code when the runner reported one, or
+// the dead reason of a dead run.
+func formatWatchSummary(run runResponse) string {
+	summary := fmt.Sprintf("run %d %s", run.RunID, run.Status)
+	if d, ok := runElapsed(run); ok {
+		summary += " in " + formatElapsed(d)
+	}
+	if run.ExitCode != nil {
+		summary += fmt.Sprintf(" (exit code %d)", *run.ExitCode)
+	}
+	if run.Status == "dead" && run.DeadReason != "" {
+		summary += fmt.Sprintf(" (%s)", run.DeadReason)
+	}
+	return summary
+}
+
+// runElapsed returns the time from a finished run's start, or from its
+// queueing if it never started, to its finish. It is false for runs that
+// have not finished.
+func runElapsed(run runResponse) (time.Duration, bool) {
+	if run.FinishedAt == nil {
+		return 0, false
+	}
+	from := run.QueuedAt
+	if run.StartedAt != nil {
+		from = *run.StartedAt
+	}
+	start, err1 := time.Parse(time.RFC3339, from)
+	end, err2 := time.Parse(time.RFC3339, *run.FinishedAt)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	return end.Sub(start), true
+}
+
+// formatElapsed formats a duration to whole seconds, e.g. 42s, 3m07s, 1h02m03s.
+func formatElapsed(d time.Duration) string {
+	secs := int64(max(d, 0).Round(time.Second) / time.Second)
+	switch {
+	case secs < 60:
+		return fmt.Sprintf("%ds", secs)
+	case secs < 3600:
+		return fmt.Sprintf("%dm%02ds", secs/60, secs%60)
+	default:
+		return fmt.Sprintf("%dh%02dm%02ds", secs/3600, secs/60%60, secs%60)
+	}
+}
+
+func cmdRunsLogs(args []string) error {
+	fs := newFlagSet("runs logs")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	follow := fs.Bool("follow", false, "follow logs")
+	interval := fs.Duration("interval", 2*time.Second, "poll interval")
+	after := fs.Int64("after-seq", 0, "start after sequence number")
+	porcelain := fs.Bool("porcelain", false, "print stable tab-separated fields")
+	formats := addFormatFlags(fs)
+	logFlags := addLogFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs logs <run-id> [--follow]"}
+	}
+	logFmt, err := logFlags.resolve()
+	if err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return &exitError{Code: 1, Message: "--interval must be > 0"}
+	}
+	if *formats.json && *follow {
+		return &exitError{Code: 1, Message: "--json is not supported with --follow"}
+	}
+	if *porcelain && *formats.json {
+		return &exitError{Code: 1, Message: "--porcelain cannot be combined with --json"}
+	}
+	if *after < 0 {
+		return &exitError{Code: 1, Message: "--after-seq must be non-negative"}
+	}
+	runID, err := parseRunIDArg(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+	// A profile-level json preference does not apply to streams.
+	if *follow || *porcelain {
+		jsonOut = false
+	}
+	printFn := func(logs []runLogEntry) { printLogs(logs, logFmt) }
+	if *porcelain {
+		printFn = func(logs []runLogEntry) { printLogsPorcelain(logFmt.filterLogs(logs)) }
+	}
+
+	afterSeq := *after
+	for {
+		logs, err := fetchRunLogs(context.Background(), client, runID, afterSeq)
+		if err != nil {
+			return mapError(err)
+		}
+		if jsonOut {
+			return ui.json(runLogsResponse{Logs: logFmt.filterLogs(logs)})
+		}
+		if len(logs) > 0 {
+			printFn(logs)
+			afterSeq = logs[len(logs)-1].Seq
+		}
+		if !*follow {
+			return nil
+		}
+
+		var run runResponse
+		if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", runID), nil, &run); err != nil {
+			return mapError(err)
+		}
+		if isTerminalRunStatus(run.Status) {
+			logs, err := fetchRunLogs(context.Background(), client, runID, afterSeq)
+			if err != nil {
+				return mapError(err)
+			}
+			if len(logs) > 0 {
+				printFn(logs)
+			}
+			return nil
+		}
+
+		time.Sleep(*interval)
+	}
+}
+
+// runsOutputsFlags holds the flags shared by runs outputs and its download
+// form, which the registry lists as one leaf.
+type runsOutputsFlags struct {
+	fs          *flag.FlagSet
+	server      *string
+	token       *string
+	profileName *string
+	out         *string
+	formats     *formatFlags
+}
+
+func newRunsOutputsFlags(name string) *runsOutputsFlags {
+	f := &runsOutputsFlags{fs: newFlagSet(name)}
+	f.server = f.fs.String("server", "", "server URL")
+	f.token = f.fs.String("token", "", "API token")
+	f.profileName = f.fs.String("profile", "", "profile name")
+	f.out = f.fs.String("out", "", "download destination path, or - for stdout")
+	f.formats = addFormatFlags(f.fs)
+	return f
+}
+
+// cmdRunsEvents prints a run's timeline, each event with the time since the
+// one before it.
+func cmdRunsEvents(args []string) error {
+	fs := newFlagSet("runs events")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs events <run-id>"}
+	}
+	runID, err := parseRunIDArg(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var resp runEventsResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d/events", runID), nil, &resp); err != nil {
+		return mapError(err)
+	}
+	if jsonOut {
+		return ui.json(resp)
+	}
+	tw := ui.table()
+	fmt.Fprintln(tw, "AT\tDELTA\tEVENT\tDETAILS")
+	var prev time.Time
+	for _, e := range resp.Events {
+		delta := ""
+		if at, err := time.Parse(time.RFC3339Nano, e.At); err == nil {
+			if !prev.IsZero() {
+				delta = "+" + formatEventGap(at.Sub(prev))
+			}
+			prev = at
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.At, delta, e.Kind, formatEventDetails(e.Details))
+	}
+	_ = tw.Flush()
+	return nil
+}
+
+// formatEventGap formats the time between two events, keeping sub-second
+// gaps readable: 350ms, 2.4s, 3m07s.
+func formatEventGap(d time.Duration) string {
+	d = max(d, 0)
+	switch {
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	case d < time.Minute:
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	default:
+		return formatElapsed(d)
+	}
+}
+
+// formatEventDetails renders event details as key=value pairs sorted by key.
+func formatEventDetails(details map[string]any) string {
+	keys := make([]string, 0, len(details))
+	for k := range details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := formatJSONValue(details[k])
+		if s, ok := details[k].(string); ok && !strings.ContainsAny(s, " \t\"") {
+			v = s
+		}
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, " ")
+}
+
+// cmdRunsAttempts lists a run's attempts. --json includes each attempt's
+// environment snapshot, with secret values redacted by the server.
+func cmdRunsAttempts(args []string) error {
+	fs := newFlagSet("runs attempts")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs attempts <run-id>"}
+	}
+	runID, err := parseRunIDArg(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var resp runAttemptsResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d/attempts", runID), nil, &resp); err != nil {
+		return mapError(err)
+	}
+	if jsonOut {
+		return ui.json(resp)
+	}
+	tw := ui.table()
+	fmt.Fprintln(tw, "ATTEMPT\tRUNNER_ID\tSTATUS\tEXIT_CODE\tSTARTED_AT\tFINISHED_AT\tENV_VARS")
+	orDash := func(s *string) string {
+		if s == nil {
+			return "-"
+		}
+		return *s
+	}
+	for _, a := range resp.Attempts {
+		exitCode := "-"
+		if a.ExitCode != nil {
+			exitCode = strconv.Itoa(*a.ExitCode)
+		}
+		envVars := strconv.Itoa(len(a.EnvSnapshot))
+		if a.EnvSnapshot == nil {
+			envVars = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t%s\n", a.AttemptNo, a.RunnerID, a.Status, exitCode, orDash(a.StartedAt), orDash(a.FinishedAt), envVars)
+	}
+	_ = tw.Flush()
+	return nil
+}
+
+// cmdRunsOutputs lists a run's uploaded outputs, or with a leading
+// "download" saves one of them.
+func cmdRunsOutputs(args []string) error {
+	if len(args) > 0 && args[0] == "download" {
+		return cmdRunsOutputsDownload(args[1:])
+	}
+	f := newRunsOutputsFlags("runs outputs")
+	if err := f.fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if f.fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs outputs <run-id> | runs outputs download <run-id> <name> [--out <path>]"}
+	}
+	if *f.out != "" {
+		return &exitError{Code: 1, Message: "--out only applies to runs outputs download"}
+	}
+	runID, err := parseRunIDArg(f.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*f.profileName, *f.server, *f.token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := f.formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var resp listRunOutputsResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d/outputs", runID), nil, &resp); err != nil {
+		return mapError(err)
+	}
+	if jsonOut {
+		return ui.json(resp)
+	}
+	tw := ui.table()
+	fmt.Fprintln(tw, "NAME\tSIZE\tSHA256\tCREATED_AT")
+	for _, o := range resp.Outputs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", o.Name, formatBytes(o.SizeBytes), o.SHA256, o.CreatedAt)
+	}
+	_ = tw.Flush()
+	return nil
+}
+
+// cmdRunsOutputsDownload saves one output to --out, the output's name in the
+// current directory by default, or stdout for "-".
+func cmdRunsOutputsDownload(args []string) error {
+	f := newRunsOutputsFlags("runs outputs download")
+	if err := f.fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if f.fs.NArg() != 2 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs outputs download <run-id> <name> [--out <path>]"}
+	}
+	if *f.formats.json || *f.formats.table {
+		return &exitError{Code: 1, Message: "--json and --table do not apply to runs outputs download"}
+	}
+	runID, err := parseRunIDArg(f.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	name := f.fs.Arg(1)
+
+	client, _, err := resolveCommandConnection(*f.profileName, *f.server, *f.token, true)
+	if err != nil {
+		return err
+	}
+
+	data, err := client.getRaw(context.Background(), fmt.Sprintf("/api/v1/runs/%d/outputs/%s", runID, url.PathEscape(name)))
+	if err != nil {
+		return mapError(err)
+	}
+	if *f.out == "-" {
+		return ui.write(data)
+	}
+	dest := *f.out
+	if dest == "" {
+		dest = filepath.Base(name)
+	}
+	if err := os.WriteFile(dest, data, 0o644); err != nil {
+		return &exitError{Code: 1, Message: fmt.Sprintf("write output: %v", err)}
+	}
+	ui.infof("Saved %s (%s) to %s\n", name, formatBytes(int64(len(data))), dest)
+	return nil
+}
+
+func cmdTokensCreate(args []string) error {
+	fs := newFlagSet("tokens create")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	name := fs.String("name", "", "token name")
+	role := fs.String("role", "", "token role (admin|member)")
+	var scopes stringsFlag
+	fs.Var(&scopes, "scope", "limit the token to a scope: runs:create, runs:read or runs:cancel (repeatable)")
+	app := fs.String("app", "", "limit a scoped token to one app's runs")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(*role) != "" {
+		r := strings.TrimSpace(*role)
+		if r != "admin" && r != "member" {
+			return &exitError{Code: 1, Message: "--role must be admin or member"}
+		}
+	}
+	if strings.TrimSpace(*app) != "" && len(scopes) == 0 {
+		return &exitError{Code: 1, Message: "--app needs at least one --scope"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{}
+	if strings.TrimSpace(*name) != "" {
+		body["name"] = strings.TrimSpace(*name)
+	}
+	if strings.TrimSpace(*role) != "" {
+		body["role"] = strings.TrimSpace(*role)
+	}
+	if len(scopes) > 0 {
+		body["scopes"] = []string(scopes)
+	}
+	if strings.TrimSpace(*app) != "" {
+		body["app_slug"] = strings.TrimSpace(*app)
+	}
+
+	var resp createTokenResponse
+	if err := client.doJSON(context.Background(), http.MethodPost, "/api/v1/tokens", body, &resp); err != nil {
+		return mapError(err)
+	}
+
+	if jsonOut {
+		return ui.json(resp)
+	}
+	ui.infof("Token created: id=%d role=%s\n", resp.TokenID, resp.Role)
+	if len(resp.Scopes) > 0 {
+		scope := strings.Join(resp.Scopes, ", ")
+		if resp.AppSlug != "" {
+			scope += " on app " + resp.AppSlug
+		}
+		ui.infof("Scopes: %s\n", scope)
+	}
+	ui.printf("%s\n", resp.Token)
+	return nil
+}
+
+func cmdRunnersList(args []string) error {
+	fs := newFlagSet("runners list")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	status := fs.String("status", "", "status filter (online or offline)")
+	env := fs.String("env", "", "environment filter")
+	namePrefix := fs.String("name-prefix", "", "runner name prefix filter")
+	staleFor := fs.Duration("stale-for", 0, "only runners not seen for at least this long")
+	limit := fs.Int("limit", 100, "max rows")
+	offset := fs.Int("offset", 0, "offset")
+	columns := fs.String("columns", "", "comma-separated columns to print")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+	if *limit <= 0 || *limit > 500 {
+		return &exitError{Code: 1, Message: "--limit must be between 1 and 500"}
+	}
+	if *offset < 0 {
+		return &exitError{Code: 1, Message: "--offset must be >= 0"}
+	}
+	if *staleFor < 0 {
+		return &exitError{Code: 1, Message: "--stale-for must be positive"}
+	}
+	cols, err := runnerColumns.parse(*columns)
+	if err != nil {
+		return err
+	}
+	query := map[string]string{
+		"status":      strings.TrimSpace(*status),
+		"environment": strings.TrimSpace(*env),
+		"name_prefix": strings.TrimSpace(*namePrefix),
+		"limit":       strconv.Itoa(*limit),
+		"offset":      strconv.Itoa(*offset),
+	}
+	if *staleFor > 0 {
+		query["stale_for"] = staleFor.String()
+	}
+	qPath, err := withQuery("/api/v1/admin/runners", query)
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var resp listAdminRunnersResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, qPath, nil, &resp); err != nil {
+		return mapError(err)
+	}
+
+	if jsonOut {
+		return ui.json(resp)
+	}
+	printRunnerTable(resp.Runners, cols)
+	if int64(*offset+len(resp.Runners)) < resp.Total {
+		ui.infof("Showing %d-%d of %d runners (use --offset for more)\n", *offset+1, *offset+len(resp.Runners), resp.Total)
+	}
+	return nil
+}
+
+// cmdRunnersRegister creates a runner and prints its token, which is shown
+// only this once, for the host's MINITOWER_RUNNER_TOKEN.
+func cmdRunnersRegister(args []string) error {
+	fs := newFlagSet("runners register")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	environment := fs.String("environment", "", "runner environment (default: default)")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if fs.NArg() != 1 || strings.TrimSpace(fs.Arg(0)) == "" {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runners register <name> [--environment <env>]"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{"name": strings.TrimSpace(fs.Arg(0))}
+	if strings.TrimSpace(*environment) != "" {
+		body["environment"] = strings.TrimSpace(*environment)
+	}
+	var resp runnerTokenResponse
+	if err := client.doJSON(context.Background(), http.MethodPost, "/api/v1/admin/runners", body, &resp); err != nil {
+		return mapError(err)
+	}
+	return printRunnerToken(resp, jsonOut, "Runner registered")
+}
+
+// cmdRunnersRotateToken issues a runner a new token. The old one stops
+// working and attempts leased under it are fenced.
+func cmdRunnersRotateToken(args []string) error {
+	fs := newFlagSet("runners rotate-token")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runners rotate-token <runner-id>"}
+	}
+	runnerID, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil || runnerID < 1 {
+		return &exitError{Code: 1, Message: "runner-id must be a positive integer"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var resp runnerTokenResponse
+	if err := client.doJSON(context.Background(), http.MethodPost, fmt.Sprintf("/api/v1/admin/runners/%d/rotate-token", runnerID), nil, &resp); err != nil {
+		return mapError(err)
+	}
+	return printRunnerToken(resp, jsonOut, "Runner token rotated")
+}
+
+// printRunnerToken prints a runner token like tokens create does: details
+// on stderr and the bare token on stdout, so it can be piped into a host's
+// config.
+func printRunnerToken(resp runnerTokenResponse, jsonOut bool, what string) error {
+	if jsonOut {
+		return ui.json(resp)
+	}
+	ui.infof("%s: id=%d name=%s environment=%s\n", what, resp.RunnerID, resp.Name, resp.Environment)
+	ui.infof("Set MINITOWER_RUNNER_TOKEN on the runner host; this token is not shown again.\n")
+	ui.printf("%s\n", resp.Token)
+	return nil
+}
+
+func cmdEnvsList(args []string) error {
+	fs := newFlagSet("envs list")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var resp listEnvironmentsResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, "/api/v1/environments", nil, &resp); err != nil {
+		return mapError(err)
+	}
+
+	if jsonOut {
+		return ui.json(resp)
+	}
+	printEnvironmentTable(resp.Environments)
+	return nil
+}
+
+func cmdEnvsCreate(args []string) error {
+	fs := newFlagSet("envs create")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli envs create <name>"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var resp environmentResponse
+	err = client.doJSON(context.Background(), http.MethodPost, "/api/v1/environments", map[string]any{
+		"name": strings.TrimSpace(fs.Arg(0)),
+	}, &resp)
+	if err != nil {
+		return mapError(err)
+	}
+
+	if jsonOut {
+		return ui.json(resp)
+	}
+	ui.infof("Environment %q created (id=%d)\n", resp.Name, resp.EnvironmentID)
+	return nil
+}
+
+func cmdEnvsDelete(args []string) error {
+	fs := newFlagSet("envs delete")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli envs delete <name>"}
+	}
+
+	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSpace(fs.Arg(0))
+	if err := client.doJSON(context.Background(), http.MethodDelete, "/api/v1/environments/"+url.PathEscape(name), nil, nil); err != nil {
+		return mapError(err)
+	}
+	ui.infof("Environment %q deleted\n", name)
+	return nil
+}
+
+func cmdAdminOverview(args []string) error {
+	fs := newFlagSet("admin overview")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	var resp adminOverviewResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, "/api/v1/admin/overview", nil, &resp); err != nil {
+		return mapError(err)
+	}
+
+	if jsonOut {
+		return ui.json(resp)
+	}
+	printAdminOverview(resp)
+	return nil
+}
+
+func cmdReportsUsage(args []string) error {
+	fs := newFlagSet("reports usage")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	from := fs.String("from", "", "start date (YYYY-MM-DD or RFC 3339), inclusive")
+	to := fs.String("to", "", "end date (YYYY-MM-DD or RFC 3339), exclusive")
+	groupBy := fs.String("group-by", "app", "app, team or environment")
+	csvOut := fs.Bool("csv", false, "print CSV")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*from) == "" || strings.TrimSpace(*to) == "" {
+		return &exitError{Code: 1, Message: "usage: minitower-cli reports usage --from DATE --to DATE [--group-by app|team|environment]"}
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+	if *csvOut && (*formats.json || *formats.table) {
+		return &exitError{Code: 1, Message: "--csv cannot be combined with --json or --table"}
+	}
+
+	q := url.Values{}
+	q.Set("from", strings.TrimSpace(*from))
+	q.Set("to", strings.TrimSpace(*to))
+	q.Set("group_by", strings.TrimSpace(*groupBy))
+	var resp usageReportResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, "/api/v1/reports/usage?"+q.Encode(), nil, &resp); err != nil {
+		return mapError(err)
+	}
+
+	switch {
+	case *csvOut:
+		return printUsageReportCSV(resp)
+	case jsonOut:
+		return ui.json(resp)
+	}
+	printUsageReport(resp)
+	return nil
+}
+
+func cmdAuditList(args []string) error {
+	fs := newFlagSet("audit list")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	since := fs.String("since", "", "entries at or after this time (duration such as 24h, YYYY-MM-DD or RFC 3339)")
+	until := fs.String("until", "", "entries before this time (duration, YYYY-MM-DD or RFC 3339)")
+	action := fs.String("action", "", "action filter, e.g. run.cancel")
+	tokenID := fs.Int64("token-id", 0, "only entries made by this token (admin)")
+	limit := fs.Int("limit", 100, "max rows")
+	offset := fs.Int("offset", 0, "offset")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+	if *limit <= 0 || *limit > 500 {
+		return &exitError{Code: 1, Message: "--limit must be between 1 and 500"}
+	}
+	if *offset < 0 {
+		return &exitError{Code: 1, Message: "--offset must be >= 0"}
+	}
+	if *tokenID < 0 {
+		return &exitError{Code: 1, Message: "--token-id must be positive"}
+	}
+	now := time.Now()
+	sinceValue, err := auditTimeFlag("since", *since, now)
+	if err != nil {
+		return err
+	}
+	untilValue, err := auditTimeFlag("until", *until, now)
+	if err != nil {
+		return err
+	}
+	query := map[string]string{
+		"since":  sinceValue,
+		"until":  untilValue,
+		"action": strings.TrimSpace(*action),
+		"limit":  strconv.Itoa(*limit),
+		"offset": strconv.Itoa(*offset),
+	}
+	if *tokenID > 0 {
+		query["token_id"] = strconv.FormatInt(*tokenID, 10)
+	}
+	qPath, err := withQuery("/api/v1/audit", query)
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
 	}
-	var resp listRunsResponse
-	if err := client.doJSON(context.Background(), http.MethodGet, listPath, nil, &resp); err != nil {
-		return 0, mapError(err)
+
+	var resp auditLogResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, qPath, nil, &resp); err != nil {
+		return mapError(err)
 	}
-	if len(resp.Runs) == 0 {
-		return 0, &exitError{Code: 11, Message: fmt.Sprintf("no runs found for app %q", app)}
+
+	if jsonOut {
+		return ui.json(resp)
 	}
-	return resp.Runs[0].RunID, nil
+	tw := ui.table()
+	fmt.Fprintln(tw, "TIME\tACTION\tRESOURCE\tTOKEN\tDETAILS")
+	for _, e := range resp.Entries {
+		tokenCol := "-"
+		if e.TokenID != nil {
+			tokenCol = strconv.FormatInt(*e.TokenID, 10)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s/%s\t%s\t%s\n", e.CreatedAt, e.Action, e.ResourceType, e.ResourceID, tokenCol, formatEventDetails(e.Details))
+	}
+	_ = tw.Flush()
+	return nil
 }
 
-func fetchRunLogs(client *apiClient, runID int64, afterSeq int64) ([]runLogEntry, error) {
-	logPath, err := withQuery(fmt.Sprintf("/api/v1/runs/%d/logs", runID), map[string]string{
-		"after_seq": strconv.FormatInt(afterSeq, 10),
-	})
-	if err != nil {
-		return nil, err
-	}
-	var logsResp runLogsResponse
-	if err := client.doJSON(context.Background(), http.MethodGet, logPath, nil, &logsResp); err != nil {
-		return nil, err
+// auditTimeFlag turns a --since/--until value into the server's time format.
+// A Go duration means that long before now; dates and RFC 3339 times pass
+// through for the server to validate.
+func auditTimeFlag(name, value string, now time.Time) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return "", &exitError{Code: 1, Message: fmt.Sprintf("--%s duration must be positive", name)}
+		}
+		return now.Add(-d).UTC().Format(time.RFC3339), nil
 	}
-	return logsResp.Logs, nil
+	return value, nil
 }
 
-func cmdRunsWatch(args []string) error {
-	fs := newFlagSet("runs watch")
+func cmdAdminBackup(args []string) error {
+	fs := newFlagSet("admin backup")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	appFlag := fs.String("app", "", "app slug (required if run-id omitted)")
-	statusOnly := fs.Bool("status-only", false, "watch status without logs")
-	interval := fs.Duration("interval", 2*time.Second, "poll interval")
-	jsonOut := fs.Bool("json", false, "print final run JSON")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if *interval <= 0 {
-		return &exitError{Code: 1, Message: "--interval must be > 0"}
-	}
-	if *jsonOut && !*statusOnly {
-		return &exitError{Code: 1, Message: "--json is only supported with --status-only for runs watch"}
-	}
-	if fs.NArg() > 1 {
-		return &exitError{Code: 1, Message: "usage: minitower-cli runs watch [run-id] [--app APP]"}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
 	}
 
 	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
 	if err != nil {
 		return err
 	}
-
-	runIDArg := ""
-	if fs.NArg() == 1 {
-		runIDArg = fs.Arg(0)
-	}
-	runID, err := resolveWatchRunID(client, runIDArg, *appFlag, conn.DefaultApp)
+	jsonOut, err := formats.resolve(conn.Output)
 	if err != nil {
 		return err
 	}
 
-	var afterSeq int64
-	lastStatus := ""
-	for {
-		var run runResponse
-		if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", runID), nil, &run); err != nil {
-			return mapError(err)
-		}
+	var resp backupResponse
+	if err := client.doJSON(context.Background(), http.MethodPost, "/api/v1/admin/backup", nil, &resp); err != nil {
+		return mapError(err)
+	}
 
-		if run.Status != lastStatus {
-			fmt.Printf("run %d status: %s\n", runID, run.Status)
-			lastStatus = run.Status
-		}
+	if jsonOut {
+		return ui.json(resp)
+	}
+	ui.infof("Backup written to %s (%d bytes, sha256:%s)\n", resp.Path, resp.SizeBytes, shortenSHA(resp.SHA256))
+	return nil
+}
 
-		if !*statusOnly {
-			logs, err := fetchRunLogs(client, runID, afterSeq)
-			if err != nil {
-				return mapError(err)
-			}
-			if len(logs) > 0 {
-				printLogs(logs)
-				afterSeq = logs[len(logs)-1].Seq
-			}
-		}
+func shortenSHA(sha string) string {
+	sha = strings.TrimSpace(sha)
+	if len(sha) <= 12 {
+		return sha
+	}
+	return sha[:12]
+}
 
-		if isTerminalRunStatus(run.Status) {
-			if !*statusOnly {
-				logs, err := fetchRunLogs(client, runID, afterSeq)
-				if err != nil {
-					return mapError(err)
-				}
-				if len(logs) > 0 {
-					printLogs(logs)
-					afterSeq = logs[len(logs)-1].Seq
-				}
-			}
-			if *jsonOut {
-				if err := printJSON(run); err != nil {
-					return err
-				}
-			}
+// maxBatchInputs mirrors the server's per-batch run limit.
+const maxBatchInputs = 500
 
-			switch run.Status {
-			case "completed":
-				return nil
-			case "cancelled":
-				return &exitError{Code: 2}
-			default:
-				return &exitError{Code: 1}
-			}
+// readBatchInputs reads one JSON object per line from path ("-" for stdin).
+// Blank lines are skipped; lines[i] is the file line of input i, for
+// reporting per-input errors from the server.
+func readBatchInputs(path string) (inputs []map[string]any, lines []int, err error) {
+	var r io.Reader
+	if path == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, &exitError{Code: 1, Message: fmt.Sprintf("open input file: %v", err)}
 		}
-
-		time.Sleep(*interval)
+		defer f.Close()
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var input map[string]any
+		if err := json.Unmarshal([]byte(line), &input); err != nil || input == nil {
+			return nil, nil, &exitError{Code: 1, Message: fmt.Sprintf("%s:%d: each line must be a JSON object", path, lineNo)}
+		}
+		inputs = append(inputs, input)
+		lines = append(lines, lineNo)
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, &exitError{Code: 1, Message: fmt.Sprintf("read input file: %v", err)}
+	}
+	if len(inputs) == 0 {
+		return nil, nil, &exitError{Code: 1, Message: fmt.Sprintf("%s contains no inputs", path)}
+	}
+	if len(inputs) > maxBatchInputs {
+		return nil, nil, &exitError{Code: 1, Message: fmt.Sprintf("%s has %d inputs; a batch holds at most %d", path, len(inputs), maxBatchInputs)}
+	}
+	return inputs, lines, nil
 }
 
-func cmdRunsLogs(args []string) error {
-	fs := newFlagSet("runs logs")
+func cmdRunsCreateBatch(args []string) error {
+	fs := newFlagSet("runs create-batch")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	follow := fs.Bool("follow", false, "follow logs")
-	interval := fs.Duration("interval", 2*time.Second, "poll interval")
-	after := fs.Int64("after-seq", 0, "start after sequence number")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	appFlag := fs.String("app", "", "app slug")
+	inputFile := fs.String("input-file", "", "file with one JSON input object per line (- for stdin)")
+	version := fs.String("version", "", "version number or label")
+	priority := fs.String("priority", "", "priority")
+	maxRetries := fs.String("max-retries", "", "max retries")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if fs.NArg() != 1 {
-		return &exitError{Code: 1, Message: "usage: minitower-cli runs logs <run-id> [--follow]"}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
 	}
-	if *interval <= 0 {
-		return &exitError{Code: 1, Message: "--interval must be > 0"}
+	if strings.TrimSpace(*inputFile) == "" {
+		return &exitError{Code: 1, Message: "--input-file is required"}
 	}
-	if *jsonOut && *follow {
-		return &exitError{Code: 1, Message: "--json is not supported with --follow"}
+
+	inputs, lines, err := readBatchInputs(*inputFile)
+	if err != nil {
+		return err
 	}
-	if *after < 0 {
-		return &exitError{Code: 1, Message: "--after-seq must be non-negative"}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
 	}
-	runID, err := parseRunIDArg(fs.Arg(0))
+	jsonOut, err := formats.resolve(conn.Output)
 	if err != nil {
 		return err
 	}
-
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
 	if err != nil {
 		return err
 	}
 
-	afterSeq := *after
-	for {
-		logs, err := fetchRunLogs(client, runID, afterSeq)
+	payload := map[string]any{"inputs": inputs}
+	if err := setRunVersion(payload, *version); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*priority) != "" {
+		val, err := strconv.Atoi(strings.TrimSpace(*priority))
 		if err != nil {
-			return mapError(err)
-		}
-		if *jsonOut {
-			return printJSON(runLogsResponse{Logs: logs})
-		}
-		if len(logs) > 0 {
-			printLogs(logs)
-			afterSeq = logs[len(logs)-1].Seq
+			return &exitError{Code: 1, Message: "--priority must be an integer"}
 		}
-		if !*follow {
-			return nil
+		payload["priority"] = val
+	}
+	if strings.TrimSpace(*maxRetries) != "" {
+		val, err := strconv.Atoi(strings.TrimSpace(*maxRetries))
+		if err != nil || val < 0 {
+			return &exitError{Code: 1, Message: "--max-retries must be a non-negative integer"}
 		}
+		payload["max_retries"] = val
+	}
 
-		var run runResponse
-		if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", runID), nil, &run); err != nil {
-			return mapError(err)
-		}
-		if isTerminalRunStatus(run.Status) {
-			logs, err := fetchRunLogs(client, runID, afterSeq)
-			if err != nil {
-				return mapError(err)
-			}
-			if len(logs) > 0 {
-				printLogs(logs)
+	var resp createBatchResponse
+	err = client.doJSON(context.Background(), http.MethodPost, "/api/v1/apps/"+url.PathEscape(app)+"/runs/batch", payload, &resp)
+	if err != nil {
+		var ae *apiError
+		if errors.As(err, &ae) {
+			for _, item := range ae.Items {
+				line := item.Index + 1
+				if item.Index >= 0 && item.Index < len(lines) {
+					line = lines[item.Index]
+				}
+				ui.warnf("%s:%d: %s\n", *inputFile, line, item.Message)
 			}
-			return nil
 		}
+		return mapError(err)
+	}
 
-		time.Sleep(*interval)
+	if jsonOut {
+		return ui.json(resp)
 	}
+	ui.infof("Batch created: %d runs of %s version %d\n", resp.Count, resp.AppSlug, resp.VersionNo)
+	ui.printf("%s\n", resp.BatchID)
+	return nil
+}
+
+func printBatch(b batchResponse) {
+	ui.printf("Batch:     %s\n", b.BatchID)
+	ui.printf("App:       %s\n", b.AppSlug)
+	ui.printf("Created:   %s\n", b.CreatedAt)
+	ui.printf("Progress:  %d/%d finished (%.1f%%)\n", b.Terminal, b.Total, b.PercentComplete)
+	ui.printf("Statuses:  %s\n", formatBatchCounts(b.Counts))
 }
 
-func cmdTokens(args []string) error {
-	if len(args) == 0 {
-		return &exitError{Code: 1, Message: "usage: minitower-cli tokens <create|list|revoke> ..."}
+// formatBatchCounts renders status counts in lifecycle order, e.g.
+// "queued=3 running=1 completed=6".
+func formatBatchCounts(counts map[string]int64) string {
+	parts := make([]string, 0, len(counts))
+	for _, status := range runStatus {
+		if n := counts[status]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", status, n))
+		}
 	}
-	switch args[0] {
-	case "create":
-		return cmdTokensCreate(args[1:])
-	case "list", "revoke":
-		return &exitError{Code: 1, Message: fmt.Sprintf("tokens %s is not available yet (API endpoint not implemented)", args[0])}
-	default:
-		return &exitError{Code: 1, Message: fmt.Sprintf("unknown tokens subcommand: %s", args[0])}
+	if len(parts) == 0 {
+		return "none"
 	}
+	return strings.Join(parts, " ")
 }
 
-func cmdTokensCreate(args []string) error {
-	fs := newFlagSet("tokens create")
+// batchPath returns the API path of a batch, plus an optional action.
+func batchPath(batchID, action string) string {
+	p := "/api/v1/batches/" + url.PathEscape(batchID)
+	if action != "" {
+		p += "/" + action
+	}
+	return p
+}
+
+func cmdBatchesGet(args []string) error {
+	fs := newFlagSet("batches get")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	name := fs.String("name", "", "token name")
-	role := fs.String("role", "", "token role (admin|member)")
-	jsonOut := fs.Bool("json", false, "print JSON")
+	formats := addFormatFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if err := ensureNoExtraArgs(fs); err != nil {
-		return err
-	}
-
-	if strings.TrimSpace(*role) != "" {
-		r := strings.TrimSpace(*role)
-		if r != "admin" && r != "member" {
-			return &exitError{Code: 1, Message: "--role must be admin or member"}
-		}
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli batches get <batch-id>"}
 	}
 
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
 	if err != nil {
 		return err
 	}
-
-	body := map[string]any{}
-	if strings.TrimSpace(*name) != "" {
-		body["name"] = strings.TrimSpace(*name)
-	}
-	if strings.TrimSpace(*role) != "" {
-		body["role"] = strings.TrimSpace(*role)
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
 	}
 
-	var resp createTokenResponse
-	if err := client.doJSON(context.Background(), http.MethodPost, "/api/v1/tokens", body, &resp); err != nil {
+	var resp batchResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, batchPath(fs.Arg(0), ""), nil, &resp); err != nil {
 		return mapError(err)
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	fmt.Printf("Token created: id=%d role=%s\n", resp.TokenID, resp.Role)
-	fmt.Printf("Token: %s\n", resp.Token)
+	printBatch(resp)
 	return nil
 }
 
-func cmdRunners(args []string) error {
-	if len(args) == 0 || args[0] != "list" {
-		return &exitError{Code: 1, Message: "usage: minitower-cli runners list"}
-	}
-
-	fs := newFlagSet("runners list")
+func cmdBatchesCancel(args []string) error {
+	fs := newFlagSet("batches cancel")
 	server := fs.String("server", "", "server URL")
 	token := fs.String("token", "", "API token")
 	profileName := fs.String("profile", "", "profile name")
-	jsonOut := fs.Bool("json", false, "print JSON")
-	if err := fs.Parse(args[1:]); err != nil {
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
 		return &exitError{Code: 1, Message: err.Error()}
 	}
-	if err := ensureNoExtraArgs(fs); err != nil {
-		return err
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli batches cancel <batch-id>"}
 	}
 
-	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
 	if err != nil {
 		return err
 	}
 
-	var resp listAdminRunnersResponse
-	if err := client.doJSON(context.Background(), http.MethodGet, "/api/v1/admin/runners", nil, &resp); err != nil {
+	var resp cancelBatchResponse
+	if err := client.doJSON(context.Background(), http.MethodPost, batchPath(fs.Arg(0), "cancel"), nil, &resp); err != nil {
 		return mapError(err)
 	}
 
-	if *jsonOut {
-		return printJSON(resp)
+	if jsonOut {
+		return ui.json(resp)
 	}
-	printRunnerTable(resp.Runners)
+	ui.infof("Batch %s: %d cancelled, %d cancelling\n", resp.BatchID, resp.Cancelled, resp.Cancelling)
 	return nil
 }
 
-func shortenSHA(sha string) string {
-	sha = strings.TrimSpace(sha)
-	if len(sha) <= 12 {
-		return sha
+func cmdBatchesWatch(args []string) error {
+	fs := newFlagSet("batches watch")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	interval := fs.Duration("interval", 5*time.Second, "poll interval")
+	jsonOut := fs.Bool("json", false, "print final batch JSON")
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if *interval <= 0 {
+		return &exitError{Code: 1, Message: "--interval must be > 0"}
+	}
+	if fs.NArg() != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli batches watch <batch-id>"}
+	}
+
+	client, _, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+
+	lastLine := ""
+	for {
+		var b batchResponse
+		if err := client.doJSON(context.Background(), http.MethodGet, batchPath(fs.Arg(0), ""), nil, &b); err != nil {
+			return mapError(err)
+		}
+
+		line := fmt.Sprintf("batch %s: %d/%d finished (%.1f%%) %s", b.BatchID, b.Terminal, b.Total, b.PercentComplete, formatBatchCounts(b.Counts))
+		if line != lastLine {
+			ui.printf("%s\n", line)
+			lastLine = line
+		}
+
+		if b.Done {
+			if *jsonOut {
+				if err := ui.json(b); err != nil {
+					return err
+				}
+			}
+			// Same exit codes as runs watch: any failure wins over
+			// cancellation.
+			switch {
+			case b.Counts["failed"] > 0 || b.Counts["dead"] > 0:
+				return &exitError{Code: 1}
+			case b.Counts["cancelled"] > 0:
+				return &exitError{Code: 2}
+			default:
+				return nil
+			}
+		}
+
+		time.Sleep(*interval)
 	}
-	return sha[:12]
 }
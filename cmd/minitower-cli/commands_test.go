@@ -0,0 +1,1488 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunsCreateBatchReportsRejectedLines(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	var got struct {
+		Inputs   []map[string]any `json:"inputs"`
+		Priority int              `json:"priority"`
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/apps/hello/runs/batch", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode batch request: %v", err)
+		}
+		if len(got.Inputs) == 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"code":"invalid_request","message":"1 of 3 inputs are invalid; no runs were created","items":[{"index":2,"message":"bad day"}]}}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"batch_id":"b1","app_slug":"hello","version_no":2,"count":2,"run_ids":[5,6]}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	// Blank lines are skipped, so input 2 is on line 4.
+	path := filepath.Join(t.TempDir(), "inputs.jsonl")
+	if err := os.WriteFile(path, []byte("{\"day\":1}\n{\"day\":2}\n\n{\"day\":\"x\"}\n"), 0o600); err != nil {
+		t.Fatalf("write inputs: %v", err)
+	}
+	err := run([]string{"runs", "create-batch", "--server", srv.URL, "--token", "tok", "--app", "hello", "--input-file", path})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Code != 1 {
+		t.Fatalf("expected exit 1, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), path+":4: bad day\n") {
+		t.Fatalf("expected rejected line 4 reported, got %q", stderr.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := os.WriteFile(path, []byte("{\"day\":1}\n{\"day\":2}\n"), 0o600); err != nil {
+		t.Fatalf("write inputs: %v", err)
+	}
+	if err := run([]string{"runs", "create-batch", "--server", srv.URL, "--token", "tok", "--app", "hello", "--priority", "4", "--input-file", path}); err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	if got.Priority != 4 || len(got.Inputs) != 2 {
+		t.Fatalf("unexpected batch request %+v", got)
+	}
+	if stdout.String() != "b1\n" {
+		t.Fatalf("expected only the batch ID on stdout, got %q", stdout.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := os.WriteFile(path, []byte("{\"day\":1}\n[1,2]\n"), 0o600); err != nil {
+		t.Fatalf("write inputs: %v", err)
+	}
+	err = run([]string{"runs", "create-batch", "--server", srv.URL, "--token", "tok", "--app", "hello", "--input-file", path})
+	if err == nil || !strings.Contains(err.Error(), ":2: each line must be a JSON object") {
+		t.Fatalf("expected line 2 rejected locally, got %v", err)
+	}
+}
+
+func TestBatchesWatchExitCodes(t *testing.T) {
+	captureOutput(t)
+
+	counts := map[string]string{
+		"ok":        `{"completed":2}`,
+		"failed":    `{"completed":1,"failed":1}`,
+		"cancelled": `{"completed":1,"cancelled":1}`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/batches/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/batches/")
+		_, _ = w.Write([]byte(`{"batch_id":"` + id + `","app_slug":"hello","total":2,"terminal":2,"percent_complete":100,"done":true,"counts":` + counts[id] + `}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	for id, want := range map[string]int{"ok": 0, "failed": 1, "cancelled": 2} {
+		err := run([]string{"batches", "watch", "--server", srv.URL, "--token", "tok", id})
+		code := 0
+		var ee *exitError
+		if errors.As(err, &ee) {
+			code = ee.Code
+		} else if err != nil {
+			t.Fatalf("%s: unexpected error %v", id, err)
+		}
+		if code != want {
+			t.Fatalf("%s: expected exit %d, got %d", id, want, code)
+		}
+	}
+}
+
+func TestDeployPrintsTowerfileWarnings(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	uploaded := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/apps/hello", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"app_id":1,"slug":"hello"}`))
+	})
+	mux.HandleFunc("/api/v1/apps/hello/versions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"versions":[]}`))
+			return
+		}
+		uploaded = true
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"version_id":3,"version_no":2,"entrypoint":"main.py","artifact_sha256":"abc","towerfile_schema_version":1}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	writeFile("main.py", "print('hi')\n")
+	writeFile("Towerfile", "[app]\nname = \"hello\"\nscript = \"main.py\"\nmemory_mb = 512\n")
+
+	if err := run([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir, "--json"}); err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if !uploaded {
+		t.Fatal("expected the version to be uploaded despite the unknown key")
+	}
+	if !strings.Contains(stderr.String(), `warning: unknown Towerfile key "app.memory_mb" is ignored`) {
+		t.Fatalf("expected unknown key warning on stderr, got %q", stderr.String())
+	}
+	var result struct {
+		Warnings []string `json:"warnings"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil || len(result.Warnings) != 1 {
+		t.Fatalf("expected one warning in JSON result, got %q (%v)", stdout.String(), err)
+	}
+
+	// Keys from a newer schema version than declared stop the deploy.
+	resetOutput(stdout, stderr)
+	uploaded = false
+	writeFile("setup.sh", "true\n")
+	writeFile("Towerfile", "[app]\nname = \"hello\"\nscript = \"main.py\"\nsetup = \"setup.sh\"\n")
+	err := run([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir})
+	if err == nil || !strings.Contains(err.Error(), "requiring schema_version >= 2") || uploaded {
+		t.Fatalf("expected schema_version error before upload, got %v (uploaded=%t)", err, uploaded)
+	}
+}
+
+func TestVersionsUploadSendsExpectedSHA256(t *testing.T) {
+	_, stderr := captureOutput(t)
+
+	var got []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/apps/hello/versions", func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.FormValue("expected_sha256"))
+		if r.FormValue("expected_sha256") == "bad" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error":{"code":"sha256_mismatch","message":"artifact sha256 is abc, expected bad","expected_sha256":"bad","actual_sha256":"abc"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"version_id":3,"version_no":2,"entrypoint":"main.py","artifact_sha256":"abc","artifact_size_bytes":4}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	file := filepath.Join(t.TempDir(), "app.tar.gz")
+	if err := os.WriteFile(file, []byte("data"), 0o600); err != nil {
+		t.Fatalf("write artifact: %v", err)
+	}
+	base := []string{"versions", "upload", "--server", srv.URL, "--token", "tok", "--app", "hello", "--file", file}
+	if err := run(append(base, "--expected-sha256", "abc")); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if err := run(base); err != nil {
+		t.Fatalf("upload without hash: %v", err)
+	}
+	err := run(append(base, "--expected-sha256", "bad"))
+	if err == nil || !strings.Contains(err.Error(), "artifact sha256 is abc, expected bad") {
+		t.Fatalf("expected the mismatch to be reported, got %v", err)
+	}
+	if len(got) != 3 || got[0] != "abc" || got[1] != "" || got[2] != "bad" {
+		t.Fatalf("unexpected expected_sha256 fields %q", got)
+	}
+	if !strings.Contains(stderr.String(), "Uploaded version 2") {
+		t.Fatalf("expected upload confirmation, got %q", stderr.String())
+	}
+}
+
+func TestDeployCompatibilityWarnings(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/apps/hello", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"app_id":1,"slug":"hello"}`))
+	})
+	mux.HandleFunc("/api/v1/apps/hello/versions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"versions":[]}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"version_id":3,"version_no":2,"entrypoint":"main.py","artifact_sha256":"abc","compatibility_warnings":[{"kind":"removed","parameter":"region","message":"parameter \"region\" was removed"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('hi')\n"), 0o600); err != nil {
+		t.Fatalf("write main.py: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Towerfile"), []byte("[app]\nname = \"hello\"\nscript = \"main.py\"\n"), 0o600); err != nil {
+		t.Fatalf("write Towerfile: %v", err)
+	}
+
+	// Warnings are printed, even under --quiet, but do not fail the deploy.
+	if err := run([]string{"--quiet", "deploy", "--server", srv.URL, "--token", "tok", "--dir", dir}); err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	want := "WARNING: this version may break input that worked with the previous version:\n  - parameter \"region\" was removed\n"
+	if stderr.String() != want {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+
+	resetOutput(stdout, stderr)
+	err := run([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir, "--json", "--fail-on-incompatible"})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Code != 1 || !strings.Contains(ee.Message, "version 2 was uploaded with 1 compatibility warnings") {
+		t.Fatalf("expected --fail-on-incompatible to fail, got %v", err)
+	}
+	var result deployResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil || len(result.Version.CompatibilityWarnings) != 1 {
+		t.Fatalf("expected the JSON result before the failure, got %q (%v)", stdout.String(), err)
+	}
+}
+
+// newDeployServer serves an app "hello" that exists when exists is set and
+// keeps the versions uploaded to it, hashing each artifact like the server.
+func newDeployServer(t *testing.T, exists bool) (*httptest.Server, *[]versionResponse, *int) {
+	t.Helper()
+	var versions []versionResponse
+	writes := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/apps", func(w http.ResponseWriter, _ *http.Request) {
+		writes++
+		exists = true
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"app_id":1,"slug":"hello"}`))
+	})
+	mux.HandleFunc("/api/v1/apps/hello", func(w http.ResponseWriter, _ *http.Request) {
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":"not_found","message":"app not found"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"app_id":1,"slug":"hello"}`))
+	})
+	mux.HandleFunc("/api/v1/apps/hello/versions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			// Newest first, like the server.
+			list := listVersionsResponse{Versions: []versionResponse{}}
+			for i := len(versions) - 1; i >= 0; i-- {
+				list.Versions = append(list.Versions, versions[i])
+			}
+			_ = json.NewEncoder(w).Encode(list)
+			return
+		}
+		writes++
+		file, _, err := r.FormFile("artifact")
+		if err != nil {
+			t.Errorf("read artifact: %v", err)
+			return
+		}
+		defer file.Close()
+		hash := sha256.New()
+		_, _ = io.Copy(hash, file)
+		if got := r.FormValue("expected_sha256"); got != hex.EncodeToString(hash.Sum(nil)) {
+			t.Errorf("expected deploy to send the packaged sha256, got %q", got)
+		}
+		v := versionResponse{VersionID: int64(len(versions) + 10), VersionNo: int64(len(versions) + 1), Entrypoint: "main.py", ArtifactSHA256: hex.EncodeToString(hash.Sum(nil))}
+		versions = append(versions, v)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(v)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &versions, &writes
+}
+
+func TestDeploySkipsUnchangedArtifact(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	srv, versions, writes := newDeployServer(t, true)
+
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	writeFile("main.py", "print('hi')\n")
+	writeFile("Towerfile", "[app]\nname = \"hello\"\nscript = \"main.py\"\n")
+	deploy := func(extra ...string) deployResult {
+		t.Helper()
+		resetOutput(stdout, stderr)
+		args := append([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir, "--json"}, extra...)
+		if err := run(args); err != nil {
+			t.Fatalf("deploy %v: %v", extra, err)
+		}
+		var result deployResult
+		if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+			t.Fatalf("decode result %q: %v", stdout.String(), err)
+		}
+		return result
+	}
+
+	first := deploy()
+	if first.Unchanged || first.Version == nil || first.Version.VersionNo != 1 || len(*versions) != 1 {
+		t.Fatalf("expected version 1 uploaded, got %+v", first)
+	}
+	if first.FileCount != 2 || first.Version.ArtifactSHA256 != first.PackagedSHA {
+		t.Fatalf("unexpected first deploy %+v", first)
+	}
+
+	unchanged := deploy()
+	if !unchanged.Unchanged || unchanged.Version == nil || unchanged.Version.VersionNo != 1 || *writes != 1 {
+		t.Fatalf("expected the upload skipped with version 1 reported, got %+v", unchanged)
+	}
+
+	forced := deploy("--force")
+	if forced.Unchanged || forced.Version.VersionNo != 2 || len(*versions) != 2 {
+		t.Fatalf("expected --force to upload version 2, got %+v", forced)
+	}
+	if noSkip := deploy("--skip-unchanged=false"); noSkip.Unchanged || noSkip.Version.VersionNo != 3 {
+		t.Fatalf("expected --skip-unchanged=false to upload version 3, got %+v", noSkip)
+	}
+
+	writeFile("main.py", "print('changed')\n")
+	changed := deploy()
+	if changed.Unchanged || changed.Version.VersionNo != 4 {
+		t.Fatalf("expected a changed artifact to upload version 4, got %+v", changed)
+	}
+
+	// The text output reports the skipped upload.
+	resetOutput(stdout, stderr)
+	if err := run([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir}); err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	if want := fmt.Sprintf("No changes since version 4 (sha256:%s)", shortenSHA(changed.PackagedSHA)); !strings.Contains(stderr.String(), want) {
+		t.Fatalf("expected %q, got %q", want, stderr.String())
+	}
+}
+
+func TestDeploySyncsAppDescription(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	var description *string
+	exists := true
+	var patches, created []map[string]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/apps", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		created = append(created, body)
+		exists = true
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"app_id":1,"slug":"hello"}`))
+	})
+	mux.HandleFunc("/api/v1/apps/hello", func(w http.ResponseWriter, r *http.Request) {
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":"not_found","message":"app not found"}}`))
+			return
+		}
+		if r.Method == http.MethodPatch {
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			patches = append(patches, body)
+			d := body["description"]
+			description = &d
+		}
+		_ = json.NewEncoder(w).Encode(appResponse{AppID: 1, Slug: "hello", Description: description})
+	})
+	mux.HandleFunc("/api/v1/apps/hello/versions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"versions":[]}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"version_id":1,"version_no":1,"artifact_sha256":"abc","parameters":[{"name":"region","type":"string"},{"name":"batch_size","type":"integer"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('hi')\n"), 0o600); err != nil {
+		t.Fatalf("write main.py: %v", err)
+	}
+	deploy := func(towerfileDescription string) {
+		t.Helper()
+		tf := "[app]\nname = \"hello\"\nscript = \"main.py\"\n"
+		if towerfileDescription != "" {
+			tf += "description = \"" + towerfileDescription + "\"\n"
+		}
+		if err := os.WriteFile(filepath.Join(dir, "Towerfile"), []byte(tf), 0o600); err != nil {
+			t.Fatalf("write Towerfile: %v", err)
+		}
+		resetOutput(stdout, stderr)
+		if err := run([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir}); err != nil {
+			t.Fatalf("deploy: %v", err)
+		}
+	}
+
+	deploy("Nightly ETL")
+	if len(patches) != 1 || patches[0]["description"] != "Nightly ETL" {
+		t.Fatalf("expected one description patch, got %v", patches)
+	}
+	for _, want := range []string{"Updated app description\n", "Parameters: region (string), batch_size (integer)\n"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Fatalf("expected %q, got %q", want, stderr.String())
+		}
+	}
+
+	// An unchanged description, or none in the Towerfile, is not patched.
+	deploy("Nightly ETL")
+	deploy("")
+	if len(patches) != 1 || strings.Contains(stderr.String(), "Updated app description") {
+		t.Fatalf("expected no further patches, got %v (%q)", patches, stderr.String())
+	}
+
+	// A new app is created with the description instead.
+	exists, description = false, nil
+	deploy("Fresh app")
+	if len(created) != 1 || created[0]["description"] != "Fresh app" || len(patches) != 1 {
+		t.Fatalf("expected the app created with its description, got created=%v patches=%v", created, patches)
+	}
+}
+
+func TestDeployAndUploadRefuseArtifactOverServerLimit(t *testing.T) {
+	_, _ = captureOutput(t)
+	limitRequests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/meta/limits", func(w http.ResponseWriter, _ *http.Request) {
+		limitRequests++
+		_, _ = w.Write([]byte(`{"max_artifact_bytes":64,"max_request_body_bytes":1024,"max_log_line_bytes":8192,"max_batch_runs":500}`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('hi')\n"), 0o600); err != nil {
+		t.Fatalf("write main.py: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Towerfile"), []byte("[app]\nname = \"hello\"\nscript = \"main.py\"\n"), 0o600); err != nil {
+		t.Fatalf("write Towerfile: %v", err)
+	}
+
+	var ee *exitError
+	err := run([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir})
+	if !errors.As(err, &ee) || !strings.Contains(ee.Message, "over the server's limit of 64B (64 bytes)") || !strings.Contains(ee.Message, "source patterns") {
+		t.Fatalf("expected deploy to refuse the artifact, got %v", err)
+	}
+
+	artifact := filepath.Join(t.TempDir(), "artifact.tar.gz")
+	if err := os.WriteFile(artifact, bytes.Repeat([]byte("x"), 100), 0o600); err != nil {
+		t.Fatalf("write artifact: %v", err)
+	}
+	err = run([]string{"versions", "upload", "--server", srv.URL, "--token", "tok", "--app", "hello", "--file", artifact})
+	if !errors.As(err, &ee) || !strings.Contains(ee.Message, "artifact is 100B (100 bytes), over the server's limit of 64B (64 bytes)") {
+		t.Fatalf("expected versions upload to refuse the artifact, got %v", err)
+	}
+	if limitRequests != 1 {
+		t.Fatalf("expected the limits to be fetched once and then cached, got %d requests", limitRequests)
+	}
+}
+
+func TestDeployPlanMakesNoWrites(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	srv, _, writes := newDeployServer(t, false)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('hi')\n"), 0o600); err != nil {
+		t.Fatalf("write main.py: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Towerfile"), []byte("[app]\nname = \"hello\"\nscript = \"main.py\"\n"), 0o600); err != nil {
+		t.Fatalf("write Towerfile: %v", err)
+	}
+
+	if err := run([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir, "--plan"}); err != nil {
+		t.Fatalf("deploy --plan: %v", err)
+	}
+	if *writes != 0 {
+		t.Fatalf("expected no writes for --plan, got %d", *writes)
+	}
+	for _, want := range []string{"  app:      create\n", "  version:  first version\n", "  artifact: 2 files, "} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("expected %q in plan, got %q", want, stdout.String())
+		}
+	}
+
+	// Once deployed, the plan reports the version as unchanged.
+	resetOutput(stdout, stderr)
+	if err := run([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir, "--json"}); err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+	resetOutput(stdout, stderr)
+	before := *writes
+	if err := run([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir, "--plan", "--json"}); err != nil {
+		t.Fatalf("deploy --plan --json: %v", err)
+	}
+	var plan deployResult
+	if err := json.Unmarshal(stdout.Bytes(), &plan); err != nil {
+		t.Fatalf("decode plan: %v", err)
+	}
+	if !plan.Planned || plan.AppCreated || !plan.Unchanged || plan.Version == nil || plan.Version.VersionNo != 1 || *writes != before {
+		t.Fatalf("unexpected plan %+v (writes %d -> %d)", plan, before, *writes)
+	}
+}
+
+func TestDeployFailsOnInvalidArtifact(t *testing.T) {
+	captureOutput(t)
+	uploads := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/apps/hello", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"app_id":1,"slug":"hello"}`))
+	})
+	mux.HandleFunc("/api/v1/apps/hello/versions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"versions":[]}`))
+			return
+		}
+		uploads++
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"code":"invalid_artifact","message":"entrypoint main.py not present in archive"}}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('hi')\n"), 0o600); err != nil {
+		t.Fatalf("write main.py: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Towerfile"), []byte("[app]\nname = \"hello\"\nscript = \"main.py\"\n"), 0o600); err != nil {
+		t.Fatalf("write Towerfile: %v", err)
+	}
+
+	err := run([]string{"deploy", "--server", srv.URL, "--token", "tok", "--dir", dir})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.APICode != "invalid_artifact" || ee.Message != "entrypoint main.py not present in archive" {
+		t.Fatalf("expected the invalid_artifact reason, got %v", err)
+	}
+	if uploads != 1 {
+		t.Fatalf("expected one upload attempt, got %d", uploads)
+	}
+}
+
+// newWatchServer serves run 9 as running for two polls with a batch of logs
+// each, then as completed.
+func newWatchServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/runs/9", func(w http.ResponseWriter, _ *http.Request) {
+		polls++
+		if polls < 3 {
+			_, _ = w.Write([]byte(`{"run_id":9,"status":"running","queued_at":"2026-01-02T03:04:00Z","started_at":"2026-01-02T03:04:05Z","attempt_no":1}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"run_id":9,"status":"completed","queued_at":"2026-01-02T03:04:00Z","started_at":"2026-01-02T03:04:05Z","finished_at":"2026-01-02T03:05:12Z","attempt_no":1,"exit_code":0}`))
+	})
+	mux.HandleFunc("/api/v1/runs/9/logs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("after_seq") {
+		case "0":
+			_, _ = w.Write([]byte(`{"logs":[{"seq":1,"stream":"stdout","line":"a","logged_at":"2026-01-02T03:04:06.250Z"},{"seq":2,"stream":"stdout","line":"b","logged_at":"2026-01-02T03:04:07Z"}]}`))
+		case "2":
+			_, _ = w.Write([]byte(`{"logs":[{"seq":3,"stream":"stderr","line":"c","logged_at":"2026-01-02T03:04:08Z"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"logs":[]}`))
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// renderTerminal replays output the way a terminal shows it: a carriage
+// return moves back to the start of the row and later text overwrites it.
+// It returns the visible text of each row, trailing blanks removed.
+func renderTerminal(out string) []string {
+	var rows []string
+	var row []rune
+	col := 0
+	for _, r := range out {
+		switch r {
+		case '\r':
+			col = 0
+		case '\n':
+			rows = append(rows, strings.TrimRight(string(row), " "))
+			row, col = nil, 0
+		default:
+			if col < len(row) {
+				row[col] = r
+			} else {
+				row = append(row, r)
+			}
+			col++
+		}
+	}
+	if rest := strings.TrimRight(string(row), " "); rest != "" {
+		rows = append(rows, rest)
+	}
+	return rows
+}
+
+// localLogTime formats an RFC 3339 time the way log lines show it.
+func localLogTime(t *testing.T, s string) string {
+	t.Helper()
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		t.Fatalf("parse %s: %v", s, err)
+	}
+	return ts.Local().Format(logTimeLayout)
+}
+
+func TestRunsWatchStatusLineOnTerminal(t *testing.T) {
+	stdout, _ := captureOutput(t)
+	t.Setenv("NO_COLOR", "")
+	prev := stdoutIsTerminal
+	stdoutIsTerminal = func() bool { return true }
+	t.Cleanup(func() { stdoutIsTerminal = prev })
+
+	srv := newWatchServer(t)
+	if err := run([]string{"runs", "watch", "--server", srv.URL, "--token", "tok", "--interval", "1ms", "9"}); err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "\r") || !strings.Contains(stdout.String(), "run 9 running") {
+		t.Fatalf("expected a redrawn status line, got %q", stdout.String())
+	}
+	want := []string{
+		localLogTime(t, "2026-01-02T03:04:06.250Z") + "  a",
+		localLogTime(t, "2026-01-02T03:04:07Z") + "  b",
+		localLogTime(t, "2026-01-02T03:04:08Z") + "  " + ansiRed + "c" + ansiReset,
+		"run 9 completed in 1m07s (exit code 0)",
+	}
+	if got := renderTerminal(stdout.String()); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected status line cleared around logs, rendered:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestRunsWatchOutputUnchangedWhenRedirected(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	srv := newWatchServer(t)
+	if err := run([]string{"runs", "watch", "--server", srv.URL, "--token", "tok", "--interval", "1ms", "9"}); err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	want := "run 9 status: running\n" +
+		localLogTime(t, "2026-01-02T03:04:06.250Z") + "  a\n" +
+		localLogTime(t, "2026-01-02T03:04:07Z") + "  b\n" +
+		localLogTime(t, "2026-01-02T03:04:08Z") + "  c\n" +
+		"run 9 status: completed\n"
+	if stdout.String() != want || stderr.Len() != 0 {
+		t.Fatalf("unexpected redirected output %q (stderr %q)", stdout.String(), stderr.String())
+	}
+}
+
+func TestRunsWatchAndFollowStopOnDeadRun(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	var polls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/runs/9", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		switch polls {
+		case 1:
+			_, _ = w.Write([]byte(`{"run_id":9,"status":"queued","queued_at":"2026-01-02T03:04:00Z"}`))
+		case 2:
+			_, _ = w.Write([]byte(`{"run_id":9,"status":"leased","queued_at":"2026-01-02T03:04:00Z","attempt_no":1}`))
+		default:
+			_, _ = w.Write([]byte(`{"run_id":9,"status":"dead","dead_reason":"max_retries_exceeded","queued_at":"2026-01-02T03:04:00Z","finished_at":"2026-01-02T03:09:00Z","attempt_no":1}`))
+		}
+	})
+	mux.HandleFunc("/api/v1/runs/9/logs", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"logs":[]}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	err := run([]string{"runs", "watch", "--server", srv.URL, "--token", "tok", "--interval", "1ms", "9"})
+	var exitErr *exitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 4 {
+		t.Fatalf("expected exit code 4 for a dead run, got %v", err)
+	}
+	want := "run 9 status: queued\n" +
+		"run 9 status: leased\n" +
+		"run 9 status: dead (max_retries_exceeded)\n"
+	if stdout.String() != want || polls != 3 {
+		t.Fatalf("expected watch to stop at the dead run after 3 polls, got %d polls and %q (stderr %q)", polls, stdout.String(), stderr.String())
+	}
+
+	polls = 0
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "logs", "--server", srv.URL, "--token", "tok", "--interval", "1ms", "--follow", "9"}); err != nil {
+		t.Fatalf("logs --follow: %v", err)
+	}
+	if polls != 3 {
+		t.Fatalf("expected logs --follow to stop at the dead run after 3 polls, got %d", polls)
+	}
+}
+
+func TestFormatWatchSummary(t *testing.T) {
+	started, finished := "2026-01-02T03:04:05Z", "2026-01-02T04:06:07Z"
+	exitCode := 3
+	cases := []struct {
+		run  runResponse
+		want string
+	}{
+		{runResponse{RunID: 1, Status: "failed", QueuedAt: "2026-01-02T03:04:00Z", StartedAt: &started, FinishedAt: &finished, ExitCode: &exitCode}, "run 1 failed in 1h02m02s (exit code 3)"},
+		{runResponse{RunID: 2, Status: "cancelled", QueuedAt: "2026-01-02T04:05:55Z", FinishedAt: &finished}, "run 2 cancelled in 12s"},
+		{runResponse{RunID: 3, Status: "dead", QueuedAt: "bad", FinishedAt: &finished}, "run 3 dead"},
+		{runResponse{RunID: 5, Status: "dead", DeadReason: "artifact_unavailable", QueuedAt: "2026-01-02T04:05:07Z", FinishedAt: &finished}, "run 5 dead in 1m00s (artifact_unavailable)"},
+	}
+	for _, tc := range cases {
+		if got := formatWatchSummary(tc.run); got != tc.want {
+			t.Fatalf("expected %q, got %q", tc.want, got)
+		}
+	}
+
+	now, _ := time.Parse(time.RFC3339, "2026-01-02T03:06:10Z")
+	got := formatWatchStatus(runResponse{RunID: 4, Status: "running", QueuedAt: "2026-01-02T03:04:00Z", StartedAt: &started, AttemptNo: 2}, now, 17, 1)
+	if want := "/ run 4 running  2m05s since started  attempt 2  17 lines"; got != want {
+		t.Fatalf("expected status %q, got %q", want, got)
+	}
+}
+
+func TestReportsUsageTableAndCSV(t *testing.T) {
+	var gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/reports/usage" {
+			http.NotFound(w, r)
+			return
+		}
+		gotQuery = r.URL.RawQuery
+		_, _ = w.Write([]byte(`{"from":"2024-05-01T00:00:00Z","to":"2024-06-01T00:00:00Z","group_by":"app","rows":[{"group":"etl","runs":3,"completed":1,"failed":1,"total_execution_seconds":90,"total_queue_seconds":35.5}]}`))
+	}))
+	defer srv.Close()
+
+	stdout, stderr := captureOutput(t)
+	if err := run([]string{"reports", "usage", "--server", srv.URL, "--token", "tok", "--from", "2024-05-01", "--to", "2024-06-01", "--group-by", "app"}); err != nil {
+		t.Fatalf("reports usage: %v", err)
+	}
+	if gotQuery != "from=2024-05-01&group_by=app&to=2024-06-01" {
+		t.Fatalf("query = %q", gotQuery)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "GROUP") || strings.Join(strings.Fields(lines[1]), " ") != "etl 3 1 1 90.000 35.500" {
+		t.Fatalf("unexpected table:\n%s", stdout.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"reports", "usage", "--server", srv.URL, "--token", "tok", "--from", "2024-05-01", "--to", "2024-06-01", "--csv"}); err != nil {
+		t.Fatalf("reports usage --csv: %v", err)
+	}
+	want := "group,runs,completed,failed,execution_seconds,queue_seconds\netl,3,1,1,90.000,35.500\n"
+	if stdout.String() != want {
+		t.Fatalf("csv = %q, want %q", stdout.String(), want)
+	}
+
+	resetOutput(stdout, stderr)
+	err := run([]string{"reports", "usage", "--server", srv.URL, "--token", "tok", "--from", "2024-05-01", "--to", "2024-06-01", "--csv", "--json"})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Code != 1 {
+		t.Fatalf("expected usage error for --csv with --json, got %v", err)
+	}
+}
+
+func TestAuditListSinceDuration(t *testing.T) {
+	var gotQuery url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/audit" {
+			http.NotFound(w, r)
+			return
+		}
+		gotQuery = r.URL.Query()
+		_, _ = w.Write([]byte(`{"entries":[{"id":3,"action":"run.cancel","resource_type":"run","resource_id":"42","token_id":7,"details":{"status":"cancelled"},"created_at":"2026-01-02T03:04:05Z"}]}`))
+	}))
+	defer srv.Close()
+
+	stdout, _ := captureOutput(t)
+	before := time.Now().Add(-24 * time.Hour).Add(-time.Second)
+	if err := run([]string{"audit", "list", "--server", srv.URL, "--token", "tok", "--since", "24h", "--action", "run.cancel"}); err != nil {
+		t.Fatalf("audit list: %v", err)
+	}
+	since, err := time.Parse(time.RFC3339, gotQuery.Get("since"))
+	if err != nil || since.Before(before) || since.After(time.Now().Add(-24*time.Hour)) {
+		t.Fatalf("since = %q (%v), want about 24h ago", gotQuery.Get("since"), err)
+	}
+	if gotQuery.Get("action") != "run.cancel" || gotQuery.Has("until") || gotQuery.Has("token_id") {
+		t.Fatalf("unexpected query: %v", gotQuery)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[1]), " ") != "2026-01-02T03:04:05Z run.cancel run/42 7 status=cancelled" {
+		t.Fatalf("unexpected table:\n%s", stdout.String())
+	}
+
+	if err := run([]string{"audit", "list", "--server", srv.URL, "--token", "tok", "--since", "2024-05-01"}); err != nil {
+		t.Fatalf("audit list by date: %v", err)
+	}
+	if gotQuery.Get("since") != "2024-05-01" {
+		t.Fatalf("date since = %q", gotQuery.Get("since"))
+	}
+}
+
+func TestRunsOutputsListAndDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/runs/7/outputs":
+			_, _ = w.Write([]byte(`{"outputs":[{"name":"report.csv","size_bytes":8,"sha256":"abc","created_at":"2026-01-02T03:04:09Z"}]}`))
+		case "/api/v1/runs/7/outputs/report.csv":
+			_, _ = w.Write([]byte("a,b\n1,2\n"))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":"not_found","message":"output not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	stdout, stderr := captureOutput(t)
+	if err := run([]string{"runs", "outputs", "--server", srv.URL, "--token", "tok", "7"}); err != nil {
+		t.Fatalf("runs outputs: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[1]), " ") != "report.csv 8B abc 2026-01-02T03:04:09Z" {
+		t.Fatalf("unexpected table:\n%s", stdout.String())
+	}
+
+	dest := filepath.Join(t.TempDir(), "out.csv")
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "outputs", "download", "--server", srv.URL, "--token", "tok", "--out", dest, "7", "report.csv"}); err != nil {
+		t.Fatalf("runs outputs download: %v", err)
+	}
+	if data, err := os.ReadFile(dest); err != nil || string(data) != "a,b\n1,2\n" {
+		t.Fatalf("downloaded %q (%v)", data, err)
+	}
+	if !strings.HasPrefix(stderr.String(), "Saved report.csv (8B) to ") {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "outputs", "download", "--server", srv.URL, "--token", "tok", "--out", "-", "7", "report.csv"}); err != nil {
+		t.Fatalf("runs outputs download to stdout: %v", err)
+	}
+	if stdout.String() != "a,b\n1,2\n" {
+		t.Fatalf("stdout = %q", stdout.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "outputs", "download", "--server", srv.URL, "--token", "tok", "7", "missing.csv"}); err == nil {
+		t.Fatal("expected an error for a missing output")
+	}
+}
+
+func TestRunsEventsRendersTimeline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/runs/7/events" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"run_id":7,"events":[
+			{"kind":"queued","at":"2026-01-02T03:04:05Z","details":{"priority":0}},
+			{"kind":"leased","at":"2026-01-02T03:04:05.25Z","details":{"runner":"r1","runner_id":3,"attempt_no":1}},
+			{"kind":"started","at":"2026-01-02T03:04:07.65Z","details":{"attempt_no":1}},
+			{"kind":"finished","at":"2026-01-02T03:07:14.65Z","details":{"status":"failed","error":"exit status 1"}}
+		]}`))
+	}))
+	defer srv.Close()
+
+	stdout, _ := captureOutput(t)
+	if err := run([]string{"runs", "events", "--server", srv.URL, "--token", "tok", "7"}); err != nil {
+		t.Fatalf("runs events: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	want := []string{
+		"AT DELTA EVENT DETAILS",
+		"2026-01-02T03:04:05Z queued priority=0",
+		"2026-01-02T03:04:05.25Z +250ms leased attempt_no=1 runner=r1 runner_id=3",
+		"2026-01-02T03:04:07.65Z +2.4s started attempt_no=1",
+		`2026-01-02T03:07:14.65Z +3m07s finished error="exit status 1" status=failed`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("unexpected table:\n%s", stdout.String())
+	}
+	for i, line := range lines {
+		if got := strings.Join(strings.Fields(line), " "); got != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestRunsAttemptsListsAttemptsAndEnvSnapshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/runs/7/attempts" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"run_id":7,"attempts":[
+			{"attempt_id":11,"attempt_no":1,"runner_id":3,"status":"failed","exit_code":1,"started_at":"2026-01-02T03:04:05Z","finished_at":"2026-01-02T03:05:05Z","created_at":"2026-01-02T03:04:00Z","env_snapshot":{"region":"eu-west-1","API_TOKEN":"***"}},
+			{"attempt_id":12,"attempt_no":2,"runner_id":4,"status":"leased","created_at":"2026-01-02T03:06:00Z"}
+		]}`))
+	}))
+	defer srv.Close()
+
+	stdout, stderr := captureOutput(t)
+	if err := run([]string{"runs", "attempts", "--server", srv.URL, "--token", "tok", "7"}); err != nil {
+		t.Fatalf("runs attempts: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	want := []string{
+		"ATTEMPT RUNNER_ID STATUS EXIT_CODE STARTED_AT FINISHED_AT ENV_VARS",
+		"1 3 failed 1 2026-01-02T03:04:05Z 2026-01-02T03:05:05Z 2",
+		"2 4 leased - - - -",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("unexpected table:\n%s", stdout.String())
+	}
+	for i, line := range lines {
+		if got := strings.Join(strings.Fields(line), " "); got != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got, want[i])
+		}
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "attempts", "--server", srv.URL, "--token", "tok", "--json", "7"}); err != nil {
+		t.Fatalf("runs attempts --json: %v", err)
+	}
+	var resp runAttemptsResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		t.Fatalf("decode json output: %v", err)
+	}
+	if len(resp.Attempts) != 2 || resp.Attempts[0].EnvSnapshot["API_TOKEN"] != "***" {
+		t.Fatalf("unexpected json output %s", stdout.String())
+	}
+}
+
+func TestRunnersRegisterAndRotateTokenPrintTokenOnStdout(t *testing.T) {
+	var gotPath string
+	var gotBody map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotBody = nil
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		status := http.StatusOK
+		if r.URL.Path == "/api/v1/admin/runners" {
+			status = http.StatusCreated
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"runner_id":5,"name":"gpu-1","environment":"gpu","token":"mtr_secret"}`))
+	}))
+	defer srv.Close()
+
+	stdout, stderr := captureOutput(t)
+	if err := run([]string{"runners", "register", "--server", srv.URL, "--token", "tok", "--environment", "gpu", "gpu-1"}); err != nil {
+		t.Fatalf("runners register: %v", err)
+	}
+	if gotPath != "/api/v1/admin/runners" || gotBody["name"] != "gpu-1" || gotBody["environment"] != "gpu" {
+		t.Fatalf("unexpected request %s %v", gotPath, gotBody)
+	}
+	if stdout.String() != "mtr_secret\n" {
+		t.Fatalf("expected only the token on stdout, got %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "id=5") || !strings.Contains(stderr.String(), "MINITOWER_RUNNER_TOKEN") {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runners", "rotate-token", "--server", srv.URL, "--token", "tok", "5"}); err != nil {
+		t.Fatalf("runners rotate-token: %v", err)
+	}
+	if gotPath != "/api/v1/admin/runners/5/rotate-token" || stdout.String() != "mtr_secret\n" {
+		t.Fatalf("unexpected rotate %s, stdout %q", gotPath, stdout.String())
+	}
+
+	var ee *exitError
+	if err := run([]string{"runners", "rotate-token", "--server", srv.URL, "--token", "tok", "gpu-1"}); !errors.As(err, &ee) {
+		t.Fatalf("expected usage error for a non-numeric id, got %v", err)
+	}
+}
+
+func TestRunsRerunSendsOverridesAndPrintsChanges(t *testing.T) {
+	var gotPath string
+	var gotBody map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"run":{"run_id":43,"run_no":8,"version_no":15,"status":"queued","rerun_of_run_id":42},"input_changes":[{"path":"fix_mode","kind":"changed","before":false,"after":true},{"path":"opts.depth","kind":"added","after":3}]}`))
+	}))
+	defer srv.Close()
+
+	_, stderr := captureOutput(t)
+	if err := run([]string{"runs", "rerun", "42", "--server", srv.URL, "--token", "tok", "--set", "fix_mode=true", "--set", "opts.depth=3", "--set", "day=null", "--version", "15"}); err != nil {
+		t.Fatalf("runs rerun: %v", err)
+	}
+	if gotPath != "/api/v1/runs/42/rerun" || gotBody["version_no"] != float64(15) {
+		t.Fatalf("unexpected request %s %v", gotPath, gotBody)
+	}
+	want := map[string]any{"fix_mode": true, "opts": map[string]any{"depth": float64(3)}, "day": nil}
+	if !reflect.DeepEqual(gotBody["input_overrides"], want) {
+		t.Fatalf("expected input_overrides %v, got %v", want, gotBody["input_overrides"])
+	}
+	for _, line := range []string{"Rerun created: run #8 (id=43, version 15, rerun of 42)", "~ fix_mode: false -> true", "+ opts.depth: 3"} {
+		if !strings.Contains(stderr.String(), line) {
+			t.Fatalf("expected %q in stderr, got %q", line, stderr.String())
+		}
+	}
+
+	var ee *exitError
+	if err := run([]string{"runs", "rerun", "42", "--server", srv.URL, "--token", "tok", "--set", "opts=1", "--set", "opts.depth=3"}); !errors.As(err, &ee) {
+		t.Fatalf("expected a conflicting --set to be rejected, got %v", err)
+	}
+}
+
+func TestTokensCreateSendsScopes(t *testing.T) {
+	var gotBody map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"token_id":7,"token":"tt_scoped","role":"member","scopes":["runs:create","runs:read"],"app_slug":"my-etl"}`))
+	}))
+	defer srv.Close()
+
+	stdout, stderr := captureOutput(t)
+	if err := run([]string{"tokens", "create", "--server", srv.URL, "--token", "tok", "--scope", "runs:create", "--scope", "runs:read", "--app", "my-etl"}); err != nil {
+		t.Fatalf("tokens create: %v", err)
+	}
+	if !reflect.DeepEqual(gotBody["scopes"], []any{"runs:create", "runs:read"}) || gotBody["app_slug"] != "my-etl" {
+		t.Fatalf("unexpected request body %v", gotBody)
+	}
+	if !strings.Contains(stderr.String(), "Scopes: runs:create, runs:read on app my-etl") || strings.TrimSpace(stdout.String()) != "tt_scoped" {
+		t.Fatalf("unexpected output: stdout %q stderr %q", stdout.String(), stderr.String())
+	}
+
+	var ee *exitError
+	if err := run([]string{"tokens", "create", "--server", srv.URL, "--token", "tok", "--app", "my-etl"}); !errors.As(err, &ee) {
+		t.Fatalf("expected --app without --scope to be rejected, got %v", err)
+	}
+}
+
+func TestRunsCancelAllConfirmsCount(t *testing.T) {
+	var requests []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/runs/cancel" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, body)
+		if body["dry_run"] == true {
+			_, _ = w.Write([]byte(`{"count":2,"dry_run":true,"run_ids":[4,5],"cancelled":0,"cancelling":0,"results":[]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"count":2,"cancelled":2,"cancelling":0,"results":[{"run_id":4,"previous_status":"queued","status":"cancelled"},{"run_id":5,"previous_status":"queued","status":"cancelled"}]}`))
+	}))
+	defer srv.Close()
+
+	stdout, stderr := captureOutput(t)
+	prev := confirmInput
+	t.Cleanup(func() { confirmInput = prev })
+
+	confirmInput = strings.NewReader("n\n")
+	err := run([]string{"runs", "cancel", "--server", srv.URL, "--token", "tok", "--all", "--app", "foo", "--status", "queued"})
+	if err == nil || !strings.Contains(err.Error(), "aborted") {
+		t.Fatalf("expected abort, got %v", err)
+	}
+	if len(requests) != 1 || !strings.Contains(stderr.String(), "Cancel 2 runs? [y/N]") {
+		t.Fatalf("expected only the dry run and a prompt, got %v / %q", requests, stderr.String())
+	}
+
+	requests = nil
+	resetOutput(stdout, stderr)
+	confirmInput = strings.NewReader("y\n")
+	if err := run([]string{"runs", "cancel", "--server", srv.URL, "--token", "tok", "--all", "--app", "foo", "--status", "queued"}); err != nil {
+		t.Fatalf("runs cancel --all: %v", err)
+	}
+	if len(requests) != 2 || requests[1]["confirm_count"] != float64(2) || requests[1]["app"] != "foo" || requests[1]["status"] != "queued" {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	if !strings.Contains(stderr.String(), "Cancelled 2 queued runs, asked 0 running runs to stop") {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+
+	requests = nil
+	resetOutput(stdout, stderr)
+	confirmInput = strings.NewReader("")
+	if err := run([]string{"runs", "cancel", "--server", srv.URL, "--token", "tok", "--all", "--yes"}); err != nil {
+		t.Fatalf("runs cancel --all --yes: %v", err)
+	}
+	if len(requests) != 2 || strings.Contains(stderr.String(), "[y/N]") {
+		t.Fatalf("expected no prompt with --yes, got %v / %q", requests, stderr.String())
+	}
+
+	if err := run([]string{"runs", "cancel", "--server", srv.URL, "--token", "tok", "--status", "queued", "7"}); err == nil {
+		t.Fatal("expected --status without --all to be rejected")
+	}
+}
+
+func TestVersionsLabelAndRunsCreateByLabel(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	var labelReq map[string]string
+	var runReqs []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/apps/hello/versions/3/labels", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&labelReq); err != nil {
+			t.Errorf("decode label request: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"version_id":9,"version_no":3,"entrypoint":"main.py","artifact_sha256":"abc","labels":["stable"],"created_at":"2026-01-01T00:00:00Z"}`))
+	})
+	mux.HandleFunc("/api/v1/apps/hello/runs", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode run request: %v", err)
+		}
+		runReqs = append(runReqs, req)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"run_id":5,"run_no":1,"app_id":1,"app_slug":"hello","version_no":3,"status":"queued"}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	if err := run([]string{"versions", "label", "--server", srv.URL, "--token", "tok", "--app", "hello", "3", "stable"}); err != nil {
+		t.Fatalf("versions label: %v", err)
+	}
+	if labelReq["label"] != "stable" {
+		t.Fatalf("unexpected label request %v", labelReq)
+	}
+	if !strings.Contains(stderr.String(), `Label "stable" now points at version 3 of hello`) {
+		t.Fatalf("unexpected label output %q", stderr.String())
+	}
+
+	resetOutput(stdout, stderr)
+	for _, version := range []string{"stable", "2"} {
+		if err := run([]string{"runs", "create", "--server", srv.URL, "--token", "tok", "--app", "hello", "--version", version}); err != nil {
+			t.Fatalf("runs create --version %s: %v", version, err)
+		}
+	}
+	if len(runReqs) != 2 || runReqs[0]["version_label"] != "stable" || runReqs[0]["version_no"] != nil ||
+		runReqs[1]["version_no"] != 2.0 || runReqs[1]["version_label"] != nil {
+		t.Fatalf("unexpected run requests %v", runReqs)
+	}
+
+	err := run([]string{"runs", "create", "--server", srv.URL, "--token", "tok", "--app", "hello", "--version", "0"})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Code != 1 {
+		t.Fatalf("expected exit 1 for --version 0, got %v", err)
+	}
+}
+
+func TestVersionsPromote(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	var promoteReq map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/apps/staging-app/versions/4/promote" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&promoteReq); err != nil {
+			t.Errorf("decode promote request: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"version_id":12,"version_no":7,"entrypoint":"main.py","artifact_sha256":"abc","promoted_from":{"app":"staging-app","version_no":4},"created_at":"2026-01-01T00:00:00Z"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	if err := run([]string{"versions", "promote", "--server", srv.URL, "--token", "tok", "--app", "staging-app", "--to", "prod-app", "4"}); err != nil {
+		t.Fatalf("versions promote: %v", err)
+	}
+	if promoteReq["target_app"] != "prod-app" {
+		t.Fatalf("unexpected promote request %v", promoteReq)
+	}
+	if !strings.Contains(stderr.String(), "Promoted version 4 of staging-app to version 7 of prod-app") {
+		t.Fatalf("unexpected promote output %q", stderr.String())
+	}
+
+	resetOutput(stdout, stderr)
+	err := run([]string{"versions", "promote", "--server", srv.URL, "--token", "tok", "--app", "staging-app", "4"})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Code != 1 || !strings.Contains(ee.Message, "--to") {
+		t.Fatalf("expected exit 1 without --to, got %v", err)
+	}
+}
+
+func TestRunsCreateCoercesByDefault(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"run_id":5,"run_no":1,"app_id":1,"version_no":3,"status":"queued","coerced_fields":["$.batch_size"]}`))
+	}))
+	defer srv.Close()
+
+	stdout, stderr := captureOutput(t)
+	base := []string{"runs", "create", "--server", srv.URL, "--token", "tok", "--app", "hello", "--input", `{"batch_size":"100"}`}
+	if err := run(base); err != nil {
+		t.Fatalf("runs create: %v", err)
+	}
+	if strings.Contains(stderr.String(), "Coerced") {
+		t.Fatalf("coerced fields reported without --verbose: %q", stderr.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run(append(base, "--verbose")); err != nil {
+		t.Fatalf("runs create --verbose: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Coerced input fields: $.batch_size") {
+		t.Fatalf("expected coerced fields report, got %q", stderr.String())
+	}
+
+	if err := run(append(base, "--no-coerce")); err != nil {
+		t.Fatalf("runs create --no-coerce: %v", err)
+	}
+	if err := run(append(base, "--dry-run")); err != nil {
+		t.Fatalf("runs create --dry-run: %v", err)
+	}
+	want := []string{"coerce=true", "coerce=true", "", "coerce=true&dry_run=true"}
+	if !reflect.DeepEqual(queries, want) {
+		t.Fatalf("queries = %q, want %q", queries, want)
+	}
+}
+
+func TestRunsCreateSetsTypedInputValues(t *testing.T) {
+	var payload map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload = nil
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"run_id":5,"run_no":1,"app_id":1,"version_no":3,"status":"queued"}`))
+	}))
+	defer srv.Close()
+	captureOutput(t)
+
+	err := run([]string{"runs", "create", "--server", srv.URL, "--token", "tok", "--app", "hello",
+		"--input", `{"region":"eu","options":{"depth":1,"fast":true}}`,
+		"--set", "ids=[1,2,3]", "--set", `options={"depth":2}`, "--set", "options.tags=[\"a\"]", "--set", "name=MiniTower", "--set", "region=null"})
+	if err != nil {
+		t.Fatalf("runs create: %v", err)
+	}
+	want := map[string]any{
+		"ids":     []any{1.0, 2.0, 3.0},
+		"options": map[string]any{"depth": 2.0, "fast": true, "tags": []any{"a"}},
+		"name":    "MiniTower",
+		"region":  nil,
+	}
+	if !reflect.DeepEqual(payload["input"], want) {
+		t.Fatalf("input = %v, want %v", payload["input"], want)
+	}
+
+	err = run([]string{"runs", "create", "--server", srv.URL, "--token", "tok", "--app", "hello", "--set", "ids"})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Code != 1 || !strings.Contains(ee.Message, "key=value") {
+		t.Fatalf("--set without a value: expected exit 1, got %v", err)
+	}
+}
+
+func TestRunsCreateWithStatusTokenPrintsURL(t *testing.T) {
+	var payload map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"run_id":5,"run_no":1,"app_id":1,"version_no":3,"status":"queued","status_token":"mts_abc"}`))
+	}))
+	defer srv.Close()
+
+	stdout, _ := captureOutput(t)
+	if err := run([]string{"runs", "create", "--server", srv.URL + "/", "--token", "tok", "--app", "hello", "--with-status-token"}); err != nil {
+		t.Fatalf("runs create: %v", err)
+	}
+	if payload["with_status_token"] != true {
+		t.Fatalf("expected with_status_token in the request, got %v", payload)
+	}
+	if got, want := stdout.String(), srv.URL+"/api/v1/run-status/mts_abc\n"; got != want {
+		t.Fatalf("stdout = %q, want %q", got, want)
+	}
+}
+
+func TestRunsListInputFilters(t *testing.T) {
+	_, _ = captureOutput(t)
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("input_contains")
+		_, _ = w.Write([]byte(`{"runs":[]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	err := run([]string{"runs", "list", "--server", srv.URL, "--token", "tok", "--json",
+		"--input", "customer=acme", "--input", "shard=3", "--input", "full=true", "--input", `code="7"`, "--input", "note=a=b"})
+	if err != nil {
+		t.Fatalf("runs list: %v", err)
+	}
+	if got != `{"code":"7","customer":"acme","full":true,"note":"a=b","shard":3}` {
+		t.Fatalf("unexpected input_contains %q", got)
+	}
+
+	for _, bad := range [][]string{{"--input", "customer"}, {"--input", "=acme"}, {"--input", "a=1", "--input", "a=2"}} {
+		err := run(append([]string{"runs", "list", "--server", srv.URL, "--token", "tok"}, bad...))
+		var ee *exitError
+		if !errors.As(err, &ee) || ee.Code != 1 {
+			t.Fatalf("%v: expected exit 1, got %v", bad, err)
+		}
+	}
+}
+
+func TestAppsErrors(t *testing.T) {
+	stdout, _ := captureOutput(t)
+
+	var gotPath string
+	var gotQuery url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery = r.URL.Path, r.URL.Query()
+		_, _ = w.Write([]byte(`{"app":"my-etl","since":"2026-01-01T00:00:00.000Z","total_failures":5,"errors":[` +
+			`{"fingerprint":"a1","pattern":"exit status <n>","count":3,"sample_message":"exit status 2","first_seen_at":"2026-01-01T01:00:00.000Z","last_seen_at":"2026-01-01T03:00:00.000Z","example_run_ids":[9,7,4]},` +
+			`{"fingerprint":"b2","pattern":"timeout","count":2,"sample_message":"timeout","first_seen_at":"2026-01-01T02:00:00.000Z","last_seen_at":"2026-01-01T02:30:00.000Z","example_run_ids":[8]}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	if err := run([]string{"apps", "errors", "my-etl", "--server", srv.URL, "--token", "tok", "--window", "48h"}); err != nil {
+		t.Fatalf("apps errors: %v", err)
+	}
+	if gotPath != "/api/v1/apps/my-etl/errors" || gotQuery.Get("window") != "48h" || gotQuery.Has("limit") {
+		t.Fatalf("unexpected request %s?%s", gotPath, gotQuery.Encode())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "COUNT") || !strings.Contains(lines[1], "9,7,4") || !strings.HasSuffix(lines[1], "exit status <n>") {
+		t.Fatalf("unexpected output:\n%s", stdout.String())
+	}
+	if lines[3] != "5 failures since 2026-01-01T00:00:00.000Z" {
+		t.Fatalf("unexpected summary line %q", lines[3])
+	}
+
+	err := run([]string{"apps", "errors", "my-etl", "--server", srv.URL, "--token", "tok", "--window", "a day"})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Code != 1 {
+		t.Fatalf("bad --window: expected exit 1, got %v", err)
+	}
+}
+
+func TestRunsListRunnerFilter(t *testing.T) {
+	stdout, _ := captureOutput(t)
+
+	var gotQuery url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query()
+		_, _ = w.Write([]byte(`{"runs":[{"run_id":7,"run_no":3,"app_slug":"hello","status":"failed","version_no":2,"runner_name":"gpu-03","queued_at":"2026-01-02T03:04:05.000Z"}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	before := time.Now()
+	if err := run([]string{"runs", "list", "--server", srv.URL, "--token", "tok", "--runner", "gpu-03", "--since", "1h", "--include", "runner"}); err != nil {
+		t.Fatalf("runs list: %v", err)
+	}
+	if gotQuery.Get("runner") != "gpu-03" || gotQuery.Get("include") != "runner" {
+		t.Fatalf("expected runner and include parameters, got %v", gotQuery)
+	}
+	since, err := time.Parse(time.RFC3339, gotQuery.Get("since"))
+	if err != nil || since.Before(before.Add(-time.Hour-time.Second)) || since.After(time.Now().Add(-time.Hour)) {
+		t.Fatalf("expected since an hour ago, got %q", gotQuery.Get("since"))
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "RUNNER") || !strings.Contains(lines[1], "gpu-03") {
+		t.Fatalf("expected a RUNNER column, got %q", stdout.String())
+	}
+
+	err = run([]string{"runs", "list", "--server", srv.URL, "--token", "tok", "--include", "stats"})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Code != 1 {
+		t.Fatalf("--include stats: expected exit 1, got %v", err)
+	}
+}
+
+func TestRunsListVersionFilterAndVersionsStats(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	var gotVersion string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/runs", func(w http.ResponseWriter, r *http.Request) {
+		gotVersion = r.URL.Query().Get("version_no")
+		_, _ = w.Write([]byte(`{"runs":[]}`))
+	})
+	mux.HandleFunc("/api/v1/apps/foo/versions/12/stats", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"version_no":12,"total_runs":5,"runs_by_status":{"queued":1,"completed":3,"failed":1},` +
+			`"first_run_at":"2026-01-01T00:00:00.000Z","last_run_at":"2026-01-02T00:00:00.000Z",` +
+			`"duration_avg_seconds":4.25,"duration_p50_seconds":2,"duration_p95_seconds":10}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	if err := run([]string{"runs", "list", "--server", srv.URL, "--token", "tok", "--json", "--app", "foo", "--version", "12"}); err != nil {
+		t.Fatalf("runs list: %v", err)
+	}
+	if gotVersion != "12" {
+		t.Fatalf("expected version_no=12, got %q", gotVersion)
+	}
+	for _, bad := range []string{"0", "latest"} {
+		err := run([]string{"runs", "list", "--server", srv.URL, "--token", "tok", "--version", bad})
+		var ee *exitError
+		if !errors.As(err, &ee) || ee.Code != 1 {
+			t.Fatalf("--version %s: expected exit 1, got %v", bad, err)
+		}
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"versions", "stats", "--server", srv.URL, "--token", "tok", "--app", "foo", "12"}); err != nil {
+		t.Fatalf("versions stats: %v", err)
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"Runs:      5 (completed=3 failed=1 queued=1)",
+		"Duration:  avg 4.250s, p50 2.000s, p95 10.000s",
+		"First run: 2026-01-01T00:00:00.000Z",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output, got %q", want, out)
+		}
+	}
+}
+
+func TestVersionsSchema(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	var queries []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/apps/foo/schema", func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"type":"object","properties":{"zeta":{"type":"string"},"alpha":{"type":"integer"}}}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	for _, args := range [][]string{nil, {"3"}, {"stable"}} {
+		resetOutput(stdout, stderr)
+		if err := run(append([]string{"versions", "schema", "--server", srv.URL, "--token", "tok", "--app", "foo"}, args...)); err != nil {
+			t.Fatalf("versions schema %v: %v", args, err)
+		}
+	}
+	if want := []string{"", "version_no=3", "version_label=stable"}; !reflect.DeepEqual(queries, want) {
+		t.Fatalf("expected queries %q, got %q", want, queries)
+	}
+	out := stdout.String()
+	if !strings.HasPrefix(out, "{\n  \"type\": \"object\",") || strings.Index(out, "zeta") > strings.Index(out, "alpha") {
+		t.Fatalf("expected the schema indented in server order, got %q", out)
+	}
+
+	err := run([]string{"versions", "schema", "--server", srv.URL, "--token", "tok", "--app", "foo", "0"})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Code != 1 {
+		t.Fatalf("version 0: expected exit 1, got %v", err)
+	}
+}
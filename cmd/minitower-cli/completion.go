@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// completeCommandName is the hidden command the shell scripts call back into.
+// It prints one candidate per line for the last word of its arguments.
+const completeCommandName = "__complete"
+
+// completionTimeout bounds API calls made while completing so a slow or
+// unreachable server never stalls the shell.
+const completionTimeout = 2 * time.Second
+
+// completionContext carries connection overrides typed earlier on the
+// command line to dynamic completers.
+type completionContext struct {
+	profile string
+	server  string
+	token   string
+}
+
+// flagValueCompleters complete the value of a flag, keyed by flag name.
+var flagValueCompleters = map[string]func(cc *completionContext) []string{
+	"app":     completeAppSlugs,
+	"profile": completeProfileNames,
+	"role":    func(*completionContext) []string { return []string{"admin", "member"} },
+	"scope":   func(*completionContext) []string { return []string{"runs:create", "runs:read", "runs:cancel"} },
+	"status":  func(*completionContext) []string { return runStatus },
+}
+
+func cmdCompletion(args []string) error {
+	if len(args) != 1 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli completion <bash|zsh|fish>"}
+	}
+	script, err := completionScript(args[0])
+	if err != nil {
+		return err
+	}
+	ui.printf("%s", script)
+	return nil
+}
+
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion, nil
+	case "zsh":
+		return zshCompletion, nil
+	case "fish":
+		return fishCompletion, nil
+	default:
+		return "", &exitError{Code: 1, Message: fmt.Sprintf("unsupported shell: %s (expected bash, zsh, or fish)", shell)}
+	}
+}
+
+func completeShells(*completionContext) []string {
+	return []string{"bash", "zsh", "fish"}
+}
+
+// cmdComplete never fails: completion errors would be printed into the
+// user's prompt, so it prints nothing instead.
+func cmdComplete(args []string) error {
+	for _, c := range completeWords(commandTree(), args) {
+		ui.printf("%s\n", c)
+	}
+	return nil
+}
+
+// completeWords returns candidates for the last element of words, which are
+// the command-line words after the program name.
+func completeWords(root *command, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	prior := words[:len(words)-1]
+	if cur == "=" {
+		// bash splits "--app=" into "--app" and "=".
+		prior, cur = words, ""
+	}
+
+	for len(prior) > 0 && isGlobalFlag(prior[0]) {
+		prior = prior[1:]
+	}
+
+	cmd := root
+	rest := prior
+	for len(rest) > 0 && cmd.run == nil {
+		sub := cmd.lookup(rest[0])
+		if sub == nil {
+			return nil
+		}
+		cmd, rest = sub, rest[1:]
+	}
+
+	if cmd == root && strings.HasPrefix(cur, "-") {
+		return filterPrefix(globalFlags, cur)
+	}
+	if cmd.run == nil {
+		var names []string
+		for _, sub := range cmd.subcommands {
+			if !sub.hidden {
+				names = append(names, sub.name)
+			}
+		}
+		return filterPrefix(names, cur)
+	}
+
+	cc := parseCompletionContext(rest)
+
+	// "--app=<cur>" as one word (zsh, fish).
+	if strings.HasPrefix(cur, "-") {
+		if name, value, ok := strings.Cut(strings.TrimLeft(cur, "-"), "="); ok {
+			prefix := cur[:len(cur)-len(value)]
+			var out []string
+			for _, v := range completeFlagValue(cmd, name, cc) {
+				out = append(out, prefix+v)
+			}
+			return filterPrefix(out, cur)
+		}
+	}
+
+	// "--app <cur>", or "--app = <cur>" when bash splits on '='.
+	if flagWord := valueFlagBefore(rest); flagWord != "" {
+		return filterPrefix(completeFlagValue(cmd, flagWord, cc), cur)
+	}
+
+	if strings.HasPrefix(cur, "-") {
+		var out []string
+		for _, f := range cmd.flags {
+			out = append(out, "--"+strings.TrimSuffix(f, "="))
+		}
+		return filterPrefix(out, cur)
+	}
+
+	if cmd.complete == nil {
+		return nil
+	}
+	return filterPrefix(cmd.complete(cc), cur)
+}
+
+// valueFlagBefore returns the flag name if the last word(s) of prior leave a
+// flag waiting for its value.
+func valueFlagBefore(prior []string) string {
+	if len(prior) == 0 {
+		return ""
+	}
+	last := prior[len(prior)-1]
+	if last == "=" && len(prior) >= 2 {
+		last = prior[len(prior)-2]
+	}
+	if !strings.HasPrefix(last, "-") || strings.Contains(last, "=") {
+		return ""
+	}
+	return strings.TrimLeft(last, "-")
+}
+
+func completeFlagValue(cmd *command, name string, cc *completionContext) []string {
+	takesValue := false
+	for _, f := range cmd.flags {
+		if f == name+"=" {
+			takesValue = true
+			break
+		}
+	}
+	if !takesValue {
+		return nil
+	}
+	if fn, ok := cmd.flagValues[name]; ok {
+		return fn(cc)
+	}
+	if fn, ok := flagValueCompleters[name]; ok {
+		return fn(cc)
+	}
+	return nil
+}
+
+func parseCompletionContext(words []string) *completionContext {
+	cc := &completionContext{}
+	for i := 0; i < len(words); i++ {
+		if !strings.HasPrefix(words[i], "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(words[i], "-"), "=")
+		var dst *string
+		switch name {
+		case "profile":
+			dst = &cc.profile
+		case "server":
+			dst = &cc.server
+		case "token":
+			dst = &cc.token
+		default:
+			continue
+		}
+		if !hasValue && i+1 < len(words) {
+			i++
+			value = words[i]
+			if value == "=" && i+1 < len(words) {
+				i++
+				value = words[i]
+			}
+		}
+		*dst = value
+	}
+	return cc
+}
+
+func filterPrefix(candidates []string, prefix string) []string {
+	var out []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func completeProfileNames(*completionContext) []string {
+	cfg, err := loadProfileConfig()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeAppSlugs lists apps from the API when a token is configured.
+func completeAppSlugs(cc *completionContext) []string {
+	conn, err := resolveConnection(cc.profile, cc.server, cc.token, true)
+	if err != nil {
+		return nil
+	}
+	client, err := newConnectionClient(conn)
+	if err != nil {
+		return nil
+	}
+	client.http.Timeout = completionTimeout
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	var resp listAppsResponse
+	if err := client.doJSON(ctx, http.MethodGet, "/api/v1/apps", nil, &resp); err != nil {
+		return nil
+	}
+	slugs := make([]string, 0, len(resp.Apps))
+	for _, app := range resp.Apps {
+		slugs = append(slugs, app.Slug)
+	}
+	return slugs
+}
+
+// The scripts delegate to the hidden __complete command so candidates always
+// come from the same command tree the dispatcher uses. They register both
+// minitower-cli and mt, for users who symlink or alias the shorter name.
+
+const bashCompletion = `# bash completion for minitower-cli
+_minitower_cli() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    [[ "$cur" == "=" ]] && cur=""
+    local IFS=$'\n'
+    COMPREPLY=($(compgen -W "$("${COMP_WORDS[0]}" ` + completeCommandName + ` "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null)" -- "$cur"))
+}
+complete -o default -F _minitower_cli minitower-cli mt
+`
+
+const zshCompletion = `#compdef minitower-cli mt
+_minitower_cli() {
+    local -a candidates
+    candidates=("${(@f)$("${words[1]}" ` + completeCommandName + ` "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    candidates=(${candidates:#})
+    compadd -a candidates
+}
+compdef _minitower_cli minitower-cli mt
+`
+
+const fishCompletion = `# fish completion for minitower-cli
+function __minitower_cli_complete
+    set -l args (commandline -opc)
+    set -l cmd $args[1]
+    set -e args[1]
+    set -l cur (commandline -ct)
+    $cmd ` + completeCommandName + ` $args "$cur" 2>/dev/null
+end
+complete -c minitower-cli -f -a '(__minitower_cli_complete)'
+complete -c mt -f -a '(__minitower_cli_complete)'
+`
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompleteWordsFromCommandTree(t *testing.T) {
+	t.Setenv(envCLIConfig, filepath.Join(t.TempDir(), "config.json"))
+	root := commandTree()
+
+	cases := []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{"ru"}, []string{"runs", "runners"}},
+		{[]string{"runs", "l"}, []string{"list", "logs"}},
+		{[]string{"runs", "ls", "--st"}, []string{"--status"}},
+		{[]string{"runs", "list", "--status", "fa"}, []string{"failed"}},
+		{[]string{"runs", "list", "--status", "=", "fa"}, []string{"failed"}},
+		{[]string{"runs", "list", "--status=fa"}, []string{"--status=failed"}},
+		{[]string{"runners", "list", "--status", "o"}, []string{"online", "offline"}},
+		{[]string{"runs", "list", "--json", ""}, nil},
+		{[]string{"tokens", "create", "--role", ""}, []string{"admin", "member"}},
+		{[]string{"completion", "z"}, []string{"zsh"}},
+		{[]string{"--q"}, []string{"--quiet"}},
+		{[]string{"--quiet", "runs", "l"}, []string{"list", "logs"}},
+		{[]string{"config", "set", "--out"}, []string{"--output"}},
+		{[]string{"nope", ""}, nil},
+	}
+	for _, tc := range cases {
+		got := completeWords(root, tc.words)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%q: expected %v, got %v", tc.words, tc.want, got)
+		}
+	}
+}
+
+func TestCompleteProfileNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv(envCLIConfig, path)
+	if err := saveProfileConfig(&profileConfig{Profiles: map[string]*profile{
+		"staging": {},
+		"prod":    {},
+	}}); err != nil {
+		t.Fatalf("save config: %v", err)
+	}
+
+	got := completeWords(commandTree(), []string{"config", "use", ""})
+	if strings.Join(got, ",") != "prod,staging" {
+		t.Fatalf("unexpected profiles: %v", got)
+	}
+	got = completeWords(commandTree(), []string{"runs", "list", "--profile", "st"})
+	if strings.Join(got, ",") != "staging" {
+		t.Fatalf("unexpected profiles: %v", got)
+	}
+}
+
+func TestCompleteAppSlugsFromAPI(t *testing.T) {
+	t.Setenv(envCLIConfig, filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv(envServerURL, "")
+	t.Setenv(envAPIToken, "")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" || r.URL.Path != "/api/v1/apps" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(listAppsResponse{Apps: []appResponse{{Slug: "billing"}, {Slug: "etl"}}})
+	}))
+	defer srv.Close()
+
+	got := completeWords(commandTree(), []string{"runs", "list", "--server", srv.URL, "--token", "tok", "--app", ""})
+	if strings.Join(got, ",") != "billing,etl" {
+		t.Fatalf("unexpected apps: %v", got)
+	}
+
+	// No token configured: no API call, no candidates.
+	if got := completeWords(commandTree(), []string{"apps", "get", "--server", srv.URL, "b"}); got != nil {
+		t.Fatalf("expected no candidates without token, got %v", got)
+	}
+
+	// Unreachable server falls back silently.
+	srv.Close()
+	if got := completeWords(commandTree(), []string{"apps", "get", "--server", srv.URL, "--token", "tok", ""}); got != nil {
+		t.Fatalf("expected no candidates from unreachable server, got %v", got)
+	}
+}
+
+func TestBashCompletionScriptParses(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not installed")
+	}
+	script, err := completionScript("bash")
+	if err != nil {
+		t.Fatalf("completion script: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "minitower-cli.bash")
+	if err := os.WriteFile(path, []byte(script), 0600); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	out, err := exec.Command(bash, "-n", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("bash -n failed: %v\n%s", err, out)
+	}
+
+	if _, err := completionScript("powershell"); err == nil {
+		t.Fatal("expected error for unsupported shell")
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// configProbeTimeout bounds the TCP and TLS probe of config test. A variable
+// so tests can shorten it.
+var configProbeTimeout = 5 * time.Second
+
+// Statuses of a config test check.
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// configCheck is one line of the config test report. exitCode is the exit
+// code a failure of the check gives the command.
+type configCheck struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	Hint       string `json:"hint,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	exitCode   int
+}
+
+type configTestReport struct {
+	Profile  string        `json:"profile,omitempty"`
+	Server   string        `json:"server"`
+	OK       bool          `json:"ok"`
+	ExitCode int           `json:"exit_code"`
+	Checks   []configCheck `json:"checks"`
+}
+
+// cliCommand returns a command line for a hint, naming the profile when
+// the user picked one explicitly.
+func cliCommand(command, profileName string) string {
+	if name := strings.TrimSpace(profileName); name != "" {
+		return fmt.Sprintf("`minitower-cli %s --profile %s`", command, name)
+	}
+	return fmt.Sprintf("`minitower-cli %s`", command)
+}
+
+func cmdConfigTest(args []string) error {
+	fs := newFlagSet("config test")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+
+	// A missing token is reported as a failed check rather than refused.
+	conn, err := resolveConnection(*profileName, *server, *token, false)
+	if err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	report := runConfigTest(conn, *profileName)
+	if jsonOut {
+		if err := ui.json(report); err != nil {
+			return err
+		}
+	} else {
+		printConfigTestReport(report)
+	}
+	if !report.OK {
+		return &exitError{Code: report.ExitCode}
+	}
+	return nil
+}
+
+// runConfigTest checks conn step by step: the URL, reaching the server,
+// an unauthenticated API call and the token. A step that fails skips the
+// steps after it.
+func runConfigTest(conn *resolvedConnection, profileFlag string) configTestReport {
+	report := configTestReport{Profile: conn.ProfileName, Server: conn.Server, OK: true}
+	add := func(c configCheck) {
+		report.Checks = append(report.Checks, c)
+		if c.Status == checkFail && report.OK {
+			report.OK = false
+			report.ExitCode = c.exitCode
+		}
+	}
+	skipRest := func(names ...string) {
+		for _, name := range names {
+			add(configCheck{Name: name, Status: checkSkip, Message: "not checked"})
+		}
+	}
+
+	check, u := checkServerURL(conn.Server, profileFlag)
+	add(check)
+	if u == nil {
+		skipRest("connect", "server", "token")
+		return report
+	}
+
+	client, err := newConnectionClient(conn)
+	if err != nil {
+		add(configCheck{Name: "connect", Status: checkFail, Message: err.Error(),
+			Hint: "fix the profile's CA certificate with " + cliCommand("config set --ca-cert <ca.pem>", profileFlag), exitCode: 1})
+		skipRest("server", "token")
+		return report
+	}
+	check = probeServer(u, client.http.Transport.(*http.Transport), profileFlag)
+	add(check)
+	if check.Status == checkFail {
+		skipRest("server", "token")
+		return report
+	}
+
+	check = checkServerAPI(conn, profileFlag)
+	add(check)
+	if check.Status == checkFail {
+		skipRest("token")
+		return report
+	}
+	add(checkToken(conn, profileFlag))
+	return report
+}
+
+// checkServerURL checks that server is an http or https URL without a path
+// and returns it parsed, or nil when it cannot be used at all.
+func checkServerURL(server, profileFlag string) (configCheck, *url.URL) {
+	check := configCheck{Name: "url", exitCode: 1}
+	fail := func(format string, args ...any) (configCheck, *url.URL) {
+		check.Status = checkFail
+		check.Message = fmt.Sprintf(format, args...)
+		return check, nil
+	}
+	setServer := func(suggested string) string {
+		return "run " + cliCommand("config set --server "+suggested, profileFlag)
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		check.Hint = setServer("https://<host>[:port]")
+		return fail("server URL %q cannot be parsed: %v", server, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		if !strings.Contains(server, "://") {
+			check.Hint = "did you mean https://" + server + "? " + setServer("https://"+server)
+		} else {
+			check.Hint = setServer("https://<host>[:port]")
+		}
+		return fail("server URL %q must start with http:// or https:// and name a host", server)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		check.Hint = setServer(u.Scheme + "://" + u.Host)
+		return fail("server URL %q has a query or fragment", server)
+	}
+
+	origin := u.Scheme + "://" + u.Host
+	path := strings.TrimRight(u.Path, "/")
+	switch {
+	case path == "":
+		check.Status = checkOK
+		check.Message = server
+	case path == "/api" || strings.HasPrefix(path, "/api/"):
+		// API paths are added by the CLI, so this path is always a mistake.
+		check.Hint = "did you mean " + origin + "? " + setServer(origin)
+		return fail("server URL has a path component (%s); API paths are added by the CLI", path)
+	default:
+		check.Status = checkWarn
+		check.Message = fmt.Sprintf("server URL has a path component (%s); this only works behind a proxy that serves mini-tower under it", path)
+		check.Hint = "if it does not, did you mean " + origin + "?"
+	}
+	return check, u
+}
+
+// probeServer opens a TCP connection to the server, or to the proxy requests
+// to it go through, and for https completes a TLS handshake with the
+// profile's TLS settings.
+func probeServer(u *url.URL, transport *http.Transport, profileFlag string) configCheck {
+	check := configCheck{Name: "connect", exitCode: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), configProbeTimeout)
+	defer cancel()
+
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	addr := net.JoinHostPort(host, port)
+	var proxy *url.URL
+	if transport.Proxy != nil {
+		proxy, _ = transport.Proxy(&http.Request{URL: u})
+	}
+	target := addr
+	if proxy != nil {
+		target = proxy.Host
+		if proxy.Port() == "" {
+			target = net.JoinHostPort(proxy.Hostname(), "80")
+			if proxy.Scheme == "https" {
+				target = net.JoinHostPort(proxy.Hostname(), "443")
+			}
+		}
+	}
+
+	start := time.Now()
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", target)
+	dialTime := time.Since(start)
+	check.DurationMS = dialTime.Milliseconds()
+	if err != nil {
+		check.Status = checkFail
+		check.Message = fmt.Sprintf("cannot reach %s: %v", target, err)
+		var dnsErr *net.DNSError
+		switch {
+		case errors.As(err, &dnsErr):
+			check.Hint = "check the host name in the server URL"
+		case proxy != nil:
+			check.Hint = "check the proxy set in HTTPS_PROXY / HTTP_PROXY, or list the server in NO_PROXY"
+		default:
+			check.Hint = fmt.Sprintf("check that the server is running and listening on %s, and that no firewall blocks it", addr)
+		}
+		return check
+	}
+	defer netConn.Close()
+	if proxy != nil {
+		// TLS to the server is tunneled through the proxy, so the API
+		// checks below cover it.
+		check.Status = checkOK
+		check.Message = fmt.Sprintf("proxy %s reached in %s for %s", target, formatProbeTime(dialTime), addr)
+		return check
+	}
+	check.Message = fmt.Sprintf("%s reached in %s", addr, formatProbeTime(dialTime))
+	if u.Scheme != "https" {
+		check.Status = checkOK
+		return check
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if transport.TLSClientConfig != nil {
+		cfg = transport.TLSClientConfig.Clone()
+	}
+	cfg.ServerName = host
+	tlsStart := time.Now()
+	err = tls.Client(netConn, cfg).HandshakeContext(ctx)
+	check.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		check.Status = checkFail
+		check.Message += fmt.Sprintf(", but the TLS handshake failed: %v", err)
+		var unknownCA x509.UnknownAuthorityError
+		var hostErr x509.HostnameError
+		var recordErr tls.RecordHeaderError
+		switch {
+		case errors.As(err, &unknownCA):
+			check.Hint = "if the server uses an internal CA, run " + cliCommand("config set --ca-cert <ca.pem>", profileFlag)
+		case errors.As(err, &hostErr):
+			check.Hint = "the certificate is not issued for " + host + "; use the host name it was issued for in the server URL"
+		case errors.As(err, &recordErr):
+			check.Hint = "the server does not speak TLS; did you mean http://" + u.Host + "?"
+		default:
+			check.Hint = "check the server's TLS setup"
+		}
+		return check
+	}
+	check.Status = checkOK
+	check.Message += fmt.Sprintf(", TLS handshake in %s", formatProbeTime(time.Since(tlsStart)))
+	return check
+}
+
+// checkServerAPI calls GET /api/v1/auth/options, which needs no token, to
+// tell a mini-tower server from anything else answering at the URL.
+func checkServerAPI(conn *resolvedConnection, profileFlag string) configCheck {
+	check := configCheck{Name: "server", exitCode: 1}
+	client, err := newConnectionClient(&resolvedConnection{Server: conn.Server, CACert: conn.CACert, Insecure: conn.Insecure})
+	if err != nil {
+		check.Status = checkFail
+		check.Message = err.Error()
+		return check
+	}
+	var options struct {
+		SignupEnabled *bool `json:"signup_enabled"`
+	}
+	start := time.Now()
+	err = client.doJSON(context.Background(), http.MethodGet, "/api/v1/auth/options", nil, &options)
+	check.DurationMS = time.Since(start).Milliseconds()
+	switch {
+	case err == nil && options.SignupEnabled != nil:
+		check.Status = checkOK
+		check.Message = fmt.Sprintf("GET /api/v1/auth/options answered in %s", formatProbeTime(time.Since(start)))
+		return check
+	case err == nil:
+		err = errors.New("unexpected response")
+	}
+	check.Status = checkFail
+	check.Message = fmt.Sprintf("GET /api/v1/auth/options failed: %v", err)
+	var ae *apiError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &ae) && ae.Status >= 500:
+		check.Hint = "the server is failing; check its logs"
+	case errors.As(err, &urlErr):
+		check.Hint = "check the server URL and any proxy between you and the server"
+	default:
+		check.Hint = "the server did not answer like mini-tower; check the server URL with " + cliCommand("config get", profileFlag)
+	}
+	return check
+}
+
+// checkToken calls GET /api/v1/me with the connection's token.
+func checkToken(conn *resolvedConnection, profileFlag string) configCheck {
+	check := configCheck{Name: "token", exitCode: apiStatusExitCode(http.StatusUnauthorized)}
+	login := "run " + cliCommand("login", profileFlag)
+	if conn.Token == "" {
+		check.Status = checkFail
+		check.Message = fmt.Sprintf("no API token (--token, %s, or config profile)", envAPIToken)
+		check.Hint = login
+		return check
+	}
+	client, err := newConnectionClient(conn)
+	if err != nil {
+		check.Status = checkFail
+		check.Message = err.Error()
+		return check
+	}
+	var me meResponse
+	start := time.Now()
+	err = client.doJSON(context.Background(), http.MethodGet, "/api/v1/me", nil, &me)
+	check.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		check.Status = checkFail
+		var ae *apiError
+		if errors.As(err, &ae) && ae.Status == http.StatusUnauthorized {
+			check.Message = "token rejected: " + ae.Message
+			check.Hint = "the token may have been revoked or the team deleted; " + login
+			return check
+		}
+		check.Message = fmt.Sprintf("GET /api/v1/me failed: %v", err)
+		check.exitCode = 1
+		if errors.As(err, &ae) {
+			check.exitCode = apiStatusExitCode(ae.Status)
+		}
+		return check
+	}
+	check.Status = checkOK
+	check.Message = fmt.Sprintf("team %s, role %s", me.TeamSlug, me.Role)
+	if me.AppSlug != "" {
+		check.Message += ", app " + me.AppSlug
+	}
+	return check
+}
+
+func formatProbeTime(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+func printConfigTestReport(report configTestReport) {
+	if report.Profile != "" {
+		ui.printf("Profile: %s\n", report.Profile)
+	}
+	ui.printf("Server: %s\n\n", report.Server)
+	for _, c := range report.Checks {
+		label := c.Status
+		if c.Status == checkFail {
+			label = "FAIL"
+		}
+		ui.printf("%-4s  %-7s  %s\n", label, c.Name, c.Message)
+		if c.Hint != "" {
+			// Hints line up under the messages.
+			ui.printf("%-15shint: %s\n", "", c.Hint)
+		}
+	}
+	if report.OK {
+		ui.printf("\nAll checks passed.\n")
+	}
+}
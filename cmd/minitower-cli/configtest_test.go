@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newConfigTestServer serves the endpoints config test calls under prefix,
+// accepting only token "good".
+func newConfigTestServer(t *testing.T, prefix string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/api/v1/auth/options", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"signup_enabled":true,"bootstrap_enabled":false}`))
+	})
+	mux.HandleFunc(prefix+"/api/v1/me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":"unauthorized","message":"invalid token"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"team_id":1,"team_slug":"acme","token_id":2,"role":"admin"}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func exitCodeOf(t *testing.T, err error) int {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	var ee *exitError
+	if !errors.As(err, &ee) {
+		t.Fatalf("expected an exitError, got %v", err)
+	}
+	return ee.Code
+}
+
+func TestConfigTestReportsEachFailureMode(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	srv := newConfigTestServer(t, "")
+	prefixed := newConfigTestServer(t, "/tower")
+	notTower := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(notTower.Close)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	tlsSrv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	tlsSrv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	tlsSrv.StartTLS()
+	t.Cleanup(tlsSrv.Close)
+
+	cases := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     []string
+	}{
+		{"ok", []string{"--server", srv.URL, "--token", "good"}, 0,
+			[]string{"ok    url      " + srv.URL, "ok    connect  ", "ok    server   GET /api/v1/auth/options answered", "ok    token    team acme, role admin", "All checks passed."}},
+		{"no scheme", []string{"--server", "tower.example.com", "--token", "good"}, 1,
+			[]string{"FAIL  url", "hint: did you mean https://tower.example.com? run `minitower-cli config set --server https://tower.example.com`", "skip  connect  not checked", "skip  token    not checked"}},
+		{"api path", []string{"--server", srv.URL + "/api/v1", "--token", "good"}, 1,
+			[]string{"FAIL  url      server URL has a path component (/api/v1)", "hint: did you mean " + srv.URL + "?"}},
+		{"proxy path", []string{"--server", prefixed.URL + "/tower/", "--token", "good"}, 0,
+			[]string{"warn  url      server URL has a path component (/tower)", "ok    token    team acme"}},
+		{"unreachable", []string{"--server", closed.URL, "--token", "good"}, 1,
+			[]string{"FAIL  connect  cannot reach", "hint: check that the server is running", "skip  server"}},
+		{"untrusted certificate", []string{"--server", tlsSrv.URL, "--token", "good"}, 1,
+			[]string{"FAIL  connect  ", "the TLS handshake failed", "hint: if the server uses an internal CA, run `minitower-cli config set --ca-cert <ca.pem>`"}},
+		{"not mini-tower", []string{"--server", notTower.URL, "--token", "good"}, 1,
+			[]string{"FAIL  server   GET /api/v1/auth/options failed", "hint: the server did not answer like mini-tower", "skip  token"}},
+		{"token rejected", []string{"--server", srv.URL, "--token", "revoked"}, 10,
+			[]string{"FAIL  token    token rejected: invalid token", "hint: the token may have been revoked or the team deleted; run `minitower-cli login`"}},
+		{"no token", []string{"--server", srv.URL}, 10,
+			[]string{"FAIL  token    no API token", "hint: run `minitower-cli login`"}},
+	}
+	for _, tc := range cases {
+		resetOutput(stdout, stderr)
+		err := run(append([]string{"config", "test"}, tc.args...))
+		if code := exitCodeOf(t, err); code != tc.wantCode {
+			t.Fatalf("%s: expected exit %d, got %d (%v)\n%s", tc.name, tc.wantCode, code, err, stdout.String())
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(stdout.String(), want) {
+				t.Fatalf("%s: expected %q in the report, got:\n%s", tc.name, want, stdout.String())
+			}
+		}
+		if stderr.Len() != 0 {
+			t.Fatalf("%s: expected nothing on stderr, got %q", tc.name, stderr.String())
+		}
+	}
+}
+
+func TestConfigTestJSONNamesTheProfile(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	srv := newConfigTestServer(t, "")
+
+	if err := run([]string{"config", "set", "--profile", "prod", "--server", srv.URL, "--token", "revoked"}); err != nil {
+		t.Fatalf("config set: %v", err)
+	}
+	resetOutput(stdout, stderr)
+	err := run([]string{"config", "test", "--profile", "prod", "--json"})
+	if code := exitCodeOf(t, err); code != 10 {
+		t.Fatalf("expected exit 10, got %d (%v)", code, err)
+	}
+
+	var report struct {
+		Profile  string `json:"profile"`
+		Server   string `json:"server"`
+		OK       bool   `json:"ok"`
+		ExitCode int    `json:"exit_code"`
+		Checks   []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+			Hint   string `json:"hint"`
+		} `json:"checks"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v\n%s", err, stdout.String())
+	}
+	if report.Profile != "prod" || report.Server != srv.URL || report.OK || report.ExitCode != 10 || len(report.Checks) != 4 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	var statuses []string
+	for _, c := range report.Checks {
+		statuses = append(statuses, c.Name+"="+c.Status)
+	}
+	if got := strings.Join(statuses, ","); got != "url=ok,connect=ok,server=ok,token=fail" {
+		t.Fatalf("unexpected checks %s", got)
+	}
+	if hint := report.Checks[3].Hint; !strings.HasSuffix(hint, "run `minitower-cli login --profile prod`") {
+		t.Fatalf("expected the login hint to name the profile, got %q", hint)
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("expected nothing on stderr, got %q", stderr.String())
+	}
+}
+
+func TestUnauthorizedErrorsHintAtConfigTest(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	srv := newConfigTestServer(t, "")
+	srv401 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":{"code":"unauthorized","message":"invalid team credentials"}}`))
+	}))
+	t.Cleanup(srv401.Close)
+
+	err := run([]string{"me", "--server", srv.URL, "--token", "revoked"})
+	if code := ui.reportError(err); code != 10 {
+		t.Fatalf("expected exit 10, got %d (%v)", code, err)
+	}
+	if want := "error: invalid token\nhint: run `minitower-cli config test`\n"; stderr.String() != want {
+		t.Fatalf("expected %q, got %q", want, stderr.String())
+	}
+
+	if err := run([]string{"config", "set", "--profile", "prod", "--server", srv.URL, "--token", "revoked"}); err != nil {
+		t.Fatalf("config set: %v", err)
+	}
+	resetOutput(stdout, stderr)
+	err = run([]string{"me", "--profile", "prod", "--json"})
+	if code := ui.reportError(err); code != 10 {
+		t.Fatalf("expected exit 10, got %d (%v)", code, err)
+	}
+	var env struct {
+		Error struct {
+			Hint     string `json:"hint"`
+			ExitCode int    `json:"exit_code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &env); err != nil {
+		t.Fatalf("decode error: %v\n%s", err, stderr.String())
+	}
+	if env.Error.Hint != "run `minitower-cli config test --profile prod`" || env.Error.ExitCode != 10 {
+		t.Fatalf("unexpected JSON error %+v", env.Error)
+	}
+
+	// A 401 from login means a wrong password, not a bad profile.
+	resetOutput(stdout, stderr)
+	err = run([]string{"login", "--server", srv401.URL, "--team", "acme", "--password", "nope"})
+	if code := ui.reportError(err); code != 10 {
+		t.Fatalf("login: expected exit 10, got %d (%v)", code, err)
+	}
+	if strings.Contains(stderr.String(), "hint:") {
+		t.Fatalf("login: expected no hint, got %q", stderr.String())
+	}
+}
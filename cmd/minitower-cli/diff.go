@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// redactedValue is how the API masks secret input values. Masked values are
+// never reported as changed, since both runs may hold different secrets
+// behind the same mask.
+const redactedValue = "***"
+
+// jsonChange is one difference between two JSON values. Kind is "added",
+// "removed", "changed" or "type_changed"; Old is null for added values and
+// New is null for removed ones.
+type jsonChange struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	Old  any    `json:"old"`
+	New  any    `json:"new"`
+}
+
+// runFieldChange is a run setting that differs between the two runs.
+type runFieldChange struct {
+	Field string `json:"field"`
+	A     any    `json:"a"`
+	B     any    `json:"b"`
+}
+
+type runDiffResponse struct {
+	RunA   int64            `json:"run_a"`
+	RunB   int64            `json:"run_b"`
+	Fields []runFieldChange `json:"fields"`
+	Input  []jsonChange     `json:"input"`
+	// Logs is a line diff of the runs' final log lines, each prefixed with
+	// " ", "-" or "+"; only with --logs.
+	Logs []string `json:"logs,omitempty"`
+}
+
+// diffJSON appends the differences between a and b, decoded from JSON, to
+// changes. Object keys are visited in sorted order and arrays are compared
+// by index, so the result is deterministic.
+func diffJSON(path string, a, b any, changes []jsonChange) []jsonChange {
+	if a == redactedValue || b == redactedValue {
+		return changes
+	}
+	kindA, kindB := jsonKind(a), jsonKind(b)
+	if kindA != kindB {
+		return append(changes, jsonChange{Path: path, Kind: "type_changed", Old: a, New: b})
+	}
+
+	switch av := a.(type) {
+	case map[string]any:
+		bv := b.(map[string]any)
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := jsonPathKey(path, k)
+			va, inA := av[k]
+			vb, inB := bv[k]
+			switch {
+			case !inB:
+				changes = append(changes, jsonChange{Path: child, Kind: "removed", Old: va})
+			case !inA:
+				changes = append(changes, jsonChange{Path: child, Kind: "added", New: vb})
+			default:
+				changes = diffJSON(child, va, vb, changes)
+			}
+		}
+	case []any:
+		bv := b.([]any)
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			child := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(bv):
+				changes = append(changes, jsonChange{Path: child, Kind: "removed", Old: av[i]})
+			case i >= len(av):
+				changes = append(changes, jsonChange{Path: child, Kind: "added", New: bv[i]})
+			default:
+				changes = diffJSON(child, av[i], bv[i], changes)
+			}
+		}
+	default:
+		if !reflect.DeepEqual(a, b) {
+			changes = append(changes, jsonChange{Path: path, Kind: "changed", Old: a, New: b})
+		}
+	}
+	return changes
+}
+
+// jsonKind names the JSON type of a decoded value.
+func jsonKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64, json.Number:
+		return "number"
+	case string:
+		return "string"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// jsonPathKey extends path with an object key, quoting keys that would not
+// read back as a single dotted segment.
+func jsonPathKey(path, key string) string {
+	plain := key != ""
+	for _, r := range key {
+		if !(r == '_' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			plain = false
+			break
+		}
+	}
+	if !plain {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// diffLines returns a line diff of a and b built from their longest common
+// subsequence. Each line is prefixed with " " when both sides have it, "-"
+// when only a does and "+" when only b does.
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+	return out
+}
+
+// diffRuns compares the settings and inputs of two runs.
+func diffRuns(a, b runResponse) runDiffResponse {
+	resp := runDiffResponse{RunA: a.RunID, RunB: b.RunID, Fields: []runFieldChange{}}
+	for _, f := range []runFieldChange{
+		{Field: "app", A: a.AppSlug, B: b.AppSlug},
+		{Field: "version_no", A: a.VersionNo, B: b.VersionNo},
+		{Field: "priority", A: a.Priority, B: b.Priority},
+		{Field: "environment", A: a.Environment, B: b.Environment},
+	} {
+		if f.A != f.B {
+			resp.Fields = append(resp.Fields, f)
+		}
+	}
+
+	// A run without input compares as an empty object.
+	inputA, inputB := map[string]any{}, map[string]any{}
+	if a.Input != nil {
+		inputA = a.Input
+	}
+	if b.Input != nil {
+		inputB = b.Input
+	}
+	resp.Input = diffJSON("input", inputA, inputB, []jsonChange{})
+	return resp
+}
+
+// tailLogLines returns the text of the last n log lines of a run.
+func tailLogLines(client *apiClient, runID int64, n int) ([]string, error) {
+	logs, err := fetchRunLogs(context.Background(), client, runID, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) > n {
+		logs = logs[len(logs)-n:]
+	}
+	lines := make([]string, len(logs))
+	for i, l := range logs {
+		lines[i] = l.Line
+	}
+	return lines, nil
+}
+
+// formatJSONValue renders a decoded JSON value compactly for display.
+func formatJSONValue(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func printRunDiff(d runDiffResponse, logLines int, withLogs bool) {
+	ui.printf("--- run %d\n+++ run %d\n", d.RunA, d.RunB)
+	if len(d.Fields) == 0 && len(d.Input) == 0 {
+		ui.printf("settings and input are the same\n")
+	}
+	for _, f := range d.Fields {
+		ui.printf("%s: %s -> %s\n", f.Field, formatJSONValue(f.A), formatJSONValue(f.B))
+	}
+	for _, c := range d.Input {
+		switch c.Kind {
+		case "added":
+			ui.printf("%s: added %s\n", c.Path, formatJSONValue(c.New))
+		case "removed":
+			ui.printf("%s: removed %s\n", c.Path, formatJSONValue(c.Old))
+		case "type_changed":
+			ui.printf("%s: %s -> %s (%s -> %s)\n", c.Path, formatJSONValue(c.Old), formatJSONValue(c.New), jsonKind(c.Old), jsonKind(c.New))
+		default:
+			ui.printf("%s: %s -> %s\n", c.Path, formatJSONValue(c.Old), formatJSONValue(c.New))
+		}
+	}
+	if withLogs {
+		ui.printf("\nlogs (last %d lines):\n", logLines)
+		for _, l := range d.Logs {
+			ui.printf("%s\n", l)
+		}
+	}
+}
+
+func cmdRunsDiff(args []string) error {
+	fs := newFlagSet("runs diff")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	withLogs := fs.Bool("logs", false, "also diff the final log lines of each run")
+	logLines := fs.Int("log-lines", 50, "log lines to compare with --logs")
+	formats := addFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if fs.NArg() != 2 {
+		return &exitError{Code: 1, Message: "usage: minitower-cli runs diff <run-a> <run-b> [--logs]"}
+	}
+	if *logLines <= 0 {
+		return &exitError{Code: 1, Message: "--log-lines must be > 0"}
+	}
+	runIDA, err := parseRunIDArg(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	runIDB, err := parseRunIDArg(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	jsonOut, err := formats.resolve(conn.Output)
+	if err != nil {
+		return err
+	}
+
+	// Runs are team-scoped, so a run of another team is not found.
+	var runA, runB runResponse
+	if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", runIDA), nil, &runA); err != nil {
+		return mapError(err)
+	}
+	if err := client.doJSON(context.Background(), http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", runIDB), nil, &runB); err != nil {
+		return mapError(err)
+	}
+	if runA.AppID != runB.AppID {
+		ui.warnf("warning: runs %d and %d belong to different apps (%s, %s)\n", runIDA, runIDB, runA.AppSlug, runB.AppSlug)
+	}
+
+	d := diffRuns(runA, runB)
+	if *withLogs {
+		linesA, err := tailLogLines(client, runIDA, *logLines)
+		if err != nil {
+			return mapError(err)
+		}
+		linesB, err := tailLogLines(client, runIDB, *logLines)
+		if err != nil {
+			return mapError(err)
+		}
+		d.Logs = diffLines(linesA, linesB)
+	}
+
+	if jsonOut {
+		return ui.json(d)
+	}
+	printRunDiff(d, *logLines, *withLogs)
+	return nil
+}
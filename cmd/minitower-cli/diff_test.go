@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeJSONValue(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return v
+}
+
+func TestDiffJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []jsonChange
+	}{
+		{
+			name: "equal",
+			a:    `{"day":1,"tags":["a"]}`,
+			b:    `{"tags":["a"],"day":1}`,
+			want: []jsonChange{},
+		},
+		{
+			name: "added removed changed",
+			a:    `{"day":1,"old":"x"}`,
+			b:    `{"day":2,"new":true}`,
+			want: []jsonChange{
+				{Path: "input.day", Kind: "changed", Old: 1.0, New: 2.0},
+				{Path: "input.new", Kind: "added", New: true},
+				{Path: "input.old", Kind: "removed", Old: "x"},
+			},
+		},
+		{
+			name: "nested objects",
+			a:    `{"opts":{"mode":"fast","db":{"host":"a"}}}`,
+			b:    `{"opts":{"mode":"fast","db":{"host":"b","port":5432}}}`,
+			want: []jsonChange{
+				{Path: "input.opts.db.host", Kind: "changed", Old: "a", New: "b"},
+				{Path: "input.opts.db.port", Kind: "added", New: 5432.0},
+			},
+		},
+		{
+			name: "arrays by index",
+			a:    `{"items":[1,{"k":"v"},3]}`,
+			b:    `{"items":[1,{"k":"w"}]}`,
+			want: []jsonChange{
+				{Path: "input.items[1].k", Kind: "changed", Old: "v", New: "w"},
+				{Path: "input.items[2]", Kind: "removed", Old: 3.0},
+			},
+		},
+		{
+			name: "type changes",
+			a:    `{"n":1,"list":[1],"maybe":null}`,
+			b:    `{"n":"1","list":{"0":1},"maybe":false}`,
+			want: []jsonChange{
+				{Path: "input.list", Kind: "type_changed", Old: []any{1.0}, New: map[string]any{"0": 1.0}},
+				{Path: "input.maybe", Kind: "type_changed", Old: nil, New: false},
+				{Path: "input.n", Kind: "type_changed", Old: 1.0, New: "1"},
+			},
+		},
+		{
+			name: "redacted values are not compared",
+			a:    `{"password":"***","nested":{"key":"***"},"user":"a"}`,
+			b:    `{"password":"***","nested":{"key":"other"},"user":"a"}`,
+			want: []jsonChange{},
+		},
+		{
+			name: "quoted keys",
+			a:    `{"a.b":1}`,
+			b:    `{"a.b":2}`,
+			want: []jsonChange{
+				{Path: `input["a.b"]`, Kind: "changed", Old: 1.0, New: 2.0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffJSON("input", decodeJSONValue(t, tt.a), decodeJSONValue(t, tt.b), []jsonChange{})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("diffJSON =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines([]string{"start", "day=1", "ok"}, []string{"start", "day=2", "ok", "extra"})
+	want := []string{" start", "-day=1", "+day=2", " ok", "+extra"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("diffLines = %q, want %q", got, want)
+	}
+}
+
+func TestRunsDiff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/runs/1041":
+			_, _ = w.Write([]byte(`{"run_id":1041,"app_id":1,"app_slug":"hello","version_no":3,"priority":0,"environment":"default","input":{"day":1,"token":"***"}}`))
+		case "/api/v1/runs/1042":
+			_, _ = w.Write([]byte(`{"run_id":1042,"app_id":1,"app_slug":"hello","version_no":4,"priority":0,"environment":"default","input":{"day":2,"token":"***"}}`))
+		case "/api/v1/runs/1043":
+			_, _ = w.Write([]byte(`{"run_id":1043,"app_id":2,"app_slug":"other","version_no":1,"priority":0,"environment":"default"}`))
+		case "/api/v1/runs/1041/logs":
+			_, _ = w.Write([]byte(`{"logs":[{"seq":1,"line":"start"},{"seq":2,"line":"done"}]}`))
+		case "/api/v1/runs/1042/logs":
+			_, _ = w.Write([]byte(`{"logs":[{"seq":1,"line":"start"},{"seq":2,"line":"boom"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":"not_found","message":"run not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	stdout, stderr := captureOutput(t)
+	if err := run([]string{"runs", "diff", "--server", srv.URL, "--token", "tok", "--logs", "1041", "1042"}); err != nil {
+		t.Fatalf("runs diff: %v", err)
+	}
+	want := "--- run 1041\n+++ run 1042\nversion_no: 3 -> 4\ninput.day: 1 -> 2\n\nlogs (last 50 lines):\n start\n-done\n+boom\n"
+	if stdout.String() != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", stdout.String(), want)
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("expected no warnings, got %q", stderr.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "diff", "--server", srv.URL, "--token", "tok", "--json", "1041", "1043"}); err != nil {
+		t.Fatalf("runs diff --json: %v", err)
+	}
+	var d runDiffResponse
+	if err := json.Unmarshal(stdout.Bytes(), &d); err != nil {
+		t.Fatalf("decode diff: %v", err)
+	}
+	if len(d.Fields) != 2 || d.Fields[0].Field != "app" || d.Fields[1].Field != "version_no" {
+		t.Fatalf("unexpected fields %+v", d.Fields)
+	}
+	if len(d.Input) != 2 || d.Input[0].Path != "input.day" || d.Input[0].Kind != "removed" {
+		t.Fatalf("unexpected input changes %+v", d.Input)
+	}
+	if !strings.Contains(stderr.String(), "belong to different apps (hello, other)") {
+		t.Fatalf("expected a different-app warning, got %q", stderr.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "diff", "--server", srv.URL, "--token", "tok", "1041", "9"}); err == nil {
+		t.Fatal("expected an error for a missing run")
+	}
+}
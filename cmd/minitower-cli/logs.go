@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// logTimeLayout is the timestamp printed before each log line.
+	logTimeLayout = "15:04:05.000"
+	ansiRed       = "\x1b[31m"
+	ansiReset     = "\x1b[0m"
+)
+
+// logFormat controls how printLogs decorates each log line.
+type logFormat struct {
+	// stream keeps only lines of that stream; empty keeps both.
+	stream     string
+	timestamps bool
+	seq        bool
+	// raw prints the stored line with no decoration at all.
+	raw bool
+	// color paints stderr lines red.
+	color bool
+}
+
+// logFormatFlags are the log display flags shared by runs logs and runs watch.
+type logFormatFlags struct {
+	timestamps *bool
+	stream     *string
+	seq        *bool
+	raw        *bool
+}
+
+func addLogFormatFlags(fs *flag.FlagSet) *logFormatFlags {
+	return &logFormatFlags{
+		timestamps: fs.Bool("timestamps", true, "prefix each line with the time it was logged"),
+		stream:     fs.String("stream", "", "only show stdout or stderr"),
+		seq:        fs.Bool("seq", false, "prefix each line with its sequence number"),
+		raw:        fs.Bool("raw", false, "print the stored lines with no decoration"),
+	}
+}
+
+// resolve validates the flags. Color is only used on a terminal and never
+// when NO_COLOR is set.
+func (f *logFormatFlags) resolve() (logFormat, error) {
+	switch *f.stream {
+	case "", "stdout", "stderr":
+	default:
+		return logFormat{}, &exitError{Code: 1, Message: "--stream must be stdout or stderr"}
+	}
+	if *f.raw && *f.seq {
+		return logFormat{}, &exitError{Code: 1, Message: "--seq cannot be combined with --raw"}
+	}
+	return logFormat{
+		stream:     *f.stream,
+		timestamps: *f.timestamps,
+		seq:        *f.seq,
+		raw:        *f.raw,
+		color:      !*f.raw && stdoutIsTerminal() && os.Getenv("NO_COLOR") == "",
+	}, nil
+}
+
+func completeLogStreams(*completionContext) []string {
+	return []string{"stdout", "stderr"}
+}
+
+// filterLogs returns the lines of logs on the format's stream.
+func (f logFormat) filterLogs(logs []runLogEntry) []runLogEntry {
+	if f.stream == "" {
+		return logs
+	}
+	kept := make([]runLogEntry, 0, len(logs))
+	for _, l := range logs {
+		if l.Stream == f.stream {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// formatLogLine renders one log line, e.g. "03:04:07.250  hello". The
+// timestamp is the line's logged_at in local time, or blank padding when
+// the server's value does not parse. A line holding several lines, such as a
+// grouped traceback, has its later lines indented under the first.
+func formatLogLine(l runLogEntry, f logFormat) string {
+	if f.raw {
+		return l.Line
+	}
+	line := l.Line
+	if f.color && l.Stream == "stderr" {
+		line = ansiRed + line + ansiReset
+	}
+	prefix := ""
+	if f.seq {
+		prefix = "[" + strconv.FormatInt(l.Seq, 10) + "] "
+	}
+	if f.timestamps {
+		ts := "            "
+		if t, err := time.Parse(time.RFC3339Nano, l.LoggedAt); err == nil {
+			ts = t.Local().Format(logTimeLayout)
+		}
+		prefix += ts + "  "
+	}
+	return prefix + indentContinuation(line, len(prefix))
+}
+
+// indentContinuation indents every line after the first by width spaces.
+func indentContinuation(s string, width int) string {
+	if width == 0 || !strings.Contains(s, "\n") {
+		return s
+	}
+	return strings.ReplaceAll(s, "\n", "\n"+strings.Repeat(" ", width))
+}
+
+func printLogs(logs []runLogEntry, f logFormat) {
+	for _, l := range f.filterLogs(logs) {
+		ui.printf("%s\n", formatLogLine(l, f))
+	}
+}
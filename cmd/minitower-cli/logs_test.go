@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFormatLogLine(t *testing.T) {
+	out := runLogEntry{Seq: 4, Stream: "stdout", Line: "hello", LoggedAt: "2026-01-02T03:04:07.250Z"}
+	errLine := runLogEntry{Seq: 5, Stream: "stderr", Line: "boom", LoggedAt: "2026-01-02T03:04:08Z"}
+	badTime := runLogEntry{Seq: 6, Stream: "stdout", Line: "late", LoggedAt: "yesterday"}
+	multi := runLogEntry{Seq: 7, Stream: "stderr", Line: "Traceback (most recent call last):\n  File \"main.py\", line 1\nValueError", LoggedAt: out.LoggedAt}
+	ts := localLogTime(t, out.LoggedAt)
+
+	cases := []struct {
+		name string
+		line runLogEntry
+		f    logFormat
+		want string
+	}{
+		{"default", out, logFormat{timestamps: true}, ts + "  hello"},
+		{"no timestamps", out, logFormat{}, "hello"},
+		{"seq", out, logFormat{timestamps: true, seq: true}, "[4] " + ts + "  hello"},
+		{"seq without timestamps", out, logFormat{seq: true}, "[4] hello"},
+		{"unparsable time is blank", badTime, logFormat{timestamps: true}, "              late"},
+		{"stderr colored", errLine, logFormat{color: true}, ansiRed + "boom" + ansiReset},
+		{"stdout never colored", out, logFormat{color: true}, "hello"},
+		{"stderr without color", errLine, logFormat{}, "boom"},
+		{"raw", errLine, logFormat{raw: true, timestamps: true, color: true}, "boom"},
+		{"multiline indented", multi, logFormat{timestamps: true, seq: true}, "[7] " + ts + "  Traceback (most recent call last):\n                    File \"main.py\", line 1\n                  ValueError"},
+		{"multiline without prefix", multi, logFormat{}, multi.Line},
+		{"multiline raw", multi, logFormat{raw: true, timestamps: true}, multi.Line},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formatLogLine(tc.line, tc.f); got != tc.want {
+				t.Fatalf("formatLogLine = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunsLogsStreamFilter(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	srv := newRunsServer(t)
+	t.Setenv(envServerURL, srv.URL)
+	t.Setenv(envAPIToken, "tok")
+
+	if err := run([]string{"runs", "logs", "--stream", "stderr", "--timestamps=false", "--seq", "7"}); err != nil {
+		t.Fatalf("runs logs: %v", err)
+	}
+	if stdout.String() != "[2] done\n" {
+		t.Fatalf("unexpected filtered logs %q", stdout.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "logs", "--stream", "stdout", "--raw", "7"}); err != nil {
+		t.Fatalf("runs logs --raw: %v", err)
+	}
+	if stdout.String() != "hello\tworld\n" {
+		t.Fatalf("unexpected raw logs %q", stdout.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "logs", "--stream", "stderr", "--porcelain", "7"}); err != nil {
+		t.Fatalf("runs logs --porcelain: %v", err)
+	}
+	if stdout.String() != "2\tstderr\t2026-01-02T03:04:08Z\tdone\n" {
+		t.Fatalf("unexpected filtered porcelain %q", stdout.String())
+	}
+
+	for _, args := range [][]string{
+		{"runs", "logs", "--stream", "both", "7"},
+		{"runs", "logs", "--raw", "--seq", "7"},
+	} {
+		var ee *exitError
+		if err := run(args); !errors.As(err, &ee) || ee.Code != 1 {
+			t.Fatalf("%v: expected a usage error, got %v", args, err)
+		}
+	}
+}
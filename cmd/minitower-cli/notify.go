@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notifyTimeout bounds a --notify-command hook; a hook still running then is
+// killed. A variable so tests can shorten it.
+var notifyTimeout = 30 * time.Second
+
+// notifyWaitDelay is how long a killed hook's children may keep its output
+// open before the hook is given up on.
+const notifyWaitDelay = time.Second
+
+// runNotifier tells the user that a watched run has finished: it rings the
+// terminal bell and runs the --notify-command hook. The zero value does
+// nothing.
+type runNotifier struct {
+	command string
+	bell    bool
+}
+
+// notifyFlags holds the flags shared by the commands that wait for a run.
+type notifyFlags struct {
+	command *string
+	bell    *bool
+}
+
+func addNotifyFlags(fs *flag.FlagSet) *notifyFlags {
+	return &notifyFlags{
+		command: fs.String("notify-command", "", "shell command to run when the run finishes, with MINITOWER_RUN_ID, MINITOWER_RUN_STATUS, MINITOWER_APP and MINITOWER_DURATION set"),
+		bell:    fs.Bool("bell", false, "ring the terminal bell when the run finishes"),
+	}
+}
+
+// set reports whether either flag was given.
+func (f *notifyFlags) set() bool {
+	return strings.TrimSpace(*f.command) != "" || *f.bell
+}
+
+func (f *notifyFlags) notifier() runNotifier {
+	return runNotifier{command: strings.TrimSpace(*f.command), bell: *f.bell}
+}
+
+// notify rings the bell and runs the hook for a run that has reached a
+// terminal status. A hook that fails or times out is reported on stderr; it
+// never changes the command's exit code.
+func (n runNotifier) notify(run runResponse) {
+	if n.bell {
+		// The bell goes to stderr so it never ends up in piped data.
+		fmt.Fprint(ui.errOut, "\a")
+	}
+	if n.command == "" {
+		return
+	}
+	if err := runNotifyCommand(n.command, notifyEnv(run), notifyTimeout); err != nil {
+		ui.warnf("notify command failed: %v\n", err)
+	}
+}
+
+// notifyEnv returns the variables describing run that the hook gets.
+// MINITOWER_DURATION is formatted as in the runs watch summary, e.g. 3m07s,
+// and is empty when the run's times are unknown.
+func notifyEnv(run runResponse) []string {
+	duration := ""
+	if d, ok := runElapsed(run); ok {
+		duration = formatElapsed(d)
+	}
+	return []string{
+		"MINITOWER_RUN_ID=" + strconv.FormatInt(run.RunID, 10),
+		"MINITOWER_RUN_STATUS=" + run.Status,
+		"MINITOWER_APP=" + run.AppSlug,
+		"MINITOWER_DURATION=" + duration,
+	}
+}
+
+// runNotifyCommand runs command with sh -c and env added to the CLI's own
+// environment. Run details reach the command only through env, never by
+// substitution into its text, so they need no quoting. The command's output
+// goes to stderr.
+func runNotifyCommand(command string, env []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = ui.errOut
+	cmd.Stderr = ui.errOut
+	cmd.WaitDelay = notifyWaitDelay
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
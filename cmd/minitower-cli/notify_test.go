@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func requireShell(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("notify hooks need sh")
+	}
+}
+
+// writeEnvHook is a --notify-command that writes the hook's run variables
+// to $HOOK_OUT.
+const writeEnvHook = `printf '%s|%s|%s|%s' "$MINITOWER_RUN_ID" "$MINITOWER_RUN_STATUS" "$MINITOWER_APP" "$MINITOWER_DURATION" > "$HOOK_OUT"`
+
+func TestRunsWatchNotifyCommand(t *testing.T) {
+	requireShell(t)
+	stdout, stderr := captureOutput(t)
+	out := filepath.Join(t.TempDir(), "hook.out")
+	t.Setenv("HOOK_OUT", out)
+
+	srv := newWatchServer(t)
+	err := run([]string{"runs", "watch", "--server", srv.URL, "--token", "tok", "--interval", "1ms", "--notify-command", writeEnvHook, "--bell", "9"})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read hook output: %v", err)
+	}
+	if string(got) != "9|completed||1m07s" {
+		t.Fatalf("unexpected hook environment %q", got)
+	}
+	if stderr.String() != "\a" || strings.Contains(stdout.String(), "\a") {
+		t.Fatalf("expected the bell on stderr only, got stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRunsCreateWaitNotifiesAndKeepsExitCode(t *testing.T) {
+	requireShell(t)
+	stdout, stderr := captureOutput(t)
+	out := filepath.Join(t.TempDir(), "hook.out")
+	t.Setenv("HOOK_OUT", out)
+
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/apps/hello/runs", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"run_id":12,"app_slug":"hello","run_no":3,"status":"queued","queued_at":"2026-01-02T03:04:00Z"}`))
+	})
+	mux.HandleFunc("/api/v1/runs/12", func(w http.ResponseWriter, _ *http.Request) {
+		polls++
+		if polls < 2 {
+			_, _ = w.Write([]byte(`{"run_id":12,"app_slug":"hello","status":"running","queued_at":"2026-01-02T03:04:00Z","started_at":"2026-01-02T03:04:01Z"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"run_id":12,"app_slug":"hello","status":"failed","queued_at":"2026-01-02T03:04:00Z","started_at":"2026-01-02T03:04:01Z","finished_at":"2026-01-02T03:04:43Z","exit_code":1}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	err := run([]string{"runs", "create", "--server", srv.URL, "--token", "tok", "--app", "hello", "--wait", "--interval", "1ms",
+		"--notify-command", writeEnvHook + "; exit 3"})
+	var exitErr *exitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected the failed run's exit code 1, got %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if string(got) != "12|failed|hello|42s" {
+		t.Fatalf("unexpected hook environment %q", got)
+	}
+	if want := "run 12 status: running\nrun 12 status: failed\n"; stdout.String() != want {
+		t.Fatalf("expected the status changes on stdout, got %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "Run #3 created") || !strings.Contains(stderr.String(), "notify command failed: exit status 3") {
+		t.Fatalf("expected the hook failure reported, got %q", stderr.String())
+	}
+
+	for _, args := range [][]string{
+		{"--notify-command", "true"},
+		{"--bell"},
+		{"--wait", "--dry-run"},
+	} {
+		err := run(append([]string{"runs", "create", "--server", srv.URL, "--token", "tok", "--app", "hello"}, args...))
+		if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+			t.Fatalf("%v: expected a usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestRunNotifyCommandTimeout(t *testing.T) {
+	requireShell(t)
+	captureOutput(t)
+
+	start := time.Now()
+	err := runNotifyCommand("sleep 5", nil, 100*time.Millisecond)
+	if err == nil || err.Error() != "timed out after 100ms" {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected the hook killed at the timeout, took %s", elapsed)
+	}
+
+	prev := notifyTimeout
+	notifyTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { notifyTimeout = prev })
+	_, stderr := captureOutput(t)
+	runNotifier{command: "sleep 5"}.notify(runResponse{RunID: 1, Status: "completed"})
+	if got := stderr.String(); got != "notify command failed: timed out after 100ms\n" {
+		t.Fatalf("expected the timeout reported, got %q", got)
+	}
+}
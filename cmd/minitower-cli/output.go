@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// Output formats for the profile "output" setting and --json/--table.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// outputWriter separates what a command prints. Data (tables, JSON, porcelain
+// lines, logs, file content) goes to out; informational messages go to errOut
+// and are dropped under --quiet, so stdout can always be piped.
+type outputWriter struct {
+	out    io.Writer
+	errOut io.Writer
+	quiet  bool
+	// jsonErrors makes reportError print a JSON error envelope. It is set
+	// when a command resolves to JSON output.
+	jsonErrors bool
+}
+
+// ui is the writer every command prints through. run sets quiet from the
+// global flag; tests swap in buffers.
+var ui = &outputWriter{out: os.Stdout, errOut: os.Stderr}
+
+// printf writes data to stdout.
+func (w *outputWriter) printf(format string, args ...any) {
+	fmt.Fprintf(w.out, format, args...)
+}
+
+// write copies raw data to stdout.
+func (w *outputWriter) write(p []byte) error {
+	_, err := w.out.Write(p)
+	return err
+}
+
+// json writes v to stdout as indented JSON.
+func (w *outputWriter) json(v any) error {
+	enc := json.NewEncoder(w.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// table returns a tabwriter on stdout; callers must Flush it.
+func (w *outputWriter) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(w.out, 0, 0, 2, ' ', 0)
+}
+
+// infof writes an informational message to stderr unless --quiet was given.
+func (w *outputWriter) infof(format string, args ...any) {
+	if w.quiet {
+		return
+	}
+	fmt.Fprintf(w.errOut, format, args...)
+}
+
+// warnf writes a warning to stderr regardless of --quiet.
+func (w *outputWriter) warnf(format string, args ...any) {
+	fmt.Fprintf(w.errOut, format, args...)
+}
+
+// reportError prints err to stderr and returns the process exit code. Under
+// JSON output the error is printed as a JSON envelope carrying the API
+// error code, so scripts can branch on it without parsing the message.
+func (w *outputWriter) reportError(err error) int {
+	ee, ok := err.(*exitError)
+	if !ok {
+		ee = &exitError{Code: 1, Message: err.Error()}
+	}
+	if ee.Message == "" {
+		return ee.Code
+	}
+	if !w.jsonErrors {
+		fmt.Fprintln(w.errOut, "error:", ee.Message)
+		if ee.Hint != "" {
+			fmt.Fprintln(w.errOut, "hint:", ee.Hint)
+		}
+		return ee.Code
+	}
+	var env struct {
+		Error struct {
+			Code      string `json:"code,omitempty"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id,omitempty"`
+			Hint      string `json:"hint,omitempty"`
+			ExitCode  int    `json:"exit_code"`
+		} `json:"error"`
+	}
+	env.Error.Code = ee.APICode
+	env.Error.Message = ee.Message
+	env.Error.RequestID = ee.RequestID
+	env.Error.Hint = ee.Hint
+	env.Error.ExitCode = ee.Code
+	_ = json.NewEncoder(w.errOut).Encode(env)
+	return ee.Code
+}
+
+// porcelainLine writes one tab-separated porcelain record. Tabs and newlines
+// inside fields are replaced with spaces so every record stays on one line.
+func (w *outputWriter) porcelainLine(fields ...string) {
+	for i, f := range fields {
+		fields[i] = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(f)
+	}
+	fmt.Fprintln(w.out, strings.Join(fields, "\t"))
+}
+
+// stdoutIsTerminal reports whether stdout is an interactive terminal. Tests
+// replace it to exercise terminal-only output.
+var stdoutIsTerminal = func() bool {
+	f, ok := ui.out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// statusLine is one line of stdout redrawn in place with carriage returns.
+// Anything else printed while it is drawn must be preceded by clear, or the
+// status text is left behind on the same row.
+type statusLine struct {
+	w     io.Writer
+	width int // Characters of the line currently drawn.
+}
+
+// draw replaces the current status text, padding over any longer previous one.
+func (s *statusLine) draw(text string) {
+	n := utf8.RuneCountInString(text)
+	fmt.Fprintf(s.w, "\r%s%s", text, strings.Repeat(" ", max(s.width-n, 0)))
+	s.width = max(s.width, n)
+}
+
+// clear blanks the status line and leaves the cursor at its start.
+func (s *statusLine) clear() {
+	if s.width == 0 {
+		return
+	}
+	fmt.Fprintf(s.w, "\r%s\r", strings.Repeat(" ", s.width))
+	s.width = 0
+}
+
+// formatFlags are a command's --json and --table flags.
+type formatFlags struct {
+	json  *bool
+	table *bool
+}
+
+func addFormatFlags(fs *flag.FlagSet) *formatFlags {
+	return &formatFlags{
+		json:  fs.Bool("json", false, "print JSON"),
+		table: fs.Bool("table", false, "print a table (overrides the profile output setting)"),
+	}
+}
+
+// resolve reports whether to print JSON: an explicit flag wins, then the
+// profile's output setting, then table.
+func (f *formatFlags) resolve(profileOutput string) (bool, error) {
+	if *f.json && *f.table {
+		return false, &exitError{Code: 1, Message: "--json and --table are mutually exclusive"}
+	}
+	jsonOut := profileOutput == formatJSON
+	if *f.json || *f.table {
+		jsonOut = *f.json
+	}
+	ui.jsonErrors = jsonOut
+	return jsonOut, nil
+}
+
+// validateOutputFormat checks a profile output setting.
+func validateOutputFormat(v string) error {
+	if v != formatTable && v != formatJSON {
+		return &exitError{Code: 1, Message: "--output must be table or json"}
+	}
+	return nil
+}
+
+// globalFlags are accepted before the command name.
+var globalFlags = []string{"--quiet", "--refresh-cache"}
+
+// parseGlobalFlags consumes leading global flags and returns the remaining
+// arguments.
+func parseGlobalFlags(args []string) []string {
+	for len(args) > 0 && isGlobalFlag(args[0]) {
+		switch args[0] {
+		case "--refresh-cache", "-refresh-cache":
+			refreshCache = true
+		default:
+			ui.quiet = true
+		}
+		args = args[1:]
+	}
+	return args
+}
+
+// isGlobalFlag reports whether word is one of the global flags.
+func isGlobalFlag(word string) bool {
+	switch word {
+	case "--quiet", "-quiet", "-q", "--refresh-cache", "-refresh-cache":
+		return true
+	}
+	return false
+}
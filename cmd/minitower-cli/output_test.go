@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureOutput routes ui to buffers and points the CLI at a fresh config
+// file for the duration of the test.
+func captureOutput(t *testing.T) (stdout, stderr *bytes.Buffer) {
+	t.Helper()
+	t.Setenv(envCLIConfig, filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv(envServerURL, "")
+	t.Setenv(envAPIToken, "")
+
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	prev := ui
+	ui = &outputWriter{out: stdout, errOut: stderr}
+	t.Cleanup(func() { ui = prev })
+	return stdout, stderr
+}
+
+func resetOutput(stdout, stderr *bytes.Buffer) {
+	stdout.Reset()
+	stderr.Reset()
+	ui.quiet = false
+	ui.jsonErrors = false
+	refreshCache = false
+}
+
+func newRunsServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	run := `{"run_id":7,"app_id":1,"app_slug":"hello","run_no":3,"version_no":2,"status":"completed","priority":5,"max_retries":3,"retry_count":1,"cancel_requested":false,"queued_at":"2026-01-02T03:04:05Z","started_at":"2026-01-02T03:04:06Z","finished_at":"2026-01-02T03:04:09Z","entrypoint":"main.py","input":{"n":1}}`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/runs", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"runs":[` + run + `,{"run_id":8,"app_id":1,"app_slug":"hello","run_no":4,"version_no":2,"status":"queued","priority":0,"max_retries":0,"retry_count":0,"cancel_requested":false,"queued_at":"2026-01-02T03:05:00Z"}]}`))
+	})
+	mux.HandleFunc("/api/v1/runs/7", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(run))
+	})
+	mux.HandleFunc("/api/v1/runs/7/logs", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"logs":[{"seq":1,"stream":"stdout","line":"hello\tworld","logged_at":"2026-01-02T03:04:07Z"},{"seq":2,"stream":"stderr","line":"done","logged_at":"2026-01-02T03:04:08Z"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestInformationalMessagesGoToStderr(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+
+	if err := run([]string{"config", "set", "--server", "http://example.invalid", "--token", "tok"}); err != nil {
+		t.Fatalf("config set: %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("expected nothing on stdout, got %q", stdout.String())
+	}
+	if stderr.String() != "Profile \"default\" updated\n" {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"--quiet", "config", "set", "--team", "acme"}); err != nil {
+		t.Fatalf("quiet config set: %v", err)
+	}
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Fatalf("expected no output with --quiet, got stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+
+	// Data still reaches stdout under --quiet.
+	resetOutput(stdout, stderr)
+	if err := run([]string{"--quiet", "config", "get"}); err != nil {
+		t.Fatalf("config get: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Team: acme\n") || stderr.Len() != 0 {
+		t.Fatalf("unexpected config get output: stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+}
+
+func TestOutputFormatFollowsProfile(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	srv := newRunsServer(t)
+
+	if err := run([]string{"config", "set", "--server", srv.URL, "--token", "tok"}); err != nil {
+		t.Fatalf("config set: %v", err)
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "get", "7"}); err != nil {
+		t.Fatalf("runs get: %v", err)
+	}
+	if !strings.HasPrefix(stdout.String(), "RUN_ID") {
+		t.Fatalf("expected a table by default, got %q", stdout.String())
+	}
+
+	if err := run([]string{"config", "set", "--output", "json"}); err != nil {
+		t.Fatalf("config set output: %v", err)
+	}
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "get", "7"}); err != nil {
+		t.Fatalf("runs get: %v", err)
+	}
+	var got runResponse
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil || got.RunID != 7 {
+		t.Fatalf("expected JSON from profile setting, got %q (%v)", stdout.String(), err)
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "get", "--table", "7"}); err != nil {
+		t.Fatalf("runs get --table: %v", err)
+	}
+	if !strings.HasPrefix(stdout.String(), "RUN_ID") {
+		t.Fatalf("expected --table to override the profile, got %q", stdout.String())
+	}
+
+	err := run([]string{"runs", "get", "--table", "--json", "7"})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Message != "--json and --table are mutually exclusive" {
+		t.Fatalf("expected conflicting flags error, got %v", err)
+	}
+
+	err = run([]string{"config", "set", "--output", "yaml"})
+	if !errors.As(err, &ee) || ee.Message != "--output must be table or json" {
+		t.Fatalf("expected invalid output error, got %v", err)
+	}
+}
+
+func TestRunsPorcelainFormat(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	srv := newRunsServer(t)
+	if err := run([]string{"config", "set", "--server", srv.URL, "--token", "tok", "--output", "json"}); err != nil {
+		t.Fatalf("config set: %v", err)
+	}
+
+	// --porcelain wins over the profile's json preference.
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "list", "--porcelain"}); err != nil {
+		t.Fatalf("runs list: %v", err)
+	}
+	want := "7\t3\thello\tcompleted\t2\t5\t1\t2026-01-02T03:04:05Z\t2026-01-02T03:04:06Z\t2026-01-02T03:04:09Z\n" +
+		"8\t4\thello\tqueued\t2\t0\t0\t2026-01-02T03:05:00Z\t\t\n"
+	if stdout.String() != want {
+		t.Fatalf("unexpected runs list porcelain:\n%q\nwant\n%q", stdout.String(), want)
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "get", "--porcelain", "7"}); err != nil {
+		t.Fatalf("runs get: %v", err)
+	}
+	if !strings.HasPrefix(want, stdout.String()) || strings.Count(stdout.String(), "\n") != 1 {
+		t.Fatalf("unexpected runs get porcelain %q", stdout.String())
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "logs", "--porcelain", "7"}); err != nil {
+		t.Fatalf("runs logs: %v", err)
+	}
+	wantLogs := "1\tstdout\t2026-01-02T03:04:07Z\thello world\n2\tstderr\t2026-01-02T03:04:08Z\tdone\n"
+	if stdout.String() != wantLogs || stderr.Len() != 0 {
+		t.Fatalf("unexpected logs porcelain %q (stderr %q)", stdout.String(), stderr.String())
+	}
+
+	err := run([]string{"runs", "list", "--porcelain", "--json"})
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Message != "--porcelain cannot be combined with --json" {
+		t.Fatalf("expected porcelain/json conflict, got %v", err)
+	}
+}
+
+func TestRunsColumnsSelection(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	srv := newRunsServer(t)
+	if err := run([]string{"config", "set", "--server", srv.URL, "--token", "tok"}); err != nil {
+		t.Fatalf("config set: %v", err)
+	}
+
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "list", "--porcelain", "--columns", "run_id,entrypoint,input,duration,finished_at"}); err != nil {
+		t.Fatalf("runs list: %v", err)
+	}
+	want := "7\tmain.py\t{\"n\":1}\t3s\t2026-01-02T03:04:09Z\n" +
+		"8\t\t\t\t\n"
+	if stdout.String() != want {
+		t.Fatalf("unexpected columns porcelain:\n%q\nwant\n%q", stdout.String(), want)
+	}
+
+	// Columns print in the order given, and empty cells show as "-".
+	resetOutput(stdout, stderr)
+	if err := run([]string{"runs", "get", "--columns", "status, RUN_ID,retry_count,retries,exit_code", "7"}); err != nil {
+		t.Fatalf("runs get: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[0]), " ") != "STATUS RUN_ID RETRY_COUNT RETRIES EXIT_CODE" ||
+		strings.Join(strings.Fields(lines[1]), " ") != "completed 7 1 1/3 -" {
+		t.Fatalf("unexpected columns table %q", stdout.String())
+	}
+
+	err := run([]string{"runs", "list", "--columns", "run_id,bogus"})
+	var ee *exitError
+	if !errors.As(err, &ee) || !strings.HasPrefix(ee.Message, `unknown runs column "bogus" (valid: run_id, run_no, app, status,`) {
+		t.Fatalf("expected unknown column error, got %v", err)
+	}
+	// Columns are checked before any request is made.
+	err = run([]string{"runners", "list", "--server", "http://example.invalid", "--columns", "cpu,input"})
+	if !errors.As(err, &ee) || !strings.HasPrefix(ee.Message, `unknown runners column "input" (valid: runner_id,`) {
+		t.Fatalf("expected unknown runners column error, got %v", err)
+	}
+}
+
+func TestRunsReasonColumn(t *testing.T) {
+	var reason func(runResponse) string
+	for _, c := range runColumns.columns {
+		if c.name == "reason" {
+			reason = c.value
+		}
+	}
+	for _, tc := range []struct {
+		run  runResponse
+		want string
+	}{
+		{runResponse{Status: "dead", DeadReason: "max_retries_exceeded", FailureKind: "infrastructure"}, "max_retries_exceeded"},
+		{runResponse{Status: "failed", FailureKind: "user"}, "user"},
+		{runResponse{Status: "failed"}, ""},
+		{runResponse{Status: "completed", DeadReason: "ignored"}, ""},
+	} {
+		if got := reason(tc.run); got != tc.want {
+			t.Fatalf("%s run: expected reason %q, got %q", tc.run.Status, tc.want, got)
+		}
+	}
+}
@@ -166,5 +166,8 @@ func resolveConnection(profileName, serverOverride, tokenOverride string, requir
 		Token:       token,
 		Team:        team,
 		DefaultApp:  defaultApp,
+		Output:      strings.TrimSpace(p.Output),
+		CACert:      strings.TrimSpace(p.CACert),
+		Insecure:    p.InsecureSkipVerify,
 	}, nil
 }
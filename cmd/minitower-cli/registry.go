@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// command is a node in the CLI command tree. Groups have subcommands and
+// leaves have run. The dispatcher, root usage, and shell completion all read
+// the same tree, so a command only needs to be registered once.
+type command struct {
+	name    string
+	aliases []string
+	summary string
+	// args is the positional argument synopsis shown in usage.
+	args string
+	// flags lists the flag names a leaf accepts, without dashes. A trailing
+	// "=" marks a flag that takes a value.
+	flags []string
+	run   func(args []string) error
+	// complete returns candidates for positional arguments.
+	complete func(cc *completionContext) []string
+	// flagValues completes flag values for this command, taking precedence
+	// over flagValueCompleters.
+	flagValues  map[string]func(cc *completionContext) []string
+	subcommands []*command
+	hidden      bool
+}
+
+var (
+	connFlags    = []string{"server=", "token=", "profile="}
+	runStatus    = []string{"queued", "leased", "running", "cancelling", "completed", "failed", "cancelled", "dead"}
+	runnerStatus = []string{"online", "offline"}
+)
+
+func withConnFlags(extra ...string) []string {
+	flags := append([]string{}, connFlags...)
+	return append(flags, extra...)
+}
+
+// commandTree builds the full CLI command tree.
+func commandTree() *command {
+	return &command{
+		name: "minitower-cli",
+		subcommands: []*command{
+			{name: "login", summary: "login with team credentials", flags: []string{"server=", "team=", "password=", "profile=", "json", "table"}, run: cmdLogin},
+			{name: "config", summary: "manage local profiles", subcommands: []*command{
+				{name: "set", flags: []string{"profile=", "server=", "token=", "team=", "app=", "output=", "ca-cert=", "insecure", "json", "table"}, run: cmdConfigSet},
+				{name: "get", flags: []string{"profile=", "json", "table"}, run: cmdConfigGet},
+				{name: "list", aliases: []string{"ls"}, flags: []string{"json", "table"}, run: cmdConfigList},
+				{name: "use", run: cmdConfigUse, complete: completeProfileNames},
+				{name: "test", flags: withConnFlags("json", "table"), run: cmdConfigTest},
+			}},
+			{name: "me", summary: "show current identity", flags: withConnFlags("cached", "json", "table"), run: cmdMe},
+			{name: "apps", summary: "manage apps", subcommands: []*command{
+				{name: "list", aliases: []string{"ls"}, flags: withConnFlags("stats", "cached", "columns=", "json", "table"), run: cmdAppsList},
+				{name: "get", flags: withConnFlags("json", "table"), run: cmdAppsGet, complete: completeAppSlugs},
+				{name: "create", flags: withConnFlags("slug=", "description=", "json", "table"), run: cmdAppsCreate},
+				{name: "errors", args: "<app>", flags: withConnFlags("window=", "limit=", "json", "table"), run: cmdAppsErrors, complete: completeAppSlugs},
+			}},
+			{name: "versions", summary: "manage versions", subcommands: []*command{
+				{name: "list", aliases: []string{"ls"}, flags: withConnFlags("app=", "columns=", "json", "table"), run: cmdVersionsList},
+				{name: "get", flags: withConnFlags("app=", "json", "table"), run: cmdVersionsGet},
+				{name: "schema", args: "[version-no|label]", flags: withConnFlags("app="), run: cmdVersionsSchema},
+				{name: "upload", flags: withConnFlags("app=", "file=", "expected-sha256=", "json", "table"), run: cmdVersionsUpload},
+				{name: "files", args: "<version-no>", flags: withConnFlags("app=", "json", "table"), run: cmdVersionsFiles},
+				{name: "cat", args: "<version-no> <path>", flags: withConnFlags("app="), run: cmdVersionsCat},
+				{name: "label", args: "<version-no> <label>", flags: withConnFlags("app=", "json", "table"), run: cmdVersionsLabel},
+				{name: "promote", args: "<version-no>", flags: withConnFlags("app=", "to=", "json", "table"), run: cmdVersionsPromote},
+				{name: "stats", args: "<version-no>", flags: withConnFlags("app=", "json", "table"), run: cmdVersionsStats},
+			}},
+			{name: "runs", summary: "manage runs", subcommands: []*command{
+				{name: "create", flags: withConnFlags("app=", "input=", "set=", "version=", "priority=", "max-retries=", "at-most-once", "dry-run", "no-coerce", "verbose", "command=", "with-status-token", "wait", "interval=", "notify-command=", "bell", "json", "table"), run: cmdRunsCreate},
+				{name: "create-batch", flags: withConnFlags("app=", "input-file=", "version=", "priority=", "max-retries=", "json", "table"), run: cmdRunsCreateBatch},
+				{name: "list", aliases: []string{"ls"}, flags: withConnFlags("app=", "status=", "input=", "version=", "runner=", "since=", "include=", "limit=", "offset=", "porcelain", "cached", "columns=", "json", "table"), run: cmdRunsList,
+					flagValues: map[string]func(*completionContext) []string{
+						"include": func(*completionContext) []string { return []string{"runner", "input"} },
+					}},
+				{name: "get", flags: withConnFlags("porcelain", "columns=", "json", "table"), run: cmdRunsGet},
+				{name: "cancel", flags: withConnFlags("all", "app=", "status=", "created-before=", "yes", "json", "table"), run: cmdRunsCancel,
+					flagValues: map[string]func(*completionContext) []string{
+						"status": func(*completionContext) []string { return []string{"queued", "running", "all-nonterminal"} },
+					}},
+				{name: "retry", flags: withConnFlags("json", "table"), run: cmdRunsRetry},
+				{name: "rerun", args: "<run-id>", flags: withConnFlags("set=", "version=", "json", "table"), run: cmdRunsRerun},
+				{name: "priority", flags: withConnFlags("json", "table"), run: cmdRunsPriority},
+				{name: "watch", flags: withConnFlags("app=", "status-only", "interval=", "timestamps", "stream=", "seq", "raw", "json", "notify-command=", "bell"), run: cmdRunsWatch,
+					flagValues: map[string]func(*completionContext) []string{"stream": completeLogStreams}},
+				{name: "logs", flags: withConnFlags("follow", "interval=", "after-seq=", "porcelain", "timestamps", "stream=", "seq", "raw", "json", "table"), run: cmdRunsLogs,
+					flagValues: map[string]func(*completionContext) []string{"stream": completeLogStreams}},
+				{name: "tail", flags: withConnFlags("app=", "interval=", "until-idle", "timestamps", "stream=", "seq", "raw"), run: cmdRunsTail,
+					flagValues: map[string]func(*completionContext) []string{"stream": completeLogStreams}},
+				{name: "diff", args: "<run-a> <run-b>", flags: withConnFlags("logs", "log-lines=", "json", "table"), run: cmdRunsDiff},
+				{name: "events", args: "<run-id>", flags: withConnFlags("json", "table"), run: cmdRunsEvents},
+				{name: "attempts", args: "<run-id>", flags: withConnFlags("json", "table"), run: cmdRunsAttempts},
+				{name: "outputs", args: "<run-id> | download <run-id> <name>", flags: withConnFlags("out=", "json", "table"), run: cmdRunsOutputs},
+			}},
+			{name: "batches", summary: "track and cancel run batches", subcommands: []*command{
+				{name: "get", args: "<batch-id>", flags: withConnFlags("json", "table"), run: cmdBatchesGet},
+				{name: "cancel", args: "<batch-id>", flags: withConnFlags("json", "table"), run: cmdBatchesCancel},
+				{name: "watch", args: "<batch-id>", flags: withConnFlags("interval=", "json"), run: cmdBatchesWatch},
+			}},
+			{name: "tokens", summary: "manage tokens (list/revoke pending API)", subcommands: []*command{
+				{name: "create", flags: withConnFlags("name=", "role=", "scope=", "app=", "json", "table"), run: cmdTokensCreate},
+				{name: "list", aliases: []string{"ls"}, run: tokensPending("list")},
+				{name: "revoke", run: tokensPending("revoke")},
+			}},
+			{name: "envs", summary: "manage environments", subcommands: []*command{
+				{name: "list", aliases: []string{"ls"}, flags: withConnFlags("json", "table"), run: cmdEnvsList},
+				{name: "create", args: "<name>", flags: withConnFlags("json", "table"), run: cmdEnvsCreate},
+				{name: "delete", args: "<name>", flags: withConnFlags(), run: cmdEnvsDelete},
+			}},
+			{name: "runners", summary: "manage runners (admin)", subcommands: []*command{
+				{name: "list", aliases: []string{"ls"}, flags: withConnFlags("status=", "env=", "name-prefix=", "stale-for=", "limit=", "offset=", "columns=", "json", "table"), run: cmdRunnersList,
+					flagValues: map[string]func(*completionContext) []string{
+						"status": func(*completionContext) []string { return runnerStatus },
+					}},
+				{name: "register", args: "<name>", flags: withConnFlags("environment=", "json", "table"), run: cmdRunnersRegister},
+				{name: "rotate-token", args: "<runner-id>", flags: withConnFlags("json", "table"), run: cmdRunnersRotateToken},
+			}},
+			{name: "admin", summary: "server overview and backups (admin)", subcommands: []*command{
+				{name: "overview", flags: withConnFlags("json", "table"), run: cmdAdminOverview},
+				{name: "backup", flags: withConnFlags("json", "table"), run: cmdAdminBackup},
+			}},
+			{name: "reports", summary: "usage reports", subcommands: []*command{
+				{name: "usage", flags: withConnFlags("from=", "to=", "group-by=", "csv", "json", "table"), run: cmdReportsUsage},
+			}},
+			{name: "audit", summary: "team audit log", subcommands: []*command{
+				{name: "list", aliases: []string{"ls"}, flags: withConnFlags("since=", "until=", "action=", "token-id=", "limit=", "offset=", "json", "table"), run: cmdAuditList},
+			}},
+			{name: "deploy", summary: "deploy from Towerfile", flags: withConnFlags("dir=", "skip-unchanged", "force", "plan", "fail-on-incompatible", "json", "table"), run: cmdDeploy},
+			{name: "completion", summary: "print a shell completion script", args: "<bash|zsh|fish>", run: cmdCompletion, complete: completeShells},
+			{name: completeCommandName, hidden: true, run: cmdComplete},
+		},
+	}
+}
+
+func tokensPending(sub string) func([]string) error {
+	return func([]string) error {
+		return &exitError{Code: 1, Message: fmt.Sprintf("tokens %s is not available yet (API endpoint not implemented)", sub)}
+	}
+}
+
+func run(args []string) error {
+	root := commandTree()
+	args = parseGlobalFlags(args)
+	if len(args) == 0 {
+		printRootUsage(ui.errOut, root)
+		return &exitError{Code: 1}
+	}
+	switch args[0] {
+	case "-h", "--help", "help":
+		printRootUsage(ui.out, root)
+		return nil
+	}
+
+	cmd := root.lookup(args[0])
+	if cmd == nil {
+		printRootUsage(ui.errOut, root)
+		return &exitError{Code: 1, Message: fmt.Sprintf("unknown command: %s", args[0])}
+	}
+	return cmd.dispatch(args[1:])
+}
+
+// dispatch runs a leaf command or resolves the next subcommand of a group.
+func (c *command) dispatch(args []string) error {
+	if c.run != nil {
+		return c.run(args)
+	}
+	if len(args) == 0 {
+		return &exitError{Code: 1, Message: fmt.Sprintf("usage: minitower-cli %s %s ...", c.name, c.usageArgs())}
+	}
+	sub := c.lookup(args[0])
+	if sub == nil {
+		return &exitError{Code: 1, Message: fmt.Sprintf("unknown %s subcommand: %s", c.name, args[0])}
+	}
+	return sub.dispatch(args[1:])
+}
+
+// lookup returns the subcommand matching name or one of its aliases.
+func (c *command) lookup(name string) *command {
+	for _, sub := range c.subcommands {
+		if sub.name == name {
+			return sub
+		}
+		for _, alias := range sub.aliases {
+			if alias == name {
+				return sub
+			}
+		}
+	}
+	return nil
+}
+
+// usageArgs renders the subcommand names as "<a|b|c>".
+func (c *command) usageArgs() string {
+	var names []string
+	for _, sub := range c.subcommands {
+		if !sub.hidden {
+			names = append(names, sub.name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return "<" + strings.Join(names, "|") + ">"
+}
+
+func printRootUsage(w io.Writer, root *command) {
+	fmt.Fprintln(w, "usage: minitower-cli [--quiet] [--refresh-cache] <command> [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range root.subcommands {
+		if c.hidden {
+			continue
+		}
+		label := c.name
+		if args := c.usageArgs(); args != "" {
+			label += " " + args
+		} else if c.args != "" {
+			label += " " + c.args
+		}
+		fmt.Fprintf(w, "  %-33s %s\n", label, c.summary)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDispatchRunsLeafWithRemainingArgs(t *testing.T) {
+	var got []string
+	root := &command{name: "minitower-cli", subcommands: []*command{
+		{name: "runs", subcommands: []*command{
+			{name: "list", aliases: []string{"ls"}, run: func(args []string) error {
+				got = args
+				return nil
+			}},
+		}},
+	}}
+
+	if err := root.lookup("runs").dispatch([]string{"ls", "--status", "failed"}); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	if strings.Join(got, " ") != "--status failed" {
+		t.Fatalf("unexpected args: %v", got)
+	}
+
+	err := root.lookup("runs").dispatch(nil)
+	var ee *exitError
+	if !errors.As(err, &ee) || ee.Message != "usage: minitower-cli runs <list> ..." {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+	err = root.lookup("runs").dispatch([]string{"nope"})
+	if !errors.As(err, &ee) || ee.Message != "unknown runs subcommand: nope" {
+		t.Fatalf("expected unknown subcommand error, got %v", err)
+	}
+}
+
+func TestCommandTreeAliases(t *testing.T) {
+	root := commandTree()
+	cases := []struct {
+		path []string
+		want string
+	}{
+		{[]string{"runs", "ls"}, "list"},
+		{[]string{"apps", "ls"}, "list"},
+		{[]string{"runs", "cancel"}, "cancel"},
+	}
+	for _, tc := range cases {
+		cmd := root
+		for _, name := range tc.path {
+			cmd = cmd.lookup(name)
+			if cmd == nil {
+				t.Fatalf("%v: %q not found", tc.path, name)
+			}
+		}
+		if cmd.name != tc.want || cmd.run == nil {
+			t.Fatalf("%v: resolved to %q", tc.path, cmd.name)
+		}
+	}
+	if root.lookup("ls") != nil {
+		t.Fatal("aliases must not leak to the root")
+	}
+}
+
+// TestCommandTreeFlagsMatchFlagSets keeps the registry's flag lists, which
+// drive completion, in sync with the flags each command actually parses.
+func TestCommandTreeFlagsMatchFlagSets(t *testing.T) {
+	var buf bytes.Buffer
+	prev := flagOutput
+	flagOutput = &buf
+	defer func() { flagOutput = prev }()
+
+	flagLine := regexp.MustCompile(`^  -(\S+)( \S+)?$`)
+	var walk func(prefix string, c *command)
+	walk = func(prefix string, c *command) {
+		for _, sub := range c.subcommands {
+			name := strings.TrimSpace(prefix + " " + sub.name)
+			if sub.run == nil {
+				walk(name, sub)
+				continue
+			}
+			if sub.hidden {
+				continue
+			}
+
+			buf.Reset()
+			_ = sub.run([]string{"-h"})
+			var got []string
+			for _, line := range strings.Split(buf.String(), "\n") {
+				m := flagLine.FindStringSubmatch(line)
+				if m == nil {
+					continue
+				}
+				if m[2] != "" {
+					got = append(got, m[1]+"=")
+				} else {
+					got = append(got, m[1])
+				}
+			}
+			want := append([]string{}, sub.flags...)
+			sort.Strings(got)
+			sort.Strings(want)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("%s: registry flags %v, flag set has %v", name, want, got)
+			}
+		}
+	}
+	walk("", commandTree())
+}
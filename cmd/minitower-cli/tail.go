@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// tailListLimit is how many of the app's most recent runs runs tail looks
+// through for active ones on each refresh.
+const tailListLimit = "100"
+
+func cmdRunsTail(args []string) error {
+	fs := newFlagSet("runs tail")
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	profileName := fs.String("profile", "", "profile name")
+	appFlag := fs.String("app", "", "app slug")
+	interval := fs.Duration("interval", 2*time.Second, "poll interval")
+	untilIdle := fs.Bool("until-idle", false, "exit once the app has no active runs")
+	logFlags := addLogFormatFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return &exitError{Code: 1, Message: err.Error()}
+	}
+	if err := ensureNoExtraArgs(fs); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return &exitError{Code: 1, Message: "--interval must be > 0"}
+	}
+	logFmt, err := logFlags.resolve()
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := resolveCommandConnection(*profileName, *server, *token, true)
+	if err != nil {
+		return err
+	}
+	app, err := defaultAppOrFlag(*appFlag, conn.DefaultApp)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	t := &runTailer{client: client, app: app, interval: *interval, untilIdle: *untilIdle, logFmt: logFmt}
+	return t.tail(ctx)
+}
+
+// runTailer follows the logs of every active run of an app at once, one
+// goroutine per run, and starts following runs created while it tails.
+type runTailer struct {
+	client    *apiClient
+	app       string
+	interval  time.Duration
+	untilIdle bool
+	logFmt    logFormat
+
+	// mu keeps lines from different runs from interleaving mid-batch.
+	mu sync.Mutex
+}
+
+// tail returns when ctx is cancelled, when a run's logs or status cannot be
+// fetched, or with untilIdle once no run is active.
+func (t *runTailer) tail(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error)
+	followed := make(map[int64]bool)
+	active := 0
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		runs, err := t.activeRuns(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return mapError(err)
+		}
+		for _, run := range runs {
+			if followed[run.RunID] {
+				continue
+			}
+			followed[run.RunID] = true
+			active++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := t.follow(ctx, run)
+				select {
+				case done <- err:
+				case <-ctx.Done():
+				}
+			}()
+		}
+		if active == 0 {
+			if t.untilIdle {
+				if first {
+					t.infof("No active runs for app %s\n", t.app)
+				}
+				return nil
+			}
+			if first {
+				t.infof("Waiting for runs of app %s...\n", t.app)
+			}
+		}
+
+		// Wait for the next refresh. With --until-idle, the last run
+		// finishing triggers one early, to catch runs created since.
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-done:
+				active--
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return mapError(err)
+				}
+				waiting = !t.untilIdle || active > 0
+			case <-ticker.C:
+				waiting = false
+			}
+		}
+	}
+}
+
+// activeRuns lists the app's non-terminal runs, oldest first.
+func (t *runTailer) activeRuns(ctx context.Context) ([]runResponse, error) {
+	listPath, err := withQuery("/api/v1/apps/"+url.PathEscape(t.app)+"/runs", map[string]string{"limit": tailListLimit})
+	if err != nil {
+		return nil, err
+	}
+	var resp listRunsResponse
+	if err := t.client.doJSON(ctx, http.MethodGet, listPath, nil, &resp); err != nil {
+		return nil, err
+	}
+	var runs []runResponse
+	for i := len(resp.Runs) - 1; i >= 0; i-- {
+		if !isTerminalRunStatus(resp.Runs[i].Status) {
+			runs = append(runs, resp.Runs[i])
+		}
+	}
+	return runs, nil
+}
+
+// follow prints the run's logs until it reaches a terminal status, then
+// prints any lines logged before it finished and a closing status line.
+func (t *runTailer) follow(ctx context.Context, run runResponse) error {
+	var afterSeq int64
+	printNew := func() error {
+		logs, err := fetchRunLogs(ctx, t.client, run.RunID, afterSeq)
+		if err != nil {
+			return err
+		}
+		if len(logs) > 0 {
+			t.printLogs(run.RunNo, logs)
+			afterSeq = logs[len(logs)-1].Seq
+		}
+		return nil
+	}
+
+	for {
+		if err := printNew(); err != nil {
+			return err
+		}
+		var current runResponse
+		if err := t.client.doJSON(ctx, http.MethodGet, fmt.Sprintf("/api/v1/runs/%d", run.RunID), nil, &current); err != nil {
+			return err
+		}
+		if isTerminalRunStatus(current.Status) {
+			if err := printNew(); err != nil {
+				return err
+			}
+			t.infof("run#%d %s\n", run.RunNo, current.Status)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.interval):
+		}
+	}
+}
+
+// printLogs prints a batch of one run's lines together, each prefixed with
+// the run number, e.g. "run#12  03:04:07.250  hello".
+func (t *runTailer) printLogs(runNo int64, logs []runLogEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, l := range t.logFmt.filterLogs(logs) {
+		prefix := fmt.Sprintf("run#%d  ", runNo)
+		ui.printf("%s%s\n", prefix, indentContinuation(formatLogLine(l, t.logFmt), len(prefix)))
+	}
+}
+
+func (t *runTailer) infof(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ui.infof(format, args...)
+}
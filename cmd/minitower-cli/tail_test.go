@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// tailScript is a fake server for runs tail: each run hands out one log
+// line per request and completes once all are read, and later runs only
+// show up in the app's run list after some number of list requests.
+type tailScript struct {
+	mu    sync.Mutex
+	lists int
+	runs  []*tailScriptRun
+}
+
+type tailScriptRun struct {
+	id, no    int64
+	lines     []string
+	delivered int
+	// listedAfter is how many list requests pass before the run exists.
+	listedAfter int
+}
+
+func (s *tailScriptRun) json() map[string]any {
+	status := "running"
+	if s.delivered == len(s.lines) {
+		status = "completed"
+	}
+	return map[string]any{"run_id": s.id, "app_id": 1, "run_no": s.no, "status": status, "queued_at": "2026-01-02T03:04:05Z"}
+}
+
+func (s *tailScript) server(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.URL.Path == "/api/v1/apps/hello/runs" {
+			s.lists++
+			runs := []map[string]any{}
+			for i := len(s.runs) - 1; i >= 0; i-- {
+				if s.lists > s.runs[i].listedAfter {
+					runs = append(runs, s.runs[i].json())
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"runs": runs})
+			return
+		}
+		for _, run := range s.runs {
+			base := fmt.Sprintf("/api/v1/runs/%d", run.id)
+			switch r.URL.Path {
+			case base:
+				_ = json.NewEncoder(w).Encode(run.json())
+				return
+			case base + "/logs":
+				after, _ := strconv.Atoi(r.URL.Query().Get("after_seq"))
+				logs := []map[string]any{}
+				if after < len(run.lines) {
+					logs = append(logs, map[string]any{"seq": after + 1, "stream": "stdout", "line": run.lines[after], "logged_at": "2026-01-02T03:04:07Z"})
+					run.delivered = max(run.delivered, after+1)
+				}
+				_ = json.NewEncoder(w).Encode(map[string]any{"logs": logs})
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunsTailFollowsActiveAndNewRuns(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	script := &tailScript{runs: []*tailScriptRun{
+		{id: 10, no: 1, lines: []string{"a1", "a2", "a3", "a4"}},
+		{id: 11, no: 2, lines: []string{"b1", "b2"}, listedAfter: 2},
+	}}
+	srv := script.server(t)
+	t.Setenv(envServerURL, srv.URL)
+	t.Setenv(envAPIToken, "tok")
+
+	if err := run([]string{"runs", "tail", "--app", "hello", "--interval", "5ms", "--until-idle", "--timestamps=false"}); err != nil {
+		t.Fatalf("runs tail: %v", err)
+	}
+
+	got := map[string][]string{}
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		prefix, text, ok := strings.Cut(line, "  ")
+		if !ok {
+			t.Fatalf("unprefixed line %q", line)
+		}
+		got[prefix] = append(got[prefix], text)
+	}
+	if strings.Join(got["run#1"], ",") != "a1,a2,a3,a4" || strings.Join(got["run#2"], ",") != "b1,b2" || len(got) != 2 {
+		t.Fatalf("unexpected tailed lines %v from %q", got, stdout.String())
+	}
+	for _, want := range []string{"run#1 completed", "run#2 completed"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Fatalf("expected %q on stderr, got %q", want, stderr.String())
+		}
+	}
+}
+
+func TestRunsTailUntilIdleWithNoActiveRuns(t *testing.T) {
+	stdout, stderr := captureOutput(t)
+	script := &tailScript{runs: []*tailScriptRun{{id: 10, no: 1}}}
+	srv := script.server(t)
+	t.Setenv(envServerURL, srv.URL)
+	t.Setenv(envAPIToken, "tok")
+
+	if err := run([]string{"runs", "tail", "--app", "hello", "--interval", "5ms", "--until-idle"}); err != nil {
+		t.Fatalf("runs tail: %v", err)
+	}
+	if stdout.Len() != 0 || !strings.Contains(stderr.String(), "No active runs for app hello") {
+		t.Fatalf("unexpected output %q / %q", stdout.String(), stderr.String())
+	}
+	if script.lists != 1 {
+		t.Fatalf("expected one list request, got %d", script.lists)
+	}
+}
@@ -3,6 +3,11 @@ package main
 type exitError struct {
 	Code    int
 	Message string
+	// APICode and RequestID are set for errors returned by the API.
+	APICode   string
+	RequestID string
+	// Hint is printed after the error to suggest a fix.
+	Hint string
 }
 
 func (e *exitError) Error() string {
@@ -11,11 +16,19 @@ func (e *exitError) Error() string {
 
 type errorEnvelope struct {
 	Error struct {
-		Code    string `json:"code"`
-		Message string `json:"message"`
+		Code      string         `json:"code"`
+		Message   string         `json:"message"`
+		RequestID string         `json:"request_id"`
+		Items     []apiErrorItem `json:"items"`
 	} `json:"error"`
 }
 
+// apiErrorItem is a per-item failure, e.g. one rejected batch input.
+type apiErrorItem struct {
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
 type loginResponse struct {
 	TeamID  int64  `json:"team_id"`
 	Token   string `json:"token"`
@@ -24,19 +37,31 @@ type loginResponse struct {
 }
 
 type meResponse struct {
-	TeamID   int64  `json:"team_id"`
-	TeamSlug string `json:"team_slug"`
-	TokenID  int64  `json:"token_id"`
-	Role     string `json:"role"`
+	TeamID   int64    `json:"team_id"`
+	TeamSlug string   `json:"team_slug"`
+	TokenID  int64    `json:"token_id"`
+	Role     string   `json:"role"`
+	Scopes   []string `json:"scopes,omitempty"`
+	AppSlug  string   `json:"app_slug,omitempty"`
 }
 
 type appResponse struct {
-	AppID       int64   `json:"app_id"`
-	Slug        string  `json:"slug"`
-	Description *string `json:"description,omitempty"`
-	Disabled    bool    `json:"disabled"`
-	CreatedAt   string  `json:"created_at"`
-	UpdatedAt   string  `json:"updated_at"`
+	AppID        int64          `json:"app_id"`
+	Slug         string         `json:"slug"`
+	Description  *string        `json:"description,omitempty"`
+	Disabled     bool           `json:"disabled"`
+	DefaultInput map[string]any `json:"default_input,omitempty"`
+	CreatedAt    string         `json:"created_at"`
+	UpdatedAt    string         `json:"updated_at"`
+	Stats        *appStats      `json:"stats,omitempty"`
+}
+
+type appStats struct {
+	LatestVersionNo *int64   `json:"latest_version_no"`
+	TotalRuns       int64    `json:"total_runs"`
+	LastRunStatus   *string  `json:"last_run_status"`
+	LastRunAt       *string  `json:"last_run_at"`
+	SuccessRate     *float64 `json:"success_rate"`
 }
 
 type listAppsResponse struct {
@@ -44,15 +69,48 @@ type listAppsResponse struct {
 }
 
 type versionResponse struct {
-	VersionID      int64          `json:"version_id"`
-	VersionNo      int64          `json:"version_no"`
-	Entrypoint     string         `json:"entrypoint"`
+	VersionID              int64            `json:"version_id"`
+	VersionNo              int64            `json:"version_no"`
+	Entrypoint             string           `json:"entrypoint"`
+	TimeoutSeconds         *int             `json:"timeout_seconds,omitempty"`
+	ParamsSchema           map[string]any   `json:"params_schema,omitempty"`
+	ArtifactSHA256         string           `json:"artifact_sha256"`
+	ArtifactSizeBytes      *int64           `json:"artifact_size_bytes,omitempty"`
+	TowerfileTOML          *string          `json:"towerfile_toml,omitempty"`
+	ImportPaths            []string         `json:"import_paths,omitempty"`
+	TowerfileSchemaVersion int              `json:"towerfile_schema_version,omitempty"`
+	Labels                 []string         `json:"labels,omitempty"`
+	Commands               []versionCommand `json:"commands,omitempty"`
+	DefaultInput           map[string]any   `json:"default_input,omitempty"`
+	// Parameters and CompatibilityWarnings are only sent in the upload
+	// response.
+	Parameters            []versionParameter     `json:"parameters,omitempty"`
+	CompatibilityWarnings []compatibilityWarning `json:"compatibility_warnings,omitempty"`
+	PromotedFrom          *versionOrigin         `json:"promoted_from,omitempty"`
+	CreatedAt             string                 `json:"created_at"`
+}
+
+type versionOrigin struct {
+	App       string `json:"app"`
+	VersionNo int64  `json:"version_no"`
+}
+
+type compatibilityWarning struct {
+	Kind      string `json:"kind"`
+	Parameter string `json:"parameter"`
+	Message   string `json:"message"`
+}
+
+type versionParameter struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type versionCommand struct {
+	Name           string         `json:"name"`
+	Script         string         `json:"script"`
 	TimeoutSeconds *int           `json:"timeout_seconds,omitempty"`
 	ParamsSchema   map[string]any `json:"params_schema,omitempty"`
-	ArtifactSHA256 string         `json:"artifact_sha256"`
-	TowerfileTOML  *string        `json:"towerfile_toml,omitempty"`
-	ImportPaths    []string       `json:"import_paths,omitempty"`
-	CreatedAt      string         `json:"created_at"`
 }
 
 type listVersionsResponse struct {
@@ -60,20 +118,77 @@ type listVersionsResponse struct {
 }
 
 type runResponse struct {
-	RunID           int64          `json:"run_id"`
-	AppID           int64          `json:"app_id"`
-	AppSlug         string         `json:"app_slug,omitempty"`
-	RunNo           int64          `json:"run_no"`
-	VersionNo       int64          `json:"version_no"`
-	Status          string         `json:"status"`
-	Input           map[string]any `json:"input,omitempty"`
-	Priority        int            `json:"priority"`
-	MaxRetries      int            `json:"max_retries"`
-	RetryCount      int            `json:"retry_count"`
-	CancelRequested bool           `json:"cancel_requested"`
-	QueuedAt        string         `json:"queued_at"`
-	StartedAt       *string        `json:"started_at,omitempty"`
-	FinishedAt      *string        `json:"finished_at,omitempty"`
+	RunID      int64          `json:"run_id"`
+	AppID      int64          `json:"app_id"`
+	AppSlug    string         `json:"app_slug,omitempty"`
+	RunNo      int64          `json:"run_no"`
+	VersionNo  int64          `json:"version_no"`
+	Entrypoint string         `json:"entrypoint,omitempty"`
+	Status     string         `json:"status"`
+	DeadReason string         `json:"dead_reason,omitempty"`
+	Input      map[string]any `json:"input,omitempty"`
+	Priority   int            `json:"priority"`
+	MaxRetries int            `json:"max_retries"`
+	RetryCount int            `json:"retry_count"`
+	// RetriesRemaining is max_retries less retry_count.
+	RetriesRemaining int      `json:"retries_remaining"`
+	FailureKind      string   `json:"failure_kind,omitempty"`
+	CancelRequested  bool     `json:"cancel_requested"`
+	AtMostOnce       bool     `json:"at_most_once"`
+	Command          string   `json:"command,omitempty"`
+	BatchID          string   `json:"batch_id,omitempty"`
+	RerunOfRunID     *int64   `json:"rerun_of_run_id,omitempty"`
+	QueuedAt         string   `json:"queued_at"`
+	StartedAt        *string  `json:"started_at,omitempty"`
+	FinishedAt       *string  `json:"finished_at,omitempty"`
+	AttemptNo        int64    `json:"attempt_no,omitempty"`
+	ExitCode         *int     `json:"exit_code,omitempty"`
+	Environment      string   `json:"environment,omitempty"`
+	CoercedFields    []string `json:"coerced_fields,omitempty"`
+	TeamActiveRuns   int64    `json:"team_active_runs,omitempty"`
+	StatusToken      string   `json:"status_token,omitempty"`
+	RunnerName       string   `json:"runner_name,omitempty"`
+	InputOmitted     bool     `json:"input_omitted,omitempty"`
+}
+
+type inputChange struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+type limitsResponse struct {
+	MaxArtifactBytes    int64 `json:"max_artifact_bytes"`
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes"`
+	MaxLogLineBytes     int   `json:"max_log_line_bytes"`
+	MaxBatchRuns        int   `json:"max_batch_runs"`
+}
+
+type rerunResponse struct {
+	Run          runResponse   `json:"run"`
+	InputChanges []inputChange `json:"input_changes"`
+}
+
+type bulkCancelRequest struct {
+	App           string `json:"app,omitempty"`
+	Status        string `json:"status,omitempty"`
+	CreatedBefore string `json:"created_before,omitempty"`
+	ConfirmCount  *int64 `json:"confirm_count,omitempty"`
+	DryRun        bool   `json:"dry_run,omitempty"`
+}
+
+type bulkCancelResponse struct {
+	Count      int64   `json:"count"`
+	DryRun     bool    `json:"dry_run,omitempty"`
+	RunIDs     []int64 `json:"run_ids,omitempty"`
+	Cancelled  int64   `json:"cancelled"`
+	Cancelling int64   `json:"cancelling"`
+	Results    []struct {
+		RunID          int64  `json:"run_id"`
+		PreviousStatus string `json:"previous_status"`
+		Status         string `json:"status"`
+	} `json:"results"`
 }
 
 type listRunsResponse struct {
@@ -91,23 +206,152 @@ type runLogsResponse struct {
 	Logs []runLogEntry `json:"logs"`
 }
 
+type runEventResponse struct {
+	Kind    string         `json:"kind"`
+	At      string         `json:"at"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type runEventsResponse struct {
+	RunID  int64              `json:"run_id"`
+	Events []runEventResponse `json:"events"`
+}
+
+type runnerTokenResponse struct {
+	RunnerID    int64  `json:"runner_id"`
+	Name        string `json:"name"`
+	Environment string `json:"environment"`
+	Token       string `json:"token"`
+}
+
+type runAttemptResponse struct {
+	AttemptID       int64             `json:"attempt_id"`
+	AttemptNo       int64             `json:"attempt_no"`
+	RunnerID        int64             `json:"runner_id"`
+	Status          string            `json:"status"`
+	ExitCode        *int              `json:"exit_code,omitempty"`
+	ErrorMessage    *string           `json:"error_message,omitempty"`
+	StartedAt       *string           `json:"started_at,omitempty"`
+	FinishedAt      *string           `json:"finished_at,omitempty"`
+	CreatedAt       string            `json:"created_at"`
+	StartedNever    bool              `json:"started_never,omitempty"`
+	EnvSnapshot     map[string]string `json:"env_snapshot,omitempty"`
+	EnvSnapshotNote *string           `json:"env_snapshot_note,omitempty"`
+}
+
+type runAttemptsResponse struct {
+	RunID    int64                `json:"run_id"`
+	Attempts []runAttemptResponse `json:"attempts"`
+}
+
+type auditEntryResponse struct {
+	ID           int64          `json:"id"`
+	Action       string         `json:"action"`
+	ResourceType string         `json:"resource_type"`
+	ResourceID   string         `json:"resource_id"`
+	TokenID      *int64         `json:"token_id,omitempty"`
+	Details      map[string]any `json:"details,omitempty"`
+	CreatedAt    string         `json:"created_at"`
+}
+
+type auditLogResponse struct {
+	Entries []auditEntryResponse `json:"entries"`
+}
+
+type runOutputResponse struct {
+	Name      string `json:"name"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+	CreatedAt string `json:"created_at"`
+}
+
+type listRunOutputsResponse struct {
+	Outputs []runOutputResponse `json:"outputs"`
+}
+
 type createTokenResponse struct {
-	TokenID int64   `json:"token_id"`
-	Token   string  `json:"token"`
-	Name    *string `json:"name,omitempty"`
-	Role    string  `json:"role"`
+	TokenID int64    `json:"token_id"`
+	Token   string   `json:"token"`
+	Name    *string  `json:"name,omitempty"`
+	Role    string   `json:"role"`
+	Scopes  []string `json:"scopes,omitempty"`
+	AppSlug string   `json:"app_slug,omitempty"`
 }
 
 type adminRunnerResponse struct {
-	RunnerID    int64   `json:"runner_id"`
-	Name        string  `json:"name"`
-	Environment string  `json:"environment"`
-	Status      string  `json:"status"`
-	LastSeenAt  *string `json:"last_seen_at,omitempty"`
+	RunnerID       int64        `json:"runner_id"`
+	Name           string       `json:"name"`
+	Environment    string       `json:"environment"`
+	Status         string       `json:"status"`
+	PythonVersions []string     `json:"python_versions,omitempty"`
+	LastSeenAt     *string      `json:"last_seen_at,omitempty"`
+	Stats          *runnerStats `json:"stats,omitempty"`
+	StatsAt        *string      `json:"stats_at,omitempty"`
+}
+
+type runnerStats struct {
+	CPUPercent    *float64 `json:"cpu_percent,omitempty"`
+	MemUsedBytes  *int64   `json:"mem_used_bytes,omitempty"`
+	MemTotalBytes *int64   `json:"mem_total_bytes,omitempty"`
+	DiskFreeBytes *int64   `json:"disk_free_bytes,omitempty"`
+	Load1         *float64 `json:"load1,omitempty"`
 }
 
 type listAdminRunnersResponse struct {
 	Runners []adminRunnerResponse `json:"runners"`
+	Total   int64                 `json:"total"`
+}
+
+type backupResponse struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+	CreatedAt string `json:"created_at"`
+}
+
+type usageReportResponse struct {
+	From    string     `json:"from"`
+	To      string     `json:"to"`
+	GroupBy string     `json:"group_by"`
+	Rows    []usageRow `json:"rows"`
+}
+
+type usageRow struct {
+	Group                 string  `json:"group"`
+	Runs                  int64   `json:"runs"`
+	Completed             int64   `json:"completed"`
+	Failed                int64   `json:"failed"`
+	TotalExecutionSeconds float64 `json:"total_execution_seconds"`
+	TotalQueueSeconds     float64 `json:"total_queue_seconds"`
+}
+
+type adminOverviewResponse struct {
+	TeamCount           int64                `json:"team_count"`
+	Teams               []teamUsage          `json:"teams"`
+	ArtifactBytes       int64                `json:"artifact_bytes"`
+	RunsLast24h         map[string]int64     `json:"runs_last_24h"`
+	Runners             adminRunnerCounts    `json:"runners"`
+	StarvedEnvironments []starvedEnvironment `json:"starved_environments"`
+}
+
+type starvedEnvironment struct {
+	Environment    string `json:"environment"`
+	QueuedRuns     int64  `json:"queued_runs"`
+	OldestQueuedAt string `json:"oldest_queued_at"`
+}
+
+type teamUsage struct {
+	TeamID        int64  `json:"team_id"`
+	Slug          string `json:"slug"`
+	Apps          int64  `json:"apps"`
+	Runs          int64  `json:"runs"`
+	ActiveRuns    int64  `json:"active_runs"`
+	MaxActiveRuns *int64 `json:"max_active_runs"`
+}
+
+type adminRunnerCounts struct {
+	Online  int64 `json:"online"`
+	Offline int64 `json:"offline"`
 }
 
 type profileConfig struct {
@@ -120,6 +364,13 @@ type profile struct {
 	Token  string `json:"token,omitempty"`
 	Team   string `json:"team,omitempty"`
 	App    string `json:"app,omitempty"`
+	// Output is the default output format, "table" or "json".
+	Output string `json:"output,omitempty"`
+	// CACert is a PEM bundle trusted for the server's certificate in
+	// addition to the system roots.
+	CACert string `json:"ca_cert,omitempty"`
+	// InsecureSkipVerify turns off server certificate verification.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
 }
 
 type resolvedConnection struct {
@@ -128,6 +379,35 @@ type resolvedConnection struct {
 	Token       string
 	Team        string
 	DefaultApp  string
+	Output      string
+	CACert      string
+	Insecure    bool
+}
+
+type createBatchResponse struct {
+	BatchID   string  `json:"batch_id"`
+	AppSlug   string  `json:"app_slug"`
+	VersionNo int64   `json:"version_no"`
+	Count     int     `json:"count"`
+	RunIDs    []int64 `json:"run_ids"`
+}
+
+type batchResponse struct {
+	BatchID         string           `json:"batch_id"`
+	AppID           int64            `json:"app_id"`
+	AppSlug         string           `json:"app_slug"`
+	Total           int64            `json:"total"`
+	Terminal        int64            `json:"terminal"`
+	Counts          map[string]int64 `json:"counts"`
+	PercentComplete float64          `json:"percent_complete"`
+	Done            bool             `json:"done"`
+	CreatedAt       string           `json:"created_at"`
+}
+
+type cancelBatchResponse struct {
+	batchResponse
+	Cancelled  int64 `json:"cancelled"`
+	Cancelling int64 `json:"cancelling"`
 }
 
 func isTerminalRunStatus(status string) bool {
@@ -138,3 +418,72 @@ func isTerminalRunStatus(status string) bool {
 		return false
 	}
 }
+
+type appErrorGroupResponse struct {
+	Fingerprint   string  `json:"fingerprint"`
+	Pattern       string  `json:"pattern"`
+	Count         int64   `json:"count"`
+	SampleMessage string  `json:"sample_message"`
+	FirstSeenAt   string  `json:"first_seen_at"`
+	LastSeenAt    string  `json:"last_seen_at"`
+	ExampleRunIDs []int64 `json:"example_run_ids"`
+}
+
+type appErrorsResponse struct {
+	App           string                  `json:"app"`
+	Since         string                  `json:"since"`
+	TotalFailures int64                   `json:"total_failures"`
+	Errors        []appErrorGroupResponse `json:"errors"`
+}
+
+type versionStatsResponse struct {
+	VersionNo          int64            `json:"version_no"`
+	TotalRuns          int64            `json:"total_runs"`
+	RunsByStatus       map[string]int64 `json:"runs_by_status"`
+	FirstRunAt         *string          `json:"first_run_at"`
+	LastRunAt          *string          `json:"last_run_at"`
+	DurationAvgSeconds *float64         `json:"duration_avg_seconds"`
+	DurationP50Seconds *float64         `json:"duration_p50_seconds"`
+	DurationP95Seconds *float64         `json:"duration_p95_seconds"`
+}
+
+type versionFilesResponse struct {
+	VersionNo int64               `json:"version_no"`
+	Files     []artifactFileEntry `json:"files"`
+	Truncated bool                `json:"truncated"`
+}
+
+type artifactFileEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Mode  string `json:"mode"`
+	IsDir bool   `json:"is_dir"`
+}
+
+type environmentResponse struct {
+	EnvironmentID int64  `json:"environment_id"`
+	Name          string `json:"name"`
+	IsDefault     bool   `json:"is_default"`
+	QueuedRuns    int64  `json:"queued_runs"`
+	RunningRuns   int64  `json:"running_runs"`
+	OnlineRunners int64  `json:"online_runners"`
+	CreatedAt     string `json:"created_at"`
+	UpdatedAt     string `json:"updated_at"`
+}
+
+type listEnvironmentsResponse struct {
+	Environments []environmentResponse `json:"environments"`
+}
+
+type dryRunResponse struct {
+	AppID         int64          `json:"app_id"`
+	AppSlug       string         `json:"app_slug"`
+	VersionNo     int64          `json:"version_no"`
+	Environment   string         `json:"environment"`
+	Status        string         `json:"status"`
+	Input         map[string]any `json:"input,omitempty"`
+	Priority      int            `json:"priority"`
+	MaxRetries    int            `json:"max_retries"`
+	Command       string         `json:"command,omitempty"`
+	CoercedFields []string       `json:"coerced_fields,omitempty"`
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"minitower/internal/objects"
+	"minitower/internal/store"
+)
+
+const artifactSizeBackfillBatch = 200
+
+// backfillArtifactSizes records the artifact size of versions uploaded before
+// sizes were stored at upload time. A version whose object is missing keeps a
+// NULL size and is skipped; it is retried on the next start.
+func backfillArtifactSizes(ctx context.Context, s *store.Store, objStore *objects.LocalStore, logger *slog.Logger) error {
+	var afterID int64
+	filled := 0
+	for {
+		artifacts, err := s.ListVersionsMissingArtifactSize(ctx, afterID, artifactSizeBackfillBatch)
+		if err != nil {
+			return err
+		}
+		for _, a := range artifacts {
+			afterID = a.VersionID
+			size, err := objStore.Size(a.ObjectKey)
+			if err != nil {
+				logger.Warn("artifact size backfill skipped version", "version_id", a.VersionID, "error", err)
+				continue
+			}
+			if err := s.SetVersionArtifactSize(ctx, a.VersionID, size); err != nil {
+				return err
+			}
+			filled++
+		}
+		if len(artifacts) < artifactSizeBackfillBatch {
+			break
+		}
+	}
+	if filled > 0 {
+		logger.Info("backfilled artifact sizes", "count", filled)
+	}
+	return nil
+}
+
+const inputKeysBackfillBatch = 200
+
+// backfillInputKeys records the input_contains projection of compressed run
+// inputs stored before it was, so the filter matches them.
+func backfillInputKeys(ctx context.Context, s *store.Store, logger *slog.Logger) error {
+	filled := 0
+	for {
+		n, err := s.FillMissingInputKeys(ctx, inputKeysBackfillBatch)
+		if err != nil {
+			return err
+		}
+		filled += n
+		if n < inputKeysBackfillBatch {
+			break
+		}
+	}
+	if filled > 0 {
+		logger.Info("backfilled run input keys", "count", filled)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+
+	"minitower/internal/auth"
+	"minitower/internal/config"
+	"minitower/internal/runner"
+	"minitower/internal/store"
+)
+
+const (
+	// devSlug names the team and the runner dev mode provisions.
+	devSlug           = "dev"
+	devListenAddr     = "127.0.0.1:8080"
+	devDataDirName    = "minitower-dev"
+	devTokenName      = "dev"
+	devRunnerEnv      = "default"
+	devRunnerDataName = "runner"
+)
+
+// devDataDirPath returns the directory dev mode keeps its database, objects and
+// runner data in: $XDG_DATA_HOME/minitower-dev, or
+// ~/.local/share/minitower-dev. When neither resolves it makes a temporary
+// directory, which is not reused across starts.
+func devDataDirPath() (string, error) {
+	base := os.Getenv("XDG_DATA_HOME")
+	if base == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			base = filepath.Join(home, ".local", "share")
+		}
+	}
+	if base == "" {
+		return os.MkdirTemp("", devDataDirName+"-")
+	}
+	dir := filepath.Join(base, devDataDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("create dev data dir: %w", err)
+	}
+	return dir, nil
+}
+
+// devConfig loads the server configuration for dev mode. Settings not in
+// the environment default to files under dataDir, a loopback listen
+// address and a generated registration token; public signup is always
+// enabled.
+func devConfig(dataDir string) (config.Config, error) {
+	registrationToken, _, err := auth.GenerateToken()
+	if err != nil {
+		return config.Config{}, err
+	}
+	for key, value := range map[string]string{
+		"MINITOWER_LISTEN_ADDR":               devListenAddr,
+		"MINITOWER_DB_PATH":                   filepath.Join(dataDir, "minitower.db"),
+		"MINITOWER_OBJECTS_DIR":               filepath.Join(dataDir, "objects"),
+		"MINITOWER_BACKUP_DIR":                filepath.Join(dataDir, "backups"),
+		"MINITOWER_RUNNER_REGISTRATION_TOKEN": registrationToken,
+		// No load balancer waits on a dev server's readiness.
+		"MINITOWER_SHUTDOWN_DRAIN_DELAY": "0s",
+	} {
+		if os.Getenv(key) != "" {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return config.Config{}, err
+		}
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.PublicSignupEnabled = true
+	return cfg, nil
+}
+
+// provisionDev creates the dev team and runner unless they exist, through
+// the store rather than the bootstrap and registration endpoints, and
+// returns a new admin token for the team and a new token for the runner.
+// The previous dev team token and the runner's previous token stop working,
+// so restarts do not pile up live admin tokens.
+func provisionDev(ctx context.Context, s *store.Store) (teamToken, runnerToken string, err error) {
+	team, err := s.GetTeamBySlug(ctx, devSlug)
+	if err != nil {
+		return "", "", fmt.Errorf("get dev team: %w", err)
+	}
+	if team == nil {
+		if team, err = s.CreateTeam(ctx, devSlug, "Dev"); err != nil {
+			return "", "", fmt.Errorf("create dev team: %w", err)
+		}
+	}
+	teamToken, teamTokenHash, err := auth.GeneratePrefixedToken(auth.PrefixTeamToken)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := s.RevokeTeamTokensByName(ctx, team.ID, devTokenName); err != nil {
+		return "", "", fmt.Errorf("revoke previous dev team token: %w", err)
+	}
+	tokenName := devTokenName
+	if _, err := s.CreateTeamToken(ctx, team.ID, teamTokenHash, &tokenName, "admin"); err != nil {
+		return "", "", fmt.Errorf("create dev team token: %w", err)
+	}
+
+	runnerToken, runnerTokenHash, err := auth.GeneratePrefixedToken(auth.PrefixRunnerToken)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = s.CreateRunner(ctx, devSlug, devRunnerEnv, runnerTokenHash)
+	if errors.Is(err, store.ErrRunnerNameTaken) {
+		existing, getErr := s.GetRunnerByName(ctx, devSlug)
+		if getErr != nil {
+			return "", "", fmt.Errorf("get dev runner: %w", getErr)
+		}
+		if existing == nil {
+			return "", "", errors.New("dev runner disappeared while re-provisioning it")
+		}
+		_, err = s.RefreshRunnerRegistration(ctx, existing.ID, devRunnerEnv, runnerTokenHash)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("provision dev runner: %w", err)
+	}
+	return teamToken, runnerToken, nil
+}
+
+// devRunnerConfig is the embedded runner's configuration: the runner
+// defaults, pointed at serverURL with a provisioned token, keeping its
+// data under dataDir. MINITOWER_PYTHON_BIN still selects the Python.
+func devRunnerConfig(serverURL, token, dataDir string) *runner.Config {
+	cfg := runner.DefaultConfig()
+	cfg.ServerURL = serverURL
+	cfg.RunnerName = devSlug
+	cfg.Token = token
+	cfg.Environment = devRunnerEnv
+	cfg.DataDir = filepath.Join(dataDir, devRunnerDataName)
+	cfg.WorkDir = filepath.Join(cfg.DataDir, "work")
+	if v := os.Getenv("MINITOWER_PYTHON_BIN"); v != "" {
+		cfg.PythonBin = v
+	}
+	return cfg
+}
+
+// devServerURL is the URL the embedded runner and the CLI reach a listener
+// bound to addr on, using loopback for an unspecified host.
+func devServerURL(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "http://" + addr.String()
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// runDevRunner runs the embedded runner until stop is closed.
+func runDevRunner(stop <-chan struct{}, cfg *runner.Config, logger *slog.Logger) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := runner.NewRunner(cfg, logger.With("component", "runner")).Run(ctx); err != nil {
+		logger.Error("embedded runner error", "error", err)
+	}
+}
+
+// printDevBanner prints the settings for pointing the CLI at the dev server.
+func printDevBanner(w io.Writer, dataDir, serverURL, teamToken string) {
+	fmt.Fprintf(w, `
+minitower dev mode: server and runner %q are up (data in %s).
+Team %q is ready; to use it from another shell:
+
+export MINITOWER_SERVER_URL=%s
+export MINITOWER_API_TOKEN=%s
+
+`, devSlug, dataDir, devSlug, serverURL, teamToken)
+}
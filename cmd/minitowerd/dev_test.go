@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"minitower/internal/config"
+	"minitower/internal/httpapi"
+	"minitower/internal/objects"
+	"minitower/internal/testutil"
+	"minitower/internal/towerfile"
+)
+
+// devRequest sends a JSON (or, with contentType, raw) request to the dev
+// server and decodes a JSON response into out, failing unless it has the
+// wanted status.
+func devRequest(t *testing.T, method, url, token, contentType string, body io.Reader, wantStatus int, out any) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: expected %d, got %d: %s", method, url, wantStatus, resp.StatusCode, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("decode %s: %v", data, err)
+		}
+	}
+}
+
+func TestDevModeRunsAProjectEndToEnd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell entrypoints need sh")
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skipf("tar not found: %v", err)
+	}
+
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := config.Config{LeaseTTL: 60 * time.Second, MaxRequestBodySize: 1 << 20, MaxArtifactSize: 1 << 20, PublicSignupEnabled: true}
+	api := httpapi.New(cfg, dbConn, objStore, logger, httpapi.WithPrometheusRegisterer(prometheus.NewRegistry()))
+	srv := httptest.NewServer(api.Handler())
+	defer srv.Close()
+
+	ctx := context.Background()
+	oldTeamToken, _, err := provisionDev(ctx, s)
+	if err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	// Provisioning again, as a restart on the same data dir does, reuses
+	// the team and runner and issues new tokens, revoking the old ones.
+	teamToken, runnerToken, err := provisionDev(ctx, s)
+	if err != nil {
+		t.Fatalf("provision again: %v", err)
+	}
+	var active int
+	if err := dbConn.QueryRow(`SELECT COUNT(*) FROM team_tokens WHERE name = ? AND revoked_at IS NULL`, devTokenName).Scan(&active); err != nil {
+		t.Fatalf("count dev tokens: %v", err)
+	}
+	if active != 1 {
+		t.Fatalf("expected one active dev team token, got %d", active)
+	}
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+oldTeamToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get me: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the previous dev token rejected, got %d", resp.StatusCode)
+	}
+
+	stop := make(chan struct{})
+	runnerDone := make(chan struct{})
+	go func() {
+		defer close(runnerDone)
+		runDevRunner(stop, devRunnerConfig(srv.URL, runnerToken, t.TempDir()), logger)
+	}()
+	defer func() {
+		close(stop)
+		<-runnerDone
+	}()
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"Towerfile": "[app]\nname = \"hello\"\nscript = \"main.sh\"\n",
+		// The sleep lets the runner read the line before the process exits.
+		"main.sh": "echo \"hello from dev\"\nsleep 1\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(filepath.Join(dir, "Towerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := towerfile.Parse(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact, _, err := towerfile.Package(dir, tf)
+	if err != nil {
+		t.Fatalf("package: %v", err)
+	}
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, err := mw.CreateFormFile("artifact", "artifact.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(part, artifact); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	devRequest(t, http.MethodPost, srv.URL+"/api/v1/apps", teamToken, "", strings.NewReader(`{"slug": "hello"}`), http.StatusCreated, nil)
+	devRequest(t, http.MethodPost, srv.URL+"/api/v1/apps/hello/versions", teamToken, mw.FormDataContentType(), &form, http.StatusCreated, nil)
+	var created struct {
+		RunID int64 `json:"run_id"`
+	}
+	devRequest(t, http.MethodPost, srv.URL+"/api/v1/apps/hello/runs", teamToken, "", strings.NewReader(`{}`), http.StatusCreated, &created)
+	runURL := srv.URL + "/api/v1/runs/" + strconv.FormatInt(created.RunID, 10)
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		var run struct {
+			Status string `json:"status"`
+		}
+		devRequest(t, http.MethodGet, runURL, teamToken, "", nil, http.StatusOK, &run)
+		if run.Status == "completed" {
+			break
+		}
+		if run.Status == "failed" || run.Status == "dead" || time.Now().After(deadline) {
+			t.Fatalf("expected the embedded runner to complete the run, got status %q", run.Status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	var logs struct {
+		Logs []struct {
+			Line string `json:"line"`
+		} `json:"logs"`
+	}
+	devRequest(t, http.MethodGet, runURL+"/logs", teamToken, "", nil, http.StatusOK, &logs)
+	var lines []string
+	for _, l := range logs.Logs {
+		lines = append(lines, l.Line)
+	}
+	if !strings.Contains(strings.Join(lines, "\n"), "hello from dev") {
+		t.Fatalf("expected the run's output in its logs, got %q", lines)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// drainer is the part of httpapi.Server the lifecycle needs.
+type drainer interface {
+	BeginShutdown()
+	WaitJobs(ctx context.Context) error
+}
+
+// lifecycle owns minitowerd's background loops and shuts the process down
+// in order: readiness flips to 503, requests keep being served for the drain
+// delay, the listener stops accepting, loops finish their current iteration,
+// in-flight requests and background jobs drain, and only then is the
+// database closed.
+type lifecycle struct {
+	stop  chan struct{}
+	loops sync.WaitGroup
+	// drainDelay is how long the listeners stay open after readiness flips,
+	// so load balancers see the 503 and stop routing here first.
+	drainDelay time.Duration
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{stop: make(chan struct{})}
+}
+
+// Go starts a background loop. fn should return once stop is closed, but may
+// finish the iteration it is in; it must not use a context that shutdown
+// cancels for that work.
+func (l *lifecycle) Go(fn func(stop <-chan struct{})) {
+	l.loops.Add(1)
+	go func() {
+		defer l.loops.Done()
+		fn(l.stop)
+	}()
+}
+
+// Shutdown performs the ordered shutdown across every listener: servers[0]
+// is the API listener and the rest, when configured, the ops listener, which
+// is only shut down once the API has drained so /readyz keeps answering 503
+// until then. If ctx expires first it returns an error and leaves the
+// database open, since closing it under running work is what causes
+// "database is closed" failures; the caller should exit.
+func (l *lifecycle) Shutdown(ctx context.Context, api drainer, db io.Closer, servers ...*http.Server) error {
+	api.BeginShutdown()
+
+	if l.drainDelay > 0 {
+		timer := time.NewTimer(l.drainDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("wait for drain delay: %w", ctx.Err())
+		}
+	}
+
+	// server.Shutdown closes the listener immediately, then waits for
+	// in-flight handlers. Run it alongside the loop wait.
+	drained := make(chan error, 1)
+	if len(servers) > 0 {
+		go func() {
+			drained <- servers[0].Shutdown(ctx)
+		}()
+	}
+
+	close(l.stop)
+	loopsDone := make(chan struct{})
+	go func() {
+		l.loops.Wait()
+		close(loopsDone)
+	}()
+	select {
+	case <-loopsDone:
+	case <-ctx.Done():
+		return fmt.Errorf("wait for background loops: %w", ctx.Err())
+	}
+
+	if len(servers) > 0 {
+		if err := <-drained; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("drain requests: %w", err)
+		}
+	}
+	for _, server := range servers[min(len(servers), 1):] {
+		if err := server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("drain ops requests: %w", err)
+		}
+	}
+	if err := api.WaitJobs(ctx); err != nil {
+		return fmt.Errorf("wait for background jobs: %w", err)
+	}
+
+	return db.Close()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"minitower/internal/config"
+	"minitower/internal/httpapi"
+	"minitower/internal/objects"
+	"minitower/internal/testutil"
+)
+
+func newLifecycleTestAPI(t *testing.T) (*httpapi.Server, func(ctx context.Context, name string) error, io.Closer) {
+	t.Helper()
+	s, dbConn, _ := testutil.NewTestDB(t) // closed by the lifecycle under test
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	cfg := config.Config{
+		LeaseTTL:           60 * time.Second,
+		MaxRequestBodySize: 1 << 20,
+		MaxArtifactSize:    1 << 20,
+		BackupDir:          t.TempDir(),
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	api := httpapi.New(cfg, dbConn, objStore, logger, httpapi.WithPrometheusRegisterer(prometheus.NewRegistry()))
+	write := func(ctx context.Context, name string) error {
+		_, err := s.CreateTeam(ctx, name, name)
+		return err
+	}
+	return api, write, dbConn
+}
+
+func TestShutdownDrainsWorkBeforeClosingDB(t *testing.T) {
+	api, write, dbConn := newLifecycleTestAPI(t)
+
+	requestStarted := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(requestStarted)
+		time.Sleep(300 * time.Millisecond)
+		// Touch the database after shutdown has begun.
+		if err := write(r.Context(), "slow-request"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("/", api.Handler())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &http.Server{Handler: mux}
+	go func() { _ = server.Serve(ln) }()
+	baseURL := "http://" + ln.Addr().String()
+
+	opsLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen ops: %v", err)
+	}
+	opsServer := &http.Server{Handler: api.OpsHandler()}
+	go func() { _ = opsServer.Serve(opsLn) }()
+	opsURL := "http://" + opsLn.Addr().String()
+
+	lc := newLifecycle()
+	lc.drainDelay = 300 * time.Millisecond
+	loopStarted := make(chan struct{})
+	loopErr := make(chan error, 1)
+	lc.Go(func(stop <-chan struct{}) {
+		close(loopStarted)
+		time.Sleep(200 * time.Millisecond)
+		loopErr <- write(context.Background(), "loop-iteration")
+		<-stop
+	})
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	respCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(baseURL + "/slow")
+		if err != nil {
+			respCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		respCh <- result{status: resp.StatusCode, body: string(body)}
+	}()
+	<-requestStarted
+	<-loopStarted
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- lc.Shutdown(ctx, api, dbConn, server, opsServer)
+	}()
+
+	// During the drain delay both listeners stay open and report 503, so a
+	// load balancer polling either one sees the server go unready.
+	for _, url := range []string{opsURL + "/readyz", baseURL + "/readyz"} {
+		deadline := time.Now().Add(250 * time.Millisecond)
+		for {
+			resp, err := http.Get(url)
+			if err != nil {
+				t.Fatalf("%s: listener closed during the drain delay: %v", url, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusServiceUnavailable {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s did not flip to 503, last status %d", url, resp.StatusCode)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	res := <-respCh
+	if res.err != nil || res.status != http.StatusOK {
+		t.Fatalf("in-flight request failed: status=%d body=%q err=%v", res.status, res.body, res.err)
+	}
+	if err := <-loopErr; err != nil {
+		t.Fatalf("loop iteration failed: %v", err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	if _, err := http.Get(baseURL + "/health"); err == nil {
+		t.Fatal("expected listener to be closed after shutdown")
+	}
+	if _, err := http.Get(opsURL + "/readyz"); err == nil {
+		t.Fatal("expected ops listener to be closed after shutdown")
+	}
+	if err := write(context.Background(), "after-shutdown"); err == nil {
+		t.Fatal("expected database to be closed after shutdown")
+	}
+}
+
+func TestShutdownLeavesDBOpenWhenDeadlineExpires(t *testing.T) {
+	api, write, dbConn := newLifecycleTestAPI(t)
+	server := &http.Server{Handler: api.Handler()}
+
+	lc := newLifecycle()
+	release := make(chan struct{})
+	lc.Go(func(stop <-chan struct{}) {
+		<-release
+	})
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := lc.Shutdown(ctx, api, dbConn, server); err == nil {
+		t.Fatal("expected deadline error")
+	}
+	if err := write(context.Background(), "after-deadline"); err != nil {
+		t.Fatalf("database should stay open after a forced shutdown: %v", err)
+	}
+	_ = dbConn.Close()
+}
@@ -3,44 +3,66 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"minitower/internal/backup"
 	"minitower/internal/config"
 	"minitower/internal/db"
 	"minitower/internal/httpapi"
+	"minitower/internal/logretention"
 	"minitower/internal/migrate"
 	"minitower/internal/migrations"
 	"minitower/internal/objects"
 	"minitower/internal/store"
 )
 
-const shutdownTimeout = 10 * time.Second
+const (
+	shutdownTimeout = 10 * time.Second
+	// logRetentionInterval is how often the log retention job runs.
+	logRetentionInterval = time.Hour
+)
 
 func main() {
+	dev := flag.Bool("dev", false, "run a local development server with an embedded runner")
+	verify := flag.Bool("verify-objects", false, "verify every version artifact against its sha256, print a report and exit")
+	markCorrupt := flag.Bool("mark-corrupt", false, "with --verify-objects, flag versions with a bad artifact so runs of them are rejected")
+	flag.Parse()
+
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 
-	cfg, err := config.Load()
+	var (
+		cfg        config.Config
+		devDataDir string
+		err        error
+	)
+	if *dev {
+		devDataDir, err = devDataDirPath()
+		if err == nil {
+			cfg, err = devConfig(devDataDir)
+		}
+	} else {
+		cfg, err = config.Load()
+	}
 	if err != nil {
 		logger.Error("config error", "error", err)
 		os.Exit(1)
 	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
 
 	dbConn, err := db.Open(ctx, cfg.DBPath)
 	if err != nil {
 		logger.Error("db error", "error", err)
 		os.Exit(1)
 	}
-	defer func() {
-		_ = dbConn.Close()
-	}()
 
 	migrator := migrate.New(migrations.FS)
 	if err := migrator.Apply(ctx, dbConn); err != nil {
@@ -54,77 +76,122 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := backfillArtifactSizes(ctx, store.New(dbConn), objectStore, logger); err != nil {
+		logger.Error("artifact size backfill error", "error", err)
+		os.Exit(1)
+	}
+	if err := backfillInputKeys(ctx, store.New(dbConn), logger); err != nil {
+		logger.Error("run input keys backfill error", "error", err)
+		os.Exit(1)
+	}
+
+	if *verify {
+		problems, err := verifyObjects(ctx, store.New(dbConn), objectStore, *markCorrupt, os.Stdout)
+		_ = dbConn.Close()
+		if err != nil {
+			logger.Error("verify objects error", "error", err)
+			os.Exit(1)
+		}
+		if problems > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
 	api := httpapi.New(cfg, dbConn, objectStore, logger)
 	metrics := api.Metrics()
 
+	lc := newLifecycle()
+	// The drain delay must leave the shutdown timeout room for the drain.
+	lc.drainDelay = min(cfg.ShutdownDrainDelay, shutdownTimeout/2)
+	if lc.drainDelay < cfg.ShutdownDrainDelay {
+		logger.Warn("shutdown drain delay capped", "configured", cfg.ShutdownDrainDelay, "used", lc.drainDelay)
+	}
+
 	reaper := store.New(dbConn)
+	slowRuns := newSlowRunMonitor(slowRunBaselineTTL)
+	starved := newStarvationMonitor()
 	if cfg.ExpiryCheckInterval > 0 {
-		go func() {
-			ticker := time.NewTicker(cfg.ExpiryCheckInterval)
+		lc.Go(func(stop <-chan struct{}) {
+			timer := time.NewTimer(cfg.ExpiryCheckInterval + reaperJitter(cfg.ExpiryCheckInterval))
+			defer timer.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-timer.C:
+				}
+
+				// Not the signal context: an iteration that has started runs
+				// to completion so shutdown never interrupts a transaction.
+				started := time.Now()
+				reapTick(context.Background(), reaper, api, cfg, logger, started)
+				slowRuns.check(context.Background(), reaper, api, logger, started)
+				starved.check(context.Background(), reaper, api, logger, started)
+				metrics.ObserveReaperTick(time.Since(started))
+
+				timer.Reset(cfg.ExpiryCheckInterval + reaperJitter(cfg.ExpiryCheckInterval))
+			}
+		})
+	}
+
+	purger := logretention.New(reaper, objectStore, logretention.Options{
+		RetentionDays:      cfg.LogRetentionDays,
+		MaxRowsPerAttempt:  cfg.LogMaxRowsPerAttempt,
+		Archive:            cfg.LogArchive,
+		AuditRetentionDays: cfg.AuditRetentionDays,
+	})
+	if purger.Enabled() {
+		lc.Go(func(stop <-chan struct{}) {
+			ticker := time.NewTicker(logRetentionInterval)
 			defer ticker.Stop()
 			for {
 				select {
-				case <-ctx.Done():
+				case <-stop:
 					return
 				case <-ticker.C:
 				}
 
-				now := time.Now()
-
-				results, err := reaper.ReapExpiredAttempts(ctx, now, 100)
+				res, err := purger.Run(context.Background(), time.Now())
+				metrics.LogsPurged(res.Purged)
 				if err != nil {
-					logger.Error("expiry reaper error", "error", err)
+					logger.Error("log retention error", "error", err, "archived", res.Archived, "purged", res.Purged, "audit_purged", res.AuditPurged)
 					continue
 				}
-				if len(results) > 0 {
-					logger.Info("expiry reaper processed attempts", "count", len(results))
+				if res.Archived > 0 || res.Purged > 0 {
+					logger.Info("log retention purged logs", "archived", res.Archived, "purged", res.Purged)
 				}
-
-				for _, r := range results {
-					team, _ := reaper.GetTeamByID(ctx, r.TeamID)
-					app, _ := reaper.GetAppByIDDirect(ctx, r.AppID)
-					teamSlug := ""
-					appSlug := ""
-					if team != nil {
-						teamSlug = team.Slug
-					}
-					if app != nil {
-						appSlug = app.Slug
-					}
-
-					switch r.Outcome {
-					case "retried":
-						metrics.RunRetried(teamSlug, appSlug)
-					case "dead", "cancelled":
-						metrics.RunCompleted(teamSlug, appSlug, r.Outcome)
-					}
+				if res.AuditPurged > 0 {
+					logger.Info("audit retention purged entries", "purged", res.AuditPurged)
 				}
+			}
+		})
+	}
 
-				// Mark long-inactive runners offline so admin visibility reflects
-				// current availability and stale tokens are fenced.
-				offlineThreshold := now.Add(-(2 * cfg.LeaseTTL))
-				marked, err := reaper.MarkStaleRunnersOffline(ctx, offlineThreshold)
-				if err != nil {
-					logger.Error("runner offline sweep error", "error", err)
-					continue
-				}
-				if marked > 0 {
-					logger.Info("marked stale runners offline", "count", marked)
+	if cfg.BackupInterval > 0 {
+		backups := api.Backups()
+		lc.Go(func(stop <-chan struct{}) {
+			ticker := time.NewTicker(cfg.BackupInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
 				}
 
-				if cfg.RunnerPruneAfter > 0 {
-					pruneCutoff := now.Add(-cfg.RunnerPruneAfter)
-					pruned, err := reaper.PruneOfflineRunners(ctx, pruneCutoff)
-					if err != nil {
-						logger.Error("runner prune sweep error", "error", err)
+				snap, err := backups.Snapshot(context.Background(), time.Now())
+				if err != nil {
+					if errors.Is(err, backup.ErrInProgress) {
+						logger.Warn("scheduled backup skipped", "reason", err)
 						continue
 					}
-					if pruned > 0 {
-						logger.Info("pruned offline runners", "count", pruned)
-					}
+					logger.Error("scheduled backup error", "error", err)
+					continue
 				}
+				logger.Info("scheduled backup written", "path", snap.Path, "size_bytes", snap.SizeBytes, "sha256", snap.SHA256)
 			}
-		}()
+		})
 	}
 
 	server := &http.Server{
@@ -136,19 +203,72 @@ func main() {
 		IdleTimeout:       120 * time.Second,
 	}
 
-	go func() {
-		<-ctx.Done()
+	servers := []*http.Server{server}
+	if cfg.OpsListenAddr != "" {
+		servers = append(servers, &http.Server{
+			Addr:              cfg.OpsListenAddr,
+			Handler:           api.OpsHandler(),
+			ReadHeaderTimeout: 5 * time.Second,
+			// pprof's CPU profile and trace stream for up to 30s by default.
+			WriteTimeout: 90 * time.Second,
+			IdleTimeout:  120 * time.Second,
+		})
+	}
 
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
-		defer cancel()
+	logger.Info("minitower listening", "addr", cfg.ListenAddr)
+	if cfg.OpsListenAddr != "" {
+		logger.Info("ops listener enabled", "addr", cfg.OpsListenAddr, "pprof", cfg.EnablePprof)
+	} else if cfg.MetricsToken == "" {
+		logger.Warn("metrics endpoint disabled: set MINITOWER_OPS_LISTEN_ADDR or MINITOWER_METRICS_TOKEN to expose /metrics")
+	}
 
-		_ = server.Shutdown(shutdownCtx)
-	}()
+	listeners := make([]net.Listener, 0, len(servers))
+	for _, srv := range servers {
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			logger.Error("listen error", "addr", srv.Addr, "error", err)
+			os.Exit(1)
+		}
+		listeners = append(listeners, ln)
+	}
+	serveErr := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func() {
+			serveErr <- srv.Serve(listeners[i])
+		}()
+	}
 
-	logger.Info("minitower listening", "addr", cfg.ListenAddr)
+	if *dev {
+		serverURL := devServerURL(listeners[0].Addr())
+		teamToken, runnerToken, err := provisionDev(ctx, store.New(dbConn))
+		if err != nil {
+			logger.Error("dev provisioning error", "error", err)
+			os.Exit(1)
+		}
+		runnerCfg := devRunnerConfig(serverURL, runnerToken, devDataDir)
+		lc.Go(func(stop <-chan struct{}) {
+			runDevRunner(stop, runnerCfg, logger)
+		})
+		printDevBanner(os.Stderr, devDataDir, serverURL, teamToken)
+	}
+
+	select {
+	case err := <-serveErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("server error", "error", err)
+			os.Exit(1)
+		}
+	case <-ctx.Done():
+	}
+	// Restore default signal handling so a second interrupt kills immediately.
+	stopSignals()
 
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		logger.Error("server error", "error", err)
+	logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := lc.Shutdown(shutdownCtx, api, dbConn, servers...); err != nil {
+		logger.Error("shutdown did not finish in time, forcing exit", "error", err)
 		os.Exit(1)
 	}
+	logger.Info("shutdown complete")
 }
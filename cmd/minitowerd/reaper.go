@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"minitower/internal/config"
+	"minitower/internal/httpapi"
+	"minitower/internal/store"
+)
+
+// recordReapResults updates metrics for attempts ended by the expiry reaper
+// or the offline-runner sweep, and wakes lease waiters for re-queued runs.
+func recordReapResults(ctx context.Context, s *store.Store, api *httpapi.Server, results []store.ReapResult) {
+	metrics := api.Metrics()
+	for _, r := range results {
+		team, _ := s.GetTeamByID(ctx, r.TeamID)
+		app, _ := s.GetAppByIDDirect(ctx, r.AppID)
+		teamSlug := ""
+		appSlug := ""
+		if team != nil {
+			teamSlug = team.Slug
+		}
+		if app != nil {
+			appSlug = app.Slug
+		}
+
+		metrics.RunReaped(teamSlug, appSlug, r.Outcome)
+		switch r.Outcome {
+		case "retried":
+			metrics.RunRetried(teamSlug, appSlug)
+			api.Queue().NotifyAll()
+		case "requeued":
+			api.Queue().NotifyAll()
+		case "dead", "dead_at_most_once":
+			metrics.RunDead(teamSlug, appSlug, r.Reason)
+		case "cancelled":
+			metrics.RunCompleted(teamSlug, appSlug, r.Outcome)
+		}
+	}
+}
+
+// reaperJitter returns a random delay of up to a tenth of interval, added to
+// each wait between reaper ticks so several servers sharing a database do
+// not contend for the write lock at the same moment.
+func reaperJitter(interval time.Duration) time.Duration {
+	spread := int64(interval / 10)
+	if spread <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(spread))
+}
+
+// reapExpiredAttempts ends expired attempts batch at a time until a batch
+// comes back short or budget has elapsed, so a backlog drains in one tick
+// without holding the reaper loop indefinitely. It returns how many attempts
+// were processed, including those before an error.
+func reapExpiredAttempts(ctx context.Context, s *store.Store, api *httpapi.Server, now time.Time, batch int, budget time.Duration) (int, error) {
+	deadline := time.Now().Add(budget)
+	processed := 0
+	for {
+		results, err := s.ReapExpiredAttempts(ctx, now, batch)
+		if err != nil {
+			return processed, err
+		}
+		recordReapResults(ctx, s, api, results)
+		api.Metrics().ReaperAttemptsProcessed(len(results))
+		processed += len(results)
+		if len(results) < batch || !time.Now().Before(deadline) {
+			return processed, nil
+		}
+	}
+}
+
+// reapTick runs one pass of the reaper: expired attempts, attempts never
+// started, unconfirmed cancellations, offline runners and, when configured,
+// runner pruning.
+func reapTick(ctx context.Context, s *store.Store, api *httpapi.Server, cfg config.Config, logger *slog.Logger, now time.Time) {
+	processed, err := reapExpiredAttempts(ctx, s, api, now, cfg.ReaperBatch, cfg.ReaperTickBudget)
+	if processed > 0 {
+		logger.Info("expiry reaper processed attempts", "count", processed)
+	}
+	if err != nil {
+		logger.Error("expiry reaper error", "error", err)
+		return
+	}
+
+	// A runner that leased an attempt but never started it has probably
+	// died before running anything; re-queue the run without waiting for
+	// its lease.
+	if deadline := cfg.StartDeadline(); deadline > 0 {
+		requeued, err := s.ReapUnstartedAttempts(ctx, now, deadline, cfg.ReaperBatch)
+		if err != nil {
+			logger.Error("start deadline reaper error", "error", err)
+		} else if len(requeued) > 0 {
+			logger.Info("re-queued runs whose runner never started them", "count", len(requeued))
+		}
+		recordReapResults(ctx, s, api, requeued)
+	}
+
+	// A runner that was told about a cancellation but never confirmed it
+	// has probably died; do not wait for its lease.
+	cancelled, err := s.ReapUnconfirmedCancels(ctx, now, cfg.CancelGracePeriod, cfg.ReaperBatch)
+	if err != nil {
+		logger.Error("cancel reaper error", "error", err)
+	} else if len(cancelled) > 0 {
+		logger.Info("cancelled runs whose runner did not confirm", "count", len(cancelled))
+	}
+	recordReapResults(ctx, s, api, cancelled)
+
+	// Mark long-inactive runners offline so admin visibility reflects
+	// current availability and stale tokens are fenced. Their attempts end
+	// now rather than when their leases run out.
+	offlineThreshold := now.Add(-(2 * cfg.LeaseTTL))
+	marked, expired, err := s.ExpireAttemptsForOfflineRunners(ctx, offlineThreshold)
+	if err != nil {
+		logger.Error("runner offline sweep error", "error", err)
+		return
+	}
+	if marked > 0 {
+		logger.Info("marked stale runners offline", "count", marked, "attempts_expired", len(expired))
+	}
+	recordReapResults(ctx, s, api, expired)
+
+	if cfg.RunnerPruneAfter > 0 {
+		pruneCutoff := now.Add(-cfg.RunnerPruneAfter)
+		pruned, err := s.PruneOfflineRunners(ctx, pruneCutoff)
+		if err != nil {
+			logger.Error("runner prune sweep error", "error", err)
+			return
+		}
+		if pruned > 0 {
+			logger.Info("pruned offline runners", "count", pruned)
+		}
+	}
+}
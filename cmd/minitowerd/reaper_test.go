@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"minitower/internal/config"
+	"minitower/internal/httpapi"
+	"minitower/internal/objects"
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestRecordReapResultsCountsOutcomes(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	cfg := config.Config{LeaseTTL: 60 * time.Second, MaxRequestBodySize: 1 << 20, MaxArtifactSize: 1 << 20}
+	api := httpapi.New(cfg, dbConn, objStore, slog.New(slog.NewTextHandler(io.Discard, nil)), httpapi.WithPrometheusRegisterer(prometheus.NewRegistry()))
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-reaped")
+	app := testutil.CreateApp(t, s, team.ID, "app-reaped")
+
+	recordReapResults(ctx, s, api, []store.ReapResult{
+		{TeamID: team.ID, AppID: app.ID, Outcome: "dead_at_most_once", Reason: store.DeadReasonAtMostOnceViolation},
+		{TeamID: team.ID, AppID: app.ID, Outcome: "dead", Reason: store.DeadReasonMaxRetriesExceeded},
+		{TeamID: team.ID, AppID: app.ID, Outcome: "retried"},
+	})
+
+	rec := httptest.NewRecorder()
+	api.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`minitower_runs_reaped_total{app="app-reaped",outcome="dead_at_most_once",team="team-reaped"} 1`,
+		`minitower_runs_reaped_total{app="app-reaped",outcome="dead",team="team-reaped"} 1`,
+		`minitower_runs_reaped_total{app="app-reaped",outcome="retried",team="team-reaped"} 1`,
+		`minitower_runs_completed_total{app="app-reaped",failure_kind="infrastructure",reason="at_most_once_violation",status="dead",team="team-reaped"} 1`,
+		`minitower_runs_completed_total{app="app-reaped",failure_kind="infrastructure",reason="max_retries_exceeded",status="dead",team="team-reaped"} 1`,
+		`minitower_runs_retried_total{app="app-reaped",team="team-reaped"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %s in metrics, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestReapExpiredAttemptsDrainsBacklogInOneTick(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	cfg := config.Config{LeaseTTL: 60 * time.Second, MaxRequestBodySize: 1 << 20, MaxArtifactSize: 1 << 20}
+	api := httpapi.New(cfg, dbConn, objStore, slog.New(slog.NewTextHandler(io.Discard, nil)), httpapi.WithPrometheusRegisterer(prometheus.NewRegistry()))
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-backlog")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-backlog")
+	version := testutil.CreateVersion(t, s, app.ID)
+	for i := 0; i < 500; i++ {
+		testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+		runner, _ := testutil.CreateRunner(t, s, fmt.Sprintf("runner-backlog-%d", i), "default")
+		testutil.LeaseRun(t, s, runner)
+	}
+	if _, err := dbConn.ExecContext(ctx, `UPDATE run_attempts SET lease_expires_at = ?`, time.Now().Add(-time.Minute).UnixMilli()); err != nil {
+		t.Fatalf("expire attempts: %v", err)
+	}
+
+	processed, err := reapExpiredAttempts(ctx, s, api, time.Now(), 100, time.Minute)
+	if err != nil {
+		t.Fatalf("reap expired attempts: %v", err)
+	}
+	if processed != 500 {
+		t.Fatalf("expected 500 attempts processed in one tick, got %d", processed)
+	}
+	var left int
+	if err := dbConn.QueryRowContext(ctx, `SELECT COUNT(*) FROM run_attempts WHERE status = 'leased'`).Scan(&left); err != nil {
+		t.Fatalf("count leased attempts: %v", err)
+	}
+	if left != 0 {
+		t.Fatalf("expected no leased attempts left, got %d", left)
+	}
+
+	rec := httptest.NewRecorder()
+	api.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if want := "minitower_reaper_attempts_processed_total 500"; !strings.Contains(rec.Body.String(), want) {
+		t.Fatalf("expected %s in metrics, got:\n%s", want, rec.Body.String())
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"minitower/internal/httpapi"
+	"minitower/internal/store"
+)
+
+// slowRunBaselineTTL is how long an app's p95 baseline is reused before the
+// slow-run check recomputes it.
+const slowRunBaselineTTL = 5 * time.Minute
+
+// slowRunMonitor flags running attempts that have been executing longer than
+// their app's p95. It only records an event and counts it; it never stops a
+// run.
+type slowRunMonitor struct {
+	ttl       time.Duration
+	baselines map[int64]cachedBaseline
+}
+
+type cachedBaseline struct {
+	baseline store.DurationBaseline
+	ok       bool // false when the app has too few completed attempts
+	at       time.Time
+}
+
+func newSlowRunMonitor(ttl time.Duration) *slowRunMonitor {
+	return &slowRunMonitor{ttl: ttl, baselines: make(map[int64]cachedBaseline)}
+}
+
+// check compares every running attempt not yet flagged against its app's
+// baseline and flags those past it. It returns how many runs it flagged.
+func (m *slowRunMonitor) check(ctx context.Context, s *store.Store, api *httpapi.Server, logger *slog.Logger, now time.Time) int {
+	attempts, err := s.ListRunningAttemptsNotFlaggedSlow(ctx)
+	if err != nil {
+		logger.Error("slow run check error", "error", err)
+		return 0
+	}
+	if err := m.refresh(ctx, s, attempts, now); err != nil {
+		logger.Error("slow run baseline error", "error", err)
+		return 0
+	}
+
+	flagged := 0
+	for _, a := range attempts {
+		b := m.baselines[a.AppID]
+		elapsed := now.Sub(a.StartedAt)
+		if !b.ok || elapsed <= b.baseline.P95 {
+			continue
+		}
+		ok, err := s.FlagRunSlow(ctx, a.RunID, a.AttemptID, elapsed, b.baseline.P95, now)
+		if err != nil {
+			logger.Error("flag slow run error", "run_id", a.RunID, "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		flagged++
+
+		team, _ := s.GetTeamByID(ctx, a.TeamID)
+		app, _ := s.GetAppByIDDirect(ctx, a.AppID)
+		teamSlug, appSlug := "", ""
+		if team != nil {
+			teamSlug = team.Slug
+		}
+		if app != nil {
+			appSlug = app.Slug
+		}
+		api.Metrics().RunSlow(teamSlug, appSlug)
+		logger.Warn("run exceeded its app's p95 duration",
+			"run_id", a.RunID, "team", teamSlug, "app", appSlug,
+			"elapsed_seconds", elapsed.Seconds(), "p95_seconds", b.baseline.P95.Seconds())
+	}
+	return flagged
+}
+
+// refresh recomputes the baselines of the attempts' apps that are missing
+// from the cache or older than the TTL, in one query, and drops expired
+// baselines of apps with nothing running.
+func (m *slowRunMonitor) refresh(ctx context.Context, s *store.Store, attempts []store.RunningAttempt, now time.Time) error {
+	var stale []int64
+	seen := make(map[int64]bool)
+	for _, a := range attempts {
+		if seen[a.AppID] {
+			continue
+		}
+		seen[a.AppID] = true
+		if c, ok := m.baselines[a.AppID]; !ok || now.Sub(c.at) >= m.ttl {
+			stale = append(stale, a.AppID)
+		}
+	}
+	for appID, c := range m.baselines {
+		if !seen[appID] && now.Sub(c.at) >= m.ttl {
+			delete(m.baselines, appID)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	baselines, err := s.DurationBaselines(ctx, stale)
+	if err != nil {
+		return err
+	}
+	for _, appID := range stale {
+		b, ok := baselines[appID]
+		m.baselines[appID] = cachedBaseline{baseline: b, ok: ok, at: now}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"minitower/internal/config"
+	"minitower/internal/httpapi"
+	"minitower/internal/objects"
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+// seedAttempt inserts an attempt for a new run of app directly, started at
+// startedAt and finished after duration, or still running when duration is
+// zero.
+func seedAttempt(t *testing.T, s *store.Store, dbConn *sql.DB, teamID, appID, envID, versionID int64, startedAt time.Time, duration time.Duration) int64 {
+	t.Helper()
+	run := testutil.CreateRun(t, s, teamID, appID, envID, versionID, 0, 0)
+	runner, _ := testutil.CreateRunner(t, s, fmt.Sprintf("runner-%d", run.ID), "default")
+	status, finishedAt := "running", sql.NullInt64{}
+	if duration > 0 {
+		status = "completed"
+		finishedAt = sql.NullInt64{Int64: startedAt.Add(duration).UnixMilli(), Valid: true}
+	}
+	now := time.Now().UnixMilli()
+	if _, err := dbConn.Exec(
+		`INSERT INTO run_attempts (run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, started_at, finished_at, created_at, updated_at)
+		 VALUES (?, 1, ?, 'hash', ?, ?, ?, ?, ?, ?)`,
+		run.ID, runner.ID, now+60_000, status, startedAt.UnixMilli(), finishedAt, now, now,
+	); err != nil {
+		t.Fatalf("insert attempt: %v", err)
+	}
+	if _, err := dbConn.Exec(`UPDATE runs SET status = ? WHERE id = ?`, status, run.ID); err != nil {
+		t.Fatalf("update run: %v", err)
+	}
+	return run.ID
+}
+
+func TestSlowRunMonitorFlagsRunsPastP95Once(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	cfg := config.Config{LeaseTTL: 60 * time.Second, MaxRequestBodySize: 1 << 20, MaxArtifactSize: 1 << 20}
+	api := httpapi.New(cfg, dbConn, objStore, slog.New(slog.NewTextHandler(io.Discard, nil)), httpapi.WithPrometheusRegisterer(prometheus.NewRegistry()))
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-slow")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	now := time.Now()
+
+	// 20 completed attempts of 1s..20s put the p95 at 19s.
+	etl := testutil.CreateApp(t, s, team.ID, "etl")
+	etlVersion := testutil.CreateVersion(t, s, etl.ID)
+	for i := 1; i <= 20; i++ {
+		seedAttempt(t, s, dbConn, team.ID, etl.ID, env.ID, etlVersion.ID, now.Add(-time.Hour), time.Duration(i)*time.Second)
+	}
+	slow := seedAttempt(t, s, dbConn, team.ID, etl.ID, env.ID, etlVersion.ID, now.Add(-time.Minute), 0)
+	seedAttempt(t, s, dbConn, team.ID, etl.ID, env.ID, etlVersion.ID, now.Add(-10*time.Second), 0)
+
+	// Too few completed attempts for a baseline.
+	fresh := testutil.CreateApp(t, s, team.ID, "fresh")
+	freshVersion := testutil.CreateVersion(t, s, fresh.ID)
+	for i := 0; i < store.DurationBaselineMinSamples-1; i++ {
+		seedAttempt(t, s, dbConn, team.ID, fresh.ID, env.ID, freshVersion.ID, now.Add(-time.Hour), time.Second)
+	}
+	seedAttempt(t, s, dbConn, team.ID, fresh.ID, env.ID, freshVersion.ID, now.Add(-time.Hour), 0)
+
+	m := newSlowRunMonitor(time.Minute)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if n := m.check(ctx, s, api, logger, now); n != 1 {
+		t.Fatalf("expected one run flagged, got %d", n)
+	}
+	if n := m.check(ctx, s, api, logger, now); n != 0 {
+		t.Fatalf("expected no run flagged twice, got %d", n)
+	}
+
+	var runID int64
+	var details string
+	if err := dbConn.QueryRow(`SELECT run_id, details_json FROM run_events WHERE kind = ?`, store.RunEventDurationExceededP95).Scan(&runID, &details); err != nil {
+		t.Fatalf("query event: %v", err)
+	}
+	if runID != slow || !strings.Contains(details, `"p95_seconds":19`) {
+		t.Fatalf("expected run %d flagged against a 19s p95, got run %d with %s", slow, runID, details)
+	}
+
+	rec := httptest.NewRecorder()
+	api.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if want := `minitower_runs_exceeded_p95_total{app="etl",team="team-slow"} 1`; !strings.Contains(rec.Body.String(), want) {
+		t.Fatalf("expected %s in metrics, got:\n%s", want, rec.Body.String())
+	}
+
+	apps, err := s.ListAppsWithStats(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("list apps: %v", err)
+	}
+	for _, a := range apps {
+		switch {
+		case a.Slug == "etl" && (a.Stats.DurationP95 == nil || *a.Stats.DurationP95 != 19*time.Second):
+			t.Fatalf("expected a 19s p95 for etl, got %v", a.Stats.DurationP95)
+		case a.Slug == "fresh" && a.Stats.DurationP95 != nil:
+			t.Fatalf("expected no p95 below the sample minimum, got %v", *a.Stats.DurationP95)
+		}
+	}
+}
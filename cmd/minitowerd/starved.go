@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"minitower/internal/httpapi"
+	"minitower/internal/store"
+)
+
+// starvationMonitor notices environments whose queued runs have no online
+// runner able to lease them, including runs needing a Python version no
+// online runner has. It only reports them, through the
+// minitower_environments_starved gauge, a run event and the log; it never
+// changes a run.
+type starvationMonitor struct {
+	// starved holds the environments starved at the last check, so the
+	// gauge drops to 0 when they recover.
+	starved map[string]bool
+}
+
+func newStarvationMonitor() *starvationMonitor {
+	return &starvationMonitor{starved: make(map[string]bool)}
+}
+
+// check finds the starved environments, updates the gauge and records a
+// no_runner_available event on each one's oldest queued run, at most once
+// per store.NoRunnerEventInterval. It returns how many environments are
+// starved.
+func (m *starvationMonitor) check(ctx context.Context, s *store.Store, api *httpapi.Server, logger *slog.Logger, now time.Time) int {
+	envs, err := s.ListStarvedEnvironments(ctx)
+	if err != nil {
+		logger.Error("starved environment check error", "error", err)
+		return 0
+	}
+
+	metrics := api.Metrics()
+	current := make(map[string]bool, len(envs))
+	for _, env := range envs {
+		current[env.Name] = true
+		metrics.EnvironmentStarved(env.Name, true)
+		if !m.starved[env.Name] {
+			logger.Warn("environment has queued runs and no online runner",
+				"environment", env.Name, "queued_runs", env.QueuedRuns, "oldest_run_id", env.OldestRunID)
+		}
+		if _, err := s.RecordNoRunnerAvailable(ctx, env, now); err != nil {
+			logger.Error("record no runner available error", "run_id", env.OldestRunID, "error", err)
+		}
+	}
+	for name := range m.starved {
+		if !current[name] {
+			metrics.EnvironmentStarved(name, false)
+			logger.Info("environment no longer starved", "environment", name)
+		}
+	}
+	m.starved = current
+	return len(envs)
+}
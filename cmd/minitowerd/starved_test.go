@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"minitower/internal/config"
+	"minitower/internal/httpapi"
+	"minitower/internal/objects"
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestStarvationMonitorFlagsEnvironmentWithoutOnlineRunner(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	cfg := config.Config{LeaseTTL: 60 * time.Second, MaxRequestBodySize: 1 << 20, MaxArtifactSize: 1 << 20}
+	api := httpapi.New(cfg, dbConn, objStore, slog.New(slog.NewTextHandler(io.Discard, nil)), httpapi.WithPrometheusRegisterer(prometheus.NewRegistry()))
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-starved")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "starved-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	oldest := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	runner, _ := testutil.CreateRunner(t, s, "runner-starved", "default")
+	if _, err := dbConn.Exec(`UPDATE runners SET status = 'offline' WHERE id = ?`, runner.ID); err != nil {
+		t.Fatalf("force runner offline: %v", err)
+	}
+	// An online runner of another environment does not help.
+	testutil.CreateRunner(t, s, "runner-gpu", "gpu")
+
+	gauge := func() string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		api.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			if strings.HasPrefix(line, "minitower_environments_starved{") {
+				return line
+			}
+		}
+		return ""
+	}
+	events := func() int {
+		t.Helper()
+		var n int
+		if err := dbConn.QueryRow(`SELECT COUNT(*) FROM run_events WHERE run_id = ? AND kind = ?`, oldest.ID, store.RunEventNoRunnerAvailable).Scan(&n); err != nil {
+			t.Fatalf("count events: %v", err)
+		}
+		return n
+	}
+
+	m := newStarvationMonitor()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	now := time.Now()
+	if n := m.check(ctx, s, api, logger, now); n != 1 {
+		t.Fatalf("expected one starved environment, got %d", n)
+	}
+	if got, want := gauge(), `minitower_environments_starved{environment="default"} 1`; got != want {
+		t.Fatalf("gauge = %q, want %q", got, want)
+	}
+	if n := events(); n != 1 {
+		t.Fatalf("expected a no_runner_available event on the oldest queued run, got %d", n)
+	}
+
+	// The event is not repeated within the interval, and is after it.
+	m.check(ctx, s, api, logger, now.Add(time.Minute))
+	if n := events(); n != 1 {
+		t.Fatalf("expected no second event within %s, got %d", store.NoRunnerEventInterval, n)
+	}
+	m.check(ctx, s, api, logger, now.Add(store.NoRunnerEventInterval+time.Minute))
+	if n := events(); n != 2 {
+		t.Fatalf("expected another event after %s, got %d", store.NoRunnerEventInterval, n)
+	}
+
+	overview, err := s.GetAdminOverview(ctx, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("admin overview: %v", err)
+	}
+	if len(overview.Starved) != 1 || overview.Starved[0].Name != "default" || overview.Starved[0].QueuedRuns != 2 {
+		t.Fatalf("expected the overview to flag default with 2 queued runs, got %+v", overview.Starved)
+	}
+
+	// A heartbeat brings the runner back online.
+	if err := s.MarkRunnerOnline(ctx, runner.ID); err != nil {
+		t.Fatalf("mark runner online: %v", err)
+	}
+	if n := m.check(ctx, s, api, logger, now.Add(time.Hour)); n != 0 {
+		t.Fatalf("expected no starved environment once the runner is online, got %d", n)
+	}
+	if got, want := gauge(), `minitower_environments_starved{environment="default"} 0`; got != want {
+		t.Fatalf("gauge = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"minitower/internal/integrity"
+	"minitower/internal/objects"
+	"minitower/internal/store"
+)
+
+// verifyObjects runs one object verification pass for --verify-objects and
+// prints its report to w: a line per problem, then a summary. It returns
+// the number of problems found.
+func verifyObjects(ctx context.Context, s *store.Store, objStore *objects.LocalStore, markCorrupt bool, w io.Writer) (int, error) {
+	verifier := integrity.New(s, objStore, integrity.Options{
+		BytesPerSecond: integrity.DefaultBytesPerSecond,
+		MarkCorrupt:    markCorrupt,
+	})
+	rep, err := verifier.Run(ctx, nil)
+	if err != nil {
+		return len(rep.Problems), err
+	}
+
+	for _, p := range rep.Problems {
+		detail := p.ObjectKey
+		switch p.Kind {
+		case integrity.ProblemMismatch:
+			detail += fmt.Sprintf(" (expected sha256 %s, got %s)", p.ExpectedSHA256, p.ActualSHA256)
+		case integrity.ProblemUnreadable:
+			detail += " (" + p.Err + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s/%s v%d\t%s\n", p.Kind, p.Team, p.App, p.VersionNo, detail)
+	}
+	fmt.Fprintf(w, "checked %d versions (%d bytes), %d problems\n", rep.Checked, rep.Bytes, len(rep.Problems))
+	if markCorrupt {
+		fmt.Fprintf(w, "marked %d versions corrupt, cleared %d\n", rep.Marked, rep.Cleared)
+	}
+	return len(rep.Problems), nil
+}
@@ -161,6 +161,7 @@ export interface AdminRunner {
 
 export interface AdminRunnersResponse {
   runners: AdminRunner[]
+  total: number
 }
 
 export interface CreateTokenRequest {
@@ -0,0 +1,123 @@
+// Package apierror defines the error codes the API returns in the "code"
+// field of its error envelope. Each code has exactly one HTTP status, so
+// clients can branch on the code without also checking the status.
+package apierror
+
+import "net/http"
+
+// Code is a machine-readable API error code.
+type Code string
+
+// Error codes. Values are part of the API and must not change.
+const (
+	Internal               Code = "internal"
+	Unavailable            Code = "unavailable"
+	InvalidRequest         Code = "invalid_request"
+	InvalidSlug            Code = "invalid_slug"
+	InvalidName            Code = "invalid_name"
+	TowerfileMissing       Code = "TOWERFILE_MISSING"
+	TowerfileInvalid       Code = "TOWERFILE_INVALID"
+	InvalidArtifact        Code = "invalid_artifact"
+	NoVersion              Code = "no_version"
+	Unauthorized           Code = "unauthorized"
+	Forbidden              Code = "forbidden"
+	InsufficientScope      Code = "insufficient_scope"
+	NotFound               Code = "not_found"
+	SlugTaken              Code = "slug_taken"
+	NameTaken              Code = "name_taken"
+	TeamExists             Code = "team_exists"
+	RunnerExists           Code = "runner_exists"
+	AppDisabled            Code = "app_disabled"
+	LeaseConflict          Code = "lease_conflict"
+	RunNotQueued           Code = "run_not_queued"
+	EnvironmentInUse       Code = "environment_in_use"
+	EnvironmentIsDefault   Code = "environment_is_default"
+	BackupInProgress       Code = "backup_in_progress"
+	LeaseInvalid           Code = "lease_invalid"
+	AttemptNotActive       Code = "attempt_not_active"
+	FileTooLarge           Code = "file_too_large"
+	BinaryFile             Code = "binary_file"
+	OutputLimit            Code = "output_limit"
+	ConfirmCountMismatch   Code = "confirm_count_mismatch"
+	QuotaExceeded          Code = "quota_exceeded"
+	LogQuotaExceeded       Code = "log_quota_exceeded"
+	SHA256Mismatch         Code = "sha256_mismatch"
+	ArtifactTooLarge       Code = "artifact_too_large"
+	InputTooLarge          Code = "input_too_large"
+	ArtifactCorrupt        Code = "artifact_corrupt"
+	VerificationInProgress Code = "verification_in_progress"
+)
+
+// Entry describes one code in the catalog.
+type Entry struct {
+	Code        Code   `json:"code"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+var catalog = []Entry{
+	{Internal, http.StatusInternalServerError, "Unexpected server error; the request ID locates the server log lines."},
+	{Unavailable, http.StatusServiceUnavailable, "The server is shutting down or its database is not ready; retry later."},
+	{InvalidRequest, http.StatusBadRequest, "The request body, query or path is malformed or fails validation."},
+	{InvalidSlug, http.StatusBadRequest, "A slug does not match the allowed pattern."},
+	{InvalidName, http.StatusBadRequest, "A name does not match the allowed pattern."},
+	{TowerfileMissing, http.StatusBadRequest, "The uploaded artifact has no Towerfile at its root."},
+	{TowerfileInvalid, http.StatusBadRequest, "The Towerfile in the uploaded artifact does not parse or validate."},
+	{InvalidArtifact, http.StatusBadRequest, "The uploaded artifact is not a gzip tarball, has an entry outside the archive root, or lacks the entrypoint."},
+	{NoVersion, http.StatusBadRequest, "The app has no versions to run."},
+	{Unauthorized, http.StatusUnauthorized, "The token or credentials are missing, invalid or revoked."},
+	{Forbidden, http.StatusForbidden, "The token is valid but lacks the required role, or the action is disabled."},
+	{InsufficientScope, http.StatusForbidden, "The token is limited to scopes or an app that do not cover the request."},
+	{NotFound, http.StatusNotFound, "The resource does not exist or belongs to another team."},
+	{SlugTaken, http.StatusConflict, "Another resource already uses the slug."},
+	{NameTaken, http.StatusConflict, "Another resource already uses the name."},
+	{TeamExists, http.StatusConflict, "A team with the slug already exists."},
+	{RunnerExists, http.StatusConflict, "A runner with the name is already registered; register with rotate to replace its token."},
+	{AppDisabled, http.StatusConflict, "The app is disabled and does not accept new runs."},
+	{LeaseConflict, http.StatusConflict, "The runner already holds a lease, or the attempt is in a state that conflicts with the request."},
+	{RunNotQueued, http.StatusConflict, "The run has left the queue."},
+	{EnvironmentInUse, http.StatusConflict, "The environment is referenced by runs or runners."},
+	{EnvironmentIsDefault, http.StatusConflict, "The default environment cannot be deleted."},
+	{BackupInProgress, http.StatusConflict, "Another backup is running."},
+	{VerificationInProgress, http.StatusConflict, "Another object verification is running."},
+	{LeaseInvalid, http.StatusGone, "The lease token is invalid or the lease expired; the runner must stop the attempt."},
+	{AttemptNotActive, http.StatusGone, "The attempt is no longer active; the runner must stop it."},
+	{FileTooLarge, http.StatusRequestEntityTooLarge, "The requested or uploaded file exceeds the size limit."},
+	{ArtifactTooLarge, http.StatusRequestEntityTooLarge, "The uploaded artifact exceeds the server's maximum artifact size; the error's limit field has the maximum in bytes and count the upload's size when it was known."},
+	{InputTooLarge, http.StatusRequestEntityTooLarge, "The run input is over the server's maximum stored input size, measured after compression; the error's count and limit fields have its size and the maximum in bytes."},
+	{ArtifactCorrupt, http.StatusConflict, "Object verification found the version's artifact missing or corrupted; deploy the app again or restore the object and re-verify."},
+	{BinaryFile, http.StatusUnsupportedMediaType, "The requested file is not UTF-8 text."},
+	{SHA256Mismatch, http.StatusUnprocessableEntity, "The uploaded artifact's sha256 differs from expected_sha256; the error's expected_sha256 and actual_sha256 fields have both and nothing was stored."},
+	{OutputLimit, http.StatusConflict, "The run already has the maximum number of outputs."},
+	{ConfirmCountMismatch, http.StatusConflict, "confirm_count does not match the number of runs the filter selects; the error's count field has the actual number."},
+	{QuotaExceeded, http.StatusTooManyRequests, "The team is at its quota of queued and active runs; the error's count and limit fields have the current count and the quota."},
+	{LogQuotaExceeded, http.StatusRequestEntityTooLarge, "The attempt has submitted the maximum number of log lines; later lines are rejected."},
+}
+
+var byCode = func() map[Code]Entry {
+	m := make(map[Code]Entry, len(catalog))
+	for _, e := range catalog {
+		m[e.Code] = e
+	}
+	return m
+}()
+
+// Catalog returns every code, in documentation order.
+func Catalog() []Entry {
+	return append([]Entry(nil), catalog...)
+}
+
+// Lookup returns the catalog entry for code.
+func Lookup(code string) (Entry, bool) {
+	e, ok := byCode[Code(code)]
+	return e, ok
+}
+
+// Status returns the HTTP status for c, or 500 for a code missing from the
+// catalog.
+func (c Code) Status() int {
+	if e, ok := byCode[c]; ok {
+		return e.Status
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,28 @@
+package apierror
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCatalogIsConsistent(t *testing.T) {
+	seen := make(map[Code]bool)
+	for _, e := range Catalog() {
+		if seen[e.Code] {
+			t.Errorf("code %q listed twice", e.Code)
+		}
+		seen[e.Code] = true
+		if e.Status < 400 || e.Status > 599 || http.StatusText(e.Status) == "" {
+			t.Errorf("code %q has non-error status %d", e.Code, e.Status)
+		}
+		if e.Description == "" {
+			t.Errorf("code %q has no description", e.Code)
+		}
+		if got, ok := Lookup(string(e.Code)); !ok || got != e {
+			t.Errorf("Lookup(%q) = %+v, %v", e.Code, got, ok)
+		}
+	}
+	if Code("no_such_code").Status() != http.StatusInternalServerError {
+		t.Error("unknown code should map to 500")
+	}
+}
@@ -13,6 +13,7 @@ const tokenBytes = 32
 const (
 	PrefixTeamToken   = "mtk_"
 	PrefixRunnerToken = "mtr_"
+	PrefixStatusToken = "mts_"
 )
 
 // GenerateToken returns a new random token and its SHA-256 hash.
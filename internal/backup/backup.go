@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	filePrefix = "minitower-"
+	fileSuffix = ".db"
+	timeLayout = "20060102T150405.000Z"
+)
+
+// ErrInProgress is returned when a snapshot is requested while another is running.
+var ErrInProgress = errors.New("backup already in progress")
+
+// Snapshot describes a completed database snapshot.
+type Snapshot struct {
+	Path      string
+	SizeBytes int64
+	SHA256    string
+	CreatedAt time.Time
+}
+
+// Manager writes consistent SQLite snapshots into a directory and keeps the
+// most recent ones. At most one snapshot runs at a time.
+type Manager struct {
+	db     *sql.DB
+	dir    string
+	retain int
+	mu     sync.Mutex
+}
+
+// NewManager creates a Manager. A retain value of 0 keeps every snapshot.
+func NewManager(db *sql.DB, dir string, retain int) *Manager {
+	return &Manager{db: db, dir: dir, retain: retain}
+}
+
+// Dir returns the backup directory.
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Snapshot writes a new snapshot using VACUUM INTO, which produces a
+// transactionally consistent copy while the server keeps serving writes.
+// Returns ErrInProgress if another snapshot is already running.
+func (m *Manager) Snapshot(ctx context.Context, now time.Time) (*Snapshot, error) {
+	if !m.mu.TryLock() {
+		return nil, ErrInProgress
+	}
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.dir, 0750); err != nil {
+		return nil, fmt.Errorf("create backup dir: %w", err)
+	}
+
+	createdAt := now.UTC()
+	name := filePrefix + createdAt.Format(timeLayout) + fileSuffix
+	finalPath := filepath.Join(m.dir, name)
+	// Write under a temp name so a crash never leaves a partial file that
+	// looks like a complete snapshot.
+	tmpPath := finalPath + ".tmp"
+	_ = os.Remove(tmpPath)
+
+	if _, err := m.db.ExecContext(ctx, `VACUUM INTO ?`, tmpPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, fmt.Errorf("vacuum into: %w", err)
+	}
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, fmt.Errorf("finalize snapshot: %w", err)
+	}
+
+	size, sum, err := hashFile(finalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := m.prune(); err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{
+		Path:      finalPath,
+		SizeBytes: size,
+		SHA256:    sum,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+// Prune removes all but the newest retained snapshots and returns the removed paths.
+func (m *Manager) Prune() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prune()
+}
+
+func (m *Manager) prune() ([]string, error) {
+	if m.retain <= 0 {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read backup dir: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) <= m.retain {
+		return nil, nil
+	}
+
+	// Timestamps in the file name sort lexically in creation order.
+	sort.Strings(names)
+	var removed []string
+	for _, name := range names[:len(names)-m.retain] {
+		path := filepath.Join(m.dir, name)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, fmt.Errorf("remove snapshot: %w", err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", fmt.Errorf("hash snapshot: %w", err)
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"minitower/internal/db"
+	"minitower/internal/testutil"
+)
+
+func TestSnapshotDuringConcurrentWritesIsConsistent(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		if _, err := s.CreateTeam(ctx, fmt.Sprintf("before-%d", i), "before"); err != nil {
+			t.Fatalf("create team: %v", err)
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		stop    atomic.Bool
+		written atomic.Int64
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; !stop.Load(); i++ {
+			if _, err := s.CreateTeam(ctx, fmt.Sprintf("during-%d", i), "during"); err != nil {
+				t.Errorf("concurrent create team: %v", err)
+				return
+			}
+			written.Add(1)
+		}
+	}()
+
+	m := NewManager(dbConn, t.TempDir(), 0)
+	snap, err := m.Snapshot(ctx, time.Now())
+	stop.Store(true)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if snap.SizeBytes <= 0 || len(snap.SHA256) != 64 {
+		t.Fatalf("unexpected snapshot metadata: %+v", snap)
+	}
+
+	restored, err := db.Open(ctx, snap.Path)
+	if err != nil {
+		t.Fatalf("open snapshot: %v", err)
+	}
+	defer restored.Close()
+
+	var integrity string
+	if err := restored.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&integrity); err != nil {
+		t.Fatalf("integrity check: %v", err)
+	}
+	if integrity != "ok" {
+		t.Fatalf("integrity check failed: %s", integrity)
+	}
+
+	var before, during int64
+	if err := restored.QueryRowContext(ctx, `SELECT COUNT(*) FROM teams WHERE slug LIKE 'before-%'`).Scan(&before); err != nil {
+		t.Fatalf("count teams: %v", err)
+	}
+	if before != 20 {
+		t.Fatalf("expected 20 pre-snapshot teams, got %d", before)
+	}
+	if err := restored.QueryRowContext(ctx, `SELECT COUNT(*) FROM teams WHERE slug LIKE 'during-%'`).Scan(&during); err != nil {
+		t.Fatalf("count teams: %v", err)
+	}
+	if during > written.Load() {
+		t.Fatalf("snapshot has %d concurrent rows, only %d were written", during, written.Load())
+	}
+}
+
+func TestSnapshotRetentionPrunesOldFiles(t *testing.T) {
+	_, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	dir := t.TempDir()
+	// Unrelated files in the backup dir must be left alone.
+	other := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(other, []byte("keep"), 0600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	m := NewManager(dbConn, dir, 2)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var paths []string
+	for i := 0; i < 4; i++ {
+		snap, err := m.Snapshot(context.Background(), base.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("snapshot %d: %v", i, err)
+		}
+		paths = append(paths, snap.Path)
+	}
+
+	for _, p := range paths[:2] {
+		if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected %s to be pruned, stat err=%v", p, err)
+		}
+	}
+	for _, p := range paths[2:] {
+		if _, err := os.Stat(p); err != nil {
+			t.Fatalf("expected %s to be kept: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("unrelated file removed: %v", err)
+	}
+}
+
+func TestSnapshotRejectsConcurrentBackup(t *testing.T) {
+	_, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	m := NewManager(dbConn, t.TempDir(), 0)
+	m.mu.Lock()
+	_, err := m.Snapshot(context.Background(), time.Now())
+	m.mu.Unlock()
+	if !errors.Is(err, ErrInProgress) {
+		t.Fatalf("expected ErrInProgress, got %v", err)
+	}
+
+	if _, err := m.Snapshot(context.Background(), time.Now()); err != nil {
+		t.Fatalf("snapshot after release: %v", err)
+	}
+}
@@ -16,25 +16,86 @@ const (
 	defaultPublicSignupEnabled = true
 	defaultLeaseTTL            = 60 * time.Second
 	defaultExpiryCheckInterval = 10 * time.Second
+	defaultCancelGracePeriod   = 20 * time.Second // twice the runner's default kill grace period
+	defaultStartDeadlineRatio  = 0.25
+	minStartDeadline           = 15 * time.Second
+	defaultReaperBatch         = 100
+	defaultReaperTickBudget    = 5 * time.Second
 	defaultRunnerPruneAfter    = 24 * time.Hour
 	defaultMaxRequestBodySize  = 10 * 1024 * 1024  // 10MB
 	defaultMaxArtifactSize     = 100 * 1024 * 1024 // 100MB
+	defaultMaxInputSize        = 1024 * 1024       // 1MB
+	defaultInputCompressAbove  = 32 * 1024         // 32KB
+	defaultBackupDir           = "./backups"
+	defaultBackupRetain        = 7
+	defaultPriorityAgingCap    = 10
+	defaultLogQuotaPerAttempt  = 250_000
+	defaultShutdownDrainDelay  = 5 * time.Second
 )
 
 // Config contains control-plane configuration.
 type Config struct {
-	ListenAddr              string
-	DBPath                  string
-	ObjectsDir              string
-	BootstrapToken          string
-	PublicSignupEnabled     bool
-	RunnerRegistrationToken string
-	CORSOrigins             []string
-	LeaseTTL                time.Duration
-	ExpiryCheckInterval     time.Duration
-	RunnerPruneAfter        time.Duration
-	MaxRequestBodySize      int64
-	MaxArtifactSize         int64
+	ListenAddr          string
+	OpsListenAddr       string
+	MetricsToken        string
+	EnablePprof         bool
+	DBPath              string
+	ObjectsDir          string
+	BootstrapToken      string
+	PublicSignupEnabled bool
+	// RunnerRegistrationTokens are the registration tokens runners may
+	// register with; more than one allows rotating the token without
+	// stopping registrations.
+	RunnerRegistrationTokens []string
+	CORSOrigins              []string
+	LeaseTTL                 time.Duration
+	ExpiryCheckInterval      time.Duration
+	CancelGracePeriod        time.Duration
+	ReaperBatch              int
+	ReaperTickBudget         time.Duration
+	RunnerPruneAfter         time.Duration
+	MaxRequestBodySize       int64
+	MaxArtifactSize          int64
+	MaxInputSize             int64
+	InputCompressAbove       int64
+	BackupDir                string
+	BackupInterval           time.Duration
+	BackupRetain             int
+	LogRetentionDays         int
+	LogMaxRowsPerAttempt     int
+	LogArchive               bool
+	AuditRetentionDays       int
+	StrictRunnerNames        bool
+
+	// LogQuotaPerAttempt caps the log lines one attempt may submit; 0 is
+	// unlimited. Runners cap their own output well below it.
+	LogQuotaPerAttempt int
+
+	// PriorityAgingMinutes raises a queued run's effective priority by one
+	// per that many minutes waited, by at most PriorityAgingCap. Zero
+	// disables aging.
+	PriorityAgingMinutes int
+	PriorityAgingCap     int
+
+	// StartDeadlineRatio is the fraction of LeaseTTL an attempt may stay
+	// leased without its runner calling start; see StartDeadline. Zero
+	// disables the start deadline.
+	StartDeadlineRatio float64
+
+	// ShutdownDrainDelay is how long shutdown keeps serving with /readyz
+	// answering 503 before the listeners close, so load balancers stop
+	// sending traffic first. Zero closes them at once.
+	ShutdownDrainDelay time.Duration
+}
+
+// StartDeadline returns how long the reaper lets an attempt stay leased
+// without a start before re-queueing its run: StartDeadlineRatio of the
+// lease TTL, but at least 15s. Zero means no start deadline.
+func (c Config) StartDeadline() time.Duration {
+	if c.StartDeadlineRatio <= 0 {
+		return 0
+	}
+	return max(time.Duration(float64(c.LeaseTTL)*c.StartDeadlineRatio), minStartDeadline)
 }
 
 // Load reads configuration from environment variables with defaults.
@@ -46,14 +107,38 @@ func Load() (Config, error) {
 		PublicSignupEnabled: defaultPublicSignupEnabled,
 		LeaseTTL:            defaultLeaseTTL,
 		ExpiryCheckInterval: defaultExpiryCheckInterval,
+		CancelGracePeriod:   defaultCancelGracePeriod,
+		ReaperBatch:         defaultReaperBatch,
+		StartDeadlineRatio:  defaultStartDeadlineRatio,
+		ReaperTickBudget:    defaultReaperTickBudget,
 		RunnerPruneAfter:    defaultRunnerPruneAfter,
 		MaxRequestBodySize:  defaultMaxRequestBodySize,
 		MaxArtifactSize:     defaultMaxArtifactSize,
+		MaxInputSize:        defaultMaxInputSize,
+		InputCompressAbove:  defaultInputCompressAbove,
+		BackupDir:           defaultBackupDir,
+		BackupRetain:        defaultBackupRetain,
+		PriorityAgingCap:    defaultPriorityAgingCap,
+		LogQuotaPerAttempt:  defaultLogQuotaPerAttempt,
+		ShutdownDrainDelay:  defaultShutdownDrainDelay,
 	}
 
 	if v := strings.TrimSpace(os.Getenv("MINITOWER_LISTEN_ADDR")); v != "" {
 		cfg.ListenAddr = v
 	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_OPS_LISTEN_ADDR")); v != "" {
+		cfg.OpsListenAddr = v
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_METRICS_TOKEN")); v != "" {
+		cfg.MetricsToken = v
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_ENABLE_PPROF")); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_ENABLE_PPROF: %w", err)
+		}
+		cfg.EnablePprof = enabled
+	}
 	if v := strings.TrimSpace(os.Getenv("MINITOWER_DB_PATH")); v != "" {
 		cfg.DBPath = v
 	}
@@ -77,6 +162,26 @@ func Load() (Config, error) {
 		}
 		cfg.LeaseTTL = dur
 	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_PRIORITY_AGING_MINUTES")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_PRIORITY_AGING_MINUTES: %w", err)
+		}
+		if n < 0 {
+			return cfg, errors.New("invalid MINITOWER_PRIORITY_AGING_MINUTES: must be >= 0")
+		}
+		cfg.PriorityAgingMinutes = n
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_PRIORITY_AGING_CAP")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_PRIORITY_AGING_CAP: %w", err)
+		}
+		if n < 0 {
+			return cfg, errors.New("invalid MINITOWER_PRIORITY_AGING_CAP: must be >= 0")
+		}
+		cfg.PriorityAgingCap = n
+	}
 	if v := strings.TrimSpace(os.Getenv("MINITOWER_EXPIRY_CHECK_INTERVAL")); v != "" {
 		dur, err := time.ParseDuration(v)
 		if err != nil {
@@ -84,6 +189,56 @@ func Load() (Config, error) {
 		}
 		cfg.ExpiryCheckInterval = dur
 	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_CANCEL_GRACE_PERIOD")); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_CANCEL_GRACE_PERIOD: %w", err)
+		}
+		if dur <= 0 {
+			return cfg, errors.New("invalid MINITOWER_CANCEL_GRACE_PERIOD: must be positive")
+		}
+		cfg.CancelGracePeriod = dur
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_SHUTDOWN_DRAIN_DELAY")); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_SHUTDOWN_DRAIN_DELAY: %w", err)
+		}
+		if dur < 0 {
+			return cfg, errors.New("invalid MINITOWER_SHUTDOWN_DRAIN_DELAY: must be >= 0")
+		}
+		cfg.ShutdownDrainDelay = dur
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_START_DEADLINE_RATIO")); v != "" {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_START_DEADLINE_RATIO: %w", err)
+		}
+		if ratio < 0 || ratio >= 1 {
+			return cfg, errors.New("invalid MINITOWER_START_DEADLINE_RATIO: must be >= 0 and < 1")
+		}
+		cfg.StartDeadlineRatio = ratio
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_REAPER_BATCH")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_REAPER_BATCH: %w", err)
+		}
+		if n <= 0 {
+			return cfg, errors.New("invalid MINITOWER_REAPER_BATCH: must be positive")
+		}
+		cfg.ReaperBatch = n
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_REAPER_TICK_BUDGET")); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_REAPER_TICK_BUDGET: %w", err)
+		}
+		if dur <= 0 {
+			return cfg, errors.New("invalid MINITOWER_REAPER_TICK_BUDGET: must be positive")
+		}
+		cfg.ReaperTickBudget = dur
+	}
 	if v := strings.TrimSpace(os.Getenv("MINITOWER_RUNNER_PRUNE_AFTER")); v != "" {
 		dur, err := time.ParseDuration(v)
 		if err != nil {
@@ -105,9 +260,107 @@ func Load() (Config, error) {
 		}
 		cfg.MaxArtifactSize = size
 	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_MAX_INPUT_SIZE")); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_MAX_INPUT_SIZE: %w", err)
+		}
+		if size < 0 {
+			return cfg, errors.New("invalid MINITOWER_MAX_INPUT_SIZE: must be >= 0")
+		}
+		cfg.MaxInputSize = size
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_INPUT_COMPRESS_THRESHOLD")); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_INPUT_COMPRESS_THRESHOLD: %w", err)
+		}
+		if size < 0 {
+			return cfg, errors.New("invalid MINITOWER_INPUT_COMPRESS_THRESHOLD: must be >= 0")
+		}
+		cfg.InputCompressAbove = size
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_BACKUP_DIR")); v != "" {
+		cfg.BackupDir = v
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_BACKUP_INTERVAL")); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_BACKUP_INTERVAL: %w", err)
+		}
+		cfg.BackupInterval = dur
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_BACKUP_RETAIN")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_BACKUP_RETAIN: %w", err)
+		}
+		if n < 0 {
+			return cfg, errors.New("invalid MINITOWER_BACKUP_RETAIN: must be >= 0")
+		}
+		cfg.BackupRetain = n
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_LOG_RETENTION_DAYS")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_LOG_RETENTION_DAYS: %w", err)
+		}
+		if n < 0 {
+			return cfg, errors.New("invalid MINITOWER_LOG_RETENTION_DAYS: must be >= 0")
+		}
+		cfg.LogRetentionDays = n
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_LOG_MAX_ROWS_PER_ATTEMPT")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_LOG_MAX_ROWS_PER_ATTEMPT: %w", err)
+		}
+		if n < 0 {
+			return cfg, errors.New("invalid MINITOWER_LOG_MAX_ROWS_PER_ATTEMPT: must be >= 0")
+		}
+		cfg.LogMaxRowsPerAttempt = n
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_LOG_QUOTA_PER_ATTEMPT")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_LOG_QUOTA_PER_ATTEMPT: %w", err)
+		}
+		if n < 0 {
+			return cfg, errors.New("invalid MINITOWER_LOG_QUOTA_PER_ATTEMPT: must be >= 0")
+		}
+		cfg.LogQuotaPerAttempt = n
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_AUDIT_RETENTION_DAYS")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_AUDIT_RETENTION_DAYS: %w", err)
+		}
+		if n < 0 {
+			return cfg, errors.New("invalid MINITOWER_AUDIT_RETENTION_DAYS: must be >= 0")
+		}
+		cfg.AuditRetentionDays = n
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_LOG_ARCHIVE")); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_LOG_ARCHIVE: %w", err)
+		}
+		cfg.LogArchive = enabled
+	}
 
 	if v := strings.TrimSpace(os.Getenv("MINITOWER_RUNNER_REGISTRATION_TOKEN")); v != "" {
-		cfg.RunnerRegistrationToken = v
+		for _, part := range strings.Split(v, ",") {
+			if token := strings.TrimSpace(part); token != "" {
+				cfg.RunnerRegistrationTokens = append(cfg.RunnerRegistrationTokens, token)
+			}
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("MINITOWER_STRICT_RUNNER_NAMES")); v != "" {
+		strict, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MINITOWER_STRICT_RUNNER_NAMES: %w", err)
+		}
+		cfg.StrictRunnerNames = strict
 	}
 	if v := strings.TrimSpace(os.Getenv("MINITOWER_CORS_ORIGINS")); v != "" {
 		parts := strings.Split(v, ",")
@@ -121,12 +374,18 @@ func Load() (Config, error) {
 		}
 	}
 
-	if cfg.RunnerRegistrationToken == "" {
+	if len(cfg.RunnerRegistrationTokens) == 0 {
 		return cfg, errors.New("MINITOWER_RUNNER_REGISTRATION_TOKEN is required")
 	}
 	if !cfg.PublicSignupEnabled && cfg.BootstrapToken == "" {
 		return cfg, errors.New("MINITOWER_BOOTSTRAP_TOKEN is required when MINITOWER_PUBLIC_SIGNUP_ENABLED is false")
 	}
+	if cfg.EnablePprof && cfg.OpsListenAddr == "" {
+		return cfg, errors.New("MINITOWER_ENABLE_PPROF requires MINITOWER_OPS_LISTEN_ADDR")
+	}
+	if cfg.OpsListenAddr != "" && cfg.OpsListenAddr == cfg.ListenAddr {
+		return cfg, errors.New("MINITOWER_OPS_LISTEN_ADDR must differ from MINITOWER_LISTEN_ADDR")
+	}
 
 	return cfg, nil
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadDefaultsToPublicSignupWithoutBootstrapToken(t *testing.T) {
@@ -65,3 +66,273 @@ func TestLoadRejectsInvalidPublicSignupValue(t *testing.T) {
 		t.Fatalf("expected public signup parse error, got: %v", err)
 	}
 }
+
+func TestLoadParsesBackupSettings(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", "runner-secret")
+	t.Setenv("MINITOWER_BACKUP_DIR", "/var/lib/minitower/backups")
+	t.Setenv("MINITOWER_BACKUP_INTERVAL", "6h")
+	t.Setenv("MINITOWER_BACKUP_RETAIN", "3")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got: %v", err)
+	}
+	if cfg.BackupDir != "/var/lib/minitower/backups" {
+		t.Fatalf("unexpected backup dir: %q", cfg.BackupDir)
+	}
+	if cfg.BackupInterval != 6*time.Hour {
+		t.Fatalf("unexpected backup interval: %s", cfg.BackupInterval)
+	}
+	if cfg.BackupRetain != 3 {
+		t.Fatalf("unexpected backup retain: %d", cfg.BackupRetain)
+	}
+}
+
+func TestLoadRejectsNegativeBackupRetain(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", "runner-secret")
+	t.Setenv("MINITOWER_BACKUP_RETAIN", "-1")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatalf("expected error for negative backup retain")
+	}
+	if !strings.Contains(err.Error(), "invalid MINITOWER_BACKUP_RETAIN") {
+		t.Fatalf("expected backup retain error, got: %v", err)
+	}
+}
+
+func TestLoadParsesLogRetentionSettings(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", "runner-secret")
+	t.Setenv("MINITOWER_LOG_RETENTION_DAYS", "30")
+	t.Setenv("MINITOWER_LOG_MAX_ROWS_PER_ATTEMPT", "50000")
+	t.Setenv("MINITOWER_LOG_ARCHIVE", "true")
+	t.Setenv("MINITOWER_AUDIT_RETENTION_DAYS", "365")
+	t.Setenv("MINITOWER_LOG_QUOTA_PER_ATTEMPT", "1000")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got: %v", err)
+	}
+	if cfg.LogRetentionDays != 30 || cfg.LogMaxRowsPerAttempt != 50000 || !cfg.LogArchive {
+		t.Fatalf("unexpected log retention config: days=%d max_rows=%d archive=%v", cfg.LogRetentionDays, cfg.LogMaxRowsPerAttempt, cfg.LogArchive)
+	}
+	if cfg.AuditRetentionDays != 365 {
+		t.Fatalf("unexpected audit retention days: %d", cfg.AuditRetentionDays)
+	}
+	if cfg.LogQuotaPerAttempt != 1000 {
+		t.Fatalf("unexpected log quota per attempt: %d", cfg.LogQuotaPerAttempt)
+	}
+
+	t.Setenv("MINITOWER_LOG_RETENTION_DAYS", "-1")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid MINITOWER_LOG_RETENTION_DAYS") {
+		t.Fatalf("expected log retention days error, got: %v", err)
+	}
+
+	t.Setenv("MINITOWER_LOG_RETENTION_DAYS", "30")
+	t.Setenv("MINITOWER_AUDIT_RETENTION_DAYS", "-1")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid MINITOWER_AUDIT_RETENTION_DAYS") {
+		t.Fatalf("expected audit retention days error, got: %v", err)
+	}
+}
+
+func TestLoadParsesOpsListenerSettings(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", "runner-secret")
+	t.Setenv("MINITOWER_OPS_LISTEN_ADDR", "127.0.0.1:9090")
+	t.Setenv("MINITOWER_METRICS_TOKEN", "metrics-secret")
+	t.Setenv("MINITOWER_ENABLE_PPROF", "true")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got: %v", err)
+	}
+	if cfg.OpsListenAddr != "127.0.0.1:9090" || cfg.MetricsToken != "metrics-secret" || !cfg.EnablePprof {
+		t.Fatalf("unexpected ops config: addr=%q token=%q pprof=%v", cfg.OpsListenAddr, cfg.MetricsToken, cfg.EnablePprof)
+	}
+
+	t.Setenv("MINITOWER_OPS_LISTEN_ADDR", "")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "MINITOWER_ENABLE_PPROF requires MINITOWER_OPS_LISTEN_ADDR") {
+		t.Fatalf("expected pprof to require an ops listener, got: %v", err)
+	}
+
+	t.Setenv("MINITOWER_ENABLE_PPROF", "")
+	t.Setenv("MINITOWER_OPS_LISTEN_ADDR", defaultListenAddr)
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "must differ") {
+		t.Fatalf("expected ops listener to differ from the API listener, got: %v", err)
+	}
+}
+
+func TestLoadParsesPriorityAgingSettings(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", "runner-secret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got: %v", err)
+	}
+	if cfg.PriorityAgingMinutes != 0 || cfg.PriorityAgingCap != 10 {
+		t.Fatalf("unexpected aging defaults: minutes=%d cap=%d", cfg.PriorityAgingMinutes, cfg.PriorityAgingCap)
+	}
+
+	t.Setenv("MINITOWER_PRIORITY_AGING_MINUTES", "15")
+	t.Setenv("MINITOWER_PRIORITY_AGING_CAP", "3")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got: %v", err)
+	}
+	if cfg.PriorityAgingMinutes != 15 || cfg.PriorityAgingCap != 3 {
+		t.Fatalf("unexpected aging config: minutes=%d cap=%d", cfg.PriorityAgingMinutes, cfg.PriorityAgingCap)
+	}
+
+	t.Setenv("MINITOWER_PRIORITY_AGING_MINUTES", "-5")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid MINITOWER_PRIORITY_AGING_MINUTES") {
+		t.Fatalf("expected aging minutes error, got: %v", err)
+	}
+}
+
+func TestLoadParsesCancelGracePeriod(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", "runner-secret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got: %v", err)
+	}
+	if cfg.CancelGracePeriod != 20*time.Second {
+		t.Fatalf("expected 20s default cancel grace period, got %s", cfg.CancelGracePeriod)
+	}
+
+	t.Setenv("MINITOWER_CANCEL_GRACE_PERIOD", "1m")
+	if cfg, err = Load(); err != nil || cfg.CancelGracePeriod != time.Minute {
+		t.Fatalf("expected 1m cancel grace period, got %s (%v)", cfg.CancelGracePeriod, err)
+	}
+
+	t.Setenv("MINITOWER_CANCEL_GRACE_PERIOD", "0s")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid MINITOWER_CANCEL_GRACE_PERIOD") {
+		t.Fatalf("expected cancel grace period error, got: %v", err)
+	}
+}
+
+func TestLoadParsesShutdownDrainDelay(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", "runner-secret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got: %v", err)
+	}
+	if cfg.ShutdownDrainDelay != 5*time.Second {
+		t.Fatalf("expected 5s default shutdown drain delay, got %s", cfg.ShutdownDrainDelay)
+	}
+
+	t.Setenv("MINITOWER_SHUTDOWN_DRAIN_DELAY", "0s")
+	if cfg, err = Load(); err != nil || cfg.ShutdownDrainDelay != 0 {
+		t.Fatalf("expected no shutdown drain delay, got %s (%v)", cfg.ShutdownDrainDelay, err)
+	}
+
+	for _, v := range []string{"-1s", "soon"} {
+		t.Setenv("MINITOWER_SHUTDOWN_DRAIN_DELAY", v)
+		if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid MINITOWER_SHUTDOWN_DRAIN_DELAY") {
+			t.Fatalf("%s: expected shutdown drain delay error, got: %v", v, err)
+		}
+	}
+}
+
+func TestLoadParsesStartDeadline(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", "runner-secret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got: %v", err)
+	}
+	// A quarter of the default 60s TTL is below the 15s minimum.
+	if cfg.StartDeadlineRatio != 0.25 || cfg.StartDeadline() != 15*time.Second {
+		t.Fatalf("expected ratio 0.25 and a 15s start deadline, got %v and %s", cfg.StartDeadlineRatio, cfg.StartDeadline())
+	}
+
+	t.Setenv("MINITOWER_LEASE_TTL", "10m")
+	if cfg, err = Load(); err != nil || cfg.StartDeadline() != 150*time.Second {
+		t.Fatalf("expected a 150s start deadline, got %s (%v)", cfg.StartDeadline(), err)
+	}
+
+	t.Setenv("MINITOWER_START_DEADLINE_RATIO", "0")
+	if cfg, err = Load(); err != nil || cfg.StartDeadline() != 0 {
+		t.Fatalf("expected no start deadline, got %s (%v)", cfg.StartDeadline(), err)
+	}
+
+	for _, v := range []string{"1", "-0.1", "quarter"} {
+		t.Setenv("MINITOWER_START_DEADLINE_RATIO", v)
+		if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid MINITOWER_START_DEADLINE_RATIO") {
+			t.Fatalf("%s: expected start deadline ratio error, got: %v", v, err)
+		}
+	}
+}
+
+func TestLoadParsesReaperSettings(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", "runner-secret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got: %v", err)
+	}
+	if cfg.ReaperBatch != 100 || cfg.ReaperTickBudget != 5*time.Second {
+		t.Fatalf("expected reaper defaults 100 and 5s, got %d and %s", cfg.ReaperBatch, cfg.ReaperTickBudget)
+	}
+
+	t.Setenv("MINITOWER_REAPER_BATCH", "500")
+	t.Setenv("MINITOWER_REAPER_TICK_BUDGET", "2s")
+	if cfg, err = Load(); err != nil || cfg.ReaperBatch != 500 || cfg.ReaperTickBudget != 2*time.Second {
+		t.Fatalf("expected reaper batch 500 and budget 2s, got %d and %s (%v)", cfg.ReaperBatch, cfg.ReaperTickBudget, err)
+	}
+
+	t.Setenv("MINITOWER_REAPER_BATCH", "0")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid MINITOWER_REAPER_BATCH") {
+		t.Fatalf("expected reaper batch error, got: %v", err)
+	}
+	t.Setenv("MINITOWER_REAPER_BATCH", "100")
+	t.Setenv("MINITOWER_REAPER_TICK_BUDGET", "-1s")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid MINITOWER_REAPER_TICK_BUDGET") {
+		t.Fatalf("expected reaper tick budget error, got: %v", err)
+	}
+}
+
+func TestLoadAcceptsSeveralRegistrationTokens(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", " reg-old, reg-new ,")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got error: %v", err)
+	}
+	if len(cfg.RunnerRegistrationTokens) != 2 || cfg.RunnerRegistrationTokens[0] != "reg-old" || cfg.RunnerRegistrationTokens[1] != "reg-new" {
+		t.Fatalf("expected both tokens, got %q", cfg.RunnerRegistrationTokens)
+	}
+
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", " , ")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "MINITOWER_RUNNER_REGISTRATION_TOKEN is required") {
+		t.Fatalf("expected a list of blanks to count as unset, got %v", err)
+	}
+}
+
+func TestLoadParsesInputSizeSettings(t *testing.T) {
+	t.Setenv("MINITOWER_RUNNER_REGISTRATION_TOKEN", "runner-secret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected config to load, got: %v", err)
+	}
+	if cfg.MaxInputSize != 1024*1024 || cfg.InputCompressAbove != 32*1024 {
+		t.Fatalf("expected input defaults 1MB and 32KB, got %d and %d", cfg.MaxInputSize, cfg.InputCompressAbove)
+	}
+
+	t.Setenv("MINITOWER_MAX_INPUT_SIZE", "0")
+	t.Setenv("MINITOWER_INPUT_COMPRESS_THRESHOLD", "4096")
+	if cfg, err = Load(); err != nil || cfg.MaxInputSize != 0 || cfg.InputCompressAbove != 4096 {
+		t.Fatalf("expected max input size 0 and threshold 4096, got %d and %d (%v)", cfg.MaxInputSize, cfg.InputCompressAbove, err)
+	}
+
+	t.Setenv("MINITOWER_MAX_INPUT_SIZE", "-1")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid MINITOWER_MAX_INPUT_SIZE") {
+		t.Fatalf("expected max input size error, got: %v", err)
+	}
+	t.Setenv("MINITOWER_MAX_INPUT_SIZE", "0")
+	t.Setenv("MINITOWER_INPUT_COMPRESS_THRESHOLD", "lots")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "invalid MINITOWER_INPUT_COMPRESS_THRESHOLD") {
+		t.Fatalf("expected compress threshold error, got: %v", err)
+	}
+}
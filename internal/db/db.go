@@ -4,47 +4,40 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
 )
 
 import _ "modernc.org/sqlite"
 
 const driverName = "sqlite"
 
+// maxOpenConns bounds the connection pool. Writes go through the database's
+// Writer one at a time, so the other connections serve reads, which WAL
+// lets run alongside the writer.
+const maxOpenConns = 8
+
+// pragmas are applied to every connection the pool opens.
+var pragmas = []string{
+	"journal_mode(WAL)",
+	"foreign_keys(ON)",
+	"busy_timeout(5000)",
+	"synchronous(NORMAL)",
+}
+
 // Open opens a SQLite database and applies required pragmas.
 func Open(ctx context.Context, path string) (*sql.DB, error) {
-	db, err := sql.Open(driverName, path)
+	q := url.Values{"_pragma": pragmas}
+	db, err := sql.Open(driverName, path+"?"+q.Encode())
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
 
-	db.SetMaxOpenConns(1)
+	db.SetMaxOpenConns(maxOpenConns)
 
 	if err := db.PingContext(ctx); err != nil {
 		_ = db.Close()
 		return nil, fmt.Errorf("ping db: %w", err)
 	}
 
-	if err := applyPragmas(ctx, db); err != nil {
-		_ = db.Close()
-		return nil, err
-	}
-
 	return db, nil
 }
-
-func applyPragmas(ctx context.Context, db *sql.DB) error {
-	pragmas := []string{
-		"PRAGMA journal_mode=WAL",
-		"PRAGMA foreign_keys=ON",
-		"PRAGMA busy_timeout=5000",
-		"PRAGMA synchronous=NORMAL",
-	}
-
-	for _, pragma := range pragmas {
-		if _, err := db.ExecContext(ctx, pragma); err != nil {
-			return fmt.Errorf("apply %s: %w", pragma, err)
-		}
-	}
-
-	return nil
-}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Writer runs the write transactions of one database one at a time on a
+// single goroutine. SQLite allows one writer at a time; queueing writes in
+// the process keeps them from contending for the file lock and retrying,
+// while reads keep using the connection pool directly.
+//
+// The goroutine is started by the first queued write and exits when the
+// queue is empty, so an idle database holds no goroutine.
+type Writer struct {
+	db       *sql.DB
+	requests chan *writeRequest
+
+	mu      sync.Mutex
+	pending int
+	running bool
+
+	depth       atomic.Int64
+	observeWait atomic.Pointer[func(time.Duration)]
+}
+
+type writeRequest struct {
+	ctx      context.Context
+	fn       func(*sql.Tx) error
+	queuedAt time.Time
+	done     chan writeResult
+}
+
+type writeResult struct {
+	err      error
+	panicked any
+}
+
+var (
+	writersMu sync.Mutex
+	writers   = make(map[*sql.DB]*Writer)
+)
+
+// WriterFor returns the writer of db. Every caller gets the same writer for
+// the same *sql.DB, so separate stores over one database share its queue.
+// Writers are never released; a process opens a handful of databases.
+func WriterFor(db *sql.DB) *Writer {
+	writersMu.Lock()
+	defer writersMu.Unlock()
+	w, ok := writers[db]
+	if !ok {
+		w = &Writer{db: db, requests: make(chan *writeRequest)}
+		writers[db] = w
+	}
+	return w
+}
+
+// Do runs fn in a transaction after the writes queued before it, committing
+// when fn returns nil and rolling back otherwise. fn must not queue another
+// write, which would wait for itself.
+func (w *Writer) Do(ctx context.Context, fn func(*sql.Tx) error) error {
+	req := &writeRequest{ctx: ctx, fn: fn, queuedAt: time.Now(), done: make(chan writeResult, 1)}
+
+	w.mu.Lock()
+	w.pending++
+	if !w.running {
+		w.running = true
+		go w.loop()
+	}
+	w.mu.Unlock()
+
+	w.depth.Add(1)
+	w.requests <- req
+	res := <-req.done
+	w.depth.Add(-1)
+	if res.panicked != nil {
+		panic(res.panicked)
+	}
+	return res.err
+}
+
+// Depth returns the number of writes queued or running.
+func (w *Writer) Depth() int64 {
+	return w.depth.Load()
+}
+
+// SetWaitObserver sets a function called with the time each write waited in
+// the queue before its transaction began.
+func (w *Writer) SetWaitObserver(observe func(time.Duration)) {
+	w.observeWait.Store(&observe)
+}
+
+func (w *Writer) loop() {
+	for {
+		req := <-w.requests
+		req.done <- w.run(req)
+
+		w.mu.Lock()
+		w.pending--
+		if w.pending == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *Writer) run(req *writeRequest) (res writeResult) {
+	if observe := w.observeWait.Load(); observe != nil {
+		(*observe)(time.Since(req.queuedAt))
+	}
+	if err := req.ctx.Err(); err != nil {
+		return writeResult{err: err}
+	}
+
+	tx, err := w.db.BeginTx(req.ctx, nil)
+	if err != nil {
+		return writeResult{err: fmt.Errorf("begin write: %w", err)}
+	}
+	// A panic in fn is re-raised on the caller's goroutine, not this one,
+	// so the queue keeps running.
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			res = writeResult{panicked: p}
+		}
+	}()
+
+	if err := req.fn(tx); err != nil {
+		_ = tx.Rollback()
+		return writeResult{err: err}
+	}
+	return writeResult{err: tx.Commit()}
+}
@@ -0,0 +1,76 @@
+// Package fingerprint groups run error messages that differ only in their
+// details. Normalize reduces a message to its pattern by replacing IDs, hex
+// strings and numbers with placeholders, so "exit status 3" and "exit
+// status 137" or two timeouts naming different run IDs fall together; Of
+// hashes the pattern into a short, stable fingerprint.
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// maxPatternLen caps a normalized pattern, in bytes; longer patterns are cut
+// at a rune boundary.
+const maxPatternLen = 200
+
+const tracebackHeader = "Traceback (most recent call last):"
+
+// Placeholders, applied in order: UUIDs, hex strings, then any other run of
+// digits.
+var (
+	uuidPattern   = regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+	hexPattern    = regexp.MustCompile(`(?i)\b(?:0x[0-9a-f]+|[0-9a-f]{8,})\b`)
+	numberPattern = regexp.MustCompile(`[0-9]+`)
+)
+
+// Normalize returns the pattern of an error message. A Python traceback is
+// reduced to its last line, the exception; other messages have their
+// whitespace collapsed to single spaces. UUIDs become <id>, 0x-prefixed hex
+// and hex strings of 8 or more characters mixing digits and letters <hex>,
+// and other numbers <n>.
+func Normalize(msg string) string {
+	msg = strings.TrimSpace(msg)
+	if strings.Contains(msg, tracebackHeader) {
+		msg = lastLine(msg)
+	}
+	msg = strings.Join(strings.Fields(msg), " ")
+	msg = uuidPattern.ReplaceAllString(msg, "<id>")
+	msg = hexPattern.ReplaceAllStringFunc(msg, func(s string) string {
+		switch {
+		case strings.HasPrefix(strings.ToLower(s), "0x"):
+			return "<hex>"
+		case !strings.ContainsAny(s, "0123456789"):
+			// Words such as "accepted" or "deadbeef" are kept.
+			return s
+		case !strings.ContainsAny(s, "abcdefABCDEF"):
+			return "<n>"
+		}
+		return "<hex>"
+	})
+	msg = numberPattern.ReplaceAllString(msg, "<n>")
+	if len(msg) > maxPatternLen {
+		msg = strings.ToValidUTF8(msg[:maxPatternLen], "")
+	}
+	return msg
+}
+
+// Of returns the fingerprint of a pattern from Normalize: the first 12 hex
+// characters of its SHA-256.
+func Of(pattern string) string {
+	sum := sha256.Sum256([]byte(pattern))
+	return hex.EncodeToString(sum[:6])
+}
+
+// lastLine returns the last non-blank line of s.
+func lastLine(s string) string {
+	lines := strings.Split(s, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
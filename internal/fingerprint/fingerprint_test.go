@@ -0,0 +1,56 @@
+package fingerprint
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		msg  string
+		want string
+	}{
+		{"timeout", "timeout"},
+		{"  timeout\n", "timeout"},
+		{"exit status 3", "exit status <n>"},
+		{"exit status 137", "exit status <n>"},
+		{"run failed: process exited with code 2", "run failed: process exited with code <n>"},
+		{"pip install failed: exit status 1", "pip install failed: exit status <n>"},
+		{"connect to 10.0.3.17:5432 failed", "connect to <n>.<n>.<n>.<n>:<n> failed"},
+		{"job 3f2a9c1e-7b4d-4e1a-9c2b-0d1e2f3a4b5c not found", "job <id> not found"},
+		{"object 9f86d081884c7d65 missing", "object <hex> missing"},
+		{"segfault at 0x7ffd", "segfault at <hex>"},
+		{"batch 12345678 rejected", "batch <n> rejected"},
+		{"deadbeef accepted", "deadbeef accepted"},
+		{"bad\tinput\n  on line 4", "bad input on line <n>"},
+		{
+			"Traceback (most recent call last):\n  File \"/work/main.py\", line 12, in <module>\n    load(rows)\nKeyError: 'customer_42'\n\n",
+			"KeyError: 'customer_<n>'",
+		},
+		{
+			"run failed\nTraceback (most recent call last):\n  File \"main.py\", line 3, in <module>\nValueError: invalid literal for int() with base 10: 'x'",
+			"ValueError: invalid literal for int() with base <n>: 'x'",
+		},
+	} {
+		if got := Normalize(tc.msg); got != tc.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeCapsLength(t *testing.T) {
+	got := Normalize(strings.Repeat("é", 150))
+	if len(got) > maxPatternLen || !strings.HasPrefix(got, "éé") || strings.ContainsRune(got, '�') {
+		t.Fatalf("expected a pattern cut at a rune boundary within %d bytes, got %d bytes", maxPatternLen, len(got))
+	}
+}
+
+func TestOf(t *testing.T) {
+	a, b := Of(Normalize("exit status 1")), Of(Normalize("exit status 2"))
+	if a != b || len(a) != 12 {
+		t.Fatalf("expected one 12-character fingerprint, got %q and %q", a, b)
+	}
+	if Of(Normalize("timeout")) == a {
+		t.Fatal("different patterns share a fingerprint")
+	}
+}
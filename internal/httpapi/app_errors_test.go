@@ -0,0 +1,125 @@
+package httpapi_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"minitower/internal/testutil"
+)
+
+func TestAppErrorsGroupsFailuresByFingerprint(t *testing.T) {
+	handler, s, dbConn, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-app-errors")
+	runner, _ := testutil.CreateRunner(t, s, "errors-runner", "default")
+	env, err := s.GetOrCreateDefaultEnvironment(context.Background(), team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	flaky := testutil.CreateApp(t, s, team.ID, "flaky")
+	other := testutil.CreateApp(t, s, team.ID, "other")
+
+	now := time.Now()
+	// seed finishes one attempt of a new run of app with status and message
+	// at the given age, and returns the run's ID.
+	seed := func(appID int64, status, message string, age time.Duration) int64 {
+		t.Helper()
+		version := testutil.CreateVersion(t, s, appID)
+		testutil.CreateRun(t, s, team.ID, appID, env.ID, version.ID, 0, 0)
+		run, attempt, _, leaseHash := testutil.LeaseRun(t, s, runner)
+		var errMsg *string
+		if message != "" {
+			errMsg = &message
+		}
+		attemptStatus := status
+		if status == "expired" {
+			attemptStatus = "failed"
+		}
+		if err := s.CompleteAttempt(context.Background(), attempt.ID, leaseHash, attemptStatus, nil, errMsg, ""); err != nil {
+			t.Fatalf("finish attempt: %v", err)
+		}
+		mustExecHTTP(t, dbConn, `UPDATE run_attempts SET status = ?, finished_at = ? WHERE id = ?`,
+			status, now.Add(-age).UnixMilli(), attempt.ID)
+		return run.ID
+	}
+
+	exit1 := seed(flaky.ID, "failed", "exit status 1", 3*time.Hour)
+	exit137 := seed(flaky.ID, "failed", "exit status 137", 2*time.Hour)
+	exit2 := seed(flaky.ID, "failed", "exit status 2", time.Hour)
+	seed(flaky.ID, "failed", "Traceback (most recent call last):\n  File \"main.py\", line 8, in <module>\nKeyError: 'customer_42'\n", 90*time.Minute)
+	keyError := seed(flaky.ID, "failed", "Traceback (most recent call last):\n  File \"main.py\", line 8, in <module>\nKeyError: 'customer_7'\n", 30*time.Minute)
+	expired := seed(flaky.ID, "expired", "", 20*time.Minute)
+	timeout := seed(flaky.ID, "failed", "timeout", 10*time.Minute)
+	seed(flaky.ID, "completed", "", 5*time.Minute)
+	old := seed(flaky.ID, "failed", "exit status 9", 48*time.Hour)
+	seed(other.ID, "failed", "exit status 1", time.Hour)
+
+	type errorsResponse struct {
+		App           string `json:"app"`
+		TotalFailures int64  `json:"total_failures"`
+		Errors        []struct {
+			Fingerprint   string  `json:"fingerprint"`
+			Pattern       string  `json:"pattern"`
+			Count         int64   `json:"count"`
+			SampleMessage string  `json:"sample_message"`
+			FirstSeenAt   string  `json:"first_seen_at"`
+			LastSeenAt    string  `json:"last_seen_at"`
+			ExampleRunIDs []int64 `json:"example_run_ids"`
+		} `json:"errors"`
+	}
+	get := func(query string) errorsResponse {
+		t.Helper()
+		var body errorsResponse
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/flaky/errors"+query, token, "", nil)
+		decodeStatus(t, resp, http.StatusOK, &body)
+		return body
+	}
+
+	body := get("")
+	if body.App != "flaky" || body.TotalFailures != 7 {
+		t.Fatalf("expected 7 failures of flaky in the default window, got %d for %q", body.TotalFailures, body.App)
+	}
+	var patterns []string
+	for _, g := range body.Errors {
+		patterns = append(patterns, g.Pattern)
+	}
+	// Ties in count go to the most recently seen group.
+	want := []string{"exit status <n>", "KeyError: 'customer_<n>'", "timeout", "lease expired"}
+	if !reflect.DeepEqual(patterns, want) {
+		t.Fatalf("expected groups %v, got %v", want, patterns)
+	}
+
+	exits := body.Errors[0]
+	if exits.Count != 3 || exits.SampleMessage != "exit status 2" || !reflect.DeepEqual(exits.ExampleRunIDs, []int64{exit2, exit137, exit1}) {
+		t.Fatalf("unexpected exit status group: %+v", exits)
+	}
+	if exits.FirstSeenAt != now.Add(-3*time.Hour).UTC().Format("2006-01-02T15:04:05.000Z") || exits.LastSeenAt != now.Add(-time.Hour).UTC().Format("2006-01-02T15:04:05.000Z") {
+		t.Fatalf("unexpected first/last seen: %s, %s", exits.FirstSeenAt, exits.LastSeenAt)
+	}
+	if exits.Fingerprint == "" || exits.Fingerprint == body.Errors[1].Fingerprint {
+		t.Fatalf("expected distinct fingerprints, got %q and %q", exits.Fingerprint, body.Errors[1].Fingerprint)
+	}
+	if g := body.Errors[1]; g.Count != 2 || g.ExampleRunIDs[0] != keyError {
+		t.Fatalf("unexpected traceback group: %+v", g)
+	}
+	if body.Errors[2].ExampleRunIDs[0] != timeout || body.Errors[3].ExampleRunIDs[0] != expired {
+		t.Fatalf("unexpected single-failure groups: %+v", body.Errors[2:])
+	}
+
+	// A wider window takes the older failure; limit caps the groups.
+	body = get("?window=72h&limit=1")
+	if body.TotalFailures != 8 || len(body.Errors) != 1 || body.Errors[0].Count != 4 || body.Errors[0].ExampleRunIDs[3] != old {
+		t.Fatalf("expected the 72h window to add the old exit, got %+v", body)
+	}
+
+	for _, query := range []string{"?window=yesterday", "?window=-1h", "?limit=0", "?limit=51"} {
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/flaky/errors"+query, token, "", nil)
+		assertErrorCode(t, query, resp, http.StatusBadRequest, "invalid_request")
+	}
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/missing/errors", token, "", nil)
+	assertErrorCode(t, "unknown app", resp, http.StatusNotFound, "not_found")
+}
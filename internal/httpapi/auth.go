@@ -3,10 +3,13 @@ package httpapi
 import (
 	"crypto/subtle"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"minitower/internal/apierror"
 	"minitower/internal/auth"
 	"minitower/internal/config"
 	"minitower/internal/httpapi/handlers"
@@ -28,7 +31,20 @@ func (a *Auth) RequireBootstrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, ok := parseBearerToken(r)
 		if !ok || !secureEqual(token, a.cfg.BootstrapToken) {
-			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid or missing token")
+			writeAPIError(w, apierror.Unauthorized, "invalid or missing token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireMetrics guards /metrics on the API listener with the metrics token.
+func (a *Auth) RequireMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := parseBearerToken(r)
+		if !ok || !secureEqual(token, a.cfg.MetricsToken) {
+			writeAPIError(w, apierror.Unauthorized, "invalid or missing token")
 			return
 		}
 
@@ -40,7 +56,7 @@ func (a *Auth) RequireTeam(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, ok := parseBearerToken(r)
 		if !ok {
-			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid or missing token")
+			writeAPIError(w, apierror.Unauthorized, "invalid or missing token")
 			return
 		}
 
@@ -50,21 +66,24 @@ func (a *Auth) RequireTeam(next http.Handler) http.Handler {
 		var teamID int64
 		var teamSlug string
 		var role string
+		var scopesJSON, appSlug sql.NullString
+		var appID sql.NullInt64
 		err := a.db.QueryRowContext(
 			r.Context(),
-			`SELECT tt.id, tt.team_id, t.slug, tt.role
+			`SELECT tt.id, tt.team_id, t.slug, tt.role, tt.scopes_json, tt.app_id, a.slug
 		     FROM team_tokens tt
 		     JOIN teams t ON tt.team_id = t.id
+		     LEFT JOIN apps a ON tt.app_id = a.id
 		     WHERE tt.token_hash = ? AND tt.revoked_at IS NULL
 		     LIMIT 1`,
 			tokenHash,
-		).Scan(&tokenID, &teamID, &teamSlug, &role)
+		).Scan(&tokenID, &teamID, &teamSlug, &role, &scopesJSON, &appID, &appSlug)
 		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid or missing token")
+			writeAPIError(w, apierror.Unauthorized, "invalid or missing token")
 			return
 		}
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			writeAPIError(w, apierror.Internal, "internal error")
 			return
 		}
 
@@ -72,15 +91,60 @@ func (a *Auth) RequireTeam(next http.Handler) http.Handler {
 		ctx = handlers.WithTeamTokenID(ctx, tokenID)
 		ctx = handlers.WithTeamSlug(ctx, teamSlug)
 		ctx = handlers.WithTokenRole(ctx, role)
+		if scopesJSON.Valid {
+			scope := handlers.TokenScope{AppSlug: appSlug.String}
+			if err := json.Unmarshal([]byte(scopesJSON.String), &scope.Scopes); err != nil {
+				writeAPIError(w, apierror.Internal, "internal error")
+				return
+			}
+			if appID.Valid {
+				scope.AppID = &appID.Int64
+			}
+			if !acceptsScopedToken(r) {
+				writeAPIError(w, apierror.InsufficientScope, "token is limited to scopes %s", strings.Join(scope.Scopes, ", "))
+				return
+			}
+			ctx = handlers.WithTokenScope(ctx, scope)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// acceptsScopedToken reports whether r is for an endpoint that checks a
+// scoped token's scopes itself: /me, an app's runs, and reads and cancels
+// of a single run. Scoped tokens are refused everywhere else.
+func acceptsScopedToken(r *http.Request) bool {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path == "/api/v1/me" {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(path, "/api/v1/apps/"); ok {
+		// /apps/{app}/runs and /apps/{app}/runs/batch
+		segs := strings.Split(rest, "/")
+		return len(segs) >= 2 && segs[1] == "runs" && (len(segs) == 2 || len(segs) == 3 && segs[2] == "batch")
+	}
+	if !strings.HasPrefix(path, "/api/v1/runs/") {
+		return false
+	}
+	segs := runPathSegments(path)
+	if _, err := strconv.ParseInt(segs[0], 10, 64); err != nil {
+		return false
+	}
+	if len(segs) == 1 {
+		return true
+	}
+	switch segs[1] {
+	case "logs", "events", "attempts", "outputs", "cancel":
+		return true
+	}
+	return false
+}
+
 func (a *Auth) RequireAdmin(next http.Handler) http.Handler {
 	return a.RequireTeam(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		role, ok := handlers.TokenRoleFromContext(r.Context())
 		if !ok || role != "admin" {
-			writeError(w, http.StatusForbidden, "forbidden", "admin role required")
+			writeAPIError(w, apierror.Forbidden, "admin role required")
 			return
 		}
 
@@ -91,8 +155,8 @@ func (a *Auth) RequireAdmin(next http.Handler) http.Handler {
 func (a *Auth) RequireRunnerRegistration(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, ok := parseBearerToken(r)
-		if !ok || !secureEqual(token, a.cfg.RunnerRegistrationToken) {
-			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid or missing token")
+		if !ok || !secureEqualAny(token, a.cfg.RunnerRegistrationTokens) {
+			writeAPIError(w, apierror.Unauthorized, "invalid or missing token")
 			return
 		}
 
@@ -104,7 +168,7 @@ func (a *Auth) RequireRunner(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, ok := parseBearerToken(r)
 		if !ok {
-			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid or missing token")
+			writeAPIError(w, apierror.Unauthorized, "invalid or missing token")
 			return
 		}
 
@@ -118,11 +182,11 @@ func (a *Auth) RequireRunner(next http.Handler) http.Handler {
 			tokenHash,
 		).Scan(&runnerID, &environment)
 		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusUnauthorized, "unauthorized", "invalid or missing token")
+			writeAPIError(w, apierror.Unauthorized, "invalid or missing token")
 			return
 		}
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			writeAPIError(w, apierror.Internal, "internal error")
 			return
 		}
 
@@ -157,6 +221,18 @@ func parseBearerToken(r *http.Request) (string, bool) {
 
 // secureEqual compares two strings in constant time by hashing both to a
 // fixed-length digest first, avoiding length-based timing side-channels.
+// secureEqualAny reports whether token matches any of accepted. Every
+// candidate is compared so the time taken does not reveal which one matched.
+func secureEqualAny(token string, accepted []string) bool {
+	match := false
+	for _, candidate := range accepted {
+		if secureEqual(token, candidate) {
+			match = true
+		}
+	}
+	return match
+}
+
 func secureEqual(a, b string) bool {
 	hashA := auth.HashToken(a)
 	hashB := auth.HashToken(b)
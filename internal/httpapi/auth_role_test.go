@@ -1,6 +1,7 @@
 package httpapi_test
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -19,8 +20,8 @@ func TestRequireTeamSetsRoleInContext(t *testing.T) {
 	_, token := testutil.CreateTeamWithRole(t, s, "role-member-team", "member")
 
 	authn := httpapi.NewAuth(config.Config{
-		BootstrapToken:          "test",
-		RunnerRegistrationToken: "test-runner",
+		BootstrapToken:           "test",
+		RunnerRegistrationTokens: []string{"test-runner"},
 	}, dbConn)
 
 	protected := authn.RequireTeam(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -58,8 +59,8 @@ func TestRequireAdminBlocksMemberAllowsAdmin(t *testing.T) {
 	_, adminToken := testutil.CreateTeamWithRole(t, s, "admin-team", "admin")
 
 	authn := httpapi.NewAuth(config.Config{
-		BootstrapToken:          "test",
-		RunnerRegistrationToken: "test-runner",
+		BootstrapToken:           "test",
+		RunnerRegistrationTokens: []string{"test-runner"},
 	}, dbConn)
 
 	protected := authn.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -82,3 +83,85 @@ func TestRequireAdminBlocksMemberAllowsAdmin(t *testing.T) {
 		t.Fatalf("expected 204 for admin, got %d", adminRec.Result().StatusCode)
 	}
 }
+
+func TestScopedTokenIsLimitedToItsScopesAndApp(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, adminToken := testutil.CreateTeam(t, s, "team-scoped")
+	etl := testutil.CreateApp(t, s, team.ID, "my-etl")
+	testutil.CreateVersion(t, s, etl.ID)
+	other := testutil.CreateApp(t, s, team.ID, "other-app")
+	testutil.CreateVersion(t, s, other.ID)
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/tokens", adminToken, "", map[string]any{"scopes": []string{"runs:nope"}})
+	assertErrorCode(t, "unknown scope", resp, http.StatusBadRequest, "invalid_request")
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/tokens", adminToken, "", map[string]any{
+		"name": "ci", "scopes": []string{"runs:create"}, "app_slug": "my-etl",
+	})
+	var created struct {
+		Token   string   `json:"token"`
+		Role    string   `json:"role"`
+		Scopes  []string `json:"scopes"`
+		AppSlug string   `json:"app_slug"`
+	}
+	decodeStatus(t, resp, http.StatusCreated, &created)
+	if created.Role != "member" || len(created.Scopes) != 1 || created.Scopes[0] != "runs:create" || created.AppSlug != "my-etl" {
+		t.Fatalf("unexpected scoped token: %+v", created)
+	}
+	ci := created.Token
+
+	var me struct {
+		Scopes  []string `json:"scopes"`
+		AppSlug string   `json:"app_slug"`
+	}
+	decodeStatus(t, doRequest(t, handler, http.MethodGet, "/api/v1/me", ci, "", nil), http.StatusOK, &me)
+	if len(me.Scopes) != 1 || me.Scopes[0] != "runs:create" || me.AppSlug != "my-etl" {
+		t.Fatalf("expected /me to report the scopes, got %+v", me)
+	}
+
+	var run struct {
+		RunID int64 `json:"run_id"`
+	}
+	decodeStatus(t, doRequest(t, handler, http.MethodPost, "/api/v1/apps/my-etl/runs", ci, "", map[string]any{}), http.StatusCreated, &run)
+
+	for _, tc := range []struct {
+		label, method, path string
+	}{
+		{"other app", http.MethodPost, "/api/v1/apps/other-app/runs"},
+		{"missing scope", http.MethodGet, "/api/v1/runs/" + itoa(run.RunID)},
+		{"missing cancel scope", http.MethodPost, "/api/v1/runs/" + itoa(run.RunID) + "/cancel"},
+		{"unscoped endpoint", http.MethodGet, "/api/v1/apps"},
+		{"token minting", http.MethodPost, "/api/v1/tokens"},
+		{"admin endpoint", http.MethodGet, "/api/v1/admin/runners"},
+	} {
+		resp := doRequest(t, handler, tc.method, tc.path, ci, "", map[string]any{})
+		assertErrorCode(t, tc.label, resp, http.StatusForbidden, "insufficient_scope")
+	}
+
+	// Tokens created before scopes existed keep full member access.
+	_, memberToken := testutil.CreateTeamWithRole(t, s, "team-unscoped", "member")
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps", memberToken, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected an unscoped member token to list apps, got %d", resp.StatusCode)
+	}
+	var memberMe map[string]any
+	decodeStatus(t, doRequest(t, handler, http.MethodGet, "/api/v1/me", memberToken, "", nil), http.StatusOK, &memberMe)
+	if _, ok := memberMe["scopes"]; ok {
+		t.Fatalf("expected no scopes for an unscoped token, got %v", memberMe)
+	}
+}
+
+func decodeStatus(t *testing.T, resp *http.Response, status int, out any) {
+	t.Helper()
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected %d, got %d: %s", status, resp.StatusCode, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+}
@@ -9,12 +9,13 @@ import (
 )
 
 // DomainCollector implements prometheus.Collector. On each scrape it queries
-// the database for current queue depth and runner counts.
+// the database for current queue depth, runner counts, and runner host stats.
 type DomainCollector struct {
 	db *sql.DB
 
-	runsPending   *prometheus.Desc
-	runnersOnline *prometheus.Desc
+	runsPending      *prometheus.Desc
+	runnersOnline    *prometheus.Desc
+	runnerCPUPercent *prometheus.Desc
 }
 
 // NewDomainCollector creates a collector that queries db on every Prometheus scrape.
@@ -33,12 +34,19 @@ func NewDomainCollector(db *sql.DB) *DomainCollector {
 			[]string{"environment"},
 			nil,
 		),
+		runnerCPUPercent: prometheus.NewDesc(
+			"minitower_runner_cpu_percent",
+			"Last reported host CPU utilisation of online runners.",
+			[]string{"runner"},
+			nil,
+		),
 	}
 }
 
 func (c *DomainCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.runsPending
 	ch <- c.runnersOnline
+	ch <- c.runnerCPUPercent
 }
 
 func (c *DomainCollector) Collect(ch chan<- prometheus.Metric) {
@@ -47,6 +55,7 @@ func (c *DomainCollector) Collect(ch chan<- prometheus.Metric) {
 
 	c.collectRunsPending(ctx, ch)
 	c.collectRunnersOnline(ctx, ch)
+	c.collectRunnerCPUPercent(ctx, ch)
 }
 
 func (c *DomainCollector) collectRunsPending(ctx context.Context, ch chan<- prometheus.Metric) {
@@ -93,3 +102,24 @@ func (c *DomainCollector) collectRunnersOnline(ctx context.Context, ch chan<- pr
 		ch <- prometheus.MustNewConstMetric(c.runnersOnline, prometheus.GaugeValue, count, env)
 	}
 }
+
+func (c *DomainCollector) collectRunnerCPUPercent(ctx context.Context, ch chan<- prometheus.Metric) {
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT name, json_extract(stats_json, '$.cpu_percent')
+		 FROM runners
+		 WHERE status = 'online'
+		   AND json_extract(stats_json, '$.cpu_percent') IS NOT NULL`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var cpu float64
+		if err := rows.Scan(&name, &cpu); err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.runnerCPUPercent, prometheus.GaugeValue, cpu, name)
+	}
+}
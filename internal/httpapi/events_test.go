@@ -0,0 +1,76 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"minitower/internal/testutil"
+)
+
+func TestRunEventsEndpoint(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-events")
+	_, otherToken := testutil.CreateTeam(t, s, "team-events-other")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "events-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-events", "default")
+	run, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/start", runnerToken, leaseToken, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("start: expected 200, got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/events", token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var body struct {
+		RunID  int64 `json:"run_id"`
+		Events []struct {
+			Kind    string         `json:"kind"`
+			At      string         `json:"at"`
+			Details map[string]any `json:"details"`
+		} `json:"events"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode events: %v", err)
+	}
+	var kinds []string
+	for _, e := range body.Events {
+		kinds = append(kinds, e.Kind)
+		if _, err := time.Parse(time.RFC3339Nano, e.At); err != nil || !strings.HasSuffix(e.At, "Z") {
+			t.Errorf("%s: expected a UTC RFC 3339 time, got %q", e.Kind, e.At)
+		}
+	}
+	if body.RunID != run.ID || strings.Join(kinds, ",") != "queued,leased,started" {
+		t.Fatalf("unexpected timeline for run %d: %v", body.RunID, kinds)
+	}
+	if body.Events[1].Details["runner"] != "runner-events" {
+		t.Fatalf("expected the leasing runner in details, got %v", body.Events[1].Details)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/events", otherToken, "", nil)
+	assertErrorCode(t, "other team", resp, http.StatusNotFound, "not_found")
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/abc/events", token, "", nil)
+	assertErrorCode(t, "bad run ID", resp, http.StatusBadRequest, "invalid_request")
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/events", runnerToken, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a runner token to be rejected, got %d", resp.StatusCode)
+	}
+}
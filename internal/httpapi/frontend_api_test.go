@@ -8,12 +8,15 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"minitower/internal/config"
 	"minitower/internal/httpapi"
+	"minitower/internal/logretention"
 	"minitower/internal/objects"
 	"minitower/internal/store"
 	"minitower/internal/testutil"
@@ -401,9 +404,10 @@ func TestGlobalRunsSummaryDetailAndIncrementalLogs(t *testing.T) {
 	}
 	var runsPayload struct {
 		Runs []struct {
-			RunID   int64  `json:"run_id"`
-			AppSlug string `json:"app_slug"`
-			Status  string `json:"status"`
+			RunID      int64  `json:"run_id"`
+			AppSlug    string `json:"app_slug"`
+			Status     string `json:"status"`
+			Entrypoint string `json:"entrypoint"`
 		} `json:"runs"`
 	}
 	if err := json.NewDecoder(runsResp.Body).Decode(&runsPayload); err != nil {
@@ -418,6 +422,9 @@ func TestGlobalRunsSummaryDetailAndIncrementalLogs(t *testing.T) {
 	if runsPayload.Runs[0].AppSlug != "app-b" || runsPayload.Runs[2].AppSlug != "app-a" {
 		t.Fatalf("expected app slugs in global runs, got %+v", runsPayload.Runs)
 	}
+	if runsPayload.Runs[0].Entrypoint != "main.py" {
+		t.Fatalf("expected the version entrypoint in global runs, got %+v", runsPayload.Runs[0])
+	}
 
 	statusResp := doRequest(t, handler, http.MethodGet, "/api/v1/runs?status=queued", token, "", nil)
 	defer statusResp.Body.Close()
@@ -528,6 +535,210 @@ func TestGlobalRunsSummaryDetailAndIncrementalLogs(t *testing.T) {
 	}
 }
 
+func TestListRunsInputContains(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-runs-input")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "input-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	acme, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, map[string]any{"customer": "acme", "day": 3}, 0, 0, false)
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if _, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, map[string]any{"customer": "globex", "day": 3}, 0, 0, false); err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+
+	filter := url.QueryEscape(`{"customer":"acme","day":3}`)
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/runs?input_contains="+filter, token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var payload struct {
+		Runs []struct {
+			RunID int64 `json:"run_id"`
+		} `json:"runs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode runs: %v", err)
+	}
+	if len(payload.Runs) != 1 || payload.Runs[0].RunID != acme.ID {
+		t.Fatalf("expected only run %d, got %+v", acme.ID, payload.Runs)
+	}
+
+	for _, bad := range []string{
+		`["acme"]`,
+		`{"customer":`,
+		`{"a":1,"b":2,"c":3,"d":4}`,
+		`{"customer":{"name":"acme"}}`,
+		`{"tags":["a"]}`,
+		`{"":1}`,
+	} {
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/runs?input_contains="+url.QueryEscape(bad), token, "", nil)
+		assertErrorCode(t, bad, resp, http.StatusBadRequest, "invalid_request")
+	}
+}
+
+func TestListAppsIncludeStats(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-apps-stats")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-stats")
+	version := testutil.CreateVersion(t, s, app.ID)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	type listResp struct {
+		Apps []struct {
+			Slug  string          `json:"slug"`
+			Stats json.RawMessage `json:"stats"`
+		} `json:"apps"`
+	}
+	decode := func(resp *http.Response) listResp {
+		t.Helper()
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("list apps status: %d", resp.StatusCode)
+		}
+		var out listResp
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return out
+	}
+
+	plain := decode(doRequest(t, handler, http.MethodGet, "/api/v1/apps", token, "", nil))
+	if len(plain.Apps) != 1 || plain.Apps[0].Stats != nil {
+		t.Fatalf("default listing should omit stats: %+v", plain.Apps)
+	}
+
+	withStats := decode(doRequest(t, handler, http.MethodGet, "/api/v1/apps?include=stats", token, "", nil))
+	if len(withStats.Apps) != 1 {
+		t.Fatalf("expected 1 app, got %d", len(withStats.Apps))
+	}
+	var stats struct {
+		LatestVersionNo *int64   `json:"latest_version_no"`
+		TotalRuns       int64    `json:"total_runs"`
+		LastRunStatus   *string  `json:"last_run_status"`
+		LastRunAt       *string  `json:"last_run_at"`
+		SuccessRate     *float64 `json:"success_rate"`
+	}
+	if err := json.Unmarshal(withStats.Apps[0].Stats, &stats); err != nil {
+		t.Fatalf("decode stats %s: %v", withStats.Apps[0].Stats, err)
+	}
+	if stats.TotalRuns != 1 || stats.LatestVersionNo == nil || *stats.LatestVersionNo != 1 {
+		t.Fatalf("unexpected stats: %s", withStats.Apps[0].Stats)
+	}
+	if stats.LastRunStatus == nil || *stats.LastRunStatus != "queued" || stats.LastRunAt == nil {
+		t.Fatalf("unexpected last run: %s", withStats.Apps[0].Stats)
+	}
+	if stats.SuccessRate != nil {
+		t.Fatalf("success rate should be null without finished runs: %s", withStats.Apps[0].Stats)
+	}
+
+	bad := doRequest(t, handler, http.MethodGet, "/api/v1/apps?include=bogus", token, "", nil)
+	defer bad.Body.Close()
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown include status: %d", bad.StatusCode)
+	}
+}
+
+func TestEnvironmentsCreateListDelete(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-envs")
+
+	for _, name := range []string{"", "QA", "ab", "has space", "-lead", "default"} {
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/environments", token, "", map[string]string{"name": name})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("name %q: expected 400, got %d", name, resp.StatusCode)
+		}
+	}
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/environments", token, "", map[string]string{"name": "staging"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/environments", token, "", map[string]string{"name": "staging"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for duplicate, got %d", resp.StatusCode)
+	}
+
+	testutil.CreateRunner(t, s, "runner-staging", "staging")
+	env, err := s.GetEnvironmentByName(context.Background(), team.ID, "staging")
+	if err != nil || env == nil {
+		t.Fatalf("get staging env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "env-api-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/environments", token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var payload struct {
+		Environments []struct {
+			Name          string `json:"name"`
+			IsDefault     bool   `json:"is_default"`
+			QueuedRuns    int64  `json:"queued_runs"`
+			RunningRuns   int64  `json:"running_runs"`
+			OnlineRunners int64  `json:"online_runners"`
+		} `json:"environments"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode environments: %v", err)
+	}
+	if len(payload.Environments) != 2 || !payload.Environments[0].IsDefault {
+		t.Fatalf("expected default and staging, got %+v", payload.Environments)
+	}
+	staging := payload.Environments[1]
+	if staging.Name != "staging" || staging.QueuedRuns != 1 || staging.RunningRuns != 0 || staging.OnlineRunners != 1 {
+		t.Fatalf("unexpected staging counts: %+v", staging)
+	}
+
+	cases := []struct {
+		name   string
+		status int
+	}{
+		{"staging", http.StatusConflict},
+		{"default", http.StatusConflict},
+		{"missing", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		resp := doRequest(t, handler, http.MethodDelete, "/api/v1/environments/"+tc.name, token, "", nil)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("delete %s: expected %d, got %d", tc.name, tc.status, resp.StatusCode)
+		}
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/environments", token, "", map[string]string{"name": "scratch"})
+	resp.Body.Close()
+	resp = doRequest(t, handler, http.MethodDelete, "/api/v1/environments/scratch", token, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+}
+
 func TestAdminRunnersEndpointIsAdminOnly(t *testing.T) {
 	handler, s, _, cleanup := newTestServer(t)
 	defer cleanup()
@@ -560,6 +771,164 @@ func TestAdminRunnersEndpointIsAdminOnly(t *testing.T) {
 	}
 }
 
+func TestAdminRunnersListFilters(t *testing.T) {
+	handler, s, db, cleanup := newTestServer(t)
+	defer cleanup()
+
+	_, adminToken := testutil.CreateTeam(t, s, "team-runner-filters")
+	now := time.Now()
+	for _, r := range []struct {
+		name, env, status string
+		ago               time.Duration
+	}{
+		{"pool-1", "default", "online", time.Minute},
+		{"pool-2", "gpu", "offline", 2 * time.Hour},
+		{"pool-3", "gpu", "online", 5 * time.Second},
+		{"spare-1", "default", "offline", 3 * time.Hour},
+	} {
+		runner, _ := testutil.CreateRunner(t, s, r.name, r.env)
+		mustExecHTTP(t, db, `UPDATE runners SET status = ?, last_seen_at = ? WHERE id = ?`, r.status, now.Add(-r.ago).UnixMilli(), runner.ID)
+	}
+
+	list := func(query string) ([]string, int64) {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/admin/runners"+query, adminToken, "", nil)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", query, resp.StatusCode)
+		}
+		var payload struct {
+			Runners []struct {
+				Name string `json:"name"`
+			} `json:"runners"`
+			Total int64 `json:"total"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("%s: decode: %v", query, err)
+		}
+		names := make([]string, 0, len(payload.Runners))
+		for _, r := range payload.Runners {
+			names = append(names, r.Name)
+		}
+		return names, payload.Total
+	}
+
+	cases := []struct {
+		query string
+		want  string
+		total int64
+	}{
+		{"", "pool-3,pool-1,pool-2,spare-1", 4},
+		{"?status=offline", "pool-2,spare-1", 2},
+		{"?environment=gpu", "pool-3,pool-2", 2},
+		{"?name_prefix=pool-", "pool-3,pool-1,pool-2", 3},
+		{"?stale_for=90m", "pool-2,spare-1", 2},
+		{"?status=offline&environment=default&stale_for=1h", "spare-1", 1},
+		{"?name_prefix=pool-&limit=1&offset=1", "pool-1", 3},
+	}
+	for _, tc := range cases {
+		names, total := list(tc.query)
+		if strings.Join(names, ",") != tc.want || total != tc.total {
+			t.Fatalf("%s: expected %s (total %d), got %v (total %d)", tc.query, tc.want, tc.total, names, total)
+		}
+	}
+
+	for _, query := range []string{"?status=draining", "?stale_for=soon", "?stale_for=-1h", "?limit=0", "?limit=501", "?offset=-1"} {
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/admin/runners"+query, adminToken, "", nil)
+		assertErrorCode(t, query, resp, http.StatusBadRequest, "invalid_request")
+	}
+}
+
+func TestAdminCreateRunnerIssuesTokenOnce(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	_, adminToken := testutil.CreateTeam(t, s, "team-runner-create")
+	_, memberToken := testutil.CreateTeamWithRole(t, s, "team-runner-create-member", "member")
+
+	body := map[string]any{"name": "prov-1", "environment": "gpu"}
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/admin/runners", memberToken, "", body)
+	assertErrorCode(t, "member create", resp, http.StatusForbidden, "forbidden")
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/admin/runners", adminToken, "", body)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var created struct {
+		RunnerID    int64  `json:"runner_id"`
+		Name        string `json:"name"`
+		Environment string `json:"environment"`
+		Token       string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode create runner: %v", err)
+	}
+	resp.Body.Close()
+	if created.Name != "prov-1" || created.Environment != "gpu" || !strings.HasPrefix(created.Token, "mtr_") {
+		t.Fatalf("unexpected created runner %+v", created)
+	}
+
+	// The token authenticates the runner without any registration.
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", created.Token, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the issued token to lease (204), got %d", resp.StatusCode)
+	}
+
+	// It is never shown again.
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/admin/runners", adminToken, "", nil)
+	listed, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(listed), `"prov-1"`) || strings.Contains(string(listed), created.Token) {
+		t.Fatalf("expected the runner listed without its token, got %s", listed)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/admin/runners", adminToken, "", body)
+	assertErrorCode(t, "duplicate name", resp, http.StatusConflict, "runner_exists")
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/admin/runners", adminToken, "", map[string]any{"name": " "})
+	assertErrorCode(t, "empty name", resp, http.StatusBadRequest, "invalid_request")
+}
+
+func TestAdminRotateRunnerToken(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	_, adminToken := testutil.CreateTeam(t, s, "team-runner-rotate")
+	_, memberToken := testutil.CreateTeamWithRole(t, s, "team-runner-rotate-member", "member")
+	runner, oldToken := testutil.CreateRunner(t, s, "runner-rotate", "default")
+	path := "/api/v1/admin/runners/" + itoa(runner.ID) + "/rotate-token"
+
+	resp := doRequest(t, handler, http.MethodPost, path, memberToken, "", nil)
+	assertErrorCode(t, "member rotate", resp, http.StatusForbidden, "forbidden")
+
+	resp = doRequest(t, handler, http.MethodPost, path, adminToken, "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var rotated struct {
+		RunnerID int64  `json:"runner_id"`
+		Token    string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rotated); err != nil {
+		t.Fatalf("decode rotate: %v", err)
+	}
+	resp.Body.Close()
+	if rotated.RunnerID != runner.ID || rotated.Token == "" || rotated.Token == oldToken {
+		t.Fatalf("unexpected rotate response %+v", rotated)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", oldToken, "", nil)
+	assertErrorCode(t, "old token", resp, http.StatusUnauthorized, "unauthorized")
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", rotated.Token, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the new token to lease (204), got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/admin/runners/999999/rotate-token", adminToken, "", nil)
+	assertErrorCode(t, "missing runner", resp, http.StatusNotFound, "not_found")
+}
+
 func TestCORSAllowlistPreflightAndOriginReflection(t *testing.T) {
 	handler, _, _, cleanup := newTestServerWithCORS(t, []string{"http://localhost:5173"})
 	defer cleanup()
@@ -615,17 +984,17 @@ func newTestServerWithOptions(t *testing.T, corsOrigins []string, signupEnabled
 	}
 
 	cfg := config.Config{
-		ListenAddr:              ":0",
-		DBPath:                  "",
-		ObjectsDir:              "",
-		BootstrapToken:          bootstrapToken,
-		PublicSignupEnabled:     signupEnabled,
-		RunnerRegistrationToken: "test-runner-reg",
-		CORSOrigins:             corsOrigins,
-		LeaseTTL:                60 * time.Second,
-		ExpiryCheckInterval:     10 * time.Second,
-		MaxRequestBodySize:      10 * 1024 * 1024,
-		MaxArtifactSize:         100 * 1024 * 1024,
+		ListenAddr:               ":0",
+		DBPath:                   "",
+		ObjectsDir:               "",
+		BootstrapToken:           bootstrapToken,
+		PublicSignupEnabled:      signupEnabled,
+		RunnerRegistrationTokens: []string{"test-runner-reg"},
+		CORSOrigins:              corsOrigins,
+		LeaseTTL:                 60 * time.Second,
+		ExpiryCheckInterval:      10 * time.Second,
+		MaxRequestBodySize:       10 * 1024 * 1024,
+		MaxArtifactSize:          100 * 1024 * 1024,
 	}
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
@@ -636,3 +1005,59 @@ func newTestServerWithOptions(t *testing.T, corsOrigins []string, signupEnabled
 		cleanup.Close(t)
 	}
 }
+
+func TestRunLogsServedFromArchiveAfterPurge(t *testing.T) {
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	handler, s, dbConn, cleanup := newTestServerWithObjects(t, objStore)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-archived-logs")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "archived-logs-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, _ := testutil.CreateRunner(t, s, "runner-archived-logs", "default")
+	_, attempt, _, _ := testutil.LeaseRun(t, s, runner)
+
+	finished := time.Now().Add(-10 * 24 * time.Hour)
+	if err := s.AppendLogs(ctx, attempt.ID, []store.LogEntry{
+		{Seq: 1, Stream: "stdout", Line: "first", LoggedAt: finished},
+		{Seq: 2, Stream: "stderr", Line: "second", LoggedAt: finished},
+	}); err != nil {
+		t.Fatalf("append logs: %v", err)
+	}
+	mustExecHTTP(t, dbConn, `UPDATE run_attempts SET status = 'completed', finished_at = ? WHERE id = ?`, finished.UnixMilli(), attempt.ID)
+	mustExecHTTP(t, dbConn, `UPDATE runs SET status = 'completed' WHERE id = ?`, run.ID)
+
+	purger := logretention.New(s, objStore, logretention.Options{RetentionDays: 7, Archive: true})
+	if res, err := purger.Run(ctx, time.Now()); err != nil || res.Purged != 2 {
+		t.Fatalf("expected 2 lines purged, got %+v (%v)", res, err)
+	}
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/logs?after_seq=1", token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var payload struct {
+		Logs []struct {
+			Seq    int64  `json:"seq"`
+			Stream string `json:"stream"`
+			Line   string `json:"line"`
+		} `json:"logs"`
+		Archived bool `json:"archived"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode logs: %v", err)
+	}
+	if !payload.Archived || len(payload.Logs) != 1 || payload.Logs[0].Seq != 2 || payload.Logs[0].Line != "second" {
+		t.Fatalf("unexpected archived logs payload: %+v", payload)
+	}
+}
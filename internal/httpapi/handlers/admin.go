@@ -1,50 +1,565 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"minitower/internal/apierror"
+	"minitower/internal/auth"
+	"minitower/internal/backup"
+	"minitower/internal/integrity"
+	"minitower/internal/store"
 )
 
 type adminRunnerResponse struct {
-	RunnerID    int64   `json:"runner_id"`
-	Name        string  `json:"name"`
-	Environment string  `json:"environment"`
-	Status      string  `json:"status"`
-	LastSeenAt  *string `json:"last_seen_at,omitempty"`
+	RunnerID       int64              `json:"runner_id"`
+	Name           string             `json:"name"`
+	Environment    string             `json:"environment"`
+	Status         string             `json:"status"`
+	PythonVersions []string           `json:"python_versions,omitempty"`
+	LastSeenAt     *string            `json:"last_seen_at,omitempty"`
+	Stats          *store.RunnerStats `json:"stats,omitempty"`
+	StatsAt        *string            `json:"stats_at,omitempty"`
 }
 
 type listAdminRunnersResponse struct {
 	Runners []adminRunnerResponse `json:"runners"`
+	Total   int64                 `json:"total"`
 }
 
-// ListRunners lists all registered runners (admin-only route).
+const (
+	defaultRunnerListLimit = 100
+	maxRunnerListLimit     = 500
+)
+
+// ListRunners lists registered runners, most recently seen first, filtered by
+// the status, environment, name_prefix and stale_for query parameters and
+// paged by limit and offset (admin-only route).
 func (h *Handlers) ListRunners(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	runners, err := h.store.ListRunners(r.Context())
+	q := r.URL.Query()
+	filter := store.RunnerFilter{
+		Status:      strings.TrimSpace(q.Get("status")),
+		Environment: strings.TrimSpace(q.Get("environment")),
+		NamePrefix:  strings.TrimSpace(q.Get("name_prefix")),
+		Limit:       defaultRunnerListLimit,
+	}
+	if filter.Status != "" && filter.Status != "online" && filter.Status != "offline" {
+		writeAPIError(w, apierror.InvalidRequest, "status must be online or offline")
+		return
+	}
+	if v := q.Get("stale_for"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeAPIError(w, apierror.InvalidRequest, "stale_for must be a positive duration such as 30m")
+			return
+		}
+		filter.StaleBefore = time.Now().Add(-d)
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRunnerListLimit {
+			writeAPIError(w, apierror.InvalidRequest, "limit must be between 1 and %d", maxRunnerListLimit)
+			return
+		}
+		filter.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeAPIError(w, apierror.InvalidRequest, "offset must be >= 0")
+			return
+		}
+		filter.Offset = n
+	}
+
+	runners, total, err := h.store.ListRunnersFiltered(r.Context(), filter)
 	if err != nil {
-		h.logger.Error("list runners", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "list runners", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
-	resp := listAdminRunnersResponse{Runners: make([]adminRunnerResponse, 0, len(runners))}
+	resp := listAdminRunnersResponse{Runners: make([]adminRunnerResponse, 0, len(runners)), Total: total}
 	for _, runner := range runners {
 		rr := adminRunnerResponse{
-			RunnerID:    runner.ID,
-			Name:        runner.Name,
-			Environment: runner.Environment,
-			Status:      runner.Status,
+			RunnerID:       runner.ID,
+			Name:           runner.Name,
+			Environment:    runner.Environment,
+			Status:         runner.Status,
+			PythonVersions: runner.PythonVersions(),
+			Stats:          runner.Stats,
 		}
 		if runner.LastSeenAt != nil {
-			s := runner.LastSeenAt.Format(time.RFC3339)
+			s := formatTime(*runner.LastSeenAt)
 			rr.LastSeenAt = &s
 		}
+		if runner.StatsAt != nil {
+			s := formatTime(*runner.StatsAt)
+			rr.StatsAt = &s
+		}
 		resp.Runners = append(resp.Runners, rr)
 	}
 
 	writeJSON(w, http.StatusOK, resp)
 }
+
+type createRunnerRequest struct {
+	Name        string `json:"name"`
+	Environment string `json:"environment"`
+}
+
+// runnerTokenResponse carries a runner token. The token is only ever shown
+// in this response.
+type runnerTokenResponse struct {
+	RunnerID    int64  `json:"runner_id"`
+	Name        string `json:"name"`
+	Environment string `json:"environment"`
+	Token       string `json:"token"`
+}
+
+// CreateRunner creates a runner and returns its token once, so a host can be
+// given just that token instead of the registration token (admin-only
+// route).
+// POST /api/v1/admin/runners
+func (h *Handlers) CreateRunner(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req createRunnerRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeAPIError(w, apierror.InvalidRequest, "name is required")
+		return
+	}
+	environment := strings.TrimSpace(req.Environment)
+	if environment == "" {
+		environment = "default"
+	}
+
+	token, tokenHash, err := auth.GeneratePrefixedToken(auth.PrefixRunnerToken)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "generate runner token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	runner, err := h.store.CreateRunner(r.Context(), req.Name, environment, tokenHash)
+	if errors.Is(err, store.ErrRunnerNameTaken) {
+		writeAPIError(w, apierror.RunnerExists, "runner already exists")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "create runner", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	h.metrics.RunnerRegistered(environment)
+	h.audit(r, AuditRunnerCreate, "runner", runner.ID, map[string]any{"name": runner.Name, "environment": environment})
+	writeJSON(w, http.StatusCreated, runnerTokenResponse{
+		RunnerID:    runner.ID,
+		Name:        runner.Name,
+		Environment: runner.Environment,
+		Token:       token,
+	})
+}
+
+// RotateRunnerToken issues a runner a new token, like a re-registration:
+// the old token stops working and attempts leased under it are fenced
+// (admin-only route).
+// POST /api/v1/admin/runners/{id}/rotate-token
+func (h *Handlers) RotateRunnerToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/runners/")
+	idStr, sub, _ := strings.Cut(rest, "/")
+	if strings.TrimSuffix(sub, "/") != "rotate-token" {
+		http.NotFound(w, r)
+		return
+	}
+	runnerID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || runnerID < 1 {
+		writeAPIError(w, apierror.InvalidRequest, "invalid runner id")
+		return
+	}
+
+	runner, err := h.store.GetRunnerByID(r.Context(), runnerID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get runner", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if runner == nil {
+		writeAPIError(w, apierror.NotFound, "runner not found")
+		return
+	}
+
+	token, tokenHash, err := auth.GeneratePrefixedToken(auth.PrefixRunnerToken)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "generate runner token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	fenced, err := h.store.RefreshRunnerRegistration(r.Context(), runner.ID, runner.Environment, tokenHash)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "refresh runner registration", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	h.recordFencedAttempts(r.Context(), fenced)
+	h.logger.InfoContext(r.Context(), "runner token rotated", "runner", runner.Name, "fenced_attempts", len(fenced))
+
+	h.audit(r, AuditRunnerRotateToken, "runner", runner.ID, map[string]any{"name": runner.Name, "fenced_attempts": len(fenced)})
+	writeJSON(w, http.StatusOK, runnerTokenResponse{
+		RunnerID:    runner.ID,
+		Name:        runner.Name,
+		Environment: runner.Environment,
+		Token:       token,
+	})
+}
+
+// overviewRunWindow is how far back the overview counts runs by status.
+const overviewRunWindow = 24 * time.Hour
+
+type teamUsageResponse struct {
+	TeamID int64  `json:"team_id"`
+	Slug   string `json:"slug"`
+	Apps   int64  `json:"apps"`
+	Runs   int64  `json:"runs"`
+	// ActiveRuns counts queued and active runs against MaxActiveRuns,
+	// which is null for a team without a quota.
+	ActiveRuns    int64  `json:"active_runs"`
+	MaxActiveRuns *int64 `json:"max_active_runs"`
+}
+
+type runnerCountsResponse struct {
+	Online  int64 `json:"online"`
+	Offline int64 `json:"offline"`
+}
+
+// starvedEnvironmentResponse is an environment with queued runs and no
+// online runner to lease them.
+type starvedEnvironmentResponse struct {
+	Environment    string `json:"environment"`
+	QueuedRuns     int64  `json:"queued_runs"`
+	OldestQueuedAt string `json:"oldest_queued_at"`
+}
+
+type adminOverviewResponse struct {
+	TeamCount           int64                        `json:"team_count"`
+	Teams               []teamUsageResponse          `json:"teams"`
+	ArtifactBytes       int64                        `json:"artifact_bytes"`
+	RunsLast24h         map[string]int64             `json:"runs_last_24h"`
+	Runners             runnerCountsResponse         `json:"runners"`
+	StarvedEnvironments []starvedEnvironmentResponse `json:"starved_environments"`
+}
+
+// GetAdminOverview aggregates usage across all teams (admin-only route).
+func (h *Handlers) GetAdminOverview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	o, err := h.store.GetAdminOverview(r.Context(), time.Now().Add(-overviewRunWindow))
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get admin overview", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	resp := adminOverviewResponse{
+		TeamCount:           o.TeamCount,
+		Teams:               make([]teamUsageResponse, 0, len(o.Teams)),
+		ArtifactBytes:       o.ArtifactBytes,
+		RunsLast24h:         o.RecentRuns,
+		Runners:             runnerCountsResponse{Online: o.OnlineRunners, Offline: o.OfflineRunners},
+		StarvedEnvironments: make([]starvedEnvironmentResponse, 0, len(o.Starved)),
+	}
+	for _, env := range o.Starved {
+		resp.StarvedEnvironments = append(resp.StarvedEnvironments, starvedEnvironmentResponse{
+			Environment:    env.Name,
+			QueuedRuns:     env.QueuedRuns,
+			OldestQueuedAt: formatTime(env.OldestQueuedAt),
+		})
+	}
+	for _, t := range o.Teams {
+		resp.Teams = append(resp.Teams, teamUsageResponse{
+			TeamID:        t.TeamID,
+			Slug:          t.Slug,
+			Apps:          t.Apps,
+			Runs:          t.Runs,
+			ActiveRuns:    t.ActiveRuns,
+			MaxActiveRuns: t.MaxActiveRuns,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// updateTeamSettingsRequest documents the PATCH body; TeamSettings decodes
+// it field by field to tell null from absent.
+type updateTeamSettingsRequest struct {
+	MaxActiveRuns *int64 `json:"max_active_runs"`
+}
+
+type teamSettingsResponse struct {
+	Team string `json:"team"`
+	// MaxActiveRuns caps the team's queued and active runs; null is
+	// unlimited.
+	MaxActiveRuns *int64 `json:"max_active_runs"`
+	ActiveRuns    int64  `json:"active_runs"`
+}
+
+// TeamSettings reads (GET) or updates (PATCH) a team's settings by slug
+// (admin-only route). PATCH accepts {"max_active_runs": n}, where null
+// removes the quota.
+// GET, PATCH /api/v1/admin/teams/{team}/settings
+func (h *Handlers) TeamSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/teams/")
+	slug, sub, _ := strings.Cut(rest, "/")
+	if slug == "" || strings.TrimSuffix(sub, "/") != "settings" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var limit *int64
+	if r.Method == http.MethodPatch {
+		// Decode into raw fields so an explicit null can be told from an
+		// absent key.
+		var req map[string]json.RawMessage
+		if err := decodeJSON(r, &req); err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "malformed JSON body")
+			return
+		}
+		raw, ok := req["max_active_runs"]
+		if !ok || len(req) != 1 {
+			writeAPIError(w, apierror.InvalidRequest, "expected exactly max_active_runs")
+			return
+		}
+		if err := json.Unmarshal(raw, &limit); err != nil || (limit != nil && *limit < 1) {
+			writeAPIError(w, apierror.InvalidRequest, "max_active_runs must be a positive integer or null")
+			return
+		}
+	}
+
+	team, err := h.store.GetTeamBySlug(r.Context(), slug)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get team", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if team == nil {
+		writeAPIError(w, apierror.NotFound, "team not found")
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		if err := h.store.SetTeamMaxActiveRuns(r.Context(), team.ID, limit); err != nil {
+			h.logger.ErrorContext(r.Context(), "set team quota", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
+			return
+		}
+		team.MaxActiveRuns = limit
+		h.audit(r, AuditTeamUpdate, "team", team.Slug, map[string]any{"max_active_runs": limit})
+	}
+
+	active, err := h.store.CountActiveRuns(r.Context(), team.ID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "count active runs", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, teamSettingsResponse{
+		Team:          team.Slug,
+		MaxActiveRuns: team.MaxActiveRuns,
+		ActiveRuns:    active,
+	})
+}
+
+type backupResponse struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+	CreatedAt string `json:"created_at"`
+}
+
+// CreateBackup writes a consistent database snapshot into the backup directory
+// (admin-only route). To restore, stop minitowerd, replace the database file
+// with the snapshot, remove any stale -wal/-shm files, and start the server.
+func (h *Handlers) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	snap, err := h.backups.Snapshot(r.Context(), time.Now())
+	if err != nil {
+		if errors.Is(err, backup.ErrInProgress) {
+			writeAPIError(w, apierror.BackupInProgress, "backup already in progress")
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "create backup", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "database backup written", "path", snap.Path, "size_bytes", snap.SizeBytes)
+	h.audit(r, AuditBackupCreate, "backup", snap.Path, map[string]any{"size_bytes": snap.SizeBytes, "sha256": snap.SHA256})
+	writeJSON(w, http.StatusCreated, backupResponse{
+		Path:      snap.Path,
+		SizeBytes: snap.SizeBytes,
+		SHA256:    snap.SHA256,
+		CreatedAt: formatTime(snap.CreatedAt),
+	})
+}
+
+type verifyObjectsRequest struct {
+	// MarkCorrupt flags versions whose artifact fails verification so runs
+	// cannot be created from them, and unflags those found intact.
+	MarkCorrupt bool `json:"mark_corrupt"`
+}
+
+type objectProblemResponse struct {
+	VersionID      int64  `json:"version_id"`
+	Team           string `json:"team"`
+	App            string `json:"app"`
+	VersionNo      int64  `json:"version_no"`
+	ObjectKey      string `json:"object_key"`
+	Kind           string `json:"kind"`
+	ExpectedSHA256 string `json:"expected_sha256"`
+	ActualSHA256   string `json:"actual_sha256,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+type verifyObjectsJobResponse struct {
+	JobID       string                  `json:"job_id"`
+	Status      string                  `json:"status"`
+	MarkCorrupt bool                    `json:"mark_corrupt"`
+	Checked     int                     `json:"checked"`
+	Total       int                     `json:"total"`
+	BytesRead   int64                   `json:"bytes_read"`
+	Problems    []objectProblemResponse `json:"problems"`
+	Marked      int                     `json:"marked"`
+	Cleared     int                     `json:"cleared"`
+	Error       string                  `json:"error,omitempty"`
+	StartedAt   string                  `json:"started_at"`
+	FinishedAt  *string                 `json:"finished_at,omitempty"`
+}
+
+func newVerifyObjectsJobResponse(job *integrity.Job) verifyObjectsJobResponse {
+	st := job.State()
+	resp := verifyObjectsJobResponse{
+		JobID:       st.ID,
+		Status:      st.Status,
+		MarkCorrupt: st.MarkCorrupt,
+		Checked:     st.Progress.Checked,
+		Total:       st.Progress.Total,
+		BytesRead:   st.Report.Bytes,
+		Problems:    make([]objectProblemResponse, 0, len(st.Report.Problems)),
+		Marked:      st.Report.Marked,
+		Cleared:     st.Report.Cleared,
+		StartedAt:   formatTime(st.StartedAt),
+	}
+	for _, p := range st.Report.Problems {
+		resp.Problems = append(resp.Problems, objectProblemResponse{
+			VersionID:      p.VersionID,
+			Team:           p.Team,
+			App:            p.App,
+			VersionNo:      p.VersionNo,
+			ObjectKey:      p.ObjectKey,
+			Kind:           p.Kind,
+			ExpectedSHA256: p.ExpectedSHA256,
+			ActualSHA256:   p.ActualSHA256,
+			Error:          p.Err,
+		})
+	}
+	if st.Err != nil {
+		resp.Error = st.Err.Error()
+	}
+	if !st.FinishedAt.IsZero() {
+		s := formatTime(st.FinishedAt)
+		resp.FinishedAt = &s
+	}
+	return resp
+}
+
+// StartObjectVerification starts re-hashing every version's artifact in the
+// background and returns the job to poll (admin-only route). Reads are
+// throttled to integrity.DefaultBytesPerSecond.
+// POST /api/v1/admin/verify-objects
+func (h *Handlers) StartObjectVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req verifyObjectsRequest
+	if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
+		return
+	}
+
+	verifier := integrity.New(h.store.Store, h.objects, integrity.Options{
+		BytesPerSecond: integrity.DefaultBytesPerSecond,
+		MarkCorrupt:    req.MarkCorrupt,
+	})
+	job, err := h.verifications.Start(verifier)
+	if errors.Is(err, integrity.ErrInProgress) {
+		writeAPIError(w, apierror.VerificationInProgress, "an object verification is already running")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "start object verification", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "object verification started", "job_id", job.ID, "mark_corrupt", req.MarkCorrupt)
+	h.audit(r, AuditObjectsVerify, "verification", job.ID, map[string]any{"mark_corrupt": req.MarkCorrupt})
+	writeJSON(w, http.StatusAccepted, newVerifyObjectsJobResponse(job))
+}
+
+// GetObjectVerification reports an object verification job's progress and,
+// once it has finished, its problems (admin-only route). Jobs are kept in
+// memory, so they are gone after a restart.
+// GET /api/v1/admin/verify-objects/{job_id}
+func (h *Handlers) GetObjectVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/verify-objects/"), "/")
+	job := h.verifications.Get(id)
+	if job == nil {
+		writeAPIError(w, apierror.NotFound, "verification job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, newVerifyObjectsJobResponse(job))
+}
@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"minitower/internal/apierror"
+	"minitower/internal/store"
 	"minitower/internal/validate"
 )
 
@@ -13,13 +18,45 @@ type createAppRequest struct {
 	Description *string `json:"description"`
 }
 
+// updateAppRequest documents UpdateApp's body, which it decodes field by
+// field to tell an explicit null from an absent key.
+type updateAppRequest struct {
+	DefaultInput map[string]any `json:"default_input,omitempty"`
+	Description  *string        `json:"description,omitempty"`
+}
+
 type appResponse struct {
-	AppID       int64   `json:"app_id"`
-	Slug        string  `json:"slug"`
-	Description *string `json:"description,omitempty"`
-	Disabled    bool    `json:"disabled"`
-	CreatedAt   string  `json:"created_at"`
-	UpdatedAt   string  `json:"updated_at"`
+	AppID        int64             `json:"app_id"`
+	Slug         string            `json:"slug"`
+	Description  *string           `json:"description,omitempty"`
+	Disabled     bool              `json:"disabled"`
+	DefaultInput map[string]any    `json:"default_input,omitempty"`
+	CreatedAt    string            `json:"created_at"`
+	UpdatedAt    string            `json:"updated_at"`
+	Stats        *appStatsResponse `json:"stats,omitempty"`
+}
+
+func newAppResponse(app *store.App) appResponse {
+	return appResponse{
+		AppID:        app.ID,
+		Slug:         app.Slug,
+		Description:  app.Description,
+		Disabled:     app.Disabled,
+		DefaultInput: app.DefaultInput,
+		CreatedAt:    formatTime(app.CreatedAt),
+		UpdatedAt:    formatTime(app.UpdatedAt),
+	}
+}
+
+type appStatsResponse struct {
+	LatestVersionNo *int64   `json:"latest_version_no"`
+	TotalRuns       int64    `json:"total_runs"`
+	LastRunStatus   *string  `json:"last_run_status"`
+	LastRunAt       *string  `json:"last_run_at"`
+	SuccessRate     *float64 `json:"success_rate"`
+	// DurationP95Seconds is the baseline the slow-run check compares
+	// running attempts against.
+	DurationP95Seconds *float64 `json:"duration_p95_seconds"`
 }
 
 type listAppsResponse struct {
@@ -35,48 +72,48 @@ func (h *Handlers) CreateApp(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
 	var req createAppRequest
 	if err := decodeJSON(r, &req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
 		return
 	}
 
 	if err := validate.ValidateSlug(req.Slug); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_slug", err.Error())
+		writeAPIError(w, apierror.InvalidSlug, "%v", err)
 		return
 	}
+	if req.Description != nil {
+		if err := validate.ValidateDescription(*req.Description); err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "%v", err)
+			return
+		}
+	}
 
 	// Check if app slug exists
 	exists, err := h.store.AppExistsBySlug(r.Context(), teamID, req.Slug)
 	if err != nil {
-		h.logger.Error("check app exists", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "check app exists", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if exists {
-		writeError(w, http.StatusConflict, "slug_taken", "app slug already exists")
+		writeAPIError(w, apierror.SlugTaken, "app slug already exists")
 		return
 	}
 
 	app, err := h.store.CreateApp(r.Context(), teamID, req.Slug, req.Description)
 	if err != nil {
-		h.logger.Error("create app", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "create app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, appResponse{
-		AppID:       app.ID,
-		Slug:        app.Slug,
-		Description: app.Description,
-		Disabled:    app.Disabled,
-		CreatedAt:   app.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:   app.UpdatedAt.Format(time.RFC3339),
-	})
+	h.audit(r, AuditAppCreate, "app", app.ID, map[string]any{"slug": app.Slug})
+	writeJSON(w, http.StatusCreated, newAppResponse(app))
 }
 
 // ListApps returns all apps for the team.
@@ -88,27 +125,56 @@ func (h *Handlers) ListApps(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
-	apps, err := h.store.ListApps(r.Context(), teamID)
+	withStats := false
+	if include := strings.TrimSpace(r.URL.Query().Get("include")); include != "" {
+		for _, part := range strings.Split(include, ",") {
+			switch strings.TrimSpace(part) {
+			case "stats":
+				withStats = true
+			default:
+				writeAPIError(w, apierror.InvalidRequest, "invalid include: %s", strings.TrimSpace(part))
+				return
+			}
+		}
+	}
+
+	// The aggregation scans the team's runs, so only pay for it on request.
+	listApps := h.store.ListApps
+	if withStats {
+		listApps = h.store.ListAppsWithStats
+	}
+	apps, err := listApps(r.Context(), teamID)
 	if err != nil {
-		h.logger.Error("list apps", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "list apps", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	resp := listAppsResponse{Apps: make([]appResponse, 0, len(apps))}
 	for _, app := range apps {
-		resp.Apps = append(resp.Apps, appResponse{
-			AppID:       app.ID,
-			Slug:        app.Slug,
-			Description: app.Description,
-			Disabled:    app.Disabled,
-			CreatedAt:   app.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:   app.UpdatedAt.Format(time.RFC3339),
-		})
+		ar := newAppResponse(app)
+		if app.Stats != nil {
+			st := &appStatsResponse{
+				LatestVersionNo: app.Stats.LatestVersionNo,
+				TotalRuns:       app.Stats.TotalRuns,
+				LastRunStatus:   app.Stats.LastRunStatus,
+				SuccessRate:     app.Stats.SuccessRate,
+			}
+			if app.Stats.DurationP95 != nil {
+				p95 := app.Stats.DurationP95.Seconds()
+				st.DurationP95Seconds = &p95
+			}
+			if app.Stats.LastRunAt != nil {
+				at := formatTime(*app.Stats.LastRunAt)
+				st.LastRunAt = &at
+			}
+			ar.Stats = st
+		}
+		resp.Apps = append(resp.Apps, ar)
 	}
 
 	writeJSON(w, http.StatusOK, resp)
@@ -123,36 +189,235 @@ func (h *Handlers) GetApp(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
 	// Extract app slug from path: /api/v1/apps/{app}
 	slug := extractPathParam(r.URL.Path, "/api/v1/apps/")
 	if slug == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "missing app slug")
+		writeAPIError(w, apierror.InvalidRequest, "missing app slug")
+		return
+	}
+
+	app, err := h.store.GetAppBySlug(r.Context(), teamID, slug)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if app == nil {
+		writeAPIError(w, apierror.NotFound, "app not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAppResponse(app))
+}
+
+// UpdateApp applies a partial update to an app. default_input and
+// description can be changed: an object replaces the app's default run
+// input, a string replaces its description, and null clears either.
+func (h *Handlers) UpdateApp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	slug := extractPathParam(r.URL.Path, "/api/v1/apps/")
+	if slug == "" {
+		writeAPIError(w, apierror.InvalidRequest, "missing app slug")
+		return
+	}
+
+	// Decode into raw fields so an explicit null can be told from an
+	// absent key.
+	var req map[string]json.RawMessage
+	if err := decodeJSON(r, &req); err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "malformed JSON body")
+		return
+	}
+	if len(req) == 0 {
+		writeAPIError(w, apierror.InvalidRequest, "no updatable fields; expected default_input or description")
+		return
+	}
+	for key := range req {
+		if key != "default_input" && key != "description" {
+			writeAPIError(w, apierror.InvalidRequest, "field %q cannot be updated", key)
+			return
+		}
+	}
+	var defaultInput map[string]any
+	rawInput, setInput := req["default_input"]
+	if setInput {
+		if err := json.Unmarshal(rawInput, &defaultInput); err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "default_input must be an object or null")
+			return
+		}
+	}
+	var description *string
+	rawDescription, setDescription := req["description"]
+	if setDescription {
+		if err := json.Unmarshal(rawDescription, &description); err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "description must be a string or null")
+			return
+		}
+		if description != nil {
+			if err := validate.ValidateDescription(*description); err != nil {
+				writeAPIError(w, apierror.InvalidRequest, "%v", err)
+				return
+			}
+		}
+	}
+
+	app, err := h.store.GetAppBySlug(r.Context(), teamID, slug)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if app == nil {
+		writeAPIError(w, apierror.NotFound, "app not found")
+		return
+	}
+
+	if setInput {
+		if err := h.store.SetAppDefaultInput(r.Context(), app.ID, defaultInput); err != nil {
+			h.logger.ErrorContext(r.Context(), "set app default input", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
+			return
+		}
+	}
+	if setDescription {
+		if err := h.store.SetAppDescription(r.Context(), app.ID, description); err != nil {
+			h.logger.ErrorContext(r.Context(), "set app description", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
+			return
+		}
+	}
+
+	app, err = h.store.GetAppByID(r.Context(), teamID, app.ID)
+	if err != nil || app == nil {
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	fields := make([]string, 0, len(req))
+	for key := range req {
+		fields = append(fields, key)
+	}
+	sort.Strings(fields)
+	h.audit(r, AuditAppUpdate, "app", app.ID, map[string]any{"slug": app.Slug, "fields": fields})
+	writeJSON(w, http.StatusOK, newAppResponse(app))
+}
+
+const (
+	defaultAppErrorsWindow = 24 * time.Hour
+	defaultAppErrorsLimit  = 10
+	maxAppErrorsLimit      = 50
+)
+
+type appErrorGroupResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	// Pattern is the normalized message: IDs, hex strings and numbers
+	// replaced with <id>, <hex> and <n>.
+	Pattern       string  `json:"pattern"`
+	Count         int64   `json:"count"`
+	SampleMessage string  `json:"sample_message"`
+	FirstSeenAt   string  `json:"first_seen_at"`
+	LastSeenAt    string  `json:"last_seen_at"`
+	ExampleRunIDs []int64 `json:"example_run_ids"`
+}
+
+type appErrorsResponse struct {
+	App           string                  `json:"app"`
+	Since         string                  `json:"since"`
+	TotalFailures int64                   `json:"total_failures"`
+	Errors        []appErrorGroupResponse `json:"errors"`
+}
+
+// GetAppErrors groups the app's failed and expired attempts of the window
+// query parameter (a duration, 24h by default) by error fingerprint and
+// returns the limit most frequent.
+// GET /api/v1/apps/{app}/errors
+func (h *Handlers) GetAppErrors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
+	q := r.URL.Query()
+	window := defaultAppErrorsWindow
+	if v := q.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeAPIError(w, apierror.InvalidRequest, "window must be a positive duration such as 24h")
+			return
+		}
+		window = d
+	}
+	limit := defaultAppErrorsLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAppErrorsLimit {
+			writeAPIError(w, apierror.InvalidRequest, "limit must be between 1 and %d", maxAppErrorsLimit)
+			return
+		}
+		limit = n
+	}
+
+	slug := extractPathParam(r.URL.Path, "/api/v1/apps/")
 	app, err := h.store.GetAppBySlug(r.Context(), teamID, slug)
 	if err != nil {
-		h.logger.Error("get app", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if app == nil {
-		writeError(w, http.StatusNotFound, "not_found", "app not found")
+		writeAPIError(w, apierror.NotFound, "app not found")
+		return
+	}
+	if !requireScope(w, r, ScopeRunsRead, app.ID) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, appResponse{
-		AppID:       app.ID,
-		Slug:        app.Slug,
-		Description: app.Description,
-		Disabled:    app.Disabled,
-		CreatedAt:   app.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:   app.UpdatedAt.Format(time.RFC3339),
-	})
+	since := time.Now().Add(-window)
+	groups, total, err := h.store.ListAppErrorGroups(r.Context(), app.ID, since, limit)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "list app error groups", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	resp := appErrorsResponse{
+		App:           app.Slug,
+		Since:         formatTime(since),
+		TotalFailures: total,
+		Errors:        make([]appErrorGroupResponse, 0, len(groups)),
+	}
+	for _, g := range groups {
+		resp.Errors = append(resp.Errors, appErrorGroupResponse{
+			Fingerprint:   g.Fingerprint,
+			Pattern:       g.Pattern,
+			Count:         g.Count,
+			SampleMessage: g.SampleMessage,
+			FirstSeenAt:   formatTime(g.FirstSeenAt),
+			LastSeenAt:    formatTime(g.LastSeenAt),
+			ExampleRunIDs: g.RunIDs,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 // extractPathParam extracts the first path segment after a prefix.
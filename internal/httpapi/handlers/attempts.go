@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"minitower/internal/store"
+)
+
+// maxEnvSnapshotBytes caps the JSON size of an attempt's environment
+// snapshot. Larger snapshots are dropped with a note rather than stored.
+const maxEnvSnapshotBytes = 64 << 10
+
+// redactedValue replaces secret values in API responses.
+const redactedValue = "***"
+
+// secretEnvNameParts mark an environment variable as secret when its
+// upper-cased name contains one of them.
+var secretEnvNameParts = []string{"SECRET", "TOKEN", "PASSWORD", "PASSWD", "CREDENTIAL", "PRIVATE_KEY", "API_KEY", "ACCESS_KEY"}
+
+// isSecretEnvName reports whether name looks like it holds a secret.
+func isSecretEnvName(name string) bool {
+	upper := strings.ToUpper(name)
+	if upper == "KEY" || strings.HasSuffix(upper, "_KEY") {
+		return true
+	}
+	for _, part := range secretEnvNameParts {
+		if strings.Contains(upper, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactEnv returns env with the values of secret-looking names replaced.
+func redactEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	out := make(map[string]string, len(env))
+	for k, v := range env {
+		if isSecretEnvName(k) {
+			v = redactedValue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// recordEnvSnapshot stores the environment a runner reported for an
+// attempt, or a note if it is over maxEnvSnapshotBytes. Like runner stats it
+// is best-effort: failures are logged and never fail the start.
+func (h *Handlers) recordEnvSnapshot(r *http.Request, attemptID int64, env map[string]string) {
+	if env == nil {
+		return
+	}
+	var note *string
+	if data, err := json.Marshal(env); err == nil && len(data) > maxEnvSnapshotBytes {
+		msg := fmt.Sprintf("environment snapshot of %d bytes exceeds the %d byte limit and was not stored", len(data), maxEnvSnapshotBytes)
+		env, note = nil, &msg
+	}
+	if err := h.store.SetAttemptEnvSnapshot(r.Context(), attemptID, env, note); err != nil {
+		h.logger.ErrorContext(r.Context(), "set attempt env snapshot", "error", err, "attempt_id", attemptID)
+	}
+}
+
+type runAttemptResponse struct {
+	AttemptID    int64   `json:"attempt_id"`
+	AttemptNo    int64   `json:"attempt_no"`
+	RunnerID     int64   `json:"runner_id"`
+	Status       string  `json:"status"`
+	ExitCode     *int    `json:"exit_code,omitempty"`
+	ErrorMessage *string `json:"error_message,omitempty"`
+	StartedAt    *string `json:"started_at,omitempty"`
+	FinishedAt   *string `json:"finished_at,omitempty"`
+	CreatedAt    string  `json:"created_at"`
+	StartedNever bool    `json:"started_never,omitempty"`
+	// EnvSnapshot is the environment minitower set for the attempt's
+	// process, with secret values redacted.
+	EnvSnapshot     map[string]string `json:"env_snapshot,omitempty"`
+	EnvSnapshotNote *string           `json:"env_snapshot_note,omitempty"`
+}
+
+type runAttemptsResponse struct {
+	RunID    int64                `json:"run_id"`
+	Attempts []runAttemptResponse `json:"attempts"`
+}
+
+// ListRunAttempts returns a run's attempts, oldest first.
+// GET /api/v1/runs/{run}/attempts
+func (h *Handlers) ListRunAttempts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	run, ok := h.teamRunFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !requireScope(w, r, ScopeRunsRead, run.AppID) {
+		return
+	}
+
+	attempts, err := h.store.ListRunAttempts(r.Context(), run.ID)
+	if writeStoreError(w, r, h.logger, err, "list run attempts") {
+		return
+	}
+	snapshots, err := h.store.ListAttemptEnvSnapshots(r.Context(), run.ID)
+	if writeStoreError(w, r, h.logger, err, "list attempt env snapshots") {
+		return
+	}
+
+	resp := runAttemptsResponse{RunID: run.ID, Attempts: make([]runAttemptResponse, 0, len(attempts))}
+	for _, a := range attempts {
+		resp.Attempts = append(resp.Attempts, newRunAttemptResponse(a, snapshots[a.ID]))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func newRunAttemptResponse(a *store.RunAttempt, snapshot *store.AttemptEnvSnapshot) runAttemptResponse {
+	resp := runAttemptResponse{
+		AttemptID:    a.ID,
+		AttemptNo:    a.AttemptNo,
+		RunnerID:     a.RunnerID,
+		Status:       a.Status,
+		ExitCode:     a.ExitCode,
+		ErrorMessage: a.ErrorMessage,
+		CreatedAt:    formatTime(a.CreatedAt),
+		StartedNever: a.StartedNever,
+	}
+	if a.StartedAt != nil {
+		s := formatTime(*a.StartedAt)
+		resp.StartedAt = &s
+	}
+	if a.FinishedAt != nil {
+		s := formatTime(*a.FinishedAt)
+		resp.FinishedAt = &s
+	}
+	if snapshot != nil {
+		resp.EnvSnapshot = redactEnv(snapshot.Env)
+		resp.EnvSnapshotNote = snapshot.Note
+	}
+	return resp
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"minitower/internal/apierror"
+	"minitower/internal/store"
+)
+
+// Audit actions, named resource.verb.
+const (
+	AuditAppCreate         = "app.create"
+	AuditAppUpdate         = "app.update"
+	AuditVersionCreate     = "version.create"
+	AuditVersionLabel      = "version.label"
+	AuditVersionPromote    = "version.promote"
+	AuditRunCreate         = "run.create"
+	AuditRunCancel         = "run.cancel"
+	AuditRunPriority       = "run.priority"
+	AuditBatchCreate       = "batch.create"
+	AuditBatchCancel       = "batch.cancel"
+	AuditTokenCreate       = "token.create"
+	AuditEnvironmentCreate = "environment.create"
+	AuditEnvironmentDelete = "environment.delete"
+	AuditBackupCreate      = "backup.create"
+	AuditObjectsVerify     = "objects.verify"
+	AuditTeamUpdate        = "team.update"
+	AuditRunnerCreate      = "runner.create"
+	AuditRunnerRotateToken = "runner.rotate_token"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 500
+)
+
+type auditEntryResponse struct {
+	ID           int64          `json:"id"`
+	Action       string         `json:"action"`
+	ResourceType string         `json:"resource_type"`
+	ResourceID   string         `json:"resource_id"`
+	TokenID      *int64         `json:"token_id,omitempty"`
+	Details      map[string]any `json:"details,omitempty"`
+	CreatedAt    string         `json:"created_at"`
+}
+
+type auditLogResponse struct {
+	Entries []auditEntryResponse `json:"entries"`
+}
+
+// audit records a change in the caller's team audit log. It is best-effort:
+// the change has already happened, so a failed insert is logged and the
+// request still succeeds.
+func (h *Handlers) audit(r *http.Request, action, resourceType string, resourceID any, details map[string]any) {
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		return
+	}
+	entry := &store.AuditEntry{
+		TeamID:       teamID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   fmt.Sprint(resourceID),
+		Details:      details,
+	}
+	if tokenID, ok := teamTokenIDFromContext(r.Context()); ok {
+		entry.TokenID = &tokenID
+	}
+	// The entry is written even if the client has gone away meanwhile.
+	if err := h.store.InsertAuditEntry(context.WithoutCancel(r.Context()), entry); err != nil {
+		h.logger.WarnContext(r.Context(), "audit log insert failed", "action", action, "resource_id", entry.ResourceID, "error", err)
+	}
+}
+
+// GetAuditLog lists the team's audit entries, newest first. Admin tokens see
+// the whole team's history and may narrow it with token_id; other tokens see
+// only their own actions.
+func (h *Handlers) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	q := r.URL.Query()
+	filter := store.AuditFilter{
+		Action: strings.TrimSpace(q.Get("action")),
+		Limit:  defaultAuditLimit,
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			writeAPIError(w, apierror.InvalidRequest, "limit must be between 1 and %d", maxAuditLimit)
+			return
+		}
+		filter.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeAPIError(w, apierror.InvalidRequest, "offset must be >= 0")
+			return
+		}
+		filter.Offset = n
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		v := strings.TrimSpace(q.Get(p.name))
+		if v == "" {
+			continue
+		}
+		t, err := parseReportTime(v)
+		if err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "%s must be a date (YYYY-MM-DD) or RFC 3339 time", p.name)
+			return
+		}
+		*p.dst = t
+	}
+
+	role, _ := TokenRoleFromContext(r.Context())
+	if role == "admin" {
+		if v := q.Get("token_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id <= 0 {
+				writeAPIError(w, apierror.InvalidRequest, "token_id must be a positive integer")
+				return
+			}
+			filter.TokenID = &id
+		}
+	} else {
+		tokenID, ok := teamTokenIDFromContext(r.Context())
+		if !ok {
+			writeAPIError(w, apierror.Unauthorized, "missing token context")
+			return
+		}
+		filter.TokenID = &tokenID
+	}
+
+	entries, err := h.store.ListAuditEntries(r.Context(), teamID, filter)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "list audit entries", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	resp := auditLogResponse{Entries: make([]auditEntryResponse, 0, len(entries))}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, auditEntryResponse{
+			ID:           e.ID,
+			Action:       e.Action,
+			ResourceType: e.ResourceType,
+			ResourceID:   e.ResourceID,
+			TokenID:      e.TokenID,
+			Details:      e.Details,
+			CreatedAt:    formatTime(e.CreatedAt),
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
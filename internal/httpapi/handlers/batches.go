@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"minitower/internal/apierror"
+	"minitower/internal/httputil"
+	"minitower/internal/store"
+)
+
+type createBatchRequest struct {
+	Inputs       []map[string]any `json:"inputs"`
+	VersionNo    *int64           `json:"version_no"`
+	VersionLabel string           `json:"version_label"`
+	Priority     *int             `json:"priority"`
+	MaxRetries   *int             `json:"max_retries"`
+	AtMostOnce   *bool            `json:"at_most_once"`
+}
+
+type createBatchResponse struct {
+	BatchID   string  `json:"batch_id"`
+	AppSlug   string  `json:"app_slug"`
+	VersionNo int64   `json:"version_no"`
+	Count     int     `json:"count"`
+	RunIDs    []int64 `json:"run_ids"`
+}
+
+type batchResponse struct {
+	BatchID         string           `json:"batch_id"`
+	AppID           int64            `json:"app_id"`
+	AppSlug         string           `json:"app_slug"`
+	Total           int64            `json:"total"`
+	Terminal        int64            `json:"terminal"`
+	Counts          map[string]int64 `json:"counts"`
+	PercentComplete float64          `json:"percent_complete"`
+	Done            bool             `json:"done"`
+	CreatedAt       string           `json:"created_at"`
+}
+
+type cancelBatchResponse struct {
+	batchResponse
+	Cancelled  int64 `json:"cancelled"`
+	Cancelling int64 `json:"cancelling"`
+}
+
+func newBatchResponse(sum *store.BatchSummary) batchResponse {
+	return batchResponse{
+		BatchID:         sum.BatchID,
+		AppID:           sum.AppID,
+		AppSlug:         sum.AppSlug,
+		Total:           sum.Total,
+		Terminal:        sum.Terminal,
+		Counts:          sum.Counts,
+		PercentComplete: float64(sum.Terminal*1000/sum.Total) / 10,
+		Done:            sum.Terminal == sum.Total,
+		CreatedAt:       formatTime(sum.CreatedAt),
+	}
+}
+
+// CreateRunBatch creates one run per input, all sharing a version, priority
+// and retry budget. Either every run is created or, if any input fails
+// validation, none is and the error lists each rejected input.
+func (h *Handlers) CreateRunBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	slug := extractAppSlugFromRunPath(r.URL.Path)
+	if slug == "" {
+		writeAPIError(w, apierror.InvalidRequest, "missing app slug")
+		return
+	}
+
+	app, err := h.store.GetAppBySlug(r.Context(), teamID, slug)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if app == nil {
+		writeAPIError(w, apierror.NotFound, "app not found")
+		return
+	}
+	if !requireScope(w, r, ScopeRunsCreate, app.ID) {
+		return
+	}
+	if app.Disabled {
+		writeAPIError(w, apierror.AppDisabled, "app is disabled")
+		return
+	}
+
+	var req createBatchRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "malformed JSON body")
+		return
+	}
+	if len(req.Inputs) == 0 || len(req.Inputs) > store.MaxBatchRuns {
+		writeAPIError(w, apierror.InvalidRequest, "inputs must contain 1 to %d items", store.MaxBatchRuns)
+		return
+	}
+
+	version, ok := h.resolveRunVersion(w, r, app.ID, req.VersionNo, req.VersionLabel)
+	if !ok {
+		return
+	}
+
+	for i, input := range req.Inputs {
+		req.Inputs[i] = store.MergeDefaultInput(app.DefaultInput, input)
+	}
+
+	priority := 0
+	if req.Priority != nil {
+		priority = clampRunPriority(*req.Priority)
+	}
+	maxRetries := runMaxRetries(req.MaxRetries, version)
+	atMostOnce := version.AtMostOnce
+	if req.AtMostOnce != nil {
+		atMostOnce = *req.AtMostOnce
+	}
+
+	env, err := h.store.GetOrCreateDefaultEnvironment(r.Context(), teamID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get default environment", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	batch, err := h.store.CreateRunBatch(r.Context(), store.RunBatchSpec{
+		TeamID:        teamID,
+		AppID:         app.ID,
+		EnvironmentID: env.ID,
+		Version:       version,
+		Inputs:        req.Inputs,
+		Priority:      priority,
+		MaxRetries:    maxRetries,
+		AtMostOnce:    atMostOnce,
+	})
+	var inputErr *store.BatchInputError
+	if errors.As(err, &inputErr) {
+		items := make([]httputil.ErrorItem, 0, len(inputErr.Items))
+		for _, item := range inputErr.Items {
+			items = append(items, httputil.ErrorItem{
+				Index:   item.Index,
+				Message: fmt.Sprintf("input does not match schema: %s", item.Message),
+			})
+		}
+		httputil.WriteErrorItems(w, apierror.InvalidRequest.Status(), string(apierror.InvalidRequest),
+			fmt.Sprintf("%d of %d inputs are invalid; no runs were created", len(items), len(req.Inputs)), items)
+		return
+	}
+	if writeQuotaError(w, err) {
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "create run batch", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	h.queue.Notify(env.Name)
+
+	teamSlug, _ := teamSlugFromContext(r.Context())
+	for range batch.RunIDs {
+		h.metrics.RunCreated(teamSlug, slug)
+	}
+
+	h.audit(r, AuditBatchCreate, "batch", batch.BatchID, map[string]any{"app": app.Slug, "version_no": version.VersionNo, "count": len(batch.RunIDs)})
+	writeJSON(w, http.StatusCreated, createBatchResponse{
+		BatchID:   batch.BatchID,
+		AppSlug:   app.Slug,
+		VersionNo: version.VersionNo,
+		Count:     len(batch.RunIDs),
+		RunIDs:    batch.RunIDs,
+	})
+}
+
+// GetBatch returns aggregate status counts for a batch.
+func (h *Handlers) GetBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	batchID := extractBatchIDFromPath(r.URL.Path)
+	if batchID == "" {
+		writeAPIError(w, apierror.InvalidRequest, "invalid batch ID")
+		return
+	}
+
+	sum, err := h.store.GetBatchSummary(r.Context(), teamID, batchID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get batch summary", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if sum == nil {
+		writeAPIError(w, apierror.NotFound, "batch not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newBatchResponse(sum))
+}
+
+// CancelBatch requests cancellation of every non-terminal run in a batch.
+func (h *Handlers) CancelBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	batchID := extractBatchIDFromPath(r.URL.Path)
+	if batchID == "" {
+		writeAPIError(w, apierror.InvalidRequest, "invalid batch ID")
+		return
+	}
+
+	res, err := h.store.CancelBatch(r.Context(), teamID, batchID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "cancel batch", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if res == nil {
+		writeAPIError(w, apierror.NotFound, "batch not found")
+		return
+	}
+
+	sum, err := h.store.GetBatchSummary(r.Context(), teamID, batchID)
+	if err != nil || sum == nil {
+		h.logger.ErrorContext(r.Context(), "get batch summary", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	// Queued runs went straight to a terminal state.
+	teamSlug, _ := teamSlugFromContext(r.Context())
+	for range res.Cancelled {
+		h.metrics.RunCompleted(teamSlug, sum.AppSlug, "cancelled")
+	}
+
+	h.audit(r, AuditBatchCancel, "batch", batchID, map[string]any{"cancelled": res.Cancelled, "cancelling": res.Cancelling})
+	writeJSON(w, http.StatusOK, cancelBatchResponse{
+		batchResponse: newBatchResponse(sum),
+		Cancelled:     res.Cancelled,
+		Cancelling:    res.Cancelling,
+	})
+}
+
+// extractBatchIDFromPath extracts the batch ID from /api/v1/batches/{id}[/...].
+func extractBatchIDFromPath(path string) string {
+	const prefix = "/api/v1/batches/"
+	if !strings.HasPrefix(path, prefix) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(path, prefix), "/")
+	if !httputil.ValidID(id) {
+		return ""
+	}
+	return id
+}
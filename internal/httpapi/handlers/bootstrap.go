@@ -5,6 +5,7 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 
+	"minitower/internal/apierror"
 	"minitower/internal/auth"
 	"minitower/internal/validate"
 )
@@ -31,24 +32,24 @@ func (h *Handlers) BootstrapTeam(w http.ResponseWriter, r *http.Request) {
 
 	var req bootstrapTeamRequest
 	if err := decodeJSON(r, &req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
 		return
 	}
 
 	if err := validate.ValidateSlug(req.Slug); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_slug", err.Error())
+		writeAPIError(w, apierror.InvalidSlug, "%v", err)
 		return
 	}
 
 	if req.Name == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "name is required")
+		writeAPIError(w, apierror.InvalidRequest, "name is required")
 		return
 	}
 
 	team, err := h.store.GetTeamBySlug(r.Context(), req.Slug)
 	if err != nil {
-		h.logger.Error("get team by slug", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get team by slug", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
@@ -58,19 +59,19 @@ func (h *Handlers) BootstrapTeam(w http.ResponseWriter, r *http.Request) {
 		// This keeps bootstrap idempotent for one slug while still preventing creating a second team.
 		exists, err := h.store.TeamExists(r.Context())
 		if err != nil {
-			h.logger.Error("check team exists", "error", err)
-			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			h.logger.ErrorContext(r.Context(), "check team exists", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
 			return
 		}
 		if exists {
-			writeError(w, http.StatusConflict, "team_exists", "a team already exists")
+			writeAPIError(w, apierror.TeamExists, "a team already exists")
 			return
 		}
 
 		team, err = h.store.CreateTeam(r.Context(), req.Slug, req.Name)
 		if err != nil {
-			h.logger.Error("create team", "error", err)
-			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			h.logger.ErrorContext(r.Context(), "create team", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
 			return
 		}
 	} else {
@@ -82,13 +83,13 @@ func (h *Handlers) BootstrapTeam(w http.ResponseWriter, r *http.Request) {
 	if req.Password != nil && *req.Password != "" {
 		hash, err := bcrypt.GenerateFromPassword([]byte(*req.Password), 12)
 		if err != nil {
-			h.logger.Error("hash password", "error", err)
-			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			h.logger.ErrorContext(r.Context(), "hash password", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
 			return
 		}
 		if err := h.store.SetTeamPassword(r.Context(), team.ID, string(hash)); err != nil {
-			h.logger.Error("set team password", "error", err)
-			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			h.logger.ErrorContext(r.Context(), "set team password", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
 			return
 		}
 	}
@@ -96,16 +97,16 @@ func (h *Handlers) BootstrapTeam(w http.ResponseWriter, r *http.Request) {
 	// Generate initial/recovery team API token.
 	teamToken, teamTokenHash, err := auth.GeneratePrefixedToken(auth.PrefixTeamToken)
 	if err != nil {
-		h.logger.Error("generate team token", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "generate team token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	tokenName := "bootstrap"
 	createdToken, err := h.store.CreateTeamToken(r.Context(), team.ID, teamTokenHash, &tokenName, "admin")
 	if err != nil {
-		h.logger.Error("create team token", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "create team token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
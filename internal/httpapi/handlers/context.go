@@ -9,6 +9,7 @@ const (
 	ctxKeyTeamSlug    contextKey = "teamSlug"
 	ctxKeyTeamTokenID contextKey = "teamTokenID"
 	ctxKeyTokenRole   contextKey = "tokenRole"
+	ctxKeyTokenScope  contextKey = "tokenScope"
 	ctxKeyRunnerID    contextKey = "runnerID"
 	ctxKeyEnvironment contextKey = "environment"
 )
@@ -53,6 +54,24 @@ func TokenRoleFromContext(ctx context.Context) (string, bool) {
 	return role, ok
 }
 
+// TokenScope is what a scoped token is limited to.
+type TokenScope struct {
+	Scopes []string
+	// AppID and AppSlug name the app the token is limited to, if any.
+	AppID   *int64
+	AppSlug string
+}
+
+func WithTokenScope(ctx context.Context, scope TokenScope) context.Context {
+	return context.WithValue(ctx, ctxKeyTokenScope, scope)
+}
+
+func tokenScopeFromContext(ctx context.Context) (TokenScope, bool) {
+	value := ctx.Value(ctxKeyTokenScope)
+	scope, ok := value.(TokenScope)
+	return scope, ok
+}
+
 func WithRunnerID(ctx context.Context, runnerID int64) context.Context {
 	return context.WithValue(ctx, ctxKeyRunnerID, runnerID)
 }
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"minitower/internal/apierror"
+	"minitower/internal/store"
+	"minitower/internal/validate"
+)
+
+type createEnvironmentRequest struct {
+	Name string `json:"name"`
+}
+
+type environmentResponse struct {
+	EnvironmentID int64  `json:"environment_id"`
+	Name          string `json:"name"`
+	IsDefault     bool   `json:"is_default"`
+	QueuedRuns    int64  `json:"queued_runs"`
+	RunningRuns   int64  `json:"running_runs"`
+	OnlineRunners int64  `json:"online_runners"`
+	CreatedAt     string `json:"created_at"`
+	UpdatedAt     string `json:"updated_at"`
+}
+
+type listEnvironmentsResponse struct {
+	Environments []environmentResponse `json:"environments"`
+}
+
+func newEnvironmentResponse(env *store.Environment) environmentResponse {
+	resp := environmentResponse{
+		EnvironmentID: env.ID,
+		Name:          env.Name,
+		IsDefault:     env.IsDefault,
+		CreatedAt:     formatTime(env.CreatedAt),
+		UpdatedAt:     formatTime(env.UpdatedAt),
+	}
+	if env.Counts != nil {
+		resp.QueuedRuns = env.Counts.QueuedRuns
+		resp.RunningRuns = env.Counts.RunningRuns
+		resp.OnlineRunners = env.Counts.OnlineRunners
+	}
+	return resp
+}
+
+// ListEnvironments returns the team's environments with queue and runner counts.
+// GET /api/v1/environments
+func (h *Handlers) ListEnvironments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	// The default environment is otherwise created lazily by the first run,
+	// so make sure a new team sees it too.
+	if _, err := h.store.GetOrCreateDefaultEnvironment(r.Context(), teamID); err != nil {
+		h.logger.ErrorContext(r.Context(), "get default environment", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	envs, err := h.store.ListEnvironmentsWithCounts(r.Context(), teamID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "list environments", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	resp := listEnvironmentsResponse{Environments: make([]environmentResponse, 0, len(envs))}
+	for _, env := range envs {
+		resp.Environments = append(resp.Environments, newEnvironmentResponse(env))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// CreateEnvironment creates a named environment for the team.
+// POST /api/v1/environments
+func (h *Handlers) CreateEnvironment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	var req createEnvironmentRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
+		return
+	}
+
+	if err := validate.ValidateSlug(req.Name); err != nil {
+		writeAPIError(w, apierror.InvalidName, "%v", err)
+		return
+	}
+
+	env, err := h.store.CreateEnvironment(r.Context(), teamID, req.Name)
+	if errors.Is(err, store.ErrEnvironmentExists) {
+		writeAPIError(w, apierror.NameTaken, "environment already exists")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "create environment", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	h.audit(r, AuditEnvironmentCreate, "environment", env.ID, map[string]any{"name": env.Name})
+	writeJSON(w, http.StatusCreated, newEnvironmentResponse(env))
+}
+
+// DeleteEnvironment deletes an unused environment.
+// DELETE /api/v1/environments/{name}
+func (h *Handlers) DeleteEnvironment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	name := extractPathParam(r.URL.Path, "/api/v1/environments/")
+	if name == "" {
+		writeAPIError(w, apierror.InvalidRequest, "missing environment name")
+		return
+	}
+
+	env, err := h.store.GetEnvironmentByName(r.Context(), teamID, name)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get environment", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if env == nil {
+		writeAPIError(w, apierror.NotFound, "environment not found")
+		return
+	}
+	if env.IsDefault {
+		writeAPIError(w, apierror.EnvironmentIsDefault, "the default environment cannot be deleted")
+		return
+	}
+
+	err = h.store.DeleteEnvironment(r.Context(), teamID, env.ID)
+	if errors.Is(err, store.ErrEnvironmentInUse) {
+		writeAPIError(w, apierror.EnvironmentInUse, "environment is referenced by runs or runners")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "delete environment", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	h.audit(r, AuditEnvironmentDelete, "environment", env.ID, map[string]any{"name": env.Name})
+	w.WriteHeader(http.StatusNoContent)
+}
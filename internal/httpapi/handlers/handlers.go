@@ -1,15 +1,22 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"minitower/internal/apierror"
+	"minitower/internal/backup"
 	"minitower/internal/config"
 	"minitower/internal/httputil"
+	"minitower/internal/integrity"
 	"minitower/internal/objects"
+	"minitower/internal/queue"
 	"minitower/internal/store"
 )
 
@@ -17,12 +24,16 @@ import (
 type DomainMetrics interface {
 	RunCreated(team, app string)
 	RunCompleted(team, app, status string)
+	RunFailed(team, app, failureKind string)
+	RunDead(team, app, reason string)
 	RunRetried(team, app string)
 	RunLeased(environment string)
+	ArtifactMissing(app string)
 	RunnerRegistered(environment string)
 	ObserveQueueWait(team, app string, seconds float64)
 	ObserveExecution(team, app, status string, seconds float64)
 	ObserveTotal(team, app, status string, seconds float64)
+	RunnerClockSkew(runner string, seconds float64)
 }
 
 // NoOpMetrics is a no-op implementation of DomainMetrics for tests.
@@ -30,12 +41,16 @@ type NoOpMetrics struct{}
 
 func (NoOpMetrics) RunCreated(string, string)                          {}
 func (NoOpMetrics) RunCompleted(string, string, string)                {}
+func (NoOpMetrics) RunFailed(string, string, string)                   {}
+func (NoOpMetrics) RunDead(string, string, string)                     {}
 func (NoOpMetrics) RunRetried(string, string)                          {}
 func (NoOpMetrics) RunLeased(string)                                   {}
+func (NoOpMetrics) ArtifactMissing(string)                             {}
 func (NoOpMetrics) RunnerRegistered(string)                            {}
 func (NoOpMetrics) ObserveQueueWait(string, string, float64)           {}
 func (NoOpMetrics) ObserveExecution(string, string, string, float64)   {}
 func (NoOpMetrics) ObserveTotal(string, string, string, float64)       {}
+func (NoOpMetrics) RunnerClockSkew(string, float64)                    {}
 
 // Handlers contains all HTTP handlers.
 type Handlers struct {
@@ -43,8 +58,15 @@ type Handlers struct {
 	db      *sql.DB
 	store   *Store
 	objects *objects.LocalStore
+	backups *backup.Manager
 	logger  *slog.Logger
 	metrics DomainMetrics
+	queue   *queue.Notifier
+
+	versionFiles *versionFilesCache
+	// verifications runs the object verification jobs the admin endpoint
+	// starts.
+	verifications *integrity.Jobs
 }
 
 // Store wraps the store.Store with additional methods for handlers.
@@ -56,27 +78,74 @@ func newStore(db *sql.DB) *Store {
 	return &Store{Store: store.New(db)}
 }
 
-// New creates a new Handlers instance.
-func New(cfg config.Config, db *sql.DB, objects *objects.LocalStore, logger *slog.Logger, metrics DomainMetrics) *Handlers {
+// New creates a new Handlers instance. ctx bounds the background jobs
+// requests start; cancel it on shutdown.
+func New(ctx context.Context, cfg config.Config, db *sql.DB, objects *objects.LocalStore, backups *backup.Manager, logger *slog.Logger, metrics DomainMetrics, notifier *queue.Notifier) *Handlers {
 	if metrics == nil {
 		metrics = NoOpMetrics{}
 	}
+	st := newStore(db)
+	st.SetPriorityAging(store.PriorityAging{
+		Interval: time.Duration(cfg.PriorityAgingMinutes) * time.Minute,
+		Cap:      cfg.PriorityAgingCap,
+	})
+	st.SetInputLimits(store.InputLimits{
+		CompressAbove: int(cfg.InputCompressAbove),
+		MaxStored:     int(cfg.MaxInputSize),
+	})
+	st.SetArtifactCheck(func(key string) bool {
+		// A failed check is left to the runner's download to report.
+		exists, err := objects.Exists(key)
+		if err != nil {
+			logger.Warn("check artifact exists", "error", err, "key", key)
+		}
+		return err != nil || exists
+	})
 	return &Handlers{
 		cfg:     cfg,
 		db:      db,
-		store:   newStore(db),
+		store:   st,
 		objects: objects,
+		backups: backups,
 		logger:  logger,
 		metrics: metrics,
+		queue:   notifier,
+
+		versionFiles:  newVersionFilesCache(versionFilesCacheSize),
+		verifications: integrity.NewJobs(ctx),
 	}
 }
 
+// WaitJobs waits for the background jobs requests started, such as object
+// verifications, to return once the context passed to New is cancelled.
+func (h *Handlers) WaitJobs(ctx context.Context) error {
+	return h.verifications.Wait(ctx)
+}
+
 func writeJSON(w http.ResponseWriter, status int, payload any) {
 	httputil.WriteJSON(w, status, payload)
 }
 
-func writeError(w http.ResponseWriter, status int, code, message string) {
-	httputil.WriteError(w, status, code, message)
+// writeAPIError writes an error response with the status the catalog
+// assigns to code.
+func writeAPIError(w http.ResponseWriter, code apierror.Code, msgf string, args ...any) {
+	httputil.WriteError(w, code.Status(), string(code), fmt.Sprintf(msgf, args...))
+}
+
+// timeLayout is the format of every timestamp the API returns: RFC 3339 in
+// UTC with exactly three fractional digits, so values sort as strings.
+const timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// formatTime formats t for an API response.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// parseTime parses a timestamp from a request. It accepts RFC 3339 with or
+// without fractional seconds, so clients sending the older second-precision
+// format keep working.
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
 }
 
 func decodeJSON(r *http.Request, v any) error {
@@ -85,22 +154,22 @@ func decodeJSON(r *http.Request, v any) error {
 
 // writeStoreError maps store sentinel errors to HTTP responses.
 // Returns true if it handled the error (wrote a response).
-func writeStoreError(w http.ResponseWriter, logger *slog.Logger, err error, logMsg string) bool {
+func writeStoreError(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error, logMsg string) bool {
 	if err == nil {
 		return false
 	}
 	switch {
 	case errors.Is(err, store.ErrInvalidLeaseToken):
-		writeError(w, http.StatusGone, "gone", "invalid or expired lease")
+		writeAPIError(w, apierror.LeaseInvalid, "invalid or expired lease")
 	case errors.Is(err, store.ErrLeaseConflict):
-		writeError(w, http.StatusConflict, "conflict", logMsg)
+		writeAPIError(w, apierror.LeaseConflict, "%s", logMsg)
 	case errors.Is(err, store.ErrAttemptNotActive):
-		writeError(w, http.StatusGone, "gone", "attempt not active")
+		writeAPIError(w, apierror.AttemptNotActive, "attempt not active")
 	case errors.Is(err, store.ErrNoRunAvailable):
 		w.WriteHeader(http.StatusNoContent)
 	default:
-		logger.Error(logMsg, "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		logger.ErrorContext(r.Context(), logMsg, "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 	}
 	return true
 }
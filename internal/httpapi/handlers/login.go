@@ -5,6 +5,7 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 
+	"minitower/internal/apierror"
 	"minitower/internal/auth"
 )
 
@@ -29,46 +30,46 @@ func (h *Handlers) LoginTeam(w http.ResponseWriter, r *http.Request) {
 
 	var req loginRequest
 	if err := decodeJSON(r, &req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
 		return
 	}
 
 	if req.Slug == "" || req.Password == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "slug and password are required")
+		writeAPIError(w, apierror.InvalidRequest, "slug and password are required")
 		return
 	}
 
 	team, err := h.store.GetTeamBySlug(r.Context(), req.Slug)
 	if err != nil {
-		h.logger.Error("get team by slug", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get team by slug", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	// Generic 401 for: team not found, no password set, or wrong password.
 	if team == nil || team.PasswordHash == nil {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "invalid slug or password")
+		writeAPIError(w, apierror.Unauthorized, "invalid slug or password")
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(*team.PasswordHash), []byte(req.Password)); err != nil {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "invalid slug or password")
+		writeAPIError(w, apierror.Unauthorized, "invalid slug or password")
 		return
 	}
 
 	// Generate a new team API token.
 	token, tokenHash, err := auth.GeneratePrefixedToken(auth.PrefixTeamToken)
 	if err != nil {
-		h.logger.Error("generate team token", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "generate team token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	tokenName := "login"
 	teamToken, err := h.store.CreateTeamToken(r.Context(), team.ID, tokenHash, &tokenName, "admin")
 	if err != nil {
-		h.logger.Error("create team token", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "create team token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
@@ -1,12 +1,19 @@
 package handlers
 
-import "net/http"
+import (
+	"net/http"
+
+	"minitower/internal/apierror"
+)
 
 type meResponse struct {
 	TeamID   int64  `json:"team_id"`
 	TeamSlug string `json:"team_slug"`
 	TokenID  int64  `json:"token_id"`
 	Role     string `json:"role"`
+	// Scopes and AppSlug are set for a scoped token.
+	Scopes  []string `json:"scopes,omitempty"`
+	AppSlug string   `json:"app_slug,omitempty"`
 }
 
 // GetMe returns the current team/token identity.
@@ -18,29 +25,34 @@ func (h *Handlers) GetMe(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 	teamSlug, ok := teamSlugFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 	tokenID, ok := teamTokenIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing token context")
+		writeAPIError(w, apierror.Unauthorized, "missing token context")
 		return
 	}
 	role, ok := TokenRoleFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing token role")
+		writeAPIError(w, apierror.Unauthorized, "missing token role")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, meResponse{
+	resp := meResponse{
 		TeamID:   teamID,
 		TeamSlug: teamSlug,
 		TokenID:  tokenID,
 		Role:     role,
-	})
+	}
+	if scope, ok := tokenScopeFromContext(r.Context()); ok {
+		resp.Scopes = scope.Scopes
+		resp.AppSlug = scope.AppSlug
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
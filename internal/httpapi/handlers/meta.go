@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minitower/internal/apierror"
+	"minitower/internal/store"
+)
+
+type errorCatalogResponse struct {
+	Errors []apierror.Entry `json:"errors"`
+}
+
+// ListErrorCodes returns the catalog of error codes the API can return,
+// with the HTTP status each one is sent with.
+func (h *Handlers) ListErrorCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, errorCatalogResponse{Errors: apierror.Catalog()})
+}
+
+// maxLogLineBytes caps one submitted log line.
+const maxLogLineBytes = 8192
+
+type limitsResponse struct {
+	// MaxArtifactBytes caps a version upload's request body, and
+	// MaxRequestBodyBytes every other request's.
+	MaxArtifactBytes    int64 `json:"max_artifact_bytes"`
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes"`
+	MaxLogLineBytes     int   `json:"max_log_line_bytes"`
+	MaxBatchRuns        int   `json:"max_batch_runs"`
+}
+
+// GetLimits returns the server's size and count limits, so clients can
+// refuse a request that would fail before sending it.
+func (h *Handlers) GetLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, limitsResponse{
+		MaxArtifactBytes:    h.cfg.MaxArtifactSize,
+		MaxRequestBodyBytes: h.cfg.MaxRequestBodySize,
+		MaxLogLineBytes:     maxLogLineBytes,
+		MaxBatchRuns:        store.MaxBatchRuns,
+	})
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"net/http"
+
+	"minitower/internal/httpapi/openapi"
+)
+
+var (
+	limitParam   = openapi.Param{Name: "limit", Type: "integer", Description: "Page size, 1-100; defaults to 50."}
+	offsetParam  = openapi.Param{Name: "offset", Type: "integer", Description: "Number of items to skip."}
+	versionParam = openapi.Param{Name: "version_no", Type: "integer", Description: "Only runs of this version number."}
+	// runListParams are the filters of the team and admin run lists.
+	runListParams = []openapi.Param{
+		{Name: "status", Type: "string", Description: "Only runs with this status."},
+		{Name: "app", Type: "string", Description: "Only runs of the app with this slug."},
+		versionParam,
+		{Name: "input_contains", Type: "string", Description: `JSON object of up to 3 top-level input keys and the scalar values they must equal, e.g. {"customer":"acme"}.`},
+		{Name: "runner", Type: "string", Description: "Only runs whose latest attempt was leased by the runner with this name."},
+		{Name: "since", Type: "string", Description: "Only runs queued at or after this date (YYYY-MM-DD) or RFC 3339 time."},
+		{Name: "include", Type: "string", Description: "Comma-separated extras; runner adds each run's runner_name."},
+	}
+)
+
+func ok(body any) openapi.Response {
+	return openapi.Response{Status: http.StatusOK, Body: body}
+}
+
+func created(body any) openapi.Response {
+	return openapi.Response{Status: http.StatusCreated, Body: body}
+}
+
+// Operations describes every endpoint the handlers serve, for the OpenAPI
+// document. Bodies are zero values of the types the handlers decode and
+// encode, so the document follows their JSON tags.
+func Operations() []openapi.Operation {
+	return []openapi.Operation{
+		// Public
+		{Method: http.MethodGet, Path: "/api/v1/auth/options", Summary: "Report which team sign-up flows are enabled",
+			Responses: []openapi.Response{ok(authOptionsResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/meta/errors", Summary: "List the error codes with their HTTP status",
+			Responses: []openapi.Response{ok(errorCatalogResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/meta/limits", Summary: "Report the server's size and count limits",
+			Responses: []openapi.Response{ok(limitsResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/bootstrap/team", Summary: "Create a team, or recover its admin token", Auth: openapi.AuthBootstrap,
+			Request: bootstrapTeamRequest{},
+			Responses: []openapi.Response{
+				{Status: http.StatusCreated, Description: "Team created", Body: bootstrapTeamResponse{}},
+				{Status: http.StatusOK, Description: "Team existed; a new admin token was issued", Body: bootstrapTeamResponse{}},
+			}},
+		{Method: http.MethodPost, Path: "/api/v1/teams/signup", Summary: "Sign up a team",
+			Request: signupTeamRequest{}, Responses: []openapi.Response{created(signupTeamResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/teams/login", Summary: "Log in to a team with its password",
+			Request: loginRequest{}, Responses: []openapi.Response{created(loginResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/run-status/{status_token}", Summary: "Get one run's status with the status token issued at its creation",
+			Responses: []openapi.Response{ok(runStatusResponse{})}},
+
+		// Team
+		{Method: http.MethodGet, Path: "/api/v1/me", Summary: "Describe the calling token", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(meResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/tokens", Summary: "Create a team token", Auth: openapi.AuthTeam,
+			Request: createTokenRequest{}, Responses: []openapi.Response{created(createTokenResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/apps", Summary: "List apps", Auth: openapi.AuthTeam,
+			Query:     []openapi.Param{{Name: "include", Type: "string", Description: "Comma-separated extras; stats adds each app's run stats."}},
+			Responses: []openapi.Response{ok(listAppsResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/apps", Summary: "Create an app", Auth: openapi.AuthTeam,
+			Request: createAppRequest{}, Responses: []openapi.Response{created(appResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/apps/{app}", Summary: "Get an app", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(appResponse{})}},
+		{Method: http.MethodPatch, Path: "/api/v1/apps/{app}", Summary: "Update an app's default input or description; null clears either", Auth: openapi.AuthTeam,
+			Request: updateAppRequest{}, Responses: []openapi.Response{ok(appResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/apps/{app}/errors", Summary: "Group an app's recent failed attempts by error fingerprint", Auth: openapi.AuthTeam,
+			Query: []openapi.Param{
+				{Name: "window", Type: "string", Description: "How far back to look, as a duration such as 24h; defaults to 24h."},
+				{Name: "limit", Type: "integer", Description: "Most groups to return, 1-50; defaults to 10."},
+			},
+			Responses: []openapi.Response{ok(appErrorsResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/apps/{app}/schema", Summary: "Get the params schema of an app's latest or named version", Auth: openapi.AuthTeam,
+			Query: []openapi.Param{
+				{Name: "version_no", Type: "integer", Description: "Use this version number instead of the latest."},
+				{Name: "version_label", Type: "string", Description: "Use the version this label points at instead of the latest."},
+			},
+			Responses: []openapi.Response{ok(map[string]any{})}},
+		{Method: http.MethodGet, Path: "/api/v1/apps/{app}/versions", Summary: "List versions", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(listVersionsResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/apps/{app}/versions/{version_no}", Summary: "Get a version", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(versionResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/apps/{app}/versions", Summary: "Upload a version as a tar.gz with a Towerfile at its root", Auth: openapi.AuthTeam,
+			Upload: "artifact", UploadFields: []openapi.Param{{Name: "expected_sha256", Type: "string", Description: "Hex sha256 the artifact must have; a mismatch is rejected with 422 sha256_mismatch."}},
+			Responses: []openapi.Response{created(versionResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/apps/{app}/versions/{version_no}/labels", Summary: "Point a label at a version", Auth: openapi.AuthTeam,
+			Request: setVersionLabelRequest{}, Responses: []openapi.Response{ok(versionResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/apps/{app}/versions/{version_no}/promote", Summary: "Copy a version to another app of the team", Auth: openapi.AuthTeam,
+			Request: promoteVersionRequest{}, Responses: []openapi.Response{created(versionResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/apps/{app}/versions/{version_no}/stats", Summary: "Get a version's run counts and durations", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(versionStatsResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/apps/{app}/versions/{version_no}/files", Summary: "List a version's artifact entries", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(versionFilesResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/apps/{app}/versions/{version_no}/files/content", Summary: "Get one text file from a version's artifact", Auth: openapi.AuthTeam,
+			Query:     []openapi.Param{{Name: "path", Type: "string", Description: "Path of the file in the artifact.", Required: true}},
+			Responses: []openapi.Response{{Status: http.StatusOK, ContentType: "text/plain"}}},
+		{Method: http.MethodGet, Path: "/api/v1/apps/{app}/runs", Summary: "List an app's runs, newest first", Auth: openapi.AuthTeam,
+			Query: []openapi.Param{limitParam, offsetParam, versionParam}, Responses: []openapi.Response{ok(listRunsResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/apps/{app}/runs", Summary: "Create a run", Auth: openapi.AuthTeam,
+			Query: []openapi.Param{
+				{Name: "dry_run", Type: "boolean", Description: "Validate and resolve the run without queueing it."},
+				{Name: "coerce", Type: "boolean", Description: "Convert string inputs to the integer, number or boolean type the params schema declares, where unambiguous."},
+			},
+			Request: createRunRequest{},
+			Responses: []openapi.Response{
+				{Status: http.StatusCreated, Description: "Run queued", Body: runResponse{}},
+				{Status: http.StatusOK, Description: "Dry run; nothing was queued", Body: dryRunResponse{}},
+			}},
+		{Method: http.MethodPost, Path: "/api/v1/apps/{app}/runs/batch", Summary: "Create a batch of runs", Auth: openapi.AuthTeam,
+			Request: createBatchRequest{}, Responses: []openapi.Response{created(createBatchResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/environments", Summary: "List environments", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(listEnvironmentsResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/environments", Summary: "Create an environment", Auth: openapi.AuthTeam,
+			Request: createEnvironmentRequest{}, Responses: []openapi.Response{created(environmentResponse{})}},
+		{Method: http.MethodDelete, Path: "/api/v1/environments/{environment}", Summary: "Delete an unused environment", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{{Status: http.StatusNoContent}}},
+		{Method: http.MethodGet, Path: "/api/v1/runs", Summary: "List the team's runs, newest first", Auth: openapi.AuthTeam,
+			Query: append([]openapi.Param{limitParam, offsetParam}, runListParams...), Responses: []openapi.Response{ok(listRunsResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/runs/summary", Summary: "Count the team's runs by state", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(runSummaryResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/cancel", Summary: "Cancel the runs a filter selects", Auth: openapi.AuthTeam,
+			Request: bulkCancelRequest{}, Responses: []openapi.Response{ok(bulkCancelResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/runs/{run_id}", Summary: "Get a run", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(runResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/runs/{run_id}/logs", Summary: "Get a run's log lines", Auth: openapi.AuthTeam,
+			Query:     []openapi.Param{{Name: "after_seq", Type: "integer", Description: "Only lines after this sequence number."}},
+			Responses: []openapi.Response{ok(runLogsResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/runs/{run_id}/events", Summary: "Get a run's timeline of state transitions", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(runEventsResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/runs/{run_id}/attempts", Summary: "List a run's attempts with their environment snapshots", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(runAttemptsResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/{run_id}/cancel", Summary: "Cancel a run", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(runResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/{run_id}/priority", Summary: "Change a queued run's priority", Auth: openapi.AuthTeam,
+			Request: setRunPriorityRequest{}, Responses: []openapi.Response{ok(runResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/{run_id}/rerun", Summary: "Create a new run from a run, with input overrides", Auth: openapi.AuthTeam,
+			Request: rerunRequest{}, Responses: []openapi.Response{created(rerunResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/runs/{run_id}/outputs", Summary: "List a run's outputs", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(listRunOutputsResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/runs/{run_id}/outputs/{name}", Summary: "Download one output file", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{{Status: http.StatusOK, ContentType: "application/octet-stream"}}},
+		{Method: http.MethodGet, Path: "/api/v1/batches/{batch_id}", Summary: "Get a batch's progress", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(batchResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/batches/{batch_id}/cancel", Summary: "Cancel a batch's unfinished runs", Auth: openapi.AuthTeam,
+			Responses: []openapi.Response{ok(cancelBatchResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/reports/usage", Summary: "Report run usage over a date range", Auth: openapi.AuthTeam,
+			Query: []openapi.Param{
+				{Name: "from", Type: "string", Description: "Start date (YYYY-MM-DD) or RFC 3339 time.", Required: true},
+				{Name: "to", Type: "string", Description: "End date (YYYY-MM-DD) or RFC 3339 time, at most 92 days after from.", Required: true},
+				{Name: "group_by", Type: "string", Description: "app (default), team or environment."},
+			},
+			Responses: []openapi.Response{ok(usageReportResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/audit", Summary: "List the team's audit log, newest first", Auth: openapi.AuthTeam,
+			Query: []openapi.Param{
+				{Name: "since", Type: "string", Description: "Only entries at or after this date (YYYY-MM-DD) or RFC 3339 time."},
+				{Name: "until", Type: "string", Description: "Only entries before this date (YYYY-MM-DD) or RFC 3339 time."},
+				{Name: "action", Type: "string", Description: "Only entries with this action, e.g. run.cancel."},
+				{Name: "token_id", Type: "integer", Description: "Admin tokens only: only entries made with this token. Other tokens always see only their own entries."},
+				{Name: "limit", Type: "integer", Description: "Maximum entries to return, 1 to 500 (default 100)."},
+				offsetParam,
+			},
+			Responses: []openapi.Response{ok(auditLogResponse{})}},
+
+		// Admin
+		{Method: http.MethodGet, Path: "/api/v1/admin/runners", Summary: "List runners", Auth: openapi.AuthAdmin,
+			Query: []openapi.Param{
+				{Name: "status", Type: "string", Description: "online or offline."},
+				{Name: "environment", Type: "string", Description: "Only runners of this environment."},
+				{Name: "name_prefix", Type: "string", Description: "Only runners whose name starts with this."},
+				{Name: "stale_for", Type: "string", Description: "Only runners not seen for this duration, e.g. 10m."},
+				{Name: "limit", Type: "integer", Description: "Page size, 1-500; defaults to 100."},
+				offsetParam,
+			},
+			Responses: []openapi.Response{ok(listAdminRunnersResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/admin/runs", Summary: "List every team's runs, newest first", Auth: openapi.AuthAdmin,
+			Query: append([]openapi.Param{limitParam, offsetParam}, runListParams...), Responses: []openapi.Response{ok(listRunsResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/admin/runners", Summary: "Create a runner and return its token once", Auth: openapi.AuthAdmin,
+			Request: createRunnerRequest{}, Responses: []openapi.Response{created(runnerTokenResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/admin/runners/{id}/rotate-token", Summary: "Issue a runner a new token", Auth: openapi.AuthAdmin,
+			Responses: []openapi.Response{ok(runnerTokenResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/admin/overview", Summary: "Summarize teams, artifacts, runs and runners", Auth: openapi.AuthAdmin,
+			Responses: []openapi.Response{ok(adminOverviewResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/admin/teams/{team}/settings", Summary: "Get a team's settings and run quota usage", Auth: openapi.AuthAdmin,
+			Responses: []openapi.Response{ok(teamSettingsResponse{})}},
+		{Method: http.MethodPatch, Path: "/api/v1/admin/teams/{team}/settings", Summary: "Update a team's settings", Auth: openapi.AuthAdmin,
+			Request: updateTeamSettingsRequest{}, Responses: []openapi.Response{ok(teamSettingsResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/admin/backup", Summary: "Back up the database", Auth: openapi.AuthAdmin,
+			Responses: []openapi.Response{created(backupResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/admin/verify-objects", Summary: "Start verifying every version's artifact", Auth: openapi.AuthAdmin,
+			Request:   verifyObjectsRequest{},
+			Responses: []openapi.Response{{Status: http.StatusAccepted, Description: "Verification started", Body: verifyObjectsJobResponse{}}}},
+		{Method: http.MethodGet, Path: "/api/v1/admin/verify-objects/{job_id}", Summary: "Get an object verification job's progress and problems", Auth: openapi.AuthAdmin,
+			Responses: []openapi.Response{ok(verifyObjectsJobResponse{})}},
+
+		// Runner protocol
+		{Method: http.MethodPost, Path: "/api/v1/runners/register", Summary: "Register a runner", Auth: openapi.AuthRunnerRegistration,
+			Request: registerRunnerRequest{},
+			Responses: []openapi.Response{
+				{Status: http.StatusCreated, Description: "Runner registered", Body: registerRunnerResponse{}},
+				{Status: http.StatusOK, Description: "Runner existed; its token was rotated", Body: registerRunnerResponse{}},
+			}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/lease", Summary: "Lease the next queued run", Auth: openapi.AuthRunner,
+			Query: []openapi.Param{{Name: "wait", Type: "string", Description: "Hold the request up to this duration, e.g. 20s, for a run to be queued."}},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Run leased", Body: leaseResponse{}},
+				{Status: http.StatusNoContent, Description: "No run to lease"},
+			}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/{run_id}/start", Summary: "Mark the leased attempt as running", Auth: openapi.AuthLease,
+			Request: startRequest{}, Responses: []openapi.Response{ok(attemptResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/{run_id}/heartbeat", Summary: "Extend the lease", Auth: openapi.AuthLease,
+			Request: heartbeatRequest{}, Responses: []openapi.Response{ok(attemptResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/{run_id}/logs", Summary: "Submit a batch of up to 100 log lines", Auth: openapi.AuthLease,
+			Request: logBatchRequest{}, Responses: []openapi.Response{ok(statusResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/{run_id}/logs/stream", Summary: "Stream log entries as NDJSON", Auth: openapi.AuthLease,
+			Responses: []openapi.Response{ok(logStreamResponse{})}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/{run_id}/result", Summary: "Report the attempt's result", Auth: openapi.AuthLease,
+			Request: resultRequest{}, Responses: []openapi.Response{ok(statusResponse{})}},
+		{Method: http.MethodGet, Path: "/api/v1/runs/{run_id}/artifact", Summary: "Download the run's version artifact", Auth: openapi.AuthLease,
+			Responses: []openapi.Response{{Status: http.StatusOK, ContentType: "application/gzip"}}},
+		{Method: http.MethodPost, Path: "/api/v1/runs/{run_id}/outputs", Summary: "Upload one output file", Auth: openapi.AuthLease,
+			Upload: "file", Responses: []openapi.Response{created(runOutputResponse{})}},
+	}
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"minitower/internal/apierror"
+	"minitower/internal/store"
+)
+
+const (
+	// maxRunOutputs caps the outputs one run may keep.
+	maxRunOutputs = 20
+	// maxRunOutputBytes caps the size of one output file.
+	maxRunOutputBytes = 10 << 20
+)
+
+type runOutputResponse struct {
+	Name      string `json:"name"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+	CreatedAt string `json:"created_at"`
+}
+
+type listRunOutputsResponse struct {
+	Outputs []runOutputResponse `json:"outputs"`
+}
+
+func toRunOutputResponse(o *store.RunOutput) runOutputResponse {
+	return runOutputResponse{
+		Name:      o.Name,
+		SizeBytes: o.SizeBytes,
+		SHA256:    o.SHA256,
+		CreatedAt: formatTime(o.CreatedAt),
+	}
+}
+
+// validOutputName reports whether name can name an output: a single path
+// element of printable UTF-8, at most 255 bytes.
+func validOutputName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > 255 || !utf8.ValidString(name) {
+		return false
+	}
+	for _, r := range name {
+		if r == '/' || r == '\\' || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// UploadRunOutput stores one file from the run's outputs directory. The body
+// is multipart with the file in the "file" part, named by its filename.
+// Uploading a name the run already has replaces that output.
+func (h *Handlers) UploadRunOutput(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	runID, attempt, _, ok := h.requireLeaseContext(w, r, extractRunIDFromPath)
+	if !ok {
+		return
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "invalid multipart form")
+		return
+	}
+	var name string
+	var data []byte
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "invalid multipart form")
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		name = part.FileName()
+		data, err = io.ReadAll(io.LimitReader(part, maxRunOutputBytes+1))
+		part.Close()
+		if err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "read output file: %v", err)
+			return
+		}
+		break
+	}
+	if data == nil {
+		writeAPIError(w, apierror.InvalidRequest, "file is required")
+		return
+	}
+	if !validOutputName(name) {
+		writeAPIError(w, apierror.InvalidRequest, "invalid output name %q", name)
+		return
+	}
+	if len(data) > maxRunOutputBytes {
+		writeAPIError(w, apierror.FileTooLarge, "output %q exceeds %d bytes", name, maxRunOutputBytes)
+		return
+	}
+
+	existing, err := h.store.GetRunOutput(r.Context(), runID, name)
+	if writeStoreError(w, r, h.logger, err, "get run output") {
+		return
+	}
+	if existing == nil {
+		count, err := h.store.CountRunOutputs(r.Context(), runID)
+		if writeStoreError(w, r, h.logger, err, "count run outputs") {
+			return
+		}
+		if count >= maxRunOutputs {
+			writeAPIError(w, apierror.OutputLimit, "run already has %d outputs", count)
+			return
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	objectKey := fmt.Sprintf("runs/%d/outputs/%s", runID, name)
+	if err := h.objects.Store(objectKey, bytes.NewReader(data)); err != nil {
+		h.logger.ErrorContext(r.Context(), "store run output", "error", err, "key", objectKey)
+		writeAPIError(w, apierror.Internal, "failed to store output")
+		return
+	}
+
+	output, err := h.store.SaveRunOutput(r.Context(), &store.RunOutput{
+		RunID:        runID,
+		RunAttemptID: attempt.ID,
+		Name:         name,
+		SizeBytes:    int64(len(data)),
+		SHA256:       hex.EncodeToString(sum[:]),
+		ObjectKey:    objectKey,
+	})
+	if writeStoreError(w, r, h.logger, err, "save run output") {
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toRunOutputResponse(output))
+}
+
+// ListRunOutputs lists the files a run uploaded.
+func (h *Handlers) ListRunOutputs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	run, ok := h.teamRunFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !requireScope(w, r, ScopeRunsRead, run.AppID) {
+		return
+	}
+
+	outputs, err := h.store.ListRunOutputs(r.Context(), run.ID)
+	if writeStoreError(w, r, h.logger, err, "list run outputs") {
+		return
+	}
+
+	resp := listRunOutputsResponse{Outputs: make([]runOutputResponse, 0, len(outputs))}
+	for _, o := range outputs {
+		resp.Outputs = append(resp.Outputs, toRunOutputResponse(o))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// DownloadRunOutput streams one output file:
+// /api/v1/runs/{run}/outputs/{name}.
+func (h *Handlers) DownloadRunOutput(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	run, ok := h.teamRunFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !requireScope(w, r, ScopeRunsRead, run.AppID) {
+		return
+	}
+
+	name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	output, err := h.store.GetRunOutput(r.Context(), run.ID, name)
+	if writeStoreError(w, r, h.logger, err, "get run output") {
+		return
+	}
+	if output == nil {
+		writeAPIError(w, apierror.NotFound, "output not found")
+		return
+	}
+
+	reader, err := h.objects.Load(output.ObjectKey)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "load run output", "error", err, "key", output.ObjectKey)
+		writeAPIError(w, apierror.Internal, "output not found")
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": output.Name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(output.SizeBytes, 10))
+	w.Header().Set("X-Output-SHA256", output.SHA256)
+	io.Copy(w, reader)
+}
+
+// teamRunFromPath loads the run named in the path for the caller's team,
+// writing the error response when it is missing.
+func (h *Handlers) teamRunFromPath(w http.ResponseWriter, r *http.Request) (*store.Run, bool) {
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return nil, false
+	}
+
+	runID := extractRunIDFromPath(r.URL.Path)
+	if runID == 0 {
+		writeAPIError(w, apierror.InvalidRequest, "invalid run ID")
+		return nil, false
+	}
+
+	run, err := h.store.GetRunByID(r.Context(), teamID, runID)
+	if writeStoreError(w, r, h.logger, err, "get run") {
+		return nil, false
+	}
+	if run == nil {
+		writeAPIError(w, apierror.NotFound, "run not found")
+		return nil, false
+	}
+	return run, true
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"minitower/internal/apierror"
+	"minitower/internal/store"
+)
+
+// maxUsageReportRange caps the span of a usage report.
+const maxUsageReportRange = 92 * 24 * time.Hour
+
+type usageRowResponse struct {
+	Group                 string  `json:"group"`
+	Runs                  int64   `json:"runs"`
+	Completed             int64   `json:"completed"`
+	Failed                int64   `json:"failed"`
+	TotalExecutionSeconds float64 `json:"total_execution_seconds"`
+	TotalQueueSeconds     float64 `json:"total_queue_seconds"`
+}
+
+type usageReportResponse struct {
+	From    string             `json:"from"`
+	To      string             `json:"to"`
+	GroupBy string             `json:"group_by"`
+	Rows    []usageRowResponse `json:"rows"`
+}
+
+// GetUsageReport returns run counts and execution and queue time per app,
+// environment or team for runs created in [from, to). Grouping by team
+// requires an admin token and spans every team; other groupings cover the
+// caller's team.
+func (h *Handlers) GetUsageReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	q := r.URL.Query()
+	from, err := parseReportTime(q.Get("from"))
+	if err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "from must be a date (YYYY-MM-DD) or RFC 3339 time")
+		return
+	}
+	to, err := parseReportTime(q.Get("to"))
+	if err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "to must be a date (YYYY-MM-DD) or RFC 3339 time")
+		return
+	}
+	if !to.After(from) {
+		writeAPIError(w, apierror.InvalidRequest, "to must be after from")
+		return
+	}
+	if to.Sub(from) > maxUsageReportRange {
+		writeAPIError(w, apierror.InvalidRequest, "date range must not exceed 92 days")
+		return
+	}
+
+	groupBy := strings.TrimSpace(q.Get("group_by"))
+	if groupBy == "" {
+		groupBy = store.UsageGroupApp
+	}
+	query := store.UsageReportQuery{TeamID: teamID, From: from, To: to, GroupBy: groupBy}
+	switch groupBy {
+	case store.UsageGroupApp, store.UsageGroupEnvironment:
+	case store.UsageGroupTeam:
+		if role, _ := TokenRoleFromContext(r.Context()); role != "admin" {
+			writeAPIError(w, apierror.Forbidden, "group_by=team requires an admin token")
+			return
+		}
+		query.TeamID = 0
+	default:
+		writeAPIError(w, apierror.InvalidRequest, "group_by must be app, team or environment")
+		return
+	}
+
+	rows, err := h.store.GetUsageReport(r.Context(), query)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get usage report", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	resp := usageReportResponse{
+		From:    formatTime(from),
+		To:      formatTime(to),
+		GroupBy: groupBy,
+		Rows:    make([]usageRowResponse, 0, len(rows)),
+	}
+	for _, row := range rows {
+		resp.Rows = append(resp.Rows, usageRowResponse{
+			Group:                 row.Group,
+			Runs:                  row.Runs,
+			Completed:             row.Completed,
+			Failed:                row.Failed,
+			TotalExecutionSeconds: row.TotalExecutionSeconds,
+			TotalQueueSeconds:     row.TotalQueueSeconds,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseReportTime parses a report bound given as a UTC date or an RFC 3339
+// time.
+func parseReportTime(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return parseTime(v)
+}
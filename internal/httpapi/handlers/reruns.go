@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"minitower/internal/apierror"
+	"minitower/internal/store"
+	"minitower/internal/validate"
+)
+
+type rerunRequest struct {
+	// InputOverrides is deep-merged over the original run's input; a null
+	// value deletes the key.
+	InputOverrides map[string]any `json:"input_overrides"`
+	// VersionNo and VersionLabel pick another version of the app; the
+	// original run's version is used when neither is given.
+	VersionNo    *int64 `json:"version_no"`
+	VersionLabel string `json:"version_label"`
+}
+
+type inputChangeResponse struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+type rerunResponse struct {
+	Run runResponse `json:"run"`
+	// InputChanges lists how the new run's input differs from the
+	// original's.
+	InputChanges []inputChangeResponse `json:"input_changes"`
+}
+
+// RerunRun creates a new run from an existing one, with its input changed
+// by input_overrides and optionally on another version. The new run keeps
+// the original's environment, command, priority, max_retries and
+// at_most_once, and records the original as rerun_of_run_id.
+func (h *Handlers) RerunRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	runID := extractRunIDFromPath(r.URL.Path)
+	if runID == 0 {
+		writeAPIError(w, apierror.InvalidRequest, "invalid run ID")
+		return
+	}
+
+	var req rerunRequest
+	if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeAPIError(w, apierror.InvalidRequest, "malformed JSON body")
+		return
+	}
+
+	source, err := h.store.GetRunByID(r.Context(), teamID, runID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get run", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if source == nil {
+		writeAPIError(w, apierror.NotFound, "run not found")
+		return
+	}
+
+	app, err := h.store.GetAppByID(r.Context(), teamID, source.AppID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if app == nil {
+		writeAPIError(w, apierror.NotFound, "app not found")
+		return
+	}
+	if app.Disabled {
+		writeAPIError(w, apierror.AppDisabled, "app is disabled")
+		return
+	}
+
+	var version *store.AppVersion
+	if req.VersionNo == nil && req.VersionLabel == "" {
+		version, err = h.store.GetVersionByID(r.Context(), source.AppVersionID)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "get version", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
+			return
+		}
+		if version == nil {
+			writeAPIError(w, apierror.NotFound, "version not found")
+			return
+		}
+		if !runnableVersion(w, version) {
+			return
+		}
+	} else if version, ok = h.resolveRunVersion(w, r, app.ID, req.VersionNo, req.VersionLabel); !ok {
+		return
+	}
+	if source.Command != "" && version.Command(source.Command) == nil {
+		writeAPIError(w, apierror.InvalidRequest, "version %d has no command %q", version.VersionNo, source.Command)
+		return
+	}
+
+	input := store.MergeInputOverrides(source.Input, req.InputOverrides)
+	if _, _, paramsSchema := version.EntrypointFor(source.Command); paramsSchema != nil {
+		if err := validate.ValidateJSONInput(input, paramsSchema); err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "input does not match schema: %s", err.Error())
+			return
+		}
+	}
+
+	env, err := h.store.GetEnvironmentByID(r.Context(), teamID, source.EnvironmentID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get environment", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if env == nil {
+		writeAPIError(w, apierror.NotFound, "environment not found")
+		return
+	}
+
+	run, err := h.store.CreateRerun(r.Context(), source, version.ID, input)
+	if writeQuotaError(w, err) || writeInputTooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "create rerun", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	h.queue.Notify(env.Name)
+
+	teamSlug, _ := teamSlugFromContext(r.Context())
+	h.metrics.RunCreated(teamSlug, app.Slug)
+	h.audit(r, AuditRunCreate, "run", run.ID, map[string]any{"app": app.Slug, "version_no": version.VersionNo, "run_no": run.RunNo, "rerun_of_run_id": source.ID})
+
+	changes := store.DiffInput(source.Input, run.Input)
+	resp := rerunResponse{
+		Run: runResponse{
+			RunID:            run.ID,
+			AppID:            run.AppID,
+			AppSlug:          app.Slug,
+			RunNo:            run.RunNo,
+			VersionNo:        version.VersionNo,
+			Status:           run.Status,
+			Input:            run.Input,
+			Priority:         run.Priority,
+			MaxRetries:       run.MaxRetries,
+			RetryCount:       run.RetryCount,
+			RetriesRemaining: retriesRemaining(run.MaxRetries, run.RetryCount),
+			CancelRequested:  run.CancelRequested,
+			AtMostOnce:       run.AtMostOnce,
+			Command:          run.Command,
+			RunTraceID:       run.TraceID,
+			QueuedAt:         formatTime(run.QueuedAt),
+			Environment:      env.Name,
+			RerunOfRunID:     run.RerunOfRunID,
+			TeamActiveRuns:   run.TeamActiveRuns,
+		},
+		InputChanges: make([]inputChangeResponse, 0, len(changes)),
+	}
+	for _, c := range changes {
+		resp.InputChanges = append(resp.InputChanges, inputChangeResponse{Path: c.Path, Kind: c.Kind, Before: c.Before, After: c.After})
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
@@ -1,70 +1,109 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"minitower/internal/apierror"
 	"minitower/internal/auth"
 	"minitower/internal/store"
+	"minitower/internal/validate"
 )
 
 // requireLeaseContext extracts the run ID from the URL path, the lease token
-// from the X-Lease-Token header, and validates via GetActiveAttempt.
-// On failure it writes the HTTP error and returns ok=false.
+// from the X-Lease-Token header, and validates via GetActiveAttempt that the
+// token is the active attempt's and the attempt is the authenticated
+// runner's. On failure it writes the HTTP error and returns ok=false.
 func (h *Handlers) requireLeaseContext(w http.ResponseWriter, r *http.Request, extractID func(string) int64) (runID int64, attempt *store.RunAttempt, leaseTokenHash string, ok bool) {
 	runID = extractID(r.URL.Path)
 	if runID == 0 {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid run ID")
+		writeAPIError(w, apierror.InvalidRequest, "invalid run ID")
 		return 0, nil, "", false
 	}
 	runnerID, ok := runnerIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing runner context")
+		writeAPIError(w, apierror.Unauthorized, "missing runner context")
 		return 0, nil, "", false
 	}
 
 	leaseToken := r.Header.Get("X-Lease-Token")
 	if leaseToken == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "missing lease token")
+		writeAPIError(w, apierror.InvalidRequest, "missing lease token")
 		return 0, nil, "", false
 	}
 	leaseTokenHash = auth.HashToken(leaseToken)
 
 	attempt, err := h.store.GetActiveAttempt(r.Context(), runID, runnerID, leaseTokenHash)
-	if writeStoreError(w, h.logger, err, "get active attempt") {
+	if writeStoreError(w, r, h.logger, err, "get active attempt") {
 		return 0, nil, "", false
 	}
 	return runID, attempt, leaseTokenHash, true
 }
 
-// writeAttemptResponse fetches the run for cancel status and writes the
-// standard attemptResponse JSON used by StartRun and HeartbeatRun.
+// writeAttemptResponse fetches the run for cancel status and the version for
+// its current timeout, and writes the standard attemptResponse JSON used by
+// StartRun and HeartbeatRun.
 func (h *Handlers) writeAttemptResponse(w http.ResponseWriter, r *http.Request, runID int64, attempt *store.RunAttempt) {
 	run, err := h.store.GetRunByIDDirect(r.Context(), runID)
 	if err != nil {
-		h.logger.Error("get run", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get run", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	version, err := h.store.GetVersionByID(r.Context(), run.AppVersionID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get version", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, attemptResponse{
+	resp := attemptResponse{
 		AttemptID:       attempt.ID,
 		AttemptNo:       attempt.AttemptNo,
 		Status:          attempt.Status,
-		LeaseExpiresAt:  attempt.LeaseExpiresAt.Format(time.RFC3339),
+		LeaseExpiresAt:  formatTime(attempt.LeaseExpiresAt),
 		CancelRequested: run.CancelRequested,
 		RunStatus:       run.Status,
-	})
+		ServerTime:      formatTime(time.Now()),
+	}
+	if attempt.CancelAckAt != nil {
+		ackAt := formatTime(*attempt.CancelAckAt)
+		resp.CancelAckAt = &ackAt
+	}
+	if version != nil {
+		if _, timeoutSeconds, _ := version.EntrypointFor(run.Command); timeoutSeconds != nil {
+			resp.TimeoutSeconds = timeoutSeconds
+			if attempt.StartedAt != nil {
+				deadline := formatTime(attempt.StartedAt.Add(time.Duration(*timeoutSeconds) * time.Second))
+				resp.DeadlineAt = &deadline
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 type registerRunnerRequest struct {
 	Name        string `json:"name"`
 	Environment string `json:"environment"`
+	// Rotate controls what happens when the name is already registered:
+	// true re-issues the token, false returns 409. Unset rotates unless
+	// MINITOWER_STRICT_RUNNER_NAMES is set.
+	Rotate *bool `json:"rotate"`
+	// PythonVersions are the Python versions ("3.12") the runner has
+	// interpreters for. Only runs needing one of them, or none, are leased
+	// to it. Each registration replaces the previous list.
+	PythonVersions []string `json:"python_versions"`
 }
 
 type registerRunnerResponse struct {
@@ -82,12 +121,12 @@ func (h *Handlers) RegisterRunner(w http.ResponseWriter, r *http.Request) {
 
 	var req registerRunnerRequest
 	if err := decodeJSON(r, &req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
 		return
 	}
 
 	if req.Name == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "name is required")
+		writeAPIError(w, apierror.InvalidRequest, "name is required")
 		return
 	}
 
@@ -96,40 +135,71 @@ func (h *Handlers) RegisterRunner(w http.ResponseWriter, r *http.Request) {
 		environment = "default"
 	}
 
-	// Check if runner already exists (globally unique name)
-	existing, err := h.store.GetRunnerByName(r.Context(), req.Name)
+	labels, err := runnerLabels(req.PythonVersions)
 	if err != nil {
-		h.logger.Error("check runner exists", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		writeAPIError(w, apierror.InvalidRequest, "python_versions: %s", err.Error())
 		return
 	}
 
 	// Generate runner token
 	token, tokenHash, err := auth.GeneratePrefixedToken(auth.PrefixRunnerToken)
 	if err != nil {
-		h.logger.Error("generate runner token", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "generate runner token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
-	if existing != nil {
-		if err := h.store.RefreshRunnerRegistration(r.Context(), existing.ID, environment, tokenHash); err != nil {
-			h.logger.Error("refresh runner registration", "error", err)
-			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+	rotate := !h.cfg.StrictRunnerNames
+	if req.Rotate != nil {
+		rotate = *req.Rotate
+	}
+
+	// Names are globally unique. Only a rotating registration looks for an
+	// existing runner to take over; otherwise the insert itself rejects a
+	// taken name, so concurrent registrations cannot both pass a check.
+	if rotate {
+		existing, err := h.store.GetRunnerByName(r.Context(), req.Name)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "check runner exists", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
+			return
+		}
+		if existing != nil {
+			fenced, err := h.store.RefreshRunnerRegistration(r.Context(), existing.ID, environment, tokenHash)
+			if err != nil {
+				h.logger.ErrorContext(r.Context(), "refresh runner registration", "error", err)
+				writeAPIError(w, apierror.Internal, "internal error")
+				return
+			}
+			h.recordFencedAttempts(r.Context(), fenced)
+			if err := h.store.SetRunnerLabels(r.Context(), existing.ID, labels); err != nil {
+				h.logger.ErrorContext(r.Context(), "set runner labels", "error", err)
+				writeAPIError(w, apierror.Internal, "internal error")
+				return
+			}
+			h.logger.InfoContext(r.Context(), "runner re-registered", "runner", existing.Name, "fenced_attempts", len(fenced))
+			writeJSON(w, http.StatusOK, registerRunnerResponse{
+				RunnerID: existing.ID,
+				Name:     existing.Name,
+				Token:    token,
+			})
 			return
 		}
-		writeJSON(w, http.StatusOK, registerRunnerResponse{
-			RunnerID: existing.ID,
-			Name:     existing.Name,
-			Token:    token,
-		})
-		return
 	}
 
 	runner, err := h.store.CreateRunner(r.Context(), req.Name, environment, tokenHash)
+	if errors.Is(err, store.ErrRunnerNameTaken) {
+		writeAPIError(w, apierror.RunnerExists, "runner already exists")
+		return
+	}
 	if err != nil {
-		h.logger.Error("create runner", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "create runner", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if err := h.store.SetRunnerLabels(r.Context(), runner.ID, labels); err != nil {
+		h.logger.ErrorContext(r.Context(), "set runner labels", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
@@ -142,19 +212,77 @@ func (h *Handlers) RegisterRunner(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// runnerLabels returns the labels of a runner declaring pythonVersions, or
+// nil when it declares none.
+func runnerLabels(pythonVersions []string) (map[string]string, error) {
+	if len(pythonVersions) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool, len(pythonVersions))
+	versions := make([]string, 0, len(pythonVersions))
+	for _, v := range pythonVersions {
+		if err := validate.ValidatePythonVersion(v); err != nil {
+			return nil, err
+		}
+		if !seen[v] {
+			seen[v] = true
+			versions = append(versions, v)
+		}
+	}
+	sort.Strings(versions)
+	return map[string]string{store.RunnerLabelPython: strings.Join(versions, ",")}, nil
+}
+
+// recordFencedAttempts counts runs whose attempts were fenced by a
+// re-registration the same way the expiry reaper would, and wakes lease
+// waiters for the runs it re-queued.
+func (h *Handlers) recordFencedAttempts(ctx context.Context, results []store.ReapResult) {
+	for _, res := range results {
+		teamSlug, appSlug := "", ""
+		if team, _ := h.store.GetTeamByID(ctx, res.TeamID); team != nil {
+			teamSlug = team.Slug
+		}
+		if app, _ := h.store.GetAppByIDDirect(ctx, res.AppID); app != nil {
+			appSlug = app.Slug
+		}
+		switch res.Outcome {
+		case "retried":
+			h.metrics.RunRetried(teamSlug, appSlug)
+			h.queue.NotifyAll()
+		case "dead", "dead_at_most_once":
+			h.metrics.RunDead(teamSlug, appSlug, res.Reason)
+		case "cancelled":
+			h.metrics.RunCompleted(teamSlug, appSlug, res.Outcome)
+		}
+	}
+}
+
 type leaseResponse struct {
 	RunID          int64          `json:"run_id"`
 	RunNo          int64          `json:"run_no"`
+	RunTraceID     string         `json:"run_trace_id"`
+	TeamSlug       string         `json:"team_slug"`
 	AppID          int64          `json:"app_id"`
 	AppSlug        string         `json:"app_slug"`
+	Environment    string         `json:"environment"`
 	VersionNo      int64          `json:"version_no"`
 	Entrypoint     string         `json:"entrypoint"`
 	TimeoutSeconds *int           `json:"timeout_seconds,omitempty"`
+	ParamsSchema   map[string]any `json:"params_schema,omitempty"`
+	// Command is the version command the run executes; Entrypoint,
+	// TimeoutSeconds and ParamsSchema are already resolved for it.
+	Command        string         `json:"command,omitempty"`
 	Input          map[string]any `json:"input,omitempty"`
 	AttemptID      int64          `json:"attempt_id"`
 	AttemptNo      int64          `json:"attempt_no"`
 	LeaseToken     string         `json:"lease_token"`
 	LeaseExpiresAt string         `json:"lease_expires_at"`
+	SetupScript    string         `json:"setup_script,omitempty"`
+	ArtifactSHA256 string         `json:"artifact_sha256"`
+	ImportPaths    []string       `json:"import_paths,omitempty"`
+	// PythonVersion is the interpreter version the run needs; the runner
+	// leasing it declared that version.
+	PythonVersion string `json:"python_version,omitempty"`
 }
 
 // LeaseRun attempts to lease a queued run.
@@ -166,12 +294,18 @@ func (h *Handlers) LeaseRun(w http.ResponseWriter, r *http.Request) {
 
 	runnerID, ok := runnerIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing runner context")
+		writeAPIError(w, apierror.Unauthorized, "missing runner context")
 		return
 	}
 
 	environment, _ := environmentFromContext(r.Context())
 
+	wait, err := parseLeaseWait(r.URL.Query().Get("wait"), h.maxLeaseWait())
+	if err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "wait must be a duration such as 20s")
+		return
+	}
+
 	// Get runner
 	runner := &store.Runner{
 		ID:          runnerID,
@@ -180,67 +314,213 @@ func (h *Handlers) LeaseRun(w http.ResponseWriter, r *http.Request) {
 
 	// Update liveness on every lease poll, including no-work responses.
 	if err := h.store.MarkRunnerOnline(r.Context(), runnerID); err != nil {
-		h.logger.Error("mark runner online", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "mark runner online", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
-	// Generate lease token
-	leaseToken, leaseTokenHash, err := auth.GenerateToken()
-	if err != nil {
-		h.logger.Error("generate lease token", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+	leased, err := h.leaseRunnable(r.Context(), runner, wait)
+	if errors.Is(err, errLeaseWaitElapsed) {
+		// The request was held for its whole wait, so the runner can poll
+		// again straight away instead of sleeping.
+		w.Header().Set(leaseWaitHeader, strconv.Itoa(int(h.maxLeaseWait()/time.Second)))
+		writeJSON(w, http.StatusNoContent, nil)
 		return
 	}
-
-	run, attempt, err := h.store.LeaseRun(r.Context(), runner, leaseTokenHash, h.cfg.LeaseTTL)
 	if errors.Is(err, store.ErrNoRunAvailable) {
 		writeJSON(w, http.StatusNoContent, nil)
 		return
 	}
 	if errors.Is(err, store.ErrLeaseConflict) {
-		writeError(w, http.StatusConflict, "conflict", "runner already has an active lease")
+		writeAPIError(w, apierror.LeaseConflict, "runner already has an active lease")
 		return
 	}
 	if err != nil {
-		h.logger.Error("lease run", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "lease run", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	h.metrics.RunLeased(environment)
+	run, attempt, app, version, leaseToken := leased.run, leased.attempt, leased.app, leased.version, leased.token
 
-	// Get app and version details
-	app, err := h.store.GetAppByIDDirect(r.Context(), run.AppID)
-	if err != nil || app == nil {
-		h.logger.Error("get app for lease", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
-		return
+	setupScript := ""
+	if version.SetupScript != nil {
+		setupScript = *version.SetupScript
+	}
+	pythonVersion := ""
+	if version.PythonVersion != nil {
+		pythonVersion = *version.PythonVersion
 	}
+	entrypoint, timeoutSeconds, paramsSchema := version.EntrypointFor(run.Command)
 
-	version, err := h.store.GetVersionByID(r.Context(), run.AppVersionID)
-	if err != nil || version == nil {
-		h.logger.Error("get version for lease", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
-		return
+	// The team slug only labels the runner's logs and metrics, so failing
+	// to read it does not cost the runner its lease.
+	teamSlug := ""
+	team, err := h.store.GetTeamByID(r.Context(), run.TeamID)
+	if err != nil {
+		h.logger.WarnContext(r.Context(), "get team for lease", "error", err, "run_id", run.ID)
+	} else if team != nil {
+		teamSlug = team.Slug
 	}
 
 	writeJSON(w, http.StatusOK, leaseResponse{
 		RunID:          run.ID,
 		RunNo:          run.RunNo,
+		RunTraceID:     run.TraceID,
+		TeamSlug:       teamSlug,
 		AppID:          app.ID,
 		AppSlug:        app.Slug,
+		Environment:    environment,
 		VersionNo:      version.VersionNo,
-		Entrypoint:     version.Entrypoint,
-		TimeoutSeconds: version.TimeoutSeconds,
+		Entrypoint:     entrypoint,
+		TimeoutSeconds: timeoutSeconds,
+		ParamsSchema:   paramsSchema,
+		Command:        run.Command,
 		Input:          run.Input,
 		AttemptID:      attempt.ID,
 		AttemptNo:      attempt.AttemptNo,
 		LeaseToken:     leaseToken,
-		LeaseExpiresAt: attempt.LeaseExpiresAt.Format(time.RFC3339),
+		LeaseExpiresAt: formatTime(attempt.LeaseExpiresAt),
+		SetupScript:    setupScript,
+		ArtifactSHA256: version.ArtifactSHA256,
+		ImportPaths:    version.ImportPaths,
+		PythonVersion:  pythonVersion,
 	})
 }
 
+// leasedRun is a lease ready to hand to a runner.
+type leasedRun struct {
+	run     *store.Run
+	attempt *store.RunAttempt
+	app     *store.App
+	version *store.AppVersion
+	token   string
+}
+
+// leaseRunnable leases a run for runner like leaseWithWait, along with its
+// app and version. A run whose artifact is gone from the object store could
+// only fail on the runner, so the store marks it dead with
+// DeadReasonArtifactUnavailable before creating an attempt, and the next
+// queued run is leased instead, within what is left of wait.
+func (h *Handlers) leaseRunnable(ctx context.Context, runner *store.Runner, wait time.Duration) (*leasedRun, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		leaseToken, leaseTokenHash, err := auth.GenerateToken()
+		if err != nil {
+			return nil, fmt.Errorf("generate lease token: %w", err)
+		}
+		run, attempt, err := h.leaseWithWait(ctx, runner, leaseTokenHash, max(time.Until(deadline), 0))
+		var unavailable *store.ArtifactUnavailableError
+		if errors.As(err, &unavailable) {
+			h.logger.ErrorContext(ctx, "artifact unavailable at lease", "key", unavailable.Key, "run_id", unavailable.RunID)
+			if dead, err := h.store.GetRunByIDDirect(ctx, unavailable.RunID); err == nil && dead != nil {
+				h.recordArtifactMissing(ctx, dead, "dead")
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		app, err := h.store.GetAppByIDDirect(ctx, run.AppID)
+		if err == nil && app == nil {
+			err = errors.New("app not found")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get app for lease: %w", err)
+		}
+		version, err := h.store.GetVersionByID(ctx, run.AppVersionID)
+		if err == nil && version == nil {
+			err = errors.New("version not found")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get version for lease: %w", err)
+		}
+		return &leasedRun{run: run, attempt: attempt, app: app, version: version, token: leaseToken}, nil
+	}
+}
+
+// maxLeaseWait caps how long a lease request may hold for work.
+const maxLeaseWait = 30 * time.Second
+
+// leaseWaitHeader carries the longest wait, in seconds, the server accepts.
+// It is only set on a 204 that was held for its full wait.
+const leaseWaitHeader = "X-Lease-Wait-Max"
+
+// errLeaseWaitElapsed reports that no run was queued during a lease wait.
+var errLeaseWaitElapsed = errors.New("lease wait elapsed")
+
+// maxLeaseWait returns the longest lease hold, kept within the lease TTL so a
+// waiting runner is never swept offline for looking idle.
+func (h *Handlers) maxLeaseWait() time.Duration {
+	if h.cfg.LeaseTTL > 0 {
+		return min(maxLeaseWait, h.cfg.LeaseTTL)
+	}
+	return maxLeaseWait
+}
+
+// parseLeaseWait parses the lease wait parameter, a duration or a number of
+// seconds, clamped to limit. Empty means no wait.
+func parseLeaseWait(v string, limit time.Duration) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		secs, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			return 0, err
+		}
+		d = time.Duration(secs) * time.Second
+	}
+	if d < 0 {
+		return 0, errors.New("negative wait")
+	}
+	return min(d, limit), nil
+}
+
+// leaseWithWait leases a run for runner. When none is queued it holds for up
+// to wait until the queue notifier reports a run for the runner's environment
+// and tries again, returning errLeaseWaitElapsed once the wait runs out.
+// Without a wait, or past the notifier's waiter cap, it answers immediately.
+func (h *Handlers) leaseWithWait(ctx context.Context, runner *store.Runner, leaseTokenHash string, wait time.Duration) (*store.Run, *store.RunAttempt, error) {
+	var deadline <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		// Subscribe before checking the queue so a run queued in between
+		// still wakes this request.
+		var ready <-chan struct{}
+		release := func() {}
+		if wait > 0 {
+			if ch, rel, ok := h.queue.Subscribe(runner.Environment); ok {
+				ready, release = ch, rel
+			}
+		}
+
+		run, attempt, err := h.store.LeaseRun(ctx, runner, leaseTokenHash, h.cfg.LeaseTTL)
+		if !errors.Is(err, store.ErrNoRunAvailable) || ready == nil {
+			release()
+			return run, attempt, err
+		}
+
+		select {
+		case <-ready:
+			release()
+		case <-deadline:
+			release()
+			return nil, nil, errLeaseWaitElapsed
+		case <-ctx.Done():
+			release()
+			return nil, nil, store.ErrNoRunAvailable
+		}
+	}
+}
+
 type attemptResponse struct {
 	AttemptID       int64  `json:"attempt_id"`
 	AttemptNo       int64  `json:"attempt_no"`
@@ -248,6 +528,22 @@ type attemptResponse struct {
 	LeaseExpiresAt  string `json:"lease_expires_at"`
 	CancelRequested bool   `json:"cancel_requested"`
 	RunStatus       string `json:"run_status"`
+	// CancelAckAt is when a heartbeat first reported cancel_requested for
+	// this attempt.
+	CancelAckAt *string `json:"cancel_ack_at,omitempty"`
+	// ServerTime lets runners estimate clock skew against lease_expires_at.
+	ServerTime string `json:"server_time"`
+	// TimeoutSeconds is the version's current timeout for the run's
+	// command, so runners pick up changes made after the lease. DeadlineAt
+	// is the attempt's start plus that timeout.
+	TimeoutSeconds *int    `json:"timeout_seconds,omitempty"`
+	DeadlineAt     *string `json:"deadline_at,omitempty"`
+}
+
+type startRequest struct {
+	// EnvSnapshot is the environment the runner sets for the attempt's
+	// process, without what it inherits from the runner's own.
+	EnvSnapshot map[string]string `json:"env_snapshot,omitempty"`
 }
 
 // StartRun acknowledges a lease and transitions to running.
@@ -262,14 +558,29 @@ func (h *Handlers) StartRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The body is optional; older runners send an empty start.
+	var req startRequest
+	if r.Body != nil {
+		if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+			writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
+			return
+		}
+	}
+
 	attempt, err := h.store.StartAttempt(r.Context(), attempt.ID, leaseTokenHash)
-	if writeStoreError(w, h.logger, err, "attempt is cancelling") {
+	if writeStoreError(w, r, h.logger, err, "attempt is cancelling") {
 		return
 	}
+	h.recordEnvSnapshot(r, attempt.ID, req.EnvSnapshot)
 
 	h.writeAttemptResponse(w, r, runID, attempt)
 }
 
+type heartbeatRequest struct {
+	Stats            *store.RunnerStats `json:"stats,omitempty"`
+	ClockSkewSeconds *float64           `json:"clock_skew_seconds,omitempty"`
+}
+
 // HeartbeatRun extends the lease.
 func (h *Handlers) HeartbeatRun(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -282,14 +593,52 @@ func (h *Handlers) HeartbeatRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The body is optional; older runners send an empty heartbeat.
+	var req heartbeatRequest
+	if r.Body != nil {
+		if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+			writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
+			return
+		}
+	}
+
 	attempt, err := h.store.ExtendLease(r.Context(), attempt.ID, leaseTokenHash, h.cfg.LeaseTTL)
-	if writeStoreError(w, h.logger, err, "extend lease") {
+	if writeStoreError(w, r, h.logger, err, "extend lease") {
 		return
 	}
 
+	// Stats are best-effort: a failed write must not cost the runner its lease.
+	if req.Stats != nil {
+		if err := h.store.UpdateRunnerStats(r.Context(), attempt.RunnerID, req.Stats); err != nil {
+			h.logger.ErrorContext(r.Context(), "update runner stats", "error", err, "runner_id", attempt.RunnerID)
+		}
+	}
+	if req.ClockSkewSeconds != nil {
+		h.recordClockSkew(r, attempt.RunnerID, *req.ClockSkewSeconds)
+	}
+
 	h.writeAttemptResponse(w, r, runID, attempt)
 }
 
+// recordClockSkew exports the runner's measured clock offset. It is
+// best-effort like stats: lookup failures are logged and ignored.
+func (h *Handlers) recordClockSkew(r *http.Request, runnerID int64, seconds float64) {
+	runner, err := h.store.GetRunnerByID(r.Context(), runnerID)
+	if err != nil || runner == nil {
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "get runner for clock skew", "error", err, "runner_id", runnerID)
+		}
+		return
+	}
+	h.metrics.RunnerClockSkew(runner.Name, seconds)
+}
+
+// statusResponse acknowledges a runner call that has nothing else to
+// return.
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
 type logBatchRequest struct {
 	Logs []logEntryRequest `json:"logs"`
 }
@@ -301,6 +650,45 @@ type logEntryRequest struct {
 	LoggedAt string `json:"logged_at"`
 }
 
+// toStore validates a submitted log entry and converts it to store format.
+// An unparseable logged_at is replaced by the current time.
+func (l logEntryRequest) toStore() (store.LogEntry, error) {
+	if l.Stream != "stdout" && l.Stream != "stderr" {
+		return store.LogEntry{}, errors.New("stream must be stdout or stderr")
+	}
+	if len(l.Line) > maxLogLineBytes {
+		return store.LogEntry{}, errors.New("log line exceeds 8KB")
+	}
+	loggedAt, err := parseTime(l.LoggedAt)
+	if err != nil {
+		loggedAt = time.Now()
+	}
+	return store.LogEntry{
+		Seq:      l.Seq,
+		Stream:   l.Stream,
+		Line:     l.Line,
+		LoggedAt: loggedAt,
+	}, nil
+}
+
+// checkLogQuota writes a log_quota_exceeded error and returns false if n
+// more lines would take the attempt past the configured log quota.
+func (h *Handlers) checkLogQuota(w http.ResponseWriter, r *http.Request, attemptID int64, n int) bool {
+	quota := h.cfg.LogQuotaPerAttempt
+	if quota <= 0 {
+		return true
+	}
+	count, err := h.store.CountAttemptLogs(r.Context(), attemptID)
+	if writeStoreError(w, r, h.logger, err, "count attempt logs") {
+		return false
+	}
+	if count+n > quota {
+		writeAPIError(w, apierror.LogQuotaExceeded, "attempt already has %d of %d log lines", count, quota)
+		return false
+	}
+	return true
+}
+
 // SubmitLogs submits a batch of log entries.
 func (h *Handlers) SubmitLogs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -315,56 +703,200 @@ func (h *Handlers) SubmitLogs(w http.ResponseWriter, r *http.Request) {
 
 	var req logBatchRequest
 	if err := decodeJSON(r, &req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
 		return
 	}
 
 	if len(req.Logs) == 0 {
-		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		writeJSON(w, http.StatusOK, statusResponse{Status: "ok"})
 		return
 	}
 
 	if len(req.Logs) > 100 {
-		writeError(w, http.StatusBadRequest, "invalid_request", "max 100 logs per batch")
+		writeAPIError(w, apierror.InvalidRequest, "max 100 logs per batch")
 		return
 	}
 
 	// Convert to store format
 	logs := make([]store.LogEntry, 0, len(req.Logs))
 	for _, l := range req.Logs {
-		if l.Stream != "stdout" && l.Stream != "stderr" {
-			writeError(w, http.StatusBadRequest, "invalid_request", "stream must be stdout or stderr")
+		entry, err := l.toStore()
+		if err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "%s", err.Error())
 			return
 		}
-		if len(l.Line) > 8192 {
-			writeError(w, http.StatusBadRequest, "invalid_request", "log line exceeds 8KB")
+		logs = append(logs, entry)
+	}
+
+	if !h.checkLogQuota(w, r, attempt.ID, len(logs)) {
+		return
+	}
+
+	if err := h.store.AppendLogs(r.Context(), attempt.ID, logs); err != nil {
+		h.logger.ErrorContext(r.Context(), "append logs", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, statusResponse{Status: "ok"})
+}
+
+const (
+	// logStreamBatchSize is the most streamed log lines StreamLogs inserts
+	// per transaction. The lease is checked again before each.
+	logStreamBatchSize = 100
+	// logStreamFlushInterval is how long StreamLogs holds lines that keep
+	// arriving before storing them, so they can be read while the runner
+	// is still writing.
+	logStreamFlushInterval = time.Second
+	// logStreamIdleTimeout is how long StreamLogs waits for the next line
+	// before giving up on the stream.
+	logStreamIdleTimeout = time.Minute
+	// maxLogStreamEntryBytes bounds one NDJSON entry: a full log line,
+	// JSON-escaped, plus the other fields.
+	maxLogStreamEntryBytes = 6*maxLogLineBytes + 1024
+)
+
+// errLogStreamAborted ends the read of a log stream whose flush has already
+// answered the request with an error.
+var errLogStreamAborted = errors.New("log stream aborted")
+
+// drainedReader calls onDrained before each read of r. A bufio.Scanner only
+// reads once it has returned every complete line it buffered, so over a
+// request body onDrained runs whenever the scanner may wait on the runner.
+type drainedReader struct {
+	r         io.Reader
+	onDrained func() error
+}
+
+func (d *drainedReader) Read(p []byte) (int, error) {
+	if err := d.onDrained(); err != nil {
+		return 0, err
+	}
+	return d.r.Read(p)
+}
+
+type logStreamResponse struct {
+	// Accepted counts the lines stored; Deduped the lines ignored because
+	// the attempt already had their seq.
+	Accepted int `json:"accepted"`
+	Deduped  int `json:"deduped"`
+}
+
+// StreamLogs accepts newline-delimited JSON log entries on a long-lived
+// request body, storing them in small transactions as they arrive, and
+// answers with a summary once the runner closes the stream. Lines are
+// stored once the body has nothing more buffered, or after
+// logStreamFlushInterval while it keeps coming, so readers see them without
+// waiting for the stream to end. Entries are validated as in SubmitLogs. A
+// lease that goes stale mid-stream ends the request with 410.
+func (h *Handlers) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	runID, attempt, leaseTokenHash, ok := h.requireLeaseContext(w, r, extractRunIDFromPath)
+	if !ok {
+		return
+	}
+	runnerID, _ := runnerIDFromContext(r.Context())
+
+	// The stream outlives the server's read and write timeouts, so they
+	// are pushed back as lines arrive; and an error answered mid-stream
+	// must not wait for the rest of the body. Writers that do not support
+	// this (tests' recorders) need neither.
+	rc := http.NewResponseController(w)
+	_ = rc.EnableFullDuplex()
+
+	var (
+		resp      logStreamResponse
+		batch     = make([]store.LogEntry, 0, logStreamBatchSize)
+		lastFlush = time.Now()
+	)
+	flush := func() bool {
+		lastFlush = time.Now()
+		if len(batch) == 0 {
+			return true
+		}
+		if _, err := h.store.GetActiveAttempt(r.Context(), runID, runnerID, leaseTokenHash); writeStoreError(w, r, h.logger, err, "get active attempt") {
+			return false
+		}
+		if !h.checkLogQuota(w, r, attempt.ID, len(batch)) {
+			return false
+		}
+		inserted, err := h.store.AppendLogsCounted(r.Context(), attempt.ID, batch)
+		if writeStoreError(w, r, h.logger, err, "append logs") {
+			return false
+		}
+		resp.Accepted += inserted
+		resp.Deduped += len(batch) - inserted
+		batch = batch[:0]
+		return true
+	}
+
+	body := &drainedReader{r: r.Body, onDrained: func() error {
+		if !flush() {
+			return errLogStreamAborted
+		}
+		return nil
+	}}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogStreamEntryBytes)
+	for {
+		_ = rc.SetReadDeadline(time.Now().Add(logStreamIdleTimeout))
+		if !scanner.Scan() {
+			break
+		}
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var l logEntryRequest
+		if err := json.Unmarshal(line, &l); err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "invalid JSON log entry")
 			return
 		}
-		loggedAt, err := time.Parse(time.RFC3339, l.LoggedAt)
+		entry, err := l.toStore()
 		if err != nil {
-			loggedAt = time.Now()
+			writeAPIError(w, apierror.InvalidRequest, "%s", err.Error())
+			return
+		}
+		batch = append(batch, entry)
+		if len(batch) == logStreamBatchSize || time.Since(lastFlush) >= logStreamFlushInterval {
+			if !flush() {
+				return
+			}
 		}
-		logs = append(logs, store.LogEntry{
-			Seq:      l.Seq,
-			Stream:   l.Stream,
-			Line:     l.Line,
-			LoggedAt: loggedAt,
-		})
 	}
-
-	if err := h.store.AppendLogs(r.Context(), attempt.ID, logs); err != nil {
-		h.logger.Error("append logs", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+	if errors.Is(scanner.Err(), errLogStreamAborted) {
+		return
+	}
+	// Lines read before a broken stream are still stored; the runner
+	// sends them again anyway, and repeats are ignored.
+	if !flush() {
+		return
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			writeAPIError(w, apierror.InvalidRequest, "log entry exceeds %d bytes", maxLogStreamEntryBytes)
+			return
+		}
+		h.logger.WarnContext(r.Context(), "read log stream", "run_id", runID, "error", err)
+		writeAPIError(w, apierror.InvalidRequest, "log stream ended early")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	_ = rc.SetWriteDeadline(time.Now().Add(logStreamIdleTimeout))
+	writeJSON(w, http.StatusOK, resp)
 }
 
 type resultRequest struct {
 	Status       string  `json:"status"`
 	ExitCode     *int    `json:"exit_code"`
 	ErrorMessage *string `json:"error_message"`
+	// FailureKind classifies a failed result; older runners send none.
+	FailureKind string `json:"failure_kind"`
 }
 
 // SubmitResult submits the final result of a run.
@@ -381,7 +913,7 @@ func (h *Handlers) SubmitResult(w http.ResponseWriter, r *http.Request) {
 
 	var req resultRequest
 	if err := decodeJSON(r, &req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
 		return
 	}
 
@@ -392,12 +924,22 @@ func (h *Handlers) SubmitResult(w http.ResponseWriter, r *http.Request) {
 		"cancelled": true,
 	}
 	if !validStatuses[req.Status] {
-		writeError(w, http.StatusBadRequest, "invalid_request", "status must be completed, failed, or cancelled")
+		writeAPIError(w, apierror.InvalidRequest, "status must be completed, failed, or cancelled")
 		return
 	}
+	if req.FailureKind != "" {
+		if !store.ValidFailureKind(req.FailureKind) {
+			writeAPIError(w, apierror.InvalidRequest, "failure_kind must be infrastructure or user")
+			return
+		}
+		if req.Status != "failed" {
+			writeAPIError(w, apierror.InvalidRequest, "failure_kind is only allowed with status failed")
+			return
+		}
+	}
 
-	err := h.store.CompleteAttempt(r.Context(), attempt.ID, leaseTokenHash, req.Status, req.ExitCode, req.ErrorMessage)
-	if writeStoreError(w, h.logger, err, "result conflicts with attempt state") {
+	err := h.store.CompleteAttempt(r.Context(), attempt.ID, leaseTokenHash, req.Status, req.ExitCode, req.ErrorMessage, req.FailureKind)
+	if writeStoreError(w, r, h.logger, err, "result conflicts with attempt state") {
 		return
 	}
 
@@ -405,7 +947,11 @@ func (h *Handlers) SubmitResult(w http.ResponseWriter, r *http.Request) {
 	if run, lookupErr := h.store.GetRunByIDDirect(r.Context(), runID); lookupErr == nil && run != nil {
 		if app, appErr := h.store.GetAppByIDDirect(r.Context(), run.AppID); appErr == nil && app != nil {
 			if team, teamErr := h.store.GetTeamByID(r.Context(), run.TeamID); teamErr == nil && team != nil {
-				h.metrics.RunCompleted(team.Slug, app.Slug, req.Status)
+				if req.Status == "failed" {
+					h.metrics.RunFailed(team.Slug, app.Slug, req.FailureKind)
+				} else {
+					h.metrics.RunCompleted(team.Slug, app.Slug, req.Status)
+				}
 				if run.StartedAt != nil && run.FinishedAt != nil {
 					h.metrics.ObserveQueueWait(team.Slug, app.Slug, run.StartedAt.Sub(run.QueuedAt).Seconds())
 					h.metrics.ObserveExecution(team.Slug, app.Slug, req.Status, run.FinishedAt.Sub(*run.StartedAt).Seconds())
@@ -415,7 +961,7 @@ func (h *Handlers) SubmitResult(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	writeJSON(w, http.StatusOK, statusResponse{Status: "ok"})
 }
 
 // GetArtifact streams the version artifact for a run.
@@ -425,7 +971,7 @@ func (h *Handlers) GetArtifact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	runID, _, _, ok := h.requireLeaseContext(w, r, extractRunIDFromArtifactPath)
+	runID, attempt, leaseTokenHash, ok := h.requireLeaseContext(w, r, extractRunIDFromArtifactPath)
 	if !ok {
 		return
 	}
@@ -433,32 +979,40 @@ func (h *Handlers) GetArtifact(w http.ResponseWriter, r *http.Request) {
 	// Get run and version
 	run, err := h.store.GetRunByIDDirect(r.Context(), runID)
 	if err != nil || run == nil {
-		h.logger.Error("get run for artifact", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get run for artifact", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	version, err := h.store.GetVersionByID(r.Context(), run.AppVersionID)
 	if err != nil || version == nil {
-		h.logger.Error("get version for artifact", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get version for artifact", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	// Load artifact
 	reader, err := h.objects.Load(version.ArtifactObjectKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Every retry would fail the same way, so the run is marked dead
+		// now rather than spending its attempts.
+		h.logger.ErrorContext(r.Context(), "artifact unavailable", "error", err, "key", version.ArtifactObjectKey, "run_id", runID)
+		h.markArtifactUnavailable(w, r, run, attempt.ID, leaseTokenHash)
+		return
+	}
 	if err != nil {
-		h.logger.Error("load artifact", "error", err, "key", version.ArtifactObjectKey)
-		writeError(w, http.StatusInternalServerError, "internal", "artifact not found")
+		h.logger.ErrorContext(r.Context(), "load artifact", "error", err, "key", version.ArtifactObjectKey)
+		writeAPIError(w, apierror.Internal, "artifact not found")
 		return
 	}
 	defer reader.Close()
 
 	w.Header().Set("Content-Type", "application/gzip")
 	w.Header().Set("X-Artifact-SHA256", version.ArtifactSHA256)
-	w.Header().Set("X-Entrypoint", version.Entrypoint)
-	if version.TimeoutSeconds != nil {
-		w.Header().Set("X-Timeout-Seconds", strconv.Itoa(*version.TimeoutSeconds))
+	entrypoint, timeoutSeconds, _ := version.EntrypointFor(run.Command)
+	w.Header().Set("X-Entrypoint", entrypoint)
+	if timeoutSeconds != nil {
+		w.Header().Set("X-Timeout-Seconds", strconv.Itoa(*timeoutSeconds))
 	}
 	if len(version.ImportPaths) > 0 {
 		if data, err := json.Marshal(version.ImportPaths); err == nil {
@@ -469,6 +1023,47 @@ func (h *Handlers) GetArtifact(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, reader)
 }
 
+// markArtifactUnavailable ends the attempt of a run whose artifact is gone
+// and marks the run dead with DeadReasonArtifactUnavailable. The runner is
+// told its attempt is no longer active so it stops without a result.
+func (h *Handlers) markArtifactUnavailable(w http.ResponseWriter, r *http.Request, run *store.Run, attemptID int64, leaseTokenHash string) {
+	status, err := h.endRunForMissingArtifact(r.Context(), run, attemptID, leaseTokenHash)
+	if writeStoreError(w, r, h.logger, err, "mark run dead for missing artifact") {
+		return
+	}
+	writeAPIError(w, apierror.AttemptNotActive, "artifact unavailable; run marked %s", status)
+}
+
+// endRunForMissingArtifact fails the attempt, marks the run dead with
+// DeadReasonArtifactUnavailable (or cancelled, if that was requested) and
+// records the metrics. Returns the run's new status.
+func (h *Handlers) endRunForMissingArtifact(ctx context.Context, run *store.Run, attemptID int64, leaseTokenHash string) (string, error) {
+	status, err := h.store.MarkAttemptDead(ctx, attemptID, leaseTokenHash, store.DeadReasonArtifactUnavailable, "artifact unavailable")
+	if err != nil {
+		return "", err
+	}
+	h.recordArtifactMissing(ctx, run, status)
+	return status, nil
+}
+
+// recordArtifactMissing records the metrics for a run ended with the given
+// status because its artifact was missing.
+func (h *Handlers) recordArtifactMissing(ctx context.Context, run *store.Run, status string) {
+	teamSlug, appSlug := "", ""
+	if team, _ := h.store.GetTeamByID(ctx, run.TeamID); team != nil {
+		teamSlug = team.Slug
+	}
+	if app, _ := h.store.GetAppByIDDirect(ctx, run.AppID); app != nil {
+		appSlug = app.Slug
+	}
+	h.metrics.ArtifactMissing(appSlug)
+	if status == "dead" {
+		h.metrics.RunDead(teamSlug, appSlug, store.DeadReasonArtifactUnavailable)
+	} else {
+		h.metrics.RunCompleted(teamSlug, appSlug, status)
+	}
+}
+
 // extractRunIDFromArtifactPath extracts run ID from /api/v1/runs/{run}/artifact
 func extractRunIDFromArtifactPath(path string) int64 {
 	const prefix = "/api/v1/runs/"
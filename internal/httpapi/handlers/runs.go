@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -9,32 +10,136 @@ import (
 	"strings"
 	"time"
 
+	"minitower/internal/apierror"
+	"minitower/internal/auth"
+	"minitower/internal/httputil"
+	"minitower/internal/logretention"
 	"minitower/internal/store"
 	"minitower/internal/validate"
 )
 
 type createRunRequest struct {
-	Input      map[string]any `json:"input"`
-	VersionNo  *int64         `json:"version_no"`
-	Priority   *int           `json:"priority"`
-	MaxRetries *int           `json:"max_retries"`
+	Input     map[string]any `json:"input"`
+	VersionNo *int64         `json:"version_no"`
+	// VersionLabel names the version by label instead of number; it is
+	// resolved when the run is created.
+	VersionLabel string `json:"version_label"`
+	Priority     *int   `json:"priority"`
+	// MaxRetries defaults to the version's Towerfile [app.retries] max.
+	MaxRetries *int `json:"max_retries"`
+	// AtMostOnce defaults to the version's Towerfile app.at_most_once.
+	AtMostOnce *bool `json:"at_most_once"`
+	// Command names one of the version's Towerfile commands to run instead
+	// of its entrypoint.
+	Command string `json:"command"`
+	DryRun  bool   `json:"dry_run"`
+	// WithStatusToken issues a read-only token for GET
+	// /api/v1/run-status/{status_token}.
+	WithStatusToken bool `json:"with_status_token"`
+}
+
+const (
+	// maxRunPriority bounds run priority in both directions.
+	maxRunPriority = 1000
+	// maxRunRetries caps max_retries on run creation.
+	maxRunRetries = 10
+)
+
+// runMaxRetries returns a new run's max_retries: the requested value, or
+// else the version's Towerfile default, clamped to 0..maxRunRetries.
+func runMaxRetries(requested *int, version *store.AppVersion) int {
+	maxRetries := 0
+	switch {
+	case requested != nil:
+		maxRetries = *requested
+	case version.MaxRetries != nil:
+		maxRetries = *version.MaxRetries
+	}
+	return min(max(maxRetries, 0), maxRunRetries)
+}
+
+// retriesRemaining is how many retries a run has left.
+func retriesRemaining(maxRetries, retryCount int) int {
+	return max(maxRetries-retryCount, 0)
+}
+
+// dryRunResponse is the would-be run returned by a dry-run create. It has
+// no run_id because nothing is inserted.
+type dryRunResponse struct {
+	AppID       int64          `json:"app_id"`
+	AppSlug     string         `json:"app_slug"`
+	VersionNo   int64          `json:"version_no"`
+	Environment string         `json:"environment"`
+	Status      string         `json:"status"`
+	Input       map[string]any `json:"input,omitempty"`
+	Priority    int            `json:"priority"`
+	MaxRetries  int            `json:"max_retries"`
+	AtMostOnce  bool           `json:"at_most_once"`
+	Command     string         `json:"command,omitempty"`
+	// CoercedFields lists the input values converted by coerce=true.
+	CoercedFields []string `json:"coerced_fields,omitempty"`
+}
+
+type setRunPriorityRequest struct {
+	Priority *int `json:"priority"`
 }
 
 type runResponse struct {
-	RunID           int64          `json:"run_id"`
-	AppID           int64          `json:"app_id"`
-	AppSlug         string         `json:"app_slug,omitempty"`
-	RunNo           int64          `json:"run_no"`
-	VersionNo       int64          `json:"version_no"`
-	Status          string         `json:"status"`
-	Input           map[string]any `json:"input,omitempty"`
-	Priority        int            `json:"priority"`
-	MaxRetries      int            `json:"max_retries"`
-	RetryCount      int            `json:"retry_count"`
-	CancelRequested bool           `json:"cancel_requested"`
-	QueuedAt        string         `json:"queued_at"`
-	StartedAt       *string        `json:"started_at,omitempty"`
-	FinishedAt      *string        `json:"finished_at,omitempty"`
+	RunID      int64          `json:"run_id"`
+	AppID      int64          `json:"app_id"`
+	AppSlug    string         `json:"app_slug,omitempty"`
+	RunNo      int64          `json:"run_no"`
+	VersionNo  int64          `json:"version_no"`
+	Entrypoint string         `json:"entrypoint,omitempty"`
+	Status     string         `json:"status"`
+	DeadReason string         `json:"dead_reason,omitempty"`
+	Input      map[string]any `json:"input,omitempty"`
+	Priority   int            `json:"priority"`
+	MaxRetries int            `json:"max_retries"`
+	RetryCount int            `json:"retry_count"`
+	// RetriesRemaining is how many more attempts the run may be retried
+	// with: max_retries less retry_count.
+	RetriesRemaining int     `json:"retries_remaining"`
+	FailureKind      string  `json:"failure_kind,omitempty"`
+	CancelRequested  bool    `json:"cancel_requested"`
+	AtMostOnce       bool    `json:"at_most_once"`
+	Command          string  `json:"command,omitempty"`
+	RunTraceID       string  `json:"run_trace_id"`
+	BatchID          string  `json:"batch_id,omitempty"`
+	QueuedAt         string  `json:"queued_at"`
+	StartedAt        *string `json:"started_at,omitempty"`
+	FinishedAt       *string `json:"finished_at,omitempty"`
+	// AttemptNo, ExitCode and CancelAckAt describe the latest attempt;
+	// GetRun only. CancelAckAt is when a heartbeat first told its runner
+	// about the cancellation.
+	AttemptNo   int64   `json:"attempt_no,omitempty"`
+	ExitCode    *int    `json:"exit_code,omitempty"`
+	CancelAckAt *string `json:"cancel_ack_at,omitempty"`
+	// Environment names the run's environment; GetRun only.
+	Environment string `json:"environment,omitempty"`
+	// RerunOfRunID is the run this one was rerun from.
+	RerunOfRunID *int64 `json:"rerun_of_run_id,omitempty"`
+	// EffectivePriority is the aged priority the lease queue orders a
+	// queued run by; GetRun only.
+	EffectivePriority *int `json:"effective_priority,omitempty"`
+	// CoercedFields lists the input values converted by coerce=true;
+	// CreateRun only.
+	CoercedFields []string `json:"coerced_fields,omitempty"`
+	// TeamActiveRuns is the team's queued and active runs, this one
+	// included, so clients can throttle before reaching the quota;
+	// CreateRun only.
+	TeamActiveRuns int64 `json:"team_active_runs,omitempty"`
+	// StatusToken reads only this run's status without an API token;
+	// CreateRun with with_status_token only. It is never returned again.
+	StatusToken string `json:"status_token,omitempty"`
+	// RunnerName names the runner of the latest attempt; run lists with
+	// include=runner only.
+	RunnerName string `json:"runner_name,omitempty"`
+	// TeamSlug names the run's team; the admin run list only.
+	TeamSlug string `json:"team_slug,omitempty"`
+	// InputOmitted is set in run lists without include=input for a run
+	// whose input is stored compressed; input is left out then.
+	InputOmitted bool `json:"input_omitted,omitempty"`
 }
 
 type listRunsResponse struct {
@@ -57,6 +162,8 @@ type runLogEntry struct {
 
 type runLogsResponse struct {
 	Logs []runLogEntry `json:"logs"`
+	// Archived is set when the logs were served from the retention archive.
+	Archived bool `json:"archived,omitempty"`
 }
 
 // CreateRun creates a new run for an app.
@@ -68,24 +175,32 @@ func (h *Handlers) CreateRun(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
 	slug := extractAppSlugFromRunPath(r.URL.Path)
 	if slug == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "missing app slug")
+		writeAPIError(w, apierror.InvalidRequest, "missing app slug")
 		return
 	}
 
 	app, err := h.store.GetAppBySlug(r.Context(), teamID, slug)
 	if err != nil {
-		h.logger.Error("get app", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if app == nil {
-		writeError(w, http.StatusNotFound, "not_found", "app not found")
+		writeAPIError(w, apierror.NotFound, "app not found")
+		return
+	}
+	if !requireScope(w, r, ScopeRunsCreate, app.ID) {
+		return
+	}
+
+	if app.Disabled {
+		writeAPIError(w, apierror.AppDisabled, "app is disabled")
 		return
 	}
 
@@ -94,90 +209,241 @@ func (h *Handlers) CreateRun(w http.ResponseWriter, r *http.Request) {
 		if errors.Is(err, io.EOF) {
 			req = createRunRequest{}
 		} else {
-			writeError(w, http.StatusBadRequest, "invalid_request", "malformed JSON body")
+			writeAPIError(w, apierror.InvalidRequest, "malformed JSON body")
 			return
 		}
 	}
-
-	// Get version to run
-	var version *store.AppVersion
-	if req.VersionNo != nil {
-		v, err := h.store.GetVersionByNumber(r.Context(), app.ID, *req.VersionNo)
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
 		if err != nil {
-			h.logger.Error("get version", "error", err)
-			writeError(w, http.StatusInternalServerError, "internal", "internal error")
-			return
-		}
-		if v == nil {
-			writeError(w, http.StatusNotFound, "not_found", "version not found")
+			writeAPIError(w, apierror.InvalidRequest, "dry_run must be a boolean")
 			return
 		}
-		version = v
-	} else {
-		// Use latest version
-		v, err := h.store.GetLatestVersion(r.Context(), app.ID)
+		req.DryRun = req.DryRun || dryRun
+	}
+	coerce := false
+	if v := r.URL.Query().Get("coerce"); v != "" {
+		coerce, err = strconv.ParseBool(v)
 		if err != nil {
-			h.logger.Error("get latest version", "error", err)
-			writeError(w, http.StatusInternalServerError, "internal", "internal error")
-			return
-		}
-		if v == nil {
-			writeError(w, http.StatusBadRequest, "no_version", "app has no versions")
+			writeAPIError(w, apierror.InvalidRequest, "coerce must be a boolean")
 			return
 		}
-		version = v
 	}
 
-	if version.ParamsSchema != nil {
-		if err := validate.ValidateJSONInput(req.Input, version.ParamsSchema); err != nil {
-			writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("input does not match schema: %s", err.Error()))
+	version, ok := h.resolveRunVersion(w, r, app.ID, req.VersionNo, req.VersionLabel)
+	if !ok {
+		return
+	}
+	if req.Command != "" && version.Command(req.Command) == nil {
+		writeAPIError(w, apierror.InvalidRequest, "version %d has no command %q", version.VersionNo, req.Command)
+		return
+	}
+	_, _, paramsSchema := version.EntrypointFor(req.Command)
+
+	// Defaults are merged before validation, and the merged input is what
+	// the run stores, so later changes to the defaults do not alter it.
+	req.Input = store.MergeDefaultInput(app.DefaultInput, req.Input)
+
+	// Coercion runs on the merged input, so string defaults are converted
+	// too, and the converted values are what the run stores.
+	var coerced []string
+	if coerce && req.Input != nil {
+		_, coerced = validate.CoerceJSONInput(req.Input, paramsSchema)
+	}
+
+	if paramsSchema != nil {
+		if err := validate.ValidateJSONInput(req.Input, paramsSchema); err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "input does not match schema: %s", err.Error())
 			return
 		}
 	}
 
+	priority := 0
+	if req.Priority != nil {
+		priority = clampRunPriority(*req.Priority)
+	}
+
+	maxRetries := runMaxRetries(req.MaxRetries, version)
+
+	atMostOnce := version.AtMostOnce
+	if req.AtMostOnce != nil {
+		atMostOnce = *req.AtMostOnce
+	}
+
+	// A dry run stops after validation: nothing is inserted and it is not
+	// counted as a created run.
+	if req.DryRun {
+		writeJSON(w, http.StatusOK, dryRunResponse{
+			AppID:         app.ID,
+			AppSlug:       app.Slug,
+			VersionNo:     version.VersionNo,
+			Environment:   "default",
+			Status:        "validated",
+			Input:         req.Input,
+			Priority:      priority,
+			MaxRetries:    maxRetries,
+			AtMostOnce:    atMostOnce,
+			Command:       req.Command,
+			CoercedFields: coerced,
+		})
+		return
+	}
+
 	// Get or create default environment
 	env, err := h.store.GetOrCreateDefaultEnvironment(r.Context(), teamID)
 	if err != nil {
-		h.logger.Error("get default environment", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get default environment", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
-	priority := 0
-	if req.Priority != nil {
-		priority = *req.Priority
+	var statusToken, statusTokenHash string
+	if req.WithStatusToken {
+		statusToken, statusTokenHash, err = auth.GeneratePrefixedToken(auth.PrefixStatusToken)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "generate status token", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
+			return
+		}
 	}
 
-	maxRetries := 0
-	if req.MaxRetries != nil {
-		maxRetries = *req.MaxRetries
+	run, err := h.store.CreateCommandRunWithStatusToken(r.Context(), teamID, app.ID, env.ID, version.ID, req.Command, req.Input, priority, maxRetries, atMostOnce, statusTokenHash)
+	if writeQuotaError(w, err) || writeInputTooLarge(w, err) {
+		return
 	}
-
-	run, err := h.store.CreateRun(r.Context(), teamID, app.ID, env.ID, version.ID, req.Input, priority, maxRetries)
 	if err != nil {
-		h.logger.Error("create run", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "create run", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
+	h.queue.Notify(env.Name)
+
 	teamSlug, _ := teamSlugFromContext(r.Context())
 	h.metrics.RunCreated(teamSlug, slug)
+	h.audit(r, AuditRunCreate, "run", run.ID, map[string]any{"app": app.Slug, "version_no": version.VersionNo, "run_no": run.RunNo})
 
 	writeJSON(w, http.StatusCreated, runResponse{
-		RunID:           run.ID,
-		AppID:           run.AppID,
-		RunNo:           run.RunNo,
-		VersionNo:       version.VersionNo,
-		Status:          run.Status,
-		Input:           run.Input,
-		Priority:        run.Priority,
-		MaxRetries:      run.MaxRetries,
-		RetryCount:      run.RetryCount,
-		CancelRequested: run.CancelRequested,
-		QueuedAt:        run.QueuedAt.Format(time.RFC3339),
+		RunID:            run.ID,
+		AppID:            run.AppID,
+		RunNo:            run.RunNo,
+		VersionNo:        version.VersionNo,
+		Status:           run.Status,
+		DeadReason:       run.DeadReason,
+		Input:            run.Input,
+		Priority:         run.Priority,
+		MaxRetries:       run.MaxRetries,
+		RetryCount:       run.RetryCount,
+		RetriesRemaining: retriesRemaining(run.MaxRetries, run.RetryCount),
+		FailureKind:      run.FailureKind,
+		CancelRequested:  run.CancelRequested,
+		AtMostOnce:       run.AtMostOnce,
+		Command:          run.Command,
+		RunTraceID:       run.TraceID,
+		BatchID:          run.BatchID,
+		QueuedAt:         formatTime(run.QueuedAt),
+		CoercedFields:    coerced,
+		TeamActiveRuns:   run.TeamActiveRuns,
+		StatusToken:      statusToken,
 	})
 }
 
+// writeQuotaError writes the 429 for a *store.QuotaExceededError and reports
+// whether err was one.
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	var quotaErr *store.QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	httputil.WriteErrorLimit(w, apierror.QuotaExceeded.Status(), string(apierror.QuotaExceeded),
+		fmt.Sprintf("team has %d queued or active runs, its quota is %d", quotaErr.Active, quotaErr.Limit), quotaErr.Active, quotaErr.Limit)
+	return true
+}
+
+// writeInputTooLarge writes the 413 for a *store.InputTooLargeError and
+// reports whether err was one.
+func writeInputTooLarge(w http.ResponseWriter, err error) bool {
+	var sizeErr *store.InputTooLargeError
+	if !errors.As(err, &sizeErr) {
+		return false
+	}
+	httputil.WriteErrorLimit(w, apierror.InputTooLarge.Status(), string(apierror.InputTooLarge),
+		sizeErr.Error(), int64(sizeErr.Size), int64(sizeErr.Limit))
+	return true
+}
+
+// resolveRunVersion returns the requested version of an app, by number or
+// label, or its latest version when neither is given. It writes the error
+// response and returns false when there is no such version or runs cannot
+// be created from it.
+func (h *Handlers) resolveRunVersion(w http.ResponseWriter, r *http.Request, appID int64, versionNo *int64, label string) (*store.AppVersion, bool) {
+	v, ok := h.lookupRunVersion(w, r, appID, versionNo, label)
+	if !ok || !runnableVersion(w, v) {
+		return nil, false
+	}
+	return v, true
+}
+
+// runnableVersion writes a 409 artifact_corrupt and returns false when
+// object verification flagged v's artifact.
+func runnableVersion(w http.ResponseWriter, v *store.AppVersion) bool {
+	if v.ArtifactCorrupt {
+		writeAPIError(w, apierror.ArtifactCorrupt, "version %d's artifact failed verification; deploy a new version or restore the object and verify again", v.VersionNo)
+		return false
+	}
+	return true
+}
+
+func (h *Handlers) lookupRunVersion(w http.ResponseWriter, r *http.Request, appID int64, versionNo *int64, label string) (*store.AppVersion, bool) {
+	if versionNo != nil && label != "" {
+		writeAPIError(w, apierror.InvalidRequest, "version_no and version_label are mutually exclusive")
+		return nil, false
+	}
+	if label != "" {
+		v, err := h.store.GetVersionByLabel(r.Context(), appID, label)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "get version by label", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
+			return nil, false
+		}
+		if v == nil {
+			writeAPIError(w, apierror.NotFound, "version label %q not found", label)
+			return nil, false
+		}
+		return v, true
+	}
+	if versionNo != nil {
+		v, err := h.store.GetVersionByNumber(r.Context(), appID, *versionNo)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "get version", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
+			return nil, false
+		}
+		if v == nil {
+			writeAPIError(w, apierror.NotFound, "version not found")
+			return nil, false
+		}
+		return v, true
+	}
+
+	v, err := h.store.GetLatestVersion(r.Context(), appID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get latest version", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return nil, false
+	}
+	if v == nil {
+		writeAPIError(w, apierror.NoVersion, "app has no versions")
+		return nil, false
+	}
+	return v, true
+}
+
+// clampRunPriority bounds a requested priority to ±maxRunPriority.
+func clampRunPriority(p int) int {
+	return min(max(p, -maxRunPriority), maxRunPriority)
+}
+
 // ListRuns returns all runs for an app.
 func (h *Handlers) ListRuns(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -187,24 +453,27 @@ func (h *Handlers) ListRuns(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
 	slug := extractAppSlugFromRunPath(r.URL.Path)
 	if slug == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "missing app slug")
+		writeAPIError(w, apierror.InvalidRequest, "missing app slug")
 		return
 	}
 
 	app, err := h.store.GetAppBySlug(r.Context(), teamID, slug)
 	if err != nil {
-		h.logger.Error("get app", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if app == nil {
-		writeError(w, http.StatusNotFound, "not_found", "app not found")
+		writeAPIError(w, apierror.NotFound, "app not found")
+		return
+	}
+	if !requireScope(w, r, ScopeRunsRead, app.ID) {
 		return
 	}
 
@@ -221,34 +490,60 @@ func (h *Handlers) ListRuns(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	runs, err := h.store.ListRunsByApp(r.Context(), teamID, app.ID, limit, offset)
+	versionNo, err := parseVersionNoFilter(r)
+	if err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "%s", err.Error())
+		return
+	}
+	includeInput := false
+	if include := strings.TrimSpace(r.URL.Query().Get("include")); include != "" {
+		for _, part := range strings.Split(include, ",") {
+			if strings.TrimSpace(part) != "input" {
+				writeAPIError(w, apierror.InvalidRequest, "invalid include: %s", strings.TrimSpace(part))
+				return
+			}
+			includeInput = true
+		}
+	}
+
+	runs, err := h.store.ListRunsByApp(r.Context(), teamID, app.ID, limit, offset, versionNo, includeInput)
 	if err != nil {
-		h.logger.Error("list runs", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "list runs", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	resp := listRunsResponse{Runs: make([]runResponse, 0, len(runs))}
 	for _, run := range runs {
 		rr := runResponse{
-			RunID:           run.ID,
-			AppID:           run.AppID,
-			RunNo:           run.RunNo,
-			VersionNo:       run.VersionNo,
-			Status:          run.Status,
-			Input:           run.Input,
-			Priority:        run.Priority,
-			MaxRetries:      run.MaxRetries,
-			RetryCount:      run.RetryCount,
-			CancelRequested: run.CancelRequested,
-			QueuedAt:        run.QueuedAt.Format(time.RFC3339),
+			RunID:            run.ID,
+			AppID:            run.AppID,
+			RunNo:            run.RunNo,
+			VersionNo:        run.VersionNo,
+			Entrypoint:       run.Entrypoint,
+			Status:           run.Status,
+			DeadReason:       run.DeadReason,
+			Input:            run.Input,
+			InputOmitted:     run.InputOmitted,
+			Priority:         run.Priority,
+			MaxRetries:       run.MaxRetries,
+			RetryCount:       run.RetryCount,
+			RetriesRemaining: retriesRemaining(run.MaxRetries, run.RetryCount),
+			FailureKind:      run.FailureKind,
+			CancelRequested:  run.CancelRequested,
+			AtMostOnce:       run.AtMostOnce,
+			Command:          run.Command,
+			RunTraceID:       run.TraceID,
+			BatchID:          run.BatchID,
+			RerunOfRunID:     run.RerunOfRunID,
+			QueuedAt:         formatTime(run.QueuedAt),
 		}
 		if run.StartedAt != nil {
-			s := run.StartedAt.Format(time.RFC3339)
+			s := formatTime(*run.StartedAt)
 			rr.StartedAt = &s
 		}
 		if run.FinishedAt != nil {
-			f := run.FinishedAt.Format(time.RFC3339)
+			f := formatTime(*run.FinishedAt)
 			rr.FinishedAt = &f
 		}
 		resp.Runs = append(resp.Runs, rr)
@@ -266,7 +561,7 @@ func (h *Handlers) ListRunsByTeam(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
@@ -283,48 +578,153 @@ func (h *Handlers) ListRunsByTeam(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	statusFilter := strings.TrimSpace(r.URL.Query().Get("status"))
-	if statusFilter != "" && !isValidRunStatus(statusFilter) {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid status filter")
+	filter, err := parseRunListFilter(r)
+	if err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "%s", err.Error())
 		return
 	}
-	appFilter := strings.TrimSpace(r.URL.Query().Get("app"))
+	filter.TeamID = teamID
+	filter.Limit, filter.Offset = limit, offset
 
-	runs, err := h.store.ListRunsByTeam(r.Context(), teamID, limit, offset, statusFilter, appFilter)
+	runs, err := h.store.ListRuns(r.Context(), filter)
 	if err != nil {
-		h.logger.Error("list team runs", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "list team runs", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toListRunsResponse(runs))
+}
+
+// ListAdminRuns returns runs across every team, with the filters of
+// ListRunsByTeam, so runs handled by one runner can be found whatever team
+// they belong to. Rows carry team_slug.
+func (h *Handlers) ListAdminRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
+	q := r.URL.Query()
+	limit, offset := 50, 0
+	if l := q.Get("limit"); l != "" {
+		if val, err := strconv.Atoi(l); err == nil && val > 0 && val <= 100 {
+			limit = val
+		}
+	}
+	if o := q.Get("offset"); o != "" {
+		if val, err := strconv.Atoi(o); err == nil && val >= 0 {
+			offset = val
+		}
+	}
+
+	filter, err := parseRunListFilter(r)
+	if err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "%s", err.Error())
+		return
+	}
+	filter.Limit, filter.Offset = limit, offset
+
+	runs, err := h.store.ListRuns(r.Context(), filter)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "list admin runs", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toListRunsResponse(runs))
+}
+
+// parseRunListFilter reads the run list filters shared by the team and
+// admin listings: status, app, version_no, input_contains, runner, since and
+// include=runner. Limits and the team are left to the caller.
+func parseRunListFilter(r *http.Request) (store.RunListFilter, error) {
+	q := r.URL.Query()
+	filter := store.RunListFilter{
+		Status:  strings.TrimSpace(q.Get("status")),
+		AppSlug: strings.TrimSpace(q.Get("app")),
+		Runner:  strings.TrimSpace(q.Get("runner")),
+	}
+	if filter.Status != "" && !isValidRunStatus(filter.Status) {
+		return filter, errors.New("invalid status filter")
+	}
+	if raw := strings.TrimSpace(q.Get("input_contains")); raw != "" {
+		inputContains, err := parseInputContains(raw)
+		if err != nil {
+			return filter, err
+		}
+		filter.InputContains = inputContains
+	}
+	versionNo, err := parseVersionNoFilter(r)
+	if err != nil {
+		return filter, err
+	}
+	filter.VersionNo = versionNo
+	if v := strings.TrimSpace(q.Get("since")); v != "" {
+		since, err := parseReportTime(v)
+		if err != nil {
+			return filter, errors.New("since must be a date (YYYY-MM-DD) or RFC 3339 time")
+		}
+		filter.Since = since
+	}
+	if include := strings.TrimSpace(q.Get("include")); include != "" {
+		for _, part := range strings.Split(include, ",") {
+			switch strings.TrimSpace(part) {
+			case "runner":
+				// Joining the latest attempt is only paid for on request.
+				filter.IncludeRunner = true
+			case "input":
+				// Decompressing large inputs is only paid for on request.
+				filter.IncludeInput = true
+			default:
+				return filter, fmt.Errorf("invalid include: %s", strings.TrimSpace(part))
+			}
+		}
+	}
+	return filter, nil
+}
+
+// toListRunsResponse renders runs listed by ListRuns.
+func toListRunsResponse(runs []*store.Run) listRunsResponse {
 	resp := listRunsResponse{Runs: make([]runResponse, 0, len(runs))}
 	for _, run := range runs {
 		rr := runResponse{
-			RunID:           run.ID,
-			AppID:           run.AppID,
-			AppSlug:         run.AppSlug,
-			RunNo:           run.RunNo,
-			VersionNo:       run.VersionNo,
-			Status:          run.Status,
-			Input:           run.Input,
-			Priority:        run.Priority,
-			MaxRetries:      run.MaxRetries,
-			RetryCount:      run.RetryCount,
-			CancelRequested: run.CancelRequested,
-			QueuedAt:        run.QueuedAt.Format(time.RFC3339),
+			RunID:            run.ID,
+			AppID:            run.AppID,
+			AppSlug:          run.AppSlug,
+			TeamSlug:         run.TeamSlug,
+			RunNo:            run.RunNo,
+			VersionNo:        run.VersionNo,
+			Entrypoint:       run.Entrypoint,
+			Status:           run.Status,
+			DeadReason:       run.DeadReason,
+			Input:            run.Input,
+			InputOmitted:     run.InputOmitted,
+			Priority:         run.Priority,
+			MaxRetries:       run.MaxRetries,
+			RetryCount:       run.RetryCount,
+			RetriesRemaining: retriesRemaining(run.MaxRetries, run.RetryCount),
+			FailureKind:      run.FailureKind,
+			CancelRequested:  run.CancelRequested,
+			AtMostOnce:       run.AtMostOnce,
+			Command:          run.Command,
+			RunTraceID:       run.TraceID,
+			BatchID:          run.BatchID,
+			RerunOfRunID:     run.RerunOfRunID,
+			RunnerName:       run.RunnerName,
+			QueuedAt:         formatTime(run.QueuedAt),
 		}
 		if run.StartedAt != nil {
-			s := run.StartedAt.Format(time.RFC3339)
+			s := formatTime(*run.StartedAt)
 			rr.StartedAt = &s
 		}
 		if run.FinishedAt != nil {
-			f := run.FinishedAt.Format(time.RFC3339)
+			f := formatTime(*run.FinishedAt)
 			rr.FinishedAt = &f
 		}
 		resp.Runs = append(resp.Runs, rr)
 	}
-
-	writeJSON(w, http.StatusOK, resp)
+	return resp
 }
 
 // GetRunsSummary returns aggregate run counts for the current team.
@@ -336,14 +736,14 @@ func (h *Handlers) GetRunsSummary(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
 	summary, err := h.store.GetRunSummaryByTeam(r.Context(), teamID)
 	if err != nil {
-		h.logger.Error("get runs summary", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get runs summary", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
@@ -364,71 +764,154 @@ func (h *Handlers) GetRun(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
 	runID := extractRunIDFromPath(r.URL.Path)
 	if runID == 0 {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid run ID")
+		writeAPIError(w, apierror.InvalidRequest, "invalid run ID")
 		return
 	}
 
 	run, err := h.store.GetRunByID(r.Context(), teamID, runID)
 	if err != nil {
-		h.logger.Error("get run", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get run", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if run == nil {
-		writeError(w, http.StatusNotFound, "not_found", "run not found")
+		writeAPIError(w, apierror.NotFound, "run not found")
+		return
+	}
+	if !requireScope(w, r, ScopeRunsRead, run.AppID) {
 		return
 	}
 
 	v, err := h.store.GetVersionByID(r.Context(), run.AppVersionID)
 	if err != nil {
-		h.logger.Error("get version", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get version", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	app, err := h.store.GetAppByIDDirect(r.Context(), run.AppID)
 	if err != nil {
-		h.logger.Error("get app", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	env, err := h.store.GetEnvironmentByID(r.Context(), teamID, run.EnvironmentID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get environment", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	rr := runResponse{
-		RunID:           run.ID,
-		AppID:           run.AppID,
-		AppSlug:         "",
-		RunNo:           run.RunNo,
-		Status:          run.Status,
-		Input:           run.Input,
-		Priority:        run.Priority,
-		MaxRetries:      run.MaxRetries,
-		RetryCount:      run.RetryCount,
-		CancelRequested: run.CancelRequested,
-		QueuedAt:        run.QueuedAt.Format(time.RFC3339),
+		RunID:            run.ID,
+		AppID:            run.AppID,
+		AppSlug:          "",
+		RunNo:            run.RunNo,
+		Status:           run.Status,
+		DeadReason:       run.DeadReason,
+		Input:            run.Input,
+		Priority:         run.Priority,
+		MaxRetries:       run.MaxRetries,
+		RetryCount:       run.RetryCount,
+		RetriesRemaining: retriesRemaining(run.MaxRetries, run.RetryCount),
+		FailureKind:      run.FailureKind,
+		CancelRequested:  run.CancelRequested,
+		AtMostOnce:       run.AtMostOnce,
+		Command:          run.Command,
+		RunTraceID:       run.TraceID,
+		BatchID:          run.BatchID,
+		RerunOfRunID:     run.RerunOfRunID,
+		QueuedAt:         formatTime(run.QueuedAt),
 	}
 	if app != nil {
 		rr.AppSlug = app.Slug
 	}
 	if v != nil {
 		rr.VersionNo = v.VersionNo
+		rr.Entrypoint = v.Entrypoint
+	}
+	if env != nil {
+		rr.Environment = env.Name
 	}
 	if run.StartedAt != nil {
-		s := run.StartedAt.Format(time.RFC3339)
+		s := formatTime(*run.StartedAt)
 		rr.StartedAt = &s
 	}
 	if run.FinishedAt != nil {
-		f := run.FinishedAt.Format(time.RFC3339)
+		f := formatTime(*run.FinishedAt)
 		rr.FinishedAt = &f
 	}
+	if run.Status == "queued" {
+		effective := h.store.PriorityAging().Effective(run.Priority, run.QueuedAt, time.Now())
+		rr.EffectivePriority = &effective
+	}
+
+	attempt, err := h.store.GetLatestAttempt(r.Context(), run.ID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get latest attempt", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if attempt != nil {
+		rr.AttemptNo = attempt.AttemptNo
+		rr.ExitCode = attempt.ExitCode
+		if attempt.CancelAckAt != nil {
+			ackAt := formatTime(*attempt.CancelAckAt)
+			rr.CancelAckAt = &ackAt
+		}
+	}
 
 	writeJSON(w, http.StatusOK, rr)
 }
 
+// runStatusResponse is everything a status token reveals about its run.
+type runStatusResponse struct {
+	Status     string  `json:"status"`
+	ExitCode   *int    `json:"exit_code"`
+	FinishedAt *string `json:"finished_at"`
+}
+
+// GetRunStatus returns the status of the single run a status token was
+// issued for. The token in the path is the only credential, so the response
+// carries nothing but the status, exit code and finish time.
+// GET /api/v1/run-status/{status_token}
+func (h *Handlers) GetRunStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, "/api/v1/run-status/")
+	if !strings.HasPrefix(token, auth.PrefixStatusToken) || strings.Contains(token, "/") {
+		writeAPIError(w, apierror.NotFound, "run status not found")
+		return
+	}
+
+	rs, err := h.store.GetRunStatusByToken(r.Context(), auth.HashToken(token), time.Now())
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get run status by token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if rs == nil {
+		writeAPIError(w, apierror.NotFound, "run status not found")
+		return
+	}
+
+	resp := runStatusResponse{Status: rs.Status, ExitCode: rs.ExitCode}
+	if rs.FinishedAt != nil {
+		s := formatTime(*rs.FinishedAt)
+		resp.FinishedAt = &s
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
 // CancelRun requests cancellation for a run.
 func (h *Handlers) CancelRun(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -438,26 +921,31 @@ func (h *Handlers) CancelRun(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
 	runID := extractRunIDFromPath(r.URL.Path)
 	if runID == 0 {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid run ID")
+		writeAPIError(w, apierror.InvalidRequest, "invalid run ID")
+		return
+	}
+
+	if !h.requireRunScope(w, r, ScopeRunsCancel, teamID, runID) {
 		return
 	}
 
 	run, err := h.store.CancelRun(r.Context(), teamID, runID)
 	if err != nil {
-		h.logger.Error("cancel run", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "cancel run", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if run == nil {
-		writeError(w, http.StatusNotFound, "not_found", "run not found")
+		writeAPIError(w, apierror.NotFound, "run not found")
 		return
 	}
+	h.audit(r, AuditRunCancel, "run", run.ID, map[string]any{"status": run.Status})
 
 	// Emit metrics if run went to a terminal state (cancelled from queued)
 	if run.Status == "cancelled" {
@@ -475,38 +963,276 @@ func (h *Handlers) CancelRun(w http.ResponseWriter, r *http.Request) {
 
 	v, err := h.store.GetVersionByID(r.Context(), run.AppVersionID)
 	if err != nil {
-		h.logger.Error("get version", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get version", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	rr := runResponse{
-		RunID:           run.ID,
-		AppID:           run.AppID,
-		RunNo:           run.RunNo,
-		Status:          run.Status,
-		Input:           run.Input,
-		Priority:        run.Priority,
-		MaxRetries:      run.MaxRetries,
-		RetryCount:      run.RetryCount,
-		CancelRequested: run.CancelRequested,
-		QueuedAt:        run.QueuedAt.Format(time.RFC3339),
+		RunID:            run.ID,
+		AppID:            run.AppID,
+		RunNo:            run.RunNo,
+		Status:           run.Status,
+		DeadReason:       run.DeadReason,
+		Input:            run.Input,
+		Priority:         run.Priority,
+		MaxRetries:       run.MaxRetries,
+		RetryCount:       run.RetryCount,
+		RetriesRemaining: retriesRemaining(run.MaxRetries, run.RetryCount),
+		FailureKind:      run.FailureKind,
+		CancelRequested:  run.CancelRequested,
+		AtMostOnce:       run.AtMostOnce,
+		Command:          run.Command,
+		RunTraceID:       run.TraceID,
+		BatchID:          run.BatchID,
+		QueuedAt:         formatTime(run.QueuedAt),
 	}
 	if v != nil {
 		rr.VersionNo = v.VersionNo
 	}
 	if run.StartedAt != nil {
-		s := run.StartedAt.Format(time.RFC3339)
+		s := formatTime(*run.StartedAt)
 		rr.StartedAt = &s
 	}
 	if run.FinishedAt != nil {
-		f := run.FinishedAt.Format(time.RFC3339)
+		f := formatTime(*run.FinishedAt)
 		rr.FinishedAt = &f
 	}
 
 	writeJSON(w, http.StatusOK, rr)
 }
 
+// maxBulkCancelRunIDs caps the run_ids a bulk cancel may list.
+const maxBulkCancelRunIDs = 1000
+
+// bulkCancelStatuses maps the status filter of a bulk cancel to the run
+// statuses it selects. Runs already cancelling are never selected.
+var bulkCancelStatuses = map[string][]string{
+	"queued":          {"queued"},
+	"running":         {"leased", "running"},
+	"all-nonterminal": {"queued", "leased", "running"},
+}
+
+type bulkCancelRequest struct {
+	App           string  `json:"app"`
+	Status        string  `json:"status"`
+	CreatedBefore string  `json:"created_before"`
+	RunIDs        []int64 `json:"run_ids"`
+	// ConfirmCount must equal the number of runs the filter selects.
+	ConfirmCount *int64 `json:"confirm_count"`
+	DryRun       bool   `json:"dry_run"`
+}
+
+type bulkCancelRunResult struct {
+	RunID          int64  `json:"run_id"`
+	PreviousStatus string `json:"previous_status"`
+	Status         string `json:"status"`
+}
+
+type bulkCancelResponse struct {
+	Count  int64 `json:"count"`
+	DryRun bool  `json:"dry_run,omitempty"`
+	// RunIDs lists the selected runs; dry runs only.
+	RunIDs     []int64               `json:"run_ids,omitempty"`
+	Cancelled  int64                 `json:"cancelled"`
+	Cancelling int64                 `json:"cancelling"`
+	Results    []bulkCancelRunResult `json:"results"`
+}
+
+// CancelRunsBulk cancels every run of the team matching a filter. The
+// request must confirm how many runs the filter selects, so a mistyped
+// filter cannot cancel more than the caller expects; a dry run returns that
+// count without cancelling anything.
+func (h *Handlers) CancelRunsBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	var req bulkCancelRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
+		return
+	}
+
+	if req.Status == "" {
+		req.Status = "all-nonterminal"
+	}
+	statuses, ok := bulkCancelStatuses[req.Status]
+	if !ok {
+		writeAPIError(w, apierror.InvalidRequest, "status must be queued, running or all-nonterminal")
+		return
+	}
+	filter := store.RunCancelFilter{Statuses: statuses, RunIDs: req.RunIDs}
+	if len(req.RunIDs) > maxBulkCancelRunIDs {
+		writeAPIError(w, apierror.InvalidRequest, "run_ids must contain at most %d IDs", maxBulkCancelRunIDs)
+		return
+	}
+	for _, id := range req.RunIDs {
+		if id <= 0 {
+			writeAPIError(w, apierror.InvalidRequest, "run_ids must be positive")
+			return
+		}
+	}
+	if req.CreatedBefore != "" {
+		t, err := parseTime(req.CreatedBefore)
+		if err != nil {
+			writeAPIError(w, apierror.InvalidRequest, "created_before must be an RFC 3339 timestamp")
+			return
+		}
+		filter.CreatedBefore = &t
+	}
+	if !req.DryRun && req.ConfirmCount == nil {
+		writeAPIError(w, apierror.InvalidRequest, "confirm_count is required")
+		return
+	}
+	if req.App != "" {
+		app, err := h.store.GetAppBySlug(r.Context(), teamID, req.App)
+		if writeStoreError(w, r, h.logger, err, "get app") {
+			return
+		}
+		if app == nil {
+			writeAPIError(w, apierror.NotFound, "app not found")
+			return
+		}
+		filter.AppID = app.ID
+	}
+
+	runIDs, err := h.store.MatchRunsForCancel(r.Context(), teamID, filter)
+	if writeStoreError(w, r, h.logger, err, "match runs for cancel") {
+		return
+	}
+	count := int64(len(runIDs))
+	if req.DryRun {
+		writeJSON(w, http.StatusOK, bulkCancelResponse{Count: count, DryRun: true, RunIDs: runIDs, Results: []bulkCancelRunResult{}})
+		return
+	}
+	if *req.ConfirmCount != count {
+		httputil.WriteErrorCount(w, apierror.ConfirmCountMismatch.Status(), string(apierror.ConfirmCountMismatch),
+			fmt.Sprintf("filter selects %d runs, confirm_count is %d", count, *req.ConfirmCount), count)
+		return
+	}
+
+	results, err := h.store.CancelRunsBulk(r.Context(), teamID, runIDs)
+	if writeStoreError(w, r, h.logger, err, "cancel runs") {
+		return
+	}
+	for _, res := range results {
+		h.audit(r, AuditRunCancel, "run", res.RunID, map[string]any{"status": res.Status, "bulk": true})
+	}
+
+	resp := bulkCancelResponse{Count: count, Results: make([]bulkCancelRunResult, 0, len(results))}
+	teamSlug, _ := teamSlugFromContext(r.Context())
+	appSlugs := make(map[int64]string)
+	for _, res := range results {
+		resp.Results = append(resp.Results, bulkCancelRunResult{RunID: res.RunID, PreviousStatus: res.PreviousStatus, Status: res.Status})
+		switch {
+		case res.Status == "cancelling":
+			resp.Cancelling++
+		case res.Status == "cancelled" && res.PreviousStatus == "queued":
+			// Queued runs went straight to a terminal state.
+			resp.Cancelled++
+			appSlug, ok := appSlugs[res.AppID]
+			if !ok {
+				if app, _ := h.store.GetAppByIDDirect(r.Context(), res.AppID); app != nil {
+					appSlug = app.Slug
+				}
+				appSlugs[res.AppID] = appSlug
+			}
+			h.metrics.RunCompleted(teamSlug, appSlug, "cancelled")
+			if res.FinishedAt != nil {
+				h.metrics.ObserveTotal(teamSlug, appSlug, "cancelled", res.FinishedAt.Sub(res.QueuedAt).Seconds())
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// SetRunPriority changes the priority of a run that is still queued.
+func (h *Handlers) SetRunPriority(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	runID := extractRunIDFromPath(r.URL.Path)
+	if runID == 0 {
+		writeAPIError(w, apierror.InvalidRequest, "invalid run ID")
+		return
+	}
+
+	var req setRunPriorityRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "malformed JSON body")
+		return
+	}
+	if req.Priority == nil {
+		writeAPIError(w, apierror.InvalidRequest, "priority is required")
+		return
+	}
+
+	run, err := h.store.SetRunPriority(r.Context(), teamID, runID, clampRunPriority(*req.Priority))
+	if errors.Is(err, store.ErrRunNotQueued) {
+		writeAPIError(w, apierror.RunNotQueued, "run is no longer queued")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "set run priority", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if run == nil {
+		writeAPIError(w, apierror.NotFound, "run not found")
+		return
+	}
+	h.audit(r, AuditRunPriority, "run", run.ID, map[string]any{"priority": run.Priority})
+
+	v, err := h.store.GetVersionByID(r.Context(), run.AppVersionID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get version", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	rr := runResponse{
+		RunID:            run.ID,
+		AppID:            run.AppID,
+		RunNo:            run.RunNo,
+		Status:           run.Status,
+		DeadReason:       run.DeadReason,
+		Input:            run.Input,
+		Priority:         run.Priority,
+		MaxRetries:       run.MaxRetries,
+		RetryCount:       run.RetryCount,
+		RetriesRemaining: retriesRemaining(run.MaxRetries, run.RetryCount),
+		FailureKind:      run.FailureKind,
+		CancelRequested:  run.CancelRequested,
+		AtMostOnce:       run.AtMostOnce,
+		Command:          run.Command,
+		RunTraceID:       run.TraceID,
+		BatchID:          run.BatchID,
+		QueuedAt:         formatTime(run.QueuedAt),
+	}
+	if v != nil {
+		rr.VersionNo = v.VersionNo
+	}
+
+	writeJSON(w, http.StatusOK, rr)
+}
+
 // GetRunLogs returns logs for a run.
 func (h *Handlers) GetRunLogs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -516,25 +1242,28 @@ func (h *Handlers) GetRunLogs(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
 	runID := extractRunIDFromLogsPath(r.URL.Path)
 	if runID == 0 {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid run ID")
+		writeAPIError(w, apierror.InvalidRequest, "invalid run ID")
 		return
 	}
 
 	// Verify run belongs to team
 	run, err := h.store.GetRunByID(r.Context(), teamID, runID)
 	if err != nil {
-		h.logger.Error("get run", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get run", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if run == nil {
-		writeError(w, http.StatusNotFound, "not_found", "run not found")
+		writeAPIError(w, apierror.NotFound, "run not found")
+		return
+	}
+	if !requireScope(w, r, ScopeRunsRead, run.AppID) {
 		return
 	}
 
@@ -542,32 +1271,110 @@ func (h *Handlers) GetRunLogs(w http.ResponseWriter, r *http.Request) {
 	if raw := r.URL.Query().Get("after_seq"); raw != "" {
 		parsed, err := strconv.ParseInt(raw, 10, 64)
 		if err != nil || parsed < 0 {
-			writeError(w, http.StatusBadRequest, "invalid_request", "after_seq must be a non-negative integer")
+			writeAPIError(w, apierror.InvalidRequest, "after_seq must be a non-negative integer")
 			return
 		}
 		afterSeq = parsed
 	}
 
-	logs, err := h.store.GetRunLogs(r.Context(), runID, afterSeq)
+	archiveKey, err := h.store.GetRunLogArchiveKey(r.Context(), runID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get run log archive", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	var logs []*store.RunLog
+	if archiveKey != "" {
+		logs, err = h.loadArchivedLogs(archiveKey, afterSeq)
+	} else {
+		logs, err = h.store.GetRunLogs(r.Context(), runID, afterSeq)
+	}
 	if err != nil {
-		h.logger.Error("get run logs", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get run logs", "error", err, "archive_key", archiveKey)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
-	resp := runLogsResponse{Logs: make([]runLogEntry, 0, len(logs))}
+	resp := runLogsResponse{Logs: make([]runLogEntry, 0, len(logs)), Archived: archiveKey != ""}
 	for _, l := range logs {
 		resp.Logs = append(resp.Logs, runLogEntry{
 			Seq:      l.Seq,
 			Stream:   l.Stream,
 			Line:     l.Line,
-			LoggedAt: l.LoggedAt.Format(time.RFC3339),
+			LoggedAt: formatTime(l.LoggedAt),
 		})
 	}
 
 	writeJSON(w, http.StatusOK, resp)
 }
 
+type runEventResponse struct {
+	Kind    string         `json:"kind"`
+	At      string         `json:"at"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type runEventsResponse struct {
+	RunID  int64              `json:"run_id"`
+	Events []runEventResponse `json:"events"`
+}
+
+// GetRunEvents returns a run's timeline of state transitions, oldest first.
+// Timestamps keep millisecond precision so close events stay ordered when
+// rendered.
+func (h *Handlers) GetRunEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	run, ok := h.teamRunFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !requireScope(w, r, ScopeRunsRead, run.AppID) {
+		return
+	}
+
+	events, err := h.store.ListRunEvents(r.Context(), run.ID)
+	if writeStoreError(w, r, h.logger, err, "list run events") {
+		return
+	}
+
+	resp := runEventsResponse{RunID: run.ID, Events: make([]runEventResponse, 0, len(events))}
+	for _, e := range events {
+		resp.Events = append(resp.Events, runEventResponse{
+			Kind:    e.Kind,
+			At:      formatTime(e.CreatedAt),
+			Details: e.Details,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// loadArchivedLogs reads an attempt's logs back from its retention archive.
+// Archives are written before any row is purged, so they are complete.
+func (h *Handlers) loadArchivedLogs(key string, afterSeq int64) ([]*store.RunLog, error) {
+	rc, err := h.objects.Load(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	all, err := logretention.ReadArchive(rc)
+	if err != nil {
+		return nil, err
+	}
+	logs := all[:0]
+	for _, l := range all {
+		if l.Seq > afterSeq {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
 // extractAppSlugFromRunPath extracts app slug from /api/v1/apps/{app}/runs
 func extractAppSlugFromRunPath(path string) string {
 	const prefix = "/api/v1/apps/"
@@ -618,6 +1425,49 @@ func extractRunIDFromLogsPath(path string) int64 {
 	return id
 }
 
+// parseVersionNoFilter reads the optional version_no filter; 0 means none.
+func parseVersionNoFilter(r *http.Request) (int64, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("version_no"))
+	if raw == "" {
+		return 0, nil
+	}
+	versionNo, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || versionNo <= 0 {
+		return 0, errors.New("version_no must be a positive integer")
+	}
+	return versionNo, nil
+}
+
+// maxInputContainsKeys bounds the input_contains filter; each key adds a
+// JSON lookup per scanned run.
+const maxInputContainsKeys = 3
+
+// parseInputContains decodes the input_contains filter: a JSON object of up
+// to maxInputContainsKeys top-level input keys and the scalar values they
+// must hold.
+func parseInputContains(raw string) (map[string]any, error) {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	var filter map[string]any
+	if err := dec.Decode(&filter); err != nil || filter == nil || dec.More() {
+		return nil, errors.New("input_contains must be a JSON object")
+	}
+	if len(filter) > maxInputContainsKeys {
+		return nil, fmt.Errorf("input_contains accepts at most %d keys", maxInputContainsKeys)
+	}
+	for key, value := range filter {
+		if key == "" || strings.Contains(key, `"`) {
+			return nil, fmt.Errorf("input_contains key %q is not supported", key)
+		}
+		switch value.(type) {
+		case nil, bool, string, json.Number:
+		default:
+			return nil, fmt.Errorf("input_contains value for %q must be a string, number, boolean or null", key)
+		}
+	}
+	return filter, nil
+}
+
 func isValidRunStatus(status string) bool {
 	switch status {
 	case "queued", "leased", "running", "cancelling", "completed", "failed", "cancelled", "dead":
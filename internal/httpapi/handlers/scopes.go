@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"minitower/internal/apierror"
+)
+
+// Token scopes. A token created with scopes can only call the endpoints its
+// scopes cover; a token without scopes has full access for its role.
+const (
+	ScopeRunsCreate = "runs:create"
+	ScopeRunsRead   = "runs:read"
+	ScopeRunsCancel = "runs:cancel"
+)
+
+var tokenScopes = []string{ScopeRunsCreate, ScopeRunsRead, ScopeRunsCancel}
+
+// requireScope reports whether the calling token may use scope on appID's
+// runs. Tokens without scopes always may. Otherwise it writes a 403
+// insufficient_scope and returns false.
+func requireScope(w http.ResponseWriter, r *http.Request, scope string, appID int64) bool {
+	ts, ok := tokenScopeFromContext(r.Context())
+	if !ok {
+		return true
+	}
+	if !slices.Contains(ts.Scopes, scope) {
+		writeAPIError(w, apierror.InsufficientScope, "token lacks the %s scope (has %s)", scope, strings.Join(ts.Scopes, ", "))
+		return false
+	}
+	if ts.AppID != nil && *ts.AppID != appID {
+		writeAPIError(w, apierror.InsufficientScope, "token is limited to app %s", ts.AppSlug)
+		return false
+	}
+	return true
+}
+
+// requireRunScope is requireScope for the app of run runID, for handlers
+// that act on a run before loading it. It only loads the run for scoped
+// tokens, and leaves a missing run to the handler.
+func (h *Handlers) requireRunScope(w http.ResponseWriter, r *http.Request, scope string, teamID, runID int64) bool {
+	if _, ok := tokenScopeFromContext(r.Context()); !ok {
+		return true
+	}
+	run, err := h.store.GetRunByID(r.Context(), teamID, runID)
+	if writeStoreError(w, r, h.logger, err, "get run") {
+		return false
+	}
+	if run == nil {
+		return true
+	}
+	return requireScope(w, r, scope, run.AppID)
+}
@@ -6,6 +6,7 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 
+	"minitower/internal/apierror"
 	"minitower/internal/auth"
 	"minitower/internal/validate"
 )
@@ -36,75 +37,75 @@ func (h *Handlers) SignupTeam(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !h.cfg.PublicSignupEnabled {
-		writeError(w, http.StatusForbidden, "forbidden", "team signup is disabled")
+		writeAPIError(w, apierror.Forbidden, "team signup is disabled")
 		return
 	}
 
 	var req signupTeamRequest
 	if err := decodeJSON(r, &req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid JSON body")
+		writeAPIError(w, apierror.InvalidRequest, "invalid JSON body")
 		return
 	}
 
 	if err := validate.ValidateSlug(req.Slug); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_slug", err.Error())
+		writeAPIError(w, apierror.InvalidSlug, "%v", err)
 		return
 	}
 	if strings.TrimSpace(req.Name) == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "name is required")
+		writeAPIError(w, apierror.InvalidRequest, "name is required")
 		return
 	}
 	if req.Password == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "password is required")
+		writeAPIError(w, apierror.InvalidRequest, "password is required")
 		return
 	}
 
 	exists, err := h.store.TeamExistsBySlug(r.Context(), req.Slug)
 	if err != nil {
-		h.logger.Error("check team exists by slug", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "check team exists by slug", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if exists {
-		writeError(w, http.StatusConflict, "slug_taken", "team slug already exists")
+		writeAPIError(w, apierror.SlugTaken, "team slug already exists")
 		return
 	}
 
 	team, err := h.store.CreateTeam(r.Context(), req.Slug, strings.TrimSpace(req.Name))
 	if err != nil {
 		if isTeamSlugUniqueConflict(err) {
-			writeError(w, http.StatusConflict, "slug_taken", "team slug already exists")
+			writeAPIError(w, apierror.SlugTaken, "team slug already exists")
 			return
 		}
-		h.logger.Error("create team", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "create team", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), 12)
 	if err != nil {
-		h.logger.Error("hash password", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "hash password", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if err := h.store.SetTeamPassword(r.Context(), team.ID, string(passwordHash)); err != nil {
-		h.logger.Error("set team password", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "set team password", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	token, tokenHash, err := auth.GeneratePrefixedToken(auth.PrefixTeamToken)
 	if err != nil {
-		h.logger.Error("generate team token", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "generate team token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	tokenName := "signup"
 	createdToken, err := h.store.CreateTeamToken(r.Context(), team.ID, tokenHash, &tokenName, "admin")
 	if err != nil {
-		h.logger.Error("create team token", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "create team token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
@@ -4,20 +4,31 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"slices"
+	"strings"
 
+	"minitower/internal/apierror"
 	"minitower/internal/auth"
+	"minitower/internal/store"
 )
 
 type createTokenRequest struct {
 	Name *string `json:"name"`
 	Role *string `json:"role"`
+	// Scopes limits the token to those scopes, such as runs:create; a
+	// scoped token is always a member token.
+	Scopes []string `json:"scopes"`
+	// AppSlug limits a scoped token to one app's runs.
+	AppSlug string `json:"app_slug"`
 }
 
 type createTokenResponse struct {
-	TokenID int64   `json:"token_id"`
-	Token   string  `json:"token"`
-	Name    *string `json:"name,omitempty"`
-	Role    string  `json:"role"`
+	TokenID int64    `json:"token_id"`
+	Token   string   `json:"token"`
+	Name    *string  `json:"name,omitempty"`
+	Role    string   `json:"role"`
+	Scopes  []string `json:"scopes,omitempty"`
+	AppSlug string   `json:"app_slug,omitempty"`
 }
 
 // CreateToken creates a new team API token.
@@ -29,7 +40,7 @@ func (h *Handlers) CreateToken(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 	callerRole, _ := TokenRoleFromContext(r.Context())
@@ -39,7 +50,7 @@ func (h *Handlers) CreateToken(w http.ResponseWriter, r *http.Request) {
 		if errors.Is(err, io.EOF) {
 			req = createTokenRequest{}
 		} else {
-			writeError(w, http.StatusBadRequest, "invalid_request", "malformed JSON body")
+			writeAPIError(w, apierror.InvalidRequest, "malformed JSON body")
 			return
 		}
 	}
@@ -48,7 +59,7 @@ func (h *Handlers) CreateToken(w http.ResponseWriter, r *http.Request) {
 	if req.Role != nil {
 		requestedRole = *req.Role
 		if requestedRole != "admin" && requestedRole != "member" {
-			writeError(w, http.StatusBadRequest, "invalid_request", "role must be admin or member")
+			writeAPIError(w, apierror.InvalidRequest, "role must be admin or member")
 			return
 		}
 	}
@@ -58,25 +69,70 @@ func (h *Handlers) CreateToken(w http.ResponseWriter, r *http.Request) {
 		tokenRole = requestedRole
 	}
 
+	scoped := req.Scopes != nil || req.AppSlug != ""
+	var appID *int64
+	if scoped {
+		if len(req.Scopes) == 0 {
+			writeAPIError(w, apierror.InvalidRequest, "app_slug needs at least one scope")
+			return
+		}
+		if requestedRole == "admin" {
+			writeAPIError(w, apierror.InvalidRequest, "a scoped token cannot have the admin role")
+			return
+		}
+		for _, scope := range req.Scopes {
+			if !slices.Contains(tokenScopes, scope) {
+				writeAPIError(w, apierror.InvalidRequest, "unknown scope %q (valid: %s)", scope, strings.Join(tokenScopes, ", "))
+				return
+			}
+		}
+		if req.AppSlug != "" {
+			app, err := h.store.GetAppBySlug(r.Context(), teamID, req.AppSlug)
+			if writeStoreError(w, r, h.logger, err, "get app") {
+				return
+			}
+			if app == nil {
+				writeAPIError(w, apierror.NotFound, "app not found")
+				return
+			}
+			appID = &app.ID
+		}
+	}
+
 	// Generate team token
 	token, tokenHash, err := auth.GeneratePrefixedToken(auth.PrefixTeamToken)
 	if err != nil {
-		h.logger.Error("generate team token", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "generate team token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
-	teamToken, err := h.store.CreateTeamToken(r.Context(), teamID, tokenHash, req.Name, tokenRole)
+	var teamToken *store.TeamToken
+	if scoped {
+		teamToken, err = h.store.CreateScopedTeamToken(r.Context(), teamID, tokenHash, req.Name, req.Scopes, appID)
+	} else {
+		teamToken, err = h.store.CreateTeamToken(r.Context(), teamID, tokenHash, req.Name, tokenRole)
+	}
 	if err != nil {
-		h.logger.Error("create team token", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "create team token", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
+	auditData := map[string]any{"role": teamToken.Role}
+	if scoped {
+		auditData["scopes"] = teamToken.Scopes
+		if req.AppSlug != "" {
+			auditData["app"] = req.AppSlug
+		}
+	}
+	h.audit(r, AuditTokenCreate, "token", teamToken.ID, auditData)
 	writeJSON(w, http.StatusCreated, createTokenResponse{
 		TokenID: teamToken.ID,
 		Token:   token,
 		Name:    teamToken.Name,
 		Role:    teamToken.Role,
+		Scopes:  teamToken.Scopes,
+		AppSlug: req.AppSlug,
 	})
 }
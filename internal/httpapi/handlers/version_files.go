@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"minitower/internal/apierror"
+	"minitower/internal/store"
+)
+
+const (
+	// maxArtifactListEntries caps the file listing of one artifact.
+	maxArtifactListEntries = 10000
+	// maxArtifactFileContent caps the file content endpoint.
+	maxArtifactFileContent = 1 << 20
+	// versionFilesCacheSize is the number of version listings kept in memory.
+	// Artifacts are immutable, so entries never need invalidating.
+	versionFilesCacheSize = 32
+)
+
+type artifactFileEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Mode  string `json:"mode"`
+	IsDir bool   `json:"is_dir"`
+}
+
+type versionFilesResponse struct {
+	VersionNo int64               `json:"version_no"`
+	Files     []artifactFileEntry `json:"files"`
+	Truncated bool                `json:"truncated"`
+}
+
+// versionFilesCache is a small LRU of artifact listings keyed by version ID.
+type versionFilesCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List
+	entries map[int64]*list.Element
+}
+
+type versionFilesCacheEntry struct {
+	versionID int64
+	resp      *versionFilesResponse
+}
+
+func newVersionFilesCache(max int) *versionFilesCache {
+	return &versionFilesCache{max: max, order: list.New(), entries: make(map[int64]*list.Element)}
+}
+
+func (c *versionFilesCache) get(versionID int64) (*versionFilesResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[versionID]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*versionFilesCacheEntry).resp, true
+}
+
+func (c *versionFilesCache) put(versionID int64, resp *versionFilesResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[versionID]; ok {
+		c.order.MoveToFront(el)
+		el.Value.(*versionFilesCacheEntry).resp = resp
+		return
+	}
+	c.entries[versionID] = c.order.PushFront(&versionFilesCacheEntry{versionID: versionID, resp: resp})
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*versionFilesCacheEntry).versionID)
+	}
+}
+
+// GetVersionFiles lists the entries of a version's artifact.
+// GET /api/v1/apps/{app}/versions/{version_no}/files
+func (h *Handlers) GetVersionFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	version, ok := h.versionFromPath(w, r, "files")
+	if !ok {
+		return
+	}
+
+	if cached, ok := h.versionFiles.get(version.ID); ok {
+		writeJSON(w, http.StatusOK, cached)
+		return
+	}
+
+	resp, err := h.listArtifactFiles(version)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "list artifact files", "error", err, "version_id", version.ID)
+		writeAPIError(w, apierror.Internal, "failed to read artifact")
+		return
+	}
+	h.versionFiles.put(version.ID, resp)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetVersionFileContent returns one text file from a version's artifact.
+// GET /api/v1/apps/{app}/versions/{version_no}/files/content?path=...
+func (h *Handlers) GetVersionFileContent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	want := normalizeArtifactPath(r.URL.Query().Get("path"))
+	if want == "" {
+		writeAPIError(w, apierror.InvalidRequest, "path is required")
+		return
+	}
+
+	version, ok := h.versionFromPath(w, r, "files")
+	if !ok {
+		return
+	}
+
+	rc, err := h.objects.Load(version.ArtifactObjectKey)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "load artifact", "error", err, "version_id", version.ID)
+		writeAPIError(w, apierror.Internal, "failed to read artifact")
+		return
+	}
+	defer rc.Close()
+
+	gr, err := gzip.NewReader(rc)
+	if err != nil {
+		writeAPIError(w, apierror.Internal, "artifact is not a valid gzip archive")
+		return
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for i := 0; i < maxArtifactListEntries; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeAPIError(w, apierror.Internal, "artifact is not a valid tar archive")
+			return
+		}
+		if normalizeArtifactPath(hdr.Name) != want {
+			continue
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			writeAPIError(w, apierror.InvalidRequest, "path is not a regular file")
+			return
+		}
+		if hdr.Size > maxArtifactFileContent {
+			writeAPIError(w, apierror.FileTooLarge, "file is %d bytes; the limit is %d", hdr.Size, maxArtifactFileContent)
+			return
+		}
+		content, err := io.ReadAll(io.LimitReader(tr, maxArtifactFileContent+1))
+		if err != nil {
+			writeAPIError(w, apierror.Internal, "failed to read artifact")
+			return
+		}
+		if bytes.IndexByte(content, 0) >= 0 || !utf8.Valid(content) {
+			writeAPIError(w, apierror.BinaryFile, "file is not UTF-8 text")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(content)
+		return
+	}
+
+	writeAPIError(w, apierror.NotFound, "file not found in artifact")
+}
+
+// versionFromPath resolves the team-scoped app and version from
+// /api/v1/apps/{app}/versions/{version_no}/{sub}[...], or from the version
+// path itself when sub is empty, writing the error response itself when it
+// returns false.
+func (h *Handlers) versionFromPath(w http.ResponseWriter, r *http.Request, sub string) (*store.AppVersion, bool) {
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return nil, false
+	}
+
+	slug, versionNo, err := parseVersionPath(r.URL.Path, sub)
+	if err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "%v", err)
+		return nil, false
+	}
+
+	app, err := h.store.GetAppBySlug(r.Context(), teamID, slug)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return nil, false
+	}
+	if app == nil {
+		writeAPIError(w, apierror.NotFound, "app not found")
+		return nil, false
+	}
+
+	version, err := h.store.GetVersionByNumber(r.Context(), app.ID, versionNo)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get version", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return nil, false
+	}
+	if version == nil {
+		writeAPIError(w, apierror.NotFound, "version not found")
+		return nil, false
+	}
+	return version, true
+}
+
+// listArtifactFiles reads the artifact's tar headers without extracting
+// anything, stopping after maxArtifactListEntries entries.
+func (h *Handlers) listArtifactFiles(version *store.AppVersion) (*versionFilesResponse, error) {
+	rc, err := h.objects.Load(version.ArtifactObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	gr, err := gzip.NewReader(rc)
+	if err != nil {
+		return nil, fmt.Errorf("open gzip: %w", err)
+	}
+	defer gr.Close()
+
+	resp := &versionFilesResponse{VersionNo: version.VersionNo, Files: []artifactFileEntry{}}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar: %w", err)
+		}
+		name := normalizeArtifactPath(hdr.Name)
+		if name == "" {
+			continue
+		}
+		if len(resp.Files) == maxArtifactListEntries {
+			resp.Truncated = true
+			break
+		}
+		resp.Files = append(resp.Files, artifactFileEntry{
+			Path:  name,
+			Size:  hdr.Size,
+			Mode:  fmt.Sprintf("%04o", hdr.Mode&0o7777),
+			IsDir: hdr.Typeflag == tar.TypeDir,
+		})
+	}
+	return resp, nil
+}
+
+// normalizeArtifactPath strips "./" and trailing slashes so "./src/" and
+// "src" compare equal. It returns "" for the archive root.
+func normalizeArtifactPath(p string) string {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return ""
+	}
+	p = path.Clean("/" + p)
+	return strings.TrimPrefix(p, "/")
+}
+
+// parseVersionPath extracts the app slug and version number from
+// /api/v1/apps/{app}/versions/{version_no}/{sub}[...], or from
+// /api/v1/apps/{app}/versions/{version_no} when sub is empty.
+func parseVersionPath(p, sub string) (string, int64, error) {
+	segs := strings.Split(strings.Trim(strings.TrimPrefix(p, "/api/v1/apps/"), "/"), "/")
+	if len(segs) < 3 || segs[0] == "" || segs[1] != "versions" || (sub != "" && (len(segs) < 4 || segs[3] != sub)) {
+		return "", 0, fmt.Errorf("invalid version %s path", sub)
+	}
+	versionNo, err := strconv.ParseInt(segs[2], 10, 64)
+	if err != nil || versionNo <= 0 {
+		return "", 0, errors.New("version number must be a positive integer")
+	}
+	return segs[0], versionNo, nil
+}
@@ -6,6 +6,8 @@ import (
 	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,19 +16,134 @@ import (
 
 	"github.com/google/uuid"
 
+	"minitower/internal/apierror"
+	"minitower/internal/httputil"
+	"minitower/internal/store"
 	"minitower/internal/towerfile"
+	"minitower/internal/validate"
 )
 
 type versionResponse struct {
-	VersionID      int64          `json:"version_id"`
-	VersionNo      int64          `json:"version_no"`
-	Entrypoint     string         `json:"entrypoint"`
+	VersionID              int64           `json:"version_id"`
+	VersionNo              int64           `json:"version_no"`
+	Entrypoint             string          `json:"entrypoint"`
+	TimeoutSeconds         *int            `json:"timeout_seconds,omitempty"`
+	ParamsSchema           json.RawMessage `json:"params_schema,omitempty"`
+	ArtifactSHA256         string          `json:"artifact_sha256"`
+	ArtifactSizeBytes      *int64          `json:"artifact_size_bytes,omitempty"`
+	TowerfileTOML          *string         `json:"towerfile_toml,omitempty"`
+	ImportPaths            []string        `json:"import_paths,omitempty"`
+	SetupScript            *string         `json:"setup_script,omitempty"`
+	TowerfileSchemaVersion int             `json:"towerfile_schema_version"`
+	AtMostOnce             bool            `json:"at_most_once,omitempty"`
+	// MaxRetries is the default max_retries of the version's runs, from
+	// the Towerfile's [app.retries].
+	MaxRetries *int `json:"max_retries,omitempty"`
+	// PythonVersion is the interpreter version the version's runs need,
+	// from the Towerfile's app.python.
+	PythonVersion *string `json:"python_version,omitempty"`
+	// ArtifactCorrupt is set when object verification found the artifact
+	// missing or corrupted; runs cannot be created from the version.
+	ArtifactCorrupt bool `json:"artifact_corrupt,omitempty"`
+	// Commands are the named entrypoints runs can select with command.
+	Commands []versionCommand `json:"commands,omitempty"`
+	// DefaultInput is the app's default run input, merged under the input
+	// of every new run. Only the list and get responses carry it.
+	DefaultInput map[string]any `json:"default_input,omitempty"`
+	// Labels are the app's labels that point at this version.
+	Labels []string `json:"labels,omitempty"`
+	// Parameters lists the Towerfile's parameters in declared order. Only
+	// the upload response carries it.
+	Parameters []versionParameter `json:"parameters,omitempty"`
+	// CompatibilityWarnings lists params schema changes since the previous
+	// version that can break existing callers. Only the upload response
+	// carries it; the upload is never rejected for them.
+	CompatibilityWarnings []compatibilityWarning `json:"compatibility_warnings,omitempty"`
+	// PromotedFrom names the version this one was promoted from.
+	PromotedFrom *versionOrigin `json:"promoted_from,omitempty"`
+	CreatedAt    string         `json:"created_at"`
+}
+
+type versionOrigin struct {
+	App       string `json:"app"`
+	VersionNo int64  `json:"version_no"`
+}
+
+type compatibilityWarning struct {
+	Kind      string `json:"kind"`
+	Parameter string `json:"parameter"`
+	Message   string `json:"message"`
+}
+
+// newCompatibilityWarnings compares a new params schema with the previous
+// version's; previous is nil for an app's first version.
+func newCompatibilityWarnings(previous *store.AppVersion, paramsSchema map[string]any) []compatibilityWarning {
+	if previous == nil {
+		return nil
+	}
+	changes := validate.DiffParamsSchema(previous.ParamsSchema, paramsSchema)
+	if len(changes) == 0 {
+		return nil
+	}
+	out := make([]compatibilityWarning, 0, len(changes))
+	for _, c := range changes {
+		out = append(out, compatibilityWarning{Kind: c.Kind, Parameter: c.Parameter, Message: c.Message})
+	}
+	return out
+}
+
+type versionParameter struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// newVersionParameters summarizes Towerfile parameters, defaulting an
+// omitted type to string as the params schema does.
+func newVersionParameters(params []towerfile.Parameter) []versionParameter {
+	out := make([]versionParameter, 0, len(params))
+	for _, p := range params {
+		typ := p.Type
+		if typ == "" {
+			typ = "string"
+		}
+		out = append(out, versionParameter{Name: p.Name, Type: typ})
+	}
+	return out
+}
+
+type versionCommand struct {
+	Name           string         `json:"name"`
+	Script         string         `json:"script"`
 	TimeoutSeconds *int           `json:"timeout_seconds,omitempty"`
 	ParamsSchema   map[string]any `json:"params_schema,omitempty"`
-	ArtifactSHA256 string         `json:"artifact_sha256"`
-	TowerfileTOML  *string        `json:"towerfile_toml,omitempty"`
-	ImportPaths    []string       `json:"import_paths,omitempty"`
-	CreatedAt      string         `json:"created_at"`
+}
+
+func newVersionCommands(commands []store.VersionCommand) []versionCommand {
+	if len(commands) == 0 {
+		return nil
+	}
+	out := make([]versionCommand, 0, len(commands))
+	for _, c := range commands {
+		out = append(out, versionCommand{Name: c.Name, Script: c.Script, TimeoutSeconds: c.TimeoutSeconds, ParamsSchema: c.ParamsSchema})
+	}
+	return out
+}
+
+// versionCommandsFromTowerfile converts [[commands]] entries for storage.
+func versionCommandsFromTowerfile(commands []towerfile.Command) []store.VersionCommand {
+	if len(commands) == 0 {
+		return nil
+	}
+	out := make([]store.VersionCommand, 0, len(commands))
+	for _, c := range commands {
+		vc := store.VersionCommand{Name: c.Name, Script: c.Script, ParamsSchema: towerfile.ParamsSchemaFromParameters(c.Parameters)}
+		if c.Timeout != nil {
+			seconds := c.Timeout.Seconds
+			vc.TimeoutSeconds = &seconds
+		}
+		out = append(out, vc)
+	}
+	return out
 }
 
 type listVersionsResponse struct {
@@ -45,154 +162,338 @@ func (h *Handlers) CreateVersion(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
 	// Extract app slug from path: /api/v1/apps/{app}/versions
 	slug := extractAppSlugFromVersionPath(r.URL.Path)
 	if slug == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "missing app slug")
+		writeAPIError(w, apierror.InvalidRequest, "missing app slug")
 		return
 	}
 
 	app, err := h.store.GetAppBySlug(r.Context(), teamID, slug)
 	if err != nil {
-		h.logger.Error("get app", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if app == nil {
-		writeError(w, http.StatusNotFound, "not_found", "app not found")
+		writeAPIError(w, apierror.NotFound, "app not found")
+		return
+	}
+
+	// A declared length over the limit is refused before reading any of
+	// the body; otherwise the body limit middleware stops reading once the
+	// limit is passed.
+	if r.ContentLength > h.cfg.MaxArtifactSize {
+		writeArtifactTooLarge(w, r.ContentLength, h.cfg.MaxArtifactSize)
 		return
 	}
 
 	// Parse multipart form (32MB max)
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "invalid multipart form")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeArtifactTooLarge(w, r.ContentLength, h.cfg.MaxArtifactSize)
+			return
+		}
+		writeAPIError(w, apierror.InvalidRequest, "invalid multipart form")
 		return
 	}
 
 	// Get artifact file
 	file, _, err := r.FormFile("artifact")
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "artifact file is required")
+		writeAPIError(w, apierror.InvalidRequest, "artifact file is required")
 		return
 	}
 	defer file.Close()
 
-	// Calculate SHA256 while reading into memory.
+	expectedSHA256 := strings.ToLower(strings.TrimSpace(r.FormValue("expected_sha256")))
+
+	// Hash, buffer and check the archive in one pass.
 	hasher := sha256.New()
-	data, err := io.ReadAll(io.TeeReader(file, hasher))
+	var buf bytes.Buffer
+	scan, err := scanArtifact(io.TeeReader(file, io.MultiWriter(hasher, &buf)))
+	if err != nil && expectedSHA256 != "" {
+		// Corruption in transit usually breaks the archive too; hash the
+		// rest so the client hears about the mismatch, not the archive.
+		if _, copyErr := io.Copy(hasher, file); copyErr == nil && writeSHA256Mismatch(w, expectedSHA256, hex.EncodeToString(hasher.Sum(nil))) {
+			return
+		}
+	}
 	if err != nil {
-		h.logger.Error("read artifact", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "failed to read artifact")
+		var invalid *invalidArtifactError
+		if errors.As(err, &invalid) {
+			writeAPIError(w, apierror.InvalidArtifact, "%s", invalid.reason)
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "read artifact", "error", err)
+		writeAPIError(w, apierror.Internal, "failed to read artifact")
 		return
 	}
+	data := buf.Bytes()
 	artifactSHA256 := hex.EncodeToString(hasher.Sum(nil))
+	if expectedSHA256 != "" && writeSHA256Mismatch(w, expectedSHA256, artifactSHA256) {
+		return
+	}
 
-	// Extract and parse the Towerfile from the artifact.
-	towerfileContent, err := extractTowerfileFromArchive(data)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "TOWERFILE_MISSING", err.Error())
+	if scan.towerfile == nil {
+		writeAPIError(w, apierror.TowerfileMissing, "artifact does not contain a Towerfile")
 		return
 	}
+	towerfileContent := string(scan.towerfile)
 
 	tf, err := towerfile.Parse(strings.NewReader(towerfileContent))
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "TOWERFILE_INVALID", fmt.Sprintf("invalid Towerfile: %s", err.Error()))
+		writeAPIError(w, apierror.TowerfileInvalid, "invalid Towerfile: %s", err.Error())
 		return
 	}
-	if err := towerfile.Validate(tf); err != nil {
-		writeError(w, http.StatusBadRequest, "TOWERFILE_INVALID", fmt.Sprintf("invalid Towerfile: %s", err.Error()))
+	// Warnings about unknown keys are the deploying client's to show.
+	if _, err := towerfile.Validate(tf); err != nil {
+		writeAPIError(w, apierror.TowerfileInvalid, "invalid Towerfile: %s", err.Error())
 		return
 	}
 
 	// Derive version metadata from the Towerfile.
 	entrypoint := tf.App.Script
+	if !scan.files[normalizeArtifactPath(entrypoint)] {
+		writeAPIError(w, apierror.InvalidArtifact, "entrypoint %s not present in archive", entrypoint)
+		return
+	}
+	for _, cmd := range tf.Commands {
+		if !scan.files[normalizeArtifactPath(cmd.Script)] {
+			writeAPIError(w, apierror.InvalidArtifact, "command %s script %s not present in archive", cmd.Name, cmd.Script)
+			return
+		}
+	}
+	commands := versionCommandsFromTowerfile(tf.Commands)
 	var timeoutSeconds *int
 	if tf.App.Timeout != nil {
 		timeoutSeconds = &tf.App.Timeout.Seconds
 	}
+	var maxRetries *int
+	if tf.App.Retries != nil {
+		maxRetries = &tf.App.Retries.Max
+	}
+	var pythonVersion *string
+	if tf.App.Python != "" {
+		pythonVersion = &tf.App.Python
+	}
 	paramsSchema := towerfile.ParamsSchemaFromParameters(tf.Parameters)
+	var setupScript *string
+	if tf.App.Setup != "" {
+		setupScript = &tf.App.Setup
+	}
+
+	previous, err := h.store.GetLatestVersion(r.Context(), app.ID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get latest version", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
 
 	objectKey := fmt.Sprintf("%d/%s.tar.gz", app.ID, uuid.NewString())
 
 	// Store artifact.
 	if err := h.objects.Store(objectKey, bytes.NewReader(data)); err != nil {
-		h.logger.Error("store artifact", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "failed to store artifact")
+		h.logger.ErrorContext(r.Context(), "store artifact", "error", err)
+		writeAPIError(w, apierror.Internal, "failed to store artifact")
 		return
 	}
 
 	// Create version record.
 	version, err := h.store.CreateVersion(
-		r.Context(), app.ID, objectKey, artifactSHA256, entrypoint,
-		timeoutSeconds, paramsSchema, &towerfileContent, tf.App.ImportPaths,
+		r.Context(), app.ID, objectKey, artifactSHA256, int64(len(data)), entrypoint,
+		timeoutSeconds, paramsSchema, &towerfileContent, tf.App.ImportPaths, setupScript, tf.EffectiveSchemaVersion(), tf.App.AtMostOnce, maxRetries, pythonVersion, commands,
 	)
 	if err != nil {
-		h.logger.Error("create version", "error", err)
+		h.logger.ErrorContext(r.Context(), "create version", "error", err)
 		_ = h.objects.Delete(objectKey)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
+	// A Towerfile without [app.default_input] leaves the app's current
+	// defaults in place, so defaults set through the API survive deploys.
+	if tf.App.DefaultInput != nil {
+		if err := h.store.SetAppDefaultInput(r.Context(), app.ID, tf.App.DefaultInput); err != nil {
+			h.logger.ErrorContext(r.Context(), "set app default input", "error", err)
+			writeAPIError(w, apierror.Internal, "internal error")
+			return
+		}
+	}
+
+	h.audit(r, AuditVersionCreate, "version", version.ID, map[string]any{"app": app.Slug, "version_no": version.VersionNo, "artifact_sha256": artifactSHA256})
 	writeJSON(w, http.StatusCreated, versionResponse{
-		VersionID:      version.ID,
-		VersionNo:      version.VersionNo,
-		Entrypoint:     entrypoint,
-		TimeoutSeconds: timeoutSeconds,
-		ParamsSchema:   paramsSchema,
-		ArtifactSHA256: artifactSHA256,
-		TowerfileTOML:  &towerfileContent,
-		ImportPaths:    tf.App.ImportPaths,
-		CreatedAt:      version.CreatedAt.Format(time.RFC3339),
+		VersionID:              version.ID,
+		VersionNo:              version.VersionNo,
+		Entrypoint:             entrypoint,
+		TimeoutSeconds:         timeoutSeconds,
+		ParamsSchema:           version.ParamsSchemaJSON,
+		ArtifactSHA256:         artifactSHA256,
+		ArtifactSizeBytes:      version.ArtifactSizeBytes,
+		TowerfileTOML:          &towerfileContent,
+		ImportPaths:            tf.App.ImportPaths,
+		SetupScript:            setupScript,
+		TowerfileSchemaVersion: version.TowerfileSchemaVersion,
+		AtMostOnce:             version.AtMostOnce,
+		MaxRetries:             version.MaxRetries,
+		PythonVersion:          version.PythonVersion,
+		Commands:               newVersionCommands(version.Commands),
+		Parameters:             newVersionParameters(tf.Parameters),
+		CompatibilityWarnings:  newCompatibilityWarnings(previous, paramsSchema),
+		CreatedAt:              formatTime(version.CreatedAt),
 	})
 }
 
-const (
-	maxTowerfileEntries = 50
-	maxTowerfileSize    = 256 * 1024 // 256 KB
-)
+const maxTowerfileSize = 256 * 1024 // 256 KB
+
+// invalidArtifactError is why an uploaded archive was rejected, worded for
+// the uploader.
+type invalidArtifactError struct {
+	reason string
+}
+
+func (e *invalidArtifactError) Error() string { return e.reason }
 
-// extractTowerfileFromArchive scans a tar.gz archive for a Towerfile at the
-// archive root. Returns the file content as a string. Only scans the first N
-// entries and rejects oversized Towerfile entries to prevent tar bombs.
-func extractTowerfileFromArchive(data []byte) (string, error) {
-	gr, err := gzip.NewReader(bytes.NewReader(data))
+// artifactScan is what scanArtifact learned about an archive.
+type artifactScan struct {
+	// files holds the normalized paths of the non-directory entries.
+	files map[string]bool
+	// towerfile is the root Towerfile's content, nil when there is none.
+	towerfile []byte
+}
+
+// scanArtifact reads a tar.gz archive to its end, so a reader teed into a
+// hash sees every byte. It rejects archives that do not decompress or
+// untar, hold no regular file, or have an absolute or ".." entry path, and
+// rejects a root Towerfile over maxTowerfileSize. Errors other than
+// *invalidArtifactError come from reading r.
+// writeArtifactTooLarge writes the 413 for an upload over limit. size is
+// the upload's length, or -1 when the client did not declare one.
+func writeArtifactTooLarge(w http.ResponseWriter, size, limit int64) {
+	code := apierror.ArtifactTooLarge
+	if size < 0 {
+		httputil.WriteJSON(w, code.Status(), httputil.ErrorEnvelope{Error: httputil.ErrorBody{
+			Code:      string(code),
+			Message:   fmt.Sprintf("upload exceeds the artifact limit of %d bytes", limit),
+			RequestID: w.Header().Get(httputil.RequestIDHeader),
+			Limit:     &limit,
+		}})
+		return
+	}
+	httputil.WriteErrorLimit(w, code.Status(), string(code),
+		fmt.Sprintf("upload is %d bytes, the artifact limit is %d bytes", size, limit), size, limit)
+}
+
+// writeSHA256Mismatch writes the 422 for an upload whose sha256 is not the
+// expected one and reports whether it did.
+func writeSHA256Mismatch(w http.ResponseWriter, expected, actual string) bool {
+	if expected == actual {
+		return false
+	}
+	httputil.WriteErrorSHA256(w, apierror.SHA256Mismatch.Status(), string(apierror.SHA256Mismatch),
+		fmt.Sprintf("artifact sha256 is %s, expected %s", actual, expected), expected, actual)
+	return true
+}
+
+func scanArtifact(r io.Reader) (*artifactScan, error) {
+	src := &errRecordingReader{r: r}
+	invalid := func(reason string) error {
+		if src.err != nil {
+			return src.err
+		}
+		return &invalidArtifactError{reason}
+	}
+
+	gr, err := gzip.NewReader(src)
 	if err != nil {
-		return "", fmt.Errorf("artifact is not a valid gzip archive")
+		return nil, invalid("artifact is not a valid gzip archive")
 	}
 	defer gr.Close()
 
+	scan := &artifactScan{files: make(map[string]bool)}
+	regular := 0
 	tr := tar.NewReader(gr)
-	for i := 0; i < maxTowerfileEntries; i++ {
+	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("artifact is not a valid tar archive")
+			return nil, invalid("artifact is not a valid tar archive")
 		}
 
-		// Match "Towerfile" at the archive root (no directory prefix).
-		name := strings.TrimPrefix(hdr.Name, "./")
-		if name != "Towerfile" {
+		if unsafeArchivePath(hdr.Name) {
+			return nil, &invalidArtifactError{fmt.Sprintf("archive entry %q escapes the archive root", hdr.Name)}
+		}
+		name := normalizeArtifactPath(hdr.Name)
+		if hdr.Typeflag == tar.TypeDir || name == "" {
 			continue
 		}
+		scan.files[name] = true
+		if hdr.Typeflag == tar.TypeReg {
+			regular++
+		}
 
+		// Match "Towerfile" at the archive root (no directory prefix).
+		if name != "Towerfile" || scan.towerfile != nil {
+			continue
+		}
 		if hdr.Size > maxTowerfileSize {
-			return "", fmt.Errorf("Towerfile exceeds maximum size of %d bytes", maxTowerfileSize)
+			return nil, &invalidArtifactError{fmt.Sprintf("Towerfile exceeds maximum size of %d bytes", maxTowerfileSize)}
 		}
-
 		content, err := io.ReadAll(io.LimitReader(tr, maxTowerfileSize+1))
 		if err != nil {
-			return "", fmt.Errorf("reading Towerfile from archive: %w", err)
+			return nil, invalid("artifact is not a valid tar archive")
 		}
-		return string(content), nil
+		scan.towerfile = content
+	}
+	if regular == 0 {
+		return nil, invalid("artifact contains no regular files")
+	}
+
+	// Read past the tar trailer and any trailing bytes so the hash covers
+	// the whole upload.
+	if _, err := io.Copy(io.Discard, gr); err != nil {
+		return nil, invalid("artifact is not a valid gzip archive")
+	}
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		return nil, err
+	}
+	return scan, nil
+}
+
+// errRecordingReader remembers the first error other than io.EOF from r,
+// telling a failed upload read apart from a malformed archive.
+type errRecordingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errRecordingReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
 	}
+	return n, err
+}
 
-	return "", fmt.Errorf("artifact does not contain a Towerfile")
+// unsafeArchivePath reports whether an entry name is absolute or climbs
+// out of the archive root with "..".
+func unsafeArchivePath(name string) bool {
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return true
+	}
+	for _, seg := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if seg == ".." {
+			return true
+		}
+	}
+	return false
 }
 
 // ListVersions returns all versions for an app.
@@ -204,52 +505,342 @@ func (h *Handlers) ListVersions(w http.ResponseWriter, r *http.Request) {
 
 	teamID, ok := teamIDFromContext(r.Context())
 	if !ok {
-		writeError(w, http.StatusUnauthorized, "unauthorized", "missing team context")
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
 		return
 	}
 
 	slug := extractAppSlugFromVersionPath(r.URL.Path)
 	if slug == "" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "missing app slug")
+		writeAPIError(w, apierror.InvalidRequest, "missing app slug")
 		return
 	}
 
 	app, err := h.store.GetAppBySlug(r.Context(), teamID, slug)
 	if err != nil {
-		h.logger.Error("get app", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 	if app == nil {
-		writeError(w, http.StatusNotFound, "not_found", "app not found")
+		writeAPIError(w, apierror.NotFound, "app not found")
 		return
 	}
 
 	versions, err := h.store.ListVersions(r.Context(), app.ID)
 	if err != nil {
-		h.logger.Error("list versions", "error", err)
-		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+		h.logger.ErrorContext(r.Context(), "list versions", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	labels, err := h.store.ListVersionLabels(r.Context(), app.ID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "list version labels", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
 		return
 	}
 
 	resp := listVersionsResponse{Versions: make([]versionResponse, 0, len(versions))}
 	for _, v := range versions {
-		resp.Versions = append(resp.Versions, versionResponse{
-			VersionID:      v.ID,
-			VersionNo:      v.VersionNo,
-			Entrypoint:     v.Entrypoint,
-			TimeoutSeconds: v.TimeoutSeconds,
-			ParamsSchema:   v.ParamsSchema,
-			ArtifactSHA256: v.ArtifactSHA256,
-			TowerfileTOML:  v.TowerfileTOML,
-			ImportPaths:    v.ImportPaths,
-			CreatedAt:      v.CreatedAt.Format(time.RFC3339),
-		})
+		vr := newVersionResponse(v, labels[v.ID])
+		vr.DefaultInput = app.DefaultInput
+		resp.Versions = append(resp.Versions, vr)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetVersion returns one version of an app.
+// GET /api/v1/apps/{app}/versions/{version_no}
+func (h *Handlers) GetVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	version, ok := h.versionFromPath(w, r, "")
+	if !ok {
+		return
+	}
+
+	app, err := h.store.GetAppByIDDirect(r.Context(), version.AppID)
+	if err != nil || app == nil {
+		h.logger.ErrorContext(r.Context(), "get app", "app_id", version.AppID, "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	labels, err := h.store.ListVersionLabels(r.Context(), version.AppID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "list version labels", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
 	}
 
+	resp := newVersionResponse(version, labels[version.ID])
+	resp.DefaultInput = app.DefaultInput
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// GetAppSchema returns the params schema of an app's latest version, or of
+// the version named by the version_no or version_label query parameter, as
+// the bare JSON object stored at deploy time. A version without parameters
+// has the empty schema {}.
+// GET /api/v1/apps/{app}/schema
+func (h *Handlers) GetAppSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamID, ok := teamIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierror.Unauthorized, "missing team context")
+		return
+	}
+
+	versionNo, err := parseVersionNoFilter(r)
+	if err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "%v", err)
+		return
+	}
+	var versionNoPtr *int64
+	if versionNo != 0 {
+		versionNoPtr = &versionNo
+	}
+	label := strings.TrimSpace(r.URL.Query().Get("version_label"))
+
+	slug := extractAppSlugFromVersionPath(r.URL.Path)
+	app, err := h.store.GetAppBySlug(r.Context(), teamID, slug)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if app == nil {
+		writeAPIError(w, apierror.NotFound, "app not found")
+		return
+	}
+
+	version, ok := h.lookupRunVersion(w, r, app.ID, versionNoPtr, label)
+	if !ok {
+		return
+	}
+
+	schema := version.ParamsSchemaJSON
+	if schema == nil {
+		schema = json.RawMessage("{}")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(schema)
+}
+
+func newVersionResponse(v *store.AppVersion, labels []string) versionResponse {
+	return versionResponse{
+		VersionID:              v.ID,
+		VersionNo:              v.VersionNo,
+		Entrypoint:             v.Entrypoint,
+		TimeoutSeconds:         v.TimeoutSeconds,
+		ParamsSchema:           v.ParamsSchemaJSON,
+		ArtifactSHA256:         v.ArtifactSHA256,
+		ArtifactSizeBytes:      v.ArtifactSizeBytes,
+		TowerfileTOML:          v.TowerfileTOML,
+		ImportPaths:            v.ImportPaths,
+		SetupScript:            v.SetupScript,
+		TowerfileSchemaVersion: v.TowerfileSchemaVersion,
+		AtMostOnce:             v.AtMostOnce,
+		MaxRetries:             v.MaxRetries,
+		PythonVersion:          v.PythonVersion,
+		ArtifactCorrupt:        v.ArtifactCorrupt,
+		Commands:               newVersionCommands(v.Commands),
+		Labels:                 labels,
+		PromotedFrom:           newVersionOrigin(v),
+		CreatedAt:              formatTime(v.CreatedAt),
+	}
+}
+
+func newVersionOrigin(v *store.AppVersion) *versionOrigin {
+	if v.PromotedFromApp == nil || v.PromotedFromVersionNo == nil {
+		return nil
+	}
+	return &versionOrigin{App: *v.PromotedFromApp, VersionNo: *v.PromotedFromVersionNo}
+}
+
+type promoteVersionRequest struct {
+	TargetApp string `json:"target_app"`
+}
+
+// PromoteVersion copies a version to another app of the team, sharing its
+// artifact so the promoted version runs exactly the bytes that were tested.
+// POST /api/v1/apps/{app}/versions/{version_no}/promote
+func (h *Handlers) PromoteVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req promoteVersionRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "malformed JSON body")
+		return
+	}
+	req.TargetApp = strings.TrimSpace(req.TargetApp)
+	if req.TargetApp == "" {
+		writeAPIError(w, apierror.InvalidRequest, "target_app is required")
+		return
+	}
+
+	source, ok := h.versionFromPath(w, r, "promote")
+	if !ok {
+		return
+	}
+	sourceSlug := extractAppSlugFromVersionPath(r.URL.Path)
+	if req.TargetApp == sourceSlug {
+		writeAPIError(w, apierror.InvalidRequest, "target_app must be a different app")
+		return
+	}
+
+	// Only apps of the caller's team are visible, so another team's app is
+	// not found like any other.
+	teamID, _ := teamIDFromContext(r.Context())
+	target, err := h.store.GetAppBySlug(r.Context(), teamID, req.TargetApp)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get app", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if target == nil {
+		writeAPIError(w, apierror.NotFound, "target app not found")
+		return
+	}
+
+	version, err := h.store.PromoteVersion(r.Context(), source, sourceSlug, target.ID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "promote version", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	if version == nil {
+		writeAPIError(w, apierror.NotFound, "version not found")
+		return
+	}
+
+	h.audit(r, AuditVersionPromote, "version", version.ID, map[string]any{
+		"app": target.Slug, "version_no": version.VersionNo, "source_app": sourceSlug, "source_version_no": source.VersionNo,
+	})
+	writeJSON(w, http.StatusCreated, newVersionResponse(version, nil))
+}
+
+type setVersionLabelRequest struct {
+	Label string `json:"label"`
+}
+
+// SetVersionLabel points a label at a version, moving it off the version it
+// was on before.
+// POST /api/v1/apps/{app}/versions/{version_no}/labels
+func (h *Handlers) SetVersionLabel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req setVersionLabelRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "malformed JSON body")
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+	if err := validate.ValidateLabel(req.Label); err != nil {
+		writeAPIError(w, apierror.InvalidRequest, "%v", err)
+		return
+	}
+
+	version, ok := h.versionFromPath(w, r, "labels")
+	if !ok {
+		return
+	}
+
+	if err := h.store.SetVersionLabel(r.Context(), version.AppID, version.ID, req.Label); err != nil {
+		h.logger.ErrorContext(r.Context(), "set version label", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+	labels, err := h.store.ListVersionLabels(r.Context(), version.AppID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "list version labels", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	h.audit(r, AuditVersionLabel, "version", version.ID, map[string]any{"version_no": version.VersionNo, "label": req.Label})
+	writeJSON(w, http.StatusOK, newVersionResponse(version, labels[version.ID]))
+}
+
+type versionStatsResponse struct {
+	VersionNo    int64            `json:"version_no"`
+	TotalRuns    int64            `json:"total_runs"`
+	RunsByStatus map[string]int64 `json:"runs_by_status"`
+	FirstRunAt   *string          `json:"first_run_at"`
+	LastRunAt    *string          `json:"last_run_at"`
+	// The durations are execution times, from start to finish, of the
+	// version's runs that have both.
+	DurationAvgSeconds *float64 `json:"duration_avg_seconds"`
+	DurationP50Seconds *float64 `json:"duration_p50_seconds"`
+	DurationP95Seconds *float64 `json:"duration_p95_seconds"`
+}
+
+// GetVersionStats returns run counts by status, execution durations and the
+// first and last run times of one version.
+// GET /api/v1/apps/{app}/versions/{version_no}/stats
+func (h *Handlers) GetVersionStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	version, ok := h.versionFromPath(w, r, "stats")
+	if !ok {
+		return
+	}
+	if !requireScope(w, r, ScopeRunsRead, version.AppID) {
+		return
+	}
+
+	stats, err := h.store.GetVersionStats(r.Context(), version.ID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "get version stats", "error", err)
+		writeAPIError(w, apierror.Internal, "internal error")
+		return
+	}
+
+	resp := versionStatsResponse{
+		VersionNo:          version.VersionNo,
+		TotalRuns:          stats.TotalRuns,
+		RunsByStatus:       stats.RunsByStatus,
+		DurationAvgSeconds: durationSeconds(stats.DurationAvg),
+		DurationP50Seconds: durationSeconds(stats.DurationP50),
+		DurationP95Seconds: durationSeconds(stats.DurationP95),
+	}
+	if stats.FirstRunAt != nil {
+		first := formatTime(*stats.FirstRunAt)
+		resp.FirstRunAt = &first
+	}
+	if stats.LastRunAt != nil {
+		last := formatTime(*stats.LastRunAt)
+		resp.LastRunAt = &last
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// durationSeconds returns d in seconds, or nil for nil.
+func durationSeconds(d *time.Duration) *float64 {
+	if d == nil {
+		return nil
+	}
+	s := d.Seconds()
+	return &s
+}
+
 // extractAppSlugFromVersionPath extracts app slug from /api/v1/apps/{app}/versions
 func extractAppSlugFromVersionPath(path string) string {
 	const prefix = "/api/v1/apps/"
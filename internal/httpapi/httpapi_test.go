@@ -3,18 +3,30 @@ package httpapi_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"minitower/internal/apierror"
 	"minitower/internal/auth"
 	"minitower/internal/config"
 	"minitower/internal/httpapi"
@@ -110,6 +122,211 @@ func TestRunnerRegistrationIsIdempotentByName(t *testing.T) {
 	}
 }
 
+func TestRunnerReregistrationFencesPreviousIncarnation(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-rereg")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-rereg")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 1)
+
+	body := map[string]any{"name": "runner-reimaged", "environment": "default"}
+	first := doRequest(t, handler, http.MethodPost, "/api/v1/runners/register", "test-runner-reg", "", body)
+	defer first.Body.Close()
+	var firstPayload struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(first.Body).Decode(&firstPayload); err != nil {
+		t.Fatalf("decode first registration: %v", err)
+	}
+
+	leaseResp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", firstPayload.Token, "", nil)
+	defer leaseResp.Body.Close()
+	if leaseResp.StatusCode != http.StatusOK {
+		t.Fatalf("expected lease, got %d", leaseResp.StatusCode)
+	}
+	var lease struct {
+		LeaseToken string `json:"lease_token"`
+	}
+	if err := json.NewDecoder(leaseResp.Body).Decode(&lease); err != nil {
+		t.Fatalf("decode lease: %v", err)
+	}
+
+	// The host is re-imaged and registers again under the same name.
+	second := doRequest(t, handler, http.MethodPost, "/api/v1/runners/register", "test-runner-reg", "", body)
+	defer second.Body.Close()
+	if second.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 on re-registration, got %d", second.StatusCode)
+	}
+	var secondPayload struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(second.Body).Decode(&secondPayload); err != nil {
+		t.Fatalf("decode second registration: %v", err)
+	}
+	runner, err := s.GetRunnerByName(ctx, "runner-reimaged")
+	if err != nil {
+		t.Fatalf("get runner: %v", err)
+	}
+	if runner.TokenHash != auth.HashToken(secondPayload.Token) {
+		t.Fatal("expected token hash to be rotated to the new token")
+	}
+
+	oldResp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", firstPayload.Token, "", nil)
+	oldResp.Body.Close()
+	if oldResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("old token should be unauthorized, got %d", oldResp.StatusCode)
+	}
+
+	// The old incarnation's attempt is fenced and the run re-queued at once.
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/heartbeat", secondPayload.Token, lease.LeaseToken, nil)
+	assertGone(t, resp)
+	loaded, err := s.GetRunByID(ctx, team.ID, run.ID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if loaded.Status != "queued" || loaded.RetryCount != 1 {
+		t.Fatalf("expected run re-queued with retry_count 1, got %s/%d", loaded.Status, loaded.RetryCount)
+	}
+
+	release := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", secondPayload.Token, "", nil)
+	release.Body.Close()
+	if release.StatusCode != http.StatusOK {
+		t.Fatalf("expected new incarnation to lease the re-queued run, got %d", release.StatusCode)
+	}
+
+	strict := doRequest(t, handler, http.MethodPost, "/api/v1/runners/register", "test-runner-reg", "",
+		map[string]any{"name": "runner-reimaged", "environment": "default", "rotate": false})
+	assertErrorCode(t, "rotate=false", strict, http.StatusConflict, "runner_exists")
+}
+
+func TestRunnerRegistrationAcceptsEveryConfiguredToken(t *testing.T) {
+	api, _, _, cleanup := newTestAPIWithConfig(t, newFixtureObjects(t), func(cfg *config.Config) {
+		cfg.RunnerRegistrationTokens = []string{"reg-old", "reg-new"}
+	})
+	defer cleanup()
+	handler := checkErrorCodes(t, api.Handler())
+
+	for i, token := range []string{"reg-old", "reg-new"} {
+		body := map[string]any{"name": "runner-rotate-" + itoa(int64(i)), "environment": "default"}
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/runners/register", token, "", body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected registration with %s to succeed, got %d", token, resp.StatusCode)
+		}
+	}
+
+	body := map[string]any{"name": "runner-rotate-bad", "environment": "default"}
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runners/register", "reg-retired", "", body)
+	assertErrorCode(t, "retired token", resp, http.StatusUnauthorized, "unauthorized")
+}
+
+func TestRegisterRunnerConcurrentSameName(t *testing.T) {
+	handler, _, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	const n = 10
+	statuses := make([]int, n)
+	codes := make([]string, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := doRequest(t, handler, http.MethodPost, "/api/v1/runners/register", "test-runner-reg", "",
+				map[string]any{"name": "runner-race", "environment": "default", "rotate": false})
+			defer resp.Body.Close()
+			statuses[i] = resp.StatusCode
+			var env struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			_ = json.NewDecoder(resp.Body).Decode(&env)
+			codes[i] = env.Error.Code
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for i := range n {
+		switch {
+		case statuses[i] == http.StatusCreated:
+			created++
+		case statuses[i] != http.StatusConflict || codes[i] != "runner_exists":
+			t.Errorf("registration %d: expected 201 or 409 runner_exists, got %d %q", i, statuses[i], codes[i])
+		}
+	}
+	if created != 1 {
+		t.Fatalf("expected exactly one registration to succeed, got %d", created)
+	}
+}
+
+func TestRunnerRegistrationDeclaresPythonVersions(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-python")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-python")
+	python := "3.12"
+	version, err := s.CreateVersion(ctx, app.ID, "objects/fixture.tar.gz", "sha256", 0, "main.py", nil, nil, nil, nil, nil, 6, false, nil, &python, nil)
+	if err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	register := func(versions []string) string {
+		t.Helper()
+		var reg struct {
+			Token string `json:"token"`
+		}
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/runners/register", "test-runner-reg", "",
+			map[string]any{"name": "runner-python", "environment": "default", "python_versions": versions})
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+			t.Fatalf("register: status %d", resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&reg); err != nil {
+			t.Fatalf("decode registration: %v", err)
+		}
+		resp.Body.Close()
+		return reg.Token
+	}
+
+	// Without 3.12 declared, the run is not leased.
+	token := register(nil)
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", token, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 for a runner without python 3.12, got %d", resp.StatusCode)
+	}
+
+	token = register([]string{"3.12", "3.10"})
+	var lease struct {
+		RunID         int64  `json:"run_id"`
+		PythonVersion string `json:"python_version"`
+	}
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", token, "", nil)
+	decodeStatus(t, resp, http.StatusOK, &lease)
+	if lease.RunID != run.ID || lease.PythonVersion != "3.12" {
+		t.Fatalf("expected run %d leased with python_version 3.12, got %+v", run.ID, lease)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runners/register", "test-runner-reg", "",
+		map[string]any{"name": "runner-python-bad", "python_versions": []string{"3.12.1"}})
+	assertErrorCode(t, "bad python version", resp, http.StatusBadRequest, "invalid_request")
+}
+
 func TestRunnerEndpointsRejectStaleLeaseToken(t *testing.T) {
 	handler, s, _, cleanup := newTestServer(t)
 	defer cleanup()
@@ -180,15 +397,169 @@ func TestRunnerEndpointsRejectLeaseOwnedByAnotherRunner(t *testing.T) {
 	version := testutil.CreateVersion(t, s, app.ID)
 	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
 
-	ownerRunner, _ := testutil.CreateRunner(t, s, "runner-owner", "default")
+	ownerRunner, ownerToken := testutil.CreateRunner(t, s, "runner-owner", "default")
 	_, attackerToken := testutil.CreateRunner(t, s, "runner-attacker", "default")
 	leaseToken, leaseHash, _ := auth.GenerateToken()
 	if _, _, err := s.LeaseRun(ctx, ownerRunner, leaseHash, time.Minute); err != nil {
 		t.Fatalf("lease run: %v", err)
 	}
 
-	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/start", attackerToken, leaseToken, nil)
-	assertGone(t, resp)
+	runPath := "/api/v1/runs/" + itoa(run.ID)
+	logsBody := map[string]any{
+		"logs": []map[string]any{{"seq": 1, "stream": "stdout", "line": "hello", "logged_at": time.Now().Format(time.RFC3339)}},
+	}
+	for _, tc := range []struct {
+		method, path string
+		body         any
+	}{
+		{http.MethodPost, runPath + "/start", nil},
+		{http.MethodPost, runPath + "/heartbeat", nil},
+		{http.MethodPost, runPath + "/logs", logsBody},
+		{http.MethodPost, runPath + "/result", map[string]any{"status": "completed", "exit_code": 0}},
+		{http.MethodGet, runPath + "/artifact", nil},
+	} {
+		resp := doRequest(t, handler, tc.method, tc.path, attackerToken, leaseToken, tc.body)
+		assertGone(t, resp)
+	}
+	assertGone(t, uploadRunOutput(t, handler, attackerToken, leaseToken, run.ID, "report.csv", []byte("a,b\n")))
+
+	// None of that touched the owner's attempt.
+	resp := doRequest(t, handler, http.MethodPost, runPath+"/start", ownerToken, leaseToken, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the owning runner to start its attempt, got %d", resp.StatusCode)
+	}
+}
+
+func TestStartRunRecordsEnvSnapshotAndAttemptsRedactSecrets(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, teamToken := testutil.CreateTeam(t, s, "team-env-snapshot")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-env-snapshot")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-env-snapshot", "default")
+	_, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	runPath := "/api/v1/runs/" + itoa(run.ID)
+	resp := doRequest(t, handler, http.MethodPost, runPath+"/start", runnerToken, leaseToken, map[string]any{
+		"env_snapshot": map[string]string{
+			"region":               "eu-west-1",
+			"DB_PASSWORD":          "hunter2",
+			"github_token":         "ghp_abc",
+			"MINITOWER_INPUT_PATH": "<workspace>/input.json",
+		},
+	})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected start to succeed, got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, runPath+"/attempts", teamToken, "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 listing attempts, got %d", resp.StatusCode)
+	}
+	var listed struct {
+		RunID    int64 `json:"run_id"`
+		Attempts []struct {
+			AttemptNo       int64             `json:"attempt_no"`
+			Status          string            `json:"status"`
+			EnvSnapshot     map[string]string `json:"env_snapshot"`
+			EnvSnapshotNote *string           `json:"env_snapshot_note"`
+		} `json:"attempts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode attempts: %v", err)
+	}
+	resp.Body.Close()
+	if listed.RunID != run.ID || len(listed.Attempts) != 1 {
+		t.Fatalf("unexpected attempts response %+v", listed)
+	}
+	got := listed.Attempts[0]
+	if got.AttemptNo != 1 || got.Status != "running" {
+		t.Fatalf("unexpected attempt %+v", got)
+	}
+	want := map[string]string{
+		"region":               "eu-west-1",
+		"DB_PASSWORD":          "***",
+		"github_token":         "***",
+		"MINITOWER_INPUT_PATH": "<workspace>/input.json",
+	}
+	if len(got.EnvSnapshot) != len(want) {
+		t.Fatalf("env snapshot = %v, want %v", got.EnvSnapshot, want)
+	}
+	for key, value := range want {
+		if got.EnvSnapshot[key] != value {
+			t.Fatalf("env snapshot[%s] = %q, want %q", key, got.EnvSnapshot[key], value)
+		}
+	}
+
+	// The stored snapshot keeps the real values; only responses redact.
+	snapshots, err := s.ListAttemptEnvSnapshots(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("list env snapshots: %v", err)
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Env["DB_PASSWORD"] != "hunter2" {
+			t.Fatalf("expected stored snapshot to keep the value, got %v", snapshot.Env)
+		}
+	}
+
+	// Another team cannot list the run's attempts.
+	_, otherToken := testutil.CreateTeam(t, s, "team-env-snapshot-other")
+	resp = doRequest(t, handler, http.MethodGet, runPath+"/attempts", otherToken, "", nil)
+	assertErrorCode(t, "other team attempts", resp, http.StatusNotFound, "not_found")
+}
+
+func TestStartRunDropsOversizedEnvSnapshotWithNote(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, teamToken := testutil.CreateTeam(t, s, "team-env-oversized")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-env-oversized")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-env-oversized", "default")
+	_, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	runPath := "/api/v1/runs/" + itoa(run.ID)
+	resp := doRequest(t, handler, http.MethodPost, runPath+"/start", runnerToken, leaseToken, map[string]any{
+		"env_snapshot": map[string]string{"blob": strings.Repeat("x", 70<<10)},
+	})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected an oversized snapshot not to fail the start, got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, runPath+"/attempts", teamToken, "", nil)
+	var listed struct {
+		Attempts []map[string]any `json:"attempts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode attempts: %v", err)
+	}
+	resp.Body.Close()
+	if len(listed.Attempts) != 1 {
+		t.Fatalf("expected one attempt, got %d", len(listed.Attempts))
+	}
+	if _, ok := listed.Attempts[0]["env_snapshot"]; ok {
+		t.Fatal("expected the oversized snapshot to be dropped")
+	}
+	note, _ := listed.Attempts[0]["env_snapshot_note"].(string)
+	if !strings.Contains(note, "exceeds the 65536 byte limit") {
+		t.Fatalf("unexpected env snapshot note %q", note)
+	}
 }
 
 func TestRunnerStartHeartbeatResponsesIncludeLeaseFields(t *testing.T) {
@@ -226,180 +597,600 @@ func TestRunnerStartHeartbeatResponsesIncludeLeaseFields(t *testing.T) {
 	assertLeaseFields(t, resp.Body)
 }
 
-func TestRunnerStartReturnsCancellingWhenRunIsCancelling(t *testing.T) {
+func TestLeaseResponseIncludesParamsSchema(t *testing.T) {
 	handler, s, _, cleanup := newTestServer(t)
 	defer cleanup()
 
 	ctx := context.Background()
-	team, teamToken := testutil.CreateTeam(t, s, "team-start-cancel-http")
+	team, _ := testutil.CreateTeam(t, s, "team-schema")
 	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
 	if err != nil {
 		t.Fatalf("get env: %v", err)
 	}
-	app := testutil.CreateApp(t, s, team.ID, "app-start-cancel-http")
-	version := testutil.CreateVersion(t, s, app.ID)
-	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
-
-	runner, runnerToken := testutil.CreateRunner(t, s, "runner-start-cancel-http", "default")
-	leaseToken, leaseHash, _ := auth.GenerateToken()
-	if _, _, err := s.LeaseRun(ctx, runner, leaseHash, time.Minute); err != nil {
-		t.Fatalf("lease run: %v", err)
+	app := testutil.CreateApp(t, s, team.ID, "app-schema")
+	schema := map[string]any{
+		"type":     "object",
+		"required": []any{"name"},
+		"properties": map[string]any{
+			"name": map[string]any{"type": "string"},
+		},
 	}
-
-	cancelResp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/cancel", teamToken, "", nil)
-	defer cancelResp.Body.Close()
-	if cancelResp.StatusCode != http.StatusOK {
-		t.Fatalf("cancel status: %d", cancelResp.StatusCode)
+	version, err := s.CreateVersion(ctx, app.ID, "objects/fixture.tar.gz", "sha256", 0, "main.py", nil, schema, nil, nil, nil, 1, false, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("create version: %v", err)
 	}
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	_, runnerToken := testutil.CreateRunner(t, s, "runner-schema", "default")
 
-	startResp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/start", runnerToken, leaseToken, nil)
-	defer startResp.Body.Close()
-	if startResp.StatusCode != http.StatusOK {
-		t.Fatalf("start status: %d", startResp.StatusCode)
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", runnerToken, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("lease status: %d", resp.StatusCode)
 	}
 
-	var payload map[string]any
-	if err := json.NewDecoder(startResp.Body).Decode(&payload); err != nil {
-		t.Fatalf("decode start response: %v", err)
+	var payload struct {
+		ParamsSchema map[string]any `json:"params_schema"`
 	}
-	if payload["status"] != "cancelling" {
-		t.Fatalf("expected cancelling status, got %v", payload["status"])
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode lease: %v", err)
 	}
-	if payload["cancel_requested"] != true {
-		t.Fatalf("expected cancel_requested true, got %v", payload["cancel_requested"])
+	if payload.ParamsSchema["type"] != "object" {
+		t.Fatalf("expected params_schema in lease response, got %#v", payload.ParamsSchema)
 	}
-	if payload["run_status"] != "cancelling" {
-		t.Fatalf("expected run_status cancelling, got %v", payload["run_status"])
+	required, ok := payload.ParamsSchema["required"].([]any)
+	if !ok || len(required) != 1 || required[0] != "name" {
+		t.Fatalf("unexpected params_schema.required: %#v", payload.ParamsSchema["required"])
 	}
 }
 
-func TestCancelRunEndpointQueued(t *testing.T) {
+func TestLeaseResponseIncludesTeamAndEnvironment(t *testing.T) {
 	handler, s, _, cleanup := newTestServer(t)
 	defer cleanup()
 
 	ctx := context.Background()
-	team, token := testutil.CreateTeam(t, s, "team-cancel-api")
+	team, _ := testutil.CreateTeam(t, s, "team-lease-context")
 	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
 	if err != nil {
 		t.Fatalf("get env: %v", err)
 	}
-	app := testutil.CreateApp(t, s, team.ID, "app-cancel-api")
+	app := testutil.CreateApp(t, s, team.ID, "app-lease-context")
 	version := testutil.CreateVersion(t, s, app.ID)
-	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	_, runnerToken := testutil.CreateRunner(t, s, "runner-lease-context", "default")
 
-	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/cancel", token, "", nil)
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", runnerToken, "", nil)
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("cancel status: %d", resp.StatusCode)
-	}
-
-	var payload map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		t.Fatalf("decode response: %v", err)
+		t.Fatalf("lease status: %d", resp.StatusCode)
 	}
-	if payload["status"] != "cancelled" {
-		t.Fatalf("expected cancelled, got %v", payload["status"])
+	var lease struct {
+		TeamSlug    string `json:"team_slug"`
+		AppSlug     string `json:"app_slug"`
+		Environment string `json:"environment"`
 	}
-	if payload["cancel_requested"] != true {
-		t.Fatalf("expected cancel_requested true")
+	if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+		t.Fatalf("decode lease: %v", err)
 	}
-
-	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/cancel", token, "", nil)
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("cancel idempotent status: %d", resp.StatusCode)
+	if lease.TeamSlug != "team-lease-context" || lease.AppSlug != "app-lease-context" || lease.Environment != "default" {
+		t.Fatalf("expected team, app and environment in lease, got %+v", lease)
 	}
 }
 
-func TestCancelPropagationToRunnerHeartbeat(t *testing.T) {
+func TestLeaseResponseIncludesSetupScript(t *testing.T) {
 	handler, s, _, cleanup := newTestServer(t)
 	defer cleanup()
 
 	ctx := context.Background()
-	team, token := testutil.CreateTeam(t, s, "team-cancel-prop")
+	team, token := testutil.CreateTeam(t, s, "team-setup")
 	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
 	if err != nil {
 		t.Fatalf("get env: %v", err)
 	}
-	app := testutil.CreateApp(t, s, team.ID, "app-cancel-prop")
-	version := testutil.CreateVersion(t, s, app.ID)
-	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
-
-	runner, runnerToken := testutil.CreateRunner(t, s, "runner-cancel-prop", "default")
-	leaseToken, leaseHash, _ := auth.GenerateToken()
-	if _, _, err := s.LeaseRun(ctx, runner, leaseHash, time.Minute); err != nil {
-		t.Fatalf("lease run: %v", err)
+	app := testutil.CreateApp(t, s, team.ID, "app-setup")
+	setup := "scripts/setup.sh"
+	version, err := s.CreateVersion(ctx, app.ID, "objects/fixture.tar.gz", "sha256", 0, "main.sh", nil, nil, nil, nil, &setup, 2, false, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("create version: %v", err)
 	}
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	_, runnerToken := testutil.CreateRunner(t, s, "runner-setup", "default")
 
-	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/cancel", token, "", nil)
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", runnerToken, "", nil)
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("cancel status: %d", resp.StatusCode)
+		t.Fatalf("lease status: %d", resp.StatusCode)
+	}
+	var lease struct {
+		SetupScript string `json:"setup_script"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+		t.Fatalf("decode lease: %v", err)
+	}
+	if lease.SetupScript != setup {
+		t.Fatalf("expected setup_script %q in lease, got %q", setup, lease.SetupScript)
 	}
 
-	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/heartbeat", runnerToken, leaseToken, nil)
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("heartbeat status: %d", resp.StatusCode)
+	versionsResp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/app-setup/versions", token, "", nil)
+	defer versionsResp.Body.Close()
+	var versions struct {
+		Versions []struct {
+			SetupScript *string `json:"setup_script"`
+		} `json:"versions"`
+	}
+	if err := json.NewDecoder(versionsResp.Body).Decode(&versions); err != nil {
+		t.Fatalf("decode versions: %v", err)
+	}
+	if len(versions.Versions) != 1 || versions.Versions[0].SetupScript == nil || *versions.Versions[0].SetupScript != setup {
+		t.Fatalf("expected setup_script on version listing, got %+v", versions.Versions)
 	}
-	assertCancelRequested(t, resp.Body)
 }
 
-func TestCancelResultRace(t *testing.T) {
+func TestCreateRunCoercesStringInputs(t *testing.T) {
 	handler, s, _, cleanup := newTestServer(t)
 	defer cleanup()
 
 	ctx := context.Background()
-	team, token := testutil.CreateTeam(t, s, "team-cancel-race")
-	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
-	if err != nil {
-		t.Fatalf("get env: %v", err)
+	team, token := testutil.CreateTeam(t, s, "team-coerce")
+	app := testutil.CreateApp(t, s, team.ID, "app-coerce")
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"batch_size": map[string]any{"type": "integer"},
+			"verbose":    map[string]any{"type": "boolean"},
+			"name":       map[string]any{"type": "string"},
+		},
 	}
-	app := testutil.CreateApp(t, s, team.ID, "app-cancel-race")
-	version := testutil.CreateVersion(t, s, app.ID)
-	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
-
-	runner, runnerToken := testutil.CreateRunner(t, s, "runner-cancel-race", "default")
-	leaseToken, leaseHash, _ := auth.GenerateToken()
-	if _, _, err := s.LeaseRun(ctx, runner, leaseHash, time.Minute); err != nil {
-		t.Fatalf("lease run: %v", err)
+	if _, err := s.CreateVersion(ctx, app.ID, "objects/fixture.tar.gz", "sha256", 0, "main.py", nil, schema, nil, nil, nil, 1, false, nil, nil, nil); err != nil {
+		t.Fatalf("create version: %v", err)
 	}
+	runsPath := "/api/v1/apps/app-coerce/runs"
+	input := map[string]any{"batch_size": "100", "verbose": "true", "name": "7"}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	var cancelStatus int
-	var resultStatus int
-
-	go func() {
-		defer wg.Done()
-		resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/cancel", token, "", nil)
-		cancelStatus = resp.StatusCode
-		resp.Body.Close()
-	}()
-
-	go func() {
-		defer wg.Done()
-		body := map[string]any{"status": "completed", "exit_code": 0}
-		resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/result", runnerToken, leaseToken, body)
-		resultStatus = resp.StatusCode
-		resp.Body.Close()
-	}()
+	// Without coerce the strings are validated as they are.
+	assertErrorCode(t, "no coerce", doRequest(t, handler, http.MethodPost, runsPath, token, "", map[string]any{"input": input}),
+		http.StatusBadRequest, "invalid_request")
+	assertErrorCode(t, "bad coerce", doRequest(t, handler, http.MethodPost, runsPath+"?coerce=maybe", token, "", map[string]any{"input": input}),
+		http.StatusBadRequest, "invalid_request")
 
-	wg.Wait()
-
-	if cancelStatus != http.StatusOK {
-		t.Fatalf("cancel status: %d", cancelStatus)
+	resp := doRequest(t, handler, http.MethodPost, runsPath+"?coerce=true", token, "", map[string]any{"input": input})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
 	}
-	if resultStatus != http.StatusOK && resultStatus != http.StatusConflict {
-		t.Fatalf("result status: %d", resultStatus)
+	var created struct {
+		RunID         int64          `json:"run_id"`
+		Input         map[string]any `json:"input"`
+		CoercedFields []string       `json:"coerced_fields"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode run: %v", err)
+	}
+	if !reflect.DeepEqual(created.CoercedFields, []string{"$.batch_size", "$.verbose"}) {
+		t.Fatalf("coerced_fields = %v", created.CoercedFields)
 	}
 
-	loaded, err := s.GetRunByID(ctx, team.ID, run.ID)
-	if err != nil {
+	// The stored input holds the converted values.
+	run, err := s.GetRunByID(ctx, team.ID, created.RunID)
+	if err != nil || run == nil {
 		t.Fatalf("get run: %v", err)
 	}
-	if loaded.Status != "cancelled" && loaded.Status != "cancelling" && loaded.Status != "completed" {
+	want := map[string]any{"batch_size": float64(100), "verbose": true, "name": "7"}
+	if !reflect.DeepEqual(run.Input, want) {
+		t.Fatalf("stored input = %#v, want %#v", run.Input, want)
+	}
+
+	// Ambiguous or unconvertible values still fail validation.
+	assertErrorCode(t, "overflow", doRequest(t, handler, http.MethodPost, runsPath+"?coerce=true", token, "", map[string]any{
+		"input": map[string]any{"batch_size": "99999999999999999999"},
+	}), http.StatusBadRequest, "invalid_request")
+}
+
+func TestRerunRunMergesOverridesAndRecordsLineage(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-rerun")
+	_, otherToken := testutil.CreateTeam(t, s, "team-rerun-other")
+	app := testutil.CreateApp(t, s, team.ID, "app-rerun")
+	props := map[string]any{
+		"day":      map[string]any{"type": "string"},
+		"fix_mode": map[string]any{"type": "boolean"},
+		"opts":     map[string]any{"type": "object"},
+		"region":   map[string]any{"type": "string"},
+	}
+	v1Schema := map[string]any{"type": "object", "properties": props, "required": []any{"day"}}
+	v2Schema := map[string]any{"type": "object", "properties": props, "required": []any{"day", "region"}}
+	if _, err := s.CreateVersion(ctx, app.ID, "objects/fixture.tar.gz", "sha256", 0, "main.py", nil, v1Schema, nil, nil, nil, 1, false, nil, nil, nil); err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+	if _, err := s.CreateVersion(ctx, app.ID, "objects/fixture.tar.gz", "sha256", 0, "main.py", nil, v2Schema, nil, nil, nil, 1, false, nil, nil, nil); err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-rerun/runs", token, "", map[string]any{
+		"version_no": 1,
+		"priority":   5,
+		"input":      map[string]any{"day": "2026-01-01", "fix_mode": false, "opts": map[string]any{"depth": 2, "dry": true}},
+	})
+	var original struct {
+		RunID int64 `json:"run_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&original); err != nil {
+		t.Fatalf("decode run: %v", err)
+	}
+	resp.Body.Close()
+
+	type rerunResult struct {
+		Run struct {
+			RunID        int64          `json:"run_id"`
+			VersionNo    int64          `json:"version_no"`
+			Priority     int            `json:"priority"`
+			Input        map[string]any `json:"input"`
+			RerunOfRunID *int64         `json:"rerun_of_run_id"`
+		} `json:"run"`
+		InputChanges []struct {
+			Path   string `json:"path"`
+			Kind   string `json:"kind"`
+			Before any    `json:"before"`
+			After  any    `json:"after"`
+		} `json:"input_changes"`
+	}
+	rerun := func(runID int64, body any) rerunResult {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(runID)+"/rerun", token, "", body)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", resp.StatusCode)
+		}
+		var out rerunResult
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("decode rerun: %v", err)
+		}
+		return out
+	}
+
+	first := rerun(original.RunID, map[string]any{
+		"input_overrides": map[string]any{"fix_mode": true, "opts": map[string]any{"dry": nil}},
+	})
+	wantInput := map[string]any{"day": "2026-01-01", "fix_mode": true, "opts": map[string]any{"depth": float64(2)}}
+	if !reflect.DeepEqual(first.Run.Input, wantInput) {
+		t.Fatalf("expected merged input %v, got %v", wantInput, first.Run.Input)
+	}
+	if first.Run.RerunOfRunID == nil || *first.Run.RerunOfRunID != original.RunID || first.Run.VersionNo != 1 || first.Run.Priority != 5 {
+		t.Fatalf("expected a rerun of %d on version 1 with priority 5, got %+v", original.RunID, first.Run)
+	}
+	if len(first.InputChanges) != 2 ||
+		first.InputChanges[0].Path != "fix_mode" || first.InputChanges[0].Kind != "changed" || first.InputChanges[0].Before != false || first.InputChanges[0].After != true ||
+		first.InputChanges[1].Path != "opts.dry" || first.InputChanges[1].Kind != "removed" {
+		t.Fatalf("unexpected input_changes %+v", first.InputChanges)
+	}
+
+	// The target version's schema applies: version 2 requires region.
+	assertErrorCode(t, "new schema", doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(first.Run.RunID)+"/rerun", token, "", map[string]any{"version_no": 2}),
+		http.StatusBadRequest, "invalid_request")
+	second := rerun(first.Run.RunID, map[string]any{"version_no": 2, "input_overrides": map[string]any{"region": "eu"}})
+	if second.Run.VersionNo != 2 || second.Run.RerunOfRunID == nil || *second.Run.RerunOfRunID != first.Run.RunID {
+		t.Fatalf("expected a rerun of the rerun on version 2, got %+v", second.Run)
+	}
+	if len(second.InputChanges) != 1 || second.InputChanges[0].Path != "region" || second.InputChanges[0].Kind != "added" {
+		t.Fatalf("unexpected input_changes %+v", second.InputChanges)
+	}
+
+	got := doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(second.Run.RunID), token, "", nil)
+	defer got.Body.Close()
+	var fetched struct {
+		RerunOfRunID *int64 `json:"rerun_of_run_id"`
+	}
+	if err := json.NewDecoder(got.Body).Decode(&fetched); err != nil {
+		t.Fatalf("decode run: %v", err)
+	}
+	if fetched.RerunOfRunID == nil || *fetched.RerunOfRunID != first.Run.RunID {
+		t.Fatalf("expected GET to report rerun_of_run_id %d, got %v", first.Run.RunID, fetched.RerunOfRunID)
+	}
+
+	assertErrorCode(t, "other team", doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(original.RunID)+"/rerun", otherToken, "", map[string]any{}),
+		http.StatusNotFound, "not_found")
+	assertErrorCode(t, "bad body", doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(original.RunID)+"/rerun", token, "", map[string]any{"input_overrides": "x"}),
+		http.StatusBadRequest, "invalid_request")
+}
+
+func TestCreateRunDryRunValidatesWithoutInserting(t *testing.T) {
+	handler, s, db, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-dry-run")
+	app := testutil.CreateApp(t, s, team.ID, "app-dry-run")
+	schema := map[string]any{
+		"type":     "object",
+		"required": []any{"name"},
+		"properties": map[string]any{
+			"name": map[string]any{"type": "string"},
+		},
+	}
+	if _, err := s.CreateVersion(ctx, app.ID, "objects/fixture.tar.gz", "sha256", 0, "main.py", nil, schema, nil, nil, nil, 1, false, nil, nil, nil); err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+
+	runsPath := "/api/v1/apps/app-dry-run/runs"
+	resp := doRequest(t, handler, http.MethodPost, runsPath+"?dry_run=true", token, "", map[string]any{
+		"input":       map[string]any{"name": "ci"},
+		"priority":    5000,
+		"max_retries": -2,
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var payload map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode dry run: %v", err)
+	}
+	if payload["status"] != "validated" || payload["version_no"] != float64(1) {
+		t.Fatalf("unexpected dry run payload: %v", payload)
+	}
+	if _, ok := payload["run_id"]; ok {
+		t.Fatalf("dry run must not return run_id: %v", payload)
+	}
+	if payload["priority"] != float64(1000) || payload["max_retries"] != float64(0) {
+		t.Fatalf("expected clamped priority and max_retries, got %v", payload)
+	}
+
+	// The body flag works too, and failures carry the schema error.
+	resp = doRequest(t, handler, http.MethodPost, runsPath, token, "", map[string]any{
+		"dry_run": true,
+		"input":   map[string]any{"name": 7},
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for schema violation, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "input does not match schema") {
+		t.Fatalf("expected schema error detail, got %s", body)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, runsPath+"?dry_run=true", token, "", map[string]any{
+		"version_no": 9,
+		"input":      map[string]any{"name": "ci"},
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing version, got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, runsPath+"?dry_run=maybe", token, "", map[string]any{})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid dry_run, got %d", resp.StatusCode)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM runs`).Scan(&count); err != nil {
+		t.Fatalf("count runs: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("expected no runs inserted, got %d", count)
+	}
+	resp = doRequest(t, handler, http.MethodGet, "/metrics", testMetricsToken, "", nil)
+	defer resp.Body.Close()
+	metrics, _ := io.ReadAll(resp.Body)
+	if strings.Contains(string(metrics), `minitower_runs_created_total{app="app-dry-run"`) {
+		t.Fatal("dry runs must not count as created runs")
+	}
+
+	mustExecHTTP(t, db, `UPDATE apps SET disabled = 1 WHERE id = ?`, app.ID)
+	resp = doRequest(t, handler, http.MethodPost, runsPath+"?dry_run=true", token, "", map[string]any{
+		"input": map[string]any{"name": "ci"},
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for disabled app, got %d", resp.StatusCode)
+	}
+}
+
+func TestRunnerStartReturnsCancellingWhenRunIsCancelling(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, teamToken := testutil.CreateTeam(t, s, "team-start-cancel-http")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-start-cancel-http")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-start-cancel-http", "default")
+	leaseToken, leaseHash, _ := auth.GenerateToken()
+	if _, _, err := s.LeaseRun(ctx, runner, leaseHash, time.Minute); err != nil {
+		t.Fatalf("lease run: %v", err)
+	}
+
+	cancelResp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/cancel", teamToken, "", nil)
+	defer cancelResp.Body.Close()
+	if cancelResp.StatusCode != http.StatusOK {
+		t.Fatalf("cancel status: %d", cancelResp.StatusCode)
+	}
+
+	startResp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/start", runnerToken, leaseToken, nil)
+	defer startResp.Body.Close()
+	if startResp.StatusCode != http.StatusOK {
+		t.Fatalf("start status: %d", startResp.StatusCode)
+	}
+
+	var payload map[string]any
+	if err := json.NewDecoder(startResp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode start response: %v", err)
+	}
+	if payload["status"] != "cancelling" {
+		t.Fatalf("expected cancelling status, got %v", payload["status"])
+	}
+	if payload["cancel_requested"] != true {
+		t.Fatalf("expected cancel_requested true, got %v", payload["cancel_requested"])
+	}
+	if payload["run_status"] != "cancelling" {
+		t.Fatalf("expected run_status cancelling, got %v", payload["run_status"])
+	}
+}
+
+func TestCancelRunEndpointQueued(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-cancel-api")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-cancel-api")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/cancel", token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("cancel status: %d", resp.StatusCode)
+	}
+
+	var payload map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if payload["status"] != "cancelled" {
+		t.Fatalf("expected cancelled, got %v", payload["status"])
+	}
+	if payload["cancel_requested"] != true {
+		t.Fatalf("expected cancel_requested true")
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/cancel", token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("cancel idempotent status: %d", resp.StatusCode)
+	}
+}
+
+func TestCancelPropagationToRunnerHeartbeat(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-cancel-prop")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-cancel-prop")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-cancel-prop", "default")
+	leaseToken, leaseHash, _ := auth.GenerateToken()
+	if _, _, err := s.LeaseRun(ctx, runner, leaseHash, time.Minute); err != nil {
+		t.Fatalf("lease run: %v", err)
+	}
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/cancel", token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("cancel status: %d", resp.StatusCode)
+	}
+
+	// The first heartbeat after the cancel records the acknowledgement; later
+	// ones keep reporting the flag with the same cancel_ack_at.
+	var ackAt string
+	for i := 0; i < 2; i++ {
+		resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/heartbeat", runnerToken, leaseToken, nil)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("heartbeat %d status: %d", i+1, resp.StatusCode)
+		}
+		var payload struct {
+			CancelRequested bool   `json:"cancel_requested"`
+			CancelAckAt     string `json:"cancel_ack_at"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode heartbeat: %v", err)
+		}
+		if !payload.CancelRequested || payload.CancelAckAt == "" {
+			t.Fatalf("heartbeat %d: expected cancel_requested with cancel_ack_at, got %+v", i+1, payload)
+		}
+		if ackAt != "" && payload.CancelAckAt != ackAt {
+			t.Fatalf("cancel_ack_at changed from %s to %s", ackAt, payload.CancelAckAt)
+		}
+		ackAt = payload.CancelAckAt
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID), token, "", nil)
+	defer resp.Body.Close()
+	var got struct {
+		CancelAckAt string `json:"cancel_ack_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil || got.CancelAckAt != ackAt {
+		t.Fatalf("get run cancel_ack_at = %q (%v), want %q", got.CancelAckAt, err, ackAt)
+	}
+}
+
+func TestCancelResultRace(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-cancel-race")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-cancel-race")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-cancel-race", "default")
+	leaseToken, leaseHash, _ := auth.GenerateToken()
+	if _, _, err := s.LeaseRun(ctx, runner, leaseHash, time.Minute); err != nil {
+		t.Fatalf("lease run: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	var cancelStatus int
+	var resultStatus int
+
+	go func() {
+		defer wg.Done()
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/cancel", token, "", nil)
+		cancelStatus = resp.StatusCode
+		resp.Body.Close()
+	}()
+
+	go func() {
+		defer wg.Done()
+		body := map[string]any{"status": "completed", "exit_code": 0}
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/result", runnerToken, leaseToken, body)
+		resultStatus = resp.StatusCode
+		resp.Body.Close()
+	}()
+
+	wg.Wait()
+
+	if cancelStatus != http.StatusOK {
+		t.Fatalf("cancel status: %d", cancelStatus)
+	}
+	if resultStatus != http.StatusOK && resultStatus != http.StatusConflict {
+		t.Fatalf("result status: %d", resultStatus)
+	}
+
+	loaded, err := s.GetRunByID(ctx, team.ID, run.ID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if loaded.Status != "cancelled" && loaded.Status != "cancelling" && loaded.Status != "completed" {
 		t.Fatalf("unexpected final status %s", loaded.Status)
 	}
 	if resultStatus == http.StatusOK && loaded.Status != "completed" {
@@ -452,35 +1243,474 @@ func TestLateResultAfterExpiryReturnsGone(t *testing.T) {
 	}
 }
 
-func newTestServer(t *testing.T) (http.Handler, *store.Store, *sql.DB, func()) {
-	t.Helper()
+func TestSetRunPriorityEndpoint(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
 
-	s, dbConn, cleanup := testutil.NewTestDB(t)
-	objStore, err := objects.NewLocalStore(t.TempDir())
+	ctx := context.Background()
+	team, teamToken := testutil.CreateTeam(t, s, "team-priority-http")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
 	if err != nil {
-		cleanup.Close(t)
-		t.Fatalf("objects store: %v", err)
+		t.Fatalf("get env: %v", err)
 	}
+	app := testutil.CreateApp(t, s, team.ID, "app-priority-http")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
 
-	cfg := config.Config{
-		ListenAddr:              ":0",
-		DBPath:                  "",
-		ObjectsDir:              "",
-		BootstrapToken:          "test",
-		PublicSignupEnabled:     true,
-		RunnerRegistrationToken: "test-runner-reg",
-		LeaseTTL:                60 * time.Second,
-		ExpiryCheckInterval:     10 * time.Second,
-		MaxRequestBodySize:      10 * 1024 * 1024,
-		MaxArtifactSize:         100 * 1024 * 1024,
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/priority", teamToken, "", map[string]any{"priority": 7})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("priority status: %d", resp.StatusCode)
 	}
+	var payload map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if payload["priority"] != float64(7) {
+		t.Fatalf("expected priority 7, got %v", payload["priority"])
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/priority", teamToken, "", map[string]any{})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 without priority, got %d", resp.StatusCode)
+	}
+
+	runner, _ := testutil.CreateRunner(t, s, "runner-priority-http", "default")
+	testutil.LeaseRun(t, s, runner)
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/priority", teamToken, "", map[string]any{"priority": 9})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for leased run, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminBackupWritesSnapshot(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	_, adminToken := testutil.CreateTeam(t, s, "team-backup")
+	_, memberToken := testutil.CreateTeamWithRole(t, s, "team-backup-member", "member")
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/admin/backup", memberToken, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for member, got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/admin/backup", adminToken, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		Path      string `json:"path"`
+		SizeBytes int64  `json:"size_bytes"`
+		SHA256    string `json:"sha256"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode backup: %v", err)
+	}
+	if payload.Path == "" || payload.SizeBytes <= 0 || len(payload.SHA256) != 64 {
+		t.Fatalf("unexpected backup response: %+v", payload)
+	}
+	info, err := os.Stat(payload.Path)
+	if err != nil {
+		t.Fatalf("stat snapshot: %v", err)
+	}
+	if info.Size() != payload.SizeBytes {
+		t.Fatalf("size mismatch: file=%d response=%d", info.Size(), payload.SizeBytes)
+	}
+}
+
+func TestRunnerAttemptResponsesIncludeCurrentTimeout(t *testing.T) {
+	handler, s, db, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-timeout")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-timeout")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-timeout", "default")
+	_, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	attempt := func(action string) map[string]any {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/"+action, runnerToken, leaseToken, nil)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s status: %d", action, resp.StatusCode)
+		}
+		var body map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode %s: %v", action, err)
+		}
+		return body
+	}
+
+	// Without a version timeout the runner keeps its default.
+	body := attempt("start")
+	if _, ok := body["timeout_seconds"]; ok {
+		t.Fatalf("expected no timeout_seconds, got %v", body["timeout_seconds"])
+	}
+	if _, ok := body["deadline_at"]; ok {
+		t.Fatalf("expected no deadline_at, got %v", body["deadline_at"])
+	}
+
+	mustExecHTTP(t, db, `UPDATE app_versions SET timeout_seconds = 60 WHERE id = ?`, version.ID)
+	mustExecHTTP(t, db, `UPDATE run_attempts SET started_at = ? WHERE run_id = ?`, time.Unix(1_700_000_000, 0).UnixMilli(), run.ID)
+	body = attempt("heartbeat")
+	if body["timeout_seconds"] != float64(60) {
+		t.Fatalf("expected timeout_seconds 60, got %v", body["timeout_seconds"])
+	}
+	if want := "2023-11-14T22:14:20.000Z"; body["deadline_at"] != want {
+		t.Fatalf("expected deadline_at %s, got %v", want, body["deadline_at"])
+	}
+}
+
+func TestTimestampsAreUTCWithMilliseconds(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-timestamps")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-timestamps")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	_, runnerToken := testutil.CreateRunner(t, s, "runner-timestamps", "default")
+
+	stamp := regexp.MustCompile(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z$`)
+	get := func(method, path, token, leaseToken string, body any) map[string]any {
+		t.Helper()
+		resp := doRequest(t, handler, method, path, token, leaseToken, body)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s status: %d", method, path, resp.StatusCode)
+		}
+		var payload map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		return payload
+	}
+	check := func(what string, v any) {
+		t.Helper()
+		if str, _ := v.(string); !stamp.MatchString(str) {
+			t.Fatalf("expected %s in UTC with milliseconds, got %v", what, v)
+		}
+	}
+
+	lease := get(http.MethodPost, "/api/v1/runs/lease", runnerToken, "", nil)
+	check("lease_expires_at", lease["lease_expires_at"])
+	leaseToken, _ := lease["lease_token"].(string)
+	started := get(http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/start", runnerToken, leaseToken, map[string]any{})
+	check("server_time", started["server_time"])
+
+	// Runners on older releases send second-precision logged_at values.
+	logs := map[string]any{"logs": []map[string]any{
+		{"seq": 1, "stream": "stdout", "line": "old", "logged_at": "2026-01-02T03:04:05Z"},
+		{"seq": 2, "stream": "stdout", "line": "new", "logged_at": "2026-01-02T03:04:05.123+01:00"},
+	}}
+	get(http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/logs", runnerToken, leaseToken, logs)
+
+	got := get(http.MethodGet, "/api/v1/runs/"+itoa(run.ID), token, "", nil)
+	check("queued_at", got["queued_at"])
+	check("started_at", got["started_at"])
+
+	lines, _ := get(http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/logs", token, "", nil)["logs"].([]any)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %v", lines)
+	}
+	want := []string{"2026-01-02T03:04:05.000Z", "2026-01-02T02:04:05.123Z"}
+	for i, l := range lines {
+		if at := l.(map[string]any)["logged_at"]; at != want[i] {
+			t.Fatalf("expected logged_at %s, got %v", want[i], at)
+		}
+	}
+
+	check("app created_at", get(http.MethodGet, "/api/v1/apps/app-timestamps", token, "", nil)["created_at"])
+	runners, _ := get(http.MethodGet, "/api/v1/admin/runners", token, "", nil)["runners"].([]any)
+	if len(runners) != 1 {
+		t.Fatalf("expected 1 runner, got %v", runners)
+	}
+	check("last_seen_at", runners[0].(map[string]any)["last_seen_at"])
+}
+
+func TestHeartbeatPersistsRunnerStats(t *testing.T) {
+	handler, s, db, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, adminToken := testutil.CreateTeam(t, s, "team-stats")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-stats")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-stats", "default")
+	_, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	body := map[string]any{
+		"stats": map[string]any{
+			"cpu_percent":     37.5,
+			"mem_used_bytes":  1024,
+			"mem_total_bytes": 4096,
+			"disk_free_bytes": 8192,
+			"load1":           0.5,
+		},
+	}
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/heartbeat", runnerToken, leaseToken, body)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("heartbeat status: %d", resp.StatusCode)
+	}
+	assertLeaseFields(t, resp.Body)
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/admin/runners", adminToken, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("list runners status: %d", resp.StatusCode)
+	}
+	var payload struct {
+		Runners []struct {
+			Name    string                     `json:"name"`
+			Stats   map[string]json.RawMessage `json:"stats"`
+			StatsAt *string                    `json:"stats_at"`
+		} `json:"runners"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode runners: %v", err)
+	}
+	if len(payload.Runners) != 1 {
+		t.Fatalf("expected 1 runner, got %d", len(payload.Runners))
+	}
+	got := payload.Runners[0]
+	if got.StatsAt == nil {
+		t.Fatal("expected stats_at")
+	}
+	want := map[string]string{
+		"cpu_percent":     "37.5",
+		"mem_used_bytes":  "1024",
+		"mem_total_bytes": "4096",
+		"disk_free_bytes": "8192",
+		"load1":           "0.5",
+	}
+	for k, v := range want {
+		if string(got.Stats[k]) != v {
+			t.Fatalf("stats.%s: expected %s, got %s", k, v, got.Stats[k])
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(httpapi.NewDomainCollector(db))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	found := false
+	for _, mf := range families {
+		if mf.GetName() != "minitower_runner_cpu_percent" {
+			continue
+		}
+		m := mf.GetMetric()
+		if len(m) != 1 || m[0].GetGauge().GetValue() != 37.5 || m[0].GetLabel()[0].GetValue() != "runner-stats" {
+			t.Fatalf("unexpected cpu gauge: %v", m)
+		}
+		found = true
+	}
+	if !found {
+		t.Fatal("expected minitower_runner_cpu_percent gauge")
+	}
+}
+
+func TestHeartbeatExportsRunnerClockSkew(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-skew")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-skew")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-skew", "default")
+	_, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/heartbeat", runnerToken, leaseToken, map[string]any{"clock_skew_seconds": -3.25})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("heartbeat status: %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/metrics", testMetricsToken, "", nil)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read metrics: %v", err)
+	}
+	if want := `minitower_runner_clock_skew_seconds{runner="runner-skew"} -3.25`; !strings.Contains(string(data), want) {
+		t.Fatalf("expected %q in metrics output", want)
+	}
+}
+
+func TestHeartbeatRejectsMalformedBody(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-stats-bad")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-stats-bad")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-stats-bad", "default")
+	_, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/heartbeat", runnerToken, leaseToken, map[string]any{"stats": "high"})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+// testMetricsToken guards /metrics on test servers' API listener.
+const testMetricsToken = "test-metrics"
+
+func newTestServer(t *testing.T) (http.Handler, *store.Store, *sql.DB, func()) {
+	t.Helper()
+
+	return newTestServerWithObjects(t, newFixtureObjects(t))
+}
+
+// newFixtureObjects returns an object store holding the artifact of
+// testutil.CreateVersion's fixture versions, which leases check for.
+func newFixtureObjects(t *testing.T) *objects.LocalStore {
+	t.Helper()
+
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	if err := objStore.Store("objects/fixture.tar.gz", strings.NewReader("fixture")); err != nil {
+		t.Fatalf("seed fixture artifact: %v", err)
+	}
+	return objStore
+}
+
+// newTestServerWithObjects is newTestServer backed by a caller-owned object
+// store, for tests that need to seed artifacts directly.
+func newTestServerWithObjects(t *testing.T, objStore *objects.LocalStore) (http.Handler, *store.Store, *sql.DB, func()) {
+	t.Helper()
+
+	api, s, dbConn, cleanup := newTestAPI(t, objStore)
+	return checkErrorCodes(t, api.Handler()), s, dbConn, cleanup
+}
+
+// checkErrorCodes wraps handler so that every error response a test
+// provokes must carry a cataloged code sent with the catalog's status.
+func checkErrorCodes(t *testing.T, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &errorCodeRecorder{ResponseWriter: w}
+		handler.ServeHTTP(rec, r)
+		if rec.status < 400 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+			return
+		}
+		var payload struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(rec.body.Bytes(), &payload); err != nil {
+			t.Errorf("%s %s: undecodable error body %q", r.Method, r.URL.Path, rec.body.String())
+			return
+		}
+		entry, ok := apierror.Lookup(payload.Error.Code)
+		if !ok {
+			t.Errorf("%s %s: error code %q is not in the catalog", r.Method, r.URL.Path, payload.Error.Code)
+			return
+		}
+		if entry.Status != rec.status {
+			t.Errorf("%s %s: code %q sent with status %d, catalog says %d", r.Method, r.URL.Path, entry.Code, rec.status, entry.Status)
+		}
+	})
+}
+
+type errorCodeRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *errorCodeRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *errorCodeRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.status >= 400 {
+		r.body.Write(p)
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// newTestAPI is newTestServerWithObjects returning the Server itself, for
+// tests that need more than its handler.
+func newTestAPI(t *testing.T, objStore *objects.LocalStore) (*httpapi.Server, *store.Store, *sql.DB, func()) {
+	t.Helper()
+	return newTestAPIWithConfig(t, objStore, func(*config.Config) {})
+}
+
+// newTestAPIWithConfig is newTestAPI with configure applied to the
+// server's config.
+func newTestAPIWithConfig(t *testing.T, objStore *objects.LocalStore, configure func(*config.Config)) (*httpapi.Server, *store.Store, *sql.DB, func()) {
+	t.Helper()
+
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+
+	cfg := config.Config{
+		ListenAddr:               ":0",
+		DBPath:                   "",
+		ObjectsDir:               "",
+		BootstrapToken:           "test",
+		PublicSignupEnabled:      true,
+		RunnerRegistrationTokens: []string{"test-runner-reg"},
+		MetricsToken:             testMetricsToken,
+		LeaseTTL:                 60 * time.Second,
+		ExpiryCheckInterval:      10 * time.Second,
+		MaxRequestBodySize:       10 * 1024 * 1024,
+		MaxArtifactSize:          100 * 1024 * 1024,
+		BackupDir:                t.TempDir(),
+	}
+	configure(&cfg)
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	// Use a fresh registry per test to avoid duplicate registration errors
 	reg := prometheus.NewRegistry()
 	api := httpapi.New(cfg, dbConn, objStore, logger, httpapi.WithPrometheusRegisterer(reg))
 
-	return api.Handler(), s, dbConn, func() {
+	return api, s, dbConn, func() {
 		cleanup.Close(t)
 	}
 }
@@ -537,16 +1767,9 @@ func assertLeaseFields(t *testing.T, body io.Reader) {
 	if _, ok := payload["cancel_requested"]; !ok {
 		t.Fatalf("missing cancel_requested")
 	}
-}
-
-func assertCancelRequested(t *testing.T, body io.Reader) {
-	t.Helper()
-	var payload map[string]any
-	if err := json.NewDecoder(body).Decode(&payload); err != nil {
-		t.Fatalf("decode response: %v", err)
-	}
-	if payload["cancel_requested"] != true {
-		t.Fatalf("expected cancel_requested true")
+	serverTime, _ := payload["server_time"].(string)
+	if _, err := time.Parse(time.RFC3339Nano, serverTime); err != nil {
+		t.Fatalf("invalid server_time %q: %v", serverTime, err)
 	}
 }
 
@@ -560,3 +1783,1991 @@ func mustExecHTTP(t *testing.T, db *sql.DB, query string, args ...any) {
 		t.Fatalf("exec %s: %v", query, err)
 	}
 }
+
+func TestAdminOverviewEndpoint(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, adminToken := testutil.CreateTeam(t, s, "team-overview-http")
+	_, memberToken := testutil.CreateTeamWithRole(t, s, "team-overview-member", "member")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-overview-http")
+	version, err := s.CreateVersion(ctx, app.ID, "objects/overview.tar.gz", "sha256", 2048, "main.py", nil, nil, nil, nil, nil, 1, false, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	testutil.CreateRunner(t, s, "runner-overview-http", "default")
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/admin/overview", memberToken, "", nil)
+	defer resp.Body.Close()
+	assertErrorCode(t, "member overview", resp, http.StatusForbidden, "forbidden")
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/admin/overview", adminToken, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var payload struct {
+		TeamCount int64 `json:"team_count"`
+		Teams     []struct {
+			Slug string `json:"slug"`
+			Apps int64  `json:"apps"`
+			Runs int64  `json:"runs"`
+		} `json:"teams"`
+		ArtifactBytes int64            `json:"artifact_bytes"`
+		RunsLast24h   map[string]int64 `json:"runs_last_24h"`
+		Runners       struct {
+			Online  int64 `json:"online"`
+			Offline int64 `json:"offline"`
+		} `json:"runners"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode overview: %v", err)
+	}
+	if payload.TeamCount != 2 || len(payload.Teams) != 2 {
+		t.Fatalf("expected 2 teams, got %+v", payload)
+	}
+	if u := payload.Teams[0]; u.Slug != "team-overview-http" || u.Apps != 1 || u.Runs != 2 {
+		t.Fatalf("unexpected team usage: %+v", u)
+	}
+	if u := payload.Teams[1]; u.Slug != "team-overview-member" || u.Apps != 0 || u.Runs != 0 {
+		t.Fatalf("unexpected team usage: %+v", u)
+	}
+	if payload.ArtifactBytes != 2048 || payload.RunsLast24h["queued"] != 2 || payload.Runners.Online != 1 || payload.Runners.Offline != 0 {
+		t.Fatalf("unexpected overview: %+v", payload)
+	}
+}
+
+func TestRunBatchEndpoints(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-batch-http")
+	app := testutil.CreateApp(t, s, team.ID, "app-batch-http")
+	schema := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"n": map[string]any{"type": "integer"}},
+	}
+	if _, err := s.CreateVersion(ctx, app.ID, "objects/batch.tar.gz", "sha256", 0, "main.py", nil, schema, nil, nil, nil, 1, false, nil, nil, nil); err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-batch-http/runs/batch", token, "", map[string]any{
+		"inputs": []map[string]any{{"n": 1}, {"n": "two"}},
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+	var errPayload struct {
+		Error struct {
+			Code  string `json:"code"`
+			Items []struct {
+				Index int `json:"index"`
+			} `json:"items"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errPayload); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if errPayload.Error.Code != "invalid_request" || len(errPayload.Error.Items) != 1 || errPayload.Error.Items[0].Index != 1 {
+		t.Fatalf("unexpected batch error: %+v", errPayload)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-batch-http/runs/batch", token, "", map[string]any{
+		"inputs":   []map[string]any{{"n": 1}, {"n": 2}, {"n": 3}},
+		"priority": 3,
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var created struct {
+		BatchID string  `json:"batch_id"`
+		Count   int     `json:"count"`
+		RunIDs  []int64 `json:"run_ids"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode batch: %v", err)
+	}
+	if created.BatchID == "" || created.Count != 3 || len(created.RunIDs) != 3 {
+		t.Fatalf("unexpected batch: %+v", created)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/batches/"+created.BatchID+"/cancel", token, "", map[string]any{})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/batches/"+created.BatchID, token, "", nil)
+	defer resp.Body.Close()
+	var batch struct {
+		Total           int64            `json:"total"`
+		Counts          map[string]int64 `json:"counts"`
+		PercentComplete float64          `json:"percent_complete"`
+		Done            bool             `json:"done"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		t.Fatalf("decode batch: %v", err)
+	}
+	if batch.Total != 3 || batch.Counts["cancelled"] != 3 || batch.PercentComplete != 100 || !batch.Done {
+		t.Fatalf("unexpected batch status: %+v", batch)
+	}
+
+	_, otherToken := testutil.CreateTeam(t, s, "team-batch-http-other")
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/batches/"+created.BatchID, otherToken, "", nil)
+	defer resp.Body.Close()
+	assertErrorCode(t, "other team batch", resp, http.StatusNotFound, "not_found")
+}
+
+func TestCancelRunsBulkRequiresMatchingCount(t *testing.T) {
+	handler, s, db, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-bulk-http")
+	other, otherToken := testutil.CreateTeam(t, s, "team-bulk-http-other")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	otherEnv, err := s.GetOrCreateDefaultEnvironment(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("get other env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "bulk-http-app")
+	ver := testutil.CreateVersion(t, s, app.ID)
+	otherApp := testutil.CreateApp(t, s, other.ID, "bulk-http-app")
+	otherVer := testutil.CreateVersion(t, s, otherApp.ID)
+
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, ver.ID, 0, 0)
+	runner, _ := testutil.CreateRunner(t, s, "runner-bulk-http", "default")
+	running, _, _, _ := testutil.LeaseRun(t, s, runner)
+	mustExecHTTP(t, db, `UPDATE runs SET status = 'running' WHERE id = ?`, running.ID)
+	queued1 := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, ver.ID, 0, 0)
+	queued2 := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, ver.ID, 0, 0)
+	otherRun := testutil.CreateRun(t, s, other.ID, otherApp.ID, otherEnv.ID, otherVer.ID, 0, 0)
+
+	type bulkResult struct {
+		Count      int64   `json:"count"`
+		RunIDs     []int64 `json:"run_ids"`
+		Cancelled  int64   `json:"cancelled"`
+		Cancelling int64   `json:"cancelling"`
+		Results    []struct {
+			RunID          int64  `json:"run_id"`
+			PreviousStatus string `json:"previous_status"`
+			Status         string `json:"status"`
+		} `json:"results"`
+	}
+	cancel := func(body map[string]any) (*http.Response, bulkResult) {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/cancel", token, "", body)
+		var out bulkResult
+		if resp.StatusCode == http.StatusOK {
+			defer resp.Body.Close()
+			if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+				t.Fatalf("decode bulk cancel: %v", err)
+			}
+		}
+		return resp, out
+	}
+
+	resp, _ := cancel(map[string]any{"status": "queued"})
+	assertErrorCode(t, "missing confirm_count", resp, http.StatusBadRequest, "invalid_request")
+	resp, _ = cancel(map[string]any{"status": "paused", "confirm_count": 0})
+	assertErrorCode(t, "bad status", resp, http.StatusBadRequest, "invalid_request")
+	resp, _ = cancel(map[string]any{"app": "missing-app", "confirm_count": 0})
+	assertErrorCode(t, "missing app", resp, http.StatusNotFound, "not_found")
+
+	resp, dry := cancel(map[string]any{"app": "bulk-http-app", "status": "queued", "dry_run": true})
+	if resp.StatusCode != http.StatusOK || dry.Count != 2 || len(dry.RunIDs) != 2 || len(dry.Results) != 0 {
+		t.Fatalf("unexpected dry run %d %+v", resp.StatusCode, dry)
+	}
+
+	resp, _ = cancel(map[string]any{"app": "bulk-http-app", "status": "all-nonterminal", "confirm_count": 2})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", resp.StatusCode)
+	}
+	var mismatch struct {
+		Error struct {
+			Code  string `json:"code"`
+			Count int64  `json:"count"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&mismatch); err != nil {
+		t.Fatalf("decode mismatch: %v", err)
+	}
+	if mismatch.Error.Code != "confirm_count_mismatch" || mismatch.Error.Count != 3 {
+		t.Fatalf("unexpected mismatch error %+v", mismatch)
+	}
+	if run, _ := s.GetRunByID(ctx, team.ID, queued1.ID); run.Status != "queued" {
+		t.Fatalf("expected nothing cancelled on a mismatch, got %s", run.Status)
+	}
+
+	resp, done := cancel(map[string]any{"app": "bulk-http-app", "status": "all-nonterminal", "confirm_count": 3})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if done.Count != 3 || done.Cancelled != 2 || done.Cancelling != 1 || len(done.Results) != 3 {
+		t.Fatalf("unexpected bulk cancel %+v", done)
+	}
+	for _, res := range done.Results {
+		want := "cancelled"
+		if res.RunID == running.ID {
+			want = "cancelling"
+		}
+		if res.Status != want {
+			t.Fatalf("run %d: expected %s, got %+v", res.RunID, want, res)
+		}
+	}
+	if run, _ := s.GetRunByID(ctx, team.ID, queued2.ID); run.Status != "cancelled" {
+		t.Fatalf("expected run %d cancelled, got %s", queued2.ID, run.Status)
+	}
+
+	// Another team's token neither sees nor cancels these runs.
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/cancel", otherToken, "", map[string]any{
+		"run_ids": []int64{running.ID, otherRun.ID}, "confirm_count": 1,
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for other team, got %d", resp.StatusCode)
+	}
+	if run, _ := s.GetRunByID(ctx, other.ID, otherRun.ID); run.Status != "cancelled" {
+		t.Fatalf("expected the other team's own run cancelled, got %s", run.Status)
+	}
+	if run, _ := s.GetRunByID(ctx, team.ID, running.ID); run.Status != "cancelling" {
+		t.Fatalf("expected run %d still cancelling, got %s", running.ID, run.Status)
+	}
+}
+
+// uploadTowerfileVersion uploads an artifact holding towerfileTOML plus the
+// main.sh and setup.sh scripts it may reference.
+func uploadTowerfileVersion(t *testing.T, handler http.Handler, token, slug, towerfileTOML string) *http.Response {
+	t.Helper()
+	return uploadArtifact(t, handler, token, slug, buildArtifact(t, []artifactEntry{
+		{name: "Towerfile", body: []byte(towerfileTOML)},
+		{name: "main.sh", body: []byte("echo hi\n")},
+		{name: "setup.sh", body: []byte("true\n")},
+	}))
+}
+
+func uploadArtifact(t *testing.T, handler http.Handler, token, slug string, artifact []byte) *http.Response {
+	t.Helper()
+	return uploadArtifactWithFields(t, handler, token, slug, artifact, nil)
+}
+
+// uploadArtifactWithFields is uploadArtifact with extra form fields.
+func uploadArtifactWithFields(t *testing.T, handler http.Handler, token, slug string, artifact []byte, fields map[string]string) *http.Response {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatalf("write field %s: %v", name, err)
+		}
+	}
+	part, err := mw.CreateFormFile("artifact", "artifact.tar.gz")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	if _, err := part.Write(artifact); err != nil {
+		t.Fatalf("write form file: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close multipart: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://example/api/v1/apps/"+slug+"/versions", &body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func TestVersionUploadChecksExpectedSHA256(t *testing.T) {
+	objectsDir := t.TempDir()
+	objStore, err := objects.NewLocalStore(objectsDir)
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	handler, s, _, cleanup := newTestServerWithObjects(t, objStore)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-expected-sha")
+	testutil.CreateApp(t, s, team.ID, "sha-app")
+	artifact := buildArtifact(t, []artifactEntry{
+		{name: "Towerfile", body: []byte("[app]\nname = \"sha-app\"\nscript = \"main.sh\"\n")},
+		{name: "main.sh", body: []byte("echo hi\n")},
+	})
+	sum := sha256.Sum256(artifact)
+	good := hex.EncodeToString(sum[:])
+	assertNoObjects := func(label string) {
+		t.Helper()
+		var files []string
+		_ = filepath.WalkDir(objectsDir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if len(files) != 0 {
+			t.Fatalf("%s: expected no stored objects, found %v", label, files)
+		}
+	}
+
+	decodeMismatch := func(resp *http.Response) (expected, actual string) {
+		t.Helper()
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("expected 422, got %d", resp.StatusCode)
+		}
+		var payload struct {
+			Error struct {
+				Code     string `json:"code"`
+				Expected string `json:"expected_sha256"`
+				Actual   string `json:"actual_sha256"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if payload.Error.Code != "sha256_mismatch" {
+			t.Fatalf("expected sha256_mismatch, got %q", payload.Error.Code)
+		}
+		return payload.Error.Expected, payload.Error.Actual
+	}
+
+	wrong := strings.Repeat("0", 64)
+	expected, actual := decodeMismatch(uploadArtifactWithFields(t, handler, token, "sha-app", artifact, map[string]string{"expected_sha256": wrong}))
+	if expected != wrong || actual != good {
+		t.Fatalf("expected both hashes echoed, got expected=%s actual=%s", expected, actual)
+	}
+	assertNoObjects("mismatch")
+
+	// Bytes corrupted in transit break the archive as well; the mismatch
+	// is what gets reported.
+	corrupted := append([]byte(nil), artifact...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	corruptedSum := sha256.Sum256(corrupted)
+	if _, actual := decodeMismatch(uploadArtifactWithFields(t, handler, token, "sha-app", corrupted, map[string]string{"expected_sha256": good})); actual != hex.EncodeToString(corruptedSum[:]) {
+		t.Fatalf("expected the corrupted upload's hash, got %s", actual)
+	}
+	assertNoObjects("corrupted")
+
+	resp := uploadArtifactWithFields(t, handler, token, "sha-app", artifact, map[string]string{"expected_sha256": strings.ToUpper(good)})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 for a matching hash, got %d", resp.StatusCode)
+	}
+	var version struct {
+		ArtifactSHA256    string `json:"artifact_sha256"`
+		ArtifactSizeBytes *int64 `json:"artifact_size_bytes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		t.Fatalf("decode version: %v", err)
+	}
+	if version.ArtifactSHA256 != good || version.ArtifactSizeBytes == nil || *version.ArtifactSizeBytes != int64(len(artifact)) {
+		t.Fatalf("expected sha256 and size of the upload, got %+v", version)
+	}
+
+	noField := uploadArtifact(t, handler, token, "sha-app", artifact)
+	noField.Body.Close()
+	if noField.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 without expected_sha256, got %d", noField.StatusCode)
+	}
+}
+
+func TestVersionUploadRejectsInvalidArtifacts(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-invalid-artifact")
+	app := testutil.CreateApp(t, s, team.ID, "artifact-app")
+	towerfileTOML := []byte("[app]\nname = \"artifact-app\"\nscript = \"main.py\"\n")
+
+	cases := []struct {
+		name     string
+		artifact []byte
+		message  string
+	}{
+		{
+			name:     "not gzip",
+			artifact: []byte("PK\x03\x04 this is a zip, not a tarball"),
+			message:  "artifact is not a valid gzip archive",
+		},
+		{
+			name: "missing entrypoint",
+			artifact: buildArtifact(t, []artifactEntry{
+				{name: "Towerfile", body: towerfileTOML},
+				{name: "src/", dir: true},
+				{name: "src/main.py", body: []byte("print('hi')")},
+			}),
+			message: "entrypoint main.py not present in archive",
+		},
+		{
+			name: "traversal",
+			artifact: buildArtifact(t, []artifactEntry{
+				{name: "Towerfile", body: towerfileTOML},
+				{name: "main.py", body: []byte("print('hi')")},
+				{name: "lib/../../evil.py", body: []byte("print('evil')")},
+			}),
+			message: `archive entry "lib/../../evil.py" escapes the archive root`,
+		},
+		{
+			name: "absolute path",
+			artifact: buildArtifact(t, []artifactEntry{
+				{name: "Towerfile", body: towerfileTOML},
+				{name: "/etc/cron.d/evil", body: []byte("* * * * * root true")},
+			}),
+			message: `archive entry "/etc/cron.d/evil" escapes the archive root`,
+		},
+		{
+			name:     "no regular files",
+			artifact: buildArtifact(t, []artifactEntry{{name: "src/", dir: true}}),
+			message:  "artifact contains no regular files",
+		},
+	}
+	for _, tc := range cases {
+		resp := uploadArtifact(t, handler, token, "artifact-app", tc.artifact)
+		var payload struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("%s: decode error: %v", tc.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || payload.Error.Code != "invalid_artifact" || payload.Error.Message != tc.message {
+			t.Fatalf("%s: expected 400 invalid_artifact %q, got %d %s %q", tc.name, tc.message, resp.StatusCode, payload.Error.Code, payload.Error.Message)
+		}
+	}
+
+	versions, err := s.ListVersions(context.Background(), app.ID)
+	if err != nil {
+		t.Fatalf("list versions: %v", err)
+	}
+	if len(versions) != 0 {
+		t.Fatalf("expected no version from rejected uploads, got %d", len(versions))
+	}
+
+	// A "./"-prefixed archive, as tar -C dir . writes, still finds both.
+	resp := uploadArtifact(t, handler, token, "artifact-app", buildArtifact(t, []artifactEntry{
+		{name: "./", dir: true},
+		{name: "./Towerfile", body: towerfileTOML},
+		{name: "./main.py", body: []byte("print('hi')")},
+	}))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 for a ./-prefixed archive, got %d", resp.StatusCode)
+	}
+}
+
+func TestVersionUploadRecordsTowerfileSchemaVersion(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-schema-version")
+	testutil.CreateApp(t, s, team.ID, "schema-app")
+
+	resp := uploadTowerfileVersion(t, handler, token, "schema-app", "[app]\nname = \"schema-app\"\nscript = \"main.sh\"\nsetup = \"setup.sh\"\n")
+	assertErrorCode(t, "v1 with setup", resp, http.StatusBadRequest, "TOWERFILE_INVALID")
+
+	resp = uploadTowerfileVersion(t, handler, token, "schema-app", "schema_version = 2\n\n[app]\nname = \"schema-app\"\nscript = \"main.sh\"\nsetup = \"setup.sh\"\n")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var version struct {
+		VersionNo              int64 `json:"version_no"`
+		TowerfileSchemaVersion int   `json:"towerfile_schema_version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		t.Fatalf("decode version: %v", err)
+	}
+	if version.TowerfileSchemaVersion != 2 {
+		t.Fatalf("expected towerfile_schema_version 2, got %d", version.TowerfileSchemaVersion)
+	}
+
+	app, err := s.GetAppBySlug(context.Background(), team.ID, "schema-app")
+	if err != nil {
+		t.Fatalf("get app: %v", err)
+	}
+	stored, err := s.GetVersionByNumber(context.Background(), app.ID, version.VersionNo)
+	if err != nil || stored == nil || stored.TowerfileSchemaVersion != 2 {
+		t.Fatalf("expected stored schema version 2, got %+v (%v)", stored, err)
+	}
+}
+
+func TestVersionUploadSummarizesParameters(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-version-params")
+	testutil.CreateApp(t, s, team.ID, "params-app")
+
+	resp := uploadTowerfileVersion(t, handler, token, "params-app",
+		"[app]\nname = \"params-app\"\nscript = \"main.sh\"\n\n[[parameters]]\nname = \"region\"\n\n[[parameters]]\nname = \"batch_size\"\ntype = \"integer\"\n")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var version struct {
+		Parameters []map[string]string `json:"parameters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		t.Fatalf("decode version: %v", err)
+	}
+	want := []map[string]string{{"name": "region", "type": "string"}, {"name": "batch_size", "type": "integer"}}
+	if !reflect.DeepEqual(version.Parameters, want) {
+		t.Fatalf("parameters = %v, want %v", version.Parameters, want)
+	}
+}
+
+func TestVersionUploadReportsCompatibilityWarnings(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-version-compat")
+	testutil.CreateApp(t, s, team.ID, "compat-app")
+
+	upload := func(towerfileTOML string) []map[string]string {
+		t.Helper()
+		resp := uploadTowerfileVersion(t, handler, token, "compat-app", towerfileTOML)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", resp.StatusCode)
+		}
+		var version struct {
+			CompatibilityWarnings []map[string]string `json:"compatibility_warnings"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+			t.Fatalf("decode version: %v", err)
+		}
+		return version.CompatibilityWarnings
+	}
+
+	// The first version has nothing to compare against.
+	if got := upload("[app]\nname = \"compat-app\"\nscript = \"main.sh\"\n\n[[parameters]]\nname = \"region\"\n\n[[parameters]]\nname = \"batch_size\"\n"); got != nil {
+		t.Fatalf("expected no warnings for the first version, got %v", got)
+	}
+
+	got := upload("[app]\nname = \"compat-app\"\nscript = \"main.sh\"\n\n[[parameters]]\nname = \"batch_size\"\ntype = \"integer\"\n")
+	want := []map[string]string{
+		{"kind": "type_changed", "parameter": "batch_size", "message": `parameter "batch_size" changed type from string to integer`},
+		{"kind": "removed", "parameter": "region", "message": `parameter "region" was removed`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("compatibility_warnings = %v, want %v", got, want)
+	}
+
+	// Warnings compare against the latest version only.
+	if got := upload("[app]\nname = \"compat-app\"\nscript = \"main.sh\"\n\n[[parameters]]\nname = \"batch_size\"\ntype = \"integer\"\n"); got != nil {
+		t.Fatalf("expected no warnings for an unchanged schema, got %v", got)
+	}
+}
+
+func TestVersionCommandsRunAndLease(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-commands")
+	testutil.CreateApp(t, s, team.ID, "commands-app")
+	towerfileTOML := `schema_version = 4
+
+[app]
+name = "commands-app"
+script = "main.sh"
+
+[[parameters]]
+name = "name"
+
+[[commands]]
+name = "report"
+script = "report.sh"
+
+[commands.timeout]
+seconds = 60
+
+[[commands.parameters]]
+name = "month"
+type = "integer"
+`
+
+	// Every command script must be in the archive.
+	resp := uploadTowerfileVersion(t, handler, token, "commands-app", towerfileTOML)
+	assertErrorCode(t, "missing command script", resp, http.StatusBadRequest, "invalid_artifact")
+	resp.Body.Close()
+
+	resp = uploadArtifact(t, handler, token, "commands-app", buildArtifact(t, []artifactEntry{
+		{name: "Towerfile", body: []byte(towerfileTOML)},
+		{name: "main.sh", body: []byte("echo hi\n")},
+		{name: "report.sh", body: []byte("echo report\n")},
+	}))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload status: %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/commands-app/versions", token, "", nil)
+	var versions struct {
+		Versions []struct {
+			Commands []struct {
+				Name           string `json:"name"`
+				Script         string `json:"script"`
+				TimeoutSeconds *int   `json:"timeout_seconds"`
+			} `json:"commands"`
+		} `json:"versions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		t.Fatalf("decode versions: %v", err)
+	}
+	resp.Body.Close()
+	if len(versions.Versions) != 1 || len(versions.Versions[0].Commands) != 1 {
+		t.Fatalf("expected one version with one command, got %+v", versions.Versions)
+	}
+	if cmd := versions.Versions[0].Commands[0]; cmd.Name != "report" || cmd.Script != "report.sh" || cmd.TimeoutSeconds == nil || *cmd.TimeoutSeconds != 60 {
+		t.Fatalf("unexpected command: %+v", cmd)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/commands-app/runs", token, "", map[string]any{"command": "missing"})
+	assertErrorCode(t, "unknown command", resp, http.StatusBadRequest, "invalid_request")
+	resp.Body.Close()
+
+	// The command's parameters replace the app's for validation.
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/commands-app/runs", token, "", map[string]any{"command": "report", "input": map[string]any{"month": "may"}})
+	assertErrorCode(t, "command schema", resp, http.StatusBadRequest, "invalid_request")
+	resp.Body.Close()
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/commands-app/runs", token, "", map[string]any{"command": "report", "input": map[string]any{"month": 5}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create run status: %d", resp.StatusCode)
+	}
+	var created struct {
+		RunID   int64  `json:"run_id"`
+		Command string `json:"command"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode run: %v", err)
+	}
+	resp.Body.Close()
+	if created.Command != "report" {
+		t.Fatalf("expected command report, got %q", created.Command)
+	}
+
+	_, runnerToken := testutil.CreateRunner(t, s, "runner-commands", "default")
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", runnerToken, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("lease status: %d", resp.StatusCode)
+	}
+	var lease struct {
+		RunID          int64          `json:"run_id"`
+		Entrypoint     string         `json:"entrypoint"`
+		TimeoutSeconds *int           `json:"timeout_seconds"`
+		Command        string         `json:"command"`
+		ParamsSchema   map[string]any `json:"params_schema"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+		t.Fatalf("decode lease: %v", err)
+	}
+	if lease.RunID != created.RunID || lease.Command != "report" || lease.Entrypoint != "report.sh" {
+		t.Fatalf("unexpected lease: %+v", lease)
+	}
+	if lease.TimeoutSeconds == nil || *lease.TimeoutSeconds != 60 {
+		t.Fatalf("expected command timeout 60 in lease, got %v", lease.TimeoutSeconds)
+	}
+	if props, _ := lease.ParamsSchema["properties"].(map[string]any); props["month"] == nil || props["name"] != nil {
+		t.Fatalf("expected the command's params_schema in lease, got %#v", lease.ParamsSchema)
+	}
+}
+
+func TestTeamRunQuota(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, adminToken := testutil.CreateTeam(t, s, "team-quota-http")
+	_, memberToken := testutil.CreateTeamWithRole(t, s, "team-quota-member", "member")
+	app := testutil.CreateApp(t, s, team.ID, "quota-http-app")
+	testutil.CreateVersion(t, s, app.ID)
+
+	resp := doRequest(t, handler, http.MethodPatch, "/api/v1/admin/teams/team-quota-http/settings", memberToken, "", map[string]any{"max_active_runs": 2})
+	assertErrorCode(t, "member settings", resp, http.StatusForbidden, "forbidden")
+	resp.Body.Close()
+	for _, body := range []map[string]any{{"max_active_runs": 0}, {"max_active_runs": "2"}, {}, {"max_active_runs": 2, "name": "x"}} {
+		resp = doRequest(t, handler, http.MethodPatch, "/api/v1/admin/teams/team-quota-http/settings", adminToken, "", body)
+		assertErrorCode(t, fmt.Sprintf("settings %v", body), resp, http.StatusBadRequest, "invalid_request")
+		resp.Body.Close()
+	}
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/admin/teams/no-such-team/settings", adminToken, "", nil)
+	assertErrorCode(t, "unknown team", resp, http.StatusNotFound, "not_found")
+	resp.Body.Close()
+
+	resp = doRequest(t, handler, http.MethodPatch, "/api/v1/admin/teams/team-quota-http/settings", adminToken, "", map[string]any{"max_active_runs": 2})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("set quota status: %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	createRun := func() *http.Response {
+		return doRequest(t, handler, http.MethodPost, "/api/v1/apps/quota-http-app/runs", adminToken, "", map[string]any{})
+	}
+	for i := int64(1); i <= 2; i++ {
+		resp = createRun()
+		var run struct {
+			TeamActiveRuns int64 `json:"team_active_runs"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+			t.Fatalf("decode run: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated || run.TeamActiveRuns != i {
+			t.Fatalf("run %d: status %d, team_active_runs %d", i, resp.StatusCode, run.TeamActiveRuns)
+		}
+	}
+
+	resp = createRun()
+	var quotaErr struct {
+		Error struct {
+			Code  string `json:"code"`
+			Count *int64 `json:"count"`
+			Limit *int64 `json:"limit"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&quotaErr); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || quotaErr.Error.Code != "quota_exceeded" {
+		t.Fatalf("expected 429 quota_exceeded, got %d %q", resp.StatusCode, quotaErr.Error.Code)
+	}
+	if quotaErr.Error.Count == nil || *quotaErr.Error.Count != 2 || quotaErr.Error.Limit == nil || *quotaErr.Error.Limit != 2 {
+		t.Fatalf("expected count 2 and limit 2, got %v and %v", quotaErr.Error.Count, quotaErr.Error.Limit)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/quota-http-app/runs/batch", adminToken, "", map[string]any{"inputs": []any{map[string]any{}}})
+	assertErrorCode(t, "batch over quota", resp, http.StatusTooManyRequests, "quota_exceeded")
+	resp.Body.Close()
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/admin/overview", adminToken, "", nil)
+	var overview struct {
+		Teams []struct {
+			Slug          string `json:"slug"`
+			ActiveRuns    int64  `json:"active_runs"`
+			MaxActiveRuns *int64 `json:"max_active_runs"`
+		} `json:"teams"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&overview); err != nil {
+		t.Fatalf("decode overview: %v", err)
+	}
+	resp.Body.Close()
+	for _, u := range overview.Teams {
+		switch u.Slug {
+		case "team-quota-http":
+			if u.ActiveRuns != 2 || u.MaxActiveRuns == nil || *u.MaxActiveRuns != 2 {
+				t.Fatalf("unexpected utilization for %s: %d of %v", u.Slug, u.ActiveRuns, u.MaxActiveRuns)
+			}
+		case "team-quota-member":
+			if u.ActiveRuns != 0 || u.MaxActiveRuns != nil {
+				t.Fatalf("unexpected utilization for %s: %d of %v", u.Slug, u.ActiveRuns, u.MaxActiveRuns)
+			}
+		}
+	}
+
+	// null removes the quota.
+	resp = doRequest(t, handler, http.MethodPatch, "/api/v1/admin/teams/team-quota-http/settings", adminToken, "", map[string]any{"max_active_runs": nil})
+	var settings struct {
+		MaxActiveRuns *int64 `json:"max_active_runs"`
+		ActiveRuns    int64  `json:"active_runs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&settings); err != nil {
+		t.Fatalf("decode settings: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || settings.MaxActiveRuns != nil || settings.ActiveRuns != 2 {
+		t.Fatalf("unexpected settings after clearing: %d %+v", resp.StatusCode, settings)
+	}
+	resp = createRun()
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 without quota, got %d", resp.StatusCode)
+	}
+}
+
+func TestSubmitLogsRejectsPastAttemptQuota(t *testing.T) {
+	api, s, _, cleanup := newTestAPIWithConfig(t, newFixtureObjects(t), func(cfg *config.Config) {
+		cfg.LogQuotaPerAttempt = 3
+	})
+	defer cleanup()
+	handler := checkErrorCodes(t, api.Handler())
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-log-quota")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-log-quota")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-log-quota", "default")
+	_, attempt, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	logsBody := func(seqs ...int) map[string]any {
+		logs := make([]map[string]any, 0, len(seqs))
+		for _, seq := range seqs {
+			logs = append(logs, map[string]any{
+				"seq":       seq,
+				"stream":    "stdout",
+				"line":      "line " + itoa(int64(seq)),
+				"logged_at": time.Now().Format(time.RFC3339),
+			})
+		}
+		return map[string]any{"logs": logs}
+	}
+	logsPath := "/api/v1/runs/" + itoa(run.ID) + "/logs"
+
+	resp := doRequest(t, handler, http.MethodPost, logsPath, runnerToken, leaseToken, logsBody(1, 2))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 under the quota, got %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	resp = doRequest(t, handler, http.MethodPost, logsPath, runnerToken, leaseToken, logsBody(3, 4))
+	assertErrorCode(t, "batch past quota", resp, http.StatusRequestEntityTooLarge, "log_quota_exceeded")
+
+	resp = doRequest(t, handler, http.MethodPost, logsPath, runnerToken, leaseToken, logsBody(3))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for the batch that fills the quota, got %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	if n, err := s.CountAttemptLogs(ctx, attempt.ID); err != nil || n != 3 {
+		t.Fatalf("expected 3 stored lines, got %d (%v)", n, err)
+	}
+}
+
+func TestArtifactUnavailableMarksRunDead(t *testing.T) {
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	handler, s, _, cleanup := newTestServerWithObjects(t, objStore)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, teamToken := testutil.CreateTeam(t, s, "team-no-artifact")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-no-artifact")
+	// The fixture version's artifact object was never written.
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 3)
+
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-no-artifact", "default")
+	leaseToken, leaseHash, _ := auth.GenerateToken()
+	if _, _, err := s.LeaseRun(ctx, runner, leaseHash, time.Minute); err != nil {
+		t.Fatalf("lease run: %v", err)
+	}
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/artifact", runnerToken, leaseToken, nil)
+	assertErrorCode(t, "missing artifact", resp, http.StatusGone, "attempt_not_active")
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID), teamToken, "", nil)
+	defer resp.Body.Close()
+	var got struct {
+		Status     string `json:"status"`
+		DeadReason string `json:"dead_reason"`
+		RetryCount int    `json:"retry_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decode run: %v", err)
+	}
+	if got.Status != "dead" || got.DeadReason != "artifact_unavailable" || got.RetryCount != 0 {
+		t.Fatalf("expected dead artifact_unavailable run without retries, got %+v", got)
+	}
+}
+
+func TestLeaseSkipsRunWithMissingArtifact(t *testing.T) {
+	objStore := newFixtureObjects(t)
+	handler, s, _, cleanup := newTestServerWithObjects(t, objStore)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-lease-missing")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-lease-missing")
+	if err := objStore.Store("objects/gone.tar.gz", strings.NewReader("gone")); err != nil {
+		t.Fatalf("store artifact: %v", err)
+	}
+	gone, err := s.CreateVersion(ctx, app.ID, "objects/gone.tar.gz", "sha256", 0, "main.py", nil, nil, nil, nil, nil, 1, false, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+	present := testutil.CreateVersion(t, s, app.ID)
+	first := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, gone.ID, 0, 3)
+	second := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, present.ID, 0, 3)
+
+	// Someone cleans up the object store by hand.
+	if err := objStore.Delete("objects/gone.tar.gz"); err != nil {
+		t.Fatalf("delete artifact: %v", err)
+	}
+
+	_, runnerToken := testutil.CreateRunner(t, s, "runner-lease-missing", "default")
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", runnerToken, "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the second run to be leased, got %d", resp.StatusCode)
+	}
+	var lease struct {
+		RunID int64 `json:"run_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+		t.Fatalf("decode lease: %v", err)
+	}
+	resp.Body.Close()
+	if lease.RunID != second.ID {
+		t.Fatalf("expected run %d leased, got %d", second.ID, lease.RunID)
+	}
+
+	got, err := s.GetRunByIDDirect(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if got.Status != "dead" || got.DeadReason != "artifact_unavailable" || got.RetryCount != 0 {
+		t.Fatalf("expected dead artifact_unavailable run without retries, got %+v", got)
+	}
+	attempts, err := s.ListRunAttempts(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("list attempts: %v", err)
+	}
+	if len(attempts) != 0 {
+		t.Fatalf("expected no attempt for the run, got %+v", attempts)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/metrics", testMetricsToken, "", nil)
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("read metrics: %v", err)
+	}
+	if want := `minitower_artifact_missing_total{app="app-lease-missing"} 1`; !strings.Contains(string(data), want) {
+		t.Fatalf("expected %q in metrics output", want)
+	}
+}
+
+func TestMetricsEndpointAuthAndOpsListener(t *testing.T) {
+	_, _, dbConn, cleanup := newTestServer(t)
+	defer cleanup()
+
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	newAPI := func(cfg config.Config) *httpapi.Server {
+		cfg.RunnerRegistrationTokens = []string{"test-runner-reg"}
+		cfg.PublicSignupEnabled = true
+		cfg.BackupDir = t.TempDir()
+		return httpapi.New(cfg, dbConn, objStore, logger, httpapi.WithPrometheusRegisterer(prometheus.NewRegistry()))
+	}
+	status := func(handler http.Handler, path, token string) int {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodGet, path, token, "", nil)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Metrics on the API listener need the metrics token.
+	api := newAPI(config.Config{MetricsToken: "metrics-secret"})
+	if got := status(api.Handler(), "/metrics", ""); got != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", got)
+	}
+	if got := status(api.Handler(), "/metrics", "wrong"); got != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong token, got %d", got)
+	}
+	if got := status(api.Handler(), "/metrics", "metrics-secret"); got != http.StatusOK {
+		t.Fatalf("expected 200 with token, got %d", got)
+	}
+
+	// Without a token or ops listener there is no way to reach them.
+	api = newAPI(config.Config{})
+	if got := status(api.Handler(), "/metrics", ""); got != http.StatusNotFound {
+		t.Fatalf("expected 404 with no metrics token, got %d", got)
+	}
+
+	// With an ops listener they move there, unauthenticated, and pprof stays
+	// off unless enabled.
+	api = newAPI(config.Config{ListenAddr: ":8080", OpsListenAddr: ":9090", MetricsToken: "metrics-secret"})
+	if got := status(api.Handler(), "/metrics", "metrics-secret"); got != http.StatusNotFound {
+		t.Fatalf("expected metrics off the API listener, got %d", got)
+	}
+	ops := api.OpsHandler()
+	for _, path := range []string{"/metrics", "/healthz", "/readyz"} {
+		if got := status(ops, path, ""); got != http.StatusOK {
+			t.Fatalf("expected 200 for ops %s, got %d", path, got)
+		}
+	}
+	resp := doRequest(t, ops, http.MethodGet, "/metrics", "", "", nil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, name := range []string{"minitower_db_write_queue_depth", "minitower_db_write_wait_seconds_bucket"} {
+		if !strings.Contains(string(body), name) {
+			t.Fatalf("expected %s in metrics", name)
+		}
+	}
+	if got := status(ops, "/debug/pprof/", ""); got != http.StatusNotFound {
+		t.Fatalf("expected pprof disabled by default, got %d", got)
+	}
+	if got := status(ops, "/api/v1/me", ""); got != http.StatusNotFound {
+		t.Fatalf("expected API routes absent from the ops listener, got %d", got)
+	}
+
+	api = newAPI(config.Config{OpsListenAddr: ":9090", EnablePprof: true})
+	if got := status(api.OpsHandler(), "/debug/pprof/", ""); got != http.StatusOK {
+		t.Fatalf("expected pprof index when enabled, got %d", got)
+	}
+	if got := status(api.Handler(), "/debug/pprof/", ""); got != http.StatusNotFound {
+		t.Fatalf("expected pprof absent from the API listener, got %d", got)
+	}
+}
+
+func TestGetRunReportsLatestAttempt(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-run-attempt")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-run-attempt")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	getRun := func() map[string]any {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID), token, "", nil)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("get run status: %d", resp.StatusCode)
+		}
+		var body map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode run: %v", err)
+		}
+		return body
+	}
+
+	body := getRun()
+	if _, ok := body["attempt_no"]; ok {
+		t.Fatalf("expected no attempt_no before lease, got %v", body["attempt_no"])
+	}
+	if body["environment"] != "default" {
+		t.Fatalf("expected environment default, got %v", body["environment"])
+	}
+	if body["effective_priority"] != float64(0) {
+		t.Fatalf("expected effective_priority 0 while queued, got %v", body["effective_priority"])
+	}
+
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-run-attempt", "default")
+	_, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/start", runnerToken, leaseToken, nil)
+	resp.Body.Close()
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/result", runnerToken, leaseToken, map[string]any{"status": "failed", "exit_code": 3})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("result status: %d", resp.StatusCode)
+	}
+
+	body = getRun()
+	if body["attempt_no"] != float64(1) || body["exit_code"] != float64(3) {
+		t.Fatalf("expected attempt 1 with exit code 3, got attempt_no=%v exit_code=%v", body["attempt_no"], body["exit_code"])
+	}
+	if _, ok := body["effective_priority"]; ok {
+		t.Fatalf("expected no effective_priority once finished, got %v", body["effective_priority"])
+	}
+}
+
+func TestCreateRunAtMostOnceDefaultsToVersion(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-at-most-once")
+	app := testutil.CreateApp(t, s, team.ID, "app-at-most-once")
+	if _, err := s.CreateVersion(ctx, app.ID, "objects/fixture.tar.gz", "sha256", 0, "main.py", nil, nil, nil, nil, nil, 3, true, nil, nil, nil); err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+
+	createRun := func(body map[string]any) int64 {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-at-most-once/runs", token, "", body)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create run status: %d", resp.StatusCode)
+		}
+		var created struct {
+			RunID int64 `json:"run_id"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("decode run: %v", err)
+		}
+		return created.RunID
+	}
+	atMostOnce := func(runID int64) any {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(runID), token, "", nil)
+		defer resp.Body.Close()
+		var body map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode run: %v", err)
+		}
+		return body["at_most_once"]
+	}
+
+	if got := atMostOnce(createRun(map[string]any{})); got != true {
+		t.Fatalf("expected the version default at_most_once true, got %v", got)
+	}
+	if got := atMostOnce(createRun(map[string]any{"at_most_once": false})); got != false {
+		t.Fatalf("expected an explicit at_most_once false, got %v", got)
+	}
+}
+
+func TestCreateRunDefaultsMaxRetriesFromVersion(t *testing.T) {
+	handler, s, dbConn, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-version-retries")
+	app := testutil.CreateApp(t, s, team.ID, "app-version-retries")
+	testutil.CreateVersion(t, s, app.ID)
+	three := 3
+	if _, err := s.CreateVersion(ctx, app.ID, "objects/fixture.tar.gz", "sha256", 0, "main.py", nil, nil, nil, nil, nil, 5, false, &three, nil, nil); err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/app-version-retries/versions", token, "", nil)
+	var versions struct {
+		Versions []struct {
+			VersionNo  int64 `json:"version_no"`
+			MaxRetries *int  `json:"max_retries"`
+		} `json:"versions"`
+	}
+	decodeStatus(t, resp, http.StatusOK, &versions)
+	for _, v := range versions.Versions {
+		if (v.VersionNo == 2) != (v.MaxRetries != nil && *v.MaxRetries == 3) {
+			t.Fatalf("expected only version 2 to carry max_retries 3, got %+v", versions.Versions)
+		}
+	}
+
+	type runBody struct {
+		RunID            int64 `json:"run_id"`
+		MaxRetries       int   `json:"max_retries"`
+		RetryCount       int   `json:"retry_count"`
+		RetriesRemaining int   `json:"retries_remaining"`
+	}
+	createRun := func(body map[string]any) runBody {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-version-retries/runs", token, "", body)
+		var run runBody
+		decodeStatus(t, resp, http.StatusCreated, &run)
+		return run
+	}
+
+	for _, tc := range []struct {
+		name string
+		body map[string]any
+		want int
+	}{
+		{"version default", map[string]any{}, 3},
+		{"request wins", map[string]any{"max_retries": 1}, 1},
+		{"request zero wins", map[string]any{"max_retries": 0}, 0},
+		{"request clamped", map[string]any{"max_retries": 50}, 10},
+		{"version without retries", map[string]any{"version_no": 1}, 0},
+	} {
+		run := createRun(tc.body)
+		if run.MaxRetries != tc.want || run.RetriesRemaining != tc.want {
+			t.Fatalf("%s: expected max_retries and retries_remaining %d, got %+v", tc.name, tc.want, run)
+		}
+	}
+
+	run := createRun(map[string]any{})
+	if _, err := dbConn.ExecContext(ctx, `UPDATE runs SET retry_count = 1 WHERE id = ?`, run.RunID); err != nil {
+		t.Fatalf("set retry count: %v", err)
+	}
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.RunID), token, "", nil)
+	var got runBody
+	decodeStatus(t, resp, http.StatusOK, &got)
+	if got.RetryCount != 1 || got.RetriesRemaining != 2 {
+		t.Fatalf("expected 2 retries remaining after one retry, got %+v", got)
+	}
+}
+
+func TestVersionLabelsAndCreateRunByLabel(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-version-labels")
+	app := testutil.CreateApp(t, s, team.ID, "app-version-labels")
+	testutil.CreateVersion(t, s, app.ID)
+	testutil.CreateVersion(t, s, app.ID)
+	testutil.CreateVersion(t, s, app.ID)
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-version-labels/versions/1/labels", token, "", map[string]any{"label": "stable"})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("set label status: %d", resp.StatusCode)
+	}
+	var labeled struct {
+		VersionNo int64    `json:"version_no"`
+		Labels    []string `json:"labels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&labeled); err != nil {
+		t.Fatalf("decode version: %v", err)
+	}
+	if labeled.VersionNo != 1 || len(labeled.Labels) != 1 || labeled.Labels[0] != "stable" {
+		t.Fatalf("unexpected labeled version %+v", labeled)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-version-labels/versions/1/labels", token, "", map[string]any{"label": "12"})
+	assertErrorCode(t, "numeric label", resp, http.StatusBadRequest, "invalid_request")
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-version-labels/versions/9/labels", token, "", map[string]any{"label": "stable"})
+	assertErrorCode(t, "missing version", resp, http.StatusNotFound, "not_found")
+
+	createRun := func(body map[string]any) int64 {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-version-labels/runs", token, "", body)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create run status: %d", resp.StatusCode)
+		}
+		var created struct {
+			VersionNo int64 `json:"version_no"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatalf("decode run: %v", err)
+		}
+		return created.VersionNo
+	}
+	if got := createRun(map[string]any{"version_label": "stable"}); got != 1 {
+		t.Fatalf("expected stable to resolve to version 1, got %d", got)
+	}
+
+	// Moving the label changes what later runs resolve to.
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-version-labels/versions/2/labels", token, "", map[string]any{"label": "stable"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("move label status: %d", resp.StatusCode)
+	}
+	if got := createRun(map[string]any{"version_label": "stable"}); got != 2 {
+		t.Fatalf("expected stable to resolve to version 2, got %d", got)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/app-version-labels/versions", token, "", nil)
+	defer resp.Body.Close()
+	var list struct {
+		Versions []struct {
+			VersionNo int64    `json:"version_no"`
+			Labels    []string `json:"labels"`
+		} `json:"versions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode versions: %v", err)
+	}
+	for _, v := range list.Versions {
+		if want := v.VersionNo == 2; want != (len(v.Labels) == 1 && v.Labels[0] == "stable") {
+			t.Fatalf("unexpected labels on version %d: %v", v.VersionNo, v.Labels)
+		}
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-version-labels/runs", token, "", map[string]any{"version_label": "missing"})
+	assertErrorCode(t, "unknown label", resp, http.StatusNotFound, "not_found")
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-version-labels/runs", token, "", map[string]any{"version_label": "stable", "version_no": 1})
+	assertErrorCode(t, "label and number", resp, http.StatusBadRequest, "invalid_request")
+}
+
+func TestLeaseLongPoll(t *testing.T) {
+	api, s, _, cleanup := newTestAPI(t, newFixtureObjects(t))
+	defer cleanup()
+	handler := api.Handler()
+
+	team, token := testutil.CreateTeam(t, s, "team-long-poll")
+	app := testutil.CreateApp(t, s, team.ID, "app-long-poll")
+	testutil.CreateVersion(t, s, app.ID)
+	_, tokenA := testutil.CreateRunner(t, s, "runner-long-poll-a", "default")
+	_, tokenB := testutil.CreateRunner(t, s, "runner-long-poll-b", "default")
+
+	type leaseResult struct {
+		status  int
+		waitMax string
+		done    time.Time
+	}
+	lease := func(runnerToken, wait string, out chan<- leaseResult) {
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease?wait="+wait, runnerToken, "", nil)
+		resp.Body.Close()
+		out <- leaseResult{status: resp.StatusCode, waitMax: resp.Header.Get("X-Lease-Wait-Max"), done: time.Now()}
+	}
+	waitForWaiters := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for api.Queue().Waiters() != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d lease waiters, have %d", n, api.Queue().Waiters())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	createRun := func() time.Time {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/app-long-poll/runs", token, "", map[string]any{})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create run status: %d", resp.StatusCode)
+		}
+		return time.Now()
+	}
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease?wait=soon", tokenA, "", nil)
+	assertErrorCode(t, "invalid wait", resp, http.StatusBadRequest, "invalid_request")
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", tokenA, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("X-Lease-Wait-Max") != "" {
+		t.Fatalf("expected immediate 204 without wait header, got %d %q", resp.StatusCode, resp.Header.Get("X-Lease-Wait-Max"))
+	}
+
+	// A run created during the hold is leased straight away.
+	results := make(chan leaseResult, 2)
+	go lease(tokenA, "5s", results)
+	waitForWaiters(1)
+	created := createRun()
+	res := <-results
+	if res.status != http.StatusOK {
+		t.Fatalf("expected held lease to get the new run, got %d", res.status)
+	}
+	if d := res.done.Sub(created); d > 500*time.Millisecond {
+		t.Fatalf("expected lease within milliseconds of the run being created, took %s", d)
+	}
+
+	// Two waiting runners, one new run: exactly one lease. The other keeps
+	// waiting and is told it may poll again without sleeping.
+	go lease(tokenB, "1s", results)
+	waitForWaiters(1)
+	// Runner A still holds its first lease, so use a fresh runner.
+	_, tokenC := testutil.CreateRunner(t, s, "runner-long-poll-c", "default")
+	go lease(tokenC, "1s", results)
+	waitForWaiters(2)
+	createRun()
+	first, second := <-results, <-results
+	if first.status == second.status || (first.status != http.StatusOK && second.status != http.StatusOK) {
+		t.Fatalf("expected one 200 and one 204, got %d and %d", first.status, second.status)
+	}
+	empty := first
+	if empty.status == http.StatusOK {
+		empty = second
+	}
+	if empty.status != http.StatusNoContent || empty.waitMax != "30" {
+		t.Fatalf("expected held 204 with X-Lease-Wait-Max 30, got %d %q", empty.status, empty.waitMax)
+	}
+	if api.Queue().Waiters() != 0 {
+		t.Fatalf("expected waiters released, have %d", api.Queue().Waiters())
+	}
+}
+
+func TestAppDefaultInputMergedIntoRuns(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-default-input")
+	testutil.CreateApp(t, s, team.ID, "defaults-app")
+
+	resp := uploadTowerfileVersion(t, handler, token, "defaults-app", `schema_version = 2
+
+[app]
+name = "defaults-app"
+script = "main.sh"
+
+[app.default_input]
+region = "us"
+rows = 10
+
+[app.default_input.limits]
+depth = 2
+
+[[parameters]]
+name = "rows"
+type = "integer"
+`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload status: %d", resp.StatusCode)
+	}
+
+	getApp := func() map[string]any {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/defaults-app", token, "", nil)
+		defer resp.Body.Close()
+		var body map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode app: %v", err)
+		}
+		return body
+	}
+	wantDefaults := map[string]any{"region": "us", "rows": 10.0, "limits": map[string]any{"depth": 2.0}}
+	if got := getApp()["default_input"]; !reflect.DeepEqual(got, wantDefaults) {
+		t.Fatalf("default_input = %v, want %v", got, wantDefaults)
+	}
+
+	createRun := func(input map[string]any) (*http.Response, int64) {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/defaults-app/runs", token, "", map[string]any{"input": input})
+		if resp.StatusCode != http.StatusCreated {
+			return resp, 0
+		}
+		defer resp.Body.Close()
+		var run struct {
+			RunID int64 `json:"run_id"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+			t.Fatalf("decode run: %v", err)
+		}
+		return resp, run.RunID
+	}
+	storedInput := func(runID int64) map[string]any {
+		t.Helper()
+		run, err := s.GetRunByID(ctx, team.ID, runID)
+		if err != nil || run == nil {
+			t.Fatalf("get run %d: %v", runID, err)
+		}
+		return run.Input
+	}
+
+	_, first := createRun(map[string]any{"region": "eu", "limits": map[string]any{"width": 3}})
+	want := map[string]any{"region": "eu", "rows": 10.0, "limits": map[string]any{"depth": 2.0, "width": 3.0}}
+	if got := storedInput(first); !reflect.DeepEqual(got, want) {
+		t.Fatalf("stored input = %v, want %v", got, want)
+	}
+
+	// Defaults are validated along with the request input, so a bad default
+	// fails runs that do not override it.
+	resp = doRequest(t, handler, http.MethodPatch, "/api/v1/apps/defaults-app", token, "", map[string]any{"default_input": map[string]any{"rows": "many"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch status: %d", resp.StatusCode)
+	}
+	resp, _ = createRun(nil)
+	assertErrorCode(t, "bad default", resp, http.StatusBadRequest, "invalid_request")
+	if _, id := createRun(map[string]any{"rows": 5}); id == 0 {
+		t.Fatal("expected override of bad default to succeed")
+	}
+	_, removed := createRun(map[string]any{"rows": nil})
+	if removed == 0 {
+		t.Fatal("expected null to remove the bad default")
+	}
+	if got := storedInput(removed); len(got) != 0 {
+		t.Fatalf("stored input = %v, want empty", got)
+	}
+
+	// Runs keep the input they were created with.
+	if got := storedInput(first); !reflect.DeepEqual(got, want) {
+		t.Fatalf("stored input changed to %v after default update", got)
+	}
+
+	resp = doRequest(t, handler, http.MethodPatch, "/api/v1/apps/defaults-app", token, "", map[string]any{"default_input": []any{1}})
+	assertErrorCode(t, "non-object default", resp, http.StatusBadRequest, "invalid_request")
+	resp = doRequest(t, handler, http.MethodPatch, "/api/v1/apps/defaults-app", token, "", map[string]any{"slug": "renamed"})
+	assertErrorCode(t, "unknown field", resp, http.StatusBadRequest, "invalid_request")
+
+	resp = doRequest(t, handler, http.MethodPatch, "/api/v1/apps/defaults-app", token, "", map[string]any{"default_input": nil})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("clear status: %d", resp.StatusCode)
+	}
+	if _, ok := getApp()["default_input"]; ok {
+		t.Fatal("expected default_input to be cleared")
+	}
+}
+
+func TestUpdateAppDescription(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	_, token := testutil.CreateTeam(t, s, "team-app-description")
+	long := strings.Repeat("x", 501)
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps", token, "", map[string]any{"slug": "described-app", "description": long})
+	assertErrorCode(t, "long description on create", resp, http.StatusBadRequest, "invalid_request")
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps", token, "", map[string]any{"slug": "described-app"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create app status: %d", resp.StatusCode)
+	}
+
+	patch := func(body map[string]any) map[string]any {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodPatch, "/api/v1/apps/described-app", token, "", body)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("patch %v: status %d", body, resp.StatusCode)
+		}
+		var app map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&app); err != nil {
+			t.Fatalf("decode app: %v", err)
+		}
+		return app
+	}
+
+	if app := patch(map[string]any{"description": "Nightly sales ETL"}); app["description"] != "Nightly sales ETL" {
+		t.Fatalf("expected the new description, got %v", app["description"])
+	}
+	// Updating one field leaves the other alone.
+	app := patch(map[string]any{"default_input": map[string]any{"rows": 10}})
+	if app["description"] != "Nightly sales ETL" || app["default_input"] == nil {
+		t.Fatalf("unexpected app after default_input update: %v", app)
+	}
+	if app := patch(map[string]any{"description": nil}); app["description"] != nil {
+		t.Fatalf("expected the description to be cleared, got %v", app["description"])
+	}
+
+	for name, body := range map[string]map[string]any{
+		"too long":   {"description": long},
+		"not string": {"description": 5},
+		"empty body": {},
+	} {
+		resp := doRequest(t, handler, http.MethodPatch, "/api/v1/apps/described-app", token, "", body)
+		assertErrorCode(t, name, resp, http.StatusBadRequest, "invalid_request")
+	}
+}
+
+func TestErrorCatalogEndpoint(t *testing.T) {
+	handler, _, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/meta/errors", "", "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 without a token, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Errors []apierror.Entry `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode catalog: %v", err)
+	}
+	if !reflect.DeepEqual(body.Errors, apierror.Catalog()) {
+		t.Fatalf("catalog = %+v, want %+v", body.Errors, apierror.Catalog())
+	}
+}
+
+func TestLimitsEndpoint(t *testing.T) {
+	api, _, _, cleanup := newTestAPIWithConfig(t, newFixtureObjects(t), func(cfg *config.Config) {
+		cfg.MaxArtifactSize = 64 << 10
+		cfg.MaxRequestBodySize = 8 << 10
+	})
+	defer cleanup()
+	handler := checkErrorCodes(t, api.Handler())
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/meta/limits", "", "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 without a token, got %d", resp.StatusCode)
+	}
+	var limits map[string]int64
+	if err := json.NewDecoder(resp.Body).Decode(&limits); err != nil {
+		t.Fatalf("decode limits: %v", err)
+	}
+	want := map[string]int64{"max_artifact_bytes": 64 << 10, "max_request_body_bytes": 8 << 10, "max_log_line_bytes": 8192, "max_batch_runs": store.MaxBatchRuns}
+	if !reflect.DeepEqual(limits, want) {
+		t.Fatalf("limits = %v, want %v", limits, want)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestVersionUploadOverArtifactLimitAbortsEarly(t *testing.T) {
+	const limit = 64 << 10
+	api, s, _, cleanup := newTestAPIWithConfig(t, newFixtureObjects(t), func(cfg *config.Config) {
+		cfg.MaxArtifactSize = limit
+	})
+	defer cleanup()
+	handler := checkErrorCodes(t, api.Handler())
+
+	team, token := testutil.CreateTeam(t, s, "team-too-large")
+	testutil.CreateApp(t, s, team.ID, "big-app")
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("artifact", "artifact.tar.gz")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	if _, err := part.Write(bytes.Repeat([]byte("x"), 1<<20)); err != nil {
+		t.Fatalf("write artifact: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close multipart: %v", err)
+	}
+
+	upload := func(contentLength int64) (*http.Response, *countingReader) {
+		t.Helper()
+		counter := &countingReader{r: bytes.NewReader(body.Bytes())}
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/apps/big-app/versions", counter)
+		req.ContentLength = contentLength
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Result(), counter
+	}
+	decode := func(resp *http.Response) (count, limit *int64) {
+		t.Helper()
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected 413, got %d", resp.StatusCode)
+		}
+		var payload struct {
+			Error struct {
+				Code  string `json:"code"`
+				Count *int64 `json:"count"`
+				Limit *int64 `json:"limit"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if payload.Error.Code != "artifact_too_large" {
+			t.Fatalf("expected artifact_too_large, got %q", payload.Error.Code)
+		}
+		return payload.Error.Count, payload.Error.Limit
+	}
+
+	// A declared length over the limit is refused without reading the body.
+	resp, counter := upload(int64(body.Len()))
+	count, gotLimit := decode(resp)
+	if count == nil || *count != int64(body.Len()) || gotLimit == nil || *gotLimit != limit {
+		t.Fatalf("expected count %d and limit %d, got %v and %v", body.Len(), limit, count, gotLimit)
+	}
+	if counter.n != 0 {
+		t.Fatalf("expected no body read, read %d bytes", counter.n)
+	}
+
+	// Without a declared length, reading stops shortly past the limit.
+	resp, counter = upload(-1)
+	count, gotLimit = decode(resp)
+	if count != nil || gotLimit == nil || *gotLimit != limit {
+		t.Fatalf("expected only the limit, got count %v and limit %v", count, gotLimit)
+	}
+	if counter.n > limit+64<<10 {
+		t.Fatalf("expected reading to stop near the %d byte limit, read %d of %d bytes", limit, counter.n, body.Len())
+	}
+}
+
+func TestUsageReportEndpoint(t *testing.T) {
+	handler, s, dbConn, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-usage-http")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "usage-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	created := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	mustExecHTTP(t, dbConn, `UPDATE runs SET status = 'completed', created_at = ?, started_at = ?, finished_at = ? WHERE id = ?`,
+		created.UnixMilli(), created.Add(2*time.Second).UnixMilli(), created.Add(12*time.Second).UnixMilli(), run.ID)
+	_, memberToken := testutil.CreateTeamWithRole(t, s, "team-usage-member", "member")
+
+	get := func(token, query string) *http.Response {
+		return doRequest(t, handler, http.MethodGet, "/api/v1/reports/usage?"+query, token, "", nil)
+	}
+
+	resp := get(token, "from=2024-05-01&to=2024-06-01&group_by=app")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("usage status: %d", resp.StatusCode)
+	}
+	var body struct {
+		GroupBy string `json:"group_by"`
+		Rows    []struct {
+			Group                 string  `json:"group"`
+			Runs                  int64   `json:"runs"`
+			Completed             int64   `json:"completed"`
+			TotalExecutionSeconds float64 `json:"total_execution_seconds"`
+			TotalQueueSeconds     float64 `json:"total_queue_seconds"`
+		} `json:"rows"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode usage: %v", err)
+	}
+	if body.GroupBy != "app" || len(body.Rows) != 1 || body.Rows[0].Group != "usage-app" || body.Rows[0].Runs != 1 ||
+		body.Rows[0].TotalExecutionSeconds != 10 || body.Rows[0].TotalQueueSeconds != 2 {
+		t.Fatalf("unexpected usage report: %+v", body)
+	}
+
+	// Member tokens only see their own team, which has no runs.
+	resp = get(memberToken, "from=2024-05-01&to=2024-06-01")
+	defer resp.Body.Close()
+	var memberBody struct {
+		Rows []any `json:"rows"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&memberBody); err != nil || len(memberBody.Rows) != 0 {
+		t.Fatalf("member usage = %+v (%v), want no rows", memberBody, err)
+	}
+
+	assertErrorCode(t, "member by team", get(memberToken, "from=2024-05-01&to=2024-06-01&group_by=team"), http.StatusForbidden, "forbidden")
+	assertErrorCode(t, "range too long", get(token, "from=2024-01-01&to=2024-06-01"), http.StatusBadRequest, "invalid_request")
+	assertErrorCode(t, "reversed range", get(token, "from=2024-06-01&to=2024-05-01"), http.StatusBadRequest, "invalid_request")
+	assertErrorCode(t, "missing from", get(token, "to=2024-05-01"), http.StatusBadRequest, "invalid_request")
+	assertErrorCode(t, "bad grouping", get(token, "from=2024-05-01&to=2024-06-01&group_by=version"), http.StatusBadRequest, "invalid_request")
+
+	resp = get(token, "from=2024-05-01T00:00:00Z&to=2024-06-01T00:00:00Z&group_by=team")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin by team status: %d", resp.StatusCode)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	handler, s, dbConn, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, adminToken := testutil.CreateTeam(t, s, "team-audit")
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps", adminToken, "", map[string]any{"slug": "audit-app"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create app status: %d", resp.StatusCode)
+	}
+	app, err := s.GetAppBySlug(context.Background(), team.ID, "audit-app")
+	if err != nil || app == nil {
+		t.Fatalf("get app: %v", err)
+	}
+	testutil.CreateVersion(t, s, app.ID)
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/tokens", adminToken, "", map[string]any{"role": "member"})
+	var member struct {
+		TokenID int64  `json:"token_id"`
+		Token   string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&member); err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+	resp.Body.Close()
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/audit-app/runs", member.Token, "", map[string]any{})
+	var run struct {
+		RunID int64 `json:"run_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil || run.RunID == 0 {
+		t.Fatalf("decode run: %v (status %d)", err, resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	resp = doRequest(t, handler, http.MethodPost, fmt.Sprintf("/api/v1/runs/%d/cancel", run.RunID), adminToken, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("cancel status: %d", resp.StatusCode)
+	}
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/environments", adminToken, "", map[string]any{"name": "audit-staging"})
+	resp.Body.Close()
+	resp = doRequest(t, handler, http.MethodDelete, "/api/v1/environments/audit-staging", adminToken, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete env status: %d", resp.StatusCode)
+	}
+
+	type entry struct {
+		Action       string         `json:"action"`
+		ResourceType string         `json:"resource_type"`
+		ResourceID   string         `json:"resource_id"`
+		TokenID      *int64         `json:"token_id"`
+		Details      map[string]any `json:"details"`
+	}
+	list := func(token, query string) []entry {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/audit?"+query, token, "", nil)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("audit %q status: %d", query, resp.StatusCode)
+		}
+		var body struct {
+			Entries []entry `json:"entries"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode audit: %v", err)
+		}
+		return body.Entries
+	}
+	actions := func(entries []entry) string {
+		names := make([]string, len(entries))
+		for i, e := range entries {
+			names[i] = e.Action
+		}
+		return strings.Join(names, ",")
+	}
+
+	all := list(adminToken, "")
+	if got, want := actions(all), "environment.delete,environment.create,run.cancel,run.create,token.create,app.create"; got != want {
+		t.Fatalf("admin audit actions = %s, want %s", got, want)
+	}
+	runCreate := all[3]
+	if runCreate.ResourceType != "run" || runCreate.ResourceID != strconv.FormatInt(run.RunID, 10) ||
+		runCreate.TokenID == nil || *runCreate.TokenID != member.TokenID || runCreate.Details["app"] != "audit-app" {
+		t.Fatalf("unexpected run.create entry: %+v", runCreate)
+	}
+	if all[2].Details["status"] != "cancelled" {
+		t.Fatalf("unexpected run.cancel details: %+v", all[2].Details)
+	}
+
+	// Members only see what their own token did.
+	if got := actions(list(member.Token, "")); got != "run.create" {
+		t.Fatalf("member audit actions = %s", got)
+	}
+	if got := actions(list(member.Token, fmt.Sprintf("token_id=%d", *all[0].TokenID))); got != "run.create" {
+		t.Fatalf("member token_id override = %s", got)
+	}
+	if got := actions(list(adminToken, fmt.Sprintf("token_id=%d", member.TokenID))); got != "run.create" {
+		t.Fatalf("admin token_id filter = %s", got)
+	}
+	if got := actions(list(adminToken, "action=environment.create")); got != "environment.create" {
+		t.Fatalf("action filter = %s", got)
+	}
+	if got := list(adminToken, "since="+url.QueryEscape(time.Now().Add(time.Hour).UTC().Format(time.RFC3339))); len(got) != 0 {
+		t.Fatalf("future since returned %d entries", len(got))
+	}
+
+	get := func(query string) *http.Response {
+		return doRequest(t, handler, http.MethodGet, "/api/v1/audit?"+query, adminToken, "", nil)
+	}
+	assertErrorCode(t, "bad since", get("since=yesterday"), http.StatusBadRequest, "invalid_request")
+	assertErrorCode(t, "bad limit", get("limit=0"), http.StatusBadRequest, "invalid_request")
+	assertErrorCode(t, "bad token_id", get("token_id=abc"), http.StatusBadRequest, "invalid_request")
+
+	// A failed audit write never fails the request.
+	mustExecHTTP(t, dbConn, `DROP TABLE audit_log`)
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps", adminToken, "", map[string]any{"slug": "audit-app-2"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create app without audit table status: %d", resp.StatusCode)
+	}
+}
+
+func TestPromoteVersionSharesArtifactAndRecordsLineage(t *testing.T) {
+	handler, s, dbConn, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-promote")
+	staging := testutil.CreateApp(t, s, team.ID, "staging-app")
+	prod := testutil.CreateApp(t, s, team.ID, "prod-app")
+	testutil.CreateVersion(t, s, prod.ID)
+	other, otherToken := testutil.CreateTeam(t, s, "team-promote-other")
+	testutil.CreateApp(t, s, other.ID, "other-prod-app")
+
+	resp := uploadTowerfileVersion(t, handler, token, "staging-app",
+		"[app]\nname = \"staging-app\"\nscript = \"main.sh\"\n\n[app.timeout]\nseconds = 90\n\n[[parameters]]\nname = \"region\"\n")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload status: %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/staging-app/versions/1/promote", token, "", map[string]any{"target_app": "prod-app"})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("promote status: %d", resp.StatusCode)
+	}
+	var promoted struct {
+		VersionNo      int64          `json:"version_no"`
+		Entrypoint     string         `json:"entrypoint"`
+		TimeoutSeconds *int           `json:"timeout_seconds"`
+		ParamsSchema   map[string]any `json:"params_schema"`
+		PromotedFrom   *struct {
+			App       string `json:"app"`
+			VersionNo int64  `json:"version_no"`
+		} `json:"promoted_from"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&promoted); err != nil {
+		t.Fatalf("decode version: %v", err)
+	}
+	if promoted.VersionNo != 2 || promoted.Entrypoint != "main.sh" || promoted.TimeoutSeconds == nil || *promoted.TimeoutSeconds != 90 || promoted.ParamsSchema == nil {
+		t.Fatalf("expected version 2 of prod-app with the source's metadata, got %+v", promoted)
+	}
+	if promoted.PromotedFrom == nil || promoted.PromotedFrom.App != "staging-app" || promoted.PromotedFrom.VersionNo != 1 {
+		t.Fatalf("expected promoted_from staging-app version 1, got %+v", promoted.PromotedFrom)
+	}
+
+	source, err := s.GetVersionByNumber(context.Background(), staging.ID, 1)
+	if err != nil || source == nil {
+		t.Fatalf("get source version: %+v (%v)", source, err)
+	}
+	target, err := s.GetVersionByNumber(context.Background(), prod.ID, 2)
+	if err != nil || target == nil {
+		t.Fatalf("get promoted version: %+v (%v)", target, err)
+	}
+	if target.ArtifactObjectKey != source.ArtifactObjectKey || target.ArtifactSHA256 != source.ArtifactSHA256 {
+		t.Fatalf("expected the promoted version to share the source artifact, got %q and %q", target.ArtifactObjectKey, source.ArtifactObjectKey)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/prod-app/versions", token, "", nil)
+	defer resp.Body.Close()
+	var list struct {
+		Versions []struct {
+			VersionNo    int64           `json:"version_no"`
+			PromotedFrom json.RawMessage `json:"promoted_from"`
+		} `json:"versions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode versions: %v", err)
+	}
+	if len(list.Versions) != 2 || string(list.Versions[0].PromotedFrom) != `{"app":"staging-app","version_no":1}` || list.Versions[1].PromotedFrom != nil {
+		t.Fatalf("expected lineage only on the promoted version, got %+v", list.Versions)
+	}
+
+	// Removing the source version's row leaves the shared object in place.
+	if _, err := dbConn.Exec(`DELETE FROM app_versions WHERE id = ?`, source.ID); err != nil {
+		t.Fatalf("delete source version: %v", err)
+	}
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/prod-app/versions/2/files/content?path=main.sh", token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the promoted artifact to stay readable, got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/prod-app/versions/2/promote", token, "", map[string]any{"target_app": "other-prod-app"})
+	assertErrorCode(t, "other team's app", resp, http.StatusNotFound, "not_found")
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/prod-app/versions/2/promote", otherToken, "", map[string]any{"target_app": "other-prod-app"})
+	assertErrorCode(t, "other team's source", resp, http.StatusNotFound, "not_found")
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/prod-app/versions/2/promote", token, "", map[string]any{"target_app": "prod-app"})
+	assertErrorCode(t, "same app", resp, http.StatusBadRequest, "invalid_request")
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/prod-app/versions/2/promote", token, "", map[string]any{})
+	assertErrorCode(t, "missing target", resp, http.StatusBadRequest, "invalid_request")
+}
@@ -0,0 +1,206 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"minitower/internal/testutil"
+)
+
+// streamLogLines returns NDJSON entries with seqs from through to.
+func streamLogLines(from, to int) string {
+	var b strings.Builder
+	for seq := from; seq <= to; seq++ {
+		fmt.Fprintf(&b, "{\"seq\":%d,\"stream\":\"stdout\",\"line\":\"line %d\",\"logged_at\":\"2024-01-01T00:00:00Z\"}\n", seq, seq)
+	}
+	return b.String()
+}
+
+func newLogStreamRequest(t *testing.T, runID int64, runnerToken, leaseToken string, body io.Reader) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "http://example/api/v1/runs/"+itoa(runID)+"/logs/stream", body)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+runnerToken)
+	req.Header.Set("X-Lease-Token", leaseToken)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	return req
+}
+
+func TestLogStreamStoresLinesAndCountsRepeats(t *testing.T) {
+	handler, s, dbConn, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, _ := testutil.CreateTeam(t, s, "team-log-stream")
+	app := testutil.CreateApp(t, s, team.ID, "log-stream-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	env, err := s.GetOrCreateDefaultEnvironment(context.Background(), team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-log-stream", "default")
+	run, attempt, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	// 10k lines, the last 500 of them repeating seqs already sent.
+	body := streamLogLines(1, 9500) + streamLogLines(9001, 9500)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newLogStreamRequest(t, run.ID, runnerToken, leaseToken, strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var summary struct {
+		Accepted int `json:"accepted"`
+		Deduped  int `json:"deduped"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("decode summary: %v", err)
+	}
+	if summary.Accepted != 9500 || summary.Deduped != 500 {
+		t.Fatalf("expected 9500 accepted and 500 deduped, got %+v", summary)
+	}
+
+	var stored int
+	if err := dbConn.QueryRow(`SELECT COUNT(*) FROM run_logs WHERE run_attempt_id = ?`, attempt.ID).Scan(&stored); err != nil {
+		t.Fatalf("count logs: %v", err)
+	}
+	if stored != 9500 {
+		t.Fatalf("expected 9500 stored lines, got %d", stored)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newLogStreamRequest(t, run.ID, runnerToken, leaseToken, strings.NewReader(`{"seq":1,"stream":"stdin","line":"x"}`+"\n")))
+	assertErrorCode(t, "bad stream", rec.Result(), http.StatusBadRequest, "invalid_request")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newLogStreamRequest(t, run.ID, runnerToken, "wrong-lease", strings.NewReader(streamLogLines(1, 1))))
+	if rec.Code != http.StatusGone {
+		t.Fatalf("expected 410 for a wrong lease, got %d", rec.Code)
+	}
+}
+
+func TestLogStreamEndsWith410WhenLeaseGoesStale(t *testing.T) {
+	handler, s, dbConn, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, _ := testutil.CreateTeam(t, s, "team-log-stream-stale")
+	app := testutil.CreateApp(t, s, team.ID, "log-stream-stale-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	env, err := s.GetOrCreateDefaultEnvironment(context.Background(), team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-log-stream-stale", "default")
+	run, attempt, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	pr, pw := io.Pipe()
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(rec, newLogStreamRequest(t, run.ID, runnerToken, leaseToken, pr))
+		pr.CloseWithError(io.ErrClosedPipe)
+	}()
+
+	if _, err := io.WriteString(pw, streamLogLines(1, 100)); err != nil {
+		t.Fatalf("write first batch: %v", err)
+	}
+	countLogs := func() int {
+		var n int
+		if err := dbConn.QueryRow(`SELECT COUNT(*) FROM run_logs WHERE run_attempt_id = ?`, attempt.ID).Scan(&n); err != nil {
+			t.Fatalf("count logs: %v", err)
+		}
+		return n
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for countLogs() < 100 {
+		if time.Now().After(deadline) {
+			t.Fatal("first batch was not stored while the stream was open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The lease is lost mid-stream, as when the reaper expires it.
+	mustExecHTTP(t, dbConn, `UPDATE run_attempts SET status = 'expired' WHERE id = ?`, attempt.ID)
+	_, _ = io.WriteString(pw, streamLogLines(101, 200))
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end after the lease went stale")
+	}
+	pw.Close()
+	if rec.Code != http.StatusGone {
+		t.Fatalf("expected 410, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if n := countLogs(); n != 100 {
+		t.Fatalf("expected only the lines sent before the lease went stale, got %d", n)
+	}
+}
+
+func TestLogStreamLineIsReadableBeforeTheStreamCloses(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-log-stream-live")
+	app := testutil.CreateApp(t, s, team.ID, "log-stream-live-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	env, err := s.GetOrCreateDefaultEnvironment(context.Background(), team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-log-stream-live", "default")
+	run, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	pr, pw := io.Pipe()
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(rec, newLogStreamRequest(t, run.ID, runnerToken, leaseToken, pr))
+	}()
+
+	// One line, far short of a batch, with the stream left open.
+	if _, err := io.WriteString(pw, streamLogLines(1, 1)); err != nil {
+		t.Fatalf("write line: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var logs struct {
+			Logs []struct {
+				Seq  int    `json:"seq"`
+				Line string `json:"line"`
+			} `json:"logs"`
+		}
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/logs", token, "", nil)
+		decodeStatus(t, resp, http.StatusOK, &logs)
+		if len(logs.Logs) == 1 && logs.Logs[0].Line == "line 1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("streamed line was not readable while the stream was open, got %+v", logs.Logs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatalf("stream ended early: %d %s", rec.Code, rec.Body.String())
+	default:
+	}
+
+	pw.Close()
+	<-done
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
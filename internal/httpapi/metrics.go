@@ -10,6 +10,9 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	dbpkg "minitower/internal/db"
+	"minitower/internal/store"
 )
 
 var (
@@ -33,13 +36,23 @@ type Metrics struct {
 	runsCreated      *prometheus.CounterVec
 	runsCompleted    *prometheus.CounterVec
 	runsRetried      *prometheus.CounterVec
+	runsReaped       *prometheus.CounterVec
+	runsSlow         *prometheus.CounterVec
 	runsLeased       *prometheus.CounterVec
 	runnersRegistered *prometheus.CounterVec
+	logsPurged        prometheus.Counter
+	reaperProcessed   prometheus.Counter
+	artifactsMissing  *prometheus.CounterVec
 
 	// Domain histograms
 	runQueueWait   *prometheus.HistogramVec
 	runExecution   *prometheus.HistogramVec
 	runTotal       *prometheus.HistogramVec
+	reaperTick     prometheus.Histogram
+
+	// Domain gauges
+	runnerClockSkew     *prometheus.GaugeVec
+	environmentsStarved *prometheus.GaugeVec
 }
 
 // NewMetrics creates a new Metrics instance with registered collectors.
@@ -100,9 +113,9 @@ func NewMetrics(reg prometheus.Registerer, db *sql.DB) *Metrics {
 		runsCompleted: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "minitower_runs_completed_total",
-				Help: "Total runs completed, by team, app, and terminal status.",
+				Help: "Total runs completed, by team, app, terminal status, dead reason, and failure kind.",
 			},
-			[]string{"team", "app", "status"},
+			[]string{"team", "app", "status", "reason", "failure_kind"},
 		),
 		runsRetried: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -111,6 +124,20 @@ func NewMetrics(reg prometheus.Registerer, db *sql.DB) *Metrics {
 			},
 			[]string{"team", "app"},
 		),
+		runsReaped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "minitower_runs_reaped_total",
+				Help: "Total attempts ended by the reaper, by team, app, and outcome.",
+			},
+			[]string{"team", "app", "outcome"},
+		),
+		runsSlow: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "minitower_runs_exceeded_p95_total",
+				Help: "Total runs flagged for running longer than their app's p95 execution time, by team and app.",
+			},
+			[]string{"team", "app"},
+		),
 		runsLeased: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "minitower_runs_leased_total",
@@ -125,6 +152,25 @@ func NewMetrics(reg prometheus.Registerer, db *sql.DB) *Metrics {
 			},
 			[]string{"environment"},
 		),
+		logsPurged: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "minitower_logs_purged_total",
+				Help: "Total run log lines deleted by the log retention job.",
+			},
+		),
+		reaperProcessed: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "minitower_reaper_attempts_processed_total",
+				Help: "Total expired attempts the reaper ended.",
+			},
+		),
+		artifactsMissing: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "minitower_artifact_missing_total",
+				Help: "Total runs ended because their version artifact was missing from the object store, by app.",
+			},
+			[]string{"app"},
+		),
 
 		// Domain histograms
 		runQueueWait: prometheus.NewHistogramVec(
@@ -151,21 +197,64 @@ func NewMetrics(reg prometheus.Registerer, db *sql.DB) *Metrics {
 			},
 			[]string{"team", "app", "status"},
 		),
+		reaperTick: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "minitower_reaper_tick_duration_seconds",
+				Help:    "Time one reaper tick took, including the cancel and offline-runner sweeps.",
+				Buckets: prometheus.ExponentialBuckets(0.001, 4, 10), // 1ms to ~262s
+			},
+		),
+
+		// Domain gauges
+		runnerClockSkew: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "minitower_runner_clock_skew_seconds",
+				Help: "Server clock minus runner clock as last reported by each runner's heartbeat.",
+			},
+			[]string{"runner"},
+		),
+		environmentsStarved: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "minitower_environments_starved",
+				Help: "1 while an environment has queued runs and no online runner, 0 once it recovers.",
+			},
+			[]string{"environment"},
+		),
 	}
 
 	reg.MustRegister(
 		m.requestsTotal, m.requestDuration, m.requestSize, m.responseSize,
-		m.runsCreated, m.runsCompleted, m.runsRetried, m.runsLeased, m.runnersRegistered,
-		m.runQueueWait, m.runExecution, m.runTotal,
+		m.runsCreated, m.runsCompleted, m.runsRetried, m.runsReaped, m.runsSlow, m.runsLeased, m.runnersRegistered, m.logsPurged, m.reaperProcessed, m.artifactsMissing,
+		m.runQueueWait, m.runExecution, m.runTotal, m.reaperTick,
+		m.runnerClockSkew, m.environmentsStarved,
 	)
 
 	if db != nil {
 		reg.MustRegister(NewDomainCollector(db))
+		registerWriterMetrics(reg, dbpkg.WriterFor(db))
 	}
 
 	return m
 }
 
+// registerWriterMetrics exposes the depth of the database write queue and
+// how long writes wait in it.
+func registerWriterMetrics(reg prometheus.Registerer, w *dbpkg.Writer) {
+	wait := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "minitower_db_write_wait_seconds",
+		Help:    "Time a database write waited in the write queue before its transaction began.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10), // 100µs to ~26s
+	})
+	w.SetWaitObserver(func(d time.Duration) { wait.Observe(d.Seconds()) })
+	reg.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "minitower_db_write_queue_depth",
+			Help: "Database writes queued or running.",
+		}, func() float64 { return float64(w.Depth()) }),
+		wait,
+	)
+}
+
 // Handler returns the Prometheus metrics HTTP handler.
 func (m *Metrics) Handler() http.Handler {
 	return promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{})
@@ -178,13 +267,42 @@ func (m *Metrics) RunCreated(team, app string) {
 }
 
 func (m *Metrics) RunCompleted(team, app, status string) {
-	m.runsCompleted.WithLabelValues(team, app, status).Inc()
+	m.runsCompleted.WithLabelValues(team, app, status, "", "").Inc()
+}
+
+// RunFailed counts a run that ended failed, labelled with the failure kind
+// its runner reported, empty if none.
+func (m *Metrics) RunFailed(team, app, failureKind string) {
+	m.runsCompleted.WithLabelValues(team, app, "failed", "", failureKind).Inc()
+}
+
+// RunDead counts a run that ended dead, labelled with its store dead reason.
+// Dead runs are always infrastructure failures.
+func (m *Metrics) RunDead(team, app, reason string) {
+	m.runsCompleted.WithLabelValues(team, app, "dead", reason, store.FailureKindInfrastructure).Inc()
 }
 
 func (m *Metrics) RunRetried(team, app string) {
 	m.runsRetried.WithLabelValues(team, app).Inc()
 }
 
+// RunReaped counts an attempt the reaper ended. Outcome is the store's
+// ReapResult outcome, so at-most-once deaths are told apart from runs that
+// ran out of retries.
+func (m *Metrics) RunReaped(team, app, outcome string) {
+	m.runsReaped.WithLabelValues(team, app, outcome).Inc()
+}
+
+// RunSlow counts a run the slow-run check flagged as past its app's p95.
+func (m *Metrics) RunSlow(team, app string) {
+	m.runsSlow.WithLabelValues(team, app).Inc()
+}
+
+// ArtifactMissing counts a run ended because its artifact object was gone.
+func (m *Metrics) ArtifactMissing(app string) {
+	m.artifactsMissing.WithLabelValues(app).Inc()
+}
+
 func (m *Metrics) RunLeased(environment string) {
 	m.runsLeased.WithLabelValues(environment).Inc()
 }
@@ -193,6 +311,35 @@ func (m *Metrics) RunnerRegistered(environment string) {
 	m.runnersRegistered.WithLabelValues(environment).Inc()
 }
 
+func (m *Metrics) RunnerClockSkew(runner string, seconds float64) {
+	m.runnerClockSkew.WithLabelValues(runner).Set(seconds)
+}
+
+// EnvironmentStarved sets whether an environment has queued runs and no
+// online runner.
+func (m *Metrics) EnvironmentStarved(environment string, starved bool) {
+	v := 0.0
+	if starved {
+		v = 1
+	}
+	m.environmentsStarved.WithLabelValues(environment).Set(v)
+}
+
+// LogsPurged counts log lines deleted by the log retention job.
+func (m *Metrics) LogsPurged(n int64) {
+	m.logsPurged.Add(float64(n))
+}
+
+// ReaperAttemptsProcessed counts expired attempts one reaper batch ended.
+func (m *Metrics) ReaperAttemptsProcessed(n int) {
+	m.reaperProcessed.Add(float64(n))
+}
+
+// ObserveReaperTick records how long one reaper tick took.
+func (m *Metrics) ObserveReaperTick(d time.Duration) {
+	m.reaperTick.Observe(d.Seconds())
+}
+
 func (m *Metrics) ObserveQueueWait(team, app string, seconds float64) {
 	m.runQueueWait.WithLabelValues(team, app).Observe(seconds)
 }
@@ -256,6 +403,11 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // normalizePath converts dynamic path segments to placeholders to prevent high cardinality.
 // Examples:
 //   - /api/v1/apps/hello -> /api/v1/apps/{app}
@@ -286,6 +438,10 @@ func normalizePath(path string) string {
 		if len(parts) >= 5 && isSlugOrID(parts[4]) {
 			parts[4] = "{run}"
 		}
+	case "run-status":
+		// /api/v1/run-status/{status_token}: the token is a credential, so it
+		// never reaches a label.
+		parts[4] = "{status_token}"
 	case "runners":
 		// /api/v1/runners/register - no dynamic segment
 	case "tokens", "bootstrap":
@@ -4,6 +4,9 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+
+	"minitower/internal/apierror"
+	"minitower/internal/httputil"
 )
 
 type Middleware func(http.Handler) http.Handler
@@ -21,15 +24,21 @@ func BodyLimitMiddleware(maxBytes int64) Middleware {
 	}
 }
 
-// ArtifactBodyLimitMiddleware applies a larger body limit specifically for artifact upload endpoints.
+// ArtifactBodyLimitMiddleware applies a larger body limit specifically for
+// artifact and run output upload endpoints. Streamed logs are not limited:
+// each entry is bounded as it is read and the log quota bounds the total.
 func ArtifactBodyLimitMiddleware(maxArtifactBytes, maxDefaultBytes int64) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/logs/stream") {
+				next.ServeHTTP(w, r)
+				return
+			}
 			limit := maxDefaultBytes
 
-			// Use larger limit for version artifact uploads
-			// POST /api/v1/apps/{app}/versions
-			if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/versions") {
+			// Use larger limit for version artifact and run output uploads
+			// POST /api/v1/apps/{app}/versions, POST /api/v1/runs/{run}/outputs
+			if r.Method == http.MethodPost && (strings.HasSuffix(r.URL.Path, "/versions") || strings.HasSuffix(r.URL.Path, "/outputs")) {
 				limit = maxArtifactBytes
 			}
 
@@ -41,6 +50,27 @@ func ArtifactBodyLimitMiddleware(maxArtifactBytes, maxDefaultBytes int64) Middle
 	}
 }
 
+// RequestIDMiddleware assigns each request an ID, keeping a valid incoming
+// X-Request-ID, and echoes it on the response. A valid X-Run-Trace-ID from a
+// runner is carried alongside it. Both are put in the request context, where
+// the context-aware log handler and WriteError pick them up.
+func RequestIDMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(httputil.RequestIDHeader)
+			if !httputil.ValidID(id) {
+				id = httputil.NewRequestID()
+			}
+			w.Header().Set(httputil.RequestIDHeader, id)
+			ctx := httputil.WithRequestID(r.Context(), id)
+			if traceID := r.Header.Get(httputil.RunTraceIDHeader); httputil.ValidID(traceID) {
+				ctx = httputil.WithRunTraceID(ctx, traceID)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 func CORSMiddleware(allowedOrigins []string) Middleware {
 	allowed := make(map[string]struct{}, len(allowedOrigins))
 	for _, origin := range allowedOrigins {
@@ -68,7 +98,8 @@ func CORSMiddleware(allowedOrigins []string) Middleware {
 				addVaryHeader(w.Header(), "Origin")
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Lease-Token")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Lease-Token, X-Request-ID")
+				w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 				w.Header().Set("Access-Control-Max-Age", "86400")
 			}
 
@@ -95,8 +126,8 @@ func Recoverer(logger *slog.Logger) Middleware {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if recovered := recover(); recovered != nil {
-					logger.Error("panic", "error", recovered)
-					writeError(w, http.StatusInternalServerError, "internal", "internal error")
+					logger.ErrorContext(r.Context(), "panic", "error", recovered)
+					writeAPIError(w, apierror.Internal, "internal error")
 				}
 			}()
 
@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"minitower/internal/httpapi/handlers"
+	"minitower/internal/httpapi/openapi"
+)
+
+const openAPIPath = "/api/v1/openapi.json"
+
+// handle registers a route on the API mux, remembering its pattern so the
+// OpenAPI document can be checked to cover it.
+func (s *Server) handle(pattern string, h http.Handler) {
+	s.patterns = append(s.patterns, pattern)
+	s.mux.Handle(pattern, h)
+}
+
+func (s *Server) handleFunc(pattern string, h http.HandlerFunc) {
+	s.handle(pattern, h)
+}
+
+// serverOperations describes the routes the server serves itself.
+func serverOperations() []openapi.Operation {
+	status := []openapi.Response{{Status: http.StatusOK, Body: statusResponse{}}}
+	return []openapi.Operation{
+		{Method: http.MethodGet, Path: "/health", Summary: "Report that the server is up", Responses: status},
+		{Method: http.MethodGet, Path: "/ready", Summary: "Report that the server can serve requests", Responses: status},
+		{Method: http.MethodGet, Path: "/readyz", Summary: "Report that the server can serve requests", Responses: status},
+		{Method: http.MethodGet, Path: "/metrics", Summary: "Prometheus metrics", Auth: openapi.AuthMetrics,
+			Responses: []openapi.Response{{Status: http.StatusOK, ContentType: "text/plain"}}},
+		{Method: http.MethodGet, Path: openAPIPath, Summary: "This document",
+			Responses: []openapi.Response{{Status: http.StatusOK, ContentType: "application/json"}}},
+	}
+}
+
+// buildOpenAPI renders the OpenAPI document once the routes are registered.
+// Like a conflicting ServeMux pattern, a route missing from the document is
+// a programming error, so it panics.
+func (s *Server) buildOpenAPI() []byte {
+	ops := append(handlers.Operations(), serverOperations()...)
+	doc, err := openapi.Build(openapi.Info{
+		Title:       "minitower API",
+		Version:     "v1",
+		Description: "Team endpoints take a team token, the runner protocol a runner token and, per run, the lease token.",
+	}, ops)
+	if err != nil {
+		panic(fmt.Sprintf("httpapi: build OpenAPI document: %v", err))
+	}
+	for _, pattern := range s.patterns {
+		if !documented(doc, pattern) {
+			panic(fmt.Sprintf("httpapi: route %s is missing from the OpenAPI document", pattern))
+		}
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		panic(fmt.Sprintf("httpapi: encode OpenAPI document: %v", err))
+	}
+	return data
+}
+
+// documented reports whether doc has a path for a mux pattern: the same
+// path, or for a subtree pattern ending in "/", a path under it.
+func documented(doc *openapi.Document, pattern string) bool {
+	if _, ok := doc.Paths[pattern]; ok {
+		return true
+	}
+	if !strings.HasSuffix(pattern, "/") {
+		return false
+	}
+	for path := range doc.Paths {
+		if strings.HasPrefix(path, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleOpenAPI serves the OpenAPI document.
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(s.openAPI)
+}
@@ -0,0 +1,334 @@
+// Package openapi builds an OpenAPI 3.0 document from a list of operations
+// whose request and response bodies are given as values of the Go types the
+// handlers encode, so the document follows the structs' JSON tags.
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"minitower/internal/httputil"
+)
+
+// Version is the OpenAPI version of built documents.
+const Version = "3.0.3"
+
+// Auth is the credential an operation requires.
+type Auth int
+
+const (
+	AuthNone Auth = iota
+	// AuthTeam is a team API token.
+	AuthTeam
+	// AuthAdmin is a team API token with the admin role.
+	AuthAdmin
+	// AuthRunner is a runner token.
+	AuthRunner
+	// AuthLease is a runner token plus the lease token of the run's active
+	// attempt in the X-Lease-Token header.
+	AuthLease
+	// AuthRunnerRegistration is the platform runner registration token.
+	AuthRunnerRegistration
+	// AuthBootstrap is the bootstrap token.
+	AuthBootstrap
+	// AuthMetrics is the metrics token.
+	AuthMetrics
+)
+
+// Security scheme names in components.securitySchemes.
+const (
+	SchemeTeamToken         = "teamToken"
+	SchemeRunnerToken       = "runnerToken"
+	SchemeLeaseToken        = "leaseToken"
+	SchemeRegistrationToken = "runnerRegistrationToken"
+	SchemeBootstrapToken    = "bootstrapToken"
+	SchemeMetricsToken      = "metricsToken"
+)
+
+// Operation describes one method on one path.
+type Operation struct {
+	Method string
+	// Path uses {name} for path parameters. Parameters named id, or ending
+	// in _id or _no, are integers; the rest are strings.
+	Path    string
+	Summary string
+	Auth    Auth
+	Query   []Param
+	// Request is a value of the JSON request body's type, nil for none.
+	Request any
+	// Upload names the file part of a multipart request body, "" for none.
+	Upload string
+	// UploadFields are the other parts of a multipart request body.
+	UploadFields []Param
+	Responses    []Response
+}
+
+// Param is a query parameter.
+type Param struct {
+	Name string
+	// Type is a JSON schema type: string, integer or boolean.
+	Type        string
+	Description string
+	Required    bool
+}
+
+// Response is one documented response of an operation.
+type Response struct {
+	Status      int
+	Description string
+	// Body is a value of the JSON body's type. With ContentType set, the
+	// body is not JSON and Body is ignored; with neither, there is no body.
+	Body        any
+	ContentType string
+}
+
+// Document is an OpenAPI document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info is the document's info object.
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations on one path.
+type PathItem struct {
+	Get    *OperationObject `json:"get,omitempty"`
+	Post   *OperationObject `json:"post,omitempty"`
+	Put    *OperationObject `json:"put,omitempty"`
+	Patch  *OperationObject `json:"patch,omitempty"`
+	Delete *OperationObject `json:"delete,omitempty"`
+}
+
+// OperationObject is an OpenAPI operation object.
+type OperationObject struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Parameters  []ParameterObject          `json:"parameters,omitempty"`
+	RequestBody *RequestBody               `json:"requestBody,omitempty"`
+	Responses   map[string]*ResponseObject `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+}
+
+// ParameterObject is an OpenAPI parameter object.
+type ParameterObject struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is an OpenAPI request body object.
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// ResponseObject is an OpenAPI response object.
+type ResponseObject struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType is an OpenAPI media type object.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the reusable schemas and the security schemes.
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+}
+
+// SecurityScheme is an OpenAPI security scheme object.
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	Name        string `json:"name,omitempty"`
+	In          string `json:"in,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+var securitySchemes = map[string]*SecurityScheme{
+	SchemeTeamToken:         {Type: "http", Scheme: "bearer", Description: "Team API token. Admin endpoints need a token with the admin role."},
+	SchemeRunnerToken:       {Type: "http", Scheme: "bearer", Description: "Runner token returned by runner registration."},
+	SchemeLeaseToken:        {Type: "apiKey", In: "header", Name: "X-Lease-Token", Description: "Lease token of the run's active attempt, returned by the lease."},
+	SchemeRegistrationToken: {Type: "http", Scheme: "bearer", Description: "Platform runner registration token."},
+	SchemeBootstrapToken:    {Type: "http", Scheme: "bearer", Description: "Bootstrap token; the endpoint exists only when one is configured."},
+	SchemeMetricsToken:      {Type: "http", Scheme: "bearer", Description: "Metrics token; the endpoint exists only when one is configured and there is no ops listener."},
+}
+
+func (a Auth) security() []map[string][]string {
+	switch a {
+	case AuthTeam, AuthAdmin:
+		return []map[string][]string{{SchemeTeamToken: {}}}
+	case AuthRunner:
+		return []map[string][]string{{SchemeRunnerToken: {}}}
+	case AuthLease:
+		return []map[string][]string{{SchemeRunnerToken: {}, SchemeLeaseToken: {}}}
+	case AuthRunnerRegistration:
+		return []map[string][]string{{SchemeRegistrationToken: {}}}
+	case AuthBootstrap:
+		return []map[string][]string{{SchemeBootstrapToken: {}}}
+	case AuthMetrics:
+		return []map[string][]string{{SchemeMetricsToken: {}}}
+	default:
+		return nil
+	}
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// Build returns the document for ops. It fails on an operation that
+// repeats a method and path, or that has no responses.
+func Build(info Info, ops []Operation) (*Document, error) {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			SecuritySchemes: securitySchemes,
+		},
+	}
+	schemas := newSchemaSet()
+	errorSchema := schemas.of(httputil.ErrorEnvelope{}, true)
+
+	for _, op := range ops {
+		if len(op.Responses) == 0 {
+			return nil, fmt.Errorf("%s %s: no responses", op.Method, op.Path)
+		}
+		item := doc.Paths[op.Path]
+		if item == nil {
+			item = &PathItem{}
+			doc.Paths[op.Path] = item
+		}
+		slot, err := item.slot(op.Method)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", op.Method, op.Path, err)
+		}
+		if *slot != nil {
+			return nil, fmt.Errorf("%s %s: duplicate operation", op.Method, op.Path)
+		}
+
+		obj := &OperationObject{
+			OperationID: operationID(op.Method, op.Path),
+			Summary:     op.Summary,
+			Responses:   make(map[string]*ResponseObject),
+			Security:    op.Auth.security(),
+		}
+		for _, m := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			obj.Parameters = append(obj.Parameters, ParameterObject{
+				Name:     m[1],
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: pathParamType(m[1])},
+			})
+		}
+		for _, q := range op.Query {
+			obj.Parameters = append(obj.Parameters, ParameterObject{
+				Name:        q.Name,
+				In:          "query",
+				Description: q.Description,
+				Required:    q.Required,
+				Schema:      &Schema{Type: q.Type},
+			})
+		}
+		switch {
+		case op.Upload != "":
+			form := &Schema{
+				Type:       "object",
+				Properties: map[string]*Schema{op.Upload: {Type: "string", Format: "binary"}},
+				Required:   []string{op.Upload},
+			}
+			for _, f := range op.UploadFields {
+				form.Properties[f.Name] = &Schema{Type: f.Type, Description: f.Description}
+				if f.Required {
+					form.Required = append(form.Required, f.Name)
+				}
+			}
+			obj.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{
+				"multipart/form-data": {Schema: form},
+			}}
+		case op.Request != nil:
+			obj.RequestBody = &RequestBody{Content: map[string]*MediaType{
+				"application/json": {Schema: schemas.of(op.Request, false)},
+			}}
+		}
+		for _, r := range op.Responses {
+			desc := r.Description
+			if desc == "" {
+				desc = http.StatusText(r.Status)
+			}
+			resp := &ResponseObject{Description: desc}
+			switch {
+			case r.ContentType != "":
+				schema := &Schema{Type: "string"}
+				if !strings.HasPrefix(r.ContentType, "text/") {
+					schema.Format = "binary"
+				}
+				resp.Content = map[string]*MediaType{r.ContentType: {Schema: schema}}
+			case r.Body != nil:
+				resp.Content = map[string]*MediaType{"application/json": {Schema: schemas.of(r.Body, true)}}
+			}
+			obj.Responses[strconv.Itoa(r.Status)] = resp
+		}
+		obj.Responses["default"] = &ResponseObject{
+			Description: "Error; the code field is one of GET /api/v1/meta/errors.",
+			Content:     map[string]*MediaType{"application/json": {Schema: errorSchema}},
+		}
+		*slot = obj
+	}
+	doc.Components.Schemas = schemas.byName
+	return doc, nil
+}
+
+func (p *PathItem) slot(method string) (**OperationObject, error) {
+	switch method {
+	case http.MethodGet:
+		return &p.Get, nil
+	case http.MethodPost:
+		return &p.Post, nil
+	case http.MethodPut:
+		return &p.Put, nil
+	case http.MethodPatch:
+		return &p.Patch, nil
+	case http.MethodDelete:
+		return &p.Delete, nil
+	default:
+		return nil, fmt.Errorf("unsupported method")
+	}
+}
+
+func pathParamType(name string) string {
+	if name == "id" || strings.HasSuffix(name, "_id") || strings.HasSuffix(name, "_no") {
+		return "integer"
+	}
+	return "string"
+}
+
+// operationID derives a stable ID from the method and path, e.g.
+// "get_api_v1_apps_app_versions" for GET /api/v1/apps/{app}/versions.
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.Split(path, "/") {
+		seg = strings.Trim(seg, "{}")
+		seg = strings.NewReplacer(".", "_", "-", "_").Replace(seg)
+		if seg != "" {
+			b.WriteString("_")
+			b.WriteString(seg)
+		}
+	}
+	return b.String()
+}
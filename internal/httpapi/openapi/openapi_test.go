@@ -0,0 +1,121 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"minitower/internal/httpapi/openapi"
+)
+
+type inner struct {
+	Name string `json:"name"`
+}
+
+type sample struct {
+	inner
+	ID      int64          `json:"id"`
+	Note    *string        `json:"note,omitempty"`
+	Tags    []string       `json:"tags"`
+	Extra   map[string]any `json:"extra,omitempty"`
+	Child   *sample        `json:"child,omitempty"`
+	Ignored string         `json:"-"`
+	hidden  string
+}
+
+func TestBuildSchemasFromJSONTags(t *testing.T) {
+	doc, err := openapi.Build(openapi.Info{Title: "t", Version: "v1"}, []openapi.Operation{{
+		Method:    http.MethodPost,
+		Path:      "/things/{thing_id}",
+		Auth:      openapi.AuthLease,
+		Request:   sample{},
+		Responses: []openapi.Response{{Status: http.StatusCreated, Body: sample{}}},
+	}})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := openapi.Validate(data); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	s := doc.Components.Schemas["Sample"]
+	if s == nil {
+		t.Fatalf("expected a Sample component, got %v", doc.Components.Schemas)
+	}
+	for _, name := range []string{"name", "id", "note", "tags", "extra", "child"} {
+		if s.Properties[name] == nil {
+			t.Errorf("missing property %q", name)
+		}
+	}
+	if len(s.Properties) != 6 {
+		t.Errorf("expected 6 properties, got %d", len(s.Properties))
+	}
+	if !s.Properties["note"].Nullable || s.Properties["child"].Ref != "#/components/schemas/Sample" {
+		t.Errorf("unexpected pointer schemas: note=%+v child=%+v", s.Properties["note"], s.Properties["child"])
+	}
+	// The request reached Sample first, so nothing is required; a request
+	// decoder accepts any field missing.
+	if len(s.Required) != 0 {
+		t.Errorf("expected no required fields from a request, got %v", s.Required)
+	}
+
+	op := doc.Paths["/things/{thing_id}"].Post
+	if op.Parameters[0].Name != "thing_id" || op.Parameters[0].Schema.Type != "integer" || !op.Parameters[0].Required {
+		t.Errorf("unexpected path parameter %+v", op.Parameters[0])
+	}
+	if len(op.Security) != 1 || len(op.Security[0]) != 2 {
+		t.Errorf("expected runner and lease tokens together, got %v", op.Security)
+	}
+	if op.Responses["default"] == nil {
+		t.Errorf("expected a default error response")
+	}
+}
+
+func TestBuildRejectsDuplicateOperations(t *testing.T) {
+	op := openapi.Operation{Method: http.MethodGet, Path: "/x", Responses: []openapi.Response{{Status: http.StatusOK}}}
+	if _, err := openapi.Build(openapi.Info{Title: "t", Version: "v1"}, []openapi.Operation{op, op}); err == nil {
+		t.Fatal("expected an error for a duplicate operation")
+	}
+}
+
+func TestValidateReportsProblems(t *testing.T) {
+	doc := `{
+		"openapi": "3.1.0",
+		"info": {"title": "t"},
+		"paths": {
+			"/runs/{run_id}": {
+				"get": {
+					"operationId": "a",
+					"responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Missing"}}}}},
+					"security": [{"nope": []}]
+				},
+				"post": {"operationId": "a", "responses": {}}
+			}
+		},
+		"components": {"schemas": {"Bad": {"type": "array"}}, "securitySchemes": {}}
+	}`
+	err := openapi.Validate([]byte(doc))
+	if err == nil {
+		t.Fatal("expected problems")
+	}
+	for _, want := range []string{
+		"not a 3.0.x version",
+		"info.version: missing",
+		`path parameter "run_id" is not declared`,
+		"missing description",
+		`$ref "#/components/schemas/Missing" does not resolve`,
+		`undefined scheme "nope"`,
+		`operationId "a" already used`,
+		"no responses",
+		"array without items",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in:\n%v", want, err)
+		}
+	}
+}
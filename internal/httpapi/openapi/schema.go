@@ -0,0 +1,149 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Schema is an OpenAPI 3.0 schema object.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// schemaSet turns Go types into schemas, putting each named struct type in
+// byName once and referring to it from everywhere else.
+type schemaSet struct {
+	byName map[string]*Schema
+	names  map[reflect.Type]string
+}
+
+func newSchemaSet() *schemaSet {
+	return &schemaSet{byName: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+}
+
+// of returns the schema of v's type. In a response, fields without
+// omitempty are always sent and so are listed as required; a request's
+// decoder accepts any of them missing. A struct type gets the required
+// list of the first body it is reached from.
+func (s *schemaSet) of(v any, response bool) *Schema {
+	return s.schema(reflect.TypeOf(v), response)
+}
+
+func (s *schemaSet) schema(t reflect.Type, response bool) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.schema(t.Elem(), response)
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schema(t.Elem(), response)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schema(t.Elem(), response)}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t, response)
+		}
+		name, ok := s.names[t]
+		if !ok {
+			name = s.componentName(t)
+			s.names[t] = name
+			// Placeholder first, so a type that refers to itself resolves.
+			s.byName[name] = &Schema{}
+			*s.byName[name] = *s.structSchema(t, response)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		panic(fmt.Sprintf("openapi: unsupported type %s", t))
+	}
+}
+
+func (s *schemaSet) structSchema(t reflect.Type, response bool) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	s.addFields(schema, t, response)
+	return schema
+}
+
+// addFields adds t's fields as encoding/json sees them, promoting the
+// fields of untagged embedded structs.
+func (s *schemaSet) addFields(schema *Schema, t reflect.Type, response bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			s.addFields(schema, f.Type, response)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		schema.Properties[name] = s.schema(f.Type, response)
+		if response && !strings.Contains(","+opts+",", ",omitempty,") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// componentName is the type's name with its first letter upper-cased,
+// prefixed with its package's when another type already took the name.
+func (s *schemaSet) componentName(t reflect.Type) string {
+	name := exportName(t.Name())
+	if _, taken := s.byName[name]; taken {
+		pkg := t.PkgPath()
+		name = exportName(pkg[strings.LastIndex(pkg, "/")+1:]) + name
+	}
+	return name
+}
+
+func exportName(name string) string {
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,272 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Validate checks a serialized document against the OpenAPI 3.0 rules that
+// generated documents rely on: the required fields of each object, path
+// templates matching their path parameters, unique operation IDs, response
+// keys, security requirements naming defined schemes, and every $ref
+// resolving. It works on the JSON, not a Document, so it checks what
+// clients receive. It returns every problem found, joined.
+func Validate(data []byte) error {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parse document: %w", err)
+	}
+	v := &validator{doc: doc, operationIDs: make(map[string]string)}
+	v.document()
+	return errors.Join(v.errs...)
+}
+
+var (
+	versionPattern     = regexp.MustCompile(`^3\.0\.\d+$`)
+	responseKeyPattern = regexp.MustCompile(`^[1-5](\d\d|XX)$`)
+	schemaTypes        = map[string]bool{"string": true, "number": true, "integer": true, "boolean": true, "array": true, "object": true}
+	parameterIns       = map[string]bool{"query": true, "header": true, "path": true, "cookie": true}
+	pathItemKeys       = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true, "summary": true, "description": true, "servers": true, "parameters": true}
+	httpMethods        = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+)
+
+type validator struct {
+	doc          map[string]any
+	errs         []error
+	operationIDs map[string]string
+}
+
+func (v *validator) errorf(format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf(format, args...))
+}
+
+func (v *validator) document() {
+	if s, _ := v.doc["openapi"].(string); !versionPattern.MatchString(s) {
+		v.errorf("openapi: %q is not a 3.0.x version", v.doc["openapi"])
+	}
+	info, ok := v.doc["info"].(map[string]any)
+	if !ok {
+		v.errorf("info: missing")
+	} else {
+		for _, key := range []string{"title", "version"} {
+			if s, _ := info[key].(string); s == "" {
+				v.errorf("info.%s: missing", key)
+			}
+		}
+	}
+
+	components, _ := v.doc["components"].(map[string]any)
+	schemes, _ := components["securitySchemes"].(map[string]any)
+	for _, name := range sortedKeys(schemes) {
+		v.securityScheme("components.securitySchemes."+name, schemes[name])
+	}
+	schemas, _ := components["schemas"].(map[string]any)
+	for _, name := range sortedKeys(schemas) {
+		v.schema("components.schemas."+name, schemas[name])
+	}
+
+	paths, ok := v.doc["paths"].(map[string]any)
+	if !ok {
+		v.errorf("paths: missing")
+		return
+	}
+	for _, path := range sortedKeys(paths) {
+		v.pathItem(path, paths[path], schemes)
+	}
+}
+
+func (v *validator) securityScheme(at string, raw any) {
+	scheme, _ := raw.(map[string]any)
+	switch scheme["type"] {
+	case "http":
+		if s, _ := scheme["scheme"].(string); s == "" {
+			v.errorf("%s: http scheme without scheme", at)
+		}
+	case "apiKey":
+		if s, _ := scheme["name"].(string); s == "" {
+			v.errorf("%s: apiKey scheme without name", at)
+		}
+		if in := scheme["in"]; in != "query" && in != "header" && in != "cookie" {
+			v.errorf("%s: apiKey scheme in %v", at, in)
+		}
+	case "oauth2", "openIdConnect":
+	default:
+		v.errorf("%s: unknown type %v", at, scheme["type"])
+	}
+}
+
+func (v *validator) pathItem(path string, raw any, schemes map[string]any) {
+	if !strings.HasPrefix(path, "/") {
+		v.errorf("paths.%s: does not start with /", path)
+	}
+	item, ok := raw.(map[string]any)
+	if !ok {
+		v.errorf("paths.%s: not an object", path)
+		return
+	}
+	templated := make(map[string]bool)
+	for _, m := range regexp.MustCompile(`\{([^}]+)\}`).FindAllStringSubmatch(path, -1) {
+		templated[m[1]] = true
+	}
+	for _, key := range sortedKeys(item) {
+		if !pathItemKeys[key] {
+			v.errorf("paths.%s: unknown field %q", path, key)
+		}
+		if httpMethods[key] {
+			v.operation(fmt.Sprintf("paths.%s.%s", path, key), item[key], templated, schemes)
+		}
+	}
+}
+
+func (v *validator) operation(at string, raw any, templated map[string]bool, schemes map[string]any) {
+	op, ok := raw.(map[string]any)
+	if !ok {
+		v.errorf("%s: not an object", at)
+		return
+	}
+	if id, _ := op["operationId"].(string); id != "" {
+		if prev, dup := v.operationIDs[id]; dup {
+			v.errorf("%s: operationId %q already used by %s", at, id, prev)
+		}
+		v.operationIDs[id] = at
+	}
+
+	declared := make(map[string]bool)
+	params, _ := op["parameters"].([]any)
+	for i, raw := range params {
+		pat := fmt.Sprintf("%s.parameters[%d]", at, i)
+		p, _ := raw.(map[string]any)
+		name, _ := p["name"].(string)
+		in, _ := p["in"].(string)
+		if name == "" || !parameterIns[in] {
+			v.errorf("%s: needs a name and in of query, header, path or cookie", pat)
+			continue
+		}
+		if in == "path" {
+			declared[name] = true
+			if p["required"] != true {
+				v.errorf("%s: path parameter %q must be required", pat, name)
+			}
+			if !templated[name] {
+				v.errorf("%s: path parameter %q is not in the path", pat, name)
+			}
+		}
+		if _, ok := p["schema"]; !ok {
+			v.errorf("%s: missing schema", pat)
+		} else {
+			v.schema(pat+".schema", p["schema"])
+		}
+	}
+	for name := range templated {
+		if !declared[name] {
+			v.errorf("%s: path parameter %q is not declared", at, name)
+		}
+	}
+
+	if raw, ok := op["requestBody"]; ok {
+		body, _ := raw.(map[string]any)
+		v.content(at+".requestBody", body["content"], true)
+	}
+
+	responses, _ := op["responses"].(map[string]any)
+	if len(responses) == 0 {
+		v.errorf("%s: no responses", at)
+	}
+	for _, key := range sortedKeys(responses) {
+		rat := at + ".responses." + key
+		if key != "default" && !responseKeyPattern.MatchString(key) {
+			v.errorf("%s: not a status code", rat)
+		}
+		resp, _ := responses[key].(map[string]any)
+		if _, ok := resp["description"].(string); !ok {
+			v.errorf("%s: missing description", rat)
+		}
+		if c, ok := resp["content"]; ok {
+			v.content(rat, c, false)
+		}
+	}
+
+	security, _ := op["security"].([]any)
+	for i, raw := range security {
+		req, _ := raw.(map[string]any)
+		for name := range req {
+			if _, ok := schemes[name]; !ok {
+				v.errorf("%s.security[%d]: undefined scheme %q", at, i, name)
+			}
+		}
+	}
+}
+
+func (v *validator) content(at string, raw any, required bool) {
+	content, _ := raw.(map[string]any)
+	if required && len(content) == 0 {
+		v.errorf("%s: empty content", at)
+	}
+	for _, mediaType := range sortedKeys(content) {
+		mt, _ := content[mediaType].(map[string]any)
+		if s, ok := mt["schema"]; ok {
+			v.schema(at+".content."+mediaType+".schema", s)
+		}
+	}
+}
+
+func (v *validator) schema(at string, raw any) {
+	schema, ok := raw.(map[string]any)
+	if !ok {
+		v.errorf("%s: not an object", at)
+		return
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		if len(schema) > 1 {
+			v.errorf("%s: $ref has sibling fields, which OpenAPI 3.0 ignores", at)
+		}
+		name, found := strings.CutPrefix(ref, "#/components/schemas/")
+		components, _ := v.doc["components"].(map[string]any)
+		schemas, _ := components["schemas"].(map[string]any)
+		if _, ok := schemas[name]; !found || !ok {
+			v.errorf("%s: $ref %q does not resolve", at, ref)
+		}
+		return
+	}
+	typ, hasType := schema["type"].(string)
+	if hasType && !schemaTypes[typ] {
+		v.errorf("%s: unknown type %q", at, typ)
+	}
+	if typ == "array" {
+		if _, ok := schema["items"]; !ok {
+			v.errorf("%s: array without items", at)
+		}
+	}
+	if items, ok := schema["items"]; ok {
+		v.schema(at+".items", items)
+	}
+	if ap, ok := schema["additionalProperties"]; ok {
+		if _, isBool := ap.(bool); !isBool {
+			v.schema(at+".additionalProperties", ap)
+		}
+	}
+	props, _ := schema["properties"].(map[string]any)
+	for _, name := range sortedKeys(props) {
+		v.schema(at+".properties."+name, props[name])
+	}
+	required, _ := schema["required"].([]any)
+	for _, r := range required {
+		name, _ := r.(string)
+		if _, ok := props[name]; !ok {
+			v.errorf("%s: required %q is not a property", at, r)
+		}
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,135 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"minitower/internal/httpapi/openapi"
+	"minitower/internal/testutil"
+)
+
+type servedDocument struct {
+	Paths map[string]map[string]struct {
+		Security  []map[string][]string `json:"security"`
+		Responses map[string]struct {
+			Content map[string]struct {
+				Schema struct {
+					Ref string `json:"$ref"`
+				} `json:"schema"`
+			} `json:"content"`
+		} `json:"responses"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]any `json:"properties"`
+			Required   []string       `json:"required"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestOpenAPIDocumentIsValidAndCoversRoutes(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/openapi.json", "", "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a public JSON document, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read document: %v", err)
+	}
+	if err := openapi.Validate(data); err != nil {
+		t.Fatalf("invalid document:\n%v", err)
+	}
+	var doc servedDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("decode document: %v", err)
+	}
+
+	// Auth requirements: bearer team and runner tokens, and the lease
+	// token on top of the runner token for per-run runner calls.
+	for _, tc := range []struct {
+		method, path string
+		schemes      []string
+	}{
+		{"get", "/api/v1/apps", []string{"teamToken"}},
+		{"get", "/api/v1/admin/runners", []string{"teamToken"}},
+		{"post", "/api/v1/runs/lease", []string{"runnerToken"}},
+		{"post", "/api/v1/runs/{run_id}/logs", []string{"leaseToken", "runnerToken"}},
+		{"get", "/api/v1/runs/{run_id}/logs", []string{"teamToken"}},
+		{"get", "/api/v1/meta/errors", nil},
+	} {
+		var got []string
+		for _, req := range doc.Paths[tc.path][tc.method].Security {
+			for name := range req {
+				got = append(got, name)
+			}
+		}
+		if strings.Join(slices.Sorted(slices.Values(got)), ",") != strings.Join(tc.schemes, ",") {
+			t.Errorf("%s %s: expected security %v, got %v", tc.method, tc.path, tc.schemes, got)
+		}
+	}
+
+	// Response schemas follow the structs' JSON tags.
+	logsOK := doc.Paths["/api/v1/runs/{run_id}/logs"]["post"].Responses["200"].Content["application/json"].Schema.Ref
+	if logsOK != "#/components/schemas/StatusResponse" {
+		t.Fatalf("expected SubmitLogs to return StatusResponse, got %q", logsOK)
+	}
+	run := doc.Components.Schemas["RunResponse"]
+	if run.Properties["run_id"] == nil || run.Properties["exit_code"] == nil {
+		t.Fatalf("RunResponse lacks fields: %v", run.Properties)
+	}
+	if !slices.Contains(run.Required, "run_id") || slices.Contains(run.Required, "exit_code") {
+		t.Fatalf("expected run_id required and omitempty exit_code not, got %v", run.Required)
+	}
+	if cancel := doc.Components.Schemas["CancelBatchResponse"]; cancel.Properties["batch_id"] == nil || cancel.Properties["cancelled"] == nil {
+		t.Fatalf("expected embedded batch fields promoted, got %v", cancel.Properties)
+	}
+
+	// Every documented operation reaches a handler: the mux answers
+	// unknown paths with a plain-text 404 and the routers wrong methods
+	// with a bare 405, while handlers answer with JSON.
+	_, adminToken := testutil.CreateTeamWithRole(t, s, "team-openapi", "admin")
+	_, runnerToken := testutil.CreateRunner(t, s, "openapi-runner", "default")
+	tokens := map[string]string{
+		"teamToken":               adminToken,
+		"runnerToken":             runnerToken,
+		"runnerRegistrationToken": "test-runner-reg",
+		"bootstrapToken":          "test",
+		"metricsToken":            testMetricsToken,
+	}
+	param := regexp.MustCompile(`\{([a-z_]+)\}`)
+	for path, item := range doc.Paths {
+		concrete := param.ReplaceAllStringFunc(path, func(m string) string {
+			if strings.HasSuffix(m, "_id}") || strings.HasSuffix(m, "_no}") {
+				return "999"
+			}
+			return "missing"
+		})
+		for method, op := range item {
+			var bearer, lease string
+			for _, req := range op.Security {
+				for name := range req {
+					if name == "leaseToken" {
+						lease = "not-a-lease"
+					} else {
+						bearer = tokens[name]
+					}
+				}
+			}
+			resp := doRequest(t, handler, strings.ToUpper(method), concrete, bearer, lease, nil)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusMethodNotAllowed ||
+				(resp.StatusCode == http.StatusNotFound && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain")) {
+				t.Errorf("%s %s: not routed (%d)", strings.ToUpper(method), path, resp.StatusCode)
+			}
+		}
+	}
+}
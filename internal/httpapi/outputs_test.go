@@ -0,0 +1,159 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func uploadRunOutput(t *testing.T, handler http.Handler, runnerToken, leaseToken string, runID int64, name string, data []byte) *http.Response {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatalf("write form file: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close multipart: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://example/api/v1/runs/"+itoa(runID)+"/outputs", &body)
+	req.Header.Set("Authorization", "Bearer "+runnerToken)
+	req.Header.Set("X-Lease-Token", leaseToken)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func TestRunOutputsUploadListDownload(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-outputs")
+	_, otherToken := testutil.CreateTeam(t, s, "team-outputs-other")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "outputs-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-outputs", "default")
+	run, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	resp := uploadRunOutput(t, handler, runnerToken, leaseToken, run.ID, "report.csv", []byte("a,b\n1,2\n"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	// Uploading the same name again replaces the output.
+	report := []byte("a,b\n3,4\n")
+	resp = uploadRunOutput(t, handler, runnerToken, leaseToken, run.ID, "report.csv", report)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 on replace, got %d", resp.StatusCode)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/outputs", token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var list struct {
+		Outputs []struct {
+			Name      string `json:"name"`
+			SizeBytes int64  `json:"size_bytes"`
+			SHA256    string `json:"sha256"`
+		} `json:"outputs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	sum := sha256.Sum256(report)
+	if len(list.Outputs) != 1 || list.Outputs[0].Name != "report.csv" ||
+		list.Outputs[0].SizeBytes != int64(len(report)) || list.Outputs[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected outputs: %+v", list.Outputs)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/outputs/report.csv", token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(data, report) {
+		t.Fatalf("downloaded %q, want %q", data, report)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename=report.csv` {
+		t.Fatalf("unexpected Content-Disposition %q", got)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/outputs/missing.csv", token, "", nil)
+	assertErrorCode(t, "missing output", resp, http.StatusNotFound, "not_found")
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/outputs", otherToken, "", nil)
+	assertErrorCode(t, "other team", resp, http.StatusNotFound, "not_found")
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID)+"/outputs/report.csv", otherToken, "", nil)
+	assertErrorCode(t, "other team download", resp, http.StatusNotFound, "not_found")
+}
+
+func TestRunOutputUploadCaps(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-output-caps")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "output-caps-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-output-caps", "default")
+	run, attempt, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+
+	resp := uploadRunOutput(t, handler, runnerToken, leaseToken, run.ID, "..", []byte("x"))
+	assertErrorCode(t, "dot-dot name", resp, http.StatusBadRequest, "invalid_request")
+
+	resp = uploadRunOutput(t, handler, runnerToken, leaseToken, run.ID, "big.bin", make([]byte, 10<<20+1))
+	assertErrorCode(t, "oversized", resp, http.StatusRequestEntityTooLarge, "file_too_large")
+
+	resp = uploadRunOutput(t, handler, runnerToken, "not-the-lease", run.ID, "a.txt", []byte("x"))
+	assertErrorCode(t, "bad lease", resp, http.StatusGone, "lease_invalid")
+
+	for i := 0; i < 20; i++ {
+		if _, err := s.SaveRunOutput(ctx, &store.RunOutput{
+			RunID: run.ID, RunAttemptID: attempt.ID, Name: fmt.Sprintf("out-%02d.txt", i),
+			SizeBytes: 1, SHA256: "00", ObjectKey: "unused",
+		}); err != nil {
+			t.Fatalf("save output: %v", err)
+		}
+	}
+	resp = uploadRunOutput(t, handler, runnerToken, leaseToken, run.ID, "one-more.txt", []byte("x"))
+	assertErrorCode(t, "count cap", resp, http.StatusConflict, "output_limit")
+
+	// Replacing an existing output does not count against the cap.
+	resp = uploadRunOutput(t, handler, runnerToken, leaseToken, run.ID, "out-00.txt", []byte("x"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 replacing an output at the cap, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,151 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"minitower/internal/httpapi"
+	"minitower/internal/httputil"
+	"minitower/internal/testutil"
+)
+
+var generatedIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func TestRequestIDHeaderRoundTrip(t *testing.T) {
+	handler, _, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	cases := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{name: "kept", incoming: "client-req.42", keep: true},
+		{name: "generated", incoming: ""},
+		{name: "invalid replaced", incoming: "bad id\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			if tc.incoming != "" {
+				req.Header.Set("X-Request-ID", tc.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Get("X-Request-ID")
+			if tc.keep {
+				if got != tc.incoming {
+					t.Fatalf("X-Request-ID = %q, want %q", got, tc.incoming)
+				}
+				return
+			}
+			if !generatedIDPattern.MatchString(got) {
+				t.Fatalf("expected generated request ID, got %q", got)
+			}
+		})
+	}
+}
+
+func TestErrorBodyIncludesRequestID(t *testing.T) {
+	handler, _, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+	req.Header.Set("X-Request-ID", "trace-me")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+	var body struct {
+		Error struct {
+			Code      string `json:"code"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Error.RequestID != "trace-me" {
+		t.Fatalf("error request_id = %q, want trace-me", body.Error.RequestID)
+	}
+}
+
+func TestRunTraceIDOnRunAndLease(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	team, teamToken := testutil.CreateTeam(t, s, "team-trace")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-trace")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	_, runnerToken := testutil.CreateRunner(t, s, "runner-trace", "default")
+
+	if !generatedIDPattern.MatchString(run.TraceID) {
+		t.Fatalf("expected generated trace ID on create, got %q", run.TraceID)
+	}
+
+	getResp := doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID), teamToken, "", nil)
+	defer getResp.Body.Close()
+	var got struct {
+		RunTraceID string `json:"run_trace_id"`
+	}
+	if err := json.NewDecoder(getResp.Body).Decode(&got); err != nil {
+		t.Fatalf("decode run: %v", err)
+	}
+	if got.RunTraceID != run.TraceID {
+		t.Fatalf("run response run_trace_id = %q, want %q", got.RunTraceID, run.TraceID)
+	}
+
+	leaseResp := doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", runnerToken, "", nil)
+	defer leaseResp.Body.Close()
+	if leaseResp.StatusCode != http.StatusOK {
+		t.Fatalf("lease status: %d", leaseResp.StatusCode)
+	}
+	var lease struct {
+		RunTraceID string `json:"run_trace_id"`
+	}
+	if err := json.NewDecoder(leaseResp.Body).Decode(&lease); err != nil {
+		t.Fatalf("decode lease: %v", err)
+	}
+	if lease.RunTraceID != run.TraceID {
+		t.Fatalf("lease run_trace_id = %q, want %q", lease.RunTraceID, run.TraceID)
+	}
+}
+
+func TestContextLogHandlerAddsCorrelationIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(httputil.NewContextHandler(slog.NewJSONHandler(&buf, nil)))
+
+	handler := httpapi.RequestIDMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "handled")
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/runs/1/heartbeat", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("X-Run-Trace-ID", "0123abcd")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("decode log line %q: %v", buf.String(), err)
+	}
+	if line["request_id"] != "req-1" {
+		t.Fatalf("request_id = %v, want req-1", line["request_id"])
+	}
+	if line["run_trace_id"] != "0123abcd" {
+		t.Fatalf("run_trace_id = %v, want 0123abcd", line["run_trace_id"])
+	}
+}
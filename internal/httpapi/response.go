@@ -1,15 +1,24 @@
 package httpapi
 
 import (
+	"fmt"
 	"net/http"
 
+	"minitower/internal/apierror"
 	"minitower/internal/httputil"
 )
 
+// statusResponse is the body of the health and readiness checks.
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
 func writeJSON(w http.ResponseWriter, status int, payload any) {
 	httputil.WriteJSON(w, status, payload)
 }
 
-func writeError(w http.ResponseWriter, status int, code, message string) {
-	httputil.WriteError(w, status, code, message)
+// writeAPIError writes an error response with the status the catalog
+// assigns to code.
+func writeAPIError(w http.ResponseWriter, code apierror.Code, msgf string, args ...any) {
+	httputil.WriteError(w, code.Status(), string(code), fmt.Sprintf(msgf, args...))
 }
@@ -0,0 +1,67 @@
+package httpapi_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"minitower/internal/testutil"
+)
+
+func TestSubmitResultRecordsFailureKind(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-failure-kind")
+	app := testutil.CreateApp(t, s, team.ID, "app-failure-kind")
+	env, err := s.GetOrCreateDefaultEnvironment(context.Background(), team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, runnerToken := testutil.CreateRunner(t, s, "runner-failure-kind", "default")
+	_, _, leaseToken, _ := testutil.LeaseRun(t, s, runner)
+	resultPath := "/api/v1/runs/" + itoa(run.ID) + "/result"
+
+	for _, tc := range []struct {
+		label string
+		body  map[string]any
+	}{
+		{"unknown kind", map[string]any{"status": "failed", "exit_code": 1, "failure_kind": "network"}},
+		{"kind on a completed run", map[string]any{"status": "completed", "exit_code": 0, "failure_kind": "user"}},
+	} {
+		resp := doRequest(t, handler, http.MethodPost, resultPath, runnerToken, leaseToken, tc.body)
+		assertErrorCode(t, tc.label, resp, http.StatusBadRequest, "invalid_request")
+	}
+
+	resp := doRequest(t, handler, http.MethodPost, resultPath, runnerToken, leaseToken,
+		map[string]any{"status": "failed", "exit_code": 1, "failure_kind": "user"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("result status: %d", resp.StatusCode)
+	}
+
+	var got struct {
+		Status      string `json:"status"`
+		FailureKind string `json:"failure_kind"`
+	}
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(run.ID), token, "", nil)
+	decodeStatus(t, resp, http.StatusOK, &got)
+	if got.Status != "failed" || got.FailureKind != "user" {
+		t.Fatalf("expected a failed run of kind user, got %+v", got)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/metrics", testMetricsToken, "", nil)
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("read metrics: %v", err)
+	}
+	want := `minitower_runs_completed_total{app="app-failure-kind",failure_kind="user",reason="",status="failed",team="team-failure-kind"} 1`
+	if !strings.Contains(string(data), want) {
+		t.Fatalf("expected %q in metrics output", want)
+	}
+}
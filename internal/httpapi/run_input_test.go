@@ -0,0 +1,112 @@
+package httpapi_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"minitower/internal/config"
+	"minitower/internal/testutil"
+)
+
+func TestLargeRunInputRoundTripsThroughLease(t *testing.T) {
+	api, s, _, cleanup := newTestAPIWithConfig(t, newFixtureObjects(t), func(cfg *config.Config) {
+		cfg.InputCompressAbove = 1024
+		cfg.MaxInputSize = 4 * 1024
+	})
+	defer cleanup()
+	handler := checkErrorCodes(t, api.Handler())
+
+	team, token := testutil.CreateTeam(t, s, "team-large-input")
+	app := testutil.CreateApp(t, s, team.ID, "large-input")
+	testutil.CreateVersion(t, s, app.ID)
+	_, runnerToken := testutil.CreateRunner(t, s, "runner-large-input", "default")
+
+	// Repetitive input compresses far below the stored limit.
+	rows := make([]any, 0, 500)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, fmt.Sprintf("row-%04d", i))
+	}
+	input := map[string]any{"rows": rows}
+
+	var created struct {
+		RunID int64 `json:"run_id"`
+	}
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/large-input/runs", token, "", map[string]any{"input": input})
+	decodeStatus(t, resp, http.StatusCreated, &created)
+
+	type listedRun struct {
+		RunID        int64          `json:"run_id"`
+		Input        map[string]any `json:"input"`
+		InputOmitted bool           `json:"input_omitted"`
+	}
+	type runList struct {
+		Runs []listedRun `json:"runs"`
+	}
+	for _, path := range []string{"/api/v1/apps/large-input/runs", "/api/v1/runs"} {
+		var list runList
+		resp = doRequest(t, handler, http.MethodGet, path, token, "", nil)
+		decodeStatus(t, resp, http.StatusOK, &list)
+		if len(list.Runs) != 1 || !list.Runs[0].InputOmitted || list.Runs[0].Input != nil {
+			t.Fatalf("%s: expected the compressed input omitted, got %+v", path, list.Runs)
+		}
+		list = runList{}
+		resp = doRequest(t, handler, http.MethodGet, path+"?include=input", token, "", nil)
+		decodeStatus(t, resp, http.StatusOK, &list)
+		if len(list.Runs) != 1 || list.Runs[0].InputOmitted || !reflect.DeepEqual(list.Runs[0].Input, input) {
+			t.Fatalf("%s?include=input: expected the full input", path)
+		}
+	}
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/large-input/runs?include=logs", token, "", nil)
+	assertErrorCode(t, "unknown include", resp, http.StatusBadRequest, "invalid_request")
+
+	var run listedRun
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs/"+itoa(created.RunID), token, "", nil)
+	decodeStatus(t, resp, http.StatusOK, &run)
+	if !reflect.DeepEqual(run.Input, input) {
+		t.Fatalf("get run: expected the full input")
+	}
+
+	var lease struct {
+		RunID int64          `json:"run_id"`
+		Input map[string]any `json:"input"`
+	}
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/lease", runnerToken, "", nil)
+	decodeStatus(t, resp, http.StatusOK, &lease)
+	if lease.RunID != created.RunID || !reflect.DeepEqual(lease.Input, input) {
+		t.Fatalf("lease: expected run %d with the full input, got run %d", created.RunID, lease.RunID)
+	}
+}
+
+func TestRunInputOverStoredLimitIs413(t *testing.T) {
+	api, s, _, cleanup := newTestAPIWithConfig(t, newFixtureObjects(t), func(cfg *config.Config) {
+		cfg.MaxInputSize = 256
+	})
+	defer cleanup()
+	handler := checkErrorCodes(t, api.Handler())
+
+	ctx := context.Background()
+	team, token := testutil.CreateTeam(t, s, "team-input-limit")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "input-limit")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	big := map[string]any{"input": map[string]any{"note": fmt.Sprintf("%0300d", 0)}}
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/input-limit/runs", token, "", big)
+	assertErrorCode(t, "create run", resp, http.StatusRequestEntityTooLarge, "input_too_large")
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/runs/"+itoa(run.ID)+"/rerun", token, "", map[string]any{"input_overrides": big["input"]})
+	assertErrorCode(t, "rerun", resp, http.StatusRequestEntityTooLarge, "input_too_large")
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/input-limit/runs", token, "", map[string]any{"input": map[string]any{"note": "ok"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 under the limit, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,101 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"minitower/internal/auth"
+	"minitower/internal/testutil"
+)
+
+func TestRunStatusTokenReadsOnlyItsRun(t *testing.T) {
+	handler, s, dbConn, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-run-status")
+	app := testutil.CreateApp(t, s, team.ID, "status-app")
+	testutil.CreateVersion(t, s, app.ID)
+
+	createRun := func(body map[string]any) (int64, string) {
+		t.Helper()
+		var created struct {
+			RunID       int64  `json:"run_id"`
+			StatusToken string `json:"status_token"`
+		}
+		resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/status-app/runs", token, "", body)
+		decodeStatus(t, resp, http.StatusCreated, &created)
+		return created.RunID, created.StatusToken
+	}
+
+	plainRunID, plainToken := createRun(map[string]any{})
+	if plainToken != "" {
+		t.Fatalf("expected no status token unless requested, got %q", plainToken)
+	}
+	runID, statusToken := createRun(map[string]any{"with_status_token": true, "input": map[string]any{"secret": "hunter2"}})
+	otherRunID, otherToken := createRun(map[string]any{"with_status_token": true})
+	if statusToken == "" || otherToken == "" || statusToken == otherToken {
+		t.Fatalf("expected distinct status tokens, got %q and %q", statusToken, otherToken)
+	}
+
+	// Only the hash is stored.
+	var storedHash string
+	if err := dbConn.QueryRow(`SELECT token_hash FROM run_status_tokens WHERE run_id = ?`, runID).Scan(&storedHash); err != nil {
+		t.Fatalf("read stored token: %v", err)
+	}
+	if storedHash != auth.HashToken(statusToken) {
+		t.Fatalf("expected the token's sha256 at rest, got %q", storedHash)
+	}
+
+	runner, _ := testutil.CreateRunner(t, s, "runner-run-status", "default")
+	mustExecHTTP(t, dbConn, `INSERT INTO run_attempts (run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, exit_code, created_at, updated_at)
+     VALUES (?, 1, ?, 'lease', 0, 'failed', 3, 0, 0)`, runID, runner.ID)
+	finishedAt := time.Now().Add(-time.Hour)
+	mustExecHTTP(t, dbConn, `UPDATE runs SET status = 'failed', finished_at = ? WHERE id = ?`, finishedAt.UnixMilli(), runID)
+	mustExecHTTP(t, dbConn, `INSERT INTO run_logs (run_attempt_id, seq, stream, line, logged_at) SELECT id, 1, 'stdout', 'hunter2', 0 FROM run_attempts WHERE run_id = ?`, runID)
+
+	getStatus := func(statusToken string) (*http.Response, map[string]any) {
+		t.Helper()
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/run-status/"+statusToken, "", "", nil)
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil
+		}
+		defer resp.Body.Close()
+		var body map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode run status: %v", err)
+		}
+		return resp, body
+	}
+
+	_, body := getStatus(statusToken)
+	if len(body) != 3 || body["status"] != "failed" || body["exit_code"] != float64(3) || body["finished_at"] == nil {
+		t.Fatalf("expected only status, exit_code and finished_at, got %v", body)
+	}
+
+	_, body = getStatus(otherToken)
+	if len(body) != 3 || body["status"] != "queued" || body["exit_code"] != nil || body["finished_at"] != nil {
+		t.Fatalf("expected the other token to read only its queued run, got %v", body)
+	}
+
+	for label, path := range map[string]string{
+		"unknown token":  "/api/v1/run-status/" + auth.PrefixStatusToken + "unknown",
+		"api token":      "/api/v1/run-status/" + token,
+		"run id":         "/api/v1/run-status/" + itoa(plainRunID),
+		"nested path":    "/api/v1/run-status/" + statusToken + "/logs",
+		"other run path": "/api/v1/run-status/" + otherToken + "/" + itoa(otherRunID),
+	} {
+		resp := doRequest(t, handler, http.MethodGet, path, "", "", nil)
+		assertErrorCode(t, label, resp, http.StatusNotFound, "not_found")
+	}
+
+	// The token works until its run has been terminal for 7 days.
+	mustExecHTTP(t, dbConn, `UPDATE runs SET finished_at = ? WHERE id = ?`, time.Now().Add(-6*24*time.Hour).UnixMilli(), runID)
+	if resp, _ := getStatus(statusToken); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the token to work 6 days after the run finished, got %d", resp.StatusCode)
+	}
+	mustExecHTTP(t, dbConn, `UPDATE runs SET finished_at = ? WHERE id = ?`, time.Now().Add(-8*24*time.Hour).UnixMilli(), runID)
+	resp, _ := getStatus(statusToken)
+	assertErrorCode(t, "expired token", resp, http.StatusNotFound, "not_found")
+}
@@ -0,0 +1,86 @@
+package httpapi_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"minitower/internal/testutil"
+)
+
+func TestRunsRunnerFilterAndAdminRunList(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	teamA, adminToken := testutil.CreateTeam(t, s, "team-runner-filter-a")
+	teamB, memberToken := testutil.CreateTeamWithRole(t, s, "team-runner-filter-b", "member")
+	runner, _ := testutil.CreateRunner(t, s, "gpu-03", "default")
+	// Each team gets a run handled by gpu-03 and, once both are leased, a
+	// queued one.
+	var queue []func()
+	for _, team := range []struct {
+		id   int64
+		slug string
+	}{{teamA.ID, "app-a"}, {teamB.ID, "app-b"}} {
+		env, err := s.GetOrCreateDefaultEnvironment(context.Background(), team.id)
+		if err != nil {
+			t.Fatalf("get env: %v", err)
+		}
+		app := testutil.CreateApp(t, s, team.id, team.slug)
+		version := testutil.CreateVersion(t, s, app.ID)
+		testutil.CreateRun(t, s, team.id, app.ID, env.ID, version.ID, 0, 0)
+		_, attempt, _, leaseHash := testutil.LeaseRun(t, s, runner)
+		if err := s.CompleteAttempt(context.Background(), attempt.ID, leaseHash, "failed", nil, nil, ""); err != nil {
+			t.Fatalf("finish attempt: %v", err)
+		}
+		queue = append(queue, func() { testutil.CreateRun(t, s, team.id, app.ID, env.ID, version.ID, 0, 0) })
+	}
+	for _, create := range queue {
+		create()
+	}
+
+	type runList struct {
+		Runs []struct {
+			AppSlug    string `json:"app_slug"`
+			RunnerName string `json:"runner_name"`
+			TeamSlug   string `json:"team_slug"`
+		} `json:"runs"`
+	}
+
+	// Member tokens stay within their team.
+	var teamRuns runList
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/runs?runner=gpu-03&include=runner&since=2020-01-01", memberToken, "", nil)
+	decodeStatus(t, resp, http.StatusOK, &teamRuns)
+	if len(teamRuns.Runs) != 1 || teamRuns.Runs[0].AppSlug != "app-b" || teamRuns.Runs[0].RunnerName != "gpu-03" || teamRuns.Runs[0].TeamSlug != "" {
+		t.Fatalf("expected team B's run on gpu-03, got %+v", teamRuns.Runs)
+	}
+
+	var plain runList
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/runs", memberToken, "", nil)
+	decodeStatus(t, resp, http.StatusOK, &plain)
+	if len(plain.Runs) != 2 || plain.Runs[0].RunnerName != "" || plain.Runs[1].RunnerName != "" {
+		t.Fatalf("expected runner names only with include=runner, got %+v", plain.Runs)
+	}
+
+	var adminRuns runList
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/admin/runs?runner=gpu-03&include=runner", adminToken, "", nil)
+	decodeStatus(t, resp, http.StatusOK, &adminRuns)
+	if len(adminRuns.Runs) != 2 {
+		t.Fatalf("expected gpu-03's runs of both teams, got %+v", adminRuns.Runs)
+	}
+	for _, run := range adminRuns.Runs {
+		if run.RunnerName != "gpu-03" || run.TeamSlug == "" {
+			t.Fatalf("expected runner and team on admin rows, got %+v", run)
+		}
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/admin/runs", memberToken, "", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for a member on the admin run list, got %d", resp.StatusCode)
+	}
+	for _, path := range []string{"/api/v1/runs?since=1h", "/api/v1/runs?include=stats", "/api/v1/admin/runs?since=yesterday"} {
+		resp := doRequest(t, handler, http.MethodGet, path, adminToken, "", nil)
+		assertErrorCode(t, path, resp, http.StatusBadRequest, "invalid_request")
+	}
+}
@@ -5,15 +5,25 @@ import (
 	"database/sql"
 	"log/slog"
 	"net/http"
+	"net/http/pprof"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"minitower/internal/apierror"
+	"minitower/internal/backup"
 	"minitower/internal/config"
 	"minitower/internal/httpapi/handlers"
+	"minitower/internal/httputil"
 	"minitower/internal/objects"
+	"minitower/internal/queue"
 )
 
+// maxLeaseWaiters caps lease requests held open waiting for work; each holds
+// a connection, so past the cap lease requests answer immediately.
+const maxLeaseWaiters = 256
+
 type Server struct {
 	cfg      config.Config
 	db       *sql.DB
@@ -23,7 +33,20 @@ type Server struct {
 	handlers *handlers.Handlers
 	logger   *slog.Logger
 	metrics  *Metrics
+	backups  *backup.Manager
+	queue    *queue.Notifier
 	promReg  prometheus.Registerer
+	draining atomic.Bool
+
+	// ctx lives as long as the server and bounds the background jobs
+	// requests start; BeginShutdown cancels it.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// patterns are the routes registered on mux, and openAPI the document
+	// describing them.
+	patterns []string
+	openAPI  []byte
 }
 
 // ServerOption configures a Server.
@@ -40,13 +63,15 @@ func New(cfg config.Config, db *sql.DB, objects *objects.LocalStore, logger *slo
 	if logger == nil {
 		logger = slog.Default()
 	}
+	logger = slog.New(httputil.NewContextHandler(logger.Handler()))
 
 	s := &Server{
-		cfg:    cfg,
-		db:     db,
-		mux:    http.NewServeMux(),
-		auth:   NewAuth(cfg, db),
-		logger: logger,
+		cfg:     cfg,
+		db:      db,
+		mux:     http.NewServeMux(),
+		auth:    NewAuth(cfg, db),
+		backups: backup.NewManager(db, cfg.BackupDir, cfg.BackupRetain),
+		logger:  logger,
 	}
 
 	// Apply options (may set promReg)
@@ -62,11 +87,15 @@ func New(cfg config.Config, db *sql.DB, objects *objects.LocalStore, logger *slo
 	}
 
 	// Create handlers with metrics
-	s.handlers = handlers.New(cfg, db, objects, logger, s.metrics)
+	s.queue = queue.NewNotifier(maxLeaseWaiters)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.handlers = handlers.New(s.ctx, cfg, db, objects, s.backups, logger, s.metrics, s.queue)
 
 	s.routes()
+	s.openAPI = s.buildOpenAPI()
 	s.handler = Chain(
 		s.mux,
+		RequestIDMiddleware(),
 		CORSMiddleware(cfg.CORSOrigins),
 		Recoverer(logger),
 		s.metrics.Middleware(),
@@ -79,48 +108,123 @@ func (s *Server) Handler() http.Handler {
 	return s.handler
 }
 
+// OpsHandler returns the handler for the ops listener: metrics, health and
+// readiness without auth, plus pprof when enabled. It must only be bound to
+// an address that is not reachable by API clients.
+func (s *Server) OpsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.Handler())
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	if s.cfg.EnablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return Chain(mux, Recoverer(s.logger))
+}
+
 // Metrics returns the server's Metrics instance.
 func (s *Server) Metrics() *Metrics {
 	return s.metrics
 }
 
+// Backups returns the backup manager shared by the admin endpoint and the
+// periodic scheduler, so both honour the single-backup-at-a-time rule.
+func (s *Server) Backups() *backup.Manager {
+	return s.backups
+}
+
+// BeginShutdown makes the readiness endpoints report 503 so load balancers
+// stop routing new traffic while in-flight requests drain.
+func (s *Server) BeginShutdown() {
+	s.draining.Store(true)
+	// Release held lease requests so they do not hold up the drain.
+	s.queue.Close()
+	// Stop background jobs, such as object verifications, at their next
+	// database call.
+	s.cancel()
+}
+
+// WaitJobs waits for the background jobs stopped by BeginShutdown to
+// return, so none touches the database after it is closed.
+func (s *Server) WaitJobs(ctx context.Context) error {
+	return s.handlers.WaitJobs(ctx)
+}
+
+// Queue returns the notifier that wakes waiting lease requests, for callers
+// outside the API that re-queue runs.
+func (s *Server) Queue() *queue.Notifier {
+	return s.queue
+}
+
 func (s *Server) routes() {
 	// Health checks (no auth)
-	s.mux.HandleFunc("/health", s.handleHealth)
-	s.mux.HandleFunc("/ready", s.handleReady)
-
-	// Metrics (no auth)
-	s.mux.Handle("/metrics", s.metrics.Handler())
+	s.handleFunc("/health", s.handleHealth)
+	s.handleFunc("/ready", s.handleReady)
+	s.handleFunc("/readyz", s.handleReady)
+
+	// Metrics move to the ops listener when one is configured. Otherwise they
+	// are served here only behind the metrics token, since label values carry
+	// team and app slugs.
+	if s.cfg.OpsListenAddr == "" && strings.TrimSpace(s.cfg.MetricsToken) != "" {
+		s.handle("/metrics", s.auth.RequireMetrics(s.metrics.Handler()))
+	}
 
 	// Public auth options
-	s.mux.HandleFunc("/api/v1/auth/options", s.handlers.GetAuthOptions)
+	s.handleFunc("/api/v1/auth/options", s.handlers.GetAuthOptions)
+
+	// Public error code catalog and limits
+	s.handleFunc("/api/v1/meta/errors", s.handlers.ListErrorCodes)
+	s.handleFunc("/api/v1/meta/limits", s.handlers.GetLimits)
+
+	// Public OpenAPI document
+	s.handleFunc(openAPIPath, s.handleOpenAPI)
 
 	// Bootstrap (bootstrap token auth) - enabled only when token is configured.
 	if strings.TrimSpace(s.cfg.BootstrapToken) != "" {
-		s.mux.Handle("/api/v1/bootstrap/team", s.auth.RequireBootstrap(http.HandlerFunc(s.handlers.BootstrapTeam)))
+		s.handle("/api/v1/bootstrap/team", s.auth.RequireBootstrap(http.HandlerFunc(s.handlers.BootstrapTeam)))
 	}
 
+	// Run status (gated by the per-run status token in the path)
+	s.handleFunc("/api/v1/run-status/", s.handlers.GetRunStatus)
+
 	// Team auth endpoints (no auth)
-	s.mux.HandleFunc("/api/v1/teams/signup", s.handlers.SignupTeam)
-	s.mux.HandleFunc("/api/v1/teams/login", s.handlers.LoginTeam)
+	s.handleFunc("/api/v1/teams/signup", s.handlers.SignupTeam)
+	s.handleFunc("/api/v1/teams/login", s.handlers.LoginTeam)
 
 	// Runner registration (platform runner registration token auth)
-	s.mux.Handle("/api/v1/runners/register", s.auth.RequireRunnerRegistration(http.HandlerFunc(s.handlers.RegisterRunner)))
+	s.handle("/api/v1/runners/register", s.auth.RequireRunnerRegistration(http.HandlerFunc(s.handlers.RegisterRunner)))
 
 	// Runner lease (runner token auth)
-	s.mux.Handle("/api/v1/runs/lease", s.auth.RequireRunner(http.HandlerFunc(s.handlers.LeaseRun)))
+	s.handle("/api/v1/runs/lease", s.auth.RequireRunner(http.HandlerFunc(s.handlers.LeaseRun)))
 
 	// Team API (team token auth)
-	s.mux.Handle("/api/v1/me", s.auth.RequireTeam(http.HandlerFunc(s.handlers.GetMe)))
-	s.mux.Handle("/api/v1/tokens", s.auth.RequireTeam(http.HandlerFunc(s.handlers.CreateToken)))
-	s.mux.Handle("/api/v1/apps", s.auth.RequireTeam(http.HandlerFunc(s.routeApps)))
-	s.mux.Handle("/api/v1/apps/", s.auth.RequireTeam(http.HandlerFunc(s.routeAppsWithSlug)))
-	s.mux.Handle("/api/v1/runs/summary", s.auth.RequireTeam(http.HandlerFunc(s.handlers.GetRunsSummary)))
-	s.mux.Handle("/api/v1/runs", s.auth.RequireTeam(http.HandlerFunc(s.handlers.ListRunsByTeam)))
-	s.mux.Handle("/api/v1/admin/runners", s.auth.RequireAdmin(http.HandlerFunc(s.handlers.ListRunners)))
+	s.handle("/api/v1/me", s.auth.RequireTeam(http.HandlerFunc(s.handlers.GetMe)))
+	s.handle("/api/v1/tokens", s.auth.RequireTeam(http.HandlerFunc(s.handlers.CreateToken)))
+	s.handle("/api/v1/apps", s.auth.RequireTeam(http.HandlerFunc(s.routeApps)))
+	s.handle("/api/v1/apps/", s.auth.RequireTeam(http.HandlerFunc(s.routeAppsWithSlug)))
+	s.handle("/api/v1/environments", s.auth.RequireTeam(http.HandlerFunc(s.routeEnvironments)))
+	s.handle("/api/v1/environments/", s.auth.RequireTeam(http.HandlerFunc(s.handlers.DeleteEnvironment)))
+	s.handle("/api/v1/runs/summary", s.auth.RequireTeam(http.HandlerFunc(s.handlers.GetRunsSummary)))
+	s.handle("/api/v1/runs/cancel", s.auth.RequireTeam(http.HandlerFunc(s.handlers.CancelRunsBulk)))
+	s.handle("/api/v1/runs", s.auth.RequireTeam(http.HandlerFunc(s.handlers.ListRunsByTeam)))
+	s.handle("/api/v1/batches/", s.auth.RequireTeam(http.HandlerFunc(s.routeBatches)))
+	s.handle("/api/v1/reports/usage", s.auth.RequireTeam(http.HandlerFunc(s.handlers.GetUsageReport)))
+	s.handle("/api/v1/audit", s.auth.RequireTeam(http.HandlerFunc(s.handlers.GetAuditLog)))
+	s.handle("/api/v1/admin/runners", s.auth.RequireAdmin(http.HandlerFunc(s.routeAdminRunners)))
+	s.handle("/api/v1/admin/runners/", s.auth.RequireAdmin(http.HandlerFunc(s.handlers.RotateRunnerToken)))
+	s.handle("/api/v1/admin/runs", s.auth.RequireAdmin(http.HandlerFunc(s.handlers.ListAdminRuns)))
+	s.handle("/api/v1/admin/overview", s.auth.RequireAdmin(http.HandlerFunc(s.handlers.GetAdminOverview)))
+	s.handle("/api/v1/admin/teams/", s.auth.RequireAdmin(http.HandlerFunc(s.handlers.TeamSettings)))
+	s.handle("/api/v1/admin/backup", s.auth.RequireAdmin(http.HandlerFunc(s.handlers.CreateBackup)))
+	s.handle("/api/v1/admin/verify-objects", s.auth.RequireAdmin(http.HandlerFunc(s.handlers.StartObjectVerification)))
+	s.handle("/api/v1/admin/verify-objects/", s.auth.RequireAdmin(http.HandlerFunc(s.handlers.GetObjectVerification)))
 
 	// Runs - mixed auth depending on method/path
-	s.mux.HandleFunc("/api/v1/runs/", s.routeRunsMixed)
+	s.handleFunc("/api/v1/runs/", s.routeRunsMixed)
 }
 
 func (s *Server) routeApps(w http.ResponseWriter, r *http.Request) {
@@ -134,6 +238,28 @@ func (s *Server) routeApps(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) routeAdminRunners(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handlers.ListRunners(w, r)
+	case http.MethodPost:
+		s.handlers.CreateRunner(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) routeEnvironments(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handlers.ListEnvironments(w, r)
+	case http.MethodPost:
+		s.handlers.CreateEnvironment(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func (s *Server) routeAppsWithSlug(w http.ResponseWriter, r *http.Request) {
 	const prefix = "/api/v1/apps/"
 	rest := strings.TrimPrefix(r.URL.Path, prefix)
@@ -161,12 +287,76 @@ func (s *Server) routeAppsWithSlug(w http.ResponseWriter, r *http.Request) {
 			default:
 				w.WriteHeader(http.StatusMethodNotAllowed)
 			}
+		case "errors":
+			s.handlers.GetAppErrors(w, r)
+		case "schema":
+			s.handlers.GetAppSchema(w, r)
 		default:
 			http.NotFound(w, r)
 		}
+	case 3:
+		// /api/v1/apps/{app}/versions/{version_no}
+		if segs[1] == "versions" {
+			s.handlers.GetVersion(w, r)
+			return
+		}
+		// /api/v1/apps/{app}/runs/batch
+		if segs[1] != "runs" || segs[2] != "batch" {
+			http.NotFound(w, r)
+			return
+		}
+		s.handlers.CreateRunBatch(w, r)
+	case 4, 5:
+		// /api/v1/apps/{app}/versions/{version_no}/labels
+		if len(segs) == 4 && segs[1] == "versions" && segs[3] == "labels" {
+			s.handlers.SetVersionLabel(w, r)
+			return
+		}
+		// /api/v1/apps/{app}/versions/{version_no}/promote
+		if len(segs) == 4 && segs[1] == "versions" && segs[3] == "promote" {
+			s.handlers.PromoteVersion(w, r)
+			return
+		}
+		// /api/v1/apps/{app}/versions/{version_no}/stats
+		if len(segs) == 4 && segs[1] == "versions" && segs[3] == "stats" {
+			s.handlers.GetVersionStats(w, r)
+			return
+		}
+		// /api/v1/apps/{app}/versions/{version_no}/files[/content]
+		if segs[1] != "versions" || segs[3] != "files" || (len(segs) == 5 && segs[4] != "content") {
+			http.NotFound(w, r)
+			return
+		}
+		if len(segs) == 5 {
+			s.handlers.GetVersionFileContent(w, r)
+			return
+		}
+		s.handlers.GetVersionFiles(w, r)
 	case 1:
 		// /api/v1/apps/{app}
-		s.handlers.GetApp(w, r)
+		switch r.Method {
+		case http.MethodGet:
+			s.handlers.GetApp(w, r)
+		case http.MethodPatch:
+			s.handlers.UpdateApp(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// routeBatches handles /api/v1/batches/{id} and /api/v1/batches/{id}/cancel.
+func (s *Server) routeBatches(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/batches/")
+	segs := strings.Split(strings.TrimSuffix(rest, "/"), "/")
+
+	switch {
+	case len(segs) == 1 && segs[0] != "":
+		s.handlers.GetBatch(w, r)
+	case len(segs) == 2 && segs[1] == "cancel":
+		s.handlers.CancelBatch(w, r)
 	default:
 		http.NotFound(w, r)
 	}
@@ -184,13 +374,35 @@ func runPathSegments(path string) []string {
 }
 
 // routeRunsMixed handles /api/v1/runs/* with mixed auth based on method and path.
-// Team auth: GET /runs/{run}, GET /runs/{run}/logs
-// Runner auth: POST /runs/{run}/start, POST /runs/{run}/heartbeat, POST /runs/{run}/logs, POST /runs/{run}/result, GET /runs/{run}/artifact
+// Team auth: GET /runs/{run}, GET /runs/{run}/logs, GET /runs/{run}/events, POST /runs/{run}/cancel,
+// POST /runs/{run}/priority, POST /runs/{run}/rerun, GET /runs/{run}/outputs, GET /runs/{run}/outputs/{name}
+// Runner auth: POST /runs/{run}/start, POST /runs/{run}/heartbeat, POST /runs/{run}/logs,
+// POST /runs/{run}/logs/stream, POST /runs/{run}/result, GET /runs/{run}/artifact, POST /runs/{run}/outputs
 func (s *Server) routeRunsMixed(w http.ResponseWriter, r *http.Request) {
 	segs := runPathSegments(r.URL.Path)
 
-	// Expect exactly /runs/{id} (1 segment) or /runs/{id}/{action} (2 segments).
+	// Expect /runs/{id} (1 segment), /runs/{id}/{action} (2 segments),
+	// /runs/{id}/outputs/{name} or /runs/{id}/logs/stream (3 segments).
 	switch len(segs) {
+	case 3:
+		if segs[1] == "logs" && segs[2] == "stream" {
+			if r.Method == http.MethodPost {
+				s.auth.RequireRunner(http.HandlerFunc(s.handlers.StreamLogs)).ServeHTTP(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if segs[1] != "outputs" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodGet {
+			s.auth.RequireTeam(http.HandlerFunc(s.handlers.DownloadRunOutput)).ServeHTTP(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
 	case 2:
 		// /runs/{id}/{action}
 		switch segs[1] {
@@ -223,11 +435,40 @@ func (s *Server) routeRunsMixed(w http.ResponseWriter, r *http.Request) {
 				s.auth.RequireRunner(http.HandlerFunc(s.handlers.GetArtifact)).ServeHTTP(w, r)
 				return
 			}
+		case "outputs":
+			if r.Method == http.MethodPost {
+				s.auth.RequireRunner(http.HandlerFunc(s.handlers.UploadRunOutput)).ServeHTTP(w, r)
+				return
+			}
+			if r.Method == http.MethodGet {
+				s.auth.RequireTeam(http.HandlerFunc(s.handlers.ListRunOutputs)).ServeHTTP(w, r)
+				return
+			}
+		case "events":
+			if r.Method == http.MethodGet {
+				s.auth.RequireTeam(http.HandlerFunc(s.handlers.GetRunEvents)).ServeHTTP(w, r)
+				return
+			}
+		case "attempts":
+			if r.Method == http.MethodGet {
+				s.auth.RequireTeam(http.HandlerFunc(s.handlers.ListRunAttempts)).ServeHTTP(w, r)
+				return
+			}
 		case "cancel":
 			if r.Method == http.MethodPost {
 				s.auth.RequireTeam(http.HandlerFunc(s.handlers.CancelRun)).ServeHTTP(w, r)
 				return
 			}
+		case "priority":
+			if r.Method == http.MethodPost {
+				s.auth.RequireTeam(http.HandlerFunc(s.handlers.SetRunPriority)).ServeHTTP(w, r)
+				return
+			}
+		case "rerun":
+			if r.Method == http.MethodPost {
+				s.auth.RequireTeam(http.HandlerFunc(s.handlers.RerunRun)).ServeHTTP(w, r)
+				return
+			}
 		default:
 			http.NotFound(w, r)
 			return
@@ -253,7 +494,7 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	writeJSON(w, http.StatusOK, statusResponse{Status: "ok"})
 }
 
 func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
@@ -262,13 +503,18 @@ func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.draining.Load() {
+		writeAPIError(w, apierror.Unavailable, "shutting down")
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
 	defer cancel()
 
 	if err := s.db.PingContext(ctx); err != nil {
-		writeError(w, http.StatusServiceUnavailable, "internal", "db not ready")
+		writeAPIError(w, apierror.Unavailable, "db not ready")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	writeJSON(w, http.StatusOK, statusResponse{Status: "ok"})
 }
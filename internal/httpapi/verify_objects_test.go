@@ -0,0 +1,105 @@
+package httpapi_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"minitower/internal/testutil"
+)
+
+type verifyObjectsJob struct {
+	JobID    string `json:"job_id"`
+	Status   string `json:"status"`
+	Checked  int    `json:"checked"`
+	Marked   int    `json:"marked"`
+	Problems []struct {
+		App       string `json:"app"`
+		VersionNo int64  `json:"version_no"`
+		Kind      string `json:"kind"`
+	} `json:"problems"`
+}
+
+func TestVerifyObjectsFlagsCorruptVersionAndBlocksRuns(t *testing.T) {
+	objStore := newFixtureObjects(t)
+	handler, s, _, cleanup := newTestServerWithObjects(t, objStore)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-verify-objects")
+	_, memberToken := testutil.CreateTeamWithRole(t, s, "team-verify-member", "member")
+	app := testutil.CreateApp(t, s, team.ID, "verify-app")
+
+	towerfileTOML := "[app]\nname = \"verify-app\"\nscript = \"main.sh\"\n"
+	for i := 0; i < 2; i++ {
+		resp := uploadTowerfileVersion(t, handler, token, "verify-app", towerfileTOML)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("upload version: expected 201, got %d", resp.StatusCode)
+		}
+	}
+	v1, err := s.GetVersionByNumber(context.Background(), app.ID, 1)
+	if err != nil || v1 == nil {
+		t.Fatalf("get version 1: %v", err)
+	}
+	// Truncate the first version's artifact, as a failing disk would.
+	if err := objStore.Store(v1.ArtifactObjectKey, strings.NewReader("trunc")); err != nil {
+		t.Fatalf("corrupt artifact: %v", err)
+	}
+
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/admin/verify-objects", memberToken, "", map[string]any{"mark_corrupt": true})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for a member token, got %d", resp.StatusCode)
+	}
+
+	var job verifyObjectsJob
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/admin/verify-objects", token, "", map[string]any{"mark_corrupt": true})
+	decodeStatus(t, resp, http.StatusAccepted, &job)
+	if job.JobID == "" {
+		t.Fatal("expected a job id")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for job.Status == "running" {
+		if time.Now().After(deadline) {
+			t.Fatal("verification did not finish")
+		}
+		time.Sleep(20 * time.Millisecond)
+		resp = doRequest(t, handler, http.MethodGet, "/api/v1/admin/verify-objects/"+job.JobID, token, "", nil)
+		decodeStatus(t, resp, http.StatusOK, &job)
+	}
+	if job.Status != "completed" || job.Checked != 2 || job.Marked != 1 {
+		t.Fatalf("expected a completed job that checked 2 versions and marked 1, got %+v", job)
+	}
+	if len(job.Problems) != 1 || job.Problems[0].VersionNo != 1 || job.Problems[0].Kind != "sha256_mismatch" {
+		t.Fatalf("expected a sha256 mismatch for version 1, got %+v", job.Problems)
+	}
+
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/admin/verify-objects/no-such-job", token, "", nil)
+	assertErrorCode(t, "unknown job", resp, http.StatusNotFound, "not_found")
+
+	var versions struct {
+		Versions []struct {
+			VersionNo       int64 `json:"version_no"`
+			ArtifactCorrupt bool  `json:"artifact_corrupt"`
+		} `json:"versions"`
+	}
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/verify-app/versions", token, "", nil)
+	decodeStatus(t, resp, http.StatusOK, &versions)
+	for _, v := range versions.Versions {
+		if v.ArtifactCorrupt != (v.VersionNo == 1) {
+			t.Fatalf("expected only version 1 to report artifact_corrupt, got %+v", versions.Versions)
+		}
+	}
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/verify-app/runs", token, "", map[string]any{"version_no": 1})
+	assertErrorCode(t, "run of corrupt version", resp, http.StatusConflict, "artifact_corrupt")
+
+	resp = doRequest(t, handler, http.MethodPost, "/api/v1/apps/verify-app/runs", token, "", map[string]any{})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected a run of the intact latest version to be created, got %d", resp.StatusCode)
+	}
+}
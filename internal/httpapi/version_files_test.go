@@ -0,0 +1,274 @@
+package httpapi_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"minitower/internal/objects"
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+type artifactEntry struct {
+	name  string
+	dir   bool
+	body  []byte
+	mode  int64
+	links string
+}
+
+func TestVersionFilesListsArtifactEntries(t *testing.T) {
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	handler, s, _, cleanup := newTestServerWithObjects(t, objStore)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-files")
+	app := testutil.CreateApp(t, s, team.ID, "files-app")
+	storeArtifactVersion(t, s, objStore, app.ID, "objects/files.tar.gz", craftedArtifactEntries())
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/files-app/versions/1/files", token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		VersionNo int64 `json:"version_no"`
+		Files     []struct {
+			Path  string `json:"path"`
+			Size  int64  `json:"size"`
+			Mode  string `json:"mode"`
+			IsDir bool   `json:"is_dir"`
+		} `json:"files"`
+		Truncated bool `json:"truncated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if payload.VersionNo != 1 || payload.Truncated {
+		t.Fatalf("unexpected payload header: version_no=%d truncated=%v", payload.VersionNo, payload.Truncated)
+	}
+
+	got := make(map[string]string)
+	for _, f := range payload.Files {
+		got[f.Path] = fmt.Sprintf("%s:%d:%v", f.Mode, f.Size, f.IsDir)
+	}
+	want := map[string]string{
+		"Towerfile":            "0644:33:false",
+		"src":                  "0755:0:true",
+		"src/pkg":              "0755:0:true",
+		"src/pkg/main.py":      "0755:21:false",
+		"data/large.txt":       fmt.Sprintf("0644:%d:false", (1<<20)+1),
+		"data/blob.bin":        "0644:4:false",
+		"data/latin1.txt":      "0644:4:false",
+		"src/pkg/link-to-main": "0777:0:false",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d entries, got %d: %v", len(want), len(got), got)
+	}
+	for path, desc := range want {
+		if got[path] != desc {
+			t.Fatalf("entry %s: expected %s, got %q", path, desc, got[path])
+		}
+	}
+
+	// Artifacts are immutable, so a second listing is served from the cache
+	// even after the underlying object disappears.
+	if err := objStore.Delete("objects/files.tar.gz"); err != nil {
+		t.Fatalf("delete object: %v", err)
+	}
+	cached := doRequest(t, handler, http.MethodGet, "/api/v1/apps/files-app/versions/1/files", token, "", nil)
+	defer cached.Body.Close()
+	if cached.StatusCode != http.StatusOK {
+		t.Fatalf("expected cached 200, got %d", cached.StatusCode)
+	}
+}
+
+func TestVersionFileContent(t *testing.T) {
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	handler, s, _, cleanup := newTestServerWithObjects(t, objStore)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-content")
+	app := testutil.CreateApp(t, s, team.ID, "content-app")
+	storeArtifactVersion(t, s, objStore, app.ID, "objects/content.tar.gz", craftedArtifactEntries())
+
+	contentPath := func(p string) string {
+		return "/api/v1/apps/content-app/versions/1/files/content?path=" + url.QueryEscape(p)
+	}
+
+	resp := doRequest(t, handler, http.MethodGet, contentPath("./src/pkg/main.py"), token, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("expected text/plain, got %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "print('hello nested')" {
+		t.Fatalf("unexpected content %q", body)
+	}
+
+	cases := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"data/large.txt", http.StatusRequestEntityTooLarge, "file_too_large"},
+		{"data/blob.bin", http.StatusUnsupportedMediaType, "binary_file"},
+		{"data/latin1.txt", http.StatusUnsupportedMediaType, "binary_file"},
+		{"src/pkg", http.StatusBadRequest, "invalid_request"},
+		{"src/pkg/link-to-main", http.StatusBadRequest, "invalid_request"},
+		{"missing.py", http.StatusNotFound, "not_found"},
+		{"", http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tc := range cases {
+		resp := doRequest(t, handler, http.MethodGet, contentPath(tc.path), token, "", nil)
+		assertErrorCode(t, tc.path, resp, tc.status, tc.code)
+	}
+}
+
+func TestVersionFilesTeamScopedAndCapped(t *testing.T) {
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+	handler, s, _, cleanup := newTestServerWithObjects(t, objStore)
+	defer cleanup()
+
+	team, ownerToken := testutil.CreateTeam(t, s, "team-owner")
+	_, otherToken := testutil.CreateTeam(t, s, "team-other")
+	app := testutil.CreateApp(t, s, team.ID, "big-app")
+
+	entries := make([]artifactEntry, 0, 10001)
+	for i := 0; i < 10001; i++ {
+		entries = append(entries, artifactEntry{name: fmt.Sprintf("gen/f%05d.txt", i), body: []byte("x")})
+	}
+	storeArtifactVersion(t, s, objStore, app.ID, "objects/big.tar.gz", entries)
+
+	for _, path := range []string{
+		"/api/v1/apps/big-app/versions/1/files",
+		"/api/v1/apps/big-app/versions/1/files/content?path=gen/f00000.txt",
+	} {
+		resp := doRequest(t, handler, http.MethodGet, path, otherToken, "", nil)
+		assertErrorCode(t, path, resp, http.StatusNotFound, "not_found")
+	}
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/big-app/versions/1/files", ownerToken, "", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var payload struct {
+		Files     []json.RawMessage `json:"files"`
+		Truncated bool              `json:"truncated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(payload.Files) != 10000 || !payload.Truncated {
+		t.Fatalf("expected 10000 truncated entries, got %d truncated=%v", len(payload.Files), payload.Truncated)
+	}
+}
+
+// craftedArtifactEntries returns a small tree with nested directories, a file
+// just over the content cap, binary files and a symlink.
+func craftedArtifactEntries() []artifactEntry {
+	return []artifactEntry{
+		{name: "Towerfile", body: []byte("[app]\nname = \"files\"\nscript = \"x\"")},
+		{name: "./src/", dir: true},
+		{name: "./src/pkg/", dir: true},
+		{name: "./src/pkg/main.py", body: []byte("print('hello nested')"), mode: 0o755},
+		{name: "data/large.txt", body: bytes.Repeat([]byte("a"), (1<<20)+1)},
+		{name: "data/blob.bin", body: []byte{0x7f, 'E', 0x00, 'F'}},
+		{name: "data/latin1.txt", body: []byte{'c', 'a', 'f', 0xe9}},
+		{name: "src/pkg/link-to-main", links: "main.py"},
+	}
+}
+
+func storeArtifactVersion(t *testing.T, s *store.Store, objStore *objects.LocalStore, appID int64, key string, entries []artifactEntry) {
+	t.Helper()
+
+	if err := objStore.Store(key, bytes.NewReader(buildArtifact(t, entries))); err != nil {
+		t.Fatalf("store artifact: %v", err)
+	}
+	if _, err := s.CreateVersion(context.Background(), appID, key, "sha256", 0, "src/pkg/main.py", nil, nil, nil, nil, nil, 1, false, nil, nil, nil); err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+}
+
+func buildArtifact(t *testing.T, entries []artifactEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: e.mode}
+		switch {
+		case e.dir:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0o755
+		case e.links != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.links
+			hdr.Mode = 0o777
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(e.body))
+			if hdr.Mode == 0 {
+				hdr.Mode = 0o644
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("write header %s: %v", e.name, err)
+		}
+		if len(e.body) > 0 {
+			if _, err := tw.Write(e.body); err != nil {
+				t.Fatalf("write body %s: %v", e.name, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func assertErrorCode(t *testing.T, label string, resp *http.Response, status int, code string) {
+	t.Helper()
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("%s: expected %d, got %d", label, status, resp.StatusCode)
+	}
+	var payload struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	if payload.Error.Code != code {
+		t.Fatalf("%s: expected code %q, got %q", label, code, payload.Error.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"minitower/internal/testutil"
+	"minitower/internal/towerfile"
+)
+
+const schemaTowerfileV1 = `schema_version = 7
+
+[app]
+name = "form-app"
+script = "main.sh"
+timeout = { seconds = 90 }
+
+[app.default_input]
+region = "eu"
+
+[[parameters]]
+name = "region"
+description = "Region to <sync> & report"
+default = "eu"
+
+[[parameters]]
+name = "limits"
+type = "object"
+properties = { max = { type = "integer", minimum = 1 }, dry_run = { type = "boolean" } }
+`
+
+const schemaTowerfileV2 = `schema_version = 7
+
+[app]
+name = "form-app"
+script = "main.sh"
+
+[[parameters]]
+name = "tags"
+type = "array"
+items_type = "string"
+`
+
+// deployedParamsSchema returns the params schema bytes a deploy of
+// towerfileTOML stores.
+func deployedParamsSchema(t *testing.T, towerfileTOML string) []byte {
+	t.Helper()
+	tf, err := towerfile.Parse(strings.NewReader(towerfileTOML))
+	if err != nil {
+		t.Fatalf("parse towerfile: %v", err)
+	}
+	data, err := json.Marshal(towerfile.ParamsSchemaFromParameters(tf.Parameters))
+	if err != nil {
+		t.Fatalf("marshal params schema: %v", err)
+	}
+	return data
+}
+
+func readBody(t *testing.T, resp *http.Response) []byte {
+	t.Helper()
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return data
+}
+
+func TestVersionParamsSchemaRoundTrips(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-version-schema")
+	testutil.CreateApp(t, s, team.ID, "form-app")
+	want1 := deployedParamsSchema(t, schemaTowerfileV1)
+	want2 := deployedParamsSchema(t, schemaTowerfileV2)
+
+	for i, tf := range []string{schemaTowerfileV1, schemaTowerfileV2, "[app]\nname = \"form-app\"\nscript = \"main.sh\"\n"} {
+		resp := uploadTowerfileVersion(t, handler, token, "form-app", tf)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("upload %d: expected 201, got %d", i+1, resp.StatusCode)
+		}
+	}
+	resp := doRequest(t, handler, http.MethodPost, "/api/v1/apps/form-app/versions/1/labels", token, "", map[string]any{"label": "stable"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("set label: expected 200, got %d", resp.StatusCode)
+	}
+
+	type version struct {
+		VersionNo      int64           `json:"version_no"`
+		TimeoutSeconds *int            `json:"timeout_seconds"`
+		ParamsSchema   json.RawMessage `json:"params_schema"`
+		DefaultInput   map[string]any  `json:"default_input"`
+	}
+
+	var got version
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/form-app/versions/1", token, "", nil)
+	decodeStatus(t, resp, http.StatusOK, &got)
+	if !bytes.Equal(got.ParamsSchema, want1) {
+		t.Fatalf("get version: params_schema\n got %s\nwant %s", got.ParamsSchema, want1)
+	}
+	if got.TimeoutSeconds == nil || *got.TimeoutSeconds != 90 || got.DefaultInput["region"] != "eu" {
+		t.Fatalf("expected timeout 90 and the app's default input, got %+v", got)
+	}
+
+	var list struct {
+		Versions []version `json:"versions"`
+	}
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/form-app/versions", token, "", nil)
+	decodeStatus(t, resp, http.StatusOK, &list)
+	if len(list.Versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(list.Versions))
+	}
+	for _, v := range list.Versions {
+		var want []byte
+		switch v.VersionNo {
+		case 1:
+			want = want1
+		case 2:
+			want = want2
+		}
+		if !bytes.Equal(v.ParamsSchema, want) {
+			t.Fatalf("list version %d: params_schema\n got %s\nwant %s", v.VersionNo, v.ParamsSchema, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []byte
+	}{
+		{"?version_no=2", want2},
+		{"?version_label=stable", want1},
+		{"", []byte("{}")},
+	} {
+		resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/form-app/schema"+tc.query, token, "", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("schema%s: expected 200, got %d", tc.query, resp.StatusCode)
+		}
+		if body := readBody(t, resp); !bytes.Equal(body, tc.want) {
+			t.Fatalf("schema%s:\n got %s\nwant %s", tc.query, body, tc.want)
+		}
+	}
+}
+
+func TestVersionSchemaLookupErrors(t *testing.T) {
+	handler, s, _, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-version-schema-errors")
+	testutil.CreateApp(t, s, team.ID, "empty-app")
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/empty-app/schema", token, "", nil)
+	assertErrorCode(t, "no versions", resp, http.StatusBadRequest, "no_version")
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/empty-app/versions/1", token, "", nil)
+	assertErrorCode(t, "unknown version", resp, http.StatusNotFound, "not_found")
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/empty-app/versions/x", token, "", nil)
+	assertErrorCode(t, "bad version number", resp, http.StatusBadRequest, "invalid_request")
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/empty-app/schema?version_no=0", token, "", nil)
+	assertErrorCode(t, "bad version_no", resp, http.StatusBadRequest, "invalid_request")
+	resp = doRequest(t, handler, http.MethodGet, "/api/v1/apps/nope/schema", token, "", nil)
+	assertErrorCode(t, "unknown app", resp, http.StatusNotFound, "not_found")
+}
@@ -0,0 +1,132 @@
+package httpapi_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"minitower/internal/testutil"
+)
+
+func TestRunsVersionFilterAndVersionStats(t *testing.T) {
+	handler, s, dbConn, cleanup := newTestServer(t)
+	defer cleanup()
+
+	team, token := testutil.CreateTeam(t, s, "team-version-stats")
+	app := testutil.CreateApp(t, s, team.ID, "stats-app")
+	v1 := testutil.CreateVersion(t, s, app.ID)
+	v2 := testutil.CreateVersion(t, s, app.ID)
+	testutil.CreateVersion(t, s, app.ID)
+	env, err := s.GetOrCreateDefaultEnvironment(context.Background(), team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	seed := func(versionID int64, status string, queuedAt time.Time, duration time.Duration) {
+		t.Helper()
+		run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, versionID, 0, 0)
+		if duration == 0 {
+			mustExecHTTP(t, dbConn, `UPDATE runs SET status = ?, queued_at = ? WHERE id = ?`, status, queuedAt.UnixMilli(), run.ID)
+			return
+		}
+		started := queuedAt.Add(time.Second)
+		mustExecHTTP(t, dbConn, `UPDATE runs SET status = ?, queued_at = ?, started_at = ?, finished_at = ? WHERE id = ?`,
+			status, queuedAt.UnixMilli(), started.UnixMilli(), started.Add(duration).UnixMilli(), run.ID)
+	}
+	seed(v1.ID, "completed", base, 10*time.Second)
+	seed(v1.ID, "failed", base.Add(time.Hour), 30*time.Second)
+	seed(v2.ID, "completed", base.Add(2*time.Hour), time.Second)
+	seed(v2.ID, "completed", base.Add(3*time.Hour), 2*time.Second)
+	seed(v2.ID, "completed", base.Add(4*time.Hour), 4*time.Second)
+	seed(v2.ID, "failed", base.Add(5*time.Hour), 10*time.Second)
+	seed(v2.ID, "queued", base.Add(6*time.Hour), 0)
+
+	type runList struct {
+		Runs []struct {
+			VersionNo int64 `json:"version_no"`
+		} `json:"runs"`
+	}
+	for _, tc := range []struct {
+		path      string
+		versionNo int64
+		want      int
+	}{
+		{"/api/v1/apps/stats-app/runs?version_no=2", 2, 5},
+		{"/api/v1/apps/stats-app/runs?version_no=1", 1, 2},
+		{"/api/v1/runs?version_no=1", 1, 2},
+		{"/api/v1/runs?app=stats-app&version_no=2", 2, 5},
+		{"/api/v1/apps/stats-app/runs?version_no=3", 3, 0},
+	} {
+		var list runList
+		resp := doRequest(t, handler, http.MethodGet, tc.path, token, "", nil)
+		decodeStatus(t, resp, http.StatusOK, &list)
+		if len(list.Runs) != tc.want {
+			t.Fatalf("%s: expected %d runs, got %d", tc.path, tc.want, len(list.Runs))
+		}
+		for _, run := range list.Runs {
+			if run.VersionNo != tc.versionNo {
+				t.Fatalf("%s: expected only version %d runs, got %+v", tc.path, tc.versionNo, list.Runs)
+			}
+		}
+	}
+	for _, path := range []string{
+		"/api/v1/apps/stats-app/runs?version_no=0",
+		"/api/v1/apps/stats-app/runs?version_no=latest",
+		"/api/v1/runs?version_no=-1",
+	} {
+		resp := doRequest(t, handler, http.MethodGet, path, token, "", nil)
+		assertErrorCode(t, path, resp, http.StatusBadRequest, "invalid_request")
+	}
+
+	type versionStats struct {
+		VersionNo          int64            `json:"version_no"`
+		TotalRuns          int64            `json:"total_runs"`
+		RunsByStatus       map[string]int64 `json:"runs_by_status"`
+		FirstRunAt         *string          `json:"first_run_at"`
+		LastRunAt          *string          `json:"last_run_at"`
+		DurationAvgSeconds *float64         `json:"duration_avg_seconds"`
+		DurationP50Seconds *float64         `json:"duration_p50_seconds"`
+		DurationP95Seconds *float64         `json:"duration_p95_seconds"`
+	}
+	getStats := func(versionNo string) versionStats {
+		t.Helper()
+		var stats versionStats
+		resp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/stats-app/versions/"+versionNo+"/stats", token, "", nil)
+		decodeStatus(t, resp, http.StatusOK, &stats)
+		return stats
+	}
+
+	stats := getStats("2")
+	if stats.VersionNo != 2 || stats.TotalRuns != 5 {
+		t.Fatalf("expected 5 runs of version 2, got %+v", stats)
+	}
+	if stats.RunsByStatus["completed"] != 3 || stats.RunsByStatus["failed"] != 1 || stats.RunsByStatus["queued"] != 1 || len(stats.RunsByStatus) != 3 {
+		t.Fatalf("unexpected counts by status: %v", stats.RunsByStatus)
+	}
+	if stats.FirstRunAt == nil || *stats.FirstRunAt != "2024-03-01T14:00:00.000Z" || stats.LastRunAt == nil || *stats.LastRunAt != "2024-03-01T18:00:00.000Z" {
+		t.Fatalf("unexpected first/last run times: %+v", stats)
+	}
+	// Durations 1s, 2s, 4s and 10s; the queued run has none.
+	if stats.DurationAvgSeconds == nil || *stats.DurationAvgSeconds != 4.25 {
+		t.Fatalf("expected an average of 4.25s, got %v", stats.DurationAvgSeconds)
+	}
+	if stats.DurationP50Seconds == nil || *stats.DurationP50Seconds != 2 || stats.DurationP95Seconds == nil || *stats.DurationP95Seconds != 10 {
+		t.Fatalf("expected p50 2s and p95 10s, got %v and %v", stats.DurationP50Seconds, stats.DurationP95Seconds)
+	}
+
+	stats = getStats("1")
+	if stats.TotalRuns != 2 || stats.RunsByStatus["completed"] != 1 || stats.RunsByStatus["failed"] != 1 || *stats.DurationAvgSeconds != 20 {
+		t.Fatalf("expected version 1's two runs averaging 20s, got %+v", stats)
+	}
+
+	// A version without runs has zero counts and no times.
+	stats = getStats("3")
+	if stats.TotalRuns != 0 || len(stats.RunsByStatus) != 0 || stats.FirstRunAt != nil || stats.DurationP95Seconds != nil {
+		t.Fatalf("expected empty stats for version 3, got %+v", stats)
+	}
+
+	resp := doRequest(t, handler, http.MethodGet, "/api/v1/apps/stats-app/versions/9/stats", token, "", nil)
+	assertErrorCode(t, "unknown version", resp, http.StatusNotFound, "not_found")
+}
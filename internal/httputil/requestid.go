@@ -0,0 +1,105 @@
+package httputil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+const (
+	// RequestIDHeader carries the per-request correlation ID. Incoming values
+	// are kept so callers can correlate their own logs; the server echoes the
+	// ID on every response and in error bodies.
+	RequestIDHeader = "X-Request-ID"
+	// RunTraceIDHeader carries a run's trace ID on runner API calls.
+	RunTraceIDHeader = "X-Run-Trace-ID"
+
+	maxIDLen = 128
+)
+
+type ctxKey int
+
+const (
+	ctxKeyRequestID ctxKey = iota
+	ctxKeyRunTraceID
+)
+
+// NewRequestID returns a random 32-character hex ID.
+func NewRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+// ValidID reports whether a client-supplied correlation ID is safe to log
+// and echo: 1-128 characters of [A-Za-z0-9._:-].
+func ValidID(id string) bool {
+	if id == "" || len(id) > maxIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == ':', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKeyRequestID, id)
+}
+
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ctxKeyRequestID).(string)
+	return id, ok
+}
+
+func WithRunTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKeyRunTraceID, id)
+}
+
+func RunTraceIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ctxKeyRunTraceID).(string)
+	return id, ok
+}
+
+// contextHandler adds request_id and run_trace_id attributes from the
+// record's context, so any *Context log call made while serving a request
+// is correlated without threading a logger through.
+type contextHandler struct {
+	slog.Handler
+}
+
+// NewContextHandler wraps h to add correlation IDs from the context.
+func NewContextHandler(h slog.Handler) slog.Handler {
+	if _, ok := h.(contextHandler); ok {
+		return h
+	}
+	return contextHandler{Handler: h}
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id, ok := RequestIDFromContext(ctx); ok {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if id, ok := RunTraceIDFromContext(ctx); ok {
+			r.AddAttrs(slog.String("run_trace_id", id))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -10,9 +10,28 @@ type ErrorEnvelope struct {
 	Error ErrorBody `json:"error"`
 }
 
-// ErrorBody contains the error code and message.
+// ErrorBody contains the error code and message, plus the request ID when
+// the request went through the request ID middleware. Items lists per-item
+// failures for requests that carry several items, such as batch run inputs.
+// Count is the actual number of items for errors that ask the client to
+// confirm a count, or the current usage for errors about a limit, which
+// Limit then carries. ExpectedSHA256 and ActualSHA256 carry both hashes of
+// an upload whose content did not match the hash the client sent.
 type ErrorBody struct {
-	Code    string `json:"code"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id,omitempty"`
+	Items     []ErrorItem `json:"items,omitempty"`
+	Count     *int64      `json:"count,omitempty"`
+	Limit     *int64      `json:"limit,omitempty"`
+
+	ExpectedSHA256 string `json:"expected_sha256,omitempty"`
+	ActualSHA256   string `json:"actual_sha256,omitempty"`
+}
+
+// ErrorItem is the failure of one item of a request, by position.
+type ErrorItem struct {
+	Index   int    `json:"index"`
 	Message string `json:"message"`
 }
 
@@ -23,12 +42,67 @@ func WriteJSON(w http.ResponseWriter, status int, payload any) {
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
-// WriteError writes a standard error response.
+// WriteError writes a standard error response. The request ID is read back
+// from the response header the middleware set, so callers need not pass it.
 func WriteError(w http.ResponseWriter, status int, code, message string) {
 	WriteJSON(w, status, ErrorEnvelope{
 		Error: ErrorBody{
-			Code:    code,
-			Message: message,
+			Code:      code,
+			Message:   message,
+			RequestID: w.Header().Get(RequestIDHeader),
+		},
+	})
+}
+
+// WriteErrorItems writes a standard error response listing per-item failures.
+func WriteErrorItems(w http.ResponseWriter, status int, code, message string, items []ErrorItem) {
+	WriteJSON(w, status, ErrorEnvelope{
+		Error: ErrorBody{
+			Code:      code,
+			Message:   message,
+			RequestID: w.Header().Get(RequestIDHeader),
+			Items:     items,
+		},
+	})
+}
+
+// WriteErrorCount writes a standard error response carrying the actual count
+// the client must confirm.
+func WriteErrorCount(w http.ResponseWriter, status int, code, message string, count int64) {
+	WriteJSON(w, status, ErrorEnvelope{
+		Error: ErrorBody{
+			Code:      code,
+			Message:   message,
+			RequestID: w.Header().Get(RequestIDHeader),
+			Count:     &count,
+		},
+	})
+}
+
+// WriteErrorLimit writes a standard error response carrying the current
+// count against the limit that was reached.
+func WriteErrorLimit(w http.ResponseWriter, status int, code, message string, count, limit int64) {
+	WriteJSON(w, status, ErrorEnvelope{
+		Error: ErrorBody{
+			Code:      code,
+			Message:   message,
+			RequestID: w.Header().Get(RequestIDHeader),
+			Count:     &count,
+			Limit:     &limit,
+		},
+	})
+}
+
+// WriteErrorSHA256 writes a standard error response carrying the hash the
+// client expected and the hash of what the server received.
+func WriteErrorSHA256(w http.ResponseWriter, status int, code, message, expected, actual string) {
+	WriteJSON(w, status, ErrorEnvelope{
+		Error: ErrorBody{
+			Code:           code,
+			Message:        message,
+			RequestID:      w.Header().Get(RequestIDHeader),
+			ExpectedSHA256: expected,
+			ActualSHA256:   actual,
 		},
 	})
 }
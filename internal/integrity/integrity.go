@@ -0,0 +1,236 @@
+// Package integrity checks that the artifacts of stored versions are still
+// intact: present in the object store and hashing to the sha256 recorded at
+// upload.
+package integrity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+
+	"minitower/internal/objects"
+	"minitower/internal/store"
+)
+
+const (
+	// DefaultBytesPerSecond bounds how fast a verification reads artifacts,
+	// so it does not starve a serving process of disk I/O.
+	DefaultBytesPerSecond = 32 << 20
+	listBatchSize         = 200
+	// readChunk is the most a throttled read asks for at once, so waits
+	// stay short and cancellation prompt.
+	readChunk = 256 << 10
+)
+
+// Problem kinds.
+const (
+	ProblemMissing    = "missing"
+	ProblemMismatch   = "sha256_mismatch"
+	ProblemUnreadable = "unreadable"
+)
+
+// Problem is a version whose artifact failed verification.
+type Problem struct {
+	VersionID      int64
+	Team           string
+	App            string
+	VersionNo      int64
+	ObjectKey      string
+	Kind           string
+	ExpectedSHA256 string
+	// ActualSHA256 is set for ProblemMismatch, Err for ProblemUnreadable.
+	ActualSHA256 string
+	Err          string
+}
+
+// Options configures a Verifier.
+type Options struct {
+	// BytesPerSecond caps the read rate; zero reads unthrottled.
+	BytesPerSecond int64
+	// MarkCorrupt sets artifact_corrupt on versions with a problem and
+	// clears it on flagged versions found intact.
+	MarkCorrupt bool
+}
+
+// Report summarizes one verification pass.
+type Report struct {
+	Checked  int
+	Bytes    int64
+	Problems []Problem
+	// Marked and Cleared count the versions whose artifact_corrupt flag
+	// the pass set and cleared.
+	Marked  int
+	Cleared int
+}
+
+// Progress is reported after each version is checked. Total is the number
+// of versions when the pass started; versions uploaded during it are
+// checked too.
+type Progress struct {
+	Checked int
+	Total   int
+}
+
+// Verifier re-hashes version artifacts and compares them with the recorded
+// sha256.
+type Verifier struct {
+	store   *store.Store
+	objects *objects.LocalStore
+	opts    Options
+}
+
+// New creates a Verifier.
+func New(s *store.Store, objStore *objects.LocalStore, opts Options) *Verifier {
+	return &Verifier{store: s, objects: objStore, opts: opts}
+}
+
+// Run checks the artifact of every version of every team, in version ID
+// order. progress, when not nil, is called after each version. A problem
+// with an artifact is reported, not returned; Run only fails when the
+// database does or ctx is done, returning the report so far.
+func (v *Verifier) Run(ctx context.Context, progress func(Progress)) (Report, error) {
+	var rep Report
+	total, err := v.store.CountVersions(ctx)
+	if err != nil {
+		return rep, err
+	}
+	limit := newThrottle(v.opts.BytesPerSecond)
+
+	var afterID int64
+	for {
+		artifacts, err := v.store.ListVersionArtifacts(ctx, afterID, listBatchSize)
+		if err != nil {
+			return rep, err
+		}
+		for _, a := range artifacts {
+			afterID = a.VersionID
+			problem, n, err := v.check(ctx, a, limit)
+			if err != nil {
+				return rep, err
+			}
+			rep.Checked++
+			rep.Bytes += n
+			if problem != nil {
+				rep.Problems = append(rep.Problems, *problem)
+			}
+			if v.opts.MarkCorrupt && (problem != nil) != a.Corrupt {
+				if err := v.store.SetVersionArtifactCorrupt(ctx, a.VersionID, problem != nil); err != nil {
+					return rep, err
+				}
+				if problem != nil {
+					rep.Marked++
+				} else {
+					rep.Cleared++
+				}
+			}
+			if progress != nil {
+				progress(Progress{Checked: rep.Checked, Total: max(total, rep.Checked)})
+			}
+		}
+		if len(artifacts) < listBatchSize {
+			return rep, nil
+		}
+	}
+}
+
+// check hashes one artifact and returns its problem, if any, and the bytes
+// read. It only returns an error when ctx is done.
+func (v *Verifier) check(ctx context.Context, a store.VersionArtifact, limit *throttle) (*Problem, int64, error) {
+	problem := &Problem{
+		VersionID:      a.VersionID,
+		Team:           a.TeamSlug,
+		App:            a.AppSlug,
+		VersionNo:      a.VersionNo,
+		ObjectKey:      a.ObjectKey,
+		ExpectedSHA256: a.SHA256,
+	}
+	rc, err := v.objects.Load(a.ObjectKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		problem.Kind = ProblemMissing
+		return problem, 0, nil
+	}
+	if err != nil {
+		problem.Kind = ProblemUnreadable
+		problem.Err = err.Error()
+		return problem, 0, nil
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, &throttledReader{ctx: ctx, r: rc, limit: limit})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, n, ctxErr
+	}
+	if err != nil {
+		problem.Kind = ProblemUnreadable
+		problem.Err = err.Error()
+		return problem, n, nil
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != a.SHA256 {
+		problem.Kind = ProblemMismatch
+		problem.ActualSHA256 = actual
+		return problem, n, nil
+	}
+	return nil, n, nil
+}
+
+// throttle paces reads across a whole pass to an average rate measured
+// from the first read. A nil *throttle does not wait.
+type throttle struct {
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func newThrottle(bytesPerSecond int64) *throttle {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &throttle{rate: bytesPerSecond}
+}
+
+// wait records n bytes read and sleeps until the average rate is back
+// under the cap.
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t == nil {
+		return nil
+	}
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	t.read += int64(n)
+	due := time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second))
+	d := due - time.Since(t.start)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	limit *throttle
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.limit != nil && len(p) > readChunk {
+		p = p[:readChunk]
+	}
+	n, err := t.r.Read(p)
+	if werr := t.limit.wait(t.ctx, n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
@@ -0,0 +1,188 @@
+package integrity_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"minitower/internal/integrity"
+	"minitower/internal/objects"
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+// storeVersion stores content as an artifact and creates a version of appID
+// recording its sha256.
+func storeVersion(t *testing.T, s *store.Store, objStore *objects.LocalStore, appID int64, key, content string) *store.AppVersion {
+	t.Helper()
+	if err := objStore.Store(key, strings.NewReader(content)); err != nil {
+		t.Fatalf("store object: %v", err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	v, err := s.CreateVersion(context.Background(), appID, key, hex.EncodeToString(sum[:]), int64(len(content)), "main.py", nil, nil, nil, nil, nil, 1, false, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+	return v
+}
+
+func TestVerifierDetectsAndFlagsBadArtifacts(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	dir := t.TempDir()
+	objStore, err := objects.NewLocalStore(dir)
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-verify")
+	app := testutil.CreateApp(t, s, team.ID, "verify-app")
+	intact := storeVersion(t, s, objStore, app.ID, "artifacts/intact.tar.gz", "intact artifact")
+	truncated := storeVersion(t, s, objStore, app.ID, "artifacts/truncated.tar.gz", "truncated artifact")
+	missing := storeVersion(t, s, objStore, app.ID, "artifacts/missing.tar.gz", "missing artifact")
+
+	if err := os.WriteFile(filepath.Join(dir, "artifacts", "truncated.tar.gz"), []byte("trunc"), 0644); err != nil {
+		t.Fatalf("truncate object: %v", err)
+	}
+	if err := objStore.Delete("artifacts/missing.tar.gz"); err != nil {
+		t.Fatalf("delete object: %v", err)
+	}
+
+	// A report-only pass leaves the versions alone.
+	var progress []integrity.Progress
+	rep, err := integrity.New(s, objStore, integrity.Options{}).Run(ctx, func(p integrity.Progress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if rep.Checked != 3 || len(rep.Problems) != 2 || rep.Marked != 0 {
+		t.Fatalf("expected 3 checked, 2 problems and none marked, got %+v", rep)
+	}
+	if len(progress) != 3 || progress[2] != (integrity.Progress{Checked: 3, Total: 3}) {
+		t.Fatalf("expected progress after each version, got %+v", progress)
+	}
+	kinds := map[int64]string{}
+	for _, p := range rep.Problems {
+		kinds[p.VersionID] = p.Kind
+		if p.Team != "team-verify" || p.App != "verify-app" {
+			t.Fatalf("expected the problem to name its team and app, got %+v", p)
+		}
+	}
+	if kinds[truncated.ID] != integrity.ProblemMismatch || kinds[missing.ID] != integrity.ProblemMissing {
+		t.Fatalf("expected a sha256 mismatch and a missing object, got %+v", rep.Problems)
+	}
+	v, err := s.GetVersionByID(ctx, truncated.ID)
+	if err != nil {
+		t.Fatalf("get version: %v", err)
+	}
+	if v.ArtifactCorrupt {
+		t.Fatal("expected a report-only pass not to flag the version")
+	}
+
+	rep, err = integrity.New(s, objStore, integrity.Options{MarkCorrupt: true}).Run(ctx, nil)
+	if err != nil {
+		t.Fatalf("verify and mark: %v", err)
+	}
+	if rep.Marked != 2 || rep.Cleared != 0 {
+		t.Fatalf("expected 2 versions marked, got %+v", rep)
+	}
+	for id, want := range map[int64]bool{intact.ID: false, truncated.ID: true, missing.ID: true} {
+		v, err := s.GetVersionByID(ctx, id)
+		if err != nil {
+			t.Fatalf("get version: %v", err)
+		}
+		if v.ArtifactCorrupt != want {
+			t.Fatalf("version %d: expected artifact_corrupt %v, got %v", id, want, v.ArtifactCorrupt)
+		}
+	}
+
+	// Restoring the object from a backup clears the flag on the next pass.
+	if err := objStore.Store("artifacts/missing.tar.gz", strings.NewReader("missing artifact")); err != nil {
+		t.Fatalf("restore object: %v", err)
+	}
+	rep, err = integrity.New(s, objStore, integrity.Options{MarkCorrupt: true}).Run(ctx, nil)
+	if err != nil {
+		t.Fatalf("verify after restore: %v", err)
+	}
+	if len(rep.Problems) != 1 || rep.Marked != 0 || rep.Cleared != 1 {
+		t.Fatalf("expected the restored version cleared, got %+v", rep)
+	}
+	v, err = s.GetVersionByID(ctx, missing.ID)
+	if err != nil {
+		t.Fatalf("get version: %v", err)
+	}
+	if v.ArtifactCorrupt {
+		t.Fatal("expected the restored version's flag cleared")
+	}
+}
+
+func TestVerifierThrottlesReads(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+
+	team, _ := testutil.CreateTeam(t, s, "team-throttle")
+	app := testutil.CreateApp(t, s, team.ID, "throttle-app")
+	storeVersion(t, s, objStore, app.ID, "artifacts/a.tar.gz", strings.Repeat("a", 2048))
+	storeVersion(t, s, objStore, app.ID, "artifacts/b.tar.gz", strings.Repeat("b", 2048))
+
+	start := time.Now()
+	rep, err := integrity.New(s, objStore, integrity.Options{BytesPerSecond: 8192}).Run(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if rep.Bytes != 4096 || len(rep.Problems) != 0 {
+		t.Fatalf("expected 4096 bytes read cleanly, got %+v", rep)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("expected 4096 bytes at 8192 B/s to take about 500ms, took %s", elapsed)
+	}
+}
+
+func TestJobsStopWhenTheirContextIsCancelled(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+
+	team, _ := testutil.CreateTeam(t, s, "team-jobs")
+	app := testutil.CreateApp(t, s, team.ID, "jobs-app")
+	storeVersion(t, s, objStore, app.ID, "artifacts/a.tar.gz", strings.Repeat("a", 2048))
+	storeVersion(t, s, objStore, app.ID, "artifacts/b.tar.gz", strings.Repeat("b", 2048))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs := integrity.NewJobs(ctx)
+	// At 1 KB/s the job would take about four seconds.
+	job, err := jobs.Start(integrity.New(s, objStore, integrity.Options{BytesPerSecond: 1024, MarkCorrupt: true}))
+	if err != nil {
+		t.Fatalf("start job: %v", err)
+	}
+	if _, err := jobs.Start(integrity.New(s, objStore, integrity.Options{})); err != integrity.ErrInProgress {
+		t.Fatalf("expected ErrInProgress for a second job, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Second)
+	defer waitCancel()
+	if err := jobs.Wait(waitCtx); err != nil {
+		t.Fatalf("expected the job to stop promptly, got %v", err)
+	}
+	st := job.State()
+	if st.Status != integrity.JobFailed || !errors.Is(st.Err, context.Canceled) {
+		t.Fatalf("expected the job failed with context.Canceled, got %s (%v)", st.Status, st.Err)
+	}
+}
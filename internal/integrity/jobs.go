@@ -0,0 +1,159 @@
+package integrity
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxJobs is how many finished jobs Jobs remembers for polling.
+const maxJobs = 20
+
+// ErrInProgress is returned when a verification is started while another
+// is running.
+var ErrInProgress = errors.New("verification already in progress")
+
+// Job statuses.
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Job is a verification running in the background.
+type Job struct {
+	ID          string
+	MarkCorrupt bool
+	StartedAt   time.Time
+
+	mu         sync.Mutex
+	status     string
+	progress   Progress
+	report     Report
+	err        error
+	finishedAt time.Time
+}
+
+// JobState is a point-in-time copy of a job.
+type JobState struct {
+	ID          string
+	MarkCorrupt bool
+	Status      string
+	Progress    Progress
+	// Report is the final report once the job has finished, or the report
+	// so far of a failed job.
+	Report     Report
+	Err        error
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// State returns a copy of the job's current state.
+func (j *Job) State() JobState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return JobState{
+		ID:          j.ID,
+		MarkCorrupt: j.MarkCorrupt,
+		Status:      j.status,
+		Progress:    j.progress,
+		Report:      j.report,
+		Err:         j.err,
+		StartedAt:   j.StartedAt,
+		FinishedAt:  j.finishedAt,
+	}
+}
+
+// Jobs runs verifications in the background one at a time and keeps the
+// most recent ones for polling. Jobs live in memory and are lost on
+// restart.
+type Jobs struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	jobs    []*Job
+	running bool
+	wg      sync.WaitGroup
+}
+
+// NewJobs creates an empty job registry. Its jobs run on ctx, so cancelling
+// it stops them; ctx should live as long as the server.
+func NewJobs(ctx context.Context) *Jobs {
+	return &Jobs{ctx: ctx}
+}
+
+// Wait blocks until the running job, if any, has returned, or until ctx is
+// done. Call it after cancelling the registry's context and before closing
+// the database the jobs write to.
+func (js *Jobs) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		js.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start runs v in the background and returns its job, or ErrInProgress if
+// another job is running.
+func (js *Jobs) Start(v *Verifier) (*Job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.running {
+		return nil, ErrInProgress
+	}
+	js.running = true
+	js.wg.Add(1)
+	job := &Job{
+		ID:          uuid.NewString(),
+		MarkCorrupt: v.opts.MarkCorrupt,
+		StartedAt:   time.Now(),
+		status:      JobRunning,
+	}
+	js.jobs = append(js.jobs, job)
+	if len(js.jobs) > maxJobs {
+		js.jobs = js.jobs[len(js.jobs)-maxJobs:]
+	}
+
+	go func() {
+		defer js.wg.Done()
+		rep, err := v.Run(js.ctx, func(p Progress) {
+			job.mu.Lock()
+			job.progress = p
+			job.mu.Unlock()
+		})
+		job.mu.Lock()
+		job.report = rep
+		job.err = err
+		job.status = JobCompleted
+		if err != nil {
+			job.status = JobFailed
+		}
+		job.finishedAt = time.Now()
+		job.mu.Unlock()
+
+		js.mu.Lock()
+		js.running = false
+		js.mu.Unlock()
+	}()
+	return job, nil
+}
+
+// Get returns the job with id, or nil if it is unknown or was dropped.
+func (js *Jobs) Get(id string) *Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for _, j := range js.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
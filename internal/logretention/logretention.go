@@ -0,0 +1,192 @@
+package logretention
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"minitower/internal/objects"
+	"minitower/internal/store"
+)
+
+// DefaultBatchSize is how many log rows a single delete statement removes.
+const DefaultBatchSize = 500
+
+// Options configures a Purger.
+type Options struct {
+	// RetentionDays purges logs of attempts that finished longer ago than
+	// this. Zero disables age-based purging.
+	RetentionDays int
+	// MaxRowsPerAttempt keeps only the newest rows of each finished attempt.
+	// Zero disables trimming.
+	MaxRowsPerAttempt int
+	// Archive writes an attempt's logs to the object store before they are
+	// purged for age.
+	Archive bool
+	// AuditRetentionDays purges audit log entries older than this. Zero
+	// keeps them forever.
+	AuditRetentionDays int
+	// BatchSize defaults to DefaultBatchSize.
+	BatchSize int
+}
+
+// Result summarizes one purge pass.
+type Result struct {
+	Archived    int
+	Purged      int64
+	AuditPurged int64
+}
+
+// Purger enforces the log retention policy. Logs of runs that have not
+// reached a terminal state are never touched.
+type Purger struct {
+	store   *store.Store
+	objects *objects.LocalStore
+	opts    Options
+}
+
+// New creates a Purger.
+func New(s *store.Store, objStore *objects.LocalStore, opts Options) *Purger {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	return &Purger{store: s, objects: objStore, opts: opts}
+}
+
+// Enabled reports whether the policy purges anything at all.
+func (p *Purger) Enabled() bool {
+	return p.opts.RetentionDays > 0 || p.opts.MaxRowsPerAttempt > 0 || p.opts.AuditRetentionDays > 0
+}
+
+// Run performs one purge pass. When archiving is enabled, every attempt due
+// for purging is archived first; if any archive fails nothing is purged for
+// age, so logs are never dropped without their archive.
+func (p *Purger) Run(ctx context.Context, now time.Time) (Result, error) {
+	var res Result
+
+	if p.opts.RetentionDays > 0 {
+		cutoff := now.Add(-time.Duration(p.opts.RetentionDays) * 24 * time.Hour)
+		if p.opts.Archive {
+			archived, err := p.archiveBefore(ctx, cutoff)
+			res.Archived = archived
+			if err != nil {
+				return res, err
+			}
+		}
+		n, err := p.store.PurgeOldLogs(ctx, cutoff, p.opts.BatchSize)
+		res.Purged += n
+		if err != nil {
+			return res, fmt.Errorf("purge old logs: %w", err)
+		}
+	}
+
+	if p.opts.MaxRowsPerAttempt > 0 {
+		n, err := p.store.TrimAttemptLogs(ctx, p.opts.MaxRowsPerAttempt, p.opts.BatchSize)
+		res.Purged += n
+		if err != nil {
+			return res, fmt.Errorf("trim attempt logs: %w", err)
+		}
+	}
+
+	if p.opts.AuditRetentionDays > 0 {
+		cutoff := now.Add(-time.Duration(p.opts.AuditRetentionDays) * 24 * time.Hour)
+		n, err := p.store.PurgeAuditLog(ctx, cutoff, p.opts.BatchSize)
+		res.AuditPurged = n
+		if err != nil {
+			return res, fmt.Errorf("purge audit log: %w", err)
+		}
+	}
+
+	return res, nil
+}
+
+func (p *Purger) archiveBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	archived := 0
+	for {
+		ids, err := p.store.ListLogPurgeCandidates(ctx, cutoff, p.opts.BatchSize)
+		if err != nil {
+			return archived, fmt.Errorf("list purge candidates: %w", err)
+		}
+		for _, attemptID := range ids {
+			if err := p.archiveAttempt(ctx, attemptID); err != nil {
+				return archived, fmt.Errorf("archive attempt %d: %w", attemptID, err)
+			}
+			archived++
+		}
+		if len(ids) < p.opts.BatchSize {
+			return archived, nil
+		}
+	}
+}
+
+func (p *Purger) archiveAttempt(ctx context.Context, attemptID int64) error {
+	logs, err := p.store.GetAttemptLogs(ctx, attemptID)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := WriteArchive(&buf, logs); err != nil {
+		return err
+	}
+	key := ArchiveKey(attemptID)
+	if err := p.objects.Store(key, &buf); err != nil {
+		return err
+	}
+	return p.store.SetAttemptLogArchive(ctx, attemptID, key)
+}
+
+// ArchiveKey returns the object key for an attempt's log archive.
+func ArchiveKey(attemptID int64) string {
+	return fmt.Sprintf("logs/attempt-%d.ndjson.gz", attemptID)
+}
+
+type archiveLine struct {
+	Seq      int64  `json:"seq"`
+	Stream   string `json:"stream"`
+	Line     string `json:"line"`
+	LoggedAt int64  `json:"logged_at_ms"`
+}
+
+// WriteArchive writes logs as gzip-compressed newline-delimited JSON.
+func WriteArchive(w io.Writer, logs []*store.RunLog) error {
+	gw := gzip.NewWriter(w)
+	enc := json.NewEncoder(gw)
+	for _, l := range logs {
+		if err := enc.Encode(archiveLine{Seq: l.Seq, Stream: l.Stream, Line: l.Line, LoggedAt: l.LoggedAt.UnixMilli()}); err != nil {
+			return err
+		}
+	}
+	return gw.Close()
+}
+
+// ReadArchive reads logs written by WriteArchive.
+func ReadArchive(r io.Reader) ([]*store.RunLog, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	var logs []*store.RunLog
+	scanner := bufio.NewScanner(gr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var line archiveLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, err
+		}
+		logs = append(logs, &store.RunLog{
+			Seq:      line.Seq,
+			Stream:   line.Stream,
+			Line:     line.Line,
+			LoggedAt: time.UnixMilli(line.LoggedAt),
+		})
+	}
+	return logs, scanner.Err()
+}
@@ -0,0 +1,155 @@
+package logretention_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"minitower/internal/logretention"
+	"minitower/internal/objects"
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestRunArchivesBeforePurging(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	objStore, err := objects.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("objects store: %v", err)
+	}
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-archive")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "archive-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	runner, _ := testutil.CreateRunner(t, s, "runner-archive", "default")
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	_, attempt, _, _ := testutil.LeaseRun(t, s, runner)
+
+	finished := time.Now().Add(-40 * 24 * time.Hour)
+	logs := make([]store.LogEntry, 0, 12)
+	for i := 1; i <= 12; i++ {
+		stream := "stdout"
+		if i%3 == 0 {
+			stream = "stderr"
+		}
+		logs = append(logs, store.LogEntry{Seq: int64(i), Stream: stream, Line: fmt.Sprintf("line %d", i), LoggedAt: finished})
+	}
+	if err := s.AppendLogs(ctx, attempt.ID, logs); err != nil {
+		t.Fatalf("append logs: %v", err)
+	}
+	if _, err := dbConn.Exec(`UPDATE run_attempts SET status = 'completed', finished_at = ? WHERE id = ?`, finished.UnixMilli(), attempt.ID); err != nil {
+		t.Fatalf("finish attempt: %v", err)
+	}
+	if _, err := dbConn.Exec(`UPDATE runs SET status = 'completed' WHERE id = ?`, run.ID); err != nil {
+		t.Fatalf("finish run: %v", err)
+	}
+
+	purger := logretention.New(s, objStore, logretention.Options{RetentionDays: 30, Archive: true, BatchSize: 5})
+	res, err := purger.Run(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("run purger: %v", err)
+	}
+	if res.Archived != 1 || res.Purged != 12 {
+		t.Fatalf("expected 1 archived and 12 purged, got %+v", res)
+	}
+
+	key, err := s.GetRunLogArchiveKey(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("get archive key: %v", err)
+	}
+	if key != logretention.ArchiveKey(attempt.ID) {
+		t.Fatalf("expected archive key %q, got %q", logretention.ArchiveKey(attempt.ID), key)
+	}
+
+	// The object is a plain gzip of newline-delimited JSON.
+	rc, err := objStore.Load(key)
+	if err != nil {
+		t.Fatalf("load archive: %v", err)
+	}
+	gr, err := gzip.NewReader(rc)
+	if err != nil {
+		t.Fatalf("open gzip: %v", err)
+	}
+	scanner := bufio.NewScanner(gr)
+	lines := 0
+	for scanner.Scan() {
+		var entry struct {
+			Seq    int64  `json:"seq"`
+			Stream string `json:"stream"`
+			Line   string `json:"line"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("decode archive line: %v", err)
+		}
+		lines++
+		if entry.Seq != int64(lines) || entry.Line != fmt.Sprintf("line %d", lines) {
+			t.Fatalf("unexpected archive line %d: %+v", lines, entry)
+		}
+	}
+	rc.Close()
+	if lines != 12 {
+		t.Fatalf("expected 12 archived lines, got %d", lines)
+	}
+
+	// Archived logs read back through ReadArchive with their timestamps.
+	rc, err = objStore.Load(key)
+	if err != nil {
+		t.Fatalf("load archive: %v", err)
+	}
+	defer rc.Close()
+	restored, err := logretention.ReadArchive(rc)
+	if err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+	if len(restored) != 12 || restored[2].Stream != "stderr" || !restored[0].LoggedAt.Equal(time.UnixMilli(finished.UnixMilli())) {
+		t.Fatalf("unexpected restored logs: %d entries, first %+v", len(restored), restored[0])
+	}
+
+	// A second pass finds nothing new to archive or purge.
+	res, err = purger.Run(ctx, time.Now())
+	if err != nil || res.Archived != 0 || res.Purged != 0 {
+		t.Fatalf("expected no-op second pass, got %+v (%v)", res, err)
+	}
+}
+
+func TestRunPurgesOldAuditEntries(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-audit-retention")
+	now := time.Now()
+	for _, age := range []time.Duration{100 * 24 * time.Hour, 95 * 24 * time.Hour, time.Hour} {
+		e := &store.AuditEntry{TeamID: team.ID, Action: "app.create", ResourceType: "app", ResourceID: "a", CreatedAt: now.Add(-age)}
+		if err := s.InsertAuditEntry(ctx, e); err != nil {
+			t.Fatalf("insert audit entry: %v", err)
+		}
+	}
+
+	purger := logretention.New(s, nil, logretention.Options{AuditRetentionDays: 90})
+	if !purger.Enabled() {
+		t.Fatal("expected audit retention alone to enable the purger")
+	}
+	res, err := purger.Run(ctx, now)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if res.AuditPurged != 2 || res.Purged != 0 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	entries, err := s.ListAuditEntries(ctx, team.ID, store.AuditFilter{Limit: 10})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected 1 entry left, got %d (%v)", len(entries), err)
+	}
+}
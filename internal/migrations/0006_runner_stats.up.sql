@@ -0,0 +1,2 @@
+ALTER TABLE runners ADD COLUMN stats_json TEXT;
+ALTER TABLE runners ADD COLUMN stats_at INTEGER;
@@ -0,0 +1,19 @@
+-- Indexes for the lease hot path and run listings on large histories.
+--
+-- runs_status_queue_idx matches LeaseRun's ORDER BY after the status filter,
+-- so the pick walks queued runs in priority order and stops at the first one
+-- in the runner's environment instead of sorting the whole queue.
+-- environment_id and cancel_requested are trailing columns so that walk never
+-- touches the table.
+--
+-- run_attempts(run_id, attempt_no) and run_logs(run_attempt_id, seq) are
+-- already served by the UNIQUE constraints in 0001, so they are not repeated.
+
+CREATE INDEX IF NOT EXISTS runs_status_queue_idx
+  ON runs(status, priority DESC, queued_at ASC, id ASC, environment_id, cancel_requested);
+
+CREATE INDEX IF NOT EXISTS runs_team_queued_idx
+  ON runs(team_id, queued_at);
+
+CREATE INDEX IF NOT EXISTS run_attempts_runner_status_idx
+  ON run_attempts(runner_id, status);
@@ -0,0 +1,5 @@
+-- run_trace_id correlates a run across server and runner logs. It is
+-- generated when the run is created; existing runs get a random one here.
+ALTER TABLE runs ADD COLUMN run_trace_id TEXT NOT NULL DEFAULT '';
+
+UPDATE runs SET run_trace_id = lower(hex(randomblob(16))) WHERE run_trace_id = '';
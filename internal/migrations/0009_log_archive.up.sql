@@ -0,0 +1,3 @@
+-- logs_archive_key points at a gzip object holding an attempt's logs after
+-- the retention job has moved them out of run_logs.
+ALTER TABLE run_attempts ADD COLUMN logs_archive_key TEXT;
@@ -0,0 +1,3 @@
+-- setup_script is the optional Towerfile [app] setup script the runner
+-- executes in the workspace before the entrypoint.
+ALTER TABLE app_versions ADD COLUMN setup_script TEXT;
@@ -0,0 +1,4 @@
+-- artifact_size_bytes is recorded at upload time. SQL cannot see the object
+-- store, so versions uploaded before this migration start out NULL and are
+-- backfilled from the stored objects when the server starts.
+ALTER TABLE app_versions ADD COLUMN artifact_size_bytes INTEGER;
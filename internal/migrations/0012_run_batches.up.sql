@@ -0,0 +1,7 @@
+-- batch_id groups runs submitted together through the batch endpoint. Runs
+-- created one at a time keep it NULL, so the partial index only covers
+-- batch members.
+ALTER TABLE runs ADD COLUMN batch_id TEXT;
+
+CREATE INDEX IF NOT EXISTS runs_team_batch_idx
+  ON runs(team_id, batch_id) WHERE batch_id IS NOT NULL;
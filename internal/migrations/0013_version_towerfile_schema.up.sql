@@ -0,0 +1,4 @@
+-- towerfile_schema_version records the Towerfile schema_version a version was
+-- deployed with. Versions uploaded before this migration predate the field
+-- and are schema version 1.
+ALTER TABLE app_versions ADD COLUMN towerfile_schema_version INTEGER NOT NULL DEFAULT 1;
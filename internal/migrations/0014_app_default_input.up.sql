@@ -0,0 +1,3 @@
+-- default_input_json holds an app's default run input, merged under the
+-- request input when a run is created. NULL means the app has no defaults.
+ALTER TABLE apps ADD COLUMN default_input_json TEXT;
@@ -0,0 +1,16 @@
+-- run_outputs records the files a run uploaded from its outputs directory.
+-- Names are unique per run; a later attempt uploading the same name replaces
+-- the earlier file.
+CREATE TABLE IF NOT EXISTS run_outputs (
+  id INTEGER PRIMARY KEY,
+  run_id INTEGER NOT NULL,
+  run_attempt_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  size_bytes INTEGER NOT NULL,
+  sha256 TEXT NOT NULL,
+  object_key TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  UNIQUE(run_id, name),
+  FOREIGN KEY(run_id) REFERENCES runs(id),
+  FOREIGN KEY(run_attempt_id) REFERENCES run_attempts(id)
+);
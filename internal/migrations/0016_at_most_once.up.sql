@@ -0,0 +1,5 @@
+-- at_most_once marks runs the reaper never re-queues once an attempt has
+-- started the process. A version's flag comes from the Towerfile's
+-- app.at_most_once and is the default for runs created from it.
+ALTER TABLE runs ADD COLUMN at_most_once INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE app_versions ADD COLUMN at_most_once INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,16 @@
+-- version_labels names one version of an app, e.g. "stable". A label is
+-- unique per app and moves when it is set on another version. Deleting a
+-- version is refused while a label points at it.
+CREATE TABLE IF NOT EXISTS version_labels (
+  app_id INTEGER NOT NULL,
+  label TEXT NOT NULL,
+  app_version_id INTEGER NOT NULL,
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL,
+  PRIMARY KEY(app_id, label),
+  FOREIGN KEY(app_id) REFERENCES apps(id),
+  FOREIGN KEY(app_version_id) REFERENCES app_versions(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX IF NOT EXISTS version_labels_version_idx
+  ON version_labels(app_version_id);
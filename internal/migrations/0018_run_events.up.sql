@@ -0,0 +1,14 @@
+-- run_events is an append-only timeline of a run's state transitions, for
+-- debugging. Rows are never updated; the store stops appending once a run
+-- has its cap of events, except for the final "finished" event.
+CREATE TABLE IF NOT EXISTS run_events (
+  id INTEGER PRIMARY KEY,
+  run_id INTEGER NOT NULL,
+  kind TEXT NOT NULL,
+  details_json TEXT,
+  created_at INTEGER NOT NULL,
+  FOREIGN KEY(run_id) REFERENCES runs(id)
+);
+
+CREATE INDEX IF NOT EXISTS run_events_run_idx
+  ON run_events(run_id, id);
@@ -0,0 +1,16 @@
+-- audit_log records who changed what through the team API. Rows are written
+-- best-effort after the change succeeds and are only removed by retention.
+CREATE TABLE IF NOT EXISTS audit_log (
+  id INTEGER PRIMARY KEY,
+  team_id INTEGER NOT NULL,
+  token_id INTEGER,
+  action TEXT NOT NULL,
+  resource_type TEXT NOT NULL,
+  resource_id TEXT NOT NULL,
+  details_json TEXT,
+  created_at INTEGER NOT NULL,
+  FOREIGN KEY(team_id) REFERENCES teams(id)
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_team_created_idx
+  ON audit_log(team_id, created_at);
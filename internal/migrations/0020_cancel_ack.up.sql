@@ -0,0 +1,4 @@
+-- cancel_ack_at is when a heartbeat first told the attempt's runner that its
+-- run was cancelled. The reaper finalizes attempts that stay cancelling long
+-- after it.
+ALTER TABLE run_attempts ADD COLUMN cancel_ack_at INTEGER;
@@ -0,0 +1,5 @@
+-- commands_json holds a version's named commands from the Towerfile's
+-- [[commands]] array: name, script, timeout and parameter schema. runs.command
+-- names the command a run executes; NULL runs the version's entrypoint.
+ALTER TABLE app_versions ADD COLUMN commands_json TEXT;
+ALTER TABLE runs ADD COLUMN command TEXT;
@@ -0,0 +1,7 @@
+-- max_active_runs caps a team's non-terminal runs (queued, leased, running
+-- and cancelling); NULL means unlimited. Run creation counts those runs per
+-- team, so runs_team_status_idx keeps that count off the table.
+ALTER TABLE teams ADD COLUMN max_active_runs INTEGER;
+
+CREATE INDEX IF NOT EXISTS runs_team_status_idx
+  ON runs(team_id, status);
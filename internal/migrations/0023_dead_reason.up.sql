@@ -0,0 +1,4 @@
+-- dead_reason records why a run ended dead: max_retries_exceeded,
+-- lease_expired_no_retry, at_most_once_violation or artifact_unavailable.
+-- It stays NULL for runs in any other status.
+ALTER TABLE runs ADD COLUMN dead_reason TEXT;
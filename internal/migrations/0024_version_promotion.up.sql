@@ -0,0 +1,5 @@
+-- promoted_from_app and promoted_from_version_no record the version a
+-- promoted version was copied from. The slug is stored rather than an ID so
+-- the lineage survives manual cleanup of the source app's rows.
+ALTER TABLE app_versions ADD COLUMN promoted_from_app TEXT;
+ALTER TABLE app_versions ADD COLUMN promoted_from_version_no INTEGER;
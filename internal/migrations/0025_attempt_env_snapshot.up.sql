@@ -0,0 +1,6 @@
+-- env_snapshot_json holds the environment variables minitower set for an
+-- attempt's process, as the runner reported them when starting it.
+-- env_snapshot_note says why an attempt has no snapshot, such as one that
+-- was over the size limit.
+ALTER TABLE run_attempts ADD COLUMN env_snapshot_json TEXT;
+ALTER TABLE run_attempts ADD COLUMN env_snapshot_note TEXT;
@@ -0,0 +1,4 @@
+-- rerun_of_run_id records the run a rerun was created from. A rerun of a
+-- rerun points at its immediate source, so following the column walks the
+-- lineage back to the first run.
+ALTER TABLE runs ADD COLUMN rerun_of_run_id INTEGER REFERENCES runs(id);
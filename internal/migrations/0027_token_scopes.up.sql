@@ -0,0 +1,5 @@
+-- scopes_json holds a JSON array of the scopes a token is limited to, such
+-- as ["runs:create"]; NULL is an unrestricted token. app_id further limits a
+-- scoped token to one app's runs.
+ALTER TABLE team_tokens ADD COLUMN scopes_json TEXT;
+ALTER TABLE team_tokens ADD COLUMN app_id INTEGER REFERENCES apps(id);
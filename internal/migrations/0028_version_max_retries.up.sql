@@ -0,0 +1,3 @@
+-- max_retries is the Towerfile's [app.retries] max, the default max_retries
+-- of runs created from the version without one; NULL means 0.
+ALTER TABLE app_versions ADD COLUMN max_retries INTEGER;
@@ -0,0 +1,4 @@
+-- artifact_corrupt is set when object verification found the version's
+-- artifact missing or not matching artifact_sha256; runs cannot be created
+-- from such a version until a later verification finds it intact.
+ALTER TABLE app_versions ADD COLUMN artifact_corrupt INTEGER NOT NULL DEFAULT 0;
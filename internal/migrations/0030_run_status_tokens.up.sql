@@ -0,0 +1,9 @@
+-- run_status_tokens holds the hashes of per-run read-only status tokens,
+-- which let a caller without an API token read one run's status. A token
+-- stops working once its run has been terminal for 7 days.
+CREATE TABLE IF NOT EXISTS run_status_tokens (
+  token_hash TEXT PRIMARY KEY,
+  run_id INTEGER NOT NULL,
+  created_at INTEGER NOT NULL,
+  FOREIGN KEY(run_id) REFERENCES runs(id)
+);
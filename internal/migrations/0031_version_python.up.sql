@@ -0,0 +1,4 @@
+-- python_version is the Towerfile's app.python, the interpreter version
+-- ("3.12") a runner must declare to lease the version's runs; NULL means
+-- any runner.
+ALTER TABLE app_versions ADD COLUMN python_version TEXT;
@@ -0,0 +1,6 @@
+-- failure_kind says whose fault a failed or dead run was: "infrastructure"
+-- for failures of the runner or server (workspace, download, venv,
+-- requirements, start, lease expiry), "user" for the app's own (a non-zero
+-- exit, a timeout, a bad input or artifact). NULL for other runs and for
+-- results from runners that do not classify their failures.
+ALTER TABLE runs ADD COLUMN failure_kind TEXT;
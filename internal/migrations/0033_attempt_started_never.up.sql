@@ -0,0 +1,4 @@
+-- started_never marks an attempt the reaper expired because its runner never
+-- called start within the start deadline. Its run was re-queued without
+-- using a retry.
+ALTER TABLE run_attempts ADD COLUMN started_never INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- input_blob holds a run's input as gzip-compressed JSON when the input is
+-- over the compression threshold; input_json is NULL for those runs.
+ALTER TABLE runs ADD COLUMN input_blob BLOB;
@@ -0,0 +1,3 @@
+-- input_keys_json holds the top-level scalar entries of a compressed input,
+-- so input_contains can match runs whose input_json is NULL.
+ALTER TABLE runs ADD COLUMN input_keys_json TEXT;
@@ -72,3 +72,12 @@ func (s *LocalStore) Exists(key string) (bool, error) {
 	}
 	return true, nil
 }
+
+// Size returns the size of an object in bytes.
+func (s *LocalStore) Size(key string) (int64, error) {
+	info, err := os.Stat(filepath.Join(s.dir, key))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,92 @@
+// Package queue wakes lease requests that are waiting for work, so idle
+// runners can long-poll instead of polling on a short interval.
+package queue
+
+import "sync"
+
+// Notifier signals waiters when runs are queued for an environment. A waiter
+// subscribes before it checks the queue, so a run queued between the check
+// and the wait is never missed.
+type Notifier struct {
+	mu         sync.Mutex
+	maxWaiters int
+	waiters    int
+	ready      map[string]chan struct{} // Closed by the next Notify for the environment.
+	closed     bool
+}
+
+// NewNotifier returns a Notifier that admits at most maxWaiters concurrent
+// subscriptions.
+func NewNotifier(maxWaiters int) *Notifier {
+	return &Notifier{
+		maxWaiters: maxWaiters,
+		ready:      make(map[string]chan struct{}),
+	}
+}
+
+// Subscribe returns a channel closed the next time a run is queued for
+// environment, and a release func the caller must call once done waiting.
+// It returns ok false when the waiter cap is reached or the notifier has been
+// closed; the caller should then answer without waiting.
+func (n *Notifier) Subscribe(environment string) (ready <-chan struct{}, release func(), ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed || n.waiters >= n.maxWaiters {
+		return nil, nil, false
+	}
+	ch, exists := n.ready[environment]
+	if !exists {
+		ch = make(chan struct{})
+		n.ready[environment] = ch
+	}
+	n.waiters++
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			n.mu.Lock()
+			n.waiters--
+			n.mu.Unlock()
+		})
+	}, true
+}
+
+// Notify wakes every waiter for environment.
+func (n *Notifier) Notify(environment string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if ch, ok := n.ready[environment]; ok {
+		close(ch)
+		delete(n.ready, environment)
+	}
+}
+
+// NotifyAll wakes every waiter, for callers that re-queue runs without
+// knowing their environments.
+func (n *Notifier) NotifyAll() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.wakeAllLocked()
+}
+
+// Close wakes every waiter and refuses further subscriptions. It is called
+// when the server starts shutting down so held requests do not delay it.
+func (n *Notifier) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	n.wakeAllLocked()
+}
+
+func (n *Notifier) wakeAllLocked() {
+	for env, ch := range n.ready {
+		close(ch)
+		delete(n.ready, env)
+	}
+}
+
+// Waiters returns the number of current subscriptions.
+func (n *Notifier) Waiters() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.waiters
+}
@@ -0,0 +1,48 @@
+package queue
+
+import "testing"
+
+func TestNotifierWakesEnvironmentWaiters(t *testing.T) {
+	n := NewNotifier(2)
+
+	prod, releaseProd, ok := n.Subscribe("prod")
+	if !ok {
+		t.Fatal("expected subscription")
+	}
+	staging, releaseStaging, ok := n.Subscribe("staging")
+	if !ok {
+		t.Fatal("expected subscription")
+	}
+	if _, _, ok := n.Subscribe("prod"); ok {
+		t.Fatal("expected subscription refused at the waiter cap")
+	}
+
+	n.Notify("prod")
+	select {
+	case <-prod:
+	default:
+		t.Fatal("expected prod waiter woken")
+	}
+	select {
+	case <-staging:
+		t.Fatal("expected staging waiter left waiting")
+	default:
+	}
+
+	releaseProd()
+	releaseProd()
+	if n.Waiters() != 1 {
+		t.Fatalf("expected release to be idempotent, have %d waiters", n.Waiters())
+	}
+
+	n.Close()
+	select {
+	case <-staging:
+	default:
+		t.Fatal("expected close to wake remaining waiters")
+	}
+	releaseStaging()
+	if _, _, ok := n.Subscribe("prod"); ok {
+		t.Fatal("expected no subscriptions after close")
+	}
+}
@@ -0,0 +1,194 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	artifactCacheDirName        = "artifact-cache"
+	defaultArtifactCacheMaxSize = 2 * 1024 * 1024 * 1024
+	artifactCacheSuffix         = ".tar.gz"
+)
+
+// artifactCache keeps downloaded artifacts keyed by sha256 so repeated runs
+// of a version skip the download. Entries are verified against their key
+// before use; the least recently used ones are evicted past maxBytes.
+type artifactCache struct {
+	dir      string
+	maxBytes int64
+}
+
+func newArtifactCache(dir string, maxBytes int64) *artifactCache {
+	return &artifactCache{dir: dir, maxBytes: maxBytes}
+}
+
+// validSHA256 reports whether s is a lowercase hex sha256, and so safe to use
+// as a file name.
+func validSHA256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+func (c *artifactCache) path(sha string) string {
+	return filepath.Join(c.dir, sha+artifactCacheSuffix)
+}
+
+// restore places the cached artifact for sha at dest, hard-linking when it
+// can and copying otherwise, and reports whether it did. An entry whose
+// content no longer matches sha is removed and treated as a miss.
+func (c *artifactCache) restore(sha, dest string) (bool, error) {
+	if !validSHA256(sha) {
+		return false, nil
+	}
+	src := c.path(sha)
+	if _, err := os.Stat(src); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := linkOrCopy(src, dest); err != nil {
+		return false, err
+	}
+	actual, err := fileSHA256(dest)
+	if err != nil {
+		os.Remove(dest)
+		return false, err
+	}
+	if actual != sha {
+		os.Remove(dest)
+		os.Remove(src)
+		return false, nil
+	}
+	now := time.Now()
+	_ = os.Chtimes(src, now, now)
+	return true, nil
+}
+
+// store adds the artifact at src under sha, then evicts old entries. The
+// entry is written under a temporary name and renamed into place, so a crash
+// never leaves a partial entry. Artifacts larger than the cache are skipped.
+func (c *artifactCache) store(sha, src string) error {
+	if !validSHA256(sha) {
+		return fmt.Errorf("invalid artifact sha256 %q", sha)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.Size() > c.maxBytes {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	os.Remove(tmpPath)
+	if err := linkOrCopy(src, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, c.path(sha)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return c.evict()
+}
+
+// evict removes the least recently used entries, and any temporary files
+// left by a crash, until the cache fits in maxBytes.
+func (c *artifactCache) evict() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		p := filepath.Join(c.dir, e.Name())
+		if !strings.HasSuffix(e.Name(), artifactCacheSuffix) {
+			if strings.HasPrefix(e.Name(), "tmp-") {
+				os.Remove(p)
+			}
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cached{path: p, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		total -= f.size
+	}
+	return nil
+}
+
+// linkOrCopy makes dest a hard link to src, falling back to a copy when the
+// two are on different filesystems or links are unsupported.
+func linkOrCopy(src, dest string) error {
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	return out.Close()
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArtifactCacheSkipsRepeatDownloads(t *testing.T) {
+	fake := &fakeRunServer{artifact: tarGz(t, map[string]string{"main.sh": "true\n"})}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	sum := sha256.Sum256(fake.artifact)
+	sha := hex.EncodeToString(sum[:])
+
+	dataDir := t.TempDir()
+	r := NewRunner(&Config{
+		ServerURL:             srv.URL,
+		DataDir:               dataDir,
+		WorkDir:               filepath.Join(dataDir, workDirName),
+		KillGracePeriod:       time.Second,
+		SetupTimeout:          10 * time.Second,
+		ArtifactCacheMaxBytes: defaultArtifactCacheMaxSize,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+		t.Fatalf("create work dir: %v", err)
+	}
+
+	execute := func(runID int64) string {
+		t.Helper()
+		fake.mu.Lock()
+		fake.lines, fake.result = nil, nil
+		fake.mu.Unlock()
+		lease := &LeaseResponse{RunID: runID, LeaseToken: "lease", Entrypoint: "main.sh", ArtifactSHA256: sha}
+		if err := r.executeRun(context.Background(), lease); err != nil {
+			t.Fatalf("execute run %d: %v", runID, err)
+		}
+		if fake.result["status"] != "completed" {
+			t.Fatalf("run %d result = %v, want completed", runID, fake.result)
+		}
+		return strings.Join(fake.lines, "\n")
+	}
+
+	if logs := execute(1); !strings.Contains(logs, "artifact cache miss") {
+		t.Fatalf("expected a cache miss on the first run, got:\n%s", logs)
+	}
+	if logs := execute(2); !strings.Contains(logs, "artifact cache hit") {
+		t.Fatalf("expected a cache hit on the second run, got:\n%s", logs)
+	}
+	if fake.artifactRequests != 1 {
+		t.Fatalf("artifact requests = %d, want 1", fake.artifactRequests)
+	}
+
+	// A corrupted entry is discarded and the artifact downloaded again.
+	if err := os.WriteFile(filepath.Join(dataDir, artifactCacheDirName, sha+artifactCacheSuffix), []byte("corrupt"), 0o600); err != nil {
+		t.Fatalf("corrupt cache entry: %v", err)
+	}
+	if logs := execute(3); !strings.Contains(logs, "artifact cache miss") {
+		t.Fatalf("expected a corrupted entry to miss, got:\n%s", logs)
+	}
+	if fake.artifactRequests != 2 {
+		t.Fatalf("artifact requests = %d, want 2", fake.artifactRequests)
+	}
+}
+
+func TestArtifactCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	c := newArtifactCache(filepath.Join(dir, "cache"), 10)
+
+	put := func(content string, age time.Duration) string {
+		t.Helper()
+		sum := sha256.Sum256([]byte(content))
+		sha := hex.EncodeToString(sum[:])
+		src := filepath.Join(dir, sha)
+		if err := os.WriteFile(src, []byte(content), 0o600); err != nil {
+			t.Fatalf("write artifact: %v", err)
+		}
+		if err := c.store(sha, src); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+		if age > 0 {
+			mtime := time.Now().Add(-age)
+			if err := os.Chtimes(c.path(sha), mtime, mtime); err != nil {
+				t.Fatalf("chtimes: %v", err)
+			}
+		}
+		return sha
+	}
+	exists := func(sha string) bool {
+		_, err := os.Stat(c.path(sha))
+		return err == nil
+	}
+
+	oldest := put("aaaa", 3*time.Hour)
+	used := put("bbbb", 2*time.Hour)
+	if ok, err := c.restore(used, filepath.Join(dir, "restored")); !ok || err != nil {
+		t.Fatalf("restore = %v, %v; want hit", ok, err)
+	}
+	newest := put("cccc", 0)
+	if exists(oldest) {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+	if !exists(used) || !exists(newest) {
+		t.Fatal("expected recently used entries to be kept")
+	}
+
+	tooBig := put("0123456789abcdef", 0)
+	if exists(tooBig) {
+		t.Fatal("expected an artifact larger than the cache to be skipped")
+	}
+}
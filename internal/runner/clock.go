@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// clockSkewSamples is how many recent offset samples the estimate uses.
+	clockSkewSamples = 8
+	// clockSkewWarnThreshold is the offset above which the runner warns.
+	clockSkewWarnThreshold = 2 * time.Second
+)
+
+// clockSkew estimates how far the server clock is ahead of the runner clock.
+// Lease expiries come from the server, so they are shifted by this offset
+// before being compared against time.Now(). The estimate is the median of
+// recent samples so a single slow response cannot swing it. A nil *clockSkew
+// reports no offset.
+type clockSkew struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	warned  bool
+}
+
+func newClockSkew() *clockSkew {
+	return &clockSkew{}
+}
+
+// observe records a sample from a response carrying serverTime. The server
+// read its clock somewhere between sent and received, so the midpoint is
+// the best local estimate of that instant. It reports the updated offset and
+// whether the offset just crossed clockSkewWarnThreshold.
+func (c *clockSkew) observe(serverTime, sent, received time.Time) (offset time.Duration, crossed bool) {
+	if c == nil || serverTime.IsZero() {
+		return 0, false
+	}
+	midpoint := sent.Add(received.Sub(sent) / 2)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples = append(c.samples, serverTime.Sub(midpoint))
+	if len(c.samples) > clockSkewSamples {
+		c.samples = c.samples[len(c.samples)-clockSkewSamples:]
+	}
+	offset = c.medianLocked()
+
+	over := offset > clockSkewWarnThreshold || offset < -clockSkewWarnThreshold
+	crossed = over && !c.warned
+	c.warned = over
+	return offset, crossed
+}
+
+// offset returns the smoothed server-minus-runner offset and whether any
+// sample has been recorded.
+func (c *clockSkew) offset() (time.Duration, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) == 0 {
+		return 0, false
+	}
+	return c.medianLocked(), true
+}
+
+// toLocal converts a server timestamp to the runner's clock.
+func (c *clockSkew) toLocal(serverTime time.Time) time.Time {
+	offset, _ := c.offset()
+	return serverTime.Add(-offset)
+}
+
+// serverNow estimates the server's current time.
+func (c *clockSkew) serverNow() time.Time {
+	offset, _ := c.offset()
+	return time.Now().Add(offset)
+}
+
+func (c *clockSkew) medianLocked() time.Duration {
+	sorted := append([]time.Duration(nil), c.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// timestampLayout is the layout the server uses for timestamps, UTC with
+// millisecond precision. The runner sends logged_at in the same layout.
+const timestampLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// parseServerTime parses a timestamp from the server. Older servers send
+// second precision, so the fractional seconds are optional.
+func parseServerTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// observeServerTime feeds a server_time value from a start or heartbeat
+// response into the skew estimate.
+func (r *Runner) observeServerTime(serverTime string, sent, received time.Time) {
+	t, err := parseServerTime(serverTime)
+	if err != nil {
+		return
+	}
+	if offset, crossed := r.clock.observe(t, sent, received); crossed {
+		r.logger.Warn("clock skew between runner and server exceeds threshold",
+			"clock_skew_seconds", offset.Seconds(), "threshold", clockSkewWarnThreshold.String())
+	}
+}
+
+// measureClockSkew takes an initial sample from the server's Date header so
+// the startup log can report the skew before any run is leased. The header
+// has one-second resolution, so the sample is centred within that second.
+func (r *Runner) measureClockSkew(ctx context.Context) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.cfg.ServerURL+"/health", nil)
+	if err != nil {
+		return
+	}
+	sent := time.Now()
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		r.logger.Warn("clock skew probe failed", "error", err)
+		return
+	}
+	received := time.Now()
+	resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	r.observeServerTime(date.Add(500*time.Millisecond).Format(time.RFC3339Nano), sent, received)
+}
+
+// leaseExpired reports whether a lease that expires at serverExpiry (server
+// clock) should be treated as expired at local time now, keeping leaseSkew
+// in reserve.
+func (r *Runner) leaseExpired(serverExpiry, now time.Time) bool {
+	return now.After(r.clock.toLocal(serverExpiry).Add(-leaseSkew))
+}
+
+// heartbeatInterval spaces heartbeats at a third of the lease time left.
+func (r *Runner) heartbeatInterval(serverExpiry, now time.Time) time.Duration {
+	remaining := r.clock.toLocal(serverExpiry).Add(-leaseSkew).Sub(now)
+	if remaining <= 0 {
+		return minHeartbeatInterval
+	}
+	return max(remaining/3, minHeartbeatInterval)
+}
@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// feedSkew records n samples with the server clock ahead of local by offset.
+func feedSkew(c *clockSkew, local time.Time, offset time.Duration, n int) {
+	for i := 0; i < n; i++ {
+		sent := local.Add(time.Duration(i) * time.Second)
+		received := sent.Add(100 * time.Millisecond)
+		c.observe(sent.Add(50*time.Millisecond).Add(offset), sent, received)
+	}
+}
+
+func TestClockSkewMedianIgnoresOutliers(t *testing.T) {
+	c := newClockSkew()
+	local := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	feedSkew(c, local, 3*time.Second, 4)
+	feedSkew(c, local, 90*time.Second, 1)
+
+	got, ok := c.offset()
+	if !ok || got != 3*time.Second {
+		t.Fatalf("expected 3s offset, got %v (ok=%v)", got, ok)
+	}
+
+	// Old samples age out once the window is full.
+	feedSkew(c, local, -time.Second, clockSkewSamples)
+	if got, _ := c.offset(); got != -time.Second {
+		t.Fatalf("expected -1s offset after window refill, got %v", got)
+	}
+}
+
+func TestClockSkewWarnsOnceWhenCrossingThreshold(t *testing.T) {
+	c := newClockSkew()
+	local := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, crossed := c.observe(local.Add(time.Second), local, local); crossed {
+		t.Fatal("1s offset should not cross the threshold")
+	}
+	crossings := 0
+	for i := 0; i < clockSkewSamples; i++ {
+		if _, crossed := c.observe(local.Add(10*time.Second), local, local); crossed {
+			crossings++
+		}
+	}
+	if crossings != 1 {
+		t.Fatalf("expected one crossing, got %d", crossings)
+	}
+}
+
+func TestLeaseExpiryCorrectsForSkew(t *testing.T) {
+	serverNow := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	serverExpiry := serverNow.Add(20 * time.Second)
+
+	t.Run("runner clock ahead", func(t *testing.T) {
+		// Uncorrected, a runner 30s ahead would self-fence a lease that still
+		// has 20s left on the server.
+		localNow := serverNow.Add(30 * time.Second)
+		uncorrected := &Runner{}
+		if !uncorrected.leaseExpired(serverExpiry, localNow) {
+			t.Fatal("expected uncorrected runner to fence early")
+		}
+
+		r := &Runner{clock: newClockSkew()}
+		feedSkew(r.clock, localNow, -30*time.Second, 3)
+		if r.leaseExpired(serverExpiry, localNow) {
+			t.Fatal("lease with 20s left on the server should not be expired")
+		}
+		if got := r.heartbeatInterval(serverExpiry, localNow); got != 5*time.Second {
+			t.Fatalf("expected 5s heartbeat interval, got %v", got)
+		}
+		if !r.leaseExpired(serverExpiry, localNow.Add(16*time.Second)) {
+			t.Fatal("expected lease to expire within leaseSkew of the server expiry")
+		}
+	})
+
+	t.Run("runner clock behind", func(t *testing.T) {
+		// Uncorrected, a runner 30s behind would keep working for 25s after
+		// the server has already fenced the lease.
+		localNow := serverNow.Add(-30 * time.Second)
+		r := &Runner{clock: newClockSkew()}
+		feedSkew(r.clock, localNow, 30*time.Second, 3)
+
+		late := localNow.Add(16 * time.Second)
+		if (&Runner{}).leaseExpired(serverExpiry, late) {
+			t.Fatal("expected uncorrected runner to keep the lease")
+		}
+		if !r.leaseExpired(serverExpiry, late) {
+			t.Fatal("expected corrected runner to fence within leaseSkew of the server expiry")
+		}
+		if r.leaseExpired(serverExpiry, localNow) {
+			t.Fatal("lease with 20s left on the server should not be expired")
+		}
+	})
+}
+
+func TestHeartbeatReportsAndUpdatesClockSkew(t *testing.T) {
+	var bodies []map[string]json.RawMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body := map[string]json.RawMessage{}
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				t.Errorf("decode heartbeat: %v", err)
+			}
+		}
+		bodies = append(bodies, body)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"lease_expires_at": "2030-01-01T00:00:00Z",
+			"server_time":      time.Now().Add(-10 * time.Second).Format(time.RFC3339Nano),
+		})
+	}))
+	defer srv.Close()
+
+	r := &Runner{
+		cfg:        &Config{ServerURL: srv.URL},
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		httpClient: srv.Client(),
+		clock:      newClockSkew(),
+	}
+	lease := &LeaseResponse{RunID: 1, LeaseToken: "lease"}
+	for i := 0; i < 2; i++ {
+		if _, err := r.heartbeat(context.Background(), lease); err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+	}
+
+	if _, ok := bodies[0]["clock_skew_seconds"]; ok {
+		t.Fatal("first heartbeat has no measurement to report")
+	}
+	var reported float64
+	if err := json.Unmarshal(bodies[1]["clock_skew_seconds"], &reported); err != nil {
+		t.Fatalf("second heartbeat clock_skew_seconds: %v", err)
+	}
+	if reported > -9 || reported < -11 {
+		t.Fatalf("expected about -10s skew, got %v", reported)
+	}
+}
+
+func TestParseServerTimeAcceptsBothPrecisions(t *testing.T) {
+	for in, want := range map[string]time.Time{
+		"2026-01-02T03:04:05Z":          time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		"2026-01-02T03:04:05.123Z":      time.Date(2026, 1, 2, 3, 4, 5, 123_000_000, time.UTC),
+		"2026-01-02T04:04:05.100+01:00": time.Date(2026, 1, 2, 3, 4, 5, 100_000_000, time.UTC),
+	} {
+		got, err := parseServerTime(in)
+		if err != nil || !got.Equal(want) {
+			t.Fatalf("parse %q: expected %v, got %v (%v)", in, want, got, err)
+		}
+	}
+	if got := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).Format(timestampLayout); got != "2026-01-02T03:04:05.000Z" {
+		t.Fatalf("expected logged_at with milliseconds, got %q", got)
+	}
+}
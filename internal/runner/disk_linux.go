@@ -0,0 +1,15 @@
+//go:build linux
+
+package runner
+
+import "syscall"
+
+// statfsFree returns the bytes available to unprivileged users on the
+// filesystem holding path.
+func statfsFree(path string) (int64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, err
+	}
+	return int64(fs.Bavail) * int64(fs.Bsize), nil
+}
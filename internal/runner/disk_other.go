@@ -0,0 +1,9 @@
+//go:build !linux
+
+package runner
+
+// statfsFree is not implemented off Linux; the disk space preflight is
+// skipped.
+func statfsFree(string) (int64, error) {
+	return 0, errDiskFreeUnsupported
+}
@@ -0,0 +1,322 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"minitower/internal/towerfile"
+	"minitower/internal/validate"
+)
+
+// Exit codes of the exec subcommand besides the process's own.
+const (
+	execExitSetup     = 1
+	execExitUsage     = 2
+	execExitTimeout   = 124
+	execExitInterrupt = 130
+)
+
+// execOptions configures a local exec run.
+type execOptions struct {
+	Dir   string
+	Input map[string]any
+	// Timeout overrides the Towerfile's timeout when set.
+	Timeout time.Duration
+}
+
+// RunExec implements "minitower-runner exec": it runs the Towerfile project
+// in --dir through the runner's packaging, workspace and process code, with
+// no server, lease or heartbeat, and returns the exit code to exit with.
+func RunExec(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("dir", ".", "project directory containing the Towerfile")
+	input := fs.String("input", "", "run input as a JSON object")
+	timeout := fs.Duration("timeout", 0, "run timeout (default: the Towerfile's, or 300s)")
+	if err := fs.Parse(args); err != nil {
+		return execExitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "exec: unexpected argument %q\n", fs.Arg(0))
+		return execExitUsage
+	}
+	if *timeout < 0 {
+		fmt.Fprintln(stderr, "exec: --timeout must be >= 0")
+		return execExitUsage
+	}
+	opts := execOptions{Dir: *dir, Timeout: *timeout}
+	if *input != "" {
+		if err := json.Unmarshal([]byte(*input), &opts.Input); err != nil {
+			fmt.Fprintf(stderr, "exec: --input must be a JSON object: %v\n", err)
+			return execExitUsage
+		}
+	}
+
+	cfg, err := localConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "exec: %v\n", err)
+		return execExitUsage
+	}
+	logger := slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	code, err := execProject(ctx, NewRunner(cfg, logger), opts, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "exec: %v\n", err)
+	}
+	return code
+}
+
+// localConfig is the configuration of a local exec run: the process
+// settings LoadConfig reads, without the server ones it requires.
+func localConfig() (*Config, error) {
+	defaults := DefaultConfig()
+	cfg := &Config{
+		PythonBin:       defaults.PythonBin,
+		KillGracePeriod: defaults.KillGracePeriod,
+		SetupTimeout:    defaults.SetupTimeout,
+	}
+	if err := loadProcessConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// execProject packages the project in opts.Dir as a deploy would, unpacks
+// it into a temporary workspace, builds the workspace and runs the
+// entrypoint, printing setup and process output to out. It returns the
+// process's exit code; err explains any other code.
+func execProject(ctx context.Context, r *Runner, opts execOptions, out io.Writer) (int, error) {
+	f, err := os.Open(filepath.Join(opts.Dir, "Towerfile"))
+	if err != nil {
+		return execExitSetup, fmt.Errorf("cannot open Towerfile: %w", err)
+	}
+	tf, err := towerfile.Parse(f)
+	f.Close()
+	if err != nil {
+		return execExitSetup, err
+	}
+	warnings, err := towerfile.Validate(tf)
+	for _, w := range warnings {
+		r.logger.Warn("towerfile warning", "warning", w)
+	}
+	if err != nil {
+		return execExitSetup, fmt.Errorf("validating Towerfile: %w", err)
+	}
+
+	lease := localLease(tf, opts)
+	// Without MINITOWER_PYTHON_BINS, app.python is ignored and the run uses
+	// MINITOWER_PYTHON_BIN, so a project runs locally on whatever Python
+	// there is.
+	if len(r.cfg.PythonBins) > 0 {
+		lease.PythonVersion = tf.App.Python
+	}
+	if err := validate.ValidateJSONInput(lease.Input, lease.ParamsSchema); err != nil {
+		return execExitSetup, fmt.Errorf("input does not match schema: %w", err)
+	}
+
+	artifact, sha256, err := towerfile.Package(opts.Dir, tf)
+	if err != nil {
+		return execExitSetup, fmt.Errorf("packaging artifact: %w", err)
+	}
+	workDir, err := os.MkdirTemp("", "minitower-exec-")
+	if err != nil {
+		return execExitSetup, fmt.Errorf("create workspace: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+	artifactPath := filepath.Join(workDir, "artifact.tar.gz")
+	if err := writeArtifact(artifactPath, artifact); err != nil {
+		return execExitSetup, err
+	}
+	if err := r.unpackArtifact(artifactPath, workDir); err != nil {
+		return execExitSetup, fmt.Errorf("failed to unpack artifact: %w", err)
+	}
+
+	timeout := time.Duration(*lease.TimeoutSeconds) * time.Second
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	state := newRunState(time.Time{}, timeout)
+	var cmd *exec.Cmd
+	processDone := make(chan struct{})
+	var killOnce sync.Once
+	terminate := func(string) {
+		if cmd == nil {
+			return
+		}
+		killOnce.Do(func() { r.stopProcess(cmd, processDone) })
+	}
+	lc := newLocalLogCollector(r, lease, state, out, terminate)
+	lc.logSetup(ctx, fmt.Sprintf("artifact unpacked (sha256: %s)", sha256))
+
+	ws, msg, err := r.buildWorkspace(ctx, lease, workDir, tf.App.ImportPaths, lc)
+	if err != nil {
+		lc.flushRemaining()
+		return execExitSetup, errors.New(msg)
+	}
+	if err := validateWorkspace(ws, lease); err != nil {
+		lc.flushRemaining()
+		return execExitSetup, err
+	}
+
+	cmd = r.entrypointCommand(lease, ws)
+	stdoutPipe, _ := cmd.StdoutPipe()
+	stderrPipe, _ := cmd.StderrPipe()
+	if err := cmd.Start(); err != nil {
+		return execExitSetup, fmt.Errorf("failed to start process: %w", err)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		timer := time.NewTimer(state.currentTimeout())
+		defer timer.Stop()
+		select {
+		case <-processDone:
+		case <-ctx.Done():
+			state.markCancel()
+			terminate("interrupted")
+		case <-timer.C:
+			state.markTimedOut()
+			terminate("timeout")
+		}
+	}()
+	logFlushDone := make(chan struct{})
+	go func() {
+		defer close(logFlushDone)
+		lc.periodicFlush(runCtx)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		lc.collect(runCtx, stdoutPipe, "stdout")
+	}()
+	go func() {
+		defer wg.Done()
+		lc.collect(runCtx, stderrPipe, "stderr")
+	}()
+
+	waitErr := cmd.Wait()
+	close(processDone)
+	wg.Wait()
+	cancel()
+	<-logFlushDone
+
+	if line := finalFailureLogLine(state, waitErr); line != "" {
+		lc.logSetup(context.Background(), line)
+	}
+	lc.flushRemaining()
+
+	_, wasCancelled, _, wasTimedOut := state.snapshot()
+	switch {
+	case wasTimedOut:
+		return execExitTimeout, nil
+	case wasCancelled:
+		return execExitInterrupt, nil
+	case waitErr == nil:
+		return 0, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(waitErr, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode(), nil
+	}
+	return execExitSetup, waitErr
+}
+
+// localLease describes a run of tf's app script with opts' input, merged
+// over the Towerfile's default input as the server merges it, in the shape
+// the workspace and process code take from a real lease. The timeout is the
+// Towerfile's; execProject applies opts.Timeout over it.
+func localLease(tf *towerfile.Towerfile, opts execOptions) *LeaseResponse {
+	timeout := int(defaultTimeout / time.Second)
+	if tf.App.Timeout != nil && tf.App.Timeout.Seconds > 0 {
+		timeout = tf.App.Timeout.Seconds
+	}
+	return &LeaseResponse{
+		AppSlug:        tf.App.Name,
+		Entrypoint:     tf.App.Script,
+		TimeoutSeconds: &timeout,
+		ParamsSchema:   towerfile.ParamsSchemaFromParameters(tf.Parameters),
+		Input:          mergeDefaultInput(tf.App.DefaultInput, opts.Input),
+		SetupScript:    tf.App.Setup,
+		ImportPaths:    tf.App.ImportPaths,
+	}
+}
+
+// mergeDefaultInput deep-merges input over defaults the way the server
+// applies an app's default input: nested objects merge key by key, other
+// values replace the default, and a null removes the defaulted key.
+func mergeDefaultInput(defaults, input map[string]any) map[string]any {
+	if len(defaults) == 0 {
+		return input
+	}
+	merged := make(map[string]any, len(defaults)+len(input))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range input {
+		def, defaulted := defaults[k]
+		defMap, defIsMap := def.(map[string]any)
+		inMap, inIsMap := v.(map[string]any)
+		switch {
+		case v == nil && defaulted:
+			delete(merged, k)
+		case defIsMap && inIsMap:
+			merged[k] = mergeDefaultInput(defMap, inMap)
+		default:
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func writeArtifact(path string, artifact io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("write artifact: %w", err)
+	}
+	if _, err := io.Copy(f, artifact); err != nil {
+		f.Close()
+		return fmt.Errorf("write artifact: %w", err)
+	}
+	return f.Close()
+}
+
+// newLocalLogCollector returns a log collector that prints each line to out
+// instead of sending it to a server.
+func newLocalLogCollector(r *Runner, lease *LeaseResponse, state *runState, out io.Writer, terminate func(string)) *logCollector {
+	var mu sync.Mutex
+	return &logCollector{
+		r:         r,
+		lease:     lease,
+		state:     state,
+		limiter:   newLogLimiter(r.cfg.MaxLogLinesPerSec, r.cfg.MaxLogLinesPerRun),
+		terminate: terminate,
+		deliver: func(_ context.Context, entries []logEntry) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, e := range entries {
+				if _, err := fmt.Fprintln(out, e.Line); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,243 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"minitower/internal/towerfile"
+)
+
+// requireExecPython skips unless the configured Python can create venvs.
+func requireExecPython(t *testing.T) {
+	t.Helper()
+	python := os.Getenv("MINITOWER_PYTHON_BIN")
+	if python == "" {
+		python = "python3"
+	}
+	if _, err := exec.LookPath(python); err != nil {
+		t.Skipf("python not found: %v", err)
+	}
+	if err := exec.Command(python, "-c", "import venv").Run(); err != nil {
+		t.Skipf("python venv unavailable: %v", err)
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skipf("tar not found: %v", err)
+	}
+}
+
+func writeProject(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestExecRunsPythonProjectLocally(t *testing.T) {
+	requireExecPython(t)
+	dir := writeProject(t, map[string]string{
+		"Towerfile": `schema_version = 2
+
+[app]
+name = "hello"
+script = "main.py"
+source = ["*.py", "lib/*.py"]
+import_paths = ["lib"]
+
+[app.default_input]
+greeting = "hi"
+
+[[parameters]]
+name = "x"
+type = "integer"
+`,
+		"main.py": `import json, os, sys
+import helper
+with open(os.environ["MINITOWER_INPUT_PATH"]) as f:
+    data = json.load(f)
+print(helper.shout(os.environ["greeting"]), data["x"])
+print("to stderr", file=sys.stderr)
+sys.exit(int(os.environ["x"]))
+`,
+		"lib/helper.py": `def shout(s):
+    return s.upper()
+`,
+	})
+
+	var stdout, stderr bytes.Buffer
+	code := RunExec([]string{"--dir", dir, "--input", `{"x": 3}`}, &stdout, &stderr)
+	if code != 3 {
+		t.Fatalf("expected the process's exit code 3, got %d (stderr: %s)", code, stderr.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{"artifact unpacked (sha256: ", "creating virtual environment at: .venv", "HI 3", "to stderr", "run failed: process exited with code 3"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output, got:\n%s", want, out)
+		}
+	}
+
+	stdout.Reset()
+	if code := RunExec([]string{"--dir", dir, "--input", `{"x": "three"}`}, &stdout, &stderr); code != execExitSetup {
+		t.Fatalf("expected input not matching the schema to fail setup, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "input does not match schema") {
+		t.Fatalf("expected a schema error, got %q", stderr.String())
+	}
+}
+
+func TestExecTimesOut(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell entrypoints need sh")
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skipf("tar not found: %v", err)
+	}
+	dir := writeProject(t, map[string]string{
+		"Towerfile": `[app]
+name = "slow"
+script = "main.sh"
+`,
+		"main.sh": "echo started\nsleep 30\n",
+	})
+
+	cfg, err := localConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.KillGracePeriod = time.Second
+	r := NewRunner(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var out bytes.Buffer
+	start := time.Now()
+	code, err := execProject(context.Background(), r, execOptions{Dir: dir, Timeout: 500 * time.Millisecond}, &out)
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if code != execExitTimeout {
+		t.Fatalf("expected exit code %d, got %d", execExitTimeout, code)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected the timeout to stop the process, took %s", elapsed)
+	}
+	if !strings.Contains(out.String(), "started") || !strings.Contains(out.String(), "run failed: timeout exceeded") {
+		t.Fatalf("expected the process output and timeout line, got:\n%s", out.String())
+	}
+}
+
+func TestExecPassesStructuredParametersAsJSON(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell entrypoints need sh")
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skipf("tar not found: %v", err)
+	}
+	dir := writeProject(t, map[string]string{
+		"Towerfile": `schema_version = 7
+
+[app]
+name = "structured"
+script = "main.sh"
+
+[[parameters]]
+name = "ids"
+type = "array"
+items_type = "integer"
+default = [3, 1, 2]
+
+[[parameters]]
+name = "options"
+type = "object"
+default = { depth = 2, tags = ["a", "b"] }
+
+[parameters.properties.depth]
+type = "integer"
+`,
+		"main.sh": "printf 'ids=%s\\noptions=%s\\n' \"$ids\" \"$options\" > \"$result_path\"\n",
+	})
+
+	// The server stores the params schema as JSON; take the defaults from
+	// that form, as a client reading the version's schema would.
+	f, err := os.Open(filepath.Join(dir, "Towerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := towerfile.Parse(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(towerfile.ParamsSchemaFromParameters(tf.Parameters))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]struct {
+			Default any `json:"default"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	// The script writes the variables to a file, so the check does not
+	// depend on log collection.
+	resultPath := filepath.Join(t.TempDir(), "env.txt")
+	input := map[string]any{"ids": schema.Properties["ids"].Default, "options": schema.Properties["options"].Default, "result_path": resultPath}
+
+	cfg, err := localConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRunner(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var out bytes.Buffer
+	code, err := execProject(context.Background(), r, execOptions{Dir: dir, Input: input}, &out)
+	if err != nil || code != 0 {
+		t.Fatalf("exec: code %d, %v\n%s", code, err, out.String())
+	}
+	env, err := os.ReadFile(resultPath)
+	if err != nil {
+		t.Fatalf("read result: %v", err)
+	}
+	if want := "ids=[3,1,2]\noptions={\"depth\":2,\"tags\":[\"a\",\"b\"]}\n"; string(env) != want {
+		t.Fatalf("expected the parameters as JSON env vars %q, got %q", want, env)
+	}
+
+	for _, bad := range []map[string]any{
+		{"ids": []any{1.0, "two"}},
+		{"options": map[string]any{"depth": "deep"}},
+		{"ids": "1,2"},
+	} {
+		code, err := execProject(context.Background(), r, execOptions{Dir: dir, Input: bad}, io.Discard)
+		if code != execExitSetup || err == nil || !strings.Contains(err.Error(), "input does not match schema") {
+			t.Fatalf("input %v: expected a schema error, got code %d, %v", bad, code, err)
+		}
+	}
+}
+
+func TestExecRejectsUsageErrors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := RunExec([]string{"--input", "[1]"}, &stdout, &stderr); code != execExitUsage {
+		t.Fatalf("expected a non-object input to be a usage error, got %d", code)
+	}
+	if code := RunExec([]string{"extra"}, &stdout, &stderr); code != execExitUsage {
+		t.Fatalf("expected a stray argument to be a usage error, got %d", code)
+	}
+	if code := RunExec([]string{"--dir", t.TempDir()}, &stdout, &stderr); code != execExitSetup {
+		t.Fatalf("expected a missing Towerfile to fail setup, got %d", code)
+	}
+}
@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFailedRunsReportFailureKind(t *testing.T) {
+	schema := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"n": map[string]any{"type": "integer"}},
+	}
+	for _, tc := range []struct {
+		name     string
+		artifact []byte
+		lease    LeaseResponse
+		want     string
+	}{
+		{
+			name:     "non-zero exit",
+			artifact: tarGz(t, map[string]string{"main.sh": "exit 3\n"}),
+			lease:    LeaseResponse{Entrypoint: "main.sh"},
+			want:     failureKindUser,
+		},
+		{
+			name:     "missing entrypoint",
+			artifact: tarGz(t, map[string]string{"app.sh": "true\n"}),
+			lease:    LeaseResponse{Entrypoint: "main.sh"},
+			want:     failureKindUser,
+		},
+		{
+			name:     "failing setup script",
+			artifact: tarGz(t, map[string]string{"setup.sh": "exit 2\n", "main.sh": "true\n"}),
+			lease:    LeaseResponse{Entrypoint: "main.sh", SetupScript: "setup.sh"},
+			want:     failureKindUser,
+		},
+		{
+			name:     "input the schema rejects",
+			artifact: tarGz(t, map[string]string{"main.sh": "true\n"}),
+			lease:    LeaseResponse{Entrypoint: "main.sh", ParamsSchema: schema, Input: map[string]any{"n": "x"}},
+			want:     failureKindUser,
+		},
+		{
+			name:     "broken artifact",
+			artifact: []byte("not a tarball"),
+			lease:    LeaseResponse{Entrypoint: "main.sh"},
+			want:     failureKindInfrastructure,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &fakeRunServer{artifact: tc.artifact}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			dataDir := t.TempDir()
+			r := NewRunner(&Config{
+				ServerURL:       srv.URL,
+				DataDir:         dataDir,
+				WorkDir:         filepath.Join(dataDir, workDirName),
+				KillGracePeriod: time.Second,
+				SetupTimeout:    10 * time.Second,
+			}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+				t.Fatalf("create work dir: %v", err)
+			}
+
+			lease := tc.lease
+			lease.RunID, lease.LeaseToken = 1, "lease"
+			_ = r.executeRun(context.Background(), &lease)
+			if fake.result["status"] != "failed" || fake.result["failure_kind"] != tc.want {
+				t.Fatalf("expected a failed result of kind %q, got %v", tc.want, fake.result)
+			}
+		})
+	}
+}
+
+func TestSubmitFinalResultFailureKind(t *testing.T) {
+	fake := &fakeRunServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	r := NewRunner(&Config{ServerURL: srv.URL, DataDir: t.TempDir()}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	lease := &LeaseResponse{RunID: 1, LeaseToken: "lease"}
+
+	exitErr := exec.Command("sh", "-c", "exit 4").Run()
+	if _, ok := exitErr.(*exec.ExitError); !ok {
+		t.Skipf("no shell to produce an exit error: %v", exitErr)
+	}
+	timedOut := newRunState(time.Now().Add(time.Minute), 0)
+	timedOut.markTimedOut()
+
+	for _, tc := range []struct {
+		name    string
+		state   *runState
+		waitErr error
+		want    any
+	}{
+		{"exit code", newRunState(time.Now().Add(time.Minute), 0), exitErr, failureKindUser},
+		{"timeout", timedOut, nil, failureKindUser},
+		{"wait error", newRunState(time.Now().Add(time.Minute), 0), errors.New("i/o error"), failureKindInfrastructure},
+		{"completed", newRunState(time.Now().Add(time.Minute), 0), nil, nil},
+	} {
+		fake.result = nil
+		if err := r.submitFinalResult(context.Background(), lease, tc.state, tc.waitErr); err != nil {
+			t.Fatalf("%s: submit: %v", tc.name, err)
+		}
+		if got := fake.result["failure_kind"]; got != tc.want {
+			t.Fatalf("%s: expected failure kind %v, got %v (result %v)", tc.name, tc.want, got, fake.result)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultMaxLogLinesPerSec = 500
+	defaultMaxLogLinesPerRun = 200_000
+	logRateWindow            = time.Second
+)
+
+// logLimiter caps how many lines a run logs, per second and in total, so a
+// script printing in a tight loop cannot flood the server. Lines past the
+// rate are counted and reported in one marker per window; lines past the
+// total are dropped after a final marker. A zero limit disables that check.
+type logLimiter struct {
+	perSec int
+	perRun int
+
+	windowStart time.Time
+	inWindow    int
+	dropped     int
+	total       int
+	capped      bool
+}
+
+func newLogLimiter(perSec, perRun int) *logLimiter {
+	return &logLimiter{perSec: perSec, perRun: perRun}
+}
+
+// admit reports whether a line seen at now is kept, and returns any
+// markers to log before it.
+func (l *logLimiter) admit(now time.Time) (markers []string, keep bool) {
+	if l.capped {
+		return nil, false
+	}
+	if marker := l.rollWindow(now); marker != "" {
+		markers = append(markers, marker)
+	}
+	if l.perSec > 0 && l.inWindow >= l.perSec {
+		l.dropped++
+		return markers, false
+	}
+	if l.perRun > 0 && l.total >= l.perRun {
+		l.capped = true
+		if marker := l.takeDropped(); marker != "" {
+			markers = append(markers, marker)
+		}
+		markers = append(markers, fmt.Sprintf("… log limit of %d lines reached; further output is not collected …", l.perRun))
+		return markers, false
+	}
+	l.inWindow++
+	l.total++
+	return markers, true
+}
+
+// rollWindow starts a new rate window once the current one has passed and
+// returns the marker for lines it dropped, if any.
+func (l *logLimiter) rollWindow(now time.Time) string {
+	if !l.windowStart.IsZero() && now.Sub(l.windowStart) < logRateWindow {
+		return ""
+	}
+	l.windowStart = now
+	l.inWindow = 0
+	return l.takeDropped()
+}
+
+// takeDropped returns the marker for lines dropped since the last one.
+func (l *logLimiter) takeDropped() string {
+	if l.dropped == 0 {
+		return ""
+	}
+	marker := fmt.Sprintf("… dropped %d lines due to rate limiting …", l.dropped)
+	l.dropped = 0
+	return marker
+}
@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogLimiterDropsPastRateWithOneMarkerPerWindow(t *testing.T) {
+	l := newLogLimiter(3, 0)
+	start := time.Now()
+	kept := 0
+	for i := range 10 {
+		markers, keep := l.admit(start.Add(time.Duration(i) * time.Millisecond))
+		if len(markers) != 0 {
+			t.Fatalf("expected no marker inside the first window, got %v", markers)
+		}
+		if keep {
+			kept++
+		}
+	}
+	if kept != 3 {
+		t.Fatalf("expected 3 lines kept in the window, got %d", kept)
+	}
+
+	markers, keep := l.admit(start.Add(logRateWindow))
+	if !keep || len(markers) != 1 || markers[0] != "… dropped 7 lines due to rate limiting …" {
+		t.Fatalf("expected the next window to report 7 dropped lines, got %v (keep=%v)", markers, keep)
+	}
+	if marker := l.takeDropped(); marker != "" {
+		t.Fatalf("expected nothing left to report, got %q", marker)
+	}
+}
+
+func TestLogLimiterStopsAtRunTotal(t *testing.T) {
+	l := newLogLimiter(0, 5)
+	now := time.Now()
+	for range 5 {
+		if _, keep := l.admit(now); !keep {
+			t.Fatal("expected lines under the total to be kept")
+		}
+	}
+	markers, keep := l.admit(now)
+	if keep || len(markers) != 1 || !strings.Contains(markers[0], "log limit of 5 lines reached") {
+		t.Fatalf("expected a final marker at the total, got %v (keep=%v)", markers, keep)
+	}
+	markers, keep = l.admit(now.Add(time.Hour))
+	if keep || len(markers) != 0 {
+		t.Fatalf("expected later lines dropped silently, got %v (keep=%v)", markers, keep)
+	}
+}
+
+func TestLogFloodIsLimitedAndRunCompletes(t *testing.T) {
+	fake := &fakeRunServer{}
+	flaky := &flakyLogServer{fakeRunServer: fake}
+	runWithLogLimits(t, flaky, `i=0
+while [ $i -lt 100000 ]; do echo "flood-$i"; i=$((i+1)); done
+sleep 0.5
+`, 500, 200_000)
+
+	if fake.result["status"] != "completed" {
+		t.Fatalf("expected completed result, got %v", fake.result)
+	}
+	dropMarkers, flood := 0, 0
+	for _, line := range fake.lines {
+		switch {
+		case strings.Contains(line, "due to rate limiting"):
+			dropMarkers++
+		case strings.HasPrefix(line, "flood-"):
+			flood++
+		}
+	}
+	if dropMarkers == 0 {
+		t.Fatal("expected rate limiting markers in the delivered logs")
+	}
+	if flood == 0 || flood >= 100000/2 {
+		t.Fatalf("expected a bounded number of lines delivered, got %d", flood)
+	}
+	for i, seq := range flaky.seqs {
+		if seq != int64(i+1) {
+			t.Fatalf("expected consecutive seqs, got a gap at %d", i)
+		}
+	}
+}
+
+func TestLogRunTotalStopsCollectingAndRunCompletes(t *testing.T) {
+	fake := &fakeRunServer{}
+	runWithLogLimits(t, &flakyLogServer{fakeRunServer: fake}, `for i in $(seq 1 300); do echo "line-$i"; done
+sleep 0.5
+`, 0, 120)
+
+	if fake.result["status"] != "completed" {
+		t.Fatalf("expected completed result, got %v", fake.result)
+	}
+	if len(fake.lines) != 121 {
+		t.Fatalf("expected 120 lines and a final marker, got %d", len(fake.lines))
+	}
+	if last := fake.lines[len(fake.lines)-1]; !strings.Contains(last, "log limit of 120 lines reached") {
+		t.Fatalf("expected the final marker last, got %q", last)
+	}
+}
+
+func TestLogBatchPastServerQuotaIsDroppedNotSpooled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, `{"error":{"code":"log_quota_exceeded"}}`, http.StatusRequestEntityTooLarge)
+	}))
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	r := NewRunner(&Config{ServerURL: srv.URL, DataDir: dataDir}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	lc := newLogCollector(r, &LeaseResponse{RunID: 5, AttemptID: 9, LeaseToken: "lease"}, newRunState(time.Now().Add(time.Minute), 0), func(string) {})
+	err := lc.send(context.Background(), []logEntry{{Seq: 1, Stream: "stdout", Line: "x"}})
+	if !errors.Is(err, errLogQuota) {
+		t.Fatalf("expected errLogQuota, got %v", err)
+	}
+	if lc.spool.pending() {
+		t.Fatal("expected a batch past the server quota not to be spooled")
+	}
+}
@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	logSpoolDirName = "log-spool"
+	// logSpoolMaxBytes caps one attempt's spool file; a batch that would
+	// grow it further is dropped.
+	logSpoolMaxBytes = 8 * 1024 * 1024
+	// logSpoolMaxBackoff bounds the wait between retries of a spool.
+	logSpoolMaxBackoff         = 30 * time.Second
+	logSpoolFinalRetryDelay    = 500 * time.Millisecond
+	defaultLogFinalFlushWindow = 15 * time.Second
+)
+
+// logSpool holds log batches the server did not take, one JSON entry per
+// line in the order they were produced, so they can be sent again later.
+// Each attempt has its own spool file under DataDir.
+type logSpool struct {
+	path     string
+	maxBytes int64
+
+	mu   sync.Mutex
+	size int64
+}
+
+func newLogSpool(dir string, lease *LeaseResponse) *logSpool {
+	return &logSpool{
+		path:     filepath.Join(dir, fmt.Sprintf("%d-%d.jsonl", lease.RunID, lease.AttemptID)),
+		maxBytes: logSpoolMaxBytes,
+	}
+}
+
+func (r *Runner) logSpoolDir() string {
+	return filepath.Join(r.cfg.DataDir, logSpoolDirName)
+}
+
+// pending reports whether the spool holds entries not yet sent.
+func (s *logSpool) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size > 0
+}
+
+// append adds entries to the end of the spool. A batch that would take the
+// spool past maxBytes is dropped whole.
+func (s *logSpool) append(entries []logEntry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(buf.Len()) > s.maxBytes {
+		return fmt.Errorf("log spool full, dropped %d lines", len(entries))
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("create log spool dir: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open log spool: %w", err)
+	}
+	n, err := f.Write(buf.Bytes())
+	s.size += int64(n)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write log spool: %w", err)
+	}
+	return nil
+}
+
+// read returns the spooled entries, oldest first.
+func (s *logSpool) read() ([]logEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size == 0 {
+		return nil, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("read log spool: %w", err)
+	}
+	var entries []logEntry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var e logEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("decode log spool: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// discard removes the n oldest entries once the server has taken them.
+// Entries appended since they were read are kept.
+func (s *logSpool) discard(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("read log spool: %w", err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if n > len(lines) {
+		n = len(lines)
+	}
+	rest := bytes.Join(lines[n:], nil)
+	if len(rest) == 0 {
+		s.size = 0
+		return removeIfExists(s.path)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, rest, 0o600); err != nil {
+		return fmt.Errorf("write log spool: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("replace log spool: %w", err)
+	}
+	s.size = int64(len(rest))
+	return nil
+}
+
+// remove deletes the spool along with anything still in it.
+func (s *logSpool) remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = 0
+	return removeIfExists(s.path)
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// sweepLogSpools removes spool files left by an earlier runner process. Their
+// attempts' lease tokens died with it, so they can no longer be sent.
+func sweepLogSpools(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,224 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyLogServer fails the first failures log batches with a 500, then
+// passes them on to fakeRunServer, recording each accepted entry's seq.
+type flakyLogServer struct {
+	*fakeRunServer
+	failures int
+
+	mu       sync.Mutex
+	attempts int
+	seqs     []int64
+}
+
+func (f *flakyLogServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasSuffix(req.URL.Path, "/logs") {
+		f.fakeRunServer.ServeHTTP(w, req)
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	f.mu.Lock()
+	f.attempts++
+	if f.failures < 0 || f.attempts <= f.failures {
+		f.mu.Unlock()
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+		return
+	}
+	var batch struct {
+		Logs []logEntry `json:"logs"`
+	}
+	_ = json.Unmarshal(body, &batch)
+	for _, l := range batch.Logs {
+		f.seqs = append(f.seqs, l.Seq)
+	}
+	f.mu.Unlock()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	f.fakeRunServer.ServeHTTP(w, req)
+}
+
+func runWithFlakyLogs(t *testing.T, flaky *flakyLogServer, script string, window time.Duration) string {
+	t.Helper()
+	return runWithLogConfig(t, flaky, script, func(cfg *Config) { cfg.LogFinalFlushWindow = window })
+}
+
+// runWithLogLimits runs script with the given log line caps.
+func runWithLogLimits(t *testing.T, flaky *flakyLogServer, script string, perSec, perRun int) string {
+	t.Helper()
+	return runWithLogConfig(t, flaky, script, func(cfg *Config) {
+		cfg.LogFinalFlushWindow = 10 * time.Second
+		cfg.MaxLogLinesPerSec = perSec
+		cfg.MaxLogLinesPerRun = perRun
+	})
+}
+
+func runWithLogConfig(t *testing.T, flaky *flakyLogServer, script string, configure func(*Config)) string {
+	t.Helper()
+	flaky.artifact = tarGz(t, map[string]string{"main.sh": script})
+	srv := httptest.NewServer(flaky)
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	cfg := &Config{
+		ServerURL:       srv.URL,
+		DataDir:         dataDir,
+		WorkDir:         filepath.Join(dataDir, workDirName),
+		KillGracePeriod: time.Second,
+	}
+	configure(cfg)
+	r := NewRunner(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+		t.Fatalf("create work dir: %v", err)
+	}
+
+	lease := &LeaseResponse{RunID: 5, AttemptID: 9, LeaseToken: "lease", Entrypoint: "main.sh"}
+	if err := r.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+	return r.logSpoolDir()
+}
+
+func TestLogSpoolDeliversAfterServerRecovers(t *testing.T) {
+	flaky := &flakyLogServer{fakeRunServer: &fakeRunServer{}, failures: 3}
+	// The sleep lets the collectors read everything before cmd.Wait closes
+	// the pipes.
+	spoolDir := runWithFlakyLogs(t, flaky, `for i in $(seq 1 250); do echo "line-$i"; done
+sleep 0.5
+`, 10*time.Second)
+
+	if flaky.result["status"] != "completed" {
+		t.Fatalf("expected completed result, got %v", flaky.result)
+	}
+	if flaky.attempts <= flaky.failures {
+		t.Fatalf("expected log batches after the outage, got %d requests", flaky.attempts)
+	}
+	for i, seq := range flaky.seqs {
+		if seq != int64(i+1) {
+			t.Fatalf("expected seqs 1..%d in order, got %v", len(flaky.seqs), flaky.seqs)
+		}
+	}
+	got := map[string]bool{}
+	for _, line := range flaky.lines {
+		got[line] = true
+	}
+	for i := 1; i <= 250; i++ {
+		if want := "line-" + strconv.Itoa(i); !got[want] {
+			t.Fatalf("missing %s from delivered logs", want)
+		}
+	}
+	assertNoSpoolFiles(t, spoolDir)
+}
+
+func TestLogSpoolGivesUpAfterFinalFlushWindow(t *testing.T) {
+	flaky := &flakyLogServer{fakeRunServer: &fakeRunServer{}, failures: -1}
+	start := time.Now()
+	spoolDir := runWithFlakyLogs(t, flaky, "echo lost\n", time.Second)
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("final flush should stop after its window, took %s", elapsed)
+	}
+	if flaky.result["status"] != "completed" {
+		t.Fatalf("expected the result submitted anyway, got %v", flaky.result)
+	}
+	if flaky.attempts < 2 {
+		t.Fatalf("expected the spool to be retried, got %d requests", flaky.attempts)
+	}
+	assertNoSpoolFiles(t, spoolDir)
+}
+
+func TestLogSpoolCapAndSweep(t *testing.T) {
+	dir := t.TempDir()
+	spool := newLogSpool(dir, &LeaseResponse{RunID: 1, AttemptID: 2})
+	spool.maxBytes = 200
+
+	first := []logEntry{{Seq: 1, Stream: "stdout", Line: "a"}, {Seq: 2, Stream: "stdout", Line: "b"}}
+	if err := spool.append(first); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if err := spool.append([]logEntry{{Seq: 3, Stream: "stdout", Line: strings.Repeat("x", 200)}}); err == nil {
+		t.Fatal("expected a batch past the cap to be rejected")
+	}
+	entries, err := spool.read()
+	if err != nil || len(entries) != 2 || entries[1].Seq != 2 {
+		t.Fatalf("expected the first batch kept, got %v (%v)", entries, err)
+	}
+
+	if err := spool.discard(1); err != nil {
+		t.Fatalf("discard: %v", err)
+	}
+	if entries, _ := spool.read(); len(entries) != 1 || entries[0].Seq != 2 {
+		t.Fatalf("expected seq 2 left after discard, got %v", entries)
+	}
+
+	// A spool left by an earlier process is swept at startup.
+	n, err := sweepLogSpools(dir)
+	if err != nil || n != 1 {
+		t.Fatalf("expected one spool swept, got %d (%v)", n, err)
+	}
+	assertNoSpoolFiles(t, dir)
+	if n, err := sweepLogSpools(filepath.Join(dir, "missing")); err != nil || n != 0 {
+		t.Fatalf("expected a missing spool dir to be ignored, got %d (%v)", n, err)
+	}
+}
+
+func TestLoadConfigRejectsNegativeLogFinalFlushWindow(t *testing.T) {
+	t.Setenv("MINITOWER_SERVER_URL", "http://localhost:8080")
+	t.Setenv("MINITOWER_RUNNER_NAME", "runner-test")
+	t.Setenv("MINITOWER_LOG_FINAL_FLUSH_WINDOW", "-1s")
+
+	_, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "MINITOWER_LOG_FINAL_FLUSH_WINDOW") {
+		t.Fatalf("expected final flush window error, got: %v", err)
+	}
+}
+
+func TestLoadConfigLogLineLimits(t *testing.T) {
+	t.Setenv("MINITOWER_SERVER_URL", "http://localhost:8080")
+	t.Setenv("MINITOWER_RUNNER_NAME", "runner-test")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.MaxLogLinesPerSec != defaultMaxLogLinesPerSec || cfg.MaxLogLinesPerRun != defaultMaxLogLinesPerRun {
+		t.Fatalf("expected default log line limits, got %d/%d", cfg.MaxLogLinesPerSec, cfg.MaxLogLinesPerRun)
+	}
+
+	t.Setenv("MINITOWER_MAX_LOG_LINES_PER_SEC", "0")
+	t.Setenv("MINITOWER_MAX_LOG_LINES_PER_RUN", "1000")
+	if cfg, err = LoadConfig(); err != nil || cfg.MaxLogLinesPerSec != 0 || cfg.MaxLogLinesPerRun != 1000 {
+		t.Fatalf("expected 0/1000 log line limits, got %+v (%v)", cfg, err)
+	}
+
+	t.Setenv("MINITOWER_MAX_LOG_LINES_PER_RUN", "-1")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "MINITOWER_MAX_LOG_LINES_PER_RUN") {
+		t.Fatalf("expected per-run limit error, got: %v", err)
+	}
+}
+
+func assertNoSpoolFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("read spool dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no spool files, found %d", len(entries))
+	}
+}
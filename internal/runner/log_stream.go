@@ -0,0 +1,216 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// logStreamIdleClose is how long an open log stream may go without a
+	// line before the runner ends it; the server gives up on idle streams
+	// after a minute.
+	logStreamIdleClose = 30 * time.Second
+	// logStreamCloseTimeout bounds the wait for the server's summary once
+	// a stream is ended.
+	logStreamCloseTimeout = 30 * time.Second
+	// logStreamExpectContinueTimeout is how long a new stream waits for
+	// the server to accept it before sending lines regardless.
+	logStreamExpectContinueTimeout = 5 * time.Second
+)
+
+// errLogStreamUnsupported is returned when the server has no log stream
+// endpoint.
+var errLogStreamUnsupported = errors.New("server has no log stream endpoint")
+
+// logStream delivers a run's log batches as newline-delimited JSON on one
+// long-lived POST /api/v1/runs/{id}/logs/stream request, written through an
+// io.Pipe. The request asks for 100-continue, so a server without the
+// endpoint answers 404 before any line is sent, and the run's logs fall
+// back to batched POSTs.
+type logStream struct {
+	r      *Runner
+	lease  *LeaseResponse
+	client *http.Client
+
+	mu sync.Mutex
+	// cur is the open request; nil between requests.
+	cur *logStreamRequest
+	// batching is set once the server turned out to lack the endpoint.
+	batching bool
+}
+
+// logStreamRequest is one open stream request.
+type logStreamRequest struct {
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+	// done is closed once the response is in; err is its outcome.
+	done      chan struct{}
+	err       error
+	lastWrite time.Time
+}
+
+func newLogStream(r *Runner, lease *LeaseResponse) *logStream {
+	// The stream outlives the API client's request timeout.
+	return &logStream{r: r, lease: lease, client: &http.Client{Transport: logStreamTransport(r.httpClient.Transport)}}
+}
+
+// logStreamTransport returns rt set to wait for 100-continue; without a
+// wait, lines sent to a server lacking the endpoint would be lost.
+func logStreamTransport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+	t = t.Clone()
+	t.ExpectContinueTimeout = logStreamExpectContinueTimeout
+	return t
+}
+
+// deliver writes entries to the open stream, opening one if needed. A
+// stream that broke since its last write is replaced once; the server
+// ignores lines it already has, so nothing is stored twice.
+func (s *logStream) deliver(ctx context.Context, entries []logEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.batching {
+		return s.r.flushLogs(ctx, s.lease, entries)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	fresh := s.cur == nil
+	err := s.writeLocked(ctx, buf.Bytes())
+	if err != nil && !fresh && ctx.Err() == nil && !errors.Is(err, ErrStaleLease) && !errors.Is(err, errLogQuota) {
+		err = s.writeLocked(ctx, buf.Bytes())
+	}
+	if errors.Is(err, errLogStreamUnsupported) {
+		s.r.logger.Info("server has no log stream endpoint, batching logs")
+		s.batching = true
+		return s.r.flushLogs(ctx, s.lease, entries)
+	}
+	return err
+}
+
+// writeLocked writes data to the open request, opening one first if there
+// is none. If the request has ended, it returns the request's outcome.
+func (s *logStream) writeLocked(ctx context.Context, data []byte) error {
+	if s.cur == nil {
+		s.cur = s.open()
+	}
+	c := s.cur
+	stop := context.AfterFunc(ctx, func() { c.pw.CloseWithError(ctx.Err()) })
+	_, err := c.pw.Write(data)
+	stop()
+	if err == nil {
+		c.lastWrite = time.Now()
+		return nil
+	}
+	s.cur = nil
+	<-c.done
+	if c.err != nil {
+		return c.err
+	}
+	return err
+}
+
+// open starts a stream request whose body is read from a pipe.
+func (s *logStream) open() *logStreamRequest {
+	pr, pw := io.Pipe()
+	reqCtx, cancel := context.WithCancel(context.Background())
+	c := &logStreamRequest{pw: pw, cancel: cancel, done: make(chan struct{}), lastWrite: time.Now()}
+	go func() {
+		defer close(c.done)
+		c.err = s.post(reqCtx, pr)
+		// Writes past the response fail rather than block.
+		pr.CloseWithError(errors.New("log stream closed"))
+	}()
+	return c
+}
+
+func (s *logStream) post(ctx context.Context, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/runs/%d/logs/stream", s.r.cfg.ServerURL, s.lease.RunID), body)
+	if err != nil {
+		return err
+	}
+	s.r.setLeaseHeaders(req, s.lease)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Expect", "100-continue")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		var summary struct {
+			Accepted int `json:"accepted"`
+			Deduped  int `json:"deduped"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+			return fmt.Errorf("decode log stream summary: %w", err)
+		}
+		s.r.logger.Debug("log stream closed", "run_id", s.lease.RunID, "accepted", summary.Accepted, "deduped", summary.Deduped)
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		return errLogStreamUnsupported
+	case isStaleLeaseStatus(resp.StatusCode):
+		return ErrStaleLease
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return errLogQuota
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("log stream failed: %d %s", resp.StatusCode, string(respBody))
+}
+
+// close ends the open request, if any, and waits for the server to store
+// the rest of its lines.
+func (s *logStream) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeLocked()
+}
+
+// closeIdle ends the open request if nothing was written to it for
+// logStreamIdleClose.
+func (s *logStream) closeIdle(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur == nil || now.Sub(s.cur.lastWrite) < logStreamIdleClose {
+		return nil
+	}
+	return s.closeLocked()
+}
+
+func (s *logStream) closeLocked() error {
+	c := s.cur
+	if c == nil {
+		return nil
+	}
+	s.cur = nil
+	c.pw.Close()
+	select {
+	case <-c.done:
+	case <-time.After(logStreamCloseTimeout):
+		c.cancel()
+		<-c.done
+	}
+	c.cancel()
+	return c.err
+}
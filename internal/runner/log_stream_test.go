@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLogServer records the log lines it is sent, by either endpoint.
+type fakeLogServer struct {
+	streaming bool
+
+	mu           sync.Mutex
+	streamCalls  int
+	batchCalls   int
+	streamedSeqs []int64
+	batchedSeqs  []int64
+}
+
+func (f *fakeLogServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/api/v1/runs/5/logs/stream":
+		f.mu.Lock()
+		f.streamCalls++
+		f.mu.Unlock()
+		if !f.streaming {
+			http.NotFound(w, req)
+			return
+		}
+		scanner := bufio.NewScanner(req.Body)
+		accepted := 0
+		for scanner.Scan() {
+			var e logEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			f.mu.Lock()
+			f.streamedSeqs = append(f.streamedSeqs, e.Seq)
+			f.mu.Unlock()
+			accepted++
+		}
+		_ = json.NewEncoder(w).Encode(map[string]int{"accepted": accepted, "deduped": 0})
+	case "/api/v1/runs/5/logs":
+		var body struct {
+			Logs []logEntry `json:"logs"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.batchCalls++
+		for _, e := range body.Logs {
+			f.batchedSeqs = append(f.batchedSeqs, e.Seq)
+		}
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func newStreamingLogCollector(t *testing.T, serverURL string) *logCollector {
+	t.Helper()
+	r := NewRunner(&Config{ServerURL: serverURL, LogStreaming: true}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return newLogCollector(r, &LeaseResponse{RunID: 5, AttemptID: 9, LeaseToken: "lease"}, newRunState(time.Now().Add(time.Minute), 0), func(string) {})
+}
+
+func TestLogStreamingSendsBatchesOnOneRequest(t *testing.T) {
+	fake := &fakeLogServer{streaming: true}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	lc := newStreamingLogCollector(t, srv.URL)
+	ctx := context.Background()
+	for _, batch := range [][]logEntry{
+		{{Seq: 1, Stream: "stdout", Line: "a"}, {Seq: 2, Stream: "stdout", Line: "b"}},
+		{{Seq: 3, Stream: "stderr", Line: "c"}},
+	} {
+		if err := lc.send(ctx, batch); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	lc.closeStream()
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.streamCalls != 1 || fake.batchCalls != 0 {
+		t.Fatalf("expected one stream request and no batches, got %d and %d", fake.streamCalls, fake.batchCalls)
+	}
+	if len(fake.streamedSeqs) != 3 {
+		t.Fatalf("expected 3 streamed lines, got %v", fake.streamedSeqs)
+	}
+}
+
+func TestLogStreamingFallsBackToBatchesWhenEndpointIsMissing(t *testing.T) {
+	fake := &fakeLogServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	lc := newStreamingLogCollector(t, srv.URL)
+	ctx := context.Background()
+	for _, batch := range [][]logEntry{
+		{{Seq: 1, Stream: "stdout", Line: "a"}, {Seq: 2, Stream: "stdout", Line: "b"}},
+		{{Seq: 3, Stream: "stderr", Line: "c"}},
+	} {
+		if err := lc.send(ctx, batch); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	lc.closeStream()
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.streamCalls != 1 {
+		t.Fatalf("expected the stream endpoint to be tried once, got %d", fake.streamCalls)
+	}
+	if fake.batchCalls != 2 || len(fake.batchedSeqs) != 3 {
+		t.Fatalf("expected both batches posted, got %d calls with %v", fake.batchCalls, fake.batchedSeqs)
+	}
+}
@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// runnerMetrics holds the runner's own Prometheus collectors. A nil
+// *runnerMetrics records nothing.
+type runnerMetrics struct {
+	registry *prometheus.Registry
+
+	runsActive       prometheus.Gauge
+	runsCompleted    *prometheus.CounterVec
+	artifactDownload *prometheus.HistogramVec
+}
+
+func newRunnerMetrics() *runnerMetrics {
+	m := &runnerMetrics{
+		registry: prometheus.NewRegistry(),
+		runsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "minitower_runner_runs_active",
+			Help: "Runs this runner is executing.",
+		}),
+		runsCompleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "minitower_runner_runs_completed_total",
+			Help: "Runs this runner reported a result for, by status, team, app and failure kind.",
+		}, []string{"status", "team", "app", "failure_kind"}),
+		artifactDownload: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "minitower_runner_artifact_download_duration_seconds",
+			Help:    "Time to download a run's artifact from the server, by team and app.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+		}, []string{"team", "app"}),
+	}
+	m.registry.MustRegister(m.runsActive, m.runsCompleted, m.artifactDownload)
+	return m
+}
+
+func (m *runnerMetrics) runStarted() {
+	if m != nil {
+		m.runsActive.Inc()
+	}
+}
+
+func (m *runnerMetrics) runFinished() {
+	if m != nil {
+		m.runsActive.Dec()
+	}
+}
+
+func (m *runnerMetrics) runCompleted(status, failureKind string, lease *LeaseResponse) {
+	if m != nil {
+		m.runsCompleted.WithLabelValues(status, lease.TeamSlug, lease.AppSlug, failureKind).Inc()
+	}
+}
+
+func (m *runnerMetrics) artifactDownloaded(lease *LeaseResponse, d time.Duration) {
+	if m != nil {
+		m.artifactDownload.WithLabelValues(lease.TeamSlug, lease.AppSlug).Observe(d.Seconds())
+	}
+}
+
+// serveMetrics serves /metrics on addr until ctx is done. It fails when it
+// cannot listen on addr, so a mistyped address stops the runner at startup.
+func (r *Runner) serveMetrics(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(r.metrics.registry, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.logger.Error("metrics server failed", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	r.logger.Info("serving metrics", "addr", ln.Addr().String())
+	return nil
+}
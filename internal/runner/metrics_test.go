@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestRunnerMetricsLabelRunsByTeamAndApp(t *testing.T) {
+	fake := &fakeRunServer{artifact: tarGz(t, map[string]string{"main.sh": "echo hi\n"})}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	var logs bytes.Buffer
+	r := NewRunner(&Config{
+		ServerURL:       srv.URL,
+		DataDir:         dataDir,
+		WorkDir:         filepath.Join(dataDir, workDirName),
+		KillGracePeriod: time.Second,
+	}, slog.New(slog.NewTextHandler(&logs, nil)))
+	if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+		t.Fatalf("create work dir: %v", err)
+	}
+
+	lease := &LeaseResponse{RunID: 5, RunNo: 3, AttemptID: 9, AttemptNo: 2, LeaseToken: "lease", Entrypoint: "main.sh", TeamSlug: "acme", AppSlug: "etl"}
+	if err := r.withRunLogger(lease).executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(r.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"minitower_runner_runs_active 0",
+		`minitower_runner_runs_completed_total{app="etl",failure_kind="",status="completed",team="acme"} 1`,
+		`minitower_runner_artifact_download_duration_seconds_count{app="etl",team="acme"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in scrape, got:\n%s", want, body)
+		}
+	}
+
+	if !strings.Contains(logs.String(), "team=acme app=etl run_no=3 attempt=2") {
+		t.Fatalf("expected run-scoped log lines to carry team, app, run_no and attempt, got:\n%s", logs.String())
+	}
+}
+
+func TestServeMetricsRejectsBadAddr(t *testing.T) {
+	r := NewRunner(&Config{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := r.serveMetrics(context.Background(), "not-an-addr"); err == nil || !strings.Contains(err.Error(), "metrics listen") {
+		t.Fatalf("expected a listen error, got %v", err)
+	}
+}
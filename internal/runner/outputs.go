@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	outputsDirName = ".minitower-outputs"
+	// maxOutputs and maxOutputBytes match the server's per-run caps, so
+	// files past them are skipped without a request.
+	maxOutputs     = 20
+	maxOutputBytes = 10 << 20
+)
+
+// errOutputLimit is returned when the server already holds the maximum
+// number of outputs for the run.
+var errOutputLimit = errors.New("run output limit reached")
+
+// uploadOutputs uploads the regular files at the top of the outputs
+// directory after a successful exit. Files past the caps and failed uploads
+// are noted in setup log lines but never change the run's status. A stale
+// lease marks the run stale and stops the uploads.
+func (r *Runner) uploadOutputs(ctx context.Context, lease *LeaseResponse, state *runState, ws *workspaceResult, lc *logCollector) {
+	entries, err := os.ReadDir(ws.OutputsDir)
+	if err != nil {
+		lc.logSetup(context.Background(), fmt.Sprintf("outputs not uploaded: %v", err))
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	uploaded, skipped := 0, 0
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			lc.logSetup(context.Background(), fmt.Sprintf("output %s skipped: not a regular file", e.Name()))
+			skipped++
+			continue
+		}
+		if uploaded >= maxOutputs {
+			skipped++
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			lc.logSetup(context.Background(), fmt.Sprintf("output %s skipped: %v", e.Name(), err))
+			skipped++
+			continue
+		}
+		if info.Size() > maxOutputBytes {
+			lc.logSetup(context.Background(), fmt.Sprintf("output %s skipped: %d bytes exceeds the %d byte limit", e.Name(), info.Size(), maxOutputBytes))
+			skipped++
+			continue
+		}
+
+		err = r.uploadOutput(ctx, lease, filepath.Join(ws.OutputsDir, e.Name()))
+		switch {
+		case errors.Is(err, ErrStaleLease):
+			r.logger.Warn("stale lease on output upload")
+			state.markStale()
+			return
+		case errors.Is(err, errOutputLimit):
+			lc.logSetup(context.Background(), fmt.Sprintf("output %s skipped: the run already has %d outputs", e.Name(), maxOutputs))
+			skipped++
+			uploaded = maxOutputs
+		case err != nil:
+			r.logger.Warn("output upload failed", "name", e.Name(), "error", err)
+			lc.logSetup(context.Background(), fmt.Sprintf("output %s upload failed: %v", e.Name(), err))
+			skipped++
+		default:
+			uploaded++
+		}
+	}
+
+	if uploaded+skipped == 0 {
+		return
+	}
+	if skipped > 0 {
+		lc.logSetup(context.Background(), fmt.Sprintf("uploaded %d outputs, %d not uploaded (at most %d files of %s each)", uploaded, skipped, maxOutputs, formatBytes(maxOutputBytes)))
+		return
+	}
+	lc.logSetup(context.Background(), fmt.Sprintf("uploaded %d outputs", uploaded))
+}
+
+// uploadOutput posts one file to the run's outputs as a multipart body.
+func (r *Runner) uploadOutput(ctx context.Context, lease *LeaseResponse, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/runs/%d/outputs", r.cfg.ServerURL, lease.RunID), &body)
+	if err != nil {
+		return err
+	}
+	r.setLeaseHeaders(req, lease)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		var apiErr struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(respBody, &apiErr)
+		if apiErr.Error.Code == "output_limit" {
+			return errOutputLimit
+		}
+		if isStaleLeaseStatus(resp.StatusCode) {
+			return ErrStaleLease
+		}
+		return fmt.Errorf("%d %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runOutputsScript(t *testing.T, fake *fakeRunServer, script string) {
+	t.Helper()
+	fake.artifact = tarGz(t, map[string]string{"main.sh": script})
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	r := NewRunner(&Config{
+		ServerURL:       srv.URL,
+		DataDir:         dataDir,
+		WorkDir:         filepath.Join(dataDir, workDirName),
+		KillGracePeriod: time.Second,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+		t.Fatalf("create work dir: %v", err)
+	}
+
+	lease := &LeaseResponse{RunID: 3, LeaseToken: "lease", Entrypoint: "main.sh"}
+	if err := r.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+}
+
+func TestOutputsUploadedAfterSuccess(t *testing.T) {
+	fake := &fakeRunServer{}
+	runOutputsScript(t, fake, `cd "$MINITOWER_OUTPUTS_DIR"
+echo "total=3" > report.txt
+head -c 10485761 /dev/zero > big.bin
+mkdir nested
+`)
+
+	if fake.result["status"] != "completed" {
+		t.Fatalf("expected completed result, got %v", fake.result)
+	}
+	if len(fake.outputs) != 1 || string(fake.outputs["report.txt"]) != "total=3\n" {
+		t.Fatalf("expected only report.txt uploaded, got %v", fake.outputs)
+	}
+	logs := strings.Join(fake.lines, "\n")
+	for _, want := range []string{
+		"output big.bin skipped: 10485761 bytes exceeds the 10485760 byte limit",
+		"output nested skipped: not a regular file",
+		"uploaded 1 outputs, 2 not uploaded",
+	} {
+		if !strings.Contains(logs, want) {
+			t.Fatalf("expected %q in logs, got:\n%s", want, logs)
+		}
+	}
+}
+
+func TestOutputsNotUploadedAfterFailure(t *testing.T) {
+	fake := &fakeRunServer{}
+	runOutputsScript(t, fake, `echo partial > "$MINITOWER_OUTPUTS_DIR/report.txt"
+exit 2
+`)
+
+	if fake.result["status"] != "failed" {
+		t.Fatalf("expected failed result, got %v", fake.result)
+	}
+	if len(fake.outputs) != 0 {
+		t.Fatalf("expected no uploads after a failed exit, got %v", fake.outputs)
+	}
+}
+
+func TestOutputLimitKeepsRunCompleted(t *testing.T) {
+	fake := &fakeRunServer{outputLimit: 1}
+	runOutputsScript(t, fake, `cd "$MINITOWER_OUTPUTS_DIR"
+echo a > a.txt
+echo b > b.txt
+`)
+
+	if fake.result["status"] != "completed" {
+		t.Fatalf("expected completed result, got %v", fake.result)
+	}
+	if len(fake.outputs) != 1 || fake.outputs["a.txt"] == nil {
+		t.Fatalf("expected only a.txt uploaded, got %v", fake.outputs)
+	}
+	logs := strings.Join(fake.lines, "\n")
+	if !strings.Contains(logs, "output b.txt skipped: the run already has 20 outputs") {
+		t.Fatalf("expected limit note in logs, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// errNoShell is returned by shellFor on Windows hosts without bash, which
+// cannot run .sh entrypoints or setup scripts. The message is sent as the
+// run's error message as-is.
+var errNoShell = errors.New("shell scripts need bash on this Windows runner: install Git for Windows or WSL and put bash on PATH")
+
+// venvExecutable returns the path of a program a venv installs, such as
+// python or pip, for goos: Scripts\<name>.exe on Windows, bin/<name>
+// elsewhere.
+func venvExecutable(goos, venvPath, name string) string {
+	if goos == "windows" {
+		return filepath.Join(venvPath, "Scripts", name+".exe")
+	}
+	return filepath.Join(venvPath, "bin", name)
+}
+
+// venvPython returns the venv interpreter on this host.
+func venvPython(venvPath string) string {
+	return venvExecutable(runtime.GOOS, venvPath, "python")
+}
+
+// shellFor returns the shell that runs .sh scripts on goos: /bin/sh, or on
+// Windows the bash lookPath finds.
+func shellFor(goos string, lookPath func(string) (string, error)) (string, error) {
+	if goos != "windows" {
+		return "/bin/sh", nil
+	}
+	bash, err := lookPath("bash")
+	if err != nil {
+		return "", errNoShell
+	}
+	return bash, nil
+}
+
+// shell returns the shell that runs .sh scripts on this host.
+func shell() (string, error) {
+	return shellFor(runtime.GOOS, exec.LookPath)
+}
+
+// joinPathList joins dirs and then existing into one PYTHONPATH-style value
+// with sep. existing is kept even when empty, as the runner always has.
+func joinPathList(sep rune, dirs []string, existing string) string {
+	return strings.Join(append(dirs[:len(dirs):len(dirs)], existing), string(sep))
+}
+
+// pythonPath prepends dirs to existing with this host's list separator.
+func pythonPath(dirs []string, existing string) string {
+	return joinPathList(os.PathListSeparator, dirs, existing)
+}
@@ -0,0 +1,49 @@
+package runner
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestVenvExecutablePerGOOS(t *testing.T) {
+	tests := []struct {
+		goos, name, want string
+	}{
+		{"linux", "python", filepath.Join("ws", ".venv", "bin", "python")},
+		{"darwin", "pip", filepath.Join("ws", ".venv", "bin", "pip")},
+		{"windows", "python", filepath.Join("ws", ".venv", "Scripts", "python.exe")},
+		{"windows", "pip", filepath.Join("ws", ".venv", "Scripts", "pip.exe")},
+	}
+	for _, tc := range tests {
+		if got := venvExecutable(tc.goos, filepath.Join("ws", ".venv"), tc.name); got != tc.want {
+			t.Errorf("venvExecutable(%s, %s) = %q, want %q", tc.goos, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestShellForPerGOOS(t *testing.T) {
+	noBash := func(string) (string, error) { return "", exec.ErrNotFound }
+	gitBash := func(name string) (string, error) { return `C:\Program Files\Git\bin\` + name + ".exe", nil }
+
+	if sh, err := shellFor("linux", noBash); err != nil || sh != "/bin/sh" {
+		t.Fatalf("expected /bin/sh on linux, got %q (%v)", sh, err)
+	}
+	if sh, err := shellFor("windows", gitBash); err != nil || sh != `C:\Program Files\Git\bin\bash.exe` {
+		t.Fatalf("expected bash from PATH on windows, got %q (%v)", sh, err)
+	}
+	if _, err := shellFor("windows", noBash); !errors.Is(err, errNoShell) {
+		t.Fatalf("expected errNoShell without bash on windows, got %v", err)
+	}
+}
+
+func TestJoinPathList(t *testing.T) {
+	dirs := []string{"/ws/src", "/ws/lib"}
+	if got := joinPathList(':', dirs, "/usr/lib/py"); got != "/ws/src:/ws/lib:/usr/lib/py" {
+		t.Fatalf("unexpected posix path list %q", got)
+	}
+	if got := joinPathList(';', []string{`C:\ws\src`}, ""); got != `C:\ws\src;` {
+		t.Fatalf("unexpected windows path list %q", got)
+	}
+}
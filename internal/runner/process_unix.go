@@ -0,0 +1,19 @@
+//go:build !windows
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// configureProcess prepares cmd so terminateProcess can stop it. Nothing is
+// needed where processes take signals.
+func configureProcess(cmd *exec.Cmd) {}
+
+// terminateProcess asks p to stop with SIGTERM. The kill grace period
+// applies before it is killed.
+func terminateProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,27 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+var procGenerateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// configureProcess starts cmd in its own process group, so a console
+// control event can be sent to it without reaching the runner.
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminateProcess asks p to stop with a CTRL_BREAK_EVENT, which ends a
+// console program unless it handles SIGBREAK. A runner without a console
+// cannot send one and asks taskkill to close the process tree instead. The
+// kill grace period applies either way before it is killed.
+func terminateProcess(p *os.Process) error {
+	if ok, _, _ := procGenerateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(p.Pid)); ok != 0 {
+		return nil
+	}
+	return exec.Command("taskkill", "/T", "/PID", strconv.Itoa(p.Pid)).Run()
+}
@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"minitower/internal/validate"
+)
+
+// parsePythonBins parses MINITOWER_PYTHON_BINS, a comma-separated list of
+// version=path pairs such as "3.10=/usr/bin/python3.10,3.12=/usr/local/bin/python3.12".
+func parsePythonBins(v string) (map[string]string, error) {
+	bins := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		version, path, ok := strings.Cut(pair, "=")
+		version, path = strings.TrimSpace(version), strings.TrimSpace(path)
+		if !ok || path == "" {
+			return nil, fmt.Errorf("%q: expected version=path", pair)
+		}
+		if err := validate.ValidatePythonVersion(version); err != nil {
+			return nil, fmt.Errorf("%q: %w", pair, err)
+		}
+		if _, dup := bins[version]; dup {
+			return nil, fmt.Errorf("python %s is listed twice", version)
+		}
+		bins[version] = path
+	}
+	return bins, nil
+}
+
+// pythonVersions returns the Python versions the runner declares when it
+// registers, sorted.
+func (c *Config) pythonVersions() []string {
+	return slices.Sorted(maps.Keys(c.PythonBins))
+}
+
+// pythonBinFor returns the interpreter for a run needing version; a run
+// needing none uses PythonBin. The server only leases a run needing a
+// version to runners that declared it, so a miss means the runner's
+// configuration changed since it registered.
+func (c *Config) pythonBinFor(version string) (string, error) {
+	if version == "" {
+		return c.PythonBin, nil
+	}
+	bin, ok := c.PythonBins[version]
+	if !ok {
+		return "", fmt.Errorf("run needs Python %s, which MINITOWER_PYTHON_BINS does not list", version)
+	}
+	return bin, nil
+}
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePythonBins(t *testing.T) {
+	bins, err := parsePythonBins("3.10=/usr/bin/python3.10, 3.12=/usr/local/bin/python3.12,")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := map[string]string{"3.10": "/usr/bin/python3.10", "3.12": "/usr/local/bin/python3.12"}
+	if !reflect.DeepEqual(bins, want) {
+		t.Fatalf("parsePythonBins = %v, want %v", bins, want)
+	}
+	cfg := &Config{PythonBins: bins}
+	if got := cfg.pythonVersions(); !reflect.DeepEqual(got, []string{"3.10", "3.12"}) {
+		t.Fatalf("pythonVersions = %v", got)
+	}
+
+	for _, bad := range []string{"3.12", "3.12=", "py3=/usr/bin/python3", "3.12=/a,3.12=/b"} {
+		if _, err := parsePythonBins(bad); err == nil {
+			t.Fatalf("parsePythonBins(%q) should fail", bad)
+		}
+	}
+}
+
+// fakePython writes an interpreter that records its name in marker and
+// fails, so venv creation stops the run right after choosing it.
+func fakePython(t *testing.T, dir, name, marker string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho "+name+" >> "+marker+"\nexit 1\n"), 0o755); err != nil {
+		t.Fatalf("write fake python: %v", err)
+	}
+	return path
+}
+
+func TestRunUsesInterpreterOfRequiredPythonVersion(t *testing.T) {
+	binDir := t.TempDir()
+	marker := filepath.Join(binDir, "used")
+	cfg := &Config{
+		PythonBin: fakePython(t, binDir, "python-default", marker),
+		PythonBins: map[string]string{
+			"3.10": fakePython(t, binDir, "python-3.10", marker),
+			"3.12": fakePython(t, binDir, "python-3.12", marker),
+		},
+		KillGracePeriod: time.Second,
+		SetupTimeout:    10 * time.Second,
+	}
+
+	for i, tc := range []struct {
+		python  string
+		used    string
+		message string
+	}{
+		{python: "3.12", used: "python-3.12", message: "failed to create venv"},
+		{python: "", used: "python-default", message: "failed to create venv"},
+		{python: "3.11", message: "run needs Python 3.11, which MINITOWER_PYTHON_BINS does not list"},
+	} {
+		fake := &fakeRunServer{artifact: tarGz(t, map[string]string{"main.py": "print('hi')\n"})}
+		srv := httptest.NewServer(fake)
+		dataDir := t.TempDir()
+		runCfg := *cfg
+		runCfg.ServerURL, runCfg.DataDir, runCfg.WorkDir = srv.URL, dataDir, filepath.Join(dataDir, workDirName)
+		r := NewRunner(&runCfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+			t.Fatalf("create work dir: %v", err)
+		}
+		_ = os.Remove(marker)
+
+		lease := &LeaseResponse{RunID: int64(i + 1), LeaseToken: "lease", Entrypoint: "main.py", PythonVersion: tc.python}
+		if err := r.executeRun(context.Background(), lease); err != nil {
+			t.Fatalf("python %q: execute run: %v", tc.python, err)
+		}
+		srv.Close()
+
+		if msg, _ := fake.result["error_message"].(string); !strings.HasPrefix(msg, tc.message) {
+			t.Fatalf("python %q: unexpected error message %q", tc.python, msg)
+		}
+		used, _ := os.ReadFile(marker)
+		if strings.TrimSpace(string(used)) != tc.used {
+			t.Fatalf("python %q: expected %q to create the venv, got %q", tc.python, tc.used, used)
+		}
+	}
+}
+
+func TestRegisterDeclaresPythonVersions(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("decode register: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `{"runner_id":1,"name":"runner-py","token":"tok"}`)
+	}))
+	defer srv.Close()
+
+	r := NewRunner(&Config{
+		ServerURL:  srv.URL,
+		RunnerName: "runner-py",
+		DataDir:    t.TempDir(),
+		PythonBins: map[string]string{"3.12": "/opt/py312", "3.10": "/opt/py310"},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := r.register(context.Background()); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if got := body["python_versions"]; !reflect.DeepEqual(got, []any{"3.10", "3.12"}) {
+		t.Fatalf("expected python_versions [3.10 3.12], got %v", got)
+	}
+}
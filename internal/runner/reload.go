@@ -0,0 +1,181 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+)
+
+// liveConfig is the runner's current configuration. A reload replaces it
+// with one re-read from the environment; each run takes a snapshot when it
+// starts, so a reload never reaches a run already in flight.
+type liveConfig struct {
+	mu  sync.Mutex
+	cfg *Config
+}
+
+func (l *liveConfig) current() *Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+// apply replaces the configuration with next and returns the result. Fields
+// a running daemon cannot change keep their current values, with a warning
+// naming the variable that needs a restart.
+func (l *liveConfig) apply(next *Config, logger *slog.Logger) *Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cur := l.cfg
+	cfg := *next
+	for _, f := range []struct {
+		env     string
+		changed bool
+	}{
+		{"MINITOWER_SERVER_URL", cfg.ServerURL != cur.ServerURL},
+		{"MINITOWER_RUNNER_NAME", cfg.RunnerName != cur.RunnerName},
+		{"MINITOWER_RUNNER_TOKEN", cfg.Token != cur.Token},
+		{"MINITOWER_RUNNER_ENVIRONMENT", cfg.Environment != cur.Environment},
+		{"MINITOWER_DATA_DIR", cfg.DataDir != cur.DataDir},
+		{"MINITOWER_WORK_DIR", cfg.WorkDir != cur.WorkDir},
+		{"MINITOWER_ARTIFACT_CACHE_MAX_BYTES", cfg.ArtifactCacheMaxBytes != cur.ArtifactCacheMaxBytes},
+		{"MINITOWER_RUNNER_METRICS_ADDR", cfg.MetricsAddr != cur.MetricsAddr},
+		{"MINITOWER_CA_CERT", cfg.CACert != cur.CACert},
+		{"MINITOWER_TLS_INSECURE", cfg.TLSInsecure != cur.TLSInsecure},
+		// The server matches runs against the versions declared at
+		// registration.
+		{"MINITOWER_PYTHON_BINS", !maps.Equal(cfg.PythonBins, cur.PythonBins)},
+	} {
+		if f.changed {
+			logger.Warn("config change needs a restart, keeping the current value", "env", f.env)
+		}
+	}
+	cfg.ServerURL = cur.ServerURL
+	cfg.RunnerName = cur.RunnerName
+	cfg.Token = cur.Token
+	cfg.Environment = cur.Environment
+	cfg.DataDir = cur.DataDir
+	cfg.WorkDir = cur.WorkDir
+	cfg.ArtifactCacheMaxBytes = cur.ArtifactCacheMaxBytes
+	cfg.MetricsAddr = cur.MetricsAddr
+	cfg.CACert = cur.CACert
+	cfg.TLSInsecure = cur.TLSInsecure
+	cfg.PythonBins = cur.PythonBins
+	l.cfg = &cfg
+	return l.cfg
+}
+
+// configAttrs lists cfg as log attributes. The registration and runner
+// tokens are only reported as set or not.
+func configAttrs(cfg *Config) []any {
+	return []any{
+		"server_url", cfg.ServerURL,
+		"runner_name", cfg.RunnerName,
+		"environment", cfg.Environment,
+		"registration_token_set", cfg.RegistrationToken != "",
+		"token_set", cfg.Token != "",
+		"data_dir", cfg.DataDir,
+		"work_dir", cfg.WorkDir,
+		"min_free_disk_bytes", cfg.MinFreeDisk,
+		"artifact_cache_max_bytes", cfg.ArtifactCacheMaxBytes,
+		"python_bin", cfg.PythonBin,
+		"python_bins", cfg.PythonBins,
+		"poll_interval", cfg.PollInterval.String(),
+		"kill_grace_period", cfg.KillGracePeriod.String(),
+		"setup_timeout", cfg.SetupTimeout.String(),
+		"allow_takeover", cfg.AllowTakeover,
+		"group_tracebacks", cfg.GroupTracebacks,
+		"log_streaming", cfg.LogStreaming,
+		"log_final_flush_window", cfg.LogFinalFlushWindow.String(),
+		"max_log_lines_per_sec", cfg.MaxLogLinesPerSec,
+		"max_log_lines_per_run", cfg.MaxLogLinesPerRun,
+		"snapshot_on_failure", cfg.SnapshotOnFailure,
+		"snapshot_max_bytes", cfg.SnapshotMaxBytes,
+		"metrics_addr", cfg.MetricsAddr,
+		"ca_cert", cfg.CACert,
+		"tls_insecure", cfg.TLSInsecure,
+		"log_level", cfg.LogLevel.String(),
+	}
+}
+
+// snapshotConfig points r at the current configuration. r keeps that
+// snapshot until it is called again, whatever reloads happen meanwhile.
+func (r *Runner) snapshotConfig() {
+	if r.live != nil {
+		r.cfg = r.live.current()
+	}
+}
+
+// loadEnvFile sets the variables listed in the MINITOWER_RUNNER_ENV_FILE
+// file, if one is named. A process cannot see changes to its environment
+// made from outside, so the file is how a reload picks up new values. Lines
+// are KEY=VALUE; blank lines and lines starting with # are skipped, and a
+// value may be wrapped in single or double quotes.
+func loadEnvFile() error {
+	path := os.Getenv("MINITOWER_RUNNER_ENV_FILE")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read MINITOWER_RUNNER_ENV_FILE: %w", err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, i+1)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+	}
+	return nil
+}
+
+// reloadConfig re-reads the configuration from MINITOWER_RUNNER_ENV_FILE
+// and the environment. A config that fails to load is logged and the
+// current one kept.
+func (r *Runner) reloadConfig() {
+	next, err := LoadConfig()
+	if err != nil {
+		r.logger.Error("config reload failed, keeping the current config", "error", err)
+		return
+	}
+	cfg := r.live.apply(next, r.logger)
+	if r.logLevel != nil {
+		r.logLevel.Set(cfg.LogLevel)
+	}
+	r.logger.Info("config reloaded", configAttrs(cfg)...)
+}
+
+// WatchConfigSignals reloads the configuration on SIGHUP and logs the
+// effective configuration on SIGUSR1 until ctx is done.
+func (r *Runner) WatchConfigSignals(ctx context.Context) {
+	reload := make(chan os.Signal, 1)
+	dump := make(chan os.Signal, 1)
+	stop := notifyConfigSignals(reload, dump)
+	defer stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			r.reloadConfig()
+		case <-dump:
+			r.logger.Info("effective config", configAttrs(r.live.current())...)
+		}
+	}
+}
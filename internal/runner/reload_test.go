@@ -0,0 +1,120 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadAppliesToTheNextRunOnly(t *testing.T) {
+	fake := &fakeRunServer{artifact: tarGz(t, map[string]string{"main.py": "print('hi')\n"})}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	cfg := &Config{
+		ServerURL:       srv.URL,
+		DataDir:         dataDir,
+		WorkDir:         filepath.Join(dataDir, workDirName),
+		PythonBin:       "/nonexistent/python-a",
+		KillGracePeriod: time.Second,
+		SetupTimeout:    10 * time.Second,
+	}
+	var logs bytes.Buffer
+	r := NewRunner(cfg, slog.New(slog.NewTextHandler(&logs, nil)))
+	if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+		t.Fatalf("create work dir: %v", err)
+	}
+
+	// The reload lands while the first run is in flight.
+	next := *cfg
+	next.PythonBin = "/nonexistent/python-b"
+	next.PollInterval = time.Minute
+	next.ServerURL = "http://elsewhere.invalid"
+	fake.onStart = func() {
+		fake.onStart = nil
+		r.live.apply(&next, r.logger)
+	}
+
+	interpreter := func() string {
+		t.Helper()
+		for _, line := range fake.lines {
+			if after, ok := strings.CutPrefix(line, "using Python interpreter at: "); ok {
+				return after
+			}
+		}
+		t.Fatalf("no interpreter line in logs: %q", fake.lines)
+		return ""
+	}
+
+	lease := &LeaseResponse{RunID: 1, LeaseToken: "lease", Entrypoint: "main.py"}
+	if err := r.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+	if got := interpreter(); got != "/nonexistent/python-a" {
+		t.Fatalf("in-flight run must keep its interpreter, got %q", got)
+	}
+
+	fake.lines = nil
+	lease = &LeaseResponse{RunID: 2, LeaseToken: "lease", Entrypoint: "main.py"}
+	if err := r.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+	if got := interpreter(); got != "/nonexistent/python-b" {
+		t.Fatalf("next run must use the reloaded interpreter, got %q", got)
+	}
+	if r.cfg.PollInterval != time.Minute || r.cfg.ServerURL != srv.URL {
+		t.Fatalf("expected poll interval reloaded and server URL kept, got %s and %q", r.cfg.PollInterval, r.cfg.ServerURL)
+	}
+	if !strings.Contains(logs.String(), "env=MINITOWER_SERVER_URL") {
+		t.Fatalf("expected a restart warning for the server URL, got:\n%s", logs.String())
+	}
+}
+
+func TestReloadConfigFromEnvFile(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), "runner.env")
+	t.Setenv("MINITOWER_SERVER_URL", "http://localhost:8080")
+	t.Setenv("MINITOWER_RUNNER_NAME", "runner-test")
+	t.Setenv("MINITOWER_RUNNER_ENV_FILE", envFile)
+	// Registered with t.Setenv so the values the file sets are restored.
+	t.Setenv("MINITOWER_LOG_LEVEL", "info")
+	t.Setenv("MINITOWER_KILL_GRACE_PERIOD", "")
+	t.Setenv("MINITOWER_POLL_INTERVAL", "")
+	writeEnv := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(envFile, []byte(content), 0o600); err != nil {
+			t.Fatalf("write env file: %v", err)
+		}
+	}
+	writeEnv("# runner settings\nMINITOWER_LOG_LEVEL=info\n")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	level := new(slog.LevelVar)
+	r := NewRunner(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	r.logLevel = level
+
+	writeEnv("MINITOWER_LOG_LEVEL=debug\n\nMINITOWER_KILL_GRACE_PERIOD=\"3s\"\n")
+	r.reloadConfig()
+	if level.Level() != slog.LevelDebug || r.live.current().KillGracePeriod != 3*time.Second {
+		t.Fatalf("expected debug level and 3s grace period, got %s and %s", level.Level(), r.live.current().KillGracePeriod)
+	}
+
+	// A config that no longer loads leaves the current one in place.
+	for _, content := range []string{"MINITOWER_POLL_INTERVAL=soon\n", "not a setting\n"} {
+		writeEnv(content)
+		before := r.live.current()
+		r.reloadConfig()
+		if r.live.current() != before {
+			t.Fatalf("%q: expected a failed reload to keep the current config", content)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package runner
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyConfigSignals relays SIGHUP to reload and SIGUSR1 to dump, and
+// returns a function that stops relaying.
+func notifyConfigSignals(reload, dump chan<- os.Signal) func() {
+	signal.Notify(reload, syscall.SIGHUP)
+	signal.Notify(dump, syscall.SIGUSR1)
+	return func() {
+		signal.Stop(reload)
+		signal.Stop(dump)
+	}
+}
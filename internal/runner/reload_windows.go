@@ -0,0 +1,9 @@
+package runner
+
+import "os"
+
+// notifyConfigSignals is a no-op on Windows, which has neither SIGHUP nor
+// SIGUSR1; configuration changes there need a restart.
+func notifyConfigSignals(reload, dump chan<- os.Signal) func() {
+	return func() {}
+}
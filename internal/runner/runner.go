@@ -0,0 +1,2220 @@
+// Package runner is the minitower runner: it registers with the server,
+// leases runs, executes them in prepared workspaces and reports their logs
+// and results. The minitower-runner binary and minitowerd's dev mode both
+// run it.
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"minitower/internal/httputil"
+	"minitower/internal/validate"
+)
+
+// Config is the runner configuration, read from the environment by
+// LoadConfig.
+type Config struct {
+	ServerURL         string
+	RunnerName        string
+	RegistrationToken string
+	// Token is a runner token issued ahead of time by an admin. When set,
+	// the runner uses it instead of registering.
+	Token       string
+	Environment string
+	DataDir     string
+	WorkDir     string
+	MinFreeDisk int64
+	// ArtifactCacheMaxBytes bounds the artifact cache under DataDir; 0
+	// disables it.
+	ArtifactCacheMaxBytes int64
+	PythonBin             string
+	// PythonBins maps the Python versions ("3.12") the runner declares at
+	// registration to their interpreters. Runs of versions needing one use
+	// it instead of PythonBin.
+	PythonBins      map[string]string
+	PollInterval    time.Duration
+	KillGracePeriod time.Duration
+	SetupTimeout    time.Duration
+	// AllowTakeover retries a registration rejected because the name is
+	// already registered, rotating the existing runner's token.
+	AllowTakeover bool
+	// GroupTracebacks logs each Python traceback on stderr as one entry
+	// instead of one entry per line.
+	GroupTracebacks bool
+	// LogStreaming sends a run's logs on one long-lived streaming request
+	// instead of a request per batch, where the server supports it.
+	LogStreaming bool
+	// LogFinalFlushWindow is how long a finished run keeps retrying log
+	// batches the server did not take before dropping them.
+	LogFinalFlushWindow time.Duration
+	// MaxLogLinesPerSec and MaxLogLinesPerRun cap the lines a run logs;
+	// lines past either are dropped with a marker. 0 disables a cap.
+	MaxLogLinesPerSec int
+	MaxLogLinesPerRun int
+	// SnapshotOnFailure uploads a diagnostic snapshot of the workspace as a
+	// run output when a run fails, of at most SnapshotMaxBytes.
+	SnapshotOnFailure bool
+	SnapshotMaxBytes  int64
+	// MetricsAddr is where the runner serves Prometheus metrics; empty
+	// serves none.
+	MetricsAddr string
+	// CACert names a PEM bundle trusted for the server's certificate in
+	// addition to the system roots; TLSInsecure skips verification.
+	CACert      string
+	TLSInsecure bool
+	LogLevel    slog.Level
+}
+
+var ErrStaleLease = errors.New("stale lease")
+
+// errRegistrationRejected is returned when the server rejects the
+// registration token, typically because it was rotated. Only a new token
+// helps, so the runner backs off instead of retrying at the poll interval.
+var errRegistrationRejected = errors.New("registration token rejected")
+
+// errLogQuota is returned when the server holds as many log lines for the
+// attempt as it accepts. Retrying cannot help, so the batch is dropped.
+var errLogQuota = errors.New("server log quota reached")
+
+const (
+	leaseSkew            = 5 * time.Second
+	minHeartbeatInterval = 2 * time.Second
+	defaultTimeout       = 300 * time.Second
+	defaultLeaseExpiry   = 60 * time.Second
+	defaultSetupTimeout  = 120 * time.Second
+	setupWaitDelay       = 5 * time.Second
+	maxRegisterBackoff   = 5 * time.Minute
+	leaseWait            = 20 * time.Second
+	logBatchSize         = 100
+	logLineMaxBytes      = 8192
+	logScanBufSize       = 64 * 1024
+	logScanMaxTokenSize  = 1 * 1024 * 1024
+	logFlushInterval     = 2 * time.Second
+	commandErrorMaxBytes = 2048
+	inputFileName        = "input.json"
+)
+
+// runState holds mutex-protected shared state for a run's lifetime.
+type runState struct {
+	mu              sync.Mutex
+	leaseExpiry     time.Time
+	cancelRequested bool
+	staleLease      bool
+	timedOut        bool
+	// timeout is the run's current timeout, counted from process start.
+	// Heartbeats may change it; timeoutChanged wakes the timeout watcher.
+	timeout        time.Duration
+	timeoutChanged chan struct{}
+}
+
+func newRunState(leaseExpiry time.Time, timeout time.Duration) *runState {
+	return &runState{leaseExpiry: leaseExpiry, timeout: timeout, timeoutChanged: make(chan struct{}, 1)}
+}
+
+func (s *runState) setLeaseExpiry(t time.Time) {
+	s.mu.Lock()
+	s.leaseExpiry = t
+	s.mu.Unlock()
+}
+
+// setTimeout records a new run timeout and reports whether it changed.
+func (s *runState) setTimeout(d time.Duration) bool {
+	s.mu.Lock()
+	changed := s.timeout != d
+	s.timeout = d
+	s.mu.Unlock()
+	if changed {
+		select {
+		case s.timeoutChanged <- struct{}{}:
+		default:
+		}
+	}
+	return changed
+}
+
+func (s *runState) currentTimeout() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.timeout
+}
+
+func (s *runState) markCancel() {
+	s.mu.Lock()
+	s.cancelRequested = true
+	s.mu.Unlock()
+}
+
+func (s *runState) markStale() {
+	s.mu.Lock()
+	s.staleLease = true
+	s.mu.Unlock()
+}
+
+func (s *runState) markTimedOut() {
+	s.mu.Lock()
+	s.timedOut = true
+	s.mu.Unlock()
+}
+
+func (s *runState) snapshot() (leaseExpiry time.Time, cancelRequested, staleLease, timedOut bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leaseExpiry, s.cancelRequested, s.staleLease, s.timedOut
+}
+
+// DefaultConfig returns the configuration defaults, without the server URL,
+// runner name, tokens or directories, which have none.
+func DefaultConfig() *Config {
+	return &Config{
+		Environment:           "default",
+		MinFreeDisk:           defaultMinFreeDisk,
+		ArtifactCacheMaxBytes: defaultArtifactCacheMaxSize,
+		PythonBin:             "python3",
+		PollInterval:          3 * time.Second,
+		KillGracePeriod:       10 * time.Second,
+		SetupTimeout:          defaultSetupTimeout,
+		LogFinalFlushWindow:   defaultLogFinalFlushWindow,
+		MaxLogLinesPerSec:     defaultMaxLogLinesPerSec,
+		MaxLogLinesPerRun:     defaultMaxLogLinesPerRun,
+		SnapshotMaxBytes:      defaultSnapshotMaxBytes,
+	}
+}
+
+// LoadConfig reads the configuration from MINITOWER_RUNNER_ENV_FILE and
+// the environment.
+func LoadConfig() (*Config, error) {
+	if err := loadEnvFile(); err != nil {
+		return nil, err
+	}
+	cfg := DefaultConfig()
+	cfg.DataDir = os.Getenv("MINITOWER_DATA_DIR")
+	cfg.WorkDir = os.Getenv("MINITOWER_WORK_DIR")
+	if err := loadProcessConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	cfg.ServerURL = os.Getenv("MINITOWER_SERVER_URL")
+	if cfg.ServerURL == "" {
+		return nil, errors.New("MINITOWER_SERVER_URL is required")
+	}
+
+	cfg.RunnerName = os.Getenv("MINITOWER_RUNNER_NAME")
+	if cfg.RunnerName == "" {
+		return nil, errors.New("MINITOWER_RUNNER_NAME is required")
+	}
+
+	cfg.RegistrationToken = os.Getenv("MINITOWER_RUNNER_REGISTRATION_TOKEN")
+	cfg.Token = strings.TrimSpace(os.Getenv("MINITOWER_RUNNER_TOKEN"))
+
+	cfg.MetricsAddr = strings.TrimSpace(os.Getenv("MINITOWER_RUNNER_METRICS_ADDR"))
+
+	cfg.CACert = strings.TrimSpace(os.Getenv("MINITOWER_CA_CERT"))
+	if cfg.CACert != "" {
+		if _, err := httputil.LoadCertPool(cfg.CACert); err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_CA_CERT: %w", err)
+		}
+	}
+	if v := os.Getenv("MINITOWER_TLS_INSECURE"); v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_TLS_INSECURE: %w", err)
+		}
+		cfg.TLSInsecure = insecure
+	}
+
+	if v := os.Getenv("MINITOWER_RUNNER_ENVIRONMENT"); v != "" {
+		cfg.Environment = v
+	}
+
+	if cfg.DataDir == "" {
+		home, _ := os.UserHomeDir()
+		cfg.DataDir = filepath.Join(home, ".minitower")
+	}
+
+	if cfg.WorkDir == "" {
+		cfg.WorkDir = filepath.Join(cfg.DataDir, workDirName)
+	}
+
+	if v := os.Getenv("MINITOWER_MIN_FREE_DISK_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_MIN_FREE_DISK_BYTES: %w", err)
+		}
+		if n < 0 {
+			return nil, errors.New("MINITOWER_MIN_FREE_DISK_BYTES must be >= 0")
+		}
+		cfg.MinFreeDisk = n
+	}
+
+	if v := os.Getenv("MINITOWER_ARTIFACT_CACHE_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_ARTIFACT_CACHE_MAX_BYTES: %w", err)
+		}
+		if n < 0 {
+			return nil, errors.New("MINITOWER_ARTIFACT_CACHE_MAX_BYTES must be >= 0")
+		}
+		cfg.ArtifactCacheMaxBytes = n
+	}
+
+	if v := os.Getenv("MINITOWER_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_POLL_INTERVAL: %w", err)
+		}
+		if d <= 0 {
+			return nil, errors.New("MINITOWER_POLL_INTERVAL must be > 0")
+		}
+		cfg.PollInterval = d
+	}
+
+	if v := os.Getenv("MINITOWER_LOG_FINAL_FLUSH_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_LOG_FINAL_FLUSH_WINDOW: %w", err)
+		}
+		if d < 0 {
+			return nil, errors.New("MINITOWER_LOG_FINAL_FLUSH_WINDOW must be >= 0")
+		}
+		cfg.LogFinalFlushWindow = d
+	}
+
+	if v := os.Getenv("MINITOWER_MAX_LOG_LINES_PER_SEC"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_MAX_LOG_LINES_PER_SEC: %w", err)
+		}
+		if n < 0 {
+			return nil, errors.New("MINITOWER_MAX_LOG_LINES_PER_SEC must be >= 0")
+		}
+		cfg.MaxLogLinesPerSec = n
+	}
+
+	if v := os.Getenv("MINITOWER_MAX_LOG_LINES_PER_RUN"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_MAX_LOG_LINES_PER_RUN: %w", err)
+		}
+		if n < 0 {
+			return nil, errors.New("MINITOWER_MAX_LOG_LINES_PER_RUN must be >= 0")
+		}
+		cfg.MaxLogLinesPerRun = n
+	}
+
+	if v := os.Getenv("MINITOWER_RUNNER_ALLOW_TAKEOVER"); v != "" {
+		allow, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_RUNNER_ALLOW_TAKEOVER: %w", err)
+		}
+		cfg.AllowTakeover = allow
+	}
+
+	if v := os.Getenv("MINITOWER_GROUP_TRACEBACKS"); v != "" {
+		group, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_GROUP_TRACEBACKS: %w", err)
+		}
+		cfg.GroupTracebacks = group
+	}
+
+	if v := os.Getenv("MINITOWER_LOG_STREAMING"); v != "" {
+		streaming, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_LOG_STREAMING: %w", err)
+		}
+		cfg.LogStreaming = streaming
+	}
+
+	if v := os.Getenv("MINITOWER_SNAPSHOT_ON_FAILURE"); v != "" {
+		snapshot, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_SNAPSHOT_ON_FAILURE: %w", err)
+		}
+		cfg.SnapshotOnFailure = snapshot
+	}
+
+	if v := os.Getenv("MINITOWER_SNAPSHOT_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_SNAPSHOT_MAX_BYTES: %w", err)
+		}
+		// The snapshot is uploaded as a run output.
+		if n <= 0 || n > maxOutputBytes {
+			return nil, fmt.Errorf("MINITOWER_SNAPSHOT_MAX_BYTES must be between 1 and %d", maxOutputBytes)
+		}
+		cfg.SnapshotMaxBytes = n
+	}
+
+	if v := os.Getenv("MINITOWER_LOG_LEVEL"); v != "" {
+		if err := cfg.LogLevel.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid MINITOWER_LOG_LEVEL: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadProcessConfig reads the settings for setting up and running a run's
+// process into cfg, leaving those that are unset as they are.
+func loadProcessConfig(cfg *Config) error {
+	if v := os.Getenv("MINITOWER_PYTHON_BIN"); v != "" {
+		cfg.PythonBin = v
+	}
+	if v := os.Getenv("MINITOWER_PYTHON_BINS"); v != "" {
+		bins, err := parsePythonBins(v)
+		if err != nil {
+			return fmt.Errorf("invalid MINITOWER_PYTHON_BINS: %w", err)
+		}
+		cfg.PythonBins = bins
+	}
+
+	if v := os.Getenv("MINITOWER_SETUP_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid MINITOWER_SETUP_TIMEOUT: %w", err)
+		}
+		if d <= 0 {
+			return errors.New("MINITOWER_SETUP_TIMEOUT must be > 0")
+		}
+		cfg.SetupTimeout = d
+	}
+
+	if v := os.Getenv("MINITOWER_KILL_GRACE_PERIOD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			cfg.KillGracePeriod = d
+		}
+	}
+	return nil
+}
+
+// Runner polls the server for leases and executes them one at a time.
+type Runner struct {
+	// cfg is the configuration snapshot this runner, or this run, works
+	// with; live holds the current one, which a reload may replace.
+	cfg        *Config
+	live       *liveConfig
+	logLevel   *slog.LevelVar
+	logger     *slog.Logger
+	httpClient *http.Client
+	token      string
+	tokenPath  string
+	stats      statsCollector
+	diskFree   func(path string) (int64, error)
+	clock      *clockSkew
+	// artifacts is nil when the artifact cache is disabled.
+	artifacts *artifactCache
+	metrics   *runnerMetrics
+	// tokenRejected records a 401 on the last poll; registerBackoff is the
+	// current wait while the registration token is rejected.
+	tokenRejected   bool
+	registerBackoff time.Duration
+}
+
+// NewRunner returns a runner for cfg. Call Run to start it.
+func NewRunner(cfg *Config, logger *slog.Logger) *Runner {
+	r := &Runner{
+		cfg:        cfg,
+		live:       &liveConfig{cfg: cfg},
+		logger:     logger,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		tokenPath:  filepath.Join(cfg.DataDir, "runner_token"),
+		stats:      newStatsCollector(cfg.DataDir),
+		diskFree:   statfsFree,
+		clock:      newClockSkew(),
+		metrics:    newRunnerMetrics(),
+	}
+	if cfg.ArtifactCacheMaxBytes > 0 {
+		r.artifacts = newArtifactCache(filepath.Join(cfg.DataDir, artifactCacheDirName), cfg.ArtifactCacheMaxBytes)
+	}
+	// LoadConfig has checked the CA bundle, so this only fails if it went
+	// away since; the system roots are used then.
+	transport, err := httputil.NewTransport(cfg.CACert, cfg.TLSInsecure)
+	if err != nil {
+		logger.Error("CA certificate not loaded, using the system roots", "ca_cert", cfg.CACert, "error", err)
+		transport, _ = httputil.NewTransport("", cfg.TLSInsecure)
+	}
+	r.httpClient.Transport = transport
+	if cfg.TLSInsecure {
+		logger.Warn("TLS certificate verification is disabled", "server_url", cfg.ServerURL)
+	}
+	return r
+}
+
+// SetLogLevel makes config reloads set level to the reloaded log level.
+func (r *Runner) SetLogLevel(level *slog.LevelVar) {
+	r.logLevel = level
+}
+
+// Run registers the runner if it has no token, then leases and executes
+// runs until ctx is done.
+func (r *Runner) Run(ctx context.Context) error {
+	if err := os.MkdirAll(r.cfg.DataDir, 0700); err != nil {
+		return fmt.Errorf("create data dir: %w", err)
+	}
+	if err := os.MkdirAll(r.workDir(), 0700); err != nil {
+		return fmt.Errorf("create work dir: %w", err)
+	}
+	if n, err := sweepWorkspaces(r.workDir(), time.Now(), workspaceMaxAge); err != nil {
+		r.logger.Warn("workspace sweep failed", "dir", r.workDir(), "error", err)
+	} else if n > 0 {
+		r.logger.Info("removed orphaned workspaces", "count", n, "dir", r.workDir())
+	}
+	if n, err := sweepLogSpools(r.logSpoolDir()); err != nil {
+		r.logger.Warn("log spool sweep failed", "dir", r.logSpoolDir(), "error", err)
+	} else if n > 0 {
+		r.logger.Info("removed orphaned log spools", "count", n, "dir", r.logSpoolDir())
+	}
+
+	if r.cfg.MetricsAddr != "" {
+		if err := r.serveMetrics(ctx, r.cfg.MetricsAddr); err != nil {
+			return err
+		}
+	}
+
+	r.loadToken()
+
+	// Register if no token
+	if r.token == "" {
+		if r.cfg.RegistrationToken == "" {
+			return errors.New("no saved token and neither MINITOWER_RUNNER_TOKEN nor MINITOWER_RUNNER_REGISTRATION_TOKEN is set")
+		}
+		if err := r.register(ctx); err != nil {
+			return fmt.Errorf("register: %w", err)
+		}
+	}
+
+	r.measureClockSkew(ctx)
+	skew, _ := r.clock.offset()
+	r.logger.Info("runner started", "name", r.cfg.RunnerName, "clock_skew_seconds", skew.Seconds())
+
+	// Main loop
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info("shutting down")
+			return nil
+		default:
+		}
+
+		held, err := r.poll(ctx)
+		if err != nil && errors.Is(err, context.Canceled) {
+			return nil
+		}
+		if held {
+			// The server already waited for work on our behalf.
+			continue
+		}
+
+		r.snapshotConfig()
+		delay := r.pollDelay(err)
+		switch {
+		case errors.Is(err, errRegistrationRejected):
+			r.logger.Error("registration token rejected; runner needs re-provisioning", "error", err, "retry_in", delay.String())
+		case err != nil:
+			r.logger.Error("poll error", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// pollDelay returns how long to wait before the next poll after one that
+// returned err: the poll interval plus jitter, or, while the server rejects
+// the registration token, a backoff doubling from the poll interval up to
+// maxRegisterBackoff.
+func (r *Runner) pollDelay(err error) time.Duration {
+	if errors.Is(err, errRegistrationRejected) {
+		r.registerBackoff = min(max(2*r.registerBackoff, r.cfg.PollInterval), maxRegisterBackoff)
+		return r.registerBackoff
+	}
+	r.registerBackoff = 0
+
+	// Add jitter to poll interval.
+	jitter := time.Duration(0)
+	if half := r.cfg.PollInterval / 2; half > 0 {
+		jitter = time.Duration(rand.Int63n(int64(half)))
+	}
+	return r.cfg.PollInterval + jitter
+}
+
+// loadToken sets the runner token from MINITOWER_RUNNER_TOKEN, saving it
+// as the runner's token file, or else from the token saved by an earlier
+// start. It leaves the token empty when there is neither.
+func (r *Runner) loadToken() {
+	if r.cfg.Token != "" {
+		r.token = r.cfg.Token
+		if err := os.WriteFile(r.tokenPath, []byte(r.token), 0600); err != nil {
+			r.logger.Warn("failed to save token", "error", err)
+		}
+		r.logger.Info("using provisioned token")
+		return
+	}
+	if data, err := os.ReadFile(r.tokenPath); err == nil {
+		r.token = strings.TrimSpace(string(data))
+		r.logger.Info("loaded saved token")
+	}
+}
+
+func (r *Runner) register(ctx context.Context) error {
+	status, body, err := r.sendRegistration(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusConflict {
+		if !r.cfg.AllowTakeover {
+			return fmt.Errorf("register failed: runner name %q is already registered; set MINITOWER_RUNNER_ALLOW_TAKEOVER=true to take it over", r.cfg.RunnerName)
+		}
+		r.logger.Warn("runner name already registered, taking it over", "name", r.cfg.RunnerName)
+		rotate := true
+		status, body, err = r.sendRegistration(ctx, &rotate)
+		if err != nil {
+			return err
+		}
+	}
+	if status == http.StatusUnauthorized {
+		return fmt.Errorf("%w: %s", errRegistrationRejected, strings.TrimSpace(string(body)))
+	}
+	if status != http.StatusCreated && status != http.StatusOK {
+		return fmt.Errorf("register failed: %d %s", status, string(body))
+	}
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+
+	r.token = result.Token
+	if err := os.WriteFile(r.tokenPath, []byte(r.token), 0600); err != nil {
+		r.logger.Warn("failed to save token", "error", err)
+	}
+
+	r.logger.Info("registered successfully")
+	return nil
+}
+
+// reregister registers again after the server rejected the runner token.
+// Without a registration token there is nothing to register with, which is
+// reported like a rejected one.
+func (r *Runner) reregister(ctx context.Context) error {
+	if r.cfg.RegistrationToken == "" {
+		return fmt.Errorf("%w: runner token unauthorized and MINITOWER_RUNNER_REGISTRATION_TOKEN is not set", errRegistrationRejected)
+	}
+	return r.register(ctx)
+}
+
+// sendRegistration posts a registration request and returns the status and
+// body. A nil rotate leaves re-registration up to the server.
+func (r *Runner) sendRegistration(ctx context.Context, rotate *bool) (int, []byte, error) {
+	payload := map[string]any{"name": r.cfg.RunnerName, "environment": r.cfg.Environment}
+	if rotate != nil {
+		payload["rotate"] = *rotate
+	}
+	if versions := r.cfg.pythonVersions(); len(versions) > 0 {
+		payload["python_versions"] = versions
+	}
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", r.cfg.ServerURL+"/api/v1/runners/register", bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+r.cfg.RegistrationToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+type LeaseResponse struct {
+	RunID          int64          `json:"run_id"`
+	RunNo          int64          `json:"run_no"`
+	RunTraceID     string         `json:"run_trace_id"`
+	TeamSlug       string         `json:"team_slug"`
+	AppSlug        string         `json:"app_slug"`
+	Environment    string         `json:"environment"`
+	VersionNo      int64          `json:"version_no"`
+	Entrypoint     string         `json:"entrypoint"`
+	TimeoutSeconds *int           `json:"timeout_seconds"`
+	ParamsSchema   map[string]any `json:"params_schema"`
+	Input          map[string]any `json:"input"`
+	AttemptID      int64          `json:"attempt_id"`
+	AttemptNo      int64          `json:"attempt_no"`
+	LeaseToken     string         `json:"lease_token"`
+	LeaseExpiresAt string         `json:"lease_expires_at"`
+	SetupScript    string         `json:"setup_script"`
+	ArtifactSHA256 string         `json:"artifact_sha256"`
+	ImportPaths    []string       `json:"import_paths"`
+	// PythonVersion is the Python version the run needs; empty for any.
+	PythonVersion string `json:"python_version"`
+}
+
+// poll asks the server for a run and executes it. It long-polls with
+// leaseWait; held reports that the server held an empty response for the
+// whole wait, so the caller can poll again without sleeping. Servers without
+// long-poll support ignore the parameter and never report held.
+func (r *Runner) poll(ctx context.Context) (held bool, err error) {
+	if r.token == "" {
+		// An earlier 401 dropped the token and registering again failed.
+		return false, r.reregister(ctx)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", r.cfg.ServerURL+"/api/v1/runs/lease?wait="+leaseWait.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+r.token)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		r.tokenRejected = false
+	}
+
+	if resp.StatusCode == http.StatusNoContent {
+		return resp.Header.Get("X-Lease-Wait-Max") != "", nil
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		// The first 401 may be a transient hiccup: keep the token for one
+		// more poll. A second one in a row means the server no longer knows
+		// it, so drop it and register again.
+		if !r.tokenRejected {
+			r.tokenRejected = true
+			return false, errors.New("lease unauthorized; retrying with the saved token")
+		}
+		r.tokenRejected = false
+		r.token = ""
+		os.Remove(r.tokenPath)
+		return false, r.reregister(ctx)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("lease failed: %d %s", resp.StatusCode, string(respBody))
+	}
+
+	var lease LeaseResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+		return false, err
+	}
+
+	run := r.withRunLogger(&lease)
+	run.logger.Info("leased run", "environment", lease.Environment)
+
+	return false, run.executeRun(ctx, &lease)
+}
+
+// withRunLogger returns a copy of r whose log lines carry the run ID and
+// trace ID, so runner logs can be matched to the server's, and the team,
+// app, run number and attempt, so a shared runner's logs can be told apart.
+func (r *Runner) withRunLogger(lease *LeaseResponse) *Runner {
+	run := *r
+	run.logger = r.logger.With(
+		"run_id", lease.RunID,
+		"run_trace_id", lease.RunTraceID,
+		"team", lease.TeamSlug,
+		"app", lease.AppSlug,
+		"run_no", lease.RunNo,
+		"attempt", lease.AttemptNo,
+	)
+	return &run
+}
+
+// setLeaseHeaders sets the headers every run-scoped API call carries: runner
+// auth, the lease token, and the run trace ID for server-side log correlation.
+func (r *Runner) setLeaseHeaders(req *http.Request, lease *LeaseResponse) {
+	req.Header.Set("Authorization", "Bearer "+r.token)
+	req.Header.Set("X-Lease-Token", lease.LeaseToken)
+	if lease.RunTraceID != "" {
+		req.Header.Set("X-Run-Trace-ID", lease.RunTraceID)
+	}
+}
+
+// workspaceResult holds the prepared workspace details.
+type workspaceResult struct {
+	Dir         string
+	ImportPaths []string
+	InputPath   string
+	OutputsDir  string
+	Cleanup     func()
+}
+
+// prepareWorkspace validates the run input against the version's params schema,
+// creates a workspace under the work directory, checks disk space, downloads
+// and unpacks the artifact, and finishes the workspace with buildWorkspace.
+// Returns the workspace result. Propagates ErrStaleLease from download; other
+// errors are submitted as user-facing failure messages.
+func (r *Runner) prepareWorkspace(ctx context.Context, lease *LeaseResponse, lc *logCollector) (*workspaceResult, error) {
+	// The schema may have been tightened after the run was queued; re-check so
+	// the process never starts with input it cannot handle.
+	if err := validate.ValidateJSONInput(lease.Input, lease.ParamsSchema); err != nil {
+		msg := fmt.Sprintf("input does not match schema: %v", err)
+		lc.logSetup(ctx, msg)
+		if submitErr := r.submitFailure(ctx, lease, failureKindUser, msg); submitErr != nil {
+			return nil, submitErr
+		}
+		return nil, err
+	}
+
+	workDir, err := r.createWorkspace(lease.RunID)
+	if err != nil {
+		lc.logSetup(ctx, "failed to create workspace")
+		if submitErr := r.submitFailure(ctx, lease, failureKindInfrastructure, "failed to create workspace"); submitErr != nil {
+			return nil, submitErr
+		}
+		return nil, err
+	}
+	cleanup := func() { os.RemoveAll(workDir) }
+
+	dl, err := r.fetchArtifact(ctx, lease, filepath.Join(workDir, "artifact.tar.gz"), lc)
+	if err != nil {
+		r.logger.Error("artifact download failed", "error", err)
+		logLine := fmt.Sprintf("artifact download failed: %v", err)
+		failureMsg := fmt.Sprintf("failed to download artifact: %v", err)
+		var diskErr *insufficientDiskError
+		if errors.As(err, &diskErr) {
+			logLine, failureMsg = diskErr.Error(), diskErr.Error()
+		}
+		lc.logSetup(ctx, logLine)
+		cleanup()
+		if errors.Is(err, ErrStaleLease) {
+			return nil, ErrStaleLease
+		}
+		if submitErr := r.submitFailure(ctx, lease, failureKindInfrastructure, failureMsg); submitErr != nil {
+			return nil, submitErr
+		}
+		return nil, err
+	}
+
+	if err := r.unpackArtifact(filepath.Join(workDir, "artifact.tar.gz"), workDir); err != nil {
+		r.logger.Error("unpack failed", "error", err)
+		lc.logSetup(ctx, fmt.Sprintf("artifact unpack failed: %v", err))
+		cleanup()
+		if submitErr := r.submitFailure(ctx, lease, failureKindInfrastructure, fmt.Sprintf("failed to unpack artifact: %v", err)); submitErr != nil {
+			return nil, submitErr
+		}
+		return nil, err
+	}
+	lc.logSetup(ctx, fmt.Sprintf("artifact unpacked (sha256: %s)", dl.SHA256))
+	r.logger.Info("artifact unpacked", "sha256", dl.SHA256)
+
+	ws, msg, err := r.buildWorkspace(ctx, lease, workDir, dl.ImportPaths, lc)
+	if err != nil {
+		r.uploadFailureSnapshot(ctx, lease, workDir, lc)
+		cleanup()
+		if submitErr := r.submitFailure(ctx, lease, failureKindOf(err), msg); submitErr != nil {
+			return nil, submitErr
+		}
+		return nil, err
+	}
+	return ws, nil
+}
+
+// buildWorkspace finishes an unpacked workspace: it checks the entrypoint and
+// import paths stay inside it, writes the input to input.json, creates the
+// outputs directory, (for Python entrypoints) creates a venv and installs
+// requirements, and runs the Towerfile setup script if there is one. On
+// failure it returns the run's failure message with the error, a
+// userFailureError for a path that escapes the workspace or a failing setup
+// script; the caller removes the workspace.
+func (r *Runner) buildWorkspace(ctx context.Context, lease *LeaseResponse, workDir string, importPaths []string, lc *logCollector) (*workspaceResult, string, error) {
+	if err := checkWorkspacePaths(workDir, lease.Entrypoint, importPaths); err != nil {
+		logLine := err.Error()
+		var wsErr *workspaceError
+		if errors.As(err, &wsErr) {
+			logLine = wsErr.Detail
+		}
+		r.logger.Error("workspace path check failed", "error", logLine)
+		lc.logSetup(ctx, logLine)
+		return nil, err.Error(), &userFailureError{err}
+	}
+
+	inputPath := filepath.Join(workDir, inputFileName)
+	if err := writeInputFile(inputPath, lease.Input); err != nil {
+		r.logger.Error("input file write failed", "error", err)
+		lc.logSetup(ctx, fmt.Sprintf("writing %s failed: %v", inputFileName, err))
+		return nil, fmt.Sprintf("failed to write input file: %v", err), err
+	}
+
+	outputsDir := filepath.Join(workDir, outputsDirName)
+	if err := os.MkdirAll(outputsDir, 0700); err != nil {
+		r.logger.Error("outputs dir create failed", "error", err)
+		lc.logSetup(ctx, fmt.Sprintf("creating %s failed: %v", outputsDirName, err))
+		return nil, fmt.Sprintf("failed to create outputs directory: %v", err), err
+	}
+
+	// Only set up Python venv for .py entrypoints.
+	if strings.HasSuffix(lease.Entrypoint, ".py") {
+		venvPath := filepath.Join(workDir, ".venv")
+		pythonBin, err := r.cfg.pythonBinFor(lease.PythonVersion)
+		if err != nil {
+			r.logger.Error("no interpreter for run", "python_version", lease.PythonVersion)
+			lc.logSetup(ctx, err.Error())
+			return nil, err.Error(), err
+		}
+		lc.logSetup(ctx, fmt.Sprintf("using Python interpreter at: %s", pythonBin))
+		lc.logSetup(ctx, "creating virtual environment at: .venv")
+		if err := r.createVenv(ctx, pythonBin, venvPath); err != nil {
+			r.logger.Error("venv creation failed", "error", err)
+			lc.logSetup(ctx, fmt.Sprintf("virtual environment creation failed: %v", err))
+			return nil, fmt.Sprintf("failed to create venv: %v", err), err
+		}
+
+		reqPath := filepath.Join(workDir, "requirements.txt")
+		if _, err := os.Stat(reqPath); err == nil {
+			lc.logSetup(ctx, "installing dependencies from requirements.txt")
+			if err := r.installRequirements(ctx, venvPath, reqPath); err != nil {
+				r.logger.Error("requirements install failed", "error", err)
+				lc.logSetup(ctx, fmt.Sprintf("dependency installation failed: %v", err))
+				return nil, fmt.Sprintf("failed to install requirements: %v", err), err
+			}
+		}
+	}
+
+	ws := &workspaceResult{
+		Dir:         workDir,
+		ImportPaths: importPaths,
+		InputPath:   inputPath,
+		OutputsDir:  outputsDir,
+		Cleanup:     func() { os.RemoveAll(workDir) },
+	}
+
+	if lease.SetupScript != "" {
+		lc.logSetup(ctx, fmt.Sprintf("running setup script: %s", lease.SetupScript))
+		if err := r.runSetupScript(ctx, lease, ws, lc); err != nil {
+			r.logger.Error("setup script failed", "error", err)
+			msg := fmt.Sprintf("setup script failed: %v", err)
+			lc.logSetup(ctx, msg)
+			return nil, msg, &userFailureError{err}
+		}
+	}
+
+	return ws, "", nil
+}
+
+// runSetupScript runs the Towerfile setup script in the workspace with the
+// entrypoint's environment, streaming its output as setup logs. Like the pip
+// install it runs under the run context, so cancellation and stale-lease
+// fencing kill it.
+func (r *Runner) runSetupScript(ctx context.Context, lease *LeaseResponse, ws *workspaceResult, lc *logCollector) error {
+	setupCtx, cancel := context.WithTimeout(ctx, r.cfg.SetupTimeout)
+	defer cancel()
+
+	sh, err := shell()
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(setupCtx, sh, filepath.Join(ws.Dir, lease.SetupScript))
+	cmd.Dir = ws.Dir
+	cmd.Env = r.processEnv(lease, ws)
+	// Background children may keep the output pipe open after a kill.
+	cmd.WaitDelay = setupWaitDelay
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		lc.collect(ctx, pr, "stderr")
+	}()
+
+	err = cmd.Run()
+	pw.Close()
+	<-collected
+	lc.flush(ctx)
+
+	if errors.Is(setupCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return fmt.Errorf("timed out after %s", r.cfg.SetupTimeout)
+	}
+	return err
+}
+
+// runHeartbeat runs the heartbeat loop until the run context is cancelled.
+func (r *Runner) runHeartbeat(runCtx context.Context, lease *LeaseResponse, state *runState, terminate func(string)) {
+	for {
+		expiry, _, _, _ := state.snapshot()
+		timer := time.NewTimer(r.heartbeatInterval(expiry, time.Now()))
+		select {
+		case <-runCtx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		resp, err := r.heartbeat(context.Background(), lease)
+		if err != nil {
+			if errors.Is(err, ErrStaleLease) {
+				r.logger.Warn("stale lease on heartbeat")
+				state.markStale()
+				terminate("stale lease")
+				return
+			}
+			r.logger.Error("heartbeat failed", "error", err)
+			expiry, _, _, _ := state.snapshot()
+			if r.leaseExpired(expiry, time.Now()) {
+				r.logger.Warn("lease expired, self-fencing")
+				state.markStale()
+				terminate("lease expired")
+				return
+			}
+			continue
+		}
+		if t, err := parseServerTime(resp.LeaseExpiresAt); err == nil {
+			state.setLeaseExpiry(t)
+		}
+		r.applyTimeout(resp, state)
+		if resp.CancelRequested {
+			state.markCancel()
+			terminate("cancel requested")
+			return
+		}
+	}
+}
+
+// runProcess sets up and runs the user process, streams logs, and submits the final result.
+// The heartbeat goroutine is already running; heartbeatDone closes when it exits.
+func (r *Runner) runProcess(ctx context.Context, runCtx context.Context, cancel context.CancelFunc, lease *LeaseResponse, state *runState, ws *workspaceResult, lc *logCollector, heartbeatDone <-chan struct{}, baseTerminate func(string)) error {
+	if err := validateWorkspace(ws, lease); err != nil {
+		logLine := err.Error()
+		var wsErr *workspaceError
+		if errors.As(err, &wsErr) {
+			logLine = wsErr.Detail
+		}
+		r.logger.Error("workspace check failed", "error", logLine)
+		lc.logSetup(ctx, logLine)
+		r.uploadFailureSnapshot(ctx, lease, ws.Dir, lc)
+		lc.flushRemaining()
+		cancel()
+		<-heartbeatDone
+		return r.submitFailure(ctx, lease, failureKindOf(err), err.Error())
+	}
+
+	cmd := r.entrypointCommand(lease, ws)
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+
+	processDone := make(chan struct{})
+	// Wrap baseTerminate to also signal the process.
+	var killOnce sync.Once
+	terminate := func(reason string) {
+		baseTerminate(reason)
+		killOnce.Do(func() { r.stopProcess(cmd, processDone) })
+	}
+
+	if runCtx.Err() != nil {
+		<-heartbeatDone
+		_, wasCancelled, isStale, _ := state.snapshot()
+		if isStale {
+			r.logger.Warn("stale lease before process start")
+			return nil
+		}
+		if wasCancelled {
+			r.logger.Info("run cancelled before process start")
+			return r.submitResultSafe(ctx, lease, "cancelled", nil, nil, "")
+		}
+		return nil
+	}
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		<-heartbeatDone
+		r.logger.Error("process start failed", "error", err)
+		return r.submitFailure(ctx, lease, failureKindInfrastructure, "failed to start process")
+	}
+
+	// Timeout watcher. The deadline is recomputed whenever a heartbeat
+	// changes the timeout, so a timeout lowered below the elapsed time
+	// terminates the run right away.
+	processStart := time.Now()
+	timeoutDone := make(chan struct{})
+	go func() {
+		defer close(timeoutDone)
+		for {
+			timer := time.NewTimer(time.Until(processStart.Add(state.currentTimeout())))
+			select {
+			case <-runCtx.Done():
+				timer.Stop()
+				return
+			case <-state.timeoutChanged:
+				timer.Stop()
+				continue
+			case <-timer.C:
+				state.markTimedOut()
+				terminate("timeout")
+				return
+			}
+		}
+	}()
+
+	// Stream logs
+	logFlushDone := make(chan struct{})
+	go func() {
+		defer close(logFlushDone)
+		lc.periodicFlush(runCtx)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		lc.collect(runCtx, stdout, "stdout")
+	}()
+	go func() {
+		defer wg.Done()
+		lc.collect(runCtx, stderr, "stderr")
+	}()
+
+	// Wait for process
+	waitErr := cmd.Wait()
+	close(processDone)
+	wg.Wait()
+	cancel()
+	<-heartbeatDone
+	<-logFlushDone
+	<-timeoutDone
+
+	if _, wasCancelled, isStale, wasTimedOut := state.snapshot(); waitErr == nil && !wasCancelled && !isStale && !wasTimedOut {
+		r.uploadOutputs(ctx, lease, state, ws, lc)
+	}
+
+	if reason := finalFailureLogLine(state, waitErr); reason != "" {
+		lc.logSetup(context.Background(), reason)
+		r.uploadFailureSnapshot(ctx, lease, ws.Dir, lc)
+	}
+	lc.flushRemaining()
+
+	return r.submitFinalResult(ctx, lease, state, waitErr)
+}
+
+// entrypointCommand builds the command that runs the lease's entrypoint in
+// ws, with the run's environment. The workspace must have passed
+// validateWorkspace.
+func (r *Runner) entrypointCommand(lease *LeaseResponse, ws *workspaceResult) *exec.Cmd {
+	entrypoint := filepath.Join(ws.Dir, lease.Entrypoint)
+
+	// Build the command based on entrypoint extension.
+	var cmd *exec.Cmd
+	if strings.HasSuffix(lease.Entrypoint, ".sh") {
+		// validateWorkspace has checked there is a shell.
+		sh, _ := shell()
+		cmd = exec.Command(sh, entrypoint)
+	} else {
+		// Force unbuffered Python stdio so logs stream during execution.
+		cmd = exec.Command(venvPython(filepath.Join(ws.Dir, ".venv")), "-u", entrypoint)
+	}
+	cmd.Dir = ws.Dir
+	configureProcess(cmd)
+	cmd.Env = r.processEnv(lease, ws)
+	return cmd
+}
+
+// stopProcess asks a started process to stop, and kills it if it has not
+// exited, as signalled by done, within the kill grace period.
+func (r *Runner) stopProcess(cmd *exec.Cmd, done <-chan struct{}) {
+	if cmd.Process == nil {
+		return
+	}
+	_ = terminateProcess(cmd.Process)
+	go func() {
+		timer := time.NewTimer(r.cfg.KillGracePeriod)
+		defer timer.Stop()
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+		_ = cmd.Process.Kill()
+	}()
+}
+
+// snapshotWorkspaceDir stands in for the workspace directory in the
+// environment snapshot, which is sent before the workspace exists.
+const snapshotWorkspaceDir = "<workspace>"
+
+// processEnv returns the environment shared by the setup script and the
+// entrypoint process.
+func (r *Runner) processEnv(lease *LeaseResponse, ws *workspaceResult) []string {
+	env := r.buildProcessEnv(os.Environ(), lease.Input)
+	env = setWorkspaceEnv(env, ws)
+
+	// For Python entrypoints, prepend import paths to PYTHONPATH.
+	if dirs := pythonImportDirs(lease, ws); len(dirs) > 0 {
+		env = append(env, "PYTHONPATH="+pythonPath(dirs, os.Getenv("PYTHONPATH")))
+	}
+	return env
+}
+
+// envSnapshot returns the variables the runner sets for the run's processes,
+// for the start call: the input-derived variables, the MINITOWER_* paths and
+// the PYTHONPATH entries the runner prepends. Nothing inherited from the
+// runner's own environment is included. Workspace paths are given under
+// snapshotWorkspaceDir.
+func (r *Runner) envSnapshot(lease *LeaseResponse) map[string]string {
+	ws := &workspaceResult{
+		Dir:         snapshotWorkspaceDir,
+		ImportPaths: lease.ImportPaths,
+		InputPath:   filepath.Join(snapshotWorkspaceDir, inputFileName),
+		OutputsDir:  filepath.Join(snapshotWorkspaceDir, outputsDirName),
+	}
+	env := setWorkspaceEnv(r.buildProcessEnv(nil, lease.Input), ws)
+	if dirs := pythonImportDirs(lease, ws); len(dirs) > 0 {
+		env = setEnvVar(env, "PYTHONPATH", strings.Join(dirs, string(os.PathListSeparator)))
+	}
+	return envToMap(env)
+}
+
+// setWorkspaceEnv sets the variables that point a run's processes at its
+// workspace.
+func setWorkspaceEnv(env []string, ws *workspaceResult) []string {
+	env = setEnvVar(env, "MINITOWER_INPUT_PATH", ws.InputPath)
+	return setEnvVar(env, "MINITOWER_OUTPUTS_DIR", ws.OutputsDir)
+}
+
+// pythonImportDirs returns the workspace import paths to prepend to
+// PYTHONPATH, or nil when the entrypoint is not a Python script.
+func pythonImportDirs(lease *LeaseResponse, ws *workspaceResult) []string {
+	if !strings.HasSuffix(lease.Entrypoint, ".py") || len(ws.ImportPaths) == 0 {
+		return nil
+	}
+	resolved := make([]string, len(ws.ImportPaths))
+	for i, p := range ws.ImportPaths {
+		resolved[i] = filepath.Join(ws.Dir, p)
+	}
+	return resolved
+}
+
+func (r *Runner) executeRun(ctx context.Context, lease *LeaseResponse) error {
+	r.snapshotConfig()
+	r.metrics.runStarted()
+	defer r.metrics.runFinished()
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Parse lease expiry
+	leaseExpiry, err := parseServerTime(lease.LeaseExpiresAt)
+	if err != nil {
+		leaseExpiry = r.clock.serverNow().Add(defaultLeaseExpiry)
+	}
+
+	// Start the run
+	startResp, err := r.startRun(runCtx, lease)
+	if errors.Is(err, ErrStaleLease) {
+		r.logger.Warn("stale lease on start")
+		return nil
+	}
+	if err != nil {
+		r.logger.Error("start failed", "error", err)
+		return err
+	}
+
+	// Update lease expiry from response
+	if t, err := parseServerTime(startResp.LeaseExpiresAt); err == nil {
+		leaseExpiry = t
+	}
+
+	// Check for early cancel
+	if startResp.CancelRequested {
+		r.logger.Info("run cancelled before start")
+		return r.submitResultSafe(ctx, lease, "cancelled", nil, nil, "")
+	}
+
+	timeout := defaultTimeout
+	if lease.TimeoutSeconds != nil {
+		timeout = time.Duration(*lease.TimeoutSeconds) * time.Second
+	}
+	state := newRunState(leaseExpiry, timeout)
+	r.applyTimeout(startResp, state)
+
+	// Start heartbeat immediately so the lease stays alive during workspace prep.
+	heartbeatDone := make(chan struct{})
+	var terminateOnce sync.Once
+	terminate := func(reason string) {
+		terminateOnce.Do(func() {
+			r.logger.Warn("terminating run", "reason", reason)
+			cancel()
+		})
+	}
+	go func() {
+		defer close(heartbeatDone)
+		r.runHeartbeat(runCtx, lease, state, terminate)
+	}()
+
+	lc := newLogCollector(r, lease, state, terminate)
+	defer lc.removeSpool()
+	defer lc.closeStream()
+
+	// Prepare workspace
+	ws, err := r.prepareWorkspace(runCtx, lease, lc)
+	if err != nil {
+		cancel()
+		<-heartbeatDone
+		if errors.Is(err, ErrStaleLease) {
+			r.logger.Warn("stale lease during workspace preparation")
+			return nil
+		}
+		lc.flushRemaining()
+		// submitFailure already called inside prepareWorkspace
+		return nil
+	}
+	defer ws.Cleanup()
+
+	return r.runProcess(ctx, runCtx, cancel, lease, state, ws, lc, heartbeatDone, terminate)
+}
+
+type AttemptResponse struct {
+	LeaseExpiresAt  string `json:"lease_expires_at"`
+	CancelRequested bool   `json:"cancel_requested"`
+	ServerTime      string `json:"server_time"`
+	// TimeoutSeconds is the version's current timeout; absent when the
+	// version has none, which keeps the timeout from the lease.
+	TimeoutSeconds *int `json:"timeout_seconds,omitempty"`
+}
+
+// applyTimeout updates the run's timeout from a start or heartbeat response.
+func (r *Runner) applyTimeout(resp *AttemptResponse, state *runState) {
+	if resp.TimeoutSeconds == nil {
+		return
+	}
+	timeout := time.Duration(*resp.TimeoutSeconds) * time.Second
+	if state.setTimeout(timeout) {
+		r.logger.Info("run timeout changed", "timeout", timeout)
+	}
+}
+
+// startRun marks the attempt running and sends the environment snapshot the
+// server keeps for the attempt.
+func (r *Runner) startRun(ctx context.Context, lease *LeaseResponse) (*AttemptResponse, error) {
+	data, err := json.Marshal(map[string]any{"env_snapshot": r.envSnapshot(lease)})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/runs/%d/start", r.cfg.ServerURL, lease.RunID), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	r.setLeaseHeaders(req, lease)
+
+	sent := time.Now()
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if isStaleLeaseStatus(resp.StatusCode) {
+			return nil, ErrStaleLease
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("start failed: %d %s", resp.StatusCode, string(respBody))
+	}
+
+	var result AttemptResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	r.observeServerTime(result.ServerTime, sent, time.Now())
+	return &result, nil
+}
+
+func (r *Runner) heartbeat(ctx context.Context, lease *LeaseResponse) (*AttemptResponse, error) {
+	payload := make(map[string]any)
+	if r.stats != nil {
+		if stats := r.stats.Collect(); stats != nil {
+			payload["stats"] = stats
+		}
+	}
+	if skew, ok := r.clock.offset(); ok {
+		payload["clock_skew_seconds"] = skew.Seconds()
+	}
+	var body io.Reader
+	if len(payload) > 0 {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/runs/%d/heartbeat", r.cfg.ServerURL, lease.RunID), body)
+	if err != nil {
+		return nil, err
+	}
+	r.setLeaseHeaders(req, lease)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	sent := time.Now()
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if isStaleLeaseStatus(resp.StatusCode) {
+			return nil, ErrStaleLease
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("heartbeat failed: %d %s", resp.StatusCode, string(respBody))
+	}
+
+	var result AttemptResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	r.observeServerTime(result.ServerTime, sent, time.Now())
+	return &result, nil
+}
+
+// downloadResult holds the artifact download metadata.
+type downloadResult struct {
+	SHA256      string
+	ImportPaths []string
+}
+
+// fetchArtifact places the run's artifact at destPath, from the artifact
+// cache when the lease names an artifact it holds and from the server
+// otherwise. Downloads are added to the cache; failing to cache one only
+// logs a warning.
+func (r *Runner) fetchArtifact(ctx context.Context, lease *LeaseResponse, destPath string, lc *logCollector) (*downloadResult, error) {
+	if r.artifacts != nil && lease.ArtifactSHA256 != "" {
+		hit, err := r.artifacts.restore(lease.ArtifactSHA256, destPath)
+		if err != nil {
+			r.logger.Warn("artifact cache read failed", "error", err)
+		}
+		if hit {
+			lc.logSetup(ctx, fmt.Sprintf("artifact cache hit (sha256: %s)", lease.ArtifactSHA256))
+			return &downloadResult{SHA256: lease.ArtifactSHA256, ImportPaths: lease.ImportPaths}, nil
+		}
+		lc.logSetup(ctx, fmt.Sprintf("artifact cache miss (sha256: %s)", lease.ArtifactSHA256))
+	}
+
+	lc.logSetup(ctx, "downloading run artifact")
+	dl, err := r.downloadArtifact(ctx, lease, destPath)
+	if err != nil {
+		return nil, err
+	}
+	if r.artifacts != nil {
+		if err := r.artifacts.store(dl.SHA256, destPath); err != nil {
+			r.logger.Warn("artifact cache write failed", "error", err)
+		}
+	}
+	return dl, nil
+}
+
+func (r *Runner) downloadArtifact(ctx context.Context, lease *LeaseResponse, destPath string) (*downloadResult, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v1/runs/%d/artifact", r.cfg.ServerURL, lease.RunID), nil)
+	if err != nil {
+		return nil, err
+	}
+	r.setLeaseHeaders(req, lease)
+
+	started := time.Now()
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if isStaleLeaseStatus(resp.StatusCode) {
+			return nil, ErrStaleLease
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("download failed: %d %s", resp.StatusCode, string(respBody))
+	}
+
+	expectedSHA256 := resp.Header.Get("X-Artifact-SHA256")
+
+	// Fail before writing anything: a partial artifact on a full disk only
+	// surfaces later as a confusing tar or pip error.
+	if err := r.checkDiskSpace(filepath.Dir(destPath), resp.ContentLength); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(destPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hasher), resp.Body); err != nil {
+		return nil, err
+	}
+	r.metrics.artifactDownloaded(lease, time.Since(started))
+
+	actualSHA256 := hex.EncodeToString(hasher.Sum(nil))
+	if expectedSHA256 != "" && actualSHA256 != expectedSHA256 {
+		return nil, fmt.Errorf("sha256 mismatch: expected %s, got %s", expectedSHA256, actualSHA256)
+	}
+
+	result := &downloadResult{SHA256: actualSHA256}
+	if raw := resp.Header.Get("X-Import-Paths"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &result.ImportPaths); err != nil {
+			r.logger.Warn("invalid X-Import-Paths header", "error", err)
+		}
+	}
+
+	return result, nil
+}
+
+func (r *Runner) unpackArtifact(artifactPath, destDir string) error {
+	cmd := exec.Command("tar", "-xzf", artifactPath, "-C", destDir)
+	return runCommand(cmd)
+}
+
+func (r *Runner) createVenv(ctx context.Context, pythonBin, venvPath string) error {
+	cmd := exec.CommandContext(ctx, pythonBin, "-m", "venv", venvPath)
+	return runCommand(cmd)
+}
+
+func (r *Runner) installRequirements(ctx context.Context, venvPath, reqPath string) error {
+	pip := venvExecutable(runtime.GOOS, venvPath, "pip")
+	args := []string{"install", "-r", reqPath}
+	if r.cfg.SnapshotOnFailure {
+		// Kept for the failure snapshot.
+		args = append(args, "--log", filepath.Join(filepath.Dir(venvPath), pipLogName))
+	}
+	cmd := exec.CommandContext(ctx, pip, args...)
+	return runCommand(cmd)
+}
+
+func runCommand(cmd *exec.Cmd) error {
+	captured := &cappedBuffer{maxBytes: commandErrorMaxBytes}
+	cmd.Stdout = captured
+	cmd.Stderr = captured
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	message := strings.TrimSpace(captured.String())
+	if message == "" {
+		return err
+	}
+	message = strings.ReplaceAll(message, "\r\n", "\n")
+	message = strings.ReplaceAll(message, "\n", " | ")
+	if captured.truncated {
+		message += "...(truncated)"
+	}
+	return fmt.Errorf("%v: %s", err, message)
+}
+
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	maxBytes  int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.maxBytes <= 0 {
+		return len(p), nil
+	}
+	remaining := b.maxBytes - b.buf.Len()
+	if remaining <= 0 {
+		b.truncated = true
+		return len(p), nil
+	}
+	if len(p) > remaining {
+		_, _ = b.buf.Write(p[:remaining])
+		b.truncated = true
+		return len(p), nil
+	}
+	_, _ = b.buf.Write(p)
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	return b.buf.String()
+}
+
+type logEntry struct {
+	Seq      int64  `json:"seq"`
+	Stream   string `json:"stream"`
+	Line     string `json:"line"`
+	LoggedAt string `json:"logged_at"`
+}
+
+// logCollector buffers log lines and flushes them in batches.
+type logCollector struct {
+	r     *Runner
+	lease *LeaseResponse
+	state *runState
+
+	mu   sync.Mutex
+	logs []logEntry
+	seq  int64
+	// traceback is the stderr traceback being grouped, if any.
+	traceback *tracebackGroup
+	limiter   *logLimiter
+
+	// spool keeps batches the server did not take; nil without a DataDir.
+	// spoolRetryAt and spoolBackoff pace periodicFlush's retries.
+	spool        *logSpool
+	spoolRetryAt time.Time
+	spoolBackoff time.Duration
+
+	// deliver hands a batch to wherever the run's logs go: the server for
+	// leased runs, the terminal for local exec runs.
+	deliver func(ctx context.Context, entries []logEntry) error
+	// stream is the log stream deliver writes to with LogStreaming; nil
+	// otherwise.
+	stream *logStream
+
+	terminate func(string)
+}
+
+func newLogCollector(r *Runner, lease *LeaseResponse, state *runState, terminate func(string)) *logCollector {
+	lc := &logCollector{
+		r:         r,
+		lease:     lease,
+		state:     state,
+		limiter:   newLogLimiter(r.cfg.MaxLogLinesPerSec, r.cfg.MaxLogLinesPerRun),
+		terminate: terminate,
+	}
+	lc.deliver = func(ctx context.Context, entries []logEntry) error {
+		return r.flushLogs(ctx, lease, entries)
+	}
+	if r.cfg.LogStreaming {
+		lc.stream = newLogStream(r, lease)
+		lc.deliver = lc.stream.deliver
+	}
+	if r.cfg.DataDir != "" {
+		lc.spool = newLogSpool(r.logSpoolDir(), lease)
+	}
+	return lc
+}
+
+// enqueue buffers a line and returns a full batch to flush, if any. With
+// GroupTracebacks, stderr traceback lines are held until the traceback
+// completes; any other line ends an open traceback first.
+func (lc *logCollector) enqueue(stream, line string) []logEntry {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.traceback != nil {
+		if stream == "stderr" && lc.traceback.add(line) {
+			if lc.traceback.done {
+				lc.endTracebackLocked()
+			}
+			return lc.fullBatchLocked()
+		}
+		lc.endTracebackLocked()
+	}
+	if lc.r.cfg.GroupTracebacks && stream == "stderr" && strings.HasPrefix(line, tracebackHeader) {
+		lc.traceback = newTracebackGroup(line, time.Now())
+		return nil
+	}
+	lc.appendLocked(stream, line)
+	return lc.fullBatchLocked()
+}
+
+// appendLocked buffers a line the limiter admits, after any markers it
+// returns.
+func (lc *logCollector) appendLocked(stream, line string) {
+	markers, keep := lc.limiter.admit(time.Now())
+	for _, marker := range markers {
+		lc.appendEntryLocked("stderr", marker)
+	}
+	if keep {
+		lc.appendEntryLocked(stream, line)
+	}
+}
+
+func (lc *logCollector) appendEntryLocked(stream, line string) {
+	if len(line) > logLineMaxBytes {
+		line = line[:logLineMaxBytes]
+	}
+	lc.seq++
+	lc.logs = append(lc.logs, logEntry{
+		Seq:      lc.seq,
+		Stream:   stream,
+		Line:     line,
+		LoggedAt: time.Now().UTC().Format(timestampLayout),
+	})
+}
+
+// fullBatchLocked takes the buffer once it holds a full batch.
+func (lc *logCollector) fullBatchLocked() []logEntry {
+	if len(lc.logs) < logBatchSize {
+		return nil
+	}
+	toFlush := lc.logs
+	lc.logs = nil
+	return toFlush
+}
+
+// endTracebackLocked buffers the open traceback as newline-joined entries
+// within the line cap.
+func (lc *logCollector) endTracebackLocked() {
+	if lc.traceback == nil {
+		return
+	}
+	for _, entry := range lc.traceback.entries(logLineMaxBytes) {
+		lc.appendLocked("stderr", entry)
+	}
+	lc.traceback = nil
+}
+
+func (lc *logCollector) logSetup(ctx context.Context, line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	if toFlush := lc.enqueue("stderr", line); len(toFlush) > 0 {
+		if err := lc.send(ctx, toFlush); err != nil {
+			if errors.Is(err, ErrStaleLease) {
+				lc.r.logger.Warn("stale lease on setup log flush")
+				lc.state.markStale()
+				lc.terminate("stale lease")
+				return
+			}
+			lc.r.logger.Warn("setup log flush failed", "error", err)
+			return
+		}
+	}
+	lc.flush(ctx)
+}
+
+// collect reads lines from reader and appends them to the log buffer, flushing when the batch is full.
+func (lc *logCollector) collect(ctx context.Context, reader io.Reader, stream string) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, logScanBufSize), logScanMaxTokenSize)
+	for scanner.Scan() {
+		if toFlush := lc.enqueue(stream, scanner.Text()); len(toFlush) > 0 {
+			if err := lc.send(ctx, toFlush); err != nil {
+				if errors.Is(err, ErrStaleLease) {
+					lc.r.logger.Warn("stale lease on log flush")
+					lc.state.markStale()
+					lc.terminate("stale lease")
+					return
+				}
+				lc.r.logger.Warn("log flush failed", "error", err)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		lc.r.logger.Warn("log collection failed", "stream", stream, "error", err)
+		lc.terminate("log collection failed")
+	}
+
+	// stderr ended; a traceback cut short is logged as it stands.
+	if stream == "stderr" {
+		lc.mu.Lock()
+		lc.endTracebackLocked()
+		lc.mu.Unlock()
+	}
+}
+
+// periodicFlush flushes buffered logs at regular intervals until ctx is cancelled.
+func (lc *logCollector) periodicFlush(ctx context.Context) {
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lc.flush(ctx)
+			lc.retrySpool(ctx, time.Now())
+			lc.closeIdleStream(time.Now())
+		}
+	}
+}
+
+// flush sends any buffered logs to the server. A traceback open for a
+// whole flush interval is sent as it stands rather than held back longer.
+func (lc *logCollector) flush(ctx context.Context) {
+	lc.mu.Lock()
+	if lc.traceback != nil && time.Since(lc.traceback.started) >= logFlushInterval {
+		lc.endTracebackLocked()
+	}
+	if marker := lc.limiter.rollWindow(time.Now()); marker != "" {
+		lc.appendEntryLocked("stderr", marker)
+	}
+	if len(lc.logs) == 0 {
+		lc.mu.Unlock()
+		return
+	}
+	toFlush := lc.logs
+	lc.logs = nil
+	lc.mu.Unlock()
+	if err := lc.send(ctx, toFlush); err != nil {
+		if errors.Is(err, ErrStaleLease) {
+			lc.r.logger.Warn("stale lease on log flush")
+			lc.state.markStale()
+			lc.terminate("stale lease")
+			return
+		}
+		lc.r.logger.Warn("log flush failed", "error", err)
+	}
+}
+
+// flushRemaining sends any remaining buffered logs using a background
+// context, then keeps retrying the spool for up to LogFinalFlushWindow. The
+// spool is removed and the log stream closed either way.
+func (lc *logCollector) flushRemaining() {
+	defer lc.removeSpool()
+	defer lc.closeStream()
+	_, _, isStale, _ := lc.state.snapshot()
+	if isStale {
+		return
+	}
+	lc.mu.Lock()
+	lc.endTracebackLocked()
+	if marker := lc.limiter.takeDropped(); marker != "" {
+		lc.appendEntryLocked("stderr", marker)
+	}
+	remaining := lc.logs
+	lc.logs = nil
+	lc.mu.Unlock()
+	if len(remaining) > 0 {
+		if err := lc.send(context.Background(), remaining); err != nil {
+			if errors.Is(err, ErrStaleLease) {
+				lc.r.logger.Warn("stale lease on final log flush")
+				lc.state.markStale()
+				return
+			}
+			lc.r.logger.Warn("final log flush failed", "error", err)
+		}
+	}
+	lc.finishSpool()
+}
+
+// closeStream ends the log stream, if any, once the server has stored
+// every line written to it.
+func (lc *logCollector) closeStream() {
+	if lc.stream == nil {
+		return
+	}
+	if err := lc.stream.close(); err != nil {
+		if errors.Is(err, ErrStaleLease) {
+			lc.r.logger.Warn("stale lease on log stream close")
+			lc.state.markStale()
+			return
+		}
+		lc.r.logger.Warn("log stream close failed", "error", err)
+	}
+}
+
+// closeIdleStream ends a log stream that has carried nothing for a while,
+// before the server gives up on it. The next batch opens a new one.
+func (lc *logCollector) closeIdleStream(now time.Time) {
+	if lc.stream == nil {
+		return
+	}
+	if err := lc.stream.closeIdle(now); err != nil {
+		if errors.Is(err, ErrStaleLease) {
+			lc.r.logger.Warn("stale lease on idle log stream close")
+			lc.state.markStale()
+			lc.terminate("stale lease")
+			return
+		}
+		lc.r.logger.Warn("idle log stream close failed", "error", err)
+	}
+}
+
+// removeSpool deletes the attempt's spool file, if any.
+func (lc *logCollector) removeSpool() {
+	if lc.spool == nil {
+		return
+	}
+	if err := lc.spool.remove(); err != nil {
+		lc.r.logger.Warn("remove log spool failed", "error", err)
+	}
+}
+
+// send delivers entries, spooling them if the server cannot take them.
+// Once anything is spooled, later batches queue behind it so lines reach
+// the server in seq order. A stale lease or the server's log quota is
+// returned, never spooled.
+func (lc *logCollector) send(ctx context.Context, entries []logEntry) error {
+	if lc.spool != nil && lc.spool.pending() {
+		return lc.spool.append(entries)
+	}
+	err := lc.deliver(ctx, entries)
+	if err == nil || errors.Is(err, ErrStaleLease) || errors.Is(err, errLogQuota) || lc.spool == nil {
+		return err
+	}
+	lc.r.logger.Warn("log flush failed, spooling", "error", err, "lines", len(entries))
+	return lc.spool.append(entries)
+}
+
+// drainSpool sends the spooled entries in batches, discarding each batch
+// the server takes or refuses for its log quota. It stops at the first
+// other failure.
+func (lc *logCollector) drainSpool(ctx context.Context) error {
+	entries, err := lc.spool.read()
+	if err != nil {
+		return err
+	}
+	for len(entries) > 0 {
+		n := min(len(entries), logBatchSize)
+		if err := lc.deliver(ctx, entries[:n]); err != nil && !errors.Is(err, errLogQuota) {
+			return err
+		}
+		if err := lc.spool.discard(n); err != nil {
+			return err
+		}
+		entries = entries[n:]
+	}
+	return nil
+}
+
+// retrySpool drains the spool while the run is live, backing off from
+// logFlushInterval up to logSpoolMaxBackoff between failed tries.
+func (lc *logCollector) retrySpool(ctx context.Context, now time.Time) {
+	if lc.spool == nil || !lc.spool.pending() || now.Before(lc.spoolRetryAt) {
+		return
+	}
+	err := lc.drainSpool(ctx)
+	if err == nil {
+		lc.spoolBackoff = 0
+		lc.r.logger.Info("log spool delivered")
+		return
+	}
+	if errors.Is(err, ErrStaleLease) {
+		lc.r.logger.Warn("stale lease on log spool retry")
+		lc.state.markStale()
+		lc.terminate("stale lease")
+		return
+	}
+	lc.spoolBackoff = min(max(2*lc.spoolBackoff, logFlushInterval), logSpoolMaxBackoff)
+	lc.spoolRetryAt = now.Add(lc.spoolBackoff)
+	lc.r.logger.Warn("log spool retry failed", "error", err, "retry_in", lc.spoolBackoff.String())
+}
+
+// finishSpool retries the spool until it is delivered or the final flush
+// window has passed, and reports the lines it had to give up on.
+func (lc *logCollector) finishSpool() {
+	if lc.spool == nil || !lc.spool.pending() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lc.r.cfg.LogFinalFlushWindow)
+	defer cancel()
+	delay := logSpoolFinalRetryDelay
+	for {
+		err := lc.drainSpool(ctx)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrStaleLease) {
+			lc.r.logger.Warn("stale lease on final log spool flush")
+			lc.state.markStale()
+			return
+		}
+		select {
+		case <-ctx.Done():
+			lost, _ := lc.spool.read()
+			lc.r.logger.Warn("final log spool flush failed, dropping lines", "error", err, "lines", len(lost))
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, logSpoolMaxBackoff)
+	}
+}
+
+func (r *Runner) flushLogs(ctx context.Context, lease *LeaseResponse, logs []logEntry) error {
+	body, _ := json.Marshal(map[string]any{"logs": logs})
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/runs/%d/logs", r.cfg.ServerURL, lease.RunID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.setLeaseHeaders(req, lease)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if isStaleLeaseStatus(resp.StatusCode) {
+			return ErrStaleLease
+		}
+		if resp.StatusCode == http.StatusRequestEntityTooLarge {
+			return errLogQuota
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("log flush failed: %d %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+func (r *Runner) buildProcessEnv(base []string, input map[string]any) []string {
+	env := append([]string(nil), base...)
+	env = unsetEnvVar(env, "MINITOWER_INPUT")
+	if input == nil {
+		return env
+	}
+
+	for key, value := range input {
+		if !validEnvKey(key) {
+			r.logger.Warn("skipping input key for env var export", "key", key)
+			continue
+		}
+		env = setEnvVar(env, key, inputValueToEnvString(value))
+	}
+	return env
+}
+
+// envToMap turns KEY=VALUE entries into a map. Entries without '=' are
+// skipped.
+func envToMap(env []string) map[string]string {
+	out := make(map[string]string, len(env))
+	for _, kv := range env {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// writeInputFile writes the raw run input as JSON so scripts can read nested
+// values without going through the flattened env vars.
+func writeInputFile(path string, input map[string]any) error {
+	if input == nil {
+		input = map[string]any{}
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("encode input: %w", err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func setEnvVar(env []string, key, value string) []string {
+	if key == "" {
+		return env
+	}
+	prefix := key + "="
+	filtered := env[:0]
+	for _, kv := range env {
+		if strings.HasPrefix(kv, prefix) {
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	return append(filtered, prefix+value)
+}
+
+func unsetEnvVar(env []string, key string) []string {
+	if key == "" {
+		return env
+	}
+	prefix := key + "="
+	filtered := env[:0]
+	for _, kv := range env {
+		if strings.HasPrefix(kv, prefix) {
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	return filtered
+}
+
+func validEnvKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	return !strings.ContainsRune(key, '=') && !strings.ContainsRune(key, 0)
+}
+
+func inputValueToEnvString(value any) string {
+	if value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// Failure kinds sent with failed results, saying whose fault the failure
+// was.
+const (
+	failureKindInfrastructure = "infrastructure"
+	failureKindUser           = "user"
+)
+
+// userFailureError marks a failure of the run's app rather than of the
+// runner: input the schema rejects, an artifact the entrypoint cannot run
+// from, or a failing setup script.
+type userFailureError struct {
+	err error
+}
+
+func (e *userFailureError) Error() string { return e.err.Error() }
+func (e *userFailureError) Unwrap() error { return e.err }
+
+// failureKindOf classifies a failure the runner hit before the process ran:
+// user for a userFailureError, infrastructure for anything else.
+func failureKindOf(err error) string {
+	var userErr *userFailureError
+	if errors.As(err, &userErr) {
+		return failureKindUser
+	}
+	return failureKindInfrastructure
+}
+
+// submitResult reports the run's result. failureKind is sent with failed
+// results and must be empty for others.
+func (r *Runner) submitResult(ctx context.Context, lease *LeaseResponse, status string, exitCode *int, errorMessage *string, failureKind string) error {
+	payload := map[string]any{
+		"status": status,
+	}
+	if exitCode != nil {
+		payload["exit_code"] = *exitCode
+	}
+	if errorMessage != nil {
+		payload["error_message"] = *errorMessage
+	}
+	if failureKind != "" {
+		payload["failure_kind"] = failureKind
+	}
+
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/runs/%d/result", r.cfg.ServerURL, lease.RunID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.setLeaseHeaders(req, lease)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if isStaleLeaseStatus(resp.StatusCode) {
+			return ErrStaleLease
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("result failed: %d %s", resp.StatusCode, string(respBody))
+	}
+
+	r.metrics.runCompleted(status, failureKind, lease)
+	return nil
+}
+
+// submitResultSafe wraps submitResult and silently returns nil on stale lease.
+func (r *Runner) submitResultSafe(ctx context.Context, lease *LeaseResponse, status string, exitCode *int, errorMessage *string, failureKind string) error {
+	if err := r.submitResult(ctx, lease, status, exitCode, errorMessage, failureKind); errors.Is(err, ErrStaleLease) {
+		r.logger.Warn("stale lease on result submit")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// submitFailure is a convenience for submitting a failed status with an
+// error message, for failures before or while starting the process.
+func (r *Runner) submitFailure(ctx context.Context, lease *LeaseResponse, failureKind, errMsg string) error {
+	return r.submitResultSafe(ctx, lease, "failed", nil, ptr(errMsg), failureKind)
+}
+
+func finalFailureLogLine(state *runState, waitErr error) string {
+	_, wasCancelled, isStale, wasTimedOut := state.snapshot()
+	if isStale || wasCancelled {
+		return ""
+	}
+	if wasTimedOut {
+		return "run failed: timeout exceeded"
+	}
+	if waitErr == nil {
+		return ""
+	}
+	if exitErr, ok := waitErr.(*exec.ExitError); ok {
+		return fmt.Sprintf("run failed: process exited with code %d", exitErr.ExitCode())
+	}
+	return fmt.Sprintf("run failed: %v", waitErr)
+}
+
+// submitFinalResult determines the final status from the run state and wait error, then submits.
+func (r *Runner) submitFinalResult(ctx context.Context, lease *LeaseResponse, state *runState, waitErr error) error {
+	_, wasCancelled, isStale, wasTimedOut := state.snapshot()
+	if isStale {
+		r.logger.Warn("stale lease, skipping result")
+		return nil
+	}
+
+	if wasCancelled {
+		r.logger.Info("run cancelled")
+		return r.submitResultSafe(ctx, lease, "cancelled", nil, nil, "")
+	}
+
+	if wasTimedOut {
+		r.logger.Info("run timed out")
+		return r.submitResultSafe(ctx, lease, "failed", nil, ptr("timeout"), failureKindUser)
+	}
+
+	if waitErr != nil {
+		// A process that ran and exited non-zero failed on its own; any
+		// other wait error is the runner's.
+		exitCode, kind := 1, failureKindInfrastructure
+		if exitErr, ok := waitErr.(*exec.ExitError); ok {
+			exitCode, kind = exitErr.ExitCode(), failureKindUser
+		}
+		r.logger.Info("run failed", "exit_code", exitCode, "failure_kind", kind)
+		return r.submitResultSafe(ctx, lease, "failed", &exitCode, ptr(waitErr.Error()), kind)
+	}
+
+	exitCode := 0
+	r.logger.Info("run completed", "exit_code", exitCode)
+	return r.submitResultSafe(ctx, lease, "completed", &exitCode, nil, "")
+}
+
+func isStaleLeaseStatus(status int) bool {
+	return status == http.StatusGone || status == http.StatusConflict
+}
+
+func ptr(s string) *string {
+	return &s
+}
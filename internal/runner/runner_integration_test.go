@@ -1,7 +1,7 @@
 //go:build integration
 // +build integration
 
-package main
+package runner
 
 import (
 	"archive/tar"
@@ -258,6 +258,93 @@ func TestRunnerEmitsFailureReasonLogLine(t *testing.T) {
 	}
 }
 
+func TestRunnerFailsWhenInputDoesNotMatchSchema(t *testing.T) {
+	artifact, sha := buildArtifact(t, "print('should not run', flush=True)\n")
+
+	server := newRunnerServer(t, serverConfig{
+		artifact:       artifact,
+		artifactSHA256: sha,
+		heartbeatCode:  http.StatusOK,
+		logsCode:       http.StatusOK,
+		resultCode:     http.StatusOK,
+	})
+
+	runner := newTestRunner(t, "http://runner.test", "python3", server.handler)
+	lease := makeLease(time.Now().Add(10*time.Second), 20)
+	lease.ParamsSchema = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"name": map[string]any{"type": "string"},
+		},
+		"required": []any{"name"},
+	}
+	lease.Input = map[string]any{"count": 3}
+
+	if err := runner.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+
+	if server.lastResultStatus != "failed" {
+		t.Fatalf("expected failed status, got %q", server.lastResultStatus)
+	}
+	if server.lastResultError == nil || !strings.HasPrefix(*server.lastResultError, "input does not match schema: ") {
+		t.Fatalf("expected schema mismatch error, got %v", server.lastResultError)
+	}
+	if server.artifactCalls.Load() != 0 {
+		t.Fatalf("expected no artifact download, got %d", server.artifactCalls.Load())
+	}
+
+	batches := server.snapshotLogBatches()
+	if !logContains(batches, "input does not match schema") {
+		t.Fatalf("expected schema mismatch setup log, got %#v", batches)
+	}
+	if logContains(batches, "should not run") {
+		t.Fatalf("process should not have been launched, got %#v", batches)
+	}
+}
+
+func TestRunnerExportsInputFile(t *testing.T) {
+	python := requirePython(t)
+	requireTar(t)
+
+	script := "import json, os\n" +
+		"path = os.environ['MINITOWER_INPUT_PATH']\n" +
+		"print('input_path=' + os.path.basename(path), flush=True)\n" +
+		"with open(path) as f:\n" +
+		"  data = json.load(f)\n" +
+		"print('nested=' + data['config']['region'], flush=True)\n"
+	artifact, sha := buildArtifact(t, script)
+
+	server := newRunnerServer(t, serverConfig{
+		artifact:       artifact,
+		artifactSHA256: sha,
+		heartbeatCode:  http.StatusOK,
+		logsCode:       http.StatusOK,
+		resultCode:     http.StatusOK,
+	})
+
+	runner := newTestRunner(t, "http://runner.test", python, server.handler)
+	lease := makeLease(time.Now().Add(10*time.Second), 20)
+	lease.Input = map[string]any{
+		"config": map[string]any{"region": "eu-west-1"},
+	}
+
+	if err := runner.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+
+	if server.lastResultStatus != "completed" {
+		t.Fatalf("expected completed status, got %q", server.lastResultStatus)
+	}
+	batches := server.snapshotLogBatches()
+	if !logContains(batches, "input_path=input.json") {
+		t.Fatalf("expected MINITOWER_INPUT_PATH in child env, got %#v", batches)
+	}
+	if !logContains(batches, "nested=eu-west-1") {
+		t.Fatalf("expected nested input from input.json, got %#v", batches)
+	}
+}
+
 type serverConfig struct {
 	artifact       []byte
 	artifactSHA256 string
@@ -273,6 +360,7 @@ type runnerServer struct {
 	heartbeatCalls   atomic.Int32
 	logCalls         atomic.Int32
 	resultCalls      atomic.Int32
+	artifactCalls    atomic.Int32
 	lastResultStatus string
 	lastResultError  *string
 
@@ -367,6 +455,7 @@ func newRunnerServer(t *testing.T, cfg serverConfig) *runnerServer {
 	})
 
 	mux.HandleFunc("/api/v1/runs/1/artifact", func(w http.ResponseWriter, r *http.Request) {
+		rs.artifactCalls.Add(1)
 		if r.Header.Get("X-Lease-Token") != testLease {
 			w.WriteHeader(http.StatusGone)
 			return
@@ -507,9 +596,3 @@ func writeJSON(w http.ResponseWriter, payload any) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(payload)
 }
-
-type roundTripperFunc func(*http.Request) (*http.Response, error)
-
-func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
-	return f(req)
-}
@@ -0,0 +1,500 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildProcessEnv_ExportsInputAsEnvVars(t *testing.T) {
+	r := &Runner{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	base := []string{
+		"PATH=/usr/bin",
+		"name=old",
+		"MINITOWER_INPUT={\"name\":\"legacy\"}",
+	}
+	input := map[string]any{
+		"name":    "MiniTower",
+		"count":   10,
+		"enabled": true,
+		"tags":    []string{"alpha", "beta"},
+		"empty":   nil,
+		"bad=key": "skip-me",
+	}
+
+	env := envToMap(r.buildProcessEnv(base, input))
+
+	if _, ok := env["MINITOWER_INPUT"]; ok {
+		t.Fatal("MINITOWER_INPUT should not be present")
+	}
+	if got := env["name"]; got != "MiniTower" {
+		t.Fatalf("name mismatch: got %q", got)
+	}
+	if got := env["count"]; got != "10" {
+		t.Fatalf("count mismatch: got %q", got)
+	}
+	if got := env["enabled"]; got != "true" {
+		t.Fatalf("enabled mismatch: got %q", got)
+	}
+	if got := env["tags"]; got != "[\"alpha\",\"beta\"]" {
+		t.Fatalf("tags mismatch: got %q", got)
+	}
+	if got := env["empty"]; got != "" {
+		t.Fatalf("empty mismatch: got %q", got)
+	}
+	if _, ok := env["bad=key"]; ok {
+		t.Fatal("invalid env key should be skipped")
+	}
+}
+
+func TestBuildProcessEnv_RemovesLegacyVarWithoutInput(t *testing.T) {
+	r := &Runner{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	env := envToMap(r.buildProcessEnv([]string{
+		"MINITOWER_INPUT={}",
+		"PATH=/usr/bin",
+	}, nil))
+
+	if _, ok := env["MINITOWER_INPUT"]; ok {
+		t.Fatal("MINITOWER_INPUT should be removed")
+	}
+	if got := env["PATH"]; got != "/usr/bin" {
+		t.Fatalf("PATH mismatch: got %q", got)
+	}
+}
+
+func TestEnvSnapshotExcludesHostEnv(t *testing.T) {
+	t.Setenv("MINITOWER_SNAPSHOT_HOST_VAR", "host-only")
+	t.Setenv("PYTHONPATH", "/host/site")
+	r := &Runner{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	lease := &LeaseResponse{
+		Entrypoint:  "main.py",
+		ImportPaths: []string{"src", "lib"},
+		Input:       map[string]any{"region": "eu-west-1", "replicas": 3},
+	}
+
+	env := r.envSnapshot(lease)
+
+	want := map[string]string{
+		"region":                "eu-west-1",
+		"replicas":              "3",
+		"MINITOWER_INPUT_PATH":  filepath.Join(snapshotWorkspaceDir, inputFileName),
+		"MINITOWER_OUTPUTS_DIR": filepath.Join(snapshotWorkspaceDir, outputsDirName),
+		"PYTHONPATH": filepath.Join(snapshotWorkspaceDir, "src") + string(os.PathListSeparator) +
+			filepath.Join(snapshotWorkspaceDir, "lib"),
+	}
+	if len(env) != len(want) {
+		t.Fatalf("snapshot = %v, want %v", env, want)
+	}
+	for key, value := range want {
+		if env[key] != value {
+			t.Fatalf("snapshot[%s] = %q, want %q", key, env[key], value)
+		}
+	}
+
+	// Shell entrypoints get no PYTHONPATH.
+	lease.Entrypoint = "main.sh"
+	if _, ok := r.envSnapshot(lease)["PYTHONPATH"]; ok {
+		t.Fatal("PYTHONPATH should only be set for Python entrypoints")
+	}
+}
+
+func TestStartRunSendsEnvSnapshot(t *testing.T) {
+	var body struct {
+		EnvSnapshot map[string]string `json:"env_snapshot"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("decode start body: %v", err)
+		}
+		_, _ = io.WriteString(w, `{"lease_expires_at":"2030-01-01T00:00:00Z"}`)
+	}))
+	defer srv.Close()
+
+	r := NewRunner(&Config{ServerURL: srv.URL, DataDir: t.TempDir()}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	lease := &LeaseResponse{RunID: 7, LeaseToken: "lease-tok", Entrypoint: "main.sh", Input: map[string]any{"name": "MiniTower"}}
+
+	if _, err := r.startRun(context.Background(), lease); err != nil {
+		t.Fatalf("start run: %v", err)
+	}
+	if body.EnvSnapshot["name"] != "MiniTower" || body.EnvSnapshot["MINITOWER_INPUT_PATH"] == "" {
+		t.Fatalf("unexpected env snapshot %v", body.EnvSnapshot)
+	}
+	if _, ok := body.EnvSnapshot["PATH"]; ok {
+		t.Fatal("env snapshot should not include the runner's PATH")
+	}
+}
+
+func TestWriteInputFilePreservesNestedValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), inputFileName)
+	input := map[string]any{
+		"config": map[string]any{"region": "eu-west-1", "replicas": 3},
+		"tags":   []any{"alpha", "beta"},
+	}
+
+	if err := writeInputFile(path, input); err != nil {
+		t.Fatalf("write input file: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read input file: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decode input file: %v", err)
+	}
+	config, ok := got["config"].(map[string]any)
+	if !ok || config["region"] != "eu-west-1" || config["replicas"] != float64(3) {
+		t.Fatalf("nested config mismatch: %#v", got["config"])
+	}
+	if tags, ok := got["tags"].([]any); !ok || len(tags) != 2 {
+		t.Fatalf("tags mismatch: %#v", got["tags"])
+	}
+}
+
+func TestWriteInputFileWritesEmptyObjectWithoutInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), inputFileName)
+	if err := writeInputFile(path, nil); err != nil {
+		t.Fatalf("write input file: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read input file: %v", err)
+	}
+	if string(data) != "{}" {
+		t.Fatalf("expected empty object, got %q", data)
+	}
+}
+
+func TestLoadConfigRejectsInvalidPollInterval(t *testing.T) {
+	t.Setenv("MINITOWER_SERVER_URL", "http://localhost:8080")
+	t.Setenv("MINITOWER_RUNNER_NAME", "runner-test")
+	t.Setenv("MINITOWER_POLL_INTERVAL", "not-a-duration")
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatalf("expected error for invalid poll interval")
+	}
+	if !strings.Contains(err.Error(), "MINITOWER_POLL_INTERVAL") {
+		t.Fatalf("expected poll interval error, got: %v", err)
+	}
+}
+
+func TestLoadConfigRejectsNonPositivePollInterval(t *testing.T) {
+	t.Setenv("MINITOWER_SERVER_URL", "http://localhost:8080")
+	t.Setenv("MINITOWER_RUNNER_NAME", "runner-test")
+	t.Setenv("MINITOWER_POLL_INTERVAL", "0s")
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatalf("expected error for non-positive poll interval")
+	}
+	if !strings.Contains(err.Error(), "must be > 0") {
+		t.Fatalf("expected non-positive poll interval error, got: %v", err)
+	}
+}
+
+func TestLoadConfigDefaultsWorkDirUnderDataDir(t *testing.T) {
+	t.Setenv("MINITOWER_SERVER_URL", "http://localhost:8080")
+	t.Setenv("MINITOWER_RUNNER_NAME", "runner-test")
+	t.Setenv("MINITOWER_DATA_DIR", "/var/lib/minitower")
+	t.Setenv("MINITOWER_WORK_DIR", "")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.WorkDir != "/var/lib/minitower/work" {
+		t.Fatalf("work dir = %q", cfg.WorkDir)
+	}
+	if cfg.MinFreeDisk != defaultMinFreeDisk {
+		t.Fatalf("min free disk = %d", cfg.MinFreeDisk)
+	}
+}
+
+func TestLoadConfigRejectsInvalidMinFreeDisk(t *testing.T) {
+	t.Setenv("MINITOWER_SERVER_URL", "http://localhost:8080")
+	t.Setenv("MINITOWER_RUNNER_NAME", "runner-test")
+	t.Setenv("MINITOWER_MIN_FREE_DISK_BYTES", "lots")
+
+	_, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "MINITOWER_MIN_FREE_DISK_BYTES") {
+		t.Fatalf("expected min free disk error, got: %v", err)
+	}
+}
+
+func TestRunnerTLSSettings(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"lease_expires_at":"2030-01-01T00:00:00Z"}`)
+	}))
+	defer srv.Close()
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatalf("write CA cert: %v", err)
+	}
+
+	t.Setenv("MINITOWER_SERVER_URL", srv.URL)
+	t.Setenv("MINITOWER_RUNNER_NAME", "runner-test")
+	t.Setenv("MINITOWER_DATA_DIR", t.TempDir())
+	start := func(env map[string]string) (string, error) {
+		t.Helper()
+		for k, v := range env {
+			t.Setenv(k, v)
+		}
+		cfg, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("load config: %v", err)
+		}
+		var logs bytes.Buffer
+		r := NewRunner(cfg, slog.New(slog.NewTextHandler(&logs, nil)))
+		_, err = r.startRun(context.Background(), &LeaseResponse{RunID: 7, LeaseToken: "lease-tok"})
+		return logs.String(), err
+	}
+
+	if _, err := start(nil); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected a certificate error without a CA, got %v", err)
+	}
+	if logs, err := start(map[string]string{"MINITOWER_CA_CERT": caCert}); err != nil || strings.Contains(logs, "disabled") {
+		t.Fatalf("expected the CA to be trusted without a warning, got %v (logs %q)", err, logs)
+	}
+	logs, err := start(map[string]string{"MINITOWER_CA_CERT": "", "MINITOWER_TLS_INSECURE": "true"})
+	if err != nil || !strings.Contains(logs, "TLS certificate verification is disabled") {
+		t.Fatalf("expected verification skipped with a warning, got %v (logs %q)", err, logs)
+	}
+
+	for env, want := range map[string]string{
+		"MINITOWER_CA_CERT":      "invalid MINITOWER_CA_CERT",
+		"MINITOWER_TLS_INSECURE": "invalid MINITOWER_TLS_INSECURE",
+	} {
+		t.Setenv("MINITOWER_CA_CERT", "")
+		t.Setenv("MINITOWER_TLS_INSECURE", "")
+		t.Setenv(env, "bogus")
+		if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: expected %q, got %v", env, want, err)
+		}
+	}
+}
+
+func TestRunScopedCallsSendRunTraceID(t *testing.T) {
+	var gotTrace, gotLease string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotTrace = req.Header.Get("X-Run-Trace-ID")
+		gotLease = req.Header.Get("X-Lease-Token")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"lease_expires_at":"2030-01-01T00:00:00Z"}`)
+	}))
+	defer srv.Close()
+
+	r := NewRunner(&Config{ServerURL: srv.URL, DataDir: t.TempDir()}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	lease := &LeaseResponse{RunID: 7, LeaseToken: "lease-tok", RunTraceID: "abc123"}
+
+	if _, err := r.startRun(context.Background(), lease); err != nil {
+		t.Fatalf("start run: %v", err)
+	}
+	if gotTrace != "abc123" {
+		t.Fatalf("X-Run-Trace-ID = %q, want abc123", gotTrace)
+	}
+	if gotLease != "lease-tok" {
+		t.Fatalf("X-Lease-Token = %q, want lease-tok", gotLease)
+	}
+}
+
+func TestRunUsesProvisionedTokenWithoutRegistering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var leaseAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v1/runners/register":
+			t.Errorf("runner registered despite a provisioned token")
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/api/v1/runs/lease":
+			leaseAuth = req.Header.Get("Authorization")
+			cancel()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dataDir, "runner_token"), []byte("stale-token"), 0600); err != nil {
+		t.Fatalf("write saved token: %v", err)
+	}
+	cfg := &Config{ServerURL: srv.URL, RunnerName: "runner-prov", Token: "mtr_provisioned", DataDir: dataDir, PollInterval: time.Millisecond}
+	r := NewRunner(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := r.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if leaseAuth != "Bearer mtr_provisioned" {
+		t.Fatalf("lease Authorization = %q, want the provisioned token", leaseAuth)
+	}
+	saved, err := os.ReadFile(r.tokenPath)
+	if err != nil || string(saved) != "mtr_provisioned" {
+		t.Fatalf("expected the provisioned token saved, got %q (%v)", saved, err)
+	}
+}
+
+func TestRunWithoutAnyTokenNamesBothVariables(t *testing.T) {
+	r := NewRunner(&Config{ServerURL: "http://127.0.0.1:1", RunnerName: "runner-none", DataDir: t.TempDir()}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	err := r.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "MINITOWER_RUNNER_TOKEN") || !strings.Contains(err.Error(), "MINITOWER_RUNNER_REGISTRATION_TOKEN") {
+		t.Fatalf("expected an error naming both token variables, got %v", err)
+	}
+}
+
+func TestRegisterTakesOverExistingNameOnlyWhenAllowed(t *testing.T) {
+	var rotates []any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("decode register: %v", err)
+		}
+		rotates = append(rotates, body["rotate"])
+		if body["rotate"] != true {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = io.WriteString(w, `{"error":{"code":"runner_exists","message":"runner already exists"}}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"runner_id":1,"name":"runner-test","token":"new-token"}`)
+	}))
+	defer srv.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	strict := NewRunner(&Config{ServerURL: srv.URL, RunnerName: "runner-test", DataDir: t.TempDir()}, logger)
+	if err := strict.register(context.Background()); err == nil || !strings.Contains(err.Error(), "MINITOWER_RUNNER_ALLOW_TAKEOVER") {
+		t.Fatalf("expected takeover hint, got: %v", err)
+	}
+	if len(rotates) != 1 || rotates[0] != nil {
+		t.Fatalf("expected a single registration without rotate, got %v", rotates)
+	}
+
+	rotates = nil
+	dataDir := t.TempDir()
+	takeover := NewRunner(&Config{ServerURL: srv.URL, RunnerName: "runner-test", DataDir: dataDir, AllowTakeover: true}, logger)
+	if err := takeover.register(context.Background()); err != nil {
+		t.Fatalf("register with takeover: %v", err)
+	}
+	if len(rotates) != 2 || rotates[1] != true {
+		t.Fatalf("expected retry with rotate=true, got %v", rotates)
+	}
+	saved, err := os.ReadFile(takeover.tokenPath)
+	if err != nil || string(saved) != "new-token" {
+		t.Fatalf("expected new token saved, got %q (%v)", saved, err)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestPollRecoversFromUnauthorizedAndBacksOffOnRejectedRegistration(t *testing.T) {
+	var requests []string
+	leaseStatus, registerStatus := http.StatusUnauthorized, http.StatusUnauthorized
+	respond := func(status int, body string) *http.Response {
+		return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+	}
+	dataDir := t.TempDir()
+	r := NewRunner(&Config{
+		ServerURL:         "http://runner.test",
+		RunnerName:        "runner-test",
+		RegistrationToken: "reg-old",
+		DataDir:           dataDir,
+		PollInterval:      time.Second,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	r.httpClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req.URL.Path)
+		if req.URL.Path == "/api/v1/runners/register" {
+			if registerStatus != http.StatusCreated {
+				return respond(registerStatus, `{"error":{"code":"unauthorized","message":"invalid or missing token"}}`), nil
+			}
+			return respond(registerStatus, `{"runner_id":1,"name":"runner-test","token":"new-token"}`), nil
+		}
+		return respond(leaseStatus, ""), nil
+	})}
+	r.token = "saved-token"
+	if err := os.WriteFile(r.tokenPath, []byte(r.token), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// A single 401 keeps the saved token for one more poll.
+	_, err := r.poll(ctx)
+	if err == nil || errors.Is(err, errRegistrationRejected) || r.token != "saved-token" {
+		t.Fatalf("expected a retry with the saved token, got err=%v token=%q", err, r.token)
+	}
+	if d := r.pollDelay(err); d < time.Second || d >= 1500*time.Millisecond {
+		t.Fatalf("expected the normal poll interval after one 401, got %s", d)
+	}
+	leaseStatus = http.StatusNoContent
+	if _, err := r.poll(ctx); err != nil || r.token != "saved-token" {
+		t.Fatalf("expected the saved token to keep working, got err=%v token=%q", err, r.token)
+	}
+
+	// Two in a row drop the token and register again, which is rejected.
+	leaseStatus = http.StatusUnauthorized
+	_, _ = r.poll(ctx)
+	requests = nil
+	_, err = r.poll(ctx)
+	if !errors.Is(err, errRegistrationRejected) {
+		t.Fatalf("expected the registration token to be rejected, got %v", err)
+	}
+	if r.token != "" {
+		t.Fatalf("expected the saved token to be dropped, got %q", r.token)
+	}
+	if _, statErr := os.Stat(r.tokenPath); !os.IsNotExist(statErr) {
+		t.Fatalf("expected the token file to be removed, got %v", statErr)
+	}
+	if len(requests) != 2 || requests[1] != "/api/v1/runners/register" {
+		t.Fatalf("expected a lease then a registration, got %v", requests)
+	}
+
+	// Each rejected registration doubles the wait, up to the cap, without
+	// polling for runs in between.
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	for i := range 12 {
+		if i > 0 {
+			requests = nil
+			if _, err = r.poll(ctx); !errors.Is(err, errRegistrationRejected) {
+				t.Fatalf("expected registration to stay rejected, got %v", err)
+			}
+			if len(requests) != 1 || requests[0] != "/api/v1/runners/register" {
+				t.Fatalf("expected only a registration attempt, got %v", requests)
+			}
+		}
+		d := r.pollDelay(err)
+		if i < len(want) && d != want[i] {
+			t.Fatalf("backoff %d: expected %s, got %s", i, want[i], d)
+		}
+		if d > maxRegisterBackoff {
+			t.Fatalf("expected backoff capped at %s, got %s", maxRegisterBackoff, d)
+		}
+	}
+	if r.registerBackoff != maxRegisterBackoff {
+		t.Fatalf("expected backoff to reach %s, got %s", maxRegisterBackoff, r.registerBackoff)
+	}
+
+	// A new registration token ends the backoff.
+	registerStatus = http.StatusCreated
+	if _, err := r.poll(ctx); err != nil || r.token != "new-token" {
+		t.Fatalf("expected registration to succeed, got err=%v token=%q", err, r.token)
+	}
+	if d := r.pollDelay(nil); d >= 1500*time.Millisecond || r.registerBackoff != 0 {
+		t.Fatalf("expected the backoff reset, got delay %s backoff %s", d, r.registerBackoff)
+	}
+}
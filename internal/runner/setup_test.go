@@ -0,0 +1,198 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRunServer serves one leased run: the artifact, log batches, output
+// uploads and the final result.
+type fakeRunServer struct {
+	artifact []byte
+	// outputLimit, when set, rejects uploads past that many outputs.
+	outputLimit int
+	// leaseTTL sets how far ahead attempt responses put the lease expiry,
+	// an hour by default; short TTLs make the runner heartbeat sooner.
+	leaseTTL time.Duration
+	// heartbeatTimeout, when set, is returned as timeout_seconds on
+	// heartbeats.
+	heartbeatTimeout int
+	// onStart, when set, is called as the runner starts a run.
+	onStart func()
+
+	mu               sync.Mutex
+	lines            []string
+	result           map[string]any
+	artifactRequests int
+	outputs          map[string][]byte
+}
+
+func (f *fakeRunServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ttl := f.leaseTTL
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	attempt := `{"lease_expires_at":"` + time.Now().Add(ttl).UTC().Format(time.RFC3339) + `"}`
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/start"):
+		if f.onStart != nil {
+			f.onStart()
+		}
+		_, _ = io.WriteString(w, attempt)
+	case strings.HasSuffix(req.URL.Path, "/heartbeat"):
+		if f.heartbeatTimeout > 0 {
+			attempt = strings.TrimSuffix(attempt, "}") + `,"timeout_seconds":` + strconv.Itoa(f.heartbeatTimeout) + "}"
+		}
+		_, _ = io.WriteString(w, attempt)
+	case strings.HasSuffix(req.URL.Path, "/artifact"):
+		f.mu.Lock()
+		f.artifactRequests++
+		f.mu.Unlock()
+		_, _ = w.Write(f.artifact)
+	case strings.HasSuffix(req.URL.Path, "/logs"):
+		var body struct {
+			Logs []logEntry `json:"logs"`
+		}
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		f.mu.Lock()
+		for _, l := range body.Logs {
+			f.lines = append(f.lines, l.Line)
+		}
+		f.mu.Unlock()
+		_, _ = io.WriteString(w, `{}`)
+	case strings.HasSuffix(req.URL.Path, "/outputs"):
+		file, header, err := req.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.outputLimit > 0 && len(f.outputs) >= f.outputLimit {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = io.WriteString(w, `{"error":{"code":"output_limit"}}`)
+			return
+		}
+		if f.outputs == nil {
+			f.outputs = make(map[string][]byte)
+		}
+		f.outputs[header.Filename] = data
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `{}`)
+	case strings.HasSuffix(req.URL.Path, "/result"):
+		f.mu.Lock()
+		_ = json.NewDecoder(req.Body).Decode(&f.result)
+		f.mu.Unlock()
+		_, _ = io.WriteString(w, `{}`)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, body := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if _, err := io.WriteString(tw, body); err != nil {
+			t.Fatalf("write body: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSetupScriptFailureSkipsEntrypoint(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "entrypoint-ran")
+	fake := &fakeRunServer{artifact: tarGz(t, map[string]string{
+		"setup.sh": "echo \"installing for $MINITOWER_INPUT_PATH\"\nexit 3\n",
+		"main.sh":  "touch " + marker + "\n",
+	})}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	r := NewRunner(&Config{
+		ServerURL:       srv.URL,
+		DataDir:         dataDir,
+		WorkDir:         filepath.Join(dataDir, workDirName),
+		KillGracePeriod: time.Second,
+		SetupTimeout:    10 * time.Second,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+		t.Fatalf("create work dir: %v", err)
+	}
+
+	lease := &LeaseResponse{RunID: 1, LeaseToken: "lease", Entrypoint: "main.sh", SetupScript: "setup.sh"}
+	if err := r.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("entrypoint must not run after a failed setup script (stat err: %v)", err)
+	}
+	if fake.result["status"] != "failed" {
+		t.Fatalf("expected failed result, got %v", fake.result)
+	}
+	msg, _ := fake.result["error_message"].(string)
+	if !strings.HasPrefix(msg, "setup script failed: exit status 3") {
+		t.Fatalf("unexpected error message %q", msg)
+	}
+	logs := strings.Join(fake.lines, "\n")
+	if !strings.Contains(logs, "installing for ") || !strings.Contains(logs, inputFileName) {
+		t.Fatalf("expected setup output with the entrypoint environment in logs, got:\n%s", logs)
+	}
+}
+
+func TestSetupScriptTimeout(t *testing.T) {
+	fake := &fakeRunServer{artifact: tarGz(t, map[string]string{
+		"setup.sh": "exec sleep 30\n",
+		"main.sh":  "true\n",
+	})}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	r := NewRunner(&Config{
+		ServerURL:       srv.URL,
+		DataDir:         dataDir,
+		WorkDir:         filepath.Join(dataDir, workDirName),
+		KillGracePeriod: time.Second,
+		SetupTimeout:    200 * time.Millisecond,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+		t.Fatalf("create work dir: %v", err)
+	}
+
+	lease := &LeaseResponse{RunID: 2, LeaseToken: "lease", Entrypoint: "main.sh", SetupScript: "setup.sh"}
+	if err := r.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+	if msg, _ := fake.result["error_message"].(string); msg != "setup script failed: timed out after 200ms" {
+		t.Fatalf("unexpected error message %q", msg)
+	}
+}
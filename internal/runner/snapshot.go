@@ -0,0 +1,270 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// snapshotOutputName is the run output a failure snapshot is uploaded
+	// as; a later failed attempt replaces it.
+	snapshotOutputName = "minitower-diagnostics.tar.gz"
+	// snapshotReportName is the generated environment report inside it.
+	snapshotReportName = "environment.txt"
+	// pipLogName is where pip writes its log when snapshots are enabled.
+	pipLogName              = ".minitower-pip.log"
+	defaultSnapshotMaxBytes = 1 << 20
+	// snapshotCommandTimeout bounds each interpreter call of the report.
+	snapshotCommandTimeout = 30 * time.Second
+	snapshotPackagesMax    = 64 << 10
+)
+
+// snapshotSkipDirs are never descended into: the venv, the run's outputs
+// and version control data.
+var snapshotSkipDirs = map[string]bool{".venv": true, outputsDirName: true, ".git": true}
+
+// Secret-looking file names are left out of snapshots, as are directories
+// with such names.
+var (
+	secretFileNames     = map[string]bool{".env": true, ".netrc": true, ".pypirc": true, ".pgpass": true, ".npmrc": true}
+	secretFileNameParts = []string{"secret", "token", "password", "passwd", "credential", "private", "id_rsa", "id_ecdsa", "id_ed25519"}
+	secretFileExts      = []string{".pem", ".key", ".p12", ".pfx", ".jks", ".keystore"}
+)
+
+// isSecretFileName reports whether a file or directory name looks like it
+// holds credentials.
+func isSecretFileName(name string) bool {
+	lower := strings.ToLower(name)
+	if secretFileNames[lower] || strings.HasPrefix(lower, ".env.") {
+		return true
+	}
+	for _, part := range secretFileNameParts {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	for _, ext := range secretFileExts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotFileRank orders the files a snapshot takes, so the most useful
+// ones come first when the size cap is reached, and reports whether rel
+// (slash-separated, relative to the workspace) is taken at all.
+func snapshotFileRank(rel, setupScript string) (int, bool) {
+	name := strings.ToLower(filepath.Base(rel))
+	switch {
+	case rel == pipLogName:
+		return 0, true
+	case strings.HasPrefix(name, "requirements") && strings.HasSuffix(name, ".txt"):
+		return 1, true
+	case rel == "Towerfile" || (setupScript != "" && rel == filepath.ToSlash(setupScript)):
+		return 2, true
+	case strings.HasSuffix(name, ".log"):
+		return 3, true
+	}
+	return 0, false
+}
+
+// selectSnapshotFiles returns the workspace files a failure snapshot
+// takes, as slash-separated paths relative to dir, most useful first: the
+// pip log, requirements files, the Towerfile and setup script, and other
+// .log files. The venv, the input file, symlinks and secret-looking names
+// are never taken.
+func selectSnapshotFiles(dir, setupScript string) []string {
+	type candidate struct {
+		rel  string
+		rank int
+	}
+	var picked []candidate
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			// Unreadable entries are left out.
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if snapshotSkipDirs[d.Name()] || isSecretFileName(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || rel == inputFileName || rel == snapshotOutputName || isSecretFileName(d.Name()) {
+			return nil
+		}
+		if rank, ok := snapshotFileRank(rel, setupScript); ok {
+			picked = append(picked, candidate{rel, rank})
+		}
+		return nil
+	})
+	sort.SliceStable(picked, func(i, j int) bool { return picked[i].rank < picked[j].rank })
+	rels := make([]string, len(picked))
+	for i, c := range picked {
+		rels[i] = c.rel
+	}
+	return rels
+}
+
+// buildSnapshot writes a gzipped tar of the report and the selected files
+// of dir. The report and files together hold at most maxBytes; the report
+// is truncated to fit, and files that no longer fit are left out and
+// returned as skipped.
+func buildSnapshot(dir string, files []string, report []byte, maxBytes int64) (data []byte, skipped []string, err error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	now := time.Now()
+
+	if int64(len(report)) > maxBytes {
+		report = report[:maxBytes]
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: snapshotReportName, Mode: 0o644, Size: int64(len(report)), ModTime: now, Typeflag: tar.TypeReg}); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tw.Write(report); err != nil {
+		return nil, nil, err
+	}
+	budget := maxBytes - int64(len(report))
+
+	for _, rel := range files {
+		info, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if info.Size() > budget {
+			skipped = append(skipped, rel)
+			continue
+		}
+		if err := addSnapshotFile(tw, filepath.Join(dir, filepath.FromSlash(rel)), rel, info); err != nil {
+			return nil, nil, err
+		}
+		budget -= info.Size()
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), skipped, nil
+}
+
+func addSnapshotFile(tw *tar.Writer, path, name string, info fs.FileInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	// The header promised info.Size() bytes; a file grown since the stat
+	// is cut there.
+	_, err = io.CopyN(tw, f, info.Size())
+	return err
+}
+
+// snapshotReport describes the run's environment: the run, the interpreter
+// and packages of the venv in dir, if there is one, and the workspace's
+// top-level files.
+func (r *Runner) snapshotReport(ctx context.Context, lease *LeaseResponse, dir string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "run: %d (attempt %d)\n", lease.RunID, lease.AttemptNo)
+	fmt.Fprintf(&b, "entrypoint: %s\n", lease.Entrypoint)
+	if lease.PythonVersion != "" {
+		fmt.Fprintf(&b, "required python version: %s\n", lease.PythonVersion)
+	}
+	python := venvPython(filepath.Join(dir, ".venv"))
+	if _, err := os.Stat(python); err != nil {
+		b.WriteString("python: no virtual environment\n")
+	} else {
+		fmt.Fprintf(&b, "python: %s\n", snapshotCommandOutput(ctx, python, "--version"))
+		fmt.Fprintf(&b, "installed packages:\n%s\n", snapshotCommandOutput(ctx, python, "-m", "pip", "freeze"))
+	}
+	fmt.Fprintf(&b, "%s\n", describeArtifactFiles(dir))
+	return []byte(b.String())
+}
+
+// snapshotCommandOutput runs name with args and returns its trimmed,
+// capped output, or a note of the failure.
+func snapshotCommandOutput(ctx context.Context, name string, args ...string) string {
+	cmdCtx, cancel := context.WithTimeout(ctx, snapshotCommandTimeout)
+	defer cancel()
+	out := &cappedBuffer{maxBytes: snapshotPackagesMax}
+	cmd := exec.CommandContext(cmdCtx, name, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Sprintf("unavailable (%v)", err)
+	}
+	text := strings.TrimSpace(out.String())
+	if out.truncated {
+		text += "\n...(truncated)"
+	}
+	return text
+}
+
+// uploadFailureSnapshot uploads a diagnostic snapshot of the workspace in
+// dir as a run output when MINITOWER_SNAPSHOT_ON_FAILURE is set. Callers
+// call it for runs about to be reported failed, before the result, while
+// the lease still allows uploads; cancelled runs and runs whose lease went
+// stale get none. Problems are noted in setup log lines and never change
+// the run's outcome.
+func (r *Runner) uploadFailureSnapshot(ctx context.Context, lease *LeaseResponse, dir string, lc *logCollector) {
+	if !r.cfg.SnapshotOnFailure || ctx.Err() != nil {
+		return
+	}
+	if _, wasCancelled, isStale, _ := lc.state.snapshot(); wasCancelled || isStale {
+		return
+	}
+
+	report := r.snapshotReport(ctx, lease, dir)
+	data, skipped, err := buildSnapshot(dir, selectSnapshotFiles(dir, lease.SetupScript), report, r.cfg.SnapshotMaxBytes)
+	if err != nil {
+		r.logger.Warn("diagnostic snapshot failed", "error", err)
+		lc.logSetup(context.Background(), fmt.Sprintf("diagnostic snapshot failed: %v", err))
+		return
+	}
+	for _, rel := range skipped {
+		lc.logSetup(context.Background(), fmt.Sprintf("diagnostic snapshot left out %s: over the %s limit", rel, formatBytes(r.cfg.SnapshotMaxBytes)))
+	}
+
+	path := filepath.Join(dir, snapshotOutputName)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		lc.logSetup(context.Background(), fmt.Sprintf("diagnostic snapshot failed: %v", err))
+		return
+	}
+	err = r.uploadOutput(ctx, lease, path)
+	switch {
+	case errors.Is(err, ErrStaleLease):
+		r.logger.Warn("stale lease on diagnostic snapshot upload")
+		lc.state.markStale()
+	case errors.Is(err, errOutputLimit):
+		lc.logSetup(context.Background(), fmt.Sprintf("diagnostic snapshot not uploaded: the run already has %d outputs", maxOutputs))
+	case err != nil:
+		r.logger.Warn("diagnostic snapshot upload failed", "error", err)
+		lc.logSetup(context.Background(), fmt.Sprintf("diagnostic snapshot upload failed: %v", err))
+	default:
+		lc.logSetup(context.Background(), fmt.Sprintf("diagnostic snapshot uploaded (%d KB)", (len(data)+1023)/1024))
+	}
+}
@@ -0,0 +1,218 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+}
+
+// untarGz returns the files of a gzipped tar by name.
+func untarGz(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		body, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(body)
+	}
+}
+
+func TestSelectSnapshotFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		pipLogName:                      "pip",
+		"requirements.txt":              "requests",
+		"requirements-dev.txt":          "pytest",
+		"Towerfile":                     "[app]",
+		"scripts/setup.sh":              "true",
+		"logs/app.log":                  "log",
+		"main.py":                       "print()",
+		inputFileName:                   "{}",
+		".venv/lib/pip.log":             "venv",
+		outputsDirName + "/out.log":     "output",
+		".env":                          "KEY=1",
+		".env.production":               "KEY=2",
+		"secrets/app.log":               "secret dir",
+		"api_token.log":                 "token",
+		"tls/server.pem":                "cert",
+		"config/credentials.txt":        "creds",
+		"requirements-private-repo.txt": "private",
+	})
+	if err := os.Symlink(filepath.Join(dir, ".env"), filepath.Join(dir, "link.log")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+
+	got := selectSnapshotFiles(dir, "scripts/setup.sh")
+	want := []string{pipLogName, "requirements-dev.txt", "requirements.txt", "Towerfile", "scripts/setup.sh", "logs/app.log"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestBuildSnapshotCapsSize(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.log": strings.Repeat("a", 30),
+		"b.log": strings.Repeat("b", 100),
+		"c.log": strings.Repeat("c", 20),
+	})
+	files := []string{"a.log", "b.log", "c.log"}
+
+	data, skipped, err := buildSnapshot(dir, files, []byte("0123456789"), 70)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !reflect.DeepEqual(skipped, []string{"b.log"}) {
+		t.Fatalf("expected b.log left out, got %v", skipped)
+	}
+	got := untarGz(t, data)
+	if len(got) != 3 || got[snapshotReportName] != "0123456789" || len(got["a.log"]) != 30 || len(got["c.log"]) != 20 {
+		t.Fatalf("unexpected snapshot contents %v", got)
+	}
+
+	// A report over the cap is cut and leaves no room for files.
+	data, skipped, err = buildSnapshot(dir, files, []byte("0123456789"), 4)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if got := untarGz(t, data); len(got) != 1 || got[snapshotReportName] != "0123" || len(skipped) != 3 {
+		t.Fatalf("expected only the truncated report, got %v (skipped %v)", got, skipped)
+	}
+}
+
+func newSnapshotRunner(t *testing.T, serverURL string) *Runner {
+	t.Helper()
+	dataDir := t.TempDir()
+	r := NewRunner(&Config{
+		ServerURL:         serverURL,
+		DataDir:           dataDir,
+		WorkDir:           filepath.Join(dataDir, workDirName),
+		KillGracePeriod:   time.Second,
+		SetupTimeout:      10 * time.Second,
+		SnapshotOnFailure: true,
+		SnapshotMaxBytes:  defaultSnapshotMaxBytes,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+		t.Fatalf("create work dir: %v", err)
+	}
+	return r
+}
+
+func TestFailedRunUploadsDiagnosticSnapshot(t *testing.T) {
+	fake := &fakeRunServer{artifact: tarGz(t, map[string]string{
+		"Towerfile": "[app]\nname = \"snap\"\n",
+		".env":      "API_KEY=hunter2\n",
+		"main.sh":   "echo boom > run.log\nexit 3\n",
+	})}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	r := newSnapshotRunner(t, srv.URL)
+	lease := &LeaseResponse{RunID: 4, AttemptNo: 2, LeaseToken: "lease", Entrypoint: "main.sh"}
+	if err := r.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+	if fake.result["status"] != "failed" {
+		t.Fatalf("expected failed result, got %v", fake.result)
+	}
+
+	data, ok := fake.outputs[snapshotOutputName]
+	if !ok {
+		t.Fatalf("expected a snapshot output, got %v", fake.outputs)
+	}
+	files := untarGz(t, data)
+	if files["Towerfile"] == "" || files["run.log"] != "boom\n" {
+		t.Fatalf("expected the Towerfile and run.log in the snapshot, got %v", files)
+	}
+	if _, ok := files[".env"]; ok {
+		t.Fatal("snapshot must not include secret-looking files")
+	}
+	report := files[snapshotReportName]
+	if !strings.Contains(report, "run: 4 (attempt 2)") || !strings.Contains(report, "python: no virtual environment") {
+		t.Fatalf("unexpected report:\n%s", report)
+	}
+	if logs := strings.Join(fake.lines, "\n"); !strings.Contains(logs, "diagnostic snapshot uploaded (") {
+		t.Fatalf("expected the upload in the setup log, got:\n%s", logs)
+	}
+}
+
+func TestDiagnosticSnapshotSkipsCancelledAndStaleRuns(t *testing.T) {
+	fake := &fakeRunServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"requirements.txt": "requests"})
+	lease := &LeaseResponse{RunID: 5, LeaseToken: "lease", Entrypoint: "main.sh"}
+
+	for _, tc := range []struct {
+		name   string
+		mark   func(*runState)
+		upload bool
+	}{
+		{"cancelled", (*runState).markCancel, false},
+		{"stale", (*runState).markStale, false},
+		{"failed", func(*runState) {}, true},
+	} {
+		fake.mu.Lock()
+		fake.outputs = nil
+		fake.mu.Unlock()
+
+		r := newSnapshotRunner(t, srv.URL)
+		state := newRunState(time.Now().Add(time.Minute), 0)
+		tc.mark(state)
+		lc := newLogCollector(r, lease, state, func(string) {})
+		r.uploadFailureSnapshot(context.Background(), lease, dir, lc)
+
+		fake.mu.Lock()
+		_, uploaded := fake.outputs[snapshotOutputName]
+		fake.mu.Unlock()
+		if uploaded != tc.upload {
+			t.Fatalf("%s: expected upload %v, got %v", tc.name, tc.upload, uploaded)
+		}
+	}
+
+	// Disabled, nothing is uploaded even for a failure.
+	fake.outputs = nil
+	r := newSnapshotRunner(t, srv.URL)
+	r.cfg.SnapshotOnFailure = false
+	lc := newLogCollector(r, lease, newRunState(time.Now().Add(time.Minute), 0), func(string) {})
+	r.uploadFailureSnapshot(context.Background(), lease, dir, lc)
+	if len(fake.outputs) != 0 {
+		t.Fatalf("expected no upload with snapshots disabled, got %v", fake.outputs)
+	}
+}
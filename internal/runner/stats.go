@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// hostStats is the optional resource snapshot sent with each heartbeat.
+// Fields the platform cannot measure are left nil and omitted.
+type hostStats struct {
+	CPUPercent    *float64 `json:"cpu_percent,omitempty"`
+	MemUsedBytes  *int64   `json:"mem_used_bytes,omitempty"`
+	MemTotalBytes *int64   `json:"mem_total_bytes,omitempty"`
+	DiskFreeBytes *int64   `json:"disk_free_bytes,omitempty"`
+	Load1         *float64 `json:"load1,omitempty"`
+}
+
+// statsCollector samples host resource usage. Collect returns nil when
+// nothing could be measured; the heartbeat is then sent without stats.
+type statsCollector interface {
+	Collect() *hostStats
+}
+
+// cpuSample holds cumulative jiffies from the aggregate "cpu" line of /proc/stat.
+type cpuSample struct {
+	idle  uint64
+	total uint64
+}
+
+// cpuPercent returns utilisation between two samples, or false if no time elapsed.
+func cpuPercent(prev, cur cpuSample) (float64, bool) {
+	if cur.total <= prev.total || cur.idle < prev.idle {
+		return 0, false
+	}
+	total := float64(cur.total - prev.total)
+	idle := float64(cur.idle - prev.idle)
+	pct := (total - idle) / total * 100
+	if pct < 0 {
+		pct = 0
+	}
+	return pct, true
+}
+
+// parseProcStat extracts the aggregate CPU counters from /proc/stat content.
+// Idle time includes iowait, matching what top(1) reports as idle.
+func parseProcStat(data string) (cpuSample, error) {
+	sc := bufio.NewScanner(strings.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		var s cpuSample
+		for i, f := range fields[1:] {
+			// guest and guest_nice are already counted in user and nice.
+			if i >= 8 {
+				break
+			}
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return cpuSample{}, fmt.Errorf("parse cpu field %d: %w", i, err)
+			}
+			s.total += v
+			if i == 3 || i == 4 {
+				s.idle += v
+			}
+		}
+		return s, nil
+	}
+	return cpuSample{}, fmt.Errorf("no aggregate cpu line")
+}
+
+// parseMeminfo returns total and used memory in bytes from /proc/meminfo
+// content. Used memory is MemTotal minus MemAvailable.
+func parseMeminfo(data string) (total, used int64, err error) {
+	values := map[string]int64{}
+	sc := bufio.NewScanner(strings.NewReader(data))
+	for sc.Scan() {
+		key, rest, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			v *= 1024
+		}
+		values[key] = v
+	}
+	total, ok := values["MemTotal"]
+	if !ok {
+		return 0, 0, fmt.Errorf("missing MemTotal")
+	}
+	avail, ok := values["MemAvailable"]
+	if !ok {
+		return 0, 0, fmt.Errorf("missing MemAvailable")
+	}
+	return total, total - avail, nil
+}
+
+// parseLoadavg returns the one-minute load average from /proc/loadavg content.
+func parseLoadavg(data string) (float64, error) {
+	fields := strings.Fields(data)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
@@ -0,0 +1,70 @@
+//go:build linux
+
+package runner
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+// procStatsCollector reads host stats from /proc and statfs(2). CPU
+// utilisation is measured between consecutive calls, so the first heartbeat
+// of a process reports no cpu_percent.
+type procStatsCollector struct {
+	procDir string
+	diskDir string
+
+	mu      sync.Mutex
+	prevCPU *cpuSample
+}
+
+func newStatsCollector(dataDir string) statsCollector {
+	return &procStatsCollector{procDir: "/proc", diskDir: dataDir}
+}
+
+func (c *procStatsCollector) Collect() *hostStats {
+	var stats hostStats
+	found := false
+
+	if data, err := os.ReadFile(c.procDir + "/stat"); err == nil {
+		if cur, err := parseProcStat(string(data)); err == nil {
+			c.mu.Lock()
+			if c.prevCPU != nil {
+				if pct, ok := cpuPercent(*c.prevCPU, cur); ok {
+					stats.CPUPercent = &pct
+					found = true
+				}
+			}
+			c.prevCPU = &cur
+			c.mu.Unlock()
+		}
+	}
+
+	if data, err := os.ReadFile(c.procDir + "/meminfo"); err == nil {
+		if total, used, err := parseMeminfo(string(data)); err == nil {
+			stats.MemTotalBytes = &total
+			stats.MemUsedBytes = &used
+			found = true
+		}
+	}
+
+	if data, err := os.ReadFile(c.procDir + "/loadavg"); err == nil {
+		if load, err := parseLoadavg(string(data)); err == nil {
+			stats.Load1 = &load
+			found = true
+		}
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(c.diskDir, &fs); err == nil {
+		free := int64(fs.Bavail) * int64(fs.Bsize)
+		stats.DiskFreeBytes = &free
+		found = true
+	}
+
+	if !found {
+		return nil
+	}
+	return &stats
+}
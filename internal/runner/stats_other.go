@@ -0,0 +1,15 @@
+//go:build !linux
+
+package runner
+
+// noStatsCollector is used where host stats are not implemented; heartbeats
+// are sent without a stats object.
+type noStatsCollector struct{}
+
+func newStatsCollector(string) statsCollector {
+	return noStatsCollector{}
+}
+
+func (noStatsCollector) Collect() *hostStats {
+	return nil
+}
@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseProcStatAndCPUPercent(t *testing.T) {
+	prev, err := parseProcStat("cpu  100 0 100 700 100 0 0 0 50 0\ncpu0 1 2 3 4 5 6 7 8 9 10\n")
+	if err != nil {
+		t.Fatalf("parse prev: %v", err)
+	}
+	if prev.total != 1000 || prev.idle != 800 {
+		t.Fatalf("unexpected sample: %+v", prev)
+	}
+
+	cur, err := parseProcStat("cpu  250 0 150 1300 100 0 0 0 90 0\n")
+	if err != nil {
+		t.Fatalf("parse cur: %v", err)
+	}
+	pct, ok := cpuPercent(prev, cur)
+	if !ok {
+		t.Fatal("expected cpu percent")
+	}
+	// 800 jiffies elapsed, 600 idle.
+	if pct != 25 {
+		t.Fatalf("expected 25%%, got %v", pct)
+	}
+
+	if _, ok := cpuPercent(cur, cur); ok {
+		t.Fatal("expected no cpu percent without elapsed time")
+	}
+	if _, err := parseProcStat("intr 1 2 3\n"); err == nil {
+		t.Fatal("expected error without aggregate cpu line")
+	}
+}
+
+func TestParseMeminfoAndLoadavg(t *testing.T) {
+	total, used, err := parseMeminfo("MemTotal:        2048 kB\nMemFree:          256 kB\nMemAvailable:    1024 kB\n")
+	if err != nil {
+		t.Fatalf("parse meminfo: %v", err)
+	}
+	if total != 2048*1024 || used != 1024*1024 {
+		t.Fatalf("unexpected memory: total=%d used=%d", total, used)
+	}
+	if _, _, err := parseMeminfo("MemTotal: 2048 kB\n"); err == nil {
+		t.Fatal("expected error without MemAvailable")
+	}
+
+	load, err := parseLoadavg("0.42 0.30 0.10 1/234 5678\n")
+	if err != nil {
+		t.Fatalf("parse loadavg: %v", err)
+	}
+	if load != 0.42 {
+		t.Fatalf("expected load 0.42, got %v", load)
+	}
+}
+
+type fakeStatsCollector struct {
+	stats *hostStats
+}
+
+func (f fakeStatsCollector) Collect() *hostStats { return f.stats }
+
+func TestHeartbeatSendsCollectedStats(t *testing.T) {
+	var bodies []map[string]json.RawMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]json.RawMessage
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &body); err != nil {
+				t.Errorf("decode heartbeat body: %v", err)
+			}
+			if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected JSON content type, got %q", ct)
+			}
+		}
+		bodies = append(bodies, body)
+		_ = json.NewEncoder(w).Encode(map[string]any{"lease_expires_at": "2030-01-01T00:00:00Z"})
+	}))
+	defer srv.Close()
+
+	cpu := 12.5
+	r := &Runner{
+		cfg:        &Config{ServerURL: srv.URL},
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		httpClient: srv.Client(),
+		stats:      fakeStatsCollector{stats: &hostStats{CPUPercent: &cpu}},
+	}
+	lease := &LeaseResponse{RunID: 1, LeaseToken: "lease"}
+	if _, err := r.heartbeat(context.Background(), lease); err != nil {
+		t.Fatalf("heartbeat with stats: %v", err)
+	}
+
+	// A collector with nothing to report falls back to an empty heartbeat.
+	r.stats = fakeStatsCollector{}
+	if _, err := r.heartbeat(context.Background(), lease); err != nil {
+		t.Fatalf("heartbeat without stats: %v", err)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 heartbeats, got %d", len(bodies))
+	}
+	if got := string(bodies[0]["stats"]); got != `{"cpu_percent":12.5}` {
+		t.Fatalf("unexpected stats payload: %s", got)
+	}
+	if bodies[1] != nil {
+		t.Fatalf("expected empty body, got %v", bodies[1])
+	}
+}
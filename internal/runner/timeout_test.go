@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatShrinksRunTimeout(t *testing.T) {
+	// The short lease makes the runner heartbeat after about two seconds;
+	// that heartbeat lowers the timeout below the elapsed time.
+	fake := &fakeRunServer{
+		artifact:         tarGz(t, map[string]string{"main.sh": "echo started\nexec sleep 30\n"}),
+		leaseTTL:         6 * time.Second,
+		heartbeatTimeout: 1,
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	r := NewRunner(&Config{
+		ServerURL:       srv.URL,
+		DataDir:         dataDir,
+		WorkDir:         filepath.Join(dataDir, workDirName),
+		KillGracePeriod: time.Second,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := os.MkdirAll(r.workDir(), 0o700); err != nil {
+		t.Fatalf("create work dir: %v", err)
+	}
+
+	timeout := 3600
+	lease := &LeaseResponse{RunID: 4, LeaseToken: "lease", Entrypoint: "main.sh", TimeoutSeconds: &timeout}
+	start := time.Now()
+	if err := r.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 15*time.Second {
+		t.Fatalf("expected the run to stop on the first heartbeat, took %v", elapsed)
+	}
+	if fake.result["status"] != "failed" {
+		t.Fatalf("expected failed result, got %v", fake.result)
+	}
+	if logs := strings.Join(fake.lines, "\n"); !strings.Contains(logs, "run failed: timeout exceeded") {
+		t.Fatalf("expected timeout log line, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"strings"
+	"time"
+)
+
+// tracebackHeader is the first line Python writes for an uncaught exception.
+const tracebackHeader = "Traceback (most recent call last):"
+
+// tracebackGroup collects the stderr lines of one Python traceback, from the
+// header through the final exception line, so they are logged as one entry.
+type tracebackGroup struct {
+	lines   []string
+	started time.Time
+	// done is set once the final exception line has been added.
+	done bool
+}
+
+func newTracebackGroup(header string, now time.Time) *tracebackGroup {
+	return &tracebackGroup{lines: []string{header}, started: now}
+}
+
+// add appends a stderr line and reports whether it belongs to the
+// traceback. Frames and source lines are indented; the first line that is
+// not is the exception and completes the traceback. A blank line or a new
+// header does not belong, so the caller ends the group before logging it.
+func (g *tracebackGroup) add(line string) bool {
+	if g.done || line == "" || strings.HasPrefix(line, tracebackHeader) {
+		return false
+	}
+	g.lines = append(g.lines, line)
+	g.done = line[0] != ' ' && line[0] != '\t'
+	return true
+}
+
+// entries joins the traceback's lines with newlines into as few entries as
+// fit in maxBytes each. Lines are never split across entries; a single line
+// over the cap is truncated as any other log line would be.
+func (g *tracebackGroup) entries(maxBytes int) []string {
+	var entries []string
+	var b strings.Builder
+	for _, line := range g.lines {
+		if len(line) > maxBytes {
+			line = line[:maxBytes]
+		}
+		if b.Len() > 0 && b.Len()+1+len(line) > maxBytes {
+			entries = append(entries, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(line)
+	}
+	if b.Len() > 0 {
+		entries = append(entries, b.String())
+	}
+	return entries
+}
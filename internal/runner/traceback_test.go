@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capturedTraceback is stderr from a failing Python 3.12 script.
+const capturedTraceback = `Traceback (most recent call last):
+  File "/work/main.py", line 14, in <module>
+    main()
+  File "/work/main.py", line 10, in main
+    total = summarize(load(path))
+            ^^^^^^^^^^^^^^^^^^^^^
+  File "/work/report.py", line 22, in summarize
+    return sum(row["amount"] for row in rows) / len(rows)
+           ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~^~~~~~~~~~~
+ZeroDivisionError: division by zero`
+
+func newTestLogCollector(group bool) *logCollector {
+	r := &Runner{cfg: &Config{GroupTracebacks: group}}
+	return newLogCollector(r, &LeaseResponse{}, newRunState(time.Now().Add(time.Minute), 0), func(string) {})
+}
+
+func enqueueAll(lc *logCollector, stream, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		lc.enqueue(stream, line)
+	}
+}
+
+func TestTracebackGroupedIntoOneEntry(t *testing.T) {
+	lc := newTestLogCollector(true)
+	lc.enqueue("stderr", "warming up")
+	enqueueAll(lc, "stderr", capturedTraceback)
+	lc.enqueue("stderr", "after")
+
+	if len(lc.logs) != 3 {
+		t.Fatalf("expected 3 entries, got %d: %+v", len(lc.logs), lc.logs)
+	}
+	if lc.logs[1].Line != capturedTraceback || lc.logs[1].Stream != "stderr" {
+		t.Fatalf("expected the traceback as one entry, got %q", lc.logs[1].Line)
+	}
+	if lc.logs[0].Seq != 1 || lc.logs[1].Seq != 2 || lc.logs[2].Seq != 3 {
+		t.Fatalf("expected consecutive seqs, got %+v", lc.logs)
+	}
+
+	off := newTestLogCollector(false)
+	enqueueAll(off, "stderr", capturedTraceback)
+	if len(off.logs) != strings.Count(capturedTraceback, "\n")+1 {
+		t.Fatalf("expected one entry per line with grouping off, got %d", len(off.logs))
+	}
+}
+
+func TestTracebackGroupSplitsAtLineCap(t *testing.T) {
+	lc := newTestLogCollector(true)
+	lines := []string{tracebackHeader}
+	for range 200 {
+		lines = append(lines, `  File "/work/recurse.py", line 3, in recurse`, "    return recurse(n + 1)")
+	}
+	lines = append(lines, "RecursionError: maximum recursion depth exceeded")
+	enqueueAll(lc, "stderr", strings.Join(lines, "\n"))
+
+	if len(lc.logs) < 2 {
+		t.Fatalf("expected an oversized traceback split, got %d entries", len(lc.logs))
+	}
+	var parts []string
+	for _, l := range lc.logs {
+		if len(l.Line) > logLineMaxBytes {
+			t.Fatalf("entry %d is %d bytes, over the cap", l.Seq, len(l.Line))
+		}
+		parts = append(parts, l.Line)
+	}
+	if strings.Join(parts, "\n") != strings.Join(lines, "\n") {
+		t.Fatal("split entries do not rejoin to the traceback")
+	}
+}
+
+func TestTracebackGroupBrokenByStdout(t *testing.T) {
+	lc := newTestLogCollector(true)
+	lc.enqueue("stderr", tracebackHeader)
+	lc.enqueue("stderr", `  File "/work/main.py", line 14, in <module>`)
+	lc.enqueue("stdout", "progress 50%")
+	lc.enqueue("stderr", "    main()")
+	lc.enqueue("stderr", "ValueError: bad input")
+
+	var got []string
+	for _, l := range lc.logs {
+		got = append(got, l.Stream+":"+l.Line)
+	}
+	want := []string{
+		"stderr:" + tracebackHeader + "\n" + `  File "/work/main.py", line 14, in <module>`,
+		"stdout:progress 50%",
+		"stderr:    main()",
+		"stderr:ValueError: bad input",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected entries:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestTracebackCutShortEndsWithOutput(t *testing.T) {
+	lc := newTestLogCollector(true)
+	lc.collect(context.Background(), strings.NewReader(tracebackHeader+"\n  File \"/work/main.py\", line 1\n"), "stderr")
+	if len(lc.logs) != 1 || lc.logs[0].Line != tracebackHeader+"\n  File \"/work/main.py\", line 1" {
+		t.Fatalf("expected the partial traceback logged at EOF, got %+v", lc.logs)
+	}
+}
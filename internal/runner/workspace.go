@@ -0,0 +1,246 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	workspacePrefix    = "minitower-run-"
+	workspaceMaxAge    = 24 * time.Hour
+	defaultMinFreeDisk = 256 * 1024 * 1024
+	workDirName        = "work"
+	// maxListedArtifactFiles caps the top-level files named when an
+	// entrypoint is missing from the artifact.
+	maxListedArtifactFiles = 20
+)
+
+// errDiskFreeUnsupported is returned by diskFree on platforms where free
+// space cannot be measured; the preflight is skipped there.
+var errDiskFreeUnsupported = errors.New("disk free space not supported on this platform")
+
+// insufficientDiskError reports a failed disk space preflight. Its message is
+// sent as the run's error message as-is.
+type insufficientDiskError struct {
+	Need int64
+	Have int64
+}
+
+func (e *insufficientDiskError) Error() string {
+	return fmt.Sprintf("insufficient disk space: need %s, have %s", formatBytes(e.Need), formatBytes(e.Have))
+}
+
+// workspaceError reports a workspace that cannot run the entrypoint. Detail
+// is written as a setup log line; the message is sent as the run's error
+// message as-is.
+type workspaceError struct {
+	Message string
+	Detail  string
+}
+
+func (e *workspaceError) Error() string {
+	return e.Message
+}
+
+// workDir returns the directory run workspaces are created in.
+func (r *Runner) workDir() string {
+	if r.cfg.WorkDir != "" {
+		return r.cfg.WorkDir
+	}
+	return filepath.Join(r.cfg.DataDir, workDirName)
+}
+
+// createWorkspace makes a fresh workspace directory for a run under the
+// configured work directory.
+func (r *Runner) createWorkspace(runID int64) (string, error) {
+	dir := r.workDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(dir, fmt.Sprintf("%s%d-", workspacePrefix, runID))
+}
+
+// checkDiskSpace fails if the filesystem holding dir has less than the
+// configured minimum plus artifactSize bytes available. An unknown
+// artifactSize (-1) only checks the minimum.
+func (r *Runner) checkDiskSpace(dir string, artifactSize int64) error {
+	have, err := r.diskFree(dir)
+	if err != nil {
+		if !errors.Is(err, errDiskFreeUnsupported) {
+			r.logger.Warn("disk space preflight skipped", "dir", dir, "error", err)
+		}
+		return nil
+	}
+	need := r.cfg.MinFreeDisk
+	if artifactSize > 0 {
+		need += artifactSize
+	}
+	if have < need {
+		return &insufficientDiskError{Need: need, Have: have}
+	}
+	return nil
+}
+
+// validateWorkspace checks that the entrypoint exists in the unpacked
+// artifact and that what runs it is in place: the venv interpreter for
+// Python entrypoints, a shell for .sh ones. A broken workspace then fails
+// with a clear message instead of an opaque start error. A missing
+// entrypoint is the artifact's fault, so it is a userFailureError.
+func validateWorkspace(ws *workspaceResult, lease *LeaseResponse) error {
+	info, err := os.Stat(filepath.Join(ws.Dir, lease.Entrypoint))
+	if err != nil || info.IsDir() {
+		return &userFailureError{&workspaceError{
+			Message: fmt.Sprintf("entrypoint not found: %s", lease.Entrypoint),
+			Detail:  fmt.Sprintf("entrypoint '%s' not found in artifact; %s", lease.Entrypoint, describeArtifactFiles(ws.Dir)),
+		}}
+	}
+	if strings.HasSuffix(lease.Entrypoint, ".py") {
+		python := venvPython(".venv")
+		if _, err := os.Stat(filepath.Join(ws.Dir, python)); err != nil {
+			return &workspaceError{
+				Message: fmt.Sprintf("virtual environment is corrupted: %s not found", filepath.ToSlash(python)),
+				Detail:  fmt.Sprintf("virtual environment is missing its Python interpreter (%s): %v", filepath.ToSlash(python), err),
+			}
+		}
+	}
+	if strings.HasSuffix(lease.Entrypoint, ".sh") {
+		if _, err := shell(); err != nil {
+			return &workspaceError{Message: err.Error(), Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
+// checkWorkspacePaths checks that the entrypoint and the import paths stay
+// inside the unpacked workspace dir: each must be relative, must not contain
+// "..", and must not resolve through a symlink to somewhere outside dir.
+// Paths that do not exist pass; validateWorkspace reports a missing
+// entrypoint.
+func checkWorkspacePaths(dir, entrypoint string, importPaths []string) error {
+	if err := checkWorkspacePath(dir, entrypoint); err != nil {
+		return &workspaceError{
+			Message: fmt.Sprintf("invalid entrypoint: %s", entrypoint),
+			Detail:  fmt.Sprintf("entrypoint '%s' rejected: %v", entrypoint, err),
+		}
+	}
+	for _, p := range importPaths {
+		if err := checkWorkspacePath(dir, p); err != nil {
+			return &workspaceError{
+				Message: fmt.Sprintf("invalid import path: %s", p),
+				Detail:  fmt.Sprintf("import path '%s' rejected: %v", p, err),
+			}
+		}
+	}
+	return nil
+}
+
+// checkWorkspacePath reports why p may not be used as a path inside dir, or
+// nil if it may.
+func checkWorkspacePath(dir, p string) error {
+	native := filepath.FromSlash(p)
+	if filepath.IsAbs(native) || filepath.VolumeName(native) != "" || strings.HasPrefix(p, "/") {
+		return errors.New("path must be relative")
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(native), "/") {
+		if elem == ".." {
+			return errors.New(`path must not contain ".."`)
+		}
+	}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(dir, native))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("path resolves to %s, outside the workspace", resolved)
+	}
+	return nil
+}
+
+// describeArtifactFiles lists the top-level files of a workspace that came
+// from the artifact, leaving out the ones the runner creates itself.
+// Directories get a trailing slash.
+func describeArtifactFiles(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Sprintf("listing artifact failed: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		switch e.Name() {
+		case "artifact.tar.gz", inputFileName, outputsDirName, ".venv", pipLogName, snapshotOutputName:
+			continue
+		}
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "artifact contains no files"
+	}
+	sort.Strings(names)
+	more := ""
+	if len(names) > maxListedArtifactFiles {
+		names, more = names[:maxListedArtifactFiles], " …"
+	}
+	return "artifact contains: " + strings.Join(names, ", ") + more
+}
+
+// sweepWorkspaces removes run workspaces in dir last modified before
+// now-maxAge. A runner only has one run in flight and always removes its
+// workspace, so anything that old was leaked by a crash.
+func sweepWorkspaces(dir string, now time.Time, maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	cutoff := now.Add(-maxAge)
+	removed := 0
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), workspacePrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// formatBytes renders a byte count with a binary unit suffix, e.g. 1.5G.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,272 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newWorkspaceTestRunner(t *testing.T, serverURL string, free int64) *Runner {
+	t.Helper()
+	dataDir := t.TempDir()
+	r := NewRunner(&Config{
+		ServerURL:   serverURL,
+		DataDir:     dataDir,
+		WorkDir:     filepath.Join(dataDir, "scratch"),
+		MinFreeDisk: 1000,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	r.diskFree = func(string) (int64, error) { return free, nil }
+	return r
+}
+
+func TestDownloadArtifactRejectsInsufficientDiskSpace(t *testing.T) {
+	artifact := strings.Repeat("x", 500)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/gzip")
+		_, _ = io.WriteString(w, artifact)
+	}))
+	defer srv.Close()
+
+	r := newWorkspaceTestRunner(t, srv.URL, 1200)
+	dest := filepath.Join(t.TempDir(), "artifact.tar.gz")
+
+	_, err := r.downloadArtifact(context.Background(), &LeaseResponse{RunID: 1}, dest)
+	var diskErr *insufficientDiskError
+	if !errors.As(err, &diskErr) {
+		t.Fatalf("expected insufficient disk error, got %v", err)
+	}
+	if diskErr.Need != 1500 || diskErr.Have != 1200 {
+		t.Fatalf("need/have = %d/%d, want 1500/1200", diskErr.Need, diskErr.Have)
+	}
+	if got, want := err.Error(), "insufficient disk space: need 1.5K, have 1.2K"; got != want {
+		t.Fatalf("message = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("artifact should not be written, stat err = %v", err)
+	}
+}
+
+func TestDownloadArtifactPassesPreflightWithEnoughSpace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, strings.Repeat("x", 500))
+	}))
+	defer srv.Close()
+
+	r := newWorkspaceTestRunner(t, srv.URL, 1500)
+	dest := filepath.Join(t.TempDir(), "artifact.tar.gz")
+	if _, err := r.downloadArtifact(context.Background(), &LeaseResponse{RunID: 1}, dest); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+}
+
+func TestCreateWorkspaceUsesConfiguredWorkDir(t *testing.T) {
+	r := newWorkspaceTestRunner(t, "", 0)
+
+	dir, err := r.createWorkspace(42)
+	if err != nil {
+		t.Fatalf("create workspace: %v", err)
+	}
+	if filepath.Dir(dir) != r.cfg.WorkDir {
+		t.Fatalf("workspace %s not under %s", dir, r.cfg.WorkDir)
+	}
+	if !strings.HasPrefix(filepath.Base(dir), "minitower-run-42-") {
+		t.Fatalf("unexpected workspace name %s", filepath.Base(dir))
+	}
+}
+
+func TestSweepWorkspacesRemovesOnlyOldRunDirs(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	old := now.Add(-25 * time.Hour)
+
+	mk := func(name string, mtime time.Time) string {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(p, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	oldRun := mk("minitower-run-1-abc", old)
+	newRun := mk("minitower-run-2-def", now.Add(-time.Hour))
+	otherOld := mk("keep-me", old)
+
+	n, err := sweepWorkspaces(dir, now, workspaceMaxAge)
+	if err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("removed %d, want 1", n)
+	}
+	if _, err := os.Stat(oldRun); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("old workspace should be removed, stat err = %v", err)
+	}
+	for _, p := range []string{newRun, otherOld} {
+		if _, err := os.Stat(p); err != nil {
+			t.Fatalf("%s should be kept: %v", p, err)
+		}
+	}
+}
+
+func TestSweepWorkspacesMissingDir(t *testing.T) {
+	n, err := sweepWorkspaces(filepath.Join(t.TempDir(), "missing"), time.Now(), workspaceMaxAge)
+	if err != nil || n != 0 {
+		t.Fatalf("sweep missing dir = %d, %v", n, err)
+	}
+}
+
+func writeWorkspaceFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestValidateWorkspaceMissingEntrypoint(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkspaceFiles(t, dir, "app.py", "requirements.txt", "lib/util.py",
+		"artifact.tar.gz", inputFileName, outputsDirName+"/.keep", ".venv/bin/python")
+
+	err := validateWorkspace(&workspaceResult{Dir: dir}, &LeaseResponse{Entrypoint: "main.py"})
+	var wsErr *workspaceError
+	if !errors.As(err, &wsErr) {
+		t.Fatalf("expected a workspace error, got %v", err)
+	}
+	if wsErr.Error() != "entrypoint not found: main.py" {
+		t.Fatalf("unexpected message %q", wsErr.Error())
+	}
+	want := "entrypoint 'main.py' not found in artifact; artifact contains: app.py, lib/, requirements.txt"
+	if wsErr.Detail != want {
+		t.Fatalf("detail = %q, want %q", wsErr.Detail, want)
+	}
+}
+
+func TestValidateWorkspaceListsAtMostTwentyFiles(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 25; i++ {
+		writeWorkspaceFiles(t, dir, fmt.Sprintf("f%02d.txt", i))
+	}
+
+	err := validateWorkspace(&workspaceResult{Dir: dir}, &LeaseResponse{Entrypoint: "main.sh"})
+	var wsErr *workspaceError
+	if !errors.As(err, &wsErr) {
+		t.Fatalf("expected a workspace error, got %v", err)
+	}
+	if !strings.HasSuffix(wsErr.Detail, "f18.txt, f19.txt …") || strings.Contains(wsErr.Detail, "f20.txt") {
+		t.Fatalf("expected the listing capped at 20 files, got %q", wsErr.Detail)
+	}
+}
+
+func TestValidateWorkspaceMissingVenvPython(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkspaceFiles(t, dir, "main.py", ".venv/pyvenv.cfg")
+
+	err := validateWorkspace(&workspaceResult{Dir: dir}, &LeaseResponse{Entrypoint: "main.py"})
+	var wsErr *workspaceError
+	if !errors.As(err, &wsErr) {
+		t.Fatalf("expected a workspace error, got %v", err)
+	}
+	if !strings.Contains(wsErr.Error(), "virtual environment is corrupted") {
+		t.Fatalf("unexpected message %q", wsErr.Error())
+	}
+
+	writeWorkspaceFiles(t, dir, ".venv/bin/python")
+	if err := validateWorkspace(&workspaceResult{Dir: dir}, &LeaseResponse{Entrypoint: "main.py"}); err != nil {
+		t.Fatalf("expected a valid workspace, got %v", err)
+	}
+	// Shell entrypoints have no venv to check.
+	writeWorkspaceFiles(t, dir, "main.sh")
+	if err := os.RemoveAll(filepath.Join(dir, ".venv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateWorkspace(&workspaceResult{Dir: dir}, &LeaseResponse{Entrypoint: "main.sh"}); err != nil {
+		t.Fatalf("expected a valid shell workspace, got %v", err)
+	}
+}
+
+func TestMissingEntrypointFailsRun(t *testing.T) {
+	fake := &fakeRunServer{artifact: tarGz(t, map[string]string{"app.sh": "echo hi\n"})}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dataDir := t.TempDir()
+	r := NewRunner(&Config{
+		ServerURL:       srv.URL,
+		DataDir:         dataDir,
+		WorkDir:         filepath.Join(dataDir, workDirName),
+		KillGracePeriod: time.Second,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	lease := &LeaseResponse{RunID: 4, LeaseToken: "lease", Entrypoint: "main.sh"}
+	if err := r.executeRun(context.Background(), lease); err != nil {
+		t.Fatalf("execute run: %v", err)
+	}
+	if fake.result["status"] != "failed" || fake.result["error_message"] != "entrypoint not found: main.sh" {
+		t.Fatalf("unexpected result %v", fake.result)
+	}
+	logs := strings.Join(fake.lines, "\n")
+	if !strings.Contains(logs, "entrypoint 'main.sh' not found in artifact; artifact contains: app.sh") {
+		t.Fatalf("expected the artifact listing in logs, got:\n%s", logs)
+	}
+}
+
+func TestCheckWorkspacePaths(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	writeWorkspaceFiles(t, dir, "main.py", "src/pkg/util.py")
+	writeWorkspaceFiles(t, outside, "secret.py")
+	if err := os.Symlink(outside, filepath.Join(dir, "lib")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.py"), filepath.Join(dir, "run.py")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("src", filepath.Join(dir, "vendor")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		entrypoint  string
+		importPaths []string
+		want        string
+	}{
+		{"nested import path", "main.py", []string{"src", "src/pkg"}, ""},
+		{"symlink inside the workspace", "main.py", []string{"vendor/pkg"}, ""},
+		{"missing import path", "main.py", []string{"generated"}, ""},
+		{"escaping symlink", "main.py", []string{"src", "lib"}, "import path 'lib' rejected: path resolves to "},
+		{"absolute import path", "main.py", []string{outside}, "rejected: path must be relative"},
+		{"dot-dot import path", "main.py", []string{"src/../../etc"}, `rejected: path must not contain ".."`},
+		{"escaping entrypoint", "run.py", nil, "entrypoint 'run.py' rejected: path resolves to "},
+		{"absolute entrypoint", "/etc/main.py", nil, "rejected: path must be relative"},
+	} {
+		err := checkWorkspacePaths(dir, tc.entrypoint, tc.importPaths)
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", tc.name, err)
+			}
+			continue
+		}
+		var wsErr *workspaceError
+		if !errors.As(err, &wsErr) || !strings.Contains(wsErr.Detail, tc.want) {
+			t.Errorf("%s: expected a workspace error containing %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sort"
+	"time"
+
+	"minitower/internal/fingerprint"
+)
+
+// errorGroupRunIDs is the most example run IDs an error group keeps.
+const errorGroupRunIDs = 5
+
+// Messages grouped for attempts that ended without one.
+const (
+	expiredAttemptMessage = "lease expired"
+	noErrorMessage        = "no error message"
+)
+
+// ErrorGroup is a set of failed attempts of an app whose error messages
+// share a fingerprint.
+type ErrorGroup struct {
+	Fingerprint string
+	// Pattern is the normalized message the fingerprint is taken from.
+	Pattern string
+	Count   int64
+	// SampleMessage is the most recent full message of the group.
+	SampleMessage string
+	FirstSeenAt   time.Time
+	LastSeenAt    time.Time
+	// RunIDs are the runs of the most recent attempts, newest first and
+	// without repeats, at most errorGroupRunIDs.
+	RunIDs []int64
+}
+
+// ListAppErrorGroups groups the app's attempts that failed or expired at or
+// after since by the fingerprint of their error message, and returns the
+// limit largest groups, largest first (ties go to the most recently seen),
+// along with the number of attempts over all groups. Expired attempts
+// without a message are grouped as "lease expired".
+func (s *Store) ListAppErrorGroups(ctx context.Context, appID int64, since time.Time, limit int) ([]*ErrorGroup, int64, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT ra.run_id, ra.status, ra.error_message, ra.finished_at
+     FROM run_attempts ra JOIN runs r ON r.id = ra.run_id
+     WHERE r.app_id = ? AND ra.status IN ('failed', 'expired')
+       AND ra.finished_at IS NOT NULL AND ra.finished_at >= ?
+     ORDER BY ra.finished_at DESC, ra.id DESC`,
+		appID, since.UnixMilli(),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	byFingerprint := make(map[string]*ErrorGroup)
+	var total int64
+	for rows.Next() {
+		var (
+			runID      int64
+			status     string
+			message    sql.NullString
+			finishedMs int64
+		)
+		if err := rows.Scan(&runID, &status, &message, &finishedMs); err != nil {
+			return nil, 0, err
+		}
+		msg := message.String
+		if msg == "" {
+			msg = noErrorMessage
+			if status == "expired" {
+				msg = expiredAttemptMessage
+			}
+		}
+		pattern := fingerprint.Normalize(msg)
+		fp := fingerprint.Of(pattern)
+		finishedAt := time.UnixMilli(finishedMs).UTC()
+		total++
+
+		// Rows come newest first, so the first row of a group sets its
+		// sample and last-seen time and each later one moves first-seen back.
+		g, ok := byFingerprint[fp]
+		if !ok {
+			g = &ErrorGroup{Fingerprint: fp, Pattern: pattern, SampleMessage: msg, LastSeenAt: finishedAt}
+			byFingerprint[fp] = g
+		}
+		g.Count++
+		g.FirstSeenAt = finishedAt
+		if len(g.RunIDs) < errorGroupRunIDs && !slices.Contains(g.RunIDs, runID) {
+			g.RunIDs = append(g.RunIDs, runID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	groups := make([]*ErrorGroup, 0, len(byFingerprint))
+	for _, g := range byFingerprint {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		if !groups[i].LastSeenAt.Equal(groups[j].LastSeenAt) {
+			return groups[i].LastSeenAt.After(groups[j].LastSeenAt)
+		}
+		return groups[i].Fingerprint < groups[j].Fingerprint
+	})
+	if len(groups) > limit {
+		groups = groups[:limit]
+	}
+	return groups, total, nil
+}
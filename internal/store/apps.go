@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 )
@@ -15,15 +16,36 @@ type App struct {
 	Slug        string
 	Description *string
 	Disabled    bool
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	// DefaultInput is merged under the request input of every new run.
+	DefaultInput map[string]any
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Stats        *AppStats // Populated by ListAppsWithStats.
+}
+
+// appStatsWindow is the number of most recent runs SuccessRate is computed over.
+const appStatsWindow = 50
+
+// AppStats holds per-app run aggregates for the apps listing.
+type AppStats struct {
+	LatestVersionNo *int64
+	TotalRuns       int64
+	LastRunStatus   *string
+	LastRunAt       *time.Time // queued_at of the most recent run
+	// SuccessRate is completed / (completed + failed + dead) over the last
+	// appStatsWindow runs; nil when none of them has finished that way.
+	// Cancelled and in-flight runs count towards neither side.
+	SuccessRate *float64
+	// DurationP95 is the execution-time p95 of the app's last 100
+	// completed attempts; nil below DurationBaselineMinSamples.
+	DurationP95 *time.Duration
 }
 
 // CreateApp creates a new app.
 func (s *Store) CreateApp(ctx context.Context, teamID int64, slug string, description *string) (*App, error) {
 	now := time.Now().UnixMilli()
 
-	result, err := s.db.ExecContext(ctx,
+	result, err := s.exec(ctx,
 		`INSERT INTO apps (team_id, slug, description, disabled, created_at, updated_at)
      VALUES (?, ?, ?, 0, ?, ?)`,
 		teamID, slug, description, now, now,
@@ -48,78 +70,60 @@ func (s *Store) CreateApp(ctx context.Context, teamID int64, slug string, descri
 	}, nil
 }
 
-// GetAppBySlug returns an app by team ID and slug.
-func (s *Store) GetAppBySlug(ctx context.Context, teamID int64, slug string) (*App, error) {
+// appColumns is the apps column list scanApp expects, qualified with the
+// alias a.
+const appColumns = `a.id, a.team_id, a.slug, a.description, a.disabled, a.created_at, a.updated_at, a.default_input_json`
+
+// scanApp scans a row selected with appColumns, followed by extra.
+func scanApp(row rowScanner, extra ...any) (*App, error) {
 	var a App
 	var createdAt, updatedAt int64
 	var disabled int
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, team_id, slug, description, disabled, created_at, updated_at
-     FROM apps WHERE team_id = ? AND slug = ?`,
-		teamID, slug,
-	).Scan(&a.ID, &a.TeamID, &a.Slug, &a.Description, &disabled, &createdAt, &updatedAt)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil
-	}
-	if err != nil {
+	var defaultInput sql.NullString
+	dest := []any{&a.ID, &a.TeamID, &a.Slug, &a.Description, &disabled, &createdAt, &updatedAt, &defaultInput}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
 		return nil, err
 	}
 	a.Disabled = disabled == 1
 	a.CreatedAt = time.UnixMilli(createdAt)
 	a.UpdatedAt = time.UnixMilli(updatedAt)
+	if defaultInput.Valid {
+		if err := json.Unmarshal([]byte(defaultInput.String), &a.DefaultInput); err != nil {
+			return nil, err
+		}
+	}
 	return &a, nil
 }
 
-// GetAppByID returns an app by ID (scoped to team).
-func (s *Store) GetAppByID(ctx context.Context, teamID int64, appID int64) (*App, error) {
-	var a App
-	var createdAt, updatedAt int64
-	var disabled int
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, team_id, slug, description, disabled, created_at, updated_at
-     FROM apps WHERE team_id = ? AND id = ?`,
-		teamID, appID,
-	).Scan(&a.ID, &a.TeamID, &a.Slug, &a.Description, &disabled, &createdAt, &updatedAt)
+// getApp returns the single app matching where, or nil, nil.
+func (s *Store) getApp(ctx context.Context, where string, args ...any) (*App, error) {
+	app, err := scanApp(s.db.QueryRowContext(ctx, `SELECT `+appColumns+` FROM apps a WHERE `+where, args...))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
-	if err != nil {
-		return nil, err
-	}
-	a.Disabled = disabled == 1
-	a.CreatedAt = time.UnixMilli(createdAt)
-	a.UpdatedAt = time.UnixMilli(updatedAt)
-	return &a, nil
+	return app, err
+}
+
+// GetAppBySlug returns an app by team ID and slug.
+func (s *Store) GetAppBySlug(ctx context.Context, teamID int64, slug string) (*App, error) {
+	return s.getApp(ctx, `a.team_id = ? AND a.slug = ?`, teamID, slug)
+}
+
+// GetAppByID returns an app by ID (scoped to team).
+func (s *Store) GetAppByID(ctx context.Context, teamID int64, appID int64) (*App, error) {
+	return s.getApp(ctx, `a.team_id = ? AND a.id = ?`, teamID, appID)
 }
 
 // GetAppByIDDirect returns an app by ID without team scoping.
 // Used by runner-scoped handlers where the lease token proves authorization.
 func (s *Store) GetAppByIDDirect(ctx context.Context, appID int64) (*App, error) {
-	var a App
-	var createdAt, updatedAt int64
-	var disabled int
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, team_id, slug, description, disabled, created_at, updated_at
-     FROM apps WHERE id = ?`,
-		appID,
-	).Scan(&a.ID, &a.TeamID, &a.Slug, &a.Description, &disabled, &createdAt, &updatedAt)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	a.Disabled = disabled == 1
-	a.CreatedAt = time.UnixMilli(createdAt)
-	a.UpdatedAt = time.UnixMilli(updatedAt)
-	return &a, nil
+	return s.getApp(ctx, `a.id = ?`, appID)
 }
 
 // ListApps returns all apps for a team.
 func (s *Store) ListApps(ctx context.Context, teamID int64) ([]*App, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, team_id, slug, description, disabled, created_at, updated_at
-     FROM apps WHERE team_id = ? ORDER BY slug`,
+		`SELECT `+appColumns+` FROM apps a WHERE a.team_id = ? ORDER BY a.slug`,
 		teamID,
 	)
 	if err != nil {
@@ -129,20 +133,97 @@ func (s *Store) ListApps(ctx context.Context, teamID int64) ([]*App, error) {
 
 	var apps []*App
 	for rows.Next() {
-		var a App
-		var createdAt, updatedAt int64
-		var disabled int
-		if err := rows.Scan(&a.ID, &a.TeamID, &a.Slug, &a.Description, &disabled, &createdAt, &updatedAt); err != nil {
+		a, err := scanApp(rows)
+		if err != nil {
 			return nil, err
 		}
-		a.Disabled = disabled == 1
-		a.CreatedAt = time.UnixMilli(createdAt)
-		a.UpdatedAt = time.UnixMilli(updatedAt)
-		apps = append(apps, &a)
+		apps = append(apps, a)
 	}
 	return apps, rows.Err()
 }
 
+// ListAppsWithStats returns all apps for a team with Stats populated. The
+// aggregates come from one grouped query, so the cost does not grow with the
+// number of apps beyond the scan of the team's runs.
+func (s *Store) ListAppsWithStats(ctx context.Context, teamID int64) ([]*App, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`WITH ranked AS (
+       SELECT app_id, status, queued_at,
+              ROW_NUMBER() OVER (PARTITION BY app_id ORDER BY run_no DESC) AS rn
+       FROM runs WHERE team_id = ?
+     ),
+     run_stats AS (
+       SELECT app_id,
+              COUNT(*) AS total_runs,
+              MAX(CASE WHEN rn = 1 THEN status END) AS last_status,
+              MAX(CASE WHEN rn = 1 THEN queued_at END) AS last_at,
+              SUM(CASE WHEN rn <= ? AND status = 'completed' THEN 1 ELSE 0 END) AS recent_ok,
+              SUM(CASE WHEN rn <= ? AND status IN ('completed', 'failed', 'dead') THEN 1 ELSE 0 END) AS recent_finished
+       FROM ranked GROUP BY app_id
+     ),
+     version_stats AS (
+       SELECT v.app_id, MAX(v.version_no) AS latest_version_no
+       FROM app_versions v JOIN apps a ON a.id = v.app_id
+       WHERE a.team_id = ?
+       GROUP BY v.app_id
+     )
+     SELECT `+appColumns+`,
+            vs.latest_version_no, COALESCE(rs.total_runs, 0), rs.last_status, rs.last_at,
+            COALESCE(rs.recent_ok, 0), COALESCE(rs.recent_finished, 0)
+     FROM apps a
+     LEFT JOIN run_stats rs ON rs.app_id = a.id
+     LEFT JOIN version_stats vs ON vs.app_id = a.id
+     WHERE a.team_id = ? ORDER BY a.slug`,
+		teamID, appStatsWindow, appStatsWindow, teamID, teamID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var apps []*App
+	for rows.Next() {
+		var st AppStats
+		var latestVersionNo, lastAt sql.NullInt64
+		var lastStatus sql.NullString
+		var recentOK, recentFinished int64
+		a, err := scanApp(rows, &latestVersionNo, &st.TotalRuns, &lastStatus, &lastAt, &recentOK, &recentFinished)
+		if err != nil {
+			return nil, err
+		}
+		if latestVersionNo.Valid {
+			st.LatestVersionNo = &latestVersionNo.Int64
+		}
+		if lastStatus.Valid {
+			st.LastRunStatus = &lastStatus.String
+		}
+		if lastAt.Valid {
+			t := time.UnixMilli(lastAt.Int64)
+			st.LastRunAt = &t
+		}
+		if recentFinished > 0 {
+			rate := float64(recentOK) / float64(recentFinished)
+			st.SuccessRate = &rate
+		}
+		a.Stats = &st
+		apps = append(apps, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	baselines, err := s.TeamDurationBaselines(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range apps {
+		if b, ok := baselines[a.ID]; ok {
+			a.Stats.DurationP95 = &b.P95
+		}
+	}
+	return apps, nil
+}
+
 // AppExistsBySlug checks if an app with the given slug exists for a team.
 func (s *Store) AppExistsBySlug(ctx context.Context, teamID int64, slug string) (bool, error) {
 	var exists int
@@ -158,3 +239,63 @@ func (s *Store) AppExistsBySlug(ctx context.Context, teamID int64, slug string)
 	}
 	return true, nil
 }
+
+// SetAppDefaultInput replaces an app's default run input; nil clears it.
+// Runs already created keep the input they were created with.
+func (s *Store) SetAppDefaultInput(ctx context.Context, appID int64, input map[string]any) error {
+	var inputJSON *string
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		v := string(data)
+		inputJSON = &v
+	}
+	_, err := s.exec(ctx,
+		`UPDATE apps SET default_input_json = ?, updated_at = ? WHERE id = ?`,
+		inputJSON, time.Now().UnixMilli(), appID,
+	)
+	return err
+}
+
+// SetAppDescription replaces an app's description; nil clears it.
+func (s *Store) SetAppDescription(ctx context.Context, appID int64, description *string) error {
+	_, err := s.exec(ctx,
+		`UPDATE apps SET description = ?, updated_at = ? WHERE id = ?`,
+		description, time.Now().UnixMilli(), appID,
+	)
+	return err
+}
+
+// MergeDefaultInput returns input deep-merged over defaults: nested objects
+// merge key by key, any other request value (arrays included) replaces the
+// default, and an explicit null in input removes the defaulted key. Neither
+// argument is modified. It returns input unchanged when there are no defaults.
+func MergeDefaultInput(defaults, input map[string]any) map[string]any {
+	if len(defaults) == 0 {
+		return input
+	}
+	merged := make(map[string]any, len(defaults)+len(input))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range input {
+		def, defaulted := defaults[k]
+		switch {
+		case v == nil && defaulted:
+			delete(merged, k)
+		case defaulted:
+			defMap, defIsMap := def.(map[string]any)
+			inMap, inIsMap := v.(map[string]any)
+			if defIsMap && inIsMap {
+				merged[k] = MergeDefaultInput(defMap, inMap)
+			} else {
+				merged[k] = v
+			}
+		default:
+			merged[k] = v
+		}
+	}
+	return merged
+}
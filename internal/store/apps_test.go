@@ -0,0 +1,199 @@
+package store_test
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestListAppsWithStats(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-app-stats")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+
+	busy := testutil.CreateApp(t, s, team.ID, "busy")
+	testutil.CreateVersion(t, s, busy.ID)
+	v2 := testutil.CreateVersion(t, s, busy.ID)
+	idle := testutil.CreateApp(t, s, team.ID, "idle")
+
+	// Another team's runs must not leak into the aggregates.
+	other, _ := testutil.CreateTeam(t, s, "team-app-stats-other")
+	otherEnv, err := s.GetOrCreateDefaultEnvironment(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("get other env: %v", err)
+	}
+	otherApp := testutil.CreateApp(t, s, other.ID, "busy")
+	otherVer := testutil.CreateVersion(t, s, otherApp.ID)
+	testutil.CreateRun(t, s, other.ID, otherApp.ID, otherEnv.ID, otherVer.ID, 0, 0)
+
+	// Runs 1-10 failed and fall outside the 50-run window. In 11-60, every
+	// run ending in 0 is cancelled and every run ending in 5 failed, leaving
+	// 40 completed, 5 failed, 5 cancelled.
+	for i := 1; i <= 60; i++ {
+		run := testutil.CreateRun(t, s, team.ID, busy.ID, env.ID, v2.ID, 0, 0)
+		status := "completed"
+		switch {
+		case i <= 10, i%10 == 5:
+			status = "failed"
+		case i%10 == 0:
+			status = "cancelled"
+		}
+		mustExec(t, dbConn, `UPDATE runs SET status = ?, queued_at = ? WHERE id = ?`, status, int64(i)*1000, run.ID)
+	}
+
+	apps, err := s.ListAppsWithStats(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("list apps with stats: %v", err)
+	}
+	if len(apps) != 2 || apps[0].Slug != "busy" || apps[1].Slug != "idle" {
+		t.Fatalf("unexpected apps: %+v", apps)
+	}
+
+	st := apps[0].Stats
+	if st == nil {
+		t.Fatalf("expected stats for busy app")
+	}
+	if st.TotalRuns != 60 {
+		t.Fatalf("total runs = %d, want 60", st.TotalRuns)
+	}
+	if st.LatestVersionNo == nil || *st.LatestVersionNo != v2.VersionNo {
+		t.Fatalf("latest version = %v, want %d", st.LatestVersionNo, v2.VersionNo)
+	}
+	if st.LastRunStatus == nil || *st.LastRunStatus != "cancelled" {
+		t.Fatalf("last run status = %v, want cancelled", st.LastRunStatus)
+	}
+	if st.LastRunAt == nil || st.LastRunAt.UnixMilli() != 60000 {
+		t.Fatalf("last run at = %v, want 60000ms", st.LastRunAt)
+	}
+	if st.SuccessRate == nil || math.Abs(*st.SuccessRate-40.0/45.0) > 1e-9 {
+		t.Fatalf("success rate = %v, want %v", st.SuccessRate, 40.0/45.0)
+	}
+
+	idleStats := apps[1].Stats
+	if idleStats == nil {
+		t.Fatalf("expected stats for idle app")
+	}
+	if apps[1].ID != idle.ID || idleStats.TotalRuns != 0 || idleStats.LatestVersionNo != nil ||
+		idleStats.LastRunStatus != nil || idleStats.LastRunAt != nil || idleStats.SuccessRate != nil {
+		t.Fatalf("unexpected idle stats: %+v", idleStats)
+	}
+}
+
+func TestMergeDefaultInput(t *testing.T) {
+	defaults := map[string]any{
+		"region": "us",
+		"limits": map[string]any{"rows": 100.0, "depth": 2.0},
+		"tags":   []any{"a", "b"},
+		"dry":    true,
+	}
+
+	tests := []struct {
+		name  string
+		input map[string]any
+		want  map[string]any
+	}{
+		{
+			name:  "no input takes every default",
+			input: nil,
+			want:  defaults,
+		},
+		{
+			name:  "request value wins",
+			input: map[string]any{"region": "eu"},
+			want: map[string]any{
+				"region": "eu",
+				"limits": map[string]any{"rows": 100.0, "depth": 2.0},
+				"tags":   []any{"a", "b"},
+				"dry":    true,
+			},
+		},
+		{
+			name:  "nested maps merge recursively",
+			input: map[string]any{"limits": map[string]any{"rows": 5.0, "extra": 1.0}},
+			want: map[string]any{
+				"region": "us",
+				"limits": map[string]any{"rows": 5.0, "depth": 2.0, "extra": 1.0},
+				"tags":   []any{"a", "b"},
+				"dry":    true,
+			},
+		},
+		{
+			name:  "arrays are replaced",
+			input: map[string]any{"tags": []any{"c"}},
+			want: map[string]any{
+				"region": "us",
+				"limits": map[string]any{"rows": 100.0, "depth": 2.0},
+				"tags":   []any{"c"},
+				"dry":    true,
+			},
+		},
+		{
+			name:  "null removes a defaulted key",
+			input: map[string]any{"dry": nil, "limits": map[string]any{"depth": nil}, "other": nil},
+			want: map[string]any{
+				"region": "us",
+				"limits": map[string]any{"rows": 100.0},
+				"tags":   []any{"a", "b"},
+				"other":  nil,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := store.MergeDefaultInput(defaults, tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("MergeDefaultInput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := defaults["limits"].(map[string]any)["depth"]; !ok {
+		t.Fatal("MergeDefaultInput modified the defaults")
+	}
+	input := map[string]any{"x": 1.0}
+	if got := store.MergeDefaultInput(nil, input); !reflect.DeepEqual(got, input) {
+		t.Fatalf("MergeDefaultInput(nil, input) = %v, want input unchanged", got)
+	}
+}
+
+func TestSetAppDefaultInput(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-default-input")
+	app := testutil.CreateApp(t, s, team.ID, "defaults")
+
+	want := map[string]any{"region": "us", "limits": map[string]any{"rows": 100.0}}
+	if err := s.SetAppDefaultInput(ctx, app.ID, want); err != nil {
+		t.Fatalf("set default input: %v", err)
+	}
+	got, err := s.GetAppBySlug(ctx, team.ID, "defaults")
+	if err != nil {
+		t.Fatalf("get app: %v", err)
+	}
+	if !reflect.DeepEqual(got.DefaultInput, want) {
+		t.Fatalf("DefaultInput = %v, want %v", got.DefaultInput, want)
+	}
+
+	if err := s.SetAppDefaultInput(ctx, app.ID, nil); err != nil {
+		t.Fatalf("clear default input: %v", err)
+	}
+	got, err = s.GetAppBySlug(ctx, team.ID, "defaults")
+	if err != nil {
+		t.Fatalf("get app: %v", err)
+	}
+	if got.DefaultInput != nil {
+		t.Fatalf("DefaultInput = %v after clearing, want nil", got.DefaultInput)
+	}
+}
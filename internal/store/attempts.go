@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// AttemptEnvSnapshot is the environment minitower set for an attempt's
+// process. Env is nil when the runner sent none or it was dropped, and Note
+// then says why if known.
+type AttemptEnvSnapshot struct {
+	Env  map[string]string
+	Note *string
+}
+
+// ListRunAttempts returns a run's attempts, oldest first.
+func (s *Store) ListRunAttempts(ctx context.Context, runID int64) ([]*RunAttempt, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, exit_code, error_message, started_at, finished_at, created_at, updated_at, cancel_ack_at, started_never
+     FROM run_attempts
+     WHERE run_id = ?
+     ORDER BY attempt_no ASC`,
+		runID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*RunAttempt
+	for rows.Next() {
+		a, err := scanAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// SetAttemptEnvSnapshot records an attempt's environment snapshot. A nil
+// env with a note records why there is none.
+func (s *Store) SetAttemptEnvSnapshot(ctx context.Context, attemptID int64, env map[string]string, note *string) error {
+	var envJSON *string
+	if env != nil {
+		data, err := json.Marshal(env)
+		if err != nil {
+			return err
+		}
+		str := string(data)
+		envJSON = &str
+	}
+	_, err := s.exec(ctx,
+		`UPDATE run_attempts SET env_snapshot_json = ?, env_snapshot_note = ? WHERE id = ?`,
+		envJSON, note, attemptID,
+	)
+	return err
+}
+
+// ListAttemptEnvSnapshots returns the environment snapshots of a run's
+// attempts keyed by attempt ID. Attempts with neither a snapshot nor a note
+// are left out.
+func (s *Store) ListAttemptEnvSnapshots(ctx context.Context, runID int64) (map[int64]*AttemptEnvSnapshot, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, env_snapshot_json, env_snapshot_note
+     FROM run_attempts
+     WHERE run_id = ? AND (env_snapshot_json IS NOT NULL OR env_snapshot_note IS NOT NULL)`,
+		runID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := make(map[int64]*AttemptEnvSnapshot)
+	for rows.Next() {
+		var attemptID int64
+		var envJSON, note sql.NullString
+		if err := rows.Scan(&attemptID, &envJSON, &note); err != nil {
+			return nil, err
+		}
+		snapshot := &AttemptEnvSnapshot{}
+		if envJSON.Valid {
+			if err := json.Unmarshal([]byte(envJSON.String), &snapshot.Env); err != nil {
+				return nil, err
+			}
+		}
+		if note.Valid {
+			snapshot.Note = &note.String
+		}
+		snapshots[attemptID] = snapshot
+	}
+	return snapshots, rows.Err()
+}
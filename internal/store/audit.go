@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry records one change made through the team API.
+type AuditEntry struct {
+	ID     int64
+	TeamID int64
+	// TokenID is the team token that made the change.
+	TokenID      *int64
+	Action       string
+	ResourceType string
+	ResourceID   string
+	Details      map[string]any
+	CreatedAt    time.Time
+}
+
+// AuditFilter narrows ListAuditEntries. Zero values do not filter.
+type AuditFilter struct {
+	TokenID *int64
+	Action  string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+	Offset  int
+}
+
+// InsertAuditEntry appends e to its team's audit log. CreatedAt defaults to
+// now.
+func (s *Store) InsertAuditEntry(ctx context.Context, e *AuditEntry) error {
+	var detailsJSON *string
+	if len(e.Details) > 0 {
+		data, err := json.Marshal(e.Details)
+		if err != nil {
+			return err
+		}
+		d := string(data)
+		detailsJSON = &d
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	res, err := s.exec(ctx,
+		`INSERT INTO audit_log (team_id, token_id, action, resource_type, resource_id, details_json, created_at)
+     VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		e.TeamID, e.TokenID, e.Action, e.ResourceType, e.ResourceID, detailsJSON, e.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return err
+	}
+	e.ID, err = res.LastInsertId()
+	return err
+}
+
+// ListAuditEntries returns a team's audit entries, newest first.
+func (s *Store) ListAuditEntries(ctx context.Context, teamID int64, f AuditFilter) ([]*AuditEntry, error) {
+	query := `SELECT id, team_id, token_id, action, resource_type, resource_id, details_json, created_at
+	     FROM audit_log
+	     WHERE team_id = ?`
+	args := []any{teamID}
+	if f.TokenID != nil {
+		query += " AND token_id = ?"
+		args = append(args, *f.TokenID)
+	}
+	if f.Action != "" {
+		query += " AND action = ?"
+		args = append(args, f.Action)
+	}
+	if !f.Since.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		query += " AND created_at < ?"
+		args = append(args, f.Until.UnixMilli())
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, f.Limit, f.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*AuditEntry, 0)
+	for rows.Next() {
+		var e AuditEntry
+		var tokenID sql.NullInt64
+		var detailsJSON sql.NullString
+		var createdAt int64
+		if err := rows.Scan(&e.ID, &e.TeamID, &tokenID, &e.Action, &e.ResourceType, &e.ResourceID, &detailsJSON, &createdAt); err != nil {
+			return nil, err
+		}
+		if tokenID.Valid {
+			e.TokenID = &tokenID.Int64
+		}
+		if detailsJSON.Valid {
+			if err := json.Unmarshal([]byte(detailsJSON.String), &e.Details); err != nil {
+				return nil, err
+			}
+		}
+		e.CreatedAt = time.UnixMilli(createdAt)
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// PurgeAuditLog deletes audit entries created before cutoff, batchSize rows
+// per statement. It returns the number of rows deleted.
+func (s *Store) PurgeAuditLog(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	var total int64
+	for {
+		res, err := s.exec(ctx,
+			`DELETE FROM audit_log
+		     WHERE id IN (SELECT id FROM audit_log WHERE created_at < ? LIMIT ?)`,
+			cutoff.UnixMilli(), batchSize,
+		)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
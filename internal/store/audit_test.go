@@ -0,0 +1,68 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestAuditEntriesFilterAndPurge(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-audit")
+	other, _ := testutil.CreateTeam(t, s, "team-audit-other")
+
+	now := time.Now()
+	tokenA, tokenB := int64(1), int64(2)
+	seed := []*store.AuditEntry{
+		{TeamID: team.ID, TokenID: &tokenA, Action: "app.create", ResourceType: "app", ResourceID: "a", CreatedAt: now.Add(-40 * 24 * time.Hour)},
+		{TeamID: team.ID, TokenID: &tokenA, Action: "run.create", ResourceType: "run", ResourceID: "1", Details: map[string]any{"app": "a"}, CreatedAt: now.Add(-2 * time.Hour)},
+		{TeamID: team.ID, TokenID: &tokenB, Action: "run.cancel", ResourceType: "run", ResourceID: "1", CreatedAt: now.Add(-time.Hour)},
+		{TeamID: other.ID, TokenID: &tokenB, Action: "run.create", ResourceType: "run", ResourceID: "2", CreatedAt: now.Add(-time.Hour)},
+	}
+	for _, e := range seed {
+		if err := s.InsertAuditEntry(ctx, e); err != nil {
+			t.Fatalf("insert audit entry: %v", err)
+		}
+	}
+
+	list := func(f store.AuditFilter) []*store.AuditEntry {
+		t.Helper()
+		f.Limit = 100
+		entries, err := s.ListAuditEntries(ctx, team.ID, f)
+		if err != nil {
+			t.Fatalf("list audit entries: %v", err)
+		}
+		return entries
+	}
+
+	all := list(store.AuditFilter{})
+	if len(all) != 3 || all[0].Action != "run.cancel" || all[2].Action != "app.create" {
+		t.Fatalf("expected the team's 3 entries newest first, got %+v", all)
+	}
+	if all[1].Details["app"] != "a" || all[1].TokenID == nil || *all[1].TokenID != tokenA {
+		t.Fatalf("unexpected run.create entry: %+v", all[1])
+	}
+	if got := list(store.AuditFilter{TokenID: &tokenB}); len(got) != 1 || got[0].Action != "run.cancel" {
+		t.Fatalf("token filter: %+v", got)
+	}
+	if got := list(store.AuditFilter{Action: "run.create"}); len(got) != 1 || got[0].ResourceID != "1" {
+		t.Fatalf("action filter: %+v", got)
+	}
+	if got := list(store.AuditFilter{Since: now.Add(-3 * time.Hour), Until: now.Add(-90 * time.Minute)}); len(got) != 1 || got[0].Action != "run.create" {
+		t.Fatalf("time range filter: %+v", got)
+	}
+
+	purged, err := s.PurgeAuditLog(ctx, now.Add(-30*24*time.Hour), 10)
+	if err != nil || purged != 1 {
+		t.Fatalf("expected 1 entry purged, got %d (%v)", purged, err)
+	}
+	if got := list(store.AuditFilter{}); len(got) != 2 {
+		t.Fatalf("expected 2 entries after purge, got %d", len(got))
+	}
+}
@@ -0,0 +1,321 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"minitower/internal/validate"
+)
+
+// MaxBatchRuns caps how many runs one batch may create.
+const MaxBatchRuns = 500
+
+// RunBatchSpec describes a batch of runs sharing an app, version, priority and
+// retry budget. Each input becomes one run.
+type RunBatchSpec struct {
+	TeamID        int64
+	AppID         int64
+	EnvironmentID int64
+	Version       *AppVersion
+	Inputs        []map[string]any
+	Priority      int
+	MaxRetries    int
+	AtMostOnce    bool
+}
+
+// RunBatch is the result of CreateRunBatch.
+type RunBatch struct {
+	BatchID string
+	RunIDs  []int64
+	RunNos  []int64
+}
+
+// BatchItemError describes why one input of a batch was rejected.
+type BatchItemError struct {
+	Index   int
+	Message string
+}
+
+// BatchInputError is returned by CreateRunBatch when any input is rejected.
+// No runs are created.
+type BatchInputError struct {
+	Items []BatchItemError
+}
+
+func (e *BatchInputError) Error() string {
+	return fmt.Sprintf("%d of the batch inputs are invalid", len(e.Items))
+}
+
+// BatchSummary aggregates the status of a batch's runs.
+type BatchSummary struct {
+	BatchID   string
+	AppID     int64
+	AppSlug   string
+	Total     int64
+	Terminal  int64
+	Counts    map[string]int64
+	CreatedAt time.Time
+}
+
+// BatchCancelResult counts the runs a batch cancel changed.
+type BatchCancelResult struct {
+	Cancelled  int64 // Queued runs moved straight to cancelled.
+	Cancelling int64 // Leased or running runs asked to stop.
+}
+
+// newBatchID returns a random 16-character hex batch ID.
+func newBatchID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// CreateRunBatch validates every input against the version's params schema and
+// creates one queued run per input in a single transaction. If any input is
+// rejected it returns a *BatchInputError listing each rejected input and
+// creates nothing; when the runs would take the team past its
+// max_active_runs it returns a *QuotaExceededError.
+func (s *Store) CreateRunBatch(ctx context.Context, spec RunBatchSpec) (*RunBatch, error) {
+	if len(spec.Inputs) == 0 || len(spec.Inputs) > MaxBatchRuns {
+		return nil, fmt.Errorf("batch must contain 1 to %d inputs", MaxBatchRuns)
+	}
+
+	inputs := make([]storedInput, len(spec.Inputs))
+	var invalid []BatchItemError
+	for i, input := range spec.Inputs {
+		if spec.Version.ParamsSchema != nil {
+			if err := validate.ValidateJSONInput(input, spec.Version.ParamsSchema); err != nil {
+				invalid = append(invalid, BatchItemError{Index: i, Message: err.Error()})
+				continue
+			}
+		}
+		stored, err := s.encodeInput(input)
+		if err != nil {
+			invalid = append(invalid, BatchItemError{Index: i, Message: err.Error()})
+			continue
+		}
+		inputs[i] = stored
+	}
+	if len(invalid) > 0 {
+		return nil, &BatchInputError{Items: invalid}
+	}
+
+	batchID, err := newBatchID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+
+	for attempt := 1; ; attempt++ {
+		batch, err := s.insertRunBatch(ctx, spec, inputs, batchID, now)
+		if err == nil {
+			return batch, nil
+		}
+		if !isUniqueViolation(err) || attempt == maxRunNoAttempts {
+			return nil, err
+		}
+	}
+}
+
+// insertRunBatch inserts the batch's runs in one transaction, numbered after
+// the app's latest run, with inputs[i] as the stored input of the i-th. Like
+// insertRun, it fails the (app_id, run_no) unique index when another writer
+// takes those numbers first.
+func (s *Store) insertRunBatch(ctx context.Context, spec RunBatchSpec, inputs []storedInput, batchID string, now int64) (*RunBatch, error) {
+	var batch *RunBatch
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		if _, err := reserveActiveRuns(ctx, tx, spec.TeamID, len(inputs)); err != nil {
+			return err
+		}
+
+		var lastRunNo int64
+		if err := tx.QueryRowContext(ctx,
+			`SELECT COALESCE(MAX(run_no), 0) FROM runs WHERE app_id = ?`,
+			spec.AppID,
+		).Scan(&lastRunNo); err != nil {
+			return err
+		}
+
+		stmt, err := tx.PrepareContext(ctx,
+			`INSERT INTO runs (team_id, app_id, environment_id, app_version_id, run_no, input_json, input_blob, input_keys_json, status, priority, max_retries, retry_count, cancel_requested, at_most_once, run_trace_id, batch_id, queued_at, created_at, updated_at)
+     VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'queued', ?, ?, 0, 0, ?, ?, ?, ?, ?, ?)`,
+		)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		batch = &RunBatch{
+			BatchID: batchID,
+			RunIDs:  make([]int64, 0, len(inputs)),
+			RunNos:  make([]int64, 0, len(inputs)),
+		}
+		for i, input := range inputs {
+			runNo := lastRunNo + int64(i) + 1
+			traceID, err := newTraceID()
+			if err != nil {
+				return err
+			}
+			result, err := stmt.ExecContext(ctx,
+				spec.TeamID, spec.AppID, spec.EnvironmentID, spec.Version.ID, runNo, input.json, input.blob, input.keys,
+				spec.Priority, spec.MaxRetries, spec.AtMostOnce, traceID, batchID, now, now, now,
+			)
+			if err != nil {
+				return err
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			if err := appendRunEvent(ctx, tx, id, RunEventQueued, map[string]any{"priority": spec.Priority, "batch_id": batchID}, now); err != nil {
+				return err
+			}
+			batch.RunIDs = append(batch.RunIDs, id)
+			batch.RunNos = append(batch.RunNos, runNo)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// GetBatchSummary returns status counts for a batch, or nil, nil if the team
+// has no runs with that batch ID.
+func (s *Store) GetBatchSummary(ctx context.Context, teamID int64, batchID string) (*BatchSummary, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT r.status, COUNT(*), MIN(r.created_at), MIN(r.app_id), MIN(a.slug)
+     FROM runs r
+     JOIN apps a ON r.app_id = a.id
+     WHERE r.team_id = ? AND r.batch_id = ?
+     GROUP BY r.status`,
+		teamID, batchID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sum := &BatchSummary{BatchID: batchID, Counts: make(map[string]int64)}
+	var createdAt int64
+	for rows.Next() {
+		var status string
+		var n, created int64
+		if err := rows.Scan(&status, &n, &created, &sum.AppID, &sum.AppSlug); err != nil {
+			return nil, err
+		}
+		sum.Counts[status] = n
+		sum.Total += n
+		switch status {
+		case "completed", "failed", "cancelled", "dead":
+			sum.Terminal += n
+		}
+		if createdAt == 0 || created < createdAt {
+			createdAt = created
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if sum.Total == 0 {
+		return nil, nil
+	}
+	sum.CreatedAt = time.UnixMilli(createdAt)
+	return sum, nil
+}
+
+// CancelBatch requests cancellation of every non-terminal run in a batch with
+// the same semantics as CancelRun: queued runs are cancelled outright, leased
+// and running ones move to cancelling. Returns nil, nil if the team has no
+// runs with that batch ID.
+func (s *Store) CancelBatch(ctx context.Context, teamID int64, batchID string) (*BatchCancelResult, error) {
+	now := time.Now().UnixMilli()
+
+	var total int64
+	var res BatchCancelResult
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM runs WHERE team_id = ? AND batch_id = ?`,
+			teamID, batchID,
+		).Scan(&total); err != nil {
+			return err
+		}
+		if total == 0 {
+			return nil
+		}
+
+		// Record the timeline first, while the statuses still show what each
+		// run is cancelled from.
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO run_events (run_id, kind, details_json, created_at)
+     SELECT id, '`+RunEventCancelRequested+`', json_object('previous_status', status), ?
+     FROM runs
+     WHERE team_id = ? AND batch_id = ? AND status IN ('queued','leased','running')
+       AND (SELECT COUNT(*) FROM run_events e WHERE e.run_id = runs.id) < ?
+     ORDER BY id`,
+			now, teamID, batchID, maxRunEvents,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO run_events (run_id, kind, details_json, created_at)
+     SELECT id, '`+RunEventFinished+`', json_object('status', 'cancelled'), ?
+     FROM runs
+     WHERE team_id = ? AND batch_id = ? AND status = 'queued'
+     ORDER BY id`,
+			now, teamID, batchID,
+		); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx,
+			`UPDATE runs SET status = 'cancelled', cancel_requested = 1, finished_at = ?, updated_at = ?
+     WHERE team_id = ? AND batch_id = ? AND status = 'queued'`,
+			now, now, teamID, batchID,
+		)
+		if err != nil {
+			return err
+		}
+		if res.Cancelled, err = result.RowsAffected(); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE run_attempts SET status = 'cancelling', updated_at = ?
+     WHERE status IN ('leased','running')
+       AND run_id IN (SELECT id FROM runs WHERE team_id = ? AND batch_id = ? AND status IN ('leased','running'))`,
+			now, teamID, batchID,
+		); err != nil {
+			return err
+		}
+
+		result, err = tx.ExecContext(ctx,
+			`UPDATE runs SET status = 'cancelling', cancel_requested = 1, updated_at = ?
+     WHERE team_id = ? AND batch_id = ? AND status IN ('leased','running')`,
+			now, teamID, batchID,
+		)
+		if err != nil {
+			return err
+		}
+		if res.Cancelling, err = result.RowsAffected(); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, nil
+	}
+	return &res, nil
+}
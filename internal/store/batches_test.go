@@ -0,0 +1,168 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestCreateRunBatchRejectsWholeBatchOnInvalidInput(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-batch-invalid")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "batch-invalid-app")
+	schema := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"day": map[string]any{"type": "string"}},
+		"required":   []any{"day"},
+	}
+	version, err := s.CreateVersion(ctx, app.ID, "objects/batch.tar.gz", "sha256", 0, "main.py", nil, schema, nil, nil, nil, 1, false, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+
+	_, err = s.CreateRunBatch(ctx, store.RunBatchSpec{
+		TeamID:        team.ID,
+		AppID:         app.ID,
+		EnvironmentID: env.ID,
+		Version:       version,
+		Inputs: []map[string]any{
+			{"day": "2026-01-01"},
+			{"day": 20260102},
+			{"day": "2026-01-03"},
+			{},
+		},
+	})
+	var inputErr *store.BatchInputError
+	if !errors.As(err, &inputErr) {
+		t.Fatalf("expected BatchInputError, got %v", err)
+	}
+	if len(inputErr.Items) != 2 || inputErr.Items[0].Index != 1 || inputErr.Items[1].Index != 3 {
+		t.Fatalf("expected inputs 1 and 3 rejected, got %+v", inputErr.Items)
+	}
+
+	var n int
+	if err := dbConn.QueryRow(`SELECT COUNT(*) FROM runs WHERE app_id = ?`, app.ID).Scan(&n); err != nil {
+		t.Fatalf("count runs: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("expected no runs created, got %d", n)
+	}
+
+	// A valid batch after the rejected one numbers its runs from 1.
+	batch, err := s.CreateRunBatch(ctx, store.RunBatchSpec{
+		TeamID:        team.ID,
+		AppID:         app.ID,
+		EnvironmentID: env.ID,
+		Version:       version,
+		Inputs:        []map[string]any{{"day": "2026-01-01"}, {"day": "2026-01-02"}},
+		Priority:      5,
+	})
+	if err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	if len(batch.RunIDs) != 2 || batch.RunNos[0] != 1 || batch.RunNos[1] != 2 {
+		t.Fatalf("unexpected batch %+v", batch)
+	}
+	run, err := s.GetRunByID(ctx, team.ID, batch.RunIDs[1])
+	if err != nil || run == nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if run.BatchID != batch.BatchID || run.Priority != 5 || run.Input["day"] != "2026-01-02" {
+		t.Fatalf("unexpected batch member %+v", run)
+	}
+}
+
+func TestBatchSummaryAndCancel(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-batch-summary")
+	other, _ := testutil.CreateTeam(t, s, "team-batch-other")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "batch-summary-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	runner, _ := testutil.CreateRunner(t, s, "runner-batch", "default")
+
+	// A run outside the batch must not be counted or cancelled.
+	loose := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	inputs := make([]map[string]any, 4)
+	for i := range inputs {
+		inputs[i] = map[string]any{"n": i}
+	}
+	batch, err := s.CreateRunBatch(ctx, store.RunBatchSpec{
+		TeamID:        team.ID,
+		AppID:         app.ID,
+		EnvironmentID: env.ID,
+		Version:       version,
+		Inputs:        inputs,
+	})
+	if err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	if batch.RunNos[0] != loose.RunNo+1 {
+		t.Fatalf("expected batch to continue run numbering after %d, got %v", loose.RunNo, batch.RunNos)
+	}
+	mustExec(t, dbConn, `UPDATE runs SET status = 'completed' WHERE id = ?`, loose.ID)
+	mustExec(t, dbConn, `UPDATE runs SET status = 'completed' WHERE id = ?`, batch.RunIDs[0])
+	mustExec(t, dbConn, `UPDATE runs SET status = 'failed' WHERE id = ?`, batch.RunIDs[1])
+	leased, attempt, _, _ := testutil.LeaseRun(t, s, runner)
+	if leased.ID != batch.RunIDs[2] {
+		t.Fatalf("expected to lease run %d, got %d", batch.RunIDs[2], leased.ID)
+	}
+
+	sum, err := s.GetBatchSummary(ctx, team.ID, batch.BatchID)
+	if err != nil {
+		t.Fatalf("get summary: %v", err)
+	}
+	if sum.Total != 4 || sum.Terminal != 2 || sum.AppSlug != app.Slug {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+	if sum.Counts["completed"] != 1 || sum.Counts["failed"] != 1 || sum.Counts["leased"] != 1 || sum.Counts["queued"] != 1 {
+		t.Fatalf("unexpected counts %v", sum.Counts)
+	}
+
+	if sum, err := s.GetBatchSummary(ctx, other.ID, batch.BatchID); err != nil || sum != nil {
+		t.Fatalf("expected batch hidden from other team, got %+v (%v)", sum, err)
+	}
+	if res, err := s.CancelBatch(ctx, other.ID, batch.BatchID); err != nil || res != nil {
+		t.Fatalf("expected other team cancel to find nothing, got %+v (%v)", res, err)
+	}
+
+	res, err := s.CancelBatch(ctx, team.ID, batch.BatchID)
+	if err != nil {
+		t.Fatalf("cancel batch: %v", err)
+	}
+	if res.Cancelled != 1 || res.Cancelling != 1 {
+		t.Fatalf("expected 1 cancelled and 1 cancelling, got %+v", res)
+	}
+	if status := getAttemptStatus(t, dbConn, attempt.ID); status != "cancelling" {
+		t.Fatalf("expected leased attempt to be cancelling, got %s", status)
+	}
+
+	sum, err = s.GetBatchSummary(ctx, team.ID, batch.BatchID)
+	if err != nil {
+		t.Fatalf("get summary: %v", err)
+	}
+	if sum.Terminal != 3 || sum.Counts["cancelled"] != 1 || sum.Counts["cancelling"] != 1 {
+		t.Fatalf("unexpected summary after cancel %+v", sum)
+	}
+	run, err := s.GetRunByID(ctx, team.ID, loose.ID)
+	if err != nil || run.Status != "completed" {
+		t.Fatalf("expected run outside the batch untouched, got %+v (%v)", run, err)
+	}
+}
@@ -0,0 +1,125 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"minitower/internal/auth"
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+const (
+	benchRuns       = 100_000
+	benchQueuedEach = 10 // one run in benchQueuedEach stays queued
+)
+
+// BenchmarkLeaseRunLargeQueue measures LeaseRun against a history of 100k
+// runs, with and without the hot-path indexes from migration 0007:
+//
+//	go test ./internal/store -run '^$' -bench LeaseRunLargeQueue
+//
+// minitowerd never runs ANALYZE, so neither does the benchmark; the planner
+// works from the schema alone, as it does in production.
+func BenchmarkLeaseRunLargeQueue(b *testing.B) {
+	b.Run("indexed", func(b *testing.B) {
+		benchmarkLeaseRun(b, false)
+	})
+	b.Run("without_0007_indexes", func(b *testing.B) {
+		benchmarkLeaseRun(b, true)
+	})
+}
+
+func benchmarkLeaseRun(b *testing.B, dropIndexes bool) {
+	s, dbConn, cleanup := testutil.NewTestDB(b)
+	defer cleanup.Close(b)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(b, s, "team-bench")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		b.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(b, s, team.ID, "app-bench")
+	version := testutil.CreateVersion(b, s, app.ID)
+	runner, _ := testutil.CreateRunner(b, s, "runner-bench", "default")
+
+	seedBenchRuns(b, dbConn, team.ID, app.ID, env.ID, version.ID)
+	if dropIndexes {
+		for _, idx := range []string{"runs_status_queue_idx", "runs_team_queued_idx", "run_attempts_runner_status_idx"} {
+			if _, err := dbConn.ExecContext(ctx, `DROP INDEX `+idx); err != nil {
+				b.Fatalf("drop index %s: %v", idx, err)
+			}
+		}
+	}
+
+	_, leaseHash, err := auth.GenerateToken()
+	if err != nil {
+		b.Fatalf("generate token: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		run, attempt, err := s.LeaseRun(ctx, runner, leaseHash, time.Minute)
+		if err != nil {
+			b.Fatalf("lease run: %v", err)
+		}
+
+		// Put the run back so every iteration sees the same queue.
+		b.StopTimer()
+		requeueBenchRun(b, dbConn, run, attempt)
+		b.StartTimer()
+	}
+}
+
+func seedBenchRuns(b *testing.B, dbConn *sql.DB, teamID, appID, envID, versionID int64) {
+	b.Helper()
+	ctx := context.Background()
+
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		b.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx,
+		`INSERT INTO runs (team_id, app_id, environment_id, app_version_id, run_no, status, priority, queued_at, finished_at, created_at, updated_at)
+	     VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		b.Fatalf("prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	base := time.Now().Add(-30 * 24 * time.Hour).UnixMilli()
+	for i := 0; i < benchRuns; i++ {
+		queuedAt := base + int64(i)*1000
+		status := "completed"
+		var finishedAt any = queuedAt + 500
+		switch {
+		case i%benchQueuedEach == 0:
+			status, finishedAt = "queued", nil
+		case i%7 == 0:
+			status = "failed"
+		}
+		if _, err := stmt.ExecContext(ctx, teamID, appID, envID, versionID, i+1, status, i%5, queuedAt, finishedAt, queuedAt, queuedAt); err != nil {
+			b.Fatalf("insert run: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		b.Fatalf("commit: %v", err)
+	}
+}
+
+func requeueBenchRun(b *testing.B, dbConn *sql.DB, run *store.Run, attempt *store.RunAttempt) {
+	b.Helper()
+	ctx := context.Background()
+	if _, err := dbConn.ExecContext(ctx, `DELETE FROM run_attempts WHERE id = ?`, attempt.ID); err != nil {
+		b.Fatalf("delete attempt: %v", err)
+	}
+	if _, err := dbConn.ExecContext(ctx, `UPDATE runs SET status = 'queued', retry_count = 0 WHERE id = ?`, run.ID); err != nil {
+		b.Fatalf("requeue run: %v", err)
+	}
+}
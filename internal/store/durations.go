@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// durationBaselineWindow is the number of most recent completed attempts an
+// app's duration baseline is computed over.
+const durationBaselineWindow = 100
+
+// DurationBaselineMinSamples is the fewest completed attempts an app needs
+// before its baseline is used; below it the p95 is too noisy to alert on.
+const DurationBaselineMinSamples = 10
+
+// DurationBaseline is the execution-time p95 of an app's most recent
+// completed attempts, measured from the attempt's start to its result.
+type DurationBaseline struct {
+	P95     time.Duration
+	Samples int
+}
+
+// DurationBaselines returns the baselines of the given apps, keyed by app
+// ID. Apps with fewer than DurationBaselineMinSamples completed attempts
+// are left out.
+func (s *Store) DurationBaselines(ctx context.Context, appIDs []int64) (map[int64]DurationBaseline, error) {
+	if len(appIDs) == 0 {
+		return map[int64]DurationBaseline{}, nil
+	}
+	args := make([]any, 0, len(appIDs)+1)
+	for _, id := range appIDs {
+		args = append(args, id)
+	}
+	args = append(args, durationBaselineWindow)
+	return s.durationBaselines(ctx,
+		`r.app_id IN (?`+strings.Repeat(", ?", len(appIDs)-1)+`)`, args...)
+}
+
+// TeamDurationBaselines returns the baselines of every app of a team with
+// enough completed attempts, keyed by app ID.
+func (s *Store) TeamDurationBaselines(ctx context.Context, teamID int64) (map[int64]DurationBaseline, error) {
+	return s.durationBaselines(ctx, `r.team_id = ?`, teamID, durationBaselineWindow)
+}
+
+func (s *Store) durationBaselines(ctx context.Context, filter string, args ...any) (map[int64]DurationBaseline, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT app_id, duration_ms FROM (
+       SELECT r.app_id, ra.finished_at - ra.started_at AS duration_ms,
+              ROW_NUMBER() OVER (PARTITION BY r.app_id ORDER BY ra.finished_at DESC) AS rn
+       FROM run_attempts ra JOIN runs r ON r.id = ra.run_id
+       WHERE `+filter+` AND ra.status = 'completed'
+         AND ra.started_at IS NOT NULL AND ra.finished_at IS NOT NULL
+     ) WHERE rn <= ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	durations := make(map[int64][]int64)
+	for rows.Next() {
+		var appID, ms int64
+		if err := rows.Scan(&appID, &ms); err != nil {
+			return nil, err
+		}
+		durations[appID] = append(durations[appID], ms)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	baselines := make(map[int64]DurationBaseline, len(durations))
+	for appID, ms := range durations {
+		if len(ms) < DurationBaselineMinSamples {
+			continue
+		}
+		sort.Slice(ms, func(i, j int) bool { return ms[i] < ms[j] })
+		// Nearest-rank percentile.
+		rank := int(math.Ceil(0.95*float64(len(ms)))) - 1
+		baselines[appID] = DurationBaseline{P95: time.Duration(ms[rank]) * time.Millisecond, Samples: len(ms)}
+	}
+	return baselines, nil
+}
+
+// RunningAttempt is an attempt that has started and not yet finished.
+type RunningAttempt struct {
+	RunID     int64
+	AttemptID int64
+	TeamID    int64
+	AppID     int64
+	StartedAt time.Time
+}
+
+// ListRunningAttemptsNotFlaggedSlow returns running attempts whose run has
+// no RunEventDurationExceededP95 event yet.
+func (s *Store) ListRunningAttemptsNotFlaggedSlow(ctx context.Context) ([]RunningAttempt, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT r.id, ra.id, r.team_id, r.app_id, ra.started_at
+     FROM run_attempts ra JOIN runs r ON r.id = ra.run_id
+     WHERE ra.status = 'running' AND ra.started_at IS NOT NULL
+       AND NOT EXISTS (SELECT 1 FROM run_events e WHERE e.run_id = r.id AND e.kind = ?)
+     ORDER BY ra.id`,
+		RunEventDurationExceededP95,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []RunningAttempt
+	for rows.Next() {
+		var a RunningAttempt
+		var startedAt int64
+		if err := rows.Scan(&a.RunID, &a.AttemptID, &a.TeamID, &a.AppID, &startedAt); err != nil {
+			return nil, err
+		}
+		a.StartedAt = time.UnixMilli(startedAt)
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// FlagRunSlow records a RunEventDurationExceededP95 event for a run whose
+// attempt has been executing for elapsed, past the app's p95. A run is
+// flagged at most once; the result reports whether this call flagged it.
+func (s *Store) FlagRunSlow(ctx context.Context, runID, attemptID int64, elapsed, p95 time.Duration, now time.Time) (bool, error) {
+	details, err := json.Marshal(map[string]any{
+		"attempt_id":      attemptID,
+		"elapsed_seconds": elapsed.Seconds(),
+		"p95_seconds":     p95.Seconds(),
+	})
+	if err != nil {
+		return false, err
+	}
+	res, err := s.exec(ctx,
+		`INSERT INTO run_events (run_id, kind, details_json, created_at)
+     SELECT ?, ?, ?, ?
+     WHERE NOT EXISTS (SELECT 1 FROM run_events WHERE run_id = ? AND kind = ?)
+       AND (SELECT COUNT(*) FROM run_events WHERE run_id = ?) < ?`,
+		runID, RunEventDurationExceededP95, string(details), now.UnixMilli(),
+		runID, RunEventDurationExceededP95, runID, maxRunEvents,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
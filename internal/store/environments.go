@@ -7,6 +7,11 @@ import (
 	"time"
 )
 
+var (
+	ErrEnvironmentExists = errors.New("environment already exists")
+	ErrEnvironmentInUse  = errors.New("environment in use")
+)
+
 type Environment struct {
 	ID        int64
 	TeamID    int64
@@ -14,13 +19,21 @@ type Environment struct {
 	IsDefault bool
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	Counts    *EnvironmentCounts // Populated by ListEnvironmentsWithCounts.
+}
+
+// EnvironmentCounts holds the live queue and capacity of an environment.
+type EnvironmentCounts struct {
+	QueuedRuns    int64
+	RunningRuns   int64 // leased, running, or cancelling
+	OnlineRunners int64
 }
 
 // GetOrCreateDefaultEnvironment returns the default environment for a team, creating it if necessary.
 func (s *Store) GetOrCreateDefaultEnvironment(ctx context.Context, teamID int64) (*Environment, error) {
 	// Attempt insert; if it already exists the ON CONFLICT clause makes this a no-op.
 	now := time.Now().UnixMilli()
-	_, err := s.db.ExecContext(ctx,
+	_, err := s.exec(ctx,
 		`INSERT INTO environments (team_id, name, is_default, created_at, updated_at)
      VALUES (?, 'default', 1, ?, ?)
      ON CONFLICT(team_id, name) DO NOTHING`,
@@ -69,3 +82,138 @@ func (s *Store) GetEnvironmentByID(ctx context.Context, teamID int64, envID int6
 	e.UpdatedAt = time.UnixMilli(updatedAt)
 	return &e, nil
 }
+
+// GetEnvironmentByName returns an environment by name (scoped to team).
+func (s *Store) GetEnvironmentByName(ctx context.Context, teamID int64, name string) (*Environment, error) {
+	var e Environment
+	var createdAt, updatedAt int64
+	var isDefault int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, team_id, name, is_default, created_at, updated_at
+     FROM environments WHERE team_id = ? AND name = ?`,
+		teamID, name,
+	).Scan(&e.ID, &e.TeamID, &e.Name, &isDefault, &createdAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.IsDefault = isDefault == 1
+	e.CreatedAt = time.UnixMilli(createdAt)
+	e.UpdatedAt = time.UnixMilli(updatedAt)
+	return &e, nil
+}
+
+// CreateEnvironment creates a non-default environment. It returns
+// ErrEnvironmentExists if the team already has one with that name.
+func (s *Store) CreateEnvironment(ctx context.Context, teamID int64, name string) (*Environment, error) {
+	now := time.Now().UnixMilli()
+	result, err := s.exec(ctx,
+		`INSERT INTO environments (team_id, name, is_default, created_at, updated_at)
+     VALUES (?, ?, 0, ?, ?)
+     ON CONFLICT(team_id, name) DO NOTHING`,
+		teamID, name, now, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrEnvironmentExists
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &Environment{
+		ID:        id,
+		TeamID:    teamID,
+		Name:      name,
+		CreatedAt: time.UnixMilli(now),
+		UpdatedAt: time.UnixMilli(now),
+	}, nil
+}
+
+// ListEnvironmentsWithCounts returns the team's environments with Counts
+// populated, default first. Runners are not team-scoped and lease by
+// environment name, so OnlineRunners counts every online runner whose
+// environment label matches.
+func (s *Store) ListEnvironmentsWithCounts(ctx context.Context, teamID int64) ([]*Environment, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`WITH run_counts AS (
+       SELECT environment_id,
+              SUM(CASE WHEN status = 'queued' THEN 1 ELSE 0 END) AS queued,
+              SUM(CASE WHEN status IN ('leased', 'running', 'cancelling') THEN 1 ELSE 0 END) AS running
+       FROM runs
+       WHERE team_id = ? AND status IN ('queued', 'leased', 'running', 'cancelling')
+       GROUP BY environment_id
+     ),
+     runner_counts AS (
+       SELECT environment, COUNT(*) AS online
+       FROM runners WHERE status = 'online'
+       GROUP BY environment
+     )
+     SELECT e.id, e.team_id, e.name, e.is_default, e.created_at, e.updated_at,
+            COALESCE(rc.queued, 0), COALESCE(rc.running, 0), COALESCE(rn.online, 0)
+     FROM environments e
+     LEFT JOIN run_counts rc ON rc.environment_id = e.id
+     LEFT JOIN runner_counts rn ON rn.environment = e.name
+     WHERE e.team_id = ?
+     ORDER BY e.is_default DESC, e.name`,
+		teamID, teamID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var envs []*Environment
+	for rows.Next() {
+		var e Environment
+		var counts EnvironmentCounts
+		var createdAt, updatedAt int64
+		var isDefault int
+		if err := rows.Scan(&e.ID, &e.TeamID, &e.Name, &isDefault, &createdAt, &updatedAt,
+			&counts.QueuedRuns, &counts.RunningRuns, &counts.OnlineRunners); err != nil {
+			return nil, err
+		}
+		e.IsDefault = isDefault == 1
+		e.CreatedAt = time.UnixMilli(createdAt)
+		e.UpdatedAt = time.UnixMilli(updatedAt)
+		e.Counts = &counts
+		envs = append(envs, &e)
+	}
+	return envs, rows.Err()
+}
+
+// DeleteEnvironment deletes an environment. It returns ErrEnvironmentInUse
+// while any run (in any state) or any runner references it, since runs keep
+// a foreign key to their environment and runners would lose their queue.
+func (s *Store) DeleteEnvironment(ctx context.Context, teamID, envID int64) error {
+	return s.write(ctx, func(tx *sql.Tx) error {
+		var inUse int
+		err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM runs WHERE environment_id = ?)
+          OR EXISTS (SELECT 1 FROM runners r JOIN environments e ON r.environment = e.name WHERE e.id = ?)`,
+			envID, envID,
+		).Scan(&inUse)
+		if err != nil {
+			return err
+		}
+		if inUse == 1 {
+			return ErrEnvironmentInUse
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM environments WHERE id = ? AND team_id = ?`,
+			envID, teamID,
+		); err != nil {
+			return err
+		}
+		return nil
+	})
+}
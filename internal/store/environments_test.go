@@ -0,0 +1,138 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestListEnvironmentsWithCounts(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-env-counts")
+	def, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	gpu, err := s.CreateEnvironment(ctx, team.ID, "gpu")
+	if err != nil {
+		t.Fatalf("create env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "env-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+
+	statuses := map[int64][]string{
+		def.ID: {"queued", "queued", "leased", "running", "completed", "failed"},
+		gpu.ID: {"queued", "cancelling", "dead"},
+	}
+	for envID, list := range statuses {
+		for _, status := range list {
+			run := testutil.CreateRun(t, s, team.ID, app.ID, envID, version.ID, 0, 0)
+			mustExec(t, dbConn, `UPDATE runs SET status = ? WHERE id = ?`, status, run.ID)
+		}
+	}
+
+	// Another team's runs in its own default environment must not leak in.
+	other, _ := testutil.CreateTeam(t, s, "team-env-other")
+	otherEnv, err := s.GetOrCreateDefaultEnvironment(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("get other env: %v", err)
+	}
+	otherApp := testutil.CreateApp(t, s, other.ID, "env-app")
+	otherVer := testutil.CreateVersion(t, s, otherApp.ID)
+	testutil.CreateRun(t, s, other.ID, otherApp.ID, otherEnv.ID, otherVer.ID, 0, 0)
+
+	testutil.CreateRunner(t, s, "runner-default-1", "default")
+	testutil.CreateRunner(t, s, "runner-default-2", "default")
+	offline, _ := testutil.CreateRunner(t, s, "runner-gpu-offline", "gpu")
+	mustExec(t, dbConn, `UPDATE runners SET status = 'offline' WHERE id = ?`, offline.ID)
+	testutil.CreateRunner(t, s, "runner-gpu", "gpu")
+
+	envs, err := s.ListEnvironmentsWithCounts(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("list environments: %v", err)
+	}
+	if len(envs) != 2 || envs[0].Name != "default" || !envs[0].IsDefault || envs[1].Name != "gpu" {
+		t.Fatalf("unexpected environments: %+v", envs)
+	}
+
+	want := map[string]store.EnvironmentCounts{
+		"default": {QueuedRuns: 2, RunningRuns: 2, OnlineRunners: 2},
+		"gpu":     {QueuedRuns: 1, RunningRuns: 1, OnlineRunners: 1},
+	}
+	for _, env := range envs {
+		if *env.Counts != want[env.Name] {
+			t.Fatalf("%s counts: expected %+v, got %+v", env.Name, want[env.Name], *env.Counts)
+		}
+	}
+}
+
+func TestCreateEnvironmentRejectsDuplicate(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-env-dup")
+	if _, err := s.CreateEnvironment(ctx, team.ID, "staging"); err != nil {
+		t.Fatalf("create env: %v", err)
+	}
+	if _, err := s.CreateEnvironment(ctx, team.ID, "staging"); !errors.Is(err, store.ErrEnvironmentExists) {
+		t.Fatalf("expected ErrEnvironmentExists, got %v", err)
+	}
+
+	// Names are per team.
+	other, _ := testutil.CreateTeam(t, s, "team-env-dup-other")
+	if _, err := s.CreateEnvironment(ctx, other.ID, "staging"); err != nil {
+		t.Fatalf("create env for other team: %v", err)
+	}
+}
+
+func TestDeleteEnvironmentRefusesWhenReferenced(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-env-delete")
+	app := testutil.CreateApp(t, s, team.ID, "env-del-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+
+	withRun, err := s.CreateEnvironment(ctx, team.ID, "with-run")
+	if err != nil {
+		t.Fatalf("create env: %v", err)
+	}
+	run := testutil.CreateRun(t, s, team.ID, app.ID, withRun.ID, version.ID, 0, 0)
+	mustExec(t, dbConn, `UPDATE runs SET status = 'completed' WHERE id = ?`, run.ID)
+	if err := s.DeleteEnvironment(ctx, team.ID, withRun.ID); !errors.Is(err, store.ErrEnvironmentInUse) {
+		t.Fatalf("expected ErrEnvironmentInUse for env with a finished run, got %v", err)
+	}
+
+	withRunner, err := s.CreateEnvironment(ctx, team.ID, "with-runner")
+	if err != nil {
+		t.Fatalf("create env: %v", err)
+	}
+	runner, _ := testutil.CreateRunner(t, s, "runner-env-delete", "with-runner")
+	mustExec(t, dbConn, `UPDATE runners SET status = 'offline' WHERE id = ?`, runner.ID)
+	if err := s.DeleteEnvironment(ctx, team.ID, withRunner.ID); !errors.Is(err, store.ErrEnvironmentInUse) {
+		t.Fatalf("expected ErrEnvironmentInUse for env with a runner, got %v", err)
+	}
+
+	unused, err := s.CreateEnvironment(ctx, team.ID, "unused")
+	if err != nil {
+		t.Fatalf("create env: %v", err)
+	}
+	if err := s.DeleteEnvironment(ctx, team.ID, unused.ID); err != nil {
+		t.Fatalf("delete unused env: %v", err)
+	}
+	got, err := s.GetEnvironmentByName(ctx, team.ID, "unused")
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("expected environment to be deleted, got %+v", got)
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Run event kinds, in the order a run usually meets them.
+const (
+	RunEventQueued              = "queued"
+	RunEventNoRunnerAvailable   = "no_runner_available"
+	RunEventLeased              = "leased"
+	RunEventStarted             = "started"
+	RunEventHeartbeatLate       = "heartbeat_late"
+	RunEventDurationExceededP95 = "duration_exceeded_p95"
+	RunEventCancelRequested     = "cancel_requested"
+	RunEventAttemptExpired      = "attempt_expired"
+	RunEventRetried             = "retried"
+	RunEventRequeued            = "requeued"
+	RunEventFinished            = "finished"
+)
+
+// maxRunEvents caps the events kept per run. Later events are dropped so a
+// run that keeps expiring cannot grow its timeline without bound, but the
+// finished event is always kept so the timeline shows how the run ended.
+const maxRunEvents = 200
+
+// RunEvent is one entry in a run's timeline. Events are appended in the
+// same transaction as the transition they record and never change.
+type RunEvent struct {
+	ID        int64
+	RunID     int64
+	Kind      string
+	Details   map[string]any
+	CreatedAt time.Time
+}
+
+// appendRunEvent records a run event inside tx. details may be nil.
+func appendRunEvent(ctx context.Context, tx *sql.Tx, runID int64, kind string, details map[string]any, nowMs int64) error {
+	var detailsJSON *string
+	if len(details) > 0 {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		s := string(data)
+		detailsJSON = &s
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO run_events (run_id, kind, details_json, created_at)
+     SELECT ?, ?, ?, ?
+     WHERE ? = '`+RunEventFinished+`' OR (SELECT COUNT(*) FROM run_events WHERE run_id = ?) < ?`,
+		runID, kind, detailsJSON, nowMs, kind, runID, maxRunEvents,
+	)
+	return err
+}
+
+// ListRunEvents returns a run's events, oldest first.
+func (s *Store) ListRunEvents(ctx context.Context, runID int64) ([]*RunEvent, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, run_id, kind, details_json, created_at
+     FROM run_events
+     WHERE run_id = ?
+     ORDER BY id ASC`,
+		runID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*RunEvent
+	for rows.Next() {
+		var e RunEvent
+		var detailsJSON sql.NullString
+		var createdAt int64
+		if err := rows.Scan(&e.ID, &e.RunID, &e.Kind, &detailsJSON, &createdAt); err != nil {
+			return nil, err
+		}
+		if detailsJSON.Valid {
+			if err := json.Unmarshal([]byte(detailsJSON.String), &e.Details); err != nil {
+				return nil, err
+			}
+		}
+		e.CreatedAt = time.UnixMilli(createdAt)
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
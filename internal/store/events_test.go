@@ -0,0 +1,182 @@
+package store_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func eventKinds(t *testing.T, s *store.Store, runID int64) ([]*store.RunEvent, string) {
+	t.Helper()
+	events, err := s.ListRunEvents(context.Background(), runID)
+	if err != nil {
+		t.Fatalf("list run events: %v", err)
+	}
+	kinds := make([]string, len(events))
+	for i, e := range events {
+		kinds[i] = e.Kind
+	}
+	return events, strings.Join(kinds, ",")
+}
+
+func TestRunEventsFollowTheLifecycle(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-events")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "events-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 3, 1)
+	runner, _ := testutil.CreateRunner(t, s, "runner-events", "default")
+
+	// First attempt: leased, started, a late heartbeat, then the lease expires.
+	_, attempt, _, leaseHash := testutil.LeaseRun(t, s, runner)
+	if _, err := s.StartAttempt(ctx, attempt.ID, leaseHash); err != nil {
+		t.Fatalf("start attempt: %v", err)
+	}
+	if _, err := s.ExtendLease(ctx, attempt.ID, leaseHash, time.Minute); err != nil {
+		t.Fatalf("extend lease: %v", err)
+	}
+	mustExec(t, dbConn, `UPDATE run_attempts SET updated_at = ? WHERE id = ?`, time.Now().Add(-45*time.Second).UnixMilli(), attempt.ID)
+	if _, err := s.ExtendLease(ctx, attempt.ID, leaseHash, time.Minute); err != nil {
+		t.Fatalf("extend lease: %v", err)
+	}
+	mustExec(t, dbConn, `UPDATE run_attempts SET lease_expires_at = ? WHERE id = ?`, time.Now().Add(-time.Second).UnixMilli(), attempt.ID)
+	if _, err := s.ReapExpiredAttempts(ctx, time.Now(), 10); err != nil {
+		t.Fatalf("reap: %v", err)
+	}
+
+	// Second attempt completes.
+	_, attempt, _, leaseHash = testutil.LeaseRun(t, s, runner)
+	exitCode := 0
+	if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, "completed", &exitCode, nil, ""); err != nil {
+		t.Fatalf("complete attempt: %v", err)
+	}
+
+	events, kinds := eventKinds(t, s, run.ID)
+	if kinds != "queued,leased,started,heartbeat_late,attempt_expired,retried,leased,finished" {
+		t.Fatalf("unexpected timeline: %s", kinds)
+	}
+	if events[0].Details["priority"] != float64(3) {
+		t.Errorf("queued details: %v", events[0].Details)
+	}
+	if events[1].Details["runner"] != "runner-events" || events[1].Details["attempt_no"] != float64(1) {
+		t.Errorf("leased details: %v", events[1].Details)
+	}
+	if gap, _ := events[3].Details["gap_ms"].(float64); gap < 30000 {
+		t.Errorf("heartbeat_late details: %v", events[3].Details)
+	}
+	if events[4].Details["attempt_status"] != "running" || events[5].Details["retry_count"] != float64(1) {
+		t.Errorf("expiry details: %v, %v", events[4].Details, events[5].Details)
+	}
+	if events[7].Details["status"] != "completed" || events[7].Details["exit_code"] != float64(0) || events[7].Details["attempt_no"] != float64(2) {
+		t.Errorf("finished details: %v", events[7].Details)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].CreatedAt.Before(events[i-1].CreatedAt) {
+			t.Fatalf("events out of order at %d", i)
+		}
+	}
+}
+
+func TestRunEventsRecordCancellation(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-events-cancel")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "events-cancel-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	runner, _ := testutil.CreateRunner(t, s, "runner-events-cancel", "default")
+
+	// A leased run moves to cancelling; asking again records nothing more,
+	// and the reaper ends it once the lease runs out.
+	leased := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	_, attempt, _, _ := testutil.LeaseRun(t, s, runner)
+	for range 2 {
+		if _, err := s.CancelRun(ctx, team.ID, leased.ID); err != nil {
+			t.Fatalf("cancel run: %v", err)
+		}
+	}
+	mustExec(t, dbConn, `UPDATE run_attempts SET lease_expires_at = ? WHERE id = ?`, time.Now().Add(-time.Second).UnixMilli(), attempt.ID)
+	if _, err := s.ReapExpiredAttempts(ctx, time.Now(), 10); err != nil {
+		t.Fatalf("reap: %v", err)
+	}
+	events, kinds := eventKinds(t, s, leased.ID)
+	if kinds != "queued,leased,cancel_requested,attempt_expired,finished" {
+		t.Fatalf("unexpected timeline: %s", kinds)
+	}
+	if events[2].Details["previous_status"] != "leased" || events[4].Details["status"] != "cancelled" {
+		t.Fatalf("unexpected details: %v, %v", events[2].Details, events[4].Details)
+	}
+
+	// A queued run is cancelled outright.
+	queued := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	if _, err := s.CancelRun(ctx, team.ID, queued.ID); err != nil {
+		t.Fatalf("cancel run: %v", err)
+	}
+	if _, kinds := eventKinds(t, s, queued.ID); kinds != "queued,cancel_requested,finished" {
+		t.Fatalf("unexpected timeline: %s", kinds)
+	}
+
+	// Batch runs record their batch, and a batch cancel records the same
+	// events as single cancels.
+	batch, err := s.CreateRunBatch(ctx, store.RunBatchSpec{
+		TeamID: team.ID, AppID: app.ID, EnvironmentID: env.ID, Version: version,
+		Inputs: []map[string]any{{}, {}},
+	})
+	if err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	if _, err := s.CancelBatch(ctx, team.ID, batch.BatchID); err != nil {
+		t.Fatalf("cancel batch: %v", err)
+	}
+	for _, id := range batch.RunIDs {
+		events, kinds := eventKinds(t, s, id)
+		if kinds != "queued,cancel_requested,finished" || events[0].Details["batch_id"] != batch.BatchID {
+			t.Fatalf("run %d: unexpected timeline %s (%v)", id, kinds, events[0].Details)
+		}
+	}
+}
+
+func TestRunEventsAreCappedButKeepFinished(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-events-cap")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "events-cap-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	for range 199 {
+		mustExec(t, dbConn, `INSERT INTO run_events (run_id, kind, created_at) VALUES (?, 'retried', ?)`, run.ID, time.Now().UnixMilli())
+	}
+	if _, err := s.CancelRun(ctx, team.ID, run.ID); err != nil {
+		t.Fatalf("cancel run: %v", err)
+	}
+	events, _ := eventKinds(t, s, run.ID)
+	if len(events) != 201 {
+		t.Fatalf("expected 201 events, got %d", len(events))
+	}
+	if events[199].Kind != "retried" || events[200].Kind != "finished" {
+		t.Fatalf("expected cancel_requested dropped and finished kept, got %s, %s", events[199].Kind, events[200].Kind)
+	}
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// purgeableAttempts selects attempts whose run has reached a terminal state.
+// Logs of queued, leased, running or cancelling runs are never purged, which
+// also protects earlier attempts of a run that is being retried.
+const purgeableAttempts = `SELECT a.id
+     FROM run_attempts a
+     JOIN runs r ON r.id = a.run_id
+     WHERE r.status IN ('completed', 'failed', 'cancelled', 'dead')`
+
+// ListLogPurgeCandidates returns up to limit attempt IDs of terminal runs that
+// finished before cutoff, still have log rows and have not been archived.
+func (s *Store) ListLogPurgeCandidates(ctx context.Context, cutoff time.Time, limit int) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx,
+		purgeableAttempts+`
+	       AND COALESCE(a.finished_at, a.updated_at) < ?
+	       AND a.logs_archive_key IS NULL
+	       AND EXISTS (SELECT 1 FROM run_logs l WHERE l.run_attempt_id = a.id)
+	     ORDER BY a.id ASC
+	     LIMIT ?`,
+		cutoff.UnixMilli(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetAttemptLogs returns every log line of an attempt in sequence order.
+func (s *Store) GetAttemptLogs(ctx context.Context, attemptID int64) ([]*RunLog, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, run_attempt_id, seq, stream, line, logged_at
+	     FROM run_logs
+	     WHERE run_attempt_id = ?
+	     ORDER BY seq ASC`,
+		attemptID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []*RunLog
+	for rows.Next() {
+		var l RunLog
+		var loggedAt int64
+		if err := rows.Scan(&l.ID, &l.RunAttemptID, &l.Seq, &l.Stream, &l.Line, &loggedAt); err != nil {
+			return nil, err
+		}
+		l.LoggedAt = time.UnixMilli(loggedAt)
+		logs = append(logs, &l)
+	}
+	return logs, rows.Err()
+}
+
+// SetAttemptLogArchive records the object key holding an attempt's archived logs.
+func (s *Store) SetAttemptLogArchive(ctx context.Context, attemptID int64, key string) error {
+	_, err := s.exec(ctx,
+		`UPDATE run_attempts SET logs_archive_key = ? WHERE id = ?`,
+		key, attemptID,
+	)
+	return err
+}
+
+// GetRunLogArchiveKey returns the archive key of the latest attempt of a run,
+// or "" if its logs have not been archived.
+func (s *Store) GetRunLogArchiveKey(ctx context.Context, runID int64) (string, error) {
+	var key sql.NullString
+	err := s.db.QueryRowContext(ctx,
+		`SELECT logs_archive_key
+	     FROM run_attempts
+	     WHERE run_id = ?
+	     ORDER BY attempt_no DESC
+	     LIMIT 1`,
+		runID,
+	).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return key.String, nil
+}
+
+// PurgeOldLogs deletes log rows of terminal runs whose attempt finished before
+// cutoff. Rows are deleted batchSize at a time, each batch in its own
+// statement, so the write lock is released between batches. It returns the
+// number of rows deleted.
+func (s *Store) PurgeOldLogs(ctx context.Context, cutoff time.Time, batchSize int) (int64, error) {
+	var total int64
+	for {
+		res, err := s.exec(ctx,
+			`DELETE FROM run_logs
+		     WHERE id IN (
+		       SELECT l.id
+		       FROM run_logs l
+		       WHERE l.run_attempt_id IN (`+purgeableAttempts+`
+		           AND COALESCE(a.finished_at, a.updated_at) < ?)
+		       LIMIT ?
+		     )`,
+			cutoff.UnixMilli(), batchSize,
+		)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// TrimAttemptLogs deletes all but the newest maxRows log rows of each attempt
+// of a terminal run, batchSize rows per statement. It returns the number of
+// rows deleted.
+func (s *Store) TrimAttemptLogs(ctx context.Context, maxRows, batchSize int) (int64, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT l.run_attempt_id
+	     FROM run_logs l
+	     WHERE l.run_attempt_id IN (`+purgeableAttempts+`)
+	     GROUP BY l.run_attempt_id
+	     HAVING COUNT(*) > ?`,
+		maxRows,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var attemptIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		attemptIDs = append(attemptIDs, id)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, attemptID := range attemptIDs {
+		// The oldest sequence number that survives the trim.
+		var keepFrom int64
+		err := s.db.QueryRowContext(ctx,
+			`SELECT seq FROM run_logs
+		     WHERE run_attempt_id = ?
+		     ORDER BY seq DESC
+		     LIMIT 1 OFFSET ?`,
+			attemptID, maxRows-1,
+		).Scan(&keepFrom)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return total, err
+		}
+
+		for {
+			res, err := s.exec(ctx,
+				`DELETE FROM run_logs
+			     WHERE id IN (
+			       SELECT id FROM run_logs
+			       WHERE run_attempt_id = ? AND seq < ?
+			       LIMIT ?
+			     )`,
+				attemptID, keepFrom, batchSize,
+			)
+			if err != nil {
+				return total, err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return total, err
+			}
+			total += n
+			if n < int64(batchSize) {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,172 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+// seedAttemptLogs leases the next queued run, finishes it with runStatus at
+// finishedAt and appends n log lines to its attempt.
+func seedAttemptLogs(t *testing.T, s *store.Store, dbConn *sql.DB, runner *store.Runner, runStatus string, finishedAt time.Time, n int) *store.RunAttempt {
+	t.Helper()
+
+	run, attempt, _, _ := testutil.LeaseRun(t, s, runner)
+	logs := make([]store.LogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		logs = append(logs, store.LogEntry{Seq: int64(i), Stream: "stdout", Line: fmt.Sprintf("line %d", i), LoggedAt: finishedAt})
+	}
+	if err := s.AppendLogs(context.Background(), attempt.ID, logs); err != nil {
+		t.Fatalf("append logs: %v", err)
+	}
+
+	attemptStatus := runStatus
+	if runStatus == "dead" || runStatus == "queued" {
+		attemptStatus = "expired"
+	}
+	if runStatus == "running" {
+		mustExec(t, dbConn, `UPDATE run_attempts SET status = 'running' WHERE id = ?`, attempt.ID)
+	} else {
+		mustExec(t, dbConn, `UPDATE run_attempts SET status = ?, finished_at = ? WHERE id = ?`, attemptStatus, finishedAt.UnixMilli(), attempt.ID)
+	}
+	mustExec(t, dbConn, `UPDATE runs SET status = ? WHERE id = ?`, runStatus, run.ID)
+	return attempt
+}
+
+func countAttemptLogs(t *testing.T, dbConn *sql.DB, attemptID int64) int {
+	t.Helper()
+	var n int
+	if err := dbConn.QueryRow(`SELECT COUNT(*) FROM run_logs WHERE run_attempt_id = ?`, attemptID).Scan(&n); err != nil {
+		t.Fatalf("count logs: %v", err)
+	}
+	return n
+}
+
+func TestPurgeOldLogsDeletesInBatches(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-purge")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "purge-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	runner, _ := testutil.CreateRunner(t, s, "runner-purge", "default")
+
+	now := time.Now()
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	oldCompleted := seedAttemptLogs(t, s, dbConn, runner, "completed", now.Add(-10*24*time.Hour), 25)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	oldDead := seedAttemptLogs(t, s, dbConn, runner, "dead", now.Add(-9*24*time.Hour), 3)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	recent := seedAttemptLogs(t, s, dbConn, runner, "failed", now.Add(-time.Hour), 4)
+
+	purged, err := s.PurgeOldLogs(ctx, now.Add(-7*24*time.Hour), 10)
+	if err != nil {
+		t.Fatalf("purge logs: %v", err)
+	}
+	if purged != 28 {
+		t.Fatalf("expected 28 rows purged, got %d", purged)
+	}
+	for _, a := range []*store.RunAttempt{oldCompleted, oldDead} {
+		if n := countAttemptLogs(t, dbConn, a.ID); n != 0 {
+			t.Fatalf("attempt %d: expected logs purged, %d left", a.ID, n)
+		}
+	}
+	if n := countAttemptLogs(t, dbConn, recent.ID); n != 4 {
+		t.Fatalf("expected recent attempt to keep 4 logs, got %d", n)
+	}
+
+	// A second pass has nothing left to do.
+	purged, err = s.PurgeOldLogs(ctx, now.Add(-7*24*time.Hour), 10)
+	if err != nil || purged != 0 {
+		t.Fatalf("expected no-op second pass, got %d (%v)", purged, err)
+	}
+}
+
+func TestLogRetentionNeverTouchesNonTerminalRuns(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-purge-active")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "purge-active-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	runner, _ := testutil.CreateRunner(t, s, "runner-purge-active", "default")
+
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	running := seedAttemptLogs(t, s, dbConn, runner, "running", old, 20)
+	mustExec(t, dbConn, `UPDATE run_attempts SET updated_at = ? WHERE id = ?`, old.UnixMilli(), running.ID)
+
+	// An expired first attempt of a run that was re-queued for retry.
+	other, _ := testutil.CreateRunner(t, s, "runner-purge-retry", "default")
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 1)
+	retried := seedAttemptLogs(t, s, dbConn, other, "queued", old, 20)
+
+	if purged, err := s.PurgeOldLogs(ctx, time.Now(), 5); err != nil || purged != 0 {
+		t.Fatalf("expected nothing purged, got %d (%v)", purged, err)
+	}
+	if trimmed, err := s.TrimAttemptLogs(ctx, 5, 5); err != nil || trimmed != 0 {
+		t.Fatalf("expected nothing trimmed, got %d (%v)", trimmed, err)
+	}
+	ids, err := s.ListLogPurgeCandidates(ctx, time.Now(), 10)
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("expected no purge candidates, got %v (%v)", ids, err)
+	}
+	for _, a := range []*store.RunAttempt{running, retried} {
+		if n := countAttemptLogs(t, dbConn, a.ID); n != 20 {
+			t.Fatalf("attempt %d: expected 20 logs kept, got %d", a.ID, n)
+		}
+	}
+}
+
+func TestTrimAttemptLogsKeepsNewestRows(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-trim")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "trim-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	runner, _ := testutil.CreateRunner(t, s, "runner-trim", "default")
+
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	big := seedAttemptLogs(t, s, dbConn, runner, "failed", time.Now(), 23)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	small := seedAttemptLogs(t, s, dbConn, runner, "completed", time.Now(), 5)
+
+	trimmed, err := s.TrimAttemptLogs(ctx, 10, 4)
+	if err != nil {
+		t.Fatalf("trim logs: %v", err)
+	}
+	if trimmed != 13 {
+		t.Fatalf("expected 13 rows trimmed, got %d", trimmed)
+	}
+	logs, err := s.GetAttemptLogs(ctx, big.ID)
+	if err != nil {
+		t.Fatalf("get logs: %v", err)
+	}
+	if len(logs) != 10 || logs[0].Seq != 14 || logs[9].Seq != 23 {
+		t.Fatalf("expected seq 14..23 kept, got %d rows starting at %d", len(logs), logs[0].Seq)
+	}
+	if n := countAttemptLogs(t, dbConn, small.ID); n != 5 {
+		t.Fatalf("expected small attempt untouched, got %d", n)
+	}
+}
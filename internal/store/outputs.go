@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RunOutput is a file a run uploaded from its outputs directory.
+type RunOutput struct {
+	ID           int64
+	RunID        int64
+	RunAttemptID int64
+	Name         string
+	SizeBytes    int64
+	SHA256       string
+	ObjectKey    string
+	CreatedAt    time.Time
+}
+
+const runOutputColumns = `id, run_id, run_attempt_id, name, size_bytes, sha256, object_key, created_at`
+
+func scanRunOutput(row rowScanner) (*RunOutput, error) {
+	var o RunOutput
+	var createdAt int64
+	if err := row.Scan(&o.ID, &o.RunID, &o.RunAttemptID, &o.Name, &o.SizeBytes, &o.SHA256, &o.ObjectKey, &createdAt); err != nil {
+		return nil, err
+	}
+	o.CreatedAt = time.UnixMilli(createdAt)
+	return &o, nil
+}
+
+// SaveRunOutput records an uploaded output. An output with the same name on
+// the run, left by an earlier attempt or an earlier upload, is replaced.
+func (s *Store) SaveRunOutput(ctx context.Context, o *RunOutput) (*RunOutput, error) {
+	now := time.Now().UnixMilli()
+	_, err := s.exec(ctx,
+		`INSERT INTO run_outputs (run_id, run_attempt_id, name, size_bytes, sha256, object_key, created_at)
+     VALUES (?, ?, ?, ?, ?, ?, ?)
+     ON CONFLICT(run_id, name) DO UPDATE SET
+       run_attempt_id = excluded.run_attempt_id,
+       size_bytes = excluded.size_bytes,
+       sha256 = excluded.sha256,
+       object_key = excluded.object_key,
+       created_at = excluded.created_at`,
+		o.RunID, o.RunAttemptID, o.Name, o.SizeBytes, o.SHA256, o.ObjectKey, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRunOutput(ctx, o.RunID, o.Name)
+}
+
+// ListRunOutputs returns a run's outputs ordered by name.
+func (s *Store) ListRunOutputs(ctx context.Context, runID int64) ([]*RunOutput, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+runOutputColumns+`
+     FROM run_outputs
+     WHERE run_id = ?
+     ORDER BY name ASC`,
+		runID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var outputs []*RunOutput
+	for rows.Next() {
+		o, err := scanRunOutput(rows)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, o)
+	}
+	return outputs, rows.Err()
+}
+
+// GetRunOutput returns the named output of a run, or nil if there is none.
+func (s *Store) GetRunOutput(ctx context.Context, runID int64, name string) (*RunOutput, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+runOutputColumns+` FROM run_outputs WHERE run_id = ? AND name = ?`,
+		runID, name,
+	)
+	o, err := scanRunOutput(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return o, err
+}
+
+// CountRunOutputs returns how many outputs a run has.
+func (s *Store) CountRunOutputs(ctx context.Context, runID int64) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM run_outputs WHERE run_id = ?`, runID,
+	).Scan(&n)
+	return n, err
+}
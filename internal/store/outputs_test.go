@@ -0,0 +1,56 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestRunOutputsReplaceByName(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-outputs")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "outputs-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, _ := testutil.CreateRunner(t, s, "runner-outputs", "default")
+	run, attempt, _, _ := testutil.LeaseRun(t, s, runner)
+
+	missing, err := s.GetRunOutput(ctx, run.ID, "report.csv")
+	if err != nil || missing != nil {
+		t.Fatalf("expected no output, got %+v (%v)", missing, err)
+	}
+
+	for _, o := range []store.RunOutput{
+		{Name: "report.csv", SizeBytes: 10, SHA256: "aa", ObjectKey: "k1"},
+		{Name: "chart.png", SizeBytes: 20, SHA256: "bb", ObjectKey: "k2"},
+		{Name: "report.csv", SizeBytes: 30, SHA256: "cc", ObjectKey: "k3"},
+	} {
+		o.RunID, o.RunAttemptID = run.ID, attempt.ID
+		if _, err := s.SaveRunOutput(ctx, &o); err != nil {
+			t.Fatalf("save %s: %v", o.Name, err)
+		}
+	}
+
+	outputs, err := s.ListRunOutputs(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("list outputs: %v", err)
+	}
+	if len(outputs) != 2 || outputs[0].Name != "chart.png" || outputs[1].Name != "report.csv" {
+		t.Fatalf("unexpected outputs: %+v", outputs)
+	}
+	if outputs[1].SizeBytes != 30 || outputs[1].SHA256 != "cc" || outputs[1].ObjectKey != "k3" {
+		t.Fatalf("expected the later upload to replace report.csv, got %+v", outputs[1])
+	}
+	if n, err := s.CountRunOutputs(ctx, run.ID); err != nil || n != 2 {
+		t.Fatalf("expected 2 outputs, got %d (%v)", n, err)
+	}
+}
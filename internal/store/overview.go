@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// AdminOverview aggregates usage across all teams.
+type AdminOverview struct {
+	TeamCount      int64
+	Teams          []TeamUsage
+	ArtifactBytes  int64            // Sum of recorded artifact sizes.
+	RecentRuns     map[string]int64 // Runs created since the overview window, by status.
+	OnlineRunners  int64
+	OfflineRunners int64
+	// Starved lists the environments with queued runs and no online runner.
+	Starved []StarvedEnvironment
+}
+
+// TeamUsage holds a team's app and run counts, and its non-terminal runs
+// against its quota.
+type TeamUsage struct {
+	TeamID        int64
+	Slug          string
+	Apps          int64
+	Runs          int64
+	ActiveRuns    int64
+	MaxActiveRuns *int64 // nil when the team has no quota.
+}
+
+// GetAdminOverview aggregates teams, apps, runs, artifact storage and runners.
+// Runs by status cover runs created at or after since. Each figure comes from
+// one grouped query, independent of the number of teams.
+func (s *Store) GetAdminOverview(ctx context.Context, since time.Time) (*AdminOverview, error) {
+	o := &AdminOverview{RecentRuns: make(map[string]int64)}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT t.id, t.slug, COALESCE(a.n, 0), COALESCE(r.n, 0), COALESCE(r.active, 0), t.max_active_runs
+     FROM teams t
+     LEFT JOIN (SELECT team_id, COUNT(*) AS n FROM apps GROUP BY team_id) a ON a.team_id = t.id
+     LEFT JOIN (SELECT team_id, COUNT(*) AS n,
+                       SUM(CASE WHEN status IN ('queued', 'leased', 'running', 'cancelling') THEN 1 ELSE 0 END) AS active
+                FROM runs GROUP BY team_id) r ON r.team_id = t.id
+     ORDER BY t.slug ASC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var u TeamUsage
+		if err := rows.Scan(&u.TeamID, &u.Slug, &u.Apps, &u.Runs, &u.ActiveRuns, &u.MaxActiveRuns); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		o.Teams = append(o.Teams, u)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	o.TeamCount = int64(len(o.Teams))
+
+	if err := s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(artifact_size_bytes), 0) FROM app_versions`,
+	).Scan(&o.ArtifactBytes); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx,
+		`SELECT status, COUNT(*) FROM runs WHERE created_at >= ? GROUP BY status`,
+		since.UnixMilli(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		o.RecentRuns[status] = n
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(CASE WHEN status = 'online' THEN 1 ELSE 0 END), 0),
+            COALESCE(SUM(CASE WHEN status = 'online' THEN 0 ELSE 1 END), 0)
+     FROM runners`,
+	).Scan(&o.OnlineRunners, &o.OfflineRunners); err != nil {
+		return nil, err
+	}
+
+	starved, err := s.ListStarvedEnvironments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o.Starved = starved
+
+	return o, nil
+}
@@ -0,0 +1,89 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"minitower/internal/testutil"
+)
+
+func TestGetAdminOverviewAggregatesAcrossTeams(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	alpha, _ := testutil.CreateTeam(t, s, "team-overview-alpha")
+	beta, _ := testutil.CreateTeam(t, s, "team-overview-beta")
+	testutil.CreateTeam(t, s, "team-overview-empty")
+
+	alphaEnv, err := s.GetOrCreateDefaultEnvironment(ctx, alpha.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	alphaApp := testutil.CreateApp(t, s, alpha.ID, "overview-a1")
+	testutil.CreateApp(t, s, alpha.ID, "overview-a2")
+	alphaVer, err := s.CreateVersion(ctx, alphaApp.ID, "objects/a.tar.gz", "sha256", 1000, "main.py", nil, nil, nil, nil, nil, 1, false, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+	if _, err := s.CreateVersion(ctx, alphaApp.ID, "objects/a2.tar.gz", "sha256", 500, "main.py", nil, nil, nil, nil, nil, 1, false, nil, nil, nil); err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+	for _, status := range []string{"queued", "completed", "completed", "failed"} {
+		run := testutil.CreateRun(t, s, alpha.ID, alphaApp.ID, alphaEnv.ID, alphaVer.ID, 0, 0)
+		mustExec(t, dbConn, `UPDATE runs SET status = ? WHERE id = ?`, status, run.ID)
+	}
+	// A run older than the window counts toward the team but not by status.
+	old := testutil.CreateRun(t, s, alpha.ID, alphaApp.ID, alphaEnv.ID, alphaVer.ID, 0, 0)
+	mustExec(t, dbConn, `UPDATE runs SET status = 'dead', created_at = ? WHERE id = ?`, time.Now().Add(-48*time.Hour).UnixMilli(), old.ID)
+
+	betaEnv, err := s.GetOrCreateDefaultEnvironment(ctx, beta.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	betaApp := testutil.CreateApp(t, s, beta.ID, "overview-b1")
+	// A version uploaded before sizes were recorded contributes nothing.
+	betaVer := testutil.CreateVersion(t, s, betaApp.ID)
+	mustExec(t, dbConn, `UPDATE app_versions SET artifact_size_bytes = NULL WHERE id = ?`, betaVer.ID)
+	testutil.CreateRun(t, s, beta.ID, betaApp.ID, betaEnv.ID, betaVer.ID, 0, 0)
+
+	testutil.CreateRunner(t, s, "runner-overview-1", "default")
+	offline, _ := testutil.CreateRunner(t, s, "runner-overview-2", "default")
+	mustExec(t, dbConn, `UPDATE runners SET status = 'offline' WHERE id = ?`, offline.ID)
+
+	o, err := s.GetAdminOverview(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("get overview: %v", err)
+	}
+	if o.TeamCount != 3 || len(o.Teams) != 3 {
+		t.Fatalf("expected 3 teams, got %d (%+v)", o.TeamCount, o.Teams)
+	}
+	want := map[string][2]int64{
+		"team-overview-alpha": {2, 5},
+		"team-overview-beta":  {1, 1},
+		"team-overview-empty": {0, 0},
+	}
+	for _, u := range o.Teams {
+		if got := [2]int64{u.Apps, u.Runs}; got != want[u.Slug] {
+			t.Fatalf("%s: expected apps/runs %v, got %v", u.Slug, want[u.Slug], got)
+		}
+	}
+	if o.ArtifactBytes != 1500 {
+		t.Fatalf("expected 1500 artifact bytes, got %d", o.ArtifactBytes)
+	}
+	if len(o.RecentRuns) != 3 || o.RecentRuns["queued"] != 2 || o.RecentRuns["completed"] != 2 || o.RecentRuns["failed"] != 1 {
+		t.Fatalf("unexpected runs by status: %v", o.RecentRuns)
+	}
+	if o.OnlineRunners != 1 || o.OfflineRunners != 1 {
+		t.Fatalf("expected 1 online and 1 offline runner, got %d/%d", o.OnlineRunners, o.OfflineRunners)
+	}
+
+	missing, err := s.ListVersionsMissingArtifactSize(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("list missing sizes: %v", err)
+	}
+	if len(missing) != 1 || missing[0].VersionID != betaVer.ID {
+		t.Fatalf("expected only version %d to miss its size, got %+v", betaVer.ID, missing)
+	}
+}
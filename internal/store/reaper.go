@@ -9,11 +9,41 @@ import (
 
 const defaultReapLimit = 100
 
+// cancelUnconfirmedError is the error the reaper records on a cancelled
+// attempt whose runner never reported the cancellation itself.
+const cancelUnconfirmedError = "runner did not confirm cancellation"
+
+// startDeadlineError is the error the reaper records on an attempt whose
+// runner never called start within the start deadline.
+const startDeadlineError = "runner did not start the attempt before the start deadline"
+
+// Dead reasons, stored in runs.dead_reason, say why a run ended dead.
+const (
+	// DeadReasonMaxRetriesExceeded: the last allowed attempt's lease expired.
+	DeadReasonMaxRetriesExceeded = "max_retries_exceeded"
+	// DeadReasonLeaseExpiredNoRetry: the lease expired on a run created
+	// with max_retries 0.
+	DeadReasonLeaseExpiredNoRetry = "lease_expired_no_retry"
+	// DeadReasonAtMostOnceViolation: an at-most-once run's lease expired
+	// after its process started, so it could not be retried.
+	DeadReasonAtMostOnceViolation = "at_most_once_violation"
+	// DeadReasonArtifactUnavailable: the version artifact was missing when
+	// the runner fetched it.
+	DeadReasonArtifactUnavailable = "artifact_unavailable"
+	// DeadReasonCancelUnacknowledged: the runner never confirmed a
+	// cancellation. Those runs end cancelled rather than dead, so the reason
+	// is only reported in ReapResult and never stored.
+	DeadReasonCancelUnacknowledged = "cancel_unacknowledged"
+)
+
 // ReapResult describes what happened to a single reaped attempt.
 type ReapResult struct {
 	TeamID int64
 	AppID  int64
-	Outcome string // "retried", "dead", "cancelled"
+	Outcome string // "retried", "requeued", "dead", "dead_at_most_once", "cancelled"
+	// Reason is the run's dead reason for dead outcomes and
+	// DeadReasonCancelUnacknowledged for cancelled ones.
+	Reason string
 }
 
 // ReapExpiredAttempts processes expired leases and applies retry/dead/cancel rules.
@@ -49,9 +79,94 @@ func (s *Store) ReapExpiredAttempts(ctx context.Context, now time.Time, limit in
 		return nil, err
 	}
 
+	return s.reapAttempts(ctx, attemptIDs, nowMs, false)
+}
+
+// ReapUnconfirmedCancels ends cancelling attempts whose runner was told of
+// the cancellation by a heartbeat more than grace ago but never reported the
+// attempt cancelled, typically because it died right after. Their runs are
+// cancelled as if the lease had expired.
+func (s *Store) ReapUnconfirmedCancels(ctx context.Context, now time.Time, grace time.Duration, limit int) ([]ReapResult, error) {
+	if limit <= 0 {
+		limit = defaultReapLimit
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id
+     FROM run_attempts
+     WHERE status = 'cancelling'
+       AND cancel_ack_at <= ?
+     ORDER BY cancel_ack_at ASC
+     LIMIT ?`,
+		now.Add(-grace).UnixMilli(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attemptIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		attemptIDs = append(attemptIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.reapAttempts(ctx, attemptIDs, now.UnixMilli(), true)
+}
+
+// ReapUnstartedAttempts expires attempts still leased more than deadline
+// after their lease was granted, without waiting for the lease to run out:
+// their runner never called start, most likely because it died right after
+// leasing. No user code ran, so the run is re-queued without using a retry
+// and keeps its place in the queue. A run with a cancellation pending is
+// cancelled as on lease expiry instead.
+func (s *Store) ReapUnstartedAttempts(ctx context.Context, now time.Time, deadline time.Duration, limit int) ([]ReapResult, error) {
+	if limit <= 0 {
+		limit = defaultReapLimit
+	}
+
+	cutoffMs := now.Add(-deadline).UnixMilli()
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id
+     FROM run_attempts
+     WHERE status = 'leased'
+       AND created_at <= ?
+     ORDER BY created_at ASC
+     LIMIT ?`,
+		cutoffMs, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attemptIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		attemptIDs = append(attemptIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	nowMs := now.UnixMilli()
 	var results []ReapResult
 	for _, attemptID := range attemptIDs {
-		result, err := s.reapAttempt(ctx, attemptID, nowMs)
+		var result *ReapResult
+		err := s.write(ctx, func(tx *sql.Tx) error {
+			var err error
+			result, err = requeueUnstartedAttemptTx(ctx, tx, attemptID, nowMs, cutoffMs)
+			return err
+		})
 		if err != nil {
 			return results, err
 		}
@@ -59,17 +174,112 @@ func (s *Store) ReapExpiredAttempts(ctx context.Context, now time.Time, limit in
 			results = append(results, *result)
 		}
 	}
+	return results, nil
+}
+
+// requeueUnstartedAttemptTx expires an attempt leased at or before cutoffMs
+// and never started, marking it started_never, and re-queues its run with
+// its retry count unchanged. An attempt that has since started or ended is
+// left alone.
+func requeueUnstartedAttemptTx(ctx context.Context, tx *sql.Tx, attemptID int64, nowMs, cutoffMs int64) (*ReapResult, error) {
+	var runID, teamID, appID, attemptNo, runnerID, createdAt int64
+	var attemptStatus, runStatus string
+	var cancelRequested int
+	err := tx.QueryRowContext(ctx,
+		`SELECT a.run_id, a.status, a.created_at, a.attempt_no, a.runner_id, r.status, r.cancel_requested, r.team_id, r.app_id
+     FROM run_attempts a
+     JOIN runs r ON r.id = a.run_id
+     WHERE a.id = ?`,
+		attemptID,
+	).Scan(&runID, &attemptStatus, &createdAt, &attemptNo, &runnerID, &runStatus, &cancelRequested, &teamID, &appID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if attemptStatus != "leased" || createdAt > cutoffMs {
+		return nil, nil
+	}
+	if cancelRequested == 1 || runStatus != "leased" {
+		return reapAttemptTx(ctx, tx, attemptID, nowMs, true)
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE run_attempts SET status = 'expired', started_never = 1, error_message = ?, finished_at = ?, updated_at = ?
+     WHERE id = ? AND status = 'leased'`,
+		startDeadlineError, nowMs, nowMs, attemptID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, nil
+	}
+	if err := appendRunEvent(ctx, tx, runID, RunEventAttemptExpired, map[string]any{
+		"attempt_no":     attemptNo,
+		"runner_id":      runnerID,
+		"attempt_status": attemptStatus,
+		"started_never":  true,
+	}, nowMs); err != nil {
+		return nil, err
+	}
+
+	result, err = tx.ExecContext(ctx,
+		`UPDATE runs SET status = 'queued', updated_at = ?
+     WHERE id = ? AND status = 'leased' AND cancel_requested = 0`,
+		nowMs, runID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	affected, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected > 0 {
+		if err := appendRunEvent(ctx, tx, runID, RunEventRequeued, map[string]any{"attempt_no": attemptNo}, nowMs); err != nil {
+			return nil, err
+		}
+	}
+	return &ReapResult{TeamID: teamID, AppID: appID, Outcome: "requeued"}, nil
+}
 
+func (s *Store) reapAttempts(ctx context.Context, attemptIDs []int64, nowMs int64, force bool) ([]ReapResult, error) {
+	var results []ReapResult
+	for _, attemptID := range attemptIDs {
+		result, err := s.reapAttempt(ctx, attemptID, nowMs, force)
+		if err != nil {
+			return results, err
+		}
+		if result != nil {
+			results = append(results, *result)
+		}
+	}
 	return results, nil
 }
 
-func (s *Store) reapAttempt(ctx context.Context, attemptID int64, nowMs int64) (*ReapResult, error) {
-	tx, err := s.db.BeginTx(ctx, nil)
+func (s *Store) reapAttempt(ctx context.Context, attemptID int64, nowMs int64, force bool) (*ReapResult, error) {
+	var result *ReapResult
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		var err error
+		result, err = reapAttemptTx(ctx, tx, attemptID, nowMs, force)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
+	return result, nil
+}
 
+// reapAttemptTx ends an active attempt inside tx, cancelling, re-queueing or
+// deading its run. Unless force is set, an attempt whose lease has not yet
+// expired is left alone.
+func reapAttemptTx(ctx context.Context, tx *sql.Tx, attemptID int64, nowMs int64, force bool) (*ReapResult, error) {
 	var runID int64
 	var attemptStatus string
 	var leaseExpiresAt int64
@@ -77,16 +287,19 @@ func (s *Store) reapAttempt(ctx context.Context, attemptID int64, nowMs int64) (
 	var cancelRequested int
 	var retryCount int
 	var maxRetries int
+	var atMostOnce int
 	var teamID int64
 	var appID int64
+	var attemptNo int64
+	var runnerID int64
 
-	err = tx.QueryRowContext(ctx,
-		`SELECT a.run_id, a.status, a.lease_expires_at, r.status, r.cancel_requested, r.retry_count, r.max_retries, r.team_id, r.app_id
+	err := tx.QueryRowContext(ctx,
+		`SELECT a.run_id, a.status, a.lease_expires_at, r.status, r.cancel_requested, r.retry_count, r.max_retries, r.at_most_once, r.team_id, r.app_id, a.attempt_no, a.runner_id
      FROM run_attempts a
      JOIN runs r ON r.id = a.run_id
      WHERE a.id = ?`,
 		attemptID,
-	).Scan(&runID, &attemptStatus, &leaseExpiresAt, &runStatus, &cancelRequested, &retryCount, &maxRetries, &teamID, &appID)
+	).Scan(&runID, &attemptStatus, &leaseExpiresAt, &runStatus, &cancelRequested, &retryCount, &maxRetries, &atMostOnce, &teamID, &appID, &attemptNo, &runnerID)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -94,13 +307,26 @@ func (s *Store) reapAttempt(ctx context.Context, attemptID int64, nowMs int64) (
 		return nil, err
 	}
 
-	if leaseExpiresAt > nowMs {
+	if !force && leaseExpiresAt > nowMs {
 		return nil, nil
 	}
 	if attemptStatus != "leased" && attemptStatus != "running" && attemptStatus != "cancelling" {
 		return nil, nil
 	}
 
+	// recordExpiry appends the attempt's expiry to the run's timeline when
+	// this reap is the one that ended it.
+	recordExpiry := func(attemptUpdated bool) error {
+		if !attemptUpdated {
+			return nil
+		}
+		return appendRunEvent(ctx, tx, runID, RunEventAttemptExpired, map[string]any{
+			"attempt_no":     attemptNo,
+			"runner_id":      runnerID,
+			"attempt_status": attemptStatus,
+		}, nowMs)
+	}
+
 	cancelPath := cancelRequested == 1 || attemptStatus == "cancelling" || runStatus == "cancelling"
 
 	if cancelPath {
@@ -108,7 +334,18 @@ func (s *Store) reapAttempt(ctx context.Context, attemptID int64, nowMs int64) (
 		if err != nil {
 			return nil, err
 		}
-		_, err = tx.ExecContext(ctx,
+		if err := recordExpiry(attemptUpdated); err != nil {
+			return nil, err
+		}
+		if attemptUpdated {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE run_attempts SET error_message = ? WHERE id = ?`,
+				cancelUnconfirmedError, attemptID,
+			); err != nil {
+				return nil, err
+			}
+		}
+		result, err := tx.ExecContext(ctx,
 			`UPDATE runs SET status = 'cancelled', finished_at = ?, updated_at = ?
        WHERE id = ? AND status IN ('leased', 'running', 'cancelling')`,
 			nowMs, nowMs, runID,
@@ -116,11 +353,53 @@ func (s *Store) reapAttempt(ctx context.Context, attemptID int64, nowMs int64) (
 		if err != nil {
 			return nil, err
 		}
-		if err := tx.Commit(); err != nil {
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if affected > 0 {
+			if err := appendRunEvent(ctx, tx, runID, RunEventFinished, map[string]any{"status": "cancelled", "error": cancelUnconfirmedError}, nowMs); err != nil {
+				return nil, err
+			}
+		}
+		if attemptUpdated {
+			return &ReapResult{TeamID: teamID, AppID: appID, Outcome: "cancelled", Reason: DeadReasonCancelUnacknowledged}, nil
+		}
+		return nil, nil
+	}
+
+	// An at-most-once run whose process may have started is never re-queued.
+	// Attempts still "leased" expired before the runner reported a start,
+	// so they retry as usual.
+	if atMostOnce == 1 && attemptStatus == "running" {
+		attemptUpdated, err := updateAttemptStatus(tx, attemptID, nowMs, "expired")
+		if err != nil {
+			return nil, err
+		}
+		if err := recordExpiry(attemptUpdated); err != nil {
+			return nil, err
+		}
+		result, err := tx.ExecContext(ctx,
+			`UPDATE runs SET status = 'dead', dead_reason = ?, failure_kind = ?, finished_at = ?, updated_at = ?
+       WHERE id = ? AND status IN ('leased', 'running', 'cancelling') AND cancel_requested = 0`,
+			DeadReasonAtMostOnceViolation, FailureKindInfrastructure, nowMs, nowMs, runID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if affected > 0 {
+			if err := appendRunEvent(ctx, tx, runID, RunEventFinished, map[string]any{"status": "dead", "at_most_once": true, "dead_reason": DeadReasonAtMostOnceViolation, "failure_kind": FailureKindInfrastructure}, nowMs); err != nil {
+				return nil, err
+			}
+		} else if err := maybeCancelRun(ctx, tx, runID, nowMs); err != nil {
 			return nil, err
 		}
 		if attemptUpdated {
-			return &ReapResult{TeamID: teamID, AppID: appID, Outcome: "cancelled"}, nil
+			return &ReapResult{TeamID: teamID, AppID: appID, Outcome: "dead_at_most_once", Reason: DeadReasonAtMostOnceViolation}, nil
 		}
 		return nil, nil
 	}
@@ -130,6 +409,9 @@ func (s *Store) reapAttempt(ctx context.Context, attemptID int64, nowMs int64) (
 		if err != nil {
 			return nil, err
 		}
+		if err := recordExpiry(attemptUpdated); err != nil {
+			return nil, err
+		}
 
 		result, err := tx.ExecContext(ctx,
 			`UPDATE runs SET status = 'queued', retry_count = retry_count + 1, queued_at = ?, updated_at = ?
@@ -143,15 +425,14 @@ func (s *Store) reapAttempt(ctx context.Context, attemptID int64, nowMs int64) (
 		if err != nil {
 			return nil, err
 		}
-		if affected == 0 {
-			if err := maybeCancelRun(ctx, tx, runID, nowMs); err != nil {
+		if affected > 0 {
+			if err := appendRunEvent(ctx, tx, runID, RunEventRetried, map[string]any{"retry_count": retryCount + 1}, nowMs); err != nil {
 				return nil, err
 			}
-		}
-
-		if err := tx.Commit(); err != nil {
+		} else if err := maybeCancelRun(ctx, tx, runID, nowMs); err != nil {
 			return nil, err
 		}
+
 		if attemptUpdated {
 			return &ReapResult{TeamID: teamID, AppID: appID, Outcome: "retried"}, nil
 		}
@@ -162,11 +443,18 @@ func (s *Store) reapAttempt(ctx context.Context, attemptID int64, nowMs int64) (
 	if err != nil {
 		return nil, err
 	}
+	if err := recordExpiry(attemptUpdated); err != nil {
+		return nil, err
+	}
 
+	deadReason := DeadReasonMaxRetriesExceeded
+	if maxRetries == 0 {
+		deadReason = DeadReasonLeaseExpiredNoRetry
+	}
 	result, err := tx.ExecContext(ctx,
-		`UPDATE runs SET status = 'dead', finished_at = ?, updated_at = ?
+		`UPDATE runs SET status = 'dead', dead_reason = ?, failure_kind = ?, finished_at = ?, updated_at = ?
      WHERE id = ? AND status IN ('leased', 'running', 'cancelling') AND cancel_requested = 0 AND retry_count >= max_retries`,
-		nowMs, nowMs, runID,
+		deadReason, FailureKindInfrastructure, nowMs, nowMs, runID,
 	)
 	if err != nil {
 		return nil, err
@@ -175,22 +463,107 @@ func (s *Store) reapAttempt(ctx context.Context, attemptID int64, nowMs int64) (
 	if err != nil {
 		return nil, err
 	}
-	if affected == 0 {
-		if err := maybeCancelRun(ctx, tx, runID, nowMs); err != nil {
+	if affected > 0 {
+		if err := appendRunEvent(ctx, tx, runID, RunEventFinished, map[string]any{"status": "dead", "dead_reason": deadReason, "failure_kind": FailureKindInfrastructure}, nowMs); err != nil {
 			return nil, err
 		}
-	}
-
-	if err := tx.Commit(); err != nil {
+	} else if err := maybeCancelRun(ctx, tx, runID, nowMs); err != nil {
 		return nil, err
 	}
 
 	if attemptUpdated {
-		return &ReapResult{TeamID: teamID, AppID: appID, Outcome: "dead"}, nil
+		return &ReapResult{TeamID: teamID, AppID: appID, Outcome: "dead", Reason: deadReason}, nil
 	}
 	return nil, nil
 }
 
+// ExpireAttemptsForOfflineRunners marks runners not seen since threshold
+// offline and, in the same transaction, ends their active attempts with the
+// rules ReapExpiredAttempts applies to expired leases, so a crashed runner's
+// runs are retried without waiting for the lease TTL. Returns the number of
+// runners marked offline and one result per attempt ended.
+func (s *Store) ExpireAttemptsForOfflineRunners(ctx context.Context, threshold time.Time) (int, []ReapResult, error) {
+	thresholdMs := threshold.UnixMilli()
+	nowMs := time.Now().UnixMilli()
+
+	var runnerIDs []int64
+	var results []ReapResult
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			`SELECT id FROM runners
+     WHERE status = 'online' AND COALESCE(last_seen_at, updated_at, created_at) < ?`,
+			thresholdMs,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			runnerIDs = append(runnerIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, runnerID := range runnerIDs {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE runners SET status = 'offline', updated_at = ? WHERE id = ?`,
+				nowMs, runnerID,
+			); err != nil {
+				return err
+			}
+
+			attemptIDs, err := activeAttemptIDs(ctx, tx, runnerID)
+			if err != nil {
+				return err
+			}
+			for _, attemptID := range attemptIDs {
+				result, err := reapAttemptTx(ctx, tx, attemptID, nowMs, true)
+				if err != nil {
+					return err
+				}
+				if result != nil {
+					results = append(results, *result)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return len(runnerIDs), results, nil
+}
+
+func activeAttemptIDs(ctx context.Context, tx *sql.Tx, runnerID int64) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id FROM run_attempts
+     WHERE runner_id = ? AND status IN ('leased', 'running', 'cancelling')
+     ORDER BY id ASC`,
+		runnerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func updateAttemptStatus(tx *sql.Tx, attemptID int64, nowMs int64, status string) (bool, error) {
 	result, err := tx.Exec(
 		`UPDATE run_attempts SET status = ?, finished_at = ?, updated_at = ?
@@ -217,7 +590,7 @@ func maybeCancelRun(ctx context.Context, tx *sql.Tx, runID int64, nowMs int64) e
 		return err
 	}
 	if cancelRequested == 1 {
-		_, err = tx.ExecContext(ctx,
+		result, err := tx.ExecContext(ctx,
 			`UPDATE runs SET status = 'cancelled', finished_at = ?, updated_at = ?
        WHERE id = ? AND status IN ('leased', 'running', 'cancelling')`,
 			nowMs, nowMs, runID,
@@ -225,6 +598,13 @@ func maybeCancelRun(ctx context.Context, tx *sql.Tx, runID int64, nowMs int64) e
 		if err != nil {
 			return err
 		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected > 0 {
+			return appendRunEvent(ctx, tx, runID, RunEventFinished, map[string]any{"status": "cancelled"}, nowMs)
+		}
 	}
 	return nil
 }
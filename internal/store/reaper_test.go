@@ -79,6 +79,63 @@ func TestReapExpiredRequeueThenDead(t *testing.T) {
 	}
 }
 
+func TestReapAtMostOnceRetriesOnlyBeforeStart(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-reap-amo")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-reap-amo")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, nil, 0, 2, true)
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	if !run.AtMostOnce {
+		t.Fatal("expected the run to be at-most-once")
+	}
+
+	// A lease that expires before the process starts is retried.
+	runner, _ := testutil.CreateRunner(t, s, "runner-reap-amo", "default")
+	_, attempt1, _, _ := testutil.LeaseRun(t, s, runner)
+	expireAttempt(t, dbConn, attempt1.ID, time.Now().Add(-2*time.Minute))
+	results, err := s.ReapExpiredAttempts(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("reap attempts: %v", err)
+	}
+	if len(results) != 1 || results[0].Outcome != "retried" {
+		t.Fatalf("expected one retried result, got %+v", results)
+	}
+
+	// Once the attempt is running, expiry marks the run dead even though
+	// retries remain.
+	_, attempt2, _, leaseHash := testutil.LeaseRun(t, s, runner)
+	if _, err := s.StartAttempt(ctx, attempt2.ID, leaseHash); err != nil {
+		t.Fatalf("start attempt: %v", err)
+	}
+	expireAttempt(t, dbConn, attempt2.ID, time.Now().Add(-2*time.Minute))
+	results, err = s.ReapExpiredAttempts(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("reap attempts: %v", err)
+	}
+	if len(results) != 1 || results[0].Outcome != "dead_at_most_once" {
+		t.Fatalf("expected one dead_at_most_once result, got %+v", results)
+	}
+
+	assertAttemptStatus(t, dbConn, attempt2.ID, "expired")
+	loaded, err := s.GetRunByID(ctx, team.ID, run.ID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if loaded.Status != "dead" || loaded.RetryCount != 1 || !loaded.AtMostOnce {
+		t.Fatalf("expected dead at-most-once run with retry_count 1, got %+v", loaded)
+	}
+}
+
 func TestReapExpiredCancelRequested(t *testing.T) {
 	s, dbConn, cleanup := testutil.NewTestDB(t)
 	defer cleanup.Close(t)
@@ -155,6 +212,112 @@ func TestReapExpiredCancellingAttempt(t *testing.T) {
 	if loaded.Status != "cancelled" {
 		t.Fatalf("expected run cancelled, got %s", loaded.Status)
 	}
+	assertAttemptError(t, dbConn, attempt.ID, "runner did not confirm cancellation")
+}
+
+func TestExtendLeaseRecordsCancelAckOnce(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-cancel-ack")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-cancel-ack")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	runner, _ := testutil.CreateRunner(t, s, "runner-cancel-ack", "default")
+	_, attempt, _, leaseHash := testutil.LeaseRun(t, s, runner)
+
+	a, err := s.ExtendLease(ctx, attempt.ID, leaseHash, time.Minute)
+	if err != nil {
+		t.Fatalf("extend lease: %v", err)
+	}
+	if a.CancelAckAt != nil {
+		t.Fatalf("expected no cancel ack before cancellation, got %v", a.CancelAckAt)
+	}
+
+	if _, err := s.CancelRun(ctx, team.ID, run.ID); err != nil {
+		t.Fatalf("cancel run: %v", err)
+	}
+	a, err = s.ExtendLease(ctx, attempt.ID, leaseHash, time.Minute)
+	if err != nil {
+		t.Fatalf("extend lease after cancel: %v", err)
+	}
+	if a.CancelAckAt == nil {
+		t.Fatal("expected cancel_ack_at after the first heartbeat following cancellation")
+	}
+	first := *a.CancelAckAt
+
+	time.Sleep(5 * time.Millisecond)
+	a, err = s.ExtendLease(ctx, attempt.ID, leaseHash, time.Minute)
+	if err != nil {
+		t.Fatalf("second extend lease after cancel: %v", err)
+	}
+	if a.CancelAckAt == nil || !a.CancelAckAt.Equal(first) {
+		t.Fatalf("expected cancel_ack_at to stay %v, got %v", first, a.CancelAckAt)
+	}
+}
+
+func TestReapUnconfirmedCancels(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-unconfirmed")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-unconfirmed")
+	version := testutil.CreateVersion(t, s, app.ID)
+
+	// Both runs are cancelled and acknowledged with live leases; only the
+	// older acknowledgement is past the grace period.
+	var attempts []*store.RunAttempt
+	var runs []*store.Run
+	for _, name := range []string{"runner-unconfirmed-old", "runner-unconfirmed-new"} {
+		run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+		runner, _ := testutil.CreateRunner(t, s, name, "default")
+		_, attempt, _, leaseHash := testutil.LeaseRun(t, s, runner)
+		if _, err := s.CancelRun(ctx, team.ID, run.ID); err != nil {
+			t.Fatalf("cancel run: %v", err)
+		}
+		if _, err := s.ExtendLease(ctx, attempt.ID, leaseHash, time.Minute); err != nil {
+			t.Fatalf("extend lease: %v", err)
+		}
+		runs = append(runs, run)
+		attempts = append(attempts, attempt)
+	}
+	mustExec(t, dbConn, `UPDATE run_attempts SET cancel_ack_at = ? WHERE id = ?`, time.Now().Add(-time.Minute).UnixMilli(), attempts[0].ID)
+
+	// The lease rule leaves them alone: neither lease has expired.
+	if results, err := s.ReapExpiredAttempts(ctx, time.Now(), 10); err != nil || len(results) != 0 {
+		t.Fatalf("expected no expired leases, got %v (%v)", results, err)
+	}
+
+	results, err := s.ReapUnconfirmedCancels(ctx, time.Now(), 20*time.Second, 10)
+	if err != nil {
+		t.Fatalf("reap unconfirmed cancels: %v", err)
+	}
+	if len(results) != 1 || results[0].Outcome != "cancelled" {
+		t.Fatalf("expected one cancelled result, got %v", results)
+	}
+
+	assertAttemptStatus(t, dbConn, attempts[0].ID, "cancelled")
+	assertAttemptError(t, dbConn, attempts[0].ID, "runner did not confirm cancellation")
+	assertAttemptStatus(t, dbConn, attempts[1].ID, "cancelling")
+	for i, want := range []string{"cancelled", "cancelling"} {
+		loaded, err := s.GetRunByID(ctx, team.ID, runs[i].ID)
+		if err != nil {
+			t.Fatalf("get run: %v", err)
+		}
+		if loaded.Status != want {
+			t.Fatalf("run %d: expected %s, got %s", i, want, loaded.Status)
+		}
+	}
 }
 
 func TestLateResultAfterExpiryDoesNotResurrect(t *testing.T) {
@@ -188,7 +351,7 @@ func TestLateResultAfterExpiryDoesNotResurrect(t *testing.T) {
 	}
 
 	exitCode := 1
-	err = s.CompleteAttempt(ctx, attempt.ID, leaseHash, "failed", &exitCode, nil)
+	err = s.CompleteAttempt(ctx, attempt.ID, leaseHash, "failed", &exitCode, nil, "")
 	if !errors.Is(err, store.ErrAttemptNotActive) {
 		t.Fatalf("expected attempt not active, got %v", err)
 	}
@@ -202,6 +365,138 @@ func TestLateResultAfterExpiryDoesNotResurrect(t *testing.T) {
 	}
 }
 
+func TestDeadReasonPerPathway(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-dead-reason")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-dead-reason")
+	version := testutil.CreateVersion(t, s, app.ID)
+	runner, _ := testutil.CreateRunner(t, s, "runner-dead-reason", "default")
+
+	// expireAndReap expires the attempt and returns the single reap result.
+	expireAndReap := func(attemptID int64) store.ReapResult {
+		t.Helper()
+		expireAttempt(t, dbConn, attemptID, time.Now().Add(-2*time.Minute))
+		results, err := s.ReapExpiredAttempts(ctx, time.Now(), 10)
+		if err != nil {
+			t.Fatalf("reap attempts: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %+v", results)
+		}
+		return results[0]
+	}
+
+	// max_retries 0: the first expiry is fatal.
+	noRetry := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	_, attempt, _, _ := testutil.LeaseRun(t, s, runner)
+	if res := expireAndReap(attempt.ID); res.Outcome != "dead" || res.Reason != store.DeadReasonLeaseExpiredNoRetry {
+		t.Fatalf("expected dead lease_expired_no_retry, got %+v", res)
+	}
+	assertDeadReason(t, s, team.ID, noRetry.ID, store.DeadReasonLeaseExpiredNoRetry)
+	assertFailureKind(t, s, team.ID, noRetry.ID, store.FailureKindInfrastructure)
+
+	// Retries used up. The run keeps NULL while it is re-queued.
+	retried := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 1)
+	_, attempt, _, _ = testutil.LeaseRun(t, s, runner)
+	if res := expireAndReap(attempt.ID); res.Outcome != "retried" || res.Reason != "" {
+		t.Fatalf("expected retried without reason, got %+v", res)
+	}
+	assertDeadReason(t, s, team.ID, retried.ID, "")
+	_, attempt, _, _ = testutil.LeaseRun(t, s, runner)
+	if res := expireAndReap(attempt.ID); res.Outcome != "dead" || res.Reason != store.DeadReasonMaxRetriesExceeded {
+		t.Fatalf("expected dead max_retries_exceeded, got %+v", res)
+	}
+	assertDeadReason(t, s, team.ID, retried.ID, store.DeadReasonMaxRetriesExceeded)
+
+	// An at-most-once run that started is never retried.
+	atMostOnce, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, nil, 0, 2, true)
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	_, attempt, _, leaseHash := testutil.LeaseRun(t, s, runner)
+	if _, err := s.StartAttempt(ctx, attempt.ID, leaseHash); err != nil {
+		t.Fatalf("start attempt: %v", err)
+	}
+	if res := expireAndReap(attempt.ID); res.Outcome != "dead_at_most_once" || res.Reason != store.DeadReasonAtMostOnceViolation {
+		t.Fatalf("expected dead_at_most_once at_most_once_violation, got %+v", res)
+	}
+	assertDeadReason(t, s, team.ID, atMostOnce.ID, store.DeadReasonAtMostOnceViolation)
+	assertFailureKind(t, s, team.ID, atMostOnce.ID, store.FailureKindInfrastructure)
+
+	// A missing artifact kills the run with retries left.
+	missing := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 3)
+	_, attempt, _, leaseHash = testutil.LeaseRun(t, s, runner)
+	status, err := s.MarkAttemptDead(ctx, attempt.ID, leaseHash, store.DeadReasonArtifactUnavailable, "artifact unavailable")
+	if err != nil || status != "dead" {
+		t.Fatalf("mark attempt dead: %s, %v", status, err)
+	}
+	assertAttemptStatus(t, dbConn, attempt.ID, "failed")
+	assertAttemptError(t, dbConn, attempt.ID, "artifact unavailable")
+	assertDeadReason(t, s, team.ID, missing.ID, store.DeadReasonArtifactUnavailable)
+	assertFailureKind(t, s, team.ID, missing.ID, store.FailureKindInfrastructure)
+	if _, err := s.MarkAttemptDead(ctx, attempt.ID, leaseHash, store.DeadReasonArtifactUnavailable, "artifact unavailable"); !errors.Is(err, store.ErrAttemptNotActive) {
+		t.Fatalf("expected attempt not active on a second call, got %v", err)
+	}
+
+	// An unconfirmed cancel reports its reason but the run ends cancelled,
+	// so the column stays NULL.
+	cancelled := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	_, attempt, _, _ = testutil.LeaseRun(t, s, runner)
+	if _, err := s.CancelRun(ctx, team.ID, cancelled.ID); err != nil {
+		t.Fatalf("cancel run: %v", err)
+	}
+	if res := expireAndReap(attempt.ID); res.Outcome != "cancelled" || res.Reason != store.DeadReasonCancelUnacknowledged {
+		t.Fatalf("expected cancelled cancel_unacknowledged, got %+v", res)
+	}
+	assertDeadReason(t, s, team.ID, cancelled.ID, "")
+	assertFailureKind(t, s, team.ID, cancelled.ID, "")
+
+	// Runs that finish normally keep NULL.
+	completed := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	_, attempt, _, leaseHash = testutil.LeaseRun(t, s, runner)
+	if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, "failed", nil, nil, ""); err != nil {
+		t.Fatalf("complete attempt: %v", err)
+	}
+	assertDeadReason(t, s, team.ID, completed.ID, "")
+
+	var stored int
+	if err := dbConn.QueryRow(`SELECT COUNT(*) FROM runs WHERE status != 'dead' AND dead_reason IS NOT NULL`).Scan(&stored); err != nil {
+		t.Fatalf("count reasons: %v", err)
+	}
+	if stored != 0 {
+		t.Fatalf("expected dead_reason NULL on every non-dead run, found %d set", stored)
+	}
+}
+
+func assertDeadReason(t *testing.T, s *store.Store, teamID, runID int64, want string) {
+	t.Helper()
+	run, err := s.GetRunByID(context.Background(), teamID, runID)
+	if err != nil || run == nil {
+		t.Fatalf("get run %d: %v", runID, err)
+	}
+	if run.DeadReason != want {
+		t.Fatalf("run %d (%s): expected dead reason %q, got %q", runID, run.Status, want, run.DeadReason)
+	}
+}
+
+func assertFailureKind(t *testing.T, s *store.Store, teamID, runID int64, want string) {
+	t.Helper()
+	run, err := s.GetRunByID(context.Background(), teamID, runID)
+	if err != nil || run == nil {
+		t.Fatalf("get run %d: %v", runID, err)
+	}
+	if run.FailureKind != want {
+		t.Fatalf("run %d (%s): expected failure kind %q, got %q", runID, run.Status, want, run.FailureKind)
+	}
+}
+
 func expireAttempt(t *testing.T, dbConn *sql.DB, attemptID int64, at time.Time) {
 	t.Helper()
 	_, err := dbConn.ExecContext(context.Background(),
@@ -227,3 +522,167 @@ func assertAttemptStatus(t *testing.T, dbConn *sql.DB, attemptID int64, status s
 		t.Fatalf("expected attempt status %s, got %s", status, current)
 	}
 }
+
+func assertAttemptError(t *testing.T, dbConn *sql.DB, attemptID int64, want string) {
+	t.Helper()
+	var got sql.NullString
+	if err := dbConn.QueryRow(`SELECT error_message FROM run_attempts WHERE id = ?`, attemptID).Scan(&got); err != nil {
+		t.Fatalf("query attempt error: %v", err)
+	}
+	if got.String != want {
+		t.Fatalf("attempt %d: expected error %q, got %q", attemptID, want, got.String)
+	}
+}
+
+func TestOfflineRunnerAttemptsExpireInOneSweep(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-offline-expire")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-offline-expire")
+	version := testutil.CreateVersion(t, s, app.ID)
+	crashedRun := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 1, 1)
+	healthyRun := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 1)
+
+	crashed, _ := testutil.CreateRunner(t, s, "runner-crashed", "default")
+	_, crashedAttempt, _, crashedLease := testutil.LeaseRun(t, s, crashed)
+	healthy, _ := testutil.CreateRunner(t, s, "runner-healthy", "default")
+	_, healthyAttempt, _, _ := testutil.LeaseRun(t, s, healthy)
+
+	// The crashed runner's lease is still valid; only its last_seen_at is old.
+	tenMinutesAgo := time.Now().Add(-10 * time.Minute).UnixMilli()
+	mustExec(t, dbConn, `UPDATE runners SET last_seen_at = ? WHERE id = ?`, tenMinutesAgo, crashed.ID)
+
+	marked, results, err := s.ExpireAttemptsForOfflineRunners(ctx, time.Now().Add(-5*time.Minute))
+	if err != nil {
+		t.Fatalf("expire offline runners: %v", err)
+	}
+	if marked != 1 {
+		t.Fatalf("expected 1 runner marked offline, got %d", marked)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	if results[0].Outcome != "retried" || results[0].TeamID != team.ID || results[0].AppID != app.ID {
+		t.Fatalf("unexpected result %+v", results[0])
+	}
+
+	assertAttemptStatus(t, dbConn, crashedAttempt.ID, "expired")
+	loaded, err := s.GetRunByID(ctx, team.ID, crashedRun.ID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if loaded.Status != "queued" || loaded.RetryCount != 1 {
+		t.Fatalf("expected run queued with retry_count 1, got %s/%d", loaded.Status, loaded.RetryCount)
+	}
+
+	assertAttemptStatus(t, dbConn, healthyAttempt.ID, "leased")
+	loaded, err = s.GetRunByID(ctx, team.ID, healthyRun.ID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if loaded.Status != "leased" {
+		t.Fatalf("expected healthy run leased, got %s", loaded.Status)
+	}
+
+	// A runner that comes back stays fenced from its old attempt.
+	if _, err := s.ExtendLease(ctx, crashedAttempt.ID, crashedLease, time.Minute); !errors.Is(err, store.ErrInvalidLeaseToken) {
+		t.Fatalf("expected invalid lease token, got %v", err)
+	}
+
+	// The lease reaper finds nothing left to do.
+	results, err = s.ReapExpiredAttempts(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("reap attempts: %v", err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("expected no reaped attempts, got %d", len(results))
+	}
+}
+
+func TestReapUnstartedAttemptsRequeuesWithoutRetry(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-unstarted")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-unstarted")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 1)
+	runner, _ := testutil.CreateRunner(t, s, "runner-unstarted", "default")
+
+	const deadline = 15 * time.Second
+	_, attempt1, _, _ := testutil.LeaseRun(t, s, runner)
+	results, err := s.ReapUnstartedAttempts(ctx, time.Now(), deadline, 10)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("expected nothing reaped within the start deadline, got %+v (%v)", results, err)
+	}
+
+	// Well before the one-minute lease runs out, the never-started attempt
+	// is expired and the run re-queued without using its retry.
+	results, err = s.ReapUnstartedAttempts(ctx, time.Now().Add(20*time.Second), deadline, 10)
+	if err != nil {
+		t.Fatalf("reap unstarted attempts: %v", err)
+	}
+	if len(results) != 1 || results[0].Outcome != "requeued" || results[0].TeamID != team.ID {
+		t.Fatalf("expected one requeued result, got %+v", results)
+	}
+	assertAttemptStatus(t, dbConn, attempt1.ID, "expired")
+	assertAttemptError(t, dbConn, attempt1.ID, "runner did not start the attempt before the start deadline")
+	loaded, err := s.GetRunByID(ctx, team.ID, run.ID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if loaded.Status != "queued" || loaded.RetryCount != 0 {
+		t.Fatalf("expected a queued run with retry_count 0, got status %s retry_count %d", loaded.Status, loaded.RetryCount)
+	}
+	events, err := s.ListRunEvents(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	last := events[len(events)-1]
+	if last.Kind != store.RunEventRequeued || events[len(events)-2].Details["started_never"] != true {
+		t.Fatalf("expected attempt_expired with started_never then requeued, got %+v", events)
+	}
+
+	// A started attempt is left to the normal expiry path, which uses a
+	// retry.
+	_, attempt2, _, leaseHash := testutil.LeaseRun(t, s, runner)
+	if _, err := s.StartAttempt(ctx, attempt2.ID, leaseHash); err != nil {
+		t.Fatalf("start attempt: %v", err)
+	}
+	results, err = s.ReapUnstartedAttempts(ctx, time.Now().Add(20*time.Second), deadline, 10)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("expected a started attempt left alone, got %+v (%v)", results, err)
+	}
+	assertAttemptStatus(t, dbConn, attempt2.ID, "running")
+	expireAttempt(t, dbConn, attempt2.ID, time.Now().Add(-time.Minute))
+	results, err = s.ReapExpiredAttempts(ctx, time.Now(), 10)
+	if err != nil || len(results) != 1 || results[0].Outcome != "retried" {
+		t.Fatalf("expected one retried result, got %+v (%v)", results, err)
+	}
+	loaded, err = s.GetRunByID(ctx, team.ID, run.ID)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	if loaded.RetryCount != 1 {
+		t.Fatalf("expected retry_count 1, got %d", loaded.RetryCount)
+	}
+
+	attempts, err := s.ListRunAttempts(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("list attempts: %v", err)
+	}
+	if len(attempts) != 2 || !attempts[0].StartedNever || attempts[1].StartedNever {
+		t.Fatalf("expected only the first attempt marked started_never, got %+v", attempts)
+	}
+}
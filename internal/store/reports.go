@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Usage report groupings.
+const (
+	UsageGroupApp         = "app"
+	UsageGroupTeam        = "team"
+	UsageGroupEnvironment = "environment"
+)
+
+// UsageReportQuery selects the runs a usage report covers: those created in
+// [From, To), for one team or, with TeamID 0, for every team.
+type UsageReportQuery struct {
+	TeamID  int64
+	From    time.Time
+	To      time.Time
+	GroupBy string
+}
+
+// UsageRow aggregates the runs of one group. Execution time runs from a
+// run's first start to its finish, so it includes time spent between retry
+// attempts. Queue time runs from creation to first start, or to finish for
+// runs that ended without starting; runs still queued or running contribute
+// only their run count.
+type UsageRow struct {
+	Group                 string
+	Runs                  int64
+	Completed             int64
+	Failed                int64 // Failed and dead runs.
+	TotalExecutionSeconds float64
+	TotalQueueSeconds     float64
+}
+
+// usageGroupColumns maps each grouping to the label column and join that
+// produces it.
+var usageGroupColumns = map[string]struct{ label, join string }{
+	UsageGroupApp:         {"a.slug", "JOIN apps a ON a.id = r.app_id"},
+	UsageGroupTeam:        {"t.slug", "JOIN teams t ON t.id = r.team_id"},
+	UsageGroupEnvironment: {"e.name", "JOIN environments e ON e.id = r.environment_id"},
+}
+
+// GetUsageReport aggregates run counts and execution and queue time per
+// group in one grouped query, ordered by group label.
+func (s *Store) GetUsageReport(ctx context.Context, q UsageReportQuery) ([]UsageRow, error) {
+	cols, ok := usageGroupColumns[q.GroupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown usage grouping %q", q.GroupBy)
+	}
+
+	where := `r.created_at >= ? AND r.created_at < ?`
+	args := []any{q.From.UnixMilli(), q.To.UnixMilli()}
+	if q.TeamID != 0 {
+		where += ` AND r.team_id = ?`
+		args = append(args, q.TeamID)
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+cols.label+`,
+            COUNT(*),
+            COALESCE(SUM(CASE WHEN r.status = 'completed' THEN 1 ELSE 0 END), 0),
+            COALESCE(SUM(CASE WHEN r.status IN ('failed', 'dead') THEN 1 ELSE 0 END), 0),
+            COALESCE(SUM(CASE WHEN r.started_at IS NOT NULL AND r.finished_at IS NOT NULL
+                              THEN MAX(r.finished_at - r.started_at, 0) ELSE 0 END), 0),
+            COALESCE(SUM(CASE WHEN COALESCE(r.started_at, r.finished_at) IS NOT NULL
+                              THEN MAX(COALESCE(r.started_at, r.finished_at) - r.created_at, 0) ELSE 0 END), 0)
+     FROM runs r
+     `+cols.join+`
+     WHERE `+where+`
+     GROUP BY `+cols.label+`
+     ORDER BY `+cols.label+` ASC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []UsageRow
+	for rows.Next() {
+		var row UsageRow
+		var execMillis, queueMillis int64
+		if err := rows.Scan(&row.Group, &row.Runs, &row.Completed, &row.Failed, &execMillis, &queueMillis); err != nil {
+			return nil, err
+		}
+		row.TotalExecutionSeconds = float64(execMillis) / 1000
+		row.TotalQueueSeconds = float64(queueMillis) / 1000
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
@@ -0,0 +1,94 @@
+package store_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestGetUsageReport(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) int64 { return from.Add(d).UnixMilli() }
+
+	alpha, _ := testutil.CreateTeam(t, s, "team-usage-alpha")
+	alphaEnv, err := s.GetOrCreateDefaultEnvironment(ctx, alpha.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	etl := testutil.CreateApp(t, s, alpha.ID, "etl")
+	etlVer := testutil.CreateVersion(t, s, etl.ID)
+	report := testutil.CreateApp(t, s, alpha.ID, "report")
+	reportVer := testutil.CreateVersion(t, s, report.ID)
+
+	seed := func(teamID, appID, envID, verID int64, status string, created int64, started, finished any) {
+		t.Helper()
+		run := testutil.CreateRun(t, s, teamID, appID, envID, verID, 0, 0)
+		mustExec(t, dbConn, `UPDATE runs SET status = ?, created_at = ?, started_at = ?, finished_at = ? WHERE id = ?`,
+			status, created, started, finished, run.ID)
+	}
+	// etl: queued 10s, ran 60s; queued 5s, ran 30s and failed; cancelled
+	// after 20s in the queue without starting.
+	seed(alpha.ID, etl.ID, alphaEnv.ID, etlVer.ID, "completed", at(time.Hour), at(time.Hour+10*time.Second), at(time.Hour+70*time.Second))
+	seed(alpha.ID, etl.ID, alphaEnv.ID, etlVer.ID, "failed", at(2*time.Hour), at(2*time.Hour+5*time.Second), at(2*time.Hour+35*time.Second))
+	seed(alpha.ID, etl.ID, alphaEnv.ID, etlVer.ID, "cancelled", at(3*time.Hour), nil, at(3*time.Hour+20*time.Second))
+	// report: dead after 1.5s of running; one still queued.
+	seed(alpha.ID, report.ID, alphaEnv.ID, reportVer.ID, "dead", at(4*time.Hour), at(4*time.Hour+500*time.Millisecond), at(4*time.Hour+2*time.Second))
+	seed(alpha.ID, report.ID, alphaEnv.ID, reportVer.ID, "queued", at(5*time.Hour), nil, nil)
+	// Outside the range: before from and exactly at to.
+	seed(alpha.ID, etl.ID, alphaEnv.ID, etlVer.ID, "completed", at(-time.Hour), at(-time.Hour), at(0))
+	seed(alpha.ID, etl.ID, alphaEnv.ID, etlVer.ID, "completed", to.UnixMilli(), to.UnixMilli(), to.UnixMilli()+1000)
+
+	beta, _ := testutil.CreateTeam(t, s, "team-usage-beta")
+	betaEnv, err := s.GetOrCreateDefaultEnvironment(ctx, beta.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	betaApp := testutil.CreateApp(t, s, beta.ID, "etl")
+	betaVer := testutil.CreateVersion(t, s, betaApp.ID)
+	seed(beta.ID, betaApp.ID, betaEnv.ID, betaVer.ID, "completed", at(time.Hour), at(time.Hour+time.Second), at(time.Hour+4*time.Second))
+
+	rows, err := s.GetUsageReport(ctx, store.UsageReportQuery{TeamID: alpha.ID, From: from, To: to, GroupBy: store.UsageGroupApp})
+	if err != nil {
+		t.Fatalf("usage by app: %v", err)
+	}
+	want := []store.UsageRow{
+		{Group: "etl", Runs: 3, Completed: 1, Failed: 1, TotalExecutionSeconds: 90, TotalQueueSeconds: 35},
+		{Group: "report", Runs: 2, Completed: 0, Failed: 1, TotalExecutionSeconds: 1.5, TotalQueueSeconds: 0.5},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("usage by app = %+v, want %+v", rows, want)
+	}
+
+	rows, err = s.GetUsageReport(ctx, store.UsageReportQuery{From: from, To: to, GroupBy: store.UsageGroupTeam})
+	if err != nil {
+		t.Fatalf("usage by team: %v", err)
+	}
+	want = []store.UsageRow{
+		{Group: "team-usage-alpha", Runs: 5, Completed: 1, Failed: 2, TotalExecutionSeconds: 91.5, TotalQueueSeconds: 35.5},
+		{Group: "team-usage-beta", Runs: 1, Completed: 1, TotalExecutionSeconds: 3, TotalQueueSeconds: 1},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("usage by team = %+v, want %+v", rows, want)
+	}
+
+	rows, err = s.GetUsageReport(ctx, store.UsageReportQuery{TeamID: beta.ID, From: from, To: to, GroupBy: store.UsageGroupEnvironment})
+	if err != nil {
+		t.Fatalf("usage by environment: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Group != "default" || rows[0].Runs != 1 {
+		t.Fatalf("usage by environment = %+v", rows)
+	}
+
+	if _, err := s.GetUsageReport(ctx, store.UsageReportQuery{From: from, To: to, GroupBy: "version"}); err == nil {
+		t.Fatal("expected an error for an unknown grouping")
+	}
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"sort"
+)
+
+// CreateRerun creates a queued run from source with versionID and input. It
+// keeps the source's environment, command, priority, max_retries and
+// at_most_once, and records source as the run it was rerun from. It returns
+// a *QuotaExceededError when the team is at its max_active_runs.
+func (s *Store) CreateRerun(ctx context.Context, source *Run, versionID int64, input map[string]any) (*Run, error) {
+	rerunOf := source.ID
+	return s.createRun(ctx, source.TeamID, source.AppID, source.EnvironmentID, versionID, source.Command, input, source.Priority, source.MaxRetries, source.AtMostOnce, &rerunOf, "")
+}
+
+// MergeInputOverrides deep-merges overrides over input and returns the
+// result. Objects present on both sides are merged key by key, a null
+// override deletes the key, and any other override replaces the value.
+// Neither argument is modified.
+func MergeInputOverrides(input, overrides map[string]any) map[string]any {
+	if input == nil && len(overrides) == 0 {
+		return nil
+	}
+	merged := make(map[string]any, len(input)+len(overrides))
+	for k, v := range input {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		base, baseIsMap := merged[k].(map[string]any)
+		over, overIsMap := v.(map[string]any)
+		if baseIsMap && overIsMap {
+			merged[k] = MergeInputOverrides(base, over)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Input change kinds reported by DiffInput.
+const (
+	InputAdded   = "added"
+	InputRemoved = "removed"
+	InputChanged = "changed"
+)
+
+// InputChange is one difference between two run inputs. Path joins the keys
+// leading to the value with dots.
+type InputChange struct {
+	Path   string
+	Kind   string
+	Before any
+	After  any
+}
+
+// DiffInput returns the changes from before to after, sorted by path.
+// Objects on both sides are compared key by key; any other difference,
+// including a type change, is reported at the key where it occurs.
+func DiffInput(before, after map[string]any) []InputChange {
+	var changes []InputChange
+	diffInput("", before, after, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func diffInput(prefix string, before, after map[string]any, changes *[]InputChange) {
+	for k, b := range before {
+		path := prefix + k
+		a, ok := after[k]
+		if !ok {
+			*changes = append(*changes, InputChange{Path: path, Kind: InputRemoved, Before: b})
+			continue
+		}
+		bMap, bIsMap := b.(map[string]any)
+		aMap, aIsMap := a.(map[string]any)
+		switch {
+		case bIsMap && aIsMap:
+			diffInput(path+".", bMap, aMap, changes)
+		case !reflect.DeepEqual(b, a):
+			*changes = append(*changes, InputChange{Path: path, Kind: InputChanged, Before: b, After: a})
+		}
+	}
+	for k, a := range after {
+		if _, ok := before[k]; !ok {
+			*changes = append(*changes, InputChange{Path: prefix + k, Kind: InputAdded, After: a})
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package store_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestMergeInputOverridesDeepMergesAndDeletesNulls(t *testing.T) {
+	input := map[string]any{
+		"fix_mode": false,
+		"day":      "2026-01-01",
+		"opts":     map[string]any{"depth": float64(2), "dry": true},
+		"tags":     []any{"a"},
+	}
+	merged := store.MergeInputOverrides(input, map[string]any{
+		"fix_mode": true,
+		"day":      nil,
+		"opts":     map[string]any{"dry": nil, "limit": float64(5)},
+		"tags":     []any{"b"},
+	})
+	want := map[string]any{
+		"fix_mode": true,
+		"opts":     map[string]any{"depth": float64(2), "limit": float64(5)},
+		"tags":     []any{"b"},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Fatalf("expected %v, got %v", want, merged)
+	}
+	if input["fix_mode"] != false || len(input["opts"].(map[string]any)) != 2 {
+		t.Fatalf("expected the original input untouched, got %v", input)
+	}
+
+	if got := store.MergeInputOverrides(nil, nil); got != nil {
+		t.Fatalf("expected no input without overrides, got %v", got)
+	}
+}
+
+func TestDiffInputReportsChangesByPath(t *testing.T) {
+	before := map[string]any{
+		"fix_mode": false,
+		"day":      "2026-01-01",
+		"opts":     map[string]any{"depth": float64(2), "dry": true},
+	}
+	after := map[string]any{
+		"fix_mode": true,
+		"opts":     map[string]any{"depth": float64(2), "limit": float64(5)},
+		"extra":    "x",
+	}
+	want := []store.InputChange{
+		{Path: "day", Kind: store.InputRemoved, Before: "2026-01-01"},
+		{Path: "extra", Kind: store.InputAdded, After: "x"},
+		{Path: "fix_mode", Kind: store.InputChanged, Before: false, After: true},
+		{Path: "opts.dry", Kind: store.InputRemoved, Before: true},
+		{Path: "opts.limit", Kind: store.InputAdded, After: float64(5)},
+	}
+	if got := store.DiffInput(before, after); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	if got := store.DiffInput(before, before); len(got) != 0 {
+		t.Fatalf("expected no changes for equal inputs, got %+v", got)
+	}
+}
+
+func TestCreateRerunRecordsLineage(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-rerun")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "rerun-app")
+	v1 := testutil.CreateVersion(t, s, app.ID)
+	v2 := testutil.CreateVersion(t, s, app.ID)
+
+	original := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, v1.ID, 7, 2)
+	first, err := s.CreateRerun(ctx, original, v2.ID, map[string]any{"fix_mode": true})
+	if err != nil {
+		t.Fatalf("create rerun: %v", err)
+	}
+	second, err := s.CreateRerun(ctx, first, v2.ID, nil)
+	if err != nil {
+		t.Fatalf("create rerun of rerun: %v", err)
+	}
+
+	got, err := s.GetRunByID(ctx, team.ID, second.ID)
+	if err != nil || got == nil {
+		t.Fatalf("get rerun: %v", err)
+	}
+	if got.RerunOfRunID == nil || *got.RerunOfRunID != first.ID {
+		t.Fatalf("expected the rerun of a rerun to point at its source %d, got %v", first.ID, got.RerunOfRunID)
+	}
+	if got.AppVersionID != v2.ID || got.Priority != 7 || got.MaxRetries != 2 || got.EnvironmentID != env.ID {
+		t.Fatalf("expected the source's settings on the new version, got %+v", got)
+	}
+	got, err = s.GetRunByID(ctx, team.ID, first.ID)
+	if err != nil || got == nil {
+		t.Fatalf("get first rerun: %v", err)
+	}
+	if got.RerunOfRunID == nil || *got.RerunOfRunID != original.ID || got.Input["fix_mode"] != true {
+		t.Fatalf("expected the first rerun to point at the original with its input, got %+v", got)
+	}
+	got, err = s.GetRunByID(ctx, team.ID, original.ID)
+	if err != nil || got == nil {
+		t.Fatalf("get original: %v", err)
+	}
+	if got.RerunOfRunID != nil {
+		t.Fatalf("expected no lineage on the original, got %d", *got.RerunOfRunID)
+	}
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// InputLimits controls how run inputs are stored. An input whose JSON is
+// over CompressAbove bytes is stored gzip-compressed in runs.input_blob
+// instead of runs.input_json; 0 never compresses. An input still over
+// MaxStored bytes as stored is refused with an *InputTooLargeError; 0 is
+// unlimited.
+type InputLimits struct {
+	CompressAbove int
+	MaxStored     int
+}
+
+// DefaultInputLimits are the input limits of a new Store.
+var DefaultInputLimits = InputLimits{CompressAbove: 32 * 1024, MaxStored: 1024 * 1024}
+
+// InputTooLargeError is returned when a run input is over
+// InputLimits.MaxStored bytes as it would be stored.
+type InputTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *InputTooLargeError) Error() string {
+	return fmt.Sprintf("input is %d bytes stored, over the %d byte limit", e.Size, e.Limit)
+}
+
+// SetInputLimits sets how run inputs are stored.
+func (s *Store) SetInputLimits(limits InputLimits) {
+	s.inputs = limits
+}
+
+// InputLimits returns how run inputs are stored.
+func (s *Store) InputLimits() InputLimits {
+	return s.inputs
+}
+
+const (
+	// maxInputKeyValueBytes bounds one value in a compressed input's key
+	// projection; longer values are left out.
+	maxInputKeyValueBytes = 256
+	// maxInputKeysBytes bounds a compressed input's key projection.
+	maxInputKeysBytes = 8 * 1024
+)
+
+// storedInput is a run input as the runs columns hold it: input_json for
+// an input stored as is, or input_blob and input_keys_json for a compressed
+// one. All are nil for a nil input.
+type storedInput struct {
+	json *string
+	blob []byte
+	keys *string
+}
+
+// encodeInput returns input as it is stored.
+func (s *Store) encodeInput(input map[string]any) (storedInput, error) {
+	if input == nil {
+		return storedInput{}, nil
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return storedInput{}, err
+	}
+	if s.inputs.CompressAbove <= 0 || len(data) <= s.inputs.CompressAbove {
+		if s.inputs.MaxStored > 0 && len(data) > s.inputs.MaxStored {
+			return storedInput{}, &InputTooLargeError{Size: len(data), Limit: s.inputs.MaxStored}
+		}
+		str := string(data)
+		return storedInput{json: &str}, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return storedInput{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return storedInput{}, err
+	}
+	if s.inputs.MaxStored > 0 && buf.Len() > s.inputs.MaxStored {
+		return storedInput{}, &InputTooLargeError{Size: buf.Len(), Limit: s.inputs.MaxStored}
+	}
+	keys, err := inputKeys(data)
+	if err != nil {
+		return storedInput{}, err
+	}
+	return storedInput{blob: buf.Bytes(), keys: &keys}, nil
+}
+
+// inputKeys returns the runs.input_keys_json projection of a compressed
+// input's JSON: its top-level strings, numbers, booleans and nulls, which is
+// all input_contains compares. Values over maxInputKeyValueBytes are left
+// out, and keys are taken in order until the projection would pass
+// maxInputKeysBytes, so a huge input does not get a huge projection.
+func inputKeys(data []byte) (string, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(top))
+	for key := range top {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	scalars := make(map[string]json.RawMessage)
+	size := len("{}")
+	for _, key := range keys {
+		v := top[key]
+		if len(v) == 0 || v[0] == '{' || v[0] == '[' || len(v) > maxInputKeyValueBytes {
+			continue
+		}
+		// Quotes, colon and comma.
+		entry := len(key) + len(v) + 4
+		if size+entry > maxInputKeysBytes {
+			break
+		}
+		scalars[key] = v
+		size += entry
+	}
+	out, err := json.Marshal(scalars)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// FillMissingInputKeys records the input_keys_json projection of up to
+// limit compressed inputs stored without one, as those of runs created
+// before the column existed are, and returns how many it filled.
+func (s *Store) FillMissingInputKeys(ctx context.Context, limit int) (int, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, input_blob FROM runs
+     WHERE input_blob IS NOT NULL AND input_keys_json IS NULL
+     ORDER BY id ASC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	type missing struct {
+		id   int64
+		blob []byte
+	}
+	var runs []missing
+	for rows.Next() {
+		var m missing
+		if err := rows.Scan(&m.id, &m.blob); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		runs = append(runs, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, m := range runs {
+		// An unreadable input has nothing to match, and an empty projection
+		// keeps it from being read again.
+		keys := "{}"
+		if data, err := readInputBlob(m.blob); err == nil {
+			if k, err := inputKeys(data); err == nil {
+				keys = k
+			}
+		}
+		if _, err := s.exec(ctx, `UPDATE runs SET input_keys_json = ? WHERE id = ?`, keys, m.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(runs), nil
+}
+
+// readInputBlob decompresses a runs.input_blob value into the input's JSON.
+func readInputBlob(blob []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		return nil, fmt.Errorf("open input blob: %w", err)
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("read input blob: %w", err)
+	}
+	return data, nil
+}
+
+// decodeInputBlob decompresses a runs.input_blob value into the run input.
+func decodeInputBlob(blob []byte) (map[string]any, error) {
+	data, err := readInputBlob(blob)
+	if err != nil {
+		return nil, err
+	}
+	var input map[string]any
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
@@ -0,0 +1,260 @@
+package store_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+// largeInput returns an input of about size bytes of JSON, made of random
+// IDs so that it compresses about as well as real ones.
+func largeInput(t *testing.T, size int) map[string]any {
+	t.Helper()
+	ids := make([]any, 0, size/35)
+	for len(ids) < cap(ids) {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			t.Fatalf("random id: %v", err)
+		}
+		ids = append(ids, hex.EncodeToString(buf))
+	}
+	return map[string]any{"customer": "acme", "ids": ids}
+}
+
+func TestLargeRunInputIsStoredCompressed(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	ctx := context.Background()
+
+	team, _ := testutil.CreateTeam(t, s, "team-large-input")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "large-input")
+	version := testutil.CreateVersion(t, s, app.ID)
+
+	input := largeInput(t, 500*1024)
+	large, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, input, 0, 0, false)
+	if err != nil {
+		t.Fatalf("create large run: %v", err)
+	}
+	small, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, map[string]any{"customer": "acme"}, 0, 0, false)
+	if err != nil {
+		t.Fatalf("create small run: %v", err)
+	}
+
+	var jsonNull bool
+	var blobSize int
+	if err := dbConn.QueryRowContext(ctx, `SELECT input_json IS NULL, COALESCE(length(input_blob), 0) FROM runs WHERE id = ?`, large.ID).Scan(&jsonNull, &blobSize); err != nil {
+		t.Fatalf("read stored input: %v", err)
+	}
+	if !jsonNull || blobSize == 0 || blobSize > 400*1024 {
+		t.Fatalf("expected a compressed input only, got input_json null %v and a %d byte blob", jsonNull, blobSize)
+	}
+
+	// Inputs come back as JSON decodes them, so compare with a decoded copy.
+	want := map[string]any{"customer": "acme", "ids": input["ids"]}
+	for name, get := range map[string]func() (*store.Run, error){
+		"by id":        func() (*store.Run, error) { return s.GetRunByID(ctx, team.ID, large.ID) },
+		"by id direct": func() (*store.Run, error) { return s.GetRunByIDDirect(ctx, large.ID) },
+	} {
+		run, err := get()
+		if err != nil || run == nil {
+			t.Fatalf("get run %s: %v", name, err)
+		}
+		if run.InputOmitted || !reflect.DeepEqual(run.Input, want) {
+			t.Fatalf("get run %s: expected the full input back", name)
+		}
+	}
+
+	for _, includeInput := range []bool{false, true} {
+		listed, err := s.ListRuns(ctx, store.RunListFilter{TeamID: team.ID, IncludeInput: includeInput, Limit: 10})
+		if err != nil {
+			t.Fatalf("list runs: %v", err)
+		}
+		byApp, err := s.ListRunsByApp(ctx, team.ID, app.ID, 10, 0, 0, includeInput)
+		if err != nil {
+			t.Fatalf("list runs by app: %v", err)
+		}
+		for name, runs := range map[string][]*store.Run{"ListRuns": listed, "ListRunsByApp": byApp} {
+			if len(runs) != 2 {
+				t.Fatalf("%s: expected 2 runs, got %d", name, len(runs))
+			}
+			for _, run := range runs {
+				label := fmt.Sprintf("%s include input %v, run %d", name, includeInput, run.ID)
+				switch {
+				case run.ID == small.ID && (run.InputOmitted || run.Input["customer"] != "acme"):
+					t.Fatalf("%s: expected the uncompressed input listed, got %v", label, run.Input)
+				case run.ID == large.ID && includeInput && (run.InputOmitted || !reflect.DeepEqual(run.Input, want)):
+					t.Fatalf("%s: expected the compressed input decoded", label)
+				case run.ID == large.ID && !includeInput && (!run.InputOmitted || run.Input != nil):
+					t.Fatalf("%s: expected the compressed input omitted", label)
+				}
+			}
+		}
+	}
+}
+
+func TestRunInputOverStoredLimitIsRejected(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	ctx := context.Background()
+
+	team, _ := testutil.CreateTeam(t, s, "team-input-limit")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "input-limit")
+	version := testutil.CreateVersion(t, s, app.ID)
+
+	if got := s.InputLimits(); got != store.DefaultInputLimits {
+		t.Fatalf("expected the default input limits, got %+v", got)
+	}
+	s.SetInputLimits(store.InputLimits{CompressAbove: 1024, MaxStored: 16 * 1024})
+
+	_, err = s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, largeInput(t, 64*1024), 0, 0, false)
+	var sizeErr *store.InputTooLargeError
+	if !errors.As(err, &sizeErr) || sizeErr.Limit != 16*1024 || sizeErr.Size <= sizeErr.Limit {
+		t.Fatalf("expected an InputTooLargeError, got %v", err)
+	}
+
+	_, err = s.CreateRunBatch(ctx, store.RunBatchSpec{
+		TeamID: team.ID, AppID: app.ID, EnvironmentID: env.ID, Version: version,
+		Inputs: []map[string]any{{"n": 1}, largeInput(t, 64*1024)},
+	})
+	var batchErr *store.BatchInputError
+	if !errors.As(err, &batchErr) || len(batchErr.Items) != 1 || batchErr.Items[0].Index != 1 {
+		t.Fatalf("expected the oversized batch input rejected, got %v", err)
+	}
+
+	// Without compression the limit applies to the JSON itself.
+	s.SetInputLimits(store.InputLimits{MaxStored: 1024})
+	if _, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, largeInput(t, 2048), 0, 0, false); !errors.As(err, &sizeErr) {
+		t.Fatalf("expected an InputTooLargeError without compression, got %v", err)
+	}
+	if _, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, map[string]any{"n": 1}, 0, 0, false); err != nil {
+		t.Fatalf("create small run: %v", err)
+	}
+}
+
+func TestInputContainsMatchesCompressedInputs(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+	ctx := context.Background()
+
+	team, _ := testutil.CreateTeam(t, s, "team-input-match")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "input-match")
+	version := testutil.CreateVersion(t, s, app.ID)
+	s.SetInputLimits(store.InputLimits{CompressAbove: 1024})
+
+	create := func(input map[string]any) int64 {
+		t.Helper()
+		run, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, input, 0, 0, false)
+		if err != nil {
+			t.Fatalf("create run: %v", err)
+		}
+		return run.ID
+	}
+	large := func(customer string, tier int) map[string]any {
+		input := largeInput(t, 4096)
+		input["customer"] = customer
+		input["tier"] = tier
+		input["note"] = strings.Repeat("n", 300)
+		return input
+	}
+	// Created oldest first, so listed in reverse.
+	smallAcme := create(map[string]any{"customer": "acme", "tier": 2})
+	create(large("globex", 2))
+	largeAcme := create(large("acme", 2))
+	create(map[string]any{"customer": "globex", "tier": 2})
+	largeAcmeTier1 := create(large("acme", 1))
+
+	list := func(f store.RunListFilter) []*store.Run {
+		t.Helper()
+		f.TeamID = team.ID
+		runs, err := s.ListRuns(ctx, f)
+		if err != nil {
+			t.Fatalf("list runs: %v", err)
+		}
+		return runs
+	}
+	ids := func(runs []*store.Run) []int64 {
+		out := make([]int64, 0, len(runs))
+		for _, r := range runs {
+			out = append(out, r.ID)
+		}
+		return out
+	}
+
+	acme := map[string]any{"customer": "acme"}
+	if got, want := ids(list(store.RunListFilter{InputContains: acme, Limit: 10})), []int64{largeAcmeTier1, largeAcme, smallAcme}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	tier2 := map[string]any{"customer": "acme", "tier": json.Number("2")}
+	if got, want := ids(list(store.RunListFilter{InputContains: tier2, Limit: 10})), []int64{largeAcme, smallAcme}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	// Paging counts matches only.
+	var paged []int64
+	for offset := 0; offset < 4; offset++ {
+		paged = append(paged, ids(list(store.RunListFilter{InputContains: acme, Limit: 1, Offset: offset}))...)
+	}
+	if want := []int64{largeAcmeTier1, largeAcme, smallAcme}; !reflect.DeepEqual(paged, want) {
+		t.Fatalf("expected pages %v, got %v", want, paged)
+	}
+
+	// A compressed match is still left out of the list without IncludeInput.
+	for _, includeInput := range []bool{false, true} {
+		runs := list(store.RunListFilter{InputContains: tier2, IncludeInput: includeInput, Limit: 10})
+		if len(runs) != 2 || runs[0].ID != largeAcme {
+			t.Fatalf("include input %v: expected the compressed match first, got %v", includeInput, ids(runs))
+		}
+		if got := runs[0]; got.InputOmitted == includeInput || (got.Input != nil) != includeInput {
+			t.Fatalf("include input %v: got input omitted %v", includeInput, got.InputOmitted)
+		}
+		if includeInput && runs[0].Input["customer"] != "acme" {
+			t.Fatalf("expected the decoded input, got customer %v", runs[0].Input["customer"])
+		}
+	}
+
+	// Values too long for the projection, and non-scalars, do not match.
+	for _, filter := range []map[string]any{{"note": strings.Repeat("n", 300)}, {"customer": "acme", "ids": nil}} {
+		if runs := list(store.RunListFilter{InputContains: filter, Limit: 10}); len(runs) != 0 {
+			t.Fatalf("expected no match for %v, got %v", filter, ids(runs))
+		}
+	}
+
+	// Runs compressed before the projection existed get it from the
+	// backfill.
+	if _, err := dbConn.ExecContext(ctx, `UPDATE runs SET input_keys_json = NULL`); err != nil {
+		t.Fatalf("clear input keys: %v", err)
+	}
+	if got, want := ids(list(store.RunListFilter{InputContains: acme, Limit: 10})), []int64{smallAcme}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected only the uncompressed match without projections, got %v", got)
+	}
+	if n, err := s.FillMissingInputKeys(ctx, 2); err != nil || n != 2 {
+		t.Fatalf("expected 2 projections filled, got %d (%v)", n, err)
+	}
+	if n, err := s.FillMissingInputKeys(ctx, 2); err != nil || n != 1 {
+		t.Fatalf("expected the last projection filled, got %d (%v)", n, err)
+	}
+	if got, want := ids(list(store.RunListFilter{InputContains: acme, Limit: 10})), []int64{largeAcmeTier1, largeAcme, smallAcme}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v after the backfill, got %v", want, got)
+	}
+}
@@ -3,7 +3,10 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -13,8 +16,21 @@ var (
 	ErrLeaseConflict     = errors.New("lease conflict")
 	ErrInvalidLeaseToken = errors.New("invalid lease token")
 	ErrAttemptNotActive  = errors.New("attempt not active")
+	ErrRunnerNameTaken   = errors.New("runner name taken")
 )
 
+// ArtifactUnavailableError is returned by LeaseRun when the run it picked
+// has lost its artifact object. The run has been marked dead with
+// DeadReasonArtifactUnavailable without an attempt; lease again for the next.
+type ArtifactUnavailableError struct {
+	RunID int64
+	Key   string
+}
+
+func (e *ArtifactUnavailableError) Error() string {
+	return fmt.Sprintf("run %d: artifact %s unavailable", e.RunID, e.Key)
+}
+
 type Runner struct {
 	ID            int64
 	Name          string
@@ -24,10 +40,21 @@ type Runner struct {
 	Status        string
 	MaxConcurrent int
 	LastSeenAt    *time.Time
+	Stats         *RunnerStats // Populated by ListRunners.
+	StatsAt       *time.Time   // Populated by ListRunners.
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 }
 
+// RunnerStats is the latest host resource snapshot reported by a runner.
+type RunnerStats struct {
+	CPUPercent    *float64 `json:"cpu_percent,omitempty"`
+	MemUsedBytes  *int64   `json:"mem_used_bytes,omitempty"`
+	MemTotalBytes *int64   `json:"mem_total_bytes,omitempty"`
+	DiskFreeBytes *int64   `json:"disk_free_bytes,omitempty"`
+	Load1         *float64 `json:"load1,omitempty"`
+}
+
 type RunAttempt struct {
 	ID             int64
 	RunID          int64
@@ -42,17 +69,62 @@ type RunAttempt struct {
 	FinishedAt     *time.Time
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
+	// CancelAckAt is when a heartbeat first reported the run's cancellation
+	// to the runner.
+	CancelAckAt *time.Time
+	// StartedNever is set on an attempt the reaper expired because its
+	// runner never called start within the start deadline.
+	StartedNever bool
 }
 
-// CreateRunner registers a new runner.
+// RunnerLabelPython is the runner label listing the Python versions the
+// runner has interpreters for, comma-separated ("3.10,3.12").
+const RunnerLabelPython = "python"
+
+// runnerCanRunVersion holds for a runner rn and version v when v needs no
+// Python version or rn's python label lists it.
+const runnerCanRunVersion = `(v.python_version IS NULL OR
+       instr(',' || COALESCE(json_extract(rn.labels_json, '$.` + RunnerLabelPython + `'), '') || ',', ',' || v.python_version || ',') > 0)`
+
+// PythonVersions returns the Python versions in the runner's python label.
+func (r *Runner) PythonVersions() []string {
+	if r.Labels[RunnerLabelPython] == "" {
+		return nil
+	}
+	return strings.Split(r.Labels[RunnerLabelPython], ",")
+}
+
+// SetRunnerLabels replaces a runner's labels; nil or empty clears them.
+func (s *Store) SetRunnerLabels(ctx context.Context, runnerID int64, labels map[string]string) error {
+	var labelsJSON *string
+	if len(labels) > 0 {
+		data, err := json.Marshal(labels)
+		if err != nil {
+			return err
+		}
+		str := string(data)
+		labelsJSON = &str
+	}
+	_, err := s.exec(ctx,
+		`UPDATE runners SET labels_json = ?, updated_at = ? WHERE id = ?`,
+		labelsJSON, time.Now().UnixMilli(), runnerID,
+	)
+	return err
+}
+
+// CreateRunner registers a new runner. It returns ErrRunnerNameTaken if a
+// runner with that name exists.
 func (s *Store) CreateRunner(ctx context.Context, name, environment, tokenHash string) (*Runner, error) {
 	now := time.Now().UnixMilli()
 
-	result, err := s.db.ExecContext(ctx,
+	result, err := s.exec(ctx,
 		`INSERT INTO runners (name, environment, token_hash, status, max_concurrent, last_seen_at, created_at, updated_at)
      VALUES (?, ?, ?, 'online', 1, ?, ?, ?)`,
 		name, environment, tokenHash, now, now, now,
 	)
+	if isUniqueViolation(err) && strings.Contains(err.Error(), "runners.name") {
+		return nil, ErrRunnerNameTaken
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -76,16 +148,63 @@ func (s *Store) CreateRunner(ctx context.Context, name, environment, tokenHash s
 	}, nil
 }
 
-// RefreshRunnerRegistration re-issues a runner token and marks the runner online.
-func (s *Store) RefreshRunnerRegistration(ctx context.Context, runnerID int64, environment, tokenHash string) error {
+// RefreshRunnerRegistration re-issues a runner token and marks the runner
+// online. Attempts still active under the previous registration belong to a
+// process that can no longer authenticate, so their leases are expired and
+// reaped immediately rather than blocking the runner until they time out.
+func (s *Store) RefreshRunnerRegistration(ctx context.Context, runnerID int64, environment, tokenHash string) ([]ReapResult, error) {
 	now := time.Now().UnixMilli()
-	_, err := s.db.ExecContext(ctx,
-		`UPDATE runners
-		 SET environment = ?, token_hash = ?, status = 'online', last_seen_at = ?, updated_at = ?
-		 WHERE id = ?`,
-		environment, tokenHash, now, now, runnerID,
-	)
-	return err
+
+	var attemptIDs []int64
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE runners
+			 SET environment = ?, token_hash = ?, status = 'online', last_seen_at = ?, updated_at = ?
+			 WHERE id = ?`,
+			environment, tokenHash, now, now, runnerID,
+		)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx,
+			`SELECT id FROM run_attempts
+			 WHERE runner_id = ? AND status IN ('leased', 'running', 'cancelling')`,
+			runnerID,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			attemptIDs = append(attemptIDs, id)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range attemptIDs {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE run_attempts SET lease_expires_at = ?, updated_at = ? WHERE id = ?`,
+				now, now, id,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.reapAttempts(ctx, attemptIDs, now, false)
 }
 
 // GetRunnerByTokenHash finds a runner by token hash.
@@ -138,15 +257,65 @@ func (s *Store) GetRunnerByName(ctx context.Context, name string) (*Runner, erro
 	return &r, nil
 }
 
-// ListRunners returns all runners, ordered by name.
-func (s *Store) ListRunners(ctx context.Context) ([]*Runner, error) {
+// RunnerFilter selects runners for ListRunnersFiltered. Zero fields match
+// every runner.
+type RunnerFilter struct {
+	Status      string
+	Environment string
+	NamePrefix  string
+	// StaleBefore matches runners last seen before it, judged as the offline
+	// sweep does when a runner has never been seen.
+	StaleBefore time.Time
+	// Limit caps the page size; 0 returns every matching runner.
+	Limit  int
+	Offset int
+}
+
+// ListRunnersFiltered returns one page of the runners matching f, most
+// recently seen first, and the number of runners matching f in total.
+func (s *Store) ListRunnersFiltered(ctx context.Context, f RunnerFilter) ([]*Runner, int64, error) {
+	var conds []string
+	var args []any
+	if f.Status != "" {
+		conds = append(conds, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Environment != "" {
+		conds = append(conds, "environment = ?")
+		args = append(args, f.Environment)
+	}
+	if f.NamePrefix != "" {
+		conds = append(conds, "substr(name, 1, length(?)) = ?")
+		args = append(args, f.NamePrefix, f.NamePrefix)
+	}
+	if !f.StaleBefore.IsZero() {
+		conds = append(conds, "COALESCE(last_seen_at, updated_at, created_at) < ?")
+		args = append(args, f.StaleBefore.UnixMilli())
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	var total int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM runners`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, name, environment, token_hash, status, max_concurrent, last_seen_at, created_at, updated_at
-	     FROM runners
-	     ORDER BY name ASC`,
+		`SELECT id, name, environment, labels_json, token_hash, status, max_concurrent, last_seen_at, stats_json, stats_at, created_at, updated_at
+	     FROM runners`+where+`
+	     ORDER BY last_seen_at IS NULL, last_seen_at DESC, name ASC
+	     LIMIT ? OFFSET ?`,
+		append(args, limit, f.Offset)...,
 	)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -154,9 +323,15 @@ func (s *Store) ListRunners(ctx context.Context) ([]*Runner, error) {
 	for rows.Next() {
 		var r Runner
 		var createdAt, updatedAt int64
-		var lastSeenAt sql.NullInt64
-		if err := rows.Scan(&r.ID, &r.Name, &r.Environment, &r.TokenHash, &r.Status, &r.MaxConcurrent, &lastSeenAt, &createdAt, &updatedAt); err != nil {
-			return nil, err
+		var lastSeenAt, statsAt sql.NullInt64
+		var labelsJSON, statsJSON sql.NullString
+		if err := rows.Scan(&r.ID, &r.Name, &r.Environment, &labelsJSON, &r.TokenHash, &r.Status, &r.MaxConcurrent, &lastSeenAt, &statsJSON, &statsAt, &createdAt, &updatedAt); err != nil {
+			return nil, 0, err
+		}
+		if labelsJSON.Valid {
+			if err := json.Unmarshal([]byte(labelsJSON.String), &r.Labels); err != nil {
+				return nil, 0, err
+			}
 		}
 		r.CreatedAt = time.UnixMilli(createdAt)
 		r.UpdatedAt = time.UnixMilli(updatedAt)
@@ -164,121 +339,208 @@ func (s *Store) ListRunners(ctx context.Context) ([]*Runner, error) {
 			t := time.UnixMilli(lastSeenAt.Int64)
 			r.LastSeenAt = &t
 		}
+		if statsJSON.Valid {
+			var stats RunnerStats
+			if err := json.Unmarshal([]byte(statsJSON.String), &stats); err != nil {
+				return nil, 0, err
+			}
+			r.Stats = &stats
+		}
+		if statsAt.Valid {
+			t := time.UnixMilli(statsAt.Int64)
+			r.StatsAt = &t
+		}
 		runners = append(runners, &r)
 	}
 
-	return runners, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return runners, total, nil
+}
+
+// UpdateRunnerStats stores the latest resource snapshot for a runner,
+// replacing any previous one.
+func (s *Store) UpdateRunnerStats(ctx context.Context, runnerID int64, stats *RunnerStats) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	_, err = s.exec(ctx,
+		`UPDATE runners SET stats_json = ?, stats_at = ?, updated_at = ? WHERE id = ?`,
+		string(data), now, now, runnerID,
+	)
+	return err
 }
 
 // UpdateRunnerLastSeen updates the runner's last seen timestamp.
 func (s *Store) UpdateRunnerLastSeen(ctx context.Context, runnerID int64) error {
 	now := time.Now().UnixMilli()
-	_, err := s.db.ExecContext(ctx,
+	_, err := s.exec(ctx,
 		`UPDATE runners SET last_seen_at = ?, updated_at = ? WHERE id = ?`,
 		now, now, runnerID,
 	)
 	return err
 }
 
+// PriorityAging raises a queued run's effective priority by one for every
+// Interval it has waited since it was queued, by at most Cap. A zero
+// Interval disables aging.
+type PriorityAging struct {
+	Interval time.Duration
+	Cap      int
+}
+
+// Effective returns the priority LeaseRun orders a queued run by at now.
+func (a PriorityAging) Effective(priority int, queuedAt, now time.Time) int {
+	if a.Interval <= 0 {
+		return priority
+	}
+	waited := now.Sub(queuedAt)
+	if waited <= 0 {
+		return priority
+	}
+	return priority + int(min(int64(waited/a.Interval), int64(a.Cap)))
+}
+
+// leaseOrder returns the ORDER BY clause and its arguments for picking the
+// next queued run. Without aging it is the plain priority order the lease
+// index serves; with aging the effective priority mirrors Effective, and
+// ties still fall back to queue time then ID so the pick stays
+// deterministic.
+func (a PriorityAging) leaseOrder(nowMs int64) (string, []any) {
+	if a.Interval <= 0 {
+		return `ORDER BY r.priority DESC, r.queued_at ASC, r.id ASC`, nil
+	}
+	return `ORDER BY r.priority + MIN(MAX(? - r.queued_at, 0) / ?, ?) DESC, r.queued_at ASC, r.id ASC`,
+		[]any{nowMs, a.Interval.Milliseconds(), a.Cap}
+}
+
 // LeaseRun attempts to lease a queued run for a runner.
 // Returns the run, new attempt, and lease token, or ErrNoRunAvailable.
+// With an artifact check set, a run whose artifact is gone is ended instead
+// and an *ArtifactUnavailableError returned.
 func (s *Store) LeaseRun(ctx context.Context, runner *Runner, leaseTokenHash string, leaseTTL time.Duration) (*Run, *RunAttempt, error) {
 	now := time.Now()
 	nowMs := now.UnixMilli()
 	leaseExpiresAt := now.Add(leaseTTL).UnixMilli()
 
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer tx.Rollback()
-
-	// Check runner has no active attempt
-	var activeCount int
-	err = tx.QueryRowContext(ctx,
-		`SELECT COUNT(*) FROM run_attempts WHERE runner_id = ? AND status IN ('leased', 'running', 'cancelling')`,
-		runner.ID,
-	).Scan(&activeCount)
-	if err != nil {
-		return nil, nil, err
-	}
-	if activeCount > 0 {
-		return nil, nil, ErrLeaseConflict
-	}
+	var runID, attemptID, attemptNo int64
+	var unavailable *ArtifactUnavailableError
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		// Check runner has no active attempt
+		var activeCount int
+		err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM run_attempts WHERE runner_id = ? AND status IN ('leased', 'running', 'cancelling')`,
+			runner.ID,
+		).Scan(&activeCount)
+		if err != nil {
+			return err
+		}
+		if activeCount > 0 {
+			return ErrLeaseConflict
+		}
 
-	// Find next queued run matching this runner's environment label
-	var runID int64
-	err = tx.QueryRowContext(ctx,
-		`SELECT r.id FROM runs r
+		// Find next queued run matching this runner's environment label
+		// whose version's Python version, if any, the runner has.
+		order, orderArgs := s.aging.leaseOrder(nowMs)
+		var artifactKey string
+		err = tx.QueryRowContext(ctx,
+			`SELECT r.id, v.artifact_object_key FROM runs r
      JOIN environments e ON r.environment_id = e.id
+     JOIN app_versions v ON v.id = r.app_version_id
+     JOIN runners rn ON rn.id = ?
      WHERE e.name = ? AND r.status = 'queued' AND r.cancel_requested = 0
-     ORDER BY r.priority DESC, r.queued_at ASC, r.id ASC
+       AND `+runnerCanRunVersion+`
+     `+order+`
      LIMIT 1`,
-		runner.Environment,
-	).Scan(&runID)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil, ErrNoRunAvailable
-	}
-	if err != nil {
-		return nil, nil, err
-	}
+			append([]any{runner.ID, runner.Environment}, orderArgs...)...,
+		).Scan(&runID, &artifactKey)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRunAvailable
+		}
+		if err != nil {
+			return err
+		}
 
-	// CAS update run status from queued to leased
-	result, err := tx.ExecContext(ctx,
-		`UPDATE runs SET status = 'leased', updated_at = ?
+		// A run whose artifact is gone could only fail its attempts, so it
+		// ends here before one is created.
+		if s.artifactExists != nil && !s.artifactExists(artifactKey) {
+			unavailable = &ArtifactUnavailableError{RunID: runID, Key: artifactKey}
+			return markQueuedRunArtifactUnavailable(ctx, tx, runID, nowMs)
+		}
+
+		// CAS update run status from queued to leased
+		result, err := tx.ExecContext(ctx,
+			`UPDATE runs SET status = 'leased', updated_at = ?
      WHERE id = ? AND status = 'queued' AND cancel_requested = 0`,
-		nowMs, runID,
-	)
-	if err != nil {
-		return nil, nil, err
-	}
-	affected, err := result.RowsAffected()
-	if err != nil {
-		return nil, nil, err
-	}
-	if affected == 0 {
-		return nil, nil, ErrLeaseConflict
-	}
+			nowMs, runID,
+		)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrLeaseConflict
+		}
 
-	// Get next attempt number
-	var maxAttemptNo sql.NullInt64
-	err = tx.QueryRowContext(ctx,
-		`SELECT MAX(attempt_no) FROM run_attempts WHERE run_id = ?`,
-		runID,
-	).Scan(&maxAttemptNo)
-	if err != nil {
-		return nil, nil, err
-	}
-	attemptNo := int64(1)
-	if maxAttemptNo.Valid {
-		attemptNo = maxAttemptNo.Int64 + 1
-	}
+		// Get next attempt number
+		var maxAttemptNo sql.NullInt64
+		err = tx.QueryRowContext(ctx,
+			`SELECT MAX(attempt_no) FROM run_attempts WHERE run_id = ?`,
+			runID,
+		).Scan(&maxAttemptNo)
+		if err != nil {
+			return err
+		}
+		attemptNo = 1
+		if maxAttemptNo.Valid {
+			attemptNo = maxAttemptNo.Int64 + 1
+		}
 
-	// Create attempt
-	attemptResult, err := tx.ExecContext(ctx,
-		`INSERT INTO run_attempts (run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, created_at, updated_at)
+		// Create attempt
+		attemptResult, err := tx.ExecContext(ctx,
+			`INSERT INTO run_attempts (run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, created_at, updated_at)
      VALUES (?, ?, ?, ?, ?, 'leased', ?, ?)`,
-		runID, attemptNo, runner.ID, leaseTokenHash, leaseExpiresAt, nowMs, nowMs,
-	)
-	if err != nil {
-		return nil, nil, err
-	}
-	attemptID, err := attemptResult.LastInsertId()
-	if err != nil {
-		return nil, nil, err
-	}
+			runID, attemptNo, runner.ID, leaseTokenHash, leaseExpiresAt, nowMs, nowMs,
+		)
+		if err != nil {
+			return err
+		}
+		attemptID, err = attemptResult.LastInsertId()
+		if err != nil {
+			return err
+		}
 
-	// Update runner last seen
-	_, err = tx.ExecContext(ctx,
-		`UPDATE runners SET last_seen_at = ?, updated_at = ? WHERE id = ?`,
-		nowMs, nowMs, runner.ID,
-	)
+		err = appendRunEvent(ctx, tx, runID, RunEventLeased, map[string]any{
+			"runner":     runner.Name,
+			"runner_id":  runner.ID,
+			"attempt_no": attemptNo,
+		}, nowMs)
+		if err != nil {
+			return err
+		}
+
+		// Update runner last seen
+		_, err = tx.ExecContext(ctx,
+			`UPDATE runners SET last_seen_at = ?, updated_at = ? WHERE id = ?`,
+			nowMs, nowMs, runner.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
 	if err != nil {
 		return nil, nil, err
 	}
-
-	if err := tx.Commit(); err != nil {
-		return nil, nil, err
+	if unavailable != nil {
+		return nil, nil, unavailable
 	}
 
 	// Fetch the leased run
@@ -302,12 +564,12 @@ func (s *Store) LeaseRun(ctx context.Context, runner *Runner, leaseTokenHash str
 	return run, attempt, nil
 }
 
-// scanAttempt scans a *sql.Row into a *RunAttempt, handling UnixMilli conversions and nullable times.
-func scanAttempt(row *sql.Row) (*RunAttempt, error) {
+// scanAttempt scans a row into a *RunAttempt, handling UnixMilli conversions and nullable times.
+func scanAttempt(row interface{ Scan(...any) error }) (*RunAttempt, error) {
 	var a RunAttempt
 	var leaseExpiresAt, createdAt, updatedAt int64
-	var startedAt, finishedAt sql.NullInt64
-	err := row.Scan(&a.ID, &a.RunID, &a.AttemptNo, &a.RunnerID, &a.LeaseTokenHash, &leaseExpiresAt, &a.Status, &a.ExitCode, &a.ErrorMessage, &startedAt, &finishedAt, &createdAt, &updatedAt)
+	var startedAt, finishedAt, cancelAckAt sql.NullInt64
+	err := row.Scan(&a.ID, &a.RunID, &a.AttemptNo, &a.RunnerID, &a.LeaseTokenHash, &leaseExpiresAt, &a.Status, &a.ExitCode, &a.ErrorMessage, &startedAt, &finishedAt, &createdAt, &updatedAt, &cancelAckAt, &a.StartedNever)
 	if err != nil {
 		return nil, err
 	}
@@ -322,6 +584,10 @@ func scanAttempt(row *sql.Row) (*RunAttempt, error) {
 		t := time.UnixMilli(finishedAt.Int64)
 		a.FinishedAt = &t
 	}
+	if cancelAckAt.Valid {
+		t := time.UnixMilli(cancelAckAt.Int64)
+		a.CancelAckAt = &t
+	}
 	return &a, nil
 }
 
@@ -329,7 +595,7 @@ func scanAttempt(row *sql.Row) (*RunAttempt, error) {
 // runner ownership and lease token.
 func (s *Store) GetActiveAttempt(ctx context.Context, runID, runnerID int64, leaseTokenHash string) (*RunAttempt, error) {
 	a, err := scanAttempt(s.db.QueryRowContext(ctx,
-		`SELECT id, run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, exit_code, error_message, started_at, finished_at, created_at, updated_at
+		`SELECT id, run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, exit_code, error_message, started_at, finished_at, created_at, updated_at, cancel_ack_at, started_never
      FROM run_attempts
      WHERE run_id = ? AND runner_id = ? AND lease_token_hash = ? AND status IN ('leased', 'running', 'cancelling')`,
 		runID, runnerID, leaseTokenHash,
@@ -340,21 +606,51 @@ func (s *Store) GetActiveAttempt(ctx context.Context, runID, runnerID int64, lea
 	return a, err
 }
 
+// GetLatestAttempt returns the run's most recent attempt, or nil, nil if it
+// has never been leased.
+func (s *Store) GetLatestAttempt(ctx context.Context, runID int64) (*RunAttempt, error) {
+	a, err := scanAttempt(s.db.QueryRowContext(ctx,
+		`SELECT id, run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, exit_code, error_message, started_at, finished_at, created_at, updated_at, cancel_ack_at, started_never
+     FROM run_attempts
+     WHERE run_id = ?
+     ORDER BY attempt_no DESC LIMIT 1`,
+		runID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return a, err
+}
+
 // StartAttempt transitions an attempt from leased to running.
 func (s *Store) StartAttempt(ctx context.Context, attemptID int64, leaseTokenHash string) (*RunAttempt, error) {
 	now := time.Now().UnixMilli()
 	shouldMarkRunRunning := true
 
 	// CAS update: leased -> running
-	result, err := s.db.ExecContext(ctx,
-		`UPDATE run_attempts SET status = 'running', started_at = ?, updated_at = ?
+	var affected int64
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE run_attempts SET status = 'running', started_at = ?, updated_at = ?
      WHERE id = ? AND lease_token_hash = ? AND status = 'leased'`,
-		now, now, attemptID, leaseTokenHash,
-	)
-	if err != nil {
-		return nil, err
-	}
-	affected, err := result.RowsAffected()
+			now, now, attemptID, leaseTokenHash,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, err = result.RowsAffected(); err != nil || affected == 0 {
+			return err
+		}
+
+		var runID, attemptNo int64
+		if err := tx.QueryRowContext(ctx,
+			`SELECT run_id, attempt_no FROM run_attempts WHERE id = ?`,
+			attemptID,
+		).Scan(&runID, &attemptNo); err != nil {
+			return err
+		}
+		return appendRunEvent(ctx, tx, runID, RunEventStarted, map[string]any{"attempt_no": attemptNo}, now)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -391,7 +687,7 @@ func (s *Store) StartAttempt(ctx context.Context, attemptID int64, leaseTokenHas
 	// Update run status to running only when the attempt is in running state.
 	// Do not override cancelling status during a cancel/start race.
 	if shouldMarkRunRunning {
-		_, err = s.db.ExecContext(ctx,
+		_, err = s.exec(ctx,
 			`UPDATE runs SET status = 'running', started_at = COALESCE(started_at, ?), updated_at = ?
 	     WHERE id = (SELECT run_id FROM run_attempts WHERE id = ?)
 	       AND status IN ('queued', 'leased', 'running')`,
@@ -404,47 +700,74 @@ func (s *Store) StartAttempt(ctx context.Context, attemptID int64, leaseTokenHas
 
 	// Return updated attempt
 	return scanAttempt(s.db.QueryRowContext(ctx,
-		`SELECT id, run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, exit_code, error_message, started_at, finished_at, created_at, updated_at
+		`SELECT id, run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, exit_code, error_message, started_at, finished_at, created_at, updated_at, cancel_ack_at, started_never
      FROM run_attempts WHERE id = ?`,
 		attemptID,
 	))
 }
 
-// ExtendLease extends the lease expiry time (heartbeat).
+// ExtendLease extends the lease expiry time (heartbeat). The first heartbeat
+// after the run's cancellation records cancel_ack_at, since its response is
+// what tells the runner to stop.
 func (s *Store) ExtendLease(ctx context.Context, attemptID int64, leaseTokenHash string, leaseTTL time.Duration) (*RunAttempt, error) {
 	now := time.Now()
 	nowMs := now.UnixMilli()
 	newExpiry := now.Add(leaseTTL).UnixMilli()
 
-	result, err := s.db.ExecContext(ctx,
-		`UPDATE run_attempts SET lease_expires_at = ?, updated_at = ?
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		// updated_at is the attempt's last sign of life before this
+		// heartbeat: the lease, the start or the previous heartbeat.
+		var runID, attemptNo, lastSeen, runnerID int64
+		err := tx.QueryRowContext(ctx,
+			`SELECT run_id, attempt_no, updated_at, runner_id FROM run_attempts
      WHERE id = ? AND lease_token_hash = ? AND status IN ('leased', 'running', 'cancelling')`,
-		newExpiry, nowMs, attemptID, leaseTokenHash,
-	)
-	if err != nil {
-		return nil, err
-	}
-	affected, err := result.RowsAffected()
-	if err != nil {
-		return nil, err
-	}
-	if affected == 0 {
-		return nil, ErrInvalidLeaseToken
-	}
+			attemptID, leaseTokenHash,
+		).Scan(&runID, &attemptNo, &lastSeen, &runnerID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidLeaseToken
+		}
+		if err != nil {
+			return err
+		}
 
-	// Update runner last seen
-	_, err = s.db.ExecContext(ctx,
-		`UPDATE runners SET last_seen_at = ?, updated_at = ?
-     WHERE id = (SELECT runner_id FROM run_attempts WHERE id = ?)`,
-		nowMs, nowMs, attemptID,
-	)
+		_, err = tx.ExecContext(ctx,
+			`UPDATE run_attempts SET lease_expires_at = ?, updated_at = ?,
+       cancel_ack_at = CASE
+         WHEN cancel_ack_at IS NULL AND (SELECT cancel_requested FROM runs WHERE id = run_attempts.run_id) = 1 THEN ?
+         ELSE cancel_ack_at
+       END
+     WHERE id = ?`,
+			newExpiry, nowMs, nowMs, attemptID,
+		)
+		if err != nil {
+			return err
+		}
+
+		// A gap of over half the lease TTL came close to losing the lease.
+		if gap := nowMs - lastSeen; gap > leaseTTL.Milliseconds()/2 {
+			err = appendRunEvent(ctx, tx, runID, RunEventHeartbeatLate, map[string]any{
+				"attempt_no": attemptNo,
+				"gap_ms":     gap,
+			}, nowMs)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Update runner last seen
+		_, err = tx.ExecContext(ctx,
+			`UPDATE runners SET last_seen_at = ?, updated_at = ? WHERE id = ?`,
+			nowMs, nowMs, runnerID,
+		)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	// Return updated attempt
 	return scanAttempt(s.db.QueryRowContext(ctx,
-		`SELECT id, run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, exit_code, error_message, started_at, finished_at, created_at, updated_at
+		`SELECT id, run_id, attempt_no, runner_id, lease_token_hash, lease_expires_at, status, exit_code, error_message, started_at, finished_at, created_at, updated_at, cancel_ack_at, started_never
      FROM run_attempts WHERE id = ?`,
 		attemptID,
 	))
@@ -452,31 +775,51 @@ func (s *Store) ExtendLease(ctx context.Context, attemptID int64, leaseTokenHash
 
 // AppendLogs appends log entries for an attempt.
 func (s *Store) AppendLogs(ctx context.Context, attemptID int64, logs []LogEntry) error {
-	if len(logs) == 0 {
-		return nil
-	}
-
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+	_, err := s.AppendLogsCounted(ctx, attemptID, logs)
+	return err
+}
 
-	stmt, err := tx.PrepareContext(ctx,
-		`INSERT OR IGNORE INTO run_logs (run_attempt_id, seq, stream, line, logged_at) VALUES (?, ?, ?, ?, ?)`)
-	if err != nil {
-		return err
+// AppendLogsCounted appends log entries for an attempt and returns how many
+// were new. The rest repeat a seq the attempt already has and are ignored.
+func (s *Store) AppendLogsCounted(ctx context.Context, attemptID int64, logs []LogEntry) (int, error) {
+	if len(logs) == 0 {
+		return 0, nil
 	}
-	defer stmt.Close()
 
-	for _, l := range logs {
-		_, err := stmt.ExecContext(ctx, attemptID, l.Seq, l.Stream, l.Line, l.LoggedAt.UnixMilli())
+	var inserted int
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		inserted = 0
+		stmt, err := tx.PrepareContext(ctx,
+			`INSERT OR IGNORE INTO run_logs (run_attempt_id, seq, stream, line, logged_at) VALUES (?, ?, ?, ?, ?)`)
 		if err != nil {
 			return err
 		}
-	}
+		defer stmt.Close()
+
+		for _, l := range logs {
+			res, err := stmt.ExecContext(ctx, attemptID, l.Seq, l.Stream, l.Line, l.LoggedAt.UnixMilli())
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			inserted += int(n)
+		}
+
+		return nil
+	})
+	return inserted, err
+}
 
-	return tx.Commit()
+// CountAttemptLogs returns how many log lines an attempt has.
+func (s *Store) CountAttemptLogs(ctx context.Context, attemptID int64) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM run_logs WHERE run_attempt_id = ?`, attemptID,
+	).Scan(&n)
+	return n, err
 }
 
 type LogEntry struct {
@@ -486,51 +829,172 @@ type LogEntry struct {
 	LoggedAt time.Time
 }
 
-// CompleteAttempt finalizes an attempt with a result.
-func (s *Store) CompleteAttempt(ctx context.Context, attemptID int64, leaseTokenHash string, status string, exitCode *int, errorMessage *string) error {
+// CompleteAttempt finalizes an attempt with a result. failureKind, one of
+// the FailureKind constants or empty, is recorded on the run for failed
+// results and ignored for others.
+func (s *Store) CompleteAttempt(ctx context.Context, attemptID int64, leaseTokenHash string, status string, exitCode *int, errorMessage *string, failureKind string) error {
 	now := time.Now().UnixMilli()
 
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+	return s.write(ctx, func(tx *sql.Tx) error {
+		// Get current attempt state
+		var currentStatus string
+		var runID, attemptNo int64
+		err := tx.QueryRowContext(ctx,
+			`SELECT status, run_id, attempt_no FROM run_attempts WHERE id = ? AND lease_token_hash = ?`,
+			attemptID, leaseTokenHash,
+		).Scan(&currentStatus, &runID, &attemptNo)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidLeaseToken
+		}
+		if err != nil {
+			return err
+		}
 
-	// Get current attempt state
-	var currentStatus string
-	var runID int64
-	err = tx.QueryRowContext(ctx,
-		`SELECT status, run_id FROM run_attempts WHERE id = ? AND lease_token_hash = ?`,
-		attemptID, leaseTokenHash,
-	).Scan(&currentStatus, &runID)
-	if errors.Is(err, sql.ErrNoRows) {
-		return ErrInvalidLeaseToken
-	}
-	if err != nil {
-		return err
-	}
+		// Check attempt is active or idempotent terminal
+		if currentStatus != "leased" && currentStatus != "running" && currentStatus != "cancelling" {
+			if currentStatus == "expired" {
+				return ErrAttemptNotActive
+			}
+			if currentStatus == status {
+				return nil // Idempotent terminal result
+			}
+			return ErrLeaseConflict
+		}
+
+		// If cancelling, only allow cancelled status
+		if currentStatus == "cancelling" && status != "cancelled" {
+			return ErrLeaseConflict
+		}
+
+		// Update attempt
+		result, err := tx.ExecContext(ctx,
+			`UPDATE run_attempts SET status = ?, exit_code = ?, error_message = ?, finished_at = ?, updated_at = ?
+     WHERE id = ? AND lease_token_hash = ? AND status IN ('leased', 'running', 'cancelling')`,
+			status, exitCode, errorMessage, now, now, attemptID, leaseTokenHash,
+		)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			// Check if already completed with same status (idempotent)
+			var finalStatus string
+			err := tx.QueryRowContext(ctx,
+				`SELECT status FROM run_attempts WHERE id = ? AND lease_token_hash = ?`,
+				attemptID, leaseTokenHash,
+			).Scan(&finalStatus)
+			if err != nil {
+				return ErrInvalidLeaseToken
+			}
+			if finalStatus == status {
+				return nil // Idempotent
+			}
+			return ErrLeaseConflict
+		}
+
+		// Update run status
+		var kind any
+		if status == "failed" && failureKind != "" {
+			kind = failureKind
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE runs SET status = ?, failure_kind = ?, finished_at = ?, updated_at = ? WHERE id = ?`,
+			status, kind, now, now, runID,
+		)
+		if err != nil {
+			return err
+		}
+
+		details := map[string]any{"status": status, "attempt_no": attemptNo}
+		if exitCode != nil {
+			details["exit_code"] = *exitCode
+		}
+		if errorMessage != nil {
+			details["error"] = *errorMessage
+		}
+		if kind != nil {
+			details["failure_kind"] = failureKind
+		}
+		return appendRunEvent(ctx, tx, runID, RunEventFinished, details, now)
+	})
+}
+
+// MarkAttemptDead fails an active attempt with errorMessage and marks its
+// run dead with reason, for failures a retry would only repeat. A run whose
+// cancellation was requested is cancelled instead. Returns the run's new
+// status.
+func (s *Store) MarkAttemptDead(ctx context.Context, attemptID int64, leaseTokenHash, reason, errorMessage string) (string, error) {
+	now := time.Now().UnixMilli()
+
+	var runStatus string
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		var runID, attemptNo int64
+		var cancelRequested int
+		err := tx.QueryRowContext(ctx,
+			`SELECT a.run_id, a.attempt_no, r.cancel_requested
+     FROM run_attempts a
+     JOIN runs r ON r.id = a.run_id
+     WHERE a.id = ? AND a.lease_token_hash = ?`,
+			attemptID, leaseTokenHash,
+		).Scan(&runID, &attemptNo, &cancelRequested)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidLeaseToken
+		}
+		if err != nil {
+			return err
+		}
 
-	// Check attempt is active or idempotent terminal
-	if currentStatus != "leased" && currentStatus != "running" && currentStatus != "cancelling" {
-		if currentStatus == "expired" {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE run_attempts SET status = 'failed', error_message = ?, finished_at = ?, updated_at = ?
+     WHERE id = ? AND status IN ('leased', 'running', 'cancelling')`,
+			errorMessage, now, now, attemptID,
+		)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
 			return ErrAttemptNotActive
 		}
-		if currentStatus == status {
-			return nil // Idempotent terminal result
+
+		runStatus = "dead"
+		var deadReason, failureKind any = reason, FailureKindInfrastructure
+		if cancelRequested == 1 {
+			runStatus, deadReason, failureKind = "cancelled", nil, nil
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE runs SET status = ?, dead_reason = ?, failure_kind = ?, finished_at = ?, updated_at = ? WHERE id = ?`,
+			runStatus, deadReason, failureKind, now, now, runID,
+		); err != nil {
+			return err
 		}
-		return ErrLeaseConflict
-	}
 
-	// If cancelling, only allow cancelled status
-	if currentStatus == "cancelling" && status != "cancelled" {
-		return ErrLeaseConflict
+		details := map[string]any{"status": runStatus, "attempt_no": attemptNo, "error": errorMessage}
+		if deadReason != nil {
+			details["dead_reason"] = reason
+			details["failure_kind"] = FailureKindInfrastructure
+		}
+		return appendRunEvent(ctx, tx, runID, RunEventFinished, details, now)
+	})
+	if err != nil {
+		return "", err
 	}
+	return runStatus, nil
+}
 
-	// Update attempt
+// markQueuedRunArtifactUnavailable marks a queued run dead with
+// DeadReasonArtifactUnavailable.
+func markQueuedRunArtifactUnavailable(ctx context.Context, tx *sql.Tx, runID, nowMs int64) error {
 	result, err := tx.ExecContext(ctx,
-		`UPDATE run_attempts SET status = ?, exit_code = ?, error_message = ?, finished_at = ?, updated_at = ?
-     WHERE id = ? AND lease_token_hash = ? AND status IN ('leased', 'running', 'cancelling')`,
-		status, exitCode, errorMessage, now, now, attemptID, leaseTokenHash,
+		`UPDATE runs SET status = 'dead', dead_reason = ?, failure_kind = ?, finished_at = ?, updated_at = ?
+     WHERE id = ? AND status = 'queued'`,
+		DeadReasonArtifactUnavailable, FailureKindInfrastructure, nowMs, nowMs, runID,
 	)
 	if err != nil {
 		return err
@@ -540,31 +1004,14 @@ func (s *Store) CompleteAttempt(ctx context.Context, attemptID int64, leaseToken
 		return err
 	}
 	if affected == 0 {
-		// Check if already completed with same status (idempotent)
-		var finalStatus string
-		err := tx.QueryRowContext(ctx,
-			`SELECT status FROM run_attempts WHERE id = ? AND lease_token_hash = ?`,
-			attemptID, leaseTokenHash,
-		).Scan(&finalStatus)
-		if err != nil {
-			return ErrInvalidLeaseToken
-		}
-		if finalStatus == status {
-			return nil // Idempotent
-		}
 		return ErrLeaseConflict
 	}
-
-	// Update run status
-	_, err = tx.ExecContext(ctx,
-		`UPDATE runs SET status = ?, finished_at = ?, updated_at = ? WHERE id = ?`,
-		status, now, now, runID,
-	)
-	if err != nil {
-		return err
-	}
-
-	return tx.Commit()
+	return appendRunEvent(ctx, tx, runID, RunEventFinished, map[string]any{
+		"status":       "dead",
+		"error":        "artifact unavailable",
+		"dead_reason":  DeadReasonArtifactUnavailable,
+		"failure_kind": FailureKindInfrastructure,
+	}, nowMs)
 }
 
 // GetRunWithCancelStatus returns a run with its cancel_requested flag.
@@ -580,25 +1027,12 @@ func (s *Store) GetRunWithCancelStatus(ctx context.Context, runID int64) (cancel
 	return cr == 1, nil
 }
 
-// MarkStaleRunnersOffline marks runners as offline if they haven't been seen since the threshold.
+// MarkStaleRunnersOffline marks runners as offline if they haven't been seen since the threshold,
+// expiring their active attempts as ExpireAttemptsForOfflineRunners does.
 // Returns the number of runners marked offline.
 func (s *Store) MarkStaleRunnersOffline(ctx context.Context, threshold time.Time) (int, error) {
-	thresholdMs := threshold.UnixMilli()
-	nowMs := time.Now().UnixMilli()
-
-	result, err := s.db.ExecContext(ctx,
-		`UPDATE runners SET status = 'offline', updated_at = ?
-     WHERE status = 'online' AND COALESCE(last_seen_at, updated_at, created_at) < ?`,
-		nowMs, thresholdMs,
-	)
-	if err != nil {
-		return 0, err
-	}
-	affected, err := result.RowsAffected()
-	if err != nil {
-		return 0, err
-	}
-	return int(affected), nil
+	marked, _, err := s.ExpireAttemptsForOfflineRunners(ctx, threshold)
+	return marked, err
 }
 
 // PruneOfflineRunners deletes offline runners older than the cutoff.
@@ -606,7 +1040,7 @@ func (s *Store) MarkStaleRunnersOffline(ctx context.Context, threshold time.Time
 func (s *Store) PruneOfflineRunners(ctx context.Context, cutoff time.Time) (int, error) {
 	cutoffMs := cutoff.UnixMilli()
 
-	result, err := s.db.ExecContext(ctx,
+	result, err := s.exec(ctx,
 		`DELETE FROM runners
      WHERE id IN (
        SELECT r.id
@@ -632,7 +1066,7 @@ func (s *Store) PruneOfflineRunners(ctx context.Context, cutoff time.Time) (int,
 // MarkRunnerOnline marks a runner as online.
 func (s *Store) MarkRunnerOnline(ctx context.Context, runnerID int64) error {
 	nowMs := time.Now().UnixMilli()
-	_, err := s.db.ExecContext(ctx,
+	_, err := s.exec(ctx,
 		`UPDATE runners SET status = 'online', last_seen_at = ?, updated_at = ?
      WHERE id = ?`,
 		nowMs, nowMs, runnerID,
@@ -2,32 +2,88 @@ package store
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 )
 
+// ErrRunNotQueued is returned when a change is only allowed while a run is queued.
+var ErrRunNotQueued = errors.New("run not queued")
+
 type Run struct {
 	ID              int64
 	TeamID          int64
 	AppID           int64
-	AppSlug         string // Populated by ListRunsByTeam.
+	AppSlug         string // Populated by ListRuns.
 	EnvironmentID   int64
 	AppVersionID    int64
 	RunNo           int64
-	VersionNo       int64 // Populated by ListRunsByApp (joined from app_versions)
+	VersionNo       int64  // Populated by ListRunsByApp (joined from app_versions)
+	Entrypoint      string // Version entrypoint; populated by ListRunsByApp and ListRuns.
 	Input           map[string]any
 	Status          string
 	Priority        int
 	MaxRetries      int
 	RetryCount      int
 	CancelRequested bool
-	QueuedAt        time.Time
-	StartedAt       *time.Time
-	FinishedAt      *time.Time
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	// AtMostOnce runs are marked dead rather than re-queued when a lease
+	// expires after the process started.
+	AtMostOnce bool
+	// TraceID correlates the run across server and runner logs.
+	TraceID string
+	// BatchID is set for runs created through CreateRunBatch.
+	BatchID string
+	// TeamActiveRuns is the team's non-terminal runs, this one included,
+	// when it was created. Populated by CreateRun and CreateCommandRun.
+	TeamActiveRuns int64
+	// Command names the version command the run executes; empty runs the
+	// version's entrypoint.
+	Command string
+	// RerunOfRunID is the run this one was rerun from, if any.
+	RerunOfRunID *int64
+	// DeadReason says why a dead run died, one of the DeadReason constants;
+	// empty for runs in any other status.
+	DeadReason string
+	// FailureKind says whose fault a failed or dead run was, one of the
+	// FailureKind constants; empty for other runs and for failures whose
+	// runner did not classify them.
+	FailureKind string
+	// InputOmitted is set by ListRuns and ListRunsByApp for a compressed
+	// input they were not asked to decode; Input is nil then.
+	InputOmitted bool
+	// TeamSlug is populated by ListRuns when it lists every team.
+	TeamSlug string
+	// RunnerName is the runner of the latest attempt; populated by ListRuns
+	// with IncludeRunner, and empty for runs never leased.
+	RunnerName string
+	QueuedAt   time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Failure kinds, stored in runs.failure_kind, say whose fault a failed or
+// dead run was.
+const (
+	// FailureKindInfrastructure: the runner or server failed the run, e.g.
+	// the artifact download, venv or dependency install failed, or the
+	// lease expired.
+	FailureKindInfrastructure = "infrastructure"
+	// FailureKindUser: the app failed the run, e.g. its process exited
+	// non-zero or timed out.
+	FailureKindUser = "user"
+)
+
+// ValidFailureKind reports whether kind is a failure kind.
+func ValidFailureKind(kind string) bool {
+	return kind == FailureKindInfrastructure || kind == FailureKindUser
 }
 
 type RunLog struct {
@@ -46,57 +102,53 @@ type RunSummary struct {
 	TerminalRuns int64
 }
 
-// CreateRun creates a new run in queued state.
-func (s *Store) CreateRun(ctx context.Context, teamID, appID, envID, versionID int64, input map[string]any, priority, maxRetries int) (*Run, error) {
-	now := time.Now().UnixMilli()
-
-	var inputJSON *string
-	if input != nil {
-		data, err := json.Marshal(input)
-		if err != nil {
-			return nil, err
-		}
-		s := string(data)
-		inputJSON = &s
+// newTraceID returns a random 32-character hex run trace ID.
+func newTraceID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(buf), nil
+}
 
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, err
-	}
-	defer tx.Rollback()
+// CreateRun creates a new run of the version's entrypoint in queued state.
+func (s *Store) CreateRun(ctx context.Context, teamID, appID, envID, versionID int64, input map[string]any, priority, maxRetries int, atMostOnce bool) (*Run, error) {
+	return s.CreateCommandRun(ctx, teamID, appID, envID, versionID, "", input, priority, maxRetries, atMostOnce)
+}
 
-	// Get next run number for this app (inside transaction to prevent duplicates)
-	var maxRunNo sql.NullInt64
-	err = tx.QueryRowContext(ctx,
-		`SELECT MAX(run_no) FROM runs WHERE app_id = ?`,
-		appID,
-	).Scan(&maxRunNo)
-	if err != nil {
-		return nil, err
-	}
+// CreateCommandRun creates a new run of one of the version's named commands
+// in queued state. An empty command runs the version's entrypoint. It returns
+// a *QuotaExceededError when the team is at its max_active_runs.
+func (s *Store) CreateCommandRun(ctx context.Context, teamID, appID, envID, versionID int64, command string, input map[string]any, priority, maxRetries int, atMostOnce bool) (*Run, error) {
+	return s.createRun(ctx, teamID, appID, envID, versionID, command, input, priority, maxRetries, atMostOnce, nil, "")
+}
 
-	runNo := int64(1)
-	if maxRunNo.Valid {
-		runNo = maxRunNo.Int64 + 1
-	}
+// CreateCommandRunWithStatusToken is CreateCommandRun that also records a
+// status token for the run, by its hash, in the same transaction.
+func (s *Store) CreateCommandRunWithStatusToken(ctx context.Context, teamID, appID, envID, versionID int64, command string, input map[string]any, priority, maxRetries int, atMostOnce bool, statusTokenHash string) (*Run, error) {
+	return s.createRun(ctx, teamID, appID, envID, versionID, command, input, priority, maxRetries, atMostOnce, nil, statusTokenHash)
+}
 
-	result, err := tx.ExecContext(ctx,
-		`INSERT INTO runs (team_id, app_id, environment_id, app_version_id, run_no, input_json, status, priority, max_retries, retry_count, cancel_requested, queued_at, created_at, updated_at)
-     VALUES (?, ?, ?, ?, ?, ?, 'queued', ?, ?, 0, 0, ?, ?, ?)`,
-		teamID, appID, envID, versionID, runNo, inputJSON, priority, maxRetries, now, now, now,
-	)
-	if err != nil {
-		return nil, err
-	}
+// createRun creates a queued run, recording rerunOf when it is a rerun and
+// statusTokenHash when not empty.
+func (s *Store) createRun(ctx context.Context, teamID, appID, envID, versionID int64, command string, input map[string]any, priority, maxRetries int, atMostOnce bool, rerunOf *int64, statusTokenHash string) (*Run, error) {
+	now := time.Now().UnixMilli()
 
-	id, err := result.LastInsertId()
+	stored, err := s.encodeInput(input)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return nil, err
+	var id, runNo, active int64
+	var traceID string
+	for attempt := 1; ; attempt++ {
+		id, runNo, active, traceID, err = s.insertRun(ctx, teamID, appID, envID, versionID, command, stored, priority, maxRetries, atMostOnce, rerunOf, statusTokenHash, now)
+		if err == nil {
+			break
+		}
+		if !isUniqueViolation(err) || attempt == maxRunNoAttempts {
+			return nil, err
+		}
 	}
 
 	queuedAt := time.UnixMilli(now)
@@ -113,72 +165,129 @@ func (s *Store) CreateRun(ctx context.Context, teamID, appID, envID, versionID i
 		MaxRetries:      maxRetries,
 		RetryCount:      0,
 		CancelRequested: false,
+		AtMostOnce:      atMostOnce,
+		TraceID:         traceID,
+		Command:         command,
+		RerunOfRunID:    rerunOf,
+		TeamActiveRuns:  active + 1,
 		QueuedAt:        queuedAt,
 		CreatedAt:       queuedAt,
 		UpdatedAt:       queuedAt,
 	}, nil
 }
 
-// GetRunByID returns a run by ID (scoped to team).
-func (s *Store) GetRunByID(ctx context.Context, teamID, runID int64) (*Run, error) {
-	var r Run
-	var inputJSON sql.NullString
-	var queuedAt, createdAt, updatedAt int64
-	var startedAt, finishedAt sql.NullInt64
-	var cancelRequested int
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, team_id, app_id, environment_id, app_version_id, run_no, input_json, status, priority, max_retries, retry_count, cancel_requested, queued_at, started_at, finished_at, created_at, updated_at
-     FROM runs WHERE team_id = ? AND id = ?`,
-		teamID, runID,
-	).Scan(&r.ID, &r.TeamID, &r.AppID, &r.EnvironmentID, &r.AppVersionID, &r.RunNo, &inputJSON, &r.Status, &r.Priority, &r.MaxRetries, &r.RetryCount, &cancelRequested, &queuedAt, &startedAt, &finishedAt, &createdAt, &updatedAt)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
+// maxRunNoAttempts bounds how often run creation allocates run numbers again
+// after losing a race for them.
+const maxRunNoAttempts = 5
+
+// insertRun inserts a queued run numbered one past the app's latest run. A
+// writer that commits a run for the same app between the read and the insert
+// makes the insert fail the (app_id, run_no) unique index, and the caller
+// allocates again. active is the team's non-terminal runs before the insert.
+func (s *Store) insertRun(ctx context.Context, teamID, appID, envID, versionID int64, command string, input storedInput, priority, maxRetries int, atMostOnce bool, rerunOf *int64, statusTokenHash string, now int64) (id, runNo, active int64, traceID string, err error) {
+	err = s.write(ctx, func(tx *sql.Tx) error {
+		active, err = reserveActiveRuns(ctx, tx, teamID, 1)
+		if err != nil {
+			return err
+		}
 
-	r.CancelRequested = cancelRequested == 1
-	r.QueuedAt = time.UnixMilli(queuedAt)
-	r.CreatedAt = time.UnixMilli(createdAt)
-	r.UpdatedAt = time.UnixMilli(updatedAt)
-	if startedAt.Valid {
-		t := time.UnixMilli(startedAt.Int64)
-		r.StartedAt = &t
-	}
-	if finishedAt.Valid {
-		t := time.UnixMilli(finishedAt.Int64)
-		r.FinishedAt = &t
-	}
-	if inputJSON.Valid {
-		if err := json.Unmarshal([]byte(inputJSON.String), &r.Input); err != nil {
-			return nil, err
+		// ORDER BY ... LIMIT 1 walks the (app_id, run_no) unique index backwards
+		// and stops at the first row.
+		runNo = 1
+		var lastRunNo int64
+		err = tx.QueryRowContext(ctx,
+			`SELECT run_no FROM runs WHERE app_id = ? ORDER BY run_no DESC LIMIT 1`,
+			appID,
+		).Scan(&lastRunNo)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return err
+		default:
+			runNo = lastRunNo + 1
+		}
+
+		traceID, err = newTraceID()
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx,
+			`INSERT INTO runs (team_id, app_id, environment_id, app_version_id, run_no, input_json, input_blob, input_keys_json, status, priority, max_retries, retry_count, cancel_requested, at_most_once, run_trace_id, command, rerun_of_run_id, queued_at, created_at, updated_at)
+     VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'queued', ?, ?, 0, 0, ?, ?, ?, ?, ?, ?, ?)`,
+			teamID, appID, envID, versionID, runNo, input.json, input.blob, input.keys, priority, maxRetries, atMostOnce, traceID, sql.NullString{String: command, Valid: command != ""}, rerunOf, now, now, now,
+		)
+		if err != nil {
+			return err
 		}
+
+		id, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		if statusTokenHash != "" {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO run_status_tokens (token_hash, run_id, created_at) VALUES (?, ?, ?)`,
+				statusTokenHash, id, now,
+			); err != nil {
+				return err
+			}
+		}
+
+		data := map[string]any{"priority": priority}
+		if rerunOf != nil {
+			data["rerun_of_run_id"] = *rerunOf
+		}
+		return appendRunEvent(ctx, tx, id, RunEventQueued, data, now)
+	})
+	if err != nil {
+		return 0, 0, 0, "", err
 	}
-	return &r, nil
+	return id, runNo, active, traceID, nil
 }
 
-// GetRunByIDDirect returns a run by ID without team scoping.
-// Used by runner-scoped handlers where the lease token proves authorization.
-func (s *Store) GetRunByIDDirect(ctx context.Context, runID int64) (*Run, error) {
+// runColumns is the runs column list scanRun expects, qualified with the
+// alias r. Queries that join extra columns select them after these and pass
+// their destinations to scanRun.
+const runColumns = `r.id, r.team_id, r.app_id, r.environment_id, r.app_version_id, r.run_no,
+            r.input_json, r.status, r.priority, r.max_retries, r.retry_count,
+            r.cancel_requested, r.queued_at, r.started_at, r.finished_at,
+            r.created_at, r.updated_at, r.run_trace_id, r.batch_id, r.at_most_once, r.command,
+            r.dead_reason, r.rerun_of_run_id, r.failure_kind, r.input_blob`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRun scans a row selected with runColumns, followed by extra.
+func scanRun(row rowScanner, extra ...any) (*Run, error) {
+	return scanRunInput(row, true, extra...)
+}
+
+// scanRunInput is scanRun that leaves a compressed input undecoded unless
+// decodeBlob is set, marking the run InputOmitted instead.
+func scanRunInput(row rowScanner, decodeBlob bool, extra ...any) (*Run, error) {
 	var r Run
-	var inputJSON sql.NullString
+	var inputJSON, batchID, command, deadReason, failureKind sql.NullString
+	var inputBlob []byte
 	var queuedAt, createdAt, updatedAt int64
-	var startedAt, finishedAt sql.NullInt64
-	var cancelRequested int
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, team_id, app_id, environment_id, app_version_id, run_no, input_json, status, priority, max_retries, retry_count, cancel_requested, queued_at, started_at, finished_at, created_at, updated_at
-     FROM runs WHERE id = ?`,
-		runID,
-	).Scan(&r.ID, &r.TeamID, &r.AppID, &r.EnvironmentID, &r.AppVersionID, &r.RunNo, &inputJSON, &r.Status, &r.Priority, &r.MaxRetries, &r.RetryCount, &cancelRequested, &queuedAt, &startedAt, &finishedAt, &createdAt, &updatedAt)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil
-	}
-	if err != nil {
+	var startedAt, finishedAt, rerunOf sql.NullInt64
+	var cancelRequested, atMostOnce int
+	dest := []any{&r.ID, &r.TeamID, &r.AppID, &r.EnvironmentID, &r.AppVersionID, &r.RunNo, &inputJSON, &r.Status, &r.Priority, &r.MaxRetries, &r.RetryCount, &cancelRequested, &queuedAt, &startedAt, &finishedAt, &createdAt, &updatedAt, &r.TraceID, &batchID, &atMostOnce, &command, &deadReason, &rerunOf, &failureKind, &inputBlob}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
 		return nil, err
 	}
 
 	r.CancelRequested = cancelRequested == 1
+	r.AtMostOnce = atMostOnce == 1
+	r.BatchID = batchID.String
+	r.Command = command.String
+	r.DeadReason = deadReason.String
+	r.FailureKind = failureKind.String
+	if rerunOf.Valid {
+		r.RerunOfRunID = &rerunOf.Int64
+	}
 	r.QueuedAt = time.UnixMilli(queuedAt)
 	r.CreatedAt = time.UnixMilli(createdAt)
 	r.UpdatedAt = time.UnixMilli(updatedAt)
@@ -190,66 +299,65 @@ func (s *Store) GetRunByIDDirect(ctx context.Context, runID int64) (*Run, error)
 		t := time.UnixMilli(finishedAt.Int64)
 		r.FinishedAt = &t
 	}
-	if inputJSON.Valid {
+	switch {
+	case inputJSON.Valid:
 		if err := json.Unmarshal([]byte(inputJSON.String), &r.Input); err != nil {
 			return nil, err
 		}
+	case inputBlob != nil && decodeBlob:
+		input, err := decodeInputBlob(inputBlob)
+		if err != nil {
+			return nil, err
+		}
+		r.Input = input
+	case inputBlob != nil:
+		r.InputOmitted = true
 	}
 	return &r, nil
 }
 
-// GetRunByAppAndRunNo returns a run by app ID and run number.
-func (s *Store) GetRunByAppAndRunNo(ctx context.Context, teamID, appID, runNo int64) (*Run, error) {
-	var r Run
-	var inputJSON sql.NullString
-	var queuedAt, createdAt, updatedAt int64
-	var startedAt, finishedAt sql.NullInt64
-	var cancelRequested int
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, team_id, app_id, environment_id, app_version_id, run_no, input_json, status, priority, max_retries, retry_count, cancel_requested, queued_at, started_at, finished_at, created_at, updated_at
-     FROM runs WHERE team_id = ? AND app_id = ? AND run_no = ?`,
-		teamID, appID, runNo,
-	).Scan(&r.ID, &r.TeamID, &r.AppID, &r.EnvironmentID, &r.AppVersionID, &r.RunNo, &inputJSON, &r.Status, &r.Priority, &r.MaxRetries, &r.RetryCount, &cancelRequested, &queuedAt, &startedAt, &finishedAt, &createdAt, &updatedAt)
+// getRun returns the single run matching where, or nil, nil.
+func (s *Store) getRun(ctx context.Context, where string, args ...any) (*Run, error) {
+	run, err := scanRun(s.db.QueryRowContext(ctx, `SELECT `+runColumns+` FROM runs r WHERE `+where, args...))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
-	if err != nil {
-		return nil, err
-	}
+	return run, err
+}
 
-	r.CancelRequested = cancelRequested == 1
-	r.QueuedAt = time.UnixMilli(queuedAt)
-	r.CreatedAt = time.UnixMilli(createdAt)
-	r.UpdatedAt = time.UnixMilli(updatedAt)
-	if startedAt.Valid {
-		t := time.UnixMilli(startedAt.Int64)
-		r.StartedAt = &t
-	}
-	if finishedAt.Valid {
-		t := time.UnixMilli(finishedAt.Int64)
-		r.FinishedAt = &t
-	}
-	if inputJSON.Valid {
-		if err := json.Unmarshal([]byte(inputJSON.String), &r.Input); err != nil {
-			return nil, err
-		}
-	}
-	return &r, nil
+// GetRunByID returns a run by ID (scoped to team).
+func (s *Store) GetRunByID(ctx context.Context, teamID, runID int64) (*Run, error) {
+	return s.getRun(ctx, `r.team_id = ? AND r.id = ?`, teamID, runID)
+}
+
+// GetRunByIDDirect returns a run by ID without team scoping.
+// Used by runner-scoped handlers where the lease token proves authorization.
+func (s *Store) GetRunByIDDirect(ctx context.Context, runID int64) (*Run, error) {
+	return s.getRun(ctx, `r.id = ?`, runID)
+}
+
+// GetRunByAppAndRunNo returns a run by app ID and run number.
+func (s *Store) GetRunByAppAndRunNo(ctx context.Context, teamID, appID, runNo int64) (*Run, error) {
+	return s.getRun(ctx, `r.team_id = ? AND r.app_id = ? AND r.run_no = ?`, teamID, appID, runNo)
 }
 
 // ListRunsByApp returns all runs for an app, joining version_no to avoid N+1 queries.
-func (s *Store) ListRunsByApp(ctx context.Context, teamID, appID int64, limit, offset int) ([]*Run, error) {
-	rows, err := s.db.QueryContext(ctx,
-		`SELECT r.id, r.team_id, r.app_id, r.environment_id, r.app_version_id, r.run_no,
-            r.input_json, r.status, r.priority, r.max_retries, r.retry_count,
-            r.cancel_requested, r.queued_at, r.started_at, r.finished_at,
-            r.created_at, r.updated_at, v.version_no
+// A versionNo above 0 keeps only that version's runs. Compressed inputs are
+// only decoded with includeInput.
+func (s *Store) ListRunsByApp(ctx context.Context, teamID, appID int64, limit, offset int, versionNo int64, includeInput bool) ([]*Run, error) {
+	query := `SELECT ` + runColumns + `, v.version_no, v.entrypoint
      FROM runs r
      JOIN app_versions v ON r.app_version_id = v.id
-     WHERE r.team_id = ? AND r.app_id = ?
-     ORDER BY r.run_no DESC LIMIT ? OFFSET ?`,
-		teamID, appID, limit, offset,
-	)
+     WHERE r.team_id = ? AND r.app_id = ?`
+	args := []any{teamID, appID}
+	if versionNo > 0 {
+		query += " AND v.version_no = ?"
+		args = append(args, versionNo)
+	}
+	query += ` ORDER BY r.run_no DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -257,59 +365,117 @@ func (s *Store) ListRunsByApp(ctx context.Context, teamID, appID int64, limit, o
 
 	var runs []*Run
 	for rows.Next() {
-		var r Run
-		var inputJSON sql.NullString
-		var queuedAt, createdAt, updatedAt int64
-		var startedAt, finishedAt sql.NullInt64
-		var cancelRequested int
 		var versionNo int64
-		if err := rows.Scan(&r.ID, &r.TeamID, &r.AppID, &r.EnvironmentID, &r.AppVersionID, &r.RunNo, &inputJSON, &r.Status, &r.Priority, &r.MaxRetries, &r.RetryCount, &cancelRequested, &queuedAt, &startedAt, &finishedAt, &createdAt, &updatedAt, &versionNo); err != nil {
+		var entrypoint string
+		r, err := scanRunInput(rows, includeInput, &versionNo, &entrypoint)
+		if err != nil {
 			return nil, err
 		}
-		r.CancelRequested = cancelRequested == 1
 		r.VersionNo = versionNo
-		r.QueuedAt = time.UnixMilli(queuedAt)
-		r.CreatedAt = time.UnixMilli(createdAt)
-		r.UpdatedAt = time.UnixMilli(updatedAt)
-		if startedAt.Valid {
-			t := time.UnixMilli(startedAt.Int64)
-			r.StartedAt = &t
-		}
-		if finishedAt.Valid {
-			t := time.UnixMilli(finishedAt.Int64)
-			r.FinishedAt = &t
-		}
-		if inputJSON.Valid {
-			if err := json.Unmarshal([]byte(inputJSON.String), &r.Input); err != nil {
-				return nil, err
-			}
-		}
-		runs = append(runs, &r)
+		r.Entrypoint = entrypoint
+		runs = append(runs, r)
 	}
 	return runs, rows.Err()
 }
 
-// ListRunsByTeam returns runs for a team with optional status and app slug filters.
-func (s *Store) ListRunsByTeam(ctx context.Context, teamID int64, limit, offset int, statusFilter, appFilter string) ([]*Run, error) {
-	query := `SELECT r.id, r.team_id, r.app_id, a.slug, r.environment_id, r.app_version_id, r.run_no,
-	            r.input_json, r.status, r.priority, r.max_retries, r.retry_count,
-	            r.cancel_requested, r.queued_at, r.started_at, r.finished_at,
-	            r.created_at, r.updated_at, v.version_no
+// RunListFilter narrows ListRuns. Zero values do not filter.
+type RunListFilter struct {
+	// TeamID scopes the list to one team. 0 lists every team's runs, for
+	// the admin API, and fills their TeamSlug.
+	TeamID    int64
+	Status    string
+	AppSlug   string
+	VersionNo int64
+	// InputContains keeps runs whose input has every given top-level key
+	// with exactly that value; values are strings, numbers (float64 or
+	// json.Number), booleans or nil, which matches a key present as JSON
+	// null. Compressed inputs (see InputLimits) match on their
+	// runs.input_keys_json projection, which leaves out values over 256
+	// bytes.
+	InputContains map[string]any
+	// Runner keeps runs whose latest attempt was leased by the runner of
+	// that name.
+	Runner string
+	// Since keeps runs queued at or after it.
+	Since time.Time
+	// IncludeRunner fills RunnerName. The latest attempt is only joined
+	// when this or Runner asks for it.
+	IncludeRunner bool
+	// IncludeInput decodes compressed inputs; without it those runs are
+	// listed InputOmitted.
+	IncludeInput bool
+	Limit        int
+	Offset       int
+}
+
+// ListRuns returns the runs matching f, active ones first and then newest
+// queued first, with their version number, entrypoint and app slug.
+func (s *Store) ListRuns(ctx context.Context, f RunListFilter) ([]*Run, error) {
+	columns := `SELECT ` + runColumns + `, v.version_no, v.entrypoint, a.slug`
+	from := `
 	     FROM runs r
 	     JOIN app_versions v ON r.app_version_id = v.id
-	     JOIN apps a ON r.app_id = a.id
-	     WHERE r.team_id = ?`
-	args := []any{teamID}
-
-	if statusFilter != "" {
-		query += " AND r.status = ?"
-		args = append(args, statusFilter)
-	}
-	if appFilter != "" {
-		query += " AND a.slug = ?"
-		args = append(args, appFilter)
+	     JOIN apps a ON r.app_id = a.id`
+	var conds []string
+	var args []any
+
+	if f.TeamID != 0 {
+		conds = append(conds, "r.team_id = ?")
+		args = append(args, f.TeamID)
+		columns += `, ''`
+	} else {
+		columns += `, t.slug`
+		from += `
+	     JOIN teams t ON r.team_id = t.id`
+	}
+	joinRunner := f.IncludeRunner || f.Runner != ""
+	if joinRunner {
+		// run_attempts(run_id, attempt_no) is unique, so the latest attempt
+		// is one index lookup per run.
+		columns += `, rn.name`
+		from += `
+	     LEFT JOIN run_attempts la ON la.run_id = r.id
+	       AND la.attempt_no = (SELECT MAX(attempt_no) FROM run_attempts WHERE run_id = r.id)
+	     LEFT JOIN runners rn ON rn.id = la.runner_id`
+	}
+	if f.Status != "" {
+		conds = append(conds, "r.status = ?")
+		args = append(args, f.Status)
+	}
+	if f.AppSlug != "" {
+		conds = append(conds, "a.slug = ?")
+		args = append(args, f.AppSlug)
+	}
+	if f.VersionNo > 0 {
+		conds = append(conds, "v.version_no = ?")
+		args = append(args, f.VersionNo)
+	}
+	if f.Runner != "" {
+		conds = append(conds, "rn.name = ?")
+		args = append(args, f.Runner)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "r.queued_at >= ?")
+		args = append(args, f.Since.UnixMilli())
+	}
+	keys := make([]string, 0, len(f.InputContains))
+	for key := range f.InputContains {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		clause, clauseArgs, err := inputMatchClause(key, f.InputContains[key])
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, clause)
+		args = append(args, clauseArgs...)
 	}
 
+	query := columns + from
+	if len(conds) > 0 {
+		query += "\n\t     WHERE " + strings.Join(conds, " AND ")
+	}
 	query += ` ORDER BY
 	     CASE r.status
 	       WHEN 'running' THEN 0
@@ -324,7 +490,7 @@ func (s *Store) ListRunsByTeam(ctx context.Context, teamID int64, limit, offset
 	     END,
 	     r.queued_at DESC
 	     LIMIT ? OFFSET ?`
-	args = append(args, limit, offset)
+	args = append(args, f.Limit, f.Offset)
 
 	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -334,56 +500,63 @@ func (s *Store) ListRunsByTeam(ctx context.Context, teamID int64, limit, offset
 
 	runs := make([]*Run, 0)
 	for rows.Next() {
-		var r Run
-		var inputJSON sql.NullString
-		var queuedAt, createdAt, updatedAt int64
-		var startedAt, finishedAt sql.NullInt64
-		var cancelRequested int
-		if err := rows.Scan(
-			&r.ID,
-			&r.TeamID,
-			&r.AppID,
-			&r.AppSlug,
-			&r.EnvironmentID,
-			&r.AppVersionID,
-			&r.RunNo,
-			&inputJSON,
-			&r.Status,
-			&r.Priority,
-			&r.MaxRetries,
-			&r.RetryCount,
-			&cancelRequested,
-			&queuedAt,
-			&startedAt,
-			&finishedAt,
-			&createdAt,
-			&updatedAt,
-			&r.VersionNo,
-		); err != nil {
+		var versionNo int64
+		var entrypoint, appSlug, teamSlug string
+		var runnerName sql.NullString
+		extra := []any{&versionNo, &entrypoint, &appSlug, &teamSlug}
+		if joinRunner {
+			extra = append(extra, &runnerName)
+		}
+		r, err := scanRunInput(rows, f.IncludeInput, extra...)
+		if err != nil {
 			return nil, err
 		}
+		r.VersionNo = versionNo
+		r.Entrypoint = entrypoint
+		r.AppSlug = appSlug
+		r.TeamSlug = teamSlug
+		r.RunnerName = runnerName.String
+		runs = append(runs, r)
+	}
 
-		r.CancelRequested = cancelRequested == 1
-		r.QueuedAt = time.UnixMilli(queuedAt)
-		r.CreatedAt = time.UnixMilli(createdAt)
-		r.UpdatedAt = time.UnixMilli(updatedAt)
-		if startedAt.Valid {
-			t := time.UnixMilli(startedAt.Int64)
-			r.StartedAt = &t
+	return runs, rows.Err()
+}
+
+const inputNumberMatch = `(json_type(COALESCE(r.input_json, r.input_keys_json), ?) IN ('integer', 'real') AND json_extract(COALESCE(r.input_json, r.input_keys_json), ?) = ?)`
+
+// inputMatchClause matches one top-level input key by JSON type as well as
+// value, so "1", 1 and true stay distinct even though json_extract returns
+// true as 1. A compressed input is matched on its input_keys_json
+// projection.
+func inputMatchClause(key string, value any) (string, []any, error) {
+	if key == "" || strings.Contains(key, `"`) {
+		return "", nil, fmt.Errorf("input_contains: invalid key %q", key)
+	}
+	path := `$."` + key + `"`
+	switch v := value.(type) {
+	case nil:
+		return `json_type(COALESCE(r.input_json, r.input_keys_json), ?) = 'null'`, []any{path}, nil
+	case bool:
+		if v {
+			return `json_type(COALESCE(r.input_json, r.input_keys_json), ?) = 'true'`, []any{path}, nil
 		}
-		if finishedAt.Valid {
-			t := time.UnixMilli(finishedAt.Int64)
-			r.FinishedAt = &t
+		return `json_type(COALESCE(r.input_json, r.input_keys_json), ?) = 'false'`, []any{path}, nil
+	case string:
+		return `(json_type(COALESCE(r.input_json, r.input_keys_json), ?) = 'text' AND json_extract(COALESCE(r.input_json, r.input_keys_json), ?) = ?)`, []any{path, path, v}, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return inputNumberMatch, []any{path, path, n}, nil
 		}
-		if inputJSON.Valid {
-			if err := json.Unmarshal([]byte(inputJSON.String), &r.Input); err != nil {
-				return nil, err
-			}
+		f, err := v.Float64()
+		if err != nil {
+			return "", nil, fmt.Errorf("input_contains %q: %w", key, err)
 		}
-		runs = append(runs, &r)
+		return inputNumberMatch, []any{path, path, f}, nil
+	case float64:
+		return inputNumberMatch, []any{path, path, v}, nil
+	default:
+		return "", nil, fmt.Errorf("input_contains %q: unsupported value type %T", key, value)
 	}
-
-	return runs, rows.Err()
 }
 
 // GetRunSummaryByTeam returns run count aggregates for a team.
@@ -440,22 +613,37 @@ func (s *Store) GetRunLogs(ctx context.Context, runID int64, afterSeq int64) ([]
 func (s *Store) CancelRun(ctx context.Context, teamID, runID int64) (*Run, error) {
 	now := time.Now().UnixMilli()
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	var status string
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		var err error
+		status, err = cancelRunTx(ctx, tx, teamID, runID, now)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
+	if status == "" {
+		return nil, nil
+	}
 
+	return s.GetRunByID(ctx, teamID, runID)
+}
+
+// cancelRunTx requests cancellation of one run inside tx: a queued run is
+// cancelled outright, a leased or running one moves to cancelling, and a
+// terminal one is left alone. It returns the run's status before the
+// change, or "" if the team has no such run.
+func cancelRunTx(ctx context.Context, tx *sql.Tx, teamID, runID, now int64) (string, error) {
 	var status string
-	err = tx.QueryRowContext(ctx,
+	err := tx.QueryRowContext(ctx,
 		`SELECT status FROM runs WHERE id = ? AND team_id = ?`,
 		runID, teamID,
 	).Scan(&status)
 	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil
+		return "", nil
 	}
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	switch status {
@@ -466,7 +654,13 @@ func (s *Store) CancelRun(ctx context.Context, teamID, runID int64) (*Run, error
 			now, now, runID, teamID,
 		)
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+		if err := appendRunEvent(ctx, tx, runID, RunEventCancelRequested, map[string]any{"previous_status": status}, now); err != nil {
+			return "", err
+		}
+		if err := appendRunEvent(ctx, tx, runID, RunEventFinished, map[string]any{"status": "cancelled"}, now); err != nil {
+			return "", err
 		}
 	case "leased", "running", "cancelling":
 		_, err = tx.ExecContext(ctx,
@@ -475,7 +669,7 @@ func (s *Store) CancelRun(ctx context.Context, teamID, runID int64) (*Run, error
 			now, runID, teamID,
 		)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 
 		_, err = tx.ExecContext(ctx,
@@ -484,15 +678,181 @@ func (s *Store) CancelRun(ctx context.Context, teamID, runID int64) (*Run, error
 			now, runID,
 		)
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+		if status != "cancelling" {
+			if err := appendRunEvent(ctx, tx, runID, RunEventCancelRequested, map[string]any{"previous_status": status}, now); err != nil {
+				return "", err
+			}
 		}
 	default:
 		// Terminal state, no change.
 	}
+	return status, nil
+}
+
+// bulkCancelBatchSize is how many runs CancelRunsBulk cancels per
+// transaction, so a large cancel does not hold the write lock for long.
+const bulkCancelBatchSize = 100
+
+// RunCancelFilter selects the runs of a team for a bulk cancel. Zero fields
+// do not filter; Statuses must be non-empty.
+type RunCancelFilter struct {
+	AppID         int64
+	Statuses      []string
+	CreatedBefore *time.Time
+	RunIDs        []int64
+}
 
-	if err := tx.Commit(); err != nil {
+// BulkCancelResult is the outcome of cancelling one run in a bulk cancel.
+type BulkCancelResult struct {
+	RunID          int64
+	AppID          int64
+	PreviousStatus string
+	Status         string
+	QueuedAt       time.Time
+	FinishedAt     *time.Time
+}
+
+// MatchRunsForCancel returns the IDs of the team's runs that match f, in ID
+// order.
+func (s *Store) MatchRunsForCancel(ctx context.Context, teamID int64, f RunCancelFilter) ([]int64, error) {
+	conds := []string{"team_id = ?"}
+	args := []any{teamID}
+	if f.AppID != 0 {
+		conds = append(conds, "app_id = ?")
+		args = append(args, f.AppID)
+	}
+	conds = append(conds, "status IN ("+sqlPlaceholders(len(f.Statuses))+")")
+	for _, st := range f.Statuses {
+		args = append(args, st)
+	}
+	if f.CreatedBefore != nil {
+		conds = append(conds, "created_at < ?")
+		args = append(args, f.CreatedBefore.UnixMilli())
+	}
+	if len(f.RunIDs) > 0 {
+		conds = append(conds, "id IN ("+sqlPlaceholders(len(f.RunIDs))+")")
+		for _, id := range f.RunIDs {
+			args = append(args, id)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id FROM runs WHERE `+strings.Join(conds, " AND ")+` ORDER BY id`,
+		args...,
+	)
+	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return s.GetRunByID(ctx, teamID, runID)
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CancelRunsBulk requests cancellation of each run with the same semantics
+// as CancelRun, committing every bulkCancelBatchSize runs. Runs that finished
+// since they were matched are reported unchanged; runs the team does not own
+// are skipped.
+func (s *Store) CancelRunsBulk(ctx context.Context, teamID int64, runIDs []int64) ([]BulkCancelResult, error) {
+	results := make([]BulkCancelResult, 0, len(runIDs))
+	for start := 0; start < len(runIDs); start += bulkCancelBatchSize {
+		batch := runIDs[start:min(start+bulkCancelBatchSize, len(runIDs))]
+		batchResults, err := s.cancelRunBatch(ctx, teamID, batch)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batchResults...)
+	}
+	return results, nil
+}
+
+func (s *Store) cancelRunBatch(ctx context.Context, teamID int64, runIDs []int64) ([]BulkCancelResult, error) {
+	now := time.Now().UnixMilli()
+
+	results := make([]BulkCancelResult, 0, len(runIDs))
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		for _, runID := range runIDs {
+			previous, err := cancelRunTx(ctx, tx, teamID, runID, now)
+			if err != nil {
+				return err
+			}
+			if previous == "" {
+				continue
+			}
+			res := BulkCancelResult{RunID: runID, PreviousStatus: previous}
+			var queuedAt int64
+			var finishedAt sql.NullInt64
+			if err := tx.QueryRowContext(ctx,
+				`SELECT app_id, status, queued_at, finished_at FROM runs WHERE id = ?`,
+				runID,
+			).Scan(&res.AppID, &res.Status, &queuedAt, &finishedAt); err != nil {
+				return err
+			}
+			res.QueuedAt = time.UnixMilli(queuedAt)
+			if finishedAt.Valid {
+				t := time.UnixMilli(finishedAt.Int64)
+				res.FinishedAt = &t
+			}
+			results = append(results, res)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SetRunPriority updates the priority of a queued run and returns the updated run.
+// The update is a CAS on status = 'queued', so a concurrent LeaseRun either picks
+// the run up first (ErrRunNotQueued) or sees the new priority. Returns nil, nil
+// if the run does not exist for the team.
+func (s *Store) SetRunPriority(ctx context.Context, teamID, runID int64, priority int) (*Run, error) {
+	now := time.Now().UnixMilli()
+
+	// The run is read back in the same transaction, so it shows this update
+	// rather than a lease that commits right after it.
+	var run *Run
+	var affected int64
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE runs SET priority = ?, updated_at = ?
+     WHERE id = ? AND team_id = ? AND status = 'queued'`,
+			priority, now, runID, teamID,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		run, err = scanRun(tx.QueryRowContext(ctx,
+			`SELECT `+runColumns+` FROM runs r WHERE r.team_id = ? AND r.id = ?`,
+			teamID, runID,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, nil
+	}
+	if affected == 0 {
+		return nil, ErrRunNotQueued
+	}
+	return run, nil
 }
@@ -2,6 +2,8 @@ package store_test
 
 import (
 	"context"
+	"encoding/json"
+	"slices"
 	"testing"
 	"time"
 
@@ -54,7 +56,7 @@ func TestListRunsByTeamAndSummary(t *testing.T) {
 	mustExec(t, dbConn, `UPDATE runs SET status = 'leased', queued_at = ? WHERE id = ?`, 1500, runLeased.ID)
 	mustExec(t, dbConn, `UPDATE runs SET status = 'failed', queued_at = ? WHERE id = ?`, 2500, runFailed.ID)
 
-	runs, err := s.ListRunsByTeam(ctx, team.ID, 20, 0, "", "")
+	runs, err := s.ListRuns(ctx, store.RunListFilter{TeamID: team.ID, Limit: 20})
 	if err != nil {
 		t.Fatalf("list runs by team: %v", err)
 	}
@@ -68,7 +70,7 @@ func TestListRunsByTeamAndSummary(t *testing.T) {
 		t.Fatalf("expected app slugs on runs, got %q and %q", runs[0].AppSlug, runs[2].AppSlug)
 	}
 
-	queuedRuns, err := s.ListRunsByTeam(ctx, team.ID, 20, 0, "queued", "")
+	queuedRuns, err := s.ListRuns(ctx, store.RunListFilter{TeamID: team.ID, Status: "queued", Limit: 20})
 	if err != nil {
 		t.Fatalf("list queued runs: %v", err)
 	}
@@ -76,7 +78,7 @@ func TestListRunsByTeamAndSummary(t *testing.T) {
 		t.Fatalf("expected only queued run %d, got %+v", runQueued.ID, queuedRuns)
 	}
 
-	appARuns, err := s.ListRunsByTeam(ctx, team.ID, 20, 0, "", "app-a")
+	appARuns, err := s.ListRuns(ctx, store.RunListFilter{TeamID: team.ID, AppSlug: "app-a", Limit: 20})
 	if err != nil {
 		t.Fatalf("list app-a runs: %v", err)
 	}
@@ -139,3 +141,239 @@ func TestGetRunLogsAfterSeq(t *testing.T) {
 		t.Fatalf("expected only seq 3, got %+v", incrementalLogs)
 	}
 }
+
+func TestCancelRunsBulkMixedStatesAndTeamScope(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-bulk-cancel")
+	other, _ := testutil.CreateTeam(t, s, "team-bulk-cancel-other")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	otherEnv, err := s.GetOrCreateDefaultEnvironment(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("get other env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "bulk-app")
+	otherApp := testutil.CreateApp(t, s, other.ID, "bulk-app")
+	ver := testutil.CreateVersion(t, s, app.ID)
+	otherVer := testutil.CreateVersion(t, s, otherApp.ID)
+
+	testutil.CreateRun(t, s, team.ID, app.ID, env.ID, ver.ID, 0, 0)
+	runner, _ := testutil.CreateRunner(t, s, "runner-bulk-cancel", "default")
+	leasedRun, attempt, _, _ := testutil.LeaseRun(t, s, runner)
+	queued := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, ver.ID, 0, 0)
+	failed := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, ver.ID, 0, 0)
+	mustExec(t, dbConn, `UPDATE runs SET status = 'failed' WHERE id = ?`, failed.ID)
+	otherRun := testutil.CreateRun(t, s, other.ID, otherApp.ID, otherEnv.ID, otherVer.ID, 0, 0)
+
+	all := []string{"queued", "leased", "running"}
+	ids, err := s.MatchRunsForCancel(ctx, team.ID, store.RunCancelFilter{Statuses: all})
+	if err != nil {
+		t.Fatalf("match runs: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != leasedRun.ID || ids[1] != queued.ID {
+		t.Fatalf("expected the queued and leased runs, got %v", ids)
+	}
+	if ids, err := s.MatchRunsForCancel(ctx, team.ID, store.RunCancelFilter{Statuses: []string{"queued"}, RunIDs: []int64{queued.ID, otherRun.ID}}); err != nil || len(ids) != 1 || ids[0] != queued.ID {
+		t.Fatalf("expected only the team's queued run, got %v (%v)", ids, err)
+	}
+	cutoff := time.Now().Add(-time.Hour)
+	if ids, err := s.MatchRunsForCancel(ctx, team.ID, store.RunCancelFilter{Statuses: all, CreatedBefore: &cutoff}); err != nil || len(ids) != 0 {
+		t.Fatalf("expected no runs created before the cutoff, got %v (%v)", ids, err)
+	}
+
+	// The failed run and the other team's run are passed in directly: one is
+	// left unchanged, the other skipped.
+	results, err := s.CancelRunsBulk(ctx, team.ID, []int64{queued.ID, leasedRun.ID, failed.ID, otherRun.ID})
+	if err != nil {
+		t.Fatalf("cancel runs: %v", err)
+	}
+	got := make(map[int64]store.BulkCancelResult)
+	for _, res := range results {
+		got[res.RunID] = res
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", results)
+	}
+	if res := got[queued.ID]; res.PreviousStatus != "queued" || res.Status != "cancelled" || res.FinishedAt == nil {
+		t.Fatalf("unexpected queued result %+v", res)
+	}
+	if res := got[leasedRun.ID]; res.PreviousStatus != "leased" || res.Status != "cancelling" {
+		t.Fatalf("unexpected leased result %+v", res)
+	}
+	if res := got[failed.ID]; res.PreviousStatus != "failed" || res.Status != "failed" {
+		t.Fatalf("unexpected failed result %+v", res)
+	}
+
+	var attemptStatus string
+	if err := dbConn.QueryRow(`SELECT status FROM run_attempts WHERE id = ?`, attempt.ID).Scan(&attemptStatus); err != nil {
+		t.Fatalf("read attempt: %v", err)
+	}
+	if attemptStatus != "cancelling" {
+		t.Fatalf("expected attempt cancelling, got %q", attemptStatus)
+	}
+	if run, err := s.GetRunByID(ctx, other.ID, otherRun.ID); err != nil || run.Status != "queued" {
+		t.Fatalf("expected the other team's run untouched, got %+v (%v)", run, err)
+	}
+}
+
+func TestListRunsByTeamInputContains(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-runs-input")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "input-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+
+	create := func(input map[string]any) int64 {
+		t.Helper()
+		run, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, input, 0, 0, false)
+		if err != nil {
+			t.Fatalf("create run: %v", err)
+		}
+		return run.ID
+	}
+	acme := create(map[string]any{"customer": "acme", "region": "eu", "shard": 3, "full": true, "note": nil})
+	acmeUS := create(map[string]any{"customer": "acme", "region": "us", "shard": 3.5, "full": false})
+	quoted := create(map[string]any{"customer": "1", "shard": "3", "full": 1})
+	noInput := create(nil)
+	mustExec(t, dbConn, `UPDATE runs SET status = 'failed' WHERE id = ?`, acmeUS)
+
+	ids := func(status string, filter map[string]any) []int64 {
+		t.Helper()
+		runs, err := s.ListRuns(ctx, store.RunListFilter{TeamID: team.ID, Status: status, InputContains: filter, Limit: 20})
+		if err != nil {
+			t.Fatalf("list runs %v: %v", filter, err)
+		}
+		out := make([]int64, 0, len(runs))
+		for _, r := range runs {
+			out = append(out, r.ID)
+		}
+		slices.Sort(out)
+		return out
+	}
+
+	for _, tc := range []struct {
+		name   string
+		status string
+		filter map[string]any
+		want   []int64
+	}{
+		{"string", "", map[string]any{"customer": "acme"}, []int64{acme, acmeUS}},
+		{"two keys", "", map[string]any{"customer": "acme", "region": "eu"}, []int64{acme}},
+		{"integer", "", map[string]any{"shard": json.Number("3")}, []int64{acme}},
+		{"integer as float", "", map[string]any{"shard": 3.0}, []int64{acme}},
+		{"real", "", map[string]any{"shard": json.Number("3.5")}, []int64{acmeUS}},
+		{"true", "", map[string]any{"full": true}, []int64{acme}},
+		{"false", "", map[string]any{"full": false}, []int64{acmeUS}},
+		{"types stay distinct", "", map[string]any{"customer": json.Number("1")}, []int64{}},
+		{"number is not a string", "", map[string]any{"shard": "3"}, []int64{quoted}},
+		{"null matches a present null", "", map[string]any{"note": nil}, []int64{acme}},
+		{"absent key never matches", "", map[string]any{"missing": nil}, []int64{}},
+		{"with status", "failed", map[string]any{"customer": "acme"}, []int64{acmeUS}},
+		{"status excludes", "queued", map[string]any{"region": "us"}, []int64{}},
+		{"no filter", "", nil, []int64{acme, acmeUS, quoted, noInput}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ids(tc.status, tc.filter); !slices.Equal(got, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := s.ListRuns(ctx, store.RunListFilter{TeamID: team.ID, InputContains: map[string]any{"list": []any{1}}, Limit: 20}); err == nil {
+		t.Fatal("expected an error for a non-scalar value")
+	}
+}
+
+func TestListRunsRunnerFilterUsesLatestAttempt(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-runner-filter")
+	other, _ := testutil.CreateTeam(t, s, "team-runner-filter-other")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	otherEnv, err := s.GetOrCreateDefaultEnvironment(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("get other env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "runner-filter-app")
+	ver := testutil.CreateVersion(t, s, app.ID)
+	otherApp := testutil.CreateApp(t, s, other.ID, "runner-filter-app")
+	otherVer := testutil.CreateVersion(t, s, otherApp.ID)
+	gpu02, _ := testutil.CreateRunner(t, s, "gpu-02", "default")
+	gpu03, _ := testutil.CreateRunner(t, s, "gpu-03", "default")
+
+	// retried's first attempt ran on gpu-03 and its second on gpu-02.
+	retried := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, ver.ID, 10, 1)
+	testutil.LeaseRun(t, s, gpu03)
+	mustExec(t, dbConn, `UPDATE run_attempts SET status = 'expired' WHERE run_id = ?`, retried.ID)
+	mustExec(t, dbConn, `UPDATE runs SET status = 'queued' WHERE id = ?`, retried.ID)
+	testutil.LeaseRun(t, s, gpu02)
+	mustExec(t, dbConn, `UPDATE run_attempts SET status = 'failed' WHERE run_id = ?`, retried.ID)
+	mustExec(t, dbConn, `UPDATE runs SET status = 'failed' WHERE id = ?`, retried.ID)
+
+	onGPU03 := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, ver.ID, 5, 0)
+	testutil.LeaseRun(t, s, gpu03)
+	mustExec(t, dbConn, `UPDATE run_attempts SET status = 'completed' WHERE run_id = ?`, onGPU03.ID)
+	mustExec(t, dbConn, `UPDATE runs SET status = 'completed', queued_at = ? WHERE id = ?`, 1000, onGPU03.ID)
+
+	otherTeamRun := testutil.CreateRun(t, s, other.ID, otherApp.ID, otherEnv.ID, otherVer.ID, 0, 0)
+	testutil.LeaseRun(t, s, gpu03)
+	neverLeased := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, ver.ID, 0, 0)
+
+	list := func(f store.RunListFilter) map[int64]*store.Run {
+		t.Helper()
+		f.Limit = 20
+		runs, err := s.ListRuns(ctx, f)
+		if err != nil {
+			t.Fatalf("list runs %+v: %v", f, err)
+		}
+		byID := make(map[int64]*store.Run, len(runs))
+		for _, r := range runs {
+			byID[r.ID] = r
+		}
+		return byID
+	}
+
+	runs := list(store.RunListFilter{TeamID: team.ID, IncludeRunner: true})
+	if len(runs) != 3 || runs[retried.ID].RunnerName != "gpu-02" || runs[onGPU03.ID].RunnerName != "gpu-03" || runs[neverLeased.ID].RunnerName != "" {
+		t.Fatalf("expected the latest attempt's runner on each run, got %+v", runs)
+	}
+	if runs := list(store.RunListFilter{TeamID: team.ID}); runs[retried.ID].RunnerName != "" {
+		t.Fatalf("expected no runner name without IncludeRunner, got %q", runs[retried.ID].RunnerName)
+	}
+
+	runs = list(store.RunListFilter{TeamID: team.ID, Runner: "gpu-03"})
+	if len(runs) != 1 || runs[onGPU03.ID] == nil {
+		t.Fatalf("expected only the run whose latest attempt ran on gpu-03, got %+v", runs)
+	}
+	if runs := list(store.RunListFilter{TeamID: team.ID, Runner: "gpu-02"}); len(runs) != 1 || runs[retried.ID] == nil {
+		t.Fatalf("expected only the retried run on gpu-02, got %+v", runs)
+	}
+
+	// Since compares queue times; onGPU03 was queued at 1000ms.
+	runs = list(store.RunListFilter{TeamID: team.ID, Since: time.UnixMilli(2000)})
+	if len(runs) != 2 || runs[onGPU03.ID] != nil {
+		t.Fatalf("expected runs queued since 2000ms, got %+v", runs)
+	}
+
+	// Without a team the filter spans teams and carries team slugs.
+	runs = list(store.RunListFilter{Runner: "gpu-03", IncludeRunner: true})
+	if len(runs) != 2 || runs[otherTeamRun.ID] == nil || runs[otherTeamRun.ID].TeamSlug != "team-runner-filter-other" || runs[onGPU03.ID].TeamSlug != "team-runner-filter" {
+		t.Fatalf("expected gpu-03's runs of both teams with team slugs, got %+v", runs)
+	}
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// NoRunnerEventInterval is the least time between two
+// RunEventNoRunnerAvailable events on a run.
+const NoRunnerEventInterval = 10 * time.Minute
+
+// StarvedEnvironment is an environment with leasable queued runs and no
+// online runner able to lease them.
+type StarvedEnvironment struct {
+	Name        string
+	QueuedRuns  int64
+	OldestRunID int64
+	// OldestQueuedAt is when OldestRunID was queued.
+	OldestQueuedAt time.Time
+}
+
+// ListStarvedEnvironments returns the starved environments by name, across
+// teams, in one grouped query. A queued run counts when no online runner of
+// its environment has the Python version its version needs, so an
+// environment whose runners lack one is starved for those runs alone. Queued
+// runs waiting on a cancellation are not counted, since no runner would
+// lease them.
+func (s *Store) ListStarvedEnvironments(ctx context.Context) ([]StarvedEnvironment, error) {
+	// With MIN() as the only aggregate, SQLite takes the bare r.id from the
+	// row holding the minimum, so it is the oldest queued run.
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT e.name, COUNT(*), MIN(r.queued_at), r.id
+     FROM runs r
+     JOIN environments e ON e.id = r.environment_id
+     JOIN app_versions v ON v.id = r.app_version_id
+     WHERE r.status = 'queued' AND r.cancel_requested = 0
+       AND NOT EXISTS (
+         SELECT 1 FROM runners rn
+         WHERE rn.environment = e.name AND rn.status = 'online' AND `+runnerCanRunVersion+`
+       )
+     GROUP BY e.name
+     ORDER BY e.name ASC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var envs []StarvedEnvironment
+	for rows.Next() {
+		var env StarvedEnvironment
+		var oldestQueuedAt int64
+		if err := rows.Scan(&env.Name, &env.QueuedRuns, &oldestQueuedAt, &env.OldestRunID); err != nil {
+			return nil, err
+		}
+		env.OldestQueuedAt = time.UnixMilli(oldestQueuedAt)
+		envs = append(envs, env)
+	}
+	return envs, rows.Err()
+}
+
+// RecordNoRunnerAvailable records a RunEventNoRunnerAvailable event on the
+// oldest queued run of a starved environment, unless the run had one within
+// NoRunnerEventInterval of now. The result reports whether it recorded one.
+func (s *Store) RecordNoRunnerAvailable(ctx context.Context, env StarvedEnvironment, now time.Time) (bool, error) {
+	details, err := json.Marshal(map[string]any{
+		"environment":    env.Name,
+		"queued_runs":    env.QueuedRuns,
+		"queued_seconds": now.Sub(env.OldestQueuedAt).Seconds(),
+	})
+	if err != nil {
+		return false, err
+	}
+	res, err := s.exec(ctx,
+		`INSERT INTO run_events (run_id, kind, details_json, created_at)
+     SELECT ?, ?, ?, ?
+     WHERE NOT EXISTS (SELECT 1 FROM run_events WHERE run_id = ? AND kind = ? AND created_at > ?)
+       AND (SELECT COUNT(*) FROM run_events WHERE run_id = ?) < ?`,
+		env.OldestRunID, RunEventNoRunnerAvailable, string(details), now.UnixMilli(),
+		env.OldestRunID, RunEventNoRunnerAvailable, now.Add(-NoRunnerEventInterval).UnixMilli(),
+		env.OldestRunID, maxRunEvents,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
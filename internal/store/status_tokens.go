@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RunStatusTokenTTL is how long a run's status tokens keep working after
+// the run reaches a terminal state.
+const RunStatusTokenTTL = 7 * 24 * time.Hour
+
+// RunStatus is all a status token may read of its run.
+type RunStatus struct {
+	RunID  int64
+	Status string
+	// ExitCode is the latest attempt's, once reported.
+	ExitCode   *int
+	FinishedAt *time.Time
+}
+
+// GetRunStatusByToken returns the status of the run a status token was
+// issued for, or nil if the token is unknown or its run finished more than
+// RunStatusTokenTTL before now.
+func (s *Store) GetRunStatusByToken(ctx context.Context, tokenHash string, now time.Time) (*RunStatus, error) {
+	var (
+		rs         RunStatus
+		exitCode   sql.NullInt64
+		finishedAt sql.NullInt64
+	)
+	err := s.db.QueryRowContext(ctx,
+		`SELECT r.id, r.status, r.finished_at,
+            (SELECT a.exit_code FROM run_attempts a WHERE a.run_id = r.id ORDER BY a.attempt_no DESC LIMIT 1)
+     FROM run_status_tokens t
+     JOIN runs r ON r.id = t.run_id
+     WHERE t.token_hash = ?`,
+		tokenHash,
+	).Scan(&rs.RunID, &rs.Status, &finishedAt, &exitCode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if finishedAt.Valid {
+		t := time.UnixMilli(finishedAt.Int64)
+		switch rs.Status {
+		case "completed", "failed", "cancelled", "dead":
+			if now.Sub(t) > RunStatusTokenTTL {
+				return nil, nil
+			}
+		}
+		rs.FinishedAt = &t
+	}
+	if exitCode.Valid {
+		code := int(exitCode.Int64)
+		rs.ExitCode = &code
+	}
+	return &rs, nil
+}
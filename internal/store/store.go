@@ -1,13 +1,73 @@
 package store
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
 
-// Store wraps database operations.
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"minitower/internal/db"
+)
+
+// Store wraps database operations. Reads query the pool directly; every
+// write goes through write so it waits its turn on the database's writer.
 type Store struct {
-	db *sql.DB
+	db     *sql.DB
+	writer *db.Writer
+	aging  PriorityAging
+	inputs InputLimits
+
+	artifactExists func(key string) bool
 }
 
 // New creates a new Store.
-func New(db *sql.DB) *Store {
-	return &Store{db: db}
+func New(conn *sql.DB) *Store {
+	return &Store{db: conn, writer: db.WriterFor(conn), inputs: DefaultInputLimits}
+}
+
+// write runs fn in a write transaction queued on the database's writer.
+func (s *Store) write(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return s.writer.Do(ctx, fn)
+}
+
+// exec runs a single write statement on the database's writer.
+func (s *Store) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	var result sql.Result
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		var err error
+		result, err = tx.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+// SetPriorityAging sets the aging LeaseRun applies to queued runs.
+func (s *Store) SetPriorityAging(aging PriorityAging) {
+	s.aging = aging
+}
+
+// SetArtifactCheck makes LeaseRun ask exists whether a queued run's artifact
+// object is still there before leasing it. Nil, the default, leases without
+// asking.
+func (s *Store) SetArtifactCheck(exists func(key string) bool) {
+	s.artifactExists = exists
+}
+
+// PriorityAging returns the aging LeaseRun applies to queued runs.
+func (s *Store) PriorityAging() PriorityAging {
+	return s.aging
+}
+
+// sqlPlaceholders returns n comma-separated "?" placeholders for an IN list.
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// isUniqueViolation reports whether err is a failed UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -74,6 +76,169 @@ func TestLeaseRunConcurrentSingleAssignment(t *testing.T) {
 	}
 }
 
+func TestCreateRunConcurrentRunNumbers(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-run-no")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-run-no")
+	version := testutil.CreateVersion(t, s, app.ID)
+
+	const n = 20
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, nil, 0, 0, false)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("create run: %v", err)
+		}
+	}
+
+	rows, err := dbConn.QueryContext(ctx, `SELECT run_no FROM runs WHERE app_id = ? ORDER BY run_no`, app.ID)
+	if err != nil {
+		t.Fatalf("list run numbers: %v", err)
+	}
+	defer rows.Close()
+	want := int64(1)
+	for rows.Next() {
+		var runNo int64
+		if err := rows.Scan(&runNo); err != nil {
+			t.Fatalf("scan run_no: %v", err)
+		}
+		if runNo != want {
+			t.Fatalf("expected run_no %d, got %d", want, runNo)
+		}
+		want++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("list run numbers: %v", err)
+	}
+	if want != n+1 {
+		t.Fatalf("expected %d runs, got %d", n, want-1)
+	}
+}
+
+func TestSetRunPriorityRacesLeaseRunCleanly(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-priority-race")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-priority-race")
+	version := testutil.CreateVersion(t, s, app.ID)
+
+	const oldPriority, newPriority = 0, 5
+	for i := 0; i < 20; i++ {
+		run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, oldPriority, 0)
+		runner, _ := testutil.CreateRunner(t, s, "runner-race-"+strconv.Itoa(i), "default")
+		_, leaseHash, _ := auth.GenerateToken()
+
+		var (
+			wg        sync.WaitGroup
+			leasedRun *store.Run
+			leaseErr  error
+			updated   *store.Run
+			updateErr error
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			leasedRun, _, leaseErr = s.LeaseRun(ctx, runner, leaseHash, time.Minute)
+		}()
+		go func() {
+			defer wg.Done()
+			updated, updateErr = s.SetRunPriority(ctx, team.ID, run.ID, newPriority)
+		}()
+		wg.Wait()
+
+		if leaseErr != nil {
+			t.Fatalf("iteration %d: lease run: %v", i, leaseErr)
+		}
+		switch {
+		case errors.Is(updateErr, store.ErrRunNotQueued):
+			// Lease won: the run must have been leased at its old priority.
+			if leasedRun.Priority != oldPriority {
+				t.Fatalf("iteration %d: lease won but priority is %d", i, leasedRun.Priority)
+			}
+		case updateErr == nil:
+			// Update won: it saw a queued run, and the lease then picked up the new priority.
+			if updated.Status != "queued" || updated.Priority != newPriority {
+				t.Fatalf("iteration %d: unexpected updated run: status=%s priority=%d", i, updated.Status, updated.Priority)
+			}
+			if leasedRun.Priority != newPriority {
+				t.Fatalf("iteration %d: update won but run leased at priority %d", i, leasedRun.Priority)
+			}
+		default:
+			t.Fatalf("iteration %d: set priority: %v", i, updateErr)
+		}
+
+		final, err := s.GetRunByID(ctx, team.ID, run.ID)
+		if err != nil {
+			t.Fatalf("get run: %v", err)
+		}
+		if final.Status != "leased" {
+			t.Fatalf("iteration %d: expected leased run, got %s", i, final.Status)
+		}
+	}
+}
+
+func TestSetRunPriorityRequiresQueuedRun(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-priority")
+	other, _ := testutil.CreateTeam(t, s, "team-priority-other")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-priority")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	updated, err := s.SetRunPriority(ctx, team.ID, run.ID, 10)
+	if err != nil {
+		t.Fatalf("set priority: %v", err)
+	}
+	if updated.Priority != 10 {
+		t.Fatalf("expected priority 10, got %d", updated.Priority)
+	}
+
+	missing, err := s.SetRunPriority(ctx, other.ID, run.ID, 1)
+	if err != nil {
+		t.Fatalf("set priority cross-team: %v", err)
+	}
+	if missing != nil {
+		t.Fatalf("expected nil run for other team")
+	}
+
+	runner, _ := testutil.CreateRunner(t, s, "runner-priority", "default")
+	testutil.LeaseRun(t, s, runner)
+
+	if _, err := s.SetRunPriority(ctx, team.ID, run.ID, 20); !errors.Is(err, store.ErrRunNotQueued) {
+		t.Fatalf("expected ErrRunNotQueued, got %v", err)
+	}
+}
+
 func TestRunnerCannotLeaseSecondRun(t *testing.T) {
 	s, _, cleanup := testutil.NewTestDB(t)
 	defer cleanup.Close(t)
@@ -111,6 +276,33 @@ func TestRunnerCannotLeaseSecondRun(t *testing.T) {
 	}
 }
 
+func TestGetActiveAttemptRequiresOwningRunner(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-attempt-owner")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-attempt-owner")
+	version := testutil.CreateVersion(t, s, app.ID)
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	owner, _ := testutil.CreateRunner(t, s, "runner-owner", "default")
+	other, _ := testutil.CreateRunner(t, s, "runner-other", "default")
+	_, _, _, leaseHash := testutil.LeaseRun(t, s, owner)
+
+	attempt, err := s.GetActiveAttempt(ctx, run.ID, owner.ID, leaseHash)
+	if err != nil || attempt == nil || attempt.RunnerID != owner.ID {
+		t.Fatalf("expected the owner's attempt, got %+v (%v)", attempt, err)
+	}
+	if _, err := s.GetActiveAttempt(ctx, run.ID, other.ID, leaseHash); !errors.Is(err, store.ErrInvalidLeaseToken) {
+		t.Fatalf("expected ErrInvalidLeaseToken for another runner, got %v", err)
+	}
+}
+
 func TestQueueSelectionDeterministic(t *testing.T) {
 	s, dbConn, cleanup := testutil.NewTestDB(t)
 	defer cleanup.Close(t)
@@ -145,6 +337,113 @@ func TestQueueSelectionDeterministic(t *testing.T) {
 	}
 }
 
+func TestQueueSelectionAgesLowPriorityRuns(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cap     int
+		wantOld bool
+	}{
+		// An hour at one point per ten minutes lifts priority 0 to 6,
+		// past the fresh priority 5 run.
+		{name: "aged past", cap: 10, wantOld: true},
+		{name: "capped below", cap: 3, wantOld: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, dbConn, cleanup := testutil.NewTestDB(t)
+			defer cleanup.Close(t)
+
+			ctx := context.Background()
+			team, _ := testutil.CreateTeam(t, s, "team-aging")
+			env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+			if err != nil {
+				t.Fatalf("get env: %v", err)
+			}
+			app := testutil.CreateApp(t, s, team.ID, "app-aging")
+			version := testutil.CreateVersion(t, s, app.ID)
+
+			oldLow := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+			freshHigh := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 5, 0)
+			now := time.Now()
+			mustExec(t, dbConn, `UPDATE runs SET queued_at = ? WHERE id = ?`, now.Add(-time.Hour).UnixMilli(), oldLow.ID)
+			mustExec(t, dbConn, `UPDATE runs SET queued_at = ? WHERE id = ?`, now.UnixMilli(), freshHigh.ID)
+
+			s.SetPriorityAging(store.PriorityAging{Interval: 10 * time.Minute, Cap: tc.cap})
+			runner, _ := testutil.CreateRunner(t, s, "runner-aging", "default")
+			_, leaseHash, _ := auth.GenerateToken()
+			leasedRun, _, err := s.LeaseRun(ctx, runner, leaseHash, time.Minute)
+			if err != nil {
+				t.Fatalf("lease run: %v", err)
+			}
+
+			want := freshHigh.ID
+			if tc.wantOld {
+				want = oldLow.ID
+			}
+			if leasedRun.ID != want {
+				t.Fatalf("expected run %d, got %d", want, leasedRun.ID)
+			}
+		})
+	}
+}
+
+func TestQueueSelectionAgingDisabledMatchesPriorityOrder(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-no-aging")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-no-aging")
+	version := testutil.CreateVersion(t, s, app.ID)
+
+	now := time.Now()
+	old := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	mid := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 5, 0)
+	tieA := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 5, 0)
+	tieB := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 5, 0)
+	mustExec(t, dbConn, `UPDATE runs SET queued_at = ? WHERE id = ?`, now.Add(-24*time.Hour).UnixMilli(), old.ID)
+	mustExec(t, dbConn, `UPDATE runs SET queued_at = ? WHERE id = ?`, now.UnixMilli(), mid.ID)
+	mustExec(t, dbConn, `UPDATE runs SET queued_at = ? WHERE id IN (?, ?)`, now.Add(-time.Minute).UnixMilli(), tieA.ID, tieB.ID)
+
+	// A zero interval disables aging even with a cap set.
+	s.SetPriorityAging(store.PriorityAging{Cap: 10})
+	for i, want := range []int64{tieA.ID, tieB.ID, mid.ID, old.ID} {
+		runner, _ := testutil.CreateRunner(t, s, "runner-no-aging-"+strconv.Itoa(i), "default")
+		_, leaseHash, _ := auth.GenerateToken()
+		leasedRun, _, err := s.LeaseRun(ctx, runner, leaseHash, time.Minute)
+		if err != nil {
+			t.Fatalf("lease run: %v", err)
+		}
+		if leasedRun.ID != want {
+			t.Fatalf("expected run %d, got %d", want, leasedRun.ID)
+		}
+	}
+}
+
+func TestPriorityAgingEffective(t *testing.T) {
+	queued := time.Unix(1000, 0)
+	aging := store.PriorityAging{Interval: 10 * time.Minute, Cap: 3}
+	for _, tc := range []struct {
+		waited time.Duration
+		want   int
+	}{
+		{waited: -time.Minute, want: 2},
+		{waited: 9 * time.Minute, want: 2},
+		{waited: 25 * time.Minute, want: 4},
+		{waited: 10 * time.Hour, want: 5},
+	} {
+		if got := aging.Effective(2, queued, queued.Add(tc.waited)); got != tc.want {
+			t.Errorf("Effective after %v = %d, want %d", tc.waited, got, tc.want)
+		}
+	}
+	if got := (store.PriorityAging{}).Effective(2, queued, queued.Add(time.Hour)); got != 2 {
+		t.Errorf("disabled Effective = %d, want 2", got)
+	}
+}
+
 func TestAppendLogsDedupe(t *testing.T) {
 	s, dbConn, cleanup := testutil.NewTestDB(t)
 	defer cleanup.Close(t)
@@ -307,10 +606,10 @@ func TestCompleteAttemptIdempotent(t *testing.T) {
 	_, attempt, _, leaseHash := testutil.LeaseRun(t, s, runner)
 
 	exitCode := 0
-	if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, "completed", &exitCode, nil); err != nil {
+	if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, "completed", &exitCode, nil, ""); err != nil {
 		t.Fatalf("complete attempt: %v", err)
 	}
-	if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, "completed", &exitCode, nil); err != nil {
+	if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, "completed", &exitCode, nil, ""); err != nil {
 		t.Fatalf("idempotent complete: %v", err)
 	}
 }
@@ -333,17 +632,56 @@ func TestCompleteAttemptConflict(t *testing.T) {
 	_, attempt, _, leaseHash := testutil.LeaseRun(t, s, runner)
 
 	exitCode := 0
-	if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, "completed", &exitCode, nil); err != nil {
+	if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, "completed", &exitCode, nil, ""); err != nil {
 		t.Fatalf("complete attempt: %v", err)
 	}
 
 	exitCode = 1
-	err = s.CompleteAttempt(ctx, attempt.ID, leaseHash, "failed", &exitCode, nil)
+	err = s.CompleteAttempt(ctx, attempt.ID, leaseHash, "failed", &exitCode, nil, "")
 	if !errors.Is(err, store.ErrLeaseConflict) {
 		t.Fatalf("expected conflict, got %v", err)
 	}
 }
 
+func TestCompleteAttemptRecordsFailureKind(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-failure-kind")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-failure-kind")
+	version := testutil.CreateVersion(t, s, app.ID)
+	runner, _ := testutil.CreateRunner(t, s, "runner-failure-kind", "default")
+
+	for _, tc := range []struct {
+		status, kind, want string
+	}{
+		{"failed", store.FailureKindUser, store.FailureKindUser},
+		{"failed", store.FailureKindInfrastructure, store.FailureKindInfrastructure},
+		// Older runners send no kind.
+		{"failed", "", ""},
+		// Only failures keep one.
+		{"completed", store.FailureKindUser, ""},
+	} {
+		run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+		_, attempt, _, leaseHash := testutil.LeaseRun(t, s, runner)
+		if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, tc.status, nil, nil, tc.kind); err != nil {
+			t.Fatalf("complete attempt: %v", err)
+		}
+		got, err := s.GetRunByID(ctx, team.ID, run.ID)
+		if err != nil || got == nil {
+			t.Fatalf("get run: %v", err)
+		}
+		if got.FailureKind != tc.want {
+			t.Fatalf("%s with kind %q: expected failure kind %q, got %q", tc.status, tc.kind, tc.want, got.FailureKind)
+		}
+	}
+}
+
 func TestCancelQueuedRun(t *testing.T) {
 	s, _, cleanup := testutil.NewTestDB(t)
 	defer cleanup.Close(t)
@@ -413,7 +751,7 @@ func TestCancelLeasedRunAndFinalize(t *testing.T) {
 		t.Fatalf("expected attempt cancelling, got %s", status)
 	}
 
-	if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, "cancelled", nil, nil); err != nil {
+	if err := s.CompleteAttempt(ctx, attempt.ID, leaseHash, "cancelled", nil, nil, ""); err != nil {
 		t.Fatalf("complete attempt: %v", err)
 	}
 
@@ -448,7 +786,7 @@ func TestCancelConflictsWithCompletedResult(t *testing.T) {
 	}
 
 	exitCode := 0
-	err = s.CompleteAttempt(ctx, attempt.ID, leaseHash, "completed", &exitCode, nil)
+	err = s.CompleteAttempt(ctx, attempt.ID, leaseHash, "completed", &exitCode, nil, "")
 	if !errors.Is(err, store.ErrLeaseConflict) {
 		t.Fatalf("expected conflict, got %v", err)
 	}
@@ -613,6 +951,73 @@ func TestRunnerStatusTransitionsOfflineWhenLastSeenMissingAndStale(t *testing.T)
 	}
 }
 
+func TestListRunnersFiltered(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	now := time.Now()
+	seen := func(name, env, status string, ago time.Duration) {
+		runner, _ := testutil.CreateRunner(t, s, name, env)
+		mustExec(t, dbConn, `UPDATE runners SET status = ?, last_seen_at = ? WHERE id = ?`, status, now.Add(-ago).UnixMilli(), runner.ID)
+	}
+	seen("cloud-a", "default", "online", time.Minute)
+	seen("cloud-b", "gpu", "offline", 2*time.Hour)
+	seen("edge-a", "gpu", "online", 10*time.Second)
+	neverSeen, _ := testutil.CreateRunner(t, s, "cloud-c", "default")
+	mustExec(t, dbConn, `UPDATE runners SET last_seen_at = NULL, updated_at = ? WHERE id = ?`, now.Add(-3*time.Hour).UnixMilli(), neverSeen.ID)
+
+	names := func(runners []*store.Runner) []string {
+		out := make([]string, 0, len(runners))
+		for _, r := range runners {
+			out = append(out, r.Name)
+		}
+		return out
+	}
+
+	cases := []struct {
+		name   string
+		filter store.RunnerFilter
+		want   []string
+		total  int64
+	}{
+		{"all by last seen", store.RunnerFilter{}, []string{"edge-a", "cloud-a", "cloud-b", "cloud-c"}, 4},
+		{"status", store.RunnerFilter{Status: "online"}, []string{"edge-a", "cloud-a", "cloud-c"}, 3},
+		{"environment", store.RunnerFilter{Environment: "gpu"}, []string{"edge-a", "cloud-b"}, 2},
+		{"name prefix", store.RunnerFilter{NamePrefix: "cloud-"}, []string{"cloud-a", "cloud-b", "cloud-c"}, 3},
+		{"stale", store.RunnerFilter{StaleBefore: now.Add(-30 * time.Minute)}, []string{"cloud-b", "cloud-c"}, 2},
+		{"combined", store.RunnerFilter{Status: "online", NamePrefix: "cloud-", StaleBefore: now.Add(-30 * time.Minute)}, []string{"cloud-c"}, 1},
+		{"page", store.RunnerFilter{Limit: 2, Offset: 1}, []string{"cloud-a", "cloud-b"}, 4},
+		{"prefix is not a pattern", store.RunnerFilter{NamePrefix: "cloud_"}, []string{}, 0},
+	}
+	for _, tc := range cases {
+		runners, total, err := s.ListRunnersFiltered(ctx, tc.filter)
+		if err != nil {
+			t.Fatalf("%s: list runners: %v", tc.name, err)
+		}
+		got := names(runners)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+		if total != tc.total {
+			t.Fatalf("%s: expected total %d, got %d", tc.name, tc.total, total)
+		}
+	}
+}
+
+func TestCreateRunnerRejectsTakenName(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	testutil.CreateRunner(t, s, "runner-dup", "default")
+
+	_, err := s.CreateRunner(ctx, "runner-dup", "other", "another-token-hash")
+	if !errors.Is(err, store.ErrRunnerNameTaken) {
+		t.Fatalf("expected ErrRunnerNameTaken, got %v", err)
+	}
+}
+
 func TestPruneOfflineRunnersDeletesStaleUnreferencedRunners(t *testing.T) {
 	s, dbConn, cleanup := testutil.NewTestDB(t)
 	defer cleanup.Close(t)
@@ -722,3 +1127,78 @@ func TestLeaseRunUpdatesRunnerLastSeen(t *testing.T) {
 		t.Fatalf("expected last_seen_at to be updated")
 	}
 }
+
+func TestLeaseRunMatchesRunnerPythonVersions(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-lease-python")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "app-lease-python")
+	createVersion := func(python string) *store.AppVersion {
+		t.Helper()
+		v, err := s.CreateVersion(ctx, app.ID, "objects/python.tar.gz", "sha256", 0, "main.py", nil, nil, nil, nil, nil, 6, false, nil, &python, nil)
+		if err != nil {
+			t.Fatalf("create version: %v", err)
+		}
+		return v
+	}
+	py312 := createVersion("3.12")
+	anyPython := testutil.CreateVersion(t, s, app.ID)
+
+	// The 3.12 run is ahead in the queue.
+	needs312 := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, py312.ID, 10, 0)
+	unpinned := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, anyPython.ID, 0, 0)
+
+	old, _ := testutil.CreateRunner(t, s, "runner-py310", "default")
+	if err := s.SetRunnerLabels(ctx, old.ID, map[string]string{store.RunnerLabelPython: "3.10"}); err != nil {
+		t.Fatalf("set labels: %v", err)
+	}
+	both, _ := testutil.CreateRunner(t, s, "runner-py310-312", "default")
+	if err := s.SetRunnerLabels(ctx, both.ID, map[string]string{store.RunnerLabelPython: "3.10,3.12"}); err != nil {
+		t.Fatalf("set labels: %v", err)
+	}
+
+	_, leaseHash, _ := auth.GenerateToken()
+	leased, _, err := s.LeaseRun(ctx, old, leaseHash, time.Minute)
+	if err != nil {
+		t.Fatalf("lease on the 3.10 runner: %v", err)
+	}
+	if leased.ID != unpinned.ID {
+		t.Fatalf("expected the 3.10 runner to skip the 3.12 run for run %d, got %d", unpinned.ID, leased.ID)
+	}
+	_, leaseHash, _ = auth.GenerateToken()
+	leased, _, err = s.LeaseRun(ctx, both, leaseHash, time.Minute)
+	if err != nil {
+		t.Fatalf("lease on the 3.12 runner: %v", err)
+	}
+	if leased.ID != needs312.ID {
+		t.Fatalf("expected the 3.12 runner to lease run %d, got %d", needs312.ID, leased.ID)
+	}
+
+	// A version no online runner has leaves its runs queued, and the
+	// environment starved, though its runners are online.
+	if starved, err := s.ListStarvedEnvironments(ctx); err != nil || len(starved) != 0 {
+		t.Fatalf("expected no starved environment, got %+v, %v", starved, err)
+	}
+	needs311 := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, createVersion("3.11").ID, 0, 0)
+	starved, err := s.ListStarvedEnvironments(ctx)
+	if err != nil {
+		t.Fatalf("list starved: %v", err)
+	}
+	if len(starved) != 1 || starved[0].Name != "default" || starved[0].QueuedRuns != 1 || starved[0].OldestRunID != needs311.ID {
+		t.Fatalf("expected default starved by run %d, got %+v", needs311.ID, starved)
+	}
+
+	runners, _, err := s.ListRunnersFiltered(ctx, store.RunnerFilter{NamePrefix: "runner-py310-"})
+	if err != nil {
+		t.Fatalf("list runners: %v", err)
+	}
+	if len(runners) != 1 || strings.Join(runners[0].PythonVersions(), ",") != "3.10,3.12" {
+		t.Fatalf("expected the runner's python versions listed, got %+v", runners)
+	}
+}
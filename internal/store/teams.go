@@ -3,7 +3,9 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -14,8 +16,10 @@ type Team struct {
 	Slug         string
 	Name         string
 	PasswordHash *string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	// MaxActiveRuns caps the team's non-terminal runs; nil is unlimited.
+	MaxActiveRuns *int64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 type TeamToken struct {
@@ -26,13 +30,17 @@ type TeamToken struct {
 	Role      string
 	CreatedAt time.Time
 	RevokedAt *time.Time
+	// Scopes limits the token to those scopes; nil is full access.
+	Scopes []string
+	// AppID limits a scoped token to one app's runs.
+	AppID *int64
 }
 
 // CreateTeam creates a new team.
 func (s *Store) CreateTeam(ctx context.Context, slug, name string) (*Team, error) {
 	now := time.Now().UnixMilli()
 
-	result, err := s.db.ExecContext(ctx,
+	result, err := s.exec(ctx,
 		`INSERT INTO teams (slug, name, created_at, updated_at)
      VALUES (?, ?, ?, ?)`,
 		slug, name, now, now,
@@ -86,10 +94,10 @@ func (s *Store) GetTeamByID(ctx context.Context, id int64) (*Team, error) {
 	var t Team
 	var createdAt, updatedAt int64
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, slug, name, password_hash, created_at, updated_at
+		`SELECT id, slug, name, password_hash, max_active_runs, created_at, updated_at
      FROM teams WHERE id = ?`,
 		id,
-	).Scan(&t.ID, &t.Slug, &t.Name, &t.PasswordHash, &createdAt, &updatedAt)
+	).Scan(&t.ID, &t.Slug, &t.Name, &t.PasswordHash, &t.MaxActiveRuns, &createdAt, &updatedAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -106,10 +114,10 @@ func (s *Store) GetTeamBySlug(ctx context.Context, slug string) (*Team, error) {
 	var t Team
 	var createdAt, updatedAt int64
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, slug, name, password_hash, created_at, updated_at
+		`SELECT id, slug, name, password_hash, max_active_runs, created_at, updated_at
      FROM teams WHERE slug = ?`,
 		slug,
-	).Scan(&t.ID, &t.Slug, &t.Name, &t.PasswordHash, &createdAt, &updatedAt)
+	).Scan(&t.ID, &t.Slug, &t.Name, &t.PasswordHash, &t.MaxActiveRuns, &createdAt, &updatedAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -124,18 +132,118 @@ func (s *Store) GetTeamBySlug(ctx context.Context, slug string) (*Team, error) {
 // SetTeamPassword updates a team's password hash.
 func (s *Store) SetTeamPassword(ctx context.Context, teamID int64, passwordHash string) error {
 	now := time.Now().UnixMilli()
-	_, err := s.db.ExecContext(ctx,
+	_, err := s.exec(ctx,
 		`UPDATE teams SET password_hash = ?, updated_at = ? WHERE id = ?`,
 		passwordHash, now, teamID,
 	)
 	return err
 }
 
+// SetTeamMaxActiveRuns sets the team's quota of non-terminal runs; nil
+// removes it. Runs already over a lowered quota are left alone.
+func (s *Store) SetTeamMaxActiveRuns(ctx context.Context, teamID int64, limit *int64) error {
+	now := time.Now().UnixMilli()
+	_, err := s.exec(ctx,
+		`UPDATE teams SET max_active_runs = ?, updated_at = ? WHERE id = ?`,
+		limit, now, teamID,
+	)
+	return err
+}
+
+// CountActiveRuns returns the team's non-terminal runs: queued, leased,
+// running and cancelling.
+func (s *Store) CountActiveRuns(ctx context.Context, teamID int64) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, countActiveRunsQuery, teamID).Scan(&n)
+	return n, err
+}
+
+const countActiveRunsQuery = `SELECT COUNT(*) FROM runs WHERE team_id = ? AND status IN ('queued', 'leased', 'running', 'cancelling')`
+
+// QuotaExceededError is returned when creating runs would take a team past
+// its max_active_runs.
+type QuotaExceededError struct {
+	Active int64 // The team's non-terminal runs.
+	Limit  int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("team has %d active runs, quota is %d", e.Active, e.Limit)
+}
+
+// reserveActiveRuns counts the team's non-terminal runs inside tx and returns
+// a *QuotaExceededError when n more would exceed its quota. Writes are
+// serialized, so no other run is created between the count and the caller's
+// insert in the same transaction. It returns the count before the insert.
+func reserveActiveRuns(ctx context.Context, tx *sql.Tx, teamID int64, n int) (int64, error) {
+	var limit sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `SELECT max_active_runs FROM teams WHERE id = ?`, teamID).Scan(&limit); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	var active int64
+	if err := tx.QueryRowContext(ctx, countActiveRunsQuery, teamID).Scan(&active); err != nil {
+		return 0, err
+	}
+	if limit.Valid && active+int64(n) > limit.Int64 {
+		return active, &QuotaExceededError{Active: active, Limit: limit.Int64}
+	}
+	return active, nil
+}
+
+// CreateScopedTeamToken creates a member token limited to scopes and, when
+// appID is set, to that app's runs.
+func (s *Store) CreateScopedTeamToken(ctx context.Context, teamID int64, tokenHash string, name *string, scopes []string, appID *int64) (*TeamToken, error) {
+	now := time.Now().UnixMilli()
+
+	scopesJSON, err := json.Marshal(scopes)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.exec(ctx,
+		`INSERT INTO team_tokens (team_id, token_hash, name, role, created_at, scopes_json, app_id)
+	     VALUES (?, ?, ?, 'member', ?, ?, ?)`,
+		teamID, tokenHash, name, now, string(scopesJSON), appID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TeamToken{
+		ID:        id,
+		TeamID:    teamID,
+		TokenHash: tokenHash,
+		Name:      name,
+		Role:      "member",
+		CreatedAt: time.UnixMilli(now),
+		Scopes:    scopes,
+		AppID:     appID,
+	}, nil
+}
+
+// RevokeTeamTokensByName revokes the team's tokens named name that are still
+// active and returns how many it revoked.
+func (s *Store) RevokeTeamTokensByName(ctx context.Context, teamID int64, name string) (int64, error) {
+	result, err := s.exec(ctx,
+		`UPDATE team_tokens SET revoked_at = ?
+	     WHERE team_id = ? AND name = ? AND revoked_at IS NULL`,
+		time.Now().UnixMilli(), teamID, name,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // CreateTeamToken creates a new team API token.
 func (s *Store) CreateTeamToken(ctx context.Context, teamID int64, tokenHash string, name *string, role string) (*TeamToken, error) {
 	now := time.Now().UnixMilli()
 
-	result, err := s.db.ExecContext(ctx,
+	result, err := s.exec(ctx,
 		`INSERT INTO team_tokens (team_id, token_hash, name, role, created_at)
 	     VALUES (?, ?, ?, ?, ?)`,
 		teamID, tokenHash, name, role, now,
@@ -0,0 +1,101 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestCreateRunEnforcesTeamQuotaUnderConcurrency(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-quota")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "quota-app")
+	version := testutil.CreateVersion(t, s, app.ID)
+
+	// A terminal run does not count against the quota.
+	done := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	mustExec(t, dbConn, `UPDATE runs SET status = 'completed' WHERE id = ?`, done.ID)
+
+	limit := int64(5)
+	if err := s.SetTeamMaxActiveRuns(ctx, team.ID, &limit); err != nil {
+		t.Fatalf("set quota: %v", err)
+	}
+
+	const attempts = 20
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		created  []*store.Run
+		rejected int
+	)
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, nil, 0, 0, false)
+			mu.Lock()
+			defer mu.Unlock()
+			var quotaErr *store.QuotaExceededError
+			switch {
+			case errors.As(err, &quotaErr):
+				if quotaErr.Active != limit || quotaErr.Limit != limit {
+					t.Errorf("quota error = %+v, want active and limit %d", quotaErr, limit)
+				}
+				rejected++
+			case err != nil:
+				t.Errorf("create run: %v", err)
+			default:
+				created = append(created, run)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if int64(len(created)) != limit || rejected != attempts-int(limit) {
+		t.Fatalf("created %d and rejected %d runs, want %d and %d", len(created), rejected, limit, attempts-int(limit))
+	}
+	seen := make(map[int64]bool)
+	for _, run := range created {
+		if run.TeamActiveRuns < 1 || run.TeamActiveRuns > limit || seen[run.TeamActiveRuns] {
+			t.Fatalf("unexpected TeamActiveRuns %d", run.TeamActiveRuns)
+		}
+		seen[run.TeamActiveRuns] = true
+	}
+	if n, err := s.CountActiveRuns(ctx, team.ID); err != nil || n != limit {
+		t.Fatalf("CountActiveRuns = %d, %v; want %d", n, err, limit)
+	}
+
+	// A batch that would cross the quota creates nothing.
+	mustExec(t, dbConn, `UPDATE runs SET status = 'completed' WHERE id = ?`, created[0].ID)
+	_, err = s.CreateRunBatch(ctx, store.RunBatchSpec{
+		TeamID: team.ID, AppID: app.ID, EnvironmentID: env.ID, Version: version,
+		Inputs: []map[string]any{nil, nil},
+	})
+	var quotaErr *store.QuotaExceededError
+	if !errors.As(err, &quotaErr) || quotaErr.Active != limit-1 {
+		t.Fatalf("expected batch quota error with 4 active runs, got %v", err)
+	}
+
+	// Removing the quota lifts the cap.
+	if err := s.SetTeamMaxActiveRuns(ctx, team.ID, nil); err != nil {
+		t.Fatalf("clear quota: %v", err)
+	}
+	if _, err := s.CreateRun(ctx, team.ID, app.ID, env.ID, version.ID, nil, 0, 0, false); err != nil {
+		t.Fatalf("create run without quota: %v", err)
+	}
+	got, err := s.GetTeamByID(ctx, team.ID)
+	if err != nil || got == nil || got.MaxActiveRuns != nil {
+		t.Fatalf("expected no quota on team, got %+v (%v)", got, err)
+	}
+}
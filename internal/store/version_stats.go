@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// VersionStats summarizes the runs of one app version.
+type VersionStats struct {
+	TotalRuns int64
+	// RunsByStatus counts the runs in each status they have.
+	RunsByStatus map[string]int64
+	// FirstRunAt and LastRunAt are the first and last queue times; nil
+	// without runs.
+	FirstRunAt *time.Time
+	LastRunAt  *time.Time
+	// The durations run from start to finish over the runs that have both;
+	// nil when none has. Percentiles are nearest-rank.
+	DurationAvg *time.Duration
+	DurationP50 *time.Duration
+	DurationP95 *time.Duration
+}
+
+// GetVersionStats returns the run statistics of a version in one grouped
+// query: a row per status, each carrying the version-wide durations.
+func (s *Store) GetVersionStats(ctx context.Context, versionID int64) (*VersionStats, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`WITH d AS (
+       SELECT status, queued_at,
+              CASE WHEN started_at IS NOT NULL AND finished_at IS NOT NULL THEN finished_at - started_at END AS duration_ms
+       FROM runs WHERE app_version_id = ?
+     ),
+     ranked AS (
+       SELECT duration_ms, ROW_NUMBER() OVER (ORDER BY duration_ms) AS rn, COUNT(*) OVER () AS n
+       FROM d WHERE duration_ms IS NOT NULL
+     ),
+     agg AS (
+       SELECT AVG(duration_ms) AS avg_ms,
+              MAX(CASE WHEN rn = (n * 50 + 99) / 100 THEN duration_ms END) AS p50_ms,
+              MAX(CASE WHEN rn = (n * 95 + 99) / 100 THEN duration_ms END) AS p95_ms
+       FROM ranked
+     )
+     SELECT d.status, COUNT(*), MIN(d.queued_at), MAX(d.queued_at), agg.avg_ms, agg.p50_ms, agg.p95_ms
+     FROM d CROSS JOIN agg
+     GROUP BY d.status`,
+		versionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &VersionStats{RunsByStatus: make(map[string]int64)}
+	for rows.Next() {
+		var (
+			status       string
+			count        int64
+			first, last  int64
+			avgMs        sql.NullFloat64
+			p50Ms, p95Ms sql.NullInt64
+		)
+		if err := rows.Scan(&status, &count, &first, &last, &avgMs, &p50Ms, &p95Ms); err != nil {
+			return nil, err
+		}
+		stats.RunsByStatus[status] = count
+		stats.TotalRuns += count
+		if t := time.UnixMilli(first); stats.FirstRunAt == nil || t.Before(*stats.FirstRunAt) {
+			stats.FirstRunAt = &t
+		}
+		if t := time.UnixMilli(last); stats.LastRunAt == nil || t.After(*stats.LastRunAt) {
+			stats.LastRunAt = &t
+		}
+		if avgMs.Valid {
+			d := time.Duration(avgMs.Float64 * float64(time.Millisecond))
+			stats.DurationAvg = &d
+		}
+		if p50Ms.Valid {
+			d := time.Duration(p50Ms.Int64) * time.Millisecond
+			stats.DurationP50 = &d
+		}
+		if p95Ms.Valid {
+			d := time.Duration(p95Ms.Int64) * time.Millisecond
+			stats.DurationP95 = &d
+		}
+	}
+	return stats, rows.Err()
+}
@@ -14,26 +14,92 @@ type AppVersion struct {
 	VersionNo         int64
 	ArtifactObjectKey string
 	ArtifactSHA256    string
+	ArtifactSizeBytes *int64
 	Entrypoint        string
 	TimeoutSeconds    *int
 	ParamsSchema      map[string]any
 	TowerfileTOML     *string
 	ImportPaths       []string
-	CreatedAt         time.Time
+	SetupScript       *string
+	// TowerfileSchemaVersion is the Towerfile schema_version the version was
+	// deployed with.
+	TowerfileSchemaVersion int
+	// AtMostOnce is the Towerfile's app.at_most_once, the default for runs
+	// created from the version.
+	AtMostOnce bool
+	// MaxRetries is the Towerfile's [app.retries] max, the default
+	// max_retries for runs created from the version; nil when unset.
+	MaxRetries *int
+	// PythonVersion is the Towerfile's app.python, the interpreter version
+	// a runner must have to lease the version's runs; nil for any runner.
+	PythonVersion *string
+	// ArtifactCorrupt is set when object verification found the artifact
+	// missing or not matching ArtifactSHA256.
+	ArtifactCorrupt bool
+	// Commands are the version's named entrypoints from the Towerfile's
+	// [[commands]] array.
+	Commands []VersionCommand
+	// ParamsSchemaJSON is ParamsSchema as stored, so it can be returned
+	// without re-marshaling; nil when the version has no schema.
+	ParamsSchemaJSON json.RawMessage
+	// PromotedFromApp and PromotedFromVersionNo name the version this one
+	// was promoted from, or are nil for an uploaded version.
+	PromotedFromApp       *string
+	PromotedFromVersionNo *int64
+	CreatedAt             time.Time
+}
+
+// VersionCommand is a named entrypoint of a version. TimeoutSeconds and
+// ParamsSchema replace the version's when set.
+type VersionCommand struct {
+	Name           string         `json:"name"`
+	Script         string         `json:"script"`
+	TimeoutSeconds *int           `json:"timeout_seconds,omitempty"`
+	ParamsSchema   map[string]any `json:"params_schema,omitempty"`
+}
+
+// Command returns the version's command named name, or nil.
+func (v *AppVersion) Command(name string) *VersionCommand {
+	for i := range v.Commands {
+		if v.Commands[i].Name == name {
+			return &v.Commands[i]
+		}
+	}
+	return nil
+}
+
+// EntrypointFor returns the script, timeout and params schema a run of
+// command executes with. An empty or unknown command gets the version's own.
+func (v *AppVersion) EntrypointFor(command string) (entrypoint string, timeoutSeconds *int, paramsSchema map[string]any) {
+	entrypoint, timeoutSeconds, paramsSchema = v.Entrypoint, v.TimeoutSeconds, v.ParamsSchema
+	if command == "" {
+		return
+	}
+	if cmd := v.Command(command); cmd != nil {
+		entrypoint = cmd.Script
+		if cmd.TimeoutSeconds != nil {
+			timeoutSeconds = cmd.TimeoutSeconds
+		}
+		if cmd.ParamsSchema != nil {
+			paramsSchema = cmd.ParamsSchema
+		}
+	}
+	return
 }
 
 // CreateVersion creates a new app version with an atomically assigned version number.
-func (s *Store) CreateVersion(ctx context.Context, appID int64, artifactKey, artifactSHA256, entrypoint string, timeoutSeconds *int, paramsSchema map[string]any, towerfileTOML *string, importPaths []string) (*AppVersion, error) {
+func (s *Store) CreateVersion(ctx context.Context, appID int64, artifactKey, artifactSHA256 string, artifactSizeBytes int64, entrypoint string, timeoutSeconds *int, paramsSchema map[string]any, towerfileTOML *string, importPaths []string, setupScript *string, towerfileSchemaVersion int, atMostOnce bool, maxRetries *int, pythonVersion *string, commands []VersionCommand) (*AppVersion, error) {
 	now := time.Now().UnixMilli()
 
 	var paramsSchemaJSON *string
+	var paramsSchemaRaw json.RawMessage
 	if paramsSchema != nil {
 		data, err := json.Marshal(paramsSchema)
 		if err != nil {
 			return nil, err
 		}
 		str := string(data)
-		paramsSchemaJSON = &str
+		paramsSchemaJSON, paramsSchemaRaw = &str, data
 	}
 
 	var importPathsJSON *string
@@ -46,12 +112,22 @@ func (s *Store) CreateVersion(ctx context.Context, appID int64, artifactKey, art
 		importPathsJSON = &str
 	}
 
+	var commandsJSON *string
+	if len(commands) > 0 {
+		data, err := json.Marshal(commands)
+		if err != nil {
+			return nil, err
+		}
+		str := string(data)
+		commandsJSON = &str
+	}
+
 	// Atomic INSERT ... SELECT computes and inserts the version number in one statement,
 	// preventing race conditions between concurrent uploads for the same app.
-	result, err := s.db.ExecContext(ctx,
-		`INSERT INTO app_versions (app_id, version_no, artifact_object_key, artifact_sha256, entrypoint, timeout_seconds, params_schema_json, towerfile_toml, import_paths_json, created_at)
-     VALUES (?, COALESCE((SELECT MAX(version_no) FROM app_versions WHERE app_id = ?), 0) + 1, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		appID, appID, artifactKey, artifactSHA256, entrypoint, timeoutSeconds, paramsSchemaJSON, towerfileTOML, importPathsJSON, now,
+	result, err := s.exec(ctx,
+		`INSERT INTO app_versions (app_id, version_no, artifact_object_key, artifact_sha256, artifact_size_bytes, entrypoint, timeout_seconds, params_schema_json, towerfile_toml, import_paths_json, setup_script, towerfile_schema_version, at_most_once, max_retries, python_version, commands_json, created_at)
+     VALUES (?, COALESCE((SELECT MAX(version_no) FROM app_versions WHERE app_id = ?), 0) + 1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		appID, appID, artifactKey, artifactSHA256, artifactSizeBytes, entrypoint, timeoutSeconds, paramsSchemaJSON, towerfileTOML, importPathsJSON, setupScript, towerfileSchemaVersion, atMostOnce, maxRetries, pythonVersion, commandsJSON, now,
 	)
 	if err != nil {
 		return nil, err
@@ -72,36 +148,80 @@ func (s *Store) CreateVersion(ctx context.Context, appID int64, artifactKey, art
 	}
 
 	return &AppVersion{
-		ID:                id,
-		AppID:             appID,
-		VersionNo:         versionNo,
-		ArtifactObjectKey: artifactKey,
-		ArtifactSHA256:    artifactSHA256,
-		Entrypoint:        entrypoint,
-		TimeoutSeconds:    timeoutSeconds,
-		ParamsSchema:      paramsSchema,
-		TowerfileTOML:     towerfileTOML,
-		ImportPaths:       importPaths,
-		CreatedAt:         time.UnixMilli(now),
+		ID:                     id,
+		AppID:                  appID,
+		VersionNo:              versionNo,
+		ArtifactObjectKey:      artifactKey,
+		ArtifactSHA256:         artifactSHA256,
+		ArtifactSizeBytes:      &artifactSizeBytes,
+		Entrypoint:             entrypoint,
+		TimeoutSeconds:         timeoutSeconds,
+		ParamsSchema:           paramsSchema,
+		TowerfileTOML:          towerfileTOML,
+		ImportPaths:            importPaths,
+		SetupScript:            setupScript,
+		TowerfileSchemaVersion: towerfileSchemaVersion,
+		AtMostOnce:             atMostOnce,
+		MaxRetries:             maxRetries,
+		PythonVersion:          pythonVersion,
+		Commands:               commands,
+		ParamsSchemaJSON:       paramsSchemaRaw,
+		CreatedAt:              time.UnixMilli(now),
 	}, nil
 }
 
-const versionColumns = `id, app_id, version_no, artifact_object_key, artifact_sha256, entrypoint, timeout_seconds, params_schema_json, towerfile_toml, import_paths_json, created_at`
+// PromoteVersion copies source, a version of the app sourceAppSlug, to a
+// new version of targetAppID. The copy shares source's artifact object
+// rather than copying its bytes and records source as its origin. It
+// returns nil if source no longer exists.
+func (s *Store) PromoteVersion(ctx context.Context, source *AppVersion, sourceAppSlug string, targetAppID int64) (*AppVersion, error) {
+	now := time.Now().UnixMilli()
+
+	var id int64
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`INSERT INTO app_versions (app_id, version_no, artifact_object_key, artifact_sha256, artifact_size_bytes, entrypoint, timeout_seconds, params_schema_json, towerfile_toml, import_paths_json, setup_script, towerfile_schema_version, at_most_once, max_retries, python_version, commands_json, artifact_corrupt, promoted_from_app, promoted_from_version_no, created_at)
+       SELECT ?, COALESCE((SELECT MAX(version_no) FROM app_versions WHERE app_id = ?), 0) + 1,
+              artifact_object_key, artifact_sha256, artifact_size_bytes, entrypoint, timeout_seconds, params_schema_json, towerfile_toml, import_paths_json, setup_script, towerfile_schema_version, at_most_once, max_retries, python_version, commands_json, artifact_corrupt, ?, version_no, ?
+       FROM app_versions WHERE id = ?`,
+			targetAppID, targetAppID, sourceAppSlug, now, source.ID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		id, err = result.LastInsertId()
+		return err
+	})
+	if err != nil || id == 0 {
+		return nil, err
+	}
+	return s.GetVersionByID(ctx, id)
+}
+
+const versionColumns = `id, app_id, version_no, artifact_object_key, artifact_sha256, artifact_size_bytes, entrypoint, timeout_seconds, params_schema_json, towerfile_toml, import_paths_json, setup_script, towerfile_schema_version, at_most_once, max_retries, python_version, commands_json, artifact_corrupt, promoted_from_app, promoted_from_version_no, created_at`
 
 // scanVersion scans a row into an AppVersion, unmarshalling JSON columns.
 func scanVersion(scanner interface{ Scan(...any) error }) (*AppVersion, error) {
 	var v AppVersion
 	var createdAt int64
-	var paramsSchemaJSON, towerfileTOML, importPathsJSON sql.NullString
+	var atMostOnce, artifactCorrupt int
+	var paramsSchemaJSON, towerfileTOML, importPathsJSON, setupScript, commandsJSON sql.NullString
 	if err := scanner.Scan(
-		&v.ID, &v.AppID, &v.VersionNo, &v.ArtifactObjectKey, &v.ArtifactSHA256,
-		&v.Entrypoint, &v.TimeoutSeconds, &paramsSchemaJSON, &towerfileTOML, &importPathsJSON, &createdAt,
+		&v.ID, &v.AppID, &v.VersionNo, &v.ArtifactObjectKey, &v.ArtifactSHA256, &v.ArtifactSizeBytes,
+		&v.Entrypoint, &v.TimeoutSeconds, &paramsSchemaJSON, &towerfileTOML, &importPathsJSON, &setupScript, &v.TowerfileSchemaVersion, &atMostOnce, &v.MaxRetries, &v.PythonVersion, &commandsJSON, &artifactCorrupt,
+		&v.PromotedFromApp, &v.PromotedFromVersionNo, &createdAt,
 	); err != nil {
 		return nil, err
 	}
 	v.CreatedAt = time.UnixMilli(createdAt)
+	v.AtMostOnce = atMostOnce == 1
+	v.ArtifactCorrupt = artifactCorrupt == 1
 	if paramsSchemaJSON.Valid {
-		if err := json.Unmarshal([]byte(paramsSchemaJSON.String), &v.ParamsSchema); err != nil {
+		v.ParamsSchemaJSON = json.RawMessage(paramsSchemaJSON.String)
+		if err := json.Unmarshal(v.ParamsSchemaJSON, &v.ParamsSchema); err != nil {
 			return nil, err
 		}
 	}
@@ -113,6 +233,14 @@ func scanVersion(scanner interface{ Scan(...any) error }) (*AppVersion, error) {
 			return nil, err
 		}
 	}
+	if setupScript.Valid {
+		v.SetupScript = &setupScript.String
+	}
+	if commandsJSON.Valid {
+		if err := json.Unmarshal([]byte(commandsJSON.String), &v.Commands); err != nil {
+			return nil, err
+		}
+	}
 	return &v, nil
 }
 
@@ -176,3 +304,146 @@ func (s *Store) ListVersions(ctx context.Context, appID int64) ([]*AppVersion, e
 	}
 	return versions, rows.Err()
 }
+
+// VersionArtifact identifies a version's artifact object. Only
+// ListVersionArtifacts fills in the fields after ObjectKey.
+type VersionArtifact struct {
+	VersionID int64
+	ObjectKey string
+	SHA256    string
+	TeamSlug  string
+	AppSlug   string
+	VersionNo int64
+	Corrupt   bool
+}
+
+// ListVersionArtifacts returns up to limit versions of every team with an ID
+// greater than afterID, in ID order.
+func (s *Store) ListVersionArtifacts(ctx context.Context, afterID int64, limit int) ([]VersionArtifact, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT v.id, v.artifact_object_key, v.artifact_sha256, t.slug, a.slug, v.version_no, v.artifact_corrupt
+     FROM app_versions v
+     JOIN apps a ON a.id = v.app_id
+     JOIN teams t ON t.id = a.team_id
+     WHERE v.id > ?
+     ORDER BY v.id ASC LIMIT ?`,
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []VersionArtifact
+	for rows.Next() {
+		var a VersionArtifact
+		var corrupt int
+		if err := rows.Scan(&a.VersionID, &a.ObjectKey, &a.SHA256, &a.TeamSlug, &a.AppSlug, &a.VersionNo, &corrupt); err != nil {
+			return nil, err
+		}
+		a.Corrupt = corrupt == 1
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+// CountVersions returns the number of versions across all teams.
+func (s *Store) CountVersions(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM app_versions`).Scan(&n)
+	return n, err
+}
+
+// SetVersionArtifactCorrupt sets or clears a version's artifact_corrupt flag.
+func (s *Store) SetVersionArtifactCorrupt(ctx context.Context, versionID int64, corrupt bool) error {
+	_, err := s.exec(ctx,
+		`UPDATE app_versions SET artifact_corrupt = ? WHERE id = ?`,
+		corrupt, versionID,
+	)
+	return err
+}
+
+// ListVersionsMissingArtifactSize returns up to limit versions with an ID
+// greater than afterID whose artifact size has not been recorded.
+func (s *Store) ListVersionsMissingArtifactSize(ctx context.Context, afterID int64, limit int) ([]VersionArtifact, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, artifact_object_key FROM app_versions
+     WHERE artifact_size_bytes IS NULL AND id > ?
+     ORDER BY id ASC LIMIT ?`,
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []VersionArtifact
+	for rows.Next() {
+		var a VersionArtifact
+		if err := rows.Scan(&a.VersionID, &a.ObjectKey); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+// SetVersionArtifactSize records the size of a version's artifact.
+func (s *Store) SetVersionArtifactSize(ctx context.Context, versionID, sizeBytes int64) error {
+	_, err := s.exec(ctx,
+		`UPDATE app_versions SET artifact_size_bytes = ? WHERE id = ?`,
+		sizeBytes, versionID,
+	)
+	return err
+}
+
+// SetVersionLabel points label at a version of the app, moving it off any
+// version it was on before.
+func (s *Store) SetVersionLabel(ctx context.Context, appID, versionID int64, label string) error {
+	now := time.Now().UnixMilli()
+	_, err := s.exec(ctx,
+		`INSERT INTO version_labels (app_id, label, app_version_id, created_at, updated_at)
+     VALUES (?, ?, ?, ?, ?)
+     ON CONFLICT(app_id, label) DO UPDATE SET app_version_id = excluded.app_version_id, updated_at = excluded.updated_at`,
+		appID, label, versionID, now, now,
+	)
+	return err
+}
+
+// GetVersionByLabel returns the version the app's label points at.
+func (s *Store) GetVersionByLabel(ctx context.Context, appID int64, label string) (*AppVersion, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+versionColumns+` FROM app_versions
+     WHERE id = (SELECT app_version_id FROM version_labels WHERE app_id = ? AND label = ?)`,
+		appID, label,
+	)
+	v, err := scanVersion(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return v, err
+}
+
+// ListVersionLabels returns the app's labels keyed by version ID, each list
+// sorted by label.
+func (s *Store) ListVersionLabels(ctx context.Context, appID int64) (map[int64][]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT app_version_id, label FROM version_labels WHERE app_id = ? ORDER BY label ASC`,
+		appID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := make(map[int64][]string)
+	for rows.Next() {
+		var versionID int64
+		var label string
+		if err := rows.Scan(&versionID, &label); err != nil {
+			return nil, err
+		}
+		labels[versionID] = append(labels[versionID], label)
+	}
+	return labels, rows.Err()
+}
@@ -0,0 +1,200 @@
+package store_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"minitower/internal/store"
+	"minitower/internal/testutil"
+)
+
+func TestVersionLabelMovesBetweenVersions(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-labels")
+	app := testutil.CreateApp(t, s, team.ID, "labels-app")
+	other := testutil.CreateApp(t, s, team.ID, "labels-other")
+	v1 := testutil.CreateVersion(t, s, app.ID)
+	v2 := testutil.CreateVersion(t, s, app.ID)
+	otherV1 := testutil.CreateVersion(t, s, other.ID)
+
+	for _, l := range []struct {
+		appID, versionID int64
+		label            string
+	}{
+		{app.ID, v1.ID, "stable"},
+		{app.ID, v1.ID, "canary"},
+		{other.ID, otherV1.ID, "stable"},
+	} {
+		if err := s.SetVersionLabel(ctx, l.appID, l.versionID, l.label); err != nil {
+			t.Fatalf("set label %s: %v", l.label, err)
+		}
+	}
+
+	labels, err := s.ListVersionLabels(ctx, app.ID)
+	if err != nil {
+		t.Fatalf("list labels: %v", err)
+	}
+	if want := map[int64][]string{v1.ID: {"canary", "stable"}}; !reflect.DeepEqual(labels, want) {
+		t.Fatalf("labels = %v, want %v", labels, want)
+	}
+
+	// Setting the label on v2 moves it; it never points at both versions.
+	if err := s.SetVersionLabel(ctx, app.ID, v2.ID, "stable"); err != nil {
+		t.Fatalf("move label: %v", err)
+	}
+	labels, err = s.ListVersionLabels(ctx, app.ID)
+	if err != nil {
+		t.Fatalf("list labels: %v", err)
+	}
+	if want := map[int64][]string{v1.ID: {"canary"}, v2.ID: {"stable"}}; !reflect.DeepEqual(labels, want) {
+		t.Fatalf("labels after move = %v, want %v", labels, want)
+	}
+
+	got, err := s.GetVersionByLabel(ctx, app.ID, "stable")
+	if err != nil || got == nil || got.ID != v2.ID {
+		t.Fatalf("expected stable to resolve to version %d, got %+v (%v)", v2.VersionNo, got, err)
+	}
+	// Labels are per app, so the other app's stable is unaffected.
+	got, err = s.GetVersionByLabel(ctx, other.ID, "stable")
+	if err != nil || got == nil || got.ID != otherV1.ID {
+		t.Fatalf("expected the other app's stable to stay on its version, got %+v (%v)", got, err)
+	}
+	missing, err := s.GetVersionByLabel(ctx, app.ID, "missing")
+	if err != nil || missing != nil {
+		t.Fatalf("expected no version for an unknown label, got %+v (%v)", missing, err)
+	}
+}
+
+func TestVersionLabelUniqueAndRestrictsDelete(t *testing.T) {
+	s, dbConn, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-label-unique")
+	app := testutil.CreateApp(t, s, team.ID, "label-unique-app")
+	v1 := testutil.CreateVersion(t, s, app.ID)
+	v2 := testutil.CreateVersion(t, s, app.ID)
+	if err := s.SetVersionLabel(ctx, app.ID, v1.ID, "stable"); err != nil {
+		t.Fatalf("set label: %v", err)
+	}
+
+	if _, err := dbConn.ExecContext(ctx,
+		`INSERT INTO version_labels (app_id, label, app_version_id, created_at, updated_at) VALUES (?, 'stable', ?, 0, 0)`,
+		app.ID, v2.ID,
+	); err == nil {
+		t.Fatal("expected a second row for the same label to be rejected")
+	}
+
+	if _, err := dbConn.ExecContext(ctx, `DELETE FROM app_versions WHERE id = ?`, v1.ID); err == nil {
+		t.Fatal("expected deleting a labeled version to be rejected")
+	}
+	if _, err := dbConn.ExecContext(ctx, `DELETE FROM app_versions WHERE id = ?`, v2.ID); err != nil {
+		t.Fatalf("delete unlabeled version: %v", err)
+	}
+}
+
+func TestCreateRunFromVersionLabel(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-label-runs")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "label-runs-app")
+	v1 := testutil.CreateVersion(t, s, app.ID)
+	v2 := testutil.CreateVersion(t, s, app.ID)
+	if err := s.SetVersionLabel(ctx, app.ID, v1.ID, "stable"); err != nil {
+		t.Fatalf("set label: %v", err)
+	}
+
+	version, err := s.GetVersionByLabel(ctx, app.ID, "stable")
+	if err != nil || version == nil {
+		t.Fatalf("resolve label: %+v (%v)", version, err)
+	}
+	run := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+
+	// The run keeps the version the label resolved to when it was created.
+	if err := s.SetVersionLabel(ctx, app.ID, v2.ID, "stable"); err != nil {
+		t.Fatalf("move label: %v", err)
+	}
+	got, err := s.GetRunByID(ctx, team.ID, run.ID)
+	if err != nil || got == nil {
+		t.Fatalf("get run: %+v (%v)", got, err)
+	}
+	if got.AppVersionID != v1.ID {
+		t.Fatalf("expected run on version id %d, got %d", v1.ID, got.AppVersionID)
+	}
+}
+
+func TestVersionCommandsPersistAndResolve(t *testing.T) {
+	s, _, cleanup := testutil.NewTestDB(t)
+	defer cleanup.Close(t)
+
+	ctx := context.Background()
+	team, _ := testutil.CreateTeam(t, s, "team-commands")
+	env, err := s.GetOrCreateDefaultEnvironment(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("get env: %v", err)
+	}
+	app := testutil.CreateApp(t, s, team.ID, "commands-app")
+
+	appTimeout, reportTimeout := 300, 60
+	appSchema := map[string]any{"type": "object", "properties": map[string]any{"name": map[string]any{"type": "string"}}}
+	reportSchema := map[string]any{"type": "object", "properties": map[string]any{"month": map[string]any{"type": "integer"}}}
+	commands := []store.VersionCommand{
+		{Name: "report", Script: "report.py", TimeoutSeconds: &reportTimeout, ParamsSchema: reportSchema},
+		{Name: "cleanup", Script: "cleanup.sh"},
+	}
+	created, err := s.CreateVersion(ctx, app.ID, "objects/commands.tar.gz", "sha256", 0, "main.py", &appTimeout, appSchema, nil, nil, nil, 4, false, nil, nil, commands)
+	if err != nil {
+		t.Fatalf("create version: %v", err)
+	}
+
+	version, err := s.GetVersionByID(ctx, created.ID)
+	if err != nil || version == nil {
+		t.Fatalf("get version: %+v (%v)", version, err)
+	}
+	// JSON round-tripping turns the schemas' nested maps into map[string]any,
+	// which the literals above already are.
+	if !reflect.DeepEqual(version.Commands, commands) {
+		t.Fatalf("commands = %+v, want %+v", version.Commands, commands)
+	}
+
+	entrypoint, timeout, schema := version.EntrypointFor("report")
+	if entrypoint != "report.py" || timeout == nil || *timeout != 60 || !reflect.DeepEqual(schema, reportSchema) {
+		t.Fatalf("report resolves to %q, %v, %v", entrypoint, timeout, schema)
+	}
+	// A command without its own timeout or parameters inherits the version's.
+	entrypoint, timeout, schema = version.EntrypointFor("cleanup")
+	if entrypoint != "cleanup.sh" || timeout == nil || *timeout != 300 || !reflect.DeepEqual(schema, appSchema) {
+		t.Fatalf("cleanup resolves to %q, %v, %v", entrypoint, timeout, schema)
+	}
+	if entrypoint, _, _ = version.EntrypointFor(""); entrypoint != "main.py" {
+		t.Fatalf("default resolves to %q, want main.py", entrypoint)
+	}
+
+	run, err := s.CreateCommandRun(ctx, team.ID, app.ID, env.ID, version.ID, "report", nil, 0, 0, false)
+	if err != nil {
+		t.Fatalf("create command run: %v", err)
+	}
+	defaultRun := testutil.CreateRun(t, s, team.ID, app.ID, env.ID, version.ID, 0, 0)
+	for _, tc := range []struct {
+		id   int64
+		want string
+	}{{run.ID, "report"}, {defaultRun.ID, ""}} {
+		got, err := s.GetRunByID(ctx, team.ID, tc.id)
+		if err != nil || got == nil {
+			t.Fatalf("get run: %+v (%v)", got, err)
+		}
+		if got.Command != tc.want {
+			t.Fatalf("run %d command = %q, want %q", tc.id, got.Command, tc.want)
+		}
+	}
+}
@@ -14,7 +14,7 @@ import (
 	"minitower/internal/store"
 )
 
-func NewTestDB(t *testing.T) (*store.Store, *sql.DB, *dbCleanup) {
+func NewTestDB(t testing.TB) (*store.Store, *sql.DB, *dbCleanup) {
 	t.Helper()
 
 	ctx := context.Background()
@@ -38,7 +38,7 @@ type dbCleanup struct {
 	db *sql.DB
 }
 
-func (c *dbCleanup) Close(t *testing.T) {
+func (c *dbCleanup) Close(t testing.TB) {
 	t.Helper()
 	if c == nil || c.db == nil {
 		return
@@ -48,11 +48,11 @@ func (c *dbCleanup) Close(t *testing.T) {
 	}
 }
 
-func CreateTeam(t *testing.T, s *store.Store, slug string) (*store.Team, string) {
+func CreateTeam(t testing.TB, s *store.Store, slug string) (*store.Team, string) {
 	return CreateTeamWithRole(t, s, slug, "admin")
 }
 
-func CreateTeamWithRole(t *testing.T, s *store.Store, slug, role string) (*store.Team, string) {
+func CreateTeamWithRole(t testing.TB, s *store.Store, slug, role string) (*store.Team, string) {
 	t.Helper()
 	ctx := context.Background()
 
@@ -73,7 +73,7 @@ func CreateTeamWithRole(t *testing.T, s *store.Store, slug, role string) (*store
 	return team, teamToken
 }
 
-func CreateRunner(t *testing.T, s *store.Store, name, environment string) (*store.Runner, string) {
+func CreateRunner(t testing.TB, s *store.Store, name, environment string) (*store.Runner, string) {
 	t.Helper()
 	ctx := context.Background()
 
@@ -90,7 +90,7 @@ func CreateRunner(t *testing.T, s *store.Store, name, environment string) (*stor
 	return runner, token
 }
 
-func CreateApp(t *testing.T, s *store.Store, teamID int64, slug string) *store.App {
+func CreateApp(t testing.TB, s *store.Store, teamID int64, slug string) *store.App {
 	t.Helper()
 	ctx := context.Background()
 
@@ -101,29 +101,29 @@ func CreateApp(t *testing.T, s *store.Store, teamID int64, slug string) *store.A
 	return app
 }
 
-func CreateVersion(t *testing.T, s *store.Store, appID int64) *store.AppVersion {
+func CreateVersion(t testing.TB, s *store.Store, appID int64) *store.AppVersion {
 	t.Helper()
 	ctx := context.Background()
 
-	version, err := s.CreateVersion(ctx, appID, "objects/fixture.tar.gz", "sha256", "main.py", nil, nil, nil, nil)
+	version, err := s.CreateVersion(ctx, appID, "objects/fixture.tar.gz", "sha256", 0, "main.py", nil, nil, nil, nil, nil, 1, false, nil, nil, nil)
 	if err != nil {
 		t.Fatalf("create version: %v", err)
 	}
 	return version
 }
 
-func CreateRun(t *testing.T, s *store.Store, teamID, appID, envID, versionID int64, priority int, maxRetries int) *store.Run {
+func CreateRun(t testing.TB, s *store.Store, teamID, appID, envID, versionID int64, priority int, maxRetries int) *store.Run {
 	t.Helper()
 	ctx := context.Background()
 
-	run, err := s.CreateRun(ctx, teamID, appID, envID, versionID, nil, priority, maxRetries)
+	run, err := s.CreateRun(ctx, teamID, appID, envID, versionID, nil, priority, maxRetries, false)
 	if err != nil {
 		t.Fatalf("create run: %v", err)
 	}
 	return run
 }
 
-func LeaseRun(t *testing.T, s *store.Store, runner *store.Runner) (*store.Run, *store.RunAttempt, string, string) {
+func LeaseRun(t testing.TB, s *store.Store, runner *store.Runner) (*store.Run, *store.RunAttempt, string, string) {
 	t.Helper()
 	ctx := context.Background()
 
@@ -10,13 +10,29 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+// PackageOptions adjusts how Package builds an archive.
+type PackageOptions struct {
+	// KeepFileMetadata stores each file's modification time, owner and
+	// exact permission bits. By default they are normalized so the same
+	// sources produce the same archive, and sha256, on any machine.
+	KeepFileMetadata bool
+}
+
 // Package validates the Towerfile, resolves source globs, packages the matched
 // files plus the Towerfile itself into a tar.gz archive, and returns the
-// archive bytes and hex-encoded SHA256.
+// archive bytes and hex-encoded SHA256. The archive is reproducible: entries
+// are sorted by path, carry no timestamps or owners, and have mode 0644, or
+// 0755 when any execute bit is set.
 func Package(dir string, tf *Towerfile) (io.Reader, string, error) {
-	if err := Validate(tf); err != nil {
+	return PackageWithOptions(dir, tf, PackageOptions{})
+}
+
+// PackageWithOptions is Package with opts.
+func PackageWithOptions(dir string, tf *Towerfile, opts PackageOptions) (io.Reader, string, error) {
+	if _, err := Validate(tf); err != nil {
 		return nil, "", fmt.Errorf("validation: %w", err)
 	}
 
@@ -30,17 +46,16 @@ func Package(dir string, tf *Towerfile) (io.Reader, string, error) {
 		return nil, "", fmt.Errorf("resolving source: %w", err)
 	}
 
-	// Verify script is in the resolved file list.
-	scriptRel := filepath.Clean(tf.App.Script)
-	found := false
+	// Verify the app script and every command script are in the resolved
+	// file list.
+	resolved := make(map[string]bool, len(files))
 	for _, f := range files {
-		if f == scriptRel {
-			found = true
-			break
-		}
+		resolved[f] = true
 	}
-	if !found {
-		return nil, "", fmt.Errorf("script %q is not matched by any source pattern", tf.App.Script)
+	for _, script := range tf.Scripts() {
+		if !resolved[filepath.Clean(script)] {
+			return nil, "", fmt.Errorf("script %q is not matched by any source pattern", script)
+		}
 	}
 
 	// Always include the Towerfile. Add it if not already in the set.
@@ -53,6 +68,7 @@ func Package(dir string, tf *Towerfile) (io.Reader, string, error) {
 	}
 	if !hasTowerfile {
 		files = append(files, "Towerfile")
+		sortPaths(files)
 	}
 
 	// Build tar.gz into a buffer, computing SHA256 as we write.
@@ -60,6 +76,7 @@ func Package(dir string, tf *Towerfile) (io.Reader, string, error) {
 	hash := sha256.New()
 	w := io.MultiWriter(&buf, hash)
 
+	// The gzip header is left without a name or modification time.
 	gw := gzip.NewWriter(w)
 	tw := tar.NewWriter(gw)
 
@@ -77,6 +94,9 @@ func Package(dir string, tf *Towerfile) (io.Reader, string, error) {
 		}
 		// Use the relative path (forward slashes) as the archive name.
 		header.Name = filepath.ToSlash(rel)
+		if !opts.KeepFileMetadata {
+			normalizeHeader(header)
+		}
 
 		// Handle symlinks: store as symlink entry, don't follow.
 		if info.Mode()&os.ModeSymlink != 0 {
@@ -116,3 +136,20 @@ func Package(dir string, tf *Towerfile) (io.Reader, string, error) {
 
 	return &buf, hex.EncodeToString(hash.Sum(nil)), nil
 }
+
+// normalizeHeader drops the parts of header that differ between machines
+// checking out the same sources.
+func normalizeHeader(header *tar.Header) {
+	header.ModTime = time.Unix(0, 0)
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.PAXRecords = nil
+	header.Format = tar.FormatUnknown
+	if header.Mode&0o111 != 0 {
+		header.Mode = 0o755
+	} else {
+		header.Mode = 0o644
+	}
+}
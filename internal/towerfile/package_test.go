@@ -9,8 +9,11 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 // readArchiveEntries extracts all entry names from a tar.gz reader.
@@ -200,6 +203,34 @@ func TestPackageScriptNotInSource(t *testing.T) {
 	}
 }
 
+func TestPackageIncludesCommandScripts(t *testing.T) {
+	dir := setupTestDir(t, []string{
+		"main.py",
+		"tasks/report.py",
+		"Towerfile",
+	})
+
+	tf := &Towerfile{
+		SchemaVersion: 4,
+		App:           App{Name: "test-app", Script: "main.py"},
+		Commands:      []Command{{Name: "report", Script: "tasks/report.py"}},
+	}
+
+	r, _, err := Package(dir, tf)
+	if err != nil {
+		t.Fatalf("Package() error: %v", err)
+	}
+	want := []string{"Towerfile", "main.py", "tasks/report.py"}
+	if got := readArchiveEntries(t, r); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("archive entries = %v, want %v", got, want)
+	}
+
+	tf.App.Source = []string{"./main.py"}
+	if _, _, err := Package(dir, tf); err == nil || !strings.Contains(err.Error(), `"tasks/report.py" is not matched`) {
+		t.Fatalf("Package() = %v, want command script not matched error", err)
+	}
+}
+
 func TestPackageValidationFailure(t *testing.T) {
 	dir := setupTestDir(t, []string{"main.py"})
 
@@ -336,3 +367,122 @@ func TestPackageSymlinkEntry(t *testing.T) {
 	}
 	t.Fatal("link.py not found in archive")
 }
+
+// writePackageFixture writes a small app whose archive has a known sha256.
+func writePackageFixture(t *testing.T) (string, *Towerfile) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, f := range []struct {
+		name string
+		body string
+		mode os.FileMode
+	}{
+		{"Towerfile", "[app]\nname = \"golden\"\nscript = \"main.py\"\n", 0o600},
+		{"main.py", "import lib.util\n\nprint(lib.util.greet())\n", 0o664},
+		{"lib/util.py", "def greet():\n    return 'hello'\n", 0o644},
+		{"bin/setup.sh", "#!/bin/sh\npip install -r requirements.txt\n", 0o700},
+		{"requirements.txt", "requests==2.32.3\n", 0o640},
+		// "a/b.txt" sorts before "a0.txt" only by its slash name.
+		{"a/b.txt", "b\n", 0o644},
+		{"a0.txt", "a0\n", 0o644},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f.body), f.mode); err != nil {
+			t.Fatal(err)
+		}
+		// WriteFile's mode is subject to the umask.
+		if err := os.Chmod(path, f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, &Towerfile{App: App{Name: "golden", Script: "main.py"}}
+}
+
+func TestPackageIsReproducible(t *testing.T) {
+	dir, tf := writePackageFixture(t)
+
+	first, sha1, err := Package(dir, tf)
+	if err != nil {
+		t.Fatalf("Package() error: %v", err)
+	}
+	firstData, _ := io.ReadAll(first)
+
+	later := time.Now().Add(time.Hour)
+	for _, f := range []string{"Towerfile", "main.py", "lib/util.py"} {
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(f)), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	second, sha2, err := Package(dir, tf)
+	if err != nil {
+		t.Fatalf("Package() error: %v", err)
+	}
+	secondData, _ := io.ReadAll(second)
+	if sha1 != sha2 || !bytes.Equal(firstData, secondData) {
+		t.Fatalf("expected identical archives after touching files, got %s and %s", sha1, sha2)
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(firstData))
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	if gr.Name != "" || !gr.ModTime.IsZero() {
+		t.Errorf("expected an empty gzip header, got name %q mtime %v", gr.Name, gr.ModTime)
+	}
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar.Next: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.ModTime.Unix() != 0 || hdr.Uid != 0 || hdr.Gid != 0 || hdr.Uname != "" || hdr.Gname != "" {
+			t.Errorf("%s: expected no timestamps or owners, got %+v", hdr.Name, hdr)
+		}
+		wantMode := int64(0o644)
+		if hdr.Name == "bin/setup.sh" {
+			wantMode = 0o755
+		}
+		if hdr.Mode != wantMode {
+			t.Errorf("%s: expected mode %o, got %o", hdr.Name, wantMode, hdr.Mode)
+		}
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("expected entries in sorted order, got %v", names)
+	}
+
+	_, kept, err := PackageWithOptions(dir, tf, PackageOptions{KeepFileMetadata: true})
+	if err != nil {
+		t.Fatalf("PackageWithOptions() error: %v", err)
+	}
+	if kept == sha1 {
+		t.Error("expected KeepFileMetadata to store the touched mtimes")
+	}
+}
+
+func TestPackageGoldenSHA256(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("execute bits are not stored on Windows file systems")
+	}
+	dir, tf := writePackageFixture(t)
+
+	_, sha, err := Package(dir, tf)
+	if err != nil {
+		t.Fatalf("Package() error: %v", err)
+	}
+	// Update only for an intended change to the archive format, or a Go
+	// release whose compress/flate output differs; every app's next deploy
+	// then uploads a new version even with --skip-unchanged.
+	const golden = "231fd43c0fa173c905ff74d4bd5fc84f25752059dd08f3afce6ceefbe7c58b89"
+	if sha != golden {
+		t.Fatalf("archive sha256 changed: got %s, want %s", sha, golden)
+	}
+}
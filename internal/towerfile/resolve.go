@@ -80,10 +80,19 @@ func ResolveSource(dir string, patterns []string) ([]string, error) {
 		}
 	}
 
-	sort.Strings(files)
+	sortPaths(files)
 	return files, nil
 }
 
+// sortPaths sorts native relative paths by their slash form, the names they
+// get in the archive, so entries come out in the same order on every OS: "\"
+// sorts after digits and capitals where "/" sorts before them.
+func sortPaths(paths []string) {
+	sort.Slice(paths, func(i, j int) bool {
+		return filepath.ToSlash(paths[i]) < filepath.ToSlash(paths[j])
+	})
+}
+
 // patternTargetsDotfiles returns true if any segment of the pattern (beyond
 // the leading "./" current-dir prefix) starts with a literal dot.
 func patternTargetsDotfiles(pattern string) bool {
@@ -1,6 +1,7 @@
 package towerfile
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -14,17 +15,91 @@ import (
 
 // Towerfile represents a parsed Towerfile.
 type Towerfile struct {
-	App        App         `toml:"app"`
-	Parameters []Parameter `toml:"parameters"`
+	// SchemaVersion declares the Towerfile features the file relies on.
+	// Zero (absent) means version 1.
+	SchemaVersion int         `toml:"schema_version"`
+	App           App         `toml:"app"`
+	Parameters    []Parameter `toml:"parameters"`
+	// Commands are named entrypoints runs can select instead of app.script.
+	Commands []Command `toml:"commands"`
+
+	// unknownKeys are keys Parse found but did not decode.
+	unknownKeys []string
+}
+
+// SupportedSchemaVersion is the newest Towerfile schema_version this build
+// understands.
+const SupportedSchemaVersion = 7
+
+// versionedFeature is a Towerfile key introduced after schema version 1.
+type versionedFeature struct {
+	key     string
+	version int
+	used    func(tf *Towerfile) bool
+}
+
+// versionedFeatures lists every key that requires a schema_version above 1.
+// Add an entry, and bump SupportedSchemaVersion if needed, for each new key.
+var versionedFeatures = []versionedFeature{
+	{key: "app.setup", version: 2, used: func(tf *Towerfile) bool { return tf.App.Setup != "" }},
+	{key: "app.default_input", version: 2, used: func(tf *Towerfile) bool { return tf.App.DefaultInput != nil }},
+	{key: "app.at_most_once", version: 3, used: func(tf *Towerfile) bool { return tf.App.AtMostOnce }},
+	{key: "commands", version: 4, used: func(tf *Towerfile) bool { return len(tf.Commands) > 0 }},
+	{key: "app.retries", version: 5, used: func(tf *Towerfile) bool { return tf.App.Retries != nil }},
+	{key: "app.python", version: 6, used: func(tf *Towerfile) bool { return tf.App.Python != "" }},
+	{key: `parameters of type "array" or "object"`, version: 7, used: func(tf *Towerfile) bool { return tf.usesStructuredParameters() }},
+}
+
+// usesStructuredParameters reports whether any parameter of the app or its
+// commands is an array or object, or sets the keys only those take.
+func (tf *Towerfile) usesStructuredParameters() bool {
+	lists := [][]Parameter{tf.Parameters}
+	for _, cmd := range tf.Commands {
+		lists = append(lists, cmd.Parameters)
+	}
+	for _, params := range lists {
+		for _, p := range params {
+			if p.Type == "array" || p.Type == "object" || p.ItemsType != "" || p.Items != nil || p.Properties != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EffectiveSchemaVersion returns the declared schema version, treating an
+// absent schema_version as 1.
+func (tf *Towerfile) EffectiveSchemaVersion() int {
+	if tf.SchemaVersion == 0 {
+		return 1
+	}
+	return tf.SchemaVersion
 }
 
 // App holds the [app] section of a Towerfile.
 type App struct {
-	Name        string   `toml:"name"`
+	Name string `toml:"name"`
+	// Description is copied to the app on deploy. It is informational,
+	// so older tools may ignore it and it needs no schema_version.
+	Description string   `toml:"description"`
 	Script      string   `toml:"script"`
+	Setup       string   `toml:"setup"`
 	Source      []string `toml:"source"`
 	ImportPaths []string `toml:"import_paths"`
 	Timeout     *Timeout `toml:"timeout"`
+	// DefaultInput holds the [app.default_input] table, merged under each
+	// run's input. Nil when the table is absent.
+	DefaultInput map[string]any `toml:"default_input"`
+	// AtMostOnce makes runs of the app default to at-most-once: a run whose
+	// process started is marked dead instead of retried when its lease
+	// expires.
+	AtMostOnce bool `toml:"at_most_once"`
+	// Retries holds the [app.retries] section, the default max_retries of
+	// runs created without one.
+	Retries *Retries `toml:"retries"`
+	// Python is the interpreter version ("3.12") the app's runs need; only
+	// runners that declare it lease them.
+	Python string `toml:"python"`
 }
 
 // Timeout holds the [app.timeout] section.
@@ -32,12 +107,49 @@ type Timeout struct {
 	Seconds int `toml:"seconds"`
 }
 
+// Retries holds the [app.retries] section.
+type Retries struct {
+	Max int `toml:"max"`
+}
+
 // Parameter holds a single [[parameters]] entry.
 type Parameter struct {
 	Name        string `toml:"name"`
 	Description string `toml:"description"`
 	Type        string `toml:"type"`
-	Default     any    `toml:"default"`
+	// Default is a TOML value of Type; arrays and inline tables for the
+	// "array" and "object" types.
+	Default any `toml:"default"`
+	// ItemsType is the type of each element of an "array" parameter, a
+	// shorthand for Items = {type = ItemsType}.
+	ItemsType string `toml:"items_type"`
+	// Items, for "array" parameters, and Properties, for "object" ones, are
+	// JSON Schema copied into the params schema as they are.
+	Items      map[string]any `toml:"items"`
+	Properties map[string]any `toml:"properties"`
+}
+
+// Command holds a single [[commands]] entry: a named entrypoint packaged
+// with the app. Timeout and Parameters replace the app's when set.
+type Command struct {
+	Name       string      `toml:"name"`
+	Script     string      `toml:"script"`
+	Timeout    *Timeout    `toml:"timeout"`
+	Parameters []Parameter `toml:"parameters"`
+}
+
+// Scripts returns app.script followed by each command's script, without
+// duplicates.
+func (tf *Towerfile) Scripts() []string {
+	scripts := []string{tf.App.Script}
+	seen := map[string]bool{filepath.Clean(tf.App.Script): true}
+	for _, cmd := range tf.Commands {
+		if cleaned := filepath.Clean(cmd.Script); !seen[cleaned] {
+			seen[cleaned] = true
+			scripts = append(scripts, cmd.Script)
+		}
+	}
+	return scripts
 }
 
 var (
@@ -50,6 +162,8 @@ var allowedParamTypes = map[string]bool{
 	"number":  true,
 	"integer": true,
 	"boolean": true,
+	"array":   true,
+	"object":  true,
 }
 
 var allowedScriptExts = map[string]bool{
@@ -57,17 +171,61 @@ var allowedScriptExts = map[string]bool{
 	".sh": true,
 }
 
-// Parse reads TOML from r and returns a parsed Towerfile.
+// Parse reads TOML from r and returns a parsed Towerfile. Keys it does not
+// recognize are kept for Validate to report.
 func Parse(r io.Reader) (*Towerfile, error) {
 	var tf Towerfile
-	if _, err := toml.NewDecoder(r).Decode(&tf); err != nil {
+	md, err := toml.NewDecoder(r).Decode(&tf)
+	if err != nil {
 		return nil, fmt.Errorf("parsing towerfile: %w", err)
 	}
+	for _, key := range md.Undecoded() {
+		if !isFreeFormKey(key) {
+			tf.unknownKeys = append(tf.unknownKeys, key.String())
+		}
+	}
 	return &tf, nil
 }
 
-// Validate checks all Towerfile rules and returns the first error found.
-func Validate(tf *Towerfile) error {
+// isFreeFormKey reports whether key lies inside a table holding arbitrary
+// values: default_input and a parameter's default, items and properties.
+// The decoder reports the keys inside their nested tables as undecoded.
+func isFreeFormKey(key toml.Key) bool {
+	if len(key) > 2 && key[0] == "app" && key[1] == "default_input" {
+		return true
+	}
+	if len(key) > 2 && key[0] == "commands" && key[1] == "parameters" {
+		key = key[1:]
+	}
+	if len(key) > 2 && key[0] == "parameters" {
+		switch key[1] {
+		case "default", "items", "properties":
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks all Towerfile rules and returns the first error found,
+// along with warnings for keys this build does not recognize. Unknown keys
+// are ignored rather than rejected so older tools can still deploy files
+// written for newer ones; callers should show the warnings.
+func Validate(tf *Towerfile) ([]string, error) {
+	var warnings []string
+	for _, key := range tf.unknownKeys {
+		warnings = append(warnings, fmt.Sprintf("unknown Towerfile key %q is ignored", key))
+	}
+	return warnings, checkRules(tf)
+}
+
+func checkRules(tf *Towerfile) error {
+	if tf.SchemaVersion < 0 {
+		return fmt.Errorf("schema_version must be >= 1, got %d", tf.SchemaVersion)
+	}
+	if tf.SchemaVersion > SupportedSchemaVersion {
+		return fmt.Errorf("Towerfile declares schema_version %d, but this version of minitower supports up to %d; upgrade to deploy it", tf.SchemaVersion, SupportedSchemaVersion)
+	}
+
 	if tf.App.Name == "" {
 		return ErrMissingName
 	}
@@ -75,6 +233,10 @@ func Validate(tf *Towerfile) error {
 		return fmt.Errorf("app.name: %w", err)
 	}
 
+	if err := validate.ValidateDescription(tf.App.Description); err != nil {
+		return fmt.Errorf("app.description: %w", err)
+	}
+
 	if tf.App.Script == "" {
 		return ErrMissingScript
 	}
@@ -86,6 +248,15 @@ func Validate(tf *Towerfile) error {
 		return fmt.Errorf("app.script must not contain path traversal")
 	}
 
+	if tf.App.Setup != "" {
+		if ext := strings.ToLower(filepath.Ext(tf.App.Setup)); ext != ".sh" {
+			return fmt.Errorf("app.setup must end in .sh, got %q", ext)
+		}
+		if containsTraversal(tf.App.Setup) {
+			return fmt.Errorf("app.setup must not contain path traversal")
+		}
+	}
+
 	for _, pattern := range tf.App.Source {
 		if containsTraversal(pattern) {
 			return fmt.Errorf("source pattern %q must not escape the project root", pattern)
@@ -101,11 +272,66 @@ func Validate(tf *Towerfile) error {
 	if tf.App.Timeout != nil && tf.App.Timeout.Seconds < 1 {
 		return fmt.Errorf("app.timeout.seconds must be >= 1, got %d", tf.App.Timeout.Seconds)
 	}
+	if tf.App.Retries != nil && tf.App.Retries.Max < 0 {
+		return fmt.Errorf("app.retries.max must be >= 0, got %d", tf.App.Retries.Max)
+	}
+	if tf.App.Python != "" {
+		if err := validate.ValidatePythonVersion(tf.App.Python); err != nil {
+			return fmt.Errorf("app.python: %w", err)
+		}
+	}
 
-	seen := make(map[string]bool, len(tf.Parameters))
-	for i, param := range tf.Parameters {
+	if err := checkParameters("parameters", tf.Parameters); err != nil {
+		return err
+	}
+
+	commandNames := make(map[string]bool, len(tf.Commands))
+	for i, cmd := range tf.Commands {
+		field := fmt.Sprintf("commands[%d]", i)
+		if cmd.Name == "" {
+			return fmt.Errorf("%s.name is required", field)
+		}
+		if err := validate.ValidateSlug(cmd.Name); err != nil {
+			return fmt.Errorf("%s.name: %w", field, err)
+		}
+		if commandNames[cmd.Name] {
+			return fmt.Errorf("duplicate command name %q", cmd.Name)
+		}
+		commandNames[cmd.Name] = true
+
+		if cmd.Script == "" {
+			return fmt.Errorf("%s.script is required", field)
+		}
+		if ext := strings.ToLower(filepath.Ext(cmd.Script)); !allowedScriptExts[ext] {
+			return fmt.Errorf("%s.script must end in .py or .sh, got %q", field, ext)
+		}
+		if containsTraversal(cmd.Script) {
+			return fmt.Errorf("%s.script must not contain path traversal", field)
+		}
+		if cmd.Timeout != nil && cmd.Timeout.Seconds < 1 {
+			return fmt.Errorf("%s.timeout.seconds must be >= 1, got %d", field, cmd.Timeout.Seconds)
+		}
+		if err := checkParameters(field+".parameters", cmd.Parameters); err != nil {
+			return err
+		}
+	}
+
+	declared := tf.EffectiveSchemaVersion()
+	for _, f := range versionedFeatures {
+		if f.version > declared && f.used(tf) {
+			return fmt.Errorf("Towerfile uses features requiring schema_version >= %d (%s); declare schema_version = %d", f.version, f.key, f.version)
+		}
+	}
+
+	return nil
+}
+
+// checkParameters validates a parameter list; field names it in errors.
+func checkParameters(field string, params []Parameter) error {
+	seen := make(map[string]bool, len(params))
+	for i, param := range params {
 		if param.Name == "" {
-			return fmt.Errorf("parameters[%d].name is required", i)
+			return fmt.Errorf("%s[%d].name is required", field, i)
 		}
 		if seen[param.Name] {
 			return fmt.Errorf("duplicate parameter name %q", param.Name)
@@ -117,46 +343,104 @@ func Validate(tf *Towerfile) error {
 			typ = "string"
 		}
 		if !allowedParamTypes[typ] {
-			return fmt.Errorf("parameters[%d].type must be one of string, number, integer, boolean; got %q", i, typ)
+			return fmt.Errorf("%s[%d].type must be one of string, number, integer, boolean, array, object; got %q", field, i, typ)
+		}
+		if err := checkStructuredParameter(param, typ, fmt.Sprintf("%s[%d]", field, i)); err != nil {
+			return err
 		}
 
 		if param.Default != nil {
-			if err := checkDefaultType(param.Default, typ, i); err != nil {
+			if err := checkDefaultType(param.Default, typ, fmt.Sprintf("%s[%d]", field, i)); err != nil {
 				return err
 			}
+			if typ == "array" || typ == "object" {
+				if err := checkStructuredDefault(param, fmt.Sprintf("%s[%d]", field, i)); err != nil {
+					return err
+				}
+			}
 		}
 	}
+	return nil
+}
 
+// checkStructuredParameter validates the keys of array and object
+// parameters: items_type or items only on arrays, properties only on
+// objects, and the schema they make. field names the parameter in errors.
+func checkStructuredParameter(param Parameter, typ, field string) error {
+	if (param.ItemsType != "" || param.Items != nil) && typ != "array" {
+		return fmt.Errorf("%s: items_type and items are only allowed on array parameters", field)
+	}
+	if param.ItemsType != "" && param.Items != nil {
+		return fmt.Errorf("%s: set items_type or items, not both", field)
+	}
+	if param.ItemsType != "" && !allowedParamTypes[param.ItemsType] {
+		return fmt.Errorf("%s.items_type must be one of string, number, integer, boolean, array, object; got %q", field, param.ItemsType)
+	}
+	if param.Properties != nil && typ != "object" {
+		return fmt.Errorf("%s: properties are only allowed on object parameters", field)
+	}
+	if typ != "array" && typ != "object" {
+		return nil
+	}
+	if err := validate.ValidateJSONSchema(parameterSchema(param)); err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
 	return nil
 }
 
 // checkDefaultType validates that a TOML-parsed default value is compatible
-// with the declared parameter type.
-func checkDefaultType(val any, typ string, idx int) error {
+// with the declared parameter type. field names the parameter in errors.
+func checkDefaultType(val any, typ string, field string) error {
 	switch typ {
 	case "string":
 		if _, ok := val.(string); !ok {
-			return fmt.Errorf("parameters[%d].default must be a string, got %T", idx, val)
+			return fmt.Errorf("%s.default must be a string, got %T", field, val)
 		}
 	case "boolean":
 		if _, ok := val.(bool); !ok {
-			return fmt.Errorf("parameters[%d].default must be a boolean, got %T", idx, val)
+			return fmt.Errorf("%s.default must be a boolean, got %T", field, val)
 		}
 	case "integer":
 		if _, ok := val.(int64); !ok {
-			return fmt.Errorf("parameters[%d].default must be an integer, got %T", idx, val)
+			return fmt.Errorf("%s.default must be an integer, got %T", field, val)
 		}
 	case "number":
 		switch val.(type) {
 		case float64, int64:
 			// both are valid for "number"
 		default:
-			return fmt.Errorf("parameters[%d].default must be a number, got %T", idx, val)
+			return fmt.Errorf("%s.default must be a number, got %T", field, val)
+		}
+	case "array":
+		if _, ok := val.([]any); !ok {
+			return fmt.Errorf("%s.default must be an array, got %T", field, val)
+		}
+	case "object":
+		if _, ok := val.(map[string]any); !ok {
+			return fmt.Errorf("%s.default must be a table, got %T", field, val)
 		}
 	}
 	return nil
 }
 
+// checkStructuredDefault validates an array or object default against the
+// parameter's items or properties, in the JSON form runs receive it in.
+// field names the parameter in errors.
+func checkStructuredDefault(param Parameter, field string) error {
+	data, err := json.Marshal(param.Default)
+	if err != nil {
+		return fmt.Errorf("%s.default: %w", field, err)
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("%s.default: %w", field, err)
+	}
+	if err := validate.ValidateJSONInput(value, parameterSchema(param)); err != nil {
+		return fmt.Errorf("%s.default does not match the parameter: %w", field, err)
+	}
+	return nil
+}
+
 // containsTraversal checks if a cleaned path escapes the project root.
 func containsTraversal(p string) bool {
 	cleaned := filepath.Clean(p)
@@ -179,22 +463,36 @@ func ParamsSchemaFromParameters(params []Parameter) map[string]any {
 	}
 	properties := make(map[string]any, len(params))
 	for _, p := range params {
-		prop := map[string]any{}
-		typ := p.Type
-		if typ == "" {
-			typ = "string"
-		}
-		prop["type"] = typ
-		if p.Description != "" {
-			prop["description"] = p.Description
-		}
-		if p.Default != nil {
-			prop["default"] = p.Default
-		}
-		properties[p.Name] = prop
+		properties[p.Name] = parameterSchema(p)
 	}
 	return map[string]any{
 		"type":       "object",
 		"properties": properties,
 	}
 }
+
+// parameterSchema returns the JSON Schema of one parameter.
+func parameterSchema(p Parameter) map[string]any {
+	prop := map[string]any{}
+	typ := p.Type
+	if typ == "" {
+		typ = "string"
+	}
+	prop["type"] = typ
+	if p.ItemsType != "" {
+		prop["items"] = map[string]any{"type": p.ItemsType}
+	}
+	if p.Items != nil {
+		prop["items"] = p.Items
+	}
+	if p.Properties != nil {
+		prop["properties"] = p.Properties
+	}
+	if p.Description != "" {
+		prop["description"] = p.Description
+	}
+	if p.Default != nil {
+		prop["default"] = p.Default
+	}
+	return prop
+}
@@ -1,6 +1,7 @@
 package towerfile
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -100,7 +101,7 @@ script = "run.sh"
 	if err != nil {
 		t.Fatalf("Parse() error: %v", err)
 	}
-	if err := Validate(tf); err != nil {
+	if _, err := Validate(tf); err != nil {
 		t.Errorf("Validate() error: %v", err)
 	}
 }
@@ -115,7 +116,7 @@ func TestParseInvalidTOML(t *testing.T) {
 
 func TestValidateMissingName(t *testing.T) {
 	tf := &Towerfile{App: App{Script: "main.py"}}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err != ErrMissingName {
 		t.Errorf("Validate() = %v, want ErrMissingName", err)
 	}
@@ -123,7 +124,7 @@ func TestValidateMissingName(t *testing.T) {
 
 func TestValidateMissingScript(t *testing.T) {
 	tf := &Towerfile{App: App{Name: "my-app"}}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err != ErrMissingScript {
 		t.Errorf("Validate() = %v, want ErrMissingScript", err)
 	}
@@ -142,7 +143,7 @@ func TestValidateInvalidSlug(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tf := &Towerfile{App: App{Name: tt.slug, Script: "main.py"}}
-			if err := Validate(tf); err == nil {
+			if _, err := Validate(tf); err == nil {
 				t.Errorf("Validate() should reject slug %q", tt.slug)
 			}
 		})
@@ -151,7 +152,7 @@ func TestValidateInvalidSlug(t *testing.T) {
 
 func TestValidateInvalidScriptExtension(t *testing.T) {
 	tf := &Towerfile{App: App{Name: "my-app", Script: "main.rb"}}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err == nil {
 		t.Fatal("Validate() should reject .rb extension")
 	}
@@ -162,7 +163,7 @@ func TestValidateInvalidScriptExtension(t *testing.T) {
 
 func TestValidateScriptTraversal(t *testing.T) {
 	tf := &Towerfile{App: App{Name: "my-app", Script: "../escape.py"}}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err == nil {
 		t.Fatal("Validate() should reject path traversal in script")
 	}
@@ -174,7 +175,7 @@ func TestValidateSourceTraversal(t *testing.T) {
 		Script: "main.py",
 		Source:  []string{"../../etc/passwd"},
 	}}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err == nil {
 		t.Fatal("Validate() should reject path traversal in source")
 	}
@@ -186,19 +187,79 @@ func TestValidateImportPathsTraversal(t *testing.T) {
 		Script:      "main.py",
 		ImportPaths: []string{"../outside"},
 	}}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err == nil {
 		t.Fatal("Validate() should reject path traversal in import_paths")
 	}
 }
 
+func TestParseSetupScript(t *testing.T) {
+	input := `
+schema_version = 2
+
+[app]
+name = "shell-app"
+script = "run.sh"
+setup = "scripts/setup.sh"
+`
+	tf, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if tf.App.Setup != "scripts/setup.sh" {
+		t.Errorf("Setup = %q, want %q", tf.App.Setup, "scripts/setup.sh")
+	}
+	if _, err := Validate(tf); err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+}
+
+func TestValidateSetupScript(t *testing.T) {
+	for _, setup := range []string{"setup.py", "setup", "../setup.sh", "scripts/../../setup.sh"} {
+		tf := &Towerfile{SchemaVersion: 2, App: App{Name: "my-app", Script: "main.sh", Setup: setup}}
+		if _, err := Validate(tf); err == nil || !strings.Contains(err.Error(), "app.setup") {
+			t.Errorf("Validate() with setup %q: expected app.setup error, got %v", setup, err)
+		}
+	}
+}
+
+func TestParseDescription(t *testing.T) {
+	input := `
+[app]
+name = "my-app"
+description = "Nightly sales ETL"
+script = "main.py"
+`
+	tf, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if tf.App.Description != "Nightly sales ETL" {
+		t.Errorf("Description = %q, want %q", tf.App.Description, "Nightly sales ETL")
+	}
+	// Description needs no schema_version and is not an unknown key.
+	warnings, err := Validate(tf)
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("Validate() = %v, %v; want no warnings or error", warnings, err)
+	}
+
+	tf.App.Description = strings.Repeat("é", 500)
+	if _, err := Validate(tf); err != nil {
+		t.Fatalf("Validate() with 500 characters: %v", err)
+	}
+	tf.App.Description += "x"
+	if _, err := Validate(tf); err == nil || !strings.Contains(err.Error(), "app.description") {
+		t.Fatalf("Validate() with 501 characters: expected app.description error, got %v", err)
+	}
+}
+
 func TestValidateTimeoutZero(t *testing.T) {
 	tf := &Towerfile{App: App{
 		Name:    "my-app",
 		Script:  "main.py",
 		Timeout: &Timeout{Seconds: 0},
 	}}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err == nil {
 		t.Fatal("Validate() should reject timeout of 0")
 	}
@@ -210,7 +271,7 @@ func TestValidateTimeoutNegative(t *testing.T) {
 		Script:  "main.py",
 		Timeout: &Timeout{Seconds: -5},
 	}}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err == nil {
 		t.Fatal("Validate() should reject negative timeout")
 	}
@@ -224,7 +285,7 @@ func TestValidateDuplicateParameterNames(t *testing.T) {
 			{Name: "region"},
 		},
 	}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err == nil {
 		t.Fatal("Validate() should reject duplicate parameter names")
 	}
@@ -238,7 +299,7 @@ func TestValidateEmptyParameterName(t *testing.T) {
 		App:        App{Name: "my-app", Script: "main.py"},
 		Parameters: []Parameter{{Name: ""}},
 	}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err == nil {
 		t.Fatal("Validate() should reject empty parameter name")
 	}
@@ -249,7 +310,7 @@ func TestValidateInvalidParameterType(t *testing.T) {
 		App:        App{Name: "my-app", Script: "main.py"},
 		Parameters: []Parameter{{Name: "x", Type: "float"}},
 	}
-	err := Validate(tf)
+	_, err := Validate(tf)
 	if err == nil {
 		t.Fatal("Validate() should reject invalid parameter type")
 	}
@@ -278,7 +339,7 @@ func TestValidateDefaultTypeMismatch(t *testing.T) {
 				App:        App{Name: "my-app", Script: "main.py"},
 				Parameters: []Parameter{{Name: "x", Type: tt.typ, Default: tt.def}},
 			}
-			err := Validate(tf)
+			_, err := Validate(tf)
 			if tt.wantErr && err == nil {
 				t.Fatal("Validate() should have returned an error")
 			}
@@ -295,7 +356,7 @@ func TestValidateDefaultTypeOmitted(t *testing.T) {
 		App:        App{Name: "my-app", Script: "main.py"},
 		Parameters: []Parameter{{Name: "x", Default: "hello"}},
 	}
-	if err := Validate(tf); err != nil {
+	if _, err := Validate(tf); err != nil {
 		t.Fatalf("Validate() unexpected error: %v", err)
 	}
 }
@@ -314,7 +375,7 @@ func TestValidateValid(t *testing.T) {
 			{Name: "count", Type: "integer", Default: int64(10)},
 		},
 	}
-	if err := Validate(tf); err != nil {
+	if _, err := Validate(tf); err != nil {
 		t.Fatalf("Validate() unexpected error: %v", err)
 	}
 }
@@ -388,3 +449,386 @@ func TestParamsSchemaDefaultType(t *testing.T) {
 		t.Errorf("foo.type = %v, want string", foo["type"])
 	}
 }
+
+func TestValidateSchemaVersionDefaultsToOne(t *testing.T) {
+	tf, err := Parse(strings.NewReader(`
+[app]
+name = "my-app"
+script = "main.py"
+`))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if tf.EffectiveSchemaVersion() != 1 {
+		t.Fatalf("EffectiveSchemaVersion() = %d, want 1", tf.EffectiveSchemaVersion())
+	}
+	warnings, err := Validate(tf)
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("Validate() = %v, %v; want no warnings or error", warnings, err)
+	}
+}
+
+func TestValidateV1FileWithV2KeysErrors(t *testing.T) {
+	for _, header := range []string{"", "schema_version = 1\n"} {
+		tf, err := Parse(strings.NewReader(header + `
+[app]
+name = "my-app"
+script = "main.sh"
+setup = "setup.sh"
+`))
+		if err != nil {
+			t.Fatalf("Parse() error: %v", err)
+		}
+		_, err = Validate(tf)
+		if err == nil || !strings.Contains(err.Error(), "requiring schema_version >= 2") {
+			t.Errorf("Validate() with header %q = %v, want schema_version error", header, err)
+		}
+	}
+}
+
+func TestParseDefaultInput(t *testing.T) {
+	src := `
+[app]
+name = "my-app"
+script = "main.sh"
+
+[app.default_input]
+region = "us"
+
+[app.default_input.limits]
+rows = 100
+`
+	tf, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	limits, _ := tf.App.DefaultInput["limits"].(map[string]any)
+	if tf.App.DefaultInput["region"] != "us" || limits["rows"] != int64(100) {
+		t.Fatalf("DefaultInput = %v", tf.App.DefaultInput)
+	}
+	if _, err := Validate(tf); err == nil || !strings.Contains(err.Error(), "app.default_input") {
+		t.Fatalf("Validate() = %v, want schema_version error naming app.default_input", err)
+	}
+
+	tf, err = Parse(strings.NewReader("schema_version = 2\n" + src))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if warnings, err := Validate(tf); err != nil || len(warnings) != 0 {
+		t.Fatalf("Validate() = %v, %v; want no warnings or error", warnings, err)
+	}
+}
+
+func TestParseAtMostOnceRequiresSchemaVersion3(t *testing.T) {
+	src := `
+[app]
+name = "my-app"
+script = "main.py"
+at_most_once = true
+`
+	tf, err := Parse(strings.NewReader("schema_version = 2\n" + src))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if !tf.App.AtMostOnce {
+		t.Fatal("expected AtMostOnce to be set")
+	}
+	if _, err := Validate(tf); err == nil || !strings.Contains(err.Error(), "app.at_most_once") {
+		t.Fatalf("Validate() = %v, want schema_version error naming app.at_most_once", err)
+	}
+
+	tf, err = Parse(strings.NewReader("schema_version = 3\n" + src))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if warnings, err := Validate(tf); err != nil || len(warnings) != 0 {
+		t.Fatalf("Validate() = %v, %v; want no warnings or error", warnings, err)
+	}
+}
+
+func TestParseRetries(t *testing.T) {
+	src := `
+[app]
+name = "my-app"
+script = "main.py"
+
+[app.retries]
+max = 3
+`
+	tf, err := Parse(strings.NewReader("schema_version = 4\n" + src))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if tf.App.Retries == nil || tf.App.Retries.Max != 3 {
+		t.Fatalf("expected retries max 3, got %+v", tf.App.Retries)
+	}
+	if _, err := Validate(tf); err == nil || !strings.Contains(err.Error(), "app.retries") {
+		t.Fatalf("Validate() = %v, want schema_version error naming app.retries", err)
+	}
+
+	tf, err = Parse(strings.NewReader("schema_version = 5\n" + src))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if warnings, err := Validate(tf); err != nil || len(warnings) != 0 {
+		t.Fatalf("Validate() = %v, %v; want no warnings or error", warnings, err)
+	}
+
+	tf.App.Retries.Max = -1
+	if _, err := Validate(tf); err == nil || !strings.Contains(err.Error(), "app.retries.max must be >= 0") {
+		t.Fatalf("Validate() = %v, want negative max rejected", err)
+	}
+}
+
+func TestParsePython(t *testing.T) {
+	src := `
+[app]
+name = "my-app"
+script = "main.py"
+python = "3.12"
+`
+	tf, err := Parse(strings.NewReader("schema_version = 5\n" + src))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if tf.App.Python != "3.12" {
+		t.Fatalf("expected python 3.12, got %q", tf.App.Python)
+	}
+	if _, err := Validate(tf); err == nil || !strings.Contains(err.Error(), "app.python") {
+		t.Fatalf("Validate() = %v, want schema_version error naming app.python", err)
+	}
+
+	tf, err = Parse(strings.NewReader("schema_version = 6\n" + src))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if warnings, err := Validate(tf); err != nil || len(warnings) != 0 {
+		t.Fatalf("Validate() = %v, %v; want no warnings or error", warnings, err)
+	}
+
+	for _, bad := range []string{"3", "3.12.1", "python3.12", " 3.12"} {
+		tf.App.Python = bad
+		if _, err := Validate(tf); err == nil || !strings.Contains(err.Error(), "app.python") {
+			t.Fatalf("Validate() with python %q = %v, want it rejected", bad, err)
+		}
+	}
+}
+
+func TestParseStructuredParameters(t *testing.T) {
+	src := `
+[app]
+name = "my-app"
+script = "main.py"
+
+[[parameters]]
+name = "ids"
+type = "array"
+items_type = "integer"
+default = [1, 2, 3]
+
+[[parameters]]
+name = "options"
+type = "object"
+default = { depth = 2, tags = { team = "data" } }
+
+[parameters.properties.depth]
+type = "integer"
+
+[[commands]]
+name = "report"
+script = "report.py"
+
+[[commands.parameters]]
+name = "regions"
+type = "array"
+items = { type = "string", minLength = 2 }
+default = ["eu", "us"]
+`
+	tf, err := Parse(strings.NewReader("schema_version = 6\n" + src))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if _, err := Validate(tf); err == nil || !strings.Contains(err.Error(), "schema_version >= 7") {
+		t.Fatalf("Validate() = %v, want a schema_version 7 error", err)
+	}
+
+	tf, err = Parse(strings.NewReader("schema_version = 7\n" + src))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if warnings, err := Validate(tf); err != nil || len(warnings) != 0 {
+		t.Fatalf("Validate() = %v, %v; want no warnings or error", warnings, err)
+	}
+
+	props := ParamsSchemaFromParameters(tf.Parameters)["properties"].(map[string]any)
+	ids := props["ids"].(map[string]any)
+	if ids["type"] != "array" || !reflect.DeepEqual(ids["items"], map[string]any{"type": "integer"}) || !reflect.DeepEqual(ids["default"], []any{int64(1), int64(2), int64(3)}) {
+		t.Errorf("ids schema = %v", ids)
+	}
+	options := props["options"].(map[string]any)
+	if options["type"] != "object" || !reflect.DeepEqual(options["properties"], map[string]any{"depth": map[string]any{"type": "integer"}}) {
+		t.Errorf("options schema = %v", options)
+	}
+	regions := ParamsSchemaFromParameters(tf.Commands[0].Parameters)["properties"].(map[string]any)["regions"].(map[string]any)
+	if !reflect.DeepEqual(regions["items"], map[string]any{"type": "string", "minLength": int64(2)}) {
+		t.Errorf("regions schema = %v", regions)
+	}
+}
+
+func TestValidateStructuredParameters(t *testing.T) {
+	tests := []struct {
+		name    string
+		param   Parameter
+		wantErr string
+	}{
+		{"array default", Parameter{Type: "array", Default: []any{"a", int64(1)}}, ""},
+		{"array of integers", Parameter{Type: "array", ItemsType: "integer", Default: []any{int64(1)}}, ""},
+		{"object default", Parameter{Type: "object", Default: map[string]any{"a": []any{true}}}, ""},
+		{"array default not an array", Parameter{Type: "array", Default: "1,2"}, "default must be an array"},
+		{"object default not a table", Parameter{Type: "object", Default: []any{}}, "default must be a table"},
+		{"item of the wrong type", Parameter{Type: "array", ItemsType: "integer", Default: []any{int64(1), "two"}}, "default does not match"},
+		{"property of the wrong type", Parameter{Type: "object", Properties: map[string]any{"depth": map[string]any{"type": "integer"}}, Default: map[string]any{"depth": "deep"}}, "default does not match"},
+		{"unknown items_type", Parameter{Type: "array", ItemsType: "float"}, "items_type must be one of"},
+		{"items_type and items", Parameter{Type: "array", ItemsType: "string", Items: map[string]any{"type": "string"}}, "not both"},
+		{"items_type on a string", Parameter{Type: "string", ItemsType: "string"}, "only allowed on array"},
+		{"properties on an array", Parameter{Type: "array", Properties: map[string]any{}}, "only allowed on object"},
+		{"property that is not a schema", Parameter{Type: "object", Properties: map[string]any{"depth": "integer"}}, "must be an object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.param.Name = "x"
+			tf := &Towerfile{
+				SchemaVersion: 7,
+				App:           App{Name: "my-app", Script: "main.py"},
+				Parameters:    []Parameter{tt.param},
+			}
+			_, err := Validate(tf)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Validate() unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseCommands(t *testing.T) {
+	tf, err := Parse(strings.NewReader(`
+schema_version = 4
+
+[app]
+name = "my-app"
+script = "main.py"
+
+[[commands]]
+name = "report"
+script = "tasks/report.py"
+
+[commands.timeout]
+seconds = 60
+
+[[commands.parameters]]
+name = "month"
+type = "integer"
+default = 1
+
+[[commands]]
+name = "cleanup"
+script = "cleanup.sh"
+`))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if warnings, err := Validate(tf); err != nil || len(warnings) != 0 {
+		t.Fatalf("Validate() = %v, %v; want no warnings or error", warnings, err)
+	}
+	if len(tf.Commands) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(tf.Commands))
+	}
+	report := tf.Commands[0]
+	if report.Name != "report" || report.Script != "tasks/report.py" {
+		t.Fatalf("unexpected first command: %+v", report)
+	}
+	if report.Timeout == nil || report.Timeout.Seconds != 60 {
+		t.Fatalf("expected report timeout 60, got %+v", report.Timeout)
+	}
+	if len(report.Parameters) != 1 || report.Parameters[0].Name != "month" {
+		t.Fatalf("unexpected report parameters: %+v", report.Parameters)
+	}
+	if tf.Commands[1].Timeout != nil || len(tf.Commands[1].Parameters) != 0 {
+		t.Fatalf("expected cleanup without timeout or parameters, got %+v", tf.Commands[1])
+	}
+
+	want := []string{"main.py", "tasks/report.py", "cleanup.sh"}
+	if got := tf.Scripts(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("Scripts() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateCommands(t *testing.T) {
+	base := func(commands ...Command) *Towerfile {
+		return &Towerfile{
+			SchemaVersion: 4,
+			App:           App{Name: "my-app", Script: "main.py"},
+			Commands:      commands,
+		}
+	}
+	tests := []struct {
+		name    string
+		tf      *Towerfile
+		wantErr string
+	}{
+		{"duplicate name", base(Command{Name: "report", Script: "a.py"}, Command{Name: "report", Script: "b.py"}), `duplicate command name "report"`},
+		{"missing name", base(Command{Script: "a.py"}), "commands[0].name is required"},
+		{"invalid name", base(Command{Name: "Report!", Script: "a.py"}), "commands[0].name"},
+		{"missing script", base(Command{Name: "report"}), "commands[0].script is required"},
+		{"bad extension", base(Command{Name: "report", Script: "report.rb"}), "commands[0].script must end in .py or .sh"},
+		{"traversal", base(Command{Name: "report", Script: "../report.py"}), "commands[0].script must not contain path traversal"},
+		{"zero timeout", base(Command{Name: "report", Script: "a.py", Timeout: &Timeout{}}), "commands[0].timeout.seconds must be >= 1"},
+		{"bad parameter default", base(Command{Name: "report", Script: "a.py", Parameters: []Parameter{{Name: "n", Type: "integer", Default: "x"}}}), "commands[0].parameters[0].default must be an integer"},
+		{"old schema version", &Towerfile{SchemaVersion: 3, App: App{Name: "my-app", Script: "main.py"}, Commands: []Command{{Name: "report", Script: "a.py"}}}, "schema_version >= 4 (commands)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Validate(tt.tf)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsNewerSchemaVersion(t *testing.T) {
+	tf := &Towerfile{SchemaVersion: SupportedSchemaVersion + 1, App: App{Name: "my-app", Script: "main.py"}}
+	if _, err := Validate(tf); err == nil || !strings.Contains(err.Error(), "upgrade") {
+		t.Fatalf("Validate() = %v, want upgrade error", err)
+	}
+}
+
+func TestValidateWarnsOnUnknownKeys(t *testing.T) {
+	tf, err := Parse(strings.NewReader(`
+schedule = "@daily"
+
+[app]
+name = "my-app"
+script = "main.py"
+args = ["--fast"]
+`))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	warnings, err := Validate(tf)
+	if err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	want := []string{`unknown Towerfile key "schedule" is ignored`, `unknown Towerfile key "app.args" is ignored`}
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings = %q, want %q", warnings, want)
+	}
+	for i := range want {
+		if warnings[i] != want[i] {
+			t.Errorf("warnings[%d] = %q, want %q", i, warnings[i], want[i])
+		}
+	}
+}
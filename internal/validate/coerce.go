@@ -0,0 +1,144 @@
+package validate
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	jsonIntegerPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+	jsonNumberPattern  = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+)
+
+// maxExactInteger is the largest integer a float64 holds exactly.
+const maxExactInteger = 1 << 53
+
+// CoerceJSONInput converts string values to the type the schema declares for
+// them, for inputs that were typed as environment-variable strings. "100"
+// becomes 100 where the schema wants an integer or number, and "true" or
+// "false" a boolean. Strings that could also be valid as they are, or that
+// do not convert exactly, are left alone so validation still reports them.
+// Maps and slices are updated in place; the returned value replaces input
+// and paths lists the converted values in validation error form ("$.a[0]"),
+// sorted.
+func CoerceJSONInput(input any, schema map[string]any) (any, []string) {
+	if schema == nil {
+		return input, nil
+	}
+	var paths []string
+	out := coerceValue(input, schema, "$", &paths)
+	sort.Strings(paths)
+	return out, paths
+}
+
+func coerceValue(value any, schema map[string]any, path string, paths *[]string) any {
+	switch v := value.(type) {
+	case string:
+		if coerced, ok := coerceString(v, schema); ok {
+			*paths = append(*paths, path)
+			return coerced
+		}
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		additional, _ := schema["additionalProperties"].(map[string]any)
+		for name, child := range v {
+			childSchema, ok := properties[name].(map[string]any)
+			if !ok {
+				childSchema = additional
+			}
+			if childSchema != nil {
+				v[name] = coerceValue(child, childSchema, path+"."+name, paths)
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				v[i] = coerceValue(item, items, fmt.Sprintf("%s[%d]", path, i), paths)
+			}
+		}
+	}
+	return value
+}
+
+// coerceString converts s to the one non-string type the schema allows it
+// to become. It does nothing when the schema has no type or accepts strings.
+func coerceString(s string, schema map[string]any) (any, bool) {
+	t, ok := schema["type"]
+	if !ok {
+		return nil, false
+	}
+	types, err := parseTypeList(t)
+	if err != nil {
+		return nil, false
+	}
+	var (
+		result any
+		found  int
+	)
+	for _, typ := range types {
+		var (
+			v  any
+			ok bool
+		)
+		switch typ {
+		case "string":
+			return nil, false
+		case "integer":
+			v, ok = parseExactInteger(s)
+		case "number":
+			v, ok = parseExactNumber(s)
+		case "boolean":
+			v, ok = parseBool(s)
+		}
+		if ok {
+			// integer and number agree on the same string; they are one
+			// candidate, not an ambiguity.
+			if found == 0 || result != v {
+				found++
+			}
+			result = v
+		}
+	}
+	if found != 1 {
+		return nil, false
+	}
+	return result, true
+}
+
+func parseExactInteger(s string) (any, bool) {
+	if !jsonIntegerPattern.MatchString(s) {
+		return nil, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n > maxExactInteger || n < -maxExactInteger {
+		return nil, false
+	}
+	return float64(n), true
+}
+
+func parseExactNumber(s string) (any, bool) {
+	if !jsonNumberPattern.MatchString(s) {
+		return nil, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) {
+		return nil, false
+	}
+	if math.Trunc(f) == f && math.Abs(f) > maxExactInteger {
+		return nil, false
+	}
+	return f, true
+}
+
+func parseBool(s string) (any, bool) {
+	switch s {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return nil, false
+}
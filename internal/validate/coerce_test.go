@@ -0,0 +1,105 @@
+package validate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCoerceJSONInputScalars(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema map[string]any
+		in     any
+		want   any
+	}{
+		{"integer", map[string]any{"type": "integer"}, "100", float64(100)},
+		{"negative integer", map[string]any{"type": "integer"}, "-7", float64(-7)},
+		{"number", map[string]any{"type": "number"}, "2.5", 2.5},
+		{"number exponent", map[string]any{"type": "number"}, "1e3", float64(1000)},
+		{"boolean true", map[string]any{"type": "boolean"}, "true", true},
+		{"boolean false", map[string]any{"type": "boolean"}, "false", false},
+		{"integer or number", map[string]any{"type": []any{"integer", "number"}}, "5", float64(5)},
+		{"integer or null", map[string]any{"type": []any{"integer", "null"}}, "5", float64(5)},
+
+		// Left alone for validation to report.
+		{"fraction for integer", map[string]any{"type": "integer"}, "1.5", "1.5"},
+		{"integer overflow", map[string]any{"type": "integer"}, "99999999999999999999", "99999999999999999999"},
+		{"integer beyond float precision", map[string]any{"type": "integer"}, "9007199254740993", "9007199254740993"},
+		{"number overflow", map[string]any{"type": "number"}, "1e400", "1e400"},
+		{"leading zero", map[string]any{"type": "integer"}, "007", "007"},
+		{"whitespace", map[string]any{"type": "integer"}, " 1", " 1"},
+		{"hex", map[string]any{"type": "number"}, "0x10", "0x10"},
+		{"infinity", map[string]any{"type": "number"}, "Inf", "Inf"},
+		{"boolean spelling", map[string]any{"type": "boolean"}, "True", "True"},
+		{"boolean as number", map[string]any{"type": "boolean"}, "1", "1"},
+		{"string allowed", map[string]any{"type": []any{"string", "integer"}}, "100", "100"},
+		{"no type", map[string]any{}, "100", "100"},
+		{"already typed", map[string]any{"type": "integer"}, float64(3), float64(3)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, paths := CoerceJSONInput(tc.in, tc.schema)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("CoerceJSONInput(%#v) = %#v, want %#v", tc.in, got, tc.want)
+			}
+			changed := !reflect.DeepEqual(tc.in, tc.want)
+			if changed != (len(paths) == 1) || (changed && paths[0] != "$") {
+				t.Fatalf("paths = %v", paths)
+			}
+			if err := ValidateJSONInput(got, tc.schema); changed && err != nil {
+				t.Fatalf("coerced value does not validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestCoerceJSONInputNested(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"batch_size": map[string]any{"type": "integer"},
+			"name":       map[string]any{"type": "string"},
+			"options": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"dry": map[string]any{"type": "boolean"},
+				},
+				"additionalProperties": map[string]any{"type": "number"},
+			},
+			"ids": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+		},
+	}
+	input := map[string]any{
+		"batch_size": "100",
+		"name":       "42",
+		"options":    map[string]any{"dry": "true", "ratio": "0.25", "label": "x"},
+		"ids":        []any{"1", float64(2), "three"},
+		"extra":      "7",
+	}
+
+	got, paths := CoerceJSONInput(input, schema)
+	want := map[string]any{
+		"batch_size": float64(100),
+		"name":       "42",
+		"options":    map[string]any{"dry": true, "ratio": 0.25, "label": "x"},
+		"ids":        []any{float64(1), float64(2), "three"},
+		"extra":      "7",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("coerced input = %#v, want %#v", got, want)
+	}
+	wantPaths := map[string]bool{"$.batch_size": true, "$.options.dry": true, "$.options.ratio": true, "$.ids[0]": true}
+	if len(paths) != len(wantPaths) {
+		t.Fatalf("paths = %v", paths)
+	}
+	for _, p := range paths {
+		if !wantPaths[p] {
+			t.Fatalf("unexpected path %q in %v", p, paths)
+		}
+	}
+
+	// What could not be converted is still caught by validation.
+	if err := ValidateJSONInput(got, schema); err == nil {
+		t.Fatal("expected validation to reject the unconverted values")
+	}
+}
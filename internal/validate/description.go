@@ -0,0 +1,19 @@
+package validate
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// MaxDescriptionLength is the longest app description, in characters.
+const MaxDescriptionLength = 500
+
+var ErrDescriptionTooLong = errors.New("description must be at most 500 characters")
+
+// ValidateDescription validates an app description.
+func ValidateDescription(s string) error {
+	if utf8.RuneCountInString(s) > MaxDescriptionLength {
+		return ErrDescriptionTooLong
+	}
+	return nil
+}
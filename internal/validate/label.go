@@ -0,0 +1,32 @@
+package validate
+
+import (
+	"errors"
+	"regexp"
+)
+
+var labelRegex = regexp.MustCompile(`^[a-z][a-z0-9._-]*$`)
+
+var (
+	ErrLabelEmpty   = errors.New("label cannot be empty")
+	ErrLabelTooLong = errors.New("label must be at most 32 characters")
+	ErrLabelFormat  = errors.New("label must start with a letter and contain only lowercase letters, numbers, dots, underscores, and hyphens")
+)
+
+// ValidateLabel validates a version label. Labels start with a letter so
+// they can never be mistaken for a version number.
+func ValidateLabel(s string) error {
+	if s == "" {
+		return ErrLabelEmpty
+	}
+
+	if len(s) > 32 {
+		return ErrLabelTooLong
+	}
+
+	if !labelRegex.MatchString(s) {
+		return ErrLabelFormat
+	}
+
+	return nil
+}
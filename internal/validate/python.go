@@ -0,0 +1,20 @@
+package validate
+
+import (
+	"errors"
+	"regexp"
+)
+
+var pythonVersionRegex = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+var ErrPythonVersionFormat = errors.New(`python version must be a major.minor version such as "3.12"`)
+
+// ValidatePythonVersion validates a Python interpreter version as apps
+// require it and runners declare it. Only major.minor is used, so the two
+// compare as plain strings.
+func ValidatePythonVersion(s string) error {
+	if !pythonVersionRegex.MatchString(s) {
+		return ErrPythonVersionFormat
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package validate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of SchemaChange.
+const (
+	SchemaChangeRemoved       = "removed"
+	SchemaChangeTypeChanged   = "type_changed"
+	SchemaChangeNewlyRequired = "newly_required"
+)
+
+// SchemaChange is a difference between two params schemas that can break
+// input written for the older one.
+type SchemaChange struct {
+	Kind      string
+	Parameter string
+	Message   string
+}
+
+// DiffParamsSchema compares the top-level parameters of a version's params
+// schema with the previous version's. It reports parameters that were
+// removed, whose type no longer accepts every value it used to, and that
+// became required without a default. Widening a type, such as integer to
+// number, is not reported. Changes are sorted by parameter, then kind.
+func DiffParamsSchema(previous, next map[string]any) []SchemaChange {
+	oldProps := schemaProperties(previous)
+	newProps := schemaProperties(next)
+	oldRequired := schemaRequired(previous)
+
+	var changes []SchemaChange
+	for name, oldProp := range oldProps {
+		newProp, ok := newProps[name]
+		if !ok {
+			changes = append(changes, SchemaChange{
+				Kind:      SchemaChangeRemoved,
+				Parameter: name,
+				Message:   fmt.Sprintf("parameter %q was removed", name),
+			})
+			continue
+		}
+		oldTypes, newTypes := schemaTypes(oldProp), schemaTypes(newProp)
+		if oldTypes != nil && newTypes != nil && !typesCovered(oldTypes, newTypes) {
+			changes = append(changes, SchemaChange{
+				Kind:      SchemaChangeTypeChanged,
+				Parameter: name,
+				Message:   fmt.Sprintf("parameter %q changed type from %s to %s", name, strings.Join(oldTypes, "|"), strings.Join(newTypes, "|")),
+			})
+		}
+	}
+	for name := range schemaRequired(next) {
+		if oldRequired[name] {
+			continue
+		}
+		if _, hasDefault := newProps[name]["default"]; hasDefault {
+			continue
+		}
+		changes = append(changes, SchemaChange{
+			Kind:      SchemaChangeNewlyRequired,
+			Parameter: name,
+			Message:   fmt.Sprintf("parameter %q is now required and has no default", name),
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Parameter != changes[j].Parameter {
+			return changes[i].Parameter < changes[j].Parameter
+		}
+		return changes[i].Kind < changes[j].Kind
+	})
+	return changes
+}
+
+func schemaProperties(schema map[string]any) map[string]map[string]any {
+	out := map[string]map[string]any{}
+	props, _ := schema["properties"].(map[string]any)
+	for name, raw := range props {
+		if child, ok := raw.(map[string]any); ok {
+			out[name] = child
+		}
+	}
+	return out
+}
+
+func schemaRequired(schema map[string]any) map[string]bool {
+	out := map[string]bool{}
+	req, _ := schema["required"].([]any)
+	for _, item := range req {
+		if s, ok := item.(string); ok {
+			out[s] = true
+		}
+	}
+	return out
+}
+
+// schemaTypes returns a property's declared types, or nil when it accepts
+// any type.
+func schemaTypes(schema map[string]any) []string {
+	t, ok := schema["type"]
+	if !ok {
+		return nil
+	}
+	types, err := parseTypeList(t)
+	if err != nil {
+		return nil
+	}
+	return types
+}
+
+// typesCovered reports whether every type in old is accepted by one in
+// next; number accepts integers.
+func typesCovered(old, next []string) bool {
+	accepts := map[string]bool{}
+	for _, typ := range next {
+		accepts[typ] = true
+	}
+	for _, typ := range old {
+		if accepts[typ] || (typ == "integer" && accepts["number"]) {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package validate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffParamsSchema(t *testing.T) {
+	props := func(kv ...any) map[string]any {
+		properties := map[string]any{}
+		for i := 0; i < len(kv); i += 2 {
+			properties[kv[i].(string)] = kv[i+1]
+		}
+		return map[string]any{"type": "object", "properties": properties}
+	}
+	typed := func(typ any) map[string]any { return map[string]any{"type": typ} }
+	withRequired := func(schema map[string]any, names ...any) map[string]any {
+		schema["required"] = names
+		return schema
+	}
+
+	tests := []struct {
+		name     string
+		previous map[string]any
+		next     map[string]any
+		want     []SchemaChange
+	}{
+		{"no previous schema", nil, props("a", typed("string")), nil},
+		{"unchanged", props("a", typed("string")), props("a", typed("string")), nil},
+		{"added optional", props("a", typed("string")), props("a", typed("string"), "b", typed("integer")), nil},
+		{"removed", props("a", typed("string"), "b", typed("integer")), props("a", typed("string")), []SchemaChange{
+			{SchemaChangeRemoved, "b", `parameter "b" was removed`},
+		}},
+		{"all removed", props("a", typed("string")), nil, []SchemaChange{
+			{SchemaChangeRemoved, "a", `parameter "a" was removed`},
+		}},
+		{"retyped", props("a", typed("string")), props("a", typed("integer")), []SchemaChange{
+			{SchemaChangeTypeChanged, "a", `parameter "a" changed type from string to integer`},
+		}},
+		{"narrowed", props("a", typed([]any{"string", "null"})), props("a", typed("string")), []SchemaChange{
+			{SchemaChangeTypeChanged, "a", `parameter "a" changed type from string|null to string`},
+		}},
+		{"widened", props("a", typed("integer")), props("a", typed("number")), nil},
+		{"type dropped", props("a", typed("integer")), props("a", map[string]any{}), nil},
+		{"newly required", props("a", typed("string")), withRequired(props("a", typed("string")), "a"), []SchemaChange{
+			{SchemaChangeNewlyRequired, "a", `parameter "a" is now required and has no default`},
+		}},
+		{"newly required with default", props("a", typed("string")),
+			withRequired(props("a", map[string]any{"type": "string", "default": "x"}), "a"), nil},
+		{"already required", withRequired(props("a", typed("string")), "a"), withRequired(props("a", typed("string")), "a"), nil},
+		{"new required parameter", props("a", typed("string")), withRequired(props("a", typed("string"), "b", typed("string")), "b"), []SchemaChange{
+			{SchemaChangeNewlyRequired, "b", `parameter "b" is now required and has no default`},
+		}},
+		{"sorted", props("b", typed("string"), "a", typed("string")), withRequired(props("b", typed("integer")), "b"), []SchemaChange{
+			{SchemaChangeRemoved, "a", `parameter "a" was removed`},
+			{SchemaChangeNewlyRequired, "b", `parameter "b" is now required and has no default`},
+			{SchemaChangeTypeChanged, "b", `parameter "b" changed type from string to integer`},
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := DiffParamsSchema(tc.previous, tc.next)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("DiffParamsSchema() = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
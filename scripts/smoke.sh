@@ -35,6 +35,7 @@ OBJDIR=$(mktemp -d)
 PORT=$((8080 + RANDOM % 1000))
 BOOTSTRAP_TOKEN="smoke-test-$(date +%s)"
 RUNNER_REG_TOKEN="runner-reg-$(date +%s)"
+METRICS_TOKEN="metrics-$(date +%s)"
 
 log "Smoke test starting..."
 log "  Work dir: $WORKDIR"
@@ -55,6 +56,7 @@ MINITOWER_DB_PATH="$DBPATH" \
 MINITOWER_OBJECTS_DIR="$OBJDIR" \
 MINITOWER_BOOTSTRAP_TOKEN="$BOOTSTRAP_TOKEN" \
 MINITOWER_RUNNER_REGISTRATION_TOKEN="$RUNNER_REG_TOKEN" \
+MINITOWER_METRICS_TOKEN="$METRICS_TOKEN" \
 MINITOWER_LEASE_TTL=30s \
 MINITOWER_EXPIRY_CHECK_INTERVAL=5s \
 "$WORKDIR/minitowerd" &
@@ -75,7 +77,7 @@ log "Server ready"
 
 # Test /metrics endpoint
 log "Checking /metrics endpoint..."
-METRICS=$(curl -s "http://localhost:$PORT/metrics")
+METRICS=$(curl -s -H "Authorization: Bearer $METRICS_TOKEN" "http://localhost:$PORT/metrics")
 if ! echo "$METRICS" | grep -q "minitower_http_requests_total"; then
   fail "/metrics endpoint not working"
 fi
@@ -211,7 +213,7 @@ log "Body limit test complete"
 
 # Final metrics check
 log "Final metrics check..."
-FINAL_METRICS=$(curl -s "http://localhost:$PORT/metrics")
+FINAL_METRICS=$(curl -s -H "Authorization: Bearer $METRICS_TOKEN" "http://localhost:$PORT/metrics")
 if ! echo "$FINAL_METRICS" | grep -q 'minitower_http_requests_total{method="POST"'; then
   fail "Final metrics check failed"
 fi